@@ -0,0 +1,140 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"sort"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func addressLess(a, b proto.AccountAddress) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+type userBilling struct {
+	cost   uint64
+	miners map[proto.AccountAddress]uint64
+}
+
+type databaseBilling struct {
+	summary types.BillingSummary
+	epochs  map[types.Range]bool
+	users   map[proto.AccountAddress]*userBilling
+}
+
+// billingSummarizer aggregates the UpdateBilling transactions by database.
+type billingSummarizer struct {
+	receiver *proto.AccountAddress // only aggregates the billing of this database if not nil
+	dbs      map[proto.AccountAddress]*databaseBilling
+}
+
+func newBillingSummarizer(receiver *proto.AccountAddress) *billingSummarizer {
+	return &billingSummarizer{
+		receiver: receiver,
+		dbs:      make(map[proto.AccountAddress]*databaseBilling),
+	}
+}
+
+// add aggregates tx if it's an UpdateBilling transaction, other transactions are ignored.
+func (s *billingSummarizer) add(tx pi.Transaction) {
+	switch t := tx.(type) {
+	case *types.UpdateBilling:
+		s.addBilling(t)
+	case *pi.TransactionWrapper:
+		s.add(t.Unwrap())
+	}
+}
+
+func (s *billingSummarizer) addBilling(tx *types.UpdateBilling) {
+	if s.receiver != nil && *s.receiver != tx.Receiver {
+		return
+	}
+	var db, ok = s.dbs[tx.Receiver]
+	if !ok {
+		db = &databaseBilling{
+			summary: types.BillingSummary{
+				Receiver: tx.Receiver,
+				Range:    tx.Range,
+			},
+			epochs: make(map[types.Range]bool),
+			users:  make(map[proto.AccountAddress]*userBilling),
+		}
+		s.dbs[tx.Receiver] = db
+	}
+	// every miner reports the billing of an epoch, only the first report is aggregated
+	if db.epochs[tx.Range] {
+		return
+	}
+	db.epochs[tx.Range] = true
+	if tx.Range.From < db.summary.Range.From {
+		db.summary.Range.From = tx.Range.From
+	}
+	if tx.Range.To > db.summary.Range.To {
+		db.summary.Range.To = tx.Range.To
+	}
+	db.summary.Updates++
+	for _, u := range tx.Users {
+		var ub, ok = db.users[u.User]
+		if !ok {
+			ub = &userBilling{miners: make(map[proto.AccountAddress]uint64)}
+			db.users[u.User] = ub
+		}
+		ub.cost += u.Cost
+		db.summary.TotalCost += u.Cost
+		for _, m := range u.Miners {
+			ub.miners[m.Miner] += m.Income
+		}
+	}
+}
+
+// summaries returns the aggregated billing summaries sorted by database address, with users
+// and miners sorted by account address.
+func (s *billingSummarizer) summaries() (summaries []*types.BillingSummary) {
+	summaries = make([]*types.BillingSummary, 0, len(s.dbs))
+	for _, db := range s.dbs {
+		var summary = db.summary
+		for user, ub := range db.users {
+			var cost = &types.UserCost{
+				User:   user,
+				Cost:   ub.cost,
+				Miners: make([]*types.MinerIncome, 0, len(ub.miners)),
+			}
+			for miner, income := range ub.miners {
+				cost.Miners = append(cost.Miners, &types.MinerIncome{
+					Miner:  miner,
+					Income: income,
+				})
+			}
+			sort.Slice(cost.Miners, func(i, j int) bool {
+				return addressLess(cost.Miners[i].Miner, cost.Miners[j].Miner)
+			})
+			summary.Users = append(summary.Users, cost)
+		}
+		sort.Slice(summary.Users, func(i, j int) bool {
+			return addressLess(summary.Users[i].User, summary.Users[j].User)
+		})
+		summaries = append(summaries, &summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return addressLess(summaries[i].Receiver, summaries[j].Receiver)
+	})
+	return
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestBillingSummarizer(t *testing.T) {
+	Convey("Given some billing updates of databases", t, func() {
+		var (
+			db1, db2     = proto.AccountAddress{0x1}, proto.AccountAddress{0x2}
+			user1, user2 = proto.AccountAddress{0x11}, proto.AccountAddress{0x12}
+			m1, m2       = proto.AccountAddress{0x21}, proto.AccountAddress{0x22}
+			txs          = []pi.Transaction{
+				types.NewUpdateBilling(&types.UpdateBillingHeader{
+					Receiver: db2,
+					Range:    types.Range{From: 10, To: 20},
+					Users: []*types.UserCost{{
+						User: user2, Cost: 10,
+						Miners: []*types.MinerIncome{{Miner: m2, Income: 6}, {Miner: m1, Income: 4}},
+					}},
+				}),
+				types.NewTransfer(&types.TransferHeader{Sender: user1, Receiver: db1}),
+				pi.WrapTransaction(types.NewUpdateBilling(&types.UpdateBillingHeader{
+					Receiver: db2,
+					Range:    types.Range{From: 0, To: 10},
+					Users: []*types.UserCost{{
+						User: user2, Cost: 5,
+						Miners: []*types.MinerIncome{{Miner: m1, Income: 5}},
+					}, {
+						User: user1, Cost: 1,
+						Miners: []*types.MinerIncome{{Miner: m2, Income: 1}},
+					}},
+				})),
+				types.NewUpdateBilling(&types.UpdateBillingHeader{
+					Receiver: db1,
+					Range:    types.Range{From: 5, To: 15},
+					Users:    []*types.UserCost{{User: user1, Cost: 3}},
+				}),
+				types.NewUpdateBilling(&types.UpdateBillingHeader{
+					Receiver: db1,
+					Range:    types.Range{From: 5, To: 15},
+					Users:    []*types.UserCost{{User: user1, Cost: 3}},
+				}),
+			}
+		)
+		Convey("The billing of all databases should be aggregated", func() {
+			var s = newBillingSummarizer(nil)
+			for _, tx := range txs {
+				s.add(tx)
+			}
+			var summaries = s.summaries()
+			So(summaries, ShouldHaveLength, 2)
+			So(summaries[0].Receiver, ShouldEqual, db1)
+			So(summaries[0].Updates, ShouldEqual, 1)
+			So(summaries[0].TotalCost, ShouldEqual, 3)
+			So(summaries[1].Receiver, ShouldEqual, db2)
+			So(summaries[1].Updates, ShouldEqual, 2)
+			So(summaries[1].Range, ShouldResemble, types.Range{From: 0, To: 20})
+			So(summaries[1].TotalCost, ShouldEqual, 16)
+			So(summaries[1].Users, ShouldResemble, []*types.UserCost{{
+				User: user1, Cost: 1,
+				Miners: []*types.MinerIncome{{Miner: m2, Income: 1}},
+			}, {
+				User: user2, Cost: 15,
+				Miners: []*types.MinerIncome{{Miner: m1, Income: 9}, {Miner: m2, Income: 6}},
+			}})
+		})
+		Convey("The billing of the specified database should be aggregated", func() {
+			var s = newBillingSummarizer(&db1)
+			for _, tx := range txs {
+				s.add(tx)
+			}
+			var summaries = s.summaries()
+			So(summaries, ShouldHaveLength, 1)
+			So(summaries[0].Receiver, ShouldEqual, db1)
+			So(summaries[0].Range, ShouldResemble, types.Range{From: 5, To: 15})
+		})
+		Convey("The invalid query range should be rejected", func() {
+			var c = &Chain{}
+			_, err := c.queryBillingSummary("", 10, 9)
+			So(err, ShouldEqual, ErrInvalidRange)
+			_, err = c.queryBillingSummary("", 0, conf.MaxBillingSummaryRange)
+			So(err, ShouldEqual, ErrInvalidRange)
+		})
+	})
+}
@@ -118,16 +118,41 @@ func (n *blockNode) lastIrreversible(confirm uint32) (irr *blockNode) {
 	if n.count > confirm {
 		count = n.count - confirm
 	}
-	for irr = n; irr.count > count; irr = irr.parent {
+	// NOTE: the base block of a fast synchronized chain is also irreversible.
+	for irr = n; irr.count > count && !irr.isFastSyncBase(); irr = irr.parent {
 	}
 	return
 }
 
+// isFastSyncBase returns whether n is the base block of a fast synchronized chain, which is
+// linked to the genesis block directly.
+func (n *blockNode) isFastSyncBase() bool {
+	return n.parent != nil && n.parent.count+1 != n.count
+}
+
 func (n *blockNode) hasAncestor(anc *blockNode) bool {
 	var match = n.ancestorByCount(anc.count)
 	return match != nil && match.hash == anc.hash
 }
 
+// commonAncestor returns the latest common ancestor of block nodes n and o, or nil if they
+// are not in the same chain.
+func (n *blockNode) commonAncestor(o *blockNode) *blockNode {
+	for x, y := n, o; x != nil && y != nil; {
+		switch {
+		case x.count > y.count:
+			x = x.parent
+		case x.count < y.count:
+			y = y.parent
+		case x.hash.IsEqual(&y.hash):
+			return x
+		default:
+			x, y = x.parent, y.parent
+		}
+	}
+	return nil
+}
+
 func (n *blockNode) hasAncestorWithMinCount(
 	blockHash hash.Hash, minCount uint32) (match *blockNode, ok bool,
 ) {
@@ -142,6 +142,11 @@ func TestBlockNode(t *testing.T) {
 		So(n4.hasAncestor(n3p), ShouldBeFalse)
 		So(n4p.hasAncestor(n3), ShouldBeFalse)
 
+		So(n4.commonAncestor(n4p), ShouldEqual, n2)
+		So(n4p.commonAncestor(n3), ShouldEqual, n2)
+		So(n4.commonAncestor(n1), ShouldEqual, n1)
+		So(n3.commonAncestor(n3), ShouldEqual, n3)
+
 		var (
 			f  *blockNode
 			ok bool
@@ -19,7 +19,6 @@ package blockproducer
 import (
 	"bytes"
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/pkg/errors"
@@ -40,7 +39,7 @@ type branch struct {
 }
 
 func newBranch(
-	baseNode, headNode *blockNode, baseState *metaState, basePool map[hash.Hash]pi.Transaction,
+	baseNode, headNode *blockNode, baseState *metaState, basePool *mempool,
 ) (
 	br *branch, err error,
 ) {
@@ -54,8 +53,8 @@ func newBranch(
 		}
 	)
 	// Copy pool
-	for k, v := range basePool {
-		inst.unpacked[k] = v
+	for k, v := range basePool.txs {
+		inst.unpacked[k] = v.tx
 	}
 	// Apply new blocks to view and pool
 	for _, bn := range list {
@@ -163,31 +162,38 @@ func (b *branch) applyBlock(n *blockNode) (br *branch, err error) {
 	return
 }
 
-func (b *branch) sortUnpackedTxs() (txs []pi.Transaction) {
+// isCanonicalOver reports whether branch b takes precedence over branch o as the head branch.
+//
+// The consensus rule prefers the branch with more blocks, then the branch whose head is
+// produced earlier, and finally breaks ties by comparing head hashes so that every node picks
+// the same branch from the same set of blocks.
+func (b *branch) isCanonicalOver(o *branch) bool {
+	if b.head.count != o.head.count {
+		return b.head.count > o.head.count
+	}
+	if b.head.height != o.head.height {
+		return b.head.height < o.head.height
+	}
+	return bytes.Compare(b.head.hash[:], o.head.hash[:]) < 0
+}
+
+// sortUnpackedTxs returns the unpacked transactions in the packing order of pool.
+func (b *branch) sortUnpackedTxs(pool *mempool) (txs []pi.Transaction) {
 	txs = make([]pi.Transaction, 0, len(b.unpacked))
 	for _, v := range b.unpacked {
 		txs = append(txs, v)
 	}
-	sort.Slice(txs, func(i, j int) bool {
-		if cmp := bytes.Compare(
-			hash.Hash(txs[i].GetAccountAddress()).AsBytes(),
-			hash.Hash(txs[j].GetAccountAddress()).AsBytes(),
-		); cmp != 0 {
-			return cmp < 0
-		}
-		return txs[i].GetAccountNonce() < txs[j].GetAccountNonce()
-	})
-	return
+	return pool.sortTxs(txs)
 }
 
 func (b *branch) produceBlock(
-	h uint32, ts time.Time, addr proto.AccountAddress, signer *ca.PrivateKey,
+	h uint32, ts time.Time, addr proto.AccountAddress, signer *ca.PrivateKey, pool *mempool,
 ) (
 	br *branch, bl *types.BPBlock, err error,
 ) {
 	var (
 		cpy       = b.makeArena()
-		txs       = cpy.sortUnpackedTxs()
+		txs       = cpy.sortUnpackedTxs(pool)
 		ierr      error
 		packCount = conf.MaxTransactionsPerBlock
 	)
@@ -23,6 +23,7 @@ import (
 	"math"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru"
@@ -30,6 +31,7 @@ import (
 	mw "github.com/zserge/metric"
 
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/chainbus"
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
@@ -71,6 +73,10 @@ type Chain struct {
 	// NOTE(leventeliu): this LRU object is only used for block cache control,
 	// do NOT read it in any case.
 	blockCache *lru.Cache
+	// Event bus for fork and reorg events
+	bus chainbus.Bus
+	// Block producing schedule of type blockSchedule, replaced on chain config updates
+	schedule atomic.Value
 
 	// Channels for incoming blocks and transactions
 	pendingBlocks    chan *types.BPBlock
@@ -78,12 +84,15 @@ type Chain struct {
 
 	// The following fields are read-only in runtime
 	address     proto.AccountAddress
-	mode        RunMode
 	genesisTime time.Time
-	period      time.Duration
-	tick        time.Duration
+	period      time.Duration // initial period, see schedule for the runtime one
+	tick        time.Duration // initial tick, see schedule for the runtime one
+	threshold   float64
+	keepBlocks  uint32 // 0 in archive mode
 
 	sync.RWMutex // protects following fields
+	mode         RunMode
+	peers        *proto.Peers
 	bpInfos      []*blockProducerInfo
 	localBPInfo  *blockProducerInfo
 	localNodeID  proto.NodeID
@@ -95,7 +104,8 @@ type Chain struct {
 	headIndex    int
 	headBranch   *branch
 	branches     []*branch
-	txPool       map[hash.Hash]pi.Transaction
+	txPool       *mempool
+	pruned       uint32 // blocks with count in [1, pruned) are pruned
 }
 
 // NewChain creates a new blockchain.
@@ -113,13 +123,18 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 		lastIrre  *blockNode
 		heads     []*blockNode
 		immutable *metaState
-		txPool    map[hash.Hash]pi.Transaction
+		txPool    *mempool
+		pruned    uint32
+		baseCount uint32
+		hasBase   bool
 
 		branches   []*branch
 		headBranch *branch
 		headIndex  int
 
 		addr        proto.AccountAddress
+		mode        = cfg.Mode
+		peers       = cfg.Peers
 		bpInfos     []*blockProducerInfo
 		localBPInfo *blockProducerInfo
 	)
@@ -139,7 +154,7 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 	if fi, err := os.Stat(cfg.DataFile); err == nil && fi.Mode().IsRegular() {
 		existed = true
 	}
-	if st, ierr = openStorage(fmt.Sprintf("file:%s", cfg.DataFile)); ierr != nil {
+	if st, ierr = openStorage(storageDSN(cfg.DataFile)); ierr != nil {
 		err = errors.Wrap(ierr, "failed to open storage")
 		return
 	}
@@ -149,6 +164,14 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 		}
 	}()
 
+	// Validate the existing storage and repair the torn records left by an unclean shutdown
+	if existed && cfg.VerifyChain {
+		if _, ierr = verifyChain(st, cfg.Genesis); ierr != nil {
+			err = errors.Wrap(ierr, "failed to verify chain storage")
+			return
+		}
+	}
+
 	// Create block cache
 	if cfg.BlockCacheSize > conf.MaxCachedBlock {
 		cfg.BlockCacheSize = conf.MaxCachedBlock
@@ -164,6 +187,15 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 		return
 	}
 
+	// Try to initialize storage from the state snapshot of peers
+	if !existed && cfg.FastSync {
+		if ierr = fastSync(ctx, st, cfg); ierr != nil {
+			log.WithError(ierr).Warn("failed to fast sync, synchronize from genesis")
+		} else {
+			existed = true
+		}
+	}
+
 	// Create initial state from genesis block and store
 	if !existed {
 		var init = newMetaState()
@@ -188,6 +220,32 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 		return
 	}
 
+	// Load pruning progress, genesis block and fast synchronization base are never pruned
+	if pruned, ierr = loadPrunedCount(st); ierr != nil {
+		err = errors.Wrap(ierr, "failed to load pruning progress from storage")
+		return
+	}
+	if baseCount, _, hasBase, ierr = loadFastSyncBase(st); ierr != nil {
+		err = errors.Wrap(ierr, "failed to load fast sync base from storage")
+		return
+	}
+	if hasBase && pruned <= baseCount {
+		pruned = baseCount + 1
+	}
+	if pruned < 1 {
+		pruned = 1
+	}
+
+	// Load block producer peers, the peer list changed on chain takes precedence over config
+	if profile, ok := immutable.loadBPPeers(); ok {
+		if profile.Peers.Term >= cfg.Peers.Term {
+			var cpy = profile.Peers.Clone()
+			peers = &cpy
+		}
+	} else {
+		immutable.readonly.bpPeers = bootstrapBPPeers(cfg.Peers)
+	}
+
 	// Check genesis block
 	if persistedGenesis := lastIrre.ancestorByCount(0); persistedGenesis == nil ||
 		!persistedGenesis.hash.IsEqual(cfg.Genesis.BlockHash()) {
@@ -235,7 +293,7 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 
 	// Select head branch
 	for i, v := range branches {
-		if headBranch == nil || v.head.count > headBranch.head.count {
+		if headBranch == nil || v.isCanonicalOver(headBranch) {
 			headIndex = i
 			headBranch = v
 		}
@@ -251,23 +309,21 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 	}
 
 	// Setup peer list
-	var (
-		l = uint32(len(cfg.Peers.Servers))
-
-		threshold    float64
-		needConfirms uint32
-	)
+	var threshold float64
 	if localBPInfo, bpInfos, err = buildBlockProducerInfos(
-		cfg.NodeID, cfg.Peers, cfg.Mode == APINodeMode,
-	); err != nil {
+		cfg.NodeID, peers, mode == APINodeMode,
+	); err == ErrLocalNodeNotFound && peers != cfg.Peers {
+		// Removed from block producers on chain, keep running as an API node
+		log.WithField("node", cfg.NodeID).Warn("local node is removed from block producers")
+		mode = APINodeMode
+		localBPInfo, bpInfos, err = buildBlockProducerInfos(cfg.NodeID, peers, true)
+	}
+	if err != nil {
 		return
 	}
 	if threshold = cfg.ConfirmThreshold; threshold <= 0.0 {
 		threshold = conf.DefaultConfirmThreshold
 	}
-	if needConfirms = uint32(math.Ceil(float64(l)*threshold + 1)); needConfirms > l {
-		needConfirms = l
-	}
 
 	// create chain
 	var cld, ccl = context.WithCancel(ctx)
@@ -281,20 +337,24 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 
 		storage:    st,
 		blockCache: cache,
+		bus:        chainbus.New(),
 
 		pendingBlocks:    make(chan *types.BPBlock),
 		pendingAddTxReqs: make(chan *types.AddTxReq),
 
 		address:     addr,
-		mode:        cfg.Mode,
 		genesisTime: cfg.Genesis.SignedHeader.Timestamp,
 		period:      cfg.Period,
 		tick:        cfg.Tick,
+		threshold:   threshold,
+		keepBlocks:  keepBlocks(cfg),
 
+		mode:        mode,
+		peers:       peers,
 		bpInfos:     bpInfos,
 		localBPInfo: localBPInfo,
 		localNodeID: cfg.NodeID,
-		confirms:    needConfirms,
+		confirms:    requiredConfirms(uint32(len(peers.Servers)), threshold),
 		nextHeight:  headBranch.head.height + 1,
 		offset:      time.Duration(0), // TODO(leventeliu): initialize offset
 		lastIrre:    lastIrre,
@@ -303,8 +363,14 @@ func NewChainWithContext(ctx context.Context, cfg *Config) (c *Chain, err error)
 		headBranch:  headBranch,
 		branches:    branches,
 		txPool:      txPool,
+		pruned:      pruned,
 	}
 
+	c.schedule.Store(newBlockSchedule(c.genesisTime, types.ChainConfig{
+		Period: c.period,
+		Tick:   c.tick,
+	}, immutable.loadChainConfigs()))
+
 	// NOTE(leventeliu): this implies that BP chain is a singleton, otherwise we will need
 	// independent metric key for each chain instance.
 	if expvar.Get(mwKeyHeight) == nil {
@@ -329,6 +395,7 @@ func (c *Chain) Start() {
 	// Start blocks/txs processing goroutines
 	c.goFunc(c.processBlocks)
 	c.goFunc(c.processTxs)
+	c.goFunc(c.evictExpiredTxs)
 	// Synchronize heads to current block period
 	c.syncHeads()
 	// TODO(leventeliu): subscribe ChainBus.
@@ -346,7 +413,11 @@ func (c *Chain) Stop() (err error) {
 	})
 	le.Debug("stopping chain")
 	c.stop()
+	c.bus.WaitAsync()
 	le.Debug("chain service stopped")
+	if err := checkpoint(c.storage); err != nil {
+		le.WithError(err).Warn("failed to checkpoint chain database")
+	}
 	c.storage.Close()
 	le.Debug("chain database closed")
 
@@ -416,12 +487,12 @@ func (c *Chain) advanceNextHeight(now time.Time, d time.Duration) {
 	}()
 
 	// Skip if it's not my turn
-	if c.mode == APINodeMode || !c.isMyTurn() {
+	if c.getMode() == APINodeMode || !c.isMyTurn() {
 		return
 	}
 	// Normally, a block producing should start right after the new period, but more time may also
 	// elapse since the last block synchronizing.
-	if elapsed+c.tick > c.period { // TODO(leventeliu): add threshold config for `elapsed`.
+	if iv := c.getSchedule().intervalOfHeight(c.getNextHeight()); elapsed+iv.Tick > iv.Period { // TODO(leventeliu): add threshold config for `elapsed`.
 		log.WithFields(log.Fields{
 			"advanced_height": c.getNextHeight(),
 			"using_timestamp": now.Format(time.RFC3339Nano),
@@ -524,7 +595,7 @@ func (c *Chain) processAddTxReq(addTxReq *types.AddTxReq) {
 	if ok := func() (ok bool) {
 		c.RLock()
 		defer c.RUnlock()
-		_, ok = c.txPool[txhash]
+		ok = c.txPool.has(txhash)
 		return
 	}(); ok {
 		le.Debug("tx already exists, abort processing")
@@ -563,6 +634,7 @@ func (c *Chain) processAddTxReq(addTxReq *types.AddTxReq) {
 		return
 	}
 	expvar.Get(mwKeyTxPooled).(mw.Metric).Add(1)
+	c.bus.Publish(EventTopicNewTx, &NewTxEvent{Tx: tx})
 }
 
 func (c *Chain) processTxs(ctx context.Context) {
@@ -621,8 +693,8 @@ func (c *Chain) blockingSyncCurrentHead(ctx context.Context, requiredReachable u
 		ticker   *time.Ticker
 		interval = 1 * time.Second
 	)
-	if c.tick < interval {
-		interval = c.tick
+	if tick := c.getTick(); tick < interval {
+		interval = tick
 	}
 	ticker = time.NewTicker(interval)
 	defer ticker.Stop()
@@ -656,14 +728,14 @@ func (c *Chain) syncCurrentHead(ctx context.Context, requiredReachable uint32) (
 		serversNum  = c.getLocalBPInfo().total
 	)
 
-	switch c.mode {
+	switch mode := c.getMode(); mode {
 	case BPMode:
 		ok = unreachable+requiredReachable <= serversNum
 	case APINodeMode:
 		ok = unreachable < serversNum
 	default:
 		ok = false
-		log.Fatalf("unknown run mode: %v", c.mode)
+		log.Fatalf("unknown run mode: %v", mode)
 	}
 
 	if !ok {
@@ -680,34 +752,126 @@ func (c *Chain) storeTx(tx pi.Transaction) (err error) {
 	var k = tx.Hash()
 	c.Lock()
 	defer c.Unlock()
-	if _, ok := c.txPool[k]; ok {
+	if c.txPool.has(k) {
 		err = ErrExistedTx
 		return
 	}
 
-	return store(c.storage, []storageProcedure{addTx(tx)}, func() {
-		c.txPool[k] = tx
+	var (
+		evicted []pi.Transaction
+		sps     = []storageProcedure{addTx(tx)}
+	)
+	if evicted, err = c.txPool.admit(tx, c.isPackedInHead); err != nil {
+		return
+	}
+	if len(evicted) > 0 {
+		sps = append(sps, deleteTxs(evicted))
+	}
+	return store(c.storage, sps, func() {
+		for _, v := range evicted {
+			log.WithFields(log.Fields{
+				"hash":    v.Hash().Short(4),
+				"type":    v.GetTransactionType(),
+				"account": v.GetAccountAddress(),
+				"nonce":   v.GetAccountNonce(),
+			}).Warn("transaction evicted from full mempool")
+			c.txPool.remove(v.Hash())
+		}
+		c.txPool.put(tx, time.Now().UTC())
 		for _, v := range c.branches {
+			v.clearUnpackedTxs(evicted)
 			v.addTx(tx)
 		}
 	})
 }
 
+// isPackedInHead returns whether the transaction is packed in the head branch, it should be
+// called with the chain lock held.
+func (c *Chain) isPackedInHead(h hash.Hash) (ok bool) {
+	_, ok = c.headBranch.packed[h]
+	return
+}
+
+// evictExpiredTxs evicts the transactions staying in mempool longer than conf.TxPoolTTL.
+func (c *Chain) evictExpiredTxs(ctx context.Context) {
+	var ticker = time.NewTicker(conf.TxPoolSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.pruneTxPool(time.Now().Add(-conf.TxPoolTTL)); err != nil {
+				log.WithError(err).Error("failed to evict expired transactions")
+			}
+		case <-ctx.Done():
+			log.WithError(ctx.Err()).Info("abort mempool eviction")
+			return
+		}
+	}
+}
+
+// pruneTxPool removes the transactions received before deadline from mempool.
+func (c *Chain) pruneTxPool(deadline time.Time) (err error) {
+	c.Lock()
+	defer c.Unlock()
+	var expired = c.txPool.expired(deadline, c.isPackedInHead)
+	if len(expired) == 0 {
+		return
+	}
+	return store(c.storage, []storageProcedure{deleteTxs(expired)}, func() {
+		for _, v := range expired {
+			c.txPool.remove(v.Hash())
+		}
+		for _, br := range c.branches {
+			br.clearUnpackedTxs(expired)
+		}
+		log.WithField("count", len(expired)).Info("evicted expired transactions from mempool")
+	})
+}
+
 func (c *Chain) replaceAndSwitchToBranch(
 	newBlock *types.BPBlock, originBrIdx int, newBranch *branch) (err error,
 ) {
 	var (
 		lastIrre *blockNode
+		prevIrre = c.lastIrre
 		newIrres []*blockNode
 		sps      []storageProcedure
 		up       storageCallback
 		txCount  int
 		height   = c.heightOfTime(newBlock.Timestamp())
 
-		resultTxPool = make(map[hash.Hash]pi.Transaction)
+		resultTxPool *mempool
 		expiredTxs   []pi.Transaction
+		readdedTxs   []pi.Transaction
+		reorg        *ReorgEvent
+		changes      []*AccountChangeEvent
 	)
 
+	// Detect reorganization: the new head branch doesn't contain the current head block
+	if oldHead := c.headBranch.head; !newBranch.head.hasAncestor(oldHead) {
+		var anc = newBranch.head.commonAncestor(oldHead)
+		if anc == nil {
+			err = ErrParentNotFound
+			return
+		}
+		reorg = &ReorgEvent{
+			CommonAncestor: newBlockRef(anc),
+			OldHead:        newBlockRef(oldHead),
+			NewHead:        newBlockRef(newBranch.head),
+			Detached:       newBlockRefs(oldHead.fetchNodeList(anc.count + 1)),
+			Attached:       newBlockRefs(newBranch.head.fetchNodeList(anc.count + 1)),
+		}
+		// Collect transactions packed in the detached blocks, which may not be seen in
+		// the transaction pool if they are received by blocks
+		for _, n := range oldHead.fetchNodeList(anc.count + 1) {
+			for _, tx := range n.load().Transactions {
+				if !c.txPool.has(tx.Hash()) {
+					readdedTxs = append(readdedTxs, tx)
+				}
+			}
+		}
+	}
+
 	// Find new irreversible blocks
 	//
 	// NOTE(leventeliu):
@@ -717,21 +881,24 @@ func (c *Chain) replaceAndSwitchToBranch(
 	newIrres = lastIrre.fetchNodeList(c.lastIrre.count + 1)
 
 	// Apply irreversible blocks to create dirty map on immutable cache
-	for k, v := range c.txPool {
-		resultTxPool[k] = v
+	resultTxPool = c.txPool.clone()
+	for _, v := range readdedTxs {
+		resultTxPool.put(v, time.Now().UTC())
 	}
 	for _, b := range newIrres {
 		txCount += b.txCount
+		changes = append(changes, newAccountChangeEvents(b)...)
 		for _, tx := range b.load().Transactions {
 			if err := c.immutable.apply(tx, b.height); err != nil {
 				log.WithError(err).Fatal("failed to apply block to immutable database")
 			}
-			delete(resultTxPool, tx.Hash()) // Remove confirmed transaction
+			resultTxPool.remove(tx.Hash()) // Remove confirmed transaction
 		}
 	}
 
 	// Check tx expiration
-	for k, v := range resultTxPool {
+	for k, e := range resultTxPool.txs {
+		var v = e.tx
 		if base, err := c.immutable.nextNonce(
 			v.GetAccountAddress(),
 		); err != nil || v.GetAccountNonce() < base {
@@ -744,9 +911,18 @@ func (c *Chain) replaceAndSwitchToBranch(
 				"immutable_base_nonce": base,
 			}).Debug("transaction expired")
 			expiredTxs = append(expiredTxs, v)
-			delete(resultTxPool, k) // Remove expired transaction
+			resultTxPool.remove(k) // Remove expired transaction
+		}
+	}
+
+	// Keep the readded transactions which are neither confirmed nor expired
+	var readded = readdedTxs[:0]
+	for _, v := range readdedTxs {
+		if resultTxPool.has(v.Hash()) {
+			readded = append(readded, v)
 		}
 	}
+	readdedTxs = readded
 
 	// Prepare storage procedures to update immutable database
 	sps = c.immutable.compileChanges(sps)
@@ -758,10 +934,14 @@ func (c *Chain) replaceAndSwitchToBranch(
 	if len(expiredTxs) > 0 {
 		sps = append(sps, deleteTxs(expiredTxs))
 	}
+	for _, v := range readdedTxs {
+		sps = append(sps, addTx(v))
+	}
 	sps = append(sps, updateIrreversible(lastIrre.hash))
 
 	// Prepare callback to update cache
 	up = func() {
+		var configUpdated = len(c.immutable.dirty.chainConfigs) > 0
 		// Update last irreversible block
 		c.lastIrre = lastIrre
 		// Apply irreversible blocks to immutable database
@@ -804,9 +984,20 @@ func (c *Chain) replaceAndSwitchToBranch(
 		}
 		for _, br := range c.branches {
 			br.clearUnpackedTxs(expiredTxs)
+			for _, v := range readdedTxs {
+				br.addTx(v)
+			}
 		}
 		// Update txPool to result txPool (packed and expired transactions cleared!)
 		c.txPool = resultTxPool
+		// Follow the block producing parameters change
+		if configUpdated {
+			c.switchSchedule(c.immutable.loadChainConfigs())
+		}
+		// Follow the block producer membership change
+		if profile, ok := c.immutable.loadBPPeers(); ok && profile.Peers.Term > c.peers.Term {
+			c.switchPeers(profile)
+		}
 		// Register new irreversible blocks to LRU cache list
 		for _, b := range newIrres {
 			c.blockCache.Add(b.count, b)
@@ -819,8 +1010,34 @@ func (c *Chain) replaceAndSwitchToBranch(
 		return
 	}
 	expvar.Get(mwKeyTxConfirmed).(mw.Metric).Add(float64(txCount))
-	// TODO(leventeliu): trigger ChainBus.Publish.
-	// ...
+	if reorg != nil {
+		for _, v := range readdedTxs {
+			reorg.Readded = append(reorg.Readded, v.Hash())
+		}
+		log.WithFields(log.Fields{
+			"ancestor": reorg.CommonAncestor.Hash.Short(4),
+			"old_head": reorg.OldHead.Hash.Short(4),
+			"new_head": reorg.NewHead.Hash.Short(4),
+			"depth":    reorg.Depth(),
+			"readded":  len(reorg.Readded),
+		}).Warn("main chain reorganized")
+		c.bus.Publish(EventTopicReorg, reorg)
+	}
+	c.bus.Publish(EventTopicNewBlock, &NewBlockEvent{
+		Ref:   newBlockRef(newBranch.head),
+		Block: newBlock,
+	})
+	for _, e := range changes {
+		c.bus.Publish(EventTopicAccountChange, e)
+	}
+	// Take state snapshot periodically
+	if lastIrre.count/conf.BPSnapshotInterval > prevIrre.count/conf.BPSnapshotInterval {
+		c.saveSnapshot()
+	}
+	// Prune old irreversible blocks
+	if lastIrre != prevIrre {
+		c.pruneBlocks()
+	}
 	return
 }
 
@@ -864,7 +1081,7 @@ func (c *Chain) applyBlock(bl *types.BPBlock) (err error) {
 				return
 			}
 			// Grow a branch while the current branch is not changed
-			if br.head.count <= c.headBranch.head.count {
+			if !br.isCanonicalOver(c.headBranch) {
 				return store(c.storage,
 					[]storageProcedure{addBlock(height, bl)},
 					func() {
@@ -895,10 +1112,24 @@ func (c *Chain) applyBlock(bl *types.BPBlock) (err error) {
 				err = errors.Wrapf(ierr, "failed to fork from %s", parent.hash.Short(4))
 				return
 			}
-			return store(c.storage,
+			if err = store(c.storage,
 				[]storageProcedure{addBlock(height, bl)},
 				func() { c.branches = append(c.branches, br) },
-			)
+			); err != nil {
+				return
+			}
+			log.WithFields(log.Fields{
+				"base_count": parent.count,
+				"base_hash":  parent.hash.Short(4),
+				"head_count": head.count,
+				"head_hash":  head.hash.Short(4),
+			}).Info("fork detected")
+			c.bus.Publish(EventTopicFork, &ForkEvent{
+				Base: newBlockRef(parent),
+				Head: newBlockRef(head),
+			})
+			c.detectEquivocation(parent, bl)
+			return
 		}
 	}
 
@@ -906,6 +1137,35 @@ func (c *Chain) applyBlock(bl *types.BPBlock) (err error) {
 	return
 }
 
+// detectEquivocation checks whether the producer of block bl has produced another block on the
+// same parent block, and publishes an EquivocationEvent if so. It should be called with the chain
+// lock held.
+func (c *Chain) detectEquivocation(parent *blockNode, bl *types.BPBlock) {
+	for _, v := range c.branches {
+		var (
+			sibling = v.head.ancestorByCount(parent.count + 1)
+			block   *types.BPBlock
+		)
+		if sibling == nil || sibling.parent != parent || sibling.hash.IsEqual(bl.BlockHash()) {
+			continue
+		}
+		if block = sibling.load(); block == nil || block.Producer() != bl.Producer() {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"producer": bl.Producer(),
+			"parent":   parent.hash.Short(4),
+			"first":    sibling.hash.Short(4),
+			"second":   bl.BlockHash().Short(4),
+		}).Warn("block producer equivocation detected")
+		c.bus.Publish(EventTopicEquivocation, &EquivocationEvent{
+			First:  block.SignedHeader,
+			Second: bl.SignedHeader,
+		})
+		return
+	}
+}
+
 func (c *Chain) produceAndStoreBlock(
 	now time.Time, priv *asymmetric.PrivateKey) (out *types.BPBlock, err error,
 ) {
@@ -921,7 +1181,7 @@ func (c *Chain) produceAndStoreBlock(
 
 	// Try to produce new block
 	if br, bl, ierr = c.headBranch.produceBlock(
-		c.heightOfTime(now), now, c.address, priv,
+		c.heightOfTime(now), now, c.address, priv, c.txPool,
 	); ierr != nil {
 		err = errors.Wrapf(ierr, "failed to produce block at head %s",
 			c.headBranch.head.hash.Short(4))
@@ -945,6 +1205,7 @@ func (c *Chain) now() time.Time {
 
 func (c *Chain) startService(chain *Chain) {
 	c.server.RegisterService(route.BlockProducerRPCName, &ChainRPCService{chain: chain})
+	c.server.RegisterStreamHandler(route.MCCSubscribe.String(), chain.serveSubscribe)
 }
 
 // nextTick returns the current clock reading and the duration till the next turn. If duration
@@ -959,9 +1220,10 @@ func (c *Chain) nextTick() (t time.Time, d time.Duration) {
 		t = time.Now().Add(c.offset).UTC()
 		return
 	}()
-	d = c.genesisTime.Add(time.Duration(h) * c.period).Sub(t)
-	if d > c.tick {
-		d = c.tick
+	var s = c.getSchedule()
+	d = s.timeOfHeight(h).Sub(t)
+	if tick := s.intervalOfHeight(h).Tick; d > tick {
+		d = tick
 	}
 	return
 }
@@ -981,7 +1243,116 @@ func (c *Chain) increaseNextHeight() {
 
 // heightOfTime calculates the heightOfTime with this sql-chain config of a given time reading.
 func (c *Chain) heightOfTime(t time.Time) uint32 {
-	return uint32(t.Sub(c.genesisTime) / c.period)
+	return c.getSchedule().heightOfTime(t)
+}
+
+// switchSchedule rebuilds the block producing schedule with the chain config updates.
+func (c *Chain) switchSchedule(updates []*types.ChainConfigProfile) {
+	var s = newBlockSchedule(c.genesisTime, types.ChainConfig{
+		Period: c.period,
+		Tick:   c.tick,
+	}, updates)
+	c.schedule.Store(s)
+	var last = s[len(s)-1]
+	log.WithFields(log.Fields{
+		"height": last.height,
+		"begin":  last.begin.Format(time.RFC3339Nano),
+		"period": last.Period,
+		"tick":   last.Tick,
+	}).Info("block producing schedule updated")
+}
+
+func (c *Chain) getSchedule() blockSchedule {
+	return c.schedule.Load().(blockSchedule)
+}
+
+// getPeriod returns the block producing period at the next height.
+func (c *Chain) getPeriod() time.Duration {
+	return c.getSchedule().intervalOfHeight(c.getNextHeight()).Period
+}
+
+// getTick returns the tick at the next height.
+func (c *Chain) getTick() time.Duration {
+	return c.getSchedule().intervalOfHeight(c.getNextHeight()).Tick
+}
+
+// switchPeers switches the chain to the new block producer peer list, it should be called with
+// the chain lock held.
+func (c *Chain) switchPeers(profile *types.BPPeersProfile) {
+	var (
+		peers = profile.Peers.Clone()
+		mode  = c.mode
+
+		localBPInfo *blockProducerInfo
+		bpInfos     []*blockProducerInfo
+		err         error
+	)
+	if localBPInfo, bpInfos, err = buildBlockProducerInfos(
+		c.localNodeID, &peers, mode == APINodeMode,
+	); err == ErrLocalNodeNotFound {
+		// Removed from block producers, keep running as an API node
+		mode = APINodeMode
+		localBPInfo, bpInfos, err = buildBlockProducerInfos(c.localNodeID, &peers, true)
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to switch block producer peers")
+		return
+	}
+
+	c.mode = mode
+	c.peers = &peers
+	c.bpInfos = bpInfos
+	c.localBPInfo = localBPInfo
+	c.confirms = requiredConfirms(uint32(len(peers.Servers)), c.threshold)
+
+	log.WithFields(log.Fields{
+		"local":    localBPInfo,
+		"term":     peers.Term,
+		"leader":   peers.Leader,
+		"servers":  peers.Servers,
+		"confirms": c.confirms,
+	}).Info("block producer peers changed")
+
+	// Update the route and key store of local node, so that the new block producers are reachable
+	var nodes = profile.BPNodes()
+	for i := range nodes {
+		if err = kms.SetNode(&nodes[i]); err != nil {
+			log.WithField("node", nodes[i].ID).WithError(err).Warn("failed to set node to kms")
+		}
+	}
+	route.UpdateBPs(nodes)
+}
+
+// bootstrapBPPeers builds the initial block producer peers profile from config, the node info of
+// the peers is loaded from local kms.
+func bootstrapBPPeers(peers *proto.Peers) (profile *types.BPPeersProfile) {
+	profile = &types.BPPeersProfile{
+		Peers: peers.Clone(),
+		Nodes: make([]proto.Node, 0, len(peers.Servers)),
+	}
+	for _, v := range peers.Servers {
+		var node, err = kms.GetNodeInfo(v)
+		if err != nil {
+			log.WithField("node", v).WithError(err).Debug("block producer node info not found")
+			continue
+		}
+		profile.Nodes = append(profile.Nodes, *node)
+	}
+	return
+}
+
+// requiredConfirms returns the confirmation count of a block to become irreversible.
+func requiredConfirms(total uint32, threshold float64) (confirms uint32) {
+	if confirms = uint32(math.Ceil(float64(total)*threshold + 1)); confirms > total {
+		confirms = total
+	}
+	return
+}
+
+func (c *Chain) getMode() RunMode {
+	c.RLock()
+	defer c.RUnlock()
+	return c.mode
 }
 
 func (c *Chain) getRequiredConfirms() uint32 {
@@ -49,7 +49,7 @@ func (c *Chain) nonblockingBroadcastBlock(block *types.BPBlock) {
 					"block_hash":  block.BlockHash().Short(4),
 					"parent_hash": block.ParentHash().Short(4),
 				}).WithError(err).Debug("broadcast new block to other peers")
-			}, c.period)
+			}, c.getPeriod())
 		}(info)
 	}
 }
@@ -76,14 +76,14 @@ func (c *Chain) nonblockingBroadcastTx(ttl uint32, tx pi.Transaction) {
 					"address": tx.GetAccountAddress(),
 					"type":    tx.GetTransactionType(),
 				}).WithError(err).Debug("broadcast transaction to other peers")
-			}, c.tick)
+			}, c.getTick())
 		}(info)
 	}
 }
 
 func (c *Chain) blockingFetchBlock(ctx context.Context, h uint32) (unreachable uint32) {
 	var (
-		cld, ccl = context.WithTimeout(ctx, c.tick)
+		cld, ccl = context.WithTimeout(ctx, c.getTick())
 		wg       = &sync.WaitGroup{}
 	)
 	defer func() {
@@ -20,6 +20,7 @@ import (
 	"database/sql"
 
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/types"
@@ -57,6 +58,11 @@ func (c *Chain) fetchBlockByHeight(h uint32) (b *types.BPBlock, count uint32, er
 	if node == nil {
 		return
 	}
+	// Transactions are pruned
+	if c.isPruned(node) {
+		err = ErrBlockPruned
+		return
+	}
 	// OK, and block is cached
 	if b = node.load(); b != nil {
 		count = node.count
@@ -76,6 +82,11 @@ func (c *Chain) fetchBlockByCount(count uint32) (b *types.BPBlock, height uint32
 	if node == nil {
 		return
 	}
+	// Transactions are pruned
+	if c.isPruned(node) {
+		err = ErrBlockPruned
+		return
+	}
 	// OK, and block is cached
 	if b = node.load(); b != nil {
 		height = node.height
@@ -203,3 +214,92 @@ func (c *Chain) immutableNextNonce(addr proto.AccountAddress) (n pi.AccountNonce
 	defer c.RUnlock()
 	return c.immutable.nextNonce(addr)
 }
+
+func (c *Chain) queryBPPeers() (profile *types.BPPeersProfile, err error) {
+	c.RLock()
+	defer c.RUnlock()
+	var ok bool
+	if profile, ok = c.immutable.loadBPPeers(); !ok {
+		err = ErrBPPeersNotInitialized
+	}
+	return
+}
+
+func (c *Chain) queryMempool(addr *proto.AccountAddress) []*types.MempoolTx {
+	c.RLock()
+	defer c.RUnlock()
+	return c.txPool.list(addr)
+}
+
+func (c *Chain) fetchSnapshot(count uint32) (snapshot *types.BPSnapshot, err error) {
+	return loadSnapshot(c.storage, count)
+}
+
+func (c *Chain) queryDatabasesByOwner(owner proto.AccountAddress) []*types.SQLChainProfile {
+	c.RLock()
+	defer c.RUnlock()
+	return c.immutable.loadROSQLChainsByOwner(owner)
+}
+
+func (c *Chain) queryAccount(addr proto.AccountAddress) (
+	account *types.Account, pending pi.AccountNonce, ok bool,
+) {
+	c.RLock()
+	defer c.RUnlock()
+	if account, ok = c.immutable.loadAccountObject(addr); !ok {
+		return
+	}
+	if n, err := c.headBranch.preview.nextNonce(addr); err == nil {
+		pending = n
+	} else {
+		pending = account.NextNonce
+	}
+	return
+}
+
+// queryBillingSummary aggregates the billing updates of database dbid, or all databases if dbid
+// is empty, packed in the irreversible blocks within height range [from, to].
+func (c *Chain) queryBillingSummary(dbid proto.DatabaseID, from, to uint32) (
+	summaries []*types.BillingSummary, err error,
+) {
+	if from > to || to-from >= conf.MaxBillingSummaryRange {
+		err = ErrInvalidRange
+		return
+	}
+	var receiver *proto.AccountAddress
+	if dbid != "" {
+		var profile, ok = c.loadSQLChainProfile(dbid)
+		if !ok {
+			err = ErrDatabaseNotFound
+			return
+		}
+		receiver = &profile.Address
+	}
+
+	var (
+		summarizer = newBillingSummarizer(receiver)
+		node       = c.lastIrreversibleBlock()
+	)
+	for ; node != nil && node.height > to; node = node.parent {
+	}
+	for ; node != nil && node.height >= from && node.count > 0; node = node.parent {
+		if c.isPruned(node) {
+			err = ErrBlockPruned
+			return
+		}
+		if node.txCount == 0 {
+			continue
+		}
+		var b = node.load()
+		if b == nil {
+			if b, err = c.loadBlock(node.hash); err != nil {
+				return
+			}
+		}
+		for _, tx := range b.Transactions {
+			summarizer.add(tx)
+		}
+	}
+	summaries = summarizer.summaries()
+	return
+}
@@ -378,7 +378,7 @@ func TestChain(t *testing.T) {
 			So(err, ShouldBeNil)
 
 			// Create a sibling block from fork#0 and apply
-			_, bl, err = f0.produceBlock(2, begin.Add(2*chain.period).UTC(), addr2, priv2)
+			_, bl, err = f0.produceBlock(2, begin.Add(2*chain.period).UTC(), addr2, priv2, nil)
 			So(err, ShouldBeNil)
 			So(bl, ShouldNotBeNil)
 			err = chain.pushBlock(bl)
@@ -399,7 +399,7 @@ func TestChain(t *testing.T) {
 			err = chain.produceBlock(begin.Add(3 * chain.period).UTC())
 			So(err, ShouldBeNil)
 			// Create a sibling block from fork#1 and apply
-			f1, bl, err = f1.produceBlock(3, begin.Add(3*chain.period).UTC(), addr2, priv2)
+			f1, bl, err = f1.produceBlock(3, begin.Add(3*chain.period).UTC(), addr2, priv2, nil)
 			So(err, ShouldBeNil)
 			So(bl, ShouldNotBeNil)
 			f1.preview.commit()
@@ -412,7 +412,7 @@ func TestChain(t *testing.T) {
 				So(err, ShouldBeNil)
 				// Create a sibling block from fork#1 and apply
 				f1, bl, err = f1.produceBlock(
-					i, begin.Add(time.Duration(i)*chain.period).UTC(), addr2, priv2)
+					i, begin.Add(time.Duration(i)*chain.period).UTC(), addr2, priv2, nil)
 				So(err, ShouldBeNil)
 				So(bl, ShouldNotBeNil)
 				f1.preview.commit()
@@ -444,23 +444,42 @@ func TestChain(t *testing.T) {
 			})
 
 			Convey("The chain head should switch to fork #1 if it grows to count 7", func() {
+				var reorgs = make(chan *ReorgEvent, 1)
+				err = chain.Subscribe(EventTopicReorg, func(e *ReorgEvent) { reorgs <- e })
+				So(err, ShouldBeNil)
 				// Add 2 more blocks to fork #1, this should trigger a branch switch to fork #1
 				chain.stat()
 				f1.addTx(t2)
 				f1.addTx(t3)
 				f1.addTx(t4)
-				f1, bl, err = f1.produceBlock(7, begin.Add(8*chain.period).UTC(), addr2, priv2)
+				f1, bl, err = f1.produceBlock(7, begin.Add(8*chain.period).UTC(), addr2, priv2, nil)
 				So(err, ShouldBeNil)
 				So(bl, ShouldNotBeNil)
 				f1.preview.commit()
 				err = chain.pushBlock(bl)
 				So(err, ShouldBeNil)
-				f1, bl, err = f1.produceBlock(8, begin.Add(9*chain.period).UTC(), addr2, priv2)
+				f1, bl, err = f1.produceBlock(8, begin.Add(9*chain.period).UTC(), addr2, priv2, nil)
 				So(err, ShouldBeNil)
 				So(bl, ShouldNotBeNil)
 				f1.preview.commit()
 				err = chain.pushBlock(bl)
 				So(err, ShouldBeNil)
+				So(chain.head().hash, ShouldResemble, f1.head.hash)
+
+				Convey("The chain should publish the reorg event", func() {
+					var e *ReorgEvent
+					select {
+					case e = <-reorgs:
+					case <-time.After(5 * time.Second):
+					}
+					So(e, ShouldNotBeNil)
+					So(e.CommonAncestor.Count, ShouldEqual, 1)
+					So(e.OldHead.Count, ShouldEqual, 6)
+					So(e.NewHead.Hash, ShouldResemble, f1.head.hash)
+					So(e.Depth(), ShouldEqual, 5)
+					So(e.Attached, ShouldHaveLength, 6)
+					So(e.Readded, ShouldBeEmpty)
+				})
 
 				Convey("The chain should have same state after reloading", func() {
 					err = chain.Stop()
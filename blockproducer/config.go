@@ -53,4 +53,14 @@ type Config struct {
 	Tick   time.Duration
 
 	BlockCacheSize int
+
+	// FastSync initializes a new chain storage from the latest state snapshot of the peers.
+	FastSync bool
+	// Archive keeps the full history of the chain, block pruning is disabled in archive mode.
+	Archive bool
+	// KeepBlocks is the number of most recent blocks kept with full content when pruning,
+	// conf.DefaultBPKeepBlocks is used if it's 0.
+	KeepBlocks uint32
+	// VerifyChain validates the blocks in storage on startup and repairs the torn records.
+	VerifyChain bool
 }
@@ -70,6 +70,31 @@ var (
 	ErrMinerUserNotMatch = errors.New("miner and user do not match")
 	// ErrInsufficientAdvancePayment indicates that the advance payment is insufficient.
 	ErrInsufficientAdvancePayment = errors.New("insufficient advance payment")
+	// ErrBPPeersNotInitialized indicates that the block producer peers are not initialized in
+	// meta state.
+	ErrBPPeersNotInitialized = errors.New("block producer peers not initialized")
+	// ErrInvalidBPPeersTerm indicates that the term of new block producer peers is not greater
+	// than the current one.
+	ErrInvalidBPPeersTerm = errors.New("invalid block producer peers term")
+	// ErrInvalidBPPeers indicates that the new block producer peers are invalid.
+	ErrInvalidBPPeers = errors.New("invalid block producer peers")
+	// ErrInvalidChainConfig indicates that the new chain config is invalid.
+	ErrInvalidChainConfig = errors.New("invalid chain config")
+	// ErrInvalidChainConfigHeight indicates that the effective height of the new chain config
+	// is too close to the current height or not greater than the latest one.
+	ErrInvalidChainConfigHeight = errors.New("invalid chain config effective height")
+	// ErrInsufficientSignoffs indicates that the new chain config is not signed off by
+	// the majority of the current block producers.
+	ErrInsufficientSignoffs = errors.New("insufficient block producer signoffs")
+	// ErrTooManyPendingTxs indicates that the account has too many pending transactions.
+	ErrTooManyPendingTxs = errors.New("too many pending transactions of account")
+	// ErrMempoolFull indicates that the mempool is full and no transaction can be evicted for
+	// the new one.
+	ErrMempoolFull = errors.New("mempool is full")
+	// ErrSnapshotNotFound indicates that the state snapshot is not found.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrSnapshotNotMatch indicates that the state snapshots of peers don't match.
+	ErrSnapshotNotMatch = errors.New("snapshot not match")
 	// ErrNilGenesis indicates that the genesis block is nil in config.
 	ErrNilGenesis = errors.New("nil genesis block")
 	// ErrMultipleGenesis indicates that there're multiple genesis blocks while loading.
@@ -87,4 +112,23 @@ var (
 	ErrNoAvailableBranch = errors.New("no available branch from state storage")
 	// ErrWrongTokenType indicates that token type in transfer is wrong.
 	ErrWrongTokenType = errors.New("wrong token type")
+	// ErrBlockPruned indicates that the transactions of the requested block have been pruned
+	// from the local storage.
+	ErrBlockPruned = errors.New("block is pruned")
+	// ErrInvalidEvidence indicates that an equivocation evidence is invalid.
+	ErrInvalidEvidence = errors.New("invalid equivocation evidence")
+	// ErrDuplicatedEvidence indicates that an equivocation evidence has been recorded.
+	ErrDuplicatedEvidence = errors.New("duplicated equivocation evidence")
+	// ErrDuplicatedBillingReport indicates that the miner has reported the billing of the current
+	// epoch.
+	ErrDuplicatedBillingReport = errors.New("duplicated billing report")
+	// ErrInvalidSubscription indicates that a chain event subscription requests no valid event.
+	ErrInvalidSubscription = errors.New("invalid subscription")
+	// ErrSubscriptionOverflow indicates that a subscriber falls behind the chain events.
+	ErrSubscriptionOverflow = errors.New("subscription overflow")
+	// ErrCorruptedStorage indicates that the chain storage is corrupted and can not be repaired.
+	ErrCorruptedStorage = errors.New("chain storage is corrupted")
+	// ErrCheckpointBusy indicates that the write-ahead log of the chain storage is not fully
+	// checkpointed because of concurrent readers or writers.
+	ErrCheckpointBusy = errors.New("storage checkpoint is busy")
 )
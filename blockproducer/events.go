@@ -0,0 +1,180 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"sort"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+const (
+	// EventTopicFork is the chain bus topic of ForkEvent, published when a block forks a new
+	// branch from the known branches.
+	EventTopicFork = "/Fork/"
+	// EventTopicReorg is the chain bus topic of ReorgEvent, published when the head branch
+	// is switched to a branch which doesn't contain the previous head block.
+	EventTopicReorg = "/Reorg/"
+	// EventTopicEquivocation is the chain bus topic of EquivocationEvent, published when two
+	// different blocks produced by the same producer on the same parent block are received.
+	EventTopicEquivocation = "/Equivocation/"
+	// EventTopicNewBlock is the chain bus topic of NewBlockEvent, published when a new block is
+	// appended to the head branch.
+	EventTopicNewBlock = "/NewBlock/"
+	// EventTopicNewTx is the chain bus topic of NewTxEvent, published when a new transaction is
+	// accepted by the transaction pool.
+	EventTopicNewTx = "/NewTx/"
+	// EventTopicAccountChange is the chain bus topic of AccountChangeEvent, published when an
+	// account is changed by a new irreversible block.
+	EventTopicAccountChange = "/AccountChange/"
+)
+
+// BlockRef is a reference to a block in the main chain.
+type BlockRef struct {
+	Hash   hash.Hash
+	Count  uint32
+	Height uint32
+}
+
+func newBlockRef(n *blockNode) BlockRef {
+	return BlockRef{
+		Hash:   n.hash,
+		Count:  n.count,
+		Height: n.height,
+	}
+}
+
+func newBlockRefs(nodes []*blockNode) (refs []BlockRef) {
+	refs = make([]BlockRef, len(nodes))
+	for i, v := range nodes {
+		refs[i] = newBlockRef(v)
+	}
+	return
+}
+
+// ForkEvent describes a new branch forked from the known branches.
+type ForkEvent struct {
+	// Base is the block which the new branch forks from.
+	Base BlockRef
+	// Head is the head block of the new branch.
+	Head BlockRef
+}
+
+// ReorgEvent describes a reorganization of the main chain.
+type ReorgEvent struct {
+	// CommonAncestor is the latest block shared by the previous and the new head branches.
+	CommonAncestor BlockRef
+	// OldHead and NewHead are the head blocks before and after the reorganization.
+	OldHead, NewHead BlockRef
+	// Detached lists the blocks removed from the main chain in ascending order of count.
+	Detached []BlockRef
+	// Attached lists the blocks added to the main chain in ascending order of count.
+	Attached []BlockRef
+	// Readded lists the transactions in the detached blocks which are moved back to the
+	// transaction pool.
+	Readded []hash.Hash
+}
+
+// Depth returns the number of blocks rolled back by the reorganization.
+func (e *ReorgEvent) Depth() int {
+	return len(e.Detached)
+}
+
+// Subscribe subscribes handler to the chain event topic. Handlers are called asynchronously
+// and serially in the order of publishing, e.g.:
+//
+//	chain.Subscribe(EventTopicReorg, func(e *ReorgEvent) { ... })
+func (c *Chain) Subscribe(topic string, handler interface{}) error {
+	return c.bus.SubscribeAsync(topic, handler, true)
+}
+
+// Unsubscribe removes handler from the chain event topic.
+func (c *Chain) Unsubscribe(topic string, handler interface{}) error {
+	return c.bus.Unsubscribe(topic, handler)
+}
+
+// EquivocationEvent describes a detected double production of a block producer, the headers
+// can be submitted as an EquivocationEvidence transaction.
+type EquivocationEvent struct {
+	// First is the header of the block received earlier.
+	First types.BPSignedHeader
+	// Second is the header of the conflicting block.
+	Second types.BPSignedHeader
+}
+
+// NewBlockEvent describes a new block appended to the head branch.
+type NewBlockEvent struct {
+	Ref   BlockRef
+	Block *types.BPBlock
+}
+
+// NewTxEvent describes a new transaction accepted by the transaction pool.
+type NewTxEvent struct {
+	Tx pi.Transaction
+}
+
+// AccountChangeEvent describes the transactions of a new irreversible block which change the
+// state of an account.
+type AccountChangeEvent struct {
+	Account proto.AccountAddress
+	Block   BlockRef
+	Txs     []hash.Hash
+}
+
+// txAccounts returns the accounts involved in transaction tx.
+func txAccounts(tx pi.Transaction) (accounts []proto.AccountAddress) {
+	accounts = append(accounts, tx.GetAccountAddress())
+	switch t := tx.(type) {
+	case *types.Transfer:
+		if t.Receiver != t.Sender {
+			accounts = append(accounts, t.Receiver)
+		}
+	case *types.UpdateBilling:
+		accounts = append(accounts, t.Receiver)
+	case *pi.TransactionWrapper:
+		return txAccounts(t.Unwrap())
+	}
+	return
+}
+
+// newAccountChangeEvents returns the account change events of the irreversible block n, sorted
+// by account address.
+func newAccountChangeEvents(n *blockNode) (events []*AccountChangeEvent) {
+	var (
+		ref     = newBlockRef(n)
+		changes = make(map[proto.AccountAddress]*AccountChangeEvent)
+	)
+	for _, tx := range n.load().Transactions {
+		var h = tx.Hash()
+		for _, v := range txAccounts(tx) {
+			var e, ok = changes[v]
+			if !ok {
+				e = &AccountChangeEvent{Account: v, Block: ref}
+				changes[v] = e
+				events = append(events, e)
+			}
+			e.Txs = append(e.Txs, h)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return addressLess(events[i].Account, events[j].Account)
+	})
+	return
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package genesis
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// ErrInvalidSpec indicates that the genesis spec is invalid.
+var ErrInvalidSpec = errors.New("invalid genesis spec")
+
+// Spec defines the YAML spec of the main chain genesis.
+type Spec struct {
+	// Version is the genesis block version.
+	Version int32 `yaml:"Version"`
+	// Timestamp is the genesis time of the main chain.
+	Timestamp time.Time `yaml:"Timestamp"`
+	// Period and Tick are the block producing parameters of the main chain.
+	Period time.Duration `yaml:"Period"`
+	Tick   time.Duration `yaml:"Tick"`
+	// BlockProducers is the initial block producer set, the first one is the leader.
+	BlockProducers []proto.Node `yaml:"BlockProducers"`
+	// Accounts are the initial accounts and balances.
+	Accounts []conf.BaseAccountInfo `yaml:"Accounts"`
+}
+
+// BPFragment defines the block producer config entries of a genesis spec.
+type BPFragment struct {
+	BPGenesis conf.BPGenesisInfo `yaml:"BPGenesisInfo"`
+}
+
+// ConfigFragment defines the config entries of a genesis spec, which should be merged into the
+// config files of all the nodes.
+type ConfigFragment struct {
+	BPPeriod   time.Duration `yaml:"BPPeriod"`
+	BPTick     time.Duration `yaml:"BPTick"`
+	BP         BPFragment    `yaml:"BlockProducer"`
+	KnownNodes []proto.Node  `yaml:"KnownNodes"`
+}
+
+// LoadSpec loads the genesis spec from the YAML file.
+func LoadSpec(path string) (spec *Spec, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		err = errors.Wrapf(err, "read genesis spec %s failed", path)
+		return
+	}
+	return ParseSpec(data)
+}
+
+// ParseSpec parses the genesis spec from YAML data.
+func ParseSpec(data []byte) (spec *Spec, err error) {
+	spec = &Spec{}
+	if err = yaml.UnmarshalStrict(data, spec); err != nil {
+		spec = nil
+		err = errors.Wrap(err, "parse genesis spec failed")
+	}
+	return
+}
+
+// FromGenesisInfo returns the genesis spec of the BPGenesisInfo config, which has no chain
+// parameters and block producers.
+func FromGenesisInfo(info *conf.BPGenesisInfo) *Spec {
+	return &Spec{
+		Version:   info.Version,
+		Timestamp: info.Timestamp,
+		Accounts:  info.BaseAccounts,
+	}
+}
+
+// Validate checks the chain parameters, block producers and accounts of the spec.
+func (s *Spec) Validate() (err error) {
+	if s.Timestamp.IsZero() {
+		return errors.Wrap(ErrInvalidSpec, "missing genesis timestamp")
+	}
+	if s.Period < conf.BPMinPeriod || s.Tick < conf.BPMinTick || s.Tick > s.Period {
+		return errors.Wrapf(ErrInvalidSpec, "invalid period %s and tick %s", s.Period, s.Tick)
+	}
+	if len(s.BlockProducers) == 0 {
+		return errors.Wrap(ErrInvalidSpec, "no block producer")
+	}
+	var nodes = make(map[proto.NodeID]bool)
+	for i, v := range s.BlockProducers {
+		if v.ID.IsEmpty() || v.PublicKey == nil || v.Addr == "" {
+			return errors.Wrapf(ErrInvalidSpec, "incomplete block producer #%d", i)
+		}
+		if !kms.IsIDPubNonceValid(v.ID.ToRawNodeID(), &v.Nonce, v.PublicKey) {
+			return errors.Wrapf(ErrInvalidSpec,
+				"node id %s doesn't match the public key and nonce", v.ID)
+		}
+		if nodes[v.ID] {
+			return errors.Wrapf(ErrInvalidSpec, "duplicated block producer %s", v.ID)
+		}
+		nodes[v.ID] = true
+	}
+	var accounts = make(map[hash.Hash]bool)
+	for _, v := range s.Accounts {
+		if v.Address.IsEqual(&hash.Hash{}) {
+			return errors.Wrap(ErrInvalidSpec, "empty account address")
+		}
+		if accounts[v.Address] {
+			return errors.Wrapf(ErrInvalidSpec, "duplicated account %s", v.Address)
+		}
+		accounts[v.Address] = true
+	}
+	return
+}
+
+// Build builds the genesis block of the spec, the result only depends on the version,
+// timestamp and accounts.
+func (s *Spec) Build() (genesis *types.BPBlock, err error) {
+	genesis = &types.BPBlock{
+		SignedHeader: types.BPSignedHeader{
+			BPHeader: types.BPHeader{
+				Version:   s.Version,
+				Timestamp: s.Timestamp,
+			},
+		},
+	}
+	for _, v := range s.Accounts {
+		genesis.Transactions = append(genesis.Transactions, types.NewBaseAccount(
+			&types.Account{
+				Address: proto.AccountAddress(v.Address),
+				TokenBalance: [types.SupportTokenNumber]uint64{
+					v.StableCoinBalance, v.CovenantCoinBalance,
+				},
+			}))
+	}
+	// Rewrite genesis merkle and block hash
+	if err = genesis.SetHash(); err != nil {
+		genesis = nil
+	}
+	return
+}
+
+// Config returns the config entries of the spec, the first block producer is the leader.
+func (s *Spec) Config() (fragment *ConfigFragment) {
+	fragment = &ConfigFragment{
+		BPPeriod: s.Period,
+		BPTick:   s.Tick,
+		BP: BPFragment{
+			BPGenesis: conf.BPGenesisInfo{
+				Version:      s.Version,
+				Timestamp:    s.Timestamp,
+				BaseAccounts: s.Accounts,
+			},
+		},
+	}
+	for i, v := range s.BlockProducers {
+		if i == 0 {
+			v.Role = proto.Leader
+		} else {
+			v.Role = proto.Follower
+		}
+		fragment.KnownNodes = append(fragment.KnownNodes, v)
+	}
+	return
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package genesis
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const testSpec = `
+Version: 1
+Timestamp: 2019-01-01T00:00:00Z
+Period: 10s
+Tick: 1s
+BlockProducers:
+- ID: 00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9
+  Addr: 127.0.0.1:2230
+  PublicKey: 02c76216704d797c64c58bc11519fb68582e8e63de7e5b3b2dbbbe8733efe5fd24
+  Nonce:
+    a: 313283
+    b: 0
+    c: 0
+    d: 0
+Accounts:
+- Address: ba0ba731c7a76ccef2c1170f42038f7e228dfb474ef0190dfe35d9a37911ed37
+  StableCoinBalance: 100
+  CovenantCoinBalance: 200
+- Address: 1a7b0959bbd0d0ec529278a61c0056c277bffe75b2646e1699b46b10a90210be
+  StableCoinBalance: 300
+`
+
+func TestSpec(t *testing.T) {
+	Convey("Given a genesis spec", t, func() {
+		spec, err := ParseSpec([]byte(testSpec))
+		So(err, ShouldBeNil)
+		So(spec.Validate(), ShouldBeNil)
+
+		Convey("The genesis block should be built deterministically", func() {
+			b1, err := spec.Build()
+			So(err, ShouldBeNil)
+			So(b1.Transactions, ShouldHaveLength, 2)
+			So(b1.Timestamp(), ShouldResemble, spec.Timestamp)
+			b2, err := spec.Build()
+			So(err, ShouldBeNil)
+			So(b2.BlockHash(), ShouldResemble, b1.BlockHash())
+
+			Convey("The config entries should build the same genesis block", func() {
+				var cfg = spec.Config()
+				So(cfg.BPPeriod, ShouldEqual, 10*time.Second)
+				So(cfg.BPTick, ShouldEqual, time.Second)
+				So(cfg.KnownNodes, ShouldHaveLength, 1)
+				So(cfg.KnownNodes[0].Role, ShouldEqual, proto.Leader)
+				b3, err := FromGenesisInfo(&cfg.BP.BPGenesis).Build()
+				So(err, ShouldBeNil)
+				So(b3.BlockHash(), ShouldResemble, b1.BlockHash())
+			})
+		})
+		Convey("The invalid spec should be rejected", func() {
+			var cases = []func(s *Spec){
+				func(s *Spec) { s.Timestamp = time.Time{} },
+				func(s *Spec) { s.Period = conf.BPMinPeriod / 2 },
+				func(s *Spec) { s.Tick = s.Period * 2 },
+				func(s *Spec) { s.BlockProducers = nil },
+				func(s *Spec) { s.BlockProducers[0].Addr = "" },
+				func(s *Spec) { s.BlockProducers[0].Nonce.A++ },
+				func(s *Spec) { s.BlockProducers = append(s.BlockProducers, s.BlockProducers[0]) },
+				func(s *Spec) { s.Accounts[1].Address = s.Accounts[0].Address },
+				func(s *Spec) { s.Accounts = append(s.Accounts, conf.BaseAccountInfo{}) },
+			}
+			for _, f := range cases {
+				spec, err := ParseSpec([]byte(testSpec))
+				So(err, ShouldBeNil)
+				f(spec)
+				So(spec.Validate(), ShouldNotBeNil)
+			}
+		})
+		Convey("The unknown fields should be rejected", func() {
+			_, err := ParseSpec([]byte(testSpec + "Unknown: 1\n"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -72,6 +72,14 @@ const (
 	TransactionTypeIssueKeys
 	// TransactionTypeUpdateBilling defines SQLChain update billing information.
 	TransactionTypeUpdateBilling
+	// TransactionTypeUpdateBPPeers defines block producer peers membership change.
+	TransactionTypeUpdateBPPeers
+	// TransactionTypeUpdateChainConfig defines main chain block producing parameters change.
+	TransactionTypeUpdateChainConfig
+	// TransactionTypeEquivocationEvidence defines block producer equivocation evidence submission.
+	TransactionTypeEquivocationEvidence
+	// TransactionTypeUpdateDatabaseQuota defines SQLChain resource quota update.
+	TransactionTypeUpdateDatabaseQuota
 	// TransactionTypeNumber defines transaction types number.
 	TransactionTypeNumber
 )
@@ -102,6 +110,14 @@ func (t TransactionType) String() string {
 		return "IssueKeys"
 	case TransactionTypeUpdateBilling:
 		return "UpdateBilling"
+	case TransactionTypeUpdateBPPeers:
+		return "UpdateBPPeers"
+	case TransactionTypeUpdateChainConfig:
+		return "UpdateChainConfig"
+	case TransactionTypeEquivocationEvidence:
+		return "EquivocationEvidence"
+	case TransactionTypeUpdateDatabaseQuota:
+		return "UpdateDatabaseQuota"
 	default:
 		return "Unknown"
 	}
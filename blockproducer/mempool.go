@@ -0,0 +1,303 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+	"time"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// txPriority returns the packing priority of a transaction, which is the gas price offered by
+// the transaction if any.
+func txPriority(tx pi.Transaction) uint64 {
+	switch t := tx.(type) {
+	case *types.CreateDatabase:
+		return t.GasPrice
+	case *pi.TransactionWrapper:
+		return txPriority(t.Unwrap())
+	default:
+		return 0
+	}
+}
+
+type mempoolTx struct {
+	tx       pi.Transaction
+	hash     hash.Hash
+	addr     proto.AccountAddress
+	nonce    pi.AccountNonce
+	priority uint64
+	seq      uint64
+	received time.Time
+}
+
+// before reports whether t should be packed before o if they're from different accounts: the one
+// with higher priority goes first, then the one received earlier.
+func (t *mempoolTx) before(o *mempoolTx) bool {
+	if t.priority != o.priority {
+		return t.priority > o.priority
+	}
+	if t.seq != o.seq {
+		return t.seq < o.seq
+	}
+	return bytes.Compare(t.hash[:], o.hash[:]) < 0
+}
+
+// mempool is the pool of the transactions which are not irreversible yet.
+type mempool struct {
+	txs      map[hash.Hash]*mempoolTx
+	accounts map[proto.AccountAddress]int
+	seq      uint64
+}
+
+func newMempool() *mempool {
+	return &mempool{
+		txs:      make(map[hash.Hash]*mempoolTx),
+		accounts: make(map[proto.AccountAddress]int),
+	}
+}
+
+func (p *mempool) len() int {
+	return len(p.txs)
+}
+
+func (p *mempool) has(h hash.Hash) (ok bool) {
+	_, ok = p.txs[h]
+	return
+}
+
+func (p *mempool) get(h hash.Hash) (tx pi.Transaction, ok bool) {
+	var e *mempoolTx
+	if e, ok = p.txs[h]; ok {
+		tx = e.tx
+	}
+	return
+}
+
+// put adds tx to the pool without checking any limit.
+func (p *mempool) put(tx pi.Transaction, received time.Time) {
+	var h = tx.Hash()
+	if _, ok := p.txs[h]; ok {
+		return
+	}
+	p.seq++
+	var e = &mempoolTx{
+		tx:       tx,
+		hash:     h,
+		addr:     tx.GetAccountAddress(),
+		nonce:    tx.GetAccountNonce(),
+		priority: txPriority(tx),
+		seq:      p.seq,
+		received: received,
+	}
+	p.txs[h] = e
+	p.accounts[e.addr]++
+}
+
+func (p *mempool) remove(h hash.Hash) {
+	var e, ok = p.txs[h]
+	if !ok {
+		return
+	}
+	delete(p.txs, h)
+	if p.accounts[e.addr]--; p.accounts[e.addr] <= 0 {
+		delete(p.accounts, e.addr)
+	}
+}
+
+// clone returns a copy of the pool, which shares the immutable entries with the origin one.
+func (p *mempool) clone() *mempool {
+	var cpy = &mempool{
+		txs:      make(map[hash.Hash]*mempoolTx, len(p.txs)),
+		accounts: make(map[proto.AccountAddress]int, len(p.accounts)),
+		seq:      p.seq,
+	}
+	for k, v := range p.txs {
+		cpy.txs[k] = v
+	}
+	for k, v := range p.accounts {
+		cpy.accounts[k] = v
+	}
+	return cpy
+}
+
+// admit checks whether tx can be added to the pool. It returns the transactions to be evicted to
+// make room for tx if the pool is full, the pinned transactions are never evicted.
+// The pool itself is not modified.
+func (p *mempool) admit(tx pi.Transaction, pinned func(hash.Hash) bool) (
+	evicted []pi.Transaction, err error,
+) {
+	var addr = tx.GetAccountAddress()
+	if p.accounts[addr] >= conf.MaxPendingTxsPerAccount {
+		err = ErrTooManyPendingTxs
+		return
+	}
+	if len(p.txs) < conf.MaxPooledTxs {
+		return
+	}
+	// Find the lowest ranked account tail as the victim
+	var (
+		tails  = p.tails()
+		victim *mempoolTx
+		rank   = &mempoolTx{priority: txPriority(tx), seq: p.seq + 1, hash: tx.Hash()}
+	)
+	for _, v := range tails {
+		if v.addr == addr || pinned(v.hash) {
+			continue
+		}
+		if victim == nil || victim.before(v) {
+			victim = v
+		}
+	}
+	if victim == nil || !rank.before(victim) {
+		err = ErrMempoolFull
+		return
+	}
+	evicted = []pi.Transaction{victim.tx}
+	return
+}
+
+// tails returns the transaction with the max nonce of each account.
+func (p *mempool) tails() (tails map[proto.AccountAddress]*mempoolTx) {
+	tails = make(map[proto.AccountAddress]*mempoolTx, len(p.accounts))
+	for _, v := range p.txs {
+		if t, ok := tails[v.addr]; !ok || v.nonce > t.nonce {
+			tails[v.addr] = v
+		}
+	}
+	return
+}
+
+// expired returns the transactions received before deadline, along with the following ones
+// from the same accounts which can't be packed anymore. The pinned transactions are excluded.
+func (p *mempool) expired(deadline time.Time, pinned func(hash.Hash) bool) (txs []pi.Transaction) {
+	var from = make(map[proto.AccountAddress]pi.AccountNonce)
+	for _, v := range p.txs {
+		if v.received.Before(deadline) && !pinned(v.hash) {
+			if n, ok := from[v.addr]; !ok || v.nonce < n {
+				from[v.addr] = v.nonce
+			}
+		}
+	}
+	for _, v := range p.txs {
+		if n, ok := from[v.addr]; ok && v.nonce >= n && !pinned(v.hash) {
+			txs = append(txs, v.tx)
+		}
+	}
+	return
+}
+
+// entry returns the pool entry of tx, or a temporary entry with the lowest rank if tx is not
+// found in the pool.
+func (p *mempool) entry(tx pi.Transaction) *mempoolTx {
+	var h = tx.Hash()
+	if p != nil {
+		if e, ok := p.txs[h]; ok {
+			return e
+		}
+	}
+	return &mempoolTx{
+		tx:       tx,
+		hash:     h,
+		addr:     tx.GetAccountAddress(),
+		nonce:    tx.GetAccountNonce(),
+		priority: txPriority(tx),
+		seq:      ^uint64(0),
+	}
+}
+
+// sortTxs sorts txs in packing order: transactions from the same account are sorted by nonce,
+// and the account with the higher ranked next transaction goes first.
+func (p *mempool) sortTxs(txs []pi.Transaction) (sorted []pi.Transaction) {
+	var (
+		queues = make(map[proto.AccountAddress][]*mempoolTx)
+		heads  = make(mempoolHeap, 0)
+	)
+	for _, v := range txs {
+		var e = p.entry(v)
+		queues[e.addr] = append(queues[e.addr], e)
+	}
+	for _, q := range queues {
+		sort.Slice(q, func(i, j int) bool {
+			if q[i].nonce != q[j].nonce {
+				return q[i].nonce < q[j].nonce
+			}
+			return q[i].before(q[j])
+		})
+		heads = append(heads, q)
+	}
+	heap.Init(&heads)
+	sorted = make([]pi.Transaction, 0, len(txs))
+	for heads.Len() > 0 {
+		var q = heads[0]
+		sorted = append(sorted, q[0].tx)
+		if len(q) > 1 {
+			heads[0] = q[1:]
+			heap.Fix(&heads, 0)
+		} else {
+			heap.Pop(&heads)
+		}
+	}
+	return
+}
+
+// list returns the pool entries of addr, or all the entries if addr is nil, in packing order.
+func (p *mempool) list(addr *proto.AccountAddress) (txs []*types.MempoolTx) {
+	var pending = make([]pi.Transaction, 0, len(p.txs))
+	for _, v := range p.txs {
+		if addr == nil || v.addr == *addr {
+			pending = append(pending, v.tx)
+		}
+	}
+	txs = make([]*types.MempoolTx, 0, len(pending))
+	for _, v := range p.sortTxs(pending) {
+		var e = p.entry(v)
+		txs = append(txs, &types.MempoolTx{
+			Hash:     e.hash,
+			Type:     v.GetTransactionType(),
+			Address:  e.addr,
+			Nonce:    e.nonce,
+			Priority: e.priority,
+			Received: e.received,
+		})
+	}
+	return
+}
+
+// mempoolHeap is a heap of per account transaction queues ordered by their first transactions.
+type mempoolHeap [][]*mempoolTx
+
+func (h mempoolHeap) Len() int            { return len(h) }
+func (h mempoolHeap) Less(i, j int) bool  { return h[i][0].before(h[j][0]) }
+func (h mempoolHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mempoolHeap) Push(x interface{}) { *h = append(*h, x.([]*mempoolTx)) }
+func (h *mempoolHeap) Pop() interface{} {
+	var (
+		old = *h
+		n   = len(old)
+		x   = old[n-1]
+	)
+	*h = old[:n-1]
+	return x
+}
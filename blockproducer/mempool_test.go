@@ -0,0 +1,128 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestMempool(t *testing.T) {
+	Convey("Given a mempool with transactions from 3 accounts", t, func() {
+		var (
+			privs [3]*asymmetric.PrivateKey
+			addrs [3]proto.AccountAddress
+			err   error
+		)
+		for i := range privs {
+			privs[i], _, err = asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			addrs[i], err = crypto.PubKeyHash(privs[i].PubKey())
+			So(err, ShouldBeNil)
+		}
+		var (
+			now   = time.Now().UTC()
+			pool  = newMempool()
+			a1, _ = newTransfer(1, privs[0], addrs[0], addrs[1], 1)
+			a2, _ = newTransfer(2, privs[0], addrs[0], addrs[1], 1)
+			b1, _ = newTransfer(1, privs[1], addrs[1], addrs[0], 1)
+			c1    = types.NewCreateDatabase(&types.CreateDatabaseHeader{
+				Owner:    addrs[2],
+				GasPrice: 10,
+				Nonce:    1,
+			})
+			never = func(hash.Hash) bool { return false }
+		)
+		So(c1.Sign(privs[2]), ShouldBeNil)
+		pool.put(b1, now.Add(-2*time.Hour))
+		pool.put(a2, now.Add(-time.Minute))
+		pool.put(a1, now.Add(-2*time.Hour))
+		pool.put(c1, now)
+		pool.put(c1, now)
+		So(pool.len(), ShouldEqual, 4)
+		So(pool.accounts[addrs[0]], ShouldEqual, 2)
+
+		Convey("The transactions should be sorted by priority, arrival and nonce", func() {
+			So(pool.sortTxs([]pi.Transaction{a2, b1, c1, a1}), ShouldResemble,
+				[]pi.Transaction{c1, b1, a1, a2})
+			var list = pool.list(&addrs[0])
+			So(list, ShouldHaveLength, 2)
+			So(list[0].Hash, ShouldResemble, a1.Hash())
+			So(list[1].Nonce, ShouldEqual, 2)
+			So(pool.list(nil)[0].Priority, ShouldEqual, 10)
+		})
+		Convey("The cloned pool should not be affected by the origin one", func() {
+			var cpy = pool.clone()
+			pool.remove(a1.Hash())
+			pool.remove(a1.Hash())
+			So(pool.has(a1.Hash()), ShouldBeFalse)
+			So(pool.accounts[addrs[0]], ShouldEqual, 1)
+			So(cpy.has(a1.Hash()), ShouldBeTrue)
+			So(cpy.accounts[addrs[0]], ShouldEqual, 2)
+		})
+		Convey("The expired transactions should be evicted with the following ones", func() {
+			var expired = pool.expired(now.Add(-time.Hour), never)
+			So(expired, ShouldHaveLength, 3)
+			So(expired, ShouldContain, a2)
+			expired = pool.expired(now.Add(-time.Hour), func(h hash.Hash) bool {
+				return h == a1.Hash()
+			})
+			So(expired, ShouldResemble, []pi.Transaction{b1})
+		})
+		Convey("The account should not exceed the pending limit", func() {
+			pool.accounts[addrs[0]] = conf.MaxPendingTxsPerAccount
+			var a3, _ = newTransfer(3, privs[0], addrs[0], addrs[1], 1)
+			_, err = pool.admit(a3, never)
+			So(err, ShouldEqual, ErrTooManyPendingTxs)
+		})
+		Convey("The full mempool should evict the lowest ranked transaction", func() {
+			for i := pool.len(); i < conf.MaxPooledTxs; i++ {
+				var h = hash.Hash{0xff, byte(i), byte(i >> 8), byte(i >> 16)}
+				pool.txs[h] = &mempoolTx{
+					hash: h, addr: proto.AccountAddress(h), priority: 1, seq: uint64(i)}
+			}
+			var (
+				b2, _ = newTransfer(2, privs[1], addrs[1], addrs[0], 1)
+				c2    = types.NewCreateDatabase(&types.CreateDatabaseHeader{
+					Owner:    addrs[2],
+					GasPrice: 10,
+					Nonce:    2,
+				})
+				evicted []pi.Transaction
+			)
+			So(c2.Sign(privs[2]), ShouldBeNil)
+			_, err = pool.admit(b2, never)
+			So(err, ShouldEqual, ErrMempoolFull)
+			evicted, err = pool.admit(c2, never)
+			So(err, ShouldBeNil)
+			So(evicted, ShouldResemble, []pi.Transaction{a2})
+			evicted, err = pool.admit(c2, func(h hash.Hash) bool { return h == a2.Hash() })
+			So(err, ShouldBeNil)
+			So(evicted, ShouldResemble, []pi.Transaction{b1})
+		})
+	})
+}
@@ -19,6 +19,7 @@ package blockproducer
 import (
 	"github.com/mohae/deepcopy"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/types"
 )
@@ -45,6 +46,12 @@ type metaIndex struct {
 	accounts  map[proto.AccountAddress]*types.Account
 	databases map[proto.DatabaseID]*types.SQLChainProfile
 	provider  map[proto.AccountAddress]*types.ProviderProfile
+	bpPeers   *types.BPPeersProfile
+	// chainConfigs lists the chain config updates in ascending order of effective height,
+	// the dirty index only holds the new ones
+	chainConfigs []*types.ChainConfigProfile
+	// equivocations indexes the recorded block producer equivocations by evidence hash
+	equivocations map[hash.Hash]*types.EquivocationRecord
 }
 
 func newMetaIndex() *metaIndex {
@@ -52,6 +59,8 @@ func newMetaIndex() *metaIndex {
 		accounts:  make(map[proto.AccountAddress]*types.Account),
 		databases: make(map[proto.DatabaseID]*types.SQLChainProfile),
 		provider:  make(map[proto.AccountAddress]*types.ProviderProfile),
+
+		equivocations: make(map[hash.Hash]*types.EquivocationRecord),
 	}
 }
 
@@ -66,5 +75,12 @@ func (i *metaIndex) deepCopy() (cpy *metaIndex) {
 	for k, v := range i.provider {
 		cpy.provider[k] = deepcopy.Copy(v).(*types.ProviderProfile)
 	}
+	// NOTE: a block producer peers profile is never modified in place, share it
+	cpy.bpPeers = i.bpPeers
+	cpy.chainConfigs = i.chainConfigs
+	// NOTE: an equivocation record is never modified once recorded, share it
+	for k, v := range i.equivocations {
+		cpy.equivocations[k] = v
+	}
 	return
 }
@@ -26,6 +26,8 @@ import (
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils"
@@ -244,6 +246,16 @@ func (s *metaState) commit() {
 			delete(s.readonly.provider, k)
 		}
 	}
+	if s.dirty.bpPeers != nil {
+		s.readonly.bpPeers = s.dirty.bpPeers
+	}
+	if len(s.dirty.chainConfigs) > 0 {
+		// NOTE: the readonly list may be shared by copies, always build a new one
+		s.readonly.chainConfigs = s.loadChainConfigs()
+	}
+	for k, v := range s.dirty.equivocations {
+		s.readonly.equivocations[k] = v
+	}
 	// Clean dirty map
 	s.dirty = newMetaIndex()
 	return
@@ -545,6 +557,7 @@ func (s *metaState) updateProviderList(tx *types.ProvideService, height uint32)
 		Deposit:       minDeposit,
 		GasPrice:      tx.GasPrice,
 		NodeID:        tx.NodeID,
+		Region:        tx.Region,
 	}
 	s.dirty.provider[sender] = &pp
 	return
@@ -583,8 +596,9 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 	}
 
 	miners := make(MinerInfos, 0, minerCount)
+	targets := s.targetMiners(&tx.ResourceMeta)
 
-	for _, m := range tx.ResourceMeta.TargetMiners {
+	for _, m := range targets {
 		if po, loaded := s.loadProviderObject(m); !loaded {
 			log.WithFields(log.Fields{
 				"miner_addr": m,
@@ -606,13 +620,13 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 
 	// not enough, find more miner(s)
 	if uint64(miners.Len()) < minerCount {
-		if uint64(len(tx.ResourceMeta.TargetMiners)) >= minerCount {
+		if uint64(len(targets)) >= minerCount {
 			err = errors.Wrapf(err, "miners match target are not enough %d:%d", miners.Len(), minerCount)
 			return
 		}
 		var newMiners MinerInfos
 		// create new merged map
-		newMiners, err = s.filterNMiners(tx, sender, int(minerCount)-miners.Len())
+		newMiners, err = s.filterNMiners(tx, sender, targets, int(minerCount)-miners.Len())
 		if err != nil {
 			return
 		}
@@ -692,43 +706,119 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 	return
 }
 
-func (s *metaState) filterNMiners(
-	tx *types.CreateDatabase,
-	user proto.AccountAddress,
-	minerCount int) (
-	m MinerInfos, err error,
-) {
-	// create new merged map
-	allProviderMap := make(map[proto.AccountAddress]*types.ProviderProfile)
+// loadProviders returns a merged map of the current providers.
+func (s *metaState) loadProviders() (providers map[proto.AccountAddress]*types.ProviderProfile) {
+	providers = make(map[proto.AccountAddress]*types.ProviderProfile)
 	for k, v := range s.readonly.provider {
-		allProviderMap[k] = v
+		providers[k] = v
 	}
 	for k, v := range s.dirty.provider {
 		if v == nil {
-			delete(allProviderMap, k)
+			delete(providers, k)
 		} else {
-			allProviderMap[k] = v
+			providers[k] = v
 		}
 	}
+	return
+}
+
+// targetMiners returns the designated miners of a database creation request, the target nodes
+// are resolved to the addresses of their providers.
+func (s *metaState) targetMiners(meta *types.ResourceMeta) (targets []proto.AccountAddress) {
+	targets = append(targets, meta.TargetMiners...)
+	if len(meta.TargetNodes) == 0 {
+		return
+	}
+	var (
+		providers = s.loadProviders()
+		selected  = make(map[proto.AccountAddress]bool)
+	)
+	for _, v := range targets {
+		selected[v] = true
+	}
+	for _, id := range meta.TargetNodes {
+		// NOTE: pick the least address if the node is claimed by multiple providers to keep
+		// the result deterministic
+		var (
+			addr  proto.AccountAddress
+			found bool
+		)
+		for k, v := range providers {
+			if v.NodeID == id && !selected[k] && (!found || addressLess(k, addr)) {
+				addr, found = k, true
+			}
+		}
+		if !found {
+			log.WithField("node", id).Warn("target node is not a provider")
+			continue
+		}
+		selected[addr] = true
+		targets = append(targets, addr)
+	}
+	return
+}
+
+func (s *metaState) filterNMiners(
+	tx *types.CreateDatabase,
+	user proto.AccountAddress,
+	targets []proto.AccountAddress,
+	minerCount int) (
+	m MinerInfos, err error,
+) {
+	// create new merged map
+	allProviderMap := s.loadProviders()
 
 	// delete selected target miners
-	for _, m := range tx.ResourceMeta.TargetMiners {
+	for _, m := range targets {
 		delete(allProviderMap, m)
 	}
 
 	// suppose 1/4 miners match
-	newMiners := make(MinerInfos, 0, len(allProviderMap)/4)
-	// filter all miners to slice and sort
+	candidates := make([]*types.ProviderProfile, 0, len(allProviderMap)/4)
+	// filter all miners to slice and rank
 	for _, po := range allProviderMap {
-		newMiners, _ = filterAndAppendMiner(newMiners, po, tx, user)
+		if !isProviderUserMatch(po.TargetUser, user) {
+			continue
+		}
+		if match, _ := isProviderReqMatch(po, tx); match {
+			candidates = append(candidates, po)
+		}
 	}
-	if newMiners.Len() < minerCount {
+	if len(candidates) < minerCount {
 		err = ErrNoEnoughMiner
 		return
 	}
 
-	sort.Slice(newMiners, newMiners.Less)
-	return newMiners[:minerCount], nil
+	sort.Slice(candidates, func(i, j int) bool {
+		return isProviderPreferred(candidates[i], candidates[j])
+	})
+	m = make(MinerInfos, 0, minerCount)
+	for _, po := range candidates[:minerCount] {
+		m = append(m, &types.MinerInfo{
+			Address: po.Provider,
+			NodeID:  po.NodeID,
+			Deposit: po.Deposit,
+		})
+	}
+	return
+}
+
+// isProviderPreferred reports whether provider a is preferred to b in allocation: the cheaper
+// one goes first, then the less loaded one, then the one with more memory and disk space.
+func isProviderPreferred(a, b *types.ProviderProfile) bool {
+	if a.GasPrice != b.GasPrice {
+		return a.GasPrice < b.GasPrice
+	}
+	if a.LoadAvgPerCPU != b.LoadAvgPerCPU {
+		return a.LoadAvgPerCPU < b.LoadAvgPerCPU
+	}
+	if a.Memory != b.Memory {
+		return a.Memory > b.Memory
+	}
+	if a.Space != b.Space {
+		return a.Space > b.Space
+	}
+	return a.NodeID < b.NodeID
 }
 
 func filterAndAppendMiner(
@@ -767,6 +857,18 @@ func isProviderUserMatch(targetUsers []proto.AccountAddress, user proto.AccountA
 	return
 }
 
+func isProviderRegionMatch(region string, regions []string) (match bool) {
+	if len(regions) == 0 {
+		return true
+	}
+	for _, v := range regions {
+		if v == region {
+			return true
+		}
+	}
+	return
+}
+
 func isProviderReqMatch(po *types.ProviderProfile, req *types.CreateDatabase) (match bool, err error) {
 	if po.GasPrice > req.GasPrice {
 		err = errors.New("gas price mismatch")
@@ -774,6 +876,18 @@ func isProviderReqMatch(po *types.ProviderProfile, req *types.CreateDatabase) (m
 			po.GasPrice, req.GasPrice)
 		return
 	}
+	if req.ResourceMeta.MaxGasPrice > 0 && po.GasPrice > req.ResourceMeta.MaxGasPrice {
+		err = errors.New("gas price exceeds ceiling")
+		log.WithError(err).Debugf("miner's gas price: %d, user's max gas price: %d",
+			po.GasPrice, req.ResourceMeta.MaxGasPrice)
+		return
+	}
+	if !isProviderRegionMatch(po.Region, req.ResourceMeta.Regions) {
+		err = errors.New("region mismatch")
+		log.WithError(err).Debugf("miner's region: %s, user's regions: %v",
+			po.Region, req.ResourceMeta.Regions)
+		return
+	}
 	if req.ResourceMeta.LoadAvgPerCPU > 0.0 && po.LoadAvgPerCPU > req.ResourceMeta.LoadAvgPerCPU {
 		err = errors.New("load average mismatch")
 		log.WithError(err).Debugf("miner's LoadAvgPerCPU: %f, user's LoadAvgPerCPU: %f",
@@ -907,6 +1021,37 @@ func (s *metaState) updateKeys(tx *types.IssueKeys) (err error) {
 	return
 }
 
+func (s *metaState) updateDatabaseQuota(tx *types.UpdateDatabaseQuota) (err error) {
+	sender := tx.GetAccountAddress()
+	so, loaded := s.loadSQLChainObject(tx.TargetSQLChain.DatabaseID())
+	if !loaded {
+		log.WithFields(log.Fields{
+			"dbID": tx.TargetSQLChain.DatabaseID(),
+		}).WithError(ErrDatabaseNotFound).Error("unexpected error in updateDatabaseQuota")
+		return ErrDatabaseNotFound
+	}
+
+	// only super user is allowed to adjust the quota
+	isSuperUser := false
+	for _, user := range so.Users {
+		if sender == user.Address {
+			isSuperUser = user.Permission.HasSuperPermission()
+			break
+		}
+	}
+	if !isSuperUser {
+		log.WithFields(log.Fields{
+			"sender": sender,
+			"dbID":   tx.TargetSQLChain,
+		}).WithError(ErrAccountPermissionDeny).Error("unexpected error in updateDatabaseQuota")
+		return ErrAccountPermissionDeny
+	}
+
+	so.Meta.Quota = tx.Quota
+	s.dirty.databases[tx.TargetSQLChain.DatabaseID()] = so
+	return
+}
+
 func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 	newProfile, loaded := s.loadSQLChainObject(tx.Receiver.DatabaseID())
 	if !loaded {
@@ -915,6 +1060,10 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 	}
 
 	if tx.Version > 0 && (tx.Range.From >= tx.Range.To || newProfile.LastUpdatedHeight != tx.Range.From) {
+		if markLateBillingReport(newProfile, tx) {
+			s.dirty.databases[tx.Receiver.DatabaseID()] = newProfile
+			return
+		}
 		err = errors.Wrapf(ErrInvalidRange,
 			"update billing within range %d:(%d, %d]",
 			newProfile.LastUpdatedHeight, tx.Range.From, tx.Range.To)
@@ -927,15 +1076,11 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 	}
 
 	var (
-		costMap   = make(map[proto.AccountAddress]uint64)
-		userMap   = make(map[proto.AccountAddress]map[proto.AccountAddress]uint64)
 		minerAddr = tx.GetAccountAddress()
 		isMiner   = false
 	)
 	for _, miner := range newProfile.Miners {
 		isMiner = isMiner || (miner.Address == minerAddr)
-		miner.ReceivedIncome += miner.PendingIncome
-		miner.PendingIncome = 0
 	}
 	if !isMiner {
 		err = ErrInvalidSender
@@ -946,7 +1091,147 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 		return
 	}
 
-	for _, userCost := range tx.Users {
+	if tx.Version == 0 {
+		// legacy billing without epoch, settle it directly
+		settleBilling(newProfile, tx.Users, tx.Range.To)
+		s.dirty.databases[tx.Receiver.DatabaseID()] = newProfile
+		return
+	}
+
+	// Collect the billing report of the epoch and settle the epoch if the majority of the miners
+	// have submitted the same report
+	var (
+		digest hash.Hash
+		votes  = 1
+	)
+	if digest, err = tx.BillingDigest(); err != nil {
+		return
+	}
+	for _, v := range newProfile.BillingReports {
+		if v.Miner == minerAddr {
+			err = errors.Wrapf(ErrDuplicatedBillingReport,
+				"miner %s has reported the billing since height %d",
+				minerAddr, newProfile.LastUpdatedHeight)
+			return
+		}
+		if v.Digest == digest {
+			votes++
+		}
+	}
+	newProfile.BillingReports = append(newProfile.BillingReports, &types.BillingReport{
+		Miner:  minerAddr,
+		Range:  tx.Range,
+		Digest: digest,
+		Users:  tx.Users,
+	})
+	if votes*2 > len(newProfile.Miners) {
+		countMissedBillings(newProfile)
+		settleBilling(newProfile, tx.Users, tx.Range.To)
+		s.replaceFailedMiners(newProfile)
+	} else if len(newProfile.BillingReports) >= len(newProfile.Miners) {
+		// All the miners have reported without a majority, drop the reports and wait for the
+		// next epoch, which will cover the range of this one
+		log.WithFields(log.Fields{
+			"db_id":   tx.Receiver.DatabaseID(),
+			"from":    newProfile.LastUpdatedHeight,
+			"reports": len(newProfile.BillingReports),
+		}).Warning("billing reports discrepancy, drop the epoch")
+		countMissedBillings(newProfile)
+		newProfile.BillingReports = nil
+	}
+	s.dirty.databases[tx.Receiver.DatabaseID()] = newProfile
+	return
+}
+
+// markLateBillingReport resets the missed billing count of the sender if tx is a late report of
+// the last closed epoch, which still proves that the miner is alive.
+func markLateBillingReport(profile *types.SQLChainProfile, tx *types.UpdateBilling) (marked bool) {
+	if tx.Range.From >= tx.Range.To || tx.Range.To != profile.LastUpdatedHeight {
+		return
+	}
+	for _, miner := range profile.Miners {
+		if miner.Address == tx.GetAccountAddress() && miner.MissedBillings > 0 {
+			miner.MissedBillings = 0
+			return true
+		}
+	}
+	return
+}
+
+// countMissedBillings updates the missed billing counts of the miners with the reports of the
+// closing epoch.
+func countMissedBillings(profile *types.SQLChainProfile) {
+	var reported = make(map[proto.AccountAddress]bool)
+	for _, v := range profile.BillingReports {
+		reported[v.Miner] = true
+	}
+	for _, miner := range profile.Miners {
+		if reported[miner.Address] {
+			miner.MissedBillings = 0
+		} else {
+			miner.MissedBillings++
+		}
+	}
+}
+
+// replaceFailedMiners replaces the miners which have missed too many successive billing epochs
+// with the standby providers matching the database requirements. The replacement is appended to
+// the miner list, so a failed leader is also taken over by the next miner. A failed miner is kept
+// if no provider is available and will be checked again at the next epoch.
+func (s *metaState) replaceFailedMiners(profile *types.SQLChainProfile) {
+	var (
+		req = &types.CreateDatabase{
+			CreateDatabaseHeader: types.CreateDatabaseHeader{
+				Owner:        profile.Owner,
+				ResourceMeta: profile.Meta,
+				GasPrice:     profile.GasPrice,
+				TokenType:    profile.TokenType,
+			},
+		}
+		current = make([]proto.AccountAddress, len(profile.Miners))
+		kept    = make([]*types.MinerInfo, 0, len(profile.Miners))
+		standby MinerInfos
+	)
+	for i, v := range profile.Miners {
+		current[i] = v.Address
+	}
+	for _, miner := range profile.Miners {
+		if miner.MissedBillings < conf.MaxMissedBillings {
+			kept = append(kept, miner)
+			continue
+		}
+		var le = log.WithFields(log.Fields{
+			"db_id":  profile.ID,
+			"miner":  miner.Address,
+			"missed": miner.MissedBillings,
+		})
+		newMiners, err := s.filterNMiners(req, profile.Owner, current, 1)
+		if err != nil {
+			le.WithError(err).Warning("no standby provider to replace the failed miner")
+			kept = append(kept, miner)
+			continue
+		}
+		current = append(current, newMiners[0].Address)
+		standby = append(standby, newMiners[0])
+		s.deleteProviderObject(newMiners[0].Address)
+		le.WithField("standby", newMiners[0].Address).Info("replaced the failed miner")
+	}
+	profile.Miners = append(kept, standby...)
+}
+
+// settleBilling applies the user costs to the database profile and closes the billing epoch at
+// height to.
+func settleBilling(newProfile *types.SQLChainProfile, users []*types.UserCost, to uint32) {
+	var (
+		costMap = make(map[proto.AccountAddress]uint64)
+		userMap = make(map[proto.AccountAddress]map[proto.AccountAddress]uint64)
+	)
+	for _, miner := range newProfile.Miners {
+		miner.ReceivedIncome += miner.PendingIncome
+		miner.PendingIncome = 0
+	}
+
+	for _, userCost := range users {
 		log.Debugf("update billing user cost: %s, cost: %d", userCost.User, userCost.Cost)
 		costMap[userCost.User] = userCost.Cost
 		if _, ok := userMap[userCost.User]; !ok {
@@ -993,8 +1278,204 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 			}
 		}
 	}
-	newProfile.LastUpdatedHeight = tx.Range.To
-	s.dirty.databases[tx.Receiver.DatabaseID()] = newProfile
+	newProfile.LastUpdatedHeight = to
+	newProfile.BillingReports = nil
+}
+
+func (s *metaState) loadBPPeers() (o *types.BPPeersProfile, loaded bool) {
+	if o = s.dirty.bpPeers; o != nil {
+		loaded = true
+		return
+	}
+	if o = s.readonly.bpPeers; o != nil {
+		loaded = true
+		return
+	}
+	return
+}
+
+func (s *metaState) updateBPPeers(tx *types.UpdateBPPeers) (err error) {
+	var (
+		cur    *types.BPPeersProfile
+		loaded bool
+		leader *proto.Node
+	)
+	if cur, loaded = s.loadBPPeers(); !loaded {
+		err = ErrBPPeersNotInitialized
+		return
+	}
+	if tx.Peers.Term <= cur.Peers.Term {
+		err = errors.Wrapf(ErrInvalidBPPeersTerm, "current term %d, new term %d",
+			cur.Peers.Term, tx.Peers.Term)
+		return
+	}
+	// Membership change must be issued by the current leader
+	if leader = cur.Node(cur.Peers.Leader); leader == nil ||
+		leader.PublicKey == nil || !leader.PublicKey.IsEqual(tx.Signee) {
+		err = ErrInvalidSender
+		return
+	}
+	if err = verifyBPPeersProfile(&tx.BPPeersProfile); err != nil {
+		return
+	}
+	s.dirty.bpPeers = &types.BPPeersProfile{
+		Peers: tx.Peers.Clone(),
+		Nodes: append([]proto.Node(nil), tx.Nodes...),
+	}
+	return
+}
+
+// verifyBPPeersProfile checks that every peer has a valid node info, and the peer list is signed
+// by its leader.
+func verifyBPPeersProfile(p *types.BPPeersProfile) (err error) {
+	if len(p.Peers.Servers) == 0 {
+		return errors.Wrap(ErrInvalidBPPeers, "empty peer list")
+	}
+	if _, found := p.Peers.Find(p.Peers.Leader); !found {
+		return errors.Wrap(ErrInvalidBPPeers, "leader not in peer list")
+	}
+	for _, v := range p.Peers.Servers {
+		var node = p.Node(v)
+		if node == nil {
+			return errors.Wrapf(ErrInvalidBPPeers, "missing node info of %s", v)
+		}
+		if !kms.IsIDPubNonceValid(v.ToRawNodeID(), &node.Nonce, node.PublicKey) {
+			return errors.Wrapf(ErrInvalidBPPeers, "invalid node info of %s", v)
+		}
+	}
+	if leader := p.Node(p.Peers.Leader); !leader.PublicKey.IsEqual(p.Peers.Signee) {
+		return errors.Wrap(ErrInvalidBPPeers, "peer list not signed by leader")
+	}
+	return p.Peers.Verify()
+}
+
+// loadChainConfigs returns the chain config updates in ascending order of effective height.
+func (s *metaState) loadChainConfigs() (profiles []*types.ChainConfigProfile) {
+	if len(s.dirty.chainConfigs) == 0 {
+		return s.readonly.chainConfigs
+	}
+	profiles = make(
+		[]*types.ChainConfigProfile, 0, len(s.readonly.chainConfigs)+len(s.dirty.chainConfigs))
+	profiles = append(profiles, s.readonly.chainConfigs...)
+	profiles = append(profiles, s.dirty.chainConfigs...)
+	return
+}
+
+func (s *metaState) updateChainConfig(tx *types.UpdateChainConfig, height uint32) (err error) {
+	var (
+		cur      *types.BPPeersProfile
+		loaded   bool
+		profiles = s.loadChainConfigs()
+		signees  = make(map[proto.NodeID]bool)
+	)
+	if tx.Period < conf.BPMinPeriod || tx.Tick < conf.BPMinTick || tx.Tick > tx.Period {
+		err = errors.Wrapf(ErrInvalidChainConfig, "period %s, tick %s", tx.Period, tx.Tick)
+		return
+	}
+	if tx.Height < height+conf.BPChainConfigActivationDelay {
+		err = errors.Wrapf(ErrInvalidChainConfigHeight, "current height %d, effective height %d",
+			height, tx.Height)
+		return
+	}
+	if l := len(profiles); l > 0 && tx.Height <= profiles[l-1].Height {
+		err = errors.Wrapf(ErrInvalidChainConfigHeight, "latest height %d, effective height %d",
+			profiles[l-1].Height, tx.Height)
+		return
+	}
+	// Count the distinct block producers signing off the chain config
+	if cur, loaded = s.loadBPPeers(); !loaded {
+		err = ErrBPPeersNotInitialized
+		return
+	}
+	for _, v := range tx.Signoffs {
+		if err = v.Verify(&tx.ChainConfigProfile); err != nil {
+			return
+		}
+		for _, id := range cur.Peers.Servers {
+			if node := cur.Node(id); node != nil &&
+				node.PublicKey != nil && node.PublicKey.IsEqual(v.Signee) {
+				signees[id] = true
+			}
+		}
+	}
+	if len(signees)*2 <= len(cur.Peers.Servers) {
+		err = errors.Wrapf(ErrInsufficientSignoffs, "%d of %d block producers",
+			len(signees), len(cur.Peers.Servers))
+		return
+	}
+	var profile = tx.ChainConfigProfile
+	s.dirty.chainConfigs = append(s.dirty.chainConfigs, &profile)
+	return
+}
+
+func (s *metaState) loadEquivocation(evidence hash.Hash) (o *types.EquivocationRecord, loaded bool) {
+	if o, loaded = s.dirty.equivocations[evidence]; loaded {
+		return
+	}
+	o, loaded = s.readonly.equivocations[evidence]
+	return
+}
+
+// recordEquivocation records a proved equivocation of a current block producer, and slashes the
+// producer by conf.BPEquivocationPenalty Particle at most, a part of which rewards the reporter.
+func (s *metaState) recordEquivocation(tx *types.EquivocationEvidence, height uint32) (err error) {
+	var (
+		cur      *types.BPPeersProfile
+		loaded   bool
+		isBP     bool
+		producer = tx.Producer()
+		evidence = tx.EvidenceHash()
+		penalty  uint64
+		reporter proto.AccountAddress
+	)
+	if err = tx.VerifyProof(); err != nil {
+		return
+	}
+	if _, loaded = s.loadEquivocation(evidence); loaded {
+		err = errors.Wrapf(ErrDuplicatedEvidence, "evidence %s", evidence.Short(4))
+		return
+	}
+	// Only the equivocation of the current block producers is recorded
+	if cur, loaded = s.loadBPPeers(); !loaded {
+		err = ErrBPPeersNotInitialized
+		return
+	}
+	for _, id := range cur.Peers.Servers {
+		if node := cur.Node(id); node != nil &&
+			node.PublicKey != nil && node.PublicKey.IsEqual(tx.First.Signee) {
+			isBP = true
+			break
+		}
+	}
+	if !isBP {
+		err = errors.Wrapf(ErrInvalidEvidence, "%s is not a block producer", producer)
+		return
+	}
+	if reporter, err = crypto.PubKeyHash(tx.Signee); err != nil {
+		return
+	}
+	// Slash the producer as much as possible up to the penalty
+	if penalty, loaded = s.loadAccountTokenBalance(producer, types.Particle); loaded {
+		if penalty > conf.BPEquivocationPenalty {
+			penalty = conf.BPEquivocationPenalty
+		}
+		if err = s.decreaseAccountToken(producer, penalty, types.Particle); err != nil {
+			return
+		}
+		if reward := penalty / conf.BPEquivocationRewardDivisor; reward > 0 {
+			s.loadOrStoreAccountObject(reporter, &types.Account{Address: reporter})
+			if err = s.increaseAccountToken(reporter, reward, types.Particle); err != nil {
+				return
+			}
+		}
+	}
+	s.dirty.equivocations[evidence] = &types.EquivocationRecord{
+		Evidence: evidence,
+		Producer: producer,
+		Reporter: reporter,
+		Height:   height,
+		Penalty:  penalty,
+	}
 	return
 }
 
@@ -1010,6 +1491,18 @@ func (s *metaState) loadROSQLChains(addr proto.AccountAddress) (dbs []*types.SQL
 	return
 }
 
+// loadROSQLChainsByOwner returns the copies of the databases owned by owner, sorted by
+// database id.
+func (s *metaState) loadROSQLChainsByOwner(owner proto.AccountAddress) (dbs []*types.SQLChainProfile) {
+	for _, db := range s.readonly.databases {
+		if db.Owner == owner {
+			dbs = append(dbs, deepcopy.Copy(db).(*types.SQLChainProfile))
+		}
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].ID < dbs[j].ID })
+	return
+}
+
 func (s *metaState) transferSQLChainTokenBalance(transfer *types.Transfer) (err error) {
 	if transfer.Signee == nil {
 		err = ErrInvalidSender
@@ -1144,8 +1637,16 @@ func (s *metaState) applyTransaction(tx pi.Transaction, height uint32) (err erro
 		err = s.updatePermission(t)
 	case *types.IssueKeys:
 		err = s.updateKeys(t)
+	case *types.UpdateDatabaseQuota:
+		err = s.updateDatabaseQuota(t)
 	case *types.UpdateBilling:
 		err = s.updateBilling(t)
+	case *types.UpdateBPPeers:
+		err = s.updateBPPeers(t)
+	case *types.UpdateChainConfig:
+		err = s.updateChainConfig(t, height)
+	case *types.EquivocationEvidence:
+		err = s.recordEquivocation(t, height)
 	case *pi.TransactionWrapper:
 		// call again using unwrapped transaction
 		err = s.applyTransaction(t.Unwrap(), height)
@@ -1246,6 +1747,15 @@ func (s *metaState) compileChanges(
 			results = append(results, deleteProvider(k))
 		}
 	}
+	if s.dirty.bpPeers != nil {
+		results = append(results, updateBPPeers(s.dirty.bpPeers))
+	}
+	for _, v := range s.dirty.chainConfigs {
+		results = append(results, addChainConfig(v))
+	}
+	for _, v := range s.dirty.equivocations {
+		results = append(results, addEquivocation(v))
+	}
 	return
 }
 
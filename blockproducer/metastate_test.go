@@ -17,9 +17,11 @@
 package blockproducer
 
 import (
+	"fmt"
 	"math"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
@@ -30,6 +32,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	mine "github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/types"
@@ -1341,6 +1344,45 @@ func TestMetaState(t *testing.T) {
 						}
 					}
 				})
+				Convey("update database quota", func() {
+					quota := types.ResourceQuota{
+						QueryTimeout:   time.Second,
+						MaxTempStorage: 1 << 20,
+						MaxResultSize:  1 << 20,
+					}
+					invalidUq1 := types.NewUpdateDatabaseQuota(&types.UpdateDatabaseQuotaHeader{
+						TargetSQLChain: addr1,
+						Quota:          quota,
+						Nonce:          3,
+					})
+					err = invalidUq1.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(invalidUq1, 0)
+					So(err, ShouldEqual, ErrDatabaseNotFound)
+					invalidUq2 := types.NewUpdateDatabaseQuota(&types.UpdateDatabaseQuotaHeader{
+						TargetSQLChain: dbAccount,
+						Quota:          quota,
+						Nonce:          4,
+					})
+					err = invalidUq2.Sign(privKey1)
+					So(err, ShouldBeNil)
+					err = ms.apply(invalidUq2, 0)
+					So(err, ShouldEqual, ErrAccountPermissionDeny)
+					uq := types.NewUpdateDatabaseQuota(&types.UpdateDatabaseQuotaHeader{
+						TargetSQLChain: dbAccount,
+						Quota:          quota,
+						Nonce:          3,
+					})
+					err = uq.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(uq, 0)
+					So(err, ShouldBeNil)
+					ms.commit()
+
+					co, loaded = ms.loadSQLChainObject(dbID)
+					So(loaded, ShouldBeTrue)
+					So(co.Meta.Quota, ShouldResemble, quota)
+				})
 				Convey("update billing", func() {
 					ub1 := &types.UpdateBilling{
 						UpdateBillingHeader: types.UpdateBillingHeader{
@@ -1510,3 +1552,451 @@ func TestMetaState(t *testing.T) {
 		})
 	})
 }
+
+func newTestBPNode(t *testing.T) (priv *asymmetric.PrivateKey, node proto.Node) {
+	var err error
+	if priv, _, err = asymmetric.GenSecp256k1KeyPair(); err != nil {
+		t.Fatalf("failed to generate key pair: %v", err)
+	}
+	node.PublicKey = priv.PubKey()
+	node.Nonce = mine.Uint256{A: 1}
+	node.ID = proto.NodeID(mine.HashBlock(node.PublicKey.Serialize(), node.Nonce).String())
+	return
+}
+
+func TestMetaStateUpdateBPPeers(t *testing.T) {
+	Convey("Given a metaState object with bootstrap block producer peers", t, func() {
+		var (
+			leaderPriv, leader = newTestBPNode(t)
+			newPriv, newBP     = newTestBPNode(t)
+			_, follower        = newTestBPNode(t)
+			ms                 = newMetaState()
+			addr, err          = crypto.PubKeyHash(leader.PublicKey)
+		)
+		So(err, ShouldBeNil)
+		ms.readonly.accounts[addr] = &types.Account{Address: addr}
+		ms.readonly.bpPeers = &types.BPPeersProfile{
+			Peers: proto.Peers{PeersHeader: proto.PeersHeader{
+				Term:    1,
+				Leader:  leader.ID,
+				Servers: []proto.NodeID{leader.ID, follower.ID},
+			}},
+			Nodes: []proto.Node{leader, follower},
+		}
+
+		var newTx = func(
+			signer, leaderSigner *asymmetric.PrivateKey, term uint64, nodes ...proto.Node,
+		) *types.UpdateBPPeers {
+			var header = &types.UpdateBPPeersHeader{Nonce: 0}
+			header.Peers.Term = term
+			header.Peers.Leader = nodes[0].ID
+			for _, v := range nodes {
+				header.Peers.Servers = append(header.Peers.Servers, v.ID)
+			}
+			header.Nodes = nodes
+			So(header.Peers.Sign(leaderSigner), ShouldBeNil)
+			var tx = types.NewUpdateBPPeers(header)
+			So(tx.Sign(signer), ShouldBeNil)
+			return tx
+		}
+
+		Convey("The membership change from the current leader should be applied", func() {
+			var tx = newTx(leaderPriv, newPriv, 2, newBP, leader)
+			So(tx.Verify(), ShouldBeNil)
+			So(ms.apply(tx, 0), ShouldBeNil)
+			ms.commit()
+			profile, loaded := ms.loadBPPeers()
+			So(loaded, ShouldBeTrue)
+			So(profile.Peers.Term, ShouldEqual, 2)
+			So(profile.Peers.Leader, ShouldEqual, newBP.ID)
+			So(profile.Node(follower.ID), ShouldBeNil)
+
+			var nodes = profile.BPNodes()
+			So(nodes, ShouldHaveLength, 2)
+			So(nodes[0].Role, ShouldEqual, proto.Leader)
+			So(nodes[1].Role, ShouldEqual, proto.Follower)
+		})
+		Convey("The membership change should be rejected with a stale term", func() {
+			var tx = newTx(leaderPriv, newPriv, 1, newBP, leader)
+			So(errors.Cause(ms.updateBPPeers(tx)), ShouldEqual, ErrInvalidBPPeersTerm)
+		})
+		Convey("The membership change should be rejected if it's not from the current leader", func() {
+			var tx = newTx(newPriv, newPriv, 2, newBP, leader)
+			So(ms.updateBPPeers(tx), ShouldEqual, ErrInvalidSender)
+		})
+		Convey("The membership change should be rejected if it's not signed by the new leader", func() {
+			var tx = newTx(leaderPriv, leaderPriv, 2, newBP, leader)
+			So(errors.Cause(ms.updateBPPeers(tx)), ShouldEqual, ErrInvalidBPPeers)
+		})
+		Convey("The membership change should be rejected with forged node info", func() {
+			var forged = newBP
+			forged.Nonce.A++
+			var tx = newTx(leaderPriv, newPriv, 2, forged, leader)
+			So(errors.Cause(ms.updateBPPeers(tx)), ShouldEqual, ErrInvalidBPPeers)
+		})
+		Convey("The membership change should be rejected without bootstrap peers", func() {
+			ms.readonly.bpPeers = nil
+			var tx = newTx(leaderPriv, newPriv, 2, newBP, leader)
+			So(ms.updateBPPeers(tx), ShouldEqual, ErrBPPeersNotInitialized)
+		})
+	})
+}
+
+func TestMetaStateUpdateChainConfig(t *testing.T) {
+	Convey("Given a metaState object with 3 block producers", t, func() {
+		var (
+			priv1, bp1 = newTestBPNode(t)
+			priv2, bp2 = newTestBPNode(t)
+			_, bp3     = newTestBPNode(t)
+			other, _   = newTestBPNode(t)
+			ms         = newMetaState()
+			addr, err  = crypto.PubKeyHash(bp1.PublicKey)
+		)
+		So(err, ShouldBeNil)
+		ms.readonly.accounts[addr] = &types.Account{Address: addr}
+		ms.readonly.bpPeers = &types.BPPeersProfile{
+			Peers: proto.Peers{PeersHeader: proto.PeersHeader{
+				Term:    1,
+				Leader:  bp1.ID,
+				Servers: []proto.NodeID{bp1.ID, bp2.ID, bp3.ID},
+			}},
+			Nodes: []proto.Node{bp1, bp2, bp3},
+		}
+
+		var newTx = func(
+			height uint32, period, tick time.Duration, signers ...*asymmetric.PrivateKey,
+		) *types.UpdateChainConfig {
+			var tx = types.NewUpdateChainConfig(&types.UpdateChainConfigHeader{
+				ChainConfigProfile: types.ChainConfigProfile{
+					ChainConfig: types.ChainConfig{Period: period, Tick: tick},
+					Height:      height,
+				},
+			})
+			for _, v := range signers {
+				signoff, err := tx.Signoff(v)
+				So(err, ShouldBeNil)
+				tx.Signoffs = append(tx.Signoffs, signoff)
+			}
+			So(tx.Sign(priv1), ShouldBeNil)
+			return tx
+		}
+
+		Convey("The chain config signed off by the majority should be applied", func() {
+			var tx = newTx(200, 10*time.Second, time.Second, priv1, priv2)
+			So(tx.Verify(), ShouldBeNil)
+			So(ms.apply(tx, 100), ShouldBeNil)
+			So(ms.loadChainConfigs(), ShouldHaveLength, 1)
+			So(ms.readonly.chainConfigs, ShouldBeEmpty)
+			So(ms.compileChanges(nil), ShouldHaveLength, 2)
+			ms.commit()
+			So(ms.loadChainConfigs(), ShouldHaveLength, 1)
+			So(ms.loadChainConfigs()[0].Height, ShouldEqual, 200)
+
+			Convey("The chain config should only take effect in ascending order", func() {
+				tx = newTx(200, 5*time.Second, time.Second, priv1, priv2)
+				So(errors.Cause(ms.updateChainConfig(tx, 100)),
+					ShouldEqual, ErrInvalidChainConfigHeight)
+			})
+		})
+		Convey("The chain config should be rejected without majority signoffs", func() {
+			var tx = newTx(200, 10*time.Second, time.Second, priv1, priv1)
+			So(errors.Cause(ms.updateChainConfig(tx, 100)), ShouldEqual, ErrInsufficientSignoffs)
+			tx = newTx(200, 10*time.Second, time.Second, priv1, other)
+			So(errors.Cause(ms.updateChainConfig(tx, 100)), ShouldEqual, ErrInsufficientSignoffs)
+		})
+		Convey("The chain config should be rejected with invalid parameters", func() {
+			var tx = newTx(200, 10*time.Second, 20*time.Second, priv1, priv2)
+			So(errors.Cause(ms.updateChainConfig(tx, 100)), ShouldEqual, ErrInvalidChainConfig)
+			tx = newTx(200, time.Millisecond, time.Millisecond, priv1, priv2)
+			So(errors.Cause(ms.updateChainConfig(tx, 100)), ShouldEqual, ErrInvalidChainConfig)
+		})
+		Convey("The chain config should be rejected if it takes effect too soon", func() {
+			var tx = newTx(150, 10*time.Second, time.Second, priv1, priv2)
+			So(errors.Cause(ms.updateChainConfig(tx, 100)),
+				ShouldEqual, ErrInvalidChainConfigHeight)
+		})
+	})
+}
+
+func TestMetaStateRecordEquivocation(t *testing.T) {
+	Convey("Given a metaState object with 2 block producers", t, func() {
+		var (
+			priv1, bp1  = newTestBPNode(t)
+			_, bp2      = newTestBPNode(t)
+			other, _    = newTestBPNode(t)
+			ms          = newMetaState()
+			producer, _ = crypto.PubKeyHash(bp1.PublicKey)
+			reporter, _ = crypto.PubKeyHash(testingPrivateKey.PubKey())
+			parent      = hash.Hash{0x1}
+		)
+		ms.readonly.accounts[producer] = &types.Account{Address: producer}
+		ms.readonly.accounts[producer].TokenBalance[types.Particle] = 2 * conf.BPEquivocationPenalty
+		ms.readonly.accounts[reporter] = &types.Account{Address: reporter}
+		ms.readonly.bpPeers = &types.BPPeersProfile{
+			Peers: proto.Peers{PeersHeader: proto.PeersHeader{
+				Term:    1,
+				Leader:  bp1.ID,
+				Servers: []proto.NodeID{bp1.ID, bp2.ID},
+			}},
+			Nodes: []proto.Node{bp1, bp2},
+		}
+
+		var newHeader = func(signer *asymmetric.PrivateKey, ts time.Time) types.BPSignedHeader {
+			var addr, err = crypto.PubKeyHash(signer.PubKey())
+			So(err, ShouldBeNil)
+			var b = &types.BPBlock{SignedHeader: types.BPSignedHeader{BPHeader: types.BPHeader{
+				Producer:   addr,
+				ParentHash: parent,
+				Timestamp:  ts,
+			}}}
+			So(b.PackAndSignBlock(signer), ShouldBeNil)
+			return b.SignedHeader
+		}
+		var newTx = func(first, second types.BPSignedHeader) *types.EquivocationEvidence {
+			var tx = types.NewEquivocationEvidence(&types.EquivocationEvidenceHeader{
+				First:  first,
+				Second: second,
+			})
+			So(tx.Sign(testingPrivateKey), ShouldBeNil)
+			return tx
+		}
+		var (
+			now = time.Now().UTC()
+			h1  = newHeader(priv1, now)
+			h2  = newHeader(priv1, now.Add(time.Second))
+		)
+
+		Convey("The equivocation of a block producer should be recorded and penalized", func() {
+			var tx = newTx(h1, h2)
+			So(tx.Verify(), ShouldBeNil)
+			So(ms.apply(tx, 100), ShouldBeNil)
+			ms.commit()
+			record, ok := ms.loadEquivocation(tx.EvidenceHash())
+			So(ok, ShouldBeTrue)
+			So(record.Producer, ShouldEqual, producer)
+			So(record.Reporter, ShouldEqual, reporter)
+			So(record.Height, ShouldEqual, 100)
+			So(record.Penalty, ShouldEqual, conf.BPEquivocationPenalty)
+			balance, _ := ms.loadAccountTokenBalance(producer, types.Particle)
+			So(balance, ShouldEqual, conf.BPEquivocationPenalty)
+			balance, _ = ms.loadAccountTokenBalance(reporter, types.Particle)
+			So(balance, ShouldEqual, conf.BPEquivocationPenalty/conf.BPEquivocationRewardDivisor)
+
+			Convey("The same evidence should not be recorded twice", func() {
+				var tx = newTx(h2, h1)
+				So(tx.EvidenceHash(), ShouldResemble, record.Evidence)
+				So(errors.Cause(ms.recordEquivocation(tx, 101)), ShouldEqual, ErrDuplicatedEvidence)
+			})
+		})
+		Convey("The invalid evidence should be rejected", func() {
+			So(newTx(h1, h1).Verify(), ShouldEqual, types.ErrInvalidEvidence)
+			So(newTx(h1, newHeader(other, now)).Verify(), ShouldEqual, types.ErrInvalidEvidence)
+			var forged = h2
+			forged.Producer = proto.AccountAddress{0x2}
+			So(newTx(h1, forged).Verify(), ShouldEqual, types.ErrInvalidEvidence)
+			var tx = newTx(newHeader(other, now), newHeader(other, now.Add(time.Second)))
+			So(tx.Verify(), ShouldBeNil)
+			So(errors.Cause(ms.recordEquivocation(tx, 100)), ShouldEqual, ErrInvalidEvidence)
+		})
+	})
+}
+
+func TestMetaStateFilterMiners(t *testing.T) {
+	Convey("Given a metaState object with some providers", t, func() {
+		var (
+			ms      = newMetaState()
+			user    = proto.AccountAddress(hash.HashH([]byte("user")))
+			addrs   = make([]proto.AccountAddress, 6)
+			profile = func(i int, region string, gasPrice uint64, load float64) {
+				addrs[i] = proto.AccountAddress(hash.HashH([]byte{byte(i)}))
+				ms.readonly.provider[addrs[i]] = &types.ProviderProfile{
+					Provider:      addrs[i],
+					Memory:        100,
+					Space:         100,
+					LoadAvgPerCPU: load,
+					GasPrice:      gasPrice,
+					NodeID:        proto.NodeID(fmt.Sprintf("%08d", i)),
+					Region:        region,
+				}
+			}
+			newTx = func(meta types.ResourceMeta) *types.CreateDatabase {
+				return &types.CreateDatabase{CreateDatabaseHeader: types.CreateDatabaseHeader{
+					ResourceMeta: meta,
+					GasPrice:     10,
+				}}
+			}
+			nodeIDs = func(miners MinerInfos) (ids []proto.NodeID) {
+				for _, v := range miners {
+					ids = append(ids, v.NodeID)
+				}
+				return
+			}
+		)
+		profile(0, "us", 5, 0.1)
+		profile(1, "us", 1, 0.5)
+		profile(2, "eu", 1, 0.1)
+		profile(3, "eu", 3, 0.1)
+		profile(4, "asia", 20, 0.1)
+		profile(5, "asia", 2, 0.1)
+		ms.dirty.provider[addrs[5]] = nil
+
+		Convey("The miners should be ranked by gas price and load average", func() {
+			miners, err := ms.filterNMiners(newTx(types.ResourceMeta{}), user, nil, 3)
+			So(err, ShouldBeNil)
+			So(nodeIDs(miners), ShouldResemble, []proto.NodeID{"00000002", "00000001", "00000003"})
+		})
+		Convey("The miners should be filtered by regions and price ceiling", func() {
+			miners, err := ms.filterNMiners(newTx(types.ResourceMeta{
+				Regions: []string{"us", "asia"},
+			}), user, nil, 2)
+			So(err, ShouldBeNil)
+			So(nodeIDs(miners), ShouldResemble, []proto.NodeID{"00000001", "00000000"})
+			_, err = ms.filterNMiners(newTx(types.ResourceMeta{
+				Regions:     []string{"us", "asia"},
+				MaxGasPrice: 4,
+			}), user, nil, 2)
+			So(err, ShouldEqual, ErrNoEnoughMiner)
+		})
+		Convey("The target nodes should be resolved to the provider addresses", func() {
+			var meta = types.ResourceMeta{
+				TargetMiners: []proto.AccountAddress{addrs[3]},
+				TargetNodes:  []proto.NodeID{"00000000", "00000003", "00000005", "99999999"},
+			}
+			So(ms.targetMiners(&meta), ShouldResemble, []proto.AccountAddress{addrs[3], addrs[0]})
+			miners, err := ms.filterNMiners(newTx(meta), user, ms.targetMiners(&meta), 2)
+			So(err, ShouldBeNil)
+			So(nodeIDs(miners), ShouldResemble, []proto.NodeID{"00000002", "00000001"})
+		})
+	})
+}
+
+func TestMetaStateSettleBilling(t *testing.T) {
+	Convey("Given a metaState object with a database of 3 miners", t, func() {
+		var (
+			ms     = newMetaState()
+			user   = proto.AccountAddress{0x1}
+			dbID   = proto.FromAccountAndNonce(user, 1)
+			privs  = make([]*asymmetric.PrivateKey, 3)
+			miners = make([]*types.MinerInfo, 3)
+		)
+		for i := range privs {
+			privs[i], _ = newTestBPNode(t)
+			addr, err := crypto.PubKeyHash(privs[i].PubKey())
+			So(err, ShouldBeNil)
+			miners[i] = &types.MinerInfo{Address: addr}
+		}
+		dbAccount, err := dbID.AccountAddress()
+		So(err, ShouldBeNil)
+		ms.readonly.databases[dbID] = &types.SQLChainProfile{
+			ID:       dbID,
+			GasPrice: 1,
+			Miners:   miners,
+			Users: []*types.SQLChainUser{
+				{Address: user, AdvancePayment: 1000},
+			},
+		}
+
+		var reportRange = func(i int, from, to uint32, cost uint64) error {
+			var tx = types.NewUpdateBilling(&types.UpdateBillingHeader{
+				Receiver: dbAccount,
+				Range:    types.Range{From: from, To: to},
+				Users: []*types.UserCost{{
+					User: user,
+					Cost: cost,
+					Miners: []*types.MinerIncome{
+						{Miner: miners[1].Address, Income: cost / 2},
+						{Miner: miners[0].Address, Income: cost / 2},
+					},
+				}},
+			})
+			tx.Version = int32(tx.HSPDefaultVersion())
+			So(tx.Sign(privs[i]), ShouldBeNil)
+			return ms.updateBilling(tx)
+		}
+		var report = func(i int, to uint32, cost uint64) error {
+			return reportRange(i, 0, to, cost)
+		}
+		var profile = func() *types.SQLChainProfile {
+			var p, ok = ms.loadSQLChainObject(dbID)
+			So(ok, ShouldBeTrue)
+			return p
+		}
+
+		Convey("The epoch should be settled once the majority reports agree", func() {
+			So(report(0, 10, 100), ShouldBeNil)
+			So(profile().LastUpdatedHeight, ShouldEqual, 0)
+			So(profile().BillingReports, ShouldHaveLength, 1)
+			So(errors.Cause(report(0, 10, 100)), ShouldEqual, ErrDuplicatedBillingReport)
+			So(report(1, 10, 120), ShouldBeNil)
+			So(profile().LastUpdatedHeight, ShouldEqual, 0)
+			So(report(2, 10, 100), ShouldBeNil)
+			var p = profile()
+			So(p.LastUpdatedHeight, ShouldEqual, 10)
+			So(p.BillingReports, ShouldBeEmpty)
+			So(p.Users[0].AdvancePayment, ShouldEqual, 900)
+			So(p.Miners[0].PendingIncome, ShouldEqual, 50)
+			So(p.Miners[1].PendingIncome, ShouldEqual, 50)
+			So(p.Miners[2].PendingIncome, ShouldEqual, 0)
+			So(errors.Cause(report(0, 10, 100)), ShouldEqual, ErrInvalidRange)
+		})
+		Convey("The epoch should be dropped if the reports disagree", func() {
+			So(report(0, 10, 100), ShouldBeNil)
+			So(report(1, 10, 120), ShouldBeNil)
+			So(report(2, 20, 100), ShouldBeNil)
+			var p = profile()
+			So(p.LastUpdatedHeight, ShouldEqual, 0)
+			So(p.BillingReports, ShouldBeEmpty)
+			So(p.Users[0].AdvancePayment, ShouldEqual, 1000)
+			So(report(0, 20, 200), ShouldBeNil)
+			So(report(2, 20, 200), ShouldBeNil)
+			So(profile().LastUpdatedHeight, ShouldEqual, 20)
+		})
+		Convey("A late report should keep the miner alive", func() {
+			So(report(0, 10, 100), ShouldBeNil)
+			So(report(1, 10, 100), ShouldBeNil)
+			So(profile().Miners[2].MissedBillings, ShouldEqual, 1)
+			So(report(2, 10, 100), ShouldBeNil)
+			So(profile().Miners[2].MissedBillings, ShouldEqual, 0)
+			So(errors.Cause(report(2, 10, 100)), ShouldEqual, ErrInvalidRange)
+		})
+		Convey("A miner missing too many epochs should be replaced by a standby provider", func() {
+			standbyPriv, _ := newTestBPNode(t)
+			standby, err := crypto.PubKeyHash(standbyPriv.PubKey())
+			So(err, ShouldBeNil)
+			ms.readonly.provider[standby] = &types.ProviderProfile{
+				Provider: standby,
+				NodeID:   "standby",
+				GasPrice: 1,
+				Deposit:  10,
+			}
+			for i := uint32(0); i < conf.MaxMissedBillings; i++ {
+				var p = profile()
+				So(p.Miners, ShouldHaveLength, 3)
+				So(p.Miners[2].Address, ShouldEqual, miners[2].Address)
+				So(reportRange(0, i*10, i*10+10, 100), ShouldBeNil)
+				So(reportRange(1, i*10, i*10+10, 100), ShouldBeNil)
+			}
+			var p = profile()
+			So(p.LastUpdatedHeight, ShouldEqual, conf.MaxMissedBillings*10)
+			So(p.Miners, ShouldHaveLength, 3)
+			So(p.Miners[0].Address, ShouldEqual, miners[0].Address)
+			So(p.Miners[1].Address, ShouldEqual, miners[1].Address)
+			So(p.Miners[2].Address, ShouldEqual, standby)
+			So(p.Miners[2].NodeID, ShouldEqual, "standby")
+			_, loaded := ms.loadProviderObject(standby)
+			So(loaded, ShouldBeFalse)
+			So(errors.Cause(reportRange(2, p.LastUpdatedHeight, p.LastUpdatedHeight+10, 100)),
+				ShouldEqual, ErrInvalidSender)
+		})
+		Convey("A failed miner should be kept if no standby provider is available", func() {
+			for i := uint32(0); i <= conf.MaxMissedBillings; i++ {
+				So(reportRange(0, i*10, i*10+10, 100), ShouldBeNil)
+				So(reportRange(1, i*10, i*10+10, 100), ShouldBeNil)
+			}
+			var p = profile()
+			So(p.Miners, ShouldHaveLength, 3)
+			So(p.Miners[2].Address, ShouldEqual, miners[2].Address)
+			So(p.Miners[2].MissedBillings, ShouldEqual, conf.MaxMissedBillings+1)
+		})
+	})
+}
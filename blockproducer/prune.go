@@ -0,0 +1,86 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// keepBlocks returns the number of most recent blocks kept with full content by config, or 0
+// if the chain runs in archive mode. The blocks required by the peers to fast sync from the
+// kept state snapshots are never pruned.
+func keepBlocks(cfg *Config) (keep uint32) {
+	if cfg.Archive {
+		return
+	}
+	if keep = cfg.KeepBlocks; keep == 0 {
+		keep = conf.DefaultBPKeepBlocks
+	}
+	if min := uint32(conf.BPSnapshotInterval * conf.BPSnapshotKept); keep < min {
+		keep = min
+	}
+	return
+}
+
+// pruneBlocks strips the transactions of the irreversible blocks older than the most recent
+// kept ones from storage, at most conf.BPPruneBatchSize blocks are pruned in each call. It
+// should be called with the chain lock held.
+func (c *Chain) pruneBlocks() {
+	if c.keepBlocks == 0 || c.lastIrre.count < c.keepBlocks {
+		return
+	}
+	var next = c.lastIrre.count - c.keepBlocks + 1
+	if next <= c.pruned {
+		return
+	}
+	if next-c.pruned > conf.BPPruneBatchSize {
+		next = c.pruned + conf.BPPruneBatchSize
+	}
+
+	var (
+		nodes  = make([]*blockNode, 0, next-c.pruned)
+		hashes = make([]hash.Hash, 0, next-c.pruned)
+		from   = c.pruned
+	)
+	for n := c.lastIrre.ancestorByCount(next - 1); n != nil && n.count >= c.pruned; n = n.parent {
+		nodes = append(nodes, n)
+		hashes = append(hashes, n.hash)
+	}
+	if err := store(c.storage, []storageProcedure{pruneBlocks(hashes, next)}, func() {
+		c.pruned = next
+		for _, n := range nodes {
+			c.blockCache.Remove(n.count)
+			n.clear()
+		}
+	}); err != nil {
+		log.WithError(err).Error("failed to prune blocks")
+		return
+	}
+	log.WithFields(log.Fields{
+		"from": from,
+		"to":   next - 1,
+	}).Info("pruned blocks")
+}
+
+// isPruned returns whether the transactions of block node n have been pruned.
+func (c *Chain) isPruned(n *blockNode) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return n.count > 0 && n.count < c.pruned && !n.isFastSyncBase()
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	. "github.com/smartystreets/goconvey/convey"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestPruneBlocks(t *testing.T) {
+	Convey("Given the block keeping configs", t, func() {
+		So(keepBlocks(&Config{Archive: true, KeepBlocks: 5000}), ShouldEqual, 0)
+		So(keepBlocks(&Config{}), ShouldEqual, conf.DefaultBPKeepBlocks)
+		So(keepBlocks(&Config{KeepBlocks: 1}), ShouldEqual,
+			conf.BPSnapshotInterval*conf.BPSnapshotKept)
+		So(keepBlocks(&Config{KeepBlocks: 5000}), ShouldEqual, 5000)
+	})
+	var round int
+	Convey("Given a chain storage with transactions packed in each block", t, func() {
+		const total = 10
+		round++
+		var (
+			now    = time.Now().UTC()
+			nodes  []*blockNode
+			sps    []storageProcedure
+			parent *blockNode
+		)
+		st, err := openStorage(fmt.Sprintf("file:%s", path.Join(testingDataDir, fmt.Sprintf("prune_%d.db", round))))
+		So(err, ShouldBeNil)
+		defer st.Close()
+		cache, err := lru.New(total)
+		So(err, ShouldBeNil)
+
+		for i := uint32(0); i < total; i++ {
+			var b = &types.BPBlock{
+				SignedHeader: types.BPSignedHeader{
+					BPHeader: types.BPHeader{Timestamp: now.Add(time.Duration(i) * time.Second)},
+				},
+			}
+			if parent != nil {
+				b.SignedHeader.ParentHash = parent.hash
+				tx, err := newTransfer(pi.AccountNonce(i), testingPrivateKey,
+					proto.AccountAddress{0x1}, proto.AccountAddress{0x2}, 1)
+				So(err, ShouldBeNil)
+				b.Transactions = []pi.Transaction{tx}
+			}
+			So(b.PackAndSignBlock(testingPrivateKey), ShouldBeNil)
+			parent = newBlockNode(i, b, parent)
+			nodes = append(nodes, parent)
+			cache.Add(parent.count, parent)
+			sps = append(sps, addBlock(i, b), buildBlockIndex(i, b))
+		}
+		So(store(st, sps, nil), ShouldBeNil)
+		count, err := loadPrunedCount(st)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+
+		var c = &Chain{
+			storage:    st,
+			blockCache: cache,
+			keepBlocks: 3,
+			lastIrre:   nodes[total-2],
+			headBranch: &branch{head: nodes[total-1]},
+			pruned:     1,
+		}
+
+		Convey("The old irreversible blocks should be pruned", func() {
+			c.pruneBlocks()
+			So(c.pruned, ShouldEqual, total-4)
+			So(nodes[total-5].load(), ShouldBeNil)
+			So(nodes[total-4].load(), ShouldNotBeNil)
+
+			count, err = loadPrunedCount(st)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, total-4)
+			pb, err := loadBlock(st, nodes[1].hash)
+			So(err, ShouldBeNil)
+			So(pb.Transactions, ShouldBeEmpty)
+			So(pb.BlockHash(), ShouldResemble, &nodes[1].hash)
+			var n int
+			So(st.Reader().QueryRow(
+				`SELECT COUNT(*) FROM "indexed_transactions"`).Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 4)
+			So(st.Reader().QueryRow(
+				`SELECT COUNT(*) FROM "indexed_blocks"`).Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, total)
+
+			_, _, err = c.fetchBlockByCount(1)
+			So(err, ShouldEqual, ErrBlockPruned)
+			_, _, err = c.fetchBlockByHeight(total - 5)
+			So(err, ShouldEqual, ErrBlockPruned)
+			b, _, err := c.fetchBlockByCount(0)
+			So(err, ShouldBeNil)
+			So(b, ShouldNotBeNil)
+			b, _, err = c.fetchBlockByCount(total - 4)
+			So(err, ShouldBeNil)
+			So(b.Transactions, ShouldHaveLength, 1)
+
+			Convey("The pruning should not go further without new irreversible blocks", func() {
+				c.pruneBlocks()
+				So(c.pruned, ShouldEqual, total-4)
+			})
+		})
+		Convey("The blocks should be kept in archive mode", func() {
+			c.keepBlocks = 0
+			c.pruneBlocks()
+			So(c.pruned, ShouldEqual, 1)
+			_, _, err = c.fetchBlockByCount(1)
+			So(err, ShouldBeNil)
+		})
+	})
+}
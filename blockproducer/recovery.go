@@ -0,0 +1,250 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// The chain storage is a SQLite database in WAL journal mode with full synchronization, each
+// storage procedure batch is committed to the write-ahead log atomically. A block producer killed
+// in the middle of applying a block leaves either the whole batch or nothing in the log, which
+// is replayed by SQLite on the next open. The log is periodically checkpointed back to the
+// database file, see checkpoint.
+
+// storageDSN returns the DSN of the chain storage at path.
+func storageDSN(path string) string {
+	return "file:" + path + "?_synchronous=FULL"
+}
+
+// checkpoint transfers the committed transactions in the write-ahead log of st to the database
+// file and truncates the log.
+func checkpoint(st xi.Storage) (err error) {
+	var busy, logged, checkpointed int
+	if err = st.Writer().QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(
+		&busy, &logged, &checkpointed,
+	); err != nil {
+		return
+	}
+	if busy != 0 {
+		err = errors.Wrapf(ErrCheckpointBusy, "%d of %d frames checkpointed", checkpointed, logged)
+	}
+	return
+}
+
+// verifyBlockRecord decodes and verifies a block record from the blocks table. A pruned block is
+// verified by its header only.
+func verifyBlockRecord(
+	genesis *types.BPBlock, height uint32, bnHex, pnHex string, enc []byte, pruned bool,
+) (err error) {
+	var (
+		bh, ph hash.Hash
+		dec    = &types.BPBlock{}
+	)
+	if err = hash.Decode(&bh, bnHex); err != nil {
+		return
+	}
+	if err = hash.Decode(&ph, pnHex); err != nil {
+		return
+	}
+	if err = utils.DecodeMsgPack(enc, dec); err != nil {
+		return
+	}
+	if !dec.BlockHash().IsEqual(&bh) || !dec.ParentHash().IsEqual(&ph) {
+		err = errors.Wrap(ErrCorruptedStorage, "block hash not match")
+		return
+	}
+	switch {
+	case height == 0:
+		if !bh.IsEqual(genesis.BlockHash()) {
+			err = ErrGenesisHashNotMatch
+			return
+		}
+		err = dec.VerifyHash()
+	case pruned:
+		err = dec.SignedHeader.DefaultHashSignVerifierImpl.Verify(&dec.SignedHeader.BPHeader)
+	default:
+		err = dec.Verify()
+	}
+	return
+}
+
+// verifyChain validates the blocks in storage st and repairs the torn records, which are the
+// invalid blocks together with their descendants and the undecodable pending transactions.
+// It fails if the database file is corrupted or the last irreversible block is invalid, which
+// can not be repaired.
+func verifyChain(st xi.Storage, genesis *types.BPBlock) (repaired int, err error) {
+	var (
+		result string
+		rows   *sql.Rows
+
+		irre      hash.Hash
+		baseCount uint32
+		baseHash  hash.Hash
+		hasBase   bool
+		pruned    uint32
+
+		// Scan buffer
+		height       uint32
+		bnHex, pnHex string
+		enc          []byte
+
+		counts = make(map[string]uint32) // valid block hash -> count
+		torn   []string
+		tornTx []string
+	)
+	if err = st.Writer().QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return
+	}
+	if result != "ok" {
+		err = errors.Wrap(ErrCorruptedStorage, result)
+		return
+	}
+	if irre, err = loadIrreHash(st); err != nil {
+		return
+	}
+	if baseCount, baseHash, hasBase, err = loadFastSyncBase(st); err != nil {
+		return
+	}
+	if pruned, err = loadPrunedCount(st); err != nil {
+		return
+	}
+
+	// Verify blocks in insertion order, so that a parent is always checked before its children
+	if rows, err = st.Reader().Query(
+		`SELECT "height", "hash", "parent", "encoded" FROM "blocks" ORDER BY "rowid"`,
+	); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&height, &bnHex, &pnHex, &enc); err != nil {
+			return
+		}
+		var (
+			count   uint32
+			isBase  = hasBase && bnHex == baseHash.String()
+			pc, ok  = counts[pnHex]
+			ierr    error
+			isValid = height == 0 || isBase || ok
+		)
+		switch {
+		case height == 0:
+			count = 0
+		case isBase:
+			count = baseCount
+		default:
+			count = pc + 1
+		}
+		if isValid {
+			ierr = verifyBlockRecord(
+				genesis, height, bnHex, pnHex, enc, count > 0 && count < pruned && !isBase)
+			isValid = ierr == nil
+		}
+		if !isValid {
+			log.WithError(ierr).WithFields(log.Fields{
+				"height": height,
+				"hash":   bnHex,
+				"parent": pnHex,
+			}).Warn("found torn block record")
+			torn = append(torn, bnHex)
+			continue
+		}
+		counts[bnHex] = count
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if _, ok := counts[irre.String()]; !ok {
+		err = errors.Wrapf(ErrCorruptedStorage,
+			"last irreversible block %s is invalid", irre.Short(4))
+		return
+	}
+
+	// Verify pending transactions
+	if rows, err = st.Reader().Query(`SELECT "hash", "encoded" FROM "txPool"`); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = rows.Scan(&bnHex, &enc); err != nil {
+			return
+		}
+		var dec pi.Transaction
+		if ierr := utils.DecodeMsgPack(enc, &dec); ierr != nil || dec == nil || dec.Hash().String() != bnHex {
+			log.WithError(ierr).WithField("hash", bnHex).Warn("found torn transaction record")
+			tornTx = append(tornTx, bnHex)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if repaired = len(torn) + len(tornTx); repaired > 0 {
+		if err = store(st, []storageProcedure{
+			deleteBlockRecords(torn),
+			deleteTxRecords(tornTx),
+		}, nil); err != nil {
+			repaired = 0
+			return
+		}
+	}
+	log.WithFields(log.Fields{
+		"blocks":   len(counts),
+		"repaired": repaired,
+	}).Info("verified chain storage")
+	return
+}
+
+func deleteBlockRecords(hashes []string) storageProcedure {
+	return func(tx *sql.Tx) (err error) {
+		for _, v := range hashes {
+			if _, err = tx.Exec(`DELETE FROM "blocks" WHERE "hash"=?`, v); err != nil {
+				return
+			}
+			if _, err = tx.Exec(`DELETE FROM "indexed_blocks" WHERE "hash"=?`, v); err != nil {
+				return
+			}
+			if _, err = tx.Exec(
+				`DELETE FROM "indexed_transactions" WHERE "block_hash"=?`, v,
+			); err != nil {
+				return
+			}
+		}
+		return
+	}
+}
+
+func deleteTxRecords(hashes []string) storageProcedure {
+	return func(tx *sql.Tx) (err error) {
+		for _, v := range hashes {
+			if _, err = tx.Exec(`DELETE FROM "txPool" WHERE "hash"=?`, v); err != nil {
+				return
+			}
+		}
+		return
+	}
+}
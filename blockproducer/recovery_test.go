@@ -0,0 +1,98 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestVerifyChain(t *testing.T) {
+	var round int
+	Convey("Given a chain storage with a torn tail", t, func() {
+		round++
+		var (
+			now        = time.Now().UTC()
+			genesis, _ = newTestBPBlock(hash.Hash{}, now)
+			b1, _      = newTestBPBlock(*genesis.BlockHash(), now.Add(time.Second))
+			b2, _      = newTestBPBlock(*b1.BlockHash(), now.Add(2*time.Second))
+			b3, _      = newTestBPBlock(*b2.BlockHash(), now.Add(3*time.Second))
+			b4, _      = newTestBPBlock(*b3.BlockHash(), now.Add(4*time.Second))
+			tx, _      = newTransfer(
+				1, testingPrivateKey, proto.AccountAddress{0x1}, proto.AccountAddress{0x2}, 1)
+		)
+		st, err := openStorage(storageDSN(
+			path.Join(testingDataDir, fmt.Sprintf("recovery_%d.db", round))))
+		So(err, ShouldBeNil)
+		defer st.Close()
+
+		// Tamper the block b3 after signing, so that b3 and its child b4 are torn
+		b3.SignedHeader.Timestamp = now
+		So(store(st, []storageProcedure{
+			addBlock(0, genesis),
+			addBlock(1, b1),
+			addBlock(2, b2),
+			addBlock(3, b3),
+			addBlock(4, b4),
+			addTx(tx),
+			updateIrreversible(*b1.BlockHash()),
+		}, nil), ShouldBeNil)
+		_, err = st.Writer().Exec(`INSERT INTO "txPool" ("type", "hash", "encoded")
+	VALUES (?, ?, ?)`, 0, hash.Hash{0x1}.String(), []byte{0x1, 0x2})
+		So(err, ShouldBeNil)
+
+		Convey("The torn records should be repaired", func() {
+			repaired, err := verifyChain(st, genesis)
+			So(err, ShouldBeNil)
+			So(repaired, ShouldEqual, 3)
+			irre, heads, err := loadBlocks(st, *b1.BlockHash())
+			So(err, ShouldBeNil)
+			So(irre.count, ShouldEqual, 1)
+			So(heads, ShouldHaveLength, 1)
+			So(heads[0].hash, ShouldResemble, *b2.BlockHash())
+			pool, err := loadTxPool(st)
+			So(err, ShouldBeNil)
+			So(pool.len(), ShouldEqual, 1)
+			So(pool.has(tx.Hash()), ShouldBeTrue)
+
+			repaired, err = verifyChain(st, genesis)
+			So(err, ShouldBeNil)
+			So(repaired, ShouldEqual, 0)
+			So(checkpoint(st), ShouldBeNil)
+		})
+		Convey("The torn irreversible block should not be repaired", func() {
+			So(store(st, []storageProcedure{
+				updateIrreversible(*b3.BlockHash()),
+			}, nil), ShouldBeNil)
+			_, err := verifyChain(st, genesis)
+			So(errors.Cause(err), ShouldEqual, ErrCorruptedStorage)
+		})
+		Convey("The storage with another genesis block should be rejected", func() {
+			var other, _ = newTestBPBlock(hash.Hash{0x1}, now)
+			_, err := verifyChain(st, other)
+			So(errors.Cause(err), ShouldEqual, ErrCorruptedStorage)
+		})
+	})
+}
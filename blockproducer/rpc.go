@@ -140,3 +140,87 @@ func (s *ChainRPCService) QueryAccountSQLChainProfiles(
 	resp.Profiles = profiles
 	return
 }
+
+// FetchSnapshot is the RPC method to fetch a state snapshot of main chain.
+func (s *ChainRPCService) FetchSnapshot(
+	req *types.FetchSnapshotReq, resp *types.FetchSnapshotResp) (err error,
+) {
+	var snapshot *types.BPSnapshot
+	if snapshot, err = s.chain.fetchSnapshot(req.Count); err != nil {
+		return
+	}
+	resp.Count = snapshot.Count
+	resp.StateHash = snapshot.StateHash
+	if !req.DigestOnly {
+		resp.Snapshot = snapshot
+	}
+	return
+}
+
+// QueryMempool is the RPC method to query the pending transactions in mempool.
+func (s *ChainRPCService) QueryMempool(
+	req *types.QueryMempoolReq, resp *types.QueryMempoolResp) (err error,
+) {
+	var txs = s.chain.queryMempool(req.Addr)
+	resp.Total = uint32(len(txs))
+	if req.Limit > 0 && uint32(len(txs)) > req.Limit {
+		txs = txs[:req.Limit]
+	}
+	resp.Txs = txs
+	return
+}
+
+// QueryBPPeers is the RPC method to query the current block producer peer list.
+func (s *ChainRPCService) QueryBPPeers(
+	req *types.QueryBPPeersReq, resp *types.QueryBPPeersResp) (err error,
+) {
+	resp.Profile, err = s.chain.queryBPPeers()
+	return
+}
+
+// QueryDatabasesByOwner is the RPC method to query the databases owned by an account.
+func (s *ChainRPCService) QueryDatabasesByOwner(
+	req *types.QueryDatabasesByOwnerReq, resp *types.QueryDatabasesByOwnerResp) (err error,
+) {
+	resp.Owner = req.Owner
+	resp.Profiles = s.chain.queryDatabasesByOwner(req.Owner)
+	return
+}
+
+// QueryDatabaseMiners is the RPC method to query the miners serving a database.
+func (s *ChainRPCService) QueryDatabaseMiners(
+	req *types.QueryDatabaseMinersReq, resp *types.QueryDatabaseMinersResp) (err error,
+) {
+	p, ok := s.chain.loadSQLChainProfile(req.DBID)
+	if !ok {
+		err = errors.Wrap(ErrDatabaseNotFound, "rpc query database miners failed")
+		return
+	}
+	resp.DBID = req.DBID
+	resp.Period = p.Period
+	resp.LastUpdatedHeight = p.LastUpdatedHeight
+	resp.Miners = p.Miners
+	return
+}
+
+// QueryAccount is the RPC method to query the balances and nonce of an account.
+func (s *ChainRPCService) QueryAccount(
+	req *types.QueryAccountReq, resp *types.QueryAccountResp) (err error,
+) {
+	var account *types.Account
+	resp.Addr = req.Addr
+	if account, resp.PendingNonce, resp.OK = s.chain.queryAccount(req.Addr); resp.OK {
+		resp.Balances = account.TokenBalance
+		resp.Nonce = account.NextNonce
+	}
+	return
+}
+
+// QueryBillingSummary is the RPC method to query the billing summaries of databases within a
+// block height range.
+func (s *ChainRPCService) QueryBillingSummary(
+	req *types.QueryBillingSummaryReq, resp *types.QueryBillingSummaryResp) (err error,
+) {
+	resp.Summaries, err = s.chain.queryBillingSummary(req.DBID, req.From, req.To)
+	return
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// blockInterval defines the block producing parameters from a height.
+type blockInterval struct {
+	types.ChainConfig
+	height uint32
+	begin  time.Time
+}
+
+// blockSchedule is a list of block intervals in ascending order of height, the first one always
+// begins from the genesis block.
+type blockSchedule []blockInterval
+
+// newBlockSchedule builds the block schedule from the genesis time, the initial chain config and
+// the chain config updates in ascending order of effective height.
+func newBlockSchedule(
+	genesis time.Time, init types.ChainConfig, updates []*types.ChainConfigProfile,
+) (
+	s blockSchedule,
+) {
+	s = blockSchedule{{ChainConfig: init, height: 0, begin: genesis}}
+	for _, v := range updates {
+		var last = s[len(s)-1]
+		if v.Height <= last.height {
+			continue
+		}
+		s = append(s, blockInterval{
+			ChainConfig: v.ChainConfig,
+			height:      v.Height,
+			begin:       last.begin.Add(time.Duration(v.Height-last.height) * last.Period),
+		})
+	}
+	return
+}
+
+// intervalOfHeight returns the block interval which contains height h.
+func (s blockSchedule) intervalOfHeight(h uint32) *blockInterval {
+	var i = sort.Search(len(s), func(i int) bool { return s[i].height > h })
+	return &s[i-1]
+}
+
+// intervalOfTime returns the block interval which contains time t, or the first one if t is
+// before genesis.
+func (s blockSchedule) intervalOfTime(t time.Time) *blockInterval {
+	var i = sort.Search(len(s), func(i int) bool { return s[i].begin.After(t) })
+	if i == 0 {
+		return &s[0]
+	}
+	return &s[i-1]
+}
+
+// heightOfTime calculates the height of a given time reading.
+func (s blockSchedule) heightOfTime(t time.Time) uint32 {
+	var iv = s.intervalOfTime(t)
+	return iv.height + uint32(t.Sub(iv.begin)/iv.Period)
+}
+
+// timeOfHeight calculates the beginning time of a given height.
+func (s blockSchedule) timeOfHeight(h uint32) time.Time {
+	var iv = s.intervalOfHeight(h)
+	return iv.begin.Add(time.Duration(h-iv.height) * iv.Period)
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestBlockSchedule(t *testing.T) {
+	Convey("Given a block schedule with chain config updates", t, func() {
+		var (
+			genesis = time.Now().UTC()
+			s       = newBlockSchedule(genesis, types.ChainConfig{
+				Period: 10 * time.Second,
+				Tick:   time.Second,
+			}, []*types.ChainConfigProfile{
+				{ChainConfig: types.ChainConfig{Period: 5 * time.Second, Tick: time.Second}, Height: 100},
+				{ChainConfig: types.ChainConfig{Period: 2 * time.Second, Tick: time.Second}, Height: 50},
+				{ChainConfig: types.ChainConfig{Period: 20 * time.Second, Tick: 2 * time.Second}, Height: 200},
+			})
+			begin1 = genesis.Add(100 * 10 * time.Second)
+			begin2 = begin1.Add(100 * 5 * time.Second)
+		)
+		So(s, ShouldHaveLength, 3)
+		So(s[1].begin, ShouldResemble, begin1)
+		So(s[2].begin, ShouldResemble, begin2)
+
+		Convey("The height and time should be converted with the matched interval", func() {
+			So(s.heightOfTime(genesis), ShouldEqual, 0)
+			So(s.heightOfTime(genesis.Add(15*time.Second)), ShouldEqual, 1)
+			So(s.heightOfTime(begin1.Add(-time.Second)), ShouldEqual, 99)
+			So(s.heightOfTime(begin1), ShouldEqual, 100)
+			So(s.heightOfTime(begin1.Add(7*time.Second)), ShouldEqual, 101)
+			So(s.heightOfTime(begin2.Add(41*time.Second)), ShouldEqual, 202)
+
+			So(s.timeOfHeight(0), ShouldResemble, genesis)
+			So(s.timeOfHeight(99), ShouldResemble, begin1.Add(-10*time.Second))
+			So(s.timeOfHeight(101), ShouldResemble, begin1.Add(5*time.Second))
+			So(s.timeOfHeight(202), ShouldResemble, begin2.Add(40*time.Second))
+
+			So(s.intervalOfHeight(99).Period, ShouldEqual, 10*time.Second)
+			So(s.intervalOfHeight(100).Period, ShouldEqual, 5*time.Second)
+			So(s.intervalOfHeight(1000).Tick, ShouldEqual, 2*time.Second)
+			So(s.intervalOfTime(genesis.Add(-time.Hour)).height, ShouldEqual, 0)
+		})
+	})
+}
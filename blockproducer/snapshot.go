@@ -0,0 +1,246 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+const fastSyncTimeout = 5 * time.Minute
+
+// makeSnapshot builds the state snapshot at the last irreversible block. It should be called
+// with the chain lock held, after the immutable state is committed.
+func (c *Chain) makeSnapshot() (snapshot *types.BPSnapshot, err error) {
+	var (
+		node  = c.lastIrre
+		ro    = c.immutable.readonly
+		block *types.BPBlock
+	)
+	if block = node.load(); block == nil {
+		if block, err = c.loadBlock(node.hash); err != nil {
+			return
+		}
+	}
+	snapshot = &types.BPSnapshot{
+		Count:     node.count,
+		Height:    node.height,
+		Block:     block,
+		Accounts:  make([]*types.Account, 0, len(ro.accounts)),
+		Databases: make([]*types.SQLChainProfile, 0, len(ro.databases)),
+		Providers: make([]*types.ProviderProfile, 0, len(ro.provider)),
+		BPPeers:   ro.bpPeers,
+
+		ChainConfigs:  ro.chainConfigs,
+		Equivocations: make([]*types.EquivocationRecord, 0, len(ro.equivocations)),
+	}
+	for _, v := range ro.accounts {
+		snapshot.Accounts = append(snapshot.Accounts, v)
+	}
+	for _, v := range ro.databases {
+		snapshot.Databases = append(snapshot.Databases, v)
+	}
+	for _, v := range ro.provider {
+		snapshot.Providers = append(snapshot.Providers, v)
+	}
+	for _, v := range ro.equivocations {
+		snapshot.Equivocations = append(snapshot.Equivocations, v)
+	}
+	err = snapshot.SetStateHash()
+	return
+}
+
+// saveSnapshot saves the state snapshot at the last irreversible block to storage. It should be
+// called with the chain lock held.
+func (c *Chain) saveSnapshot() {
+	var (
+		snapshot *types.BPSnapshot
+		err      error
+	)
+	if snapshot, err = c.makeSnapshot(); err != nil {
+		log.WithError(err).Error("failed to make state snapshot")
+		return
+	}
+	if err = store(c.storage, []storageProcedure{
+		addSnapshot(snapshot),
+		pruneSnapshots(conf.BPSnapshotKept),
+	}, nil); err != nil {
+		log.WithError(err).Error("failed to save state snapshot")
+		return
+	}
+	log.WithFields(log.Fields{
+		"count":      snapshot.Count,
+		"height":     snapshot.Height,
+		"state_hash": snapshot.StateHash.Short(4),
+	}).Info("saved state snapshot")
+	if err = checkpoint(c.storage); err != nil {
+		log.WithError(err).Warn("failed to checkpoint chain database")
+	}
+}
+
+// fastSync initializes the empty storage st from the latest state snapshot of the remote peers,
+// followed by the blocks produced after the snapshot.
+func fastSync(ctx context.Context, st xi.Storage, cfg *Config) (err error) {
+	var (
+		cld, ccl = context.WithTimeout(ctx, fastSyncTimeout)
+		caller   = rpc.NewCaller()
+		remotes  []proto.NodeID
+		source   proto.NodeID
+		snapshot *types.BPSnapshot
+	)
+	defer ccl()
+	for _, v := range cfg.Peers.Servers {
+		if !v.IsEqual(&cfg.NodeID) {
+			remotes = append(remotes, v)
+		}
+	}
+
+	// Fetch the latest snapshot from the first available peer
+	for _, v := range remotes {
+		var (
+			req  = &types.FetchSnapshotReq{}
+			resp = &types.FetchSnapshotResp{}
+			le   = log.WithField("remote", v)
+			ierr error
+		)
+		if ierr = caller.CallNodeWithContext(
+			cld, v, route.MCCFetchSnapshot.String(), req, resp,
+		); ierr != nil {
+			le.WithError(ierr).Warn("failed to fetch state snapshot")
+			continue
+		}
+		if resp.Snapshot == nil {
+			continue
+		}
+		if ierr = resp.Snapshot.VerifyStateHash(); ierr != nil {
+			le.WithError(ierr).Warn("failed to verify state snapshot")
+			continue
+		}
+		source, snapshot = v, resp.Snapshot
+		break
+	}
+	if snapshot == nil {
+		err = ErrSnapshotNotFound
+		return
+	}
+
+	// Cross check the state hash with the other peers
+	for _, v := range remotes {
+		if v == source {
+			continue
+		}
+		var (
+			req  = &types.FetchSnapshotReq{Count: snapshot.Count, DigestOnly: true}
+			resp = &types.FetchSnapshotResp{}
+		)
+		if ierr := caller.CallNodeWithContext(
+			cld, v, route.MCCFetchSnapshot.String(), req, resp,
+		); ierr != nil {
+			log.WithField("remote", v).WithError(ierr).Debug("failed to fetch snapshot digest")
+			continue
+		}
+		if !resp.StateHash.IsEqual(&snapshot.StateHash) {
+			err = errors.Wrapf(ErrSnapshotNotMatch, "snapshot of peer %s at count %d",
+				v, snapshot.Count)
+			return
+		}
+	}
+
+	// Fetch the blocks produced after the snapshot
+	var (
+		base   = *snapshot.Block.BlockHash()
+		parent = base
+		blocks int
+		sps    = []storageProcedure{
+			addBlock(0, cfg.Genesis),
+			addBlock(snapshot.Height, snapshot.Block),
+		}
+	)
+	for count := snapshot.Count + 1; ; count++ {
+		var (
+			req  = &types.FetchBlockByCountReq{Count: count}
+			resp = &types.FetchBlockResp{}
+		)
+		if err = caller.CallNodeWithContext(
+			cld, source, route.MCCFetchBlockByCount.String(), req, resp,
+		); err != nil {
+			err = errors.Wrapf(err, "failed to fetch block at count %d", count)
+			return
+		}
+		if resp.Block == nil {
+			break
+		}
+		if err = resp.Block.Verify(); err != nil {
+			err = errors.Wrapf(err, "failed to verify block at count %d", count)
+			return
+		}
+		if !resp.Block.ParentHash().IsEqual(&parent) {
+			err = errors.Wrapf(ErrParentNotMatch, "block at count %d", count)
+			return
+		}
+		sps = append(sps, addBlock(resp.Height, resp.Block))
+		parent = *resp.Block.BlockHash()
+		blocks++
+	}
+
+	// Restore state from the snapshot
+	for _, v := range snapshot.Accounts {
+		sps = append(sps, updateAccount(v))
+	}
+	for _, v := range snapshot.Databases {
+		sps = append(sps, updateShardChain(v))
+	}
+	for _, v := range snapshot.Providers {
+		sps = append(sps, updateProvider(v))
+	}
+	if snapshot.BPPeers != nil {
+		sps = append(sps, updateBPPeers(snapshot.BPPeers))
+	}
+	for _, v := range snapshot.ChainConfigs {
+		sps = append(sps, addChainConfig(v))
+	}
+	for _, v := range snapshot.Equivocations {
+		sps = append(sps, addEquivocation(v))
+	}
+	sps = append(sps,
+		updateIrreversible(base),
+		setFastSyncBase(snapshot.Count, base),
+		addSnapshot(snapshot),
+	)
+	if err = store(st, sps, nil); err != nil {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"source":     source,
+		"count":      snapshot.Count,
+		"height":     snapshot.Height,
+		"state_hash": snapshot.StateHash.Short(4),
+		"blocks":     blocks,
+	}).Info("fast synchronized from state snapshot")
+	return
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func newTestBPBlock(parent hash.Hash, ts time.Time) (b *types.BPBlock, err error) {
+	b = &types.BPBlock{
+		SignedHeader: types.BPSignedHeader{
+			BPHeader: types.BPHeader{
+				Timestamp:  ts,
+				ParentHash: parent,
+			},
+		},
+	}
+	err = b.PackAndSignBlock(testingPrivateKey)
+	return
+}
+
+func TestSnapshot(t *testing.T) {
+	Convey("Given a state snapshot", t, func() {
+		var (
+			now      = time.Now().UTC()
+			block, _ = newTestBPBlock(hash.Hash{}, now)
+			accounts = []*types.Account{
+				{Address: proto.AccountAddress{0x1}, NextNonce: 1},
+				{Address: proto.AccountAddress{0x2}, NextNonce: 2},
+				{Address: proto.AccountAddress{0x3}, NextNonce: 3},
+			}
+			s1 = &types.BPSnapshot{
+				Count:    10,
+				Height:   12,
+				Block:    block,
+				Accounts: []*types.Account{accounts[2], accounts[0], accounts[1]},
+			}
+			s2 = &types.BPSnapshot{
+				Count:    10,
+				Height:   12,
+				Block:    block,
+				Accounts: []*types.Account{accounts[1], accounts[2], accounts[0]},
+			}
+		)
+		So(block, ShouldNotBeNil)
+		So(s1.SetStateHash(), ShouldBeNil)
+		So(s2.SetStateHash(), ShouldBeNil)
+
+		Convey("The state hash should be deterministic", func() {
+			So(s1.StateHash, ShouldResemble, s2.StateHash)
+			So(s1.VerifyStateHash(), ShouldBeNil)
+		})
+		Convey("The tampered snapshot should fail verification", func() {
+			s1.Accounts[0].NextNonce++
+			So(s1.VerifyStateHash(), ShouldEqual, types.ErrHashVerification)
+			s2.Block = nil
+			So(s2.VerifyStateHash(), ShouldEqual, types.ErrNilBlock)
+		})
+		Convey("The snapshots should be saved, pruned and loaded from storage", func() {
+			st, err := openStorage(fmt.Sprintf("file:%s",
+				path.Join(testingDataDir, "snapshot.db")))
+			So(err, ShouldBeNil)
+			defer st.Close()
+
+			_, err = loadSnapshot(st, 0)
+			So(err, ShouldEqual, ErrSnapshotNotFound)
+			for i := uint32(1); i <= 5; i++ {
+				var s = *s1
+				s.Count = i * 10
+				So(store(st, []storageProcedure{addSnapshot(&s), pruneSnapshots(3)}, nil),
+					ShouldBeNil)
+			}
+			latest, err := loadSnapshot(st, 0)
+			So(err, ShouldBeNil)
+			So(latest.Count, ShouldEqual, 50)
+			So(latest.StateHash, ShouldResemble, s1.StateHash)
+			So(latest.Accounts, ShouldHaveLength, 3)
+			_, err = loadSnapshot(st, 30)
+			So(err, ShouldBeNil)
+			_, err = loadSnapshot(st, 20)
+			So(err, ShouldEqual, ErrSnapshotNotFound)
+		})
+	})
+	Convey("Given a fast synchronized chain storage", t, func() {
+		var (
+			now        = time.Now().UTC()
+			genesis, _ = newTestBPBlock(hash.Hash{}, now)
+			base, _    = newTestBPBlock(hash.Hash{0x1}, now.Add(time.Minute))
+			head, _    = newTestBPBlock(*base.BlockHash(), now.Add(2*time.Minute))
+			st, err    = openStorage(fmt.Sprintf("file:%s",
+				path.Join(testingDataDir, "fastsync.db")))
+		)
+		So(err, ShouldBeNil)
+		defer st.Close()
+		So(store(st, []storageProcedure{
+			addBlock(0, genesis),
+			addBlock(100, base),
+			addBlock(101, head),
+			updateIrreversible(*base.BlockHash()),
+			setFastSyncBase(90, *base.BlockHash()),
+		}, nil), ShouldBeNil)
+
+		Convey("The blocks should be linked from the base block", func() {
+			irre, heads, err := loadBlocks(st, *base.BlockHash())
+			So(err, ShouldBeNil)
+			So(irre.count, ShouldEqual, 90)
+			So(irre.height, ShouldEqual, 100)
+			So(irre.isFastSyncBase(), ShouldBeTrue)
+			So(irre.ancestorByCount(0).hash, ShouldResemble, *genesis.BlockHash())
+			So(heads, ShouldHaveLength, 1)
+			So(heads[0].count, ShouldEqual, 91)
+			So(heads[0].hasAncestor(irre), ShouldBeTrue)
+			So(heads[0].lastIrreversible(10), ShouldEqual, irre)
+			So(heads[0].fetchNodeList(irre.count+1), ShouldHaveLength, 1)
+		})
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -79,6 +80,45 @@ var (
 	UNIQUE ("address")
 );`,
 
+		`CREATE TABLE IF NOT EXISTS "bpPeers" (
+	"id"		INT,
+	"term"		INT,
+	"encoded"	BLOB,
+	UNIQUE ("id")
+);`,
+
+		`CREATE TABLE IF NOT EXISTS "chainConfigs" (
+	"height"	INTEGER PRIMARY KEY,
+	"encoded"	BLOB
+);`,
+
+		`CREATE TABLE IF NOT EXISTS "equivocations" (
+	"evidence"	TEXT PRIMARY KEY,
+	"producer"	TEXT,
+	"height"	INTEGER,
+	"encoded"	BLOB
+);`,
+
+		`CREATE TABLE IF NOT EXISTS "snapshots" (
+	"count"		INTEGER PRIMARY KEY,
+	"height"	INTEGER,
+	"hash"		TEXT,
+	"encoded"	BLOB
+);`,
+
+		`CREATE TABLE IF NOT EXISTS "fastSyncBase" (
+	"id"		INT,
+	"count"		INT,
+	"hash"		TEXT,
+	UNIQUE ("id")
+);`,
+
+		`CREATE TABLE IF NOT EXISTS "pruning" (
+	"id"		INT,
+	"count"		INT,
+	UNIQUE ("id")
+);`,
+
 		`CREATE TABLE IF NOT EXISTS "indexed_blocks" (
 	"height"		INTEGER PRIMARY KEY,
 	"hash"			TEXT,
@@ -404,6 +444,187 @@ func deleteProvider(address proto.AccountAddress) storageProcedure {
 	}
 }
 
+func updateBPPeers(profile *types.BPPeersProfile) storageProcedure {
+	var (
+		enc *bytes.Buffer
+		err error
+	)
+	if enc, err = utils.EncodeMsgPack(profile); err != nil {
+		return errPass(err)
+	}
+	return func(tx *sql.Tx) (err error) {
+		log.WithFields(log.Fields{
+			"term":    profile.Peers.Term,
+			"leader":  profile.Peers.Leader,
+			"servers": profile.Peers.Servers,
+		}).Debug("updating block producer peers")
+		_, err = tx.Exec(`INSERT OR REPLACE INTO "bpPeers" ("id", "term", "encoded")
+	VALUES (?, ?, ?)`, 0, profile.Peers.Term, enc.Bytes())
+		return
+	}
+}
+
+func addChainConfig(profile *types.ChainConfigProfile) storageProcedure {
+	var (
+		enc *bytes.Buffer
+		err error
+	)
+	if enc, err = utils.EncodeMsgPack(profile); err != nil {
+		return errPass(err)
+	}
+	return func(tx *sql.Tx) (err error) {
+		log.WithFields(log.Fields{
+			"height": profile.Height,
+			"period": profile.Period,
+			"tick":   profile.Tick,
+		}).Debug("adding chain config")
+		_, err = tx.Exec(`INSERT OR REPLACE INTO "chainConfigs" ("height", "encoded")
+	VALUES (?, ?)`, profile.Height, enc.Bytes())
+		return
+	}
+}
+
+func addEquivocation(record *types.EquivocationRecord) storageProcedure {
+	var (
+		enc *bytes.Buffer
+		err error
+	)
+	if enc, err = utils.EncodeMsgPack(record); err != nil {
+		return errPass(err)
+	}
+	return func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO "equivocations" ("evidence", "producer", "height", "encoded")
+	VALUES (?, ?, ?, ?)`,
+			record.Evidence.String(), record.Producer.String(), record.Height, enc.Bytes())
+		return
+	}
+}
+
+func addSnapshot(snapshot *types.BPSnapshot) storageProcedure {
+	var (
+		enc *bytes.Buffer
+		err error
+	)
+	if enc, err = utils.EncodeMsgPack(snapshot); err != nil {
+		return errPass(err)
+	}
+	return func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO "snapshots" ("count", "height", "hash", "encoded")
+	VALUES (?, ?, ?, ?)`,
+			snapshot.Count, snapshot.Height, snapshot.StateHash.String(), enc.Bytes())
+		return
+	}
+}
+
+func pruneSnapshots(kept int) storageProcedure {
+	return func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`DELETE FROM "snapshots" WHERE "count" NOT IN (
+	SELECT "count" FROM "snapshots" ORDER BY "count" DESC LIMIT ?)`, kept)
+		return
+	}
+}
+
+func setFastSyncBase(count uint32, h hash.Hash) storageProcedure {
+	return func(tx *sql.Tx) (err error) {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO "fastSyncBase" ("id", "count", "hash")
+	VALUES (?, ?, ?)`, 0, count, h.String())
+		return
+	}
+}
+
+// pruneBlocks strips the transactions of the blocks with the given hashes and drops their
+// transaction indexes, the block headers are kept. The blocks with count less than next are
+// recorded as pruned.
+func pruneBlocks(hashes []hash.Hash, next uint32) storageProcedure {
+	return func(tx *sql.Tx) (err error) {
+		for _, h := range hashes {
+			var (
+				enc []byte
+				buf *bytes.Buffer
+				dec = &types.BPBlock{}
+			)
+			if err = tx.QueryRow(
+				`SELECT "encoded" FROM "blocks" WHERE "hash"=?`, h.String(),
+			).Scan(&enc); err != nil {
+				return
+			}
+			if err = utils.DecodeMsgPack(enc, dec); err != nil {
+				return
+			}
+			dec.Transactions = nil
+			if buf, err = utils.EncodeMsgPack(dec); err != nil {
+				return
+			}
+			if _, err = tx.Exec(`UPDATE "blocks" SET "encoded"=? WHERE "hash"=?`,
+				buf.Bytes(), h.String()); err != nil {
+				return
+			}
+			if _, err = tx.Exec(`DELETE FROM "indexed_transactions" WHERE "block_hash"=?`,
+				h.String()); err != nil {
+				return
+			}
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO "pruning" ("id", "count") VALUES (?, ?)`, 0, next)
+		return
+	}
+}
+
+// loadPrunedCount loads the count which all the blocks before it are pruned, count is 0 if no
+// block has been pruned.
+func loadPrunedCount(st xi.Storage) (count uint32, err error) {
+	if err = st.Reader().QueryRow(
+		`SELECT "count" FROM "pruning" WHERE "id"=0`,
+	).Scan(&count); err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// loadSnapshot loads the snapshot at block count from storage, or the latest one if count is 0.
+func loadSnapshot(st xi.Storage, count uint32) (snapshot *types.BPSnapshot, err error) {
+	var (
+		row *sql.Row
+		enc []byte
+	)
+	if count == 0 {
+		row = st.Reader().QueryRow(
+			`SELECT "encoded" FROM "snapshots" ORDER BY "count" DESC LIMIT 1`)
+	} else {
+		row = st.Reader().QueryRow(`SELECT "encoded" FROM "snapshots" WHERE "count"=?`, count)
+	}
+	if err = row.Scan(&enc); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrSnapshotNotFound
+		}
+		return
+	}
+	var dec = &types.BPSnapshot{}
+	if err = utils.DecodeMsgPack(enc, dec); err != nil {
+		return
+	}
+	snapshot = dec
+	return
+}
+
+// loadFastSyncBase loads the base block of a fast synchronized chain, ok is false if the chain
+// is synchronized from genesis.
+func loadFastSyncBase(st xi.Storage) (count uint32, h hash.Hash, ok bool, err error) {
+	var hex string
+	if err = st.Reader().QueryRow(
+		`SELECT "count", "hash" FROM "fastSyncBase" WHERE "id"=0`,
+	).Scan(&count, &hex); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+		return
+	}
+	if err = hash.Decode(&h, hex); err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
 func loadIrreHash(st xi.Storage) (irre hash.Hash, err error) {
 	var hex string
 	// Load last irreversible block hash
@@ -418,14 +639,15 @@ func loadIrreHash(st xi.Storage) (irre hash.Hash, err error) {
 	return
 }
 
-func loadTxPool(st xi.Storage) (txPool map[hash.Hash]pi.Transaction, err error) {
+func loadTxPool(st xi.Storage) (txPool *mempool, err error) {
 	var (
 		th   hash.Hash
 		rows *sql.Rows
 		tt   uint32
 		hex  string
 		enc  []byte
-		pool = make(map[hash.Hash]pi.Transaction)
+		now  = time.Now().UTC()
+		pool = newMempool()
 	)
 
 	if rows, err = st.Reader().Query(
@@ -446,7 +668,7 @@ func loadTxPool(st xi.Storage) (txPool map[hash.Hash]pi.Transaction, err error)
 		if err = utils.DecodeMsgPack(enc, &dec); err != nil {
 			return
 		}
-		pool[th] = dec
+		pool.put(dec, now)
 	}
 
 	txPool = pool
@@ -488,8 +710,18 @@ func loadBlocks(
 		ok     bool
 		bh, ph hash.Hash
 		bn, pn *blockNode
+
+		genesis   *blockNode
+		baseCount uint32
+		baseHash  hash.Hash
+		hasBase   bool
 	)
 
+	// Load the base block of fast synchronization
+	if baseCount, baseHash, hasBase, err = loadFastSyncBase(st); err != nil {
+		return
+	}
+
 	// Load blocks
 	if rows, err = st.Reader().Query(
 		`SELECT "rowid", "height", "hash", "parent", "encoded" FROM "blocks" ORDER BY "rowid"`,
@@ -526,6 +758,7 @@ func loadBlocks(
 				return
 			}
 			bn = newNonCacheBlockNode(0, dec, nil)
+			genesis = bn
 			index[bh] = bn
 			headsIndex[bh] = bn
 			log.WithFields(log.Fields{
@@ -536,6 +769,15 @@ func loadBlocks(
 			}).Debug("set genesis block")
 			continue
 		}
+		// Add fast synchronization base block, which is linked to genesis directly
+		if hasBase && bh.IsEqual(&baseHash) && genesis != nil {
+			bn = newNonCacheBlockNode(height, dec, genesis)
+			bn.count = baseCount
+			index[bh] = bn
+			delete(headsIndex, genesis.hash)
+			headsIndex[bh] = bn
+			continue
+		}
 		// Add normal block
 		if pn, ok = index[ph]; !ok {
 			err = errors.Wrapf(ErrParentNotFound, "parent %s not found", ph.Short(4))
@@ -647,6 +889,77 @@ func loadAndCacheProviders(st xi.Storage, view *metaState) (err error) {
 	return
 }
 
+func loadAndCacheBPPeers(st xi.Storage, view *metaState) (err error) {
+	var enc []byte
+	if err = st.Reader().QueryRow(
+		`SELECT "encoded" FROM "bpPeers" WHERE "id"=0`,
+	).Scan(&enc); err != nil {
+		if err == sql.ErrNoRows {
+			// Not changed since genesis, use the peers from config
+			err = nil
+		}
+		return
+	}
+	var dec = &types.BPPeersProfile{}
+	if err = utils.DecodeMsgPack(enc, dec); err != nil {
+		return
+	}
+	view.readonly.bpPeers = dec
+	return
+}
+
+func loadAndCacheChainConfigs(st xi.Storage, view *metaState) (err error) {
+	var (
+		rows *sql.Rows
+		enc  []byte
+	)
+
+	if rows, err = st.Reader().Query(
+		`SELECT "encoded" FROM "chainConfigs" ORDER BY "height"`,
+	); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.Scan(&enc); err != nil {
+			return
+		}
+		var dec = &types.ChainConfigProfile{}
+		if err = utils.DecodeMsgPack(enc, dec); err != nil {
+			return
+		}
+		view.readonly.chainConfigs = append(view.readonly.chainConfigs, dec)
+	}
+
+	return
+}
+
+func loadAndCacheEquivocations(st xi.Storage, view *metaState) (err error) {
+	var (
+		rows *sql.Rows
+		enc  []byte
+	)
+
+	if rows, err = st.Reader().Query(`SELECT "encoded" FROM "equivocations"`); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.Scan(&enc); err != nil {
+			return
+		}
+		var dec = &types.EquivocationRecord{}
+		if err = utils.DecodeMsgPack(enc, dec); err != nil {
+			return
+		}
+		view.readonly.equivocations[dec.Evidence] = dec
+	}
+
+	return
+}
+
 func loadImmutableState(st xi.Storage) (immutable *metaState, err error) {
 	immutable = newMetaState()
 	if err = loadAndCacheAccounts(st, immutable); err != nil {
@@ -658,6 +971,15 @@ func loadImmutableState(st xi.Storage) (immutable *metaState, err error) {
 	if err = loadAndCacheProviders(st, immutable); err != nil {
 		return
 	}
+	if err = loadAndCacheBPPeers(st, immutable); err != nil {
+		return
+	}
+	if err = loadAndCacheChainConfigs(st, immutable); err != nil {
+		return
+	}
+	if err = loadAndCacheEquivocations(st, immutable); err != nil {
+		return
+	}
 	return
 }
 
@@ -665,7 +987,7 @@ func loadDatabase(st xi.Storage) (
 	irre *blockNode,
 	heads []*blockNode,
 	immutable *metaState,
-	txPool map[hash.Hash]pi.Transaction,
+	txPool *mempool,
 	err error,
 ) {
 	var irreHash hash.Hash
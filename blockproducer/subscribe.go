@@ -0,0 +1,173 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// subscription filters the chain events of a subscriber and buffers them for pushing.
+type subscription struct {
+	events   map[types.ChainEventType]bool
+	accounts map[proto.AccountAddress]bool // all accounts if empty
+
+	backlog  chan *types.ChainEvent
+	overflow chan struct{}
+	once     sync.Once
+}
+
+func newSubscription(req *types.SubscribeReq) (s *subscription) {
+	s = &subscription{
+		events:   make(map[types.ChainEventType]bool),
+		accounts: make(map[proto.AccountAddress]bool),
+		backlog:  make(chan *types.ChainEvent, conf.MaxSubscriptionBacklog),
+		overflow: make(chan struct{}),
+	}
+	for _, v := range req.Events {
+		s.events[v] = true
+	}
+	for _, v := range req.Accounts {
+		s.accounts[v] = true
+	}
+	return
+}
+
+func (s *subscription) watches(accounts ...proto.AccountAddress) bool {
+	if len(s.accounts) == 0 {
+		return true
+	}
+	for _, v := range accounts {
+		if s.accounts[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// push adds e to the backlog without blocking the chain bus, the subscription overflows if the
+// backlog is full.
+func (s *subscription) push(e *types.ChainEvent) {
+	select {
+	case s.backlog <- e:
+	default:
+		s.once.Do(func() { close(s.overflow) })
+	}
+}
+
+func (s *subscription) onNewBlock(e *NewBlockEvent) {
+	s.push(&types.ChainEvent{
+		Type:      types.ChainEventNewBlock,
+		Count:     e.Ref.Count,
+		Height:    e.Ref.Height,
+		BlockHash: e.Ref.Hash,
+		Block:     e.Block,
+	})
+}
+
+func (s *subscription) onNewTx(e *NewTxEvent) {
+	if s.watches(txAccounts(e.Tx)...) {
+		s.push(&types.ChainEvent{
+			Type: types.ChainEventNewTx,
+			Tx:   e.Tx,
+		})
+	}
+}
+
+func (s *subscription) onAccountChange(e *AccountChangeEvent) {
+	if s.watches(e.Account) {
+		s.push(&types.ChainEvent{
+			Type:      types.ChainEventAccountChange,
+			Count:     e.Block.Count,
+			Height:    e.Block.Height,
+			BlockHash: e.Block.Hash,
+			Account:   e.Account,
+			Txs:       e.Txs,
+		})
+	}
+}
+
+// serveSubscribe is the streaming RPC handler which pushes the subscribed chain events to the
+// caller until the caller disconnects or the chain stops.
+func (c *Chain) serveSubscribe(ctx context.Context, call *rpc.StreamCall, w io.Writer) (err error) {
+	var req = &types.SubscribeReq{}
+	if err = call.ReadArgs(req); err != nil {
+		return
+	}
+	var (
+		s        = newSubscription(req)
+		handlers = map[string]interface{}{}
+	)
+	if s.events[types.ChainEventNewBlock] {
+		handlers[EventTopicNewBlock] = s.onNewBlock
+	}
+	if s.events[types.ChainEventNewTx] {
+		handlers[EventTopicNewTx] = s.onNewTx
+	}
+	if s.events[types.ChainEventAccountChange] {
+		handlers[EventTopicAccountChange] = s.onAccountChange
+	}
+	if len(handlers) == 0 {
+		err = ErrInvalidSubscription
+		return
+	}
+	// NOTE: the same handler values must be used to unsubscribe
+	for k, v := range handlers {
+		if err = c.Subscribe(k, v); err != nil {
+			return
+		}
+		defer func(topic string, handler interface{}) {
+			_ = c.Unsubscribe(topic, handler)
+		}(k, v)
+	}
+
+	var (
+		enc = utils.GetMsgPackEncoder(w)
+		le  = log.WithFields(log.Fields{
+			"remote": call.Remote,
+			"events": req.Events,
+		})
+	)
+	le.Debug("chain events subscribed")
+	for {
+		select {
+		case e := <-s.backlog:
+			if err = enc.Encode(e); err != nil {
+				le.WithError(err).Debug("subscriber disconnected")
+				return
+			}
+		case <-s.overflow:
+			err = errors.Wrapf(ErrSubscriptionOverflow,
+				"subscriber fell behind %d events", conf.MaxSubscriptionBacklog)
+			return
+		case <-c.ctx.Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+func TestSubscription(t *testing.T) {
+	Convey("Given some transactions of accounts", t, func() {
+		var (
+			addr1, addr2, addr3 = proto.AccountAddress{0x1}, proto.AccountAddress{0x2}, proto.AccountAddress{0x3}
+
+			tx1, _ = newTransfer(1, testingPrivateKey, addr1, addr2, 10)
+			tx2, _ = newTransfer(2, testingPrivateKey, addr1, addr3, 10)
+			sender = tx1.GetAccountAddress()
+			block  = &types.BPBlock{Transactions: []pi.Transaction{tx1, pi.WrapTransaction(tx2)}}
+			node   = newBlockNode(1, block, nil)
+		)
+		Convey("The involved accounts should be collected", func() {
+			So(txAccounts(tx1), ShouldResemble, []proto.AccountAddress{sender, addr2})
+			So(txAccounts(pi.WrapTransaction(tx2)), ShouldResemble, []proto.AccountAddress{sender, addr3})
+
+			var events = newAccountChangeEvents(node)
+			So(events, ShouldHaveLength, 3)
+			for i, v := range events {
+				if i > 0 {
+					So(addressLess(events[i-1].Account, v.Account), ShouldBeTrue)
+				}
+				So(v.Block, ShouldResemble, newBlockRef(node))
+				switch v.Account {
+				case sender:
+					So(v.Txs, ShouldResemble, []hash.Hash{tx1.Hash(), tx2.Hash()})
+				case addr2:
+					So(v.Txs, ShouldResemble, []hash.Hash{tx1.Hash()})
+				case addr3:
+					So(v.Txs, ShouldResemble, []hash.Hash{tx2.Hash()})
+				}
+			}
+		})
+		Convey("The subscription should filter events by accounts", func() {
+			var s = newSubscription(&types.SubscribeReq{
+				Events:   []types.ChainEventType{types.ChainEventNewTx, types.ChainEventAccountChange},
+				Accounts: []proto.AccountAddress{addr2},
+			})
+			s.onNewTx(&NewTxEvent{Tx: tx1})
+			s.onNewTx(&NewTxEvent{Tx: tx2})
+			for _, v := range newAccountChangeEvents(node) {
+				s.onAccountChange(v)
+			}
+			So(s.backlog, ShouldHaveLength, 2)
+			var e = <-s.backlog
+			So(e.Type, ShouldEqual, types.ChainEventNewTx)
+			So(e.Tx, ShouldEqual, tx1)
+			e = <-s.backlog
+			So(e.Type, ShouldEqual, types.ChainEventAccountChange)
+			So(e.Account, ShouldEqual, addr2)
+			So(e.Txs, ShouldResemble, []hash.Hash{tx1.Hash()})
+
+			Convey("The events should be encodable", func() {
+				var (
+					out = &types.ChainEvent{
+						Type: types.ChainEventNewTx,
+						Tx:   tx1,
+					}
+					in = &types.ChainEvent{}
+				)
+				buf, err := utils.EncodeMsgPack(out)
+				So(err, ShouldBeNil)
+				So(utils.DecodeMsgPack(buf.Bytes(), in), ShouldBeNil)
+				So(in.Type, ShouldEqual, types.ChainEventNewTx)
+				So(in.Tx.Hash(), ShouldEqual, tx1.Hash())
+			})
+		})
+		Convey("The subscription should overflow if the subscriber falls behind", func() {
+			var s = newSubscription(&types.SubscribeReq{
+				Events: []types.ChainEventType{types.ChainEventNewBlock},
+			})
+			for i := 0; i <= conf.MaxSubscriptionBacklog; i++ {
+				s.onNewBlock(&NewBlockEvent{Ref: newBlockRef(node), Block: block})
+			}
+			So(s.backlog, ShouldHaveLength, conf.MaxSubscriptionBacklog)
+			_, ok := <-s.overflow
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
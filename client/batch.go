@@ -0,0 +1,197 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+const (
+	// MaxInsertParams defines the max bind parameters of a multi-row insert statement, which is
+	// the default SQLITE_MAX_VARIABLE_NUMBER of SQLite.
+	MaxInsertParams = 999
+
+	// batchQuery is the placeholder query to execute the batch in context.
+	batchQuery = "/* cql batch */"
+)
+
+var (
+	ctxBatchKey = "_cql_batch"
+)
+
+// Execer defines the ExecContext method of *sql.DB, *sql.Conn and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Batch defines write statements which are sent to the database in one request, the statements
+// are executed in one transaction by the peers.
+type Batch struct {
+	queries []types.Query
+}
+
+// NewBatch returns a new empty batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Add appends a statement to the batch, the arguments could be sql.NamedArg to bind the :name,
+// @name or $name parameters.
+func (b *Batch) Add(query string, args ...interface{}) (err error) {
+	var q = types.Query{Pattern: query}
+	if q.Args, err = convertArgs(args); err != nil {
+		return
+	}
+	b.queries = append(b.queries, q)
+	return
+}
+
+// AddInsert appends multi-row insert statements of the rows to the batch, the rows are split to
+// several statements if the bind parameters exceed MaxInsertParams.
+func (b *Batch) AddInsert(table string, columns []string, rows ...[]interface{}) (err error) {
+	if len(columns) == 0 || len(columns) > MaxInsertParams {
+		return errors.Wrapf(ErrInvalidInsert, "invalid column count %d", len(columns))
+	}
+	var size = MaxInsertParams / len(columns)
+	for len(rows) > 0 {
+		var chunk = rows
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		var (
+			query string
+			args  []interface{}
+		)
+		if query, args, err = BuildMultiInsert(table, columns, chunk...); err != nil {
+			return
+		}
+		if err = b.Add(query, args...); err != nil {
+			return
+		}
+		rows = rows[len(chunk):]
+	}
+	return
+}
+
+// Len returns the statement count of the batch.
+func (b *Batch) Len() int {
+	return len(b.queries)
+}
+
+// Reset removes all statements of the batch.
+func (b *Batch) Reset() {
+	b.queries = b.queries[:0]
+}
+
+// ExecBatch executes the statements of the batch in one round trip, the affected rows of the
+// result is the sum of all statements. If the execer is a *sql.Tx, the statements are appended
+// to the transaction.
+func ExecBatch(ctx context.Context, execer Execer, b *Batch) (result sql.Result, err error) {
+	if b == nil || b.Len() == 0 {
+		err = ErrEmptyBatch
+		return
+	}
+	return execer.ExecContext(context.WithValue(ctx, &ctxBatchKey, b), batchQuery)
+}
+
+// BuildMultiInsert builds a multi-row insert statement of the rows and returns the statement
+// with the flattened arguments.
+func BuildMultiInsert(table string, columns []string, rows ...[]interface{}) (
+	query string, args []interface{}, err error,
+) {
+	if table == "" || len(columns) == 0 || len(rows) == 0 {
+		err = errors.Wrap(ErrInvalidInsert, "empty table, columns or rows")
+		return
+	}
+	if len(columns)*len(rows) > MaxInsertParams {
+		err = errors.Wrapf(ErrInvalidInsert, "too many parameters %d", len(columns)*len(rows))
+		return
+	}
+
+	var (
+		sb          strings.Builder
+		placeholder = "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	)
+	args = make([]interface{}, 0, len(columns)*len(rows))
+
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(quoteIdentifier(table))
+	sb.WriteString(" (")
+	for i, c := range columns {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(quoteIdentifier(c))
+	}
+	sb.WriteString(") VALUES ")
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			err = errors.Wrapf(ErrInvalidInsert, "row %d has %d values, expected %d", i, len(row), len(columns))
+			return
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(placeholder)
+		args = append(args, row...)
+	}
+
+	query = sb.String()
+	return
+}
+
+// NamedArgs converts the parameters map to sql.NamedArg arguments sorted by name, which could be
+// used as the arguments of queries with :name, @name or $name parameters.
+func NamedArgs(params map[string]interface{}) (args []interface{}) {
+	var names = make([]string, 0, len(params))
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	args = make([]interface{}, len(names))
+	for i, k := range names {
+		args[i] = sql.Named(k, params[k])
+	}
+	return
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func convertArgs(args []interface{}) (nargs []types.NamedArg, err error) {
+	nargs = make([]types.NamedArg, len(args))
+	for i, v := range args {
+		if na, ok := v.(sql.NamedArg); ok {
+			nargs[i].Name = na.Name
+			v = na.Value
+		}
+		if nargs[i].Value, err = driver.DefaultParameterConverter.ConvertValue(v); err != nil {
+			err = errors.Wrapf(err, "convert argument %d failed", i)
+			return
+		}
+	}
+	return
+}
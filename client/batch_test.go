@@ -0,0 +1,135 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestBuildMultiInsert(t *testing.T) {
+	Convey("test multi-row insert builder", t, func() {
+		query, args, err := BuildMultiInsert("test", []string{"a", `b"c`}, []interface{}{1, "x"}, []interface{}{2, "y"})
+		So(err, ShouldBeNil)
+		So(query, ShouldEqual, `INSERT INTO "test" ("a", "b""c") VALUES (?, ?), (?, ?)`)
+		So(args, ShouldResemble, []interface{}{1, "x", 2, "y"})
+
+		_, _, err = BuildMultiInsert("test", []string{"a"})
+		So(errors.Cause(err), ShouldEqual, ErrInvalidInsert)
+		_, _, err = BuildMultiInsert("test", []string{"a", "b"}, []interface{}{1})
+		So(errors.Cause(err), ShouldEqual, ErrInvalidInsert)
+		_, _, err = BuildMultiInsert("test", []string{"a"}, make([][]interface{}, MaxInsertParams+1)...)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidInsert)
+
+		b := NewBatch()
+		rows := make([][]interface{}, 1000)
+		for i := range rows {
+			rows[i] = []interface{}{i, i}
+		}
+		err = b.AddInsert("test", []string{"a", "b"}, rows...)
+		So(err, ShouldBeNil)
+		So(b.Len(), ShouldEqual, 3)
+		So(b.queries[0].Args, ShouldHaveLength, 998)
+		So(b.queries[2].Args, ShouldHaveLength, 4)
+		err = b.AddInsert("test", nil, rows...)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidInsert)
+		b.Reset()
+		So(b.Len(), ShouldEqual, 0)
+
+		err = b.Add("insert into test values (:a)", NamedArgs(map[string]interface{}{"b": 2, "a": int32(1)})...)
+		So(err, ShouldBeNil)
+		So(b.queries[0].Args, ShouldResemble, []types.NamedArg{{Name: "a", Value: int64(1)}, {Name: "b", Value: int64(2)}})
+		err = b.Add("insert into test values (?)", struct{}{})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExecBatch(t *testing.T) {
+	Convey("test batch execution", t, func() {
+		var (
+			stopTestService func()
+			err             error
+			db              *sql.DB
+			result          sql.Result
+			count           int64
+			ctx             = context.Background()
+		)
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		db, err = sql.Open("covenantsql", "covenantsql://db")
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		_, err = db.Exec("create table test (a int, b text)")
+		So(err, ShouldBeNil)
+
+		_, err = ExecBatch(ctx, db, NewBatch())
+		So(err, ShouldEqual, ErrEmptyBatch)
+
+		b := NewBatch()
+		So(b.Add("insert into test values (?, ?)", 1, "a"), ShouldBeNil)
+		So(b.Add("insert into test values (:a, :b)", NamedArgs(map[string]interface{}{"a": 2, "b": "b"})...), ShouldBeNil)
+		So(b.AddInsert("test", []string{"a", "b"}, []interface{}{3, "c"}, []interface{}{4, "d"}), ShouldBeNil)
+		result, err = ExecBatch(ctx, db, b)
+		So(err, ShouldBeNil)
+		count, err = result.RowsAffected()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 4)
+		count, err = result.LastInsertId()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 4)
+
+		var value string
+		err = db.QueryRow("select b from test where a = :a", NamedArgs(map[string]interface{}{"a": 2})...).Scan(&value)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "b")
+
+		// batch in transaction
+		tx, err := db.Begin()
+		So(err, ShouldBeNil)
+		b.Reset()
+		So(b.Add("delete from test where a > ?", 2), ShouldBeNil)
+		_, err = ExecBatch(ctx, tx, b)
+		So(err, ShouldBeNil)
+		_, err = tx.Exec("insert into test values (?, ?)", 5, "e")
+		So(err, ShouldBeNil)
+		err = tx.Commit()
+		So(err, ShouldBeNil)
+
+		err = db.QueryRow("select count(1) from test").Scan(&count)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+
+		// failed statement aborts the whole batch
+		b.Reset()
+		So(b.Add("insert into test values (?, ?)", 6, "f"), ShouldBeNil)
+		So(b.Add("insert into not_exists values (?)", 7), ShouldBeNil)
+		_, err = ExecBatch(ctx, db, b)
+		So(err, ShouldNotBeNil)
+		err = db.QueryRow("select count(1) from test").Scan(&count)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+	})
+}
@@ -0,0 +1,425 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/worker"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+const (
+	// cdcPrefix is the name prefix of change capture tables and triggers in the local replica.
+	cdcPrefix = "__cql_cdc"
+)
+
+var (
+	// ChangePollInterval defines the interval to poll new blocks for change subscriptions.
+	ChangePollInterval = time.Second
+)
+
+// ChangeOp defines the row change operation.
+type ChangeOp int
+
+const (
+	// ChangeInsert represents an inserted row.
+	ChangeInsert ChangeOp = iota
+	// ChangeUpdate represents an updated row.
+	ChangeUpdate
+	// ChangeDelete represents a deleted row.
+	ChangeDelete
+)
+
+// String implements fmt.Stringer.
+func (o ChangeOp) String() string {
+	switch o {
+	case ChangeInsert:
+		return "Insert"
+	case ChangeUpdate:
+		return "Update"
+	case ChangeDelete:
+		return "Delete"
+	default:
+		return "Unknown"
+	}
+}
+
+// ChangeEvent defines a committed row change of a database table.
+type ChangeEvent struct {
+	Table string
+	Op    ChangeOp
+	// Before is the row image before change, it's nil for inserts.
+	Before map[string]interface{}
+	// After is the row image after change, it's nil for deletes.
+	After map[string]interface{}
+
+	// Count is the serial number of the block since genesis, which packs the change.
+	Count     int32
+	BlockHash hash.Hash
+	Timestamp time.Time
+}
+
+// changeCapture replays the database blocks on a local replica and captures the row changes of
+// the tables by triggers.
+type changeCapture struct {
+	dbID   proto.DatabaseID
+	dir    string
+	strg   *xs.SQLite3
+	st     *x.State
+	tables []string
+	count  int32 // count of next block
+	seqSet bool  // whether the state sequence is synced with blocks
+	last   int64 // last captured change sequence
+}
+
+// Subscribe subscribes the committed row changes of the tables in the database specified by dsn,
+// all tables are subscribed if no table is specified. The database state is restored from the
+// leader miner first and the following blocks are replayed locally, the row changes are decoded
+// and sent to the returned channel in the order of blocks. The channel is closed if ctx is
+// canceled or the subscription is broken. Columns added after subscribing are not captured.
+func Subscribe(ctx context.Context, dsn string, tables ...string) (events <-chan *ChangeEvent, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var cfg *Config
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	var c = &changeCapture{dbID: proto.DatabaseID(cfg.DatabaseID)}
+	if c.dir, err = ioutil.TempDir("", "cql-cdc-"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.close()
+		}
+	}()
+
+	var (
+		dataFile = filepath.Join(c.dir, "cdc.db3")
+		point    *types.RecoveryPoint
+		nodeID   proto.NodeID
+	)
+	if point, err = Restore(ctx, dsn, -1, time.Time{}, dataFile); err != nil {
+		return
+	}
+	c.count = point.Count + 1
+	if c.strg, err = xs.NewSqlite(dataFile); err != nil {
+		return
+	}
+	if err = c.install(tables); err != nil {
+		return
+	}
+	if nodeID, err = kms.GetLocalNodeID(); err != nil {
+		return
+	}
+	c.st = x.NewState(sql.LevelReadUncommitted, nodeID, c.strg)
+
+	var ch = make(chan *ChangeEvent)
+	go func() {
+		defer close(ch)
+		defer c.close()
+		if err := c.run(ctx, ch); err != nil {
+			log.WithField("db", c.dbID).WithError(err).Debug("change subscription closed")
+		}
+	}()
+	events = ch
+	return
+}
+
+// install creates the change capture tables and triggers.
+func (c *changeCapture) install(tables []string) (err error) {
+	var db = c.strg.Writer()
+	if len(tables) == 0 {
+		if tables, err = listTables(db); err != nil {
+			return
+		}
+	}
+	if _, err = db.Exec(`CREATE TABLE ` + quoteIdentifier(cdcPrefix) +
+		` ("seq" INTEGER PRIMARY KEY AUTOINCREMENT, "tbl" TEXT, "op" INTEGER)`); err != nil {
+		return
+	}
+	for _, t := range tables {
+		var columns []string
+		if columns, err = tableColumns(db, t); err != nil {
+			return
+		}
+		if len(columns) == 0 {
+			return errors.Errorf("no such table: %s", t)
+		}
+		for _, q := range changeCaptureDDL(t, columns) {
+			if _, err = db.Exec(q); err != nil {
+				err = errors.Wrapf(err, "install change capture on table %s failed", t)
+				return
+			}
+		}
+	}
+	c.tables = tables
+	return
+}
+
+// changeCaptureDDL returns the statements to create the image table and triggers of table.
+func changeCaptureDDL(table string, columns []string) []string {
+	var (
+		image   = quoteIdentifier(cdcPrefix + "_" + table)
+		trigger = func(op string) string { return quoteIdentifier(cdcPrefix + "_" + table + "_" + op) }
+		seq     = `(SELECT max("seq") FROM ` + quoteIdentifier(cdcPrefix) + `)`
+		values  = func(row string) string {
+			var vs = make([]string, len(columns))
+			for i, c := range columns {
+				vs[i] = row + "." + quoteIdentifier(c)
+			}
+			return strings.Join(vs, ", ")
+		}
+		logChange = func(op ChangeOp) string {
+			return `INSERT INTO ` + quoteIdentifier(cdcPrefix) + ` ("tbl", "op") VALUES (` +
+				sqlLiteral(table) + `, ` + sqlLiteral(int64(op)) + `); `
+		}
+		logImage = func(after int, row string) string {
+			return `INSERT INTO ` + image + ` VALUES (` + seq + `, ` + sqlLiteral(int64(after)) + `, ` +
+				values(row) + `); `
+		}
+		on = ` ON ` + quoteIdentifier(table) + ` BEGIN `
+	)
+	return []string{
+		`CREATE TABLE ` + image + ` AS SELECT 0 AS "__cql_seq", 0 AS "__cql_after", * FROM ` +
+			quoteIdentifier(table) + ` WHERE 0`,
+		`CREATE TRIGGER ` + trigger("insert") + ` AFTER INSERT` + on +
+			logChange(ChangeInsert) + logImage(1, "NEW") + `END`,
+		`CREATE TRIGGER ` + trigger("update") + ` AFTER UPDATE` + on +
+			logChange(ChangeUpdate) + logImage(0, "OLD") + logImage(1, "NEW") + `END`,
+		`CREATE TRIGGER ` + trigger("delete") + ` AFTER DELETE` + on +
+			logChange(ChangeDelete) + logImage(0, "OLD") + `END`,
+	}
+}
+
+func listTables(db *sql.DB) (tables []string, err error) {
+	rows, err := db.Query(`SELECT "name" FROM "sqlite_master" WHERE "type" = 'table' ` +
+		`AND "name" NOT LIKE 'sqlite\_%' ESCAPE '\' AND "name" NOT LIKE '\_\_cql\_%' ESCAPE '\' ORDER BY "name"`)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var t string
+		if err = rows.Scan(&t); err != nil {
+			return
+		}
+		tables = append(tables, t)
+	}
+	err = rows.Err()
+	return
+}
+
+func tableColumns(db *sql.DB, table string) (columns []string, err error) {
+	rows, err := db.Query(`SELECT * FROM ` + quoteIdentifier(table) + ` WHERE 0`)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	return rows.Columns()
+}
+
+// run fetches and replays the following blocks, and sends the captured changes to ch.
+func (c *changeCapture) run(ctx context.Context, ch chan<- *ChangeEvent) (err error) {
+	for {
+		var block *types.Block
+		if block, err = c.fetchBlock(ctx); err != nil {
+			return
+		}
+		if block == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(ChangePollInterval):
+			}
+			continue
+		}
+
+		var changes []*ChangeEvent
+		if changes, err = c.apply(ctx, block); err != nil {
+			return errors.Wrapf(err, "apply block %d failed", c.count)
+		}
+		for _, e := range changes {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		c.count++
+	}
+}
+
+func (c *changeCapture) fetchBlock(ctx context.Context) (block *types.Block, err error) {
+	var (
+		privKey *asymmetric.PrivateKey
+		peers   *proto.Peers
+		req     = &worker.ObserverFetchBlockReq{DatabaseID: c.dbID, Count: c.count}
+		resp    = &worker.ObserverFetchBlockResp{}
+	)
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if peers, err = cacheGetPeers(c.dbID, privKey); err != nil {
+		return
+	}
+	if err = rpc.NewCaller().CallNodeWithContext(
+		ctx, peers.Leader, route.DBSObserverFetchBlock.String(), req, resp,
+	); err != nil {
+		return
+	}
+	block = resp.Block
+	return
+}
+
+// apply replays the block on the local replica and returns the captured changes.
+func (c *changeCapture) apply(ctx context.Context, block *types.Block) (changes []*ChangeEvent, err error) {
+	if !c.seqSet {
+		// sync the state sequence with the log offset of the first write query after restore point
+		for _, q := range block.QueryTxs {
+			if q.Request.Header.QueryType == types.WriteQuery {
+				c.st.SetSeq(q.Response.LogOffset)
+				c.seqSet = true
+				break
+			}
+		}
+	}
+	if err = c.st.ReplayBlockWithContext(ctx, block); err != nil {
+		return
+	}
+
+	var (
+		db   = c.strg.Reader()
+		from = c.last
+		seqs = make(map[int64]*ChangeEvent)
+		rows *sql.Rows
+	)
+	if rows, err = db.QueryContext(ctx, `SELECT "seq", "tbl", "op" FROM `+quoteIdentifier(cdcPrefix)+
+		` WHERE "seq" > ? ORDER BY "seq"`, c.last); err != nil {
+		return
+	}
+	for rows.Next() {
+		var (
+			seq int64
+			e   = &ChangeEvent{
+				Count:     c.count,
+				BlockHash: *block.BlockHash(),
+				Timestamp: block.Timestamp(),
+			}
+		)
+		if err = rows.Scan(&seq, &e.Table, &e.Op); err != nil {
+			_ = rows.Close()
+			return
+		}
+		seqs[seq] = e
+		changes = append(changes, e)
+		c.last = seq
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	// fill row images
+	for _, t := range c.tables {
+		if err = c.readImages(ctx, db, t, from, seqs); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readImages reads the row images of table captured after seq.
+func (c *changeCapture) readImages(
+	ctx context.Context, db *sql.DB, table string, seq int64, seqs map[int64]*ChangeEvent) (err error,
+) {
+	rows, err := db.QueryContext(ctx, `SELECT * FROM `+quoteIdentifier(cdcPrefix+"_"+table)+
+		` WHERE "__cql_seq" > ?`, seq)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+	var (
+		values = make([]interface{}, len(columns))
+		dest   = make([]interface{}, len(columns))
+	)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		var (
+			seq, _   = values[0].(int64)
+			after, _ = values[1].(int64)
+			image    = make(map[string]interface{}, len(columns)-2)
+		)
+		for i, col := range columns[2:] {
+			image[col] = values[i+2]
+		}
+		e, ok := seqs[seq]
+		if !ok {
+			continue
+		}
+		if after == 1 {
+			e.After = image
+		} else {
+			e.Before = image
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+func (c *changeCapture) close() {
+	if c.st != nil {
+		_ = c.st.Close(false)
+	} else if c.strg != nil {
+		_ = c.strg.Close()
+	}
+	_ = os.RemoveAll(c.dir)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+)
+
+func TestChangeCaptureDDL(t *testing.T) {
+	Convey("test change capture on local database", t, func() {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cdc.db3"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Exec(`CREATE TABLE "my ""test""" ("id" INTEGER PRIMARY KEY, "v" TEXT)`)
+		So(err, ShouldBeNil)
+		tables, err := listTables(db)
+		So(err, ShouldBeNil)
+		So(tables, ShouldResemble, []string{`my "test"`})
+		columns, err := tableColumns(db, tables[0])
+		So(err, ShouldBeNil)
+		So(columns, ShouldResemble, []string{"id", "v"})
+
+		_, err = db.Exec(`CREATE TABLE "__cql_cdc" ("seq" INTEGER PRIMARY KEY AUTOINCREMENT, "tbl" TEXT, "op" INTEGER)`)
+		So(err, ShouldBeNil)
+		for _, q := range changeCaptureDDL(tables[0], columns) {
+			_, err = db.Exec(q)
+			So(err, ShouldBeNil)
+		}
+		tables, err = listTables(db)
+		So(err, ShouldBeNil)
+		So(tables, ShouldResemble, []string{`my "test"`})
+
+		for _, q := range []string{
+			`INSERT INTO "my ""test""" VALUES (1, 'a')`,
+			`UPDATE "my ""test""" SET "v" = 'b'`,
+			`DELETE FROM "my ""test"""`,
+		} {
+			_, err = db.Exec(q)
+			So(err, ShouldBeNil)
+		}
+		var count int
+		err = db.QueryRow(`SELECT count(1) FROM "__cql_cdc"`).Scan(&count)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+		err = db.QueryRow(`SELECT count(1) FROM "__cql_cdc_my ""test""" WHERE "__cql_seq" = 2`).Scan(&count)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+		So(ChangeUpdate.String(), ShouldEqual, "Update")
+		So(ChangeOp(-1).String(), ShouldEqual, "Unknown")
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	Convey("test subscribe row changes", t, func() {
+		stopTestService, _, err := startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		interval := ChangePollInterval
+		ChangePollInterval = 100 * time.Millisecond
+		defer func() { ChangePollInterval = interval }()
+
+		db, err := sql.Open("covenantsql", "covenantsql://db")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Exec("create table test (id int primary key, name text)")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("create table other (v int)")
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_, err = Subscribe(ctx, "db", "not_exists")
+		So(err, ShouldNotBeNil)
+		// wait for the table creation to be packed in block
+		var events <-chan *ChangeEvent
+		for i := 0; i < 100; i++ {
+			if events, err = Subscribe(ctx, "db", "test"); err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		So(err, ShouldBeNil)
+
+		_, err = db.Exec("insert into test values (1, 'a')")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("insert into test values (2, 'b')")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("insert into other values (1)")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("update test set name = 'c' where id = 1")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("delete from test where id = 2")
+		So(err, ShouldBeNil)
+
+		var received []*ChangeEvent
+		for len(received) < 4 {
+			e, ok := <-events
+			So(ok, ShouldBeTrue)
+			received = append(received, e)
+		}
+		So(received[0].After, ShouldResemble, map[string]interface{}{"id": int64(1), "name": "a"})
+		So(received[0].BlockHash, ShouldNotResemble, hash.Hash{})
+		received = received[1:]
+		So(received[0].Table, ShouldEqual, "test")
+		So(received[0].Op, ShouldEqual, ChangeInsert)
+		So(received[0].Before, ShouldBeNil)
+		So(received[0].After, ShouldResemble, map[string]interface{}{"id": int64(2), "name": "b"})
+		So(received[1].Op, ShouldEqual, ChangeUpdate)
+		So(received[1].Before, ShouldResemble, map[string]interface{}{"id": int64(1), "name": "a"})
+		So(received[1].After, ShouldResemble, map[string]interface{}{"id": int64(1), "name": "c"})
+		So(received[2].Op, ShouldEqual, ChangeDelete)
+		So(received[2].Before, ShouldResemble, map[string]interface{}{"id": int64(2), "name": "b"})
+		So(received[2].After, ShouldBeNil)
+
+		cancel()
+		for range events {
+		}
+	})
+}
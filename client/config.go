@@ -20,6 +20,11 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
 )
 
 const (
@@ -27,8 +32,36 @@ const (
 	paramUseFollower  = "use_follower"
 	paramUseDirectRPC = "use_direct_rpc"
 	paramMirror       = "mirror"
+
+	paramMaxStaleBlocks = "max_stale_blocks"
+	paramMaxStaleMillis = "max_stale_ms"
+
+	paramConsistency  = "consistency"
+	paramTimeout      = "timeout"
+	paramLeader       = "lead"
+	paramRetry        = "retry"
+	paramRetryBackoff = "retry_backoff"
+	paramConfigFile   = "config"
+	paramPrivateKey   = "private_key"
+	paramCompress     = "compress"
+	paramStream       = "stream"
 )
 
+// Consistency levels accepted by the consistency option.
+const (
+	// ConsistencyStrong sends all queries to the leader node.
+	ConsistencyStrong = "strong"
+	// ConsistencyEventual sends read queries to a follower node, and falls back to the leader
+	// if the follower read is rejected.
+	ConsistencyEventual = "eventual"
+	// ConsistencyLinearizable sends read queries to a follower node like eventual, and the read
+	// waits for all the writes acknowledged before it to be applied on the serving node.
+	ConsistencyLinearizable = "linearizable"
+)
+
+// DefaultRetryBackoff is the default wait time between retries of a read query.
+const DefaultRetryBackoff = 100 * time.Millisecond
+
 // Config is a configuration parsed from a DSN string.
 type Config struct {
 	DatabaseID string
@@ -48,6 +81,46 @@ type Config struct {
 
 	// Mirror option forces client to query from mirror server
 	Mirror string
+
+	// MaxStaleBlocks bounds the staleness of follower reads by the replication log index lag
+	// behind the last commit of leader, 0 means not bounded
+	MaxStaleBlocks int32
+
+	// MaxStaleMillis bounds the staleness of follower reads by the milliseconds since the
+	// follower state is known to catch up with the leader, 0 means not bounded
+	MaxStaleMillis int64
+
+	// Linearizable requires read queries to reflect all the writes acknowledged before them
+	Linearizable bool
+
+	// Timeout bounds the execution time of each query, 0 means not bounded
+	Timeout time.Duration
+
+	// Leader is the preferred leader node, which replaces the leader reported by block
+	// producer if it is one of the database peers
+	Leader proto.NodeID
+
+	// RetryCount is the max retry times of a read query failed by transient errors, writes are
+	// never retried
+	RetryCount int
+
+	// RetryBackoff is the wait time between retries, DefaultRetryBackoff is used if not set
+	RetryBackoff time.Duration
+
+	// ConfigFile is the config file used to initialize the driver if it's not initialized yet,
+	// DefaultConfigFile is used if not set
+	ConfigFile string
+
+	// PrivateKeyFile overrides the private key file in config during driver initialization
+	PrivateKeyFile string
+
+	// Compress overrides the RPC payload compression algorithm during driver initialization,
+	// valid values are none, snappy and zstd
+	Compress string
+
+	// StreamQuery reads the rows of read queries incrementally over a dedicated stream instead
+	// of receiving the whole result set in one response
+	StreamQuery bool
 }
 
 // NewConfig creates a new config with default value.
@@ -71,6 +144,15 @@ func (cfg *Config) FormatDSN() string {
 		if cfg.UseLeader {
 			newQuery.Add(paramUseLeader, strconv.FormatBool(cfg.UseLeader))
 		}
+		if cfg.MaxStaleBlocks > 0 {
+			newQuery.Add(paramMaxStaleBlocks, strconv.FormatInt(int64(cfg.MaxStaleBlocks), 10))
+		}
+		if cfg.MaxStaleMillis > 0 {
+			newQuery.Add(paramMaxStaleMillis, strconv.FormatInt(cfg.MaxStaleMillis, 10))
+		}
+	}
+	if cfg.Linearizable {
+		newQuery.Add(paramConsistency, ConsistencyLinearizable)
 	}
 	if cfg.Mirror != "" {
 		newQuery.Add(paramMirror, cfg.Mirror)
@@ -78,6 +160,30 @@ func (cfg *Config) FormatDSN() string {
 	if cfg.UseDirectRPC {
 		newQuery.Add(paramUseDirectRPC, strconv.FormatBool(cfg.UseDirectRPC))
 	}
+	if cfg.Timeout > 0 {
+		newQuery.Add(paramTimeout, cfg.Timeout.String())
+	}
+	if cfg.Leader != "" {
+		newQuery.Add(paramLeader, string(cfg.Leader))
+	}
+	if cfg.RetryCount > 0 {
+		newQuery.Add(paramRetry, strconv.Itoa(cfg.RetryCount))
+		if cfg.RetryBackoff > 0 {
+			newQuery.Add(paramRetryBackoff, cfg.RetryBackoff.String())
+		}
+	}
+	if cfg.ConfigFile != "" {
+		newQuery.Add(paramConfigFile, cfg.ConfigFile)
+	}
+	if cfg.PrivateKeyFile != "" {
+		newQuery.Add(paramPrivateKey, cfg.PrivateKeyFile)
+	}
+	if cfg.Compress != "" {
+		newQuery.Add(paramCompress, cfg.Compress)
+	}
+	if cfg.StreamQuery {
+		newQuery.Add(paramStream, strconv.FormatBool(cfg.StreamQuery))
+	}
 	u.RawQuery = newQuery.Encode()
 
 	return u.String()
@@ -101,11 +207,62 @@ func ParseDSN(dsn string) (cfg *Config, err error) {
 	// option: use_leader, use_follower
 	cfg.UseLeader, _ = strconv.ParseBool(q.Get(paramUseLeader))
 	cfg.UseFollower, _ = strconv.ParseBool(q.Get(paramUseFollower))
+	// option: consistency, overrides use_leader and use_follower
+	switch v := strings.ToLower(q.Get(paramConsistency)); v {
+	case "":
+	case ConsistencyStrong:
+		cfg.UseLeader, cfg.UseFollower = true, false
+	case ConsistencyEventual:
+		cfg.UseLeader, cfg.UseFollower = true, true
+	case ConsistencyLinearizable:
+		cfg.UseLeader, cfg.UseFollower, cfg.Linearizable = true, true, true
+	default:
+		return nil, errors.Errorf("invalid consistency level: %s", v)
+	}
 	if !cfg.UseLeader && !cfg.UseFollower {
 		cfg.UseLeader = true
 	}
+	if v := q.Get(paramMaxStaleBlocks); v != "" {
+		var blocks int64
+		if blocks, err = strconv.ParseInt(v, 10, 32); err != nil {
+			return nil, err
+		}
+		cfg.MaxStaleBlocks = int32(blocks)
+	}
+	if v := q.Get(paramMaxStaleMillis); v != "" {
+		if cfg.MaxStaleMillis, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
 	cfg.Mirror = q.Get(paramMirror)
 	cfg.UseDirectRPC, _ = strconv.ParseBool(q.Get(paramUseDirectRPC))
+	if v := q.Get(paramTimeout); v != "" {
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil {
+			return nil, errors.Wrap(err, "invalid timeout")
+		}
+	}
+	cfg.Leader = proto.NodeID(q.Get(paramLeader))
+	if v := q.Get(paramRetry); v != "" {
+		if cfg.RetryCount, err = strconv.Atoi(v); err != nil {
+			return nil, errors.Wrap(err, "invalid retry count")
+		}
+	}
+	if v := q.Get(paramRetryBackoff); v != "" {
+		if cfg.RetryBackoff, err = time.ParseDuration(v); err != nil {
+			return nil, errors.Wrap(err, "invalid retry backoff")
+		}
+	}
+	if cfg.Timeout < 0 || cfg.RetryCount < 0 || cfg.RetryBackoff < 0 {
+		return nil, errors.New("timeout and retry options should not be negative")
+	}
+	cfg.ConfigFile = q.Get(paramConfigFile)
+	cfg.PrivateKeyFile = q.Get(paramPrivateKey)
+	switch cfg.Compress = strings.ToLower(q.Get(paramCompress)); cfg.Compress {
+	case "", "none", "snappy", "zstd":
+	default:
+		return nil, errors.Errorf("invalid compression algorithm: %s", cfg.Compress)
+	}
+	cfg.StreamQuery, _ = strconv.ParseBool(q.Get(paramStream))
 
 	return cfg, nil
 }
@@ -18,6 +18,7 @@ package client
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -90,6 +91,89 @@ func TestConfig(t *testing.T) {
 			UseLeader:   true,
 			UseFollower: true,
 		})
+		testFormatAndParse(&Config{
+			UseLeader:      true,
+			UseFollower:    true,
+			MaxStaleBlocks: 2,
+			MaxStaleMillis: 500,
+		})
+		testFormatAndParse(&Config{
+			DatabaseID:     "db",
+			UseLeader:      true,
+			Timeout:        5 * time.Second,
+			Leader:         "node1",
+			RetryCount:     3,
+			RetryBackoff:   time.Second,
+			ConfigFile:     "~/.cql/config.yaml",
+			PrivateKeyFile: "~/.cql/private.key",
+			Compress:       "zstd",
+		})
+		testFormatAndParse(&Config{
+			DatabaseID:  "db",
+			UseLeader:   true,
+			StreamQuery: true,
+		})
+	})
+
+	Convey("test dsn with full option set", t, func() {
+		cfg, err := ParseDSN("covenantsql://db?consistency=strong&timeout=5s&lead=node1&compress=zstd" +
+			"&retry=2&retry_backoff=50ms&config=/tmp/config.yaml&private_key=/tmp/private.key")
+		So(err, ShouldBeNil)
+		So(cfg, ShouldResemble, &Config{
+			DatabaseID:     "db",
+			UseLeader:      true,
+			Timeout:        5 * time.Second,
+			Leader:         "node1",
+			RetryCount:     2,
+			RetryBackoff:   50 * time.Millisecond,
+			ConfigFile:     "/tmp/config.yaml",
+			PrivateKeyFile: "/tmp/private.key",
+			Compress:       "zstd",
+		})
+
+		cfg, err = ParseDSN("covenantsql://db?consistency=eventual&use_leader=false")
+		So(err, ShouldBeNil)
+		So(cfg.UseLeader, ShouldBeTrue)
+		So(cfg.UseFollower, ShouldBeTrue)
+		cfg, err = ParseDSN("covenantsql://db?consistency=STRONG&use_follower=true")
+		So(err, ShouldBeNil)
+		So(cfg.UseLeader, ShouldBeTrue)
+		So(cfg.UseFollower, ShouldBeFalse)
+		So(cfg.Linearizable, ShouldBeFalse)
+		cfg, err = ParseDSN("covenantsql://db?consistency=linearizable")
+		So(err, ShouldBeNil)
+		So(cfg.UseLeader, ShouldBeTrue)
+		So(cfg.UseFollower, ShouldBeTrue)
+		So(cfg.Linearizable, ShouldBeTrue)
+		cfg2, err := ParseDSN(cfg.FormatDSN())
+		So(err, ShouldBeNil)
+		So(cfg2, ShouldResemble, cfg)
+
+		for _, dsn := range []string{
+			"covenantsql://db?consistency=serializable",
+			"covenantsql://db?timeout=5",
+			"covenantsql://db?timeout=-1s",
+			"covenantsql://db?retry=many",
+			"covenantsql://db?retry=-1",
+			"covenantsql://db?retry=1&retry_backoff=soon",
+			"covenantsql://db?compress=lz4",
+		} {
+			cfg, err = ParseDSN(dsn)
+			So(err, ShouldNotBeNil)
+			So(cfg, ShouldBeNil)
+		}
+	})
+
+	Convey("test dsn with staleness bound options", t, func() {
+		cfg, err := ParseDSN("covenantsql://db?use_follower=true&max_stale_blocks=3&max_stale_ms=1000")
+		So(err, ShouldBeNil)
+		So(cfg.MaxStaleBlocks, ShouldEqual, 3)
+		So(cfg.MaxStaleMillis, ShouldEqual, 1000)
+
+		_, err = ParseDSN("covenantsql://db?use_follower=true&max_stale_blocks=invalid")
+		So(err, ShouldNotBeNil)
+		_, err = ParseDSN("covenantsql://db?use_follower=true&max_stale_ms=invalid")
+		So(err, ShouldNotBeNil)
 	})
 
 	Convey("test format and parse dsn with mirror option", t, func() {
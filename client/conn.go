@@ -20,6 +20,7 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -28,13 +29,16 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/rpc/mux"
 	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	"github.com/CovenantSQL/CovenantSQL/utils/trace"
+	"github.com/CovenantSQL/CovenantSQL/worker"
 )
 
 // conn implements an interface sql.Conn.
@@ -46,14 +50,43 @@ type conn struct {
 	privKey     *asymmetric.PrivateKey
 
 	inTransaction bool
+	savepoints    []savepoint
 	closed        int32
+	mirror        bool
 
 	leader   *pconn
 	follower *pconn
+
+	// leader failover states
+	leaderID        proto.NodeID
+	preferredLeader proto.NodeID
+	useDirectRPC    bool
+
+	// staleness bounds of follower reads
+	maxStaleBlocks int32
+	maxStaleMillis int64
+	// reads wait for all the writes acknowledged before them
+	linearizable bool
+
+	// per-query timeout and read retry policy
+	timeout     time.Duration
+	retryPolicy rpc.RetryPolicy
+	// read query rows over streaming RPC
+	streamQuery bool
+
+	// replicas chosen by read preference and their round-trip time
+	replicas map[proto.NodeID]*pconn
+	rtt      map[proto.NodeID]time.Duration
+}
+
+// contextCaller is implemented by callers which support aborting a pending call by context.
+type contextCaller interface {
+	CallWithContext(ctx context.Context, method string, request interface{}, reply interface{}) error
 }
 
 // pconn represents a connection to a peer.
 type pconn struct {
+	node    proto.NodeID
 	wg      *sync.WaitGroup
 	parent  *conn
 	ackCh   chan *types.Ack
@@ -62,6 +95,20 @@ type pconn struct {
 
 const workerCount int = 2
 
+// FailoverPolicy defines the backoff policy of re-routing queries after leader failure, the
+// leader is refreshed from block producer before each retry.
+var FailoverPolicy = rpc.RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// QueryRetryPolicy defines the retry policy of the idempotent requests other than database
+// queries, e.g. the peers queries to block producers and the proof queries to leader.
+var QueryRetryPolicy = rpc.DefaultRetryPolicy
+
 func newConn(cfg *Config) (c *conn, err error) {
 	// get local node id
 	var localNodeID proto.NodeID
@@ -80,15 +127,27 @@ func newConn(cfg *Config) (c *conn, err error) {
 		localNodeID: localNodeID,
 		privKey:     privKey,
 		queries:     make([]types.Query, 0),
-	}
 
+		maxStaleBlocks: cfg.MaxStaleBlocks,
+		maxStaleMillis: cfg.MaxStaleMillis,
+		linearizable:   cfg.Linearizable,
+
+		timeout:     cfg.Timeout,
+		streamQuery: cfg.StreamQuery,
+		retryPolicy: readRetryPolicy(cfg),
+
+		preferredLeader: cfg.Leader,
+		useDirectRPC:    cfg.UseDirectRPC,
+	}
 	// get peers from BP
 	var peers *proto.Peers
 	if peers, err = cacheGetPeers(c.dbID, c.privKey); err != nil {
 		return nil, errors.WithMessage(err, "cacheGetPeers failed")
 	}
+	leader := preferredLeader(peers, c.preferredLeader)
 
 	if cfg.Mirror != "" {
+		c.mirror = true
 		c.leader = &pconn{
 			wg:      &sync.WaitGroup{},
 			parent:  c,
@@ -98,37 +157,16 @@ func newConn(cfg *Config) (c *conn, err error) {
 		// no ack workers required, mirror mode does not support ack worker
 	} else {
 		if cfg.UseLeader {
-			var caller rpc.PCaller
-			if cfg.UseDirectRPC {
-				caller = rpc.NewPersistentCaller(peers.Leader)
-			} else {
-				caller = mux.NewPersistentCaller(peers.Leader)
-			}
-			c.leader = &pconn{
-				wg:      &sync.WaitGroup{},
-				ackCh:   make(chan *types.Ack, workerCount*4),
-				parent:  c,
-				pCaller: caller,
-			}
+			c.leader = c.newPeerConn(leader)
+			c.leaderID = leader
 		}
 
 		// choose a random follower node
 		if cfg.UseFollower && len(peers.Servers) > 1 {
 			for {
 				node := peers.Servers[randSource.Intn(len(peers.Servers))]
-				if node != peers.Leader {
-					var caller rpc.PCaller
-					if cfg.UseDirectRPC {
-						caller = rpc.NewPersistentCaller(node)
-					} else {
-						caller = mux.NewPersistentCaller(node)
-					}
-					c.follower = &pconn{
-						wg:      &sync.WaitGroup{},
-						ackCh:   make(chan *types.Ack, workerCount*4),
-						parent:  c,
-						pCaller: caller,
-					}
+				if node != leader {
+					c.follower = c.newPeerConn(node)
 					break
 				}
 			}
@@ -154,6 +192,97 @@ func newConn(cfg *Config) (c *conn, err error) {
 	return
 }
 
+// newPeerConn creates a peer connection to node with ack workers not started.
+func (c *conn) newPeerConn(node proto.NodeID) *pconn {
+	var caller rpc.PCaller
+	if c.useDirectRPC {
+		caller = rpc.NewPersistentCaller(node)
+	} else {
+		caller = mux.NewPersistentCaller(node)
+	}
+	return &pconn{
+		node:    node,
+		wg:      &sync.WaitGroup{},
+		ackCh:   make(chan *types.Ack, workerCount*4),
+		parent:  c,
+		pCaller: caller,
+	}
+}
+
+// switchLeader routes the leader queries of connection to node, the previous leader connection
+// is closed asynchronously after its pending acks are sent.
+func (c *conn) switchLeader(node proto.NodeID) (err error) {
+	nl := c.newPeerConn(node)
+	if err = nl.startAckWorkers(); err != nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"db":   c.dbID,
+		"from": c.leaderID,
+		"to":   node,
+	}).Info("switch database leader")
+	ol := c.leader
+	c.leader, c.leaderID = nl, node
+	go ol.close()
+	return
+}
+
+// syncLeader follows the leader change found by the peers updater.
+func (c *conn) syncLeader() {
+	if c.mirror || c.leader == nil {
+		return
+	}
+	rawPeers, ok := peerList.Load(c.dbID)
+	if !ok {
+		return
+	}
+	peers, ok := rawPeers.(*proto.Peers)
+	if !ok {
+		return
+	}
+	if leader := preferredLeader(peers, c.preferredLeader); leader != c.leaderID {
+		if err := c.switchLeader(leader); err != nil {
+			log.WithField("db", c.dbID).WithError(err).Warning("switch database leader failed")
+		}
+	}
+}
+
+// refreshLeader queries the current leader from block producer and switches to it if the leader
+// is changed.
+func (c *conn) refreshLeader() (changed bool, err error) {
+	var peers *proto.Peers
+	if peers, err = getPeers(c.dbID, c.privKey); err != nil {
+		return
+	}
+	leader := preferredLeader(peers, c.preferredLeader)
+	if leader == c.leaderID {
+		return
+	}
+	if err = c.switchLeader(leader); err != nil {
+		return
+	}
+	changed = true
+	return
+}
+
+// preferredLeader returns the preferred node if it's one of the peers, otherwise the leader of
+// peers is returned.
+func preferredLeader(peers *proto.Peers, preferred proto.NodeID) proto.NodeID {
+	if preferred == "" || preferred == peers.Leader {
+		return peers.Leader
+	}
+	for _, s := range peers.Servers {
+		if s == preferred {
+			return preferred
+		}
+	}
+	log.WithFields(log.Fields{
+		"preferred": preferred,
+		"leader":    peers.Leader,
+	}).Warning("preferred leader is not a database peer, use current leader")
+	return peers.Leader
+}
+
 func (c *pconn) startAckWorkers() (err error) {
 	for i := 0; i < workerCount; i++ {
 		c.wg.Add(1)
@@ -232,6 +361,9 @@ func (c *conn) Close() error {
 	if c.follower != nil {
 		c.follower.close()
 	}
+	for _, pc := range c.replicas {
+		pc.close()
+	}
 	return nil
 }
 
@@ -256,6 +388,7 @@ func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, e
 	// TODO(xq262144): make use of the ctx argument
 	c.inTransaction = true
 	c.queries = c.queries[:0]
+	c.savepoints = c.savepoints[:0]
 
 	return c, nil
 }
@@ -269,7 +402,9 @@ func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, e
 	log.WithField("query", query).Debug("prepared statement")
 
 	// prepare the statement
-	return newStmt(c, query), nil
+	s := newStmt(c, query)
+	s.prepare()
+	return s, nil
 }
 
 // ExecContext implements the driver.ExecerContext.ExecContext method.
@@ -281,11 +416,15 @@ func (c *conn) ExecContext(ctx context.Context, query string, args []driver.Name
 		return
 	}
 
-	// TODO(xq262144): make use of the ctx argument
-	sq := convertQuery(query, args)
-
 	var affectedRows, lastInsertID int64
-	if affectedRows, lastInsertID, _, err = c.addQuery(ctx, types.WriteQuery, sq); err != nil {
+	if b, ok := ctx.Value(&ctxBatchKey).(*Batch); ok && query == batchQuery {
+		// execute statements of batch in one request
+		affectedRows, lastInsertID, err = c.addBatch(ctx, b)
+	} else {
+		// TODO(xq262144): make use of the ctx argument
+		affectedRows, lastInsertID, _, err = c.addQuery(ctx, types.WriteQuery, convertQuery(query, args))
+	}
+	if err != nil {
 		return
 	}
 
@@ -324,6 +463,7 @@ func (c *conn) Commit() (err error) {
 
 	defer func() {
 		c.queries = c.queries[:0]
+		c.savepoints = c.savepoints[:0]
 		c.inTransaction = false
 	}()
 
@@ -349,6 +489,7 @@ func (c *conn) Rollback() error {
 
 	defer func() {
 		c.queries = c.queries[:0]
+		c.savepoints = c.savepoints[:0]
 		c.inTransaction = false
 	}()
 
@@ -368,6 +509,12 @@ func (c *conn) addQuery(ctx context.Context, queryType types.QueryType, query *t
 			return
 		}
 
+		// savepoints are maintained on the buffered queries
+		if op, name, ok := parseSavepoint(query.Pattern); ok && len(query.Args) == 0 {
+			err = c.applySavepoint(op, name)
+			return
+		}
+
 		// append queries
 		c.queries = append(c.queries, *query)
 
@@ -387,18 +534,149 @@ func (c *conn) addQuery(ctx context.Context, queryType types.QueryType, query *t
 	return c.sendQuery(ctx, queryType, []types.Query{*query})
 }
 
+func (c *conn) addBatch(ctx context.Context, b *Batch) (affectedRows int64, lastInsertID int64, err error) {
+	if c.inTransaction {
+		// append queries
+		c.queries = append(c.queries, b.queries...)
+
+		log.WithField("count", len(b.queries)).Debug("add batch to tx")
+
+		return
+	}
+
+	log.WithField("count", len(b.queries)).Debug("execute batch")
+
+	affectedRows, lastInsertID, _, err = c.sendQuery(ctx, types.WriteQuery, b.queries)
+	return
+}
+
+// readRetryPolicy builds the retry policy of read queries from the retry options of config, the
+// retries are sent after a constant backoff.
+func readRetryPolicy(cfg *Config) rpc.RetryPolicy {
+	p := rpc.RetryPolicy{
+		MaxAttempts:    cfg.RetryCount + 1,
+		InitialBackoff: cfg.RetryBackoff,
+		Multiplier:     1,
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = DefaultRetryBackoff
+	}
+	return p
+}
+
 func (c *conn) sendQuery(ctx context.Context, queryType types.QueryType, queries []types.Query) (affectedRows int64, lastInsertID int64, rows driver.Rows, err error) {
+	if queryType != types.ReadQuery {
+		// writes are not idempotent, only read queries are retried
+		return c.trySendQuery(ctx, queryType, queries)
+	}
+	err = c.retryPolicy.Run(ctx, func(attempt int) (err error) {
+		if affectedRows, lastInsertID, rows, err = c.trySendQuery(ctx, queryType, queries); err != nil {
+			log.WithField("db", c.dbID).WithError(err).Debugf("query attempt #%d failed", attempt+1)
+		}
+		return
+	})
+	return
+}
+
+func (c *conn) trySendQuery(ctx context.Context, queryType types.QueryType, queries []types.Query) (affectedRows int64, lastInsertID int64, rows driver.Rows, err error) {
 	var uc *pconn // peer connection used to execute the queries
 
 	uc = c.leader
-	// use follower pconn only when the query is readonly
-	if queryType == types.ReadQuery && c.follower != nil {
-		uc = c.follower
+	if queryType == types.ReadQuery {
+		if pref, ok := GetReadPreference(ctx); ok && !c.mirror {
+			uc = c.readReplica(pref)
+		} else if c.follower != nil {
+			// use follower pconn only when the query is readonly
+			uc = c.follower
+		}
 	}
 	if uc == nil {
 		uc = c.follower
 	}
+	if uc == c.leader {
+		return c.sendQueryToLeader(ctx, queryType, queries)
+	}
+
+	affectedRows, lastInsertID, rows, err = c.sendQueryTo(ctx, uc, queryType, queries)
+	if err != nil && c.leader != nil && strings.Contains(err.Error(), worker.ErrStaleRead.Error()) {
+		// follower is lagging behind the staleness bound, fallback to leader
+		log.WithField("db", c.dbID).WithError(err).Debug("follower read rejected, retry on leader")
+		return c.sendQueryToLeader(ctx, queryType, queries)
+	}
+
+	return
+}
+
+// sendQueryToLeader sends queries to the leader and re-routes them on leader failure. Writes are
+// re-sent only if they are rejected by a non-leader node or the leader is changed, to avoid
+// applying them twice on a leader which is just temporarily unreachable.
+func (c *conn) sendQueryToLeader(ctx context.Context, queryType types.QueryType, queries []types.Query) (
+	affectedRows int64, lastInsertID int64, rows driver.Rows, err error) {
+	c.syncLeader()
+	affectedRows, lastInsertID, rows, err = c.sendQueryTo(ctx, c.leader, queryType, queries)
+	if c.mirror || !isLeaderFailure(err) {
+		return
+	}
+
+	var resend bool
+	for i := 1; i < FailoverPolicy.Attempts(); i++ {
+		if notLeader := strings.Contains(err.Error(), kt.ErrNotLeader.Error()); notLeader {
+			// the preferred node is not the leader, follow the leader from block producer
+			c.preferredLeader = ""
+			resend = true
+		} else if queryType == types.ReadQuery {
+			resend = true
+		}
+
+		log.WithFields(log.Fields{
+			"db":      c.dbID,
+			"leader":  c.leaderID,
+			"attempt": i,
+		}).WithError(err).Debug("leader query failed, try failover")
+
+		timer := time.NewTimer(FailoverPolicy.Backoff(i))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = errors.Wrapf(ctx.Err(), "leader failover aborted, last error: %v", err)
+			return
+		case <-timer.C:
+		}
+
+		changed, lerr := c.refreshLeader()
+		if lerr != nil {
+			log.WithField("db", c.dbID).WithError(lerr).Debug("refresh database leader failed")
+			continue
+		}
+		if !changed && !resend {
+			continue
+		}
+
+		affectedRows, lastInsertID, rows, err = c.sendQueryTo(ctx, c.leader, queryType, queries)
+		if !isLeaderFailure(err) {
+			return
+		}
+		resend = false
+	}
+
+	return
+}
+
+// isLeaderFailure reports whether the query error is caused by an unavailable or deposed leader.
+func isLeaderFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	// TODO(xq262144), better rpc remote error judgement
+	// dialing errors may be caused by a remote error of node resolving
+	msg := err.Error()
+	return strings.Contains(msg, kt.ErrNotLeader.Error()) ||
+		strings.Contains(msg, "init PersistentCaller client failed") ||
+		rpc.IsRetryableError(err)
+}
 
+func (c *conn) sendQueryTo(ctx context.Context, uc *pconn, queryType types.QueryType, queries []types.Query) (
+	affectedRows int64, lastInsertID int64, rows driver.Rows, err error) {
 	// allocate sequence
 	connID, seqNo := allocateConnAndSeq()
 	defer putBackConn(connID)
@@ -430,6 +708,17 @@ func (c *conn) sendQuery(ctx context.Context, queryType types.QueryType, queries
 			Queries: queries,
 		},
 	}
+	if queryType == types.ReadQuery && c.linearizable {
+		// linearizable reads are never stale
+		req.Header.Linearizable = true
+	} else if queryType == types.ReadQuery && uc != c.leader {
+		if pref, ok := GetReadPreference(ctx); ok {
+			req.Header.MaxStaleMillis = pref.staleMillis()
+		} else {
+			req.Header.MaxStaleBlocks = c.maxStaleBlocks
+			req.Header.MaxStaleMillis = c.maxStaleMillis
+		}
+	}
 
 	if err = req.Sign(c.privKey); err != nil {
 		return
@@ -442,37 +731,110 @@ func (c *conn) sendQuery(ctx context.Context, queryType types.QueryType, queries
 		})
 	}
 
-	var response types.Response
-	if err = uc.pCaller.Call(route.DBSQuery.String(), req, &response); err != nil {
+	if queryType == types.ReadQuery && c.streamQuery && !c.mirror && ctx.Value(&ctxReceiptKey) == nil {
+		rows, err = c.streamQueryTo(ctx, uc, req)
 		return
 	}
+
+	var (
+		response types.Response
+		start    = time.Now()
+	)
+	if err = c.call(ctx, uc, req, &response); err != nil {
+		if isLeaderFailure(err) {
+			c.recordRTT(uc.node, rttFailurePenalty)
+		}
+		return
+	}
+	c.recordRTT(uc.node, time.Since(start))
 	rows = newRows(&response)
 
+	// update receipt with response
+	if val := ctx.Value(&ctxReceiptKey); val != nil {
+		val.(*atomic.Value).Store(&Receipt{
+			RequestHash: req.Header.Hash(),
+			Response:    &response,
+		})
+	}
+
 	if queryType == types.WriteQuery {
 		affectedRows = response.Header.AffectedRows
 		lastInsertID = response.Header.LastInsertID
 	}
 
-	// build ack
-	func() {
-		defer trace.StartRegion(ctx, "ackEnqueue").End()
-		if uc.ackCh != nil {
-			uc.ackCh <- &types.Ack{
-				Header: types.SignedAckHeader{
-					AckHeader: types.AckHeader{
-						Response:     response.Header.ResponseHeader,
-						ResponseHash: response.Header.Hash(),
-						NodeID:       c.localNodeID,
-						Timestamp:    getLocalTime(),
-					},
+	c.enqueueAck(ctx, uc, &response.Header)
+
+	return
+}
+
+// enqueueAck builds the ack of response and sends it to the ack workers of peer connection.
+func (c *conn) enqueueAck(ctx context.Context, uc *pconn, header *types.SignedResponseHeader) {
+	defer trace.StartRegion(ctx, "ackEnqueue").End()
+	if uc.ackCh != nil {
+		uc.ackCh <- &types.Ack{
+			Header: types.SignedAckHeader{
+				AckHeader: types.AckHeader{
+					Response:     header.ResponseHeader,
+					ResponseHash: header.Hash(),
+					NodeID:       c.localNodeID,
+					Timestamp:    getLocalTime(),
 				},
-			}
+			},
 		}
-	}()
+	}
+}
+
+// streamQueryTo sends the read query to peer with the streaming method. The rows are decoded
+// from the stream on demand, only the response header is bounded by the query timeout.
+func (c *conn) streamQueryTo(ctx context.Context, uc *pconn, req *types.Request) (rows driver.Rows, err error) {
+	caller := mux.NewCaller().Caller
+	if c.useDirectRPC {
+		caller = rpc.NewCaller()
+	}
+	start := time.Now()
+	r, err := caller.CallStream(ctx, uc.node, route.DBSQueryStream.String(), req)
+	if err != nil {
+		c.recordRTT(uc.node, rttFailurePenalty)
+		return
+	}
+	if c.timeout > 0 {
+		timer := time.AfterFunc(c.timeout, func() { _ = r.Close() })
+		defer timer.Stop()
+	}
 
+	var (
+		dec    = utils.GetMsgPackDecoder(r)
+		header types.ResponseStreamHeader
+	)
+	if err = dec.Decode(&header); err != nil {
+		_ = r.Close()
+		if isLeaderFailure(err) {
+			c.recordRTT(uc.node, rttFailurePenalty)
+		}
+		err = errors.Wrap(err, "read query response header failed")
+		return
+	}
+	c.recordRTT(uc.node, time.Since(start))
+	rows = newStreamRows(&header, dec, r)
+	c.enqueueAck(ctx, uc, &header.Header)
 	return
 }
 
+// call sends the request to peer, the call is aborted on the connection timeout if the peer
+// caller supports context.
+func (c *conn) call(ctx context.Context, uc *pconn, req *types.Request, resp *types.Response) error {
+	cc, ok := uc.pCaller.(contextCaller)
+	if !ok {
+		return uc.pCaller.Call(route.DBSQuery.String(), req, resp)
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	return cc.CallWithContext(ctx, route.DBSQuery.String(), req, resp)
+}
+
 func getLocalTime() time.Time {
 	return time.Now().UTC()
 }
@@ -19,11 +19,19 @@ package client
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	nrpc "net/rpc"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
@@ -72,6 +80,31 @@ func TestConn(t *testing.T) {
 		rec, ok = GetReceipt(ctx)
 		So(ok, ShouldBeTrue)
 		So(rec, ShouldNotBeNil)
+		So(rec.Response, ShouldNotBeNil)
+		So(rec.RequestHash, ShouldResemble, rec.Response.Header.RequestHash)
+
+		// verify the response after it's packed in block
+		var proof *types.QueryProof
+		for i := 0; i < 50; i++ {
+			if proof, err = VerifyReceipt(context.Background(), "covenantsql://db", rec); err == nil {
+				break
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+		So(err, ShouldBeNil)
+		So(proof, ShouldNotBeNil)
+		_, err = VerifyReceipt(context.Background(), "covenantsql://db", &Receipt{})
+		So(err, ShouldEqual, ErrNoResponse)
+
+		// cross database transaction requires distinct databases
+		branch := &CrossTxBranch{
+			DSN:     "covenantsql://db",
+			Queries: []types.Query{{Pattern: "insert into test values(1)"}},
+		}
+		_, err = ExecuteCrossTx(context.Background(), 0, branch)
+		So(err, ShouldEqual, ErrInvalidCrossTx)
+		_, err = ExecuteCrossTx(context.Background(), 0, branch, branch)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidCrossTx)
 
 		err = rows.Scan(&result)
 		So(err, ShouldBeNil)
@@ -282,6 +315,192 @@ func TestTransaction(t *testing.T) {
 	})
 }
 
+func TestConnWithDSNOptions(t *testing.T) {
+	Convey("test connection with timeout and retry options", t, func() {
+		stopTestService, _, err := startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		db, err := sql.Open("covenantsql", "covenantsql://db?consistency=strong&timeout=5s&retry=2&retry_backoff=10ms&lead=unknown")
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		_, err = db.Exec("create table test (test int)")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("insert into test values (1)")
+		So(err, ShouldBeNil)
+
+		var result int
+		err = db.QueryRow("select * from test").Scan(&result)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, 1)
+
+		// read query keeps failing after retries
+		err = db.QueryRow("select * from not_exists").Scan(&result)
+		So(err, ShouldNotBeNil)
+
+		// canceled context aborts the retries
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = db.QueryContext(ctx, "select * from test")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("test connection with streamed read queries", t, func() {
+		stopTestService, _, err := startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		db, err := sql.Open("covenantsql", "covenantsql://db?stream=true&timeout=5s")
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		_, err = db.Exec("create table test (test int, name text)")
+		So(err, ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			_, err = db.Exec("insert into test values (?, ?)", i, fmt.Sprintf("name%d", i))
+			So(err, ShouldBeNil)
+		}
+
+		rows, err := db.Query("select * from test order by test")
+		So(err, ShouldBeNil)
+		columns, err := rows.Columns()
+		So(err, ShouldBeNil)
+		So(columns, ShouldResemble, []string{"test", "name"})
+		types, err := rows.ColumnTypes()
+		So(err, ShouldBeNil)
+		So(types[0].DatabaseTypeName(), ShouldEqual, "INT")
+		var count int
+		for ; rows.Next(); count++ {
+			var (
+				id   int
+				name string
+			)
+			So(rows.Scan(&id, &name), ShouldBeNil)
+			So(id, ShouldEqual, count)
+			So(name, ShouldEqual, fmt.Sprintf("name%d", count))
+		}
+		So(rows.Err(), ShouldBeNil)
+		So(rows.Close(), ShouldBeNil)
+		So(count, ShouldEqual, 100)
+
+		// rows could be closed before all rows are read
+		rows, err = db.Query("select * from test")
+		So(err, ShouldBeNil)
+		So(rows.Next(), ShouldBeTrue)
+		So(rows.Close(), ShouldBeNil)
+
+		// error of query is returned before any row
+		_, err = db.Query("select * from not_exists")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no such table")
+	})
+
+	Convey("test read retry policy", t, func() {
+		p := readRetryPolicy(&Config{RetryCount: 2, RetryBackoff: 50 * time.Millisecond})
+		So(p.Attempts(), ShouldEqual, 3)
+		So(p.Backoff(1), ShouldEqual, 50*time.Millisecond)
+		So(p.Backoff(2), ShouldEqual, 50*time.Millisecond)
+		p = readRetryPolicy(&Config{})
+		So(p.Attempts(), ShouldEqual, 1)
+		So(p.Backoff(1), ShouldEqual, DefaultRetryBackoff)
+	})
+
+	Convey("test preferred leader", t, func() {
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Leader:  "leader",
+				Servers: []proto.NodeID{"leader", "follower"},
+			},
+		}
+		So(preferredLeader(peers, ""), ShouldEqual, "leader")
+		So(preferredLeader(peers, "follower"), ShouldEqual, "follower")
+		So(preferredLeader(peers, "unknown"), ShouldEqual, "leader")
+	})
+}
+
+func TestLeaderFailover(t *testing.T) {
+	Convey("test leader failover", t, func() {
+		stopTestService, _, err := startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		policy := FailoverPolicy
+		FailoverPolicy.InitialBackoff = 10 * time.Millisecond
+		defer func() { FailoverPolicy = policy }()
+
+		db, err := sql.Open("covenantsql", "covenantsql://db")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		_, err = db.Exec("create table test (test int)")
+		So(err, ShouldBeNil)
+
+		sc, err := db.Conn(context.Background())
+		So(err, ShouldBeNil)
+		defer sc.Close()
+
+		var (
+			c        *conn
+			leaderID proto.NodeID
+			deadNode = proto.NodeID(hash.THashH([]byte("dead leader")).String())
+		)
+		err = sc.Raw(func(dc interface{}) error {
+			c = dc.(*conn)
+			leaderID = c.leaderID
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(leaderID, ShouldNotBeEmpty)
+		defer peerList.Delete(c.dbID)
+
+		// leader is unreachable, the write is re-routed to the leader from block producer
+		err = sc.Raw(func(interface{}) error { return c.switchLeader(deadNode) })
+		So(err, ShouldBeNil)
+		_, err = sc.ExecContext(context.Background(), "insert into test values (1)")
+		So(err, ShouldBeNil)
+		So(c.leaderID, ShouldEqual, leaderID)
+
+		// leader change found by the peers updater is followed before query
+		peers, err := cacheGetPeers(c.dbID, c.privKey)
+		So(err, ShouldBeNil)
+		peerList.Store(c.dbID, &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Leader:  deadNode,
+				Servers: []proto.NodeID{deadNode},
+			},
+		})
+		var result int
+		err = sc.QueryRowContext(context.Background(), "select count(1) from test").Scan(&result)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, 1)
+		So(c.leaderID, ShouldEqual, peers.Leader)
+
+		// failover is aborted by context
+		FailoverPolicy.InitialBackoff = time.Second
+		peerList.Store(c.dbID, &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Leader:  deadNode,
+				Servers: []proto.NodeID{deadNode},
+			},
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err = sc.ExecContext(ctx, "insert into test values (2)")
+		So(err, ShouldNotBeNil)
+		So(errors.Cause(err), ShouldResemble, context.DeadlineExceeded)
+	})
+
+	Convey("test leader failure errors", t, func() {
+		So(isLeaderFailure(nil), ShouldBeFalse)
+		So(isLeaderFailure(nrpc.ServerError("no such table")), ShouldBeFalse)
+		So(isLeaderFailure(context.DeadlineExceeded), ShouldBeFalse)
+		So(isLeaderFailure(nrpc.ServerError(kt.ErrNotLeader.Error())), ShouldBeTrue)
+		So(isLeaderFailure(errors.New("connection refused")), ShouldBeTrue)
+	})
+}
+
 func TestConnAndSeqAllocation(t *testing.T) {
 	Convey("conn id and seq no allocation test", t, func() {
 		var wg sync.WaitGroup
@@ -22,6 +22,7 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"math/rand"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -90,7 +91,7 @@ func (d *covenantSQLDriver) Open(dsn string) (conn driver.Conn, err error) {
 	}
 
 	if atomic.LoadUint32(&driverInitialized) == 0 {
-		err = defaultInit()
+		err = dsnInit(cfg)
 		if err != nil && err != ErrAlreadyInitialized {
 			return
 		}
@@ -111,6 +112,10 @@ type ResourceMeta struct {
 	UseEventualConsistency bool                   `json:"eventual-consistency,omitempty"` // use eventual consistency replication if enabled
 	ConsistencyLevel       float64                `json:"consistency-level,omitempty"`    // customized strong consistency level
 	IsolationLevel         int                    `json:"isolation-level,omitempty"`      // customized isolation level
+	TargetNodes            []proto.NodeID         `json:"target-nodes,omitempty"`         // designated miner nodes
+	Regions                []string               `json:"regions,omitempty"`              // acceptable miner regions
+	MaxGasPrice            uint64                 `json:"max-gas-price,omitempty"`        // max gas price of each miner
+	Quota                  types.ResourceQuota    `json:"quota,omitempty"`                // resource usage limits
 
 	GasPrice       uint64 `json:"gas-price"`       // customized gas price
 	AdvancePayment uint64 `json:"advance-payment"` // customized advance payment
@@ -128,8 +133,36 @@ func defaultInit() (err error) {
 	return Init(configFile, []byte(""))
 }
 
+// dsnInit initializes the driver with the config file, private key and compression options
+// of the DSN, the default config is used if no config file is specified.
+func dsnInit(cfg *Config) (err error) {
+	if cfg.ConfigFile == "" && cfg.PrivateKeyFile == "" && cfg.Compress == "" {
+		return defaultInit()
+	}
+
+	configFile := DefaultConfigFile
+	if cfg.ConfigFile != "" {
+		configFile = cfg.ConfigFile
+	}
+	configFile = utils.HomeDirExpand(configFile)
+
+	log.Debugf("Using CovenantSQL config location from dsn: %v", configFile)
+	return initWithOverride(configFile, []byte(""), func(c *conf.Config) {
+		if cfg.PrivateKeyFile != "" {
+			c.PrivateKeyFile = utils.HomeDirExpand(cfg.PrivateKeyFile)
+		}
+		if cfg.Compress != "" {
+			c.Compression = cfg.Compress
+		}
+	})
+}
+
 // Init defines init process for client.
 func Init(configFile string, masterKey []byte) (err error) {
+	return initWithOverride(configFile, masterKey, nil)
+}
+
+func initWithOverride(configFile string, masterKey []byte, override func(*conf.Config)) (err error) {
 	if !atomic.CompareAndSwapUint32(&driverInitialized, 0, 1) {
 		err = ErrAlreadyInitialized
 		return
@@ -139,6 +172,9 @@ func Init(configFile string, masterKey []byte) (err error) {
 	if conf.GConf, err = conf.LoadConfig(configFile); err != nil {
 		return
 	}
+	if override != nil {
+		override(conf.GConf)
+	}
 
 	route.InitKMS(conf.GConf.PubKeyStoreFile)
 	if err = kms.InitLocalKeyPair(conf.GConf.PrivateKeyFile, masterKey); err != nil {
@@ -184,7 +220,7 @@ func Create(meta ResourceMeta) (txHash hash.Hash, dsn string, err error) {
 	// allocate nonce
 	nonceReq.Addr = clientAddr
 
-	if err = requestBP(route.MCCNextAccountNonce, nonceReq, nonceResp); err != nil {
+	if err = queryBP(route.MCCNextAccountNonce, nonceReq, nonceResp); err != nil {
 		err = errors.Wrap(err, "allocate create database transaction nonce failed")
 		return
 	}
@@ -209,6 +245,10 @@ func Create(meta ResourceMeta) (txHash hash.Hash, dsn string, err error) {
 			UseEventualConsistency: meta.UseEventualConsistency,
 			ConsistencyLevel:       meta.ConsistencyLevel,
 			IsolationLevel:         meta.IsolationLevel,
+			TargetNodes:            meta.TargetNodes,
+			Regions:                meta.Regions,
+			MaxGasPrice:            meta.MaxGasPrice,
+			Quota:                  meta.Quota,
 		},
 		GasPrice:       meta.GasPrice,
 		AdvancePayment: meta.AdvancePayment,
@@ -340,7 +380,7 @@ func GetTokenBalance(tt types.TokenType) (balance uint64, err error) {
 	}
 	req.TokenType = tt
 
-	if err = requestBP(route.MCCQueryAccountTokenBalance, req, resp); err == nil {
+	if err = queryBP(route.MCCQueryAccountTokenBalance, req, resp); err == nil {
 		if !resp.OK {
 			err = ErrNoSuchTokenBalance
 			return
@@ -404,25 +444,64 @@ func UpdatePermission(targetUser proto.AccountAddress,
 	return
 }
 
-// TransferToken send Transfer transaction to chain.
-func TransferToken(targetUser proto.AccountAddress, amount uint64, tokenType types.TokenType) (
-	txHash hash.Hash, err error,
-) {
+// UpdateDatabaseQuota sends UpdateDatabaseQuota transaction to chain.
+func UpdateDatabaseQuota(targetChain proto.AccountAddress, quota types.ResourceQuota) (txHash hash.Hash, err error) {
 	if atomic.LoadUint32(&driverInitialized) == 0 {
 		err = ErrNotInitialized
 		return
 	}
 
 	var (
-		pubKey  *asymmetric.PublicKey
 		privKey *asymmetric.PrivateKey
 		addr    proto.AccountAddress
 		nonce   interfaces.AccountNonce
 	)
-	if pubKey, err = kms.GetLocalPublicKey(); err != nil {
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
 		return
 	}
-	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+	if addr, err = crypto.PubKeyHash(privKey.PubKey()); err != nil {
+		return
+	}
+	if nonce, err = getNonce(addr); err != nil {
+		return
+	}
+
+	uq := types.NewUpdateDatabaseQuota(&types.UpdateDatabaseQuotaHeader{
+		TargetSQLChain: targetChain,
+		Quota:          quota,
+		Nonce:          nonce,
+	})
+	if err = uq.Sign(privKey); err != nil {
+		log.WithError(err).Warning("sign failed")
+		return
+	}
+	addTxReq := new(types.AddTxReq)
+	addTxResp := new(types.AddTxResp)
+	addTxReq.Tx = uq
+	if err = requestBP(route.MCCAddTx, addTxReq, addTxResp); err != nil {
+		log.WithError(err).Warning("send tx failed")
+		return
+	}
+
+	txHash = uq.Hash()
+	return
+}
+
+// TransferToken send Transfer transaction to chain.
+func TransferToken(targetUser proto.AccountAddress, amount uint64, tokenType types.TokenType) (
+	txHash hash.Hash, err error,
+) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var (
+		pubKey *asymmetric.PublicKey
+		addr   proto.AccountAddress
+		nonce  interfaces.AccountNonce
+	)
+	if pubKey, err = kms.GetLocalPublicKey(); err != nil {
 		return
 	}
 	if addr, err = crypto.PubKeyHash(pubKey); err != nil {
@@ -434,6 +513,31 @@ func TransferToken(targetUser proto.AccountAddress, amount uint64, tokenType typ
 		return
 	}
 
+	return TransferTokenWithNonce(targetUser, amount, tokenType, nonce)
+}
+
+// TransferTokenWithNonce sends Transfer transaction with the specified account nonce to chain.
+func TransferTokenWithNonce(
+	targetUser proto.AccountAddress, amount uint64, tokenType types.TokenType, nonce interfaces.AccountNonce,
+) (
+	txHash hash.Hash, err error,
+) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var (
+		privKey *asymmetric.PrivateKey
+		addr    proto.AccountAddress
+	)
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if addr, err = crypto.PubKeyHash(privKey.PubKey()); err != nil {
+		return
+	}
+
 	tran := types.NewTransfer(&types.TransferHeader{
 		Sender:    addr,
 		Receiver:  targetUser,
@@ -474,7 +578,7 @@ func WaitTxConfirmation(
 	defer ticker.Stop()
 	defer fmt.Printf("\n")
 	for {
-		if err = requestBP(method, req, resp); err != nil {
+		if err = queryBP(method, req, resp); err != nil {
 			err = errors.Wrapf(err, "failed to call %s", method)
 			return
 		}
@@ -509,11 +613,214 @@ func WaitTxConfirmation(
 	}
 }
 
+// SubscribeChainEvents subscribes the chain events of block producer. The events are sent to the
+// returned channel, which is closed if ctx is canceled or the subscription is broken.
+func SubscribeChainEvents(
+	ctx context.Context, req *types.SubscribeReq) (events <-chan *types.ChainEvent, err error,
+) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var bpNodeID proto.NodeID
+	if bpNodeID, err = rpc.GetCurrentBP(); err != nil {
+		return
+	}
+	r, err := rpc.NewCaller().CallStream(ctx, bpNodeID, route.MCCSubscribe.String(), req)
+	if err != nil {
+		return
+	}
+
+	var ch = make(chan *types.ChainEvent)
+	go func() {
+		defer close(ch)
+		defer func() { _ = r.Close() }()
+		var dec = utils.GetMsgPackDecoder(r)
+		for {
+			var e = &types.ChainEvent{}
+			if err := dec.Decode(e); err != nil {
+				log.WithError(err).Debug("chain event subscription closed")
+				return
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	events = ch
+	return
+}
+
+// Restore materializes the state of the database specified by dsn at the last block no higher
+// than height, or at the last block produced no later than ts if ts is not zero, into the new
+// SQLite data file. It's used to recover the data from bad writes, the data file can be opened
+// with the sqlite3 driver or imported into a new database.
+func Restore(
+	ctx context.Context, dsn string, height int32, ts time.Time, dataFile string,
+) (point *types.RecoveryPoint, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var cfg *Config
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	var (
+		dbID    = proto.DatabaseID(cfg.DatabaseID)
+		privKey *asymmetric.PrivateKey
+		peers   *proto.Peers
+	)
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if peers, err = cacheGetPeers(dbID, privKey); err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(dataFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(dataFile)
+		}
+	}()
+
+	req := &types.RestoreReq{
+		DatabaseID: dbID,
+		Height:     height,
+		Timestamp:  ts,
+	}
+	r, err := rpc.NewCaller().CallStream(ctx, peers.Leader, route.DBSRestore.String(), req)
+	if err != nil {
+		return
+	}
+	defer func() { _ = r.Close() }()
+
+	var (
+		dec = utils.GetMsgPackDecoder(r)
+		rp  = &types.RecoveryPoint{}
+	)
+	if err = dec.Decode(rp); err != nil {
+		err = errors.Wrap(err, "read recovery point failed")
+		return
+	}
+	for written := int64(0); written < rp.Size; {
+		var chunk []byte
+		if err = dec.Decode(&chunk); err != nil {
+			err = errors.Wrapf(err, "read data file failed at offset %d", written)
+			return
+		}
+		if _, err = f.Write(chunk); err != nil {
+			return
+		}
+		written += int64(len(chunk))
+	}
+	point = rp
+	return
+}
+
+// SchemaChangeStatus returns the progress of the last schema change of the database from its
+// leader miner.
+func SchemaChangeStatus(ctx context.Context, dsn string) (status *types.SchemaChangeStatus, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var cfg *Config
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	var (
+		dbID    = proto.DatabaseID(cfg.DatabaseID)
+		privKey *asymmetric.PrivateKey
+		peers   *proto.Peers
+		req     = &types.SchemaChangeStatusReq{DatabaseID: dbID}
+		resp    = &types.SchemaChangeStatusResp{}
+	)
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if peers, err = cacheGetPeers(dbID, privKey); err != nil {
+		return
+	}
+	if err = rpc.NewCaller().CallNodeWithRetry(
+		ctx, QueryRetryPolicy, peers.Leader, route.DBSSchemaChangeStatus.String(), req, resp,
+	); err != nil {
+		return
+	}
+	status = &resp.Status
+	return
+}
+
+// VerifyReceipt fetches the proof of the query response in receipt from the leader miner and
+// verifies that the response is packed in a block signed by one of the database peers. The proof
+// is available after the block is produced, so the call should be retried on not found errors.
+func VerifyReceipt(ctx context.Context, dsn string, rec *Receipt) (proof *types.QueryProof, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+	if rec == nil || rec.Response == nil {
+		err = ErrNoResponse
+		return
+	}
+
+	var cfg *Config
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	var (
+		dbID    = proto.DatabaseID(cfg.DatabaseID)
+		privKey *asymmetric.PrivateKey
+		peers   *proto.Peers
+		req     = &types.QueryProofReq{
+			DatabaseID:   dbID,
+			ResponseHash: rec.Response.Header.ResponseHash,
+		}
+		resp = &types.QueryProofResp{}
+	)
+	if rec.Response.Header.Request.DatabaseID != dbID {
+		err = errors.Errorf("response of database %s is not from %s", rec.Response.Header.Request.DatabaseID, dbID)
+		return
+	}
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if peers, err = cacheGetPeers(dbID, privKey); err != nil {
+		return
+	}
+	if err = rpc.NewCaller().CallNodeWithRetry(
+		ctx, QueryRetryPolicy, peers.Leader, route.DBSQueryProof.String(), req, resp,
+	); err != nil {
+		return
+	}
+	if err = resp.Proof.VerifyResponse(rec.Response); err != nil {
+		return
+	}
+	if _, found := peers.Find(resp.Proof.Header.Producer); !found {
+		err = errors.Wrapf(ErrUntrustedProducer, "producer %s", resp.Proof.Header.Producer)
+		return
+	}
+	proof = &resp.Proof
+	return
+}
+
 func getNonce(addr proto.AccountAddress) (nonce interfaces.AccountNonce, err error) {
 	nonceReq := new(types.NextAccountNonceReq)
 	nonceResp := new(types.NextAccountNonceResp)
 	nonceReq.Addr = addr
-	err = requestBP(route.MCCNextAccountNonce, nonceReq, nonceResp)
+	err = queryBP(route.MCCNextAccountNonce, nonceReq, nonceResp)
 	if err != nil {
 		log.WithError(err).Warning("get nonce failed")
 		return
@@ -531,6 +838,11 @@ func requestBP(method route.RemoteFunc, request interface{}, response interface{
 	return rpc.NewCaller().CallNode(bpNodeID, method.String(), request, response)
 }
 
+// queryBP sends idempotent request to block producers with retries according to QueryRetryPolicy.
+func queryBP(method route.RemoteFunc, request interface{}, response interface{}) (err error) {
+	return rpc.RequestBPWithRetry(context.Background(), QueryRetryPolicy, method.String(), request, response)
+}
+
 func registerNode() (err error) {
 	var nodeID proto.NodeID
 
@@ -641,7 +953,9 @@ func getPeers(dbID proto.DatabaseID, privKey *asymmetric.PrivateKey) (peers *pro
 	profileReq := &types.QuerySQLChainProfileReq{}
 	profileResp := &types.QuerySQLChainProfileResp{}
 	profileReq.DBID = dbID
-	err = rpc.RequestBP(route.MCCQuerySQLChainProfile.String(), profileReq, profileResp)
+	err = rpc.RequestBPWithRetry(
+		context.Background(), QueryRetryPolicy,
+		route.MCCQuerySQLChainProfile.String(), profileReq, profileResp)
 	if err != nil {
 		err = errors.Wrap(err, "get sqlchain profile failed in getPeers")
 		return
@@ -284,6 +284,8 @@ func TestTransferToken(t *testing.T) {
 		// driver not initialized
 		_, err = TransferToken(user, 100, types.Particle)
 		So(err, ShouldEqual, ErrNotInitialized)
+		_, err = TransferTokenWithNonce(user, 100, types.Particle, 1)
+		So(err, ShouldEqual, ErrNotInitialized)
 
 		// fake driver initialized
 		atomic.StoreUint32(&driverInitialized, 1)
@@ -303,6 +305,11 @@ func TestTransferToken(t *testing.T) {
 		ctx := context.Background()
 		_, err = WaitTxConfirmation(ctx, txHash)
 		So(err, ShouldBeNil)
+
+		// explicit nonce
+		nonceHash, err := TransferTokenWithNonce(user, 100, types.Particle, stubNextNonce+1)
+		So(err, ShouldBeNil)
+		So(nonceHash, ShouldNotEqual, txHash)
 	})
 }
 
@@ -334,6 +341,27 @@ func TestUpdatePermission(t *testing.T) {
 	})
 }
 
+func TestUpdateDatabaseQuota(t *testing.T) {
+	Convey("test UpdateDatabaseQuota of a database", t, func() {
+		var stopTestService func()
+		var err error
+		var chain proto.AccountAddress
+		var quota = types.ResourceQuota{MaxResultSize: 1 << 20}
+
+		// driver not initialized
+		_, err = UpdateDatabaseQuota(chain, quota)
+		So(err, ShouldEqual, ErrNotInitialized)
+
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		// with mock bp, any params will be success
+		_, err = UpdateDatabaseQuota(chain, quota)
+		So(err, ShouldBeNil)
+	})
+}
+
 func TestRunPeerListUpdater(t *testing.T) {
 	Convey("test peersUpdaterRunning", t, func() {
 		var stopTestService func()
@@ -0,0 +1,306 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// DumpFormat defines the output format of database dump.
+type DumpFormat int
+
+const (
+	// DumpSQL dumps the database as SQL statements which rebuild the schema and data.
+	DumpSQL DumpFormat = iota
+	// DumpCSV dumps each table as a section of CSV records, the section starts with a
+	// "# table: <name>" line followed by the header record of column names.
+	DumpCSV
+)
+
+// String implements fmt.Stringer.
+func (f DumpFormat) String() string {
+	switch f {
+	case DumpSQL:
+		return "SQL"
+	case DumpCSV:
+		return "CSV"
+	default:
+		return "Unknown"
+	}
+}
+
+// Dump writes a logical dump of the database specified by dsn at its latest block to w. See
+// DumpAt for details.
+func Dump(ctx context.Context, dsn string, w io.Writer, format DumpFormat) (
+	point *types.RecoveryPoint, err error,
+) {
+	return DumpAt(ctx, dsn, -1, w, format)
+}
+
+// DumpAt writes a logical dump of the database specified by dsn at the last block no higher than
+// height to w, a negative height means the latest block. The database state is restored from
+// the leader miner into a temporary data file, so the dump is consistent at a single block and
+// the rows are streamed to w table by table without loading them into memory.
+func DumpAt(ctx context.Context, dsn string, height int32, w io.Writer, format DumpFormat) (
+	point *types.RecoveryPoint, err error,
+) {
+	if format != DumpSQL && format != DumpCSV {
+		err = errors.Errorf("unknown dump format: %d", format)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "cql-dump-")
+	if err != nil {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var dataFile = filepath.Join(dir, "dump.db3")
+	if point, err = Restore(ctx, dsn, height, time.Time{}, dataFile); err != nil {
+		return
+	}
+
+	var fdsn *storage.DSN
+	if fdsn, err = storage.NewDSN(dataFile); err != nil {
+		return
+	}
+	fdsn.AddParam("mode", "ro")
+	db, err := sql.Open("sqlite3", fdsn.Format())
+	if err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+
+	err = dumpDatabase(ctx, db, w, format, point)
+	return
+}
+
+// dumpObject defines a schema object in sqlite_master.
+type dumpObject struct {
+	typ  string
+	name string
+	sql  string
+}
+
+func dumpDatabase(ctx context.Context, db *sql.DB, w io.Writer, format DumpFormat, point *types.RecoveryPoint) (err error) {
+	var objects []dumpObject
+	if objects, err = listDumpObjects(ctx, db); err != nil {
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	switch format {
+	case DumpSQL:
+		err = dumpSQL(ctx, db, bw, objects, point)
+	case DumpCSV:
+		err = dumpCSV(ctx, db, bw, objects)
+	default:
+		err = errors.Errorf("unknown dump format: %d", format)
+	}
+	if err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+func listDumpObjects(ctx context.Context, db *sql.DB) (objects []dumpObject, err error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT "type", "name", "sql" FROM "sqlite_master" `+
+			`WHERE "sql" IS NOT NULL AND "name" NOT LIKE 'sqlite_%' `+
+			`ORDER BY CASE "type" WHEN 'table' THEN 0 WHEN 'index' THEN 1 ELSE 2 END, "name"`)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var o dumpObject
+		if err = rows.Scan(&o.typ, &o.name, &o.sql); err != nil {
+			return
+		}
+		objects = append(objects, o)
+	}
+	err = rows.Err()
+	return
+}
+
+// dumpRows calls fn with each row of the table.
+func dumpRows(ctx context.Context, db *sql.DB, table string, fn func(columns []string, row []interface{}) error) (err error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM "+quoteIdentifier(table))
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+	var (
+		row  = make([]interface{}, len(columns))
+		dest = make([]interface{}, len(columns))
+	)
+	for i := range row {
+		dest[i] = &row[i]
+	}
+	if err = fn(columns, nil); err != nil {
+		return
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		if err = fn(columns, row); err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+func dumpSQL(ctx context.Context, db *sql.DB, w *bufio.Writer, objects []dumpObject, point *types.RecoveryPoint) (err error) {
+	if point != nil {
+		fmt.Fprintf(w, "-- CovenantSQL dump at block %s, height %d, time %s\n",
+			point.BlockHash.String(), point.Height, point.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	w.WriteString("BEGIN TRANSACTION;\n")
+	for _, o := range objects {
+		if o.typ != "table" {
+			continue
+		}
+		fmt.Fprintf(w, "%s;\n", o.sql)
+		var prefix = "INSERT INTO " + quoteIdentifier(o.name) + " VALUES("
+		if err = dumpRows(ctx, db, o.name, func(_ []string, row []interface{}) (err error) {
+			if row == nil {
+				return
+			}
+			w.WriteString(prefix)
+			for i, v := range row {
+				if i > 0 {
+					w.WriteString(", ")
+				}
+				w.WriteString(sqlLiteral(v))
+			}
+			_, err = w.WriteString(");\n")
+			return
+		}); err != nil {
+			err = errors.Wrapf(err, "dump table %s failed", o.name)
+			return
+		}
+	}
+	for _, o := range objects {
+		if o.typ != "table" {
+			fmt.Fprintf(w, "%s;\n", o.sql)
+		}
+	}
+	_, err = w.WriteString("COMMIT;\n")
+	return
+}
+
+func dumpCSV(ctx context.Context, db *sql.DB, w *bufio.Writer, objects []dumpObject) (err error) {
+	var (
+		cw     = csv.NewWriter(w)
+		record []string
+		first  = true
+	)
+	for _, o := range objects {
+		if o.typ != "table" {
+			continue
+		}
+		if !first {
+			w.WriteString("\n")
+		}
+		first = false
+		fmt.Fprintf(w, "# table: %s\n", o.name)
+		if err = dumpRows(ctx, db, o.name, func(columns []string, row []interface{}) error {
+			if row == nil {
+				return cw.Write(columns)
+			}
+			record = record[:0]
+			for _, v := range row {
+				record = append(record, csvField(v))
+			}
+			return cw.Write(record)
+		}); err != nil {
+			err = errors.Wrapf(err, "dump table %s failed", o.name)
+			return
+		}
+		if cw.Flush(); cw.Error() != nil {
+			return cw.Error()
+		}
+	}
+	return
+}
+
+// sqlLiteral formats the value as a SQLite literal.
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case time.Time:
+		return "'" + v.Format(sqlite3.SQLiteTimestampFormats[0]) + "'"
+	default:
+		return "'" + strings.Replace(fmt.Sprint(v), "'", "''", -1) + "'"
+	}
+}
+
+// csvField formats the value as a CSV field, NULL is formatted as empty string.
+func csvField(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format(sqlite3.SQLiteTimestampFormats[0])
+	case int64, float64, bool:
+		return sqlLiteral(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestDump(t *testing.T) {
+	Convey("test dump database", t, func() {
+		dir, err := ioutil.TempDir("", "dump_test_")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "src.db3"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+		for _, q := range []string{
+			`CREATE TABLE "test" ("id" INTEGER PRIMARY KEY, "name" TEXT, "score" REAL, "data" BLOB)`,
+			`CREATE INDEX "idx_name" ON "test" ("name")`,
+			`CREATE TABLE "empty" ("v" TEXT)`,
+			`CREATE VIEW "names" AS SELECT "name" FROM "test"`,
+			`INSERT INTO "test" VALUES (1, 'it''s', 1.5, X'0102')`,
+			`INSERT INTO "test" VALUES (2, NULL, NULL, NULL)`,
+		} {
+			_, err = db.Exec(q)
+			So(err, ShouldBeNil)
+		}
+
+		point := &types.RecoveryPoint{
+			Height:    3,
+			BlockHash: hash.THashH([]byte("block")),
+			Timestamp: time.Unix(0, 0),
+		}
+
+		var buf bytes.Buffer
+		err = dumpDatabase(context.Background(), db, &buf, DumpSQL, point)
+		So(err, ShouldBeNil)
+		dump := buf.String()
+		So(dump, ShouldStartWith, "-- CovenantSQL dump at block "+point.BlockHash.String()+", height 3")
+		So(dump, ShouldContainSubstring, `INSERT INTO "test" VALUES(1, 'it''s', 1.5, X'0102');`)
+		So(dump, ShouldContainSubstring, `INSERT INTO "test" VALUES(2, NULL, NULL, NULL);`)
+		So(strings.Index(dump, `CREATE TABLE "test"`), ShouldBeLessThan, strings.Index(dump, `CREATE INDEX "idx_name"`))
+		So(dump, ShouldEndWith, "COMMIT;\n")
+
+		// rebuild database from sql dump
+		rdb, err := sql.Open("sqlite3", filepath.Join(dir, "dst.db3"))
+		So(err, ShouldBeNil)
+		defer rdb.Close()
+		_, err = rdb.Exec(dump)
+		So(err, ShouldBeNil)
+		var (
+			name  string
+			score float64
+			data  []byte
+		)
+		err = rdb.QueryRow(`SELECT "name", "score", "data" FROM "test" WHERE "id" = 1`).Scan(&name, &score, &data)
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, "it's")
+		So(score, ShouldEqual, 1.5)
+		So(data, ShouldResemble, []byte{1, 2})
+		err = rdb.QueryRow(`SELECT count(1) FROM "names"`).Scan(&score)
+		So(err, ShouldBeNil)
+		So(score, ShouldEqual, 2)
+
+		buf.Reset()
+		err = dumpDatabase(context.Background(), db, &buf, DumpCSV, point)
+		So(err, ShouldBeNil)
+		sections := strings.Split(buf.String(), "\n\n")
+		So(sections, ShouldHaveLength, 2)
+		So(sections[0], ShouldEqual, "# table: empty\nv")
+		So(sections[1], ShouldStartWith, "# table: test\n")
+		records, err := csv.NewReader(strings.NewReader(
+			strings.TrimPrefix(sections[1], "# table: test\n"))).ReadAll()
+		So(err, ShouldBeNil)
+		So(records, ShouldResemble, [][]string{
+			{"id", "name", "score", "data"},
+			{"1", "it's", "1.5", "\x01\x02"},
+			{"2", "", "", ""},
+		})
+
+		err = dumpDatabase(context.Background(), db, &buf, DumpFormat(3), point)
+		So(err, ShouldNotBeNil)
+		_, err = DumpAt(context.Background(), "db", -1, &buf, DumpFormat(3))
+		So(err, ShouldNotBeNil)
+		So(DumpSQL.String(), ShouldEqual, "SQL")
+		So(DumpCSV.String(), ShouldEqual, "CSV")
+		So(DumpFormat(3).String(), ShouldEqual, "Unknown")
+	})
+
+	Convey("test sql literal", t, func() {
+		So(sqlLiteral(true), ShouldEqual, "1")
+		So(sqlLiteral(false), ShouldEqual, "0")
+		So(sqlLiteral(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)), ShouldEqual, "'2019-01-02 03:04:05+00:00'")
+		So(csvField(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)), ShouldEqual, "2019-01-02 03:04:05+00:00")
+		So(csvField(int64(-3)), ShouldEqual, "-3")
+		So(csvField(uint8(3)), ShouldEqual, "3")
+	})
+}
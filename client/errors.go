@@ -32,4 +32,16 @@ var (
 	ErrInvalidProfile = errors.New("invalid sqlchain profile")
 	// ErrNoSuchTokenBalance indicates no such token balance in chain.
 	ErrNoSuchTokenBalance = errors.New("no such token balance")
+	// ErrNoResponse indicates the receipt has no query response to verify.
+	ErrNoResponse = errors.New("no response in receipt")
+	// ErrUntrustedProducer indicates the block in query proof is not produced by the database peers.
+	ErrUntrustedProducer = errors.New("block producer is not a database peer")
+	// ErrInvalidCrossTx defines invalid cross database transaction branches.
+	ErrInvalidCrossTx = errors.New("cross database transaction requires distinct databases")
+	// ErrEmptyBatch indicates the batch to execute has no statements.
+	ErrEmptyBatch = errors.New("empty batch")
+	// ErrInvalidInsert indicates the multi-row insert statement could not be built.
+	ErrInvalidInsert = errors.New("invalid multi-row insert")
+	// ErrNoSuchSavepoint indicates the savepoint to rollback to or release does not exist.
+	ErrNoSuchSavepoint = errors.New("no such savepoint")
 )
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+const (
+	// DefaultImportChunkRows defines the default rows imported in one transaction.
+	DefaultImportChunkRows = 1000
+)
+
+// RowReader defines a source of rows to import, such as a CSV decoder.
+type RowReader interface {
+	// Columns returns the column names of rows.
+	Columns() ([]string, error)
+	// Read returns the next row, io.EOF is returned after the last row.
+	Read() ([]interface{}, error)
+}
+
+// ImportProgress defines the progress of an import.
+type ImportProgress struct {
+	// Rows is the count of rows committed, including the skipped rows of a resumed import.
+	Rows int64
+	// Chunks is the count of transactions committed in this import.
+	Chunks int
+}
+
+// ImportOptions defines the options of an import.
+type ImportOptions struct {
+	// Columns overrides the column names of the source.
+	Columns []string
+	// ChunkRows is the rows imported in one transaction, DefaultImportChunkRows is used if not set.
+	ChunkRows int
+	// SkipRows skips the first rows of the source, set it to the Rows of the last progress to
+	// resume a failed import.
+	SkipRows int64
+	// Progress is called after each chunk is committed.
+	Progress func(ImportProgress)
+
+	// Comma is the field delimiter of CSV, ',' is used if not set.
+	Comma rune
+	// NoHeader indicates the CSV has no header row, Columns must be set in this case.
+	NoHeader bool
+	// NullString is the CSV field value imported as NULL if set.
+	NullString *string
+}
+
+// ImportCSV imports the CSV records into the table of the database, the dsn could be a database
+// id. See ImportRows for the import process.
+func ImportCSV(ctx context.Context, dsn string, table string, r io.Reader, opts *ImportOptions) (
+	progress ImportProgress, err error,
+) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	return ImportRows(ctx, dsn, table, newCSVRowReader(r, opts), opts)
+}
+
+// ImportRows streams the rows into the table of the database. Rows are imported in chunks, each
+// chunk is sent as multi-row insert statements in one request and committed in one transaction.
+// The progress of committed rows is returned even if the import fails, so it could be resumed
+// by setting opts.SkipRows.
+func ImportRows(ctx context.Context, dsn string, table string, r RowReader, opts *ImportOptions) (
+	progress ImportProgress, err error,
+) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	chunkRows := opts.ChunkRows
+	if chunkRows <= 0 {
+		chunkRows = DefaultImportChunkRows
+	}
+
+	columns := opts.Columns
+	if len(columns) == 0 {
+		if columns, err = r.Columns(); err != nil {
+			err = errors.Wrap(err, "read columns failed")
+			return
+		}
+	}
+	if len(columns) == 0 {
+		err = errors.Wrap(ErrInvalidInsert, "no columns to import")
+		return
+	}
+
+	// skip imported rows
+	for ; progress.Rows < opts.SkipRows; progress.Rows++ {
+		if _, err = r.Read(); err == io.EOF {
+			// all rows are imported
+			err = nil
+			return
+		} else if err != nil {
+			err = errors.Wrapf(err, "skip row %d failed", progress.Rows)
+			return
+		}
+	}
+
+	var db *sql.DB
+	if db, err = sql.Open(DBScheme, dsn); err != nil {
+		return
+	}
+	defer db.Close()
+
+	var (
+		rows = make([][]interface{}, 0, chunkRows)
+		eof  bool
+	)
+	for !eof {
+		rows = rows[:0]
+		for len(rows) < chunkRows {
+			var row []interface{}
+			if row, err = r.Read(); err == io.EOF {
+				err = nil
+				eof = true
+				break
+			} else if err != nil {
+				err = errors.Wrapf(err, "read row %d failed", progress.Rows+int64(len(rows)))
+				return
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		b := NewBatch()
+		if err = b.AddInsert(table, columns, rows...); err != nil {
+			err = errors.Wrapf(err, "build chunk at row %d failed", progress.Rows)
+			return
+		}
+		if _, err = ExecBatch(ctx, db, b); err != nil {
+			err = errors.Wrapf(err, "import chunk at row %d failed", progress.Rows)
+			return
+		}
+
+		progress.Rows += int64(len(rows))
+		progress.Chunks++
+		log.WithFields(log.Fields{
+			"table":  table,
+			"rows":   progress.Rows,
+			"chunks": progress.Chunks,
+		}).Debug("imported chunk")
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	return
+}
+
+// csvRowReader reads rows from CSV records.
+type csvRowReader struct {
+	r          *csv.Reader
+	noHeader   bool
+	nullString *string
+}
+
+func newCSVRowReader(r io.Reader, opts *ImportOptions) *csvRowReader {
+	cr := csv.NewReader(r)
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	cr.ReuseRecord = true
+	return &csvRowReader{
+		r:          cr,
+		noHeader:   opts.NoHeader,
+		nullString: opts.NullString,
+	}
+}
+
+// Columns implements RowReader.Columns, the header row is consumed.
+func (r *csvRowReader) Columns() (columns []string, err error) {
+	if r.noHeader {
+		return nil, errors.Wrap(ErrInvalidInsert, "columns are required for csv without header")
+	}
+	var record []string
+	if record, err = r.r.Read(); err != nil {
+		return
+	}
+	columns = append([]string(nil), record...)
+	r.noHeader = true
+	return
+}
+
+// Read implements RowReader.Read.
+func (r *csvRowReader) Read() (row []interface{}, err error) {
+	if !r.noHeader {
+		// skip header if columns are overridden
+		if _, err = r.Columns(); err != nil {
+			return
+		}
+	}
+	var record []string
+	if record, err = r.r.Read(); err != nil {
+		return
+	}
+	row = make([]interface{}, len(record))
+	for i, v := range record {
+		if r.nullString != nil && v == *r.nullString {
+			continue
+		}
+		row[i] = v
+	}
+	return
+}
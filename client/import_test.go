@@ -0,0 +1,143 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingReader fails after n bytes are read.
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *failingReader) Read(p []byte) (n int, err error) {
+	if r.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err = r.r.Read(p)
+	r.n -= n
+	return
+}
+
+func TestImportCSV(t *testing.T) {
+	Convey("test csv row reader", t, func() {
+		null := "NULL"
+		r := newCSVRowReader(strings.NewReader("a;b\n1;NULL\n"), &ImportOptions{Comma: ';', NullString: &null})
+		columns, err := r.Columns()
+		So(err, ShouldBeNil)
+		So(columns, ShouldResemble, []string{"a", "b"})
+		row, err := r.Read()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, []interface{}{"1", nil})
+		_, err = r.Read()
+		So(err, ShouldEqual, io.EOF)
+
+		// header is skipped if columns are overridden
+		r = newCSVRowReader(strings.NewReader("a,b\n1,2\n"), &ImportOptions{})
+		row, err = r.Read()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, []interface{}{"1", "2"})
+
+		r = newCSVRowReader(strings.NewReader("1,2\n"), &ImportOptions{NoHeader: true})
+		_, err = r.Columns()
+		So(err, ShouldNotBeNil)
+		row, err = r.Read()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, []interface{}{"1", "2"})
+	})
+
+	Convey("test import csv", t, func() {
+		stopTestService, _, err := startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		db, err := sql.Open("covenantsql", "covenantsql://db")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Exec("create table test (id int primary key, name text)")
+		So(err, ShouldBeNil)
+
+		var sb strings.Builder
+		sb.WriteString("id,name\n")
+		for i := 0; i < 1200; i++ {
+			fmt.Fprintf(&sb, "%d,name%d\n", i, i)
+		}
+		data := sb.String()
+
+		// import fails in the middle
+		var progresses []ImportProgress
+		opts := &ImportOptions{
+			ChunkRows: 500,
+			Progress: func(p ImportProgress) {
+				progresses = append(progresses, p)
+			},
+		}
+		progress, err := ImportCSV(context.Background(), "db", "test",
+			&failingReader{r: strings.NewReader(data), n: len(data) / 2}, opts)
+		So(err, ShouldNotBeNil)
+		So(progress, ShouldResemble, ImportProgress{Rows: 500, Chunks: 1})
+		So(progresses, ShouldResemble, []ImportProgress{{Rows: 500, Chunks: 1}})
+
+		// resume import
+		progresses = nil
+		opts.SkipRows = progress.Rows
+		progress, err = ImportCSV(context.Background(), "db", "test", strings.NewReader(data), opts)
+		So(err, ShouldBeNil)
+		So(progress, ShouldResemble, ImportProgress{Rows: 1200, Chunks: 2})
+		So(progresses, ShouldResemble, []ImportProgress{{Rows: 1000, Chunks: 1}, {Rows: 1200, Chunks: 2}})
+
+		var count, sum int
+		err = db.QueryRow("select count(1), sum(id) from test").Scan(&count, &sum)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1200)
+		So(sum, ShouldEqual, 1199*1200/2)
+		var name string
+		err = db.QueryRow("select name from test where id = 1000").Scan(&name)
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, "name1000")
+
+		// nothing left to import
+		opts.SkipRows = 1200
+		progress, err = ImportCSV(context.Background(), "db", "test", strings.NewReader(data), opts)
+		So(err, ShouldBeNil)
+		So(progress, ShouldResemble, ImportProgress{Rows: 1200})
+
+		// duplicated rows fail the chunk
+		opts.SkipRows = 0
+		progress, err = ImportCSV(context.Background(), "db", "test", strings.NewReader(data), opts)
+		So(err, ShouldNotBeNil)
+		So(progress.Rows, ShouldEqual, 0)
+
+		_, err = ImportCSV(context.Background(), "db", "test", strings.NewReader(""), nil)
+		So(err, ShouldNotBeNil)
+		_, err = ImportRows(context.Background(), "db", "test",
+			newCSVRowReader(strings.NewReader("1,2\n"), &ImportOptions{NoHeader: true}), nil)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+var (
+	ctxReadPreferenceKey = "_cql_read_preference"
+)
+
+const (
+	// rttWeight is the weight of new sample in the moving average of peer round-trip time.
+	rttWeight = 0.2
+	// rttFailurePenalty is the round-trip time sample of a failed query.
+	rttFailurePenalty = 5 * time.Second
+)
+
+// ReadMode defines the replica selection mode of read queries.
+type ReadMode int
+
+const (
+	// Primary reads from the leader node.
+	Primary ReadMode = iota
+	// Nearest reads from the peer with the lowest round-trip time, which may be the leader or
+	// a follower.
+	Nearest
+)
+
+// String implements fmt.Stringer.
+func (m ReadMode) String() string {
+	switch m {
+	case Primary:
+		return "Primary"
+	case Nearest:
+		return "Nearest"
+	default:
+		return "Unknown"
+	}
+}
+
+// ReadPreference defines how to choose the replica of a read query.
+type ReadPreference struct {
+	Mode ReadMode
+	// MaxStaleness bounds the staleness of reads served by a follower, 0 means not bounded.
+	// A follower read beyond the bound is retried on the leader.
+	MaxStaleness time.Duration
+}
+
+// ReadPreferenceOption defines an option of read preference.
+type ReadPreferenceOption func(*ReadPreference)
+
+// MaxStaleness returns an option which bounds the staleness of follower reads.
+func MaxStaleness(d time.Duration) ReadPreferenceOption {
+	return func(p *ReadPreference) {
+		p.MaxStaleness = d
+	}
+}
+
+// WithReadPreference returns a context who holds the read preference, read queries executed
+// with this context choose replica by the preference instead of the dsn options.
+func WithReadPreference(ctx context.Context, mode ReadMode, opts ...ReadPreferenceOption) context.Context {
+	pref := &ReadPreference{Mode: mode}
+	for _, opt := range opts {
+		opt(pref)
+	}
+	return context.WithValue(ctx, &ctxReadPreferenceKey, pref)
+}
+
+// GetReadPreference tries to get *ReadPreference from context.
+func GetReadPreference(ctx context.Context) (pref *ReadPreference, ok bool) {
+	pref, ok = ctx.Value(&ctxReadPreferenceKey).(*ReadPreference)
+	return
+}
+
+// staleMillis returns the staleness bound in milliseconds, a positive bound less than 1ms is
+// rounded up.
+func (p *ReadPreference) staleMillis() int64 {
+	if p.MaxStaleness <= 0 {
+		return 0
+	}
+	if ms := int64(p.MaxStaleness / time.Millisecond); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+// readReplica returns the peer connection chosen by read preference.
+func (c *conn) readReplica(pref *ReadPreference) *pconn {
+	if pref.Mode != Nearest {
+		return c.leader
+	}
+
+	peers, err := cacheGetPeers(c.dbID, c.privKey)
+	if err != nil {
+		log.WithField("db", c.dbID).WithError(err).Debug("get peers failed, read from leader")
+		return c.leader
+	}
+	node := c.nearestNode(peers.Servers)
+	if node == "" {
+		return c.leader
+	}
+	return c.replica(node)
+}
+
+// nearestNode returns the node with the lowest round-trip time, the nodes which are never
+// queried are returned first to measure their round-trip time.
+func (c *conn) nearestNode(nodes []proto.NodeID) (nearest proto.NodeID) {
+	var minRTT time.Duration
+	for _, node := range nodes {
+		rtt, ok := c.rtt[node]
+		if !ok {
+			return node
+		}
+		if nearest == "" || rtt < minRTT {
+			nearest, minRTT = node, rtt
+		}
+	}
+	return
+}
+
+// replica returns the peer connection to node, which is created on first use.
+func (c *conn) replica(node proto.NodeID) *pconn {
+	if c.leader != nil && node == c.leaderID {
+		return c.leader
+	}
+	if c.follower != nil && node == c.follower.node {
+		return c.follower
+	}
+	if pc, ok := c.replicas[node]; ok {
+		return pc
+	}
+	pc := c.newPeerConn(node)
+	if err := pc.startAckWorkers(); err != nil {
+		log.WithField("node", node).WithError(err).Warning("start replica ack workers failed")
+		return c.leader
+	}
+	if c.replicas == nil {
+		c.replicas = make(map[proto.NodeID]*pconn)
+	}
+	c.replicas[node] = pc
+	return pc
+}
+
+// recordRTT updates the moving average of round-trip time to node.
+func (c *conn) recordRTT(node proto.NodeID, sample time.Duration) {
+	if node == "" {
+		return
+	}
+	if c.rtt == nil {
+		c.rtt = make(map[proto.NodeID]time.Duration)
+	}
+	if rtt, ok := c.rtt[node]; ok {
+		sample = time.Duration(float64(rtt)*(1-rttWeight) + float64(sample)*rttWeight)
+	}
+	c.rtt[node] = sample
+}
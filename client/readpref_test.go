@@ -0,0 +1,118 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestReadPreference(t *testing.T) {
+	Convey("test read preference context", t, func() {
+		_, ok := GetReadPreference(context.Background())
+		So(ok, ShouldBeFalse)
+
+		ctx := WithReadPreference(context.Background(), Nearest, MaxStaleness(3*time.Second))
+		pref, ok := GetReadPreference(ctx)
+		So(ok, ShouldBeTrue)
+		So(pref, ShouldResemble, &ReadPreference{Mode: Nearest, MaxStaleness: 3 * time.Second})
+		So(pref.staleMillis(), ShouldEqual, 3000)
+		So(pref.Mode.String(), ShouldEqual, "Nearest")
+
+		pref, ok = GetReadPreference(WithReadPreference(ctx, Primary))
+		So(ok, ShouldBeTrue)
+		So(pref, ShouldResemble, &ReadPreference{Mode: Primary})
+		So(pref.staleMillis(), ShouldEqual, 0)
+		So(MaxStaleness(time.Microsecond), ShouldNotBeNil)
+		pref = &ReadPreference{MaxStaleness: time.Microsecond}
+		So(pref.staleMillis(), ShouldEqual, 1)
+		So(ReadMode(-1).String(), ShouldEqual, "Unknown")
+	})
+
+	Convey("test nearest node selection", t, func() {
+		var (
+			c     = &conn{}
+			nodes = []proto.NodeID{"a", "b", "c"}
+		)
+		So(c.nearestNode(nil), ShouldBeEmpty)
+		// nodes without round-trip time are probed first
+		So(c.nearestNode(nodes), ShouldEqual, "a")
+		c.recordRTT("a", 30*time.Millisecond)
+		So(c.nearestNode(nodes), ShouldEqual, "b")
+		c.recordRTT("b", 10*time.Millisecond)
+		c.recordRTT("c", 20*time.Millisecond)
+		So(c.nearestNode(nodes), ShouldEqual, "b")
+
+		// moving average follows the latency changes
+		for i := 0; i < 10; i++ {
+			c.recordRTT("b", rttFailurePenalty)
+		}
+		So(c.nearestNode(nodes), ShouldEqual, "c")
+		c.recordRTT("", time.Millisecond)
+		So(c.rtt, ShouldHaveLength, 3)
+
+		// replica connections are created on first use and closed with connection
+		pc := c.replica("a")
+		So(pc, ShouldNotBeNil)
+		So(pc.node, ShouldEqual, "a")
+		So(c.replica("a"), ShouldEqual, pc)
+		So(c.Close(), ShouldBeNil)
+	})
+
+	Convey("test query with read preference", t, func() {
+		stopTestService, _, err := startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		db, err := sql.Open("covenantsql", "covenantsql://db")
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		_, err = db.Exec("create table test (test int)")
+		So(err, ShouldBeNil)
+		_, err = db.Exec("insert into test values (1)")
+		So(err, ShouldBeNil)
+
+		for _, ctx := range []context.Context{
+			WithReadPreference(context.Background(), Nearest, MaxStaleness(3*time.Second)),
+			WithReadPreference(context.Background(), Nearest),
+			WithReadPreference(context.Background(), Primary),
+		} {
+			var result int
+			err = db.QueryRowContext(ctx, "select * from test").Scan(&result)
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, 1)
+		}
+
+		sc, err := db.Conn(context.Background())
+		So(err, ShouldBeNil)
+		defer sc.Close()
+		err = sc.Raw(func(dc interface{}) error {
+			c := dc.(*conn)
+			So(c.rtt, ShouldContainKey, c.leaderID)
+			So(c.replica(c.leaderID), ShouldEqual, c.leader)
+			return nil
+		})
+		So(err, ShouldBeNil)
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"sync/atomic"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/types"
 )
 
 var (
@@ -30,6 +31,9 @@ var (
 // Receipt defines a receipt of CovenantSQL query request.
 type Receipt struct {
 	RequestHash hash.Hash
+	// Response is the query response, it's set after the query succeeds and can be verified by
+	// VerifyReceipt once the response is packed in a block.
+	Response *types.Response
 }
 
 // WithReceipt returns a context who holds a *atomic.Value. A *Receipt will be set to this value
@@ -69,3 +69,50 @@ func (r *rows) Next(dest []driver.Value) error {
 func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
 	return strings.ToUpper(r.types[index])
 }
+
+// streamRows reads the rows of a streamed query response on demand.
+type streamRows struct {
+	columns []string
+	types   []string
+	dec     interface{ Decode(interface{}) error }
+	stream  io.Closer
+}
+
+func newStreamRows(header *types.ResponseStreamHeader, dec interface{ Decode(interface{}) error },
+	stream io.Closer) *streamRows {
+	return &streamRows{
+		columns: header.Columns,
+		types:   header.DeclTypes,
+		dec:     dec,
+		stream:  stream,
+	}
+}
+
+// Columns implements driver.Rows.Columns method.
+func (r *streamRows) Columns() []string {
+	return r.columns[:]
+}
+
+// Close implements driver.Rows.Close method.
+func (r *streamRows) Close() error {
+	return r.stream.Close()
+}
+
+// Next implements driver.Rows.Next method.
+func (r *streamRows) Next(dest []driver.Value) (err error) {
+	var row types.ResponseRow
+	if err = r.dec.Decode(&row); err != nil {
+		return
+	}
+
+	for i, d := range row.Values {
+		dest[i] = d
+	}
+
+	return
+}
+
+// ColumnTypeDatabaseTypeName implements driver.RowsColumnTypeDatabaseTypeName.ColumnTypeDatabaseTypeName method.
+func (r *streamRows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(r.types[index])
+}
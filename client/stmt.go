@@ -20,12 +20,20 @@ import (
 	"context"
 	"database/sql/driver"
 	"sync/atomic"
+
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
 type stmt struct {
 	c       *conn
 	closed  int32
 	pattern string
+
+	// statement ids prepared on the peers, zero if not prepared remotely
+	leaderID   uint64
+	followerID uint64
 }
 
 func newStmt(c *conn, query string) (s *stmt) {
@@ -33,6 +41,55 @@ func newStmt(c *conn, query string) (s *stmt) {
 	return
 }
 
+// prepare prepares the statement on the connected peers, so the peers skip the query parsing
+// of the following executions. Remote preparation is an optimization only, the statement is
+// still usable if the peer refuses to prepare it.
+func (s *stmt) prepare() {
+	if s.c.mirror {
+		return
+	}
+	if s.c.leader != nil {
+		s.leaderID = s.c.leader.prepare(s.pattern)
+	}
+	if s.c.follower != nil {
+		s.followerID = s.c.follower.prepare(s.pattern)
+	}
+}
+
+func (c *pconn) prepare(pattern string) (id uint64) {
+	var (
+		req = &types.PrepareReq{
+			DatabaseID: c.parent.dbID,
+			Pattern:    pattern,
+		}
+		resp = &types.PrepareResp{}
+	)
+	if err := c.pCaller.Call(route.DBSPrepare.String(), req, resp); err != nil {
+		log.WithFields(log.Fields{
+			"target":  c.pCaller.Target(),
+			"pattern": pattern,
+		}).WithError(err).Debug("prepare statement remotely failed")
+		return
+	}
+	return resp.StatementID
+}
+
+func (c *pconn) closeStmt(id uint64) {
+	var (
+		req = &types.CloseStmtReq{
+			DatabaseID:  c.parent.dbID,
+			StatementID: id,
+		}
+		resp = &types.CloseStmtResp{}
+	)
+	if err := c.pCaller.Call(route.DBSCloseStmt.String(), req, resp); err != nil {
+		log.WithFields(log.Fields{
+			"target":    c.pCaller.Target(),
+			"statement": id,
+		}).WithError(err).Debug("close statement remotely failed")
+	}
+}
+
 // Query executes a query that may return rows, such as SELECT.
 func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
 	// convert bind parameters to named bind parameters.
@@ -68,6 +125,14 @@ func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (drive
 // Close closes the statement.
 func (s *stmt) Close() error {
 	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		if s.c != nil && atomic.LoadInt32(&s.c.closed) == 0 {
+			if s.leaderID != 0 {
+				s.c.leader.closeStmt(s.leaderID)
+			}
+			if s.followerID != 0 {
+				s.c.follower.closeStmt(s.followerID)
+			}
+		}
 		s.c = nil
 	}
 	return nil
@@ -44,6 +44,21 @@ func TestStmt(t *testing.T) {
 		_, err = db.Exec("insert into test values (1)")
 		So(err, ShouldBeNil)
 
+		// statement prepared remotely
+		var sc *sql.Conn
+		sc, err = db.Conn(context.Background())
+		So(err, ShouldBeNil)
+		err = sc.Raw(func(driverConn interface{}) error {
+			ds, err := driverConn.(*conn).PrepareContext(context.Background(), "select * from test")
+			if err != nil {
+				return err
+			}
+			So(ds.(*stmt).leaderID, ShouldNotEqual, uint64(0))
+			return ds.Close()
+		})
+		So(err, ShouldBeNil)
+		So(sc.Close(), ShouldBeNil)
+
 		var stmt *sql.Stmt
 		stmt, err = db.Prepare("select count(1) as cnt from test where test = ?")
 		So(err, ShouldBeNil)
@@ -21,10 +21,120 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"regexp"
+	"strings"
 
 	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
+// savepointOp defines the savepoint statement types.
+type savepointOp int
+
+const (
+	opSavepoint savepointOp = iota
+	opRelease
+	opRollbackTo
+)
+
+var savepointRegexp = regexp.MustCompile(
+	`(?is)^\s*(SAVEPOINT|RELEASE(?:\s+SAVEPOINT)?|ROLLBACK(?:\s+TRANSACTION)?\s+TO(?:\s+SAVEPOINT)?)` +
+		`\s+("(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_]*)\s*;?\s*$`)
+
+// savepoint marks the position of buffered queries in transaction.
+type savepoint struct {
+	name string
+	pos  int
+}
+
+// Tx is an explicit transaction of a database. The statements are buffered by the client and
+// sent to the database in one request on Commit, so they are applied atomically. Savepoints
+// mark positions of the buffered statements, which could be rolled back to without aborting
+// the whole transaction.
+type Tx struct {
+	*sql.Tx
+}
+
+// BeginTx starts an explicit transaction on db.
+func BeginTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (tx *Tx, err error) {
+	var stx *sql.Tx
+	if stx, err = db.BeginTx(ctx, opts); err != nil {
+		return
+	}
+	tx = &Tx{Tx: stx}
+	return
+}
+
+// Savepoint creates a savepoint of the transaction, the name could be reused and the latest
+// one is referred by RollbackTo and Release.
+func (tx *Tx) Savepoint(ctx context.Context, name string) (err error) {
+	_, err = tx.ExecContext(ctx, "SAVEPOINT "+quoteIdentifier(name))
+	return
+}
+
+// RollbackTo discards the statements after the savepoint, the savepoint is kept and could be
+// rolled back to again.
+func (tx *Tx) RollbackTo(ctx context.Context, name string) (err error) {
+	_, err = tx.ExecContext(ctx, "ROLLBACK TO "+quoteIdentifier(name))
+	return
+}
+
+// Release removes the savepoint and the savepoints created after it, the statements are kept.
+func (tx *Tx) Release(ctx context.Context, name string) (err error) {
+	_, err = tx.ExecContext(ctx, "RELEASE "+quoteIdentifier(name))
+	return
+}
+
+// parseSavepoint parses the SAVEPOINT, RELEASE and ROLLBACK TO statements.
+func parseSavepoint(query string) (op savepointOp, name string, ok bool) {
+	m := savepointRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return
+	}
+	switch strings.ToUpper(m[1][:2]) {
+	case "SA":
+		op = opSavepoint
+	case "RE":
+		op = opRelease
+	default:
+		op = opRollbackTo
+	}
+	name = m[2]
+	if strings.HasPrefix(name, `"`) {
+		name = strings.Replace(name[1:len(name)-1], `""`, `"`, -1)
+	}
+	ok = true
+	return
+}
+
+// applySavepoint applies the savepoint statement to the buffered queries of transaction.
+func (c *conn) applySavepoint(op savepointOp, name string) (err error) {
+	if op == opSavepoint {
+		c.savepoints = append(c.savepoints, savepoint{name: name, pos: len(c.queries)})
+		return
+	}
+
+	var i = len(c.savepoints) - 1
+	for ; i >= 0 && !strings.EqualFold(c.savepoints[i].name, name); i-- {
+	}
+	if i < 0 {
+		return errors.Wrapf(ErrNoSuchSavepoint, "savepoint %s", name)
+	}
+
+	if op == opRollbackTo {
+		log.WithFields(log.Fields{
+			"savepoint": name,
+			"discarded": len(c.queries) - c.savepoints[i].pos,
+		}).Debug("rollback to savepoint")
+		c.queries = c.queries[:c.savepoints[i].pos]
+		c.savepoints = c.savepoints[:i+1]
+	} else {
+		c.savepoints = c.savepoints[:i]
+	}
+	return
+}
+
 // ExecuteTx starts a transaction, and runs fn in it.
 func ExecuteTx(
 	ctx context.Context, db *sql.DB, txopts *sql.TxOptions, fn func(*sql.Tx) error,
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSavepoint(t *testing.T) {
+	Convey("test parse savepoint statements", t, func() {
+		for _, c := range []struct {
+			query string
+			op    savepointOp
+			name  string
+			ok    bool
+		}{
+			{"SAVEPOINT sp1", opSavepoint, "sp1", true},
+			{" savepoint \"my \"\"sp\"\"\" ; ", opSavepoint, `my "sp"`, true},
+			{"RELEASE sp1", opRelease, "sp1", true},
+			{"release savepoint sp1;", opRelease, "sp1", true},
+			{"ROLLBACK TO sp1", opRollbackTo, "sp1", true},
+			{"rollback transaction to savepoint sp1", opRollbackTo, "sp1", true},
+			{"ROLLBACK", 0, "", false},
+			{"SAVEPOINT", 0, "", false},
+			{"SAVEPOINT a b", 0, "", false},
+			{"INSERT INTO savepoint VALUES (1)", 0, "", false},
+		} {
+			op, name, ok := parseSavepoint(c.query)
+			So(ok, ShouldEqual, c.ok)
+			So(op, ShouldEqual, c.op)
+			So(name, ShouldEqual, c.name)
+		}
+	})
+}
+
+func TestTxSavepoint(t *testing.T) {
+	Convey("test transaction with savepoints", t, func() {
+		stopTestService, _, err := startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		db, err := sql.Open("covenantsql", "covenantsql://db")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = db.Exec("create table test (test int)")
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		queryValues := func() (values []int) {
+			rows, err := db.Query("select test from test order by test")
+			So(err, ShouldBeNil)
+			defer rows.Close()
+			for rows.Next() {
+				var v int
+				So(rows.Scan(&v), ShouldBeNil)
+				values = append(values, v)
+			}
+			return
+		}
+
+		tx, err := BeginTx(ctx, db, nil)
+		So(err, ShouldBeNil)
+		_, err = tx.ExecContext(ctx, "insert into test values (1)")
+		So(err, ShouldBeNil)
+		So(tx.Savepoint(ctx, "a"), ShouldBeNil)
+		_, err = tx.ExecContext(ctx, "insert into test values (2)")
+		So(err, ShouldBeNil)
+		So(tx.Savepoint(ctx, "b"), ShouldBeNil)
+		_, err = tx.ExecContext(ctx, "insert into test values (3)")
+		So(err, ShouldBeNil)
+
+		// rollback to a discards the later savepoint b
+		So(tx.RollbackTo(ctx, "A"), ShouldBeNil)
+		err = tx.RollbackTo(ctx, "b")
+		So(errors.Cause(err), ShouldEqual, ErrNoSuchSavepoint)
+		// savepoint a is kept after rollback
+		_, err = tx.ExecContext(ctx, "insert into test values (4)")
+		So(err, ShouldBeNil)
+		So(tx.RollbackTo(ctx, "a"), ShouldBeNil)
+		_, err = tx.ExecContext(ctx, "insert into test values (5)")
+		So(err, ShouldBeNil)
+		So(tx.Release(ctx, "a"), ShouldBeNil)
+		err = tx.Release(ctx, "a")
+		So(errors.Cause(err), ShouldEqual, ErrNoSuchSavepoint)
+		So(tx.Commit(), ShouldBeNil)
+		So(queryValues(), ShouldResemble, []int{1, 5})
+
+		// savepoints are not shared between transactions
+		tx, err = BeginTx(ctx, db, nil)
+		So(err, ShouldBeNil)
+		err = tx.RollbackTo(ctx, "a")
+		So(errors.Cause(err), ShouldEqual, ErrNoSuchSavepoint)
+		// plain sql statements are supported too
+		_, err = tx.ExecContext(ctx, "SAVEPOINT sp")
+		So(err, ShouldBeNil)
+		_, err = tx.ExecContext(ctx, "insert into test values (6)")
+		So(err, ShouldBeNil)
+		_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT sp")
+		So(err, ShouldBeNil)
+		So(tx.Commit(), ShouldBeNil)
+		So(queryValues(), ShouldResemble, []int{1, 5})
+	})
+}
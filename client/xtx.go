@@ -0,0 +1,219 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+const (
+	// DefaultCrossTxTimeout defines the default timeout of prepared cross database transaction.
+	DefaultCrossTxTimeout = 10 * time.Second
+
+	// CrossTxTable defines the table recording the cross database transactions in each database.
+	CrossTxTable = "__cql_xtx"
+)
+
+var (
+	crossTxCreateTable = `CREATE TABLE IF NOT EXISTS "` + CrossTxTable + `" (
+	"xid" TEXT PRIMARY KEY, "participants" TEXT, "state" TEXT, "deadline" INTEGER)`
+	crossTxPrepare  = `INSERT INTO "` + CrossTxTable + `" VALUES (?, ?, 'prepared', ?)`
+	crossTxCommit   = `UPDATE "` + CrossTxTable + `" SET "state" = 'committed' WHERE "xid" = ?`
+	crossTxRollback = `UPDATE "` + CrossTxTable + `" SET "state" = 'aborted' WHERE "xid" = ?`
+)
+
+// CrossTxBranch defines the write queries of a cross database transaction on a database.
+type CrossTxBranch struct {
+	DSN     string
+	Queries []types.Query
+}
+
+type crossTxParticipant struct {
+	dbID   proto.DatabaseID
+	leader proto.NodeID
+	req    *types.PrepareTxReq
+}
+
+// ExecuteCrossTx executes the branches atomically on the databases by two-phase commit. The
+// prepare, commit and rollback records of the transaction are written to the table CrossTxTable
+// of each database, so they are anchored in the chains of the databases. A prepared branch is
+// rolled back automatically by the database if not finished within timeout.
+//
+// The databases must be owned by the current account, and the other writes of the databases
+// are blocked while the branches are prepared.
+func ExecuteCrossTx(ctx context.Context, timeout time.Duration, branches ...*CrossTxBranch) (xid string, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+	if len(branches) < 2 {
+		err = ErrInvalidCrossTx
+		return
+	}
+	if timeout <= 0 {
+		timeout = DefaultCrossTxTimeout
+	}
+
+	var (
+		privKey      *asymmetric.PrivateKey
+		nodeID       proto.NodeID
+		participants = make([]*crossTxParticipant, len(branches))
+		dbIDs        = make([]string, len(branches))
+		seen         = make(map[proto.DatabaseID]bool)
+	)
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if nodeID, err = kms.GetLocalNodeID(); err != nil {
+		return
+	}
+	for i, b := range branches {
+		var cfg *Config
+		if cfg, err = ParseDSN(b.DSN); err != nil {
+			return
+		}
+		dbID := proto.DatabaseID(cfg.DatabaseID)
+		if seen[dbID] {
+			err = errors.Wrapf(ErrInvalidCrossTx, "duplicate database %s", dbID)
+			return
+		}
+		seen[dbID] = true
+		dbIDs[i] = string(dbID)
+
+		var peers *proto.Peers
+		if peers, err = cacheGetPeers(dbID, privKey); err != nil {
+			return
+		}
+		participants[i] = &crossTxParticipant{dbID: dbID, leader: peers.Leader}
+	}
+
+	xid = fmt.Sprintf("%016x%016x", time.Now().UnixNano(), randSource.Uint64())
+	var (
+		deadline = time.Now().Add(timeout).UnixNano()
+		members  = fmt.Sprint(dbIDs)
+	)
+	for i, p := range participants {
+		p.req = &types.PrepareTxReq{
+			DatabaseID: p.dbID,
+			XID:        xid,
+			Timeout:    timeout,
+		}
+		commitQueries := append(append([]types.Query{}, branches[i].Queries...), types.Query{
+			Pattern: crossTxCommit, Args: []types.NamedArg{{Value: xid}},
+		})
+		if err = buildCrossTxRecords(p.req, nodeID, privKey, [][]types.Query{
+			{
+				{Pattern: crossTxCreateTable},
+				{Pattern: crossTxPrepare, Args: []types.NamedArg{{Value: xid}, {Value: members}, {Value: deadline}}},
+			},
+			commitQueries,
+			{{Pattern: crossTxRollback, Args: []types.NamedArg{{Value: xid}}}},
+		}); err != nil {
+			return
+		}
+	}
+
+	// phase 1: prepare all the branches, rollback the prepared ones on failure
+	caller := rpc.NewCaller()
+	for i, p := range participants {
+		resp := &types.PrepareTxResp{}
+		if err = caller.CallNodeWithContext(ctx, p.leader, route.DBSPrepareTx.String(), p.req, resp); err != nil {
+			err = errors.Wrapf(err, "prepare transaction on database %s failed", p.dbID)
+			finishCrossTx(caller, xid, participants[:i], false)
+			return
+		}
+	}
+
+	// phase 2: commit all the prepared branches
+	if ferr := finishCrossTx(caller, xid, participants, true); ferr != nil {
+		err = ferr
+	}
+	return
+}
+
+func buildCrossTxRecords(
+	req *types.PrepareTxReq, nodeID proto.NodeID, privKey *asymmetric.PrivateKey, queries [][]types.Query,
+) (err error) {
+	connID, seqNo := allocateConnAndSeq()
+	defer putBackConn(connID)
+
+	for i, r := range []*types.Request{&req.Prepare, &req.Commit, &req.Rollback} {
+		if i > 0 {
+			seqNo = atomic.AddUint64(&globalSeqNo, 1)
+		}
+		*r = types.Request{
+			Header: types.SignedRequestHeader{
+				RequestHeader: types.RequestHeader{
+					QueryType:    types.WriteQuery,
+					NodeID:       nodeID,
+					DatabaseID:   req.DatabaseID,
+					ConnectionID: connID,
+					SeqNo:        seqNo,
+					Timestamp:    getLocalTime(),
+				},
+			},
+			Payload: types.RequestPayload{
+				Queries: queries[i],
+			},
+		}
+		if err = r.Sign(privKey); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func finishCrossTx(caller *rpc.Caller, xid string, participants []*crossTxParticipant, commit bool) (err error) {
+	for _, p := range participants {
+		var (
+			req = &types.FinishTxReq{
+				DatabaseID: p.dbID,
+				XID:        xid,
+				Commit:     commit,
+			}
+			resp = &types.FinishTxResp{}
+		)
+		// finish the branches even if the request is canceled, otherwise the databases are
+		// blocked until the transaction is timeout
+		if ferr := caller.CallNodeWithContext(
+			context.Background(), p.leader, route.DBSFinishTx.String(), req, resp,
+		); ferr != nil {
+			log.WithFields(log.Fields{
+				"db":     p.dbID,
+				"xid":    xid,
+				"commit": commit,
+			}).WithError(ferr).Error("finish cross database transaction failed")
+			if err == nil {
+				err = errors.Wrapf(ferr, "finish transaction on database %s failed", p.dbID)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/worker"
+)
+
+const (
+	metricNamespace = "covenantsql"
+
+	adminShutdownTimeout = 5 * time.Second
+)
+
+var (
+	dbLabels     = []string{"database"}
+	dbHeightDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "miner", "database_block_height"),
+		"Height of the sqlchain head block by database.",
+		dbLabels, nil,
+	)
+	dbLastCommitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "miner", "database_last_commit"),
+		"Last committed kayak log index by database.",
+		dbLabels, nil,
+	)
+	dbReplicationLagDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "miner", "database_replication_lag_seconds"),
+		"Time since the database state is known to catch up with the leader.",
+		dbLabels, nil,
+	)
+	dbLeaderDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "miner", "database_leader"),
+		"Whether the miner is the leader of the database.",
+		dbLabels, nil,
+	)
+	dbStorageDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "miner", "database_storage_bytes"),
+		"Size of the data directory by database.",
+		dbLabels, nil,
+	)
+	diskUsageDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "miner", "disk_usage_bytes"),
+		"Last collected disk usage of the miner root directory.",
+		nil, nil,
+	)
+)
+
+// databaseStatus is the JSON view of worker.DatabaseStatus.
+type databaseStatus struct {
+	DatabaseID     proto.DatabaseID `json:"database_id"`
+	Role           string           `json:"role"`
+	Leader         proto.NodeID     `json:"leader"`
+	Height         int32            `json:"height"`
+	Head           hash.Hash        `json:"head"`
+	LastCommit     uint64           `json:"last_commit"`
+	CommitLag      uint64           `json:"commit_lag"`
+	ReplicationLag float64          `json:"replication_lag_seconds"`
+	StorageSize    int64            `json:"storage_bytes"`
+}
+
+// minerStatus is the response of the /status endpoint.
+type minerStatus struct {
+	NodeID    proto.NodeID      `json:"node_id"`
+	Version   string            `json:"version"`
+	StartTime time.Time         `json:"start_time"`
+	Uptime    float64           `json:"uptime_seconds"`
+	Ready     bool              `json:"ready"`
+	DiskUsage int64             `json:"disk_usage_bytes"`
+	Databases []*databaseStatus `json:"databases"`
+}
+
+// adminServer serves the health checks, database status and Prometheus metrics of the miner.
+//
+//	/healthz  200 while the process is serving http
+//	/readyz   200 once databases are loaded, 503 during startup and shutdown
+//	/status   JSON status of the miner and its databases
+//	/metrics  Prometheus metrics including the per-database gauges
+type adminServer struct {
+	startTime time.Time
+	dbms      atomic.Value // *worker.DBMS
+	ready     int32
+	server    *http.Server
+}
+
+// startAdminServer starts the admin endpoint on addr, metrics of the node collector registry
+// are exported along with the default registry.
+func startAdminServer(addr string, reg *prometheus.Registry) (s *adminServer, err error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		err = errors.Wrapf(err, "listen admin endpoint on %s failed", addr)
+		return
+	}
+
+	s = &adminServer{startTime: time.Now()}
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer}
+	if reg != nil {
+		gatherers = append(gatherers, reg)
+	}
+	dbReg := prometheus.NewRegistry()
+	if err = dbReg.Register(s); err != nil {
+		err = errors.Wrap(err, "register database collector failed")
+		return
+	}
+	gatherers = append(gatherers, dbReg)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+	s.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("admin endpoint stopped")
+		}
+	}()
+	log.WithField("addr", l.Addr()).Info("admin endpoint started")
+	return
+}
+
+// SetDBMS marks the miner ready to serve the databases of dbms.
+func (s *adminServer) SetDBMS(dbms *worker.DBMS) {
+	s.dbms.Store(dbms)
+	atomic.StoreInt32(&s.ready, 1)
+}
+
+// SetUnready marks the miner not ready on shutdown.
+func (s *adminServer) SetUnready() {
+	atomic.StoreInt32(&s.ready, 0)
+}
+
+// Stop stops the admin endpoint.
+func (s *adminServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.WithError(err).Warning("stop admin endpoint failed")
+	}
+}
+
+func (s *adminServer) isReady() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+func (s *adminServer) databases() []*worker.DatabaseStatus {
+	if dbms, ok := s.dbms.Load().(*worker.DBMS); ok && dbms != nil {
+		return dbms.Status()
+	}
+	return nil
+}
+
+func (s *adminServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *adminServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.isReady() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := &minerStatus{
+		NodeID:    conf.GConf.ThisNodeID,
+		Version:   version,
+		StartTime: s.startTime,
+		Uptime:    time.Since(s.startTime).Seconds(),
+		Ready:     s.isReady(),
+		DiskUsage: atomic.LoadInt64(&lastDiskUsage) * 1024,
+		Databases: []*databaseStatus{},
+	}
+	for _, st := range s.databases() {
+		status.Databases = append(status.Databases, &databaseStatus{
+			DatabaseID:     st.DatabaseID,
+			Role:           st.Role.String(),
+			Leader:         st.Leader,
+			Height:         st.Height,
+			Head:           st.Head,
+			LastCommit:     st.LastCommit,
+			CommitLag:      st.CommitLag,
+			ReplicationLag: st.ReplicationLag.Seconds(),
+			StorageSize:    st.StorageSize,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(status); err != nil {
+		log.WithError(err).Debug("write admin status failed")
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (s *adminServer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbHeightDesc
+	ch <- dbLastCommitDesc
+	ch <- dbReplicationLagDesc
+	ch <- dbLeaderDesc
+	ch <- dbStorageDesc
+	ch <- diskUsageDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (s *adminServer) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(diskUsageDesc, prometheus.GaugeValue,
+		float64(atomic.LoadInt64(&lastDiskUsage)*1024))
+	for _, st := range s.databases() {
+		id := string(st.DatabaseID)
+		var leader float64
+		if st.Role == proto.Leader {
+			leader = 1
+		}
+		ch <- prometheus.MustNewConstMetric(dbHeightDesc, prometheus.GaugeValue, float64(st.Height), id)
+		ch <- prometheus.MustNewConstMetric(dbLastCommitDesc, prometheus.GaugeValue, float64(st.LastCommit), id)
+		ch <- prometheus.MustNewConstMetric(
+			dbReplicationLagDesc, prometheus.GaugeValue, st.ReplicationLag.Seconds(), id)
+		ch <- prometheus.MustNewConstMetric(dbLeaderDesc, prometheus.GaugeValue, leader, id)
+		ch <- prometheus.MustNewConstMetric(dbStorageDesc, prometheus.GaugeValue, float64(st.StorageSize), id)
+	}
+}
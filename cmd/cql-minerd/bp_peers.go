@@ -0,0 +1,72 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// syncBPPeers fetches the block producer peer list from main chain and updates the local route
+// if the peer list term is newer than lastTerm.
+func syncBPPeers(lastTerm uint64) (term uint64, err error) {
+	var (
+		req  = new(types.QueryBPPeersReq)
+		resp = new(types.QueryBPPeersResp)
+	)
+	term = lastTerm
+	if err = rpc.RequestBP(route.MCCQueryBPPeers.String(), req, resp); err != nil {
+		return
+	}
+	if resp.Profile == nil || resp.Profile.Peers.Term <= lastTerm {
+		return
+	}
+	if err = resp.Profile.Peers.Verify(); err != nil {
+		err = errors.Wrap(err, "verify block producer peers failed")
+		return
+	}
+
+	var nodes = resp.Profile.BPNodes()
+	for i := range nodes {
+		if !kms.IsIDPubNonceValid(nodes[i].ID.ToRawNodeID(), &nodes[i].Nonce, nodes[i].PublicKey) {
+			err = errors.Errorf("invalid block producer node info: %s", nodes[i].ID)
+			return
+		}
+	}
+	for i := range nodes {
+		if err = kms.SetNode(&nodes[i]); err != nil {
+			log.WithField("node", nodes[i].ID).WithError(err).Warning("set node to kms failed")
+		}
+	}
+	route.UpdateBPs(nodes)
+	// Reconnect to the nearest block producer in the new peer list
+	rpc.SetCurrentBP("")
+	term = resp.Profile.Peers.Term
+	err = nil
+
+	log.WithFields(log.Fields{
+		"term":    term,
+		"leader":  resp.Profile.Peers.Leader,
+		"servers": resp.Profile.Peers.Servers,
+	}).Info("block producer peers updated")
+	return
+}
@@ -35,11 +35,16 @@ func startDBMS(server *mux.Server, direct *rpc.Server, onCreateDB func()) (dbms
 	}
 
 	cfg := &worker.DBMSConfig{
-		RootDir:          conf.GConf.Miner.RootDir,
-		Server:           server,
-		DirectServer:     direct,
-		MaxReqTimeGap:    conf.GConf.Miner.MaxReqTimeGap,
-		OnCreateDatabase: onCreateDB,
+		RootDir:           conf.GConf.Miner.RootDir,
+		Server:            server,
+		DirectServer:      direct,
+		MaxReqTimeGap:     conf.GConf.Miner.MaxReqTimeGap,
+		SlowQueryTime:     conf.GConf.Miner.SlowQueryTime,
+		KeyRotationPeriod: conf.GConf.Miner.KeyRotationPeriod,
+		WriteBatchWindow:  conf.GConf.Miner.WriteBatchWindow,
+		MaxWriteBatchSize: conf.GConf.Miner.MaxWriteBatchSize,
+		ElectionTimeout:   conf.GConf.Miner.ElectionTimeout,
+		OnCreateDatabase:  onCreateDB,
 	}
 
 	if dbms, err = worker.NewDBMS(cfg); err != nil {
@@ -23,6 +23,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	mw "github.com/zserge/metric"
 
@@ -32,6 +33,8 @@ import (
 
 var (
 	diskUsageMetric = mw.NewGauge("5m1m")
+	// lastDiskUsage is the last collected disk usage of the miner root dir in kilobytes.
+	lastDiskUsage int64
 )
 
 func collectDiskUsage() (err error) {
@@ -89,6 +92,7 @@ func collectDiskUsage() (err error) {
 	}
 
 	diskUsageMetric.Add(float64(usedKiloBytes))
+	atomic.StoreInt64(&lastDiskUsage, usedKiloBytes)
 
 	return
 }
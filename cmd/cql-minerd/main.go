@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -157,7 +158,10 @@ func main() {
 		conf.GConf.Miner.DiskUsageInterval = time.Minute * 10
 	}
 
+	applyLogLevel(conf.GConf.Miner)
+
 	log.Debugf("config:\n%#v", conf.GConf)
+	mux.GetSessionPoolInstance().SetLimits(conf.GConf.MaxStreamsPerNode, conf.GConf.MaxStreams)
 
 	// init log
 	initLogs()
@@ -172,6 +176,12 @@ func main() {
 	// set generate key pair config
 	conf.GConf.GenerateKeyPair = genKeyPair
 
+	// init distributed tracing
+	shutdownTracing, err := rpc.SetupTracing(conf.GConf.Tracing, conf.GConf.ThisNodeID)
+	if err != nil {
+		log.WithError(err).Fatal("setup tracing failed")
+	}
+
 	// start rpc
 	var (
 		server *mux.Server
@@ -200,10 +210,22 @@ func main() {
 			os.Exit(-1)
 		}
 	}
+	if len(conf.GConf.MetricsAddr) > 0 {
+		if err = metric.InitPrometheusExporter(conf.GConf.MetricsAddr); err != nil {
+			log.WithError(err).Fatal("start prometheus exporter failed")
+		}
+	}
 
 	// start prometheus collector
 	reg := metric.StartMetricCollector()
 
+	// start admin endpoint, readiness is reported after dbms is started
+	r := &reloader{reg: reg}
+	if err = r.startAdmin(conf.GConf.Miner.AdminAddr); err != nil {
+		log.WithError(err).Fatal("start admin endpoint failed")
+	}
+	defer r.stopAdmin()
+
 	// start periodic provide service transaction generator
 	go func() {
 		tick := time.NewTicker(conf.GConf.Miner.ProvideServiceInterval)
@@ -220,6 +242,25 @@ func main() {
 		}
 	}()
 
+	// start periodic block producer peers synchronization
+	go func() {
+		var (
+			term uint64
+			err  error
+		)
+		for {
+			if term, err = syncBPPeers(term); err != nil {
+				log.WithError(err).Warning("sync block producer peers failed")
+			}
+
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(conf.BPPeersSyncInterval):
+			}
+		}
+	}()
+
 	// start periodic disk usage metric update
 	go func() {
 		for {
@@ -263,6 +304,8 @@ func main() {
 
 	defer dbms.Shutdown()
 
+	r.setDBMS(dbms)
+
 	if metricLog {
 		go metrics.Log(metrics.DefaultRegistry, 5*time.Second, log.StandardLogger())
 	}
@@ -294,7 +337,19 @@ func main() {
 		defer trace.Stop()
 	}
 
-	<-utils.WaitForExit()
+	// reload config on SIGHUP until exit
+	r.waitForExit()
+	r.setUnready()
+
+	// finish the in-flight outgoing RPCs before stopping services
+	ctx, cancel := context.WithTimeout(context.Background(), conf.SessionPoolDrainTimeout)
+	defer cancel()
+	if err := mux.GetSessionPoolInstance().Drain(ctx); err != nil {
+		log.WithError(err).Warning("drain session pool failed")
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.WithError(err).Warning("shutdown tracing failed")
+	}
 	utils.StopProfile()
 
 	log.Info("miner stopped")
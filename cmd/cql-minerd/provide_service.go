@@ -156,6 +156,9 @@ func sendProvideService(reg *prometheus.Registry) {
 	if conf.GConf.Miner != nil && len(conf.GConf.Miner.TargetUsers) > 0 {
 		tx.ProvideServiceHeader.TargetUser = conf.GConf.Miner.TargetUsers
 	}
+	if conf.GConf.Miner != nil {
+		tx.ProvideServiceHeader.Region = conf.GConf.Miner.Region
+	}
 
 	tx.Nonce = nonceResp.Nonce
 
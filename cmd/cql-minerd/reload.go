@@ -0,0 +1,227 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/worker"
+)
+
+// reloader reloads the config file on SIGHUP and applies the settings which can be changed
+// without restarting the miner, the served databases are kept running.
+//
+// The reloadable settings are the log level, session pool limits, request time gap, slow query
+// time, key rotation and write batching settings, target users, region, admin endpoint address
+// and the block producers in KnownNodes. Changes of the RPC listen addresses, root dir, node id
+// and keys are reported and take effect after restart.
+type reloader struct {
+	reg   *prometheus.Registry
+	dbms  *worker.DBMS
+	admin *adminServer
+}
+
+// applyLogLevel sets the log level of config unless the -log-level flag is given.
+func applyLogLevel(cfg *conf.MinerInfo) {
+	if logLevel != "" || cfg.LogLevel == "" {
+		return
+	}
+	lvl, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		log.WithField("level", cfg.LogLevel).WithError(err).Warning("invalid log level")
+		return
+	}
+	log.SetLevel(lvl)
+}
+
+// startAdmin starts the admin endpoint on addr if it's not empty.
+func (r *reloader) startAdmin(addr string) (err error) {
+	if addr == "" {
+		return
+	}
+	if r.admin, err = startAdminServer(addr, r.reg); err != nil {
+		return
+	}
+	if r.dbms != nil {
+		r.admin.SetDBMS(r.dbms)
+	}
+	return
+}
+
+// stopAdmin stops the running admin endpoint.
+func (r *reloader) stopAdmin() {
+	if r.admin != nil {
+		r.admin.Stop()
+		r.admin = nil
+	}
+}
+
+// setDBMS marks the miner ready after dbms is started.
+func (r *reloader) setDBMS(dbms *worker.DBMS) {
+	r.dbms = dbms
+	if r.admin != nil {
+		r.admin.SetDBMS(dbms)
+	}
+}
+
+// setUnready marks the miner not ready on shutdown.
+func (r *reloader) setUnready() {
+	if r.admin != nil {
+		r.admin.SetUnready()
+	}
+}
+
+// waitForExit reloads the config on SIGHUP until the exit signal is received.
+func (r *reloader) waitForExit() {
+	exitCh := utils.WaitForExit()
+	// utils.WaitForExit ignores SIGHUP, so the notification must be registered afterwards
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+
+	for {
+		select {
+		case <-exitCh:
+			return
+		case <-hupCh:
+			if err := r.reload(); err != nil {
+				log.WithField("config", configFile).WithError(err).Error("reload config failed")
+			}
+		}
+	}
+}
+
+func (r *reloader) reload() (err error) {
+	log.WithField("config", configFile).Info("reloading config")
+	cfg, err := conf.LoadConfig(configFile)
+	if err != nil {
+		err = errors.Wrap(err, "load config failed")
+		return
+	}
+	if cfg.Miner == nil {
+		err = errors.New("miner config does not exists")
+		return
+	}
+	if cfg.Miner.MaxReqTimeGap <= 0 {
+		err = errors.New("miner request time gap is invalid")
+		return
+	}
+	if cfg.Miner.DiskUsageInterval <= 0 {
+		cfg.Miner.DiskUsageInterval = conf.GConf.Miner.DiskUsageInterval
+	}
+
+	var (
+		oldCfg = conf.GConf
+		miner  = *cfg.Miner
+	)
+	for _, v := range []struct {
+		name     string
+		old, new string
+	}{
+		{"ListenAddr", oldCfg.ListenAddr, cfg.ListenAddr},
+		{"ListenDirectAddr", oldCfg.ListenDirectAddr, cfg.ListenDirectAddr},
+		{"MetricsAddr", oldCfg.MetricsAddr, cfg.MetricsAddr},
+		{"ThisNodeID", string(oldCfg.ThisNodeID), string(cfg.ThisNodeID)},
+		{"PrivateKeyFile", oldCfg.PrivateKeyFile, cfg.PrivateKeyFile},
+		{"Miner.RootDir", oldCfg.Miner.RootDir, cfg.Miner.RootDir},
+	} {
+		if v.old != v.new {
+			log.WithFields(log.Fields{
+				"old": v.old,
+				"new": v.new,
+			}).Warningf("%s change takes effect after restart", v.name)
+		}
+	}
+
+	// restart admin endpoint on the new address
+	if miner.AdminAddr != oldCfg.Miner.AdminAddr {
+		r.stopAdmin()
+		if err = r.startAdmin(miner.AdminAddr); err != nil {
+			err = errors.Wrap(err, "restart admin endpoint failed")
+			return
+		}
+	}
+
+	applyLogLevel(&miner)
+	mux.GetSessionPoolInstance().SetLimits(cfg.MaxStreamsPerNode, cfg.MaxStreams)
+	if r.dbms != nil {
+		r.dbms.Reload(&worker.DBMSConfig{
+			MaxReqTimeGap:     miner.MaxReqTimeGap,
+			SlowQueryTime:     miner.SlowQueryTime,
+			KeyRotationPeriod: miner.KeyRotationPeriod,
+			WriteBatchWindow:  miner.WriteBatchWindow,
+			MaxWriteBatchSize: miner.MaxWriteBatchSize,
+			ElectionTimeout:   miner.ElectionTimeout,
+		})
+	}
+	if err := reloadBPs(cfg.KnownNodes); err != nil {
+		log.WithError(err).Warning("reload block producers failed")
+	}
+
+	miner.RootDir = oldCfg.Miner.RootDir
+	oldCfg.Miner = &miner
+	oldCfg.MaxStreamsPerNode = cfg.MaxStreamsPerNode
+	oldCfg.MaxStreams = cfg.MaxStreams
+	oldCfg.KnownNodes = cfg.KnownNodes
+
+	log.WithField("config", configFile).Info("config reloaded")
+	return
+}
+
+// reloadBPs replaces the block producers in route with the ones listed in known nodes, which are
+// updated later by block producer peers synchronization if the main chain has newer peers.
+func reloadBPs(knownNodes []proto.Node) (err error) {
+	var nodes []proto.Node
+	for _, n := range knownNodes {
+		if n.Role == proto.Leader || n.Role == proto.Follower {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+	for i := range nodes {
+		if !kms.IsIDPubNonceValid(nodes[i].ID.ToRawNodeID(), &nodes[i].Nonce, nodes[i].PublicKey) {
+			err = errors.Errorf("invalid block producer node info: %s", nodes[i].ID)
+			return
+		}
+	}
+	for i := range nodes {
+		if err = kms.SetNode(&nodes[i]); err != nil {
+			log.WithField("node", nodes[i].ID).WithError(err).Warning("set node to kms failed")
+		}
+	}
+	route.UpdateBPs(nodes)
+	// Reconnect to the nearest block producer in the new list
+	mux.SetCurrentBP("")
+	err = nil
+
+	log.WithField("count", len(nodes)).Info("block producers reloaded")
+	return
+}
@@ -21,6 +21,8 @@ import (
 
 	mys "github.com/siddontang/go-mysql/server"
 
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/mysql"
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/storage"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
@@ -30,6 +32,7 @@ type Server struct {
 	listener      net.Listener
 	mysqlUser     string
 	mysqlPassword string
+	storage       storage.Storage
 }
 
 // NewServer bind the service port and return a runnable adapter.
@@ -38,6 +41,7 @@ func NewServer(listenAddr string, user string, password string) (s *Server, err
 		listenAddr:    listenAddr,
 		mysqlUser:     user,
 		mysqlPassword: password,
+		storage:       storage.NewCovenantSQLStorage(""),
 	}
 
 	if s.listener, err = net.Listen("tcp", listenAddr); err != nil {
@@ -60,7 +64,7 @@ func (s *Server) Serve() {
 }
 
 func (s *Server) handleConn(conn net.Conn) {
-	h, err := mys.NewConn(conn, s.mysqlUser, s.mysqlPassword, NewCursor(s))
+	h, err := mys.NewConn(conn, s.mysqlUser, s.mysqlPassword, mysql.NewHandler(s.storage, s.mysqlUser))
 
 	if err != nil {
 		log.WithError(err).Error("process connection failed")
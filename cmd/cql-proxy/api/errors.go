@@ -85,6 +85,8 @@ var (
 	ErrNoPublicServiceHosts = errors.New("ERR_NO_PUBLIC_SERVICE_HOSTS")
 	// ErrIncompleteOAuthConfig defines incomplete oauth config being set.
 	ErrIncompleteOAuthConfig = errors.New("ERR_INCOMPLETE_OAUTH_CONFIG")
+	// ErrUnsupportedOAuthProvider defines unknown or globally disabled oauth provider being used.
+	ErrUnsupportedOAuthProvider = errors.New("ERR_UNSUPPORTED_OAUTH_PROVIDER")
 	// ErrAddProjectOAuthConfigFailed defines error on add new project oauth config.
 	ErrAddProjectOAuthConfigFailed = errors.New("ERR_ADD_PROJECT_OAUTH_CONFIG_FAILED")
 	// ErrUpdateProjectConfigFailed defines error on update project config.
@@ -93,6 +95,16 @@ var (
 	ErrGetProjectRulesFailed = errors.New("ERR_GET_PROJECT_RULES_FAILED")
 	// ErrPopulateProjectRulesFailed defines error on update project query enforce rules in database and take effect.
 	ErrPopulateProjectRulesFailed = errors.New("ERR_POPULATE_PROJECT_RULES_FAILED")
+	// ErrReloadProjectRulesFailed defines error on re-compiling project query enforce rules from database.
+	ErrReloadProjectRulesFailed = errors.New("ERR_RELOAD_PROJECT_RULES_FAILED")
+	// ErrExplainProjectRulesFailed defines error on dry-run project query enforce rules.
+	ErrExplainProjectRulesFailed = errors.New("ERR_EXPLAIN_PROJECT_RULES_FAILED")
+	// ErrTestProjectRulesFailed defines error on running scenario tests against project query enforce rules.
+	ErrTestProjectRulesFailed = errors.New("ERR_TEST_PROJECT_RULES_FAILED")
+	// ErrGetProjectRulesVersionFailed defines error on get recorded project query enforce rules version.
+	ErrGetProjectRulesVersionFailed = errors.New("ERR_GET_PROJECT_RULES_VERSION_FAILED")
+	// ErrRollbackProjectRulesFailed defines error on restoring project query enforce rules to previous version.
+	ErrRollbackProjectRulesFailed = errors.New("ERR_ROLLBACK_PROJECT_RULES_FAILED")
 	// ErrSetProjectAliasFailed defines error on setting project alias.
 	ErrSetProjectAliasFailed = errors.New("ERR_SET_PROJECT_ALIAS_FAILED")
 	// ErrAddProjectMiscConfigFailed defines failure on adding project misc config.
@@ -129,6 +141,12 @@ var (
 	ErrPrepareExecutionContextFailed = errors.New("ERR_PREPARE_EXECUTION_CONTEXT_FAILED")
 	// ErrEnforceRuleOnQueryFailed defines error on resolving enforce rules.
 	ErrEnforceRuleOnQueryFailed = errors.New("ERR_ENFORCE_RULES_ON_QUERY_FAILED")
+	// ErrQuotaExceeded defines error on query rate limit of rules exceeded.
+	ErrQuotaExceeded = errors.New("ERR_QUOTA_EXCEEDED")
+	// ErrUnboundedQueryRefused defines error on find query without limit from anonymous user.
+	ErrUnboundedQueryRefused = errors.New("ERR_UNBOUNDED_QUERY_REFUSED")
+	// ErrQueryValidationFailed defines error on insert/update data violating validation constraints of rules.
+	ErrQueryValidationFailed = errors.New("ERR_QUERY_VALIDATION_FAILED")
 	// ErrExecuteQueryFailed defines error on executing query.
 	ErrExecuteQueryFailed = errors.New("ERR_EXECUTE_QUERY_FAILED")
 	// ErrScanRowsFailed defines error on scanning rows for find query.
@@ -143,4 +161,48 @@ var (
 	ErrProjectIsDisabled = errors.New("ERR_PROJECT_IS_DISABLED")
 	// ErrLogoutFailed defines error on failure session logout.
 	ErrLogoutFailed = errors.New("ERR_LOGOUT_FAILED")
+	// ErrInvalidJWTConfig defines error on project jwt config without verification key.
+	ErrInvalidJWTConfig = errors.New("ERR_INVALID_JWT_CONFIG")
+	// ErrAddProjectJWTConfigFailed defines error on add new project jwt config.
+	ErrAddProjectJWTConfigFailed = errors.New("ERR_ADD_PROJECT_JWT_CONFIG_FAILED")
+	// ErrInvalidUserToken defines error on invalid jwt token issued by external identity provider.
+	ErrInvalidUserToken = errors.New("ERR_INVALID_USER_TOKEN")
+	// ErrFileStorageDisabled defines error on accessing file api of project without file storage enabled.
+	ErrFileStorageDisabled = errors.New("ERR_FILE_STORAGE_DISABLED")
+	// ErrInvalidFileSize defines error on starting chunked upload without file size.
+	ErrInvalidFileSize = errors.New("ERR_INVALID_FILE_SIZE")
+	// ErrFileTooLarge defines error on uploading file exceeding max file size of project.
+	ErrFileTooLarge = errors.New("ERR_FILE_TOO_LARGE")
+	// ErrInvalidFileChunk defines error on uploading chunk with invalid sequence or length.
+	ErrInvalidFileChunk = errors.New("ERR_INVALID_FILE_CHUNK")
+	// ErrIncompleteFileUpload defines error on accessing file with chunks not fully uploaded.
+	ErrIncompleteFileUpload = errors.New("ERR_INCOMPLETE_FILE_UPLOAD")
+	// ErrFileUploadCompleted defines error on uploading chunks to completed file.
+	ErrFileUploadCompleted = errors.New("ERR_FILE_UPLOAD_COMPLETED")
+	// ErrFileNotFound defines error on accessing unknown or unauthorized file.
+	ErrFileNotFound = errors.New("ERR_FILE_NOT_FOUND")
+	// ErrInvalidFileSignature defines error on downloading file with invalid or expired signed url.
+	ErrInvalidFileSignature = errors.New("ERR_INVALID_FILE_SIGNATURE")
+	// ErrGetFileFailed defines error on fetching file metadata or chunks.
+	ErrGetFileFailed = errors.New("ERR_GET_FILE_FAILED")
+	// ErrSaveFileFailed defines error on saving file metadata or chunks.
+	ErrSaveFileFailed = errors.New("ERR_SAVE_FILE_FAILED")
+	// ErrRemoveFileFailed defines error on removing file.
+	ErrRemoveFileFailed = errors.New("ERR_REMOVE_FILE_FAILED")
+	// ErrInvalidHookConfig defines error on hook functions config failed to compile.
+	ErrInvalidHookConfig = errors.New("ERR_INVALID_HOOK_CONFIG")
+	// ErrAddProjectHookConfigFailed defines error on add new table hook functions config.
+	ErrAddProjectHookConfigFailed = errors.New("ERR_ADD_PROJECT_HOOK_CONFIG_FAILED")
+	// ErrRejectedByHook defines error on data write rejected by hook function.
+	ErrRejectedByHook = errors.New("ERR_REJECTED_BY_HOOK")
+	// ErrExecuteHookFailed defines error on loading or executing hook functions before data write.
+	ErrExecuteHookFailed = errors.New("ERR_EXECUTE_HOOK_FAILED")
+	// ErrMissingGraphQLQuery defines error on graphql request without query document.
+	ErrMissingGraphQLQuery = errors.New("ERR_MISSING_GRAPHQL_QUERY")
+	// ErrBuildGraphQLSchemaFailed defines error on generating graphql schema from project tables.
+	ErrBuildGraphQLSchemaFailed = errors.New("ERR_BUILD_GRAPHQL_SCHEMA_FAILED")
+	// ErrInvalidSubscription defines error on invalid realtime subscription action.
+	ErrInvalidSubscription = errors.New("ERR_INVALID_SUBSCRIPTION")
+	// ErrTooManySubscriptions defines error on exceeding max subscriptions of realtime connection.
+	ErrTooManySubscriptions = errors.New("ERR_TOO_MANY_SUBSCRIPTIONS")
 )
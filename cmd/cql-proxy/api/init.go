@@ -77,6 +77,8 @@ func AddRoutes(e *gin.Engine) {
 
 			v3AdminLogin.PUT("/project/:db/config/misc", updateProjectMiscConfig)
 			v3AdminLogin.PUT("/project/:db/config/group", updateProjectGroupConfig)
+			v3AdminLogin.PUT("/project/:db/config/jwt", updateProjectJWTConfig)
+			v3AdminLogin.PUT("/project/:db/config/files", updateProjectFilesConfig)
 
 			v3AdminLogin.PUT("/project/:db/oauth/:provider", updateProjectOAuthConfig)
 			v3AdminLogin.GET("/project/:db/oauth/:provider/callback", getProjectOAuthCallback)
@@ -86,6 +88,13 @@ func AddRoutes(e *gin.Engine) {
 			v3AdminLogin.GET("/project/:db/table/:table", getProjectTableDetail)
 			v3AdminLogin.DELETE("/project/:db/table/:table", dropProjectTable)
 			v3AdminLogin.PUT("/project/:db/table/:table/rules", updateProjectTableRules)
+			v3AdminLogin.PUT("/project/:db/table/:table/hooks", updateProjectTableHooks)
+			v3AdminLogin.POST("/project/:db/rules/reload", reloadProjectRules)
+			v3AdminLogin.POST("/project/:db/rules/explain", explainProjectRules)
+			v3AdminLogin.POST("/project/:db/rules/test", testProjectRules)
+			v3AdminLogin.GET("/project/:db/rules/version", listProjectRulesVersions)
+			v3AdminLogin.GET("/project/:db/rules/diff", diffProjectRulesVersions)
+			v3AdminLogin.POST("/project/:db/rules/rollback", rollbackProjectRules)
 
 			v3AdminLogin.GET("/project/:db/config", getProjectConfig)
 			v3AdminLogin.GET("/project/:db/audits", getProjectAudits)
@@ -119,6 +128,22 @@ func AddRoutes(e *gin.Engine) {
 		v3UserPermissive.POST("/data/:table/remove", userDataRemove)
 		v3UserPermissive.GET("/data/:table/count", userDataCount)
 		v3UserPermissive.POST("/data/:table/count", userDataCount)
+		v3UserPermissive.GET("/data/:table/aggregate", userDataAggregate)
+		v3UserPermissive.POST("/data/:table/aggregate", userDataAggregate)
+
+		v3UserPermissive.GET("/graphql", userGraphQL)
+		v3UserPermissive.POST("/graphql", userGraphQL)
+
+		v3UserPermissive.GET("/realtime", userRealtime)
+
+		v3UserPermissive.POST("/files", userFileUpload)
+		v3UserPermissive.PUT("/files/:id/chunks/:seq", userFileUploadChunk)
+		v3UserPermissive.POST("/files/:id/complete", userFileComplete)
+		v3UserPermissive.GET("/files/:id", userFileInfo)
+		v3UserPermissive.GET("/files/:id/url", userFileSignURL)
+		v3UserPermissive.POST("/files/:id/url", userFileSignURL)
+		v3UserPermissive.GET("/files/:id/download", userFileDownload)
+		v3UserPermissive.DELETE("/files/:id", userFileRemove)
 	}
 
 	// alias
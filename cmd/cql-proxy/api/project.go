@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -44,17 +45,29 @@ const (
 	metaTableUserInfo      = "____user"
 	metaTableProjectConfig = "____config"
 	metaTableSession       = "____session"
+	metaTableFile          = "____file"
+	metaTableFileChunk     = "____file_chunk"
+	metaTableRulesVersion  = "____rules_version"
 	deletedTablePrefix     = "____deleted"
+
+	// maxRulesVersions defines the max number of rules versions retained for each project.
+	maxRulesVersions = 64
 )
 
 type projectRulesContext struct {
 	dbID   proto.DatabaseID
 	db     *gorp.DbMap
 	group  *model.ProjectConfig
+	files  *model.ProjectConfig
 	tables map[string]*model.ProjectConfig
 
-	toUpdate *model.ProjectConfig
-	toInsert *model.ProjectConfig
+	toUpdate     *model.ProjectConfig
+	toInsert     *model.ProjectConfig
+	tableUpdates []*model.ProjectConfig
+}
+
+func (ctx *projectRulesContext) hasUpdates() bool {
+	return ctx.toUpdate != nil || ctx.toInsert != nil || len(ctx.tableUpdates) > 0
 }
 
 func getProjects(c *gin.Context) {
@@ -360,6 +373,8 @@ func updateProjectUser(c *gin.Context) {
 		Email    string           `json:"email" form:"email" binding:"omitempty,email"`
 		Provider string           `json:"provider" form:"provider"`
 		State    string           `json:"state" form:"state"`
+		// developer-defined user state, set to empty string to remove
+		CustomState *string `json:"custom_state" form:"custom_state" binding:"omitempty,max=64"`
 	}{}
 
 	_ = c.ShouldBindUri(&r)
@@ -400,6 +415,9 @@ func updateProjectUser(c *gin.Context) {
 			return
 		}
 	}
+	if r.CustomState != nil {
+		u.SetCustomState(strings.ToLower(*r.CustomState))
+	}
 
 	err = model.UpdateProjectUser(projectDB, u)
 	if err != nil {
@@ -495,9 +513,14 @@ func updateProjectOAuthConfig(c *gin.Context) {
 		return
 	}
 
+	if !isOAuthProviderAvailable(c, r.Provider) {
+		abortWithError(c, http.StatusBadRequest, ErrUnsupportedOAuthProvider)
+		return
+	}
+
 	cfg := &r.ProjectOAuthConfig
 
-	if cfg.ClientID == "" && cfg.ClientSecret == "" {
+	if cfg.ClientID == "" && cfg.ClientSecret == "" && len(cfg.Extra) == 0 {
 		// update nothing
 		_ = c.Error(err)
 		abortWithError(c, http.StatusBadRequest, ErrIncompleteOAuthConfig)
@@ -534,6 +557,17 @@ func updateProjectOAuthConfig(c *gin.Context) {
 		if cfg.Enabled != nil {
 			poc.Enabled = cfg.Enabled
 		}
+		for k, v := range cfg.Extra {
+			if poc.Extra == nil {
+				poc.Extra = map[string]string{}
+			}
+			if v == "" {
+				// empty value removes the config item
+				delete(poc.Extra, k)
+			} else {
+				poc.Extra[k] = v
+			}
+		}
 		err = model.UpdateProjectConfig(projectDB, p)
 		if err != nil {
 			_ = c.Error(err)
@@ -707,8 +741,11 @@ func getProjectConfig(c *gin.Context) {
 	var (
 		miscConfig   interface{}
 		groupConfig  interface{}
+		jwtConfig    interface{}
+		filesConfig  interface{}
 		oauthConfig  []gin.H
 		tablesConfig []gin.H
+		hooksConfig  []gin.H
 	)
 	for _, p := range projectConfigList {
 		switch p.Type {
@@ -726,6 +763,15 @@ func getProjectConfig(c *gin.Context) {
 			})
 		case model.ProjectConfigGroup:
 			groupConfig = p.Value
+		case model.ProjectConfigJWT:
+			jwtConfig = p.Value
+		case model.ProjectConfigFiles:
+			filesConfig = p.Value
+		case model.ProjectConfigHook:
+			hooksConfig = append(hooksConfig, gin.H{
+				"table":  p.Key,
+				"config": p.Value,
+			})
 		}
 	}
 
@@ -741,6 +787,9 @@ func getProjectConfig(c *gin.Context) {
 			"oauth":              oauthConfig,
 			"tables":             tablesConfig,
 			"group":              groupConfig,
+			"jwt":                jwtConfig,
+			"files":              filesConfig,
+			"hooks":              hooksConfig,
 			"client_api_domains": nil,
 		}
 		cfg = getConfig(c)
@@ -814,7 +863,9 @@ func createProjectTable(c *gin.Context) {
 	}
 
 	if strings.EqualFold(r.Table, metaTableProjectConfig) || strings.EqualFold(r.Table, metaTableUserInfo) ||
-		strings.EqualFold(r.Table, metaTableSession) || strings.HasPrefix(r.Table, deletedTablePrefix) {
+		strings.EqualFold(r.Table, metaTableSession) || strings.EqualFold(r.Table, metaTableFile) ||
+		strings.EqualFold(r.Table, metaTableFileChunk) || strings.EqualFold(r.Table, metaTableRulesVersion) ||
+		strings.HasPrefix(r.Table, deletedTablePrefix) {
 		abortWithError(c, http.StatusBadRequest, ErrReservedTableName)
 		return
 	}
@@ -1097,6 +1148,15 @@ func dropProjectTable(c *gin.Context) {
 		return
 	}
 
+	// hook functions are not inherited by new table with same name
+	if hp, _, hErr := model.GetProjectHookConfig(projectDB, r.Table); hErr == nil {
+		_ = model.DeleteProjectConfig(projectDB, hp)
+		getHookManager(c).Remove(string(r.DB), r.Table)
+	}
+
+	// rules of the dropped table are excluded on next rules compilation
+	getRulesManager(c).Remove(r.DB)
+
 	responseWithData(c, http.StatusOK, gin.H{
 		"project":      r.DB,
 		"db":           r.DB,
@@ -1248,6 +1308,11 @@ func initProjectDB(dbID proto.DatabaseID, key *asymmetric.PrivateKey) (db *gorp.
 		SetKeys(true, "ID")
 	tblConfig.AddIndex("____idx_config_1", "", []string{"type", "key"}).SetUnique(true)
 	db.AddTableWithName(model.Session{}, metaTableSession).SetKeys(false, "ID")
+	db.AddTableWithName(model.ProjectFile{}, metaTableFile).SetKeys(false, "ID")
+	tblFileChunk := db.AddTableWithName(model.ProjectFileChunk{}, metaTableFileChunk).
+		SetKeys(true, "ID")
+	tblFileChunk.AddIndex("____idx_file_chunk_1", "", []string{"file_id", "seq"}).SetUnique(true)
+	db.AddTableWithName(model.ProjectRulesVersion{}, metaTableRulesVersion).SetKeys(true, "ID")
 
 	err = db.CreateTablesIfNotExists()
 
@@ -1309,17 +1374,22 @@ func getRulesContext(dbID proto.DatabaseID, db *gorp.DbMap) (ctx *projectRulesCo
 			ctx.tables[cfg.Key] = cfg
 		case model.ProjectConfigGroup:
 			ctx.group = cfg
+		case model.ProjectConfigFiles:
+			ctx.files = cfg
 		}
 	}
 
 	return
 }
 
-func populateRulesContext(c *gin.Context, ctx *projectRulesContext) (r *resolver.Rules, err error) {
+func buildRawRules(ctx *projectRulesContext) (rules json.RawMessage, err error) {
 	var (
-		rm         = getRulesManager(c)
 		groupRules = map[string][]string{}
 		tableRules = map[string]json.RawMessage{}
+		limits     = map[string]json.RawMessage{}
+		states     []string
+		source     *model.ProjectGroupSource
+		external   *model.ProjectExternalAuth
 	)
 
 	if ctx.group != nil {
@@ -1329,6 +1399,9 @@ func populateRulesContext(c *gin.Context, ctx *projectRulesContext) (r *resolver
 				groupRules[groupName] = append(groupRules[groupName], fmt.Sprint(userID))
 			}
 		}
+		states = gc.States
+		source = gc.Source
+		external = gc.External
 	}
 
 	for tableName, ptc := range ctx.tables {
@@ -1346,19 +1419,103 @@ func populateRulesContext(c *gin.Context, ctx *projectRulesContext) (r *resolver
 		}
 
 		tableRules[tableName] = tableRule
+
+		// limits are declared in table rules as the "limits" field
+		if bytes.HasPrefix(tableRule, []byte{'{'}) {
+			var tableLimits struct {
+				Limits json.RawMessage `json:"limits"`
+			}
+			if err = json.Unmarshal(tableRule, &tableLimits); err != nil {
+				err = errors.Wrapf(err, "decode limits of table %s failed", tableName)
+				return
+			}
+			if len(tableLimits.Limits) > 0 && !bytes.Equal(tableLimits.Limits, []byte("null")) {
+				limits[tableName] = tableLimits.Limits
+			}
+		}
+	}
+
+	// file storage rules are applied on file metadata table
+	if ctx.files != nil {
+		if fileRules := ctx.files.Value.(*model.ProjectFilesConfig).Rules; len(fileRules) > 0 {
+			tableRules[metaTableFile] = fileRules
+		}
 	}
 
-	r, err = resolver.CompileRules(map[string]interface{}{
-		"groups": groupRules,
-		"rules":  tableRules,
+	rules, err = json.Marshal(map[string]interface{}{
+		"groups":       groupRules,
+		"rules":        tableRules,
+		"limits":       limits,
+		"states":       states,
+		"group_source": source,
+		"external":     external,
 	})
+	if err != nil {
+		err = errors.Wrapf(err, "encode rules config failed")
+	}
+
+	return
+}
+
+// populateRulesContext persists the rules config updates of context with a new rules version,
+// the cached rules are swapped after the transaction is committed.
+func populateRulesContext(c *gin.Context, ctx *projectRulesContext) (r *resolver.Rules, err error) {
+	rm := getRulesManager(c)
+
+	rawRules, err := buildRawRules(ctx)
+	if err != nil {
+		return
+	}
+
+	// the config changes are discarded on compilation failure
+	r, err = rm.Compile(ctx.dbID, rawRules, ctx.db)
 	if err != nil {
 		err = errors.Wrapf(err, "compile rules failed")
 		return
 	}
 
+	// reads are not supported in project database transaction, fetch latest version beforehand
+	latest, err := model.GetLatestProjectRulesVersion(ctx.db)
+	if err != nil {
+		return
+	}
+
+	newVersion := latest == nil || !bytes.Equal(latest.Rules, rawRules)
+
+	if newVersion || ctx.hasUpdates() {
+		if err = persistRulesContext(c, ctx, rawRules, newVersion); err != nil {
+			return
+		}
+	}
+
+	rm.Set(ctx.dbID, r)
+
+	return
+}
+
+func persistRulesContext(c *gin.Context, ctx *projectRulesContext, rawRules json.RawMessage, newVersion bool) (
+	err error) {
+	tx, err := ctx.db.Begin()
+	if err != nil {
+		err = errors.Wrapf(err, "begin rules config transaction failed")
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, pc := range ctx.tableUpdates {
+		if err = model.UpdateProjectConfig(tx, pc); err != nil {
+			err = errors.Wrapf(err, "execute table rules config update failed")
+			return
+		}
+	}
+
 	if ctx.toUpdate != nil {
-		err = model.UpdateProjectConfig(ctx.db, ctx.toUpdate)
+		err = model.UpdateProjectConfig(tx, ctx.toUpdate)
 		if err != nil {
 			err = errors.Wrapf(err, "execute rules config update failed")
 			return
@@ -1366,42 +1523,476 @@ func populateRulesContext(c *gin.Context, ctx *projectRulesContext) (r *resolver
 	}
 
 	if ctx.toInsert != nil {
-		err = model.AddRawProjectConfig(ctx.db, ctx.toInsert)
+		err = model.AddRawProjectConfig(tx, ctx.toInsert)
 		if err != nil {
 			err = errors.Wrapf(err, "execute new rules creation failed")
 			return
 		}
 	}
 
-	rm.Set(ctx.dbID, r)
+	if newVersion {
+		err = model.AddProjectRulesVersion(tx, getRulesAuthor(c), rawRules, maxRulesVersions)
+		if err != nil {
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrapf(err, "commit rules config transaction failed")
+	}
 
 	return
 }
 
+// loadRules returns the cached rules of project, the rules are compiled from persisted config on cold cache.
+// The project database is never written on this path.
 func loadRules(c *gin.Context, dbID proto.DatabaseID, db *gorp.DbMap) (r *resolver.Rules, err error) {
 	rm := getRulesManager(c)
 
 	r = rm.Get(dbID)
 	if r == nil {
-		var ctx *projectRulesContext
+		var (
+			ctx      *projectRulesContext
+			rawRules json.RawMessage
+		)
 		ctx, err = getRulesContext(dbID, db)
 		if err != nil {
 			err = errors.Wrapf(err, "get rules failed")
 			return
 		}
 
-		r, err = populateRulesContext(c, ctx)
+		if rawRules, err = buildRawRules(ctx); err != nil {
+			err = errors.Wrapf(err, "build rules failed")
+			return
+		}
+
+		if r, err = rm.Reload(dbID, rawRules, db); err != nil {
+			err = errors.Wrapf(err, "reload rules failed")
+			return
+		}
+	}
+
+	return
+}
+
+func reloadProjectRules(c *gin.Context) {
+	r := struct {
+		DB proto.DatabaseID `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	rulesCtx, err := getRulesContext(r.DB, projectDB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesFailed)
+		return
+	}
+
+	// re-compile rules with group provider bound to project database
+	if _, err = populateRulesContext(c, rulesCtx); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrReloadProjectRulesFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"project": r.DB,
+		"db":      r.DB,
+	})
+}
+
+func explainProjectRules(c *gin.Context) {
+	r := struct {
+		DB     proto.DatabaseID       `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+		Table  string                 `json:"table" form:"table" binding:"required,max=128"`
+		Type   string                 `json:"type" form:"type" binding:"required"`
+		User   int64                  `json:"user" form:"user" binding:"omitempty,gt=0"`
+		State  string                 `json:"state" form:"state"`
+		Filter map[string]interface{} `json:"filter" form:"filter"`
+		Data   map[string]interface{} `json:"data" form:"data"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	resolver.CheckAndBindParams(c, &r.Filter, "filter")
+	resolver.CheckAndBindParams(c, &r.Data, "data")
+
+	qt, err := resolver.ParseRuleQueryType(r.Type)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	rules, err := loadRules(c, r.DB, projectDB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesFailed)
+		return
+	}
+
+	uid, userState, vars, err := buildUserContext(projectDB, r.User, rules, "")
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrGetProjectUserFailed)
+		return
+	}
+
+	if r.State != "" {
+		// simulate query in specified user state
+		userState = strings.ToLower(r.State)
+	}
+
+	vars, err = resolver.ResolveMagicVars(&resolver.MagicVarContext{
+		DatabaseID: r.DB,
+		Table:      r.Table,
+		UserID:     uid,
+		UserState:  userState,
+		Request:    c.Request,
+		Vars:       vars,
+	})
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrExplainProjectRulesFailed)
+		return
+	}
+
+	explanation, err := getRulesManager(c).ExplainEnforcement(
+		r.DB, r.Table, qt, uid, userState, r.Filter, r.Data, vars)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrExplainProjectRulesFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"project":     r.DB,
+		"db":          r.DB,
+		"explanation": explanation,
+	})
+}
+
+func testProjectRules(c *gin.Context) {
+	r := struct {
+		DB    proto.DatabaseID          `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+		Rules json.RawMessage           `json:"rules"`
+		Cases []*resolver.RulesTestCase `json:"cases" binding:"required,min=1"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBindJSON(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var (
+		report *resolver.RulesTestReport
+		err    error
+	)
+
+	if len(r.Rules) > 0 {
+		// test proposed rules before saving
+		report, err = resolver.TestRules(r.Rules, r.Cases)
+	} else {
+		var (
+			projectDB *gorp.DbMap
+			rules     *resolver.Rules
+		)
+
+		_, projectDB, err = getProjectDB(c, r.DB)
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+			return
+		}
+
+		rules, err = loadRules(c, r.DB, projectDB)
 		if err != nil {
-			err = errors.Wrapf(err, "populate rules failed")
+			_ = c.Error(err)
+			abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesFailed)
 			return
 		}
 
-		rm.Set(dbID, r)
+		report, err = rules.Test(r.Cases)
+	}
+
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrTestProjectRulesFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"project": r.DB,
+		"db":      r.DB,
+		"report":  report,
+	})
+}
+
+func listProjectRulesVersions(c *gin.Context) {
+	r := struct {
+		DB proto.DatabaseID `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	// ensure the current rules is loaded as a version
+	if _, err = loadRules(c, r.DB, projectDB); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesFailed)
+		return
+	}
+
+	versions, err := model.GetProjectRulesVersions(projectDB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesVersionFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"project":  r.DB,
+		"db":       r.DB,
+		"versions": rulesVersionsInfo(versions),
+	})
+}
+
+func diffProjectRulesVersions(c *gin.Context) {
+	r := struct {
+		DB   proto.DatabaseID `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+		From int64            `json:"from" form:"from" binding:"required,gt=0"`
+		To   int64            `json:"to" form:"to" binding:"required,gt=0"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	from, err := model.GetProjectRulesVersion(projectDB, r.From)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrGetProjectRulesVersionFailed)
+		return
+	}
+
+	to, err := model.GetProjectRulesVersion(projectDB, r.To)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrGetProjectRulesVersionFailed)
+		return
+	}
+
+	diffs, err := resolver.DiffRawRules(from.Rules, to.Rules)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrGetProjectRulesVersionFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"project": r.DB,
+		"db":      r.DB,
+		"from":    r.From,
+		"to":      r.To,
+		"diff":    diffs,
+	})
+}
+
+func rollbackProjectRules(c *gin.Context) {
+	r := struct {
+		DB      proto.DatabaseID `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+		Version int64            `json:"version" form:"version" binding:"required,gt=0"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	v, err := model.GetProjectRulesVersion(projectDB, r.Version)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrGetProjectRulesVersionFailed)
+		return
+	}
+
+	rulesCtx, err := getRulesContext(r.DB, projectDB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesFailed)
+		return
+	}
+
+	if err = restoreRulesContext(rulesCtx, v.Rules); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrRollbackProjectRulesFailed)
+		return
+	}
+
+	if _, err = populateRulesContext(c, rulesCtx); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrPopulateProjectRulesFailed)
+		return
+	}
+
+	versions, err := model.GetProjectRulesVersions(projectDB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesVersionFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"project":  r.DB,
+		"db":       r.DB,
+		"version":  r.Version,
+		"versions": rulesVersionsInfo(versions),
+	})
+}
+
+// restoreRulesContext prepares the table rules and group config updates of raw rules config in
+// rules context, which are persisted in one transaction by populateRulesContext.
+func restoreRulesContext(ctx *projectRulesContext, rawRules json.RawMessage) (err error) {
+	var cfg struct {
+		Groups   map[string][]string        `json:"groups"`
+		Rules    map[string]json.RawMessage `json:"rules"`
+		States   []string                   `json:"states"`
+		Source   *model.ProjectGroupSource  `json:"group_source"`
+		External *model.ProjectExternalAuth `json:"external"`
+	}
+
+	if err = json.Unmarshal(rawRules, &cfg); err != nil {
+		err = errors.Wrapf(err, "decode rules version failed")
+		return
+	}
+
+	// validate before any config being persisted
+	if _, err = resolver.CompileRawRules(rawRules); err != nil {
+		err = errors.Wrapf(err, "compile rules version failed")
+		return
+	}
+
+	gc := &model.ProjectGroupConfig{
+		Groups:   map[string][]int64{},
+		States:   cfg.States,
+		Source:   cfg.Source,
+		External: cfg.External,
+	}
+
+	for groupName, userIDs := range cfg.Groups {
+		for _, userID := range userIDs {
+			var id int64
+			if id, err = strconv.ParseInt(userID, 10, 64); err != nil {
+				err = errors.Wrapf(err, "invalid user id %s in group %s", userID, groupName)
+				return
+			}
+			gc.Groups[groupName] = append(gc.Groups[groupName], id)
+		}
+	}
+
+	for tableName, pc := range ctx.tables {
+		tableRule, ok := cfg.Rules[tableName]
+		if !ok {
+			// table created after the version, keep current rules
+			continue
+		}
+
+		ptc := pc.Value.(*model.ProjectTableConfig)
+		if bytes.Equal(ptc.Rules, tableRule) {
+			continue
+		}
+
+		ptc.Rules = tableRule
+		ctx.tableUpdates = append(ctx.tableUpdates, pc)
+	}
+
+	if ctx.group == nil {
+		ctx.group = &model.ProjectConfig{
+			Type:  model.ProjectConfigGroup,
+			Key:   "",
+			Value: gc,
+		}
+		ctx.toInsert = ctx.group
+	} else {
+		ctx.group.Value = gc
+		ctx.toUpdate = ctx.group
 	}
 
 	return
 }
 
+func rulesVersionsInfo(versions []*model.ProjectRulesVersion) (info []gin.H) {
+	info = make([]gin.H, 0, len(versions))
+	for _, v := range versions {
+		info = append(info, v.Info())
+	}
+	return
+}
+
+func getRulesAuthor(c *gin.Context) string {
+	if developerID := getDeveloperID(c); developerID != 0 {
+		return fmt.Sprintf("developer:%d", developerID)
+	}
+
+	return "proxy"
+}
+
 func getRulesManager(c *gin.Context) (r *resolver.RulesManager) {
 	return c.MustGet("rules").(*resolver.RulesManager)
 }
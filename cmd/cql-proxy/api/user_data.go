@@ -19,12 +19,14 @@ package api
 import (
 	"database/sql"
 	"fmt"
+	"math"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	gorp "gopkg.in/gorp.v2"
 
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/hook"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/resolver"
 )
@@ -60,7 +62,14 @@ func userDataFind(c *gin.Context) {
 		return
 	}
 
-	var filter map[string]interface{}
+	if !adminMode && !enforceRateLimit(c, rules, r.Table, resolver.RuleQueryFind, uid, userState) {
+		return
+	}
+
+	var (
+		filter map[string]interface{}
+		mask   resolver.ColumnMask
+	)
 
 	if !adminMode {
 		filter, err = rules.EnforceRulesOnFilter(r.Filter, r.Table, uid, userState, vars, resolver.RuleQueryFind)
@@ -69,6 +78,18 @@ func userDataFind(c *gin.Context) {
 			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
 			return
 		}
+
+		if fieldMap, mask, err = enforceColumnMask(rules, r.Table, uid, userState, r.Filter, fieldMap); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
+			return
+		}
+
+		if r.Limit, err = rules.ResolveRowLimit(r.Table, resolver.RuleQueryFind, uid, userState, r.Limit); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusBadRequest, ErrUnboundedQueryRefused)
+			return
+		}
 	} else {
 		filter = r.Filter
 	}
@@ -95,6 +116,10 @@ func userDataFind(c *gin.Context) {
 		return
 	}
 
+	for _, row := range result {
+		mask.Apply(row)
+	}
+
 	responseWithData(c, http.StatusOK, result)
 }
 
@@ -122,13 +147,23 @@ func userDataInsert(c *gin.Context) {
 		return
 	}
 
+	if !adminMode && !enforceRateLimit(c, rules, r.Table, resolver.RuleQueryInsert, uid, userState) {
+		return
+	}
+
+	hooks, hookResp, ok := runBeforeDataHooks(c, db, hook.EventBeforeInsert, r.Table, r.Data, nil, vars)
+	if !ok {
+		return
+	}
+
+	r.Data = hookResp.Data
+
 	var insertData map[string]interface{}
 
 	if !adminMode {
 		insertData, err = rules.EnforceRulesOnInsert(r.Data, r.Table, uid, userState, vars)
 		if err != nil {
-			_ = c.Error(err)
-			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
+			abortWithEnforceError(c, err)
 			return
 		}
 	} else {
@@ -148,10 +183,14 @@ func userDataInsert(c *gin.Context) {
 		return
 	}
 
-	responseWithData(c, http.StatusOK, gin.H{
+	resp := gin.H{
 		"last_insert_id": mustGetInt64Var(result.LastInsertId()),
 		"affected_rows":  mustGetInt64Var(result.RowsAffected()),
-	})
+	}
+
+	runAfterDataHooks(c, db, hooks, hook.EventAfterInsert, r.Table, insertData, nil, vars, resp, hookResp.Writes)
+
+	responseWithData(c, http.StatusOK, resp)
 }
 
 func userDataUpdate(c *gin.Context) {
@@ -181,6 +220,17 @@ func userDataUpdate(c *gin.Context) {
 		return
 	}
 
+	if !adminMode && !enforceRateLimit(c, rules, r.Table, resolver.RuleQueryUpdate, uid, userState) {
+		return
+	}
+
+	hooks, hookResp, ok := runBeforeDataHooks(c, db, hook.EventBeforeUpdate, r.Table, r.Update, r.Filter, vars)
+	if !ok {
+		return
+	}
+
+	r.Update = hookResp.Data
+
 	var (
 		filter map[string]interface{}
 		update map[string]interface{}
@@ -196,8 +246,7 @@ func userDataUpdate(c *gin.Context) {
 
 		update, err = rules.EnforceRulesOnUpdate(r.Update, r.Table, uid, userState, vars)
 		if err != nil {
-			_ = c.Error(err)
-			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
+			abortWithEnforceError(c, err)
 			return
 		}
 	} else {
@@ -218,9 +267,13 @@ func userDataUpdate(c *gin.Context) {
 		return
 	}
 
-	responseWithData(c, http.StatusOK, gin.H{
+	resp := gin.H{
 		"affected_rows": mustGetInt64Var(result.RowsAffected()),
-	})
+	}
+
+	runAfterDataHooks(c, db, hooks, hook.EventAfterUpdate, r.Table, update, filter, vars, resp, hookResp.Writes)
+
+	responseWithData(c, http.StatusOK, resp)
 }
 
 func userDataRemove(c *gin.Context) {
@@ -248,6 +301,15 @@ func userDataRemove(c *gin.Context) {
 		return
 	}
 
+	if !adminMode && !enforceRateLimit(c, rules, r.Table, resolver.RuleQueryRemove, uid, userState) {
+		return
+	}
+
+	hooks, hookResp, ok := runBeforeDataHooks(c, db, hook.EventBeforeRemove, r.Table, nil, r.Filter, vars)
+	if !ok {
+		return
+	}
+
 	var filter map[string]interface{}
 
 	if !adminMode {
@@ -261,6 +323,31 @@ func userDataRemove(c *gin.Context) {
 		filter = r.Filter
 	}
 
+	if rules.HasCascade(r.Table) {
+		statements, err := rules.RemoveWithCascade(r.Table, fieldMap, filter, r.JustOne, vars)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		affectedRows, err := execInTransaction(db, statements)
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusBadRequest, ErrExecuteQueryFailed)
+			return
+		}
+
+		// affected rows includes the rows affected by cascade operations
+		resp := gin.H{
+			"affected_rows": affectedRows,
+		}
+
+		runAfterDataHooks(c, db, hooks, hook.EventAfterRemove, r.Table, nil, filter, vars, resp, hookResp.Writes)
+
+		responseWithData(c, http.StatusOK, resp)
+		return
+	}
+
 	stmt, args, _, err := resolver.Remove(r.Table, fieldMap, filter, r.JustOne)
 	if err != nil {
 		abortWithError(c, http.StatusBadRequest, err)
@@ -274,9 +361,43 @@ func userDataRemove(c *gin.Context) {
 		return
 	}
 
-	responseWithData(c, http.StatusOK, gin.H{
+	resp := gin.H{
 		"affected_rows": mustGetInt64Var(result.RowsAffected()),
-	})
+	}
+
+	runAfterDataHooks(c, db, hooks, hook.EventAfterRemove, r.Table, nil, filter, vars, resp, hookResp.Writes)
+
+	responseWithData(c, http.StatusOK, resp)
+}
+
+// execInTransaction executes statements atomically, returns total affected rows of all statements.
+func execInTransaction(db *gorp.DbMap, statements []*resolver.Statement) (affectedRows int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		err = errors.Wrapf(err, "begin transaction failed")
+		return
+	}
+
+	var result sql.Result
+
+	for _, s := range statements {
+		if result, err = tx.Exec(s.Query, s.Args...); err != nil {
+			_ = tx.Rollback()
+			err = errors.Wrapf(err, "execute statement failed")
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrapf(err, "commit transaction failed")
+		return
+	}
+
+	if result != nil {
+		affectedRows, err = result.RowsAffected()
+	}
+
+	return
 }
 
 func userDataCount(c *gin.Context) {
@@ -303,6 +424,10 @@ func userDataCount(c *gin.Context) {
 		return
 	}
 
+	if !adminMode && !enforceRateLimit(c, rules, r.Table, resolver.RuleQueryCount, uid, userState) {
+		return
+	}
+
 	var filter map[string]interface{}
 
 	if !adminMode {
@@ -312,6 +437,12 @@ func userDataCount(c *gin.Context) {
 			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
 			return
 		}
+
+		if fieldMap, _, err = enforceColumnMask(rules, r.Table, uid, userState, r.Filter, fieldMap); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
+			return
+		}
 	} else {
 		filter = r.Filter
 	}
@@ -334,31 +465,125 @@ func userDataCount(c *gin.Context) {
 	})
 }
 
-func buildExecuteContext(c *gin.Context, tableName string) (projectDB *gorp.DbMap, uid string, userState string,
-	vars map[string]interface{}, r *resolver.Rules, fields resolver.FieldMap, adminMode bool, err error) {
-	project := getCurrentProject(c)
-	projectDB, err = getCurrentProjectDB(c)
+func userDataAggregate(c *gin.Context) {
+	r := struct {
+		Table       string                 `json:"table" form:"table" uri:"table" binding:"required,max=128"`
+		Filter      map[string]interface{} `json:"filter" form:"filter"`
+		GroupBy     []string               `json:"group" form:"group"`
+		Aggregation map[string]interface{} `json:"aggregate" form:"aggregate"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	resolver.CheckAndBindParams(c, &r.Filter, "filter")
+	resolver.CheckAndBindParams(c, &r.Aggregation, "aggregate")
+
+	db, uid, userState, vars, rules, fieldMap, adminMode, err := buildExecuteContext(c, r.Table)
 	if err != nil {
-		err = errors.Wrapf(err, "load project database failed")
+		if err != ErrProjectIsDisabled {
+			err = ErrPrepareExecutionContextFailed
+		}
+		abortWithError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	var (
-		userID      = getUserID(c)
-		developerID = getDeveloperID(c)
-		userInfo    *model.ProjectUser
-	)
+	if !adminMode && !enforceRateLimit(c, rules, r.Table, resolver.RuleQueryAggregate, uid, userState) {
+		return
+	}
 
-	if userID != 0 {
-		userInfo, err = model.GetProjectUser(projectDB, userID)
+	var filter map[string]interface{}
+
+	if !adminMode {
+		filter, err = rules.EnforceRulesOnFilter(r.Filter, r.Table, uid, userState, vars, resolver.RuleQueryAggregate)
 		if err != nil {
-			err = errors.Wrapf(err, "get project user info failed")
+			_ = c.Error(err)
+			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
 			return
 		}
 
-		uid = fmt.Sprint(userID)
+		var mask resolver.ColumnMask
+		if _, mask, err = enforceColumnMask(rules, r.Table, uid, userState, r.Filter, fieldMap); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
+			return
+		}
+
+		// masked columns could not be grouped or aggregated
+		fieldMap = mask.ExcludeAvailFields(fieldMap)
+	} else {
+		filter = r.Filter
 	}
 
+	stmt, args, _, err := resolver.Aggregate(r.Table, fieldMap, filter, r.GroupBy, r.Aggregation)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var rows *sql.Rows
+	rows, err = db.Query(stmt, args...)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrExecuteQueryFailed)
+		return
+	}
+
+	var result []gin.H
+	result, err = scanRows(rows)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrScanRowsFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, result)
+}
+
+func buildExecuteContext(c *gin.Context, tableName string) (projectDB *gorp.DbMap, uid string, userState string,
+	vars map[string]interface{}, r *resolver.Rules, fields resolver.FieldMap, adminMode bool, err error) {
+	projectDB, uid, userState, vars, r, adminMode, err = buildUserQueryContext(c, tableName)
+	if err != nil {
+		return
+	}
+
+	// load table fields
+	_, ptc, err := model.GetProjectTableConfig(projectDB, tableName)
+	if err != nil {
+		err = errors.Wrapf(err, "get project table config failed")
+		return
+	}
+
+	if ptc.IsDeleted {
+		err = errors.New("table does not exists")
+		return
+	}
+
+	fields = resolver.FieldMap{}
+
+	for _, c := range ptc.Columns {
+		fields[c] = true
+	}
+
+	return
+}
+
+// buildUserQueryContext prepares project database, rules and user context for query on table.
+func buildUserQueryContext(c *gin.Context, tableName string) (projectDB *gorp.DbMap, uid string, userState string,
+	vars map[string]interface{}, r *resolver.Rules, adminMode bool, err error) {
+	project := getCurrentProject(c)
+	projectDB, err = getCurrentProjectDB(c)
+	if err != nil {
+		err = errors.Wrapf(err, "load project database failed")
+		return
+	}
+
+	var developerID = getDeveloperID(c)
+
 	if developerID != 0 && project.Developer == developerID {
 		// table accessing from admin mode, check admin project belonging
 		adminMode = true
@@ -374,6 +599,109 @@ func buildExecuteContext(c *gin.Context, tableName string) (projectDB *gorp.DbMa
 		}
 	}
 
+	r, err = loadRules(c, project.DB, projectDB)
+	if err != nil {
+		err = errors.Wrapf(err, "load rules failed")
+		return
+	}
+
+	claimState, _ := getSession(c).GetString("jwt_state")
+
+	uid, userState, vars, err = buildUserContext(projectDB, getUserID(c), r, claimState)
+	if err != nil {
+		return
+	}
+
+	vars, err = resolver.ResolveMagicVars(&resolver.MagicVarContext{
+		DatabaseID: project.DB,
+		Table:      tableName,
+		UserID:     uid,
+		UserState:  userState,
+		Request:    c.Request,
+		Vars:       vars,
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "resolve magic variables failed")
+	}
+
+	return
+}
+
+func abortWithEnforceError(c *gin.Context, err error) {
+	_ = c.Error(err)
+
+	if vErr, ok := err.(*resolver.ValidationError); ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     ErrQueryValidationFailed.Error(),
+			"data":    vErr,
+		})
+		return
+	}
+
+	abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
+}
+
+func enforceRateLimit(c *gin.Context, rules *resolver.Rules, table string, qt resolver.RuleQueryType,
+	uid string, userState string) bool {
+	client := uid
+	if client == "" {
+		// anonymous user, limit by remote address
+		client = c.ClientIP()
+	}
+
+	err := getRateLimiter(c).Allow(getCurrentProject(c).DB, rules, table, qt, uid, userState, client)
+	if err == nil {
+		return true
+	}
+
+	if qe, ok := err.(*resolver.QuotaError); ok {
+		_ = c.Error(err)
+		c.Header("Retry-After", fmt.Sprint(int64(math.Ceil(qe.RetryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"success": false,
+			"msg":     ErrQuotaExceeded.Error(),
+			"data":    qe,
+		})
+	} else {
+		abortWithError(c, http.StatusInternalServerError, err)
+	}
+
+	return false
+}
+
+func enforceColumnMask(rules *resolver.Rules, table string, uid string, userState string,
+	filter map[string]interface{}, fields resolver.FieldMap) (
+	maskedFields resolver.FieldMap, mask resolver.ColumnMask, err error) {
+	mask, err = rules.EnforceMask(table, uid, userState)
+	if err != nil {
+		return
+	}
+
+	// user provided filter could not reference masked columns
+	if err = mask.CheckFilter(filter, fields); err != nil {
+		return
+	}
+
+	maskedFields = mask.FilterAvailFields(fields)
+
+	return
+}
+
+func buildUserContext(projectDB *gorp.DbMap, userID int64, r *resolver.Rules, claimState string) (
+	uid string, userState string, vars map[string]interface{}, err error) {
+	var userInfo *model.ProjectUser
+
+	if userID != 0 {
+		userInfo, err = model.GetProjectUser(projectDB, userID)
+		if err != nil {
+			err = errors.Wrapf(err, "get project user info failed")
+			return
+		}
+
+		uid = fmt.Sprint(userID)
+	}
+
 	vars = map[string]interface{}{}
 
 	if userInfo == nil {
@@ -403,31 +731,18 @@ func buildExecuteContext(c *gin.Context, tableName string) (projectDB *gorp.DbMa
 		case model.ProjectUserStateWaitSignedConfirm:
 			userState = resolver.UserStateWaitSignUpConfirm
 		}
-	}
-
-	r, err = loadRules(c, project.DB, projectDB)
-	if err != nil {
-		err = errors.Wrapf(err, "load rules failed")
-		return
-	}
 
-	// load table fields
-	_, ptc, err := model.GetProjectTableConfig(projectDB, tableName)
-	if err != nil {
-		err = errors.Wrapf(err, "get project table config failed")
-		return
-	}
-
-	if ptc.IsDeleted {
-		err = errors.New("table does not exists")
-		return
+		if r != nil {
+			// user state claimed in external identity provider token takes precedence
+			customState := userInfo.CustomState()
+			if claimState != "" {
+				customState = claimState
+			}
+			userState = r.ResolveUserState(userState, customState)
+		}
 	}
 
-	fields = resolver.FieldMap{}
-
-	for _, c := range ptc.Columns {
-		fields[c] = true
-	}
+	vars["user_state"] = userState
 
 	return
 }
@@ -0,0 +1,639 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	gorp "gopkg.in/gorp.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/resolver"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const (
+	defaultFileMaxSize    = 10 * 1024 * 1024
+	defaultFileChunkSize  = 256 * 1024
+	defaultFileURLExpires = time.Hour
+)
+
+// fileFields defines the file metadata columns available to file rules.
+var fileFields = resolver.FieldMap{
+	"id":           true,
+	"name":         true,
+	"content_type": true,
+	"size":         true,
+	"chunk_size":   true,
+	"checksum":     true,
+	"owner":        true,
+	"state":        true,
+	"created":      true,
+	"last_updated": true,
+}
+
+type fileContext struct {
+	db        *gorp.DbMap
+	cfg       *model.ProjectFilesConfig
+	uid       string
+	userState string
+	vars      map[string]interface{}
+	rules     *resolver.Rules
+	adminMode bool
+}
+
+func updateProjectFilesConfig(c *gin.Context) {
+	r := struct {
+		DB proto.DatabaseID `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+		model.ProjectFilesConfig
+		// additional parameters, see ProjectFilesConfig structure
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	rulesCtx, err := getRulesContext(r.DB, projectDB)
+	if err != nil {
+		// get rules context failed
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectRulesFailed)
+		return
+	}
+
+	cfg := &r.ProjectFilesConfig
+
+	if rulesCtx.files == nil {
+		rulesCtx.files = &model.ProjectConfig{
+			Type:  model.ProjectConfigFiles,
+			Key:   "",
+			Value: cfg,
+		}
+		rulesCtx.toInsert = rulesCtx.files
+	} else {
+		// keep existing sign key and enabled status if not provided
+		pfc := rulesCtx.files.Value.(*model.ProjectFilesConfig)
+		cfg.SignKey = pfc.SignKey
+		if cfg.Enabled == nil {
+			cfg.Enabled = pfc.Enabled
+		}
+		rulesCtx.files.Value = cfg
+		rulesCtx.toUpdate = rulesCtx.files
+	}
+
+	if cfg.SignKey == "" {
+		var key [32]byte
+		if _, err = rand.Read(key[:]); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusInternalServerError, ErrUpdateProjectConfigFailed)
+			return
+		}
+		cfg.SignKey = hex.EncodeToString(key[:])
+	}
+
+	if _, err = populateRulesContext(c, rulesCtx); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrPopulateProjectRulesFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"files": rulesCtx.files.Value,
+	})
+}
+
+func userFileUpload(c *gin.Context) {
+	r := struct {
+		Name        string `json:"name" form:"name" binding:"max=256"`
+		ContentType string `json:"content_type" form:"content_type" binding:"max=128"`
+		Size        int64  `json:"size" form:"size" binding:"gte=0"`
+	}{}
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	fc, ok := buildFileContextOrAbort(c)
+	if !ok {
+		return
+	}
+
+	if !fc.adminMode && !enforceRateLimit(c, fc.rules, metaTableFile, resolver.RuleQueryInsert, fc.uid, fc.userState) {
+		return
+	}
+
+	// upload in single request if file is provided in multipart form, otherwise start chunked upload
+	fh, fileErr := c.FormFile("file")
+	if fileErr == nil {
+		r.Size = fh.Size
+		if r.Name == "" {
+			r.Name = fh.Filename
+		}
+		if r.ContentType == "" {
+			r.ContentType = fh.Header.Get("Content-Type")
+		}
+	} else if r.Size <= 0 {
+		abortWithError(c, http.StatusBadRequest, ErrInvalidFileSize)
+		return
+	}
+
+	if r.Size > fc.cfg.MaxSize {
+		abortWithError(c, http.StatusRequestEntityTooLarge, ErrFileTooLarge)
+		return
+	}
+
+	if r.ContentType == "" {
+		r.ContentType = mime.TypeByExtension(path.Ext(r.Name))
+	}
+	if r.ContentType == "" {
+		r.ContentType = "application/octet-stream"
+	}
+
+	if !fc.adminMode {
+		_, err := fc.rules.EnforceRulesOnInsert(map[string]interface{}{
+			"name":         r.Name,
+			"content_type": r.ContentType,
+			"size":         r.Size,
+			"owner":        getUserID(c),
+		}, metaTableFile, fc.uid, fc.userState, fc.vars)
+		if err != nil {
+			abortWithEnforceError(c, err)
+			return
+		}
+	}
+
+	f, err := model.NewProjectFile(fc.db, r.Name, r.ContentType, r.Size, fc.cfg.ChunkSize, getUserID(c))
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrSaveFileFailed)
+		return
+	}
+
+	if fileErr != nil {
+		responseWithData(c, http.StatusOK, f.Info())
+		return
+	}
+
+	src, err := fh.Open()
+	if err != nil {
+		_ = c.Error(err)
+		_ = model.DeleteProjectFile(fc.db, f.ID)
+		abortWithError(c, http.StatusBadRequest, ErrInvalidFileChunk)
+		return
+	}
+
+	defer src.Close()
+
+	h := sha256.New()
+	buf := make([]byte, f.ChunkSize)
+
+	for seq := int64(0); seq < f.Chunks(); seq++ {
+		chunk := buf[:f.ChunkLen(seq)]
+		if _, err = io.ReadFull(src, chunk); err == nil {
+			err = model.SaveProjectFileChunk(fc.db, f.ID, seq, chunk)
+		}
+		if err != nil {
+			_ = c.Error(err)
+			_ = model.DeleteProjectFile(fc.db, f.ID)
+			abortWithError(c, http.StatusInternalServerError, ErrSaveFileFailed)
+			return
+		}
+		_, _ = h.Write(chunk)
+	}
+
+	if err = model.CompleteProjectFile(fc.db, f, hex.EncodeToString(h.Sum(nil))); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrSaveFileFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, f.Info())
+}
+
+func userFileUploadChunk(c *gin.Context) {
+	r := struct {
+		ID  string `json:"id" form:"id" uri:"id" binding:"required,max=64"`
+		Seq int64  `json:"seq" form:"seq" uri:"seq" binding:"gte=0"`
+	}{}
+
+	if err := c.ShouldBindUri(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	fc, ok := buildFileContextOrAbort(c)
+	if !ok {
+		return
+	}
+
+	f, ok := getUploadingFileOrAbort(c, fc, r.ID)
+	if !ok {
+		return
+	}
+
+	chunkLen := f.ChunkLen(r.Seq)
+	if chunkLen == 0 {
+		abortWithError(c, http.StatusBadRequest, ErrInvalidFileChunk)
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, chunkLen+1))
+	if err != nil || int64(len(data)) != chunkLen {
+		abortWithError(c, http.StatusBadRequest, ErrInvalidFileChunk)
+		return
+	}
+
+	if err = model.SaveProjectFileChunk(fc.db, f.ID, r.Seq, data); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrSaveFileFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"id":   f.ID,
+		"seq":  r.Seq,
+		"size": chunkLen,
+	})
+}
+
+func userFileComplete(c *gin.Context) {
+	r := struct {
+		ID string `json:"id" form:"id" uri:"id" binding:"required,max=64"`
+	}{}
+
+	if err := c.ShouldBindUri(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	fc, ok := buildFileContextOrAbort(c)
+	if !ok {
+		return
+	}
+
+	f, ok := getUploadingFileOrAbort(c, fc, r.ID)
+	if !ok {
+		return
+	}
+
+	sizes, err := model.GetProjectFileChunkSizes(fc.db, f.ID)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetFileFailed)
+		return
+	}
+
+	var missing []int64
+
+	for seq := int64(0); seq < f.Chunks(); seq++ {
+		if sizes[seq] != f.ChunkLen(seq) {
+			missing = append(missing, seq)
+		}
+	}
+
+	if len(missing) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     ErrIncompleteFileUpload.Error(),
+			"data": gin.H{
+				"missing": missing,
+			},
+		})
+		return
+	}
+
+	h := sha256.New()
+
+	for seq := int64(0); seq < f.Chunks(); seq++ {
+		var data []byte
+		if data, err = model.GetProjectFileChunk(fc.db, f.ID, seq); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusInternalServerError, ErrGetFileFailed)
+			return
+		}
+		_, _ = h.Write(data)
+	}
+
+	if err = model.CompleteProjectFile(fc.db, f, hex.EncodeToString(h.Sum(nil))); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrSaveFileFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, f.Info())
+}
+
+func userFileInfo(c *gin.Context) {
+	r := struct {
+		ID string `json:"id" form:"id" uri:"id" binding:"required,max=64"`
+	}{}
+
+	if err := c.ShouldBindUri(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	fc, ok := buildFileContextOrAbort(c)
+	if !ok {
+		return
+	}
+
+	f, ok := findFileOrAbort(c, fc, r.ID, resolver.RuleQueryFind)
+	if !ok {
+		return
+	}
+
+	responseWithData(c, http.StatusOK, f.Info())
+}
+
+func userFileSignURL(c *gin.Context) {
+	r := struct {
+		ID string `json:"id" form:"id" uri:"id" binding:"required,max=64"`
+	}{}
+
+	if err := c.ShouldBindUri(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	fc, ok := buildFileContextOrAbort(c)
+	if !ok {
+		return
+	}
+
+	f, ok := findFileOrAbort(c, fc, r.ID, resolver.RuleQueryFind)
+	if !ok {
+		return
+	}
+
+	if f.State != model.ProjectFileStateComplete {
+		abortWithError(c, http.StatusBadRequest, ErrIncompleteFileUpload)
+		return
+	}
+
+	// request host and forwarded headers are client controlled, use configured url only
+	cfg := getConfig(c)
+	if cfg == nil || cfg.PublicURL == "" {
+		abortWithError(c, http.StatusInternalServerError, ErrNoPublicServiceHosts)
+		return
+	}
+
+	expires := time.Now().Add(fc.cfg.URLExpires).Unix()
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"url": fmt.Sprintf("%s/v3/files/%s/download?project=%s&expires=%d&signature=%s",
+			strings.TrimRight(cfg.PublicURL, "/"), f.ID, getCurrentProject(c).DB, expires,
+			signFileURL(fc.cfg.SignKey, f.ID, expires)),
+		"expires": expires,
+	})
+}
+
+func userFileDownload(c *gin.Context) {
+	r := struct {
+		ID        string `json:"id" form:"id" uri:"id" binding:"required,max=64"`
+		Expires   int64  `json:"expires" form:"expires"`
+		Signature string `json:"signature" form:"signature"`
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	fc, ok := buildFileContextOrAbort(c)
+	if !ok {
+		return
+	}
+
+	var f *model.ProjectFile
+
+	if r.Signature != "" {
+		// signed url is accessible without find rules
+		if r.Expires < time.Now().Unix() || !hmac.Equal([]byte(r.Signature),
+			[]byte(signFileURL(fc.cfg.SignKey, r.ID, r.Expires))) {
+			abortWithError(c, http.StatusForbidden, ErrInvalidFileSignature)
+			return
+		}
+
+		var err error
+		if f, err = model.GetProjectFile(fc.db, r.ID); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusNotFound, ErrFileNotFound)
+			return
+		}
+	} else if f, ok = findFileOrAbort(c, fc, r.ID, resolver.RuleQueryFind); !ok {
+		return
+	}
+
+	if f.State != model.ProjectFileStateComplete {
+		abortWithError(c, http.StatusBadRequest, ErrIncompleteFileUpload)
+		return
+	}
+
+	c.Header("Content-Type", f.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(f.Size, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": f.Name,
+	}))
+	c.Header("ETag", `"`+f.Checksum+`"`)
+	c.Status(http.StatusOK)
+
+	for seq := int64(0); seq < f.Chunks(); seq++ {
+		data, err := model.GetProjectFileChunk(fc.db, f.ID, seq)
+		if err != nil {
+			// response is already partially sent, abort the connection
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		if _, err = c.Writer.Write(data); err != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+	}
+}
+
+func userFileRemove(c *gin.Context) {
+	r := struct {
+		ID string `json:"id" form:"id" uri:"id" binding:"required,max=64"`
+	}{}
+
+	if err := c.ShouldBindUri(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	fc, ok := buildFileContextOrAbort(c)
+	if !ok {
+		return
+	}
+
+	f, ok := findFileOrAbort(c, fc, r.ID, resolver.RuleQueryRemove)
+	if !ok {
+		return
+	}
+
+	if err := model.DeleteProjectFile(fc.db, f.ID); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrRemoveFileFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"id": f.ID,
+	})
+}
+
+func buildFileContextOrAbort(c *gin.Context) (fc *fileContext, ok bool) {
+	fc, err := buildFileContext(c)
+	if err != nil {
+		_ = c.Error(err)
+		switch errors.Cause(err) {
+		case ErrProjectIsDisabled, ErrFileStorageDisabled:
+			abortWithError(c, http.StatusForbidden, errors.Cause(err))
+		default:
+			abortWithError(c, http.StatusInternalServerError, ErrPrepareExecutionContextFailed)
+		}
+		return
+	}
+
+	ok = true
+	return
+}
+
+func buildFileContext(c *gin.Context) (fc *fileContext, err error) {
+	fc = &fileContext{}
+
+	fc.db, fc.uid, fc.userState, fc.vars, fc.rules, fc.adminMode, err = buildUserQueryContext(c, metaTableFile)
+	if err != nil {
+		return
+	}
+
+	_, fc.cfg, err = model.GetProjectFilesConfig(fc.db)
+	if err != nil || !fc.cfg.IsEnabled() {
+		err = ErrFileStorageDisabled
+		return
+	}
+
+	if fc.cfg.MaxSize <= 0 {
+		fc.cfg.MaxSize = defaultFileMaxSize
+	}
+	if fc.cfg.ChunkSize <= 0 {
+		fc.cfg.ChunkSize = defaultFileChunkSize
+	}
+	if fc.cfg.URLExpires <= 0 {
+		fc.cfg.URLExpires = defaultFileURLExpires
+	}
+
+	return
+}
+
+// findFileOrAbort finds the file metadata with rules of specified query type enforced.
+func findFileOrAbort(c *gin.Context, fc *fileContext, id string, qt resolver.RuleQueryType) (
+	f *model.ProjectFile, ok bool) {
+	filter := map[string]interface{}{
+		"id": id,
+	}
+
+	if !fc.adminMode {
+		if !enforceRateLimit(c, fc.rules, metaTableFile, qt, fc.uid, fc.userState) {
+			return
+		}
+
+		var err error
+		filter, err = fc.rules.EnforceRulesOnFilter(filter, metaTableFile, fc.uid, fc.userState, fc.vars, qt)
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusForbidden, ErrEnforceRuleOnQueryFailed)
+			return
+		}
+	}
+
+	limit := int64(1)
+	stmt, args, _, err := resolver.Find(metaTableFile, fileFields, filter, nil, nil, nil, &limit)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetFileFailed)
+		return
+	}
+
+	var files []*model.ProjectFile
+	if _, err = fc.db.Select(&files, stmt, args...); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetFileFailed)
+		return
+	}
+
+	if len(files) == 0 {
+		abortWithError(c, http.StatusNotFound, ErrFileNotFound)
+		return
+	}
+
+	f, ok = files[0], true
+	return
+}
+
+// getUploadingFileOrAbort returns the file in uploading state, only uploader could continue the upload.
+func getUploadingFileOrAbort(c *gin.Context, fc *fileContext, id string) (f *model.ProjectFile, ok bool) {
+	f, err := model.GetProjectFile(fc.db, id)
+	if err != nil || (!fc.adminMode && f.Owner != getUserID(c)) {
+		abortWithError(c, http.StatusNotFound, ErrFileNotFound)
+		return
+	}
+
+	if f.State != model.ProjectFileStateUploading {
+		abortWithError(c, http.StatusBadRequest, ErrFileUploadCompleted)
+		return
+	}
+
+	ok = true
+	return
+}
+
+func signFileURL(key string, id string, expires int64) string {
+	h := hmac.New(sha256.New, []byte(key))
+	_, _ = fmt.Fprintf(h, "%s\n%d", id, expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,457 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	gorp "gopkg.in/gorp.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/gql"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/hook"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/resolver"
+)
+
+// graphQLError defines the graphql error with api error code and detail data in extensions.
+type graphQLError struct {
+	code error
+	data interface{}
+}
+
+// Error implements error interface.
+func (e *graphQLError) Error() string {
+	return e.code.Error()
+}
+
+// Extensions implements gqlerrors.ExtendedError interface.
+func (e *graphQLError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{
+		"code": e.code.Error(),
+	}
+	if e.data != nil {
+		ext["data"] = e.data
+	}
+	return ext
+}
+
+// graphQLExecutor executes graphql queries of current user with project rules and hooks enforced.
+type graphQLExecutor struct {
+	c         *gin.Context
+	db        *gorp.DbMap
+	uid       string
+	userState string
+	vars      map[string]interface{}
+	rules     *resolver.Rules
+	adminMode bool
+	fields    map[string]resolver.FieldMap
+	// skip rate limit for queries not requested by user directly, e.g. subscription refreshes.
+	noRateLimit bool
+}
+
+func userGraphQL(c *gin.Context) {
+	r := struct {
+		Query         string                 `json:"query" form:"query"`
+		Variables     map[string]interface{} `json:"variables" form:"-"`
+		OperationName string                 `json:"operationName" form:"operationName"`
+	}{}
+
+	var err error
+
+	if c.Request.Method == http.MethodGet {
+		// variables are json encoded in query string
+		if err = c.ShouldBindQuery(&r); err == nil && c.Query("variables") != "" {
+			err = json.Unmarshal([]byte(c.Query("variables")), &r.Variables)
+		}
+	} else {
+		err = c.ShouldBind(&r)
+	}
+
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if r.Query == "" {
+		abortWithError(c, http.StatusBadRequest, ErrMissingGraphQLQuery)
+		return
+	}
+
+	e, tables, ok := buildGraphQLExecutorOrAbort(c)
+	if !ok {
+		return
+	}
+
+	schema, err := getGraphQLManager(c).Get(string(getCurrentProject(c).DB), tables)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrBuildGraphQLSchemaFailed)
+		return
+	}
+
+	c.JSON(http.StatusOK, gql.Do(c.Request.Context(), schema, e, r.Query, r.Variables, r.OperationName))
+}
+
+// buildGraphQLExecutorOrAbort prepares the executor of current user and the tables of project.
+func buildGraphQLExecutorOrAbort(c *gin.Context) (e *graphQLExecutor, tables []*gql.Table, ok bool) {
+	e = &graphQLExecutor{
+		c:      c,
+		fields: map[string]resolver.FieldMap{},
+	}
+
+	var err error
+
+	e.db, e.uid, e.userState, e.vars, e.rules, e.adminMode, err = buildUserQueryContext(c, "")
+	if err != nil {
+		_ = c.Error(err)
+		if err != ErrProjectIsDisabled {
+			err = ErrPrepareExecutionContextFailed
+		}
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	configs, err := model.GetAllProjectConfig(e.db)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrGetProjectConfigFailed)
+		return
+	}
+
+	for _, p := range configs {
+		ptc, isTable := p.Value.(*model.ProjectTableConfig)
+		if p.Type != model.ProjectConfigTable || !isTable || ptc.IsDeleted {
+			continue
+		}
+
+		tables = append(tables, &gql.Table{
+			Name:       p.Key,
+			Columns:    ptc.Columns,
+			Types:      ptc.Types,
+			PrimaryKey: ptc.PrimaryKey,
+		})
+
+		fields := resolver.FieldMap{}
+		for _, col := range ptc.Columns {
+			fields[col] = true
+		}
+		e.fields[p.Key] = fields
+	}
+
+	ok = true
+
+	return
+}
+
+// Find implements gql.Executor.Find.
+func (e *graphQLExecutor) Find(table string, filter map[string]interface{}, order map[string]interface{},
+	offset int64, first *int64) (page *gql.Page, err error) {
+	fields, err := e.prepare(table, resolver.RuleQueryFind)
+	if err != nil {
+		return
+	}
+
+	var mask resolver.ColumnMask
+
+	if !e.adminMode {
+		userFilter := filter
+
+		if filter, err = e.rules.EnforceRulesOnFilter(userFilter, table, e.uid, e.userState, e.vars,
+			resolver.RuleQueryFind); err != nil {
+			return nil, e.error(err, ErrEnforceRuleOnQueryFailed)
+		}
+
+		if fields, mask, err = enforceColumnMask(e.rules, table, e.uid, e.userState, userFilter, fields); err != nil {
+			return nil, e.error(err, ErrEnforceRuleOnQueryFailed)
+		}
+
+		if first, err = e.rules.ResolveRowLimit(table, resolver.RuleQueryFind, e.uid, e.userState, first); err != nil {
+			return nil, e.error(err, ErrUnboundedQueryRefused)
+		}
+	}
+
+	// fetch one more row to detect next page
+	var limit *int64
+	if first != nil {
+		l := *first + 1
+		limit = &l
+	}
+
+	stmt, args, _, err := resolver.Find(table, fields, filter, nil, order, &offset, limit)
+	if err != nil {
+		return
+	}
+
+	rows, err := e.db.Query(stmt, args...)
+	if err != nil {
+		return nil, e.error(err, ErrExecuteQueryFailed)
+	}
+
+	result, err := scanRows(rows)
+	if err != nil {
+		return nil, e.error(err, ErrScanRowsFailed)
+	}
+
+	page = &gql.Page{}
+
+	if first != nil && int64(len(result)) > *first {
+		result = result[:*first]
+		page.HasNext = true
+	}
+
+	for _, row := range result {
+		mask.Apply(row)
+		page.Rows = append(page.Rows, map[string]interface{}(row))
+	}
+
+	return
+}
+
+// Count implements gql.Executor.Count.
+func (e *graphQLExecutor) Count(table string, filter map[string]interface{}) (count int64, err error) {
+	fields, err := e.prepare(table, resolver.RuleQueryCount)
+	if err != nil {
+		return
+	}
+
+	if !e.adminMode {
+		userFilter := filter
+
+		if filter, err = e.rules.EnforceRulesOnFilter(userFilter, table, e.uid, e.userState, e.vars,
+			resolver.RuleQueryCount); err != nil {
+			return 0, e.error(err, ErrEnforceRuleOnQueryFailed)
+		}
+
+		if fields, _, err = enforceColumnMask(e.rules, table, e.uid, e.userState, userFilter, fields); err != nil {
+			return 0, e.error(err, ErrEnforceRuleOnQueryFailed)
+		}
+	}
+
+	stmt, args, _, err := resolver.Count(table, fields, filter)
+	if err != nil {
+		return
+	}
+
+	if count, err = e.db.SelectInt(stmt, args...); err != nil {
+		return 0, e.error(err, ErrExecuteQueryFailed)
+	}
+
+	return
+}
+
+// Insert implements gql.Executor.Insert.
+func (e *graphQLExecutor) Insert(table string, data map[string]interface{}) (
+	lastInsertID int64, affectedRows int64, err error) {
+	fields, err := e.prepare(table, resolver.RuleQueryInsert)
+	if err != nil {
+		return
+	}
+
+	hooks, hookResp, err := e.runBeforeHooks(hook.EventBeforeInsert, table, data, nil)
+	if err != nil {
+		return
+	}
+
+	data = hookResp.Data
+
+	if !e.adminMode {
+		if data, err = e.rules.EnforceRulesOnInsert(data, table, e.uid, e.userState, e.vars); err != nil {
+			return 0, 0, e.enforceError(err)
+		}
+	}
+
+	stmt, args, _, err := resolver.Insert(table, fields, data)
+	if err != nil {
+		return
+	}
+
+	result, err := e.db.Exec(stmt, args...)
+	if err != nil {
+		return 0, 0, e.error(err, ErrExecuteQueryFailed)
+	}
+
+	lastInsertID = mustGetInt64Var(result.LastInsertId())
+	affectedRows = mustGetInt64Var(result.RowsAffected())
+
+	runAfterDataHooks(e.c, e.db, hooks, hook.EventAfterInsert, table, data, nil, e.vars, gin.H{
+		"last_insert_id": lastInsertID,
+		"affected_rows":  affectedRows,
+	}, hookResp.Writes)
+
+	return
+}
+
+// Update implements gql.Executor.Update.
+func (e *graphQLExecutor) Update(table string, filter map[string]interface{}, update map[string]interface{},
+	justOne bool) (affectedRows int64, err error) {
+	fields, err := e.prepare(table, resolver.RuleQueryUpdate)
+	if err != nil {
+		return
+	}
+
+	hooks, hookResp, err := e.runBeforeHooks(hook.EventBeforeUpdate, table, update, filter)
+	if err != nil {
+		return
+	}
+
+	update = hookResp.Data
+
+	if !e.adminMode {
+		if filter, err = e.rules.EnforceRulesOnFilter(filter, table, e.uid, e.userState, e.vars,
+			resolver.RuleQueryUpdate); err != nil {
+			return 0, e.error(err, ErrEnforceRuleOnQueryFailed)
+		}
+
+		if update, err = e.rules.EnforceRulesOnUpdate(update, table, e.uid, e.userState, e.vars); err != nil {
+			return 0, e.enforceError(err)
+		}
+	}
+
+	stmt, args, _, err := resolver.Update(table, fields, filter, update, justOne)
+	if err != nil {
+		return
+	}
+
+	result, err := e.db.Exec(stmt, args...)
+	if err != nil {
+		return 0, e.error(err, ErrExecuteQueryFailed)
+	}
+
+	affectedRows = mustGetInt64Var(result.RowsAffected())
+
+	runAfterDataHooks(e.c, e.db, hooks, hook.EventAfterUpdate, table, update, filter, e.vars, gin.H{
+		"affected_rows": affectedRows,
+	}, hookResp.Writes)
+
+	return
+}
+
+// Remove implements gql.Executor.Remove.
+func (e *graphQLExecutor) Remove(table string, filter map[string]interface{}, justOne bool) (
+	affectedRows int64, err error) {
+	fields, err := e.prepare(table, resolver.RuleQueryRemove)
+	if err != nil {
+		return
+	}
+
+	hooks, hookResp, err := e.runBeforeHooks(hook.EventBeforeRemove, table, nil, filter)
+	if err != nil {
+		return
+	}
+
+	if !e.adminMode {
+		if filter, err = e.rules.EnforceRulesOnFilter(filter, table, e.uid, e.userState, e.vars,
+			resolver.RuleQueryRemove); err != nil {
+			return 0, e.error(err, ErrEnforceRuleOnQueryFailed)
+		}
+	}
+
+	var statements []*resolver.Statement
+
+	if e.rules.HasCascade(table) {
+		statements, err = e.rules.RemoveWithCascade(table, fields, filter, justOne, e.vars)
+	} else {
+		s := &resolver.Statement{}
+		s.Query, s.Args, _, err = resolver.Remove(table, fields, filter, justOne)
+		statements = append(statements, s)
+	}
+	if err != nil {
+		return
+	}
+
+	if affectedRows, err = execInTransaction(e.db, statements); err != nil {
+		return 0, e.error(err, ErrExecuteQueryFailed)
+	}
+
+	runAfterDataHooks(e.c, e.db, hooks, hook.EventAfterRemove, table, nil, filter, e.vars, gin.H{
+		"affected_rows": affectedRows,
+	}, hookResp.Writes)
+
+	return
+}
+
+// prepare checks the table existence and rate limit of query.
+func (e *graphQLExecutor) prepare(table string, qt resolver.RuleQueryType) (fields resolver.FieldMap, err error) {
+	fieldMap, ok := e.fields[table]
+	if !ok {
+		err = &graphQLError{code: ErrTableNotExists}
+		return
+	}
+
+	if !e.adminMode && !e.noRateLimit {
+		client := e.uid
+		if client == "" {
+			// anonymous user, limit by remote address
+			client = e.c.ClientIP()
+		}
+
+		err = getRateLimiter(e.c).Allow(getCurrentProject(e.c).DB, e.rules, table, qt, e.uid, e.userState, client)
+		if qe, isQuotaErr := err.(*resolver.QuotaError); isQuotaErr {
+			err = &graphQLError{code: ErrQuotaExceeded, data: qe}
+			return
+		} else if err != nil {
+			return
+		}
+	}
+
+	// copy the field map as column mask filters the fields
+	fields = resolver.FieldMap{}
+	fields.Merge(fieldMap)
+
+	return
+}
+
+func (e *graphQLExecutor) runBeforeHooks(event hook.Event, table string, data map[string]interface{},
+	filter map[string]interface{}) (hooks *hook.Hooks, resp *hook.Response, err error) {
+	hooks, err = loadDataHooks(e.c, e.db, table)
+	if err == nil {
+		resp, err = hooks.Run(&hook.Request{
+			Event:  event,
+			Table:  table,
+			Data:   data,
+			Filter: filter,
+			Vars:   e.vars,
+		})
+	}
+
+	if err != nil {
+		if rErr, isRejected := errors.Cause(err).(*hook.RejectError); isRejected {
+			err = &graphQLError{code: ErrRejectedByHook, data: rErr}
+		} else {
+			err = e.error(err, ErrExecuteHookFailed)
+		}
+	}
+
+	return
+}
+
+func (e *graphQLExecutor) enforceError(err error) error {
+	if vErr, ok := err.(*resolver.ValidationError); ok {
+		_ = e.c.Error(err)
+		return &graphQLError{code: ErrQueryValidationFailed, data: vErr}
+	}
+	return e.error(err, ErrEnforceRuleOnQueryFailed)
+}
+
+// error records the internal error to request and returns the api error to client.
+func (e *graphQLExecutor) error(err error, code error) error {
+	_ = e.c.Error(err)
+	return &graphQLError{code: code}
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	gorp "gopkg.in/gorp.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/hook"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/resolver"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+func updateProjectTableHooks(c *gin.Context) {
+	r := struct {
+		DB    proto.DatabaseID `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+		Table string           `json:"table" form:"table" uri:"table" binding:"required,max=128"`
+		model.ProjectHookConfig
+		// additional parameters, see ProjectHookConfig structure
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	_, ptc, err := model.GetProjectTableConfig(projectDB, r.Table)
+	if err != nil || ptc.IsDeleted {
+		abortWithError(c, http.StatusNotFound, ErrTableNotExists)
+		return
+	}
+
+	cfg := &r.ProjectHookConfig
+
+	// disabled functions are validated too
+	if _, err = hook.Compile(buildHookFunctions(cfg, true)); err != nil {
+		_ = c.Error(err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"msg":     ErrInvalidHookConfig.Error(),
+			"data": gin.H{
+				"error": err.Error(),
+			},
+		})
+		return
+	}
+
+	p, _, err := model.GetProjectHookConfig(projectDB, r.Table)
+	if err != nil {
+		p, err = model.AddProjectConfig(projectDB, model.ProjectConfigHook, r.Table, cfg)
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusInternalServerError, ErrAddProjectHookConfigFailed)
+			return
+		}
+	} else {
+		p.Value = cfg
+		err = model.UpdateProjectConfig(projectDB, p)
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusInternalServerError, ErrUpdateProjectConfigFailed)
+			return
+		}
+	}
+
+	getHookManager(c).Remove(string(r.DB), r.Table)
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"project": r.DB,
+		"db":      r.DB,
+		"table":   r.Table,
+		"hooks":   cfg,
+	})
+}
+
+// runBeforeDataHooks loads hook functions of table and runs the before event functions,
+// the request is aborted if the data write is rejected by hook function.
+func runBeforeDataHooks(c *gin.Context, projectDB *gorp.DbMap, event hook.Event, table string,
+	data map[string]interface{}, filter map[string]interface{}, vars map[string]interface{}) (
+	hooks *hook.Hooks, resp *hook.Response, ok bool) {
+	hooks, err := loadDataHooks(c, projectDB, table)
+	if err == nil {
+		resp, err = hooks.Run(&hook.Request{
+			Event:  event,
+			Table:  table,
+			Data:   data,
+			Filter: filter,
+			Vars:   vars,
+		})
+	}
+
+	if err != nil {
+		_ = c.Error(err)
+
+		if rErr, isRejected := errors.Cause(err).(*hook.RejectError); isRejected {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"msg":     ErrRejectedByHook.Error(),
+				"data":    rErr,
+			})
+		} else {
+			abortWithError(c, http.StatusInternalServerError, ErrExecuteHookFailed)
+		}
+
+		return
+	}
+
+	ok = true
+	return
+}
+
+// runAfterDataHooks runs the after event functions and executes the writes queued by hook functions,
+// failures are only logged since the data write is already committed.
+func runAfterDataHooks(c *gin.Context, projectDB *gorp.DbMap, hooks *hook.Hooks, event hook.Event, table string,
+	data map[string]interface{}, filter map[string]interface{}, vars map[string]interface{},
+	result gin.H, writes []*hook.Write) {
+	resp, err := hooks.Run(&hook.Request{
+		Event:  event,
+		Table:  table,
+		Data:   data,
+		Filter: filter,
+		Result: map[string]interface{}(result),
+		Vars:   vars,
+	})
+	if err == nil {
+		err = executeHookWrites(projectDB, append(writes, resp.Writes...))
+	}
+
+	if err != nil {
+		_ = c.Error(err)
+		log.WithFields(log.Fields{
+			"project": getCurrentProject(c).DB,
+			"table":   table,
+			"event":   event,
+		}).WithError(err).Warning("execute data hooks failed")
+	}
+}
+
+// loadDataHooks returns the compiled hook functions of table, nil hooks is returned if not configured.
+func loadDataHooks(c *gin.Context, projectDB *gorp.DbMap, table string) (h *hook.Hooks, err error) {
+	p, hc, err := model.GetProjectHookConfig(projectDB, table)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			err = nil
+		}
+		return
+	}
+
+	return getHookManager(c).Get(string(getCurrentProject(c).DB), table, p.LastUpdated,
+		buildHookFunctions(hc, false))
+}
+
+// executeHookWrites executes the writes queued by hook functions atomically, rules and hooks of the
+// written tables are not applied.
+func executeHookWrites(projectDB *gorp.DbMap, writes []*hook.Write) (err error) {
+	if len(writes) == 0 {
+		return
+	}
+
+	var (
+		statements  []*resolver.Statement
+		tableFields = map[string]resolver.FieldMap{}
+	)
+
+	for _, w := range writes {
+		fields, ok := tableFields[w.Table]
+		if !ok {
+			_, ptc, tblErr := model.GetProjectTableConfig(projectDB, w.Table)
+			if tblErr != nil || ptc.IsDeleted {
+				return errors.Wrapf(ErrTableNotExists, "hook write to table %s", w.Table)
+			}
+
+			fields = resolver.FieldMap{}
+			for _, col := range ptc.Columns {
+				fields[col] = true
+			}
+			tableFields[w.Table] = fields
+		}
+
+		s := &resolver.Statement{}
+
+		switch w.Op {
+		case "insert":
+			s.Query, s.Args, _, err = resolver.Insert(w.Table, fields, w.Data)
+		case "update":
+			s.Query, s.Args, _, err = resolver.Update(w.Table, fields, w.Filter, w.Data, false)
+		case "remove":
+			s.Query, s.Args, _, err = resolver.Remove(w.Table, fields, w.Filter, false)
+		default:
+			err = errors.Wrapf(hook.ErrInvalidWrite, "unknown op %s", w.Op)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "resolve hook %s to table %s failed", w.Op, w.Table)
+		}
+
+		statements = append(statements, s)
+	}
+
+	_, err = execInTransaction(projectDB, statements)
+
+	return
+}
+
+func buildHookFunctions(hc *model.ProjectHookConfig, withDisabled bool) (functions []*hook.Function) {
+	for _, f := range hc.Functions {
+		if f.Disabled && !withDisabled {
+			continue
+		}
+
+		events := make([]hook.Event, 0, len(f.Events))
+		for _, e := range f.Events {
+			events = append(events, hook.Event(e))
+		}
+
+		functions = append(functions, &hook.Function{
+			Name:     f.Name,
+			Language: f.Language,
+			Events:   events,
+			Source:   f.Source,
+			Timeout:  f.Timeout,
+		})
+	}
+
+	return
+}
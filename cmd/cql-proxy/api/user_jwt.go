@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	gorp "gopkg.in/gorp.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/auth"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const (
+	// jwtUserProvider defines the provider name of users authorized by external identity provider token.
+	jwtUserProvider = "jwt"
+	// jwtBearerPrefix defines the authorization header prefix of external identity provider token.
+	jwtBearerPrefix = "Bearer "
+)
+
+func updateProjectJWTConfig(c *gin.Context) {
+	r := struct {
+		DB proto.DatabaseID `json:"db" json:"project" form:"db" form:"project" uri:"db" uri:"project" binding:"required,len=64"`
+		model.ProjectJWTConfig
+		// additional parameters, see ProjectJWTConfig structure
+	}{}
+
+	_ = c.ShouldBindUri(&r)
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, projectDB, err := getProjectDB(c, r.DB)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, ErrLoadProjectDatabaseFailed)
+		return
+	}
+
+	cfg := &r.ProjectJWTConfig
+
+	p, pjc, err := model.GetProjectJWTConfig(projectDB)
+	if err == nil {
+		// keep existing secret and enabled status if not provided
+		if cfg.Secret == "" {
+			cfg.Secret = pjc.Secret
+		}
+		if cfg.Enabled == nil {
+			cfg.Enabled = pjc.Enabled
+		}
+	}
+
+	if _, err = auth.NewJWTVerifier(buildJWTConfig(cfg)); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusBadRequest, ErrInvalidJWTConfig)
+		return
+	}
+
+	if p == nil {
+		p, err = model.AddProjectConfig(projectDB, model.ProjectConfigJWT, "", cfg)
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusInternalServerError, ErrAddProjectJWTConfigFailed)
+			return
+		}
+	} else {
+		p.Value = cfg
+		err = model.UpdateProjectConfig(projectDB, p)
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusInternalServerError, ErrUpdateProjectConfigFailed)
+			return
+		}
+	}
+
+	// drop cached verifier and keys of previous config
+	getJWTManager(c).Remove(string(r.DB))
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"jwt": cfg,
+	})
+}
+
+// injectJWTSession validates the token issued by external identity provider and injects stateless user session,
+// the token is not handled if jwt auth of project is not enabled.
+func injectJWTSession(c *gin.Context, projectDB *gorp.DbMap, miscConfig *model.ProjectMiscConfig, token string) (
+	handled bool, err error) {
+	p, pjc, err := model.GetProjectJWTConfig(projectDB)
+	if err != nil || !pjc.IsEnabled() {
+		err = nil
+		return
+	}
+
+	handled = true
+	project := getCurrentProject(c)
+
+	v, err := getJWTManager(c).Get(string(project.DB), p.LastUpdated, buildJWTConfig(pjc))
+	if err != nil {
+		err = errors.Wrapf(err, "load jwt verifier failed")
+		return
+	}
+
+	claims, err := v.Verify(token)
+	if err != nil {
+		err = errors.Wrapf(err, "verify jwt token failed")
+		return
+	}
+
+	u, err := model.GetProjectUserByProvider(projectDB, jwtUserProvider, claims.UID)
+	if err != nil {
+		// identity provider is trusted, provision user on first access
+		newUserState := model.ProjectUserStateEnabled
+		if miscConfig.ShouldVerifyAfterSignUp() {
+			newUserState = model.ProjectUserStateWaitSignedConfirm
+		}
+
+		u, err = model.EnsureProjectUser(projectDB, jwtUserProvider,
+			claims.UID, claims.Name, claims.Email, claims.Raw, true, newUserState)
+		if err != nil {
+			err = errors.Wrapf(err, "provision jwt user failed")
+			return
+		}
+	}
+
+	rules, err := loadRules(c, project.DB, projectDB)
+	if err != nil {
+		err = errors.Wrapf(err, "load rules failed")
+		return
+	}
+
+	rules.BindClaimGroups(fmt.Sprint(u.ID), claims.Groups, claims.Expires)
+
+	// session without id is not persisted
+	s := model.NewEmptySession(c)
+	s.Set("user", true)
+	s.Set("user_id", u.ID)
+	s.Set("provider", jwtUserProvider)
+	s.Set("provider_id", u.ProviderUID)
+	s.Set("name", u.Name)
+	s.Set("email", u.Email)
+
+	if claims.State != "" {
+		s.Set("jwt_state", claims.State)
+	}
+
+	return
+}
+
+func buildJWTConfig(pjc *model.ProjectJWTConfig) *auth.JWTConfig {
+	return &auth.JWTConfig{
+		Issuer:          pjc.Issuer,
+		Audience:        pjc.Audience,
+		JWKSURL:         pjc.JWKSURL,
+		Secret:          pjc.Secret,
+		Algorithms:      pjc.Algorithms,
+		UIDClaim:        pjc.UIDClaim,
+		NameClaim:       pjc.NameClaim,
+		EmailClaim:      pjc.EmailClaim,
+		StateClaim:      pjc.StateClaim,
+		GroupsClaim:     pjc.GroupsClaim,
+		Leeway:          pjc.Leeway,
+		RefreshInterval: pjc.RefreshInterval,
+	}
+}
@@ -17,6 +17,7 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -67,7 +68,7 @@ func userOAuthAuthorize(c *gin.Context) {
 		return
 	}
 
-	if !oauthConfig.IsEnabled() {
+	if !oauthConfig.IsEnabled() || !isOAuthProviderAvailable(c, r.Provider) {
 		_ = c.AbortWithError(http.StatusForbidden, errors.New("oauth provider disabled"))
 		return
 	}
@@ -75,9 +76,7 @@ func userOAuthAuthorize(c *gin.Context) {
 	auth.HandleUserAuth(
 		c,
 		r.Provider,
-		oauthConfig.ClientID,
-		oauthConfig.ClientSecret,
-		r.Callback,
+		buildProviderConfig(c, r.Provider, oauthConfig, r.Callback),
 	)
 }
 
@@ -157,7 +156,7 @@ func handleUserOAuthCallback(c *gin.Context) (res gin.H, status int, err error)
 		return
 	}
 
-	if !oauthConfig.IsEnabled() {
+	if !oauthConfig.IsEnabled() || !isOAuthProviderAvailable(c, r.Provider) {
 		status = http.StatusForbidden
 		err = errors.New("oauth provider disabled")
 		return
@@ -168,8 +167,7 @@ func handleUserOAuthCallback(c *gin.Context) (res gin.H, status int, err error)
 	auth.HandleUserCallback(
 		c,
 		r.Provider,
-		oauthConfig.ClientID,
-		oauthConfig.ClientSecret,
+		buildProviderConfig(c, r.Provider, oauthConfig, ""),
 		handleUserOAuthCallbackSuccess(c, miscConfig, projectDB, r.Provider, ch),
 		func(err error) {
 			resp := &userOAuthCallbackPayload{
@@ -277,6 +275,49 @@ func handleUserOAuthCallbackSuccess(
 	}
 }
 
+// isOAuthProviderAvailable checks if the provider is registered and globally enabled in proxy config.
+func isOAuthProviderAvailable(c *gin.Context, provider string) bool {
+	if _, err := auth.GetUserAuthProvider(provider); err != nil {
+		return false
+	}
+
+	cfg := getConfig(c)
+	if cfg == nil || cfg.UserAuth == nil {
+		return false
+	}
+
+	for _, p := range cfg.UserAuth.Providers {
+		if p == provider {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildProviderConfig merges the global provider extra config with project oauth config.
+func buildProviderConfig(c *gin.Context, provider string, oauthConfig *model.ProjectOAuthConfig,
+	callback string) (cfg *auth.ProviderConfig) {
+	cfg = &auth.ProviderConfig{
+		ClientID:     oauthConfig.ClientID,
+		ClientSecret: oauthConfig.ClientSecret,
+		Callback:     callback,
+		Extra:        map[string]string{},
+	}
+
+	if pc := getConfig(c); pc != nil && pc.UserAuth != nil {
+		for k, v := range pc.UserAuth.Extra[provider] {
+			cfg.Extra[k] = fmt.Sprint(v)
+		}
+	}
+
+	for k, v := range oauthConfig.Extra {
+		cfg.Extra[k] = v
+	}
+
+	return
+}
+
 func userCheckRequireLogin(c *gin.Context) {
 	s := getSession(c)
 
@@ -418,7 +459,20 @@ func userSessionInject(c *gin.Context) {
 	// load session
 	var token string
 
-	for i := 0; i != 4; i++ {
+	if bearer := c.GetHeader("Authorization"); strings.HasPrefix(bearer, jwtBearerPrefix) {
+		// stateless session of token issued by external identity provider
+		var handled bool
+		if handled, err = injectJWTSession(c, projectDB, miscConfig, strings.TrimPrefix(bearer, jwtBearerPrefix)); err != nil {
+			_ = c.Error(err)
+			abortWithError(c, http.StatusUnauthorized, ErrInvalidUserToken)
+			return
+		}
+		if handled {
+			token = bearer
+		}
+	}
+
+	for i := 0; token == "" && i != 4; i++ {
 		switch i {
 		case 0:
 			// header
@@ -0,0 +1,403 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/realtime"
+)
+
+const (
+	// message types pushed to realtime clients
+	realtimeResult       = "result"
+	realtimeInsert       = "insert"
+	realtimeUpdate       = "update"
+	realtimeRemove       = "remove"
+	realtimeUnsubscribed = "unsubscribed"
+	realtimeError        = "error"
+
+	// actions sent by realtime clients
+	realtimeActionSubscribe   = "subscribe"
+	realtimeActionUnsubscribe = "unsubscribe"
+)
+
+var (
+	// realtimeRefreshInterval defines the interval to coalesce table changes before refreshing
+	// the affected subscriptions.
+	realtimeRefreshInterval = 200 * time.Millisecond
+	// realtimeMaxSubscriptions defines the max subscriptions of a connection.
+	realtimeMaxSubscriptions = 32
+	realtimeReadLimit        = int64(64 * 1024)
+	realtimeWriteTimeout     = 10 * time.Second
+	realtimePingInterval     = 30 * time.Second
+	realtimeUpgrader         = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+)
+
+// realtimeAction defines the message sent by realtime clients to change subscriptions.
+type realtimeAction struct {
+	Action  string                 `json:"action"`
+	ID      string                 `json:"id"`
+	Table   string                 `json:"table"`
+	Filter  map[string]interface{} `json:"filter"`
+	OrderBy map[string]interface{} `json:"order"`
+	Skip    *int64                 `json:"skip"`
+	Limit   *int64                 `json:"limit"`
+}
+
+// realtimeMessage defines the message pushed to realtime clients.
+//
+// The initial result of subscription is pushed as result message, the following changes of result
+// are pushed as remove messages first, then insert and update messages in the order of new result,
+// index is the position of the inserted or updated row in new result.
+type realtimeMessage struct {
+	Type  string                 `json:"type"`
+	ID    string                 `json:"id,omitempty"`
+	Rows  interface{}            `json:"rows,omitempty"`
+	Row   map[string]interface{} `json:"row,omitempty"`
+	Old   map[string]interface{} `json:"old,omitempty"`
+	Index *int                   `json:"index,omitempty"`
+	Msg   string                 `json:"msg,omitempty"`
+	Data  interface{}            `json:"data,omitempty"`
+}
+
+// realtimeSubscription defines a find query subscribed by realtime client.
+type realtimeSubscription struct {
+	realtimeAction
+	rows  []map[string]interface{}
+	dirty bool
+}
+
+// realtimeSession serves the subscriptions of a realtime connection.
+type realtimeSession struct {
+	sync.Mutex
+	c           *gin.Context
+	conn        *websocket.Conn
+	e           *graphQLExecutor
+	primaryKeys map[string]string
+	subs        map[string]*realtimeSubscription
+	listener    *realtime.Listener
+	writeLock   sync.Mutex
+}
+
+// userRealtime upgrades the request to websocket connection serving realtime find query
+// subscriptions, the subscribed queries are refreshed on changes of the tables observed from
+// the database change feed, the rules are enforced on each refresh as current user.
+func userRealtime(c *gin.Context) {
+	e, tables, ok := buildGraphQLExecutorOrAbort(c)
+	if !ok {
+		return
+	}
+
+	conn, err := realtimeUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// response is written by upgrader
+		_ = c.Error(err)
+		return
+	}
+
+	conn.SetReadLimit(realtimeReadLimit)
+
+	s := &realtimeSession{
+		c:           c,
+		conn:        conn,
+		e:           e,
+		primaryKeys: map[string]string{},
+		subs:        map[string]*realtimeSubscription{},
+	}
+
+	for _, t := range tables {
+		s.primaryKeys[t.Name] = t.PrimaryKey
+	}
+
+	s.serve()
+}
+
+func (s *realtimeSession) serve() {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			var action realtimeAction
+			if err := s.conn.ReadJSON(&action); err != nil {
+				return
+			}
+			s.handle(&action)
+		}
+	}()
+
+	s.refreshLoop(done)
+
+	s.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.Unlock()
+
+	_ = s.conn.Close()
+	<-done
+}
+
+func (s *realtimeSession) handle(action *realtimeAction) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case action.ID == "":
+		s.writeError("", ErrInvalidSubscription, nil)
+	case action.Action == realtimeActionSubscribe:
+		s.subscribe(action)
+	case action.Action == realtimeActionUnsubscribe:
+		delete(s.subs, action.ID)
+		s.write(&realtimeMessage{Type: realtimeUnsubscribed, ID: action.ID})
+	default:
+		s.writeError(action.ID, ErrInvalidSubscription, nil)
+	}
+}
+
+func (s *realtimeSession) subscribe(action *realtimeAction) {
+	if _, exists := s.subs[action.ID]; !exists && len(s.subs) >= realtimeMaxSubscriptions {
+		s.writeError(action.ID, ErrTooManySubscriptions, nil)
+		return
+	}
+
+	if (action.Skip != nil && *action.Skip < 0) || (action.Limit != nil && *action.Limit < 0) {
+		s.writeError(action.ID, ErrInvalidSubscription, nil)
+		return
+	}
+
+	sub := &realtimeSubscription{realtimeAction: *action}
+
+	// initial query is rate limited as normal find query
+	s.e.noRateLimit = false
+	rows, err := s.find(sub)
+	if err != nil {
+		delete(s.subs, action.ID)
+		s.writeFindError(action.ID, err)
+		return
+	}
+
+	sub.rows = rows
+	s.subs[action.ID] = sub
+
+	if s.listener == nil {
+		// listen all tables of project to avoid restarting the change feed on new subscriptions
+		tables := make([]string, 0, len(s.primaryKeys))
+		for t := range s.primaryKeys {
+			tables = append(tables, t)
+		}
+
+		s.listener = getRealtimeHub(s.c).Listen(getCurrentProject(s.c).DB, tables...)
+
+		go func(l *realtime.Listener) {
+			for range l.C() {
+				s.markDirty(l.Changes())
+			}
+		}(s.listener)
+	}
+
+	s.write(&realtimeMessage{Type: realtimeResult, ID: action.ID, Rows: rows})
+}
+
+func (s *realtimeSession) markDirty(tables []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	changed := map[string]bool{}
+	for _, t := range tables {
+		changed[t] = true
+	}
+
+	for _, sub := range s.subs {
+		if changed[sub.Table] {
+			sub.dirty = true
+		}
+	}
+}
+
+func (s *realtimeSession) refreshLoop(done <-chan struct{}) {
+	refresh := time.NewTicker(realtimeRefreshInterval)
+	defer refresh.Stop()
+	ping := time.NewTicker(realtimePingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-refresh.C:
+			s.refresh()
+		case <-ping.C:
+			s.writeLock.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(realtimeWriteTimeout))
+			s.writeLock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// refresh re-executes the dirty subscriptions and pushes the result changes.
+func (s *realtimeSession) refresh() {
+	s.Lock()
+	defer s.Unlock()
+
+	var reloaded bool
+
+	for id, sub := range s.subs {
+		if !sub.dirty {
+			continue
+		}
+
+		sub.dirty = false
+
+		if !reloaded && !s.e.adminMode {
+			// apply the rules updated after subscribing
+			rules, err := loadRules(s.c, getCurrentProject(s.c).DB, s.e.db)
+			if err != nil {
+				_ = s.c.Error(err)
+			} else {
+				s.e.rules = rules
+			}
+			reloaded = true
+		}
+
+		s.e.noRateLimit = true
+		rows, err := s.find(sub)
+		if err != nil {
+			delete(s.subs, id)
+			s.writeFindError(id, err)
+			continue
+		}
+
+		for _, msg := range diffRealtimeRows(id, s.primaryKeys[sub.Table], sub.rows, rows) {
+			s.write(msg)
+		}
+
+		sub.rows = rows
+	}
+}
+
+func (s *realtimeSession) find(sub *realtimeSubscription) (rows []map[string]interface{}, err error) {
+	var offset int64
+	if sub.Skip != nil {
+		offset = *sub.Skip
+	}
+
+	page, err := s.e.Find(sub.Table, sub.Filter, sub.OrderBy, offset, sub.Limit)
+	if err != nil {
+		return
+	}
+
+	rows = page.Rows
+	if rows == nil {
+		rows = []map[string]interface{}{}
+	}
+
+	return
+}
+
+func (s *realtimeSession) write(msg *realtimeMessage) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(realtimeWriteTimeout))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		// unblock the reader to close the session
+		_ = s.conn.Close()
+	}
+}
+
+func (s *realtimeSession) writeError(id string, code error, data interface{}) {
+	s.write(&realtimeMessage{Type: realtimeError, ID: id, Msg: code.Error(), Data: data})
+}
+
+func (s *realtimeSession) writeFindError(id string, err error) {
+	if qErr, ok := err.(*graphQLError); ok {
+		s.writeError(id, qErr.code, qErr.data)
+	} else {
+		// invalid query
+		s.writeError(id, err, nil)
+	}
+}
+
+// diffRealtimeRows returns the messages to transform old result rows to new rows, rows are
+// identified by primary key if it's not masked, otherwise by the whole row content.
+func diffRealtimeRows(id string, primaryKey string, oldRows []map[string]interface{},
+	newRows []map[string]interface{}) (msgs []*realtimeMessage) {
+	rowKey := func(row map[string]interface{}) (key string, content string) {
+		b, _ := json.Marshal(row)
+		content = string(b)
+		if v, ok := row[primaryKey]; ok && primaryKey != "" && v != nil {
+			key = fmt.Sprintf("pk:%v", v)
+		} else {
+			key = "row:" + content
+		}
+		return
+	}
+
+	oldIndex := map[string][]int{}
+	oldContents := make([]string, len(oldRows))
+	for i, row := range oldRows {
+		var key string
+		key, oldContents[i] = rowKey(row)
+		oldIndex[key] = append(oldIndex[key], i)
+	}
+
+	var (
+		matched = make([]bool, len(oldRows))
+		changes []*realtimeMessage
+	)
+
+	for i, row := range newRows {
+		key, content := rowKey(row)
+		index := i
+
+		if olds := oldIndex[key]; len(olds) > 0 {
+			j := olds[0]
+			oldIndex[key] = olds[1:]
+			matched[j] = true
+
+			if oldContents[j] != content {
+				changes = append(changes, &realtimeMessage{
+					Type: realtimeUpdate, ID: id, Row: row, Old: oldRows[j], Index: &index})
+			}
+			continue
+		}
+
+		changes = append(changes, &realtimeMessage{Type: realtimeInsert, ID: id, Row: row, Index: &index})
+	}
+
+	for j, row := range oldRows {
+		if !matched[j] {
+			msgs = append(msgs, &realtimeMessage{Type: realtimeRemove, ID: id, Row: row})
+		}
+	}
+
+	msgs = append(msgs, changes...)
+
+	return
+}
@@ -24,7 +24,11 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/auth"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/config"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/gql"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/hook"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/realtime"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/resolver"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/task"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
@@ -75,6 +79,26 @@ func getConfig(c *gin.Context) *config.Config {
 	return c.MustGet("config").(*config.Config)
 }
 
+func getJWTManager(c *gin.Context) *auth.JWTManager {
+	return c.MustGet("jwt").(*auth.JWTManager)
+}
+
+func getGraphQLManager(c *gin.Context) *gql.Manager {
+	return c.MustGet("graphql").(*gql.Manager)
+}
+
+func getHookManager(c *gin.Context) *hook.Manager {
+	return c.MustGet("hook").(*hook.Manager)
+}
+
+func getRealtimeHub(c *gin.Context) *realtime.Hub {
+	return c.MustGet("realtime").(*realtime.Hub)
+}
+
+func getRateLimiter(c *gin.Context) *resolver.RateLimiter {
+	return c.MustGet("limiter").(*resolver.RateLimiter)
+}
+
 func getCurrentProject(c *gin.Context) *model.Project {
 	return c.MustGet("project").(*model.Project)
 }
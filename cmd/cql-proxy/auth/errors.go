@@ -25,4 +25,22 @@ var (
 	ErrOAuthGetUserFailed = errors.New("get user failed")
 	// ErrUnsupportedUserAuthProvider defines error on currently unsupported oauth user provider.
 	ErrUnsupportedUserAuthProvider = errors.New("unsupported user auth provider")
+	// ErrAuthorizeNotRequired defines error on authorize request to provider logged in by client sdk directly.
+	ErrAuthorizeNotRequired = errors.New("authorize is not required for provider")
+	// ErrInvalidJWT defines error on malformed jwt token.
+	ErrInvalidJWT = errors.New("invalid jwt token")
+	// ErrInvalidJWTSignature defines error on jwt signature verification failure.
+	ErrInvalidJWTSignature = errors.New("invalid jwt signature")
+	// ErrInvalidJWTClaims defines error on jwt claims not accepted by project config.
+	ErrInvalidJWTClaims = errors.New("invalid jwt claims")
+	// ErrJWTExpired defines error on expired jwt token.
+	ErrJWTExpired = errors.New("jwt token is expired")
+	// ErrJWTNotValidYet defines error on jwt token used before its nbf claim.
+	ErrJWTNotValidYet = errors.New("jwt token is not valid yet")
+	// ErrUnsupportedJWTAlgorithm defines error on unsupported or disallowed jwt signing algorithm.
+	ErrUnsupportedJWTAlgorithm = errors.New("unsupported jwt algorithm")
+	// ErrJWTKeyNotConfigured defines error on missing jwt secret or jwks url for token verification.
+	ErrJWTKeyNotConfigured = errors.New("jwt verification key is not configured")
+	// ErrJWTKeyNotFound defines error on jwt key id not found in jwks.
+	ErrJWTKeyNotFound = errors.New("jwt verification key not found")
 )
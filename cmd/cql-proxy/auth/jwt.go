@@ -0,0 +1,670 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // register sha256 for jwt signatures
+	_ "crypto/sha512" // register sha384/sha512 for jwt signatures
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultJWKSRefreshInterval defines the default interval to refresh the cached jwks keys.
+	defaultJWKSRefreshInterval = time.Hour
+	// minJWKSRefreshInterval defines the minimum interval between jwks fetches triggered by unknown key id.
+	minJWKSRefreshInterval = 10 * time.Second
+	// jwksFetchTimeout defines the timeout of jwks http request.
+	jwksFetchTimeout = 10 * time.Second
+
+	defaultJWTUIDClaim   = "sub"
+	defaultJWTNameClaim  = "name"
+	defaultJWTEmailClaim = "email"
+)
+
+// JWTConfig defines the external identity provider token validation config.
+type JWTConfig struct {
+	Issuer   string // required "iss" claim, not checked if empty
+	Audience string // required "aud" claim, not checked if empty
+	JWKSURL  string // json web key set url for RS/PS/ES signed tokens
+	Secret   string // shared secret for HS signed tokens
+
+	// allowed signing algorithms, all supported algorithms with configured keys are allowed if empty.
+	Algorithms []string
+
+	// claims mapping, nested claim is addressed by dot separated path like "realm_access.roles".
+	UIDClaim    string // defaults to "sub"
+	NameClaim   string // defaults to "name"
+	EmailClaim  string // defaults to "email"
+	StateClaim  string // custom user state claim, not mapped if empty
+	GroupsClaim string // user groups claim, not mapped if empty
+
+	Leeway          time.Duration // clock skew tolerance of exp/nbf validation
+	RefreshInterval time.Duration // jwks cache refresh interval, defaults to 1h
+}
+
+// JWTClaims defines the user info mapped from validated token claims.
+type JWTClaims struct {
+	UID     string
+	Name    string
+	Email   string
+	State   string
+	Groups  []string
+	Expires time.Time // zero for token without expiration
+	Raw     gin.H
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// JWTVerifier validates tokens issued by external identity provider and maps the claims to user info.
+//
+// Keys of the configured jwks url are cached and refreshed periodically, an unknown key id triggers
+// an immediate refresh (rate limited), so the key rotation of identity provider is picked up.
+type JWTVerifier struct {
+	cfg    *JWTConfig
+	client *http.Client
+
+	keyLock   sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	lastFetch time.Time
+	fetchLock sync.Mutex
+}
+
+// NewJWTVerifier returns new jwt verifier of specified config.
+func NewJWTVerifier(cfg *JWTConfig) (v *JWTVerifier, err error) {
+	if cfg == nil || (cfg.JWKSURL == "" && cfg.Secret == "") {
+		err = ErrJWTKeyNotConfigured
+		return
+	}
+
+	for _, alg := range cfg.Algorithms {
+		if _, _, err = jwtAlgorithm(alg); err != nil {
+			return
+		}
+	}
+
+	v = &JWTVerifier{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: jwksFetchTimeout,
+		},
+		keys: make(map[string]crypto.PublicKey),
+	}
+
+	return
+}
+
+// Verify validates the signature and registered claims of token and returns the mapped user info.
+func (v *JWTVerifier) Verify(token string) (claims *JWTClaims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = ErrInvalidJWT
+		return
+	}
+
+	var header jwtHeader
+	if err = decodeJWTSegment(parts[0], &header); err != nil {
+		err = errors.Wrapf(ErrInvalidJWT, "decode header failed: %v", err)
+		return
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		err = errors.Wrapf(ErrInvalidJWT, "decode signature failed: %v", err)
+		return
+	}
+
+	if err = v.verifySignature(&header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return
+	}
+
+	var raw gin.H
+	if err = decodeJWTSegment(parts[1], &raw); err != nil {
+		err = errors.Wrapf(ErrInvalidJWT, "decode claims failed: %v", err)
+		return
+	}
+
+	return v.mapClaims(raw)
+}
+
+func (v *JWTVerifier) verifySignature(header *jwtHeader, signed []byte, signature []byte) (err error) {
+	if !v.algorithmAllowed(header.Algorithm) {
+		err = errors.Wrapf(ErrUnsupportedJWTAlgorithm, "algorithm %s is not allowed", header.Algorithm)
+		return
+	}
+
+	kty, hash, err := jwtAlgorithm(header.Algorithm)
+	if err != nil {
+		return
+	}
+
+	if kty == "oct" {
+		if v.cfg.Secret == "" {
+			err = ErrJWTKeyNotConfigured
+			return
+		}
+
+		mac := hmac.New(hash.New, []byte(v.cfg.Secret))
+		_, _ = mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			err = ErrInvalidJWTSignature
+		}
+		return
+	}
+
+	key, err := v.getKey(header.KeyID, kty)
+	if err != nil {
+		return
+	}
+
+	if jwkType(key) != kty {
+		// the key of specified id is not usable by the algorithm
+		err = errors.Wrapf(ErrUnsupportedJWTAlgorithm, "algorithm %s does not match key %s", header.Algorithm, header.KeyID)
+		return
+	}
+
+	h := hash.New()
+	_, _ = h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(header.Algorithm, "PS") {
+			err = rsa.VerifyPSS(pub, hash, digest, signature,
+				&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		}
+		if err != nil {
+			err = ErrInvalidJWTSignature
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			err = ErrInvalidJWTSignature
+			return
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			err = ErrInvalidJWTSignature
+		}
+	default:
+		err = ErrInvalidJWTSignature
+	}
+
+	return
+}
+
+func (v *JWTVerifier) algorithmAllowed(alg string) bool {
+	if len(v.cfg.Algorithms) == 0 {
+		return alg != "" && alg != "none"
+	}
+
+	for _, a := range v.cfg.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *JWTVerifier) getKey(kid string, kty string) (key crypto.PublicKey, err error) {
+	if v.cfg.JWKSURL == "" {
+		err = ErrJWTKeyNotConfigured
+		return
+	}
+
+	refreshInterval := v.cfg.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+
+	key, lastFetch := v.findKey(kid, kty)
+	if key != nil && time.Since(lastFetch) < refreshInterval {
+		return
+	}
+
+	if key == nil && time.Since(lastFetch) < minJWKSRefreshInterval {
+		err = errors.Wrapf(ErrJWTKeyNotFound, "key %s", kid)
+		return
+	}
+
+	if err = v.refreshKeys(lastFetch); err != nil {
+		if key != nil {
+			// keep using the cached key if identity provider is temporarily unavailable
+			err = nil
+		}
+		return
+	}
+
+	if key, _ = v.findKey(kid, kty); key == nil {
+		err = errors.Wrapf(ErrJWTKeyNotFound, "key %s", kid)
+	}
+
+	return
+}
+
+func (v *JWTVerifier) findKey(kid string, kty string) (key crypto.PublicKey, lastFetch time.Time) {
+	v.keyLock.RLock()
+	defer v.keyLock.RUnlock()
+
+	lastFetch = v.lastFetch
+
+	if kid != "" {
+		key = v.keys[kid]
+		return
+	}
+
+	// token without key id, use the only key of matching type
+	for _, k := range v.keys {
+		if jwkType(k) != kty {
+			continue
+		}
+		if key != nil {
+			key = nil
+			return
+		}
+		key = k
+	}
+
+	return
+}
+
+func (v *JWTVerifier) refreshKeys(lastFetch time.Time) (err error) {
+	v.fetchLock.Lock()
+	defer v.fetchLock.Unlock()
+
+	v.keyLock.RLock()
+	refreshed := v.lastFetch.After(lastFetch)
+	v.keyLock.RUnlock()
+
+	if refreshed {
+		// keys are refreshed by concurrent request
+		return
+	}
+
+	keys, err := v.fetchKeys()
+
+	v.keyLock.Lock()
+	defer v.keyLock.Unlock()
+
+	v.lastFetch = time.Now()
+
+	if err == nil {
+		v.keys = keys
+	}
+
+	return
+}
+
+func (v *JWTVerifier) fetchKeys() (keys map[string]crypto.PublicKey, err error) {
+	resp, err := v.client.Get(v.cfg.JWKSURL)
+	if err != nil {
+		err = errors.Wrapf(err, "fetch jwks failed")
+		return
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("fetch jwks failed with status %d", resp.StatusCode)
+		return
+	}
+
+	var keySet struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		err = errors.Wrapf(err, "decode jwks failed")
+		return
+	}
+
+	keys = make(map[string]crypto.PublicKey, len(keySet.Keys))
+
+	for i, k := range keySet.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		if key, err = k.publicKey(); err != nil {
+			err = errors.Wrapf(err, "decode jwks key %s failed", k.KeyID)
+			return
+		}
+
+		kid := k.KeyID
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+
+		keys[kid] = key
+	}
+
+	return
+}
+
+func (v *JWTVerifier) mapClaims(raw gin.H) (claims *JWTClaims, err error) {
+	now := time.Now()
+
+	claims = &JWTClaims{
+		Raw: raw,
+	}
+
+	if exp, ok := numericClaim(raw, "exp"); ok {
+		claims.Expires = time.Unix(exp, 0)
+		if now.After(claims.Expires.Add(v.cfg.Leeway)) {
+			claims, err = nil, ErrJWTExpired
+			return
+		}
+	}
+
+	if nbf, ok := numericClaim(raw, "nbf"); ok && now.Add(v.cfg.Leeway).Before(time.Unix(nbf, 0)) {
+		claims, err = nil, ErrJWTNotValidYet
+		return
+	}
+
+	if v.cfg.Issuer != "" {
+		if iss, _ := raw["iss"].(string); iss != v.cfg.Issuer {
+			claims, err = nil, errors.Wrapf(ErrInvalidJWTClaims, "unexpected issuer %s", iss)
+			return
+		}
+	}
+
+	if v.cfg.Audience != "" && !containsString(stringsClaim(raw["aud"]), v.cfg.Audience) {
+		claims, err = nil, errors.Wrapf(ErrInvalidJWTClaims, "audience %s is not accepted", v.cfg.Audience)
+		return
+	}
+
+	claims.UID = stringClaim(raw, defaultString(v.cfg.UIDClaim, defaultJWTUIDClaim))
+	if claims.UID == "" {
+		claims, err = nil, errors.Wrapf(ErrInvalidJWTClaims, "missing user id claim")
+		return
+	}
+
+	claims.Name = stringClaim(raw, defaultString(v.cfg.NameClaim, defaultJWTNameClaim))
+	claims.Email = stringClaim(raw, defaultString(v.cfg.EmailClaim, defaultJWTEmailClaim))
+
+	if v.cfg.StateClaim != "" {
+		claims.State = stringClaim(raw, v.cfg.StateClaim)
+	}
+
+	if v.cfg.GroupsClaim != "" {
+		claims.Groups = stringsClaim(lookupClaim(raw, v.cfg.GroupsClaim))
+	}
+
+	return
+}
+
+func (k *jsonWebKey) publicKey() (key crypto.PublicKey, err error) {
+	switch k.KeyType {
+	case "RSA":
+		var n, e []byte
+		if n, err = base64.RawURLEncoding.DecodeString(k.N); err != nil {
+			return
+		}
+		if e, err = base64.RawURLEncoding.DecodeString(k.E); err != nil {
+			return
+		}
+		key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			err = errors.Errorf("unsupported curve %s", k.Curve)
+			return
+		}
+		var x, y []byte
+		if x, err = base64.RawURLEncoding.DecodeString(k.X); err != nil {
+			return
+		}
+		if y, err = base64.RawURLEncoding.DecodeString(k.Y); err != nil {
+			return
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			err = errors.New("invalid curve point")
+			return
+		}
+		key = pub
+	default:
+		err = errors.Errorf("unsupported key type %s", k.KeyType)
+	}
+
+	return
+}
+
+// JWTManager caches the jwt verifiers of projects, so the jwks keys are shared between requests.
+type JWTManager struct {
+	verifiers sync.Map // map[string]*cachedJWTVerifier
+}
+
+type cachedJWTVerifier struct {
+	version  int64
+	verifier *JWTVerifier
+}
+
+// NewJWTManager returns new jwt verifier manager.
+func NewJWTManager() *JWTManager {
+	return &JWTManager{}
+}
+
+// Get returns the cached verifier of project, the verifier is re-created if config version is changed.
+func (m *JWTManager) Get(project string, version int64, cfg *JWTConfig) (v *JWTVerifier, err error) {
+	if cached, ok := m.verifiers.Load(project); ok && cached.(*cachedJWTVerifier).version == version {
+		v = cached.(*cachedJWTVerifier).verifier
+		return
+	}
+
+	if v, err = NewJWTVerifier(cfg); err != nil {
+		return
+	}
+
+	m.verifiers.Store(project, &cachedJWTVerifier{
+		version:  version,
+		verifier: v,
+	})
+
+	return
+}
+
+// Remove removes the cached verifier of project.
+func (m *JWTManager) Remove(project string) {
+	m.verifiers.Delete(project)
+}
+
+func jwtAlgorithm(alg string) (kty string, hash crypto.Hash, err error) {
+	if len(alg) != 5 {
+		err = errors.Wrapf(ErrUnsupportedJWTAlgorithm, "algorithm %s", alg)
+		return
+	}
+
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		err = errors.Wrapf(ErrUnsupportedJWTAlgorithm, "algorithm %s", alg)
+		return
+	}
+
+	switch alg[:2] {
+	case "HS":
+		kty = "oct"
+	case "RS", "PS":
+		kty = "RSA"
+	case "ES":
+		kty = "EC"
+	default:
+		err = errors.Wrapf(ErrUnsupportedJWTAlgorithm, "algorithm %s", alg)
+	}
+
+	return
+}
+
+func jwkType(key crypto.PublicKey) string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "EC"
+	default:
+		return ""
+	}
+}
+
+func decodeJWTSegment(seg string, v interface{}) (err error) {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err = dec.Decode(v)
+
+	return
+}
+
+func lookupClaim(raw gin.H, path string) (value interface{}) {
+	value = raw[path]
+	if value != nil || !strings.Contains(path, ".") {
+		return
+	}
+
+	var cur interface{} = map[string]interface{}(raw)
+	for _, seg := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[seg]
+	}
+
+	return cur
+}
+
+func stringClaim(raw gin.H, path string) string {
+	switch v := lookupClaim(raw, path).(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
+
+func stringsClaim(value interface{}) (res []string) {
+	switch v := value.(type) {
+	case string:
+		// space separated list like oauth scope claim
+		res = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				res = append(res, s)
+			}
+		}
+	}
+
+	return
+}
+
+func numericClaim(raw gin.H, name string) (value int64, ok bool) {
+	n, ok := raw[name].(json.Number)
+	if !ok {
+		return
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		ok = false
+		return
+	}
+
+	value = int64(f)
+	return
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func defaultString(s string, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
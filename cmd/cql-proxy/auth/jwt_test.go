@@ -0,0 +1,329 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testJWTSecret = "test jwt secret"
+
+type testJWTKeys struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestJWTKeys() (keys *testJWTKeys, err error) {
+	keys = &testJWTKeys{}
+	if keys.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return
+	}
+	keys.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func paddedBytes(n *big.Int, size int) []byte {
+	buf := make([]byte, size)
+	b := n.Bytes()
+	copy(buf[size-len(b):], b)
+	return buf
+}
+
+// jwks returns the json web key set of test keys.
+func (k *testJWTKeys) jwks() gin.H {
+	return gin.H{
+		"keys": []gin.H{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"use": "sig",
+				"n":   b64(k.rsaKey.N.Bytes()),
+				"e":   b64(big.NewInt(int64(k.rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec",
+				"crv": "P-256",
+				"x":   b64(paddedBytes(k.ecKey.X, 32)),
+				"y":   b64(paddedBytes(k.ecKey.Y, 32)),
+			},
+		},
+	}
+}
+
+// sign returns the token of claims signed by alg, the test key of algorithm is used.
+func (k *testJWTKeys) sign(alg string, kid string, claims gin.H) (token string, err error) {
+	var header, payload []byte
+	if header, err = json.Marshal(gin.H{"alg": alg, "kid": kid, "typ": "JWT"}); err != nil {
+		return
+	}
+	if payload, err = json.Marshal(claims); err != nil {
+		return
+	}
+	signed := b64(header) + "." + b64(payload)
+
+	var signature []byte
+	if alg == "none" {
+		return signed + ".", nil
+	}
+	_, hash, err := jwtAlgorithm(alg)
+	if err != nil {
+		return
+	}
+	h := hash.New()
+	_, _ = h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "HS":
+		mac := hmac.New(hash.New, []byte(testJWTSecret))
+		_, _ = mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsaKey, hash, digest)
+	case "PS":
+		signature, err = rsa.SignPSS(rand.Reader, k.rsaKey, hash, digest,
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, k.ecKey, digest); err == nil {
+			signature = append(paddedBytes(r, 32), paddedBytes(s, 32)...)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	token = signed + "." + b64(signature)
+	return
+}
+
+// serveJWKS starts a jwks server of test keys, the fetch count is returned by fetches.
+func serveJWKS(keys *testJWTKeys) (server *httptest.Server, fetches *int32) {
+	fetches = new(int32)
+	server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(fetches, 1)
+		_ = json.NewEncoder(rw).Encode(keys.jwks())
+	}))
+	return
+}
+
+func TestJWTVerifier(t *testing.T) {
+	keys, err := newTestJWTKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := serveJWKS(keys)
+	defer server.Close()
+
+	var (
+		now    = time.Now()
+		claims = func(extra gin.H) gin.H {
+			c := gin.H{
+				"sub": "user",
+				"iss": "https://issuer",
+				"aud": "project",
+				"exp": now.Add(time.Hour).Unix(),
+			}
+			for k, v := range extra {
+				c[k] = v
+			}
+			return c
+		}
+		fullConfig = &JWTConfig{
+			Issuer:   "https://issuer",
+			Audience: "project",
+			JWKSURL:  server.URL,
+			Secret:   testJWTSecret,
+			Leeway:   time.Minute,
+		}
+		jwksConfig = &JWTConfig{
+			JWKSURL: server.URL,
+		}
+	)
+
+	Convey("verify jwt tokens", t, func() {
+		cases := []struct {
+			name   string
+			cfg    *JWTConfig
+			alg    string
+			kid    string
+			claims gin.H
+			err    error
+		}{
+			{name: "HS256 signature", cfg: fullConfig, alg: "HS256", claims: claims(nil)},
+			{name: "HS512 signature", cfg: fullConfig, alg: "HS512", claims: claims(nil)},
+			{name: "RS256 signature", cfg: fullConfig, alg: "RS256", kid: "rsa", claims: claims(nil)},
+			{name: "RS384 signature", cfg: fullConfig, alg: "RS384", kid: "rsa", claims: claims(nil)},
+			{name: "PS256 signature", cfg: fullConfig, alg: "PS256", kid: "rsa", claims: claims(nil)},
+			{name: "ES256 signature", cfg: fullConfig, alg: "ES256", kid: "ec", claims: claims(nil)},
+			{name: "ES256 signature without key id", cfg: fullConfig, alg: "ES256", claims: claims(nil)},
+			{
+				name: "alg none", cfg: fullConfig, alg: "none", claims: claims(nil),
+				err: ErrUnsupportedJWTAlgorithm,
+			},
+			{
+				name: "HS token against jwks only config", cfg: jwksConfig, alg: "HS256", kid: "rsa",
+				claims: claims(nil), err: ErrJWTKeyNotConfigured,
+			},
+			{
+				name: "RS algorithm with EC key", cfg: fullConfig, alg: "RS256", kid: "ec",
+				claims: claims(nil), err: ErrUnsupportedJWTAlgorithm,
+			},
+			{
+				name: "ES algorithm with RSA key", cfg: fullConfig, alg: "ES256", kid: "rsa",
+				claims: claims(nil), err: ErrUnsupportedJWTAlgorithm,
+			},
+			{
+				name: "algorithm not allowed", alg: "HS256", claims: claims(nil),
+				cfg: &JWTConfig{Secret: testJWTSecret, Algorithms: []string{"RS256"}},
+				err: ErrUnsupportedJWTAlgorithm,
+			},
+			{
+				name: "expired within leeway", cfg: fullConfig, alg: "HS256",
+				claims: claims(gin.H{"exp": now.Add(-30 * time.Second).Unix()}),
+			},
+			{
+				name: "expired beyond leeway", cfg: fullConfig, alg: "HS256",
+				claims: claims(gin.H{"exp": now.Add(-2 * time.Minute).Unix()}), err: ErrJWTExpired,
+			},
+			{
+				name: "not valid yet within leeway", cfg: fullConfig, alg: "HS256",
+				claims: claims(gin.H{"nbf": now.Add(30 * time.Second).Unix()}),
+			},
+			{
+				name: "not valid yet beyond leeway", cfg: fullConfig, alg: "HS256",
+				claims: claims(gin.H{"nbf": now.Add(2 * time.Minute).Unix()}), err: ErrJWTNotValidYet,
+			},
+			{
+				name: "wrong issuer", cfg: fullConfig, alg: "HS256",
+				claims: claims(gin.H{"iss": "https://attacker"}), err: ErrInvalidJWTClaims,
+			},
+			{
+				name: "wrong audience", cfg: fullConfig, alg: "HS256",
+				claims: claims(gin.H{"aud": "other"}), err: ErrInvalidJWTClaims,
+			},
+			{
+				name: "audience list", cfg: fullConfig, alg: "RS256", kid: "rsa",
+				claims: claims(gin.H{"aud": []string{"other", "project"}}),
+			},
+			{
+				name: "missing user id", cfg: fullConfig, alg: "HS256",
+				claims: claims(gin.H{"sub": ""}), err: ErrInvalidJWTClaims,
+			},
+		}
+
+		for _, c := range cases {
+			Convey(c.name, func() {
+				v, err := NewJWTVerifier(c.cfg)
+				So(err, ShouldBeNil)
+				token, err := keys.sign(c.alg, c.kid, c.claims)
+				So(err, ShouldBeNil)
+				res, err := v.Verify(token)
+				if c.err != nil {
+					So(errors.Cause(err), ShouldEqual, c.err)
+					So(res, ShouldBeNil)
+				} else {
+					So(err, ShouldBeNil)
+					So(res.UID, ShouldEqual, "user")
+				}
+			})
+		}
+	})
+
+	Convey("tampered tokens", t, func() {
+		v, err := NewJWTVerifier(fullConfig)
+		So(err, ShouldBeNil)
+		for _, alg := range []string{"HS256", "RS256", "PS256", "ES256"} {
+			kid := map[string]string{"RS": "rsa", "PS": "rsa", "ES": "ec"}[alg[:2]]
+			token, err := keys.sign(alg, kid, claims(nil))
+			So(err, ShouldBeNil)
+			other, err := keys.sign(alg, kid, claims(gin.H{"sub": "admin"}))
+			So(err, ShouldBeNil)
+
+			// replace the claims segment of token
+			parts, otherParts := strings.Split(token, "."), strings.Split(other, ".")
+			tampered := parts[0] + "." + otherParts[1] + "." + parts[2]
+			_, err = v.Verify(tampered)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidJWTSignature)
+		}
+		_, err = v.Verify("not a token")
+		So(errors.Cause(err), ShouldEqual, ErrInvalidJWT)
+	})
+}
+
+func TestJWTVerifierKeyRefresh(t *testing.T) {
+	keys, err := newTestJWTKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, fetches := serveJWKS(keys)
+	defer server.Close()
+
+	Convey("unknown key id refresh is throttled", t, func() {
+		v, err := NewJWTVerifier(&JWTConfig{JWKSURL: server.URL})
+		So(err, ShouldBeNil)
+
+		token, err := keys.sign("RS256", "rsa", gin.H{"sub": "user"})
+		So(err, ShouldBeNil)
+		_, err = v.Verify(token)
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(fetches), ShouldEqual, 1)
+
+		unknown, err := keys.sign("RS256", "rotated", gin.H{"sub": "user"})
+		So(err, ShouldBeNil)
+		for i := 0; i < 10; i++ {
+			_, err = v.Verify(unknown)
+			So(errors.Cause(err), ShouldEqual, ErrJWTKeyNotFound)
+		}
+		So(atomic.LoadInt32(fetches), ShouldEqual, 1)
+
+		// refresh is allowed after the minimum interval
+		v.keyLock.Lock()
+		v.lastFetch = time.Now().Add(-minJWKSRefreshInterval)
+		v.keyLock.Unlock()
+		_, err = v.Verify(unknown)
+		So(errors.Cause(err), ShouldEqual, ErrJWTKeyNotFound)
+		So(atomic.LoadInt32(fetches), ShouldEqual, 2)
+
+		// known keys are served from cache
+		_, err = v.Verify(token)
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(fetches), ShouldEqual, 2)
+	})
+}
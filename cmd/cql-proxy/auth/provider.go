@@ -0,0 +1,98 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// ProviderConfig defines the uniform config of user auth provider.
+type ProviderConfig struct {
+	ClientID     string
+	ClientSecret string
+	// redirect url after authorization, only used by authorize request.
+	Callback string
+	// provider specific config items, e.g. team_id/key_id of apple.
+	Extra map[string]string
+}
+
+// UserAuthProvider defines the third-party user login provider plugin.
+type UserAuthProvider interface {
+	// Authorize starts the login process, usually redirects user to provider login page.
+	Authorize(c *gin.Context, cfg *ProviderConfig)
+	// Callback resolves the user info from provider callback request.
+	Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback)
+}
+
+var (
+	providersLock sync.RWMutex
+	providers     = map[string]UserAuthProvider{}
+)
+
+// RegisterUserAuthProvider registers user auth provider with name, it panics on duplicate registration.
+func RegisterUserAuthProvider(name string, p UserAuthProvider) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	if p == nil {
+		panic("register nil user auth provider " + name)
+	}
+	if _, exists := providers[name]; exists {
+		panic("duplicate user auth provider " + name)
+	}
+
+	providers[name] = p
+}
+
+// GetUserAuthProvider returns the registered user auth provider.
+func GetUserAuthProvider(name string) (p UserAuthProvider, err error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	p, ok := providers[name]
+	if !ok {
+		err = errors.Wrapf(ErrUnsupportedUserAuthProvider, "provider %s", name)
+	}
+
+	return
+}
+
+// UserAuthProviders returns the sorted names of all registered user auth providers.
+func UserAuthProviders() (names []string) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+
+	for name := range providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return
+}
+
+func (cfg *ProviderConfig) extra(key string) string {
+	if cfg == nil || cfg.Extra == nil {
+		return ""
+	}
+
+	return cfg.Extra[key]
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dghubble/gologin"
+	oauth2Login "github.com/dghubble/gologin/oauth2"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	appleAuthURL  = "https://appleid.apple.com/auth/authorize"
+	appleTokenURL = "https://appleid.apple.com/auth/token"
+	appleKeysURL  = "https://appleid.apple.com/auth/keys"
+	appleIssuer   = "https://appleid.apple.com"
+
+	// appleClientSecretTTL defines the lifetime of generated client secret, only used for code exchange.
+	appleClientSecretTTL = 5 * time.Minute
+)
+
+// appleVerifiers caches the apple id token verifiers by client id to share apple jwks keys.
+var appleVerifiers = NewJWTManager()
+
+func init() {
+	RegisterUserAuthProvider("apple", &appleProvider{})
+}
+
+// appleProvider implements sign in with apple.
+//
+// The ClientID is the services id, the ClientSecret is the content of the p8 private key file,
+// the "team_id" and "key_id" extra config items are required to sign the client secret.
+type appleProvider struct{}
+
+// Authorize implements UserAuthProvider.Authorize.
+func (p *appleProvider) Authorize(c *gin.Context, cfg *ProviderConfig) {
+	oauthCfg := getAppleConfig(cfg.ClientID, "", cfg.Callback)
+
+	oauth2Login.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			state, err := oauth2Login.StateFromContext(r.Context())
+			if err != nil {
+				_ = c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
+			// name and email scopes require the authorization result to be posted
+			http.Redirect(rw, r, oauthCfg.AuthCodeURL(state,
+				oauth2.SetAuthURLParam("response_mode", "form_post")), http.StatusFound)
+		}),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+// Callback implements UserAuthProvider.Callback.
+func (p *appleProvider) Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback) {
+	clientSecret, err := appleClientSecret(cfg)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	oauth2Login.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		oauth2Login.CallbackHandler(getAppleConfig(cfg.ClientID, clientSecret, ""),
+			appleAuthCallback(c, success, fail, cfg.ClientID), wrapFailCallback(fail)),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+func getAppleConfig(clientID string, clientSecret string, callback string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  callback,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   appleAuthURL,
+			TokenURL:  appleTokenURL,
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		Scopes: []string{
+			"name",
+			"email",
+		},
+	}
+}
+
+// appleClientSecret generates the ES256 signed client secret required by apple token api.
+func appleClientSecret(cfg *ProviderConfig) (secret string, err error) {
+	teamID, keyID := cfg.extra("team_id"), cfg.extra("key_id")
+	if teamID == "" || keyID == "" {
+		err = errors.New("team_id and key_id are required for apple provider")
+		return
+	}
+
+	block, _ := pem.Decode([]byte(cfg.ClientSecret))
+	if block == nil {
+		err = errors.New("invalid apple private key")
+		return
+	}
+
+	rawKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		err = errors.Wrapf(err, "parse apple private key failed")
+		return
+	}
+
+	key, ok := rawKey.(*ecdsa.PrivateKey)
+	if !ok {
+		err = errors.New("apple private key is not ecdsa key")
+		return
+	}
+
+	now := time.Now()
+
+	header, _ := json.Marshal(map[string]string{
+		"alg": "ES256",
+		"kid": keyID,
+	})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": teamID,
+		"iat": now.Unix(),
+		"exp": now.Add(appleClientSecretTTL).Unix(),
+		"aud": appleIssuer,
+		"sub": cfg.ClientID,
+	})
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	h := crypto.SHA256.New()
+	_, _ = h.Write([]byte(signed))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+	if err != nil {
+		err = errors.Wrapf(err, "sign apple client secret failed")
+		return
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(signature[size-len(rb):size], rb)
+	copy(signature[2*size-len(sb):], sb)
+
+	secret = signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	return
+}
+
+func appleAuthCallback(c *gin.Context, success UserSuccessCallback, fail UserFailCallback, clientID string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token, err := oauth2Login.TokenFromContext(r.Context())
+		if err != nil {
+			err = errors.Wrapf(err, "get apple token info failed")
+			fail(err)
+			return
+		}
+
+		idToken, _ := token.Extra("id_token").(string)
+		if idToken == "" {
+			fail(errors.New("apple id token not found"))
+			return
+		}
+
+		verifier, err := appleVerifiers.Get(clientID, 0, &JWTConfig{
+			Issuer:     appleIssuer,
+			Audience:   clientID,
+			JWKSURL:    appleKeysURL,
+			Algorithms: []string{"RS256"},
+		})
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		claims, err := verifier.Verify(idToken)
+		if err != nil {
+			err = errors.Wrapf(err, "verify apple id token failed")
+			fail(err)
+			return
+		}
+
+		if success != nil {
+			// user name is only posted on the first authorization
+			var user struct {
+				Name struct {
+					FirstName string `json:"firstName"`
+					LastName  string `json:"lastName"`
+				} `json:"name"`
+			}
+
+			_ = json.Unmarshal([]byte(r.FormValue("user")), &user)
+
+			name := strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+			if name == "" {
+				name = claims.Email
+			}
+
+			extraInfo := gin.H{}
+			for k, v := range claims.Raw {
+				extraInfo[k] = v
+			}
+			extraInfo["name"] = name
+
+			success(&UserInfo{
+				UID:   claims.UID,
+				Name:  name,
+				Email: claims.Email,
+				Extra: extraInfo,
+			})
+		}
+	})
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"net/http"
+
+	"github.com/dghubble/gologin"
+	"github.com/dghubble/gologin/facebook"
+	oauth2Login "github.com/dghubble/gologin/oauth2"
+	"github.com/dghubble/sling"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	facebookOAuth2 "golang.org/x/oauth2/facebook"
+)
+
+func init() {
+	RegisterUserAuthProvider("facebook", &facebookProvider{})
+}
+
+// facebookProvider implements facebook oauth2 login.
+type facebookProvider struct{}
+
+// Authorize implements UserAuthProvider.Authorize.
+func (p *facebookProvider) Authorize(c *gin.Context, cfg *ProviderConfig) {
+	facebook.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		facebook.LoginHandler(
+			getFacebookConfig(
+				cfg.ClientID,
+				cfg.ClientSecret,
+				cfg.Callback,
+			), nil),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+// Callback implements UserAuthProvider.Callback.
+func (p *facebookProvider) Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback) {
+	oauthCfg := getFacebookConfig(cfg.ClientID, cfg.ClientSecret, "")
+	facebook.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		oauth2Login.CallbackHandler(oauthCfg,
+			facebookAuthCallback(c, success, fail, oauthCfg), wrapFailCallback(fail)),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+func getFacebookConfig(clientID string, clientSecret string, callback string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  callback,
+		Endpoint:     facebookOAuth2.Endpoint,
+	}
+}
+
+func facebookAuthCallback(c *gin.Context, success UserSuccessCallback, fail UserFailCallback, cfg *oauth2.Config) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token, err := oauth2Login.TokenFromContext(r.Context())
+		if err != nil {
+			err = errors.Wrapf(err, "get facebook token info failed")
+			fail(err)
+			return
+		}
+
+		if success != nil {
+			resp := struct {
+				ID      string `json:"id"`
+				Name    string `json:"name"`
+				Email   string `json:"email"`
+				Picture struct {
+					IsDefaultPic bool   `json:"is_silhouette"`
+					URL          string `json:"url"`
+				} `json:"picture"`
+			}{}
+
+			oauthClient := sling.New().Client(cfg.Client(c.Request.Context(), token)).
+				Base("https://graph.facebook.com/v3.2")
+			_, err = oauthClient.New().Set("Accept", "application/json").
+				Get("me?fields=id,name,email,picture").ReceiveSuccess(&resp)
+			if err != nil {
+				err = errors.Wrapf(err, "request facebook user info failed")
+				fail(err)
+				return
+			}
+
+			success(&UserInfo{
+				UID:    resp.ID,
+				Name:   resp.Name,
+				Email:  resp.Email,
+				Avatar: resp.Picture.URL,
+				Extra: gin.H{
+					"avatar":            resp.Picture.URL,
+					"is_default_avatar": resp.Picture.IsDefaultPic,
+					"id":                resp.ID,
+					"name":              resp.Name,
+					"email":             resp.Email,
+				},
+			})
+		}
+	})
+}
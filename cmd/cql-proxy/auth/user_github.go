@@ -0,0 +1,148 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dghubble/gologin"
+	"github.com/dghubble/gologin/github"
+	oauth2Login "github.com/dghubble/gologin/oauth2"
+	"github.com/gin-gonic/gin"
+	githubRPC "github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	githubOAuth2 "golang.org/x/oauth2/github"
+)
+
+func init() {
+	RegisterUserAuthProvider("github", &githubProvider{})
+}
+
+// githubProvider implements github oauth2 login.
+type githubProvider struct{}
+
+// Authorize implements UserAuthProvider.Authorize.
+func (p *githubProvider) Authorize(c *gin.Context, cfg *ProviderConfig) {
+	github.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		github.LoginHandler(
+			getGithubConfig(
+				cfg.ClientID,
+				cfg.ClientSecret,
+				cfg.Callback,
+			), nil),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+// Callback implements UserAuthProvider.Callback.
+func (p *githubProvider) Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback) {
+	oauthCfg := getGithubConfig(cfg.ClientID, cfg.ClientSecret, "")
+	github.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		oauth2Login.CallbackHandler(oauthCfg,
+			githubAuthCallback(c, success, fail, oauthCfg), wrapFailCallback(fail)),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+func getGithubConfig(clientID string, clientSecret string, callback string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  callback,
+		Endpoint:     githubOAuth2.Endpoint,
+		Scopes: []string{
+			"user:email",
+			"read:user",
+		},
+	}
+}
+
+func githubAuthCallback(c *gin.Context, success UserSuccessCallback, fail UserFailCallback, cfg *oauth2.Config) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		tokenInfo, err := oauth2Login.TokenFromContext(r.Context())
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		rpc := githubRPC.NewClient(cfg.Client(r.Context(), tokenInfo))
+
+		userInfo, _, err := rpc.Users.Get(r.Context(), "")
+		if err != nil {
+			err = errors.Wrapf(err, "get github user info failed")
+			fail(err)
+			return
+		}
+
+		if success != nil {
+			var extraInfo gin.H
+
+			userInfoJSON, err := json.Marshal(userInfo)
+			if err != nil {
+				err = errors.Wrapf(err, "marshal user info failed")
+				fail(err)
+				return
+			}
+
+			err = json.Unmarshal(userInfoJSON, &extraInfo)
+			if err != nil {
+				err = errors.Wrapf(err, "unmarshal extra user info failed")
+				fail(err)
+				return
+			}
+
+			if userInfo.GetID() == 0 {
+				err = errors.New("could not get user id")
+				fail(err)
+				return
+			}
+
+			// get primary email instead of public email
+			// userInfo.GetEmail returns the public email, may not be primary email
+			emails, _, err := rpc.Users.ListEmails(r.Context(), nil)
+			if err != nil {
+				err = errors.Wrapf(err, "get github user primary email failed")
+				fail(err)
+				return
+			}
+
+			var primaryEmail string
+
+			for _, email := range emails {
+				if email.GetPrimary() && email.GetVerified() {
+					primaryEmail = email.GetEmail()
+				}
+			}
+
+			if primaryEmail == "" {
+				err = errors.New("could not get user email")
+				return
+			}
+
+			success(&UserInfo{
+				UID:    fmt.Sprint(userInfo.GetID()),
+				Name:   userInfo.GetName(),
+				Email:  primaryEmail,
+				Avatar: userInfo.GetAvatarURL(),
+				Extra:  extraInfo,
+			})
+		}
+	})
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dghubble/gologin"
+	"github.com/dghubble/gologin/google"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	googleOAuth2 "golang.org/x/oauth2/google"
+)
+
+func init() {
+	RegisterUserAuthProvider("google", &googleProvider{})
+}
+
+// googleProvider implements google oauth2 login.
+type googleProvider struct{}
+
+// Authorize implements UserAuthProvider.Authorize.
+func (p *googleProvider) Authorize(c *gin.Context, cfg *ProviderConfig) {
+	google.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		google.LoginHandler(
+			getGoogleConfig(
+				cfg.ClientID,
+				cfg.ClientSecret,
+				cfg.Callback,
+			), nil),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+// Callback implements UserAuthProvider.Callback.
+func (p *googleProvider) Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback) {
+	google.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		google.CallbackHandler(
+			getGoogleConfig(cfg.ClientID, cfg.ClientSecret, ""),
+			googleAuthCallback(c, success, fail), wrapFailCallback(fail)),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+func getGoogleConfig(clientID string, clientSecret string, callback string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  callback,
+		Endpoint:     googleOAuth2.Endpoint,
+	}
+}
+
+func googleAuthCallback(c *gin.Context, success UserSuccessCallback, fail UserFailCallback) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		userInfo, err := google.UserFromContext(r.Context())
+		if err != nil {
+			err = errors.Wrapf(err, "get user info failed")
+			fail(err)
+			return
+		}
+
+		// build userInfo and set into callback
+		if success != nil {
+			var extraInfo gin.H
+
+			userInfoJSON, err := json.Marshal(userInfo)
+			if err != nil {
+				err = errors.Wrapf(err, "marshal user info failed")
+				fail(err)
+				return
+			}
+
+			err = json.Unmarshal(userInfoJSON, &extraInfo)
+			if err != nil {
+				err = errors.Wrapf(err, "unmarshal extra user info failed")
+				fail(err)
+				return
+			}
+
+			success(&UserInfo{
+				UID:    userInfo.Id,
+				Name:   userInfo.Name,
+				Email:  userInfo.Email,
+				Avatar: userInfo.Picture,
+				Extra:  extraInfo,
+			})
+		}
+	})
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dghubble/gologin/twitter"
+	"github.com/dghubble/oauth1"
+	twitterOAuth1 "github.com/dghubble/oauth1/twitter"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	RegisterUserAuthProvider("twitter", &twitterProvider{})
+}
+
+// twitterProvider implements twitter oauth1 login.
+type twitterProvider struct{}
+
+// Authorize implements UserAuthProvider.Authorize.
+func (p *twitterProvider) Authorize(c *gin.Context, cfg *ProviderConfig) {
+	twitter.LoginHandler(getTwitterConfig(
+		cfg.ClientID,
+		cfg.ClientSecret,
+		cfg.Callback,
+	), nil).ServeHTTP(c.Writer, c.Request)
+}
+
+// Callback implements UserAuthProvider.Callback.
+func (p *twitterProvider) Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback) {
+	twitter.CallbackHandler(
+		getTwitterConfig(cfg.ClientID, cfg.ClientSecret, ""),
+		twitterAuthCallback(c, success, fail), wrapFailCallback(fail),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+func getTwitterConfig(clientID string, clientSecret string, callback string) *oauth1.Config {
+	return &oauth1.Config{
+		ConsumerKey:    clientID,
+		ConsumerSecret: clientSecret,
+		CallbackURL:    callback,
+		Endpoint:       twitterOAuth1.AuthorizeEndpoint,
+	}
+}
+
+func twitterAuthCallback(c *gin.Context, success UserSuccessCallback, fail UserFailCallback) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		userInfo, err := twitter.UserFromContext(r.Context())
+		if err != nil {
+			err = errors.Wrapf(err, "get twitter user info failed")
+			fail(err)
+			return
+		}
+
+		if success != nil {
+			var extraInfo gin.H
+
+			userInfoJSON, err := json.Marshal(userInfo)
+			if err != nil {
+				err = errors.Wrapf(err, "marshal user info failed")
+				fail(err)
+				return
+			}
+
+			err = json.Unmarshal(userInfoJSON, &extraInfo)
+			if err != nil {
+				err = errors.Wrapf(err, "unmarshal extra user info failed")
+				fail(err)
+				return
+			}
+
+			success(&UserInfo{
+				UID:    userInfo.IDStr,
+				Name:   userInfo.Name,
+				Email:  userInfo.Email,
+				Avatar: userInfo.ProfileImageURLHttps,
+				Extra:  extraInfo,
+			})
+		}
+	})
+}
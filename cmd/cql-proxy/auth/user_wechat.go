@@ -0,0 +1,188 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/dghubble/sling"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	wechatAPIBase = "https://api.weixin.qq.com/"
+)
+
+func init() {
+	RegisterUserAuthProvider("wechat_mini_program", &wechatMiniProgramProvider{})
+}
+
+// wechatMiniProgramProvider implements wechat mini program login.
+//
+// The mini program gets login code using wx.login and posts the code to callback api directly,
+// the optional encrypted_data and iv fields from wx.getUserInfo are decrypted for user profile.
+type wechatMiniProgramProvider struct{}
+
+// Authorize implements UserAuthProvider.Authorize.
+func (p *wechatMiniProgramProvider) Authorize(c *gin.Context, cfg *ProviderConfig) {
+	_ = c.AbortWithError(http.StatusBadRequest, ErrAuthorizeNotRequired)
+}
+
+// Callback implements UserAuthProvider.Callback.
+func (p *wechatMiniProgramProvider) Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback) {
+	code := c.Request.FormValue("code")
+	if code == "" {
+		fail(errors.New("wechat login code is required"))
+		return
+	}
+
+	var resp struct {
+		OpenID     string `json:"openid"`
+		SessionKey string `json:"session_key"`
+		UnionID    string `json:"unionid"`
+		ErrCode    int    `json:"errcode"`
+		ErrMsg     string `json:"errmsg"`
+	}
+
+	_, err := sling.New().Base(wechatAPIBase).Set("Accept", "application/json").
+		Get("sns/jscode2session").QueryStruct(&struct {
+		AppID     string `url:"appid"`
+		Secret    string `url:"secret"`
+		JSCode    string `url:"js_code"`
+		GrantType string `url:"grant_type"`
+	}{
+		AppID:     cfg.ClientID,
+		Secret:    cfg.ClientSecret,
+		JSCode:    code,
+		GrantType: "authorization_code",
+	}).ReceiveSuccess(&resp)
+	if err != nil {
+		err = errors.Wrapf(err, "request wechat session failed")
+		fail(err)
+		return
+	}
+
+	if resp.ErrCode != 0 || resp.OpenID == "" {
+		fail(errors.Errorf("get wechat session failed: %d %s", resp.ErrCode, resp.ErrMsg))
+		return
+	}
+
+	if success == nil {
+		return
+	}
+
+	extraInfo := gin.H{
+		"openid":  resp.OpenID,
+		"unionid": resp.UnionID,
+	}
+
+	if encryptedData := c.Request.FormValue("encrypted_data"); encryptedData != "" {
+		var profile gin.H
+		profile, err = wechatDecryptUserInfo(cfg.ClientID, resp.SessionKey, encryptedData, c.Request.FormValue("iv"))
+		if err != nil {
+			fail(err)
+			return
+		}
+		for k, v := range profile {
+			extraInfo[k] = v
+		}
+	}
+
+	// use union id to identify user across apps of same wechat open platform account
+	uid := resp.UnionID
+	if uid == "" {
+		uid = resp.OpenID
+	}
+
+	name, _ := extraInfo["nickName"].(string)
+	if name == "" {
+		name = uid
+	}
+	avatar, _ := extraInfo["avatarUrl"].(string)
+
+	success(&UserInfo{
+		UID:    uid,
+		Name:   name,
+		Email:  uid + "@fake.email.wechat.com", // use dummy email
+		Avatar: avatar,
+		Extra:  extraInfo,
+	})
+}
+
+func wechatDecryptUserInfo(appID string, sessionKey string, encryptedData string, iv string) (
+	profile gin.H, err error) {
+	key, err := base64.StdEncoding.DecodeString(sessionKey)
+	if err != nil {
+		err = errors.Wrapf(err, "decode wechat session key failed")
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil {
+		err = errors.Wrapf(err, "decode wechat encrypted data failed")
+		return
+	}
+	ivBytes, err := base64.StdEncoding.DecodeString(iv)
+	if err != nil {
+		err = errors.Wrapf(err, "decode wechat iv failed")
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		err = errors.Wrapf(err, "init wechat cipher failed")
+		return
+	}
+
+	if len(ivBytes) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		err = errors.New("invalid wechat encrypted data")
+		return
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, ivBytes).CryptBlocks(plain, data)
+
+	// remove pkcs#7 padding
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > block.BlockSize() ||
+		!bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		err = errors.New("invalid wechat encrypted data padding")
+		return
+	}
+	plain = plain[:len(plain)-padding]
+
+	if err = json.Unmarshal(plain, &profile); err != nil {
+		err = errors.Wrapf(err, "decode wechat user info failed")
+		return
+	}
+
+	// check the data is issued to current mini program
+	watermark, _ := profile["watermark"].(map[string]interface{})
+	if watermark == nil || watermark["appid"] != appID {
+		profile, err = nil, errors.New("wechat user info watermark mismatch")
+		return
+	}
+
+	delete(profile, "watermark")
+
+	return
+}
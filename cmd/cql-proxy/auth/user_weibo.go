@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dghubble/gologin"
+	oauth2Login "github.com/dghubble/gologin/oauth2"
+	"github.com/dghubble/sling"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/jsonq"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	RegisterUserAuthProvider("weibo", &sinaWeiboProvider{})
+}
+
+// sinaWeiboProvider implements sina weibo oauth2 login.
+type sinaWeiboProvider struct{}
+
+// Authorize implements UserAuthProvider.Authorize.
+func (p *sinaWeiboProvider) Authorize(c *gin.Context, cfg *ProviderConfig) {
+	oauth2Login.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		oauth2Login.LoginHandler(
+			getSinaWeiboConfig(
+				cfg.ClientID,
+				cfg.ClientSecret,
+				cfg.Callback,
+			), nil),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+// Callback implements UserAuthProvider.Callback.
+func (p *sinaWeiboProvider) Callback(c *gin.Context, cfg *ProviderConfig, success UserSuccessCallback, fail UserFailCallback) {
+	oauthCfg := getSinaWeiboConfig(cfg.ClientID, cfg.ClientSecret, "")
+	oauth2Login.StateHandler(
+		gologin.DebugOnlyCookieConfig,
+		oauth2Login.CallbackHandler(oauthCfg,
+			sinaWeiboAuthCallback(c, success, fail, oauthCfg), wrapFailCallback(fail)),
+	).ServeHTTP(c.Writer, c.Request)
+}
+
+func getSinaWeiboConfig(clientID string, clientSecret string, callback string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  callback,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://api.weibo.com/oauth2/authorize",
+			TokenURL: "https://api.weibo.com/oauth2/access_token",
+		},
+	}
+}
+
+func sinaWeiboAuthCallback(c *gin.Context, success UserSuccessCallback, fail UserFailCallback, cfg *oauth2.Config) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token, err := oauth2Login.TokenFromContext(r.Context())
+		if err != nil {
+			err = errors.Wrapf(err, "get weibo token info failed")
+			fail(err)
+			return
+		}
+
+		if success != nil {
+			// weibo returns data with unspecific type
+			var (
+				userInfo gin.H
+				uidData  gin.H
+			)
+
+			oauthClient := sling.New().Client(cfg.Client(c.Request.Context(), token)).
+				Base("https://api.weibo.com/2")
+			_, err = oauthClient.New().Set("Accept", "application/json").
+				Get("account/get_uid.json").ReceiveSuccess(&uidData)
+			if err != nil {
+				err = errors.Wrapf(err, "get weibo user id failed")
+				fail(err)
+				return
+			}
+
+			_, err = oauthClient.New().Set("Accept", "application/json").
+				Get(fmt.Sprintf("users/show.json?uid=%v", uidData["uid"])).ReceiveSuccess(&userInfo)
+			if err != nil {
+				err = errors.Wrapf(err, "get weibo user info failed")
+				fail(err)
+				return
+			}
+
+			// remove userinfo recent weibo status
+			delete(userInfo, "status")
+			delete(userInfo, "statuses_count")
+
+			res := jsonq.NewQuery(userInfo)
+			idStr, err := res.String("idstr")
+			if err != nil {
+				err = errors.Wrapf(err, "get weibo user id failed")
+				fail(err)
+				return
+			}
+			screenName, _ := res.String("screen_name")
+			avatar, _ := res.String("avatar_large")
+
+			success(&UserInfo{
+				UID:    idStr,
+				Name:   screenName,
+				Email:  idStr + "@fake.email.weibo.com", // use dummy email
+				Avatar: avatar,
+				Extra:  userInfo,
+			})
+		}
+	})
+}
@@ -60,10 +60,12 @@ type AdminAuthConfig struct {
 
 // UserAuthConfig defines the user auth feature config for proxy service.
 type UserAuthConfig struct {
-	// globally enabled oauth/openid providers for all projects.
+	// globally enabled oauth/openid providers for all projects,
+	// available providers: google, facebook, twitter, github, weibo, apple, wechat_mini_program.
 	Providers []string `yaml:"Providers" validate:"required"`
 
 	// provider specific configs, first key is provider id, second key is provide config item.
+	// the items are used as default extra config of project oauth provider config.
 	Extra map[string]gin.H `yaml:"Extra"`
 }
 
@@ -73,6 +75,8 @@ type Config struct {
 	// platform wildcard hosts for proxy to accept and dispatch requests.
 	// project specific hosts is defined in project admin settings.
 	Hosts []string `yaml:"Hosts" validate:"dive,required"`
+	// public base url of proxy service used in generated links, e.g. https://api.covenantsql.io.
+	PublicURL string `yaml:"PublicURL" validate:"omitempty,url"`
 
 	// persistence config for proxy service.
 	Storage *StorageConfig `yaml:"Storage" validate:"required"`
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gql
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// JSONScalar defines the scalar of arbitrary json value, used by mongodb like filter/order/update objects.
+var JSONScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Arbitrary JSON value.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: parseJSONLiteral,
+})
+
+// NumberScalar defines the scalar of NUMBER column, both 64-bit integer and float values are kept as is.
+var NumberScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Number",
+	Description: "64-bit integer or float number.",
+	Serialize:   coerceNumber,
+	ParseValue:  coerceNumber,
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch v := valueAST.(type) {
+		case *ast.IntValue:
+			return parseNumber(v.Value)
+		case *ast.FloatValue:
+			return parseNumber(v.Value)
+		case *ast.StringValue:
+			return parseNumber(v.Value)
+		}
+		return nil
+	},
+})
+
+func parseJSONLiteral(valueAST ast.Value) interface{} {
+	switch v := valueAST.(type) {
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.IntValue:
+		return parseNumber(v.Value)
+	case *ast.FloatValue:
+		return parseNumber(v.Value)
+	case *ast.EnumValue:
+		return v.Value
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(v.Values))
+		for _, item := range v.Values {
+			list = append(list, parseJSONLiteral(item))
+		}
+		return list
+	case *ast.ObjectValue:
+		obj := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			obj[f.Name.Value] = parseJSONLiteral(f.Value)
+		}
+		return obj
+	}
+	return nil
+}
+
+func parseNumber(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return nil
+}
+
+func coerceNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return float64(v)
+		}
+		return int64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case string:
+		return parseNumber(v)
+	case []byte:
+		return parseNumber(string(v))
+	}
+	return nil
+}
@@ -0,0 +1,529 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/pkg/errors"
+)
+
+const (
+	executorKey  = "executor"
+	cursorPrefix = "offset:"
+)
+
+var (
+	nameRegex = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+	// reservedTypeNames defines the type names could not be generated from table names.
+	reservedTypeNames = map[string]bool{
+		"Query": true, "Mutation": true, "PageInfo": true, "MutationResult": true, "JSON": true, "Number": true,
+		"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true,
+	}
+
+	pageInfoType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"hasPreviousPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"startCursor":     &graphql.Field{Type: graphql.String},
+			"endCursor":       &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	mutationResultType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "MutationResult",
+		Fields: graphql.Fields{
+			"affected_rows":  &graphql.Field{Type: graphql.NewNonNull(NumberScalar)},
+			"last_insert_id": &graphql.Field{Type: NumberScalar},
+		},
+	})
+)
+
+// Table defines the project table exposed in graphql schema.
+type Table struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	Types      []string `json:"types"`
+	PrimaryKey string   `json:"primary_key"`
+}
+
+// Page defines the rows of a connection page.
+type Page struct {
+	Rows    []map[string]interface{}
+	HasNext bool
+}
+
+// Executor executes the queries resolved from graphql request, the project rules must be enforced by executor.
+type Executor interface {
+	// Find returns rows from offset, the row limit is resolved by executor if first is not provided.
+	Find(table string, filter map[string]interface{}, order map[string]interface{}, offset int64, first *int64) (
+		page *Page, err error)
+	Count(table string, filter map[string]interface{}) (count int64, err error)
+	Insert(table string, data map[string]interface{}) (lastInsertID int64, affectedRows int64, err error)
+	Update(table string, filter map[string]interface{}, update map[string]interface{}, justOne bool) (
+		affectedRows int64, err error)
+	Remove(table string, filter map[string]interface{}, justOne bool) (affectedRows int64, err error)
+}
+
+type connection struct {
+	table  string
+	filter map[string]interface{}
+	offset int64
+	page   *Page
+}
+
+// NewSchema generates graphql schema from table definitions, tables or columns with names not valid in graphql
+// are not exposed.
+//
+// For each table, following fields are generated:
+//
+//	query    { <table>(filter, order, first, after): <Table>Connection!, <table>_by_pk(<pk>): <Table> }
+//	mutation { insert_<table>(data), update_<table>(filter, set, update, one), remove_<table>(filter, one) }
+//
+// The filter/order/update arguments accept the mongodb like objects of data api, objects with $ prefixed operators
+// must be passed by variables since $ is not allowed in graphql object field names.
+func NewSchema(tables []*Table) (schema graphql.Schema, err error) {
+	var (
+		exposed   []string
+		usedNames = map[string]bool{}
+		queries   = graphql.Fields{}
+		mutations = graphql.Fields{}
+	)
+
+	for _, t := range tables {
+		typeName := pascalName(t.Name)
+		if !nameRegex.MatchString(t.Name) || strings.HasPrefix(t.Name, "__") || typeName == "" ||
+			reservedTypeNames[typeName] || usedNames[typeName] || usedNames[typeName+"Connection"] ||
+			usedNames[typeName+"Edge"] || usedNames[typeName+"Input"] {
+			continue
+		}
+
+		for _, name := range []string{typeName, typeName + "Connection", typeName + "Edge", typeName + "Input"} {
+			usedNames[name] = true
+		}
+
+		addTable(t, typeName, queries, mutations)
+		exposed = append(exposed, t.Name)
+	}
+
+	queries["tables"] = &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+		Description: "Names of tables exposed in schema.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return exposed, nil
+		},
+	}
+
+	cfg := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: queries,
+		}),
+	}
+
+	if len(mutations) > 0 {
+		cfg.Mutation = graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Mutation",
+			Fields: mutations,
+		})
+	}
+
+	schema, err = graphql.NewSchema(cfg)
+	if err != nil {
+		err = errors.Wrapf(err, "build graphql schema failed")
+	}
+
+	return
+}
+
+// Do executes the graphql request with executor.
+func Do(ctx context.Context, schema *graphql.Schema, executor Executor, query string,
+	variables map[string]interface{}, operationName string) *graphql.Result {
+	return graphql.Do(graphql.Params{
+		Schema:         *schema,
+		RequestString:  query,
+		RootObject:     map[string]interface{}{executorKey: executor},
+		VariableValues: variables,
+		OperationName:  operationName,
+		Context:        ctx,
+	})
+}
+
+func addTable(t *Table, typeName string, queries graphql.Fields, mutations graphql.Fields) {
+	var (
+		fields      = graphql.Fields{}
+		inputFields = graphql.InputObjectConfigFieldMap{}
+		pkType      graphql.Output
+	)
+
+	for i, col := range t.Columns {
+		if !nameRegex.MatchString(col) || strings.HasPrefix(col, "__") {
+			continue
+		}
+
+		var colType string
+		if i < len(t.Types) {
+			colType = t.Types[i]
+		}
+
+		st := scalarType(colType)
+		fields[col] = &graphql.Field{Type: st}
+		inputFields[col] = &graphql.InputObjectFieldConfig{Type: st}
+
+		if t.PrimaryKey != "" && strings.EqualFold(col, t.PrimaryKey) {
+			pkType = st
+		}
+	}
+
+	if len(fields) == 0 {
+		return
+	}
+
+	rowType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   typeName,
+		Fields: fields,
+	})
+	inputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   typeName + "Input",
+		Fields: inputFields,
+	})
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: typeName + "Edge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"node":   &graphql.Field{Type: graphql.NewNonNull(rowType)},
+		},
+	})
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: typeName + "Connection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType))),
+				Resolve: resolveEdges,
+			},
+			"nodes": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(rowType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*connection).page.Rows, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type:    graphql.NewNonNull(pageInfoType),
+				Resolve: resolvePageInfo,
+			},
+			"totalCount": &graphql.Field{
+				Type: graphql.NewNonNull(NumberScalar),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					conn := p.Source.(*connection)
+					return getExecutor(p).Count(conn.table, conn.filter)
+				},
+			},
+		},
+	})
+
+	table := t.Name
+
+	queries[table] = &graphql.Field{
+		Type: graphql.NewNonNull(connectionType),
+		Args: graphql.FieldConfigArgument{
+			"filter": &graphql.ArgumentConfig{Type: JSONScalar},
+			"order":  &graphql.ArgumentConfig{Type: JSONScalar},
+			"first":  &graphql.ArgumentConfig{Type: graphql.Int},
+			"after":  &graphql.ArgumentConfig{Type: graphql.String},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			conn := &connection{table: table}
+
+			var err error
+			if conn.filter, err = objectArg(p, "filter"); err != nil {
+				return nil, err
+			}
+			order, err := objectArg(p, "order")
+			if err != nil {
+				return nil, err
+			}
+
+			if after, ok := p.Args["after"].(string); ok && after != "" {
+				if conn.offset, err = decodeCursor(after); err != nil {
+					return nil, err
+				}
+				conn.offset++
+			}
+
+			var first *int64
+			if v, ok := p.Args["first"].(int); ok {
+				if v < 0 {
+					return nil, errors.New("first must not be negative")
+				}
+				limit := int64(v)
+				first = &limit
+			}
+
+			conn.page, err = getExecutor(p).Find(table, conn.filter, order, conn.offset, first)
+			if err != nil {
+				return nil, err
+			}
+
+			return conn, nil
+		},
+	}
+
+	if pkType != nil {
+		pk := t.PrimaryKey
+		queries[table+"_by_pk"] = &graphql.Field{
+			Type: rowType,
+			Args: graphql.FieldConfigArgument{
+				pk: &graphql.ArgumentConfig{Type: graphql.NewNonNull(pkType)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				first := int64(1)
+				page, err := getExecutor(p).Find(table, map[string]interface{}{pk: p.Args[pk]}, nil, 0, &first)
+				if err != nil || len(page.Rows) == 0 {
+					return nil, err
+				}
+				return page.Rows[0], nil
+			},
+		}
+	}
+
+	mutations["insert_"+table] = &graphql.Field{
+		Type: graphql.NewNonNull(mutationResultType),
+		Args: graphql.FieldConfigArgument{
+			"data": &graphql.ArgumentConfig{Type: graphql.NewNonNull(inputType)},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			data, _ := p.Args["data"].(map[string]interface{})
+			lastInsertID, affectedRows, err := getExecutor(p).Insert(table, data)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"affected_rows":  affectedRows,
+				"last_insert_id": lastInsertID,
+			}, nil
+		},
+	}
+
+	mutations["update_"+table] = &graphql.Field{
+		Type: graphql.NewNonNull(mutationResultType),
+		Args: graphql.FieldConfigArgument{
+			"filter": &graphql.ArgumentConfig{Type: JSONScalar},
+			"set":    &graphql.ArgumentConfig{Type: inputType},
+			"update": &graphql.ArgumentConfig{Type: JSONScalar, Description: "Update object with $ operators."},
+			"one":    &graphql.ArgumentConfig{Type: graphql.Boolean},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			filter, err := objectArg(p, "filter")
+			if err != nil {
+				return nil, err
+			}
+			update, err := objectArg(p, "update")
+			if err != nil {
+				return nil, err
+			}
+
+			// typed set object is merged to update object
+			if set, ok := p.Args["set"].(map[string]interface{}); ok && len(set) > 0 {
+				if update == nil {
+					update = set
+				} else {
+					update["$set"] = set
+				}
+			}
+
+			justOne, _ := p.Args["one"].(bool)
+			affectedRows, err := getExecutor(p).Update(table, filter, update, justOne)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"affected_rows": affectedRows,
+			}, nil
+		},
+	}
+
+	mutations["remove_"+table] = &graphql.Field{
+		Type: graphql.NewNonNull(mutationResultType),
+		Args: graphql.FieldConfigArgument{
+			"filter": &graphql.ArgumentConfig{Type: JSONScalar},
+			"one":    &graphql.ArgumentConfig{Type: graphql.Boolean},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			filter, err := objectArg(p, "filter")
+			if err != nil {
+				return nil, err
+			}
+
+			justOne, _ := p.Args["one"].(bool)
+			affectedRows, err := getExecutor(p).Remove(table, filter, justOne)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"affected_rows": affectedRows,
+			}, nil
+		},
+	}
+}
+
+func resolveEdges(p graphql.ResolveParams) (interface{}, error) {
+	conn := p.Source.(*connection)
+	edges := make([]map[string]interface{}, 0, len(conn.page.Rows))
+
+	for i, row := range conn.page.Rows {
+		edges = append(edges, map[string]interface{}{
+			"cursor": encodeCursor(conn.offset + int64(i)),
+			"node":   row,
+		})
+	}
+
+	return edges, nil
+}
+
+func resolvePageInfo(p graphql.ResolveParams) (interface{}, error) {
+	conn := p.Source.(*connection)
+	info := map[string]interface{}{
+		"hasNextPage":     conn.page.HasNext,
+		"hasPreviousPage": conn.offset > 0,
+	}
+
+	if n := int64(len(conn.page.Rows)); n > 0 {
+		info["startCursor"] = encodeCursor(conn.offset)
+		info["endCursor"] = encodeCursor(conn.offset + n - 1)
+	}
+
+	return info, nil
+}
+
+func getExecutor(p graphql.ResolveParams) Executor {
+	return p.Info.RootValue.(map[string]interface{})[executorKey].(Executor)
+}
+
+func objectArg(p graphql.ResolveParams, name string) (obj map[string]interface{}, err error) {
+	v, ok := p.Args[name]
+	if !ok || v == nil {
+		return
+	}
+
+	if obj, ok = v.(map[string]interface{}); !ok {
+		err = errors.Errorf("%s must be an object", name)
+	}
+
+	return
+}
+
+func scalarType(colType string) *graphql.Scalar {
+	switch strings.ToUpper(colType) {
+	case "TEXT", "BINARY":
+		return graphql.String
+	case "NUMBER":
+		return NumberScalar
+	default:
+		return JSONScalar
+	}
+}
+
+// pascalName converts snake case table name to graphql type name.
+func pascalName(name string) string {
+	var b strings.Builder
+
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]))
+		b.WriteString(part[1:])
+	}
+
+	return b.String()
+}
+
+func encodeCursor(offset int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatInt(offset, 10)))
+}
+
+func decodeCursor(cursor string) (offset int64, err error) {
+	raw, err := base64.StdEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(raw), cursorPrefix) {
+		offset, err = strconv.ParseInt(string(raw[len(cursorPrefix):]), 10, 64)
+	} else {
+		err = errors.New("malformed cursor")
+	}
+	if err == nil && offset < 0 {
+		err = errors.New("malformed cursor")
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "invalid cursor %s", cursor)
+	}
+	return
+}
+
+// Manager caches the generated graphql schemas of projects.
+type Manager struct {
+	schemas sync.Map // map[string]*cachedSchema
+}
+
+type cachedSchema struct {
+	fingerprint string
+	schema      *graphql.Schema
+}
+
+// NewManager returns new graphql schema manager.
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Get returns the cached schema of project, the schema is re-generated if table definitions are changed.
+func (m *Manager) Get(project string, tables []*Table) (schema *graphql.Schema, err error) {
+	raw, err := json.Marshal(tables)
+	if err != nil {
+		err = errors.Wrapf(err, "encode table definitions failed")
+		return
+	}
+
+	h := sha256.Sum256(raw)
+	fingerprint := hex.EncodeToString(h[:])
+
+	if cached, ok := m.schemas.Load(project); ok && cached.(*cachedSchema).fingerprint == fingerprint {
+		schema = cached.(*cachedSchema).schema
+		return
+	}
+
+	s, err := NewSchema(tables)
+	if err != nil {
+		return
+	}
+
+	schema = &s
+	m.schemas.Store(project, &cachedSchema{
+		fingerprint: fingerprint,
+		schema:      schema,
+	})
+
+	return
+}
@@ -0,0 +1,34 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hook
+
+import "errors"
+
+var (
+	// ErrUnsupportedLanguage defines error on hook function written in unsupported language.
+	ErrUnsupportedLanguage = errors.New("unsupported hook function language")
+	// ErrUnsupportedEvent defines error on hook function subscribing unknown data event.
+	ErrUnsupportedEvent = errors.New("unsupported hook event")
+	// ErrHandlerNotFound defines error on hook function source without handler function.
+	ErrHandlerNotFound = errors.New("handler function not found")
+	// ErrTimeout defines error on hook function execution exceeding timeout.
+	ErrTimeout = errors.New("hook function execution timeout")
+	// ErrTooManyWrites defines error on hook function queueing too many fan-out writes.
+	ErrTooManyWrites = errors.New("too many writes in hook function")
+	// ErrInvalidWrite defines error on hook function queueing malformed fan-out write.
+	ErrInvalidWrite = errors.New("invalid write in hook function")
+)
@@ -0,0 +1,362 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hook
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Event defines the data event type triggering hook functions.
+type Event string
+
+const (
+	// EventBeforeInsert triggers before data insert, the inserting data could be validated or transformed.
+	EventBeforeInsert Event = "before_insert"
+	// EventAfterInsert triggers after data insert.
+	EventAfterInsert Event = "after_insert"
+	// EventBeforeUpdate triggers before data update, the update object could be validated or transformed.
+	EventBeforeUpdate Event = "before_update"
+	// EventAfterUpdate triggers after data update.
+	EventAfterUpdate Event = "after_update"
+	// EventBeforeRemove triggers before data remove, the remove could be rejected.
+	EventBeforeRemove Event = "before_remove"
+	// EventAfterRemove triggers after data remove.
+	EventAfterRemove Event = "after_remove"
+)
+
+const (
+	// LanguageJavaScript defines the hook function written in javascript (ECMAScript 5.1).
+	LanguageJavaScript = "javascript"
+	// LanguageLua defines the hook function written in lua 5.1.
+	LanguageLua = "lua"
+
+	// handlerName defines the entry function name of hook function source.
+	handlerName = "handler"
+
+	// DefaultTimeout defines the default execution timeout of hook function.
+	DefaultTimeout = time.Second
+	// MaxTimeout defines the max execution timeout of hook function.
+	MaxTimeout = 10 * time.Second
+	// MaxWrites defines the max fan-out writes queued in one event.
+	MaxWrites = 100
+)
+
+// IsBefore returns if the event triggers before data write.
+func (e Event) IsBefore() bool {
+	return strings.HasPrefix(string(e), "before_")
+}
+
+func (e Event) valid() bool {
+	switch e {
+	case EventBeforeInsert, EventAfterInsert, EventBeforeUpdate, EventAfterUpdate,
+		EventBeforeRemove, EventAfterRemove:
+		return true
+	default:
+		return false
+	}
+}
+
+// Function defines the hook function executed on data events of table.
+//
+// The function source must define a global function named "handler" receiving the event object:
+//
+//	{event: "before_insert", table: "t", data: {...}, filter: {...}, result: {...}, vars: {...}}
+//
+// For before events, the modified event.data or the data field of returned object is used as the new
+// insert/update data. Following global functions are available in sandbox:
+//
+//	reject(message)               rejects the data write with message, only available in before events
+//	insert(table, data)           queues insert to another table, executed after the data write
+//	update(table, filter, update) queues update to another table, executed after the data write
+//	remove(table, filter)         queues remove to another table, executed after the data write
+//	log(...)                      writes log of proxy
+type Function struct {
+	Name     string
+	Language string
+	Events   []Event
+	Source   string
+	Timeout  time.Duration
+}
+
+// Request defines the event object passed to hook function.
+type Request struct {
+	Event  Event
+	Table  string
+	Data   map[string]interface{} // insert data or update object
+	Filter map[string]interface{} // update/remove filter
+	Result map[string]interface{} // data write result, only available in after events
+	Vars   map[string]interface{} // magic variables of current user
+}
+
+// Response defines the execution result of hook functions.
+type Response struct {
+	Data   map[string]interface{}
+	Writes []*Write
+}
+
+// Write defines the fan-out write queued by hook function.
+type Write struct {
+	Op     string                 `json:"op"` // insert/update/remove
+	Table  string                 `json:"table"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Filter map[string]interface{} `json:"filter,omitempty"`
+}
+
+// RejectError defines the data write rejected by hook function.
+type RejectError struct {
+	Function string `json:"function"`
+	Message  string `json:"message"`
+}
+
+// Error implements error interface.
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected by hook function %s: %s", e.Function, e.Message)
+}
+
+// program defines the compiled hook function of specific language.
+type program interface {
+	call(req *Request, ctx *callContext) (ret interface{}, err error)
+}
+
+// callContext collects the side effects of hook function call.
+type callContext struct {
+	fn       *Function
+	rejected *RejectError
+	writes   []*Write
+}
+
+func (ctx *callContext) reject(message string) {
+	ctx.rejected = &RejectError{
+		Function: ctx.fn.Name,
+		Message:  message,
+	}
+}
+
+func (ctx *callContext) write(op string, table string, data interface{}, filter interface{}) (err error) {
+	if len(ctx.writes) >= MaxWrites {
+		return ErrTooManyWrites
+	}
+
+	w := &Write{
+		Op:    op,
+		Table: table,
+	}
+
+	var ok bool
+
+	if data != nil {
+		if w.Data, ok = data.(map[string]interface{}); !ok {
+			return errors.Wrapf(ErrInvalidWrite, "%s data must be an object", op)
+		}
+	}
+	if filter != nil {
+		if w.Filter, ok = filter.(map[string]interface{}); !ok {
+			return errors.Wrapf(ErrInvalidWrite, "%s filter must be an object", op)
+		}
+	}
+	if table == "" {
+		return errors.Wrapf(ErrInvalidWrite, "%s table is required", op)
+	}
+
+	ctx.writes = append(ctx.writes, w)
+
+	return
+}
+
+func (ctx *callContext) log(args []interface{}) {
+	log.WithFields(log.Fields{
+		"function": ctx.fn.Name,
+	}).Info(fmt.Sprint(args...))
+}
+
+type compiledFunction struct {
+	*Function
+	program program
+}
+
+// Hooks defines the compiled hook functions of table.
+type Hooks struct {
+	functions []*compiledFunction
+}
+
+// Compile validates and compiles the hook functions.
+func Compile(functions []*Function) (h *Hooks, err error) {
+	h = &Hooks{}
+
+	for _, f := range functions {
+		for _, e := range f.Events {
+			if !e.valid() {
+				err = errors.Wrapf(ErrUnsupportedEvent, "event %s of function %s", e, f.Name)
+				return
+			}
+		}
+
+		if f.Timeout <= 0 {
+			f.Timeout = DefaultTimeout
+		} else if f.Timeout > MaxTimeout {
+			f.Timeout = MaxTimeout
+		}
+
+		cf := &compiledFunction{Function: f}
+
+		switch f.Language {
+		case LanguageJavaScript:
+			cf.program, err = compileJavaScript(f)
+		case LanguageLua:
+			cf.program, err = compileLua(f)
+		default:
+			err = errors.Wrapf(ErrUnsupportedLanguage, "language %s of function %s", f.Language, f.Name)
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "compile function %s failed", f.Name)
+			return
+		}
+
+		h.functions = append(h.functions, cf)
+	}
+
+	return
+}
+
+// Has checks if any function subscribes the event.
+func (h *Hooks) Has(event Event) bool {
+	if h == nil {
+		return false
+	}
+
+	for _, f := range h.functions {
+		for _, e := range f.Events {
+			if e == event {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Run executes the functions subscribing the event in order, the data transformed by previous function is
+// passed to the next one.
+func (h *Hooks) Run(req *Request) (resp *Response, err error) {
+	resp = &Response{
+		Data: req.Data,
+	}
+
+	if !h.Has(req.Event) {
+		return
+	}
+
+	for _, f := range h.functions {
+		if !f.subscribes(req.Event) {
+			continue
+		}
+
+		ctx := &callContext{fn: f.Function}
+		fnReq := *req
+		fnReq.Data = resp.Data
+
+		var ret interface{}
+		ret, err = f.program.call(&fnReq, ctx)
+		if ctx.rejected != nil {
+			err = ctx.rejected
+			return
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "execute function %s failed", f.Name)
+			return
+		}
+
+		resp.Writes = append(resp.Writes, ctx.writes...)
+
+		if !req.Event.IsBefore() || req.Data == nil {
+			continue
+		}
+
+		if retObj, ok := ret.(map[string]interface{}); ok {
+			if data, ok := retObj["data"].(map[string]interface{}); ok {
+				resp.Data = data
+				continue
+			}
+		}
+
+		// event.data modified in place
+		resp.Data = fnReq.Data
+	}
+
+	if len(resp.Writes) > MaxWrites {
+		err = ErrTooManyWrites
+	}
+
+	return
+}
+
+func (f *compiledFunction) subscribes(event Event) bool {
+	for _, e := range f.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Manager caches the compiled hook functions of project tables.
+type Manager struct {
+	hooks sync.Map // map[string]*cachedHooks
+}
+
+type cachedHooks struct {
+	version int64
+	hooks   *Hooks
+}
+
+// NewManager returns new hook functions manager.
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Get returns the cached hooks of project table, the hooks are re-compiled if config version is changed.
+func (m *Manager) Get(project string, table string, version int64, functions []*Function) (h *Hooks, err error) {
+	key := project + "/" + table
+
+	if cached, ok := m.hooks.Load(key); ok && cached.(*cachedHooks).version == version {
+		h = cached.(*cachedHooks).hooks
+		return
+	}
+
+	if h, err = Compile(functions); err != nil {
+		return
+	}
+
+	m.hooks.Store(key, &cachedHooks{
+		version: version,
+		hooks:   h,
+	})
+
+	return
+}
+
+// Remove removes the cached hooks of project table.
+func (m *Manager) Remove(project string, table string) {
+	m.hooks.Delete(project + "/" + table)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hook
+
+import (
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+)
+
+// jsProgram runs hook function in javascript sandbox, no module loading or io api is available.
+type jsProgram struct {
+	fn      *Function
+	program *goja.Program
+}
+
+func compileJavaScript(f *Function) (p program, err error) {
+	prog, err := goja.Compile(f.Name, f.Source, false)
+	if err != nil {
+		return
+	}
+
+	p = &jsProgram{
+		fn:      f,
+		program: prog,
+	}
+
+	return
+}
+
+func (p *jsProgram) call(req *Request, ctx *callContext) (ret interface{}, err error) {
+	vm := goja.New()
+
+	timer := time.AfterFunc(p.fn.Timeout, func() {
+		vm.Interrupt(ErrTimeout)
+	})
+	defer timer.Stop()
+
+	// interrupt the execution on rejection or invalid write, so it could not be caught by script
+	vm.Set("reject", func(call goja.FunctionCall) goja.Value {
+		ctx.reject(call.Argument(0).String())
+		vm.Interrupt(ctx.rejected)
+		return goja.Undefined()
+	})
+	vm.Set("insert", func(call goja.FunctionCall) goja.Value {
+		if wErr := ctx.write("insert", call.Argument(0).String(), call.Argument(1).Export(), nil); wErr != nil {
+			vm.Interrupt(wErr)
+		}
+		return goja.Undefined()
+	})
+	vm.Set("update", func(call goja.FunctionCall) goja.Value {
+		if wErr := ctx.write("update", call.Argument(0).String(),
+			call.Argument(2).Export(), call.Argument(1).Export()); wErr != nil {
+			vm.Interrupt(wErr)
+		}
+		return goja.Undefined()
+	})
+	vm.Set("remove", func(call goja.FunctionCall) goja.Value {
+		if wErr := ctx.write("remove", call.Argument(0).String(), nil, call.Argument(1).Export()); wErr != nil {
+			vm.Interrupt(wErr)
+		}
+		return goja.Undefined()
+	})
+	vm.Set("log", func(call goja.FunctionCall) goja.Value {
+		args := make([]interface{}, 0, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			if i > 0 {
+				args = append(args, " ")
+			}
+			args = append(args, arg.String())
+		}
+		ctx.log(args)
+		return goja.Undefined()
+	})
+
+	if _, err = vm.RunProgram(p.program); err != nil {
+		err = jsError(err)
+		return
+	}
+
+	handler, ok := goja.AssertFunction(vm.Get(handlerName))
+	if !ok {
+		err = ErrHandlerNotFound
+		return
+	}
+
+	event := vm.NewObject()
+	_ = event.Set("event", string(req.Event))
+	_ = event.Set("table", req.Table)
+	_ = event.Set("data", jsObject(req.Data))
+	_ = event.Set("filter", jsObject(req.Filter))
+	_ = event.Set("result", jsObject(req.Result))
+	_ = event.Set("vars", jsObject(req.Vars))
+
+	v, err := handler(goja.Undefined(), event)
+	if err != nil {
+		err = jsError(err)
+		return
+	}
+
+	// event.data may be replaced by script
+	if data, ok := event.Get("data").Export().(map[string]interface{}); ok {
+		req.Data = data
+	}
+
+	if v != nil {
+		ret = v.Export()
+	}
+
+	return
+}
+
+func jsObject(m map[string]interface{}) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m
+}
+
+func jsError(err error) error {
+	if iErr, ok := err.(*goja.InterruptedError); ok {
+		if vErr, ok := iErr.Value().(error); ok {
+			return vErr
+		}
+	}
+	return errors.Wrapf(err, "javascript error")
+}
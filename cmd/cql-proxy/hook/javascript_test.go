@@ -0,0 +1,114 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func runFunction(language string, source string, req *Request) (resp *Response, err error) {
+	h, err := Compile([]*Function{{
+		Name:     "test",
+		Language: language,
+		Events:   []Event{req.Event},
+		Source:   source,
+		Timeout:  100 * time.Millisecond,
+	}})
+	if err != nil {
+		return
+	}
+	return h.Run(req)
+}
+
+func TestJavaScriptFunction(t *testing.T) {
+	Convey("transform data in before event", t, func() {
+		resp, err := runFunction(LanguageJavaScript, `
+function handler(e) {
+	e.data.name = e.data.name.toUpperCase();
+	insert("audit", {table: e.table});
+}`, &Request{
+			Event: EventBeforeInsert,
+			Table: "users",
+			Data:  map[string]interface{}{"name": "alice"},
+		})
+		So(err, ShouldBeNil)
+		So(resp.Data["name"], ShouldEqual, "ALICE")
+		So(resp.Writes, ShouldHaveLength, 1)
+		So(resp.Writes[0].Table, ShouldEqual, "audit")
+	})
+
+	Convey("timeout interrupts infinite loop", t, func() {
+		start := time.Now()
+		_, err := runFunction(LanguageJavaScript, `function handler(e) { while(true){} }`, &Request{
+			Event: EventBeforeInsert,
+			Data:  map[string]interface{}{},
+		})
+		So(errors.Cause(err), ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		// the interruption could not be caught by script
+		start = time.Now()
+		_, err = runFunction(LanguageJavaScript, `
+function handler(e) {
+	for (;;) {
+		try { while(true){} } catch (ex) {}
+	}
+}`, &Request{
+			Event: EventBeforeInsert,
+			Data:  map[string]interface{}{},
+		})
+		So(errors.Cause(err), ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+
+	Convey("no module loading or io api is available", t, func() {
+		resp, err := runFunction(LanguageJavaScript, `
+function handler(e) {
+	e.data.require = typeof require;
+	e.data.process = typeof process;
+	e.data.console = typeof console;
+}`, &Request{
+			Event: EventBeforeInsert,
+			Data:  map[string]interface{}{},
+		})
+		So(err, ShouldBeNil)
+		So(resp.Data, ShouldResemble, map[string]interface{}{
+			"require": "undefined",
+			"process": "undefined",
+			"console": "undefined",
+		})
+	})
+
+	Convey("reject aborts the write", t, func() {
+		for _, source := range []string{
+			`function handler(e) { insert("audit", {}); reject("no way"); e.data.name = "changed"; }`,
+			`function handler(e) { try { reject("no way"); } catch (ex) {} e.data.name = "changed"; }`,
+		} {
+			resp, err := runFunction(LanguageJavaScript, source, &Request{
+				Event: EventBeforeInsert,
+				Data:  map[string]interface{}{"name": "alice"},
+			})
+			So(err, ShouldResemble, &RejectError{Function: "test", Message: "no way"})
+			So(resp.Data["name"], ShouldEqual, "alice")
+			So(resp.Writes, ShouldBeEmpty)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// luaUnsafeFunctions defines the base library functions removed from lua sandbox.
+var luaUnsafeFunctions = []string{"dofile", "loadfile", "load", "loadstring", "require", "module"}
+
+// luaProgram runs hook function in lua sandbox, only base/table/string/math libraries are available.
+type luaProgram struct {
+	fn    *Function
+	proto *lua.FunctionProto
+}
+
+func compileLua(f *Function) (p program, err error) {
+	chunk, err := parse.Parse(strings.NewReader(f.Source), f.Name)
+	if err != nil {
+		return
+	}
+
+	proto, err := lua.Compile(chunk, f.Name)
+	if err != nil {
+		return
+	}
+
+	p = &luaProgram{
+		fn:    f,
+		proto: proto,
+	}
+
+	return
+}
+
+func (p *luaProgram) call(req *Request, ctx *callContext) (ret interface{}, err error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		if err = L.CallByParam(lua.P{
+			Fn:      L.NewFunction(lib.open),
+			NRet:    0,
+			Protect: true,
+		}, lua.LString(lib.name)); err != nil {
+			return
+		}
+	}
+
+	for _, name := range luaUnsafeFunctions {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), p.fn.Timeout)
+	defer cancel()
+	L.SetContext(timeoutCtx)
+
+	L.SetGlobal("reject", L.NewFunction(func(L *lua.LState) int {
+		ctx.reject(L.ToString(1))
+		L.RaiseError("rejected")
+		return 0
+	}))
+	L.SetGlobal("insert", L.NewFunction(func(L *lua.LState) int {
+		if wErr := ctx.write("insert", L.ToString(1), fromLua(L.Get(2)), nil); wErr != nil {
+			L.RaiseError("%v", wErr)
+		}
+		return 0
+	}))
+	L.SetGlobal("update", L.NewFunction(func(L *lua.LState) int {
+		if wErr := ctx.write("update", L.ToString(1), fromLua(L.Get(3)), fromLua(L.Get(2))); wErr != nil {
+			L.RaiseError("%v", wErr)
+		}
+		return 0
+	}))
+	L.SetGlobal("remove", L.NewFunction(func(L *lua.LState) int {
+		if wErr := ctx.write("remove", L.ToString(1), nil, fromLua(L.Get(2))); wErr != nil {
+			L.RaiseError("%v", wErr)
+		}
+		return 0
+	}))
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		args := make([]interface{}, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			if i > 1 {
+				args = append(args, " ")
+			}
+			args = append(args, L.ToStringMeta(L.Get(i)).String())
+		}
+		ctx.log(args)
+		return 0
+	}))
+
+	defer func() {
+		if err != nil && timeoutCtx.Err() == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
+	}()
+
+	if err = L.CallByParam(lua.P{
+		Fn:      L.NewFunctionFromProto(p.proto),
+		NRet:    0,
+		Protect: true,
+	}); err != nil {
+		err = errors.Wrapf(err, "lua error")
+		return
+	}
+
+	handler, ok := L.GetGlobal(handlerName).(*lua.LFunction)
+	if !ok {
+		err = ErrHandlerNotFound
+		return
+	}
+
+	event := L.NewTable()
+	event.RawSetString("event", lua.LString(req.Event))
+	event.RawSetString("table", lua.LString(req.Table))
+	event.RawSetString("data", toLua(L, req.Data))
+	event.RawSetString("filter", toLua(L, req.Filter))
+	event.RawSetString("result", toLua(L, req.Result))
+	event.RawSetString("vars", toLua(L, req.Vars))
+
+	if err = L.CallByParam(lua.P{
+		Fn:      handler,
+		NRet:    1,
+		Protect: true,
+	}, event); err != nil {
+		err = errors.Wrapf(err, "lua error")
+		return
+	}
+
+	ret = fromLua(L.Get(-1))
+	L.Pop(1)
+
+	// event.data may be modified in place
+	if data, ok := fromLua(event.RawGetString("data")).(map[string]interface{}); ok {
+		req.Data = data
+	}
+
+	return
+}
+
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case string:
+		return lua.LString(v)
+	case []byte:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case int8:
+		return lua.LNumber(v)
+	case int16:
+		return lua.LNumber(v)
+	case int32:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint:
+		return lua.LNumber(v)
+	case uint8:
+		return lua.LNumber(v)
+	case uint16:
+		return lua.LNumber(v)
+	case uint32:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case float32:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case map[string]interface{}:
+		if v == nil {
+			return lua.LNil
+		}
+		t := L.CreateTable(0, len(v))
+		for k, item := range v {
+			t.RawSetString(k, toLua(L, item))
+		}
+		return t
+	case []interface{}:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+func fromLua(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return float64(v)
+	case *lua.LTable:
+		// table with sequence keys only is converted to array
+		if n := v.MaxN(); n > 0 {
+			isArray := true
+			v.ForEach(func(k lua.LValue, _ lua.LValue) {
+				if _, ok := k.(lua.LNumber); !ok {
+					isArray = false
+				}
+			})
+			if isArray {
+				arr := make([]interface{}, 0, n)
+				for i := 1; i <= n; i++ {
+					arr = append(arr, fromLua(v.RawGetInt(i)))
+				}
+				return arr
+			}
+		}
+
+		m := map[string]interface{}{}
+		v.ForEach(func(k lua.LValue, item lua.LValue) {
+			m[k.String()] = fromLua(item)
+		})
+		return m
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLuaFunction(t *testing.T) {
+	Convey("transform data in before event", t, func() {
+		resp, err := runFunction(LanguageLua, `
+function handler(e)
+	e.data.name = string.upper(e.data.name)
+	insert("audit", {table = e.table})
+end`, &Request{
+			Event: EventBeforeInsert,
+			Table: "users",
+			Data:  map[string]interface{}{"name": "alice"},
+		})
+		So(err, ShouldBeNil)
+		So(resp.Data["name"], ShouldEqual, "ALICE")
+		So(resp.Writes, ShouldHaveLength, 1)
+		So(resp.Writes[0].Table, ShouldEqual, "audit")
+	})
+
+	Convey("timeout interrupts infinite loop", t, func() {
+		start := time.Now()
+		_, err := runFunction(LanguageLua, `function handler(e) while true do end end`, &Request{
+			Event: EventBeforeInsert,
+			Data:  map[string]interface{}{},
+		})
+		So(errors.Cause(err), ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		// the timeout could not be swallowed by pcall
+		start = time.Now()
+		_, err = runFunction(LanguageLua, `
+function handler(e)
+	while true do
+		pcall(function() while true do end end)
+	end
+end`, &Request{
+			Event: EventBeforeInsert,
+			Data:  map[string]interface{}{},
+		})
+		So(errors.Cause(err), ShouldEqual, ErrTimeout)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+
+	Convey("unsafe functions are unavailable", t, func() {
+		resp, err := runFunction(LanguageLua, `
+function handler(e)
+	for _, name in ipairs({"dofile", "loadfile", "load", "loadstring", "require", "module", "os", "io", "debug", "package"}) do
+		e.data[name] = type(_G[name])
+	end
+end`, &Request{
+			Event: EventBeforeInsert,
+			Data:  map[string]interface{}{},
+		})
+		So(err, ShouldBeNil)
+		for _, name := range append(luaUnsafeFunctions, "os", "io", "debug", "package") {
+			So(resp.Data[name], ShouldEqual, "nil")
+		}
+
+		for _, source := range []string{
+			`function handler(e) load("return 1") end`,
+			`function handler(e) dofile("/etc/passwd") end`,
+			`function handler(e) require("os") end`,
+		} {
+			_, err = runFunction(LanguageLua, source, &Request{
+				Event: EventBeforeInsert,
+				Data:  map[string]interface{}{},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "attempt to call a non-function object")
+		}
+	})
+
+	Convey("reject aborts the write", t, func() {
+		for _, source := range []string{
+			`function handler(e) insert("audit", {}) reject("no way") e.data.name = "changed" end`,
+			`function handler(e) pcall(reject, "no way") e.data.name = "changed" end`,
+		} {
+			resp, err := runFunction(LanguageLua, source, &Request{
+				Event: EventBeforeInsert,
+				Data:  map[string]interface{}{"name": "alice"},
+			})
+			So(err, ShouldResemble, &RejectError{Function: "test", Message: "no way"})
+			So(resp.Data["name"], ShouldEqual, "alice")
+			So(resp.Writes, ShouldBeEmpty)
+		}
+	})
+}
@@ -17,19 +17,30 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	gorp "gopkg.in/gorp.v2"
 
+	"github.com/CovenantSQL/CovenantSQL/client"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/api"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/auth"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/config"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/gql"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/hook"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/realtime"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/resolver"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/storage"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/task"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const (
+	rateLimiterPurgeInterval = time.Hour
 )
 
 func initServer(cfg *config.Config) (server *http.Server, afterShutdown func(), err error) {
@@ -55,7 +66,22 @@ func initServer(cfg *config.Config) (server *http.Server, afterShutdown func(),
 	tm := initTaskManager(e, cfg, db)
 
 	// init rules manager
-	initRulesManager(e)
+	rm := initRulesManager(e)
+
+	// init jwt verifier manager
+	initJWTManager(e)
+
+	// init data hook functions manager
+	initHookManager(e)
+
+	// init graphql schema manager
+	initGraphQLManager(e)
+
+	// init realtime change feed hub
+	hub := initRealtimeHub(e)
+
+	// init rules rate limiter
+	stopLimiter := initRateLimiter(e, rm)
 
 	api.AddRoutes(e)
 
@@ -66,6 +92,8 @@ func initServer(cfg *config.Config) (server *http.Server, afterShutdown func(),
 
 	afterShutdown = func() {
 		tm.Stop()
+		stopLimiter()
+		hub.Close()
 	}
 
 	return
@@ -74,7 +102,7 @@ func initServer(cfg *config.Config) (server *http.Server, afterShutdown func(),
 func initCors(e *gin.Engine) {
 	corsCfg := cors.DefaultConfig()
 	corsCfg.AllowAllOrigins = true
-	corsCfg.AddAllowHeaders("X-CQL-Token")
+	corsCfg.AddAllowHeaders("X-CQL-Token", "Authorization")
 	e.Use(cors.New(corsCfg))
 }
 
@@ -140,6 +168,94 @@ func initRulesManager(e *gin.Engine) (rm *resolver.RulesManager) {
 	return
 }
 
+func initGraphQLManager(e *gin.Engine) (gm *gql.Manager) {
+	gm = gql.NewManager()
+
+	e.Use(func(c *gin.Context) {
+		c.Set("graphql", gm)
+		c.Next()
+	})
+
+	return
+}
+
+func initRealtimeHub(e *gin.Engine) (hub *realtime.Hub) {
+	hub = realtime.NewHub(func(ctx context.Context, dbID proto.DatabaseID, tables []string) (
+		<-chan *client.ChangeEvent, error) {
+		cfg := client.NewConfig()
+		cfg.DatabaseID = string(dbID)
+		return client.Subscribe(ctx, cfg.FormatDSN(), tables...)
+	})
+
+	e.Use(func(c *gin.Context) {
+		c.Set("realtime", hub)
+		c.Next()
+	})
+
+	return
+}
+
+func initHookManager(e *gin.Engine) (hm *hook.Manager) {
+	hm = hook.NewManager()
+
+	e.Use(func(c *gin.Context) {
+		c.Set("hook", hm)
+		c.Next()
+	})
+
+	return
+}
+
+func initJWTManager(e *gin.Engine) (jm *auth.JWTManager) {
+	jm = auth.NewJWTManager()
+
+	e.Use(func(c *gin.Context) {
+		c.Set("jwt", jm)
+		c.Next()
+	})
+
+	return
+}
+
+func initRateLimiter(e *gin.Engine, rm *resolver.RulesManager) (stop func()) {
+	limiter := resolver.NewRateLimiter()
+	stopCh := make(chan struct{})
+
+	// buckets are re-created with new limits on rules update
+	updates, cancel := rm.Watch()
+
+	go func() {
+		ticker := time.NewTicker(rateLimiterPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case u, ok := <-updates:
+				if !ok {
+					return
+				}
+				limiter.Reset(u.DatabaseID)
+			case <-ticker.C:
+				limiter.Purge(rateLimiterPurgeInterval)
+			}
+		}
+	}()
+
+	e.Use(func(c *gin.Context) {
+		c.Set("limiter", limiter)
+		c.Next()
+	})
+
+	stop = func() {
+		cancel()
+		close(stopCh)
+	}
+
+	return
+}
+
 func initConfig(e *gin.Engine, cfg *config.Config) {
 	e.Use(func(c *gin.Context) {
 		c.Set("config", cfg)
@@ -27,7 +27,9 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/client"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/config"
+	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
@@ -74,6 +76,14 @@ func main() {
 		return
 	}
 
+	// init distributed tracing
+	shutdownTracing, err := rpc.SetupTracing(conf.GConf.Tracing, conf.GConf.ThisNodeID)
+	if err != nil {
+		log.WithError(err).Error("setup tracing failed")
+		os.Exit(-1)
+		return
+	}
+
 	// load proxy config from same config file
 	var cfg *config.Config
 
@@ -108,5 +118,6 @@ func main() {
 
 	_ = server.Shutdown(ctx)
 	afterShutdown()
+	_ = shutdownTracing(ctx)
 	log.Info("stopped proxy")
 }
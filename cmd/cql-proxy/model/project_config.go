@@ -37,6 +37,12 @@ const (
 	ProjectConfigTable
 	// ProjectConfigGroup defines the rules user group info for project.
 	ProjectConfigGroup
+	// ProjectConfigJWT defines the external identity provider jwt auth config of project.
+	ProjectConfigJWT
+	// ProjectConfigFiles defines the file storage config of project.
+	ProjectConfigFiles
+	// ProjectConfigHook defines the serverless hook functions config of project table.
+	ProjectConfigHook
 )
 
 // String implements Stringer interface to ProjectConfigType enum stringify.
@@ -50,6 +56,12 @@ func (c ProjectConfigType) String() string {
 		return "Table"
 	case ProjectConfigGroup:
 		return "Group"
+	case ProjectConfigJWT:
+		return "JWT"
+	case ProjectConfigFiles:
+		return "Files"
+	case ProjectConfigHook:
+		return "Hook"
 	default:
 		return "Unknown"
 	}
@@ -95,6 +107,8 @@ type ProjectOAuthConfig struct {
 	ClientID     string `json:"client_id" form:"client_id"`
 	ClientSecret string `json:"client_secret" form:"client_secret"`
 	Enabled      *bool  `json:"enabled,omitempty" form:"enabled"`
+	// provider specific config items, e.g. team_id/key_id of apple.
+	Extra map[string]string `json:"extra,omitempty" form:"extra"`
 }
 
 // IsEnabled checks if project oauth provider is enabled.
@@ -115,7 +129,80 @@ type ProjectTableConfig struct {
 
 // ProjectGroupConfig defines the group config object.
 type ProjectGroupConfig struct {
-	Groups map[string][]int64 `json:"groups" binding:"omitempty,dive,keys,required,endkeys,dive,gt=0"`
+	Groups map[string][]int64  `json:"groups" binding:"omitempty,dive,keys,required,endkeys,dive,gt=0"`
+	States []string            `json:"states" binding:"omitempty,dive,required,max=64"`
+	Source *ProjectGroupSource `json:"source" binding:"omitempty"`
+	// external authorization service config
+	External *ProjectExternalAuth `json:"external" binding:"omitempty"`
+}
+
+// ProjectGroupSource defines the membership table config for dynamic groups.
+type ProjectGroupSource struct {
+	Table       string `json:"table" binding:"required,max=128"`
+	UserColumn  string `json:"user_column" binding:"required,max=128"`
+	GroupColumn string `json:"group_column" binding:"required,max=128"`
+	TTL         string `json:"ttl"`
+}
+
+// ProjectExternalAuth defines the external authorization service config.
+type ProjectExternalAuth struct {
+	URL      string            `json:"url" binding:"required,url"`
+	Timeout  string            `json:"timeout"`
+	Headers  map[string]string `json:"headers"`
+	FailOpen bool              `json:"fail_open"`
+}
+
+// ProjectJWTConfig defines the jwt auth config object for tokens issued by external identity provider.
+type ProjectJWTConfig struct {
+	Enabled         *bool         `json:"enabled,omitempty" form:"enabled"`
+	Issuer          string        `json:"issuer" form:"issuer"`
+	Audience        string        `json:"audience" form:"audience"`
+	JWKSURL         string        `json:"jwks_url" form:"jwks_url" binding:"omitempty,url"`
+	Secret          string        `json:"secret" form:"secret"`
+	Algorithms      []string      `json:"algorithms" form:"algorithms" binding:"omitempty,dive,oneof=HS256 HS384 HS512 RS256 RS384 RS512 PS256 PS384 PS512 ES256 ES384 ES512"`
+	UIDClaim        string        `json:"uid_claim" form:"uid_claim"`
+	NameClaim       string        `json:"name_claim" form:"name_claim"`
+	EmailClaim      string        `json:"email_claim" form:"email_claim"`
+	StateClaim      string        `json:"state_claim" form:"state_claim"`
+	GroupsClaim     string        `json:"groups_claim" form:"groups_claim"`
+	Leeway          time.Duration `json:"leeway" form:"leeway"`
+	RefreshInterval time.Duration `json:"refresh_interval" form:"refresh_interval"`
+}
+
+// IsEnabled checks if project jwt auth is enabled.
+func (c *ProjectJWTConfig) IsEnabled() bool {
+	return c != nil && c.Enabled != nil && *c.Enabled
+}
+
+// ProjectFilesConfig defines the file storage config object.
+type ProjectFilesConfig struct {
+	Enabled    *bool           `json:"enabled,omitempty" form:"enabled"`
+	MaxSize    int64           `json:"max_size" form:"max_size" binding:"omitempty,gt=0"`
+	ChunkSize  int64           `json:"chunk_size" form:"chunk_size" binding:"omitempty,gt=0,max=1048576"`
+	URLExpires time.Duration   `json:"url_expires" form:"url_expires" binding:"omitempty,gt=0"`
+	Rules      json.RawMessage `json:"rules"`
+	// key to sign download urls, generated on creation
+	SignKey string `json:"sign_key,omitempty" form:"-"`
+}
+
+// IsEnabled checks if project file storage is enabled.
+func (c *ProjectFilesConfig) IsEnabled() bool {
+	return c != nil && c.Enabled != nil && *c.Enabled
+}
+
+// ProjectHookConfig defines the hook functions config object of table.
+type ProjectHookConfig struct {
+	Functions []*ProjectHookFunction `json:"functions" binding:"omitempty,max=16,dive"`
+}
+
+// ProjectHookFunction defines the hook function triggered on data events of table.
+type ProjectHookFunction struct {
+	Name     string        `json:"name" binding:"required,max=64"`
+	Language string        `json:"language" binding:"required"`
+	Events   []string      `json:"events" binding:"required,min=1"`
+	Source   string        `json:"source" binding:"required,max=65536"`
+	Timeout  time.Duration `json:"timeout" binding:"omitempty,gt=0"`
+	Disabled bool          `json:"disabled"`
 }
 
 // GetAllProjectConfig returns all configs of a project.
@@ -136,6 +223,12 @@ func GetAllProjectConfig(db *gorp.DbMap) (p []*ProjectConfig, err error) {
 			pc.Value = &ProjectTableConfig{}
 		case ProjectConfigGroup:
 			pc.Value = &ProjectGroupConfig{}
+		case ProjectConfigJWT:
+			pc.Value = &ProjectJWTConfig{}
+		case ProjectConfigFiles:
+			pc.Value = &ProjectFilesConfig{}
+		case ProjectConfigHook:
+			pc.Value = &ProjectHookConfig{}
 		}
 
 		_ = json.Unmarshal(pc.RawValue, &pc.Value)
@@ -243,6 +336,63 @@ func GetProjectGroupConfig(db *gorp.DbMap) (p *ProjectConfig, gc *ProjectGroupCo
 	return
 }
 
+// GetProjectJWTConfig returns jwt auth config object of project.
+func GetProjectJWTConfig(db *gorp.DbMap) (p *ProjectConfig, jc *ProjectJWTConfig, err error) {
+	err = db.SelectOne(&p, `SELECT * FROM "____config" WHERE "type" = ? LIMIT 1`,
+		ProjectConfigJWT)
+	if err != nil {
+		err = errors.Wrapf(err, "get project jwt config failed")
+		return
+	}
+
+	err = json.Unmarshal(p.RawValue, &jc)
+	if err == nil {
+		p.Value = jc
+	} else {
+		err = errors.Wrapf(err, "resolve project jwt config failed")
+	}
+
+	return
+}
+
+// GetProjectFilesConfig returns file storage config object of project.
+func GetProjectFilesConfig(db *gorp.DbMap) (p *ProjectConfig, fc *ProjectFilesConfig, err error) {
+	err = db.SelectOne(&p, `SELECT * FROM "____config" WHERE "type" = ? LIMIT 1`,
+		ProjectConfigFiles)
+	if err != nil {
+		err = errors.Wrapf(err, "get project files config failed")
+		return
+	}
+
+	err = json.Unmarshal(p.RawValue, &fc)
+	if err == nil {
+		p.Value = fc
+	} else {
+		err = errors.Wrapf(err, "resolve project files config failed")
+	}
+
+	return
+}
+
+// GetProjectHookConfig returns hook functions config of specified table.
+func GetProjectHookConfig(db *gorp.DbMap, tableName string) (p *ProjectConfig, hc *ProjectHookConfig, err error) {
+	err = db.SelectOne(&p, `SELECT * FROM "____config" WHERE "type" = ? AND "key" = ? LIMIT 1`,
+		ProjectConfigHook, tableName)
+	if err != nil {
+		err = errors.Wrapf(err, "get project hook config failed")
+		return
+	}
+
+	err = json.Unmarshal(p.RawValue, &hc)
+	if err == nil {
+		p.Value = hc
+	} else {
+		err = errors.Wrapf(err, "resolve project hook config failed")
+	}
+
+	return
+}
+
 // AddProjectConfig adds new project config.
 func AddProjectConfig(db *gorp.DbMap, configType ProjectConfigType, configKey string, value interface{}) (p *ProjectConfig, err error) {
 	p = &ProjectConfig{
@@ -267,7 +417,7 @@ func AddProjectConfig(db *gorp.DbMap, configType ProjectConfigType, configKey st
 }
 
 // AddRawProjectConfig add constructed project config object to database.
-func AddRawProjectConfig(db *gorp.DbMap, p *ProjectConfig) (err error) {
+func AddRawProjectConfig(db gorp.SqlExecutor, p *ProjectConfig) (err error) {
 	p.RawValue, err = json.Marshal(p.Value)
 	if err != nil {
 		err = errors.Wrapf(err, "encode project config data failed")
@@ -285,7 +435,7 @@ func AddRawProjectConfig(db *gorp.DbMap, p *ProjectConfig) (err error) {
 }
 
 // UpdateProjectConfig updates existing project config.
-func UpdateProjectConfig(db *gorp.DbMap, p *ProjectConfig) (err error) {
+func UpdateProjectConfig(db gorp.SqlExecutor, p *ProjectConfig) (err error) {
 	p.RawValue, err = json.Marshal(p.Value)
 	if err != nil {
 		err = errors.Wrapf(err, "encode project config data failed")
@@ -301,3 +451,13 @@ func UpdateProjectConfig(db *gorp.DbMap, p *ProjectConfig) (err error) {
 
 	return
 }
+
+// DeleteProjectConfig removes existing project config.
+func DeleteProjectConfig(db *gorp.DbMap, p *ProjectConfig) (err error) {
+	_, err = db.Delete(p)
+	if err != nil {
+		err = errors.Wrapf(err, "delete project config failed")
+	}
+
+	return
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	gorp "gopkg.in/gorp.v2"
+)
+
+// ProjectFileState defines the upload state of project file.
+type ProjectFileState int16
+
+const (
+	// ProjectFileStateUploading represents file chunks are being uploaded.
+	ProjectFileStateUploading ProjectFileState = iota
+	// ProjectFileStateComplete represents all file chunks are uploaded and verified.
+	ProjectFileStateComplete
+)
+
+// String implements Stringer for file state stringify.
+func (s ProjectFileState) String() string {
+	switch s {
+	case ProjectFileStateUploading:
+		return "Uploading"
+	case ProjectFileStateComplete:
+		return "Complete"
+	default:
+		return "Unknown"
+	}
+}
+
+// ProjectFile defines the file metadata object stored in project database.
+type ProjectFile struct {
+	ID          string           `db:"id"`
+	Name        string           `db:"name"`
+	ContentType string           `db:"content_type"`
+	Size        int64            `db:"size"`
+	ChunkSize   int64            `db:"chunk_size"`
+	Checksum    string           `db:"checksum"` // hex encoded sha256 of file content
+	Owner       int64            `db:"owner"`    // project user id of uploader, 0 for anonymous user
+	State       ProjectFileState `db:"state"`
+	Created     int64            `db:"created"`
+	LastUpdated int64            `db:"last_updated"`
+}
+
+// ProjectFileChunk defines the file content chunk object stored in project database.
+type ProjectFileChunk struct {
+	ID     int64  `db:"id"`
+	FileID string `db:"file_id"`
+	Seq    int64  `db:"seq"`
+	Data   []byte `db:"data"`
+}
+
+// Chunks returns the chunk count of file.
+func (f *ProjectFile) Chunks() int64 {
+	if f.Size == 0 || f.ChunkSize == 0 {
+		return 0
+	}
+
+	return (f.Size + f.ChunkSize - 1) / f.ChunkSize
+}
+
+// ChunkLen returns the expected length of specified chunk.
+func (f *ProjectFile) ChunkLen(seq int64) int64 {
+	if seq < 0 || seq >= f.Chunks() {
+		return 0
+	}
+
+	if seq == f.Chunks()-1 {
+		return f.Size - seq*f.ChunkSize
+	}
+
+	return f.ChunkSize
+}
+
+// Info returns the public file info object.
+func (f *ProjectFile) Info() gin.H {
+	return gin.H{
+		"id":           f.ID,
+		"name":         f.Name,
+		"content_type": f.ContentType,
+		"size":         f.Size,
+		"chunk_size":   f.ChunkSize,
+		"chunks":       f.Chunks(),
+		"checksum":     f.Checksum,
+		"owner":        f.Owner,
+		"state":        f.State.String(),
+		"created":      f.Created,
+		"last_updated": f.LastUpdated,
+	}
+}
+
+// NewProjectFile adds new file metadata in uploading state.
+func NewProjectFile(db *gorp.DbMap, name string, contentType string, size int64, chunkSize int64, owner int64) (
+	f *ProjectFile, err error) {
+	now := time.Now().Unix()
+	f = &ProjectFile{
+		ID:          uuid.Must(uuid.NewV4()).String(),
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		ChunkSize:   chunkSize,
+		Owner:       owner,
+		State:       ProjectFileStateUploading,
+		Created:     now,
+		LastUpdated: now,
+	}
+
+	err = db.Insert(f)
+	if err != nil {
+		err = errors.Wrapf(err, "add file failed")
+	}
+
+	return
+}
+
+// GetProjectFile returns file metadata of specified file id.
+func GetProjectFile(db *gorp.DbMap, id string) (f *ProjectFile, err error) {
+	err = db.SelectOne(&f, `SELECT * FROM "____file" WHERE "id" = ? LIMIT 1`, id)
+	if err != nil {
+		err = errors.Wrapf(err, "get file failed")
+	}
+	return
+}
+
+// CompleteProjectFile marks the file upload as complete with content checksum.
+func CompleteProjectFile(db *gorp.DbMap, f *ProjectFile, checksum string) (err error) {
+	f.Checksum = checksum
+	f.State = ProjectFileStateComplete
+	f.LastUpdated = time.Now().Unix()
+
+	_, err = db.Update(f)
+	if err != nil {
+		err = errors.Wrapf(err, "update file state failed")
+	}
+
+	return
+}
+
+// DeleteProjectFile removes the file metadata and content chunks.
+func DeleteProjectFile(db *gorp.DbMap, id string) (err error) {
+	_, err = db.Exec(`DELETE FROM "____file_chunk" WHERE "file_id" = ?`, id)
+	if err != nil {
+		err = errors.Wrapf(err, "remove file chunks failed")
+		return
+	}
+
+	_, err = db.Exec(`DELETE FROM "____file" WHERE "id" = ?`, id)
+	if err != nil {
+		err = errors.Wrapf(err, "remove file failed")
+	}
+
+	return
+}
+
+// SaveProjectFileChunk saves the file content chunk, existing chunk with same sequence is replaced.
+func SaveProjectFileChunk(db *gorp.DbMap, fileID string, seq int64, data []byte) (err error) {
+	_, err = db.Exec(`INSERT OR REPLACE INTO "____file_chunk" ("file_id", "seq", "data") VALUES (?, ?, ?)`,
+		fileID, seq, data)
+	if err != nil {
+		err = errors.Wrapf(err, "save file chunk failed")
+	}
+
+	return
+}
+
+// GetProjectFileChunk returns the content of specified file chunk.
+func GetProjectFileChunk(db *gorp.DbMap, fileID string, seq int64) (data []byte, err error) {
+	var chunk *ProjectFileChunk
+
+	err = db.SelectOne(&chunk, `SELECT * FROM "____file_chunk" WHERE "file_id" = ? AND "seq" = ? LIMIT 1`,
+		fileID, seq)
+	if err != nil {
+		err = errors.Wrapf(err, "get file chunk %d failed", seq)
+		return
+	}
+
+	data = chunk.Data
+
+	return
+}
+
+// GetProjectFileChunkSizes returns the uploaded chunks length of file indexed by chunk sequence.
+func GetProjectFileChunkSizes(db *gorp.DbMap, fileID string) (sizes map[int64]int64, err error) {
+	var rows []struct {
+		Seq  int64 `db:"seq"`
+		Size int64 `db:"size"`
+	}
+
+	_, err = db.Select(&rows, `SELECT "seq", LENGTH("data") AS "size" FROM "____file_chunk" WHERE "file_id" = ?`,
+		fileID)
+	if err != nil {
+		err = errors.Wrapf(err, "get file chunks failed")
+		return
+	}
+
+	sizes = make(map[int64]int64, len(rows))
+	for _, r := range rows {
+		sizes[r.Seq] = r.Size
+	}
+
+	return
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	gorp "gopkg.in/gorp.v2"
+)
+
+// ProjectRulesVersion defines a recorded rules config version stored in project database.
+type ProjectRulesVersion struct {
+	ID      int64  `db:"id"` // version number, increases monotonically
+	Author  string `db:"author"`
+	Created int64  `db:"created"`
+	Rules   []byte `db:"rules"`
+}
+
+// Info returns the public rules version info object.
+func (v *ProjectRulesVersion) Info() gin.H {
+	return gin.H{
+		"version": v.ID,
+		"author":  v.Author,
+		"created": v.Created,
+		"rules":   json.RawMessage(v.Rules),
+	}
+}
+
+// GetProjectRulesVersions returns all recorded rules versions of project in ascending order.
+func GetProjectRulesVersions(db *gorp.DbMap) (versions []*ProjectRulesVersion, err error) {
+	_, err = db.Select(&versions, `SELECT * FROM "____rules_version" ORDER BY "id" ASC`)
+	if err != nil {
+		err = errors.Wrapf(err, "get project rules versions failed")
+	}
+
+	return
+}
+
+// GetProjectRulesVersion returns specified rules version of project.
+func GetProjectRulesVersion(db *gorp.DbMap, version int64) (v *ProjectRulesVersion, err error) {
+	err = db.SelectOne(&v, `SELECT * FROM "____rules_version" WHERE "id" = ? LIMIT 1`, version)
+	if err != nil {
+		err = errors.Wrapf(err, "get project rules version %d failed", version)
+	}
+
+	return
+}
+
+// GetLatestProjectRulesVersion returns the latest rules version of project, nil is returned if no
+// version is recorded.
+func GetLatestProjectRulesVersion(db *gorp.DbMap) (v *ProjectRulesVersion, err error) {
+	var versions []*ProjectRulesVersion
+
+	_, err = db.Select(&versions, `SELECT * FROM "____rules_version" ORDER BY "id" DESC LIMIT 1`)
+	if err != nil {
+		err = errors.Wrapf(err, "get latest project rules version failed")
+		return
+	}
+
+	if len(versions) > 0 {
+		v = versions[0]
+	}
+
+	return
+}
+
+// AddProjectRulesVersion records new rules version of project, only the latest keep versions are
+// retained.
+func AddProjectRulesVersion(db gorp.SqlExecutor, author string, rules []byte, keep int) (err error) {
+	v := &ProjectRulesVersion{
+		Author:  author,
+		Created: time.Now().Unix(),
+		Rules:   rules,
+	}
+
+	if err = db.Insert(v); err != nil {
+		err = errors.Wrapf(err, "add project rules version failed")
+		return
+	}
+
+	_, err = db.Exec(`DELETE FROM "____rules_version" WHERE "id" <= (SELECT MAX("id") FROM "____rules_version") - ?`,
+		keep)
+	if err != nil {
+		err = errors.Wrapf(err, "prune project rules versions failed")
+	}
+
+	return
+}
@@ -40,6 +40,10 @@ const (
 	ProjectUserStateDisabled
 )
 
+const (
+	projectUserCustomStateKey = "custom_state"
+)
+
 var projectStateStrMap = [...]string{
 	"PreRegistered",
 	"SignedUp",
@@ -83,6 +87,29 @@ type ProjectUser struct {
 	LastLogin   int64            `db:"last_login"`
 }
 
+// CustomState returns the developer-defined user state saved in extra user info.
+func (u *ProjectUser) CustomState() string {
+	if s, ok := u.Extra[projectUserCustomStateKey].(string); ok {
+		return s
+	}
+
+	return ""
+}
+
+// SetCustomState saves the developer-defined user state to extra user info, empty state removes the state.
+func (u *ProjectUser) SetCustomState(s string) {
+	if s == "" {
+		delete(u.Extra, projectUserCustomStateKey)
+		return
+	}
+
+	if u.Extra == nil {
+		u.Extra = gin.H{}
+	}
+
+	u.Extra[projectUserCustomStateKey] = s
+}
+
 // PostGet implements gorp.HasPostGet interface.
 func (u *ProjectUser) PostGet(gorp.SqlExecutor) error {
 	return u.LoadExtra()
@@ -139,6 +166,17 @@ func GetProjectUser(db *gorp.DbMap, id int64) (u *ProjectUser, err error) {
 	return
 }
 
+// GetProjectUserByProvider get project user info of specified provider and provider uid.
+func GetProjectUserByProvider(db *gorp.DbMap, provider string, uid string) (u *ProjectUser, err error) {
+	err = db.SelectOne(&u, `SELECT * FROM "____user" WHERE "provider" = ? AND "provider_uid" = ? LIMIT 1`,
+		provider, uid)
+	if err != nil {
+		err = errors.Wrapf(err, "get project user failed")
+		return
+	}
+	return
+}
+
 // GetProjectUsers batch fetch user info by ids.
 func GetProjectUsers(db *gorp.DbMap, id []int64) (users []*ProjectUser, err error) {
 	if len(id) == 0 {
@@ -0,0 +1,240 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package realtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+var (
+	// RetryInterval defines the interval to re-subscribe a broken change feed.
+	RetryInterval = 5 * time.Second
+)
+
+// SubscribeFunc subscribes the committed row changes of the tables in database.
+type SubscribeFunc func(ctx context.Context, dbID proto.DatabaseID, tables []string) (
+	<-chan *client.ChangeEvent, error)
+
+// Hub shares the change feeds of project databases between listeners.
+//
+// Each database is subscribed once for the union of tables of its listeners, the feed is
+// restarted if a new listener requires tables not covered yet and stopped if the last listener
+// is closed.
+type Hub struct {
+	lock      sync.Mutex
+	subscribe SubscribeFunc
+	feeds     map[proto.DatabaseID]*feed
+	closed    bool
+}
+
+// Listener receives the change notifications of tables in a database.
+type Listener struct {
+	hub     *Hub
+	dbID    proto.DatabaseID
+	tables  map[string]bool
+	pending map[string]bool // changed tables not taken yet, guarded by hub lock
+	ch      chan struct{}
+}
+
+type feed struct {
+	dbID      proto.DatabaseID
+	tables    map[string]bool // tables of current subscription
+	listeners map[*Listener]struct{}
+	cancel    context.CancelFunc
+}
+
+// NewHub returns new change feed hub.
+func NewHub(subscribe SubscribeFunc) *Hub {
+	return &Hub{
+		subscribe: subscribe,
+		feeds:     make(map[proto.DatabaseID]*feed),
+	}
+}
+
+// Listen registers a listener of row changes of the tables in database, the listener must be
+// closed to release the feed.
+func (h *Hub) Listen(dbID proto.DatabaseID, tables ...string) (l *Listener) {
+	l = &Listener{
+		hub:     h,
+		dbID:    dbID,
+		tables:  make(map[string]bool, len(tables)),
+		pending: make(map[string]bool),
+		ch:      make(chan struct{}, 1),
+	}
+	for _, t := range tables {
+		l.tables[t] = true
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.closed {
+		close(l.ch)
+		return
+	}
+
+	f, ok := h.feeds[dbID]
+	if !ok {
+		f = &feed{
+			dbID:      dbID,
+			listeners: make(map[*Listener]struct{}),
+		}
+		h.feeds[dbID] = f
+	}
+
+	f.listeners[l] = struct{}{}
+
+	for t := range l.tables {
+		if !f.tables[t] {
+			h.restart(f)
+			break
+		}
+	}
+
+	return
+}
+
+// Close stops all the change feeds and closes the listeners.
+func (h *Hub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closed = true
+
+	for dbID, f := range h.feeds {
+		f.cancel()
+		for l := range f.listeners {
+			close(l.ch)
+		}
+		delete(h.feeds, dbID)
+	}
+}
+
+// C returns the channel notified on table changes, the notifications are coalesced until the
+// changed tables are taken by Changes. The channel is closed if the listener or hub is closed.
+func (l *Listener) C() <-chan struct{} {
+	return l.ch
+}
+
+// Changes takes the tables changed since last call.
+func (l *Listener) Changes() (tables []string) {
+	l.hub.lock.Lock()
+	defer l.hub.lock.Unlock()
+
+	for t := range l.pending {
+		tables = append(tables, t)
+	}
+	l.pending = make(map[string]bool)
+
+	return
+}
+
+// Close unregisters the listener.
+func (l *Listener) Close() {
+	h := l.hub
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	f, ok := h.feeds[l.dbID]
+	if !ok {
+		return
+	}
+	if _, ok = f.listeners[l]; !ok {
+		return
+	}
+
+	delete(f.listeners, l)
+	close(l.ch)
+
+	if len(f.listeners) == 0 {
+		f.cancel()
+		delete(h.feeds, l.dbID)
+	}
+}
+
+// restart replaces the running subscription of feed with the union tables of listeners.
+func (h *Hub) restart(f *feed) {
+	if f.cancel != nil {
+		f.cancel()
+	}
+
+	f.tables = make(map[string]bool)
+	for l := range f.listeners {
+		for t := range l.tables {
+			f.tables[t] = true
+		}
+	}
+
+	tables := make([]string, 0, len(f.tables))
+	for t := range f.tables {
+		tables = append(tables, t)
+	}
+
+	var ctx context.Context
+	ctx, f.cancel = context.WithCancel(context.Background())
+
+	go h.run(ctx, f, tables)
+}
+
+func (h *Hub) run(ctx context.Context, f *feed, tables []string) {
+	for {
+		events, err := h.subscribe(ctx, f.dbID, tables)
+		if err == nil {
+			for ev := range events {
+				h.dispatch(f, ev)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.WithField("db", f.dbID).WithError(err).Warning("change feed broken, retry later")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RetryInterval):
+		}
+	}
+}
+
+func (h *Hub) dispatch(f *feed, ev *client.ChangeEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for l := range f.listeners {
+		if !l.tables[ev.Table] {
+			continue
+		}
+
+		l.pending[ev.Table] = true
+
+		select {
+		case l.ch <- struct{}{}:
+		default:
+			// already notified
+		}
+	}
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// CascadeActionDelete removes related rows in cascade table.
+	CascadeActionDelete = "delete"
+	// CascadeActionUpdate updates related rows in cascade table with specified values.
+	CascadeActionUpdate = "update"
+
+	// maxCascadeDepth defines the max nested level of cascade rules.
+	maxCascadeDepth = 8
+)
+
+// CascadeRule defines the related table operation triggered by removing rows from table.
+type CascadeRule struct {
+	Table  string                 `json:"table"`
+	Action string                 `json:"action"` // delete/update, defaults to delete
+	Keys   map[string]string      `json:"keys"`   // cascade table column to removing table column
+	Set    map[string]interface{} `json:"set"`    // values to set for update action
+}
+
+// Statement defines a single query statement with arguments.
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+func compileCascadeRules(tableName string, rules []*CascadeRule) (err error) {
+	for i, rule := range rules {
+		if rule == nil {
+			err = errors.Errorf("%s: empty cascade rule #%d", tableName, i)
+			return
+		}
+
+		if !identifierRegex.MatchString(rule.Table) {
+			err = errors.Errorf("%s: invalid cascade table %s", tableName, rule.Table)
+			return
+		}
+
+		if rule.Table == tableName {
+			err = errors.Errorf("%s: cascade on the same table is not supported", tableName)
+			return
+		}
+
+		switch rule.Action {
+		case "":
+			rule.Action = CascadeActionDelete
+		case CascadeActionDelete:
+		case CascadeActionUpdate:
+			if len(rule.Set) == 0 {
+				err = errors.Errorf("%s: cascade update on %s requires set values", tableName, rule.Table)
+				return
+			}
+		default:
+			err = errors.Errorf("%s: invalid cascade action %s", tableName, rule.Action)
+			return
+		}
+
+		if rule.Action == CascadeActionDelete && len(rule.Set) > 0 {
+			err = errors.Errorf("%s: set values are not supported in cascade delete", tableName)
+			return
+		}
+
+		if len(rule.Keys) == 0 {
+			err = errors.Errorf("%s: cascade on %s requires keys", tableName, rule.Table)
+			return
+		}
+
+		for k, v := range rule.Keys {
+			if !identifierRegex.MatchString(k) || !identifierRegex.MatchString(v) {
+				err = errors.Errorf("%s: invalid cascade key %s -> %s", tableName, k, v)
+				return
+			}
+		}
+
+		for k, v := range rule.Set {
+			if !identifierRegex.MatchString(k) {
+				err = errors.Errorf("%s: invalid cascade set column %s", tableName, k)
+				return
+			}
+
+			switch v.(type) {
+			case nil, string, float64, bool:
+			default:
+				err = errors.Errorf("%s: cascade set value of %s must be scalar", tableName, k)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// validateCascadeCycles detects cyclic cascade rules which could not be resolved to finite statements.
+func (r *Rules) validateCascadeCycles() (err error) {
+	var visit func(table string, path []string) error
+
+	visit = func(table string, path []string) error {
+		for _, p := range path {
+			if p == table {
+				return errors.Errorf("cascade cycle detected: %s -> %s", strings.Join(path, " -> "), table)
+			}
+		}
+
+		if len(path) > maxCascadeDepth {
+			return errors.Errorf("cascade rules too deep: %s", strings.Join(path, " -> "))
+		}
+
+		tr := r.rules[table]
+		if tr == nil {
+			return nil
+		}
+
+		for _, rule := range tr.cascade {
+			if rule.Action != CascadeActionDelete {
+				continue
+			}
+			if err := visit(rule.Table, append(path[:len(path):len(path)], table)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for table := range r.rules {
+		if err = visit(table, nil); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// HasCascade returns whether removing rows from table triggers cascade operations.
+func (r *Rules) HasCascade(table string) bool {
+	if r == nil || r.rules[table] == nil {
+		return false
+	}
+
+	return len(r.rules[table].cascade) > 0
+}
+
+// RemoveWithCascade builds statements to remove rows from table along with the cascade operations,
+// the statements should be executed in order in a single transaction.
+func (r *Rules) RemoveWithCascade(table string, availFields FieldMap, filter map[string]interface{},
+	justOne bool, vars map[string]interface{}) (statements []*Statement, err error) {
+	_, filterStatement, filterArgs, err := ResolveFilter(filter, availFields)
+	if err != nil {
+		err = errors.Wrapf(err, "resolve query filter failed")
+		return
+	}
+
+	cond := "1"
+	if filterStatement != "" {
+		cond = filterStatement
+	}
+
+	if justOne {
+		// pin the removing row, so cascade operations affect the same row
+		cond = fmt.Sprintf(`rowid IN (SELECT rowid FROM "%s" WHERE %s LIMIT 1)`, table, cond)
+	}
+
+	if statements, err = r.buildCascade(table, cond, filterArgs, vars, 0); err != nil {
+		return
+	}
+
+	statements = append(statements, &Statement{
+		Query: fmt.Sprintf(`DELETE FROM "%s" WHERE %s`, table, cond),
+		Args:  filterArgs,
+	})
+
+	return
+}
+
+// buildCascade builds cascade statements of rows in table matching the condition, deepest first.
+func (r *Rules) buildCascade(table string, cond string, args []interface{}, vars map[string]interface{},
+	depth int) (statements []*Statement, err error) {
+	if r.rules[table] == nil {
+		return
+	}
+
+	if depth >= maxCascadeDepth {
+		err = errors.Errorf("cascade rules of %s too deep", table)
+		return
+	}
+
+	for _, rule := range r.rules[table].cascade {
+		// correlate rows in cascade table with the removing rows
+		var keys []string
+		for k := range rule.Keys {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var joins []string
+		for _, k := range keys {
+			joins = append(joins, fmt.Sprintf(`"%s"."%s" = "%s"."%s"`, table, rule.Keys[k], rule.Table, k))
+		}
+
+		relatedCond := fmt.Sprintf(`EXISTS (SELECT 1 FROM "%s" WHERE (%s) AND %s)`,
+			table, cond, strings.Join(joins, " AND "))
+
+		switch rule.Action {
+		case CascadeActionUpdate:
+			var (
+				setValues = InjectMagicVars(rule.Set, vars)
+				setFields []string
+				setArgs   []interface{}
+				sets      []string
+			)
+
+			for k := range setValues {
+				setFields = append(setFields, k)
+			}
+			sort.Strings(setFields)
+
+			for _, k := range setFields {
+				sets = append(sets, fmt.Sprintf(`"%s" = ?`, k))
+				setArgs = append(setArgs, setValues[k])
+			}
+
+			statements = append(statements, &Statement{
+				Query: fmt.Sprintf(`UPDATE "%s" SET %s WHERE %s`, rule.Table, strings.Join(sets, ", "), relatedCond),
+				Args:  append(setArgs, args...),
+			})
+		default:
+			var nested []*Statement
+			if nested, err = r.buildCascade(rule.Table, relatedCond, args, vars, depth+1); err != nil {
+				return
+			}
+
+			statements = append(statements, nested...)
+			statements = append(statements, &Statement{
+				Query: fmt.Sprintf(`DELETE FROM "%s" WHERE %s`, rule.Table, relatedCond),
+				Args:  args,
+			})
+		}
+	}
+
+	return
+}
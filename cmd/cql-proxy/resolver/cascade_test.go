@@ -0,0 +1,176 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCascade(t *testing.T) {
+	Convey("cascade rules", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"rules": map[string]interface{}{
+				"article": map[string]interface{}{
+					"cascade": []interface{}{
+						map[string]interface{}{
+							"table": "comment",
+							"keys":  map[string]interface{}{"article_id": "id"},
+						},
+						map[string]interface{}{
+							"table":  "draft",
+							"action": "update",
+							"keys":   map[string]interface{}{"article_id": "id"},
+							"set":    map[string]interface{}{"article_id": nil, "editor": "$user_id"},
+						},
+					},
+				},
+				"comment": map[string]interface{}{
+					"cascade": []interface{}{
+						map[string]interface{}{
+							"table": "reply",
+							"keys":  map[string]interface{}{"comment_id": "id"},
+						},
+					},
+				},
+			},
+		})
+		vars := map[string]interface{}{"user_id": "bob"}
+
+		So(r.HasCascade("article"), ShouldBeTrue)
+		So(r.HasCascade("reply"), ShouldBeFalse)
+		So((*Rules)(nil).HasCascade("article"), ShouldBeFalse)
+
+		statements, err := r.RemoveWithCascade("article", FieldMap{"id": true, "title": true},
+			map[string]interface{}{"title": "t"}, false, vars)
+		So(err, ShouldBeNil)
+		So(statements, ShouldHaveLength, 4)
+		articleCond := `("title" = ?)`
+		commentCond := `EXISTS (SELECT 1 FROM "article" WHERE (` + articleCond +
+			`) AND "article"."id" = "comment"."article_id")`
+		So(statements[0], ShouldResemble, &Statement{
+			Query: `DELETE FROM "reply" WHERE EXISTS (SELECT 1 FROM "comment" WHERE (` + commentCond +
+				`) AND "comment"."id" = "reply"."comment_id")`,
+			Args: []interface{}{"t"},
+		})
+		So(statements[1], ShouldResemble, &Statement{
+			Query: `DELETE FROM "comment" WHERE ` + commentCond,
+			Args:  []interface{}{"t"},
+		})
+		So(statements[2], ShouldResemble, &Statement{
+			Query: `UPDATE "draft" SET "article_id" = ?, "editor" = ? WHERE EXISTS (SELECT 1 FROM "article" WHERE (` +
+				articleCond + `) AND "article"."id" = "draft"."article_id")`,
+			Args: []interface{}{nil, "bob", "t"},
+		})
+		So(statements[3], ShouldResemble, &Statement{
+			Query: `DELETE FROM "article" WHERE ` + articleCond,
+			Args:  []interface{}{"t"},
+		})
+
+		Convey("cascade statements should remove the related rows", func() {
+			dir, err := ioutil.TempDir("", "cascade_test_")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			db, err := sql.Open("sqlite3", filepath.Join(dir, "cascade.db3"))
+			So(err, ShouldBeNil)
+			defer db.Close()
+
+			for _, q := range []string{
+				`CREATE TABLE "article" ("id" INTEGER PRIMARY KEY, "title" TEXT)`,
+				`CREATE TABLE "comment" ("id" INTEGER PRIMARY KEY, "article_id" INTEGER)`,
+				`CREATE TABLE "reply" ("id" INTEGER PRIMARY KEY, "comment_id" INTEGER)`,
+				`CREATE TABLE "draft" ("id" INTEGER PRIMARY KEY, "article_id" INTEGER, "editor" TEXT)`,
+				`INSERT INTO "article" VALUES (1, 't'), (2, 't'), (3, 'x')`,
+				`INSERT INTO "comment" VALUES (1, 1), (2, 2), (3, 3)`,
+				`INSERT INTO "reply" VALUES (1, 1), (2, 2), (3, 3)`,
+				`INSERT INTO "draft" VALUES (1, 1, 'alice'), (2, 3, 'alice')`,
+			} {
+				_, err = db.Exec(q)
+				So(err, ShouldBeNil)
+			}
+
+			statements, err = r.RemoveWithCascade("article", FieldMap{"id": true, "title": true},
+				map[string]interface{}{"title": "t"}, true, vars)
+			So(err, ShouldBeNil)
+			for _, s := range statements {
+				_, err = db.Exec(s.Query, s.Args...)
+				So(err, ShouldBeNil)
+			}
+
+			count := func(q string) (c int) {
+				So(db.QueryRow(q).Scan(&c), ShouldBeNil)
+				return
+			}
+			// only the first matched article is removed with its related rows
+			So(count(`SELECT COUNT(1) FROM "article"`), ShouldEqual, 2)
+			So(count(`SELECT COUNT(1) FROM "comment" WHERE "article_id" = 1`), ShouldEqual, 0)
+			So(count(`SELECT COUNT(1) FROM "reply" WHERE "comment_id" = 1`), ShouldEqual, 0)
+			So(count(`SELECT COUNT(1) FROM "comment"`), ShouldEqual, 2)
+			So(count(`SELECT COUNT(1) FROM "reply"`), ShouldEqual, 2)
+			So(count(`SELECT COUNT(1) FROM "draft" WHERE "article_id" IS NULL AND "editor" = 'bob'`), ShouldEqual, 1)
+			So(count(`SELECT COUNT(1) FROM "draft" WHERE "article_id" = 3 AND "editor" = 'alice'`), ShouldEqual, 1)
+		})
+	})
+	Convey("invalid cascade rules should be rejected", t, func() {
+		for _, rules := range []map[string]interface{}{
+			{"article": map[string]interface{}{"cascade": []interface{}{nil}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": `comment"`, "keys": map[string]interface{}{"a": "id"}},
+			}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": "article", "keys": map[string]interface{}{"a": "id"}},
+			}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": "comment", "action": "truncate", "keys": map[string]interface{}{"a": "id"}},
+			}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": "comment", "action": "update", "keys": map[string]interface{}{"a": "id"}},
+			}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": "comment", "keys": map[string]interface{}{"a": "id"},
+					"set": map[string]interface{}{"a": nil}},
+			}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": "comment"},
+			}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": "comment", "keys": map[string]interface{}{"a": "1id"}},
+			}}},
+			{"article": map[string]interface{}{"cascade": []interface{}{
+				map[string]interface{}{"table": "comment", "action": "update", "keys": map[string]interface{}{"a": "id"},
+					"set": map[string]interface{}{"a": []interface{}{}}},
+			}}},
+			{
+				"article": map[string]interface{}{"cascade": []interface{}{
+					map[string]interface{}{"table": "comment", "keys": map[string]interface{}{"article_id": "id"}},
+				}},
+				"comment": map[string]interface{}{"cascade": []interface{}{
+					map[string]interface{}{"table": "article", "keys": map[string]interface{}{"id": "article_id"}},
+				}},
+			},
+		} {
+			_, err := CompileRules(map[string]interface{}{"rules": rules})
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
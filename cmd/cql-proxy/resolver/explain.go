@@ -0,0 +1,149 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// ExplainedRule defines single compiled rule matched by the query subject.
+type ExplainedRule struct {
+	Subject string                 `json:"subject"`
+	Rule    map[string]interface{} `json:"rule"`
+}
+
+// Explanation defines the rules enforcement result of a dry-run query.
+type Explanation struct {
+	Table       string                 `json:"table"`
+	QueryType   string                 `json:"type"`
+	UserID      string                 `json:"uid"`
+	UserState   string                 `json:"state"`
+	Allowed     bool                   `json:"allowed"`
+	Reason      string                 `json:"reason,omitempty"`
+	Rules       []ExplainedRule        `json:"rules"`
+	UpdateRules []ExplainedRule        `json:"update_rules,omitempty"`
+	Filter      map[string]interface{} `json:"filter,omitempty"`
+	Update      map[string]interface{} `json:"update,omitempty"`
+	Insert      map[string]interface{} `json:"insert,omitempty"`
+	Mask        ColumnMask             `json:"mask,omitempty"`
+}
+
+// ExplainEnforcement explains the rules applied to query without executing it, data argument is used as
+// the update object for update query and the inserting data for insert query.
+func (m *RulesManager) ExplainEnforcement(dbID proto.DatabaseID, table string, qt RuleQueryType,
+	uid string, userState string, filter map[string]interface{}, data map[string]interface{},
+	vars map[string]interface{}) (e *Explanation, err error) {
+	r := m.Get(dbID)
+	if r == nil {
+		err = errors.Errorf("rules of database %s is not loaded", dbID)
+		return
+	}
+
+	return r.Explain(table, qt, uid, userState, filter, data, vars)
+}
+
+// Explain explains the rules applied to query of specified table, a denied query is not treated as error
+// but reported by the allowed flag and reason of explanation.
+func (r *Rules) Explain(table string, qt RuleQueryType, uid string, userState string,
+	filter map[string]interface{}, data map[string]interface{}, vars map[string]interface{}) (
+	e *Explanation, err error) {
+	if _, ok := ruleQueryTypeNames[qt]; !ok {
+		err = errors.Errorf("unknown query type %d", qt)
+		return
+	}
+
+	e = &Explanation{
+		Table:     table,
+		QueryType: qt.String(),
+		UserID:    uid,
+		UserState: userState,
+	}
+
+	var enforceErr error
+
+	defer func() {
+		if enforceErr != nil {
+			e.Allowed = false
+			e.Reason = enforceErr.Error()
+		} else {
+			e.Allowed = true
+		}
+	}()
+
+	queryRules := r.findUserRules(table, qt)
+
+	e.Rules, enforceErr = r.explainRules(queryRules, uid, userState)
+	if enforceErr != nil {
+		return
+	}
+
+	if queryRules != nil && queryRules.externalRules != nil {
+		e.Rules = append(e.Rules, ExplainedRule{
+			Subject: externalSubject,
+			Rule:    queryRules.externalRules,
+		})
+	}
+
+	switch qt {
+	case RuleQueryInsert:
+		e.Insert, enforceErr = r.EnforceRulesOnInsert(data, table, uid, userState, vars)
+	case RuleQueryUpdate:
+		if tableRules, ok := r.rules[table]; ok && tableRules != nil {
+			e.UpdateRules, enforceErr = r.explainRules(tableRules.updateRules, uid, userState)
+			if enforceErr != nil {
+				return
+			}
+		}
+
+		e.Filter, enforceErr = r.EnforceRulesOnFilter(filter, table, uid, userState, vars, qt)
+		if enforceErr != nil {
+			return
+		}
+
+		e.Update, enforceErr = r.EnforceRulesOnUpdate(data, table, uid, userState, vars)
+	case RuleQueryFind, RuleQueryCount, RuleQueryAggregate:
+		e.Filter, enforceErr = r.EnforceRulesOnFilter(filter, table, uid, userState, vars, qt)
+		if enforceErr != nil {
+			return
+		}
+
+		e.Mask, enforceErr = r.EnforceMask(table, uid, userState)
+	default:
+		e.Filter, enforceErr = r.EnforceRulesOnFilter(filter, table, uid, userState, vars, qt)
+	}
+
+	return
+}
+
+func (r *Rules) explainRules(queryRules *QueryRules, uid string, userState string) (
+	rules []ExplainedRule, err error) {
+	subjects, resultRules, err := r.findRulesToApplyWithSubjects(queryRules, uid, userState)
+	if err != nil {
+		return
+	}
+
+	for i, rule := range resultRules {
+		rules = append(rules, ExplainedRule{
+			Subject: subjects[i],
+			Rule:    rule,
+		})
+	}
+
+	return
+}
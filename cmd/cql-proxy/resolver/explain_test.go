@@ -0,0 +1,127 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestExplain(t *testing.T) {
+	Convey("explain rules enforcement", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"groups": map[string]interface{}{
+				"admin": []interface{}{"alice"},
+			},
+			"rules": map[string]interface{}{
+				"article": map[string]interface{}{
+					"find": map[string]interface{}{
+						"g:admin": map[string]interface{}{},
+						"default": map[string]interface{}{"owner": "$user_id"},
+					},
+					"update": map[string]interface{}{
+						"filter": map[string]interface{}{
+							"default": map[string]interface{}{"owner": "$user_id"},
+						},
+					},
+					"remove": map[string]interface{}{
+						"g:admin": map[string]interface{}{},
+						"default": nil,
+					},
+					"mask": map[string]interface{}{
+						"default": map[string]interface{}{"secret": false},
+					},
+				},
+			},
+		})
+		vars := map[string]interface{}{"user_id": "bob"}
+
+		Convey("allowed find query should report the matched rules, filter and mask", func() {
+			e, err := r.Explain("article", RuleQueryFind, "bob", UserStateLoggedIn,
+				map[string]interface{}{"id": 1}, nil, vars)
+			So(err, ShouldBeNil)
+			So(e.Allowed, ShouldBeTrue)
+			So(e.Reason, ShouldBeEmpty)
+			So(e.QueryType, ShouldEqual, RuleQueryFind.String())
+			So(e.Rules, ShouldResemble, []ExplainedRule{
+				{Subject: "default", Rule: map[string]interface{}{"owner": "$user_id"}},
+			})
+			So(e.Filter, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"owner": "bob"},
+					map[string]interface{}{"id": 1},
+				},
+			})
+			So(e.Mask, ShouldResemble, ColumnMask{"secret": MaskActionStrip})
+
+			e, err = r.Explain("article", RuleQueryFind, "alice", UserStateLoggedIn, nil, nil, nil)
+			So(err, ShouldBeNil)
+			So(e.Allowed, ShouldBeTrue)
+			So(e.Rules, ShouldResemble, []ExplainedRule{
+				{Subject: "g:admin", Rule: map[string]interface{}{}},
+			})
+		})
+		Convey("denied query should be reported without error", func() {
+			e, err := r.Explain("article", RuleQueryRemove, "bob", UserStateLoggedIn, nil, nil, vars)
+			So(err, ShouldBeNil)
+			So(e.Allowed, ShouldBeFalse)
+			So(e.Reason, ShouldNotBeEmpty)
+		})
+		Convey("update query should report the filter and update rules", func() {
+			e, err := r.Explain("article", RuleQueryUpdate, "bob", UserStateLoggedIn,
+				nil, map[string]interface{}{"$set": map[string]interface{}{"title": "t"}}, vars)
+			So(err, ShouldBeNil)
+			So(e.Allowed, ShouldBeTrue)
+			So(e.Filter, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"owner": "bob"},
+				},
+			})
+			So(e.Update, ShouldResemble, map[string]interface{}{
+				"$set": map[string]interface{}{"title": "t"},
+			})
+		})
+		Convey("unknown query type should be rejected", func() {
+			_, err := r.Explain("article", RuleQueryType(1000), "bob", UserStateLoggedIn, nil, nil, vars)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("explain through rules manager requires loaded rules", func() {
+			m := &RulesManager{}
+			dbID := proto.DatabaseID("db")
+			_, err := m.ExplainEnforcement(dbID, "article", RuleQueryFind, "bob", UserStateLoggedIn, nil, nil, vars)
+			So(err, ShouldNotBeNil)
+
+			raw, err := json.Marshal(map[string]interface{}{
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{"default": nil},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+			_, err = m.Reload(dbID, raw, nil)
+			So(err, ShouldBeNil)
+			e, err := m.ExplainEnforcement(dbID, "article", RuleQueryFind, "bob", UserStateLoggedIn, nil, nil, vars)
+			So(err, ShouldBeNil)
+			So(e.Allowed, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var exprCompareOpMap = map[string]string{
+	"$eq":  "=",
+	"$ne":  "<>",
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+var exprArithOpMap = map[string]string{
+	"$add":      "+",
+	"$subtract": "-",
+	"$multiply": "*",
+	"$divide":   "/",
+	"$mod":      "%",
+}
+
+// ResolveExpr resolves $expr object comparing columns and values as where condition statement.
+//
+// Column is referenced as {"$col": "name"} to distinguish from string literals and magic variables, e.g.
+//
+//	{"$eq": [{"$col": "owner_id"}, {"$col": "editor_id"}]}
+//	{"$gt": [{"$col": "expire_at"}, {"$add": ["$now_unix", 3600]}]}
+func ResolveExpr(v interface{}, availFields FieldMap) (fields FieldMap, statement string, args []interface{}, err error) {
+	return resolveExpr(v, func(field string) error {
+		if !availFields[field] {
+			return errors.Errorf("unknown field: %s", field)
+		}
+		return nil
+	})
+}
+
+// validateExpr validates the syntax of $expr object in rules, fields are checked on enforcement.
+func validateExpr(v interface{}) (err error) {
+	_, _, _, err = resolveExpr(v, func(field string) error {
+		if !identifierRegex.MatchString(field) {
+			return errors.Errorf("invalid field: %s", field)
+		}
+		return nil
+	})
+	return
+}
+
+func resolveExpr(v interface{}, checkField func(string) error) (
+	fields FieldMap, statement string, args []interface{}, err error) {
+	o, ok := v.(map[string]interface{})
+	if !ok || len(o) == 0 {
+		err = errors.New("$expr operator needs non-empty object")
+		return
+	}
+
+	fields = FieldMap{}
+	var subStatements []string
+
+	for k, ov := range o {
+		var (
+			childFields    FieldMap
+			childStatement string
+			childArgs      []interface{}
+		)
+
+		switch k {
+		case "$and", "$or":
+			var children []interface{}
+			if children, ok = ov.([]interface{}); !ok || len(children) == 0 {
+				err = errors.Errorf("%s operator needs non-empty array", k)
+				return
+			}
+
+			var logicStatements []string
+
+			for _, child := range children {
+				var (
+					cFields    FieldMap
+					cStatement string
+					cArgs      []interface{}
+				)
+				if cFields, cStatement, cArgs, err = resolveExpr(child, checkField); err != nil {
+					return
+				}
+				fields.Merge(cFields)
+				logicStatements = append(logicStatements, cStatement)
+				args = append(args, cArgs...)
+			}
+
+			subStatements = append(subStatements,
+				"("+strings.Join(logicStatements, ") "+opMap[k]+" (")+")")
+			continue
+		case "$not":
+			if childFields, childStatement, childArgs, err = resolveExpr(ov, checkField); err != nil {
+				return
+			}
+			childStatement = "NOT " + childStatement
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			var operands []interface{}
+			if operands, ok = ov.([]interface{}); !ok || len(operands) != 2 {
+				err = errors.Errorf("%s operator needs array of 2 operands", k)
+				return
+			}
+
+			op := exprCompareOpMap[k]
+			if operands[0] == nil || operands[1] == nil {
+				// compare with null
+				switch k {
+				case "$eq":
+					op = "IS"
+				case "$ne":
+					op = "IS NOT"
+				default:
+					err = errors.Errorf("%s operator could not compare with null", k)
+					return
+				}
+			}
+
+			var operandStatements []string
+
+			for _, operand := range operands {
+				var (
+					oFields    FieldMap
+					oStatement string
+					oArgs      []interface{}
+				)
+				if oFields, oStatement, oArgs, err = resolveExprOperand(operand, checkField); err != nil {
+					return
+				}
+				fields.Merge(oFields)
+				operandStatements = append(operandStatements, oStatement)
+				childArgs = append(childArgs, oArgs...)
+			}
+
+			childStatement = fmt.Sprintf("(%s %s %s)", operandStatements[0], op, operandStatements[1])
+		case "$comment":
+			// ignore
+			continue
+		default:
+			err = errors.Errorf("unknown $expr operator %s", k)
+			return
+		}
+
+		fields.Merge(childFields)
+		subStatements = append(subStatements, childStatement)
+		args = append(args, childArgs...)
+	}
+
+	if len(subStatements) == 0 {
+		err = errors.New("empty $expr operator")
+		return
+	}
+
+	statement = "(" + strings.Join(subStatements, ") AND (") + ")"
+
+	return
+}
+
+func resolveExprOperand(v interface{}, checkField func(string) error) (
+	fields FieldMap, statement string, args []interface{}, err error) {
+	fields = FieldMap{}
+
+	if v == nil || isLiteral(v) {
+		statement = "?"
+		args = append(args, v)
+		return
+	}
+
+	o, ok := v.(map[string]interface{})
+	if !ok || len(o) != 1 {
+		err = errors.New("$expr operand must be literal, column or arithmetic expression")
+		return
+	}
+
+	for k, ov := range o {
+		if k == "$col" {
+			var field string
+			if field, ok = ov.(string); !ok {
+				err = errors.New("$col operator needs column name")
+				return
+			}
+			if err = checkField(field); err != nil {
+				return
+			}
+
+			fields[field] = true
+			statement = fmt.Sprintf(`"%s"`, field)
+			return
+		}
+
+		op, ok := exprArithOpMap[k]
+		if !ok {
+			err = errors.Errorf("unknown $expr operator %s", k)
+			return
+		}
+
+		var operands []interface{}
+		if operands, ok = ov.([]interface{}); !ok || len(operands) < 2 {
+			err = errors.Errorf("%s operator needs array of at least 2 operands", k)
+			return
+		}
+
+		if k != "$add" && k != "$multiply" && len(operands) != 2 {
+			err = errors.Errorf("%s operator needs array of 2 operands", k)
+			return
+		}
+
+		var operandStatements []string
+
+		for _, operand := range operands {
+			var (
+				oFields    FieldMap
+				oStatement string
+				oArgs      []interface{}
+			)
+			if oFields, oStatement, oArgs, err = resolveExprOperand(operand, checkField); err != nil {
+				return
+			}
+			fields.Merge(oFields)
+			operandStatements = append(operandStatements, oStatement)
+			args = append(args, oArgs...)
+		}
+
+		statement = "(" + strings.Join(operandStatements, " "+op+" ") + ")"
+	}
+
+	return
+}
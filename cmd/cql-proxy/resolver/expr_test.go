@@ -0,0 +1,159 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResolveExpr(t *testing.T) {
+	Convey("resolve expr", t, func() {
+		availFields := FieldMap{"owner": true, "editor": true, "expire_at": true}
+
+		fields, statement, args, err := ResolveExpr(map[string]interface{}{
+			"$eq": []interface{}{
+				map[string]interface{}{"$col": "owner"},
+				map[string]interface{}{"$col": "editor"},
+			},
+		}, availFields)
+		So(err, ShouldBeNil)
+		So(fields, ShouldResemble, FieldMap{"owner": true, "editor": true})
+		So(statement, ShouldEqual, `(("owner" = "editor"))`)
+		So(args, ShouldBeNil)
+
+		fields, statement, args, err = ResolveExpr(map[string]interface{}{
+			"$gt": []interface{}{
+				map[string]interface{}{"$col": "expire_at"},
+				map[string]interface{}{"$add": []interface{}{1000, 3600}},
+			},
+		}, availFields)
+		So(err, ShouldBeNil)
+		So(fields, ShouldResemble, FieldMap{"expire_at": true})
+		So(statement, ShouldEqual, `(("expire_at" > (? + ?)))`)
+		So(args, ShouldResemble, []interface{}{1000, 3600})
+
+		_, statement, args, err = ResolveExpr(map[string]interface{}{
+			"$ne": []interface{}{map[string]interface{}{"$col": "editor"}, nil},
+		}, availFields)
+		So(err, ShouldBeNil)
+		So(statement, ShouldEqual, `(("editor" IS NOT ?))`)
+		So(args, ShouldResemble, []interface{}{nil})
+
+		for _, v := range []interface{}{
+			nil,
+			map[string]interface{}{},
+			map[string]interface{}{"$eq": []interface{}{map[string]interface{}{"$col": "unknown"}, 1}},
+			map[string]interface{}{"$eq": []interface{}{map[string]interface{}{"$col": "t.owner"}, 1}},
+			map[string]interface{}{"$eq": []interface{}{map[string]interface{}{"$col": 1}, 1}},
+			map[string]interface{}{"$eq": []interface{}{1}},
+			map[string]interface{}{"$gt": []interface{}{map[string]interface{}{"$col": "owner"}, nil}},
+			map[string]interface{}{"$eq": []interface{}{map[string]interface{}{"$sql": "1"}, 1}},
+			map[string]interface{}{"$mod": []interface{}{1, 2, 3}},
+			map[string]interface{}{"$where": "1 = 1"},
+		} {
+			_, _, _, err = ResolveExpr(v, availFields)
+			So(err, ShouldNotBeNil)
+		}
+
+		Convey("expr in filter", func() {
+			fields, statement, args, err := ResolveFilter(map[string]interface{}{
+				"$expr": map[string]interface{}{
+					"$lt": []interface{}{map[string]interface{}{"$col": "expire_at"}, 100},
+				},
+			}, availFields)
+			So(err, ShouldBeNil)
+			So(fields, ShouldResemble, FieldMap{"expire_at": true})
+			So(statement, ShouldEqual, `((("expire_at" < ?)))`)
+			So(args, ShouldResemble, []interface{}{100})
+		})
+
+		Convey("invalid field in rules", func() {
+			for _, col := range []string{"t.owner", "owner; DROP TABLE article", `owner"`} {
+				_, err = CompileRules(map[string]interface{}{
+					"rules": map[string]interface{}{
+						"article": map[string]interface{}{
+							"find": map[string]interface{}{
+								"default": map[string]interface{}{
+									"$expr": map[string]interface{}{
+										"$eq": []interface{}{map[string]interface{}{"$col": col}, 1},
+									},
+								},
+							},
+						},
+					},
+				})
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("logical operators", func() {
+			_, statement, args, err = ResolveExpr(map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"$eq": []interface{}{map[string]interface{}{"$col": "owner"}, nil}},
+					map[string]interface{}{"$not": map[string]interface{}{
+						"$lte": []interface{}{map[string]interface{}{
+							"$mod": []interface{}{map[string]interface{}{"$col": "expire_at"}, 2},
+						}, 0},
+					}},
+				},
+			}, availFields)
+			So(err, ShouldBeNil)
+			So(statement, ShouldEqual, `(((("owner" IS ?))) OR ((NOT ((("expire_at" % ?) <= ?)))))`)
+			So(args, ShouldResemble, []interface{}{nil, 2, 0})
+
+			_, statement, _, err = ResolveExpr(map[string]interface{}{
+				"$eq":      []interface{}{map[string]interface{}{"$col": "owner"}, 1},
+				"$comment": "ignored",
+			}, availFields)
+			So(err, ShouldBeNil)
+			So(statement, ShouldEqual, `(("owner" = ?))`)
+
+			for _, v := range []interface{}{
+				map[string]interface{}{"$comment": "empty"},
+				map[string]interface{}{"$and": []interface{}{}},
+				map[string]interface{}{"$or": []interface{}{1}},
+				map[string]interface{}{"$not": nil},
+			} {
+				_, _, _, err = ResolveExpr(v, availFields)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("magic vars in rules", func() {
+			r := mustCompileRules(map[string]interface{}{
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{
+							"default": map[string]interface{}{
+								"$expr": map[string]interface{}{
+									"$eq": []interface{}{map[string]interface{}{"$col": "owner"}, "$user_id"},
+								},
+							},
+						},
+					},
+				},
+			})
+			filter, err := r.EnforceRulesOnFilter(nil, "article", "bob", UserStateLoggedIn,
+				map[string]interface{}{"user_id": "bob"}, RuleQueryFind)
+			So(err, ShouldBeNil)
+			_, _, args, err = ResolveFilter(filter, availFields)
+			So(err, ShouldBeNil)
+			So(args, ShouldResemble, []interface{}{"bob"})
+		})
+	})
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ExternalDecisionAllow allows the query as it is.
+	ExternalDecisionAllow = "allow"
+	// ExternalDecisionDeny denies the query.
+	ExternalDecisionDeny = "deny"
+	// ExternalDecisionRewrite allows the query with rewritten filter or data.
+	ExternalDecisionRewrite = "rewrite"
+
+	// externalSubject defines the enforce subject delegating authorization to external service.
+	externalSubject = "external"
+
+	// defaultExternalAuthTimeout defines the default timeout of external authorization request.
+	defaultExternalAuthTimeout = 3 * time.Second
+)
+
+// ExternalAuthConfig defines the external authorization service config.
+type ExternalAuthConfig struct {
+	URL      string            `json:"url" validate:"required"`
+	Timeout  string            `json:"timeout"` // defaults to 3s
+	Headers  map[string]string `json:"headers"`
+	FailOpen bool              `json:"fail_open"` // allow query if authorization service is unavailable
+
+	timeout time.Duration
+}
+
+// ExternalAuthRequest defines the query context sent to external authorization service.
+type ExternalAuthRequest struct {
+	Table     string                 `json:"table"`
+	QueryType string                 `json:"type"`
+	UserID    string                 `json:"uid"`
+	UserState string                 `json:"state"`
+	Groups    []string               `json:"groups"`
+	Filter    map[string]interface{} `json:"filter,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Rule      map[string]interface{} `json:"rule,omitempty"` // enforce object of external subject
+}
+
+// ExternalAuthDecision defines the decision replied by external authorization service.
+type ExternalAuthDecision struct {
+	Decision string                 `json:"decision"`
+	Reason   string                 `json:"reason"`
+	Filter   map[string]interface{} `json:"filter"` // filter appended to query on rewrite
+	Data     map[string]interface{} `json:"data"`   // insert data merged on rewrite
+}
+
+// ExternalAuthorizer defines the external authorization service called on each query.
+type ExternalAuthorizer interface {
+	Authorize(req *ExternalAuthRequest) (decision *ExternalAuthDecision, err error)
+}
+
+// HTTPAuthorizer calls external authorization service using http json api.
+//
+// The query context is posted as {"input": request}, the reply could be the decision object itself
+// or wrapped as {"result": decision}, which is compatible with the data api of Open Policy Agent.
+type HTTPAuthorizer struct {
+	cfg    *ExternalAuthConfig
+	client *http.Client
+}
+
+func compileExternalAuth(cfg *ExternalAuthConfig) (err error) {
+	if cfg == nil {
+		return
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid external authorization url %s", cfg.URL)
+		return
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = errors.Errorf("unsupported external authorization url scheme %s", u.Scheme)
+		return
+	}
+
+	if cfg.Timeout == "" {
+		cfg.timeout = defaultExternalAuthTimeout
+	} else if cfg.timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+		err = errors.Wrapf(err, "invalid external authorization timeout %s", cfg.Timeout)
+	} else if cfg.timeout <= 0 {
+		err = errors.Errorf("invalid external authorization timeout %s", cfg.Timeout)
+	}
+
+	return
+}
+
+// NewHTTPAuthorizer returns new http external authorizer.
+func NewHTTPAuthorizer(cfg *ExternalAuthConfig) *HTTPAuthorizer {
+	return &HTTPAuthorizer{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: cfg.timeout,
+		},
+	}
+}
+
+// Authorize implements ExternalAuthorizer.Authorize.
+func (a *HTTPAuthorizer) Authorize(req *ExternalAuthRequest) (decision *ExternalAuthDecision, err error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"input": req,
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "encode authorization request failed")
+		return
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, a.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrapf(err, "build authorization request failed")
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range a.cfg.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		err = errors.Wrapf(err, "send authorization request failed")
+		return
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Errorf("authorization service replied status %d", resp.StatusCode)
+		return
+	}
+
+	var reply struct {
+		ExternalAuthDecision
+		Result *ExternalAuthDecision `json:"result"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		err = errors.Wrapf(err, "decode authorization decision failed")
+		return
+	}
+
+	if reply.Result != nil {
+		decision = reply.Result
+	} else {
+		decision = &reply.ExternalAuthDecision
+	}
+
+	return
+}
+
+// SetAuthorizer sets the external authorization service of rules.
+func (r *Rules) SetAuthorizer(a ExternalAuthorizer) {
+	r.authorizer = a
+}
+
+// authorizeExternal calls the external authorization service if external subject is declared in query rules,
+// nil decision is returned if external authorization is not required.
+func (r *Rules) authorizeExternal(queryRules *QueryRules, table string, qt RuleQueryType,
+	uid string, userState string, filter map[string]interface{}, data map[string]interface{},
+	vars map[string]interface{}) (decision *ExternalAuthDecision, err error) {
+	if queryRules == nil || queryRules.externalRules == nil {
+		return
+	}
+
+	if r.authorizer == nil {
+		err = errors.New("external authorization service is not configured")
+		return
+	}
+
+	groups, err := r.groupsOf(uid)
+	if err != nil {
+		return
+	}
+
+	decision, err = r.authorizer.Authorize(&ExternalAuthRequest{
+		Table:     table,
+		QueryType: qt.String(),
+		UserID:    uid,
+		UserState: userState,
+		Groups:    groups,
+		Filter:    filter,
+		Data:      data,
+		Rule:      InjectMagicVars(queryRules.externalRules, vars),
+	})
+	if err != nil {
+		if r.external != nil && r.external.FailOpen {
+			decision = &ExternalAuthDecision{Decision: ExternalDecisionAllow}
+			err = nil
+			return
+		}
+
+		err = errors.Wrapf(err, "external authorization failed")
+		return
+	}
+
+	switch decision.Decision {
+	case ExternalDecisionAllow, ExternalDecisionRewrite:
+	case ExternalDecisionDeny:
+		err = errors.Errorf("permission denied of external authorization: %s", decision.Reason)
+	default:
+		err = errors.Errorf("unknown external authorization decision %s", decision.Decision)
+	}
+
+	if decision.Decision == ExternalDecisionRewrite {
+		decision.Filter = InjectMagicVars(decision.Filter, vars)
+		decision.Data = InjectMagicVars(decision.Data, vars)
+	}
+
+	return
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type stubAuthorizer struct {
+	decision *ExternalAuthDecision
+	err      error
+	req      *ExternalAuthRequest
+}
+
+func (a *stubAuthorizer) Authorize(req *ExternalAuthRequest) (*ExternalAuthDecision, error) {
+	a.req = req
+	if a.err != nil {
+		return nil, a.err
+	}
+	d := *a.decision
+	return &d, nil
+}
+
+func TestCompileExternalAuth(t *testing.T) {
+	Convey("compile external auth", t, func() {
+		cfg := &ExternalAuthConfig{URL: "http://127.0.0.1/v1/data/authz"}
+		So(compileExternalAuth(cfg), ShouldBeNil)
+		So(cfg.timeout, ShouldEqual, defaultExternalAuthTimeout)
+
+		cfg.Timeout = "500ms"
+		So(compileExternalAuth(cfg), ShouldBeNil)
+		So(cfg.timeout, ShouldEqual, 500*time.Millisecond)
+
+		for _, cfg := range []*ExternalAuthConfig{
+			{URL: "ftp://127.0.0.1/authz"},
+			{URL: "://invalid"},
+			{URL: "https://127.0.0.1/authz", Timeout: "invalid"},
+			{URL: "https://127.0.0.1/authz", Timeout: "0s"},
+		} {
+			So(compileExternalAuth(cfg), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestHTTPAuthorizer(t *testing.T) {
+	Convey("http authorizer", t, func() {
+		var (
+			reply  interface{}
+			status = http.StatusOK
+			input  struct {
+				Input *ExternalAuthRequest `json:"input"`
+			}
+			token string
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token = r.Header.Get("Authorization")
+			_ = json.NewDecoder(r.Body).Decode(&input)
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(reply)
+		}))
+		defer server.Close()
+
+		cfg := &ExternalAuthConfig{
+			URL:     server.URL,
+			Headers: map[string]string{"Authorization": "Bearer token"},
+		}
+		So(compileExternalAuth(cfg), ShouldBeNil)
+		a := NewHTTPAuthorizer(cfg)
+		req := &ExternalAuthRequest{
+			Table:     "article",
+			QueryType: RuleQueryFind.String(),
+			UserID:    "bob",
+			UserState: UserStateLoggedIn,
+		}
+
+		reply = map[string]interface{}{"decision": ExternalDecisionAllow}
+		d, err := a.Authorize(req)
+		So(err, ShouldBeNil)
+		So(d.Decision, ShouldEqual, ExternalDecisionAllow)
+		So(token, ShouldEqual, "Bearer token")
+		So(input.Input, ShouldResemble, req)
+
+		// decision wrapped in result as open policy agent data api
+		reply = map[string]interface{}{
+			"result": map[string]interface{}{"decision": ExternalDecisionDeny, "reason": "blocked"},
+		}
+		d, err = a.Authorize(req)
+		So(err, ShouldBeNil)
+		So(d.Decision, ShouldEqual, ExternalDecisionDeny)
+		So(d.Reason, ShouldEqual, "blocked")
+
+		reply = "invalid"
+		_, err = a.Authorize(req)
+		So(err, ShouldNotBeNil)
+
+		status = http.StatusInternalServerError
+		_, err = a.Authorize(req)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAuthorizeExternal(t *testing.T) {
+	Convey("external authorization of rules", t, func() {
+		compile := func(failOpen bool) *Rules {
+			return mustCompileRules(map[string]interface{}{
+				"external": map[string]interface{}{
+					"url":       "http://127.0.0.1/authz",
+					"fail_open": failOpen,
+				},
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{
+							"external": map[string]interface{}{"tenant": "$user_id"},
+						},
+					},
+				},
+			})
+		}
+		var (
+			r    = compile(false)
+			a    = &stubAuthorizer{}
+			vars = map[string]interface{}{"user_id": "bob"}
+		)
+		r.SetAuthorizer(a)
+
+		Convey("allowed query should be passed as it is", func() {
+			a.decision = &ExternalAuthDecision{Decision: ExternalDecisionAllow}
+			filter, err := r.EnforceRulesOnFilter(map[string]interface{}{"id": 1}, "article", "bob",
+				UserStateLoggedIn, vars, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{"id": 1})
+			So(a.req.Table, ShouldEqual, "article")
+			So(a.req.QueryType, ShouldEqual, RuleQueryFind.String())
+			So(a.req.UserID, ShouldEqual, "bob")
+			So(a.req.Filter, ShouldResemble, map[string]interface{}{"id": 1})
+			So(a.req.Rule, ShouldResemble, map[string]interface{}{"tenant": "bob"})
+		})
+		Convey("rewritten filter should be appended to query", func() {
+			a.decision = &ExternalAuthDecision{
+				Decision: ExternalDecisionRewrite,
+				Filter:   map[string]interface{}{"owner": "$user_id"},
+			}
+			filter, err := r.EnforceRulesOnFilter(map[string]interface{}{"id": 1}, "article", "bob",
+				UserStateLoggedIn, vars, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"id": 1},
+					map[string]interface{}{"owner": "bob"},
+				},
+			})
+		})
+		Convey("denied or unknown decision should fail the query", func() {
+			for _, decision := range []string{ExternalDecisionDeny, "unknown"} {
+				a.decision = &ExternalAuthDecision{Decision: decision}
+				filter, err := r.EnforceRulesOnFilter(nil, "article", "bob", UserStateLoggedIn, vars, RuleQueryFind)
+				So(err, ShouldNotBeNil)
+				So(filter, ShouldBeNil)
+			}
+		})
+		Convey("unavailable authorization service should fail closed by default", func() {
+			a.err = errors.New("unavailable")
+			_, err := r.EnforceRulesOnFilter(nil, "article", "bob", UserStateLoggedIn, vars, RuleQueryFind)
+			So(err, ShouldNotBeNil)
+
+			r = compile(true)
+			r.SetAuthorizer(a)
+			_, err = r.EnforceRulesOnFilter(nil, "article", "bob", UserStateLoggedIn, vars, RuleQueryFind)
+			So(err, ShouldBeNil)
+		})
+		Convey("query without external subject should not be authorized externally", func() {
+			_, err := r.EnforceRulesOnFilter(nil, "comment", "bob", UserStateLoggedIn, vars, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(a.req, ShouldBeNil)
+		})
+		Convey("external subject requires authorization service config", func() {
+			_, err := CompileRules(map[string]interface{}{
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{"external": map[string]interface{}{}},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
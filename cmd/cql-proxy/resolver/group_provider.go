@@ -0,0 +1,233 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultGroupCacheTTL defines the default cache ttl of dynamic group membership.
+	defaultGroupCacheTTL = time.Minute
+)
+
+var identifierRegex = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// GroupSourceConfig defines the membership table config for dynamic groups resolution.
+type GroupSourceConfig struct {
+	Table       string `json:"table" validate:"required"`
+	UserColumn  string `json:"user_column" validate:"required"`
+	GroupColumn string `json:"group_column" validate:"required"`
+	TTL         string `json:"ttl"` // membership cache ttl, defaults to 1m
+
+	ttl time.Duration
+}
+
+func compileGroupSource(gs *GroupSourceConfig) (err error) {
+	if gs == nil {
+		return
+	}
+
+	for _, id := range []string{gs.Table, gs.UserColumn, gs.GroupColumn} {
+		if !identifierRegex.MatchString(id) {
+			err = errors.Errorf("invalid group source identifier %s", id)
+			return
+		}
+	}
+
+	if gs.TTL == "" {
+		gs.ttl = defaultGroupCacheTTL
+	} else if gs.ttl, err = time.ParseDuration(gs.TTL); err != nil {
+		err = errors.Wrapf(err, "invalid group source ttl %s", gs.TTL)
+		return
+	} else if gs.ttl < 0 {
+		err = errors.Errorf("invalid group source ttl %s", gs.TTL)
+	}
+
+	return
+}
+
+// GroupProvider defines the dynamic user groups resolver.
+type GroupProvider interface {
+	Groups(uid string) (groups []string, err error)
+}
+
+// GroupQuerier defines the database object to query group membership table.
+type GroupQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type cachedGroups struct {
+	groups  []string
+	expires time.Time
+}
+
+// TableGroupProvider resolves user groups from membership table in project database with cache.
+type TableGroupProvider struct {
+	db    GroupQuerier
+	cfg   *GroupSourceConfig
+	query string
+
+	cacheLock sync.Mutex
+	cache     map[string]*cachedGroups
+}
+
+// NewTableGroupProvider returns new membership table group provider.
+func NewTableGroupProvider(db GroupQuerier, cfg *GroupSourceConfig) *TableGroupProvider {
+	return &TableGroupProvider{
+		db:  db,
+		cfg: cfg,
+		query: fmt.Sprintf(`SELECT DISTINCT "%s" FROM "%s" WHERE "%s" = ?`,
+			cfg.GroupColumn, cfg.Table, cfg.UserColumn),
+		cache: make(map[string]*cachedGroups),
+	}
+}
+
+// Groups implements GroupProvider interface.
+func (p *TableGroupProvider) Groups(uid string) (groups []string, err error) {
+	if uid == "" {
+		// anonymous user
+		return
+	}
+
+	now := time.Now()
+
+	p.cacheLock.Lock()
+	if c, ok := p.cache[uid]; ok && now.Before(c.expires) {
+		p.cacheLock.Unlock()
+		groups = c.groups
+		return
+	}
+	p.cacheLock.Unlock()
+
+	rows, err := p.db.Query(p.query, uid)
+	if err != nil {
+		err = errors.Wrapf(err, "query group membership failed")
+		return
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var g sql.NullString
+		if err = rows.Scan(&g); err != nil {
+			err = errors.Wrapf(err, "scan group membership failed")
+			return
+		}
+		if g.Valid && g.String != "" {
+			groups = append(groups, g.String)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		err = errors.Wrapf(err, "scan group membership failed")
+		return
+	}
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	p.cache[uid] = &cachedGroups{
+		groups:  groups,
+		expires: now.Add(p.cfg.ttl),
+	}
+
+	return
+}
+
+// Invalidate removes the cached groups of user, all users cache is removed for empty uid.
+func (p *TableGroupProvider) Invalidate(uid string) {
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	if uid == "" {
+		p.cache = make(map[string]*cachedGroups)
+	} else {
+		delete(p.cache, uid)
+	}
+}
+
+// ClaimGroupProvider holds the user groups declared in validated identity tokens until the tokens expire.
+type ClaimGroupProvider struct {
+	lock      sync.Mutex
+	groups    map[string]*cachedGroups
+	lastPurge time.Time
+}
+
+// NewClaimGroupProvider returns new token claimed groups provider.
+func NewClaimGroupProvider() *ClaimGroupProvider {
+	return &ClaimGroupProvider{
+		groups: make(map[string]*cachedGroups),
+	}
+}
+
+// Bind sets the claimed groups of user, token without expiration is bound for default group cache ttl.
+func (p *ClaimGroupProvider) Bind(uid string, groups []string, expires time.Time) {
+	if uid == "" {
+		return
+	}
+
+	now := time.Now()
+	if expires.IsZero() {
+		expires = now.Add(defaultGroupCacheTTL)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(groups) == 0 {
+		delete(p.groups, uid)
+	} else {
+		p.groups[uid] = &cachedGroups{
+			groups:  groups,
+			expires: expires,
+		}
+	}
+
+	// purge expired bindings periodically
+	if now.Sub(p.lastPurge) < defaultGroupCacheTTL {
+		return
+	}
+
+	p.lastPurge = now
+
+	for k, c := range p.groups {
+		if now.After(c.expires) {
+			delete(p.groups, k)
+		}
+	}
+}
+
+// Groups implements GroupProvider interface.
+func (p *ClaimGroupProvider) Groups(uid string) (groups []string, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if c, ok := p.groups[uid]; ok && time.Now().Before(c.expires) {
+		groups = c.groups
+	}
+
+	return
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/CovenantSQL/go-sqlite3-encrypt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompileGroupSource(t *testing.T) {
+	Convey("compile group source", t, func() {
+		gs := &GroupSourceConfig{Table: "members", UserColumn: "uid", GroupColumn: "gid"}
+		So(compileGroupSource(gs), ShouldBeNil)
+		So(gs.ttl, ShouldEqual, defaultGroupCacheTTL)
+
+		gs.TTL = "10s"
+		So(compileGroupSource(gs), ShouldBeNil)
+		So(gs.ttl, ShouldEqual, 10*time.Second)
+
+		for _, gs := range []*GroupSourceConfig{
+			{Table: `members"`, UserColumn: "uid", GroupColumn: "gid"},
+			{Table: "members", UserColumn: "1uid", GroupColumn: "gid"},
+			{Table: "members", UserColumn: "uid", GroupColumn: ""},
+			{Table: "members", UserColumn: "uid", GroupColumn: "gid", TTL: "invalid"},
+			{Table: "members", UserColumn: "uid", GroupColumn: "gid", TTL: "-1s"},
+		} {
+			So(compileGroupSource(gs), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestTableGroupProvider(t *testing.T) {
+	Convey("table group provider", t, func() {
+		dir, err := ioutil.TempDir("", "group_provider_test_")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "groups.db3"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		for _, q := range []string{
+			`CREATE TABLE "members" ("uid" TEXT, "gid" TEXT)`,
+			`INSERT INTO "members" VALUES ('alice', 'admin'), ('alice', 'staff'), ('alice', 'admin'),
+				('bob', 'staff'), ('bob', NULL), ('bob', '')`,
+		} {
+			_, err = db.Exec(q)
+			So(err, ShouldBeNil)
+		}
+
+		gs := &GroupSourceConfig{Table: "members", UserColumn: "uid", GroupColumn: "gid", TTL: "1h"}
+		So(compileGroupSource(gs), ShouldBeNil)
+		p := NewTableGroupProvider(db, gs)
+
+		groups, err := p.Groups("alice")
+		So(err, ShouldBeNil)
+		So(groups, ShouldHaveLength, 2)
+		So(groups, ShouldContain, "admin")
+		So(groups, ShouldContain, "staff")
+
+		groups, err = p.Groups("bob")
+		So(err, ShouldBeNil)
+		So(groups, ShouldResemble, []string{"staff"})
+
+		groups, err = p.Groups("")
+		So(err, ShouldBeNil)
+		So(groups, ShouldBeEmpty)
+
+		// membership is cached until invalidated
+		_, err = db.Exec(`INSERT INTO "members" VALUES ('bob', 'admin')`)
+		So(err, ShouldBeNil)
+		groups, err = p.Groups("bob")
+		So(err, ShouldBeNil)
+		So(groups, ShouldResemble, []string{"staff"})
+
+		p.Invalidate("bob")
+		groups, err = p.Groups("bob")
+		So(err, ShouldBeNil)
+		So(groups, ShouldHaveLength, 2)
+		So(groups, ShouldContain, "admin")
+
+		Convey("dynamic groups should be used in rules enforcement", func() {
+			r := mustCompileRules(map[string]interface{}{
+				"group_source": map[string]interface{}{
+					"table": "members", "user_column": "uid", "group_column": "gid",
+				},
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{
+							"g:admin": map[string]interface{}{},
+							"default": nil,
+						},
+					},
+				},
+			})
+			r.SetGroupProvider(p)
+
+			_, err = r.EnforceRulesOnFilter(nil, "article", "alice", UserStateLoggedIn, nil, RuleQueryFind)
+			So(err, ShouldBeNil)
+			_, err = r.EnforceRulesOnFilter(nil, "article", "carol", UserStateLoggedIn, nil, RuleQueryFind)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("membership query failure should be reported", func() {
+			_, err = db.Exec(`DROP TABLE "members"`)
+			So(err, ShouldBeNil)
+			p.Invalidate("")
+			_, err = p.Groups("alice")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
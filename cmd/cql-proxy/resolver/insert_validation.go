@@ -0,0 +1,247 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// insertValidateKey defines the key of validation constraints in insert rules.
+	insertValidateKey = "$validate"
+)
+
+// FieldConstraint defines the validation constraint of single field in insert rules.
+type FieldConstraint struct {
+	Type      string        `json:"type,omitempty"` // string/number/integer/boolean
+	Regex     string        `json:"regex,omitempty"`
+	Min       *float64      `json:"min,omitempty"`
+	Max       *float64      `json:"max,omitempty"`
+	Enum      []interface{} `json:"enum,omitempty"`
+	Required  bool          `json:"required,omitempty"`
+	Forbidden bool          `json:"forbidden,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// InsertConstraints defines the compiled field constraints of insert rules.
+type InsertConstraints map[string]*FieldConstraint
+
+// ValidationError defines the field-level validation error of insert data.
+type ValidationError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+// Error implements error interface.
+func (e *ValidationError) Error() string {
+	var msgs []string
+
+	for field, msg := range e.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", field, msg))
+	}
+
+	sort.Strings(msgs)
+
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func compileInsertConstraints(v interface{}) (ic InsertConstraints, err error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		err = errors.Wrapf(err, "encode %s failed", insertValidateKey)
+		return
+	}
+
+	if err = json.Unmarshal(raw, &ic); err != nil {
+		err = errors.Wrapf(err, "%s requires object of field constraints", insertValidateKey)
+		return
+	}
+
+	for field, fc := range ic {
+		if fc == nil {
+			err = errors.Errorf("%s: empty constraint", field)
+			return
+		}
+
+		switch fc.Type {
+		case "", "string", "number", "integer", "boolean":
+		default:
+			err = errors.Errorf("%s: invalid type constraint %s", field, fc.Type)
+			return
+		}
+
+		if fc.Required && fc.Forbidden {
+			err = errors.Errorf("%s: field could not be both required and forbidden", field)
+			return
+		}
+
+		if fc.Min != nil && fc.Max != nil && *fc.Min > *fc.Max {
+			err = errors.Errorf("%s: min is larger than max", field)
+			return
+		}
+
+		if fc.Regex != "" {
+			if fc.regex, err = regexp.Compile(fc.Regex); err != nil {
+				err = errors.Wrapf(err, "%s: invalid regex constraint", field)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Validate checks the insert data with constraints, and returns ValidationError with all failed fields.
+func (ic InsertConstraints) Validate(d map[string]interface{}) (err error) {
+	fields := map[string]string{}
+
+	for field, fc := range ic {
+		v, exists := d[field]
+
+		if fc.Forbidden {
+			if exists {
+				fields[field] = "field is forbidden"
+			}
+			continue
+		}
+
+		if !exists || v == nil {
+			if fc.Required {
+				fields[field] = "field is required"
+			}
+			continue
+		}
+
+		if msg := fc.check(v); msg != "" {
+			fields[field] = msg
+		}
+	}
+
+	if len(fields) > 0 {
+		err = &ValidationError{Fields: fields}
+	}
+
+	return
+}
+
+func (fc *FieldConstraint) check(v interface{}) string {
+	switch fc.Type {
+	case "string":
+		if !isString(v) {
+			return "requires string value"
+		}
+	case "number":
+		if !isNumber(v) {
+			return "requires number value"
+		}
+	case "integer":
+		if !isNumber(v) || math.Trunc(asFloat(v)) != asFloat(v) {
+			return "requires integer value"
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return "requires boolean value"
+		}
+	}
+
+	if fc.regex != nil {
+		if s, ok := v.(string); !ok || !fc.regex.MatchString(s) {
+			return fmt.Sprintf("does not match pattern %s", fc.Regex)
+		}
+	}
+
+	if fc.Min != nil || fc.Max != nil {
+		if !isNumber(v) {
+			return "requires number value for range constraint"
+		}
+		if fc.Min != nil && asFloat(v) < *fc.Min {
+			return fmt.Sprintf("must be greater than or equal to %v", *fc.Min)
+		}
+		if fc.Max != nil && asFloat(v) > *fc.Max {
+			return fmt.Sprintf("must be less than or equal to %v", *fc.Max)
+		}
+	}
+
+	if len(fc.Enum) > 0 {
+		matched := false
+		for _, e := range fc.Enum {
+			if isNumber(e) && isNumber(v) {
+				matched = asFloat(e) == asFloat(v)
+			} else {
+				matched = reflect.DeepEqual(e, v)
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return "value is not in enum"
+		}
+	}
+
+	return ""
+}
+
+// splitInsertRule separates validation constraints from insert rule default values.
+func splitInsertRule(rule map[string]interface{}) (values map[string]interface{}, ic InsertConstraints) {
+	if v, ok := rule[insertValidateKey]; ok {
+		ic, _ = v.(InsertConstraints)
+
+		values = make(map[string]interface{}, len(rule))
+		for k, v := range rule {
+			if k != insertValidateKey {
+				values[k] = v
+			}
+		}
+
+		return
+	}
+
+	values = rule
+
+	return
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64,
+		float32, float64:
+		return true
+	}
+
+	return false
+}
+
+func asFloat(v interface{}) float64 {
+	switch d := v.(type) {
+	case float32:
+		return float64(d)
+	case float64:
+		return d
+	}
+
+	return float64(asInt(v))
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInsertConstraints(t *testing.T) {
+	Convey("insert constraints", t, func() {
+		ic, err := compileInsertConstraints(map[string]interface{}{
+			"title":  map[string]interface{}{"type": "string", "regex": "^[a-z]+$", "required": true},
+			"score":  map[string]interface{}{"type": "integer", "min": 0, "max": 100},
+			"rating": map[string]interface{}{"type": "number", "enum": []interface{}{1, 2.5}},
+			"public": map[string]interface{}{"type": "boolean"},
+			"owner":  map[string]interface{}{"forbidden": true},
+		})
+		So(err, ShouldBeNil)
+
+		So(ic.Validate(map[string]interface{}{
+			"title":  "hello",
+			"score":  int64(10),
+			"rating": 2.5,
+			"public": true,
+		}), ShouldBeNil)
+		So(ic.Validate(map[string]interface{}{"title": "hello", "rating": int64(1)}), ShouldBeNil)
+
+		err = ic.Validate(map[string]interface{}{
+			"score":  10.5,
+			"rating": 3,
+			"public": "true",
+			"owner":  "alice",
+		})
+		So(err, ShouldNotBeNil)
+		vErr, ok := err.(*ValidationError)
+		So(ok, ShouldBeTrue)
+		So(vErr.Fields, ShouldContainKey, "title")
+		So(vErr.Fields, ShouldContainKey, "score")
+		So(vErr.Fields, ShouldContainKey, "rating")
+		So(vErr.Fields, ShouldContainKey, "public")
+		So(vErr.Fields, ShouldContainKey, "owner")
+		So(vErr.Error(), ShouldStartWith, "validation failed: ")
+
+		for _, d := range []map[string]interface{}{
+			{"title": nil},
+			{"title": 1},
+			{"title": "Hello"},
+			{"title": "hello", "score": -1},
+			{"title": "hello", "score": 101},
+			{"title": "hello", "score": "10"},
+		} {
+			So(ic.Validate(d), ShouldNotBeNil)
+		}
+
+		for _, c := range []map[string]interface{}{
+			{"title": nil},
+			{"title": map[string]interface{}{"type": "date"}},
+			{"title": map[string]interface{}{"required": true, "forbidden": true}},
+			{"title": map[string]interface{}{"min": 10, "max": 1}},
+			{"title": map[string]interface{}{"regex": "("}},
+			{"title": "string"},
+		} {
+			_, err = compileInsertConstraints(c)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestEnforceRulesOnInsertValidation(t *testing.T) {
+	Convey("enforce rules on insert with validation", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"rules": map[string]interface{}{
+				"article": map[string]interface{}{
+					"insert": map[string]interface{}{
+						"default": map[string]interface{}{
+							"owner": "$user_id",
+							"$validate": map[string]interface{}{
+								"title": map[string]interface{}{"type": "string", "required": true},
+								"owner": map[string]interface{}{"forbidden": true},
+							},
+						},
+					},
+				},
+			},
+		})
+		vars := map[string]interface{}{"user_id": "bob"}
+
+		insert, err := r.EnforceRulesOnInsert(map[string]interface{}{"title": "t"},
+			"article", "bob", UserStateLoggedIn, vars)
+		So(err, ShouldBeNil)
+		So(insert, ShouldResemble, map[string]interface{}{"title": "t", "owner": "bob"})
+
+		// constraints are checked against original insert data before defaults are merged
+		_, err = r.EnforceRulesOnInsert(map[string]interface{}{"title": "t", "owner": "alice"},
+			"article", "bob", UserStateLoggedIn, vars)
+		So(err, ShouldNotBeNil)
+		vErr, ok := err.(*ValidationError)
+		So(ok, ShouldBeTrue)
+		So(vErr.Fields, ShouldResemble, map[string]string{"owner": "field is forbidden"})
+
+		_, err = r.EnforceRulesOnInsert(map[string]interface{}{}, "article", "bob", UserStateLoggedIn, vars)
+		So(err, ShouldNotBeNil)
+
+		for _, rule := range []map[string]interface{}{
+			{"$validate": map[string]interface{}{"title": map[string]interface{}{"type": "date"}}},
+			{"$unknown": 1},
+		} {
+			_, err = CompileRules(map[string]interface{}{
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"insert": map[string]interface{}{"default": rule},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
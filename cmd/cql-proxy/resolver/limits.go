@@ -0,0 +1,236 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UnboundedQueryError defines the error returned on unbounded scan from anonymous user.
+type UnboundedQueryError struct {
+	Table     string `json:"table"`
+	QueryType string `json:"type"`
+}
+
+// Error implements error interface.
+func (e *UnboundedQueryError) Error() string {
+	return fmt.Sprintf("unbounded %s query on table %s from anonymous user is not allowed, limit is required",
+		e.QueryType, e.Table)
+}
+
+// use various helper types
+type queryLimits = map[string]*LimitConfig // first dim is group/user/default def
+type tableLimits struct {
+	Find      queryLimits `json:"find"`
+	Count     queryLimits `json:"count"`
+	Remove    queryLimits `json:"remove"`
+	Update    queryLimits `json:"update"`
+	Insert    queryLimits `json:"insert"`
+	Aggregate queryLimits `json:"aggregate"`
+}
+
+// LimitConfig defines the rate and result row limits of query.
+type LimitConfig struct {
+	Rate  float64 `json:"rate"`  // query count allowed in each period, 0 for unlimited
+	Per   string  `json:"per"`   // period of rate, defaults to 1s
+	Burst int64   `json:"burst"` // max burst query count, defaults to ceil of rate
+	// max rows returned in single find query, 0 for unlimited
+	MaxRows int64 `json:"max_rows"`
+	// limit applied to find query without limit, 0 for no default
+	DefaultLimit int64 `json:"default_limit"`
+
+	period time.Duration
+}
+
+// Period returns the parsed rate period of limit.
+func (l *LimitConfig) Period() time.Duration {
+	return l.period
+}
+
+// QueryLimits defines limits for specified query type.
+type QueryLimits struct {
+	groupLimits     map[string]*LimitConfig
+	userLimits      map[string]*LimitConfig
+	userStateLimits map[string]*LimitConfig
+	defaultLimits   *LimitConfig
+}
+
+func compileLimitConfig(l *LimitConfig) (err error) {
+	if l == nil {
+		return
+	}
+
+	if l.Rate < 0 || l.Burst < 0 || l.MaxRows < 0 || l.DefaultLimit < 0 {
+		err = errors.New("negative rate/burst/max_rows/default_limit limit")
+		return
+	}
+
+	if l.MaxRows > 0 && l.DefaultLimit > l.MaxRows {
+		err = errors.Errorf("default limit %d exceeds max rows %d", l.DefaultLimit, l.MaxRows)
+		return
+	}
+
+	if l.Per == "" {
+		l.period = time.Second
+	} else if l.period, err = time.ParseDuration(l.Per); err != nil {
+		err = errors.Wrapf(err, "invalid limit period %s", l.Per)
+		return
+	} else if l.period <= 0 {
+		err = errors.Errorf("invalid limit period %s", l.Per)
+		return
+	}
+
+	if l.Rate > 0 && l.Burst == 0 {
+		l.Burst = int64(l.Rate)
+		if float64(l.Burst) < l.Rate {
+			l.Burst++
+		}
+	}
+
+	return
+}
+
+func compileQueryLimits(cfg *RulesConfig, limits queryLimits) (ql *QueryLimits, err error) {
+	ql = &QueryLimits{
+		groupLimits:     make(map[string]*LimitConfig),
+		userLimits:      make(map[string]*LimitConfig),
+		userStateLimits: make(map[string]*LimitConfig),
+	}
+
+	for limitSubject, l := range limits {
+		if err = compileLimitConfig(l); err != nil {
+			err = errors.Wrapf(err, "%s: invalid limit", limitSubject)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(limitSubject, "g:"):
+			groupName := limitSubject[2:]
+
+			if !cfg.hasGroup(groupName) {
+				err = errors.Errorf("%s: unknown group", groupName)
+				return
+			}
+
+			ql.groupLimits[groupName] = l
+		case strings.HasPrefix(limitSubject, "u:"):
+			userName := limitSubject[2:]
+
+			if userName == "" {
+				err = errors.New("invalid empty user name")
+				return
+			}
+
+			ql.userLimits[userName] = l
+		case strings.HasPrefix(limitSubject, "s:"):
+			userState := strings.ToLower(limitSubject[2:])
+
+			if !isValidUserState(cfg, userState) {
+				err = errors.Errorf("invalid user state %s", userState)
+				return
+			}
+
+			ql.userStateLimits[userState] = l
+		case limitSubject == "default":
+			ql.defaultLimits = l
+		default:
+			err = errors.Errorf("%s: invalid limit type", limitSubject)
+			return
+		}
+	}
+
+	return
+}
+
+// FindLimit returns the most specific limit config of query for specified user,
+// user limit precedes group limits, group limits precedes user state limit.
+func (r *Rules) FindLimit(table string, qt RuleQueryType, uid string, userState string) (
+	subject string, l *LimitConfig) {
+	tableLimits, ok := r.limits[table]
+	if !ok || tableLimits == nil {
+		return
+	}
+
+	ql, ok := tableLimits[qt]
+	if !ok || ql == nil {
+		return
+	}
+
+	if l, ok = ql.userLimits[uid]; ok && l != nil {
+		subject = "u:" + uid
+		return
+	}
+
+	// limits are best effort, use static groups only if dynamic groups resolution failed
+	groups, err := r.groupsOf(uid)
+	if err != nil {
+		groups = r.userGroups[uid]
+	}
+
+	for _, g := range groups {
+		if l, ok = ql.groupLimits[g]; ok && l != nil {
+			subject = "g:" + g
+			return
+		}
+	}
+
+	if l, ok = ql.userStateLimits[userState]; ok && l != nil {
+		subject = "s:" + userState
+		return
+	}
+
+	if ql.defaultLimits != nil {
+		subject = "default"
+		l = ql.defaultLimits
+	}
+
+	return
+}
+
+// ResolveRowLimit returns the effective row limit of query for specified user,
+// the requested limit defaults to default_limit and is capped by max_rows of the most specific limit config.
+// Unbounded scans from anonymous user are refused.
+func (r *Rules) ResolveRowLimit(table string, qt RuleQueryType, uid string, userState string, limit *int64) (
+	rowLimit *int64, err error) {
+	rowLimit = limit
+
+	if r != nil {
+		if _, lc := r.FindLimit(table, qt, uid, userState); lc != nil {
+			if rowLimit == nil && lc.DefaultLimit > 0 {
+				defaultLimit := lc.DefaultLimit
+				rowLimit = &defaultLimit
+			}
+			if lc.MaxRows > 0 && (rowLimit == nil || *rowLimit > lc.MaxRows) {
+				maxRows := lc.MaxRows
+				rowLimit = &maxRows
+			}
+		}
+	}
+
+	if rowLimit == nil && userState == UserStateAnonymous {
+		err = &UnboundedQueryError{
+			Table:     table,
+			QueryType: qt.String(),
+		}
+	}
+
+	return
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResolveRowLimit(t *testing.T) {
+	Convey("resolve row limit", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"limits": map[string]interface{}{
+				"article": map[string]interface{}{
+					"find": map[string]interface{}{
+						"u:alice": map[string]interface{}{"max_rows": 1000},
+						"default": map[string]interface{}{"max_rows": 100, "default_limit": 20},
+					},
+				},
+			},
+		})
+		limit := func(l int64) *int64 { return &l }
+
+		rowLimit, err := r.ResolveRowLimit("article", RuleQueryFind, "bob", UserStateLoggedIn, nil)
+		So(err, ShouldBeNil)
+		So(rowLimit, ShouldResemble, limit(20))
+
+		rowLimit, err = r.ResolveRowLimit("article", RuleQueryFind, "bob", UserStateLoggedIn, limit(50))
+		So(err, ShouldBeNil)
+		So(rowLimit, ShouldResemble, limit(50))
+
+		rowLimit, err = r.ResolveRowLimit("article", RuleQueryFind, "bob", UserStateLoggedIn, limit(500))
+		So(err, ShouldBeNil)
+		So(rowLimit, ShouldResemble, limit(100))
+
+		// the most specific limit config takes effect
+		rowLimit, err = r.ResolveRowLimit("article", RuleQueryFind, "alice", UserStateLoggedIn, nil)
+		So(err, ShouldBeNil)
+		So(rowLimit, ShouldResemble, limit(1000))
+
+		rowLimit, err = r.ResolveRowLimit("article", RuleQueryFind, "alice", UserStateLoggedIn, limit(500))
+		So(err, ShouldBeNil)
+		So(rowLimit, ShouldResemble, limit(500))
+
+		rowLimit, err = r.ResolveRowLimit("article", RuleQueryFind, "", UserStateAnonymous, nil)
+		So(err, ShouldBeNil)
+		So(rowLimit, ShouldResemble, limit(20))
+
+		Convey("unbounded scan from anonymous user should be refused", func() {
+			rowLimit, err = r.ResolveRowLimit("user", RuleQueryFind, "bob", UserStateLoggedIn, nil)
+			So(err, ShouldBeNil)
+			So(rowLimit, ShouldBeNil)
+
+			_, err = r.ResolveRowLimit("user", RuleQueryFind, "", UserStateAnonymous, nil)
+			So(err, ShouldNotBeNil)
+			uErr, ok := err.(*UnboundedQueryError)
+			So(ok, ShouldBeTrue)
+			So(uErr.Table, ShouldEqual, "user")
+			So(uErr.QueryType, ShouldEqual, RuleQueryFind.String())
+
+			rowLimit, err = r.ResolveRowLimit("user", RuleQueryFind, "", UserStateAnonymous, limit(10))
+			So(err, ShouldBeNil)
+			So(rowLimit, ShouldResemble, limit(10))
+
+			_, err = (*Rules)(nil).ResolveRowLimit("user", RuleQueryFind, "", UserStateAnonymous, nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -16,7 +16,115 @@
 
 package resolver
 
-import "strings"
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// MagicVarContext defines the request context for magic variables resolution.
+type MagicVarContext struct {
+	DatabaseID proto.DatabaseID
+	Table      string
+	UserID     string
+	UserState  string
+	Request    *http.Request
+	Vars       map[string]interface{} // resolved builtin user variables
+}
+
+// MagicVarResolver defines the callback to resolve magic variable value for each request.
+type MagicVarResolver func(ctx *MagicVarContext) (v interface{}, err error)
+
+var (
+	magicVarNameRegex = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+	// builtin variables populated by proxy from user info
+	builtinMagicVars = map[string]bool{
+		"user_id":         true,
+		"user_name":       true,
+		"user_email":      true,
+		"user_provider":   true,
+		"user_created":    true,
+		"user_last_login": true,
+		"user_state":      true,
+	}
+
+	magicVarsLock sync.RWMutex
+	magicVars     = map[string]MagicVarResolver{}
+)
+
+func init() {
+	_ = RegisterMagicVar("now_utc", func(*MagicVarContext) (interface{}, error) {
+		return time.Now().UTC().Format("2006-01-02 15:04:05"), nil
+	})
+	_ = RegisterMagicVar("now_unix", func(*MagicVarContext) (interface{}, error) {
+		return time.Now().Unix(), nil
+	})
+}
+
+// RegisterMagicVar registers custom magic variable resolved by callback for each request,
+// variable is referenced as "$name" or "${name}" in rules.
+func RegisterMagicVar(name string, resolver MagicVarResolver) (err error) {
+	if !magicVarNameRegex.MatchString(name) {
+		err = errors.Errorf("invalid magic variable name %s", name)
+		return
+	}
+	if builtinMagicVars[name] {
+		err = errors.Errorf("magic variable %s conflicts with builtin variable", name)
+		return
+	}
+	if resolver == nil {
+		err = errors.Errorf("nil resolver of magic variable %s", name)
+		return
+	}
+
+	magicVarsLock.Lock()
+	defer magicVarsLock.Unlock()
+
+	if _, ok := magicVars[name]; ok {
+		err = errors.Errorf("magic variable %s already registered", name)
+		return
+	}
+
+	magicVars[name] = resolver
+
+	return
+}
+
+// UnregisterMagicVar removes the custom magic variable.
+func UnregisterMagicVar(name string) {
+	magicVarsLock.Lock()
+	defer magicVarsLock.Unlock()
+
+	delete(magicVars, name)
+}
+
+// ResolveMagicVars resolves all registered custom magic variables and merges them with builtin variables.
+func ResolveMagicVars(ctx *MagicVarContext) (vars map[string]interface{}, err error) {
+	magicVarsLock.RLock()
+	defer magicVarsLock.RUnlock()
+
+	vars = make(map[string]interface{}, len(ctx.Vars)+len(magicVars))
+
+	for k, v := range ctx.Vars {
+		vars[k] = v
+	}
+
+	for name, resolver := range magicVars {
+		if vars[name], err = resolver(ctx); err != nil {
+			err = errors.Wrapf(err, "resolve magic variable %s failed", name)
+			return
+		}
+	}
+
+	return
+}
 
 // InjectMagicVars replaces the variables symbol in query to real value.
 func InjectMagicVars(q map[string]interface{}, vars map[string]interface{}) (
@@ -46,10 +154,12 @@ func processInject(v interface{}, vars map[string]interface{}) (r interface{}) {
 		r = subQueryList
 	case map[string]interface{}:
 		return InjectMagicVars(rv, vars)
+	case *SQLPredicate:
+		r = rv.Bind(vars)
 	case string:
-		if !strings.HasPrefix("$", rv) {
+		if !strings.HasPrefix(rv, "$") {
 			r = v
-		} else if injectedVar, ok := vars[rv[1:]]; !ok {
+		} else if injectedVar, ok := vars[magicVarName(rv)]; !ok {
 			r = v
 		} else {
 			r = injectedVar
@@ -61,3 +171,12 @@ func processInject(v interface{}, vars map[string]interface{}) (r interface{}) {
 
 	return
 }
+
+func magicVarName(s string) string {
+	// both $name and ${name} form is supported
+	if strings.HasPrefix(s, "${") && strings.HasSuffix(s, "}") {
+		return s[2 : len(s)-1]
+	}
+
+	return s[1:]
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMagicVarsRegistry(t *testing.T) {
+	Convey("magic vars registry", t, func() {
+		resolveTenant := func(ctx *MagicVarContext) (interface{}, error) {
+			return "tenant_" + ctx.UserID, nil
+		}
+
+		So(RegisterMagicVar("tenant_id", resolveTenant), ShouldBeNil)
+		defer UnregisterMagicVar("tenant_id")
+
+		So(RegisterMagicVar("tenant_id", resolveTenant), ShouldNotBeNil)
+		So(RegisterMagicVar("user_id", resolveTenant), ShouldNotBeNil)
+		So(RegisterMagicVar("1tenant", resolveTenant), ShouldNotBeNil)
+		So(RegisterMagicVar("tenant-id", resolveTenant), ShouldNotBeNil)
+		So(RegisterMagicVar("tenant", nil), ShouldNotBeNil)
+
+		ctx := &MagicVarContext{
+			UserID: "alice",
+			Vars:   map[string]interface{}{"user_id": "alice"},
+		}
+		vars, err := ResolveMagicVars(ctx)
+		So(err, ShouldBeNil)
+		So(vars["user_id"], ShouldEqual, "alice")
+		So(vars["tenant_id"], ShouldEqual, "tenant_alice")
+		So(vars, ShouldContainKey, "now_utc")
+		So(vars, ShouldContainKey, "now_unix")
+
+		// builtin variables of context are not modified
+		So(ctx.Vars, ShouldResemble, map[string]interface{}{"user_id": "alice"})
+
+		So(InjectMagicVars(map[string]interface{}{"tenant": "${tenant_id}"}, vars), ShouldResemble,
+			map[string]interface{}{"tenant": "tenant_alice"})
+
+		Convey("failed resolver should fail the resolution", func() {
+			So(RegisterMagicVar("broken", func(*MagicVarContext) (interface{}, error) {
+				return nil, errors.New("broken")
+			}), ShouldBeNil)
+			defer UnregisterMagicVar("broken")
+
+			_, err = ResolveMagicVars(ctx)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("unregistered variable should not be resolved", func() {
+			UnregisterMagicVar("tenant_id")
+			vars, err = ResolveMagicVars(ctx)
+			So(err, ShouldBeNil)
+			So(vars, ShouldNotContainKey, "tenant_id")
+			So(RegisterMagicVar("tenant_id", resolveTenant), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaskActionStrip removes the column from query result.
+	MaskActionStrip = "strip"
+	// MaskActionHash replaces the column value with hex encoded sha256 hash.
+	MaskActionHash = "hash"
+	// MaskActionNull replaces the column value with null.
+	MaskActionNull = "null"
+	// MaskActionRedact replaces the column value with fixed placeholder.
+	MaskActionRedact = "redact"
+
+	redactPlaceholder = "******"
+)
+
+// ColumnMask defines the column masking actions applied to find/count query.
+type ColumnMask map[string]string // column name to mask action
+
+// FilterAvailFields returns available fields with stripped columns removed.
+func (m ColumnMask) FilterAvailFields(availFields FieldMap) (fields FieldMap) {
+	fields = FieldMap{}
+
+	for k := range availFields {
+		if m[k] != MaskActionStrip {
+			fields[k] = true
+		}
+	}
+
+	return
+}
+
+// ExcludeAvailFields returns available fields with all masked columns removed.
+func (m ColumnMask) ExcludeAvailFields(availFields FieldMap) (fields FieldMap) {
+	fields = FieldMap{}
+
+	for k := range availFields {
+		if _, ok := m[k]; !ok {
+			fields[k] = true
+		}
+	}
+
+	return
+}
+
+// CheckFilter ensures no masked columns is referenced in filter to prevent masked value exposure.
+func (m ColumnMask) CheckFilter(filter map[string]interface{}, availFields FieldMap) (err error) {
+	if len(m) == 0 {
+		return
+	}
+
+	fields, _, _, err := ResolveFilter(filter, availFields)
+	if err != nil {
+		return
+	}
+
+	for k := range fields {
+		if _, ok := m[k]; ok {
+			err = errors.Errorf("%s: could not filter on masked column", k)
+			return
+		}
+	}
+
+	return
+}
+
+// Apply masks the columns of the row in-place.
+func (m ColumnMask) Apply(row map[string]interface{}) {
+	for col, action := range m {
+		v, ok := row[col]
+		if !ok {
+			continue
+		}
+
+		switch action {
+		case MaskActionStrip:
+			delete(row, col)
+		case MaskActionNull:
+			row[col] = nil
+		case MaskActionRedact:
+			if v != nil {
+				row[col] = redactPlaceholder
+			}
+		case MaskActionHash:
+			if v != nil {
+				h := sha256.Sum256([]byte(fmt.Sprint(v)))
+				row[col] = hex.EncodeToString(h[:])
+			}
+		}
+	}
+}
+
+func parseMaskAction(v interface{}) (action string, err error) {
+	switch rv := v.(type) {
+	case string:
+		action = strings.ToLower(rv)
+
+		switch action {
+		case MaskActionStrip, MaskActionHash, MaskActionNull, MaskActionRedact:
+		default:
+			err = errors.Errorf("invalid mask action %s", rv)
+		}
+	default:
+		if !isLiteral(v) && v != nil {
+			if _, ok := v.(bool); !ok {
+				err = errors.Errorf("invalid mask action %v", v)
+				return
+			}
+		}
+
+		if v == nil || !asBool(v) {
+			// falsy values means the column is not visible
+			action = MaskActionStrip
+		}
+	}
+
+	return
+}
+
+func buildColumnMask(q ...map[string]interface{}) (m ColumnMask, err error) {
+	m = ColumnMask{}
+
+	for col, v := range mergeInsert(q...) {
+		if strings.HasPrefix(col, "$") {
+			err = errors.Errorf("invalid mask column %s", col)
+			return
+		}
+
+		var action string
+		if action, err = parseMaskAction(v); err != nil {
+			return
+		}
+
+		if action != "" {
+			// truthy values means column is visible
+			m[col] = action
+		}
+	}
+
+	return
+}
+
+func validateMaskRules(rules *QueryRules) (err error) {
+	if rules == nil {
+		return
+	}
+
+	for _, stateRules := range rules.userStateRules {
+		if _, err = buildColumnMask(stateRules); err != nil {
+			return
+		}
+	}
+
+	for _, groupRules := range rules.groupRules {
+		if _, err = buildColumnMask(groupRules); err != nil {
+			return
+		}
+	}
+
+	for _, userRules := range rules.userRules {
+		if _, err = buildColumnMask(userRules); err != nil {
+			return
+		}
+	}
+
+	_, err = buildColumnMask(rules.defaultRules)
+
+	return
+}
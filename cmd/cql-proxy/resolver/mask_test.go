@@ -0,0 +1,138 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestColumnMask(t *testing.T) {
+	Convey("column mask", t, func() {
+		m := ColumnMask{
+			"a": MaskActionStrip,
+			"b": MaskActionHash,
+			"c": MaskActionNull,
+			"d": MaskActionRedact,
+		}
+		availFields := FieldMap{"a": true, "b": true, "c": true, "d": true, "e": true}
+
+		So(m.FilterAvailFields(availFields), ShouldResemble, FieldMap{"b": true, "c": true, "d": true, "e": true})
+		So(m.ExcludeAvailFields(availFields), ShouldResemble, FieldMap{"e": true})
+
+		row := map[string]interface{}{"a": 1, "b": "secret", "c": 2, "d": "text", "e": 3}
+		m.Apply(row)
+		h := sha256.Sum256([]byte("secret"))
+		So(row, ShouldResemble, map[string]interface{}{
+			"b": hex.EncodeToString(h[:]),
+			"c": nil,
+			"d": redactPlaceholder,
+			"e": 3,
+		})
+
+		// null values are kept for hash and redact
+		row = map[string]interface{}{"b": nil, "d": nil}
+		m.Apply(row)
+		So(row, ShouldResemble, map[string]interface{}{"b": nil, "d": nil})
+
+		So(m.CheckFilter(map[string]interface{}{"e": 1}, availFields), ShouldBeNil)
+		So(m.CheckFilter(map[string]interface{}{"b": "x"}, availFields), ShouldNotBeNil)
+		So(m.CheckFilter(map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{"e": 1},
+			map[string]interface{}{"c": 1},
+		}}, availFields), ShouldNotBeNil)
+		So(ColumnMask{}.CheckFilter(map[string]interface{}{"b": "x"}, availFields), ShouldBeNil)
+	})
+}
+
+func TestBuildColumnMask(t *testing.T) {
+	Convey("build column mask", t, func() {
+		m, err := buildColumnMask(map[string]interface{}{
+			"a": false,
+			"b": "HASH",
+			"c": true,
+			"d": nil,
+			"e": 0,
+			"f": 1,
+			"g": "redact",
+		})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, ColumnMask{
+			"a": MaskActionStrip,
+			"b": MaskActionHash,
+			"d": MaskActionStrip,
+			"e": MaskActionStrip,
+			"g": MaskActionRedact,
+		})
+
+		for _, o := range []map[string]interface{}{
+			{"a": "unknown"},
+			{"$or": false},
+			{"a": []interface{}{}},
+		} {
+			_, err = buildColumnMask(o)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestEnforceMask(t *testing.T) {
+	Convey("enforce mask", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"groups": map[string]interface{}{
+				"admin": []interface{}{"alice"},
+			},
+			"rules": map[string]interface{}{
+				"users": map[string]interface{}{
+					"mask": map[string]interface{}{
+						"g:admin": map[string]interface{}{},
+						"default": map[string]interface{}{
+							"email": "hash",
+							"phone": false,
+						},
+					},
+				},
+			},
+		})
+
+		m, err := r.EnforceMask("users", "alice", UserStateLoggedIn)
+		So(err, ShouldBeNil)
+		So(m, ShouldBeEmpty)
+
+		m, err = r.EnforceMask("users", "bob", UserStateLoggedIn)
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, ColumnMask{"email": MaskActionHash, "phone": MaskActionStrip})
+
+		m, err = r.EnforceMask("posts", "bob", UserStateLoggedIn)
+		So(err, ShouldBeNil)
+		So(m, ShouldBeEmpty)
+
+		_, err = CompileRules(map[string]interface{}{
+			"rules": map[string]interface{}{
+				"users": map[string]interface{}{
+					"mask": map[string]interface{}{
+						"default": map[string]interface{}{"email": "encrypt"},
+					},
+				},
+			},
+		})
+		So(err, ShouldNotBeNil)
+	})
+}
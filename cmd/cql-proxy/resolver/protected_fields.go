@@ -0,0 +1,155 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProtectedFields defines the fields which could not be updated by user query.
+type ProtectedFields struct {
+	readonly      FieldMap            // table-level readonly fields for all subjects
+	groupFields   map[string]FieldMap // subject-level denied fields
+	userFields    map[string]FieldMap
+	stateFields   map[string]FieldMap
+	defaultFields FieldMap
+}
+
+func compileProtectedFields(cfg *RulesConfig, readonly []string, deny map[string][]string) (
+	pf *ProtectedFields, err error) {
+	pf = &ProtectedFields{
+		readonly:      FieldMap{},
+		groupFields:   make(map[string]FieldMap),
+		userFields:    make(map[string]FieldMap),
+		stateFields:   make(map[string]FieldMap),
+		defaultFields: FieldMap{},
+	}
+
+	toFieldMap := func(fields []string) (fm FieldMap, err error) {
+		fm = FieldMap{}
+		for _, f := range fields {
+			if f == "" || strings.HasPrefix(f, "$") {
+				err = errors.Errorf("invalid protected field %s", f)
+				return
+			}
+			fm[f] = true
+		}
+		return
+	}
+
+	if pf.readonly, err = toFieldMap(readonly); err != nil {
+		return
+	}
+
+	for subject, fields := range deny {
+		var fm FieldMap
+		if fm, err = toFieldMap(fields); err != nil {
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(subject, "g:"):
+			groupName := subject[2:]
+
+			if !cfg.hasGroup(groupName) {
+				err = errors.Errorf("%s: unknown group", groupName)
+				return
+			}
+
+			pf.groupFields[groupName] = fm
+		case strings.HasPrefix(subject, "u:"):
+			userName := subject[2:]
+
+			if userName == "" {
+				err = errors.New("invalid empty user name")
+				return
+			}
+
+			pf.userFields[userName] = fm
+		case strings.HasPrefix(subject, "s:"):
+			userState := strings.ToLower(subject[2:])
+
+			if !isValidUserState(cfg, userState) {
+				err = errors.Errorf("invalid user state %s", userState)
+				return
+			}
+
+			pf.stateFields[userState] = fm
+		case subject == "default":
+			pf.defaultFields = fm
+		default:
+			err = errors.Errorf("%s: invalid deny fields type", subject)
+			return
+		}
+	}
+
+	return
+}
+
+// fieldsFor returns all protected fields for the user, the subject-level fields of state/groups/user
+// are combined and default fields is applied if no subject is matched.
+func (pf *ProtectedFields) fieldsFor(groups []string, uid string, userState string) (fields FieldMap) {
+	fields = FieldMap{}
+	fields.Merge(pf.readonly)
+
+	matched := false
+
+	if fm, ok := pf.stateFields[userState]; ok {
+		matched = true
+		fields.Merge(fm)
+	}
+
+	for _, g := range groups {
+		if fm, ok := pf.groupFields[g]; ok {
+			matched = true
+			fields.Merge(fm)
+		}
+	}
+
+	if fm, ok := pf.userFields[uid]; ok {
+		matched = true
+		fields.Merge(fm)
+	}
+
+	if !matched {
+		fields.Merge(pf.defaultFields)
+	}
+
+	return
+}
+
+// updatedFields returns fields touched by the update object.
+func updatedFields(d map[string]interface{}) (fields FieldMap) {
+	fields = FieldMap{}
+
+	for k, v := range d {
+		if !strings.HasPrefix(k, "$") {
+			fields[k] = true
+			continue
+		}
+
+		if ov, ok := v.(map[string]interface{}); ok && k != "$comment" {
+			for field := range ov {
+				fields[field] = true
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProtectedFields(t *testing.T) {
+	Convey("protected fields", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"groups": map[string]interface{}{
+				"admin": []interface{}{"alice"},
+			},
+			"rules": map[string]interface{}{
+				"article": map[string]interface{}{
+					"update": map[string]interface{}{
+						"update": map[string]interface{}{
+							"default": map[string]interface{}{},
+						},
+						"readonly_fields": []interface{}{"id"},
+						"deny_fields": map[string]interface{}{
+							"g:admin": []interface{}{},
+							"u:carol": []interface{}{"title"},
+							"default": []interface{}{"owner", "created"},
+						},
+					},
+				},
+			},
+		})
+		vars := map[string]interface{}{"user_id": "bob"}
+
+		Convey("readonly fields are protected for all subjects", func() {
+			for _, uid := range []string{"alice", "bob", "carol"} {
+				_, err := r.EnforceRulesOnUpdate(map[string]interface{}{
+					"$set": map[string]interface{}{"id": 1},
+				}, "article", uid, UserStateLoggedIn, vars)
+				So(err, ShouldNotBeNil)
+				vErr, ok := err.(*ValidationError)
+				So(ok, ShouldBeTrue)
+				So(vErr.Fields, ShouldResemble, map[string]string{"id": "field is read-only"})
+			}
+		})
+		Convey("default deny fields are applied if no subject is matched", func() {
+			_, err := r.EnforceRulesOnUpdate(map[string]interface{}{
+				"$set": map[string]interface{}{"owner": "bob", "title": "t"},
+				"$inc": map[string]interface{}{"created": 1},
+			}, "article", "bob", UserStateLoggedIn, vars)
+			So(err, ShouldNotBeNil)
+			vErr, ok := err.(*ValidationError)
+			So(ok, ShouldBeTrue)
+			So(vErr.Fields, ShouldResemble, map[string]string{
+				"owner":   "field is read-only",
+				"created": "field is read-only",
+			})
+
+			_, err = r.EnforceRulesOnUpdate(map[string]interface{}{
+				"$set": map[string]interface{}{"title": "t"},
+			}, "article", "bob", UserStateLoggedIn, vars)
+			So(err, ShouldBeNil)
+		})
+		Convey("subject deny fields replace the default fields", func() {
+			_, err := r.EnforceRulesOnUpdate(map[string]interface{}{
+				"$set": map[string]interface{}{"owner": "bob", "title": "t"},
+			}, "article", "alice", UserStateLoggedIn, vars)
+			So(err, ShouldBeNil)
+
+			_, err = r.EnforceRulesOnUpdate(map[string]interface{}{
+				"$set": map[string]interface{}{"owner": "bob"},
+			}, "article", "carol", UserStateLoggedIn, vars)
+			So(err, ShouldBeNil)
+			_, err = r.EnforceRulesOnUpdate(map[string]interface{}{
+				"$set": map[string]interface{}{"title": "t"},
+			}, "article", "carol", UserStateLoggedIn, vars)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("invalid protected fields should be rejected", func() {
+			for _, update := range []map[string]interface{}{
+				{"readonly_fields": []interface{}{""}},
+				{"readonly_fields": []interface{}{"$set"}},
+				{"deny_fields": map[string]interface{}{"g:unknown": []interface{}{"a"}}},
+				{"deny_fields": map[string]interface{}{"u:": []interface{}{"a"}}},
+				{"deny_fields": map[string]interface{}{"s:unknown": []interface{}{"a"}}},
+				{"deny_fields": map[string]interface{}{"unknown": []interface{}{"a"}}},
+			} {
+				_, err := CompileRules(map[string]interface{}{
+					"rules": map[string]interface{}{
+						"article": map[string]interface{}{"update": update},
+					},
+				})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestUpdatedFields(t *testing.T) {
+	Convey("updated fields", t, func() {
+		So(updatedFields(map[string]interface{}{
+			"a":        1,
+			"$set":     map[string]interface{}{"b": 1},
+			"$inc":     map[string]interface{}{"c": 1},
+			"$comment": map[string]interface{}{"d": 1},
+		}), ShouldResemble, FieldMap{"a": true, "b": true, "c": true})
+	})
+}
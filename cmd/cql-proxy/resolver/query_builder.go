@@ -186,3 +186,56 @@ func Count(table string, availFields FieldMap, filter map[string]interface{}) (
 
 	return
 }
+
+// Aggregate process group by/aggregation query with filter applied.
+func Aggregate(table string, availFields FieldMap, filter map[string]interface{}, groupBy []string,
+	aggregation map[string]interface{}) (
+	statement string, args []interface{}, fields FieldMap, err error) {
+	fields = FieldMap{}
+	statement = `SELECT `
+
+	// group by columns are also projected
+	groupByFields, groupByStatement, err := ResolveGroupBy(groupBy, availFields)
+	if err != nil {
+		err = errors.Wrapf(err, "resolve group by failed")
+		return
+	}
+	fields.Merge(groupByFields)
+
+	if groupByStatement != "" {
+		statement += groupByStatement + ","
+	}
+
+	// aggregation segment
+	aggregationFields, aggregationStatement, err := ResolveAggregation(aggregation, availFields)
+	if err != nil {
+		err = errors.Wrapf(err, "resolve aggregation failed")
+		return
+	}
+	fields.Merge(aggregationFields)
+	statement += aggregationStatement
+
+	// table segment
+	statement += fmt.Sprintf(` FROM "%s" `, table)
+
+	// where segment
+	filterFields, filterStatement, filterArgs, err := ResolveFilter(filter, availFields)
+	if err != nil {
+		err = errors.Wrapf(err, "resolve query filter failed")
+		return
+	}
+	fields.Merge(filterFields)
+	args = append(args, filterArgs...)
+
+	if filterStatement != "" {
+		statement += " WHERE "
+		statement += filterStatement
+	}
+
+	if groupByStatement != "" {
+		statement += " GROUP BY "
+		statement += groupByStatement
+	}
+
+	return
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregate(t *testing.T) {
+	Convey("aggregate query", t, func() {
+		availFields := FieldMap{"author": true, "score": true, "title": true}
+
+		statement, args, fields, err := Aggregate("article", availFields,
+			map[string]interface{}{"title": "t"}, []string{"author"},
+			map[string]interface{}{"total": map[string]interface{}{"$sum": "score"}})
+		So(err, ShouldBeNil)
+		So(statement, ShouldEqual,
+			`SELECT "author",SUM("score") AS "total" FROM "article"  WHERE ("title" = ?) GROUP BY "author"`)
+		So(args, ShouldResemble, []interface{}{"t"})
+		So(fields, ShouldResemble, FieldMap{"author": true, "score": true, "title": true})
+
+		statement, args, _, err = Aggregate("article", availFields, nil, nil,
+			map[string]interface{}{"cnt": map[string]interface{}{"$count": "*"}})
+		So(err, ShouldBeNil)
+		So(statement, ShouldEqual, `SELECT COUNT(1) AS "cnt" FROM "article" `)
+		So(args, ShouldBeEmpty)
+
+		for _, c := range []struct {
+			groupBy     []string
+			aggregation map[string]interface{}
+		}{
+			{[]string{"unknown"}, map[string]interface{}{"cnt": map[string]interface{}{"$count": "*"}}},
+			{nil, nil},
+			{nil, map[string]interface{}{"$cnt": map[string]interface{}{"$count": "*"}}},
+			{nil, map[string]interface{}{`c"nt`: map[string]interface{}{"$count": "*"}}},
+			{nil, map[string]interface{}{"cnt": "score"}},
+			{nil, map[string]interface{}{"cnt": map[string]interface{}{"$count": "*", "$sum": "score"}}},
+			{nil, map[string]interface{}{"cnt": map[string]interface{}{"$median": "score"}}},
+			{nil, map[string]interface{}{"cnt": map[string]interface{}{"$sum": 1}}},
+			{nil, map[string]interface{}{"cnt": map[string]interface{}{"$sum": "unknown"}}},
+		} {
+			_, _, _, err = Aggregate("article", availFields, nil, c.groupBy, c.aggregation)
+			So(err, ShouldNotBeNil)
+		}
+	})
+	Convey("aggregate rules should be enforced separately", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"rules": map[string]interface{}{
+				"article": map[string]interface{}{
+					"find": map[string]interface{}{
+						"default": map[string]interface{}{"owner": "$user_id"},
+					},
+					"aggregate": map[string]interface{}{
+						"default": nil,
+					},
+				},
+			},
+		})
+		vars := map[string]interface{}{"user_id": "bob"}
+
+		_, err := r.EnforceRulesOnFilter(nil, "article", "bob", UserStateLoggedIn, vars, RuleQueryFind)
+		So(err, ShouldBeNil)
+		_, err = r.EnforceRulesOnFilter(nil, "article", "bob", UserStateLoggedIn, vars, RuleQueryAggregate)
+		So(err, ShouldNotBeNil)
+		So(RuleQueryAggregate.String(), ShouldEqual, "aggregate")
+	})
+}
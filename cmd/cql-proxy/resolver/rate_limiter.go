@@ -0,0 +1,141 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// QuotaError defines the structured error returned on query limit exceeded.
+type QuotaError struct {
+	Table      string        `json:"table"`
+	QueryType  string        `json:"type"`
+	Subject    string        `json:"subject"`
+	Rate       float64       `json:"rate,omitempty"`
+	Per        string        `json:"per,omitempty"`
+	Rows       int64         `json:"rows,omitempty"`
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+}
+
+// Error implements error interface.
+func (e *QuotaError) Error() string {
+	if e.Rows > 0 {
+		return fmt.Sprintf("%s query on table %s exceeds row limit %d of %s",
+			e.QueryType, e.Table, e.Rows, e.Subject)
+	}
+
+	return fmt.Sprintf("%s query on table %s exceeds rate limit %v/%s of %s, retry after %v",
+		e.QueryType, e.Table, e.Rate, e.Per, e.Subject, e.RetryAfter)
+}
+
+type tokenBucket struct {
+	sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter defines the token bucket rate limiter of rules limits.
+type RateLimiter struct {
+	buckets sync.Map // map[string]*tokenBucket
+}
+
+// NewRateLimiter returns new rate limiter object.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{}
+}
+
+// Allow consumes one token from bucket of the client, client is the key of user or remote address of
+// anonymous user, returns QuotaError if rate limit is exceeded.
+func (l *RateLimiter) Allow(dbID proto.DatabaseID, r *Rules, table string, qt RuleQueryType,
+	uid string, userState string, client string) (err error) {
+	if r == nil {
+		return
+	}
+
+	subject, lc := r.FindLimit(table, qt, uid, userState)
+	if lc == nil || lc.Rate <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%d/%s/%s", dbID, table, qt, subject, client)
+	v, _ := l.buckets.LoadOrStore(key, &tokenBucket{
+		tokens: float64(lc.Burst),
+		last:   time.Now(),
+	})
+	b := v.(*tokenBucket)
+
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	ratePerSecond := lc.Rate / lc.Period().Seconds()
+	b.tokens = math.Min(float64(lc.Burst), b.tokens+now.Sub(b.last).Seconds()*ratePerSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		err = &QuotaError{
+			Table:      table,
+			QueryType:  qt.String(),
+			Subject:    subject,
+			Rate:       lc.Rate,
+			Per:        lc.Period().String(),
+			RetryAfter: time.Duration((1 - b.tokens) / ratePerSecond * float64(time.Second)),
+		}
+		return
+	}
+
+	b.tokens--
+
+	return
+}
+
+// Purge removes the buckets which are idle for more than the ttl duration.
+func (l *RateLimiter) Purge(ttl time.Duration) {
+	now := time.Now()
+
+	l.buckets.Range(func(key, value interface{}) bool {
+		b := value.(*tokenBucket)
+		b.Lock()
+		idle := now.Sub(b.last) > ttl
+		b.Unlock()
+
+		if idle {
+			l.buckets.Delete(key)
+		}
+
+		return true
+	})
+}
+
+// Reset removes all the buckets of specified database, used on rules limits update.
+func (l *RateLimiter) Reset(dbID proto.DatabaseID) {
+	prefix := string(dbID) + "/"
+
+	l.buckets.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			l.buckets.Delete(key)
+		}
+
+		return true
+	})
+}
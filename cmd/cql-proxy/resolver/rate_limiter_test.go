@@ -0,0 +1,181 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestCompileLimits(t *testing.T) {
+	Convey("compile limits", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"limits": map[string]interface{}{
+				"article": map[string]interface{}{
+					"find": map[string]interface{}{
+						"default": map[string]interface{}{"rate": 1.5},
+					},
+					"count": map[string]interface{}{
+						"default": map[string]interface{}{"rate": 10, "per": "1m", "burst": 2},
+					},
+				},
+			},
+		})
+
+		_, l := r.FindLimit("article", RuleQueryFind, "bob", UserStateLoggedIn)
+		So(l, ShouldNotBeNil)
+		So(l.Period(), ShouldEqual, time.Second)
+		So(l.Burst, ShouldEqual, 2)
+
+		_, l = r.FindLimit("article", RuleQueryCount, "bob", UserStateLoggedIn)
+		So(l, ShouldNotBeNil)
+		So(l.Period(), ShouldEqual, time.Minute)
+		So(l.Burst, ShouldEqual, 2)
+
+		for _, l := range []map[string]interface{}{
+			{"rate": -1},
+			{"burst": -1},
+			{"max_rows": 10, "default_limit": 20},
+			{"rate": 1, "per": "invalid"},
+			{"rate": 1, "per": "-1s"},
+		} {
+			_, err := CompileRules(map[string]interface{}{
+				"limits": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{"default": l},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		}
+
+		for _, subject := range []string{"g:unknown", "u:", "s:unknown", "unknown"} {
+			_, err := CompileRules(map[string]interface{}{
+				"limits": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{
+							subject: map[string]interface{}{"rate": 1},
+						},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestFindLimit(t *testing.T) {
+	Convey("find limit", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"groups": map[string]interface{}{
+				"admin": []interface{}{"alice", "carol"},
+			},
+			"limits": map[string]interface{}{
+				"article": map[string]interface{}{
+					"find": map[string]interface{}{
+						"u:alice":                 map[string]interface{}{"rate": 100},
+						"g:admin":                 map[string]interface{}{"rate": 50},
+						"s:" + UserStateAnonymous: map[string]interface{}{"rate": 1},
+						"default":                 map[string]interface{}{"rate": 10},
+					},
+				},
+			},
+		})
+
+		subject, l := r.FindLimit("article", RuleQueryFind, "alice", UserStateLoggedIn)
+		So(subject, ShouldEqual, "u:alice")
+		So(l.Rate, ShouldEqual, 100)
+
+		subject, l = r.FindLimit("article", RuleQueryFind, "carol", UserStateLoggedIn)
+		So(subject, ShouldEqual, "g:admin")
+		So(l.Rate, ShouldEqual, 50)
+
+		subject, l = r.FindLimit("article", RuleQueryFind, "", UserStateAnonymous)
+		So(subject, ShouldEqual, "s:"+UserStateAnonymous)
+		So(l.Rate, ShouldEqual, 1)
+
+		subject, l = r.FindLimit("article", RuleQueryFind, "bob", UserStateLoggedIn)
+		So(subject, ShouldEqual, "default")
+		So(l.Rate, ShouldEqual, 10)
+
+		subject, l = r.FindLimit("article", RuleQueryUpdate, "bob", UserStateLoggedIn)
+		So(subject, ShouldBeEmpty)
+		So(l, ShouldBeNil)
+
+		subject, l = r.FindLimit("user", RuleQueryFind, "bob", UserStateLoggedIn)
+		So(subject, ShouldBeEmpty)
+		So(l, ShouldBeNil)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	Convey("rate limiter", t, func() {
+		var (
+			dbID = proto.DatabaseID("db")
+			rl   = NewRateLimiter()
+			r    = mustCompileRules(map[string]interface{}{
+				"limits": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{
+							"default": map[string]interface{}{"rate": 1, "per": "1h", "burst": 2},
+						},
+					},
+				},
+			})
+		)
+
+		So(rl.Allow(dbID, nil, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob"), ShouldBeNil)
+		So(rl.Allow(dbID, r, "article", RuleQueryUpdate, "bob", UserStateLoggedIn, "bob"), ShouldBeNil)
+
+		// burst of queries is allowed
+		So(rl.Allow(dbID, r, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob"), ShouldBeNil)
+		So(rl.Allow(dbID, r, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob"), ShouldBeNil)
+
+		err := rl.Allow(dbID, r, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob")
+		So(err, ShouldNotBeNil)
+		qe, ok := err.(*QuotaError)
+		So(ok, ShouldBeTrue)
+		So(qe.Subject, ShouldEqual, "default")
+		So(qe.Rate, ShouldEqual, 1)
+		So(qe.Per, ShouldEqual, time.Hour.String())
+		So(qe.RetryAfter, ShouldBeGreaterThan, 0)
+		So(qe.RetryAfter, ShouldBeLessThanOrEqualTo, time.Hour)
+		So(qe.Error(), ShouldNotBeEmpty)
+
+		// buckets are separated by client
+		So(rl.Allow(dbID, r, "article", RuleQueryFind, "carol", UserStateLoggedIn, "carol"), ShouldBeNil)
+
+		// buckets are separated by database
+		So(rl.Allow(proto.DatabaseID("db2"), r, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob"),
+			ShouldBeNil)
+
+		Convey("reset should remove buckets of database", func() {
+			rl.Reset(dbID)
+			So(rl.Allow(dbID, r, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob"), ShouldBeNil)
+		})
+		Convey("purge should remove idle buckets", func() {
+			rl.Purge(time.Hour)
+			So(rl.Allow(dbID, r, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob"), ShouldNotBeNil)
+			rl.Purge(0)
+			So(rl.Allow(dbID, r, "article", RuleQueryFind, "bob", UserStateLoggedIn, "bob"), ShouldBeNil)
+		})
+	})
+}
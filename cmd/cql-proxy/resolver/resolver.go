@@ -60,6 +60,14 @@ var opMap = map[string]string{
 	"$set": `"{field}" = ?`,
 }
 
+var aggregateOpMap = map[string]string{
+	"$count": "COUNT",
+	"$sum":   "SUM",
+	"$avg":   "AVG",
+	"$min":   "MIN",
+	"$max":   "MAX",
+}
+
 // ResolveProjection resolves projection object and returns project sql statement and dependent fields.
 func ResolveProjection(p map[string]interface{}, availFields FieldMap) (fm FieldMap, statement string, err error) {
 	fm = FieldMap{}
@@ -252,6 +260,40 @@ func ResolveFilter(q map[string]interface{}, availFields FieldMap) (
 			fields.Merge(childFields)
 			subStatements = append(subStatements, childStatement)
 			args = append(args, childArgs...)
+		case k == "$sql":
+			// sql predicate is only available from compiled rules
+			p, ok := v.(*SQLPredicate)
+			if !ok {
+				err = errors.Errorf("invalid operator %s", k)
+				return
+			}
+
+			var (
+				childFields    FieldMap
+				childStatement string
+				childArgs      []interface{}
+			)
+			childFields, childStatement, childArgs, err = p.Resolve(availFields)
+			if err != nil {
+				return
+			}
+			fields.Merge(childFields)
+			subStatements = append(subStatements, childStatement)
+			args = append(args, childArgs...)
+		case k == "$expr":
+			var (
+				childFields    FieldMap
+				childStatement string
+				childArgs      []interface{}
+			)
+			childFields, childStatement, childArgs, err = ResolveExpr(v, availFields)
+			if err != nil {
+				err = errors.Wrapf(err, "%s operator", k)
+				return
+			}
+			fields.Merge(childFields)
+			subStatements = append(subStatements, childStatement)
+			args = append(args, childArgs...)
 		case k == "$comment":
 			// ignore
 		case strings.HasPrefix(k, "$"):
@@ -368,6 +410,20 @@ func ResolveUpdate(q map[string]interface{}, availFields FieldMap) (
 					args = append(args, argument)
 				}
 			}
+		case k == "$expr":
+			var (
+				childFields    FieldMap
+				childStatement string
+				childArgs      []interface{}
+			)
+			childFields, childStatement, childArgs, err = ResolveExpr(v, availFields)
+			if err != nil {
+				err = errors.Wrapf(err, "%s operator", k)
+				return
+			}
+			fields.Merge(childFields)
+			subStatements = append(subStatements, childStatement)
+			args = append(args, childArgs...)
 		case k == "$comment":
 			// ignore
 		case strings.HasPrefix(k, "$"):
@@ -433,6 +489,88 @@ func ResolveInsert(q map[string]interface{}, availFields FieldMap) (fields Field
 	return
 }
 
+// ResolveGroupBy resolves group by columns as group by statement.
+func ResolveGroupBy(groupBy []string, availFields FieldMap) (fields FieldMap, statement string, err error) {
+	fields = FieldMap{}
+
+	var colStatements []string
+
+	for _, k := range groupBy {
+		if !availFields[k] {
+			err = errors.Errorf("unknown field: %s", k)
+			return
+		}
+
+		fields[k] = true
+		colStatements = append(colStatements, fmt.Sprintf(`"%s"`, k))
+	}
+
+	statement = strings.Join(colStatements, ",")
+
+	return
+}
+
+// ResolveAggregation resolves aggregation object like {"alias": {"$sum": "field"}} as projection statement.
+func ResolveAggregation(q map[string]interface{}, availFields FieldMap) (
+	fields FieldMap, statement string, err error) {
+	fields = FieldMap{}
+
+	if len(q) == 0 {
+		err = errors.New("aggregation requires non-empty object")
+		return
+	}
+
+	var subStatements []string
+
+	for alias, v := range q {
+		if alias == "" || strings.HasPrefix(alias, "$") || strings.Contains(alias, `"`) {
+			err = errors.Errorf("invalid aggregation alias: %s", alias)
+			return
+		}
+
+		var (
+			ov map[string]interface{}
+			ok bool
+		)
+
+		if ov, ok = v.(map[string]interface{}); !ok || len(ov) != 1 {
+			err = errors.Errorf("aggregation %s requires object with single operator", alias)
+			return
+		}
+
+		for op, arg := range ov {
+			var fn string
+			if fn, ok = aggregateOpMap[op]; !ok {
+				err = errors.Errorf("unknown aggregate operator %s", op)
+				return
+			}
+
+			var field string
+			if field, ok = arg.(string); !ok {
+				err = errors.Errorf("%s operator requires field name argument", op)
+				return
+			}
+
+			if op == "$count" && field == "*" {
+				subStatements = append(subStatements, fmt.Sprintf(`COUNT(1) AS "%s"`, alias))
+				continue
+			}
+
+			if !availFields[field] {
+				err = errors.Errorf("unknown field: %s", field)
+				return
+			}
+
+			fields[field] = true
+			subStatements = append(subStatements, fmt.Sprintf(`%s("%s") AS "%s"`, fn, field, alias))
+		}
+	}
+
+	statement = strings.Join(subStatements, ",")
+
+	return
+}
+
 // ResolveOrderBy resolves order by statement.
 func ResolveOrderBy(q map[string]interface{}, availFields FieldMap) (fields FieldMap, statement string, err error) {
 	fields = FieldMap{}
@@ -18,13 +18,12 @@ package resolver
 
 import (
 	"encoding/json"
+	"regexp"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	validator "gopkg.in/go-playground/validator.v9"
-
-	"github.com/CovenantSQL/CovenantSQL/proto"
 )
 
 // RuleQueryType defines the rule query type enum.
@@ -41,8 +40,43 @@ const (
 	RuleQueryRemove
 	// RuleQueryCount defines the count type query.
 	RuleQueryCount
+	// RuleQueryAggregate defines the group by/aggregation type query.
+	RuleQueryAggregate
 )
 
+var ruleQueryTypeNames = map[RuleQueryType]string{
+	RuleQueryInsert:    "insert",
+	RuleQueryUpdate:    "update",
+	RuleQueryFind:      "find",
+	RuleQueryRemove:    "remove",
+	RuleQueryCount:     "count",
+	RuleQueryAggregate: "aggregate",
+}
+
+// String implements fmt.Stringer for rule query type.
+func (t RuleQueryType) String() string {
+	if n, ok := ruleQueryTypeNames[t]; ok {
+		return n
+	}
+
+	return "unknown"
+}
+
+// ParseRuleQueryType parses rule query type from string.
+func ParseRuleQueryType(s string) (t RuleQueryType, err error) {
+	for qt, n := range ruleQueryTypeNames {
+		if strings.EqualFold(n, s) {
+			t = qt
+			return
+		}
+	}
+
+	err = errors.Errorf("unknown query type %s", s)
+	return
+}
+
+var userStateRegex = regexp.MustCompile("^[a-z][a-z0-9_]{0,63}$")
+
 const (
 	// UserStateAnonymous defines anonymous user state.
 	UserStateAnonymous = "anonymous"
@@ -56,44 +90,36 @@ const (
 	UserStateDisabled = "disabled"
 )
 
-// RulesManager defines the rules manger object for project rules cache.
-type RulesManager struct {
-	rules sync.Map // map[proto.DatabaseID]*Rules
-}
-
-// Get returns the rules object of specified database.
-func (m *RulesManager) Get(dbID proto.DatabaseID) *Rules {
-	if v, ok := m.rules.Load(dbID); ok && v != nil {
-		return v.(*Rules)
-	}
-
-	return nil
-}
-
-// Set update the global rules cache with new rules object for specified database.
-func (m *RulesManager) Set(dbID proto.DatabaseID, rules *Rules) {
-	m.rules.Store(dbID, rules)
-}
-
 // use various helper types
 type enforceObject = map[string]interface{}
 type queryEnforces = map[string]enforceObject // first dim is group/user/default def, second dim is enforce desc
 type updateQueryEnforces struct {
-	Filter queryEnforces `json:"filter"`
-	Update queryEnforces `json:"update"`
+	Filter         queryEnforces       `json:"filter"`
+	Update         queryEnforces       `json:"update"`
+	ReadonlyFields []string            `json:"readonly_fields"`
+	DenyFields     map[string][]string `json:"deny_fields"`
 }
 type tableEnforces struct {
-	Find   queryEnforces       `json:"find"`
-	Count  queryEnforces       `json:"count"`
-	Remove queryEnforces       `json:"remove"`
-	Update updateQueryEnforces `json:"update"`
-	Insert queryEnforces       `json:"insert"`
+	Find      queryEnforces       `json:"find"`
+	Count     queryEnforces       `json:"count"`
+	Remove    queryEnforces       `json:"remove"`
+	Update    updateQueryEnforces `json:"update"`
+	Insert    queryEnforces       `json:"insert"`
+	Mask      queryEnforces       `json:"mask"`
+	Aggregate queryEnforces       `json:"aggregate"`
+	Cascade   []*CascadeRule      `json:"cascade"` // related table operations on remove
 }
 
 // RulesConfig defines raw rules config wrapper.
 type RulesConfig struct {
 	Groups map[string][]string      `json:"groups" validate:"omitempty,dive,keys,required,endkeys,required,dive,required"`
 	Rules  map[string]tableEnforces `json:"rules" validate:"omitempty,dive,keys,required,endkeys,required"`
+	Limits map[string]tableLimits   `json:"limits" validate:"omitempty,dive,keys,required,endkeys,required"`
+	States []string                 `json:"states" validate:"omitempty,dive,required"`
+	// dynamic groups resolved from membership table in project database
+	GroupSource *GroupSourceConfig `json:"group_source" validate:"omitempty"`
+	// external authorization service of the external enforce subject
+	External *ExternalAuthConfig `json:"external" validate:"omitempty"`
 }
 
 // Rules defines rules object for further enforce execution.
@@ -101,12 +127,23 @@ type Rules struct {
 	groups     []string
 	userGroups map[string][]string
 	rules      map[string]*TableRules
+	limits     map[string]map[RuleQueryType]*QueryLimits
+	states     map[string]bool
+
+	groupSource   *GroupSourceConfig
+	groupProvider GroupProvider
+	claimGroups   *ClaimGroupProvider
+	external      *ExternalAuthConfig
+	authorizer    ExternalAuthorizer
 }
 
 // TableRules defines rules for single table.
 type TableRules struct {
 	rules       map[RuleQueryType]*QueryRules
 	updateRules *QueryRules
+	maskRules   *QueryRules
+	protected   *ProtectedFields
+	cascade     []*CascadeRule
 }
 
 // QueryRules defines rules for specified query type.
@@ -115,6 +152,7 @@ type QueryRules struct {
 	userRules      map[string]map[string]interface{}
 	userStateRules map[string]map[string]interface{}
 	defaultRules   map[string]interface{} // worked as deny all, allow all
+	externalRules  map[string]interface{} // context of external authorization, nil for not required
 }
 
 type updateMergeItem struct {
@@ -139,6 +177,38 @@ func CompileRawRules(rules json.RawMessage) (r *Rules, err error) {
 	r = &Rules{
 		userGroups: make(map[string][]string),
 		rules:      make(map[string]*TableRules),
+		limits:     make(map[string]map[RuleQueryType]*QueryLimits),
+		states:     make(map[string]bool),
+
+		claimGroups: NewClaimGroupProvider(),
+	}
+
+	if err = compileGroupSource(cfg.GroupSource); err != nil {
+		return
+	}
+	r.groupSource = cfg.GroupSource
+
+	if err = compileExternalAuth(cfg.External); err != nil {
+		return
+	}
+	if cfg.External != nil {
+		r.external = cfg.External
+		r.authorizer = NewHTTPAuthorizer(cfg.External)
+	}
+
+	for i, userState := range cfg.States {
+		userState = strings.ToLower(strings.TrimPrefix(userState, "s:"))
+
+		if !userStateRegex.MatchString(userState) {
+			err = errors.Errorf("invalid custom user state %s", userState)
+			return
+		} else if isBuiltinUserState(userState) {
+			err = errors.Errorf("custom user state %s conflicts with builtin state", userState)
+			return
+		}
+
+		cfg.States[i] = userState
+		r.states[userState] = true
 	}
 
 	for groupName, userNames := range cfg.Groups {
@@ -161,6 +231,10 @@ func CompileRawRules(rules json.RawMessage) (r *Rules, err error) {
 		if err != nil {
 			return
 		}
+		tableRules.rules[RuleQueryAggregate], err = compileQueryEnforces(cfg, tableEnforces.Aggregate)
+		if err != nil {
+			return
+		}
 		tableRules.rules[RuleQueryRemove], err = compileQueryEnforces(cfg, tableEnforces.Remove)
 		if err != nil {
 			return
@@ -169,6 +243,10 @@ func CompileRawRules(rules json.RawMessage) (r *Rules, err error) {
 		if err != nil {
 			return
 		}
+		err = validateInsertRules(tableRules.rules[RuleQueryInsert])
+		if err != nil {
+			return
+		}
 		tableRules.rules[RuleQueryUpdate], err = compileQueryEnforces(cfg, tableEnforces.Update.Filter)
 		if err != nil {
 			return
@@ -182,10 +260,112 @@ func CompileRawRules(rules json.RawMessage) (r *Rules, err error) {
 		if err != nil {
 			return
 		}
+		tableRules.protected, err = compileProtectedFields(
+			cfg, tableEnforces.Update.ReadonlyFields, tableEnforces.Update.DenyFields)
+		if err != nil {
+			return
+		}
+		tableRules.maskRules, err = compileQueryEnforces(cfg, tableEnforces.Mask)
+		if err != nil {
+			return
+		}
+
+		err = validateMaskRules(tableRules.maskRules)
+		if err != nil {
+			return
+		}
+		err = compileCascadeRules(tableName, tableEnforces.Cascade)
+		if err != nil {
+			return
+		}
+		tableRules.cascade = tableEnforces.Cascade
 
 		r.rules[tableName] = tableRules
 	}
 
+	if err = r.validateCascadeCycles(); err != nil {
+		return
+	}
+
+	for tableName, tableLimits := range cfg.Limits {
+		limits := make(map[RuleQueryType]*QueryLimits)
+
+		for qt, ql := range map[RuleQueryType]queryLimits{
+			RuleQueryFind:      tableLimits.Find,
+			RuleQueryCount:     tableLimits.Count,
+			RuleQueryRemove:    tableLimits.Remove,
+			RuleQueryUpdate:    tableLimits.Update,
+			RuleQueryInsert:    tableLimits.Insert,
+			RuleQueryAggregate: tableLimits.Aggregate,
+		} {
+			limits[qt], err = compileQueryLimits(cfg, ql)
+			if err != nil {
+				err = errors.Wrapf(err, "%s: invalid %s limits", tableName, qt)
+				return
+			}
+		}
+
+		r.limits[tableName] = limits
+	}
+
+	return
+}
+
+// GroupSource returns the dynamic groups config of rules.
+func (r *Rules) GroupSource() *GroupSourceConfig {
+	return r.groupSource
+}
+
+// SetGroupProvider sets the dynamic groups resolver of rules.
+func (r *Rules) SetGroupProvider(p GroupProvider) {
+	r.groupProvider = p
+}
+
+// BindClaimGroups binds the groups declared in validated identity token to user until the token expires.
+func (r *Rules) BindClaimGroups(uid string, groups []string, expires time.Time) {
+	if r.claimGroups != nil {
+		r.claimGroups.Bind(uid, groups, expires)
+	}
+}
+
+// groupsOf returns static groups, token claimed groups and dynamic groups of user.
+func (r *Rules) groupsOf(uid string) (groups []string, err error) {
+	groups = r.userGroups[uid]
+
+	var claimGroups, dynamicGroups []string
+
+	if r.claimGroups != nil {
+		claimGroups, _ = r.claimGroups.Groups(uid)
+	}
+
+	if r.groupProvider != nil {
+		dynamicGroups, err = r.groupProvider.Groups(uid)
+		if err != nil {
+			err = errors.Wrapf(err, "resolve dynamic groups of user %s failed", uid)
+			return
+		}
+	}
+
+	if len(claimGroups) == 0 && len(dynamicGroups) == 0 {
+		return
+	}
+
+	exists := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		exists[g] = true
+	}
+
+	groups = append([]string(nil), groups...)
+
+	for _, extraGroups := range [][]string{claimGroups, dynamicGroups} {
+		for _, g := range extraGroups {
+			if !exists[g] {
+				exists[g] = true
+				groups = append(groups, g)
+			}
+		}
+	}
+
 	return
 }
 
@@ -208,6 +388,30 @@ func compileQueryEnforces(cfg *RulesConfig, enforces queryEnforces) (queryRules
 	}
 
 	for enforceSubject, enforceObject := range enforces {
+		if enforceSubject == externalSubject {
+			if cfg.External == nil {
+				err = errors.New("external authorization service is not configured")
+				return
+			}
+
+			// enforce object is passed to external authorization service as is
+			queryRules.externalRules = enforceObject
+			if queryRules.externalRules == nil {
+				queryRules.externalRules = map[string]interface{}{}
+			}
+
+			continue
+		}
+
+		if err = validateEnforceObject(cfg, enforceObject); err != nil {
+			err = errors.Wrapf(err, "%s: invalid enforce object", enforceSubject)
+			return
+		}
+		if err = compileSQLPredicates(enforceObject); err != nil {
+			err = errors.Wrapf(err, "%s: invalid sql predicate", enforceSubject)
+			return
+		}
+
 		switch {
 		case strings.HasPrefix(enforceSubject, "g:"):
 			groupName := enforceSubject[2:]
@@ -217,7 +421,7 @@ func compileQueryEnforces(cfg *RulesConfig, enforces queryEnforces) (queryRules
 				return
 			}
 
-			if _, ok := cfg.Groups[groupName]; !ok {
+			if !cfg.hasGroup(groupName) {
 				// invalid group
 				err = errors.Errorf("%s: unknown group", groupName)
 				return
@@ -236,13 +440,7 @@ func compileQueryEnforces(cfg *RulesConfig, enforces queryEnforces) (queryRules
 		case strings.HasPrefix(enforceSubject, "s:"):
 			userState := strings.ToLower(enforceSubject[2:])
 
-			switch userState {
-			case UserStateAnonymous:
-			case UserStateLoggedIn:
-			case UserStateWaitSignUpConfirm:
-			case UserStatePreRegistered:
-			case UserStateDisabled:
-			default:
+			if !isValidUserState(cfg, userState) {
 				err = errors.Errorf("invalid user state %s", userState)
 				return
 			}
@@ -260,11 +458,88 @@ func compileQueryEnforces(cfg *RulesConfig, enforces queryEnforces) (queryRules
 	return
 }
 
+func (cfg *RulesConfig) hasGroup(groupName string) bool {
+	if cfg.GroupSource != nil {
+		// any group is possible in dynamic groups
+		return true
+	}
+
+	_, ok := cfg.Groups[groupName]
+	return ok
+}
+
+func isValidUserState(cfg *RulesConfig, userState string) bool {
+	if isBuiltinUserState(userState) {
+		return true
+	}
+
+	for _, s := range cfg.States {
+		if s == userState {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isBuiltinUserState(userState string) bool {
+	switch userState {
+	case UserStateAnonymous:
+	case UserStateLoggedIn:
+	case UserStateWaitSignUpConfirm:
+	case UserStatePreRegistered:
+	case UserStateDisabled:
+	default:
+		return false
+	}
+
+	return true
+}
+
+// ResolveUserState returns the effective user state for rules enforcement, the declared custom state
+// of user takes effect only if the user is in logged in state.
+func (r *Rules) ResolveUserState(userState string, customState string) string {
+	customState = strings.ToLower(customState)
+
+	if userState == UserStateLoggedIn && customState != "" && r.states[customState] {
+		return customState
+	}
+
+	return userState
+}
+
 // EnforceRulesOnFilter combines filter and rules to new filter object.
 func (r *Rules) EnforceRulesOnFilter(f map[string]interface{}, table string,
 	uid string, userState string, vars map[string]interface{}, qt RuleQueryType) (
 	filter map[string]interface{}, err error) {
-	resultRules, err := r.findRulesToApply(r.findUserRules(table, RuleQueryUpdate), uid, userState)
+	queryRules := r.findUserRules(table, qt)
+
+	if filter, err = r.enforceRulesOnFilter(queryRules, f, uid, userState, vars); err != nil {
+		return
+	}
+
+	decision, err := r.authorizeExternal(queryRules, table, qt, uid, userState, f, nil, vars)
+	if err != nil {
+		filter = nil
+		return
+	}
+
+	if decision != nil && len(decision.Filter) > 0 {
+		if len(filter) > 0 {
+			filter = map[string]interface{}{
+				"$and": []interface{}{filter, decision.Filter},
+			}
+		} else {
+			filter = decision.Filter
+		}
+	}
+
+	return
+}
+
+func (r *Rules) enforceRulesOnFilter(queryRules *QueryRules, f map[string]interface{},
+	uid string, userState string, vars map[string]interface{}) (filter map[string]interface{}, err error) {
+	resultRules, err := r.findRulesToApply(queryRules, uid, userState)
 	if err != nil {
 		return
 	}
@@ -274,13 +549,52 @@ func (r *Rules) EnforceRulesOnFilter(f map[string]interface{}, table string,
 		return
 	}
 
-	var resultAndSubExpr []interface{}
+	groups, err := r.groupsOf(uid)
+	if err != nil {
+		return
+	}
+
+	var (
+		resultAndSubExpr []interface{}
+		subject          = &enforceSubject{
+			uid:       uid,
+			userState: userState,
+			groups:    groups,
+		}
+	)
+
+	for _, rule := range resultRules {
+		var (
+			reducedRule map[string]interface{}
+			constant    *bool
+		)
+		reducedRule, constant, err = reduceEnforceObject(rule, subject)
+		if err != nil {
+			return
+		}
+
+		if constant != nil {
+			if !*constant {
+				err = errors.New("permission denied of logical rule")
+				return
+			}
 
-	for _, r := range resultRules {
-		resultAndSubExpr = append(resultAndSubExpr, InjectMagicVars(r, vars))
+			continue
+		}
+
+		if len(reducedRule) > 0 {
+			resultAndSubExpr = append(resultAndSubExpr, InjectMagicVars(reducedRule, vars))
+		}
+	}
+
+	if len(resultAndSubExpr) == 0 {
+		filter = f
+		return
 	}
 
-	resultAndSubExpr = append(resultAndSubExpr, f)
+	if len(f) > 0 {
+		resultAndSubExpr = append(resultAndSubExpr, f)
+	}
 
 	filter = map[string]interface{}{
 		"$and": resultAndSubExpr,
@@ -302,6 +616,29 @@ func (r *Rules) EnforceRulesOnUpdate(d map[string]interface{}, table string,
 		return
 	}
 
+	if tableRules.protected != nil {
+		var groups []string
+		if groups, err = r.groupsOf(uid); err != nil {
+			return
+		}
+
+		var (
+			protected = tableRules.protected.fieldsFor(groups, uid, userState)
+			denied    = map[string]string{}
+		)
+
+		for field := range updatedFields(d) {
+			if protected[field] {
+				denied[field] = "field is read-only"
+			}
+		}
+
+		if len(denied) > 0 {
+			err = &ValidationError{Fields: denied}
+			return
+		}
+	}
+
 	resultRules, err := r.findRulesToApply(tableRules.updateRules, uid, userState)
 	if err != nil {
 		return
@@ -320,7 +657,28 @@ func (r *Rules) EnforceRulesOnUpdate(d map[string]interface{}, table string,
 // EnforceRulesOnInsert combines insert and rules to new insert data object.
 func (r *Rules) EnforceRulesOnInsert(d map[string]interface{}, table string,
 	uid string, userState string, vars map[string]interface{}) (insert map[string]interface{}, err error) {
-	resultRules, err := r.findRulesToApply(r.findUserRules(table, RuleQueryInsert), uid, userState)
+	queryRules := r.findUserRules(table, RuleQueryInsert)
+
+	if insert, err = r.enforceRulesOnInsert(queryRules, d, uid, userState, vars); err != nil {
+		return
+	}
+
+	decision, err := r.authorizeExternal(queryRules, table, RuleQueryInsert, uid, userState, nil, d, vars)
+	if err != nil {
+		insert = nil
+		return
+	}
+
+	if decision != nil && len(decision.Data) > 0 {
+		insert = mergeInsert(insert, decision.Data)
+	}
+
+	return
+}
+
+func (r *Rules) enforceRulesOnInsert(queryRules *QueryRules, d map[string]interface{},
+	uid string, userState string, vars map[string]interface{}) (insert map[string]interface{}, err error) {
+	resultRules, err := r.findRulesToApply(queryRules, uid, userState)
 	if err != nil {
 		return
 	}
@@ -330,8 +688,51 @@ func (r *Rules) EnforceRulesOnInsert(d map[string]interface{}, table string,
 		return
 	}
 
+	// validate original query before merging defaults
+	var (
+		values           = make([]map[string]interface{}, 0, len(resultRules))
+		validationErrors = map[string]string{}
+	)
+
+	for _, rule := range resultRules {
+		v, ic := splitInsertRule(rule)
+		values = append(values, v)
+
+		if vErr, ok := ic.Validate(d).(*ValidationError); ok {
+			for field, msg := range vErr.Fields {
+				validationErrors[field] = msg
+			}
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		err = &ValidationError{Fields: validationErrors}
+		return
+	}
+
 	// merge inserts vars to original query
-	insert = mergeInsert(d, InjectMagicVars(mergeInsert(resultRules...), vars))
+	insert = mergeInsert(d, InjectMagicVars(mergeInsert(values...), vars))
+
+	return
+}
+
+// EnforceMask returns the column mask of find/count query result for specified user.
+func (r *Rules) EnforceMask(table string, uid string, userState string) (mask ColumnMask, err error) {
+	var (
+		tableRules *TableRules
+		ok         bool
+	)
+
+	if tableRules, ok = r.rules[table]; !ok || tableRules == nil || tableRules.maskRules == nil {
+		return
+	}
+
+	resultRules, err := r.findRulesToApply(tableRules.maskRules, uid, userState)
+	if err != nil {
+		return
+	}
+
+	mask, err = buildColumnMask(resultRules...)
 
 	return
 }
@@ -357,6 +758,17 @@ func (r *Rules) findUserRules(table string, qt RuleQueryType) (queryRules *Query
 
 func (r *Rules) findRulesToApply(queryRules *QueryRules, uid string, userState string) (
 	resultRules []map[string]interface{}, err error) {
+	_, resultRules, err = r.findRulesToApplyWithSubjects(queryRules, uid, userState)
+	return
+}
+
+func (r *Rules) findRulesToApplyWithSubjects(queryRules *QueryRules, uid string, userState string) (
+	subjects []string, resultRules []map[string]interface{}, err error) {
+	if queryRules == nil {
+		// open privilege
+		return
+	}
+
 	// state rule
 	var (
 		stateRule map[string]interface{}
@@ -367,12 +779,17 @@ func (r *Rules) findRulesToApply(queryRules *QueryRules, uid string, userState s
 	} else if stateRule == nil {
 		err = errors.Errorf("permission denied of user state %s", userState)
 		return
+	} else {
+		subjects = append(subjects, "s:"+userState)
+		resultRules = append(resultRules, stateRule)
 	}
 
-	resultRules = append(resultRules, stateRule)
-
 	// group rules
-	var groups = r.userGroups[uid]
+	groups, err := r.groupsOf(uid)
+	if err != nil {
+		return
+	}
+
 	for _, g := range groups {
 		var rule map[string]interface{}
 
@@ -383,6 +800,7 @@ func (r *Rules) findRulesToApply(queryRules *QueryRules, uid string, userState s
 			return
 		}
 
+		subjects = append(subjects, "g:"+g)
 		resultRules = append(resultRules, queryRules.groupRules[g])
 	}
 
@@ -393,10 +811,11 @@ func (r *Rules) findRulesToApply(queryRules *QueryRules, uid string, userState s
 	} else if rule == nil {
 		err = errors.New("permission denied of user rule")
 		return
+	} else {
+		subjects = append(subjects, "u:"+uid)
+		resultRules = append(resultRules, rule)
 	}
 
-	resultRules = append(resultRules, rule)
-
 	// nothing yet founded, apply to default rules
 	if len(resultRules) == 0 {
 		if queryRules.defaultRules == nil {
@@ -404,12 +823,47 @@ func (r *Rules) findRulesToApply(queryRules *QueryRules, uid string, userState s
 			return
 		}
 
+		subjects = append(subjects, "default")
 		resultRules = append(resultRules, queryRules.defaultRules)
 	}
 
 	return
 }
 
+func validateInsertRules(rules *QueryRules) (err error) {
+	if rules == nil {
+		return
+	}
+
+	var all []map[string]interface{}
+
+	for _, stateRules := range rules.userStateRules {
+		all = append(all, stateRules)
+	}
+	for _, groupRules := range rules.groupRules {
+		all = append(all, groupRules)
+	}
+	for _, userRules := range rules.userRules {
+		all = append(all, userRules)
+	}
+	all = append(all, rules.defaultRules)
+
+	for _, r := range all {
+		for k, v := range r {
+			if k == insertValidateKey {
+				if r[k], err = compileInsertConstraints(v); err != nil {
+					return
+				}
+			} else if strings.HasPrefix(k, "$") {
+				err = errors.Errorf("invalid insert field %s", k)
+				return
+			}
+		}
+	}
+
+	return
+}
+
 func validateUpdateRules(rules *QueryRules) (err error) {
 	if rules == nil {
 		return
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// enforceSubject defines the requesting subject used to evaluate subject predicates in enforce objects.
+type enforceSubject struct {
+	uid       string
+	userState string
+	groups    []string
+}
+
+// isSubjectPredicate returns whether the key is a subject predicate operator which is evaluated on enforcement.
+func isSubjectPredicate(k string) bool {
+	return k == "$group" || k == "$user" || k == "$state"
+}
+
+// validateEnforceObject validates logical operators and subject predicates in enforce object.
+func validateEnforceObject(cfg *RulesConfig, o map[string]interface{}) (err error) {
+	for k, v := range o {
+		switch k {
+		case "$and", "$or", "$nor":
+			var childQuery []map[string]interface{}
+			childQuery, err = getNonEmptyArrayOfObjects(v)
+			if err != nil {
+				err = errors.Wrapf(err, "%s operator", k)
+				return
+			}
+			for _, cq := range childQuery {
+				if err = validateEnforceObject(cfg, cq); err != nil {
+					return
+				}
+			}
+		case "$expr":
+			if err = validateExpr(v); err != nil {
+				err = errors.Wrapf(err, "%s operator", k)
+				return
+			}
+		case "$group", "$user", "$state":
+			var names []string
+			names, err = getSubjectPredicateArgs(k, v)
+			if err != nil {
+				return
+			}
+			for _, name := range names {
+				switch k {
+				case "$group":
+					if !cfg.hasGroup(name) {
+						err = errors.Errorf("%s: unknown group", name)
+						return
+					}
+				case "$state":
+					if !isValidUserState(cfg, strings.ToLower(name)) {
+						err = errors.Errorf("invalid user state %s", name)
+						return
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// reduceEnforceObject evaluates subject predicates of enforce object and simplifies the logical operators
+// containing constant results, constant is returned as non-nil if the whole object is evaluated as true/false.
+func reduceEnforceObject(o map[string]interface{}, s *enforceSubject) (
+	res map[string]interface{}, constant *bool, err error) {
+	res = make(map[string]interface{}, len(o))
+
+	for k, v := range o {
+		var c *bool
+
+		switch k {
+		case "$and", "$or", "$nor":
+			var childQuery []map[string]interface{}
+			childQuery, err = getNonEmptyArrayOfObjects(v)
+			if err != nil {
+				err = errors.Wrapf(err, "%s operator", k)
+				return
+			}
+
+			var children []interface{}
+			children, c, err = reduceLogicRelation(k, childQuery, s)
+			if err != nil {
+				return
+			}
+			if c == nil {
+				res[k] = children
+			}
+		case "$group", "$user", "$state":
+			var (
+				names   []string
+				matched bool
+			)
+			names, err = getSubjectPredicateArgs(k, v)
+			if err != nil {
+				return
+			}
+			for _, name := range names {
+				switch k {
+				case "$group":
+					for _, g := range s.groups {
+						matched = matched || g == name
+					}
+				case "$user":
+					matched = matched || s.uid == name
+				case "$state":
+					matched = matched || s.userState == strings.ToLower(name)
+				}
+			}
+			c = &matched
+		default:
+			res[k] = v
+		}
+
+		if c != nil && !*c {
+			// implicit and relation between keys, short circuit on false
+			constant = c
+			res = nil
+			return
+		}
+	}
+
+	if len(res) == 0 && len(o) > 0 {
+		// all keys are evaluated as true
+		t := true
+		constant = &t
+		res = nil
+	}
+
+	return
+}
+
+func reduceLogicRelation(op string, childQuery []map[string]interface{}, s *enforceSubject) (
+	children []interface{}, constant *bool, err error) {
+	var (
+		t = true
+		f = false
+	)
+
+	for _, cq := range childQuery {
+		var (
+			child map[string]interface{}
+			c     *bool
+		)
+		child, c, err = reduceEnforceObject(cq, s)
+		if err != nil {
+			return
+		}
+
+		if c == nil {
+			children = append(children, child)
+			continue
+		}
+
+		switch {
+		case op == "$and" && !*c:
+			constant = &f
+			return
+		case op == "$or" && *c:
+			constant = &t
+			return
+		case op == "$nor" && *c:
+			constant = &f
+			return
+		}
+		// neutral element of the relation, ignore
+	}
+
+	if len(children) == 0 {
+		switch op {
+		case "$and", "$nor":
+			constant = &t
+		case "$or":
+			constant = &f
+		}
+	}
+
+	return
+}
+
+func getSubjectPredicateArgs(k string, v interface{}) (names []string, err error) {
+	switch rv := v.(type) {
+	case string:
+		names = append(names, rv)
+	case []interface{}:
+		for _, e := range rv {
+			var (
+				name string
+				ok   bool
+			)
+			if name, ok = e.(string); !ok {
+				err = errors.Errorf("%s operator requires string or array of strings", k)
+				return
+			}
+			names = append(names, name)
+		}
+	default:
+		err = errors.Errorf("%s operator requires string or array of strings", k)
+		return
+	}
+
+	for _, name := range names {
+		if name == "" {
+			err = errors.Errorf("%s operator requires non-empty name", k)
+			return
+		}
+	}
+
+	return
+}
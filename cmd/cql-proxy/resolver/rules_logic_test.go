@@ -0,0 +1,322 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func mustCompileRules(rules map[string]interface{}) *Rules {
+	r, err := CompileRules(rules)
+	So(err, ShouldBeNil)
+	So(r, ShouldNotBeNil)
+	return r
+}
+
+func TestValidateEnforceObject(t *testing.T) {
+	Convey("validate enforce object", t, func() {
+		cfg := &RulesConfig{
+			Groups: map[string][]string{"admin": {"alice"}},
+			States: []string{"vip"},
+		}
+
+		for _, o := range []map[string]interface{}{
+			{"owner": "$user_id"},
+			{"$group": "admin"},
+			{"$group": []interface{}{"admin"}},
+			{"$user": "bob"},
+			{"$state": "LOGGED_IN"},
+			{"$state": "vip"},
+			{"$or": []interface{}{
+				map[string]interface{}{"$group": "admin"},
+				map[string]interface{}{"$nor": []interface{}{
+					map[string]interface{}{"$state": "disabled"},
+				}},
+			}},
+			{"$expr": map[string]interface{}{
+				"$eq": []interface{}{map[string]interface{}{"$col": "a"}, map[string]interface{}{"$col": "b"}},
+			}},
+		} {
+			So(validateEnforceObject(cfg, o), ShouldBeNil)
+		}
+
+		for _, o := range []map[string]interface{}{
+			{"$group": "unknown"},
+			{"$group": ""},
+			{"$group": 1},
+			{"$user": []interface{}{"bob", 1}},
+			{"$state": "unknown"},
+			{"$or": []interface{}{}},
+			{"$and": "invalid"},
+			{"$or": []interface{}{map[string]interface{}{"$group": "unknown"}}},
+			{"$expr": map[string]interface{}{"$eq": []interface{}{map[string]interface{}{"$col": "a;"}, 1}}},
+		} {
+			So(validateEnforceObject(cfg, o), ShouldNotBeNil)
+		}
+
+		Convey("any group is valid with dynamic groups", func() {
+			cfg.GroupSource = &GroupSourceConfig{}
+			So(validateEnforceObject(cfg, map[string]interface{}{"$group": "unknown"}), ShouldBeNil)
+		})
+	})
+}
+
+func TestReduceEnforceObject(t *testing.T) {
+	Convey("reduce enforce object", t, func() {
+		s := &enforceSubject{
+			uid:       "alice",
+			userState: UserStateLoggedIn,
+			groups:    []string{"admin"},
+		}
+
+		Convey("keep field conditions", func() {
+			res, c, err := reduceEnforceObject(map[string]interface{}{"owner": "$user_id"}, s)
+			So(err, ShouldBeNil)
+			So(c, ShouldBeNil)
+			So(res, ShouldResemble, map[string]interface{}{"owner": "$user_id"})
+		})
+
+		Convey("evaluate subject predicates", func() {
+			for _, o := range []map[string]interface{}{
+				{"$group": "admin"},
+				{"$group": []interface{}{"staff", "admin"}},
+				{"$user": "alice"},
+				{"$state": "Logged_In"},
+				{"$nor": []interface{}{map[string]interface{}{"$user": "bob"}}},
+				{"$and": []interface{}{
+					map[string]interface{}{"$user": "alice"},
+					map[string]interface{}{"$group": "admin"},
+				}},
+			} {
+				res, c, err := reduceEnforceObject(o, s)
+				So(err, ShouldBeNil)
+				So(res, ShouldBeNil)
+				So(c, ShouldNotBeNil)
+				So(*c, ShouldBeTrue)
+			}
+
+			for _, o := range []map[string]interface{}{
+				{"$group": "staff"},
+				{"$user": "bob"},
+				{"$state": UserStateAnonymous},
+				{"$group": "admin", "$user": "bob"},
+				{"$nor": []interface{}{map[string]interface{}{"$user": "alice"}}},
+				{"$or": []interface{}{
+					map[string]interface{}{"$user": "bob"},
+					map[string]interface{}{"$group": "staff"},
+				}},
+			} {
+				res, c, err := reduceEnforceObject(o, s)
+				So(err, ShouldBeNil)
+				So(res, ShouldBeNil)
+				So(c, ShouldNotBeNil)
+				So(*c, ShouldBeFalse)
+			}
+		})
+
+		Convey("simplify logical operators", func() {
+			// true predicate in $or makes the whole relation true
+			res, c, err := reduceEnforceObject(map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"$group": "admin"},
+					map[string]interface{}{"owner": "$user_id"},
+				},
+			}, s)
+			So(err, ShouldBeNil)
+			So(c, ShouldNotBeNil)
+			So(*c, ShouldBeTrue)
+			So(res, ShouldBeNil)
+
+			// false predicate in $or is dropped as neutral element
+			res, c, err = reduceEnforceObject(map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"$group": "staff"},
+					map[string]interface{}{"owner": "$user_id"},
+				},
+				"public": true,
+			}, s)
+			So(err, ShouldBeNil)
+			So(c, ShouldBeNil)
+			So(res, ShouldResemble, map[string]interface{}{
+				"$or": []interface{}{
+					map[string]interface{}{"owner": "$user_id"},
+				},
+				"public": true,
+			})
+
+			// true predicate in $and is dropped as neutral element
+			res, c, err = reduceEnforceObject(map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"$user": "alice"},
+					map[string]interface{}{"owner": "$user_id"},
+				},
+			}, s)
+			So(err, ShouldBeNil)
+			So(c, ShouldBeNil)
+			So(res, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"owner": "$user_id"},
+				},
+			})
+		})
+
+		Convey("invalid predicates", func() {
+			_, _, err := reduceEnforceObject(map[string]interface{}{"$group": 1}, s)
+			So(err, ShouldNotBeNil)
+			_, _, err = reduceEnforceObject(map[string]interface{}{"$or": []interface{}{}}, s)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestInjectMagicVars(t *testing.T) {
+	Convey("inject magic vars", t, func() {
+		vars := map[string]interface{}{
+			"user_id": "alice",
+			"now":     int64(1000),
+		}
+
+		So(InjectMagicVars(nil, vars), ShouldBeNil)
+		So(InjectMagicVars(map[string]interface{}{
+			"owner":   "$user_id",
+			"editor":  "${user_id}",
+			"expired": map[string]interface{}{"$lt": "$now"},
+			"tags":    []interface{}{"$unknown", "user_id", 1},
+		}, vars), ShouldResemble, map[string]interface{}{
+			"owner":   "alice",
+			"editor":  "alice",
+			"expired": map[string]interface{}{"$lt": int64(1000)},
+			"tags":    []interface{}{"$unknown", "user_id", 1},
+		})
+	})
+}
+
+func TestEnforceRulesOnFilter(t *testing.T) {
+	Convey("enforce rules on filter", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"groups": map[string]interface{}{
+				"admin": []interface{}{"alice"},
+				"staff": []interface{}{"bob", "carol"},
+			},
+			"rules": map[string]interface{}{
+				"article": map[string]interface{}{
+					"find": map[string]interface{}{
+						"g:staff": map[string]interface{}{"public": true},
+						"u:bob":   map[string]interface{}{"owner": "$user_id"},
+						"default": map[string]interface{}{
+							"$or": []interface{}{
+								map[string]interface{}{"$state": UserStateLoggedIn},
+								map[string]interface{}{"public": true},
+							},
+						},
+					},
+					"update": map[string]interface{}{
+						"filter": map[string]interface{}{
+							"g:admin": map[string]interface{}{},
+							"default": map[string]interface{}{"owner": "$user_id"},
+						},
+					},
+					"remove": map[string]interface{}{
+						"g:admin": map[string]interface{}{},
+						"g:staff": map[string]interface{}{"$user": "carol"},
+					},
+				},
+			},
+		})
+		vars := map[string]interface{}{"user_id": "bob"}
+
+		Convey("merge all matched rules with user filter", func() {
+			filter, err := r.EnforceRulesOnFilter(map[string]interface{}{"id": 1},
+				"article", "bob", UserStateLoggedIn, vars, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"public": true},
+					map[string]interface{}{"owner": "bob"},
+					map[string]interface{}{"id": 1},
+				},
+			})
+		})
+
+		Convey("constant true rule keeps user filter as is", func() {
+			filter, err := r.EnforceRulesOnFilter(map[string]interface{}{"id": 1},
+				"article", "dave", UserStateLoggedIn, nil, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{"id": 1})
+
+			filter, err = r.EnforceRulesOnFilter(nil,
+				"article", "dave", UserStateAnonymous, nil, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{
+						"$or": []interface{}{map[string]interface{}{"public": true}},
+					},
+				},
+			})
+		})
+
+		Convey("rules of requested query type are applied", func() {
+			// admin has open update rule but no find rule
+			filter, err := r.EnforceRulesOnFilter(nil,
+				"article", "alice", UserStateLoggedIn, nil, RuleQueryUpdate)
+			So(err, ShouldBeNil)
+			So(filter, ShouldBeNil)
+
+			filter, err = r.EnforceRulesOnFilter(nil,
+				"article", "alice", UserStateLoggedIn, map[string]interface{}{"user_id": "alice"}, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldBeNil)
+
+			// default update rule applies to staff, not the find rules of staff
+			filter, err = r.EnforceRulesOnFilter(nil,
+				"article", "bob", UserStateLoggedIn, vars, RuleQueryUpdate)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{"owner": "bob"},
+				},
+			})
+
+			// no count rules declared, open privilege
+			filter, err = r.EnforceRulesOnFilter(map[string]interface{}{"id": 1},
+				"article", "bob", UserStateLoggedIn, vars, RuleQueryCount)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{"id": 1})
+		})
+
+		Convey("false subject predicate denies the query", func() {
+			_, err := r.EnforceRulesOnFilter(nil,
+				"article", "bob", UserStateLoggedIn, vars, RuleQueryRemove)
+			So(err, ShouldNotBeNil)
+
+			filter, err := r.EnforceRulesOnFilter(nil,
+				"article", "carol", UserStateLoggedIn, nil, RuleQueryRemove)
+			So(err, ShouldBeNil)
+			So(filter, ShouldBeNil)
+		})
+
+		Convey("tables without rules are open", func() {
+			filter, err := r.EnforceRulesOnFilter(map[string]interface{}{"id": 1},
+				"comment", "dave", UserStateAnonymous, nil, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{"id": 1})
+		})
+	})
+}
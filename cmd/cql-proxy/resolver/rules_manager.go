@@ -0,0 +1,140 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const (
+	// rulesUpdateChanSize defines the buffer size of each rules update subscription channel.
+	rulesUpdateChanSize = 16
+)
+
+// RulesUpdate defines the rules update event sent to rules manager subscribers.
+type RulesUpdate struct {
+	DatabaseID proto.DatabaseID
+	Rules      *Rules // nil means the cached rules of database is invalidated
+}
+
+// RulesManager defines the rules manger object for project rules cache.
+type RulesManager struct {
+	rules sync.Map // map[proto.DatabaseID]*Rules
+
+	subscriberLock sync.RWMutex
+	subscribers    map[chan *RulesUpdate]struct{}
+}
+
+// Get returns the rules object of specified database.
+func (m *RulesManager) Get(dbID proto.DatabaseID) *Rules {
+	if v, ok := m.rules.Load(dbID); ok && v != nil {
+		return v.(*Rules)
+	}
+
+	return nil
+}
+
+// Set update the global rules cache with new rules object for specified database.
+func (m *RulesManager) Set(dbID proto.DatabaseID, rules *Rules) {
+	m.rules.Store(dbID, rules)
+	m.notify(dbID, rules)
+}
+
+// Remove invalidates the cached rules of specified database, rules will be re-compiled on next access.
+func (m *RulesManager) Remove(dbID proto.DatabaseID) {
+	m.rules.Delete(dbID)
+	m.notify(dbID, nil)
+}
+
+// Reload re-compiles raw rules config and atomically swaps the cached rules of specified database.
+// The previous rules object is kept untouched if the new config could not be compiled.
+func (m *RulesManager) Reload(dbID proto.DatabaseID, rules json.RawMessage, groups GroupQuerier) (
+	r *Rules, err error) {
+	if r, err = m.Compile(dbID, rules, groups); err != nil {
+		return
+	}
+
+	m.Set(dbID, r)
+
+	return
+}
+
+// Compile compiles raw rules config of specified database without touching the cached rules,
+// the group provider of rules is bound to the groups querier if a group source is configured.
+func (m *RulesManager) Compile(dbID proto.DatabaseID, rules json.RawMessage, groups GroupQuerier) (
+	r *Rules, err error) {
+	r, err = CompileRawRules(rules)
+	if err != nil {
+		err = errors.Wrapf(err, "compile rules of database %s failed", dbID)
+		return
+	}
+	if r == nil {
+		err = errors.Errorf("empty rules config of database %s", dbID)
+		return
+	}
+	if gs := r.GroupSource(); gs != nil && groups != nil {
+		r.SetGroupProvider(NewTableGroupProvider(groups, gs))
+	}
+
+	return
+}
+
+// Watch subscribes rules updates of all databases, the returned cancel function must be called
+// to release the subscription. Updates are dropped for slow subscribers with full channel buffer.
+func (m *RulesManager) Watch() (ch <-chan *RulesUpdate, cancel func()) {
+	c := make(chan *RulesUpdate, rulesUpdateChanSize)
+
+	m.subscriberLock.Lock()
+	if m.subscribers == nil {
+		m.subscribers = make(map[chan *RulesUpdate]struct{})
+	}
+	m.subscribers[c] = struct{}{}
+	m.subscriberLock.Unlock()
+
+	var once sync.Once
+
+	cancel = func() {
+		once.Do(func() {
+			m.subscriberLock.Lock()
+			defer m.subscriberLock.Unlock()
+			delete(m.subscribers, c)
+			close(c)
+		})
+	}
+
+	ch = c
+
+	return
+}
+
+func (m *RulesManager) notify(dbID proto.DatabaseID, rules *Rules) {
+	m.subscriberLock.RLock()
+	defer m.subscriberLock.RUnlock()
+
+	for c := range m.subscribers {
+		select {
+		case c <- &RulesUpdate{DatabaseID: dbID, Rules: rules}:
+		default:
+			// subscriber is too slow, drop the update
+		}
+	}
+}
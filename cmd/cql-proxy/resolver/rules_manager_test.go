@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+type fakeGroupQuerier struct{}
+
+func (fakeGroupQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestRulesManager(t *testing.T) {
+	Convey("rules manager", t, func() {
+		var (
+			m    = &RulesManager{}
+			dbID = proto.DatabaseID("db")
+		)
+
+		So(m.Get(dbID), ShouldBeNil)
+
+		ch, cancel := m.Watch()
+		defer cancel()
+
+		r, err := m.Reload(dbID, json.RawMessage(`{"rules": {"t": {"find": {"default": {"owner": "$user_id"}}}}}`), nil)
+		So(err, ShouldBeNil)
+		So(r, ShouldNotBeNil)
+		So(m.Get(dbID), ShouldEqual, r)
+		update := <-ch
+		So(update.DatabaseID, ShouldEqual, dbID)
+		So(update.Rules, ShouldEqual, r)
+
+		Convey("invalid rules should not replace the cached rules", func() {
+			_, err = m.Reload(dbID, json.RawMessage(`{"rules": {"t": {"find": {"g:unknown": {}}}}}`), nil)
+			So(err, ShouldNotBeNil)
+			_, err = m.Reload(dbID, json.RawMessage(`null`), nil)
+			So(err, ShouldNotBeNil)
+			So(m.Get(dbID), ShouldEqual, r)
+			So(ch, ShouldHaveLength, 0)
+		})
+		Convey("compiled rules should not be cached until set", func() {
+			var compiled *Rules
+			compiled, err = m.Compile(dbID, json.RawMessage(`{"rules": {}}`), nil)
+			So(err, ShouldBeNil)
+			So(m.Get(dbID), ShouldEqual, r)
+			m.Set(dbID, compiled)
+			So(m.Get(dbID), ShouldEqual, compiled)
+			So((<-ch).Rules, ShouldEqual, compiled)
+		})
+		Convey("removed rules should be notified as invalidated", func() {
+			m.Remove(dbID)
+			So(m.Get(dbID), ShouldBeNil)
+			update = <-ch
+			So(update.DatabaseID, ShouldEqual, dbID)
+			So(update.Rules, ShouldBeNil)
+		})
+		Convey("group provider should be bound for group source", func() {
+			r, err = m.Reload(dbID, json.RawMessage(`{"group_source": {
+				"table": "members", "user_column": "uid", "group_column": "gid"
+			}}`), &fakeGroupQuerier{})
+			So(err, ShouldBeNil)
+			So(r.GroupSource(), ShouldNotBeNil)
+			So(r.groupProvider, ShouldNotBeNil)
+		})
+		Convey("canceled subscription should be closed", func() {
+			cancel()
+			cancel()
+			_, ok := <-ch
+			So(ok, ShouldBeFalse)
+			m.Remove(dbID)
+		})
+	})
+}
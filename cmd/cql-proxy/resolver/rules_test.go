@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCustomUserStates(t *testing.T) {
+	Convey("custom user states", t, func() {
+		r := mustCompileRules(map[string]interface{}{
+			"states": []interface{}{"VIP", "s:trial"},
+			"rules": map[string]interface{}{
+				"article": map[string]interface{}{
+					"find": map[string]interface{}{
+						"s:vip": map[string]interface{}{},
+						"default": map[string]interface{}{
+							"$or": []interface{}{
+								map[string]interface{}{"$state": "trial"},
+								map[string]interface{}{"public": true},
+							},
+						},
+					},
+				},
+			},
+		})
+
+		Convey("custom state takes effect for logged in user only", func() {
+			So(r.ResolveUserState(UserStateLoggedIn, "vip"), ShouldEqual, "vip")
+			So(r.ResolveUserState(UserStateLoggedIn, "Trial"), ShouldEqual, "trial")
+			So(r.ResolveUserState(UserStateLoggedIn, "unknown"), ShouldEqual, UserStateLoggedIn)
+			So(r.ResolveUserState(UserStateLoggedIn, ""), ShouldEqual, UserStateLoggedIn)
+			So(r.ResolveUserState(UserStateDisabled, "vip"), ShouldEqual, UserStateDisabled)
+			So(r.ResolveUserState(UserStateAnonymous, "vip"), ShouldEqual, UserStateAnonymous)
+		})
+		Convey("custom state rules should be enforced", func() {
+			filter, err := r.EnforceRulesOnFilter(nil, "article", "bob", "vip", nil, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldBeEmpty)
+
+			filter, err = r.EnforceRulesOnFilter(nil, "article", "bob", "trial", nil, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldBeEmpty)
+
+			filter, err = r.EnforceRulesOnFilter(nil, "article", "bob", UserStateLoggedIn, nil, RuleQueryFind)
+			So(err, ShouldBeNil)
+			So(filter, ShouldResemble, map[string]interface{}{
+				"$and": []interface{}{
+					map[string]interface{}{
+						"$or": []interface{}{
+							map[string]interface{}{"public": true},
+						},
+					},
+				},
+			})
+		})
+		Convey("invalid custom states should be rejected", func() {
+			for _, states := range [][]interface{}{
+				{"1vip"},
+				{"v-i-p"},
+				{""},
+				{UserStateLoggedIn},
+				{"s:" + UserStateAnonymous},
+			} {
+				_, err := CompileRules(map[string]interface{}{"states": states})
+				So(err, ShouldNotBeNil)
+			}
+
+			_, err := CompileRules(map[string]interface{}{
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{"s:vip": map[string]interface{}{}},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// RulesTestCase defines a single access scenario to verify against rules.
+type RulesTestCase struct {
+	Name   string                 `json:"name"`
+	Table  string                 `json:"table"`
+	Type   string                 `json:"type"`
+	UserID string                 `json:"uid"`
+	State  string                 `json:"state"`
+	Groups []string               `json:"groups"` // replaces groups of user in scenario if not nil
+	Vars   map[string]interface{} `json:"vars"`   // user_id defaults to uid of scenario
+	Filter map[string]interface{} `json:"filter"`
+	Data   map[string]interface{} `json:"data"` // update object or insert data
+	Expect RulesTestExpect        `json:"expect"`
+}
+
+// RulesTestExpect defines the expected enforcement outcome of test case, nil fields are not checked.
+type RulesTestExpect struct {
+	Allowed *bool                  `json:"allowed"`
+	Filter  map[string]interface{} `json:"filter"`
+	Update  map[string]interface{} `json:"update"`
+	Insert  map[string]interface{} `json:"insert"`
+	Mask    ColumnMask             `json:"mask"`
+}
+
+// RulesTestResult defines the test result of single test case.
+type RulesTestResult struct {
+	Name        string       `json:"name"`
+	Passed      bool         `json:"passed"`
+	Failures    []string     `json:"failures,omitempty"`
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// RulesTestReport defines the test results of all test cases.
+type RulesTestReport struct {
+	Passed  int                `json:"passed"`
+	Failed  int                `json:"failed"`
+	Results []*RulesTestResult `json:"results"`
+}
+
+// TestRules compiles the raw rules and verifies all scenario cases against it.
+func TestRules(rules json.RawMessage, cases []*RulesTestCase) (report *RulesTestReport, err error) {
+	r, err := CompileRawRules(rules)
+	if err != nil {
+		err = errors.Wrapf(err, "compile rules failed")
+		return
+	}
+	if r == nil {
+		err = errors.New("empty rules config")
+		return
+	}
+
+	return r.Test(cases)
+}
+
+// Test verifies all scenario cases against the rules object.
+func (r *Rules) Test(cases []*RulesTestCase) (report *RulesTestReport, err error) {
+	report = &RulesTestReport{}
+
+	for i, tc := range cases {
+		if tc == nil {
+			err = errors.Errorf("nil test case #%d", i)
+			return
+		}
+
+		result := r.runTestCase(tc)
+		if result.Name == "" {
+			result.Name = fmt.Sprintf("case #%d", i)
+		}
+
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+
+		report.Results = append(report.Results, result)
+	}
+
+	return
+}
+
+func (r *Rules) runTestCase(tc *RulesTestCase) (result *RulesTestResult) {
+	result = &RulesTestResult{
+		Name: tc.Name,
+	}
+
+	defer func() {
+		result.Passed = len(result.Failures) == 0
+	}()
+
+	qt, err := ParseRuleQueryType(tc.Type)
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return
+	}
+
+	// use scenario groups instead of resolved groups
+	sr := r
+	if tc.Groups != nil {
+		rc := *r
+		rc.userGroups = map[string][]string{tc.UserID: tc.Groups}
+		rc.groupProvider = nil
+		rc.claimGroups = nil
+		sr = &rc
+	}
+
+	vars := make(map[string]interface{}, len(tc.Vars)+1)
+	if tc.UserID != "" {
+		vars["user_id"] = tc.UserID
+	}
+	for k, v := range tc.Vars {
+		vars[k] = v
+	}
+
+	// request is not available in scenario tests
+	vars, err = ResolveMagicVars(&MagicVarContext{
+		Table:     tc.Table,
+		UserID:    tc.UserID,
+		UserState: tc.State,
+		Vars:      vars,
+	})
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return
+	}
+
+	e, err := sr.Explain(tc.Table, qt, tc.UserID, tc.State, tc.Filter, tc.Data, vars)
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return
+	}
+
+	result.Explanation = e
+
+	if tc.Expect.Allowed != nil && *tc.Expect.Allowed != e.Allowed {
+		result.Failures = append(result.Failures,
+			fmt.Sprintf("expect allowed to be %v, got %v: %s", *tc.Expect.Allowed, e.Allowed, e.Reason))
+	}
+
+	for _, c := range []struct {
+		name   string
+		expect interface{}
+		actual interface{}
+	}{
+		{"filter", tc.Expect.Filter, e.Filter},
+		{"update", tc.Expect.Update, e.Update},
+		{"insert", tc.Expect.Insert, e.Insert},
+		{"mask", tc.Expect.Mask, e.Mask},
+	} {
+		if reflect.ValueOf(c.expect).IsNil() {
+			continue
+		}
+
+		if equal, err := jsonEqual(c.expect, c.actual); err != nil {
+			result.Failures = append(result.Failures, err.Error())
+		} else if !equal {
+			result.Failures = append(result.Failures,
+				fmt.Sprintf("%s mismatch, expect %s, got %s", c.name, mustJSON(c.expect), mustJSON(c.actual)))
+		}
+	}
+
+	return
+}
+
+// jsonEqual compares two objects in json form to normalize number types and compiled rules objects.
+func jsonEqual(a interface{}, b interface{}) (equal bool, err error) {
+	var na, nb interface{}
+
+	if err = json.Unmarshal(mustJSON(a), &na); err != nil {
+		return
+	}
+	if err = json.Unmarshal(mustJSON(b), &nb); err != nil {
+		return
+	}
+
+	// treat empty object same as null
+	if isEmptyObject(na) && isEmptyObject(nb) {
+		equal = true
+		return
+	}
+
+	equal = reflect.DeepEqual(na, nb)
+
+	return
+}
+
+func isEmptyObject(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	m, ok := v.(map[string]interface{})
+	return ok && len(m) == 0
+}
+
+func mustJSON(v interface{}) []byte {
+	d, _ := json.Marshal(v)
+	return d
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testHarnessRules = `{
+	"groups": {"admin": ["alice"]},
+	"rules": {
+		"article": {
+			"find": {
+				"g:admin": {},
+				"default": {"owner": "$user_id"}
+			},
+			"update": {
+				"filter": {"default": {"owner": "$user_id"}},
+				"update": {"default": {"$set": {"editor": "$user_id"}}}
+			},
+			"mask": {
+				"default": {"secret": "redact"}
+			}
+		}
+	}
+}`
+
+func TestRulesTestHarness(t *testing.T) {
+	Convey("rules test harness", t, func() {
+		var (
+			allowed = true
+			denied  = false
+		)
+
+		Convey("run scenario cases", func() {
+			var cases []*RulesTestCase
+			So(json.Unmarshal([]byte(`[
+				{
+					"name": "owner find",
+					"table": "article",
+					"type": "find",
+					"uid": "bob",
+					"state": "logged_in",
+					"filter": {"id": 1},
+					"expect": {
+						"allowed": true,
+						"filter": {"$and": [{"owner": "bob"}, {"id": 1}]},
+						"mask": {"secret": "redact"}
+					}
+				},
+				{
+					"table": "article",
+					"type": "update",
+					"uid": "bob",
+					"state": "logged_in",
+					"data": {"$set": {"title": "t"}},
+					"expect": {
+						"filter": {"$and": [{"owner": "bob"}]},
+						"update": {"$set": {"title": "t", "editor": "bob"}}
+					}
+				}
+			]`), &cases), ShouldBeNil)
+
+			cases = append(cases,
+				&RulesTestCase{
+					Name:   "admin by scenario groups",
+					Table:  "article",
+					Type:   "find",
+					UserID: "bob",
+					State:  UserStateLoggedIn,
+					Groups: []string{"admin"},
+					Expect: RulesTestExpect{Allowed: &allowed, Filter: map[string]interface{}{}},
+				},
+				&RulesTestCase{
+					Name:   "mismatched expectation",
+					Table:  "article",
+					Type:   "find",
+					UserID: "bob",
+					State:  UserStateLoggedIn,
+					Expect: RulesTestExpect{
+						Allowed: &denied,
+						Filter:  map[string]interface{}{"owner": "alice"},
+					},
+				},
+				&RulesTestCase{
+					Name:  "invalid query type",
+					Table: "article",
+					Type:  "drop",
+				},
+			)
+
+			report, err := TestRules(json.RawMessage(testHarnessRules), cases)
+			So(err, ShouldBeNil)
+			So(report.Passed, ShouldEqual, 3)
+			So(report.Failed, ShouldEqual, 2)
+			So(report.Results, ShouldHaveLength, 5)
+
+			So(report.Results[0].Name, ShouldEqual, "owner find")
+			So(report.Results[0].Passed, ShouldBeTrue)
+			So(report.Results[0].Explanation, ShouldNotBeNil)
+			So(report.Results[1].Name, ShouldEqual, "case #1")
+			So(report.Results[1].Passed, ShouldBeTrue)
+			So(report.Results[2].Passed, ShouldBeTrue)
+			So(report.Results[2].Explanation.Rules[0].Subject, ShouldEqual, "g:admin")
+
+			So(report.Results[3].Passed, ShouldBeFalse)
+			So(report.Results[3].Failures, ShouldHaveLength, 2)
+			So(report.Results[4].Passed, ShouldBeFalse)
+			So(report.Results[4].Failures, ShouldHaveLength, 1)
+			So(report.Results[4].Explanation, ShouldBeNil)
+		})
+
+		Convey("scenario groups do not leak to rules", func() {
+			r, err := CompileRawRules(json.RawMessage(testHarnessRules))
+			So(err, ShouldBeNil)
+
+			_, err = r.Test([]*RulesTestCase{{
+				Table:  "article",
+				Type:   "find",
+				UserID: "bob",
+				State:  UserStateLoggedIn,
+				Groups: []string{"admin"},
+			}})
+			So(err, ShouldBeNil)
+			So(r.userGroups["bob"], ShouldBeNil)
+		})
+
+		Convey("invalid arguments", func() {
+			_, err := TestRules(json.RawMessage(`{"rules": {"article": {"find": {"x:y": {}}}}}`), nil)
+			So(err, ShouldNotBeNil)
+			_, err = TestRules(json.RawMessage(`null`), nil)
+			So(err, ShouldNotBeNil)
+
+			r, err := CompileRawRules(json.RawMessage(testHarnessRules))
+			So(err, ShouldBeNil)
+			_, err = r.Test([]*RulesTestCase{nil})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestJSONEqual(t *testing.T) {
+	Convey("json equal", t, func() {
+		equal, err := jsonEqual(map[string]interface{}{"a": 1}, map[string]interface{}{"a": float64(1)})
+		So(err, ShouldBeNil)
+		So(equal, ShouldBeTrue)
+
+		equal, err = jsonEqual(map[string]interface{}{}, nil)
+		So(err, ShouldBeNil)
+		So(equal, ShouldBeTrue)
+
+		equal, err = jsonEqual(map[string]interface{}{"a": 1}, map[string]interface{}{"a": "1"})
+		So(err, ShouldBeNil)
+		So(equal, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// rulesPathEscaper escapes rules key as json pointer reference token.
+var rulesPathEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// RulesDiff defines a single changed item between two rules versions.
+type RulesDiff struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // added/removed/changed
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// DiffRawRules returns the changes between two raw rules configs.
+func DiffRawRules(from json.RawMessage, to json.RawMessage) (diffs []*RulesDiff, err error) {
+	var fromObj, toObj interface{}
+
+	if err = json.Unmarshal(from, &fromObj); err != nil {
+		err = errors.Wrapf(err, "decode rules failed")
+		return
+	}
+	if err = json.Unmarshal(to, &toObj); err != nil {
+		err = errors.Wrapf(err, "decode rules failed")
+		return
+	}
+
+	fromItems, toItems := map[string]interface{}{}, map[string]interface{}{}
+	flattenRules("", fromObj, fromItems)
+	flattenRules("", toObj, toItems)
+
+	for path, fv := range fromItems {
+		if tv, ok := toItems[path]; !ok {
+			diffs = append(diffs, &RulesDiff{Path: path, Op: "removed", From: fv})
+		} else if !reflect.DeepEqual(fv, tv) {
+			diffs = append(diffs, &RulesDiff{Path: path, Op: "changed", From: fv, To: tv})
+		}
+	}
+
+	for path, tv := range toItems {
+		if _, ok := fromItems[path]; !ok {
+			diffs = append(diffs, &RulesDiff{Path: path, Op: "added", To: tv})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+
+	return
+}
+
+func flattenRules(prefix string, v interface{}, items map[string]interface{}) {
+	switch rv := v.(type) {
+	case map[string]interface{}:
+		if len(rv) == 0 {
+			items[prefix] = rv
+			return
+		}
+		for k, cv := range rv {
+			flattenRules(prefix+"/"+rulesPathEscaper.Replace(k), cv, items)
+		}
+	case []interface{}:
+		if len(rv) == 0 {
+			items[prefix] = rv
+			return
+		}
+		for i, cv := range rv {
+			flattenRules(fmt.Sprintf("%s/%d", prefix, i), cv, items)
+		}
+	default:
+		items[prefix] = v
+	}
+}
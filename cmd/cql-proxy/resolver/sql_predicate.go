@@ -0,0 +1,203 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/CovenantSQL/sqlparser"
+	"github.com/pkg/errors"
+)
+
+var sqlPredicateVarRegex = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// SQLPredicate defines the compiled sql expression rule which is AND-ed to generated where clause.
+// Predicate could only be declared in rules, filter object from query could not contain sql predicates.
+type SQLPredicate struct {
+	raw       string
+	statement string
+	fields    FieldMap
+	vars      []string
+
+	// bound arguments after magic vars injection
+	bound   bool
+	args    []interface{}
+	missing []string
+}
+
+// CompileSQLPredicate parses sql predicate string with ${var} placeholders to predicate object.
+func CompileSQLPredicate(raw string) (p *SQLPredicate, err error) {
+	if strings.TrimSpace(raw) == "" {
+		err = errors.New("empty sql predicate")
+		return
+	}
+
+	stmt, err := sqlparser.Parse("SELECT 1 FROM t WHERE " +
+		sqlPredicateVarRegex.ReplaceAllString(raw, ":$1"))
+	if err != nil {
+		err = errors.Wrapf(err, "parse sql predicate failed")
+		return
+	}
+
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || sel.Where == nil || sel.Limit != nil || sel.OrderBy != nil || sel.GroupBy != nil {
+		err = errors.Errorf("invalid sql predicate: %s", raw)
+		return
+	}
+
+	p = &SQLPredicate{
+		raw:    raw,
+		fields: FieldMap{},
+	}
+
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch n := node.(type) {
+		case *sqlparser.Subquery, *sqlparser.ExistsExpr:
+			err = errors.New("sub query is not supported in sql predicate")
+			return
+		case *sqlparser.FuncExpr:
+			if name := n.Name.Lowered(); strings.HasPrefix(name, "sqlite") || name == "load_extension" {
+				err = errors.Errorf("function %s is not supported in sql predicate", name)
+				return
+			}
+		case *sqlparser.ColName:
+			if !n.Qualifier.IsEmpty() {
+				err = errors.New("qualified column is not supported in sql predicate")
+				return
+			}
+			p.fields[n.Name.String()] = true
+		}
+		return true, nil
+	}, sel.Where.Expr)
+	if err != nil {
+		p = nil
+		return
+	}
+
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		switch n := node.(type) {
+		case *sqlparser.ColName:
+			buf.WriteString(`"` + strings.Replace(n.Name.String(), `"`, `""`, -1) + `"`)
+		case *sqlparser.SQLVal:
+			if n.Type == sqlparser.ValArg {
+				p.vars = append(p.vars, strings.TrimPrefix(string(n.Val), ":"))
+				buf.WriteString("?")
+				return
+			}
+			n.Format(buf)
+		default:
+			node.Format(buf)
+		}
+	})
+	p.statement = buf.WriteNode(sel.Where.Expr).String()
+
+	return
+}
+
+// String returns the original predicate string.
+func (p *SQLPredicate) String() string {
+	return p.raw
+}
+
+// MarshalJSON implements json.Marshaler for rules explanation.
+func (p *SQLPredicate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.raw)
+}
+
+// Bind returns new predicate object bound with magic vars as statement arguments.
+func (p *SQLPredicate) Bind(vars map[string]interface{}) (bp *SQLPredicate) {
+	bp = &SQLPredicate{
+		raw:       p.raw,
+		statement: p.statement,
+		fields:    p.fields,
+		vars:      p.vars,
+		bound:     true,
+	}
+
+	for _, name := range p.vars {
+		v, ok := vars[name]
+		if !ok {
+			bp.missing = append(bp.missing, name)
+		}
+		bp.args = append(bp.args, v)
+	}
+
+	return
+}
+
+// Resolve returns the where condition statement of predicate.
+func (p *SQLPredicate) Resolve(availFields FieldMap) (
+	fields FieldMap, statement string, args []interface{}, err error) {
+	if len(p.vars) > 0 && !p.bound {
+		err = errors.Errorf("sql predicate %s is not bound with variables", p.raw)
+		return
+	}
+	if len(p.missing) > 0 {
+		err = errors.Errorf("unknown variables %s in sql predicate", strings.Join(p.missing, ","))
+		return
+	}
+
+	fields = FieldMap{}
+
+	for k := range p.fields {
+		if !availFields[k] {
+			err = errors.Errorf("unknown field: %s", k)
+			return
+		}
+		fields[k] = true
+	}
+
+	statement = p.statement
+	args = append(args, p.args...)
+
+	return
+}
+
+// compileSQLPredicates converts the sql predicate strings in enforce object to predicate objects.
+func compileSQLPredicates(o map[string]interface{}) (err error) {
+	for k, v := range o {
+		switch k {
+		case "$sql":
+			var (
+				raw string
+				ok  bool
+			)
+			if raw, ok = v.(string); !ok {
+				err = errors.New("$sql operator requires string argument")
+				return
+			}
+			if o[k], err = CompileSQLPredicate(raw); err != nil {
+				return
+			}
+		case "$and", "$or", "$nor":
+			var childQuery []map[string]interface{}
+			if childQuery, err = getNonEmptyArrayOfObjects(v); err != nil {
+				err = errors.Wrapf(err, "%s operator", k)
+				return
+			}
+			for _, cq := range childQuery {
+				if err = compileSQLPredicates(cq); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolver
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompileSQLPredicate(t *testing.T) {
+	Convey("compile sql predicate", t, func() {
+		availFields := FieldMap{"owner": true, "score": true, "name": true}
+
+		Convey("valid predicate", func() {
+			p, err := CompileSQLPredicate("owner = ${user_id} AND length(name) > 3")
+			So(err, ShouldBeNil)
+			So(p.String(), ShouldEqual, "owner = ${user_id} AND length(name) > 3")
+
+			d, err := p.MarshalJSON()
+			So(err, ShouldBeNil)
+			So(d, ShouldResemble, mustJSON("owner = ${user_id} AND length(name) > 3"))
+
+			// variables must be bound before resolving
+			_, _, _, err = p.Resolve(availFields)
+			So(err, ShouldNotBeNil)
+
+			_, _, _, err = p.Bind(nil).Resolve(availFields)
+			So(err, ShouldNotBeNil)
+
+			fields, statement, args, err := p.Bind(map[string]interface{}{"user_id": "alice"}).Resolve(availFields)
+			So(err, ShouldBeNil)
+			So(fields, ShouldResemble, FieldMap{"owner": true, "name": true})
+			So(statement, ShouldEqual, `"owner" = ? and length("name") > 3`)
+			So(args, ShouldResemble, []interface{}{"alice"})
+
+			_, _, _, err = p.Bind(map[string]interface{}{"user_id": "alice"}).Resolve(FieldMap{"owner": true})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("invalid predicate", func() {
+			for _, raw := range []string{
+				"",
+				"  ",
+				"owner = ",
+				"1 = 1 ORDER BY owner",
+				"owner IN (SELECT owner FROM admins)",
+				"EXISTS (SELECT 1 FROM admins)",
+				"t.owner = ${user_id}",
+				"load_extension('evil.so') = 1",
+				"LOAD_EXTENSION('evil.so') = 1",
+				"sqlite_version() = '3'",
+				"sqlite_source_id() <> ''",
+			} {
+				p, err := CompileSQLPredicate(raw)
+				So(err, ShouldNotBeNil)
+				So(p, ShouldBeNil)
+			}
+		})
+
+		Convey("predicates in rules", func() {
+			r := mustCompileRules(map[string]interface{}{
+				"rules": map[string]interface{}{
+					"article": map[string]interface{}{
+						"find": map[string]interface{}{
+							"default": map[string]interface{}{
+								"$or": []interface{}{
+									map[string]interface{}{"$sql": "owner = ${user_id}"},
+								},
+							},
+						},
+					},
+				},
+			})
+
+			filter, err := r.EnforceRulesOnFilter(nil, "article", "alice", UserStateLoggedIn,
+				map[string]interface{}{"user_id": "alice"}, RuleQueryFind)
+			So(err, ShouldBeNil)
+
+			fields, statement, args, err := ResolveFilter(filter, availFields)
+			So(err, ShouldBeNil)
+			So(fields, ShouldResemble, FieldMap{"owner": true})
+			So(statement, ShouldEqual, `((((("owner" = ?)))))`)
+			So(args, ShouldResemble, []interface{}{"alice"})
+
+			for _, sql := range []interface{}{
+				1,
+				"owner IN (SELECT owner FROM admins)",
+				"sqlite_version() = '3'",
+			} {
+				_, err = CompileRules(map[string]interface{}{
+					"rules": map[string]interface{}{
+						"article": map[string]interface{}{
+							"find": map[string]interface{}{
+								"default": map[string]interface{}{"$sql": sql},
+							},
+						},
+					},
+				})
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("predicates from query filter are rejected", func() {
+			_, _, _, err := ResolveFilter(map[string]interface{}{"$sql": "1 = 1"}, availFields)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
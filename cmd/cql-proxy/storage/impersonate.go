@@ -98,9 +98,26 @@ type impersonatedDB struct {
 	key    *asymmetric.PrivateKey
 }
 
-func (d *impersonatedDB) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (
+type impersonatedConn struct {
+	db *impersonatedDB
+
+	inTransaction bool
+	queries       []types.Query
+	txResult      *impersonatedResult
+}
+
+func (c *impersonatedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (
 	result driver.Result, err error) {
-	resp, err := d.sendQuery(query, args, types.WriteQuery)
+	q := buildQuery(query, args)
+
+	if c.inTransaction {
+		// enqueue query, result is available after transaction commits
+		c.queries = append(c.queries, q)
+		result = c.txResult
+		return
+	}
+
+	resp, err := c.db.sendQuery([]types.Query{q}, types.WriteQuery)
 	if err != nil {
 		err = errors.Wrapf(err, "send query failed")
 		return
@@ -114,9 +131,14 @@ func (d *impersonatedDB) ExecContext(ctx context.Context, query string, args []d
 	return
 }
 
-func (d *impersonatedDB) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (
+func (c *impersonatedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (
 	rows driver.Rows, err error) {
-	resp, err := d.sendQuery(query, args, types.ReadQuery)
+	if c.inTransaction {
+		err = errors.New("read query is not supported in transaction")
+		return
+	}
+
+	resp, err := c.db.sendQuery([]types.Query{buildQuery(query, args)}, types.ReadQuery)
 	if err != nil {
 		err = errors.Wrapf(err, "send query failed")
 		return
@@ -131,22 +153,88 @@ func (d *impersonatedDB) QueryContext(ctx context.Context, query string, args []
 	return
 }
 
-func (d *impersonatedDB) sendQuery(query string, args []driver.NamedValue, queryType types.QueryType) (
-	resp *types.Response, err error) {
-	var (
-		connID, seqNo = allocateConnAndSeq()
-		dArgs         []types.NamedArg
-	)
+func (c *impersonatedConn) Prepare(query string) (driver.Stmt, error) {
+	return &impersonatedStmt{
+		conn:  c,
+		query: query,
+	}, nil
+}
 
-	defer putBackConn(connID)
+func (c *impersonatedConn) Close() error {
+	return nil
+}
+
+// Begin starts a transaction, queries in transaction are sent in a single request and executed atomically.
+func (c *impersonatedConn) Begin() (driver.Tx, error) {
+	if c.inTransaction {
+		return nil, sql.ErrTxDone
+	}
+
+	c.inTransaction = true
+	c.queries = c.queries[:0]
+	c.txResult = &impersonatedResult{}
+
+	return c, nil
+}
+
+func (c *impersonatedConn) Commit() (err error) {
+	if !c.inTransaction {
+		return sql.ErrTxDone
+	}
+
+	defer func() {
+		c.queries = c.queries[:0]
+		c.inTransaction = false
+	}()
+
+	if len(c.queries) == 0 {
+		return
+	}
+
+	resp, err := c.db.sendQuery(c.queries, types.WriteQuery)
+	if err != nil {
+		err = errors.Wrapf(err, "commit transaction failed")
+		return
+	}
+
+	// affected rows of all queries in transaction
+	c.txResult.affectedRows = resp.Header.AffectedRows
+	c.txResult.lastInsertID = resp.Header.LastInsertID
+
+	return
+}
+
+func (c *impersonatedConn) Rollback() error {
+	if !c.inTransaction {
+		return sql.ErrTxDone
+	}
+
+	// nothing sent yet, just drop the queued queries
+	c.queries = c.queries[:0]
+	c.inTransaction = false
+
+	return nil
+}
+
+func buildQuery(query string, args []driver.NamedValue) (q types.Query) {
+	q.Pattern = query
 
 	for _, arg := range args {
-		dArgs = append(dArgs, types.NamedArg{
+		q.Args = append(q.Args, types.NamedArg{
 			Name:  arg.Name,
 			Value: arg.Value,
 		})
 	}
 
+	return
+}
+
+func (d *impersonatedDB) sendQuery(queries []types.Query, queryType types.QueryType) (
+	resp *types.Response, err error) {
+	var connID, seqNo = allocateConnAndSeq()
+
+	defer putBackConn(connID)
+
 	req := &types.Request{
 		Header: types.SignedRequestHeader{
 			RequestHeader: types.RequestHeader{
@@ -159,12 +247,7 @@ func (d *impersonatedDB) sendQuery(query string, args []driver.NamedValue, query
 			},
 		},
 		Payload: types.RequestPayload{
-			Queries: []types.Query{
-				{
-					Pattern: query,
-					Args:    dArgs,
-				},
-			},
+			Queries: queries,
 		},
 	}
 	resp = &types.Response{}
@@ -204,26 +287,11 @@ func (d *impersonatedDB) sendAck(ack *types.Ack) {
 }
 
 func (d *impersonatedDB) Open(name string) (driver.Conn, error) {
-	return d, nil
-}
-
-func (d *impersonatedDB) Prepare(query string) (driver.Stmt, error) {
-	return &impersonatedStmt{
-		db:    d,
-		query: query,
-	}, nil
-}
-
-func (d *impersonatedDB) Close() error {
-	return nil
-}
-
-func (d *impersonatedDB) Begin() (driver.Tx, error) {
-	return nil, errors.New("transaction not supported")
+	return &impersonatedConn{db: d}, nil
 }
 
 func (d *impersonatedDB) Connect(context.Context) (driver.Conn, error) {
-	return d, nil
+	return &impersonatedConn{db: d}, nil
 }
 
 func (d *impersonatedDB) Driver() driver.Driver {
@@ -231,7 +299,7 @@ func (d *impersonatedDB) Driver() driver.Driver {
 }
 
 type impersonatedStmt struct {
-	db    *impersonatedDB
+	conn  *impersonatedConn
 	query string
 }
 
@@ -244,19 +312,19 @@ func (s *impersonatedStmt) NumInput() int {
 }
 
 func (s *impersonatedStmt) Exec(args []driver.Value) (driver.Result, error) {
-	return s.db.ExecContext(context.Background(), s.query, convertOldArgs(args))
+	return s.conn.ExecContext(context.Background(), s.query, convertOldArgs(args))
 }
 
 func (s *impersonatedStmt) Query(args []driver.Value) (driver.Rows, error) {
-	return s.db.QueryContext(context.Background(), s.query, convertOldArgs(args))
+	return s.conn.QueryContext(context.Background(), s.query, convertOldArgs(args))
 }
 
 func (s *impersonatedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
-	return s.db.ExecContext(ctx, s.query, args)
+	return s.conn.ExecContext(ctx, s.query, args)
 }
 
 func (s *impersonatedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
-	return s.db.QueryContext(ctx, s.query, args)
+	return s.conn.QueryContext(ctx, s.query, args)
 }
 
 func allocateConnAndSeq() (connID uint64, seqNo uint64) {
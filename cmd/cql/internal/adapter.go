@@ -22,22 +22,37 @@ import (
 	"time"
 
 	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter"
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/config"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 )
 
 var (
 	adapterAddr          string // adapter listen addr
 	adapterUseMirrorAddr string
+	adapterMySQLAddr     string // adapter mysql protocol listen addr
+	adapterPostgresAddr  string // adapter postgresql protocol listen addr
 )
 
 // CmdAdapter is cql adapter command entity.
 var CmdAdapter = &Command{
-	UsageLine: "cql adapter [common params] [-tmp-path path] [-bg-log-level level] [-mirror addr] listen_address",
+	UsageLine: "cql adapter [common params] [-tmp-path path] [-bg-log-level level] [-mirror addr] [-mysql addr] [-postgres addr] listen_address",
 	Short:     "start a SQLChain adapter server",
 	Long: `
 Adapter serves a SQLChain adapter.
 e.g.
     cql adapter 127.0.0.1:7784
+
+Adapter also serves MySQL protocol if -mysql flag or Adapter.MySQLListenAddr config is set,
+the database id is used as the MySQL database name.
+e.g.
+    cql adapter -mysql 127.0.0.1:4664 127.0.0.1:7784
+    mysql -h 127.0.0.1 -P 4664 -u root -p database_id
+
+Adapter also serves PostgreSQL protocol if -postgres flag or Adapter.PostgresListenAddr config
+is set, the database id is used as the PostgreSQL database name.
+e.g.
+    cql adapter -postgres 127.0.0.1:5432 127.0.0.1:7784
+    psql -h 127.0.0.1 -p 5432 -U postgres database_id
 `,
 	Flag:       flag.NewFlagSet("Adapter params", flag.ExitOnError),
 	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
@@ -47,6 +62,8 @@ e.g.
 func init() {
 	CmdAdapter.Run = runAdapter
 	CmdAdapter.Flag.StringVar(&adapterUseMirrorAddr, "mirror", "", "Mirror server for adapter to query")
+	CmdAdapter.Flag.StringVar(&adapterMySQLAddr, "mysql", "", "MySQL protocol listen address for adapter")
+	CmdAdapter.Flag.StringVar(&adapterPostgresAddr, "postgres", "", "PostgreSQL protocol listen address for adapter")
 
 	addCommonFlags(CmdAdapter)
 	addConfigFlag(CmdAdapter)
@@ -69,10 +86,47 @@ func startAdapterServer(adapterAddr string, adapterUseMirrorAddr string) func()
 
 	ConsoleLog.Infof("adapter started on %s", adapterAddr)
 
+	var adapterMySQLServer *adapter.MySQLAdapter
+	if adapterMySQLAddr != "" || config.GetConfig().MySQLListenAddr != "" {
+		if adapterMySQLServer, err = adapter.NewMySQLAdapter(adapterMySQLAddr); err == nil {
+			err = adapterMySQLServer.Serve()
+		}
+		if err != nil {
+			ConsoleLog.WithError(err).Error("start mysql adapter failed")
+			SetExitStatus(1)
+			adapterHTTPServer.Shutdown(context.Background())
+			return nil
+		}
+		ConsoleLog.Info("mysql adapter started")
+	}
+
+	var adapterPostgresServer *adapter.PostgresAdapter
+	if adapterPostgresAddr != "" || config.GetConfig().PostgresListenAddr != "" {
+		if adapterPostgresServer, err = adapter.NewPostgresAdapter(adapterPostgresAddr); err == nil {
+			err = adapterPostgresServer.Serve()
+		}
+		if err != nil {
+			ConsoleLog.WithError(err).Error("start postgresql adapter failed")
+			SetExitStatus(1)
+			adapterHTTPServer.Shutdown(context.Background())
+			if adapterMySQLServer != nil {
+				adapterMySQLServer.Shutdown()
+			}
+			return nil
+		}
+		ConsoleLog.Info("postgresql adapter started")
+	}
+
 	return func() {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
 		adapterHTTPServer.Shutdown(ctx)
+		if adapterMySQLServer != nil {
+			adapterMySQLServer.Shutdown()
+		}
+		if adapterPostgresServer != nil {
+			adapterPostgresServer.Shutdown()
+		}
 		ConsoleLog.Info("adapter stopped")
 	}
 }
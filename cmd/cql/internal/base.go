@@ -70,6 +70,9 @@ type Command struct {
 
 	// Flag is a set of flags specific to this command.
 	Flag *flag.FlagSet
+
+	// Commands lists the sub commands like cql db create.
+	Commands []*Command
 }
 
 // LongName returns the command's long name: all the words in the usage line between "cql" and a flag or argument,
@@ -106,6 +109,45 @@ func (c *Command) Runnable() bool {
 	return c.Run != nil
 }
 
+// ParseFlags parses the flags, common flags and debug flags of the command from args,
+// and returns the remaining arguments.
+func (c *Command) ParseFlags(args []string) []string {
+	var allFlags flag.FlagSet
+	allFlags.Usage = func() { c.Usage() }
+	for _, fs := range []*flag.FlagSet{c.Flag, c.CommonFlag, c.DebugFlag} {
+		if fs == nil {
+			continue
+		}
+		fs.VisitAll(func(flag *flag.Flag) {
+			allFlags.Var(flag.Value, flag.Name, flag.Usage)
+		})
+	}
+	_ = allFlags.Parse(args)
+	return allFlags.Args()
+}
+
+// runSubCommand runs the sub command named by the first argument.
+func runSubCommand(cmd *Command, args []string) {
+	if len(args) < 1 {
+		ConsoleLog.Errorf("%s command need a sub command", cmd.LongName())
+		SetExitStatus(1)
+		printCommandHelp(cmd)
+		Exit()
+	}
+
+	for _, sub := range cmd.Commands {
+		if sub.Name() != args[0] || !sub.Runnable() {
+			continue
+		}
+		sub.Run(sub, sub.ParseFlags(args[1:]))
+		return
+	}
+
+	ConsoleLog.Errorf("unknown sub command %#v of %s command", args[0], cmd.LongName())
+	SetExitStatus(2)
+	printCommandHelp(cmd)
+}
+
 var atExitFuncs []func()
 
 // AtExit will register function to be executed before exit.
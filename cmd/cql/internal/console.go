@@ -48,7 +48,7 @@ import (
 
 // CmdConsole is cql console command entity.
 var CmdConsole = &Command{
-	UsageLine: "cql console [common params] [-command sqlcommand] [-out outputfile] [-no-rc true/false] [-single-transaction] [-variable variables] [-explorer explorer_addr] [-adapter adapter_addr] [dsn]",
+	UsageLine: "cql console [common params] [-command sqlcommand] [-out outputfile] [-no-rc true/false] [-single-transaction] [-variable variables] [-format table/json/csv/tsv/vertical] [-explorer explorer_addr] [-adapter adapter_addr] [dsn]",
 	Short:     "run a console for interactive sql operation",
 	Long: `
 Console runs an interactive SQL console for CovenantSQL.
@@ -59,6 +59,13 @@ There is also a -command param for SQL script, and you can add "< file.sql" at e
 If those params are set, it will run SQL script and exit without staying console mode.
 e.g.
     cql console -command "create table test1(test2 int);" covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+
+Use -format to print results as json, csv or tsv for scripts, or vertical with one column per line.
+e.g.
+    cql console -format json -command "select * from test1;" covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c | jq .
+
+In interactive mode, TAB completes SQL keywords, table and column names, "\d [NAME]", "\dt", "\di"
+and "\dv" describe the schema, "\x" toggles vertical output, and long results are shown through $PAGER ("\pset pager 0" disables it).
 `,
 	Flag:       flag.NewFlagSet("Console params", flag.ExitOnError),
 	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
@@ -72,6 +79,7 @@ var (
 	noRC              bool
 	singleTransaction bool
 	command           string
+	outputFormat      string
 )
 
 func init() {
@@ -84,6 +92,7 @@ func init() {
 	CmdConsole.Flag.BoolVar(&noRC, "no-rc", false, "Do not read start up file")
 	CmdConsole.Flag.BoolVar(&singleTransaction, "single-transaction", false, "Execute as a single transaction (if non-interactive)")
 	CmdConsole.Flag.StringVar(&command, "command", "", "Run only single command (SQL or usql internal command) and exit")
+	CmdConsole.Flag.StringVar(&outputFormat, "format", FormatTable, "Output format of results: table, json, csv, tsv or vertical")
 	CmdConsole.Flag.StringVar(&adapterAddr, "adapter", "", "Address to serve a database chain adapter, e.g. :7784")
 	CmdConsole.Flag.StringVar(&explorerAddr, "explorer", "", "Address serve a database chain explorer, e.g. :8546")
 }
@@ -260,14 +269,16 @@ func run(u *user.User) (err error) {
 
 	// create input/output
 	interactive := command != ""
-	l, err := rline.New(interactive, outFile, env.HistoryFile(u))
+	rl, err := rline.New(interactive, outFile, env.HistoryFile(u))
 	if err != nil {
 		return err
 	}
+	l := newShellIO(rl)
 	defer l.Close()
 
 	// create handler
 	h := handler.New(l, u, wd, true)
+	l.attach(h)
 
 	// open dsn
 	if err = h.Open(dsn); err != nil {
@@ -295,6 +306,7 @@ func run(u *user.User) (err error) {
 		// one liner command
 		h.SetSingleLineMode(true)
 		h.Reset([]rune(command))
+		l.switchFormat()
 		if err = h.Run(); err != nil && err != io.EOF {
 			ConsoleLog.WithError(err).Error("run command failed")
 			SetExitStatus(1)
@@ -380,6 +392,13 @@ func runConsole(cmd *Command, args []string) {
 
 	usqlRegister()
 
+	if err = applyOutputFormat(outputFormat); err != nil {
+		ConsoleLog.WithError(err).Error("set output format failed")
+		SetExitStatus(1)
+		printCommandHelp(cmd)
+		Exit()
+	}
+
 	var (
 		curUser   *user.User
 		available = drivers.Available()
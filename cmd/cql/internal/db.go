@@ -0,0 +1,381 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+var (
+	quotaQueryTimeout    string
+	quotaMaxMemory       string
+	quotaMaxTempStorage  string
+	quotaMaxResultSize   string
+	quotaMaxDatabaseSize string
+)
+
+// CmdDB is cql db command entity.
+var CmdDB = &Command{
+	UsageLine: "cql db [common params] <create|drop|list|describe|scale> [params] [arguments]",
+	Short:     "manage the lifecycle of databases",
+	Long: `
+DB manages the lifecycle of the CovenantSQL databases of the current account.
+e.g.
+    cql db create -wait-tx-confirm -db-node 2 -db-advance-payment 20000000
+
+    cql db list
+
+    cql db describe covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+
+    cql db scale -max-memory 268435456 covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+
+    cql db drop -wait-tx-confirm covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+
+Use "cql help db <command>" for more information about a sub command.
+`,
+	Flag:       flag.NewFlagSet("DB params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+var cmdDBCreate = &Command{
+	UsageLine: "cql db create [common params] [-wait-tx-confirm] [db_meta_params]",
+	Short:     "create a database",
+	Long: `
+Create creates a CovenantSQL database with the node count and the advance payment, it works
+the same as "cql create".
+e.g.
+    cql db create -wait-tx-confirm -db-node 2 -db-advance-payment 20000000
+`,
+	Flag:       flag.NewFlagSet("DB meta params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+var cmdDBDrop = &Command{
+	UsageLine: "cql db drop [common params] [-wait-tx-confirm] dsn",
+	Short:     "drop a database by dsn or database id",
+	Long: `
+Drop drops a CovenantSQL database by DSN or database ID, it works the same as "cql drop".
+e.g.
+    cql db drop -wait-tx-confirm covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+`,
+	Flag:       flag.NewFlagSet("Drop params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+var cmdDBList = &Command{
+	UsageLine: "cql db list [common params]",
+	Short:     "list the databases owned by current account",
+	Long: `
+List lists the databases owned by the current account with the miner count and the balances
+of the owner in each database.
+e.g.
+    cql db list
+`,
+	Flag:       flag.NewFlagSet("List params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+var cmdDBDescribe = &Command{
+	UsageLine: "cql db describe [common params] dsn",
+	Short:     "show the profile, miners and users of a database",
+	Long: `
+Describe shows the profile of a database on the block producers, including the resource meta,
+the miner membership (the first one is the leader) and the users with their balances.
+e.g.
+    cql db describe covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+`,
+	Flag:       flag.NewFlagSet("Describe params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+var cmdDBScale = &Command{
+	UsageLine: "cql db scale [common params] [-wait-tx-confirm] [quota_params] dsn",
+	Short:     "update the resource quota of a database",
+	Long: `
+Scale updates the resource quota enforced by the miners of a database, the quota params not
+set keep their current values and 0 means unlimited. The miner count of a database is fixed
+at creation and can not be changed by scale.
+e.g.
+    cql db scale -query-timeout 10s -max-memory 268435456 covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+`,
+	Flag:       flag.NewFlagSet("Quota params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+func init() {
+	CmdDB.Run = runSubCommand
+	CmdDB.Commands = []*Command{cmdDBCreate, cmdDBDrop, cmdDBList, cmdDBDescribe, cmdDBScale}
+	addCommonFlags(CmdDB)
+	addConfigFlag(CmdDB)
+
+	cmdDBCreate.Run = runCreate
+	addCommonFlags(cmdDBCreate)
+	addConfigFlag(cmdDBCreate)
+	addWaitFlag(cmdDBCreate)
+	addCreateFlags(cmdDBCreate)
+
+	cmdDBDrop.Run = runDrop
+	addCommonFlags(cmdDBDrop)
+	addConfigFlag(cmdDBDrop)
+	addWaitFlag(cmdDBDrop)
+
+	cmdDBList.Run = runDBList
+	addCommonFlags(cmdDBList)
+	addConfigFlag(cmdDBList)
+
+	cmdDBDescribe.Run = runDBDescribe
+	addCommonFlags(cmdDBDescribe)
+	addConfigFlag(cmdDBDescribe)
+
+	cmdDBScale.Run = runDBScale
+	addCommonFlags(cmdDBScale)
+	addConfigFlag(cmdDBScale)
+	addWaitFlag(cmdDBScale)
+	cmdDBScale.Flag.StringVar(&quotaQueryTimeout, "query-timeout", "", "Max execution time of a read query, e.g. 10s")
+	cmdDBScale.Flag.StringVar(&quotaMaxMemory, "max-memory", "", "Max page cache memory of a storage connection in bytes")
+	cmdDBScale.Flag.StringVar(&quotaMaxTempStorage, "max-temp-storage", "", "Max temporary storage of a storage connection in bytes")
+	cmdDBScale.Flag.StringVar(&quotaMaxResultSize, "max-result-size", "", "Max result size of a read query in bytes")
+	cmdDBScale.Flag.StringVar(&quotaMaxDatabaseSize, "max-database-size", "", "Max size of the database storage in bytes")
+}
+
+func statusName(s types.Status) string {
+	switch s {
+	case types.Normal:
+		return "Normal"
+	case types.Reminder:
+		return "Reminder"
+	case types.Arrears:
+		return "Arrears"
+	case types.Arbitration:
+		return "Arbitration"
+	default:
+		return "Unknown"
+	}
+}
+
+func localAccountAddress() (addr proto.AccountAddress, err error) {
+	pubKey, err := kms.GetLocalPublicKey()
+	if err != nil {
+		return
+	}
+	return crypto.PubKeyHash(pubKey)
+}
+
+func queryDatabaseProfile(cmd *Command, args []string) (profile *types.SQLChainProfile) {
+	if len(args) != 1 {
+		ConsoleLog.Error("command need CovenantSQL dsn or database_id string as param")
+		SetExitStatus(1)
+		printCommandHelp(cmd)
+		Exit()
+	}
+
+	dsnCfg, err := client.ParseDSN(args[0])
+	if err != nil {
+		ConsoleLog.WithField("db", args[0]).WithError(err).Error("not a valid dsn")
+		SetExitStatus(1)
+		return
+	}
+
+	var (
+		req  = new(types.QuerySQLChainProfileReq)
+		resp = new(types.QuerySQLChainProfileResp)
+	)
+	req.DBID = proto.DatabaseID(dsnCfg.DatabaseID)
+	if err = mux.RequestBP(route.MCCQuerySQLChainProfile.String(), req, resp); err != nil {
+		ConsoleLog.WithField("db", args[0]).WithError(err).Error("query database chain profile failed")
+		SetExitStatus(1)
+		return
+	}
+
+	return &resp.Profile
+}
+
+func runDBList(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+	configInit()
+
+	var (
+		req  = new(types.QueryDatabasesByOwnerReq)
+		resp = new(types.QueryDatabasesByOwnerResp)
+		err  error
+	)
+
+	if req.Owner, err = localAccountAddress(); err != nil {
+		ConsoleLog.WithError(err).Error("get current account address failed")
+		SetExitStatus(1)
+		return
+	}
+
+	if err = mux.RequestBP(route.MCCQueryDatabasesByOwner.String(), req, resp); err != nil {
+		ConsoleLog.WithError(err).Error("query owned databases failed")
+		SetExitStatus(1)
+		return
+	}
+
+	if len(resp.Profiles) == 0 {
+		fmt.Println("found no owned database")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DatabaseID\tMiners\tToken\tDeposit\tArrears\tAdvancePayment")
+	for _, p := range resp.Profiles {
+		var deposit, arrears, advancePayment uint64
+		for _, user := range p.Users {
+			if user.Address == req.Owner {
+				deposit, arrears, advancePayment = user.Deposit, user.Arrears, user.AdvancePayment
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%d\n",
+			p.ID, len(p.Miners), p.TokenType, deposit, arrears, advancePayment)
+	}
+	_ = w.Flush()
+}
+
+func runDBDescribe(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+	configInit()
+
+	p := queryDatabaseProfile(cmd, args)
+	if p == nil {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "DatabaseID:\t%s\n", p.ID)
+	fmt.Fprintf(w, "Address:\t%s\n", p.Address)
+	fmt.Fprintf(w, "Owner:\t%s\n", p.Owner)
+	fmt.Fprintf(w, "Token:\t%s\n", p.TokenType)
+	fmt.Fprintf(w, "GasPrice:\t%d\n", p.GasPrice)
+	fmt.Fprintf(w, "Period:\t%d\n", p.Period)
+	fmt.Fprintf(w, "LastUpdatedHeight:\t%d\n", p.LastUpdatedHeight)
+	fmt.Fprintf(w, "Node:\t%d\n", p.Meta.Node)
+	fmt.Fprintf(w, "EventualConsistency:\t%t\n", p.Meta.UseEventualConsistency)
+	fmt.Fprintf(w, "Quota:\tquery-timeout=%s max-memory=%d max-temp-storage=%d max-result-size=%d max-database-size=%d\n",
+		p.Meta.Quota.QueryTimeout, p.Meta.Quota.MaxMemory,
+		p.Meta.Quota.MaxTempStorage, p.Meta.Quota.MaxResultSize, p.Meta.Quota.MaxDatabaseSize)
+	_ = w.Flush()
+
+	fmt.Printf("\nMiners:\n\n")
+	fmt.Fprintln(w, "Role\tNodeID\tAddress\tDeposit\tPendingIncome\tReceivedIncome\tStatus")
+	for i, miner := range p.Miners {
+		role := "follower"
+		if i == 0 {
+			role = "leader"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", role, miner.NodeID, miner.Address,
+			miner.Deposit, miner.PendingIncome, miner.ReceivedIncome, statusName(miner.Status))
+	}
+	_ = w.Flush()
+
+	fmt.Printf("\nUsers:\n\n")
+	fmt.Fprintln(w, "Address\tRole\tDeposit\tArrears\tAdvancePayment\tStatus")
+	for _, user := range p.Users {
+		role := types.Void
+		if user.Permission != nil {
+			role = user.Permission.Role
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", user.Address, role,
+			user.Deposit, user.Arrears, user.AdvancePayment, statusName(user.Status))
+	}
+	_ = w.Flush()
+}
+
+func runDBScale(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+	configInit()
+
+	p := queryDatabaseProfile(cmd, args)
+	if p == nil {
+		return
+	}
+
+	var (
+		quota = p.Meta.Quota
+		err   error
+	)
+	if quotaQueryTimeout != "" {
+		if quota.QueryTimeout, err = time.ParseDuration(quotaQueryTimeout); err != nil || quota.QueryTimeout < 0 {
+			ConsoleLog.WithError(err).Errorf("invalid query-timeout %#v", quotaQueryTimeout)
+			SetExitStatus(1)
+			return
+		}
+	}
+	for _, v := range []struct {
+		name  string
+		value string
+		quota *uint64
+	}{
+		{"max-memory", quotaMaxMemory, &quota.MaxMemory},
+		{"max-temp-storage", quotaMaxTempStorage, &quota.MaxTempStorage},
+		{"max-result-size", quotaMaxResultSize, &quota.MaxResultSize},
+		{"max-database-size", quotaMaxDatabaseSize, &quota.MaxDatabaseSize},
+	} {
+		if v.value == "" {
+			continue
+		}
+		if *v.quota, err = strconv.ParseUint(strings.TrimSpace(v.value), 10, 64); err != nil {
+			ConsoleLog.WithError(err).Errorf("invalid %s %#v", v.name, v.value)
+			SetExitStatus(1)
+			return
+		}
+	}
+
+	if quota == p.Meta.Quota {
+		ConsoleLog.Error("scale command need at least one changed quota param")
+		SetExitStatus(1)
+		printCommandHelp(cmd)
+		return
+	}
+
+	txHash, err := client.UpdateDatabaseQuota(p.Address, quota)
+	if err != nil {
+		ConsoleLog.WithField("db", p.ID).WithError(err).Error("update database quota failed")
+		SetExitStatus(1)
+		return
+	}
+
+	if waitTxConfirmation {
+		if err = wait(txHash); err != nil {
+			ConsoleLog.WithField("db", p.ID).WithError(err).Error("update database quota failed")
+			SetExitStatus(1)
+			return
+		}
+	}
+
+	ConsoleLog.WithField("db", p.ID).Infof("update database quota to %+v success", quota)
+}
@@ -18,6 +18,7 @@ package internal
 
 import (
 	"flag"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/CovenantSQL/CovenantSQL/sqlchain/observer"
@@ -25,7 +26,8 @@ import (
 )
 
 var (
-	explorerAddr string // Explorer addr
+	explorerAddr    string // Explorer addr
+	explorerOpenAPI string // OpenAPI spec output path
 
 	explorerService    *observer.Service
 	explorerHTTPServer *http.Server
@@ -33,12 +35,16 @@ var (
 
 // CmdExplorer is cql explorer command.
 var CmdExplorer = &Command{
-	UsageLine: "cql explorer [common params] [-tmp-path path] [-bg-log-level level] listen_address",
+	UsageLine: "cql explorer [common params] [-tmp-path path] [-bg-log-level level] [-openapi path] listen_address",
 	Short:     "start a SQLChain explorer server",
 	Long: `
 Explorer serves a SQLChain web explorer.
 e.g.
     cql explorer 127.0.0.1:8546
+
+The OpenAPI spec of the explorer REST API could be generated without starting the server.
+e.g.
+    cql explorer -openapi openapi.json
 `,
 	Flag:       flag.NewFlagSet("Explorer params", flag.ExitOnError),
 	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
@@ -47,6 +53,8 @@ e.g.
 
 func init() {
 	CmdExplorer.Run = runExplorer
+	CmdExplorer.Flag.StringVar(&explorerOpenAPI, "openapi", "",
+		"Write the OpenAPI spec of the explorer REST API to the path and exit")
 
 	addCommonFlags(CmdExplorer)
 	addConfigFlag(CmdExplorer)
@@ -73,6 +81,20 @@ func startExplorerServer(explorerAddr string) func() {
 func runExplorer(cmd *Command, args []string) {
 	commonFlagsInit(cmd)
 
+	if explorerOpenAPI != "" {
+		spec, err := observer.GenerateOpenAPISpec(Version)
+		if err == nil {
+			err = ioutil.WriteFile(explorerOpenAPI, spec, 0644)
+		}
+		if err != nil {
+			ConsoleLog.WithError(err).Error("generate OpenAPI spec failed")
+			SetExitStatus(1)
+			return
+		}
+		ConsoleLog.Infof("OpenAPI spec written to %s", explorerOpenAPI)
+		return
+	}
+
 	if len(args) != 1 {
 		ConsoleLog.Error("explorer command need listen address as param")
 		SetExitStatus(1)
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/xo/usql/env"
+)
+
+// Output formats of the console results.
+const (
+	FormatTable    = "table"
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatTSV      = "tsv"
+	FormatVertical = "vertical"
+)
+
+var (
+	// ErrInvalidFormat indicates an unknown output format.
+	ErrInvalidFormat = errors.New("invalid output format, must be one of table/json/csv/tsv/vertical")
+	// errNotRecords indicates the output is not a json array of records.
+	errNotRecords = errors.New("output is not json records")
+)
+
+// applyOutputFormat sets the usql print variables for the output format, the
+// machine readable formats also hide the connection banner.
+func applyOutputFormat(format string) (err error) {
+	var pvars [][2]string
+
+	switch format {
+	case FormatTable:
+		pvars = [][2]string{{"format", "aligned"}, {"expanded", "off"}}
+	case FormatVertical:
+		pvars = [][2]string{{"format", "aligned"}, {"expanded", "on"}}
+	case FormatJSON:
+		pvars = [][2]string{{"format", "json"}}
+	case FormatCSV:
+		pvars = [][2]string{{"format", "csv"}, {"fieldsep", ","}}
+	case FormatTSV:
+		pvars = [][2]string{{"format", "csv"}, {"fieldsep", "\t"}}
+	default:
+		return errors.Wrapf(ErrInvalidFormat, "%s", format)
+	}
+
+	for _, v := range pvars {
+		if _, err = env.Pset(v[0], v[1]); err != nil {
+			return errors.Wrapf(err, "set %s to %s failed", v[0], v[1])
+		}
+	}
+
+	if format != FormatTable && format != FormatVertical {
+		return env.Set("SHOW_HOST_INFORMATION", "false")
+	}
+	return
+}
+
+// writeVertical prints the json arrays of records in output as one column
+// name and value pair per line, like the psql expanded display.
+func writeVertical(w io.Writer, output []byte, null string) (err error) {
+	var (
+		dec     = json.NewDecoder(bytes.NewReader(output))
+		results [][][2]string
+	)
+	dec.UseNumber()
+
+	for dec.More() {
+		var records [][][2]string
+		if records, err = decodeRecords(dec, null); err != nil {
+			return
+		}
+		// a nil record ends the result set
+		results = append(results, records...)
+		results = append(results, nil)
+	}
+	if len(results) == 0 {
+		return errNotRecords
+	}
+
+	var (
+		buf bytes.Buffer
+		n   int
+	)
+	for _, record := range results {
+		if record == nil {
+			if n == 1 {
+				fmt.Fprintf(&buf, "(%d row)\n\n", n)
+			} else {
+				fmt.Fprintf(&buf, "(%d rows)\n\n", n)
+			}
+			n = 0
+			continue
+		}
+		n++
+		writeRecord(&buf, n, record)
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// decodeRecords decodes a json array of objects keeping the column order.
+func decodeRecords(dec *json.Decoder, null string) (records [][][2]string, err error) {
+	if err = expectDelim(dec, '['); err != nil {
+		return
+	}
+
+	for dec.More() {
+		var record [][2]string
+		if err = expectDelim(dec, '{'); err != nil {
+			return
+		}
+		for dec.More() {
+			var key, value json.Token
+			if key, err = dec.Token(); err != nil {
+				return
+			}
+			if value, err = dec.Token(); err != nil {
+				return
+			}
+			if _, ok := value.(json.Delim); ok {
+				return nil, errNotRecords
+			}
+			record = append(record, [2]string{fmt.Sprint(key), formatJSONValue(value, null)})
+		}
+		if err = expectDelim(dec, '}'); err != nil {
+			return
+		}
+		records = append(records, record)
+	}
+
+	err = expectDelim(dec, ']')
+	return
+}
+
+// expectDelim reads the next token and checks it is delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) (err error) {
+	t, err := dec.Token()
+	if err != nil {
+		return
+	}
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return errNotRecords
+	}
+	return
+}
+
+// formatJSONValue returns the display string of a json scalar.
+func formatJSONValue(v json.Token, null string) string {
+	switch x := v.(type) {
+	case nil:
+		return null
+	case string:
+		return x
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// writeRecord prints record n as "column | value" lines under a record header.
+func writeRecord(w io.Writer, n int, record [][2]string) {
+	var keyWidth, valueWidth int
+	for _, kv := range record {
+		if l := utf8.RuneCountInString(kv[0]); l > keyWidth {
+			keyWidth = l
+		}
+		for _, line := range strings.Split(kv[1], "\n") {
+			if l := utf8.RuneCountInString(line); l > valueWidth {
+				valueWidth = l
+			}
+		}
+	}
+
+	header := fmt.Sprintf("-[ RECORD %d ]", n)
+	if pad := keyWidth + 3 + valueWidth - utf8.RuneCountInString(header); pad > 0 {
+		header += strings.Repeat("-", pad)
+	}
+	fmt.Fprintln(w, header)
+
+	for _, kv := range record {
+		key := kv[0] + strings.Repeat(" ", keyWidth-utf8.RuneCountInString(kv[0]))
+		for i, line := range strings.Split(kv[1], "\n") {
+			if i > 0 {
+				key = strings.Repeat(" ", keyWidth)
+			}
+			fmt.Fprintf(w, "%s | %s\n", key, line)
+		}
+	}
+}
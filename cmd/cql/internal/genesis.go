@@ -0,0 +1,124 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/genesis"
+)
+
+var (
+	genesisConfigOut string
+)
+
+// CmdGenesis is cql genesis command entity.
+var CmdGenesis = &Command{
+	UsageLine: "cql genesis [common params] [-config-out file] spec.yaml",
+	Short:     "build the main chain genesis block from a spec",
+	Long: `
+Genesis builds the main chain genesis block from a YAML spec of initial accounts, block
+producers and chain parameters, validates it and prints the genesis block hash.
+e.g.
+    cql genesis genesis.yaml
+
+The spec is in the following format:
+    Version: 1
+    Timestamp: 2019-01-01T00:00:00Z
+    Period: 10s
+    Tick: 1s
+    BlockProducers:
+    - ID: 00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9
+      Addr: 127.0.0.1:2230
+      PublicKey: 02c76216704d797c64c58bc11519fb68582e8e63de7e5b3b2dbbbe8733efe5fd24
+      Nonce:
+        a: 313283
+        b: 0
+        c: 0
+        d: 0
+    Accounts:
+    - Address: ba0ba731c7a76ccef2c1170f42038f7e228dfb474ef0190dfe35d9a37911ed37
+      StableCoinBalance: 10000000000000000000
+      CovenantCoinBalance: 10000000000000000000
+
+The config entries of the genesis, which should be merged into the config files of all the
+nodes, can be written to a file.
+e.g.
+    cql genesis -config-out genesis_config.yaml genesis.yaml
+`,
+	Flag:       flag.NewFlagSet("Genesis params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+func init() {
+	CmdGenesis.Run = runGenesis
+
+	addCommonFlags(CmdGenesis)
+	CmdGenesis.Flag.StringVar(&genesisConfigOut, "config-out", "",
+		"Write the config entries of the genesis to file")
+}
+
+func runGenesis(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+
+	if len(args) != 1 {
+		ConsoleLog.Error("genesis command need a spec file as param")
+		SetExitStatus(1)
+		printCommandHelp(cmd)
+		Exit()
+	}
+
+	spec, err := genesis.LoadSpec(args[0])
+	if err != nil {
+		ConsoleLog.WithError(err).Error("load genesis spec failed")
+		SetExitStatus(1)
+		return
+	}
+	if err = spec.Validate(); err != nil {
+		ConsoleLog.WithError(err).Error("validate genesis spec failed")
+		SetExitStatus(1)
+		return
+	}
+	block, err := spec.Build()
+	if err != nil {
+		ConsoleLog.WithError(err).Error("build genesis block failed")
+		SetExitStatus(1)
+		return
+	}
+
+	if genesisConfigOut != "" {
+		data, err := yaml.Marshal(spec.Config())
+		if err != nil {
+			ConsoleLog.WithError(err).Error("marshal genesis config failed")
+			SetExitStatus(1)
+			return
+		}
+		if err = ioutil.WriteFile(genesisConfigOut, data, 0644); err != nil {
+			ConsoleLog.WithError(err).Error("write genesis config failed")
+			SetExitStatus(1)
+			return
+		}
+		ConsoleLog.Infof("genesis config is written to %s", genesisConfigOut)
+	}
+
+	fmt.Printf("genesis block hash: %s\n", block.BlockHash())
+}
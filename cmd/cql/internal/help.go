@@ -88,6 +88,13 @@ func printCommandHelp(cmd *Command) {
 	_, _ = fmt.Fprintf(os.Stdout, "usage: %s\n", cmd.UsageLine)
 	_, _ = fmt.Fprintf(os.Stdout, cmd.Long)
 
+	if len(cmd.Commands) > 0 {
+		_, _ = fmt.Fprintf(os.Stdout, "\nThe sub commands are:\n\n")
+		for _, sub := range cmd.Commands {
+			_, _ = fmt.Fprintf(os.Stdout, "\t%-10s\t%s\n", sub.Name(), sub.Short)
+		}
+	}
+
 	if cmd.Flag != nil {
 		printParamHelp(cmd.Flag)
 	}
@@ -100,20 +107,29 @@ func printCommandHelp(cmd *Command) {
 }
 
 func runHelp(cmd *Command, args []string) {
-	if l := len(args); l != 1 {
-		if l > 1 {
-			// Don't support multiple commands
-			SetExitStatus(2)
-		}
+	if len(args) < 1 {
 		MainUsage()
 	}
 
-	cmdName := args[0]
-	for _, command := range CqlCommands {
-		if command.Name() != cmdName {
-			continue
+	var (
+		commands = CqlCommands
+		found    *Command
+	)
+	for _, cmdName := range args {
+		found = nil
+		for _, command := range commands {
+			if command.Name() == cmdName {
+				found = command
+				break
+			}
+		}
+		if found == nil {
+			break
 		}
-		printCommandHelp(command)
+		commands = found.Commands
+	}
+	if found != nil {
+		printCommandHelp(found)
 		return
 	}
 
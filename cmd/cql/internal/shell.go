@@ -0,0 +1,473 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/xo/tblfmt"
+	"github.com/xo/usql/env"
+	"github.com/xo/usql/handler"
+	"github.com/xo/usql/rline"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// defaultPager is the pager command used when $PAGER is not set.
+	defaultPager = "less -FRSX"
+	// schemaCacheTTL is the max age of the schema cached for tab completion.
+	schemaCacheTTL = 30 * time.Second
+)
+
+var (
+	// describeRegex matches the \d family of meta commands handled by the shell:
+	//   \d[+] [NAME], \dt[+] [PATTERN], \di[+] [PATTERN], \dv[+] [PATTERN].
+	describeRegex = regexp.MustCompile(`^\\d([tiv]?)(\+?)(?:\s+(\S+))?\s*;?\s*$`)
+	// schemaChangeRegex matches statements which may change the schema.
+	schemaChangeRegex = regexp.MustCompile(`(?i)\b(create|alter|drop)\b`)
+	// tableContextRegex matches the keyword before a word which must be a table name.
+	tableContextRegex = regexp.MustCompile(`(?i)(\bfrom|\bjoin|\binto|\bupdate|\btable|\bdesc|\\d[tiv]?\+?)\s+$`)
+
+	sqlKeywords = []string{
+		"ALTER", "AND", "AS", "ASC", "BEGIN", "BETWEEN", "BY", "CASE", "COMMIT",
+		"COUNT", "CREATE", "DEFAULT", "DELETE", "DESC", "DISTINCT", "DROP", "ELSE",
+		"END", "EXISTS", "FROM", "GROUP", "HAVING", "IN", "INDEX", "INSERT",
+		"INTEGER", "INTO", "IS", "JOIN", "KEY", "LEFT", "LIKE", "LIMIT", "NOT",
+		"NULL", "OFFSET", "ON", "OR", "ORDER", "PRIMARY", "REPLACE", "ROLLBACK",
+		"SELECT", "SET", "SHOW", "TABLE", "TABLES", "TEXT", "THEN", "UNIQUE",
+		"UPDATE", "VALUES", "VIEW", "WHEN", "WHERE",
+	}
+)
+
+// shellIO wraps the usql readline IO with schema aware tab completion, the \d
+// family of describe meta commands, vertical output and output paging.
+type shellIO struct {
+	rline.IO
+	h         *handler.Handler
+	completer *schemaCompleter
+	out       io.Writer
+	buf       *bytes.Buffer
+
+	// vertical is set while results are encoded as json to be printed as
+	// vertical records.
+	vertical bool
+}
+
+// newShellIO returns a shell IO over l, paging and completion are only enabled
+// on interactive terminals.
+func newShellIO(l rline.IO) (s *shellIO) {
+	s = &shellIO{
+		IO:  l,
+		out: l.Stdout(),
+		buf: new(bytes.Buffer),
+	}
+	s.completer = &schemaCompleter{shell: s}
+
+	if !l.Interactive() {
+		return
+	}
+
+	if r, ok := l.(*rline.Rline); ok && r.Inst != nil {
+		r.Inst.Config.AutoComplete = s.completer
+	}
+
+	return
+}
+
+// attach binds the shell to the handler which owns the database connection.
+func (s *shellIO) attach(h *handler.Handler) {
+	s.h = h
+}
+
+// Stdout returns the buffer holding the output of the current statement.
+func (s *shellIO) Stdout() io.Writer {
+	return s.buf
+}
+
+// Next flushes the output of the previous statement and reads the next line,
+// the describe meta commands are executed in place without reaching usql.
+func (s *shellIO) Next() (line []rune, err error) {
+	for {
+		s.flush()
+
+		if line, err = s.IO.Next(); err != nil {
+			return
+		}
+
+		if !s.describe(string(line)) {
+			if schemaChangeRegex.MatchString(string(line)) {
+				s.completer.invalidate()
+			}
+			s.switchFormat()
+			return
+		}
+
+		if s.IO.Interactive() {
+			_ = s.IO.Save(string(line))
+		}
+	}
+}
+
+// Close flushes the pending output and closes the underlying IO.
+func (s *shellIO) Close() error {
+	s.flush()
+	return s.IO.Close()
+}
+
+// switchFormat encodes results as json while expanded output is toggled on by
+// \x in the aligned format, flush prints them as vertical records.
+func (s *shellIO) switchFormat() {
+	pvars := env.Pall()
+	expanded := pvars["expanded"] == "on"
+
+	switch {
+	case s.vertical && pvars["format"] != "json":
+		// format changed by \pset
+		s.vertical = false
+	case s.vertical && !expanded:
+		_, _ = env.Pset("format", "aligned")
+		s.vertical = false
+	case !s.vertical && expanded && pvars["format"] == "aligned":
+		_, _ = env.Pset("format", "json")
+		s.vertical = true
+	}
+}
+
+// flush writes the buffered output to the terminal, through the pager if it
+// does not fit in the screen.
+func (s *shellIO) flush() {
+	if s.buf.Len() == 0 {
+		return
+	}
+	defer s.buf.Reset()
+
+	output := s.buf.Bytes()
+	if s.vertical {
+		var vbuf bytes.Buffer
+		if err := writeVertical(&vbuf, output, env.Pall()["null"]); err == nil {
+			output = vbuf.Bytes()
+		}
+	}
+
+	if s.IO.Interactive() && s.shouldPage(bytes.Count(output, []byte("\n"))) {
+		err := runPager(output)
+		if err == nil {
+			return
+		}
+		ConsoleLog.WithError(err).Debug("run pager failed")
+	}
+
+	_, _ = s.out.Write(output)
+}
+
+// shouldPage reports whether the output of lines should be paged according to
+// the \pset pager and pager_min_lines settings.
+func (s *shellIO) shouldPage(lines int) bool {
+	pvars := env.Pall()
+	mode, _ := strconv.Atoi(pvars["pager"])
+	minLines, _ := strconv.Atoi(pvars["pager_min_lines"])
+
+	switch {
+	case mode <= 0 || lines < minLines:
+		return false
+	case mode > 1:
+		return true
+	}
+
+	_, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	return err == nil && lines >= height
+}
+
+// runPager runs $PAGER on the output.
+func runPager(output []byte) (err error) {
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = strings.Fields(defaultPager)
+	}
+
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdin = bytes.NewReader(output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// describe runs line if it is a describe meta command and reports whether it
+// is handled.
+func (s *shellIO) describe(line string) bool {
+	m := describeRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil || s.h == nil || s.h.URL() == nil {
+		return false
+	}
+
+	var (
+		kind, verbose, name = m[1], m[2] != "", m[3]
+		w                   = s.Stdout()
+		err                 error
+	)
+
+	switch {
+	case kind == "" && name != "":
+		err = s.describeTable(w, name, verbose)
+	case kind == "" || kind == "t":
+		err = s.listObjects(w, "table", name, verbose)
+	case kind == "i":
+		err = s.listObjects(w, "index", name, verbose)
+	case kind == "v":
+		err = s.listObjects(w, "view", name, verbose)
+	}
+
+	if err != nil {
+		fmt.Fprintf(s.Stderr(), "error: %v", err)
+		fmt.Fprintln(s.Stderr())
+	}
+
+	return true
+}
+
+// listObjects prints the schema objects of type typ with name matching the glob
+// pattern, indexes are also matched by their table name.
+func (s *shellIO) listObjects(w io.Writer, typ, pattern string, verbose bool) (err error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	columns := `name AS "Name", tbl_name AS "Table"`
+	if verbose {
+		columns += `, sql AS "SQL"`
+	}
+
+	q := fmt.Sprintf(`SELECT %s FROM sqlite_master
+WHERE type = '%s' AND name NOT LIKE 'sqlite%%' AND (name GLOB ? OR tbl_name GLOB ?)
+ORDER BY tbl_name, name`, columns, typ)
+
+	return s.encode(w, "List of "+typ+"s", q, pattern, pattern)
+}
+
+// describeTable prints the columns and indexes of table name.
+func (s *shellIO) describeTable(w io.Writer, name string, verbose bool) (err error) {
+	name = strings.Trim(name, "\"`")
+
+	var q string
+	if s.h.URL().Driver == "covenantsql" {
+		q = fmt.Sprintf("DESC `%s`", name)
+	} else {
+		q = fmt.Sprintf(`PRAGMA table_info("%s")`, name)
+	}
+	if err = s.encode(w, fmt.Sprintf(`Table "%s"`, name), q); err != nil {
+		return
+	}
+
+	if err = s.encode(w, "Indexes", `SELECT name AS "Name", sql AS "SQL" FROM sqlite_master
+WHERE type = 'index' AND tbl_name = ? AND name NOT LIKE 'sqlite%'
+ORDER BY name`, name); err != nil || !verbose {
+		return
+	}
+
+	return s.encode(w, "Definition", `SELECT sql AS "SQL" FROM sqlite_master
+WHERE tbl_name = ? AND type IN ('table', 'view')`, name)
+}
+
+// encode runs query q and prints the result with the current \pset settings.
+func (s *shellIO) encode(w io.Writer, title, q string, args ...interface{}) (err error) {
+	rows, err := s.h.DB().Query(q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	opts := env.Pall()
+	if s.vertical {
+		opts["format"] = "aligned"
+	}
+	fmt.Fprintln(w, title)
+	return tblfmt.EncodeAll(w, rows, opts)
+}
+
+// schemaCompleter completes sql keywords, table names and column names fetched
+// from the connected database.
+type schemaCompleter struct {
+	shell *shellIO
+
+	sync.Mutex
+	tables   []string
+	columns  map[string][]string
+	loadTime time.Time
+}
+
+// invalidate drops the cached schema.
+func (c *schemaCompleter) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.loadTime = time.Time{}
+}
+
+// load refreshes the cached schema if it is expired.
+func (c *schemaCompleter) load() {
+	h := c.shell.h
+	if h == nil || h.URL() == nil || time.Since(c.loadTime) < schemaCacheTTL {
+		return
+	}
+
+	rows, err := h.DB().Query(`SELECT name FROM sqlite_master
+WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite%' ORDER BY name`)
+	if err != nil {
+		ConsoleLog.WithError(err).Debug("load tables for completion failed")
+		return
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err == nil {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+
+	columns := make(map[string][]string, len(tables))
+	for _, table := range tables {
+		var q string
+		if h.URL().Driver == "covenantsql" {
+			q = fmt.Sprintf("DESC `%s`", table)
+		} else {
+			q = fmt.Sprintf(`PRAGMA table_info("%s")`, table)
+		}
+		if columns[strings.ToLower(table)], err = queryColumnNames(h, q); err != nil {
+			ConsoleLog.WithError(err).WithField("table", table).Debug(
+				"load columns for completion failed")
+		}
+	}
+
+	c.tables, c.columns, c.loadTime = tables, columns, time.Now()
+}
+
+// queryColumnNames returns the name column of a table_info query result.
+func queryColumnNames(h *handler.Handler, q string) (names []string, err error) {
+	rows, err := h.DB().Query(q)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return
+	}
+	var (
+		dest = make([]interface{}, len(cols))
+		name string
+	)
+	for i, col := range cols {
+		if col == "name" {
+			dest[i] = &name
+		} else {
+			dest[i] = new(interface{})
+		}
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		names = append(names, name)
+	}
+	err = rows.Err()
+	return
+}
+
+// Do implements readline.AutoCompleter, it returns the suffixes of candidates
+// matching the word before pos.
+func (c *schemaCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	if pos > len(line) {
+		pos = len(line)
+	}
+	start := pos
+	for start > 0 && isIdentRune(line[start-1]) {
+		start--
+	}
+
+	word := strings.TrimLeft(string(line[start:pos]), "\"`")
+	before := string(line[:start])
+
+	c.Lock()
+	defer c.Unlock()
+	c.load()
+
+	var candidates []string
+	if i := strings.LastIndex(word, "."); i >= 0 {
+		// table.column
+		candidates = c.columns[strings.ToLower(strings.Trim(word[:i], "\"`"))]
+		word = word[i+1:]
+	} else if tableContextRegex.MatchString(before) {
+		candidates = c.tables
+	} else if word == "" {
+		return
+	} else {
+		upper := strings.ToUpper(word) == word
+		for _, kw := range sqlKeywords {
+			if !upper {
+				kw = strings.ToLower(kw)
+			}
+			candidates = append(candidates, kw)
+		}
+		candidates = append(candidates, c.tables...)
+		for _, table := range c.tables {
+			candidates = append(candidates, c.columns[strings.ToLower(table)]...)
+		}
+	}
+
+	return completeWord(word, candidates), len([]rune(word))
+}
+
+// completeWord returns the distinct suffixes of candidates prefixed by word
+// ignoring case.
+func completeWord(word string, candidates []string) (suffixes [][]rune) {
+	var (
+		prefix = strings.ToLower(word)
+		seen   = make(map[string]bool)
+		size   = len([]rune(word))
+		list   []string
+	)
+	for _, cand := range candidates {
+		if !strings.HasPrefix(strings.ToLower(cand), prefix) || seen[cand] {
+			continue
+		}
+		seen[cand] = true
+		list = append(list, cand)
+	}
+	sort.Strings(list)
+
+	for _, cand := range list {
+		suffixes = append(suffixes, []rune(cand)[size:])
+	}
+	return
+}
+
+// isIdentRune reports whether r may be part of a (qualified) identifier.
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.\"`", r)
+}
@@ -39,7 +39,7 @@ var (
 
 // CmdWallet is cql wallet command entity.
 var CmdWallet = &Command{
-	UsageLine: "cql wallet [common params] [-token type] [-dsn dsn]",
+	UsageLine: "cql wallet [common params] [-token type] [-dsn dsn] [transfer|balance|history] [params]",
 	Short:     "get the wallet address and the balance of current account",
 	Long: `
 Wallet gets the CovenantSQL wallet address and the token balances of the current account.
@@ -49,6 +49,17 @@ e.g.
     cql wallet -token Particle
 
     cql wallet -dsn "covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c"
+
+The transfer, balance and history sub commands send tokens, watch the balances and list the
+recent transactions of the current account.
+e.g.
+    cql wallet transfer -wait-tx-confirm -to 43602c17adcc96acf2f68964830bb6ebfbca6834961c0eca0915fcc5270e0b40 -amount 100
+
+    cql wallet balance -watch
+
+    cql wallet history -limit 10
+
+Use "cql help wallet <command>" for more information about a sub command.
 `,
 	Flag:       flag.NewFlagSet("Wallet params", flag.ExitOnError),
 	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
@@ -57,6 +68,7 @@ e.g.
 
 func init() {
 	CmdWallet.Run = runWallet
+	CmdWallet.Commands = []*Command{cmdWalletTransfer, cmdWalletBalance, cmdWalletHistory}
 
 	addCommonFlags(CmdWallet)
 	addConfigFlag(CmdWallet)
@@ -230,6 +242,11 @@ func showAllDatabaseDeposit() {
 }
 
 func runWallet(cmd *Command, args []string) {
+	if len(args) > 0 {
+		runSubCommand(cmd, args)
+		return
+	}
+
 	commonFlagsInit(cmd)
 	configInit()
 
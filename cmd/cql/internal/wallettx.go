@@ -0,0 +1,380 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package internal
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/client"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+// Exit codes of the wallet transfer command, the transaction is confirmed if the exit code is 0.
+const (
+	exitTxError       = 1 // failed to send or query the transaction
+	exitTxUnconfirmed = 3 // the transaction is still pending or packed after the timeout
+	exitTxRejected    = 4 // the transaction is expired or not found
+)
+
+var (
+	transferTo      string
+	transferAmount  uint64
+	transferToken   string
+	transferNonce   int64
+	transferTimeout time.Duration
+
+	balanceWatch    bool
+	balanceInterval time.Duration
+
+	historyLimit  int
+	historyBlocks uint
+)
+
+var cmdWalletTransfer = &Command{
+	UsageLine: "cql wallet transfer [common params] [-wait-tx-confirm] [-timeout duration] [-nonce nonce] -to address|dsn -amount count [-token token_type]",
+	Short:     "transfer token to target account with nonce and confirmation control",
+	Long: `
+Transfer transfers your token to the target account or database. The used nonce and the
+transaction hash are printed, and -nonce sends the transaction with a specified account nonce.
+Transfers are free of fee on CovenantSQL.
+e.g.
+    cql wallet transfer -to 43602c17adcc96acf2f68964830bb6ebfbca6834961c0eca0915fcc5270e0b40 -amount 100 -token Particle
+
+With -wait-tx-confirm, the exit code reports the transaction state for scripting:
+    0: confirmed
+    1: failed to send or query the transaction
+    3: still pending or packed after the timeout
+    4: expired or not found
+`,
+	Flag:       flag.NewFlagSet("Transfer params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+var cmdWalletBalance = &Command{
+	UsageLine: "cql wallet balance [common params] [-token token_type] [-watch] [-interval duration]",
+	Short:     "show or watch the token balances of current account",
+	Long: `
+Balance shows the token balances and the nonces of the current account, with -watch it keeps
+printing the changes of the balances until interrupted.
+e.g.
+    cql wallet balance
+
+    cql wallet balance -token Particle -watch -interval 10s
+`,
+	Flag:       flag.NewFlagSet("Balance params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+var cmdWalletHistory = &Command{
+	UsageLine: "cql wallet history [common params] [-limit count] [-blocks count]",
+	Short:     "list the recent transactions of current account",
+	Long: `
+History lists the pending transactions and the confirmed transactions of the current account
+found in the recent main chain blocks, the newest first. Transfers show the direction and the
+amount.
+e.g.
+    cql wallet history -limit 10 -blocks 5000
+`,
+	Flag:       flag.NewFlagSet("History params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+func init() {
+	cmdWalletTransfer.Run = runWalletTransfer
+	addCommonFlags(cmdWalletTransfer)
+	addConfigFlag(cmdWalletTransfer)
+	addWaitFlag(cmdWalletTransfer)
+	cmdWalletTransfer.Flag.StringVar(&transferTo, "to", "", "Target account address or database dsn")
+	cmdWalletTransfer.Flag.Uint64Var(&transferAmount, "amount", 0, "Token amount to transfer")
+	cmdWalletTransfer.Flag.StringVar(&transferToken, "token", types.Particle.String(), "Token type to transfer, e.g. Particle, Wave")
+	cmdWalletTransfer.Flag.Int64Var(&transferNonce, "nonce", -1, "Account nonce of the transaction, -1 for the next nonce")
+	cmdWalletTransfer.Flag.DurationVar(&transferTimeout, "timeout", 0, "Max duration to wait for confirmation, 0 for default")
+
+	cmdWalletBalance.Run = runWalletBalance
+	addCommonFlags(cmdWalletBalance)
+	addConfigFlag(cmdWalletBalance)
+	cmdWalletBalance.Flag.StringVar(&tokenName, "token", "", "Show specific token balance only, e.g. Particle, Wave")
+	cmdWalletBalance.Flag.BoolVar(&balanceWatch, "watch", false, "Keep printing the balance changes until interrupted")
+	cmdWalletBalance.Flag.DurationVar(&balanceInterval, "interval", 5*time.Second, "Poll interval of -watch")
+
+	cmdWalletHistory.Run = runWalletHistory
+	addCommonFlags(cmdWalletHistory)
+	addConfigFlag(cmdWalletHistory)
+	cmdWalletHistory.Flag.IntVar(&historyLimit, "limit", 20, "Max count of the listed transactions")
+	cmdWalletHistory.Flag.UintVar(&historyBlocks, "blocks", 1000, "Max count of the recent blocks to search")
+}
+
+func parseAccountAddress(s string) (addr proto.AccountAddress, err error) {
+	for _, scheme := range []string{client.DBScheme, client.DBSchemeAlias} {
+		s = strings.TrimPrefix(s, scheme+"://")
+	}
+	h, err := hash.NewHashFromStr(s)
+	if err != nil {
+		return
+	}
+	return proto.AccountAddress(*h), nil
+}
+
+func queryAccount(addr proto.AccountAddress) (resp *types.QueryAccountResp, err error) {
+	resp = new(types.QueryAccountResp)
+	err = mux.RequestBP(route.MCCQueryAccount.String(), &types.QueryAccountReq{Addr: addr}, resp)
+	return
+}
+
+func runWalletTransfer(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+
+	if len(args) > 0 || transferTo == "" || transferAmount == 0 {
+		ConsoleLog.Error("transfer command need -to address and -amount as param")
+		SetExitStatus(exitTxError)
+		printCommandHelp(cmd)
+		Exit()
+	}
+
+	unit := types.FromString(transferToken)
+	if !unit.Listed() {
+		ConsoleLog.Errorf("transfer token failed: invalid token type %#v", transferToken)
+		SetExitStatus(exitTxError)
+		return
+	}
+
+	target, err := parseAccountAddress(transferTo)
+	if err != nil {
+		ConsoleLog.WithError(err).Error("target account address is not valid")
+		SetExitStatus(exitTxError)
+		return
+	}
+
+	configInit()
+
+	var nonce pi.AccountNonce
+	if transferNonce >= 0 {
+		nonce = pi.AccountNonce(transferNonce)
+	} else {
+		var (
+			addr proto.AccountAddress
+			resp = new(types.NextAccountNonceResp)
+		)
+		if addr, err = localAccountAddress(); err == nil {
+			err = mux.RequestBP(route.MCCNextAccountNonce.String(),
+				&types.NextAccountNonceReq{Addr: addr}, resp)
+		}
+		if err != nil {
+			ConsoleLog.WithError(err).Error("get next account nonce failed")
+			SetExitStatus(exitTxError)
+			return
+		}
+		nonce = resp.Nonce
+	}
+
+	txHash, err := client.TransferTokenWithNonce(target, transferAmount, unit, nonce)
+	if err != nil {
+		ConsoleLog.WithError(err).Error("transfer token failed")
+		SetExitStatus(exitTxError)
+		return
+	}
+
+	fmt.Printf("tx hash: %s\n", txHash)
+	fmt.Printf("nonce: %d\n", nonce)
+	fmt.Printf("amount: %d %s\n", transferAmount, unit)
+	fmt.Printf("fee: 0 %s\n", unit)
+
+	if !waitTxConfirmation {
+		return
+	}
+
+	timeout := transferTimeout
+	if timeout <= 0 {
+		timeout = waitTxConfirmationMaxDuration
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	state, err := client.WaitTxConfirmation(ctx, txHash)
+	fmt.Printf("state: %s\n", state)
+	switch {
+	case err == context.DeadlineExceeded:
+		ConsoleLog.WithField("tx_hash", txHash).Error("transaction is not confirmed before timeout")
+		SetExitStatus(exitTxUnconfirmed)
+	case err != nil:
+		ConsoleLog.WithField("tx_hash", txHash).WithError(err).Error("query transaction state failed")
+		SetExitStatus(exitTxError)
+	case state != pi.TransactionStateConfirmed:
+		ConsoleLog.WithField("tx_hash", txHash).Errorf("transaction is %s", state)
+		SetExitStatus(exitTxRejected)
+	}
+}
+
+func runWalletBalance(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+
+	tokens := make([]types.TokenType, 0, types.SupportTokenNumber)
+	if tokenName == "" {
+		for t := types.Particle; t < types.SupportTokenNumber; t++ {
+			tokens = append(tokens, t)
+		}
+	} else if t := types.FromString(tokenName); t.Listed() {
+		tokens = append(tokens, t)
+	} else {
+		ConsoleLog.Errorf("no such token supporting in CovenantSQL: %s", tokenName)
+		SetExitStatus(1)
+		return
+	}
+
+	configInit()
+
+	addr, err := localAccountAddress()
+	if err != nil {
+		ConsoleLog.WithError(err).Error("get current account address failed")
+		SetExitStatus(1)
+		return
+	}
+
+	resp, err := queryAccount(addr)
+	if err != nil {
+		ConsoleLog.WithError(err).Error("query account failed")
+		SetExitStatus(1)
+		return
+	}
+	if !resp.OK {
+		fmt.Println("Your account is not created in the TestNet, please apply tokens from our faucet first.")
+	}
+
+	fmt.Printf("wallet address: %s\n", addr)
+	fmt.Printf("nonce: %d, pending nonce: %d\n", resp.Nonce, resp.PendingNonce)
+	for _, t := range tokens {
+		fmt.Printf("%s balance is: %d\n", t, resp.Balances[t])
+	}
+
+	if !balanceWatch {
+		return
+	}
+
+	var (
+		ticker = time.NewTicker(balanceInterval)
+		stop   = utils.WaitForExit()
+		last   = resp.Balances
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if resp, err = queryAccount(addr); err != nil {
+			ConsoleLog.WithError(err).Warning("query account failed")
+			continue
+		}
+		for _, t := range tokens {
+			if resp.Balances[t] != last[t] {
+				fmt.Printf("%s %s balance is: %d (%+d)\n", time.Now().Format(time.RFC3339), t,
+					resp.Balances[t], int64(resp.Balances[t]-last[t]))
+			}
+		}
+		last = resp.Balances
+	}
+}
+
+func runWalletHistory(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+	configInit()
+
+	addr, err := localAccountAddress()
+	if err != nil {
+		ConsoleLog.WithError(err).Error("get current account address failed")
+		SetExitStatus(1)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Time\tCount\tHash\tType\tNonce\tDirection\tCounterparty\tAmount")
+	defer func() { _ = w.Flush() }()
+
+	var (
+		listed      int
+		mempoolResp = new(types.QueryMempoolResp)
+	)
+	if err = mux.RequestBP(route.MCCQueryMempool.String(),
+		&types.QueryMempoolReq{Addr: &addr}, mempoolResp); err != nil {
+		ConsoleLog.WithError(err).Warning("query pending transactions failed")
+	}
+	for i := len(mempoolResp.Txs) - 1; i >= 0 && listed < historyLimit; i-- {
+		tx := mempoolResp.Txs[i]
+		fmt.Fprintf(w, "%s\tpending\t%s\t%s\t%d\t-\t-\t-\n",
+			tx.Received.Format(time.RFC3339), tx.Hash, tx.Type, tx.Nonce)
+		listed++
+	}
+
+	lastResp := new(types.FetchLastIrreversibleBlockResp)
+	if err = mux.RequestBP(route.MCCFetchLastIrreversibleBlock.String(),
+		&types.FetchLastIrreversibleBlockReq{Address: addr}, lastResp); err != nil {
+		ConsoleLog.WithError(err).Error("fetch last irreversible block failed")
+		SetExitStatus(1)
+		return
+	}
+
+	for scanned, count := uint(0), int64(lastResp.Count); count >= 0 &&
+		scanned < historyBlocks && listed < historyLimit; scanned, count = scanned+1, count-1 {
+		resp := new(types.FetchBlockResp)
+		if err = mux.RequestBP(route.MCCFetchBlockByCount.String(),
+			&types.FetchBlockByCountReq{Count: uint32(count)}, resp); err != nil {
+			ConsoleLog.WithError(err).WithField("count", count).Error("fetch block failed")
+			SetExitStatus(1)
+			return
+		}
+		if resp.Block == nil {
+			continue
+		}
+		for i := len(resp.Block.Transactions) - 1; i >= 0 && listed < historyLimit; i-- {
+			tx := resp.Block.Transactions[i]
+			var (
+				direction, counterparty, amount = "-", "-", "-"
+			)
+			if t, ok := tx.(*types.Transfer); ok && (t.Sender == addr || t.Receiver == addr) {
+				direction, counterparty = "out", t.Receiver.String()
+				if t.Receiver == addr {
+					direction, counterparty = "in", t.Sender.String()
+				}
+				amount = fmt.Sprintf("%d %s", t.Amount, t.TokenType)
+			} else if tx.GetAccountAddress() != addr {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				resp.Block.Timestamp().Format(time.RFC3339), count, tx.Hash(),
+				tx.GetTransactionType(), tx.GetAccountNonce(), direction, counterparty, amount)
+			listed++
+		}
+	}
+}
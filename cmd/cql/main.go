@@ -37,6 +37,7 @@ func init() {
 		internal.CmdCreate,
 		internal.CmdConsole,
 		internal.CmdDrop,
+		internal.CmdDB,
 		internal.CmdTransfer,
 		internal.CmdGrant,
 		internal.CmdMirror,
@@ -44,6 +45,7 @@ func init() {
 		internal.CmdAdapter,
 		internal.CmdIDMiner,
 		internal.CmdRPC,
+		internal.CmdGenesis,
 		internal.CmdVersion,
 		internal.CmdHelp,
 	}
@@ -74,20 +76,7 @@ func main() {
 		if !cmd.Runnable() {
 			continue
 		}
-		var allFlags flag.FlagSet
-		allFlags.Usage = func() { cmd.Usage() }
-		cmd.Flag.VisitAll(func(flag *flag.Flag) {
-			allFlags.Var(flag.Value, flag.Name, flag.Usage)
-		})
-		cmd.CommonFlag.VisitAll(func(flag *flag.Flag) {
-			allFlags.Var(flag.Value, flag.Name, flag.Usage)
-		})
-		cmd.DebugFlag.VisitAll(func(flag *flag.Flag) {
-			allFlags.Var(flag.Value, flag.Name, flag.Usage)
-		})
-		allFlags.Parse(args[1:])
-		args = allFlags.Args()
-		cmd.Run(cmd, args)
+		cmd.Run(cmd, cmd.ParseFlags(args[1:]))
 		internal.Exit()
 		return
 	}
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"syscall"
 	"time"
@@ -26,6 +27,7 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/api"
 	bp "github.com/CovenantSQL/CovenantSQL/blockproducer"
+	bpgenesis "github.com/CovenantSQL/CovenantSQL/blockproducer/genesis"
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/proto"
@@ -154,6 +156,10 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 		Period:         conf.GConf.BPPeriod,
 		Tick:           conf.GConf.BPTick,
 		BlockCacheSize: 1000,
+		FastSync:       conf.GConf.BP.FastSync,
+		Archive:        conf.GConf.BP.Archive,
+		KeepBlocks:     conf.GConf.BP.KeepBlocks,
+		VerifyChain:    verifyChain,
 	}
 	chain, err := bp.NewChain(chainConfig)
 	if err != nil {
@@ -176,6 +182,13 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 	}
 
 	<-utils.WaitForExit()
+
+	// finish the in-flight outgoing RPCs before stopping services
+	ctx, cancel := context.WithTimeout(context.Background(), conf.SessionPoolDrainTimeout)
+	defer cancel()
+	if err := rpc.GetSessionPoolInstance().Drain(ctx); err != nil {
+		log.WithError(err).Warning("drain session pool failed")
+	}
 	return
 }
 
@@ -199,31 +212,12 @@ func loadGenesis() (genesis *types.BPBlock, err error) {
 	genesisInfo := conf.GConf.BP.BPGenesis
 	log.WithField("config", genesisInfo).Info("load genesis config")
 
-	genesis = &types.BPBlock{
-		SignedHeader: types.BPSignedHeader{
-			BPHeader: types.BPHeader{
-				Version:   genesisInfo.Version,
-				Timestamp: genesisInfo.Timestamp,
-			},
-		},
-	}
-
 	for _, ba := range genesisInfo.BaseAccounts {
 		log.WithFields(log.Fields{
 			"address":             ba.Address.String(),
 			"stableCoinBalance":   ba.StableCoinBalance,
 			"covenantCoinBalance": ba.CovenantCoinBalance,
 		}).Debug("setting one balance fixture in genesis block")
-		genesis.Transactions = append(genesis.Transactions, types.NewBaseAccount(
-			&types.Account{
-				Address:      proto.AccountAddress(ba.Address),
-				TokenBalance: [types.SupportTokenNumber]uint64{ba.StableCoinBalance, ba.CovenantCoinBalance},
-			}))
 	}
-
-	// Rewrite genesis merkle and block hash
-	if err = genesis.SetHash(); err != nil {
-		return
-	}
-	return
+	return bpgenesis.FromGenesisInfo(&genesisInfo).Build()
 }
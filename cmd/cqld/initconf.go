@@ -81,6 +81,7 @@ func initNodePeers(nodeID proto.NodeID, publicKeystorePath string) (nodes *[]pro
 				PublicKey:  p.PublicKey,
 				Nonce:      p.Nonce,
 				Role:       p.Role,
+				Addrs:      p.Addrs,
 			}
 			err = kms.SetNode(node)
 			if err != nil {
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -29,6 +30,8 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/metric"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/rpc/mux"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	_ "github.com/CovenantSQL/CovenantSQL/utils/log/debug"
@@ -60,7 +63,8 @@ var (
 	showVersion bool
 	configFile  string
 
-	wsapiAddr string
+	wsapiAddr   string
+	verifyChain bool
 
 	logLevel string
 )
@@ -81,6 +85,8 @@ func init() {
 
 	flag.StringVar(&wsapiAddr, "wsapi", "", "Address of the websocket JSON-RPC API, run as API Node")
 	flag.StringVar(&logLevel, "log-level", "", "Service log level")
+	flag.BoolVar(&verifyChain, "verify-chain", false,
+		"Validate the chain data on startup and repair the torn records")
 
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
@@ -122,6 +128,7 @@ func main() {
 
 	kms.InitBP()
 	log.Debugf("config:\n%#v", conf.GConf)
+	mux.GetSessionPoolInstance().SetLimits(conf.GConf.MaxStreamsPerNode, conf.GConf.MaxStreams)
 	// BP Never Generate new key pair
 	conf.GConf.GenerateKeyPair = false
 
@@ -139,10 +146,26 @@ func main() {
 			os.Exit(-1)
 		}
 	}
+	if len(conf.GConf.MetricsAddr) > 0 {
+		if err = metric.InitPrometheusExporter(conf.GConf.MetricsAddr); err != nil {
+			log.WithError(err).Fatal("start prometheus exporter failed")
+		}
+	}
 	// init profile, if cpuProfile, memProfile length is 0, nothing will be done
 	_ = utils.StartProfile(cpuProfile, memProfile)
 	defer utils.StopProfile()
 
+	// init distributed tracing
+	shutdownTracing, err := rpc.SetupTracing(conf.GConf.Tracing, conf.GConf.ThisNodeID)
+	if err != nil {
+		log.WithError(err).Fatal("setup tracing failed")
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.WithError(err).Warning("shutdown tracing failed")
+		}
+	}()
+
 	if err := runNode(conf.GConf.ThisNodeID, conf.GConf.ListenAddr); err != nil {
 		log.WithError(err).Fatal("run block producer node failed")
 	}
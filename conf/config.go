@@ -76,6 +76,14 @@ type BPInfo struct {
 	ChainFileName string `yaml:"ChainFileName"`
 	// BPGenesis is the genesis block filed
 	BPGenesis BPGenesisInfo `yaml:"BPGenesisInfo,omitempty"`
+	// FastSync enables initializing a new chain db from the latest state snapshot of other
+	// Block Producers instead of replaying the whole chain
+	FastSync bool `yaml:"FastSync,omitempty"`
+	// Archive keeps the full history of main chain, otherwise the transactions of irreversible
+	// blocks older than the most recent KeepBlocks are pruned and only block headers are kept
+	Archive bool `yaml:"Archive,omitempty"`
+	// KeepBlocks is the number of most recent blocks kept with full content in pruning mode
+	KeepBlocks uint32 `yaml:"KeepBlocks,omitempty"`
 }
 
 // MinerDatabaseFixture config.
@@ -96,14 +104,122 @@ type MinerInfo struct {
 	ProvideServiceInterval time.Duration          `yaml:"ProvideServiceInterval,omitempty"`
 	DiskUsageInterval      time.Duration          `yaml:"DiskUsageInterval,omitempty"`
 	TargetUsers            []proto.AccountAddress `yaml:"TargetUsers,omitempty"`
+	// Region is the region which the miner is located in, matched against the regions required
+	// by database creation requests.
+	Region string `yaml:"Region,omitempty"`
+	// SlowQueryTime is the execution time threshold of the slow query log.
+	SlowQueryTime time.Duration `yaml:"SlowQueryTime,omitempty"`
+	// KeyRotationPeriod is the age of database encryption keys to rotate on database loading,
+	// zero to disable key rotation.
+	KeyRotationPeriod time.Duration `yaml:"KeyRotationPeriod,omitempty"`
+	// WriteBatchWindow is the window to group concurrent write requests into a single
+	// replication log entry, zero to disable write batching.
+	WriteBatchWindow time.Duration `yaml:"WriteBatchWindow,omitempty"`
+	// MaxWriteBatchSize is the max request count of a write batch.
+	MaxWriteBatchSize int `yaml:"MaxWriteBatchSize,omitempty"`
+	// ElectionTimeout is the base leader election timeout of databases, it must exceed the read
+	// lease duration. Zero disables leader election.
+	ElectionTimeout time.Duration `yaml:"ElectionTimeout,omitempty"`
+	// AdminAddr is the listen address of the miner admin HTTP endpoint serving health checks,
+	// database status and Prometheus metrics, disabled if empty.
+	AdminAddr string `yaml:"AdminAddr,omitempty"`
+	// LogLevel is the service log level, overridden by the -log-level flag.
+	LogLevel string `yaml:"LogLevel,omitempty"`
 }
 
+// AnonymousQuota defines the server side limits of anonymous ETLS sessions, zero values fall
+// back to the defaults of the node role.
+type AnonymousQuota struct {
+	// MaxConcurrent limits the concurrent anonymous sessions of a listener, negative for unlimited.
+	MaxConcurrent int `yaml:"MaxConcurrent,omitempty"`
+	// MaxConcurrentPerIP limits the concurrent anonymous sessions from the same IP, negative for
+	// unlimited.
+	MaxConcurrentPerIP int `yaml:"MaxConcurrentPerIP,omitempty"`
+	// RatePerIP limits the new anonymous sessions per second from the same IP, negative for
+	// unlimited.
+	RatePerIP float64 `yaml:"RatePerIP,omitempty"`
+	// BurstPerIP is the max burst of new anonymous sessions from the same IP.
+	BurstPerIP int `yaml:"BurstPerIP,omitempty"`
+	// AllowedMethods is the whitelist of RPC methods callable by anonymous sessions.
+	AllowedMethods []string `yaml:"AllowedMethods,omitempty"`
+}
+
+// ConnTuning defines the connection level options of node RPC, zero values fall back to the
+// defaults.
+type ConnTuning struct {
+	// KeepAlive is the TCP keepalive period, default is DefaultTCPKeepAlive, negative value
+	// disables TCP keepalive.
+	KeepAlive time.Duration `yaml:"KeepAlive,omitempty"`
+	// DisableNoDelay enables the Nagle's algorithm, TCP_NODELAY is set by default.
+	DisableNoDelay bool `yaml:"DisableNoDelay,omitempty"`
+	// ReadBufferSize is the socket receive buffer size, system default is used if not set.
+	ReadBufferSize int `yaml:"ReadBufferSize,omitempty"`
+	// WriteBufferSize is the socket send buffer size, system default is used if not set.
+	WriteBufferSize int `yaml:"WriteBufferSize,omitempty"`
+	// HeartbeatInterval is the interval of heartbeats sent over multiplexed ETLS sessions,
+	// default is DefaultHeartbeatInterval.
+	HeartbeatInterval time.Duration `yaml:"HeartbeatInterval,omitempty"`
+	// HeartbeatTimeout is the max silent time before a multiplexed ETLS session is considered
+	// dead and closed, default is DefaultHeartbeatTimeout.
+	HeartbeatTimeout time.Duration `yaml:"HeartbeatTimeout,omitempty"`
+}
+
+// TracingConfig defines the distributed tracing options of a node.
+type TracingConfig struct {
+	// Exporter is the span exporter, TracingExporterNone or TracingExporterStdout, tracing is
+	// disabled if not set.
+	Exporter string `yaml:"Exporter,omitempty"`
+	// File is the output file of stdout exporter, spans are written to stdout if not set.
+	File string `yaml:"File,omitempty"`
+	// SampleRate is the fraction of root traces sampled in (0, 1], all traces are sampled if it's
+	// zero. The sampling decisions of remote parents are always respected.
+	SampleRate float64 `yaml:"SampleRate,omitempty"`
+	// ServiceName is the service name of the spans exported, default is the node role.
+	ServiceName string `yaml:"ServiceName,omitempty"`
+}
+
+// HSMConfig defines the PKCS#11 token holding the private key of the node.
+type HSMConfig struct {
+	// Module is the path of the PKCS#11 library, e.g. /usr/lib/softhsm/libsofthsm2.so.
+	Module string `yaml:"Module"`
+	// TokenLabel is the label of the token holding the key.
+	TokenLabel string `yaml:"TokenLabel"`
+	// PIN is the user PIN of the token.
+	PIN string `yaml:"PIN"`
+	// KeyLabel is the label of the secp256k1 key pair objects in the token.
+	KeyLabel string `yaml:"KeyLabel"`
+}
+
+// Tracing span exporters.
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+)
+
+// Node RPC transports.
+const (
+	TransportTCP  = "tcp"
+	TransportQUIC = "quic"
+)
+
+// Node address fallback dialing strategies.
+const (
+	DialFallbackParallel   = "parallel"
+	DialFallbackSequential = "sequential"
+)
+
 // DNSSeed defines seed DNS info.
 type DNSSeed struct {
 	EnforcedDNSSEC bool     `yaml:"EnforcedDNSSEC"`
 	DNSServers     []string `yaml:"DNSServers"`
 	Domain         string   `yaml:"Domain"`
 	BPCount        int      `yaml:"BPCount"`
+	// DNSSECTrustAnchors defines the DS or DNSKEY records in zone file format of trust anchors of
+	// the local DNSSEC validation enabled by EnforcedDNSSEC, the root zone KSK is used if empty.
+	DNSSECTrustAnchors []string `yaml:"DNSSECTrustAnchors,omitempty"`
+	// NodeDomain is the domain of the IPv6 seed records of non-BP nodes, which are used to
+	// discover the nodes unknown to block producers.
+	NodeDomain string `yaml:"NodeDomain,omitempty"`
 }
 
 // Config holds all the config read from yaml config file.
@@ -126,8 +242,54 @@ type Config struct {
 	ValidDNSKeys       map[string]string `yaml:"ValidDNSKeys"` // map[DNSKEY]domain
 	// Check By BP DHT.Ping
 	MinNodeIDDifficulty int `yaml:"MinNodeIDDifficulty"`
+	// HandshakeTimeout is the deadline of server side ETLS handshake, default is
+	// DefaultHandshakeTimeout if not set.
+	HandshakeTimeout time.Duration `yaml:"HandshakeTimeout,omitempty"`
+	// MaxHandshakesPerIP limits the concurrent server side ETLS handshakes from the same IP,
+	// default is DefaultMaxHandshakesPerIP if not set, negative value means unlimited.
+	MaxHandshakesPerIP int `yaml:"MaxHandshakesPerIP,omitempty"`
+	// MetricsAddr is the listen address of the Prometheus /metrics endpoint, disabled if empty.
+	MetricsAddr string `yaml:"MetricsAddr,omitempty"`
+	// MaxStreamsPerNode limits the in-flight RPC clients of the session pool to each node, 0 for
+	// unlimited.
+	MaxStreamsPerNode int `yaml:"MaxStreamsPerNode,omitempty"`
+	// MaxStreams limits the in-flight RPC clients of the session pool to all nodes, 0 for unlimited.
+	MaxStreams int `yaml:"MaxStreams,omitempty"`
+	// AnonymousQuota overrides the default limits of anonymous ETLS sessions of the node role.
+	AnonymousQuota *AnonymousQuota `yaml:"AnonymousQuota,omitempty"`
+	// MethodACL maps the remote node roles "BP", "Miner", "Client" and "Unknown" to the whitelists
+	// of RPC methods they can call, e.g. "DBS.Query" or "DBS.*". The calls are not checked by role
+	// if it's not set, otherwise the calls from the roles not listed are rejected. The anonymous
+	// sessions are restricted by AnonymousQuota.AllowedMethods instead.
+	MethodACL map[string][]string `yaml:"MethodACL,omitempty"`
+	// Compression is the RPC payload compression algorithm offered or preferred in ETLS handshake,
+	// "snappy", "zstd" or "none" by default.
+	Compression string `yaml:"Compression,omitempty"`
+	// CompressThreshold is the minimum size of payload to be compressed, default is
+	// DefaultCompressThreshold if not set.
+	CompressThreshold int `yaml:"CompressThreshold,omitempty"`
+	// CipherSuites are the ETLS cipher suites offered by client or accepted by server in preference
+	// order, e.g. "chacha20-poly1305", "aes-256-gcm", "xchacha20", "aes-256-ctr". The AEAD suites
+	// "chacha20-poly1305" and "aes-256-gcm" authenticate every record of the connection. The
+	// default "aes-256-cfb" is used if none matches.
+	CipherSuites []string `yaml:"CipherSuites,omitempty"`
+	// Transport is the node RPC transport, TransportTCP or TransportQUIC, default is TCP.
+	// With QUIC transport, RPC server also listens on UDP with the same address alongside TCP.
+	Transport string `yaml:"Transport,omitempty"`
+	// DialFallback is the strategy to dial the multiple advertised addresses of a node,
+	// DialFallbackParallel or DialFallbackSequential, default is parallel.
+	DialFallback string `yaml:"DialFallback,omitempty"`
+	// Conn tunes the TCP options and heartbeats of node RPC connections.
+	Conn *ConnTuning `yaml:"Conn,omitempty"`
+	// Tracing configures the span export of distributed tracing.
+	Tracing *TracingConfig `yaml:"Tracing,omitempty"`
+	// HSM keeps the private key in a PKCS#11 token instead of PrivateKeyFile if set.
+	HSM *HSMConfig `yaml:"HSM,omitempty"`
 
 	DNSSeed DNSSeed `yaml:"DNSSeed"`
+	// NodeRegistryFile is the static node list file in the same format as KnownNodes, which is
+	// used to discover the nodes unknown to block producers.
+	NodeRegistryFile string `yaml:"NodeRegistryFile,omitempty"`
 
 	BP    *BPInfo    `yaml:"BlockProducer"`
 	Miner *MinerInfo `yaml:"Miner,omitempty"`
@@ -195,6 +357,10 @@ func LoadConfig(configPath string) (config *Config, err error) {
 		config.DHTFileName = path.Join(configDir, config.DHTFileName)
 	}
 
+	if config.NodeRegistryFile != "" && !path.IsAbs(config.NodeRegistryFile) {
+		config.NodeRegistryFile = path.Join(configDir, config.NodeRegistryFile)
+	}
+
 	if !path.IsAbs(config.WorkingRoot) {
 		config.WorkingRoot = path.Join(configDir, config.WorkingRoot)
 	}
@@ -21,13 +21,22 @@ import "time"
 const (
 	// MaxPendingTxsPerAccount defines the limit of pending transactions of one account.
 	MaxPendingTxsPerAccount = 1000
+	// MaxPooledTxs defines the limit of pending transactions in the mempool of block producer.
+	MaxPooledTxs = 100000
 	// MaxTransactionsPerBlock defines the limit of transactions per block.
 	MaxTransactionsPerBlock = 10000
+	// MaxBillingSummaryRange defines the limit of the block height range of a billing summary
+	// query.
+	MaxBillingSummaryRange = 10000
 	// MaxRPCPoolPhysicalConnection defines max physical connection for one node pair.
 	MaxRPCPoolPhysicalConnection = 1024
-	// MaxRPCMuxPoolPhysicalConnection defines max underlying physical connection of mux component
-	// for one node pair.
-	MaxRPCMuxPoolPhysicalConnection = 2
+	// ETLSRekeyBytes defines the data volume of each direction after which the ETLS session key
+	// is ratcheted, if both peers advertise rekeying in handshake.
+	ETLSRekeyBytes = 1 << 30
+	// ETLSRekeyInterval defines the lifetime of each ETLS session key after which it is ratcheted
+	// on next write, if both peers advertise rekeying in handshake. The rekey signal is carried
+	// by the framed ETLS record format, which is only used on the connections negotiated it.
+	ETLSRekeyInterval = time.Hour
 )
 
 // These limits will not cause inconsistency within certain range.
@@ -37,4 +46,71 @@ const (
 	MaxTxBroadcastTTL = 1
 	MaxCachedBlock    = 1000
 	TCPDialTimeout    = 10 * time.Second
+	// DefaultHandshakeTimeout defines the default deadline of server side ETLS handshake.
+	DefaultHandshakeTimeout = 5 * time.Second
+	// DefaultMaxHandshakesPerIP defines the default limit of concurrent server side ETLS
+	// handshakes from the same IP.
+	DefaultMaxHandshakesPerIP = 32
+	// SymmetricKeyCacheSize defines the max cached ECDH shared keys of remote nodes.
+	SymmetricKeyCacheSize = 4096
+	// SymmetricKeyCacheTTL defines the lifetime of a cached ECDH shared key.
+	SymmetricKeyCacheTTL = time.Hour
+	// VerifiedNodeCacheSize defines the max cached node ID and nonce pairs verified in handshake.
+	VerifiedNodeCacheSize = 4096
+	// SessionPoolDrainTimeout defines the max waiting time of in-flight RPCs on shutdown.
+	SessionPoolDrainTimeout = 10 * time.Second
+	// DefaultCompressThreshold defines the default minimum size of RPC payload to be compressed.
+	DefaultCompressThreshold = 1024
+	// DefaultTCPKeepAlive defines the default TCP keepalive period of node RPC connections.
+	DefaultTCPKeepAlive = 15 * time.Second
+	// DefaultHeartbeatInterval defines the default heartbeat interval of multiplexed sessions.
+	DefaultHeartbeatInterval = 10 * time.Second
+	// DefaultHeartbeatTimeout defines the default max silent time of multiplexed sessions.
+	DefaultHeartbeatTimeout = 30 * time.Second
+	// DialFallbackDelay defines the delay before dialing the next address of a node while the
+	// previous dialing is still in progress with parallel fallback strategy.
+	DialFallbackDelay = 300 * time.Millisecond
+	// StreamChunkSize defines the max data size of each frame in streaming RPC responses.
+	StreamChunkSize = 64 * 1024
+	// BPPeersSyncInterval defines the interval of non-BP nodes synchronizing the block producer
+	// peer list from main chain.
+	BPPeersSyncInterval = time.Minute
+	// BPSnapshotInterval defines the block count interval of main chain state snapshots.
+	BPSnapshotInterval = 1000
+	// BPSnapshotKept defines the number of main chain state snapshots kept in storage.
+	BPSnapshotKept = 3
+	// DefaultBPKeepBlocks defines the default number of most recent blocks kept with full content
+	// by a non-archive block producer.
+	DefaultBPKeepBlocks = 10000
+	// BPPruneBatchSize defines the max number of blocks pruned in a single storage transaction.
+	BPPruneBatchSize = 1000
+	// TxPoolTTL defines the max time of a transaction staying in the mempool of block producer
+	// without being packed.
+	TxPoolTTL = time.Hour
+	// TxPoolSweepInterval defines the interval of evicting expired transactions from mempool.
+	TxPoolSweepInterval = time.Minute
+	// MaxSubscriptionBacklog defines the max number of chain events buffered for a subscriber,
+	// the subscription is terminated if the subscriber falls behind.
+	MaxSubscriptionBacklog = 1024
+)
+
+// These limits will cause inconsistency if they're changed without a coordinated upgrade.
+const (
+	// BPChainConfigActivationDelay defines the minimum height distance between the height which
+	// a chain config update transaction is packed at and its effective height, which should be
+	// large enough for the transaction to become irreversible before taking effect.
+	BPChainConfigActivationDelay = 100
+	// BPMinPeriod defines the minimum block producing period of main chain.
+	BPMinPeriod = time.Second
+	// BPMinTick defines the minimum tick of main chain.
+	BPMinTick = 100 * time.Millisecond
+	// BPEquivocationPenalty defines the max amount of Particle slashed from a block producer
+	// for each proved equivocation.
+	BPEquivocationPenalty = 1000000000
+	// BPEquivocationRewardDivisor defines the divisor of the slashed amount rewarding the
+	// reporter of an equivocation.
+	BPEquivocationRewardDivisor = 10
+	// MaxMissedBillings defines the max successive billing epochs a database miner may miss the
+	// report, after which it's replaced by a standby provider.
+	MaxMissedBillings = 3
 )
@@ -21,6 +21,7 @@ package etls
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
 	"net"
 	"time"
@@ -34,6 +35,13 @@ import (
 const (
 	// MagicSize is the ETLS magic header size.
 	MagicSize = 2
+
+	// recordHeaderSize is the size of record header: 1 byte flags and 4 bytes payload length.
+	recordHeaderSize = 5
+	// maxRecordSize is the max payload length of each record.
+	maxRecordSize = 1 << 20
+	// recordFlagRekey signals the stream key is ratcheted after the record header.
+	recordFlagRekey = 0x01
 )
 
 var (
@@ -69,7 +77,11 @@ func Dial(network, address string, cipher *Cipher) (c *CryptoConn, err error) {
 
 // Read iv and Encrypted data.
 func (c *CryptoConn) Read(b []byte) (n int, err error) {
-	if c.decStream == nil {
+	if c.isAEAD() {
+		return c.readAEAD(b)
+	}
+
+	if !c.decryptInitialized() {
 		buf := make([]byte, c.info.ivLen+MagicSize)
 		if _, err = io.ReadFull(c.Conn, buf); err != nil {
 			log.WithError(err).Info("read full failed")
@@ -87,28 +99,109 @@ func (c *CryptoConn) Read(b []byte) (n int, err error) {
 		}
 	}
 
+	if c.rekeyInterval > 0 {
+		if len(b) == 0 {
+			return
+		}
+		if err = c.readRecordHeader(); err != nil {
+			return
+		}
+		if uint64(len(b)) > c.recordLeft {
+			b = b[:c.recordLeft]
+		}
+	}
+
 	cipherData := make([]byte, len(b))
 
+	// the last data may be returned with io.EOF, e.g. read from a QUIC stream
 	n, err = c.Conn.Read(cipherData)
-	if err != nil {
-		return
-	}
 	if n > 0 {
 		c.decrypt(b[0:n], cipherData[0:n])
+		if c.rekeyInterval > 0 {
+			c.recordLeft -= uint64(n)
+		}
 	}
 	return
 }
 
+// readRecordHeader reads the next record header if the current record is consumed.
+func (c *CryptoConn) readRecordHeader() (err error) {
+	for c.recordLeft == 0 {
+		header := make([]byte, recordHeaderSize)
+		if _, err = io.ReadFull(c.Conn, header); err != nil {
+			return
+		}
+		c.decrypt(header, header)
+		if header[0]&^recordFlagRekey != 0 {
+			return errors.New("bad ETLS record header")
+		}
+		if header[0]&recordFlagRekey != 0 {
+			c.rekeyDecrypt()
+		}
+		c.recordLeft = uint64(binary.BigEndian.Uint32(header[1:]))
+	}
+	return
+}
+
+// readAEAD reads the iv and the authenticated records of AEAD cipher suite, the connection
+// fails on any record not authenticated.
+func (c *CryptoConn) readAEAD(b []byte) (n int, err error) {
+	if !c.decryptInitialized() {
+		iv := make([]byte, c.info.ivLen)
+		if _, err = io.ReadFull(c.Conn, iv); err != nil {
+			return
+		}
+		if err = c.initDecrypt(iv); err != nil {
+			return
+		}
+	}
+	if len(b) == 0 {
+		return
+	}
+	for len(c.pending) == 0 {
+		header := make([]byte, recordHeaderSize)
+		if _, err = io.ReadFull(c.Conn, header); err != nil {
+			return
+		}
+		size := uint64(binary.BigEndian.Uint32(header[1:]))
+		overhead := uint64(c.decAEAD.Overhead())
+		if header[0]&^recordFlagRekey != 0 || size < overhead || size > maxRecordSize+overhead {
+			return 0, errors.New("bad ETLS record header")
+		}
+		record := make([]byte, size)
+		if _, err = io.ReadFull(c.Conn, record); err != nil {
+			return
+		}
+		if header[0]&recordFlagRekey != 0 {
+			c.rekeyDecrypt()
+		}
+		if c.pending, err = c.open(header, record); err != nil {
+			return 0, errors.Wrap(err, "authenticate ETLS record failed")
+		}
+	}
+	n = copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return
+}
+
 // Write iv and Encrypted data.
 func (c *CryptoConn) Write(b []byte) (n int, err error) {
 	var iv []byte
-	if c.encStream == nil {
+	if !c.encryptInitialized() {
 		iv, err = c.initEncrypt()
 		if err != nil {
 			return
 		}
 	}
 
+	if c.isAEAD() {
+		// iv and the records are sent in a single write
+		if _, err = c.Conn.Write(c.sealAEADRecords(iv, b)); err == nil {
+			n = len(b)
+		}
+		return
+	}
+
 	if iv != nil {
 		ivHeader := make([]byte, len(iv)+MagicSize)
 		// Put initialization vector in buffer, do a single write to send both
@@ -121,12 +214,79 @@ func (c *CryptoConn) Write(b []byte) (n int, err error) {
 		}
 	}
 
+	if c.rekeyInterval > 0 {
+		if _, err = c.Conn.Write(c.sealRecords(b)); err == nil {
+			n = len(b)
+		}
+		return
+	}
+
 	cipherData := make([]byte, len(b))
 	c.encrypt(cipherData, b)
 	n, err = c.Conn.Write(cipherData)
 	return
 }
 
+// sealRecords encrypts data to records, the stream key is ratcheted after the record header
+// flagged once the rekey interval is reached.
+func (c *CryptoConn) sealRecords(b []byte) (cipherData []byte) {
+	cipherData = make([]byte, 0, len(b)+(len(b)/maxRecordSize+1)*recordHeaderSize)
+	header := make([]byte, recordHeaderSize)
+	for len(b) > 0 {
+		l := len(b)
+		if l > maxRecordSize {
+			l = maxRecordSize
+		}
+		header[0] = 0
+		rekey := c.rekeyDue()
+		if rekey {
+			header[0] = recordFlagRekey
+		}
+		binary.BigEndian.PutUint32(header[1:], uint32(l))
+		off := len(cipherData)
+		cipherData = append(cipherData, header...)
+		c.encrypt(cipherData[off:], header)
+		if rekey {
+			c.rekeyEncrypt()
+		}
+		off = len(cipherData)
+		cipherData = append(cipherData, b[:l]...)
+		c.encrypt(cipherData[off:], b[:l])
+		b = b[l:]
+	}
+	return
+}
+
+// sealAEADRecords appends the authenticated records of data to iv, the record header is
+// authenticated as additional data. The stream key is ratcheted after the record header flagged
+// once the rekey interval or data volume is reached.
+func (c *CryptoConn) sealAEADRecords(iv, b []byte) (cipherData []byte) {
+	overhead := c.encAEAD.Overhead()
+	cipherData = make([]byte, 0, len(iv)+len(b)+(len(b)/maxRecordSize+1)*(recordHeaderSize+overhead))
+	cipherData = append(cipherData, iv...)
+	for len(b) > 0 {
+		header := make([]byte, recordHeaderSize)
+		if c.rekeyDue() || (c.rekeyBytes > 0 && c.encLeft == 0) {
+			header[0] = recordFlagRekey
+			c.rekeyEncrypt()
+		}
+		l := len(b)
+		if l > maxRecordSize {
+			l = maxRecordSize
+		}
+		if c.rekeyBytes > 0 && uint64(l) > c.encLeft {
+			l = int(c.encLeft)
+		}
+		binary.BigEndian.PutUint32(header[1:], uint32(l+overhead))
+		cipherData = c.seal(append(cipherData, header...), header, b[:l])
+		if c.rekeyBytes > 0 {
+			c.encLeft -= uint64(l)
+		}
+		b = b[l:]
+	}
+	return
+}
+
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
 func (c *CryptoConn) Close() error {
@@ -17,11 +17,14 @@
 package etls
 
 import (
+	"bytes"
+	"io"
 	"net"
 	"net/rpc"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
@@ -316,3 +319,160 @@ func TestDialTimeout(t *testing.T) {
 	})
 
 }
+
+func TestCryptoConn_Rekey(t *testing.T) {
+	Convey("rekey every few bytes", t, func(c C) {
+		newPair := func(serverRekey, clientRekey uint64) (server, client *CryptoConn) {
+			sc, cc := net.Pipe()
+			sCipher, cCipher := NewCipher([]byte(pass)), NewCipher([]byte(pass))
+			sCipher.SetRekeyBytes(serverRekey)
+			cCipher.SetRekeyBytes(clientRekey)
+			return NewConn(sc, sCipher), NewConn(cc, cCipher)
+		}
+		msg := make([]byte, 4096)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		transfer := func(w, r *CryptoConn) []byte {
+			go func() {
+				// write with uneven chunks to cross the rekey boundaries
+				for i, n := 0, 1; i < len(msg); i, n = i+n, n+3 {
+					if i+n > len(msg) {
+						n = len(msg) - i
+					}
+					_, _ = w.Write(msg[i : i+n])
+				}
+			}()
+			buf := make([]byte, len(msg))
+			_, err := io.ReadFull(r, buf)
+			c.So(err, ShouldBeNil)
+			return buf
+		}
+
+		server, client := newPair(7, 7)
+		defer func() { _ = server.Close(); _ = client.Close() }()
+		So(transfer(client, server), ShouldResemble, msg)
+		So(transfer(server, client), ShouldResemble, msg)
+		So(client.encKey, ShouldResemble, server.decKey)
+		So(server.encKey, ShouldResemble, client.decKey)
+		So(client.encKey, ShouldNotResemble, server.encKey)
+		So(client.encKey, ShouldNotResemble, client.key)
+
+		// peer without rekeying can not decrypt data after the first rekey
+		server, client = newPair(0, 7)
+		defer func() { _ = server.Close(); _ = client.Close() }()
+		buf := transfer(client, server)
+		So(buf[:5], ShouldResemble, msg[:5])
+		So(buf, ShouldNotResemble, msg)
+	})
+	Convey("rekey on interval", t, func(c C) {
+		sc, cc := net.Pipe()
+		sCipher, cCipher := NewCipher([]byte(pass)), NewCipher([]byte(pass))
+		sCipher.SetRekeyInterval(time.Nanosecond)
+		cCipher.SetRekeyInterval(time.Nanosecond)
+		server, client := NewConn(sc, sCipher), NewConn(cc, cCipher)
+		defer func() { _ = server.Close(); _ = client.Close() }()
+
+		msg := "rekey on interval"
+		var keys, ivs [][]byte
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 3; i++ {
+				_, _ = client.Write([]byte(msg))
+				keys, ivs = append(keys, client.encKey), append(ivs, client.encIV)
+			}
+		}()
+		buf := make([]byte, 3*len(msg))
+		_, err := io.ReadFull(server, buf)
+		So(err, ShouldBeNil)
+		<-done
+		So(string(buf), ShouldEqual, strings.Repeat(msg, 3))
+		So(keys, ShouldHaveLength, 3)
+		So(keys[0], ShouldNotResemble, client.key)
+		So(keys[1], ShouldNotResemble, keys[0])
+		So(keys[2], ShouldNotResemble, keys[1])
+		So(server.decKey, ShouldResemble, keys[2])
+
+		// stream key is not derived from the previous key and iv only
+		_, next, _ := cCipher.ratchet(keys[1], ivs[1])
+		So(next, ShouldNotResemble, keys[2])
+
+		// peer without record format can not read the data
+		sc, cc = net.Pipe()
+		cCipher = NewCipher([]byte(pass))
+		cCipher.SetRekeyInterval(time.Hour)
+		server, client = NewConn(sc, NewCipher([]byte(pass))), NewConn(cc, cCipher)
+		go func() { _, _ = client.Write([]byte(msg)) }()
+		buf = make([]byte, len(msg))
+		_, err = io.ReadFull(server, buf)
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldNotEqual, msg)
+	})
+}
+
+func TestCryptoConn_AEAD(t *testing.T) {
+	Convey("transfer authenticated records", t, func(c C) {
+		msg := bytes.Repeat([]byte("CovenantSQL"), maxRecordSize/8)
+		for _, suite := range []CipherSuite{ChaCha20Poly1305, AES256GCM} {
+			So(suite.IsAEAD(), ShouldBeTrue)
+			sc, cc := net.Pipe()
+			sCipher, cCipher := NewCipherWithSuite([]byte(pass), suite), NewCipherWithSuite([]byte(pass), suite)
+			cCipher.SetRekeyBytes(1000)
+			cCipher.SetRekeyInterval(time.Nanosecond)
+			server, client := NewConn(sc, sCipher), NewConn(cc, cCipher)
+
+			go func() {
+				for _, b := range [][]byte{msg, msg[:7], msg} {
+					_, err := client.Write(b)
+					c.So(err, ShouldBeNil)
+				}
+			}()
+			buf := make([]byte, 2*len(msg)+7)
+			_, err := io.ReadFull(server, buf)
+			So(err, ShouldBeNil)
+			So(buf, ShouldResemble, append(append(append([]byte{}, msg...), msg[:7]...), msg...))
+			So(server.decKey, ShouldResemble, client.encKey)
+			So(client.encKey, ShouldNotResemble, client.key)
+			_ = server.Close()
+			_ = client.Close()
+		}
+		So(AES256CFB.IsAEAD(), ShouldBeFalse)
+		So(XChaCha20.IsAEAD(), ShouldBeFalse)
+	})
+	Convey("tampered records should be rejected", t, func(c C) {
+		for _, suite := range []CipherSuite{ChaCha20Poly1305, AES256GCM} {
+			wire, cc := net.Pipe()
+			client := NewConn(cc, NewCipherWithSuite([]byte(pass), suite))
+			msg := []byte("authenticated message")
+			go func() { _, _ = client.Write(msg) }()
+			size := 12 + recordHeaderSize + len(msg) + 16
+			raw := make([]byte, size)
+			_, err := io.ReadFull(wire, raw)
+			So(err, ShouldBeNil)
+			_ = client.Close()
+
+			for _, offset := range []int{12 + 1, 12 + recordHeaderSize, size - 1} {
+				tampered := append([]byte{}, raw...)
+				tampered[offset] ^= 0x01
+				sc, peer := net.Pipe()
+				server := NewConn(sc, NewCipherWithSuite([]byte(pass), suite))
+				go func() { _, _ = peer.Write(tampered); _ = peer.Close() }()
+				buf := make([]byte, len(msg))
+				_, err = io.ReadFull(server, buf)
+				So(err, ShouldNotBeNil)
+				_ = server.Close()
+			}
+
+			// untampered records are accepted
+			sc, peer := net.Pipe()
+			server := NewConn(sc, NewCipherWithSuite([]byte(pass), suite))
+			go func() { _, _ = peer.Write(raw) }()
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(server, buf)
+			So(err, ShouldBeNil)
+			So(buf, ShouldResemble, msg)
+			_ = server.Close()
+		}
+	})
+}
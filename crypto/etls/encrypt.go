@@ -21,6 +21,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"io"
+	"time"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 )
@@ -75,56 +76,228 @@ func newAESCFBDecStream(key, iv []byte) (cipher.Stream, error) {
 
 type cipherInfo struct {
 	keyLen       int
-	ivLen        int
+	ivLen        int // nonce size of AEAD suite
 	newDecStream func(key, iv []byte) (cipher.Stream, error)
 	newEncStream func(key, iv []byte) (cipher.Stream, error)
+	newAEAD      func(key []byte) (cipher.AEAD, error) // set for AEAD suites only
 }
 
+const (
+	rekeyChainLabel = "ETLS rekey chain"
+	rekeyLabel      = "ETLS rekey"
+	rekeyIVLabel    = "ETLS rekey iv"
+)
+
 // Cipher struct keeps cipher mode, key, iv.
 type Cipher struct {
 	encStream cipher.Stream
 	decStream cipher.Stream
 	key       []byte
 	info      *cipherInfo
+
+	// AEAD states of each direction, the nonce is the iv xor record sequence number.
+	encAEAD, decAEAD cipher.AEAD
+	encSeq, decSeq   uint64
+	pending          []byte // opened record data not yet read
+
+	// Rekeying states of each direction, the stream key is ratcheted every rekeyBytes, or
+	// every rekeyInterval signaled by the sender in record header.
+	rekeyBytes         uint64
+	rekeyInterval      time.Duration
+	chainKey           []byte
+	encChain, decChain []byte
+	encKey, decKey     []byte
+	encIV, decIV       []byte
+	encLeft            uint64
+	decLeft            uint64
+	encRekeyed         time.Time
+	recordLeft         uint64
 }
 
 // NewCipher creates a cipher that can be used in Dial(), Listen() etc.
 func NewCipher(rawKey []byte) (c *Cipher) {
-	mi := &cipherInfo{
-		32,
-		16,
-		newAESCFBDecStream,
-		newAESCFBEncStream,
+	return NewCipherWithSuite(rawKey, AES256CFB)
+}
+
+// NewCipherWithSuite creates a cipher of the cipher suite, unknown suite falls back to the
+// default AES256CFB.
+func NewCipherWithSuite(rawKey []byte, suite CipherSuite) (c *Cipher) {
+	mi, ok := cipherSuites[suite]
+	if !ok {
+		suite, mi = AES256CFB, cipherSuites[AES256CFB]
 	}
 	hSuite := &hash.HashSuite{
 		HashLen:  hash.HashBSize,
 		HashFunc: hash.DoubleHashB,
 	}
+	if suite != AES256CFB {
+		// separate the keys of different suites derived from the same raw key
+		rawKey = append(append([]byte(suite.String()), 0), rawKey...)
+	}
 	key := KeyDerivation(rawKey, mi.keyLen, hSuite)
-	c = &Cipher{key: key, info: mi}
+	// the rekey chain is seeded from the shared secret and never used as stream key
+	chainKey := KeyDerivation(append([]byte(rekeyChainLabel), rawKey...), hash.HashBSize, hSuite)
+	c = &Cipher{key: key, info: mi, chainKey: chainKey}
 
 	return c
 }
 
-// initEncrypt Initializes the block cipher with CFB mode, returns IV.
+// SetRekeyBytes sets the stream key of each direction to be ratcheted every n bytes, zero
+// disables rekeying. Both sides of the connection must use the same value, and it should be set
+// before any data is transferred.
+func (c *Cipher) SetRekeyBytes(n uint64) {
+	c.rekeyBytes = n
+}
+
+// SetRekeyInterval sets the stream key of each direction to be ratcheted on the first write after
+// every d duration, zero disables it. A non-zero interval enables the framed record format
+// carrying the rekey signal, so both sides of the connection must enable it, and it should be
+// set before any data is transferred.
+func (c *Cipher) SetRekeyInterval(d time.Duration) {
+	c.rekeyInterval = d
+}
+
+// isAEAD reports whether the data is sent as authenticated records.
+func (c *Cipher) isAEAD() bool {
+	return c.info.newAEAD != nil
+}
+
+func (c *Cipher) encryptInitialized() bool {
+	return c.encStream != nil || c.encAEAD != nil
+}
+
+func (c *Cipher) decryptInitialized() bool {
+	return c.decStream != nil || c.decAEAD != nil
+}
+
+// initEncrypt Initializes the encrypt stream or AEAD with a random IV, returns IV.
 func (c *Cipher) initEncrypt() (iv []byte, err error) {
 	iv = make([]byte, c.info.ivLen)
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
 		return nil, err
 	}
-	c.encStream, err = c.info.newEncStream(c.key, iv)
+	if c.isAEAD() {
+		if c.encAEAD, err = c.info.newAEAD(c.key); err != nil {
+			return
+		}
+	} else if c.encStream, err = c.info.newEncStream(c.key, iv); err != nil {
+		return
+	}
+	c.encChain, c.encKey, c.encIV, c.encLeft = c.chainKey, c.key, iv, c.rekeyBytes
+	c.encRekeyed = time.Now()
 	return
 }
 
 func (c *Cipher) initDecrypt(iv []byte) (err error) {
-	c.decStream, err = c.info.newDecStream(c.key, iv)
+	if c.isAEAD() {
+		if c.decAEAD, err = c.info.newAEAD(c.key); err != nil {
+			return
+		}
+	} else if c.decStream, err = c.info.newDecStream(c.key, iv); err != nil {
+		return
+	}
+	c.decChain, c.decKey, c.decIV, c.decLeft = c.chainKey, c.key, append([]byte(nil), iv...), c.rekeyBytes
 	return
 }
 
+// ratchet advances the one-way rekey chain and derives the next stream key and iv from it, so
+// neither the past nor the next keys could be derived from a leaked stream key and the iv on
+// wire. The iv of each direction is randomly chosen by the sender, so the key chains of the two
+// directions diverge.
+func (c *Cipher) ratchet(chain, iv []byte) (nextChain, nextKey, nextIV []byte) {
+	buf := make([]byte, 0, len(rekeyChainLabel)+len(chain)+len(iv))
+	buf = append(append(append(buf, rekeyChainLabel...), chain...), iv...)
+	nextChain = hash.DoubleHashB(buf)
+	buf = append(append(buf[:0], rekeyLabel...), nextChain...)
+	nextKey = hash.DoubleHashB(buf)[:c.info.keyLen]
+	buf = append(append(buf[:0], rekeyIVLabel...), nextChain...)
+	nextIV = hash.DoubleHashB(buf)[:c.info.ivLen]
+	return
+}
+
+func (c *Cipher) rekeyEncrypt() {
+	c.encChain, c.encKey, c.encIV = c.ratchet(c.encChain, c.encIV)
+	// key length is fixed, so there won't be any error
+	if c.isAEAD() {
+		c.encAEAD, _ = c.info.newAEAD(c.encKey)
+		c.encSeq = 0
+	} else {
+		c.encStream, _ = c.info.newEncStream(c.encKey, c.encIV)
+	}
+	c.encLeft = c.rekeyBytes
+	c.encRekeyed = time.Now()
+}
+
+func (c *Cipher) rekeyDecrypt() {
+	c.decChain, c.decKey, c.decIV = c.ratchet(c.decChain, c.decIV)
+	// key length is fixed, so there won't be any error
+	if c.isAEAD() {
+		c.decAEAD, _ = c.info.newAEAD(c.decKey)
+		c.decSeq = 0
+	} else {
+		c.decStream, _ = c.info.newDecStream(c.decKey, c.decIV)
+	}
+	c.decLeft = c.rekeyBytes
+}
+
+// nonce returns the AEAD nonce of the record sequence number.
+func nonce(iv []byte, seq uint64) []byte {
+	n := append([]byte(nil), iv...)
+	for i := 0; i < 8; i++ {
+		n[len(n)-1-i] ^= byte(seq >> (8 * uint(i)))
+	}
+	return n
+}
+
+// seal appends the authenticated record of data with header as additional data to dst.
+func (c *Cipher) seal(dst, header, data []byte) []byte {
+	dst = c.encAEAD.Seal(dst, nonce(c.encIV, c.encSeq), data, header)
+	c.encSeq++
+	return dst
+}
+
+// open authenticates and decrypts the record with header as additional data.
+func (c *Cipher) open(header, record []byte) (data []byte, err error) {
+	if data, err = c.decAEAD.Open(record[:0], nonce(c.decIV, c.decSeq), record, header); err != nil {
+		return
+	}
+	c.decSeq++
+	return
+}
+
+// rekeyDue reports whether the encrypt stream key has been used for more than rekeyInterval.
+func (c *Cipher) rekeyDue() bool {
+	return c.rekeyInterval > 0 && time.Since(c.encRekeyed) >= c.rekeyInterval
+}
+
 func (c *Cipher) encrypt(dst, src []byte) {
-	c.encStream.XORKeyStream(dst, src)
+	for len(src) > 0 {
+		n := len(src)
+		if c.rekeyBytes > 0 && uint64(n) > c.encLeft {
+			n = int(c.encLeft)
+		}
+		c.encStream.XORKeyStream(dst[:n], src[:n])
+		dst, src = dst[n:], src[n:]
+		if c.rekeyBytes > 0 {
+			if c.encLeft -= uint64(n); c.encLeft == 0 {
+				c.rekeyEncrypt()
+			}
+		}
+	}
 }
 
 func (c *Cipher) decrypt(dst, src []byte) {
-	c.decStream.XORKeyStream(dst, src)
+	for len(src) > 0 {
+		n := len(src)
+		if c.rekeyBytes > 0 && uint64(n) > c.decLeft {
+			n = int(c.decLeft)
+		}
+		c.decStream.XORKeyStream(dst[:n], src[:n])
+		dst, src = dst[n:], src[n:]
+		if c.rekeyBytes > 0 {
+			if c.decLeft -= uint64(n); c.decLeft == 0 {
+				c.rekeyDecrypt()
+			}
+		}
+	}
 }
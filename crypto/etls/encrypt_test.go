@@ -18,6 +18,7 @@ package etls
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -37,3 +38,38 @@ func TestKeyDerivation(t *testing.T) {
 		So(dKey, ShouldHaveLength, 100)
 	})
 }
+
+func TestCipherSuite(t *testing.T) {
+	Convey("encrypt and decrypt with cipher suites", t, func() {
+		rawKey := []byte("shared key")
+		plain := bytes.Repeat([]byte("CovenantSQL"), 1000)
+		var keys [][]byte
+		for _, name := range []string{"aes-256-cfb", "AES-256-CTR", "xchacha20"} {
+			suite, ok := CipherSuiteByName(name)
+			So(ok, ShouldBeTrue)
+			So(suite.String(), ShouldEqual, strings.ToLower(name))
+			enc, dec := NewCipherWithSuite(rawKey, suite), NewCipherWithSuite(rawKey, suite)
+			enc.SetRekeyBytes(100)
+			dec.SetRekeyBytes(100)
+			iv, err := enc.initEncrypt()
+			So(err, ShouldBeNil)
+			So(dec.initDecrypt(iv), ShouldBeNil)
+			encrypted := make([]byte, len(plain))
+			enc.encrypt(encrypted, plain)
+			So(encrypted, ShouldNotResemble, plain)
+			decrypted := make([]byte, len(plain))
+			dec.decrypt(decrypted[:333], encrypted[:333])
+			dec.decrypt(decrypted[333:], encrypted[333:])
+			So(decrypted, ShouldResemble, plain)
+			keys = append(keys, enc.key)
+		}
+		So(keys[0], ShouldResemble, NewCipher(rawKey).key)
+		So(keys[1], ShouldNotResemble, keys[0])
+		So(keys[2], ShouldNotResemble, keys[1])
+
+		_, ok := CipherSuiteByName("rot13")
+		So(ok, ShouldBeFalse)
+		So(CipherSuite(0xff).String(), ShouldEqual, "unknown")
+		So(NewCipherWithSuite(rawKey, CipherSuite(0xff)).key, ShouldResemble, keys[0])
+	})
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"strings"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherSuite identifies the cipher of ETLS connection.
+type CipherSuite byte
+
+// ETLS cipher suites.
+const (
+	// AES256CFB is the default cipher suite.
+	AES256CFB CipherSuite = iota
+	// AES256CTR is the AES-256 cipher in CTR mode, which is faster than CFB mode with AES-NI.
+	AES256CTR
+	// XChaCha20 is the XChaCha20 stream cipher, which is faster on platforms without AES
+	// hardware acceleration, e.g. ARM.
+	XChaCha20
+	// ChaCha20Poly1305 is the ChaCha20-Poly1305 AEAD, the data is sent as authenticated records.
+	ChaCha20Poly1305
+	// AES256GCM is the AES-256 cipher in GCM mode, the data is sent as authenticated records.
+	AES256GCM
+)
+
+var cipherSuites = map[CipherSuite]*cipherInfo{
+	AES256CFB: {keyLen: 32, ivLen: aes.BlockSize, newDecStream: newAESCFBDecStream, newEncStream: newAESCFBEncStream},
+	AES256CTR: {keyLen: 32, ivLen: aes.BlockSize, newDecStream: newAESCTRStream, newEncStream: newAESCTRStream},
+	XChaCha20: {
+		keyLen: chacha20.KeySize, ivLen: chacha20.NonceSizeX,
+		newDecStream: newXChaCha20Stream, newEncStream: newXChaCha20Stream,
+	},
+	ChaCha20Poly1305: {keyLen: chacha20poly1305.KeySize, ivLen: chacha20poly1305.NonceSize, newAEAD: chacha20poly1305.New},
+	AES256GCM:        {keyLen: 32, ivLen: 12, newAEAD: newAESGCM},
+}
+
+var cipherSuiteNames = map[CipherSuite]string{
+	AES256CFB:        "aes-256-cfb",
+	AES256CTR:        "aes-256-ctr",
+	XChaCha20:        "xchacha20",
+	ChaCha20Poly1305: "chacha20-poly1305",
+	AES256GCM:        "aes-256-gcm",
+}
+
+// String returns the name of the cipher suite.
+func (s CipherSuite) String() string {
+	if name, ok := cipherSuiteNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// IsAEAD reports whether the cipher suite authenticates the data.
+func (s CipherSuite) IsAEAD() bool {
+	info, ok := cipherSuites[s]
+	return ok && info.newAEAD != nil
+}
+
+// CipherSuiteByName returns the cipher suite of name, ok is false if name is unknown.
+func CipherSuiteByName(name string) (suite CipherSuite, ok bool) {
+	for k, v := range cipherSuiteNames {
+		if strings.EqualFold(v, name) {
+			return k, true
+		}
+	}
+	return
+}
+
+func newAESCTRStream(key, iv []byte) (cipher.Stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, iv), nil
+}
+
+func newXChaCha20Stream(key, iv []byte) (cipher.Stream, error) {
+	return chacha20.NewUnauthenticatedCipher(key, iv)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
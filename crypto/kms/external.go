@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+)
+
+// ErrPKCS11NotSupported indicates the binary is built without PKCS#11 support.
+var ErrPKCS11NotSupported = errors.New("PKCS#11 is not supported, rebuild with tag pkcs11")
+
+// ExternalKey is the local private key kept out of the process, e.g. in an HSM or a PKCS#11
+// token, which performs the private key operations on behalf of the node.
+type ExternalKey interface {
+	// PublicKey returns the public key of the key pair.
+	PublicKey() *asymmetric.PublicKey
+	// Sign signs the hash with the private key.
+	Sign(hash []byte) (*asymmetric.Signature, error)
+	// ECDH generates the shared secret with the remote public key, which must be the same as
+	// the one generated by asymmetric.GenECDHSharedSecret.
+	ECDH(remote *asymmetric.PublicKey) ([]byte, error)
+}
+
+// SetLocalExternalKey sets the external key as local key pair, this is a one time thing.
+//
+// GetLocalPrivateKey returns ErrNilField with an external key, the key operations should be
+// made with LocalSign and LocalECDH instead.
+func SetLocalExternalKey(key ExternalKey) {
+	localKey.Lock()
+	defer localKey.Unlock()
+	if localKey.isSet {
+		return
+	}
+	localKey.isSet = true
+	localKey.external = key
+	localKey.public = key.PublicKey()
+}
+
+// GetLocalExternalKey gets local external key, if not set yet returns nil.
+func GetLocalExternalKey() (key ExternalKey, err error) {
+	localKey.RLock()
+	key = localKey.external
+	if key == nil {
+		err = ErrNilField
+	}
+	localKey.RUnlock()
+	return
+}
+
+// LocalSign signs the hash with local private key or the external key.
+func LocalSign(hash []byte) (sig *asymmetric.Signature, err error) {
+	localKey.RLock()
+	private, external := localKey.private, localKey.external
+	localKey.RUnlock()
+	switch {
+	case external != nil:
+		return external.Sign(hash)
+	case private != nil:
+		return private.Sign(hash)
+	}
+	err = ErrNilField
+	return
+}
+
+// LocalECDH generates the shared secret between local private key or the external key and the
+// remote public key.
+func LocalECDH(remote *asymmetric.PublicKey) (secret []byte, err error) {
+	localKey.RLock()
+	private, external := localKey.private, localKey.external
+	localKey.RUnlock()
+	switch {
+	case external != nil:
+		return external.ECDH(remote)
+	case private != nil:
+		secret = asymmetric.GenECDHSharedSecret(private, remote)
+		return
+	}
+	err = ErrNilField
+	return
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+)
+
+// memoryKey is an ExternalKey keeping the private key in memory.
+type memoryKey struct {
+	private *asymmetric.PrivateKey
+}
+
+func (k *memoryKey) PublicKey() *asymmetric.PublicKey {
+	return k.private.PubKey()
+}
+
+func (k *memoryKey) Sign(hash []byte) (*asymmetric.Signature, error) {
+	return k.private.Sign(hash)
+}
+
+func (k *memoryKey) ECDH(remote *asymmetric.PublicKey) ([]byte, error) {
+	return asymmetric.GenECDHSharedSecret(k.private, remote), nil
+}
+
+func TestExternalKey(t *testing.T) {
+	Convey("sign and exchange key with local key", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		h := hash.THashB([]byte("data"))
+		_, err := LocalSign(h)
+		So(err, ShouldEqual, ErrNilField)
+		_, remote, _ := asymmetric.GenSecp256k1KeyPair()
+		_, err = LocalECDH(remote)
+		So(err, ShouldEqual, ErrNilField)
+
+		private, public, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(private, public)
+		sig, err := LocalSign(h)
+		So(err, ShouldBeNil)
+		So(sig.Verify(h, public), ShouldBeTrue)
+		secret, err := LocalECDH(remote)
+		So(err, ShouldBeNil)
+		So(secret, ShouldResemble, asymmetric.GenECDHSharedSecret(private, remote))
+	})
+	Convey("sign and exchange key with external key", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		_, err := GetLocalExternalKey()
+		So(err, ShouldEqual, ErrNilField)
+
+		private, _, _ := asymmetric.GenSecp256k1KeyPair()
+		key := &memoryKey{private: private}
+		SetLocalExternalKey(key)
+		got, err := GetLocalExternalKey()
+		So(err, ShouldBeNil)
+		So(got, ShouldEqual, key)
+		public, err := GetLocalPublicKey()
+		So(err, ShouldBeNil)
+		So(public.IsEqual(private.PubKey()), ShouldBeTrue)
+		_, err = GetLocalPrivateKey()
+		So(err, ShouldEqual, ErrNilField)
+
+		h := hash.THashB([]byte("data"))
+		sig, err := LocalSign(h)
+		So(err, ShouldBeNil)
+		So(sig.Verify(h, public), ShouldBeTrue)
+		remotePrivate, remote, _ := asymmetric.GenSecp256k1KeyPair()
+		secret, err := LocalECDH(remote)
+		So(err, ShouldBeNil)
+		So(secret, ShouldResemble, asymmetric.GenECDHSharedSecret(remotePrivate, public))
+
+		_, err = OpenPKCS11Key(nil)
+		So(err, ShouldEqual, ErrPKCS11NotSupported)
+	})
+}
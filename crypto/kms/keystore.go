@@ -0,0 +1,340 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcutil/base58"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+const (
+	// DefaultKeyName is the name of the key imported from a single private key file.
+	DefaultKeyName = "default"
+)
+
+var (
+	// ErrKeyExists indicates the key name is already used in the keystore
+	ErrKeyExists = errors.New("key already exists in keystore")
+	// ErrActiveKey indicates the operation is not allowed on the active key
+	ErrActiveKey = errors.New("operation not allowed on active key")
+	// ErrNoActiveKey indicates the keystore has no active key
+	ErrNoActiveKey = errors.New("no active key in keystore")
+	// ErrPasswordNotMatch indicates the master password of keystore is wrong
+	ErrPasswordNotMatch = errors.New("keystore password not match")
+	// ErrInvalidKeyName indicates the key name is empty
+	ErrInvalidKeyName = errors.New("invalid key name")
+	// KeyStoreVersion defines the multiple private keys store version byte.
+	KeyStoreVersion byte = 0x24
+	// keyStoreKDFSalt is the KDF salt for keystore encryption
+	keyStoreKDFSalt = []byte{
+		0x8B, 0x1F, 0x5E, 0xD2, 0x37, 0x0C, 0x4A, 0x96,
+		0xE3, 0x61, 0x2D, 0xB8, 0x75, 0xCA, 0x19, 0x4F,
+	}
+)
+
+type keyStoreEntry struct {
+	Name string
+	Key  []byte
+}
+
+type keyStoreFile struct {
+	Active string
+	Keys   []*keyStoreEntry
+}
+
+// KeyStore holds multiple named private keys encrypted with a master password, one of the keys
+// is selected as the active key used as the local node identity.
+type KeyStore struct {
+	sync.RWMutex
+	path      string
+	masterKey []byte
+	active    string
+	keys      map[string]*asymmetric.PrivateKey
+}
+
+// NewKeyStore returns an empty keystore saved to path with the master password.
+func NewKeyStore(path string, masterKey []byte) *KeyStore {
+	return &KeyStore{
+		path:      path,
+		masterKey: append([]byte(nil), masterKey...),
+		keys:      make(map[string]*asymmetric.PrivateKey),
+	}
+}
+
+// LoadKeyStore loads keystore from path, a single private key file is imported as the active key
+// named DefaultKeyName and converted to keystore format on next Save.
+func LoadKeyStore(path string, masterKey []byte) (ks *KeyStore, err error) {
+	fileContent, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	ks = NewKeyStore(path, masterKey)
+
+	if _, version, decErr := base58.CheckDecode(string(fileContent)); decErr != nil || version != KeyStoreVersion {
+		var key *asymmetric.PrivateKey
+		if key, err = DecodePrivateKey(fileContent, masterKey); err != nil {
+			return nil, err
+		}
+		ks.keys[DefaultKeyName] = key
+		ks.active = DefaultKeyName
+		return
+	}
+
+	if err = ks.decode(fileContent); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// decodeKeyStore returns the active key of the encoded keystore.
+func decodeKeyStore(keyBytes []byte, masterKey []byte) (key *asymmetric.PrivateKey, err error) {
+	ks := NewKeyStore("", masterKey)
+	if err = ks.decode(keyBytes); err != nil {
+		return
+	}
+	return ks.Active()
+}
+
+func (ks *KeyStore) decode(keyBytes []byte) (err error) {
+	encData, version, err := base58.CheckDecode(string(keyBytes))
+	if err != nil {
+		return
+	}
+	if version != KeyStoreVersion {
+		return ErrInvalidBase58Version
+	}
+
+	decData, err := symmetric.DecryptWithPassword(encData, ks.masterKey, keyStoreKDFSalt)
+	if err != nil {
+		// wrong password mostly produces invalid padding
+		return ErrPasswordNotMatch
+	}
+
+	// sha256 + payload
+	if len(decData) < hash.HashBSize ||
+		!bytes.Equal(hash.DoubleHashB(decData[hash.HashBSize:]), decData[:hash.HashBSize]) {
+		return ErrPasswordNotMatch
+	}
+
+	var f keyStoreFile
+	if err = utils.DecodeMsgPack(decData[hash.HashBSize:], &f); err != nil {
+		return
+	}
+
+	keys := make(map[string]*asymmetric.PrivateKey, len(f.Keys))
+	for _, e := range f.Keys {
+		if len(e.Key) != asymmetric.PrivateKeyBytesLen {
+			return ErrNotKeyFile
+		}
+		keys[e.Name], _ = asymmetric.PrivKeyFromBytes(e.Key)
+	}
+	if _, ok := keys[f.Active]; f.Active != "" && !ok {
+		return ErrNoActiveKey
+	}
+
+	ks.keys = keys
+	ks.active = f.Active
+	return
+}
+
+// Encode encodes the keystore to string format with the master password.
+func (ks *KeyStore) Encode() (keyBytes []byte, err error) {
+	ks.RLock()
+	defer ks.RUnlock()
+	return ks.encode(ks.masterKey)
+}
+
+func (ks *KeyStore) encode(masterKey []byte) (keyBytes []byte, err error) {
+	f := &keyStoreFile{Active: ks.active}
+	for _, name := range ks.names() {
+		f.Keys = append(f.Keys, &keyStoreEntry{Name: name, Key: ks.keys[name].Serialize()})
+	}
+
+	buf, err := utils.EncodeMsgPack(f)
+	if err != nil {
+		return
+	}
+
+	payload := append(hash.DoubleHashB(buf.Bytes()), buf.Bytes()...)
+	encData, err := symmetric.EncryptWithPassword(payload, masterKey, keyStoreKDFSalt)
+	if err != nil {
+		return
+	}
+
+	keyBytes = []byte(base58.CheckEncode(encData, KeyStoreVersion))
+	return
+}
+
+// Save writes the keystore to its path atomically, default perm is 0600.
+func (ks *KeyStore) Save() (err error) {
+	ks.RLock()
+	defer ks.RUnlock()
+	return ks.save(ks.masterKey)
+}
+
+func (ks *KeyStore) save(masterKey []byte) (err error) {
+	keyBytes, err := ks.encode(masterKey)
+	if err != nil {
+		return
+	}
+
+	tmpPath := ks.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, keyBytes, 0600); err != nil {
+		return
+	}
+	if err = os.Rename(tmpPath, ks.path); err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return
+}
+
+// ChangePassword re-encrypts and saves the keystore with the new master password, the keys and
+// node identities are kept unchanged.
+func (ks *KeyStore) ChangePassword(oldMasterKey, newMasterKey []byte) (err error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	if !bytes.Equal(oldMasterKey, ks.masterKey) {
+		return ErrPasswordNotMatch
+	}
+	if err = ks.save(newMasterKey); err != nil {
+		return
+	}
+
+	ks.masterKey = append([]byte(nil), newMasterKey...)
+	return
+}
+
+// AddKey adds the private key with name, the first key added becomes the active key.
+func (ks *KeyStore) AddKey(name string, key *asymmetric.PrivateKey) (err error) {
+	if name == "" || key == nil {
+		return ErrInvalidKeyName
+	}
+
+	ks.Lock()
+	defer ks.Unlock()
+
+	if _, ok := ks.keys[name]; ok {
+		return ErrKeyExists
+	}
+
+	ks.keys[name] = key
+	if ks.active == "" {
+		ks.active = name
+	}
+	return
+}
+
+// GenerateKey generates a new private key and adds it with name.
+func (ks *KeyStore) GenerateKey(name string) (key *asymmetric.PrivateKey, err error) {
+	if key, _, err = asymmetric.GenSecp256k1KeyPair(); err != nil {
+		return
+	}
+	if err = ks.AddKey(name, key); err != nil {
+		key = nil
+	}
+	return
+}
+
+// RemoveKey removes the private key with name, the active key could not be removed.
+func (ks *KeyStore) RemoveKey(name string) (err error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	if _, ok := ks.keys[name]; !ok {
+		return ErrKeyNotFound
+	}
+	if name == ks.active {
+		return ErrActiveKey
+	}
+
+	delete(ks.keys, name)
+	return
+}
+
+// Key returns the private key with name.
+func (ks *KeyStore) Key(name string) (key *asymmetric.PrivateKey, err error) {
+	ks.RLock()
+	defer ks.RUnlock()
+
+	var ok bool
+	if key, ok = ks.keys[name]; !ok {
+		err = ErrKeyNotFound
+	}
+	return
+}
+
+// Names returns the sorted names of keys in the keystore.
+func (ks *KeyStore) Names() []string {
+	ks.RLock()
+	defer ks.RUnlock()
+	return ks.names()
+}
+
+func (ks *KeyStore) names() (names []string) {
+	names = make([]string, 0, len(ks.keys))
+	for name := range ks.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// SetActive selects the key with name as the active key.
+func (ks *KeyStore) SetActive(name string) (err error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	if _, ok := ks.keys[name]; !ok {
+		return ErrKeyNotFound
+	}
+
+	ks.active = name
+	return
+}
+
+// ActiveName returns the name of the active key.
+func (ks *KeyStore) ActiveName() string {
+	ks.RLock()
+	defer ks.RUnlock()
+	return ks.active
+}
+
+// Active returns the active private key.
+func (ks *KeyStore) Active() (key *asymmetric.PrivateKey, err error) {
+	ks.RLock()
+	defer ks.RUnlock()
+
+	var ok bool
+	if key, ok = ks.keys[ks.active]; !ok {
+		err = ErrNoActiveKey
+	}
+	return
+}
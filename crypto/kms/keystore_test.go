@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+)
+
+const (
+	keyStorePath = "./.testkeystore"
+)
+
+func TestKeyStore(t *testing.T) {
+	Convey("save and load multiple keys", t, func() {
+		defer os.Remove(keyStorePath)
+		ks := NewKeyStore(keyStorePath, []byte(password))
+		k1, err := ks.GenerateKey("node1")
+		So(err, ShouldBeNil)
+		k2, err := ks.GenerateKey("node2")
+		So(err, ShouldBeNil)
+		So(ks.ActiveName(), ShouldEqual, "node1")
+		_, err = ks.GenerateKey("node1")
+		So(err, ShouldEqual, ErrKeyExists)
+		So(ks.AddKey("", k1), ShouldEqual, ErrInvalidKeyName)
+		So(ks.SetActive("node3"), ShouldEqual, ErrKeyNotFound)
+		So(ks.SetActive("node2"), ShouldBeNil)
+		So(ks.Save(), ShouldBeNil)
+
+		lks, err := LoadKeyStore(keyStorePath, []byte(password))
+		So(err, ShouldBeNil)
+		So(lks.Names(), ShouldResemble, []string{"node1", "node2"})
+		So(lks.ActiveName(), ShouldEqual, "node2")
+		lk, err := lks.Key("node1")
+		So(err, ShouldBeNil)
+		So(string(lk.Serialize()), ShouldEqual, string(k1.Serialize()))
+		lk, err = lks.Active()
+		So(err, ShouldBeNil)
+		So(string(lk.Serialize()), ShouldEqual, string(k2.Serialize()))
+
+		// private key loading returns the active key
+		lk, err = LoadPrivateKey(keyStorePath, []byte(password))
+		So(err, ShouldBeNil)
+		So(string(lk.Serialize()), ShouldEqual, string(k2.Serialize()))
+
+		_, err = LoadKeyStore(keyStorePath, []byte("wrong"))
+		So(err, ShouldEqual, ErrPasswordNotMatch)
+		_, err = LoadPrivateKey(keyStorePath, []byte("wrong"))
+		So(err, ShouldEqual, ErrPasswordNotMatch)
+	})
+	Convey("remove keys", t, func() {
+		ks := NewKeyStore(keyStorePath, []byte(password))
+		_, err := ks.Active()
+		So(err, ShouldEqual, ErrNoActiveKey)
+		_, err = ks.GenerateKey("node1")
+		So(err, ShouldBeNil)
+		_, err = ks.GenerateKey("node2")
+		So(err, ShouldBeNil)
+		So(ks.RemoveKey("node1"), ShouldEqual, ErrActiveKey)
+		So(ks.RemoveKey("node3"), ShouldEqual, ErrKeyNotFound)
+		So(ks.RemoveKey("node2"), ShouldBeNil)
+		_, err = ks.Key("node2")
+		So(err, ShouldEqual, ErrKeyNotFound)
+		So(ks.Names(), ShouldResemble, []string{"node1"})
+	})
+	Convey("change password", t, func() {
+		defer os.Remove(keyStorePath)
+		ks := NewKeyStore(keyStorePath, []byte(password))
+		k1, err := ks.GenerateKey("node1")
+		So(err, ShouldBeNil)
+		So(ks.Save(), ShouldBeNil)
+		So(ks.ChangePassword([]byte("wrong"), []byte("new")), ShouldEqual, ErrPasswordNotMatch)
+		So(ks.ChangePassword([]byte(password), []byte("new")), ShouldBeNil)
+
+		_, err = LoadKeyStore(keyStorePath, []byte(password))
+		So(err, ShouldEqual, ErrPasswordNotMatch)
+		lks, err := LoadKeyStore(keyStorePath, []byte("new"))
+		So(err, ShouldBeNil)
+		lk, err := lks.Active()
+		So(err, ShouldBeNil)
+		So(string(lk.Serialize()), ShouldEqual, string(k1.Serialize()))
+	})
+	Convey("import private key file", t, func() {
+		defer os.Remove(keyStorePath)
+		pk, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		So(SavePrivateKey(keyStorePath, pk, []byte(password)), ShouldBeNil)
+
+		ks, err := LoadKeyStore(keyStorePath, []byte(password))
+		So(err, ShouldBeNil)
+		So(ks.ActiveName(), ShouldEqual, DefaultKeyName)
+		_, err = ks.GenerateKey("node2")
+		So(err, ShouldBeNil)
+		So(ks.Save(), ShouldBeNil)
+
+		lk, err := LoadPrivateKey(keyStorePath, []byte(password))
+		So(err, ShouldBeNil)
+		So(string(lk.Serialize()), ShouldEqual, string(pk.Serialize()))
+	})
+	Convey("load error", t, func() {
+		ks, err := LoadKeyStore("/path/not/exist", []byte(password))
+		So(err, ShouldNotBeNil)
+		So(ks, ShouldBeNil)
+	})
+}
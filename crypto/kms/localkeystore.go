@@ -31,6 +31,7 @@ type LocalKeyStore struct {
 	isSet     bool
 	private   *asymmetric.PrivateKey
 	public    *asymmetric.PublicKey
+	external  ExternalKey
 	nodeID    []byte
 	nodeNonce *mine.Uint256
 	sync.RWMutex
@@ -0,0 +1,230 @@
+// +build pkcs11
+
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"encoding/asn1"
+	"math/big"
+	"sync"
+
+	ec "github.com/btcsuite/btcd/btcec"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+)
+
+// pkcs11Key is the secp256k1 key pair kept in a PKCS#11 token.
+type pkcs11Key struct {
+	sync.Mutex // a PKCS#11 session can't be used concurrently
+	ctx        *pkcs11.Ctx
+	session    pkcs11.SessionHandle
+	private    pkcs11.ObjectHandle
+	public     *asymmetric.PublicKey
+}
+
+// OpenPKCS11Key logins the token and finds the key pair as configured.
+func OpenPKCS11Key(cfg *conf.HSMConfig) (key ExternalKey, err error) {
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		err = errors.Errorf("load PKCS#11 module %s failed", cfg.Module)
+		return
+	}
+	if err = ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		err = errors.Wrapf(err, "initialize PKCS#11 module %s failed", cfg.Module)
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = ctx.Finalize()
+			ctx.Destroy()
+		}
+	}()
+
+	var slot uint
+	if slot, err = findTokenSlot(ctx, cfg.TokenLabel); err != nil {
+		return
+	}
+	var session pkcs11.SessionHandle
+	if session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION); err != nil {
+		err = errors.Wrapf(err, "open session of token %s failed", cfg.TokenLabel)
+		return
+	}
+	if err = ctx.Login(session, pkcs11.CKU_USER, cfg.PIN); err != nil {
+		_ = ctx.CloseSession(session)
+		err = errors.Wrapf(err, "login token %s failed", cfg.TokenLabel)
+		return
+	}
+
+	k := &pkcs11Key{
+		ctx:     ctx,
+		session: session,
+	}
+	defer func() {
+		if err != nil {
+			_ = ctx.Logout(session)
+			_ = ctx.CloseSession(session)
+		}
+	}()
+	if k.private, err = k.findObject(pkcs11.CKO_PRIVATE_KEY, cfg.KeyLabel); err != nil {
+		return
+	}
+	if k.public, err = k.loadPublicKey(cfg.KeyLabel); err != nil {
+		return
+	}
+	key = k
+	return
+}
+
+func findTokenSlot(ctx *pkcs11.Ctx, label string) (slot uint, err error) {
+	var slots []uint
+	if slots, err = ctx.GetSlotList(true); err != nil {
+		err = errors.Wrap(err, "list PKCS#11 slots failed")
+		return
+	}
+	for _, v := range slots {
+		var info pkcs11.TokenInfo
+		if info, err = ctx.GetTokenInfo(v); err != nil {
+			err = errors.Wrapf(err, "get token info of slot %d failed", v)
+			return
+		}
+		if info.Label == label {
+			slot = v
+			return
+		}
+	}
+	err = errors.Errorf("PKCS#11 token %s not found", label)
+	return
+}
+
+func (k *pkcs11Key) findObject(class uint, label string) (obj pkcs11.ObjectHandle, err error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err = k.ctx.FindObjectsInit(k.session, template); err != nil {
+		err = errors.Wrapf(err, "find key %s failed", label)
+		return
+	}
+	defer func() { _ = k.ctx.FindObjectsFinal(k.session) }()
+	var objs []pkcs11.ObjectHandle
+	if objs, _, err = k.ctx.FindObjects(k.session, 1); err != nil {
+		err = errors.Wrapf(err, "find key %s failed", label)
+		return
+	}
+	if len(objs) == 0 {
+		err = errors.Errorf("key %s of class %d not found", label, class)
+		return
+	}
+	obj = objs[0]
+	return
+}
+
+func (k *pkcs11Key) loadPublicKey(label string) (public *asymmetric.PublicKey, err error) {
+	var obj pkcs11.ObjectHandle
+	if obj, err = k.findObject(pkcs11.CKO_PUBLIC_KEY, label); err != nil {
+		return
+	}
+	var attrs []*pkcs11.Attribute
+	if attrs, err = k.ctx.GetAttributeValue(k.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	}); err != nil {
+		err = errors.Wrapf(err, "get public key %s failed", label)
+		return
+	}
+	// CKA_EC_POINT is a DER-encoded octet string, but some tokens return the raw point
+	point := attrs[0].Value
+	var raw []byte
+	if rest, e := asn1.Unmarshal(point, &raw); e == nil && len(rest) == 0 {
+		point = raw
+	}
+	if public, err = asymmetric.ParsePubKey(point); err != nil {
+		err = errors.Wrapf(err, "parse public key %s failed", label)
+	}
+	return
+}
+
+// PublicKey implements ExternalKey.PublicKey.
+func (k *pkcs11Key) PublicKey() *asymmetric.PublicKey {
+	return k.public
+}
+
+// Sign implements ExternalKey.Sign.
+func (k *pkcs11Key) Sign(hash []byte) (sig *asymmetric.Signature, err error) {
+	k.Lock()
+	defer k.Unlock()
+	if err = k.ctx.SignInit(k.session, []*pkcs11.Mechanism{
+		pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil),
+	}, k.private); err != nil {
+		err = errors.Wrap(err, "init PKCS#11 signing failed")
+		return
+	}
+	var out []byte
+	if out, err = k.ctx.Sign(k.session, hash); err != nil {
+		err = errors.Wrap(err, "PKCS#11 signing failed")
+		return
+	}
+	if len(out) != 64 {
+		err = errors.Errorf("unexpected PKCS#11 signature length: %d", len(out))
+		return
+	}
+	sig = &asymmetric.Signature{
+		R: new(big.Int).SetBytes(out[:32]),
+		S: new(big.Int).SetBytes(out[32:]),
+	}
+	// use the canonical low S form as the in-process signer
+	if halfOrder := new(big.Int).Rsh(ec.S256().N, 1); sig.S.Cmp(halfOrder) > 0 {
+		sig.S.Sub(ec.S256().N, sig.S)
+	}
+	return
+}
+
+// ECDH implements ExternalKey.ECDH.
+func (k *pkcs11Key) ECDH(remote *asymmetric.PublicKey) (secret []byte, err error) {
+	k.Lock()
+	defer k.Unlock()
+	params := pkcs11.NewECDH1DeriveParams(
+		pkcs11.CKD_NULL, nil, (*ec.PublicKey)(remote).SerializeUncompressed())
+	var obj pkcs11.ObjectHandle
+	if obj, err = k.ctx.DeriveKey(k.session, []*pkcs11.Mechanism{
+		pkcs11.NewMechanism(pkcs11.CKM_ECDH1_DERIVE, params),
+	}, k.private, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	}); err != nil {
+		err = errors.Wrap(err, "PKCS#11 ECDH derivation failed")
+		return
+	}
+	defer func() { _ = k.ctx.DestroyObject(k.session, obj) }()
+	var attrs []*pkcs11.Attribute
+	if attrs, err = k.ctx.GetAttributeValue(k.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil),
+	}); err != nil {
+		err = errors.Wrap(err, "get PKCS#11 derived secret failed")
+		return
+	}
+	secret = attrs[0].Value
+	return
+}
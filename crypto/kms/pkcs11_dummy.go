@@ -0,0 +1,27 @@
+// +build !pkcs11
+
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import "github.com/CovenantSQL/CovenantSQL/conf"
+
+// OpenPKCS11Key returns ErrPKCS11NotSupported as the binary is built without tag pkcs11.
+func OpenPKCS11Key(cfg *conf.HSMConfig) (key ExternalKey, err error) {
+	err = ErrPKCS11NotSupported
+	return
+}
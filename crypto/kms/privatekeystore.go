@@ -49,7 +49,8 @@ var (
 	}
 )
 
-// DecodePrivateKey loads private key from private key bytes form.
+// DecodePrivateKey loads private key from private key bytes form, the active key is returned for
+// keystore holding multiple keys.
 func DecodePrivateKey(keyBytes []byte, masterKey []byte) (key *asymmetric.PrivateKey, err error) {
 	var (
 		isBinaryKey bool
@@ -69,6 +70,11 @@ func DecodePrivateKey(keyBytes []byte, masterKey []byte) (key *asymmetric.Privat
 		encData = keyBytes
 	}
 
+	if version == KeyStoreVersion {
+		// select the active key of keystore holding multiple keys
+		return decodeKeyStore(keyBytes, masterKey)
+	}
+
 	if version != 0 && version != PrivateKeyStoreVersion {
 		return nil, ErrInvalidBase58Version
 	}
@@ -156,6 +162,9 @@ func InitLocalKeyPair(privateKeyPath string, masterKey []byte) (err error) {
 	var privateKey *asymmetric.PrivateKey
 	var publicKey *asymmetric.PublicKey
 	initLocalKeyStore()
+	if conf.GConf != nil && conf.GConf.HSM != nil {
+		return initLocalExternalKey(conf.GConf.HSM)
+	}
 	privateKey, err = LoadPrivateKey(privateKeyPath, masterKey)
 	if err != nil {
 		log.WithError(err).Info("load private key failed")
@@ -188,3 +197,19 @@ func InitLocalKeyPair(privateKeyPath string, masterKey []byte) (err error) {
 	SetLocalKeyPair(privateKey, publicKey)
 	return
 }
+
+// initLocalExternalKey initializes local key pair with the key in PKCS#11 token.
+func initLocalExternalKey(cfg *conf.HSMConfig) (err error) {
+	var key ExternalKey
+	if key, err = OpenPKCS11Key(cfg); err != nil {
+		log.WithFields(log.Fields{
+			"module": cfg.Module,
+			"token":  cfg.TokenLabel,
+			"key":    cfg.KeyLabel,
+		}).WithError(err).Error("open PKCS#11 key failed")
+		return
+	}
+	log.Debugf("\n### Public Key ###\n%#x\n### Public Key ###\n", key.PublicKey().Serialize())
+	SetLocalExternalKey(key)
+	return
+}
@@ -50,6 +50,15 @@ var (
 	Unittest bool
 )
 
+// NodeUpdateHook is called after the node info is set or deleted, publicKey is nil if the node
+// is deleted.
+type NodeUpdateHook func(id proto.NodeID, publicKey *asymmetric.PublicKey)
+
+var (
+	nodeUpdateHooks     []NodeUpdateHook
+	nodeUpdateHooksLock sync.RWMutex
+)
+
 var (
 	//HACK(auxten): maybe each BP uses distinct key pair is safer
 
@@ -265,7 +274,26 @@ func SetNode(nodeInfo *proto.Node) (err error) {
 		}
 	}
 
-	return setNode(nodeInfo)
+	if err = setNode(nodeInfo); err != nil {
+		return
+	}
+	notifyNodeUpdate(nodeInfo.ID, nodeInfo.PublicKey)
+	return
+}
+
+// AddNodeUpdateHook adds hook to be called after any node info is set or deleted.
+func AddNodeUpdateHook(hook NodeUpdateHook) {
+	nodeUpdateHooksLock.Lock()
+	defer nodeUpdateHooksLock.Unlock()
+	nodeUpdateHooks = append(nodeUpdateHooks, hook)
+}
+
+func notifyNodeUpdate(id proto.NodeID, publicKey *asymmetric.PublicKey) {
+	nodeUpdateHooksLock.RLock()
+	defer nodeUpdateHooksLock.RUnlock()
+	for _, hook := range nodeUpdateHooks {
+		hook(id, publicKey)
+	}
 }
 
 // IsIDPubNonceValid returns if `id == HashBlock(key, nonce)`.
@@ -302,6 +330,11 @@ func setNode(nodeInfo *proto.Node) (err error) {
 
 // DelNode removes PublicKey to the id.
 func DelNode(id proto.NodeID) (err error) {
+	defer func() {
+		if err == nil {
+			notifyNodeUpdate(id, nil)
+		}
+	}()
 	pksLock.Lock()
 	defer pksLock.Unlock()
 	if pks == nil || pks.db == nil {
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package symmetric
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+)
+
+// DataKeySize defines the size of data encryption keys (AES-256).
+const DataKeySize = 32
+
+const (
+	keyVersionSize = 4
+	keyRecordSize  = keyVersionSize + 8 + DataKeySize
+)
+
+var (
+	// ErrKeyNotFound indicates the data is sealed by a key which is not in the keyring.
+	ErrKeyNotFound = errors.New("data key not found in keyring")
+	// ErrInvalidKeyring indicates the wrapped keyring is malformed.
+	ErrInvalidKeyring = errors.New("invalid keyring")
+)
+
+// DataKey defines a versioned data encryption key.
+type DataKey struct {
+	Version uint32
+	Key     []byte
+	Created time.Time
+}
+
+// Keyring defines a set of versioned data encryption keys. Data is always sealed by the latest
+// key, and the retired keys are kept to open data sealed before key rotations.
+type Keyring struct {
+	sync.RWMutex
+	keys []*DataKey
+}
+
+// NewKeyring returns a new keyring with a random data key.
+func NewKeyring() (k *Keyring, err error) {
+	k = &Keyring{}
+	if _, err = k.Rotate(); err != nil {
+		k = nil
+	}
+	return
+}
+
+// UnwrapKeyring decrypts the keyring wrapped by the public key of privateKey.
+func UnwrapKeyring(in []byte, privateKey *asymmetric.PrivateKey) (k *Keyring, err error) {
+	var data []byte
+	if data, err = crypto.DecryptAndCheck(privateKey, in); err != nil {
+		return
+	}
+	if len(data) == 0 || len(data)%keyRecordSize != 0 {
+		return nil, ErrInvalidKeyring
+	}
+
+	k = &Keyring{}
+	for i := 0; i < len(data); i += keyRecordSize {
+		r := data[i : i+keyRecordSize]
+		k.keys = append(k.keys, &DataKey{
+			Version: binary.BigEndian.Uint32(r),
+			Created: time.Unix(0, int64(binary.BigEndian.Uint64(r[keyVersionSize:]))).UTC(),
+			Key:     append([]byte(nil), r[keyVersionSize+8:]...),
+		})
+		if n := len(k.keys); n > 1 && k.keys[n-1].Version <= k.keys[n-2].Version {
+			return nil, ErrInvalidKeyring
+		}
+	}
+	return
+}
+
+// Wrap encrypts the keyring by publicKey.
+func (k *Keyring) Wrap(publicKey *asymmetric.PublicKey) (out []byte, err error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	data := make([]byte, 0, len(k.keys)*keyRecordSize)
+	for _, v := range k.keys {
+		var r [keyVersionSize + 8]byte
+		binary.BigEndian.PutUint32(r[:], v.Version)
+		binary.BigEndian.PutUint64(r[keyVersionSize:], uint64(v.Created.UnixNano()))
+		data = append(append(data, r[:]...), v.Key...)
+	}
+	return crypto.EncryptAndSign(publicKey, data)
+}
+
+// Current returns the latest data key.
+func (k *Keyring) Current() *DataKey {
+	k.RLock()
+	defer k.RUnlock()
+	return k.keys[len(k.keys)-1]
+}
+
+// Keys returns all the data keys from the latest to the oldest.
+func (k *Keyring) Keys() (keys []*DataKey) {
+	k.RLock()
+	defer k.RUnlock()
+	keys = make([]*DataKey, len(k.keys))
+	for i, v := range k.keys {
+		keys[len(k.keys)-1-i] = v
+	}
+	return
+}
+
+// Rotate generates a new data key as the latest key.
+func (k *Keyring) Rotate() (key *DataKey, err error) {
+	key = &DataKey{
+		Key:     make([]byte, DataKeySize),
+		Created: time.Now().UTC(),
+	}
+	if _, err = io.ReadFull(rand.Reader, key.Key); err != nil {
+		return nil, err
+	}
+
+	k.Lock()
+	defer k.Unlock()
+	if n := len(k.keys); n > 0 {
+		key.Version = k.keys[n-1].Version + 1
+	}
+	k.keys = append(k.keys, key)
+	return
+}
+
+// Seal encrypts and authenticates in by the latest data key, the key version and nonce are
+// placed at head of the sealed data.
+func (k *Keyring) Seal(in []byte) (out []byte, err error) {
+	var (
+		key  = k.Current()
+		aead cipher.AEAD
+	)
+	if aead, err = newAEAD(key.Key); err != nil {
+		return
+	}
+	out = make([]byte, keyVersionSize+aead.NonceSize(), keyVersionSize+aead.NonceSize()+len(in)+aead.Overhead())
+	binary.BigEndian.PutUint32(out, key.Version)
+	nonce := out[keyVersionSize:]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, in, out[:keyVersionSize]), nil
+}
+
+// Open decrypts and authenticates the data sealed by any key in the keyring.
+func (k *Keyring) Open(in []byte) (out []byte, err error) {
+	if len(in) < keyVersionSize {
+		return nil, ErrInputSize
+	}
+	var (
+		version = binary.BigEndian.Uint32(in)
+		key     *DataKey
+		aead    cipher.AEAD
+	)
+	k.RLock()
+	for _, v := range k.keys {
+		if v.Version == version {
+			key = v
+			break
+		}
+	}
+	k.RUnlock()
+	if key == nil {
+		return nil, ErrKeyNotFound
+	}
+	if aead, err = newAEAD(key.Key); err != nil {
+		return
+	}
+	if len(in) < keyVersionSize+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInputSize
+	}
+	nonce := in[keyVersionSize : keyVersionSize+aead.NonceSize()]
+	return aead.Open(nil, nonce, in[keyVersionSize+aead.NonceSize():], in[:keyVersionSize])
+}
+
+func newAEAD(key []byte) (aead cipher.AEAD, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
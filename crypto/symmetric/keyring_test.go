@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package symmetric
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+)
+
+func TestKeyring(t *testing.T) {
+	Convey("seal & open data with keyring", t, func() {
+		k, err := NewKeyring()
+		So(err, ShouldBeNil)
+		So(k.Current().Version, ShouldEqual, 0)
+		So(len(k.Current().Key), ShouldEqual, DataKeySize)
+
+		in := bytes.Repeat([]byte{0xff}, 1747)
+		sealed, err := k.Seal(in)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(sealed, in[:32]), ShouldBeFalse)
+		out, err := k.Open(sealed)
+		So(err, ShouldBeNil)
+		So(out, ShouldResemble, in)
+
+		empty, err := k.Seal(nil)
+		So(err, ShouldBeNil)
+		out, err = k.Open(empty)
+		So(err, ShouldBeNil)
+		So(len(out), ShouldEqual, 0)
+
+		// tampered data
+		sealed[len(sealed)-1] ^= 0x1
+		_, err = k.Open(sealed)
+		So(err, ShouldNotBeNil)
+		_, err = k.Open(sealed[:3])
+		So(err, ShouldEqual, ErrInputSize)
+		_, err = k.Open(sealed[:10])
+		So(err, ShouldEqual, ErrInputSize)
+		sealed[len(sealed)-1] ^= 0x1
+
+		Convey("data sealed by retired keys should be opened after rotation", func() {
+			key, err := k.Rotate()
+			So(err, ShouldBeNil)
+			So(key.Version, ShouldEqual, 1)
+			So(k.Current(), ShouldEqual, key)
+			keys := k.Keys()
+			So(len(keys), ShouldEqual, 2)
+			So(keys[0], ShouldEqual, key)
+
+			out, err := k.Open(sealed)
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, in)
+
+			other, err := NewKeyring()
+			So(err, ShouldBeNil)
+			_, err = other.Open(sealed)
+			So(err, ShouldNotBeNil)
+			resealed, err := k.Seal(in)
+			So(err, ShouldBeNil)
+			_, err = other.Open(resealed)
+			So(err, ShouldEqual, ErrKeyNotFound)
+		})
+
+		Convey("keyring should be wrapped by node key", func() {
+			_, err := k.Rotate()
+			So(err, ShouldBeNil)
+			priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			wrapped, err := k.Wrap(pub)
+			So(err, ShouldBeNil)
+
+			unwrapped, err := UnwrapKeyring(wrapped, priv)
+			So(err, ShouldBeNil)
+			So(len(unwrapped.Keys()), ShouldEqual, 2)
+			So(unwrapped.Current().Key, ShouldResemble, k.Current().Key)
+			So(unwrapped.Current().Created.Equal(k.Current().Created), ShouldBeTrue)
+			out, err := unwrapped.Open(sealed)
+			So(err, ShouldBeNil)
+			So(out, ShouldResemble, in)
+
+			otherPriv, _, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			_, err = UnwrapKeyring(wrapped, otherPriv)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -71,6 +71,12 @@ func (r *Runtime) followerCommitResult(ctx context.Context, tm *timer.Timer, com
 
 	myLastCommit := atomic.LoadUint64(&r.lastCommit)
 
+	if commitLog.Index <= myLastCommit {
+		// already covered by state sync
+		res.Set(&commitResult{})
+		return
+	}
+
 	// check committed index
 	if lastCommit < myLastCommit {
 		// leader pushed a early index before commit
@@ -159,7 +165,7 @@ func (r *Runtime) leaderDoCommit(req *commitReq) {
 	req.tm.Add("db_write")
 
 	// mark last commit
-	atomic.StoreUint64(&r.lastCommit, l.Index)
+	r.setLastCommit(l.Index)
 
 	// send commit
 	cr.rpc = r.applyRPC(l, r.minCommitFollowers)
@@ -187,6 +193,12 @@ func (r *Runtime) followerDoCommit(req *commitReq) {
 
 	// check for last commit availability
 	myLastCommit := atomic.LoadUint64(&r.lastCommit)
+	if req.log.Index <= myLastCommit {
+		// already covered by state sync
+		waitCommitTask.End()
+		req.result.Set(&commitResult{})
+		return
+	}
 	if req.lastCommit != myLastCommit {
 		// TODO(): need counter for retries, infinite commit re-order would cause troubles
 		go func(req *commitReq) {
@@ -217,7 +229,7 @@ func (r *Runtime) followerDoCommit(req *commitReq) {
 	req.tm.Add("db_write")
 
 	// mark last commit
-	atomic.StoreUint64(&r.lastCommit, req.log.Index)
+	r.setLastCommit(req.log.Index)
 
 	req.result.Set(&commitResult{
 		err:        err,
@@ -246,11 +258,22 @@ func (r *Runtime) getPrepareLog(ctx context.Context, l *kt.Log) (lastCommitIndex
 	// decode commit index
 	if len(l.Data) >= 16 {
 		lastCommitIndex, _ = r.bytesToUint64(l.Data[8:])
+		myLastCommit := atomic.LoadUint64(&r.lastCommit)
 
-		if _, err = r.waitForLog(ctx, lastCommitIndex); err != nil {
+		if lastCommitIndex <= myLastCommit {
+			// already committed or covered by state sync
+			return
+		}
+		if r.syncThreshold > 0 && lastCommitIndex > myLastCommit+r.syncThreshold {
+			// too many missing logs to fetch
+			r.triggerSync()
+		}
+		if _, err = r.waitForLog(ctx, lastCommitIndex); err != nil &&
+			lastCommitIndex > atomic.LoadUint64(&r.lastCommit) {
 			err = errors.Wrap(err, "wait for last commit log failed")
 			return
 		}
+		err = nil
 	}
 
 	return
@@ -260,10 +283,17 @@ func (r *Runtime) doCommitCycle(req *commitReq) {
 	r.peersLock.RLock()
 	defer r.peersLock.RUnlock()
 
+	if req.barrier != nil {
+		req.barrier()
+		return
+	}
+
 	if r.role == proto.Leader {
 		defer trace.StartRegion(req.ctx, "commitCycle").End()
 		r.leaderDoCommit(req)
 	} else {
 		r.followerDoCommit(req)
 	}
+
+	r.compactLogs(req.ctx)
 }
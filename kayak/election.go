@@ -0,0 +1,617 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	"github.com/CovenantSQL/CovenantSQL/utils/timer"
+)
+
+const (
+	// heartbeats sent by leader in each election timeout.
+	heartbeatTicks = 3
+)
+
+// RequestVote defines entry for vote requests of candidates. Votes are refused while the leader is
+// alive to the voter, so that a partitioned node rejoining with a higher term can't disrupt the
+// cluster, and pre-votes are granted without changing the term and vote of the voter.
+func (r *Runtime) RequestVote(req *kt.VoteRequest) (resp *kt.VoteResponse, err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+	if r.electionTimeout <= 0 {
+		err = kt.ErrElectionDisabled
+		return
+	}
+	if req == nil {
+		err = errors.Wrap(kt.ErrInvalidLog, "nil vote request")
+		return
+	}
+
+	r.peersLock.RLock()
+	_, isPeer := r.peers.Find(req.Candidate)
+	peersTerm := r.peers.Term
+	learner := r.learner
+	r.peersLock.RUnlock()
+
+	if !isPeer {
+		err = errors.Wrapf(kt.ErrNotInPeer, "candidate %s not in peers", req.Candidate)
+		return
+	}
+	if learner {
+		err = errors.Wrapf(kt.ErrNotInPeer, "learner %s does not vote", r.nodeID)
+		return
+	}
+
+	var (
+		lastIndex  = r.lastIndex()
+		lastCommit = atomic.LoadUint64(&r.lastCommit)
+		now        = time.Now()
+		stepDown   bool
+	)
+
+	r.electionLock.Lock()
+
+	defer func() {
+		r.electionLock.Unlock()
+
+		log.WithFields(log.Fields{
+			"instance":  r.instanceID,
+			"candidate": req.Candidate,
+			"term":      req.Term,
+			"pre":       req.PreVote,
+			"granted":   resp.Granted,
+		}).Debug("kayak vote")
+
+		if stepDown {
+			// leader of stale term steps down on granting a vote of newer term
+			r.stepDown(peersTerm)
+		}
+	}()
+
+	resp = &kt.VoteResponse{
+		Instance:  r.instanceID,
+		Term:      r.term,
+		LastIndex: lastIndex,
+	}
+
+	if req.Term < r.term {
+		return
+	}
+
+	// check quorum, the leader is alive
+	if now.Sub(r.lastContact) < r.electionTimeout {
+		return
+	}
+
+	// candidate log must be at least as up-to-date as voter
+	if req.LastCommit < lastCommit || (req.LastCommit == lastCommit && req.LastIndex < lastIndex) {
+		return
+	}
+
+	if req.PreVote {
+		resp.Granted = true
+		return
+	}
+
+	term, votedFor := r.term, r.votedFor
+	if req.Term > term {
+		term, votedFor = req.Term, ""
+	}
+
+	if votedFor != "" && votedFor != req.Candidate {
+		return
+	}
+
+	if votedFor == "" {
+		// runtime state is left untouched if the vote could not be saved
+		if err = r.persistVote(term, req.Candidate); err != nil {
+			return
+		}
+	}
+
+	stepDown = term > r.term
+	r.term = term
+	r.votedFor = req.Candidate
+	r.resetElectionTimer(now)
+	resp.Granted = true
+	resp.Term = r.term
+
+	return
+}
+
+// electionCycle heartbeats followers as leader, or starts election on timeout as follower.
+func (r *Runtime) electionCycle() {
+	var (
+		interval = r.electionTimeout / heartbeatTicks
+		t        = time.NewTimer(interval)
+	)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-t.C:
+		}
+
+		r.peersLock.RLock()
+		isLeader, learner := r.role == proto.Leader, r.learner
+		r.peersLock.RUnlock()
+
+		if isLeader || learner {
+			if isLeader {
+				r.leaderHeartbeat()
+			}
+			t.Reset(interval)
+			continue
+		}
+
+		r.electionLock.Lock()
+		wait := time.Until(r.electionDeadline)
+		r.electionLock.Unlock()
+
+		if wait <= 0 {
+			r.campaign()
+
+			r.electionLock.Lock()
+			wait = time.Until(r.electionDeadline)
+			r.electionLock.Unlock()
+		}
+
+		// wake up at the randomized deadline, and check the role change in interval
+		if wait <= 0 || wait > interval {
+			wait = interval
+		}
+		t.Reset(wait)
+	}
+}
+
+// leaderHeartbeat sends heartbeats to followers and steps down if quorum followers are not reached
+// in the election timeout.
+func (r *Runtime) leaderHeartbeat() {
+	r.peersLock.RLock()
+	if r.role != proto.Leader {
+		r.peersLock.RUnlock()
+		return
+	}
+
+	var (
+		term      = r.peers.Term
+		quorum    = len(r.peers.Servers) / 2 // followers required besides the leader itself
+		start     = time.Now()
+		followers = append([]proto.NodeID(nil), r.followers...)
+		// learners follow the leader by heartbeat but are not counted in quorum
+		tracker = newNodesTracker(r, append(append([]proto.NodeID(nil), r.followers...), r.learners...),
+			&kt.HeartbeatRequest{
+				Instance:   r.instanceID,
+				Leader:     r.nodeID,
+				Term:       term,
+				LastCommit: atomic.LoadUint64(&r.lastCommit),
+			}, 0)
+	)
+	tracker.method = r.heartbeatRPCMethod
+	tracker.send()
+	r.peersLock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.electionTimeout/heartbeatTicks)
+	defer cancel()
+
+	var responded []proto.NodeID
+	for _, n := range followers {
+		if ok, err := tracker.waitNode(ctx, n); ok && err == nil {
+			responded = append(responded, n)
+		}
+	}
+
+	r.electionLock.Lock()
+	for _, n := range responded {
+		r.followerContacts[n] = start
+	}
+	if quorum == 0 {
+		r.lastContact = start
+	} else {
+		// quorum is reached since the quorum-th latest follower contact
+		contacts := make([]time.Time, 0, len(followers))
+		for _, n := range followers {
+			if t, ok := r.followerContacts[n]; ok {
+				contacts = append(contacts, t)
+			}
+		}
+		sort.Slice(contacts, func(i, j int) bool { return contacts[i].After(contacts[j]) })
+		if len(contacts) >= quorum && contacts[quorum-1].After(r.lastContact) {
+			r.lastContact = contacts[quorum-1]
+		}
+	}
+	lost := time.Since(r.lastContact) >= r.electionTimeout
+	r.electionLock.Unlock()
+
+	if lost {
+		log.WithFields(log.Fields{
+			"instance": r.instanceID,
+			"term":     term,
+		}).Warning("kayak leader lost quorum followers, step down")
+		r.stepDown(term)
+	}
+}
+
+// campaign runs a pre-vote and then a vote of next term, current node becomes leader if quorum
+// peers grant both of them.
+func (r *Runtime) campaign() {
+	r.electionLock.Lock()
+	term := r.term
+	r.resetElectionTimer(time.Now())
+	r.electionLock.Unlock()
+
+	if won, _ := r.requestVotes(term+1, true); !won {
+		return
+	}
+
+	r.electionLock.Lock()
+	if r.term != term {
+		// term changed during pre-vote
+		r.electionLock.Unlock()
+		return
+	}
+	if err := r.persistVote(term+1, r.nodeID); err != nil {
+		r.electionLock.Unlock()
+		log.WithField("instance", r.instanceID).WithError(err).Warning("campaign failed")
+		return
+	}
+	r.term++
+	term = r.term
+	r.votedFor = r.nodeID
+	r.resetElectionTimer(time.Now())
+	r.electionLock.Unlock()
+
+	won, lastIndex := r.requestVotes(term, false)
+	if !won {
+		return
+	}
+
+	r.electionLock.Lock()
+	stale := r.term != term
+	r.electionLock.Unlock()
+	if stale {
+		return
+	}
+
+	// logs after the last index of voters are not known to the new leader
+	if r.changeLeader(r.nodeID, term, lastIndex+1) {
+		r.leaderHeartbeat()
+		r.rollbackPendingPrepares(lastIndex + 1)
+	}
+}
+
+// requestVotes requests votes of the term from peers, the max last log index of granted voters is
+// returned.
+func (r *Runtime) requestVotes(term uint64, preVote bool) (won bool, lastIndex uint64) {
+	r.peersLock.RLock()
+	var (
+		voters = make([]proto.NodeID, 0, len(r.peers.Servers))
+		quorum = len(r.peers.Servers) / 2 // votes required besides current node itself
+	)
+	for _, s := range r.peers.Servers {
+		if s != r.nodeID {
+			voters = append(voters, s)
+		}
+	}
+	r.peersLock.RUnlock()
+
+	lastIndex = r.lastIndex()
+
+	if quorum == 0 {
+		won = true
+		return
+	}
+
+	var (
+		req = &kt.VoteRequest{
+			Instance:   r.instanceID,
+			Candidate:  r.nodeID,
+			Term:       term,
+			LastIndex:  lastIndex,
+			LastCommit: atomic.LoadUint64(&r.lastCommit),
+			PreVote:    preVote,
+		}
+		respCh  = make(chan *kt.VoteResponse, len(voters))
+		granted int
+	)
+
+	for _, v := range voters {
+		go func(v proto.NodeID) {
+			caller := r.TrackerNewCallerFunc(v)
+			if pcaller, ok := caller.(*rpc.PersistentCaller); ok && pcaller != nil {
+				defer pcaller.Close()
+			}
+			resp := &kt.VoteResponse{}
+			if err := caller.Call(r.voteRPCMethod, req, resp); err != nil {
+				log.WithField("voter", v).WithError(err).Debug("send vote rpc failed")
+				resp = nil
+			}
+			respCh <- resp
+		}(v)
+	}
+
+	timeout := time.NewTimer(r.electionTimeout)
+	defer timeout.Stop()
+
+	for range voters {
+		select {
+		case <-r.stopCh:
+			return
+		case <-timeout.C:
+			return
+		case resp := <-respCh:
+			if resp == nil {
+				continue
+			}
+			if !resp.Granted {
+				if !preVote && resp.Term > term {
+					if err := r.observeTerm(resp.Term); err != nil {
+						log.WithError(err).WithField("instance", r.instanceID).Warning("observe kayak term failed")
+					}
+				}
+				continue
+			}
+			if resp.LastIndex > lastIndex {
+				lastIndex = resp.LastIndex
+			}
+			if granted++; granted >= quorum {
+				won = true
+				log.WithFields(log.Fields{
+					"instance": r.instanceID,
+					"term":     term,
+					"pre":      preVote,
+				}).Info("kayak won election")
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// observeLeader follows the leader of newer term announced by heartbeat and records the contact of
+// current leader.
+func (r *Runtime) observeLeader(leader proto.NodeID, term uint64) (err error) {
+	r.electionLock.Lock()
+	current := r.term
+	r.electionLock.Unlock()
+
+	if term < current {
+		err = errors.Wrapf(kt.ErrNotLeader, "node %s of stale term %d, current term %d", leader, term, current)
+		return
+	}
+
+	r.peersLock.RLock()
+	newer := term > r.peers.Term || (term == r.peers.Term && r.peers.Leader == "")
+	known := term == r.peers.Term && leader == r.peers.Leader && leader != r.nodeID
+	r.peersLock.RUnlock()
+
+	if newer {
+		known = r.changeLeader(leader, term, 0)
+	}
+
+	if known {
+		r.electionLock.Lock()
+		now := time.Now()
+		r.lastContact = now
+		r.resetElectionTimer(now)
+		r.electionLock.Unlock()
+	}
+
+	return
+}
+
+// persistVote saves the term and vote durably before the vote takes effect, election lock must be
+// held by caller.
+func (r *Runtime) persistVote(term uint64, votedFor proto.NodeID) (err error) {
+	if err = r.voteStore.SaveVoteState(&kt.VoteState{Term: term, VotedFor: votedFor}); err != nil {
+		err = errors.Wrapf(err, "persist vote for %s of term %d failed", votedFor, term)
+	}
+	return
+}
+
+// restoreVote restores the term and vote saved before restart.
+func (r *Runtime) restoreVote() (err error) {
+	if r.voteStore == nil {
+		return
+	}
+
+	var st *kt.VoteState
+	if st, err = r.voteStore.LoadVoteState(); err != nil {
+		err = errors.Wrap(err, "load vote state failed")
+		return
+	}
+
+	if st.VotedFor.IsEmpty() {
+		// empty vote of term change is decoded as zero node id
+		st.VotedFor = ""
+	}
+
+	r.electionLock.Lock()
+	defer r.electionLock.Unlock()
+
+	if st.Term > r.term {
+		r.term = st.Term
+		r.votedFor = st.VotedFor
+	} else if st.Term == r.term {
+		r.votedFor = st.VotedFor
+	}
+
+	return
+}
+
+// observeTerm catches up newer term replied by voters, the term is persisted before it takes effect.
+func (r *Runtime) observeTerm(term uint64) (err error) {
+	r.electionLock.Lock()
+	defer r.electionLock.Unlock()
+
+	if term > r.term {
+		if err = r.persistVote(term, ""); err != nil {
+			return
+		}
+		r.term = term
+		r.votedFor = ""
+	}
+
+	return
+}
+
+// stepDown makes the leader of the term a follower without knowing the new leader.
+func (r *Runtime) stepDown(term uint64) {
+	r.peersLock.RLock()
+	isLeader := r.role == proto.Leader && r.peers.Term == term
+	r.peersLock.RUnlock()
+
+	if isLeader {
+		r.changeLeader("", term, 0)
+	}
+}
+
+// changeLeader updates the leader and term of peers, the peers are re-signed by current node as the
+// leadership is not assigned by the peers issuer. The next log index is raised to nextIndex for new leader.
+func (r *Runtime) changeLeader(leader proto.NodeID, term uint64, nextIndex uint64) (changed bool) {
+	r.peersLock.Lock()
+
+	if term < r.peers.Term || (term == r.peers.Term && leader == r.peers.Leader) {
+		// leadership of the term is already known
+		r.peersLock.Unlock()
+		return
+	}
+
+	peers := r.peers.Clone()
+	peers.Leader = leader
+	peers.Term = term
+	if err := peers.Sign(r.privateKey); err != nil {
+		r.peersLock.Unlock()
+		log.WithError(err).WithField("instance", r.instanceID).Warning("sign elected kayak peers failed")
+		return
+	}
+	if err := r.setPeers(&peers); err != nil {
+		r.peersLock.Unlock()
+		log.WithError(err).WithField("instance", r.instanceID).Warning("change kayak leader failed")
+		return
+	}
+
+	r.nextIndexLock.Lock()
+	if r.nextIndex < nextIndex {
+		r.nextIndex = nextIndex
+	}
+	r.nextIndexLock.Unlock()
+
+	r.peersLock.Unlock()
+
+	r.electionLock.Lock()
+	now := time.Now()
+	r.lastContact = now
+	r.resetElectionTimer(now)
+	r.followerContacts = make(map[proto.NodeID]time.Time)
+	r.electionLock.Unlock()
+
+	log.WithFields(log.Fields{
+		"instance": r.instanceID,
+		"leader":   leader,
+		"term":     term,
+	}).Info("kayak leader changed")
+
+	if r.onLeaderChange != nil {
+		notified := peers.Clone()
+		r.onLeaderChange(&notified)
+	}
+
+	changed = true
+	return
+}
+
+// rollbackPendingPrepares rolls back the prepares before index which are left uncommitted by the
+// previous leaders, the followers resolve them by the rollback logs.
+func (r *Runtime) rollbackPendingPrepares(before uint64) {
+	r.peersLock.RLock()
+	defer r.peersLock.RUnlock()
+
+	if r.role != proto.Leader {
+		return
+	}
+
+	var indexes []uint64
+	r.pendingPreparesLock.RLock()
+	for i := range r.pendingPrepares {
+		if i < before {
+			indexes = append(indexes, i)
+		}
+	}
+	r.pendingPreparesLock.RUnlock()
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	ctx := context.Background()
+	for _, i := range indexes {
+		if l, err := r.leaderLogRollback(ctx, timer.NewTimer(), i); err == nil {
+			r.applyRPC(l, 0)
+		}
+		r.markPrepareFinished(ctx, i)
+	}
+}
+
+// checkElectedLeader rejects new prepare logs which are not produced by the leader of current term
+// to fence the deposed leaders, peers lock must be held by caller.
+func (r *Runtime) checkElectedLeader(l *kt.Log) (err error) {
+	if r.electionTimeout <= 0 || l.Type != kt.LogPrepare || l.Producer == r.peers.Leader {
+		return
+	}
+
+	r.nextIndexLock.Lock()
+	isNew := l.Index >= r.nextIndex
+	r.nextIndexLock.Unlock()
+
+	if isNew {
+		err = errors.Wrapf(kt.ErrNotLeader, "prepare produced by %s, current leader %s", l.Producer, r.peers.Leader)
+	}
+
+	return
+}
+
+// resetElectionTimer randomizes the election deadline of follower, election lock must be held by
+// caller.
+func (r *Runtime) resetElectionTimer(now time.Time) {
+	r.electionDeadline = now.Add(r.electionTimeout + time.Duration(rand.Int63n(int64(r.electionTimeout))))
+}
+
+// lastIndex returns the last known log index.
+func (r *Runtime) lastIndex() (index uint64) {
+	r.nextIndexLock.Lock()
+	defer r.nextIndexLock.Unlock()
+
+	if r.nextIndex > 0 {
+		index = r.nextIndex - 1
+	}
+	return
+}
@@ -0,0 +1,368 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak_test
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	kl "github.com/CovenantSQL/CovenantSQL/kayak/wal"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestRuntimeElection(t *testing.T) {
+	Convey("Given a leader and two followers with leader election", t, func() {
+		var (
+			nodes = []proto.NodeID{
+				proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade"),
+				proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5"),
+				proto.NodeID("00000f3b43288fe99831eb533ab77ec455d13e11fc38ec35a42d4edd17aa320d"),
+			}
+			peers = &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  nodes[0],
+					Servers: nodes,
+				},
+			}
+			timeout = 200 * time.Millisecond
+			changes int32
+			m       = newFakeMux()
+			dbs     = make([]*kvStorage, len(nodes))
+			rts     = make([]*kayak.Runtime, len(nodes))
+			callers = make([][]*switchCaller, len(nodes))
+		)
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		err = peers.Sign(privKey)
+		So(err, ShouldBeNil)
+
+		for i, n := range nodes {
+			wal := kl.NewMemWal()
+			defer wal.Close()
+			dbs[i] = newKVStorage()
+			rts[i], err = kayak.NewRuntime(&kt.RuntimeConfig{
+				Handler:             dbs[i],
+				PrepareThreshold:    0.5,
+				CommitThreshold:     0.5,
+				PrepareTimeout:      time.Second,
+				CommitTimeout:       time.Second,
+				LogWaitTimeout:      10 * time.Second,
+				Peers:               peers,
+				Wal:                 wal,
+				NodeID:              n,
+				ServiceName:         "Test",
+				ApplyMethodName:     "Apply",
+				FetchMethodName:     "Fetch",
+				HeartbeatMethodName: "Heartbeat",
+				VoteMethodName:      "RequestVote",
+				ElectionTimeout:     timeout,
+				PrivateKey:          privKey,
+				OnLeaderChange: func(*proto.Peers) {
+					atomic.AddInt32(&changes, 1)
+				},
+			})
+			So(err, ShouldBeNil)
+			m.register(n, newFakeService(rts[i]))
+		}
+
+		for i := range nodes {
+			callers[i] = make([]*switchCaller, len(nodes))
+			for j, n := range nodes {
+				callers[i][j] = &switchCaller{Caller: newFakeCaller(m, n), enabled: 1}
+			}
+			from := callers[i]
+			newCaller := func(target proto.NodeID) kayak.Caller {
+				for j, n := range nodes {
+					if n == target {
+						return from[j]
+					}
+				}
+				return &switchCaller{}
+			}
+			rts[i].TrackerNewCallerFunc = newCaller
+			rts[i].WaiterNewCallerFunc = newCaller
+		}
+
+		for _, rt := range rts {
+			So(rt.Start(), ShouldBeNil)
+			defer rt.Shutdown()
+		}
+
+		// connect or disconnect node i from other nodes
+		connect := func(i int, connected bool) {
+			var enabled uint32
+			if connected {
+				enabled = 1
+			}
+			for j := range nodes {
+				atomic.StoreUint32(&callers[i][j].enabled, enabled)
+				atomic.StoreUint32(&callers[j][i].enabled, enabled)
+			}
+		}
+		// wait for the nodes except the excluded one to agree on a leader among them
+		waitLeader := func(exclude int) int {
+			for deadline := time.Now().Add(30 * timeout); time.Now().Before(deadline); time.Sleep(timeout / 10) {
+				var leaders = make(map[proto.NodeID]bool)
+				for i, rt := range rts {
+					if i != exclude {
+						leaders[rt.Peers().Leader] = true
+					}
+				}
+				for i, n := range nodes {
+					if len(leaders) == 1 && leaders[n] && i != exclude {
+						return i
+					}
+				}
+			}
+			return -1
+		}
+		apply := func(rt *kayak.Runtime) {
+			_, _, err := rt.Apply(context.Background(), &kvPair{
+				Key:   RandStringRunes(8),
+				Value: RandStringRunes(16),
+			})
+			So(err, ShouldBeNil)
+		}
+		for i := 0; i < 10; i++ {
+			apply(rts[0])
+		}
+
+		Convey("The leader should be kept without term inflation", func() {
+			time.Sleep(3 * timeout)
+			for _, rt := range rts {
+				So(rt.Peers().Leader, ShouldEqual, nodes[0])
+				So(rt.Peers().Term, ShouldEqual, 0)
+			}
+
+			// votes are refused while the leader is alive
+			resp, err := rts[1].RequestVote(&kt.VoteRequest{
+				Candidate:  nodes[2],
+				Term:       5,
+				LastIndex:  1000,
+				LastCommit: 1000,
+			})
+			So(err, ShouldBeNil)
+			So(resp.Granted, ShouldBeFalse)
+			So(resp.Term, ShouldEqual, 0)
+			_, err = rts[1].RequestVote(&kt.VoteRequest{Candidate: proto.NodeID("unknown"), Term: 5})
+			So(errors.Cause(err), ShouldEqual, kt.ErrNotInPeer)
+
+			Convey("A partitioned follower should not disrupt the leader after rejoin", func() {
+				connect(2, false)
+				time.Sleep(5 * timeout)
+				connect(2, true)
+				time.Sleep(3 * timeout)
+				for _, rt := range rts {
+					So(rt.Peers().Leader, ShouldEqual, nodes[0])
+					So(rt.Peers().Term, ShouldEqual, 0)
+				}
+				So(atomic.LoadInt32(&changes), ShouldEqual, 0)
+				apply(rts[0])
+			})
+		})
+
+		Convey("The followers should elect a new leader after the leader dies", func() {
+			connect(0, false)
+			i := waitLeader(0)
+			So(i, ShouldBeIn, []int{1, 2})
+			term := rts[i].Peers().Term
+			So(term, ShouldBeGreaterThan, 0)
+			So(rts[i].Peers().Verify(), ShouldBeNil)
+
+			// the peers issuer removes the dead node, elected leadership is kept
+			alive := &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  nodes[1],
+					Servers: nodes[1:],
+				},
+			}
+			So(alive.Sign(privKey), ShouldBeNil)
+			for _, rt := range rts[1:] {
+				So(rt.UpdatePeers(alive), ShouldBeNil)
+				So(rt.Peers().Leader, ShouldEqual, nodes[i])
+				So(rt.Peers().Term, ShouldEqual, term)
+				So(rt.Peers().Servers, ShouldResemble, nodes[1:])
+			}
+
+			apply(rts[i])
+			follower := 3 - i
+			for deadline := time.Now().Add(10 * timeout); time.Now().Before(deadline) &&
+				rts[follower].LastCommit() != rts[i].LastCommit(); {
+				time.Sleep(timeout / 10)
+			}
+			So(rts[follower].LastCommit(), ShouldEqual, rts[i].LastCommit())
+			So(dbs[follower].snapshot(), ShouldResemble, dbs[i].snapshot())
+
+			// the isolated leader steps down by check quorum
+			for deadline := time.Now().Add(10 * timeout); time.Now().Before(deadline) &&
+				rts[0].Peers().Leader == nodes[0]; {
+				time.Sleep(timeout / 10)
+			}
+			So(rts[0].Peers().Leader, ShouldNotEqual, nodes[0])
+			_, _, err := rts[0].Apply(context.Background(), &kvPair{Key: "k", Value: "v"})
+			So(errors.Cause(err), ShouldEqual, kt.ErrNotLeader)
+
+			Convey("The old leader should follow the new leader after rejoin", func() {
+				connect(0, true)
+				for _, rt := range rts[1:] {
+					So(rt.UpdatePeers(peers), ShouldBeNil)
+				}
+				So(waitLeader(-1), ShouldEqual, i)
+				So(rts[0].Peers().Term, ShouldEqual, rts[i].Peers().Term)
+				apply(rts[i])
+			})
+		})
+	})
+}
+
+func TestRuntimeElection_VotePersistence(t *testing.T) {
+	Convey("Given a voter restarted in the middle of a term", t, func() {
+		var (
+			nodes = []proto.NodeID{
+				proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade"),
+				proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5"),
+				proto.NodeID("00000f3b43288fe99831eb533ab77ec455d13e11fc38ec35a42d4edd17aa320d"),
+			}
+			peers = &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  nodes[0],
+					Servers: nodes,
+				},
+			}
+			timeout = 100 * time.Millisecond
+		)
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		err = peers.Sign(privKey)
+		So(err, ShouldBeNil)
+
+		defer os.RemoveAll("testVote.db")
+
+		// start the voter isolated from other nodes, the leader is lost after election timeout
+		startVoter := func() (rt *kayak.Runtime, wal *voteWal) {
+			lw, err := kl.NewLevelDBWal("testVote.db")
+			So(err, ShouldBeNil)
+			wal = &voteWal{LevelDBWal: lw}
+			rt, err = kayak.NewRuntime(&kt.RuntimeConfig{
+				Handler:             newKVStorage(),
+				PrepareThreshold:    0.5,
+				CommitThreshold:     0.5,
+				PrepareTimeout:      time.Second,
+				CommitTimeout:       time.Second,
+				LogWaitTimeout:      10 * time.Second,
+				Peers:               peers,
+				Wal:                 wal,
+				NodeID:              nodes[1],
+				ServiceName:         "Test",
+				ApplyMethodName:     "Apply",
+				FetchMethodName:     "Fetch",
+				HeartbeatMethodName: "Heartbeat",
+				VoteMethodName:      "RequestVote",
+				ElectionTimeout:     timeout,
+				PrivateKey:          privKey,
+			})
+			So(err, ShouldBeNil)
+			newCaller := func(proto.NodeID) kayak.Caller { return &switchCaller{} }
+			rt.TrackerNewCallerFunc = newCaller
+			rt.WaiterNewCallerFunc = newCaller
+			So(rt.Start(), ShouldBeNil)
+			time.Sleep(2 * timeout)
+			return
+		}
+		vote := func(rt *kayak.Runtime, candidate proto.NodeID, term uint64) bool {
+			resp, err := rt.RequestVote(&kt.VoteRequest{
+				Instance:  "test",
+				Candidate: candidate,
+				Term:      term,
+			})
+			So(err, ShouldBeNil)
+			return resp.Granted
+		}
+
+		rt, wal := startVoter()
+		So(vote(rt, nodes[2], 1), ShouldBeTrue)
+		So(vote(rt, nodes[0], 1), ShouldBeFalse)
+		So(rt.Shutdown(), ShouldBeNil)
+		wal.Close()
+
+		Convey("The voter should not vote twice in the term after restart", func() {
+			rt, wal := startVoter()
+			defer wal.Close()
+			defer rt.Shutdown()
+
+			So(vote(rt, nodes[0], 1), ShouldBeFalse)
+			So(vote(rt, nodes[2], 1), ShouldBeTrue)
+			So(vote(rt, nodes[0], 2), ShouldBeTrue)
+		})
+		Convey("The voter should persist the term of peers before it takes effect", func() {
+			rt, wal := startVoter()
+			newer := peers.Clone()
+			newer.Term = 5
+			So(newer.Sign(privKey), ShouldBeNil)
+			So(rt.UpdatePeers(&newer), ShouldBeNil)
+			So(rt.Shutdown(), ShouldBeNil)
+			wal.Close()
+
+			rt, wal = startVoter()
+			defer wal.Close()
+			defer rt.Shutdown()
+
+			So(vote(rt, nodes[2], 4), ShouldBeFalse)
+			So(vote(rt, nodes[2], 5), ShouldBeTrue)
+		})
+		Convey("The voter should keep its term if the vote is not saved", func() {
+			rt, wal := startVoter()
+			defer wal.Close()
+			defer rt.Shutdown()
+
+			atomic.StoreUint32(&wal.failSave, 1)
+			_, err := rt.RequestVote(&kt.VoteRequest{
+				Instance:  "test",
+				Candidate: nodes[0],
+				Term:      3,
+			})
+			So(err, ShouldNotBeNil)
+			atomic.StoreUint32(&wal.failSave, 0)
+
+			So(vote(rt, nodes[0], 2), ShouldBeTrue)
+		})
+	})
+}
+
+// voteWal fails to save vote state on demand.
+type voteWal struct {
+	*kl.LevelDBWal
+	failSave uint32
+}
+
+func (w *voteWal) SaveVoteState(st *kt.VoteState) error {
+	if atomic.LoadUint32(&w.failSave) == 1 {
+		return errors.New("save vote state failed")
+	}
+	return w.LevelDBWal.SaveVoteState(st)
+}
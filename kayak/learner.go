@@ -0,0 +1,88 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// LearnerLag returns the count of committed logs not acknowledged by the learner yet, the learner is
+// safe to be promoted to server by the peers issuer after it catches up.
+func (r *Runtime) LearnerLag(node proto.NodeID) (lag uint64, err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+
+	r.peersLock.RLock()
+	defer r.peersLock.RUnlock()
+
+	if r.role != proto.Leader {
+		err = kt.ErrNotLeader
+		return
+	}
+
+	if !r.peers.IsLearner(node) {
+		err = errors.Wrapf(kt.ErrNotInPeer, "node %s is not a learner", node)
+		return
+	}
+
+	r.learnerProgressLock.Lock()
+	progress := r.learnerProgress[node]
+	r.learnerProgressLock.Unlock()
+
+	if lastCommit := atomic.LoadUint64(&r.lastCommit); lastCommit > progress {
+		lag = lastCommit - progress
+	}
+
+	return
+}
+
+// IsLearner returns whether current node is a non-voting learner.
+func (r *Runtime) IsLearner() bool {
+	r.peersLock.RLock()
+	defer r.peersLock.RUnlock()
+
+	return r.learner
+}
+
+// setLearnerProgress records the commit log acknowledged by the learner.
+func (r *Runtime) setLearnerProgress(node proto.NodeID, index uint64) {
+	r.learnerProgressLock.Lock()
+	defer r.learnerProgressLock.Unlock()
+
+	if progress, ok := r.learnerProgress[node]; ok && progress < index {
+		r.learnerProgress[node] = index
+	}
+}
+
+// retainLearnerProgress drops the progress of the nodes which are no longer learners.
+func (r *Runtime) retainLearnerProgress(learners []proto.NodeID) {
+	r.learnerProgressLock.Lock()
+	defer r.learnerProgressLock.Unlock()
+
+	progress := make(map[proto.NodeID]uint64, len(learners))
+	for _, n := range learners {
+		progress[n] = r.learnerProgress[n]
+	}
+	r.learnerProgress = progress
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	kl "github.com/CovenantSQL/CovenantSQL/kayak/wal"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestRuntimeLearner(t *testing.T) {
+	Convey("Given a leader, a follower and a learner missing the logs", t, func() {
+		var (
+			node1 = proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+			node2 = proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+			node3 = proto.NodeID("00000f3b43288fe99831eb533ab77ec455d13e11fc38ec35a42d4edd17aa320d")
+			peers = &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:   node1,
+					Servers:  []proto.NodeID{node1, node2},
+					Learners: []proto.NodeID{node3},
+				},
+			}
+			db1, db2, db3    = newKVStorage(), newKVStorage(), newKVStorage()
+			wal1, wal2, wal3 = kl.NewMemWal(), kl.NewMemWal(), kl.NewMemWal()
+			newCfg           = func(h kt.Handler, w kt.Wal, nodeID proto.NodeID) *kt.RuntimeConfig {
+				return &kt.RuntimeConfig{
+					Handler:          h,
+					PrepareThreshold: 1.0,
+					PrepareTimeout:   time.Second,
+					CommitTimeout:    time.Second,
+					LogWaitTimeout:   100 * time.Millisecond,
+					Peers:            peers,
+					Wal:              w,
+					NodeID:           nodeID,
+					ServiceName:      "Test",
+					ApplyMethodName:  "Apply",
+					FetchMethodName:  "Fetch",
+				}
+			}
+		)
+		defer wal1.Close()
+		defer wal2.Close()
+		defer wal3.Close()
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		err = peers.Sign(privKey)
+		So(err, ShouldBeNil)
+
+		rt1, err := kayak.NewRuntime(newCfg(db1, wal1, node1))
+		So(err, ShouldBeNil)
+		rt2, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+		So(err, ShouldBeNil)
+		rt3, err := kayak.NewRuntime(newCfg(db3, wal3, node3))
+		So(err, ShouldBeNil)
+		So(rt3.IsLearner(), ShouldBeTrue)
+		So(rt2.IsLearner(), ShouldBeFalse)
+
+		m := newFakeMux()
+		m.register(node1, newFakeService(rt1))
+		m.register(node2, newFakeService(rt2))
+		m.register(node3, newFakeService(rt3))
+		var (
+			toFollower = newFakeCaller(m, node2)
+			toLearner  = &switchCaller{Caller: newFakeCaller(m, node3)}
+			toLeader   = newFakeCaller(m, node1)
+			fromLeader = func(target proto.NodeID) kayak.Caller {
+				if target == node3 {
+					return toLearner
+				}
+				return toFollower
+			}
+		)
+		rt1.TrackerNewCallerFunc = fromLeader
+		rt1.WaiterNewCallerFunc = fromLeader
+		for _, rt := range []*kayak.Runtime{rt2, rt3} {
+			rt.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+			rt.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+		}
+
+		for _, rt := range []*kayak.Runtime{rt1, rt2, rt3} {
+			So(rt.Start(), ShouldBeNil)
+			defer rt.Shutdown()
+		}
+
+		apply := func() {
+			_, _, err := rt1.Apply(context.Background(), &kvPair{
+				Key:   RandStringRunes(8),
+				Value: RandStringRunes(16),
+			})
+			So(err, ShouldBeNil)
+		}
+		waitCaughtUp := func(rt *kayak.Runtime, db *kvStorage) {
+			var deadline = time.Now().Add(10 * time.Second)
+			for rt.LastCommit() != rt1.LastCommit() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(rt.LastCommit(), ShouldEqual, rt1.LastCommit())
+			So(db.snapshot(), ShouldResemble, db1.snapshot())
+		}
+
+		// the unreachable learner does not fail the applies
+		for i := 0; i < 20; i++ {
+			apply()
+		}
+		waitCaughtUp(rt2, db2)
+		So(rt3.LastCommit(), ShouldEqual, 0)
+		lag, err := rt1.LearnerLag(node3)
+		So(err, ShouldBeNil)
+		So(lag, ShouldEqual, rt1.LastCommit())
+
+		_, err = rt1.LearnerLag(node2)
+		So(errors.Cause(err), ShouldEqual, kt.ErrNotInPeer)
+		_, err = rt2.LearnerLag(node3)
+		So(errors.Cause(err), ShouldEqual, kt.ErrNotLeader)
+
+		Convey("The learner should catch up and be promoted to server", func() {
+			atomic.StoreUint32(&toLearner.enabled, 1)
+			apply()
+			waitCaughtUp(rt3, db3)
+
+			var deadline = time.Now().Add(10 * time.Second)
+			for lag != 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				lag, err = rt1.LearnerLag(node3)
+				So(err, ShouldBeNil)
+			}
+			So(lag, ShouldEqual, 0)
+
+			promoted := &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  node1,
+					Servers: []proto.NodeID{node1, node2, node3},
+				},
+			}
+			So(promoted.Sign(privKey), ShouldBeNil)
+			for _, rt := range []*kayak.Runtime{rt1, rt2, rt3} {
+				So(rt.UpdatePeers(promoted), ShouldBeNil)
+			}
+			So(rt3.IsLearner(), ShouldBeFalse)
+			_, err = rt1.LearnerLag(node3)
+			So(errors.Cause(err), ShouldEqual, kt.ErrNotInPeer)
+
+			// the promoted server is required by prepares
+			atomic.StoreUint32(&toLearner.enabled, 0)
+			_, _, err = rt1.Apply(context.Background(), &kvPair{Key: "k", Value: "v"})
+			So(errors.Cause(err), ShouldEqual, kt.ErrPrepareFailed)
+			atomic.StoreUint32(&toLearner.enabled, 1)
+			apply()
+			waitCaughtUp(rt3, db3)
+		})
+
+		Convey("The learner should not be a server at the same time", func() {
+			invalid := &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:   node1,
+					Servers:  []proto.NodeID{node1, node2},
+					Learners: []proto.NodeID{node2},
+				},
+			}
+			So(invalid.Sign(privKey), ShouldBeNil)
+			So(errors.Cause(rt1.UpdatePeers(invalid)), ShouldEqual, kt.ErrInvalidConfig)
+		})
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"io"
 	"log"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -78,7 +79,7 @@ func (r *Runtime) readLogs() (err error) {
 		switch l.Type {
 		case kt.LogPrepare:
 			// record in pending prepares
-			r.pendingPrepares[l.Index] = true
+			r.pendingPrepares[l.Index] = time.Now()
 		case kt.LogCommit:
 			// record last commit
 			var lastCommit uint64
@@ -92,20 +93,27 @@ func (r *Runtime) readLogs() (err error) {
 					"last commit record in wal mismatched (expected: %v, actual: %v)", r.lastCommit, lastCommit)
 				return
 			}
-			if !r.pendingPrepares[prepareLog.Index] {
+			if _, ok := r.pendingPrepares[prepareLog.Index]; !ok {
 				err = errors.Wrap(kt.ErrInvalidLog, "previous prepare already committed/rollback")
 				return
 			}
 			r.lastCommit = l.Index
 			// resolve previous prepared
 			delete(r.pendingPrepares, prepareLog.Index)
+		case kt.LogCheckpoint:
+			// state synced from peer or logs compacted
+			if err = r.readCheckpoint(l); err != nil {
+				err = errors.Wrap(err, "load checkpoint failed")
+				return
+			}
+			r.checkpoint = l
 		case kt.LogRollback:
 			var prepareLog *kt.Log
 			if _, prepareLog, err = r.getPrepareLog(context.Background(), l); err != nil {
 				err = errors.Wrap(err, "previous prepare does not exists, node need full recovery")
 				return
 			}
-			if !r.pendingPrepares[prepareLog.Index] {
+			if _, ok := r.pendingPrepares[prepareLog.Index]; !ok {
 				err = errors.Wrap(kt.ErrInvalidLog, "previous prepare already committed/rollback")
 				return
 			}
@@ -92,6 +92,7 @@ func (r *Runtime) doLeaderCommit(ctx context.Context, tm *timer.Timer, prepareLo
 
 	if commitResult.rpc != nil {
 		commitResult.rpc.get(ctx)
+		r.waitReadLeases(commitResult.rpc)
 	}
 
 	tm.Add("wait_follower_commit")
@@ -151,6 +152,11 @@ func (r *Runtime) followerPrepare(ctx context.Context, tm *timer.Timer, l *kt.Lo
 		tm.Add("check")
 	}
 
+	if cp := r.getCheckpoint(); cp != nil && l.Index <= cp.Index {
+		// a late prepare resolved by the installed checkpoint, the pending ones are persisted on install
+		return
+	}
+
 	// write log
 	if err = r.writeWAL(ctx, l); err != nil {
 		return
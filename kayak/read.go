@@ -0,0 +1,480 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// ReadIndex defines entry for read index requests of followers. The leader confirms its leadership
+// with quorum followers and returns the last commit index, a read lease is granted to the requester
+// if leases are enabled, the leader does not acknowledge commits before they are applied by the
+// lease holders until the leases expire.
+func (r *Runtime) ReadIndex(ctx context.Context, requester proto.NodeID) (
+	index uint64, lease time.Duration, err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+
+	r.peersLock.RLock()
+	defer r.peersLock.RUnlock()
+
+	if r.role != proto.Leader {
+		err = kt.ErrNotLeader
+		return
+	}
+
+	if index, err = r.leaderReadIndex(ctx); err != nil {
+		return
+	}
+
+	if r.leaseDuration <= 0 {
+		return
+	}
+
+	for _, f := range r.followers {
+		if f != requester {
+			continue
+		}
+
+		r.leaseLock.Lock()
+		now := time.Now()
+		// follower lease never outlives the leader lease
+		expire := now.Add(r.leaseDuration)
+		if r.leaderLease.Before(expire) {
+			expire = r.leaderLease
+		}
+		if expire.After(now) {
+			if expire.After(r.readLeases[requester]) {
+				r.readLeases[requester] = expire
+			}
+			lease = expire.Sub(now)
+		}
+		r.leaseLock.Unlock()
+		break
+	}
+
+	return
+}
+
+// Heartbeat defines entry for leadership confirmation requests of leader. The follower promises
+// not to accept new logs produced by other nodes until the lease of confirmed leader expires, the
+// leader of newer term is followed if leader election is enabled. The last commit index of leader
+// is recorded to bound the staleness of follower reads.
+func (r *Runtime) Heartbeat(leader proto.NodeID, term uint64, lastCommit uint64) (err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+
+	if r.electionTimeout > 0 {
+		if err = r.observeLeader(leader, term); err != nil {
+			return
+		}
+	}
+
+	r.peersLock.RLock()
+	defer r.peersLock.RUnlock()
+
+	if r.role == proto.Leader {
+		err = kt.ErrNotFollower
+		return
+	}
+
+	if r.peers.Leader != leader || r.peers.Term != term {
+		err = errors.Wrapf(kt.ErrNotLeader, "node %s of term %d is not current leader", leader, term)
+		return
+	}
+
+	if r.leaseDuration > 0 {
+		r.leaseLock.Lock()
+		r.grantedLeader = leader
+		r.grantedLease = time.Now().Add(r.leaseDuration)
+		r.leaseLock.Unlock()
+	}
+
+	r.observeLeaderCommit(lastCommit, time.Now())
+
+	return
+}
+
+// LinearizableRead blocks until the local state reflects all the commits acknowledged before the
+// call. The leader serves the read after confirming its leadership, the follower waits for the read
+// index from leader to be committed locally, or serves the read directly within its read lease.
+func (r *Runtime) LinearizableRead(ctx context.Context) (err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+
+	r.peersLock.RLock()
+	role, leader := r.role, r.peers.Leader
+	if role == proto.Leader {
+		defer r.peersLock.RUnlock()
+		// commits are applied before the last commit index is updated
+		_, err = r.leaderReadIndex(ctx)
+		return
+	}
+	r.peersLock.RUnlock()
+
+	r.leaseLock.Lock()
+	leased := time.Now().Before(r.readLease)
+	r.leaseLock.Unlock()
+
+	if leased {
+		return
+	}
+
+	var (
+		start = time.Now()
+		resp  *kt.ReadIndexResponse
+	)
+
+	if resp, err = r.requestReadIndex(leader); err != nil {
+		return
+	}
+	r.observeLeaderCommit(resp.Index, start)
+
+	if err = r.waitForCommit(ctx, resp.Index); err != nil {
+		err = errors.Wrapf(err, "wait for read index %d failed", resp.Index)
+		return
+	}
+
+	if resp.Lease > 0 {
+		r.peersLock.RLock()
+		if r.peers.Leader == leader {
+			// the lease is counted from request time to cover the rpc latency
+			expire := start.Add(resp.Lease - resp.Lease/10)
+			r.leaseLock.Lock()
+			if expire.After(r.readLease) {
+				r.readLease = expire
+			}
+			r.leaseLock.Unlock()
+		}
+		r.peersLock.RUnlock()
+	}
+
+	return
+}
+
+// CheckStaleness checks that the local state lags behind the leader by at most maxLag commit log
+// indexes, and includes all the leader commits made maxAge before, 0 means not bounded by that
+// measure. The last commit index is requested from the leader if it's not heard from in time, so a
+// partitioned follower rejects the read instead of serving its stale state. The leader always
+// serves the latest state.
+func (r *Runtime) CheckStaleness(maxLag uint64, maxAge time.Duration) (err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+
+	r.peersLock.RLock()
+	role, leader := r.role, r.peers.Leader
+	r.peersLock.RUnlock()
+
+	if role == proto.Leader {
+		return
+	}
+
+	contactTimeout := maxAge
+	if contactTimeout <= 0 || contactTimeout > maxLeaderContactAge {
+		contactTimeout = maxLeaderContactAge
+	}
+
+	r.stalenessLock.Lock()
+	contact := r.leaderContact
+	r.stalenessLock.Unlock()
+
+	if contact.IsZero() || time.Since(contact) > contactTimeout {
+		var (
+			start = time.Now()
+			resp  *kt.ReadIndexResponse
+		)
+		if resp, err = r.requestReadIndex(leader); err != nil {
+			err = errors.Wrapf(kt.ErrStaleRead, "leader %s not reachable: %v", leader, err)
+			return
+		}
+		r.observeLeaderCommit(resp.Index, start)
+	}
+
+	lag, caughtUp := r.followerStaleness()
+	if maxLag > 0 && lag > maxLag {
+		err = errors.Wrapf(kt.ErrStaleRead, "commit lag %d exceeds %d", lag, maxLag)
+		return
+	}
+	if maxAge > 0 {
+		if caughtUp.IsZero() {
+			err = errors.Wrap(kt.ErrStaleRead, "never caught up with leader")
+			return
+		}
+		if age := time.Since(caughtUp); age > maxAge {
+			err = errors.Wrapf(kt.ErrStaleRead, "staleness %v exceeds %v", age, maxAge)
+			return
+		}
+	}
+
+	return
+}
+
+// Staleness returns the commit log index lag behind the last commit advertised by the leader, and
+// the time since the local state is known to include all the leader commits, or since the Runtime
+// is started if the follower never caught up with the leader. The leader is never stale.
+func (r *Runtime) Staleness() (lag uint64, age time.Duration) {
+	r.peersLock.RLock()
+	role := r.role
+	r.peersLock.RUnlock()
+
+	if role == proto.Leader {
+		return
+	}
+
+	lag, caughtUp := r.followerStaleness()
+	if caughtUp.IsZero() {
+		r.stalenessLock.Lock()
+		caughtUp = r.startTime
+		r.stalenessLock.Unlock()
+	}
+	age = time.Since(caughtUp)
+
+	return
+}
+
+func (r *Runtime) followerStaleness() (lag uint64, caughtUp time.Time) {
+	lastCommit := atomic.LoadUint64(&r.lastCommit)
+
+	r.stalenessLock.Lock()
+	defer r.stalenessLock.Unlock()
+
+	if r.leaderCommit > lastCommit {
+		lag = r.leaderCommit - lastCommit
+	}
+	caughtUp = r.caughtUp
+
+	return
+}
+
+// observeLeaderCommit records the last commit index advertised by leader at the contact time.
+func (r *Runtime) observeLeaderCommit(index uint64, contact time.Time) {
+	r.stalenessLock.Lock()
+	defer r.stalenessLock.Unlock()
+
+	if index > r.leaderCommit {
+		r.leaderCommit = index
+	}
+	if contact.After(r.leaderContact) {
+		r.leaderContact = contact
+	}
+	if atomic.LoadUint64(&r.lastCommit) >= r.leaderCommit && r.leaderContact.After(r.caughtUp) {
+		r.caughtUp = r.leaderContact
+	}
+}
+
+// observeLocalCommit records the time the local state catches up with the leader.
+func (r *Runtime) observeLocalCommit(index uint64) {
+	r.stalenessLock.Lock()
+	defer r.stalenessLock.Unlock()
+
+	if index >= r.leaderCommit && r.leaderContact.After(r.caughtUp) {
+		r.caughtUp = r.leaderContact
+	}
+}
+
+// requestReadIndex requests the last commit index confirmed by the leader.
+func (r *Runtime) requestReadIndex(leader proto.NodeID) (resp *kt.ReadIndexResponse, err error) {
+	var (
+		req = &kt.ReadIndexRequest{Instance: r.instanceID}
+	)
+
+	resp = &kt.ReadIndexResponse{}
+	caller := r.WaiterNewCallerFunc(leader)
+	if pcaller, ok := caller.(*rpc.PersistentCaller); ok && pcaller != nil {
+		defer pcaller.Close()
+	}
+	if err = caller.Call(r.readIndexRPCMethod, req, resp); err != nil {
+		err = errors.Wrap(err, "send read index rpc failed")
+		return
+	}
+
+	return
+}
+
+// leaderReadIndex returns the last commit index after the leadership is confirmed, peers lock must
+// be held by caller.
+func (r *Runtime) leaderReadIndex(ctx context.Context) (index uint64, err error) {
+	index = atomic.LoadUint64(&r.lastCommit)
+
+	r.leaseLock.Lock()
+	leased := time.Now().Before(r.leaderLease)
+	r.leaseLock.Unlock()
+
+	if leased {
+		return
+	}
+
+	var (
+		start  = time.Now()
+		quorum = len(r.peers.Servers) / 2 // followers required besides the leader itself
+	)
+
+	if quorum > 0 {
+		tracker := newTracker(r, &kt.HeartbeatRequest{
+			Instance:   r.instanceID,
+			Leader:     r.nodeID,
+			Term:       r.peers.Term,
+			LastCommit: index,
+		}, quorum)
+		tracker.method = r.heartbeatRPCMethod
+		tracker.countSuccess = true
+		tracker.send()
+
+		confirmCtx, cancel := context.WithTimeout(ctx, r.prepareTimeout)
+		defer cancel()
+
+		errs, _, _ := tracker.get(confirmCtx)
+
+		var confirmed int
+		for _, e := range errs {
+			if e == nil {
+				confirmed++
+			}
+		}
+
+		if confirmed < quorum {
+			log.WithField("instance", r.instanceID).WithField("errors", errs).
+				Debug("confirm leadership failed")
+			err = errors.Wrapf(kt.ErrLeadershipNotConfirmed, "confirmed by %d of %d followers", confirmed, quorum)
+			return
+		}
+	}
+
+	if r.leaseDuration > 0 {
+		r.leaseLock.Lock()
+		// tolerate the clock drift between peers
+		if expire := start.Add(r.leaseDuration - r.leaseDuration/10); expire.After(r.leaderLease) {
+			r.leaderLease = expire
+		}
+		r.leaseLock.Unlock()
+	}
+
+	return
+}
+
+// waitReadLeases waits for the commit to be applied by followers holding read leases, or their
+// leases to expire.
+func (r *Runtime) waitReadLeases(tracker *rpcTracker) {
+	var (
+		now    = time.Now()
+		leases = make(map[proto.NodeID]time.Time)
+	)
+
+	r.leaseLock.Lock()
+	for node, expire := range r.readLeases {
+		if expire.After(now) {
+			leases[node] = expire
+		} else {
+			delete(r.readLeases, node)
+		}
+	}
+	r.leaseLock.Unlock()
+
+	for node, expire := range leases {
+		ctx, cancel := context.WithDeadline(context.Background(), expire)
+		if responded, err := tracker.waitNode(ctx, node); !responded || err != nil {
+			// the commit is not applied by lease holder, wait for the lease to expire
+			<-ctx.Done()
+		}
+		cancel()
+	}
+}
+
+// checkGrantedLeader rejects new prepare logs produced by other nodes before the lease of the
+// leader confirmed by heartbeat expires.
+func (r *Runtime) checkGrantedLeader(l *kt.Log) (err error) {
+	if l.Type != kt.LogPrepare {
+		return
+	}
+
+	r.nextIndexLock.Lock()
+	isNew := l.Index >= r.nextIndex
+	r.nextIndexLock.Unlock()
+
+	if !isNew {
+		return
+	}
+
+	r.leaseLock.Lock()
+	defer r.leaseLock.Unlock()
+
+	if l.Producer != r.grantedLeader && time.Now().Before(r.grantedLease) {
+		err = errors.Wrapf(kt.ErrLeaseNotExpired, "lease of leader %s expires at %v", r.grantedLeader, r.grantedLease)
+	}
+
+	return
+}
+
+// resetReadLeases drops the leases bound to previous peers, the granted leader lease is kept.
+func (r *Runtime) resetReadLeases() {
+	r.leaseLock.Lock()
+	defer r.leaseLock.Unlock()
+
+	r.leaderLease = time.Time{}
+	r.readLeases = make(map[proto.NodeID]time.Time)
+	r.readLease = time.Time{}
+}
+
+// setLastCommit updates the last commit index and notifies the commit waiters.
+func (r *Runtime) setLastCommit(index uint64) {
+	atomic.StoreUint64(&r.lastCommit, index)
+	r.observeLocalCommit(index)
+
+	r.commitNotifyLock.Lock()
+	defer r.commitNotifyLock.Unlock()
+
+	close(r.commitNotify)
+	r.commitNotify = make(chan struct{})
+}
+
+// waitForCommit waits for the last commit index to reach index.
+func (r *Runtime) waitForCommit(ctx context.Context, index uint64) (err error) {
+	for {
+		r.commitNotifyLock.Lock()
+		ch := r.commitNotify
+		r.commitNotifyLock.Unlock()
+
+		if atomic.LoadUint64(&r.lastCommit) >= index {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.stopCh:
+			return kt.ErrStopped
+		case <-ch:
+		}
+	}
+}
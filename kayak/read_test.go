@@ -0,0 +1,286 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	kl "github.com/CovenantSQL/CovenantSQL/kayak/wal"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestRuntimeReadIndex(t *testing.T) {
+	Convey("Given a leader and a follower with read leases", t, func() {
+		var (
+			node1 = proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+			node2 = proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+			node3 = proto.NodeID("00000f3b43288fe99831eb533ab77ec455d13e11fc38ec35a42d4edd17aa320d")
+			peers = &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  node1,
+					Servers: []proto.NodeID{node1, node2},
+				},
+			}
+			lease    = time.Second
+			db1, db2 = newKVStorage(), newKVStorage()
+			wal1     = kl.NewMemWal()
+			wal2     = kl.NewMemWal()
+			newCfg   = func(h kt.Handler, w kt.Wal, nodeID proto.NodeID) *kt.RuntimeConfig {
+				return &kt.RuntimeConfig{
+					Handler:             h,
+					PrepareThreshold:    1.0,
+					PrepareTimeout:      time.Second,
+					CommitTimeout:       time.Second,
+					LogWaitTimeout:      10 * time.Second,
+					Peers:               peers,
+					Wal:                 w,
+					NodeID:              nodeID,
+					ServiceName:         "Test",
+					ApplyMethodName:     "Apply",
+					FetchMethodName:     "Fetch",
+					ReadIndexMethodName: "ReadIndex",
+					HeartbeatMethodName: "Heartbeat",
+					LeaseDuration:       lease,
+				}
+			}
+		)
+		defer wal1.Close()
+		defer wal2.Close()
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		err = peers.Sign(privKey)
+		So(err, ShouldBeNil)
+
+		// leader acknowledges writes without waiting for follower prepares
+		cfg1 := newCfg(db1, wal1, node1)
+		cfg1.PrepareThreshold = 0
+		rt1, err := kayak.NewRuntime(cfg1)
+		So(err, ShouldBeNil)
+		rt2, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+		So(err, ShouldBeNil)
+
+		m := newFakeMux()
+		fs1 := newFakeService(rt1)
+		fs1.requester = node2
+		m.register(node1, fs1)
+		m.register(node2, newFakeService(rt2))
+		toFollower := &switchCaller{Caller: newFakeCaller(m, node2), enabled: 1}
+		toLeader := &switchCaller{Caller: newFakeCaller(m, node1), enabled: 1}
+		rt1.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt1.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt2.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+		rt2.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+
+		So(rt1.Start(), ShouldBeNil)
+		defer rt1.Shutdown()
+		So(rt2.Start(), ShouldBeNil)
+		defer rt2.Shutdown()
+
+		apply := func() {
+			_, _, err := rt1.Apply(context.Background(), &kvPair{
+				Key:   RandStringRunes(8),
+				Value: RandStringRunes(16),
+			})
+			So(err, ShouldBeNil)
+		}
+		for i := 0; i < 10; i++ {
+			apply()
+		}
+
+		var (
+			ctx   = context.Background()
+			start = time.Now()
+		)
+
+		Convey("The leader should serve reads after confirming leadership", func() {
+			So(rt1.LinearizableRead(ctx), ShouldBeNil)
+
+			_, _, err = rt2.ReadIndex(ctx, node1)
+			So(errors.Cause(err), ShouldEqual, kt.ErrNotLeader)
+			So(errors.Cause(rt1.Heartbeat(node1, 0, 0)), ShouldEqual, kt.ErrNotFollower)
+			So(errors.Cause(rt2.Heartbeat(node2, 0, 0)), ShouldEqual, kt.ErrNotLeader)
+
+			// read lease is only granted to followers
+			_, granted, err := rt1.ReadIndex(ctx, node3)
+			So(err, ShouldBeNil)
+			So(granted, ShouldEqual, 0)
+
+			Convey("The follower should reject new prepares of other nodes in leader lease", func() {
+				err = rt2.FollowerApply(&kt.Log{
+					LogHeader: kt.LogHeader{
+						Index:    1000,
+						Type:     kt.LogPrepare,
+						Producer: node3,
+					},
+				})
+				So(errors.Cause(err), ShouldEqual, kt.ErrLeaseNotExpired)
+			})
+
+			Convey("The leader should fail to confirm leadership after lease expires", func() {
+				atomic.StoreUint32(&toFollower.enabled, 0)
+				time.Sleep(lease)
+				So(errors.Cause(rt1.LinearizableRead(ctx)), ShouldEqual, kt.ErrLeadershipNotConfirmed)
+			})
+		})
+
+		Convey("The follower should serve reads after catching up read index", func() {
+			So(rt2.LinearizableRead(ctx), ShouldBeNil)
+			So(rt2.LastCommit(), ShouldEqual, rt1.LastCommit())
+			So(db2.snapshot(), ShouldResemble, db1.snapshot())
+
+			// served in read lease without asking leader
+			atomic.StoreUint32(&toLeader.enabled, 0)
+			So(rt2.LinearizableRead(ctx), ShouldBeNil)
+
+			Convey("The leader should not acknowledge writes missed by lease holder until lease expires", func() {
+				atomic.StoreUint32(&toFollower.enabled, 0)
+				_, _, err := rt1.Apply(ctx, &kvPair{Key: "k", Value: "v"})
+				So(err, ShouldBeNil)
+				// follower lease is bounded by leader lease confirmed after start
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, lease*9/10)
+			})
+		})
+	})
+}
+
+func TestRuntimeStaleness(t *testing.T) {
+	Convey("Given a leader and a follower serving bounded stale reads", t, func() {
+		var (
+			node1 = proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+			node2 = proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+			peers = &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  node1,
+					Servers: []proto.NodeID{node1, node2},
+				},
+			}
+			db1, db2 = newKVStorage(), newKVStorage()
+			wal1     = kl.NewMemWal()
+			wal2     = kl.NewMemWal()
+			newCfg   = func(h kt.Handler, w kt.Wal, nodeID proto.NodeID) *kt.RuntimeConfig {
+				return &kt.RuntimeConfig{
+					Handler:             h,
+					PrepareThreshold:    1.0,
+					PrepareTimeout:      time.Second,
+					CommitTimeout:       time.Second,
+					LogWaitTimeout:      10 * time.Second,
+					Peers:               peers,
+					Wal:                 w,
+					NodeID:              nodeID,
+					ServiceName:         "Test",
+					ApplyMethodName:     "Apply",
+					FetchMethodName:     "Fetch",
+					ReadIndexMethodName: "ReadIndex",
+					HeartbeatMethodName: "Heartbeat",
+				}
+			}
+		)
+		defer wal1.Close()
+		defer wal2.Close()
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		err = peers.Sign(privKey)
+		So(err, ShouldBeNil)
+
+		// leader acknowledges writes without waiting for follower prepares
+		cfg1 := newCfg(db1, wal1, node1)
+		cfg1.PrepareThreshold = 0
+		rt1, err := kayak.NewRuntime(cfg1)
+		So(err, ShouldBeNil)
+		rt2, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+		So(err, ShouldBeNil)
+
+		m := newFakeMux()
+		fs1 := newFakeService(rt1)
+		fs1.requester = node2
+		m.register(node1, fs1)
+		m.register(node2, newFakeService(rt2))
+		toFollower := &switchCaller{Caller: newFakeCaller(m, node2), enabled: 1}
+		toLeader := &switchCaller{Caller: newFakeCaller(m, node1), enabled: 1}
+		rt1.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt1.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt2.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+		rt2.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+
+		So(rt1.Start(), ShouldBeNil)
+		defer rt1.Shutdown()
+		So(rt2.Start(), ShouldBeNil)
+		defer rt2.Shutdown()
+
+		apply := func() {
+			_, _, err := rt1.Apply(context.Background(), &kvPair{
+				Key:   RandStringRunes(8),
+				Value: RandStringRunes(16),
+			})
+			So(err, ShouldBeNil)
+		}
+		for i := 0; i < 10; i++ {
+			apply()
+		}
+		for deadline := time.Now().Add(5 * time.Second); rt2.LastCommit() < rt1.LastCommit(); {
+			So(time.Now().Before(deadline), ShouldBeTrue)
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		So(rt1.CheckStaleness(1, time.Millisecond), ShouldBeNil)
+		So(rt2.CheckStaleness(1, time.Second), ShouldBeNil)
+		lag, _ := rt2.Staleness()
+		So(lag, ShouldEqual, 0)
+
+		Convey("The follower should reject reads beyond the commit lag advertised by leader", func() {
+			So(rt2.Heartbeat(node1, 0, rt1.LastCommit()+100), ShouldBeNil)
+			lag, _ = rt2.Staleness()
+			So(lag, ShouldEqual, 100)
+			So(errors.Cause(rt2.CheckStaleness(10, 0)), ShouldEqual, kt.ErrStaleRead)
+			So(rt2.CheckStaleness(100, 0), ShouldBeNil)
+
+			// the state is not caught up with the leader since the heartbeat
+			time.Sleep(20 * time.Millisecond)
+			So(errors.Cause(rt2.CheckStaleness(0, 10*time.Millisecond)), ShouldEqual, kt.ErrStaleRead)
+		})
+
+		Convey("The follower which stopped receiving prepares should reject reads", func() {
+			atomic.StoreUint32(&toFollower.enabled, 0)
+			atomic.StoreUint32(&toLeader.enabled, 0)
+			for i := 0; i < 5; i++ {
+				apply()
+			}
+			So(rt2.LastCommit(), ShouldBeLessThan, rt1.LastCommit())
+
+			// the leader is not heard from within the bound and can not be reached
+			time.Sleep(50 * time.Millisecond)
+			err = rt2.CheckStaleness(0, 20*time.Millisecond)
+			So(errors.Cause(err), ShouldEqual, kt.ErrStaleRead)
+
+			// commit lag is not trusted once the leader is not heard from for a while
+			time.Sleep(time.Second)
+			err = rt2.CheckStaleness(1, 0)
+			So(errors.Cause(err), ShouldEqual, kt.ErrStaleRead)
+		})
+	})
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// replicateItem defines a log to replicate with the tracker to report result to.
+type replicateItem struct {
+	log     *kt.Log
+	tracker *rpcTracker
+}
+
+// replicator replicates logs to a follower, the pending logs are sent in batches with multiple
+// batches in-flight. The in-flight window shrinks to a single batch after a failed call until the
+// follower is reachable again, and the oldest pending logs are dropped if the follower lags too
+// much, which are fetched by the follower on demand.
+type replicator struct {
+	r      *Runtime
+	node   proto.NodeID
+	caller Caller
+
+	lock     sync.Mutex
+	pending  []*replicateItem
+	inflight int
+	probing  bool
+	stopped  bool
+
+	notifyCh chan struct{}
+	stopCh   chan struct{}
+}
+
+func newReplicator(r *Runtime, node proto.NodeID) *replicator {
+	return &replicator{
+		r:        r,
+		node:     node,
+		caller:   r.TrackerNewCallerFunc(node),
+		notifyCh: make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// getReplicator returns the replicator of the follower, nil if the runtime is stopped.
+func (r *Runtime) getReplicator(node proto.NodeID) (rp *replicator) {
+	r.replicatorsLock.Lock()
+	defer r.replicatorsLock.Unlock()
+
+	if r.replicators == nil {
+		return
+	}
+
+	var ok bool
+	if rp, ok = r.replicators[node]; !ok {
+		rp = newReplicator(r, node)
+		r.replicators[node] = rp
+		r.goFunc(rp.run)
+	}
+
+	return
+}
+
+// retainReplicators stops the replicators of the nodes which are no longer followers.
+func (r *Runtime) retainReplicators(followers []proto.NodeID) {
+	r.replicatorsLock.Lock()
+	defer r.replicatorsLock.Unlock()
+
+	retained := make(map[proto.NodeID]bool, len(followers))
+	for _, n := range followers {
+		retained[n] = true
+	}
+
+	for n, rp := range r.replicators {
+		if !retained[n] {
+			close(rp.stopCh)
+			delete(r.replicators, n)
+		}
+	}
+}
+
+// stopReplicators prevents new replicators from starting after the runtime is stopped, the running
+// ones exit with the runtime stop channel.
+func (r *Runtime) stopReplicators() {
+	r.replicatorsLock.Lock()
+	defer r.replicatorsLock.Unlock()
+
+	r.replicators = nil
+}
+
+func (rp *replicator) enqueue(item *replicateItem) {
+	var dropped []*replicateItem
+
+	rp.lock.Lock()
+	if rp.stopped {
+		rp.lock.Unlock()
+		item.tracker.setResult(rp.node, kt.ErrStopped)
+		return
+	}
+	rp.pending = append(rp.pending, item)
+	if over := len(rp.pending) - rp.r.maxReplicationLag; rp.r.maxReplicationLag > 0 && over > 0 {
+		dropped = append(dropped, rp.pending[:over]...)
+		rp.pending = append(rp.pending[:0], rp.pending[over:]...)
+	}
+	rp.lock.Unlock()
+
+	if len(dropped) > 0 {
+		log.WithFields(log.Fields{
+			"instance": rp.r.instanceID,
+			"node":     rp.node,
+			"dropped":  len(dropped),
+		}).Warning("follower lags too much, drop pending logs")
+	}
+	for _, d := range dropped {
+		d.tracker.setResult(rp.node, kt.ErrFollowerLagging)
+	}
+
+	rp.notify()
+}
+
+func (rp *replicator) notify() {
+	select {
+	case rp.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (rp *replicator) run() {
+	if pcaller, ok := rp.caller.(*rpc.PersistentCaller); ok && pcaller != nil {
+		defer pcaller.Close()
+	}
+
+	for {
+		select {
+		case <-rp.r.stopCh:
+			rp.stop()
+			return
+		case <-rp.stopCh:
+			rp.stop()
+			return
+		case <-rp.notifyCh:
+		}
+
+		for {
+			batch := rp.next()
+			if len(batch) == 0 {
+				break
+			}
+			go rp.send(batch)
+		}
+	}
+}
+
+// next takes the next batch of pending logs if the in-flight window is not full.
+func (rp *replicator) next() (batch []*replicateItem) {
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+
+	window := rp.r.maxInflightBatches
+	if rp.probing {
+		window = 1
+	}
+	if rp.stopped || len(rp.pending) == 0 || rp.inflight >= window {
+		return
+	}
+
+	size := 0
+	for _, item := range rp.pending {
+		size += len(item.log.Data)
+		if len(batch) > 0 && size > rp.r.maxBatchSize {
+			break
+		}
+		batch = append(batch, item)
+	}
+	rp.pending = append(rp.pending[:0], rp.pending[len(batch):]...)
+	rp.inflight++
+
+	return
+}
+
+func (rp *replicator) send(batch []*replicateItem) {
+	var (
+		req = &kt.ApplyBatchRequest{
+			Instance: rp.r.instanceID,
+			Logs:     make([]*kt.Log, len(batch)),
+		}
+		resp = &kt.ApplyBatchResponse{}
+	)
+	for i, item := range batch {
+		req.Logs[i] = item.log
+	}
+
+	err := rp.caller.Call(rp.r.applyBatchRPCMethod, req, resp)
+	if err == nil && len(resp.Errors) != len(batch) {
+		err = errors.Errorf("invalid batched apply response: %d results for %d logs",
+			len(resp.Errors), len(batch))
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"instance": rp.r.instanceID,
+			"node":     rp.node,
+			"logs":     len(batch),
+		}).WithError(err).Debug("send batched apply rpc failed")
+	}
+
+	for i, item := range batch {
+		itemErr := err
+		if itemErr == nil && resp.Errors[i] != "" {
+			itemErr = errors.New(resp.Errors[i])
+		}
+		item.tracker.setResult(rp.node, itemErr)
+	}
+
+	rp.lock.Lock()
+	rp.inflight--
+	rp.probing = err != nil
+	rp.lock.Unlock()
+
+	rp.notify()
+}
+
+// stop fails the pending logs, the in-flight batches are reported by their calls.
+func (rp *replicator) stop() {
+	rp.lock.Lock()
+	pending := rp.pending
+	rp.pending = nil
+	rp.stopped = true
+	rp.lock.Unlock()
+
+	for _, item := range pending {
+		item.tracker.setResult(rp.node, kt.ErrStopped)
+	}
+}
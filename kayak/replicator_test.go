@@ -0,0 +1,211 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+type fakeBatchCaller struct {
+	sync.Mutex
+	block       chan struct{}
+	fail        uint32
+	batches     [][]uint64
+	inflight    int32
+	maxInflight int32
+}
+
+func (c *fakeBatchCaller) Call(method string, req interface{}, resp interface{}) (err error) {
+	n := atomic.AddInt32(&c.inflight, 1)
+	defer atomic.AddInt32(&c.inflight, -1)
+
+	c.Lock()
+	if n > c.maxInflight {
+		c.maxInflight = n
+	}
+	block := c.block
+	c.Unlock()
+
+	if block != nil {
+		<-block
+	}
+
+	if method != "Test.ApplyBatch" {
+		return errors.New("unknown method")
+	}
+	if atomic.LoadUint32(&c.fail) == 1 {
+		return errors.New("network unreachable")
+	}
+
+	var (
+		logs    = req.(*kt.ApplyBatchRequest).Logs
+		indexes = make([]uint64, len(logs))
+		r       = resp.(*kt.ApplyBatchResponse)
+	)
+	r.Errors = make([]string, len(logs))
+	for i, l := range logs {
+		indexes[i] = l.Index
+		if l.Index%10 == 9 {
+			r.Errors[i] = "apply failed"
+		}
+	}
+
+	c.Lock()
+	c.batches = append(c.batches, indexes)
+	c.Unlock()
+
+	return
+}
+
+func TestReplicator(t *testing.T) {
+	Convey("Given a runtime replicating logs to a follower by batches", t, func() {
+		var (
+			node1  = proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+			caller = &fakeBatchCaller{}
+			r      = &Runtime{
+				applyBatchRPCMethod: "Test.ApplyBatch",
+				followers:           []proto.NodeID{node1},
+				maxBatchSize:        10,
+				maxInflightBatches:  2,
+				replicators:         make(map[proto.NodeID]*replicator),
+				stopCh:              make(chan struct{}),
+				TrackerNewCallerFunc: func(proto.NodeID) Caller {
+					return caller
+				},
+			}
+			replicate = func(begin, end uint64) (trackers []*rpcTracker) {
+				for i := begin; i < end; i++ {
+					t := newTracker(r, &kt.ApplyRequest{
+						Log: &kt.Log{
+							LogHeader: kt.LogHeader{Index: i},
+							Data:      []byte("data"),
+						},
+					}, 1)
+					t.replicate()
+					trackers = append(trackers, t)
+				}
+				return
+			}
+			result = func(t *rpcTracker) error {
+				errs, meets, finished := t.get(context.Background())
+				So(meets, ShouldBeTrue)
+				So(finished, ShouldBeTrue)
+				return errs[node1]
+			}
+		)
+		defer func() {
+			close(r.stopCh)
+			r.stopReplicators()
+			r.wg.Wait()
+		}()
+
+		Convey("The logs should be sent in pipelined batches", func() {
+			caller.block = make(chan struct{})
+			trackers := replicate(0, 10)
+			time.Sleep(100 * time.Millisecond)
+			So(atomic.LoadInt32(&caller.inflight), ShouldEqual, 2)
+			close(caller.block)
+
+			for i, t := range trackers {
+				if i%10 == 9 {
+					So(result(t), ShouldNotBeNil)
+				} else {
+					So(result(t), ShouldBeNil)
+				}
+			}
+
+			var sent []uint64
+			for _, b := range caller.batches {
+				// each batch is limited to 2 logs by size
+				So(len(b), ShouldBeBetweenOrEqual, 1, 2)
+				sent = append(sent, b...)
+			}
+			So(sent, ShouldHaveLength, 10)
+			So(caller.maxInflight, ShouldEqual, 2)
+		})
+
+		Convey("The oldest pending logs should be dropped if follower lags too much", func() {
+			r.maxReplicationLag = 3
+			caller.block = make(chan struct{})
+			trackers := replicate(0, 10)
+			close(caller.block)
+
+			var sent, dropped int
+			for _, t := range trackers {
+				if err := result(t); err == kt.ErrFollowerLagging {
+					dropped++
+				} else {
+					sent++
+				}
+			}
+			So(dropped, ShouldBeGreaterThan, 0)
+			So(sent, ShouldBeGreaterThanOrEqualTo, 3)
+			So(sent+dropped, ShouldEqual, 10)
+		})
+
+		Convey("The in-flight window should shrink while follower is unreachable", func() {
+			atomic.StoreUint32(&caller.fail, 1)
+			for _, t := range replicate(0, 5) {
+				So(result(t), ShouldNotBeNil)
+			}
+			rp := r.getReplicator(node1)
+			rp.lock.Lock()
+			So(rp.probing, ShouldBeTrue)
+			rp.lock.Unlock()
+
+			atomic.StoreUint32(&caller.fail, 0)
+			for _, t := range replicate(10, 15) {
+				So(result(t), ShouldBeNil)
+			}
+			rp.lock.Lock()
+			So(rp.probing, ShouldBeFalse)
+			rp.lock.Unlock()
+		})
+
+		Convey("The logs should not be replicated to removed followers or after stop", func() {
+			for _, t := range replicate(0, 3) {
+				So(result(t), ShouldBeNil)
+			}
+			rp := r.getReplicator(node1)
+			r.retainReplicators(nil)
+			So(r.replicators, ShouldBeEmpty)
+			time.Sleep(10 * time.Millisecond)
+			removed := newTracker(r, &kt.ApplyRequest{Log: &kt.Log{}}, 1)
+			removed.wg.Add(1)
+			rp.enqueue(&replicateItem{log: &kt.Log{}, tracker: removed})
+			So(result(removed), ShouldEqual, kt.ErrStopped)
+
+			close(r.stopCh)
+			r.stopReplicators()
+			r.wg.Wait()
+			r.stopCh = make(chan struct{})
+			for _, t := range replicate(0, 3) {
+				So(result(t), ShouldEqual, kt.ErrStopped)
+			}
+		})
+	})
+}
@@ -47,7 +47,28 @@ func (r *Runtime) applyRPC(l *kt.Log, minCount int) (tracker *rpcTracker) {
 	}
 
 	tracker = newTracker(r, req, minCount)
-	tracker.send()
+	if r.applyBatchRPCMethod != "" {
+		tracker.replicate()
+	} else {
+		tracker.send()
+	}
+
+	// learners are replicated without affecting the result
+	if len(r.learners) > 0 {
+		lt := newNodesTracker(r, r.learners, req, 0)
+		if l.Type == kt.LogCommit {
+			lt.onResult = func(node proto.NodeID, err error) {
+				if err == nil {
+					r.setLearnerProgress(node, l.Index)
+				}
+			}
+		}
+		if r.applyBatchRPCMethod != "" {
+			lt.replicate()
+		} else {
+			lt.send()
+		}
+	}
 
 	// TODO(): track this rpc
 
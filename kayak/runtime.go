@@ -25,6 +25,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -35,6 +36,12 @@ import (
 const (
 	// commit channel window size
 	commitWindow = 0
+	// default max total log data size of a batched apply request.
+	defaultMaxBatchSize = 1 << 20
+	// default max in-flight batched apply requests of each follower.
+	defaultMaxInflightBatches = 4
+	// max age of the last leader contact to bound follower reads without requesting the leader.
+	maxLeaderContactAge = time.Second
 )
 
 // Runtime defines the main kayak Runtime.
@@ -45,8 +52,8 @@ type Runtime struct {
 	nextIndex     uint64
 	// lastCommit, last commit log index
 	lastCommit uint64
-	// pendingPrepares, prepares needs to be committed/rollback
-	pendingPrepares     map[uint64]bool
+	// pendingPrepares, prepares needs to be committed/rollback with their arrival time
+	pendingPrepares     map[uint64]time.Time
 	pendingPreparesLock sync.RWMutex
 
 	/// Runtime entities
@@ -67,6 +74,10 @@ type Runtime struct {
 	role proto.ServerRole
 	// cached followers in peers, calculated from peers info.
 	followers []proto.NodeID
+	// cached learners in peers, the non-voting followers.
+	learners []proto.NodeID
+	// current node is a learner.
+	learner bool
 	// peers lock for peers update logic.
 	peersLock sync.RWMutex
 	// calculated min follower nodes for prepare.
@@ -82,8 +93,20 @@ type Runtime struct {
 	serviceName string
 	// rpc method for apply requests.
 	applyRPCMethod string
+	// rpc method for batched apply requests, empty to replicate by single apply requests.
+	applyBatchRPCMethod string
 	// rpc method for startFetch requests.
 	fetchRPCMethod string
+	// rpc method for state sync requests.
+	syncRPCMethod string
+	// rpc method for snapshot requests.
+	snapshotRPCMethod string
+	// rpc method for read index requests.
+	readIndexRPCMethod string
+	// rpc method for leadership heartbeat requests.
+	heartbeatRPCMethod string
+	// rpc method for vote requests.
+	voteRPCMethod string
 
 	//// Parameters
 	// prepare threshold defines the minimum node count requirement for prepare operation.
@@ -96,9 +119,84 @@ type Runtime struct {
 	commitTimeout time.Duration
 	// log wait timeout to startFetch missing logs.
 	logWaitTimeout time.Duration
+	// min missing log count to catch up by state sync, 0 to disable.
+	syncThreshold uint64
+	// state sync or snapshot install is running.
+	syncing uint32
+	// min log count between log compactions, 0 to disable.
+	snapshotInterval uint64
+	// checkpoint to compact logs before at next compaction.
+	compactPoint *kt.Log
+	// latest checkpoint the logs before are compacted or missing.
+	checkpoint     *kt.Log
+	checkpointLock sync.RWMutex
 	// channel for awaiting commits.
 	commitCh   chan *commitReq
 	waitLogMap sync.Map // map[uint64]*waitItem
+	// closed and renewed on each last commit update.
+	commitNotify     chan struct{}
+	commitNotifyLock sync.Mutex
+
+	/// Replication
+	// max total log data size of a batched apply request.
+	maxBatchSize int
+	// max in-flight batched apply requests of each follower.
+	maxInflightBatches int
+	// max pending log count of each follower, 0 for unlimited.
+	maxReplicationLag int
+	// replicators of followers, nil after shutdown.
+	replicators     map[proto.NodeID]*replicator
+	replicatorsLock sync.Mutex
+
+	/// Learners
+	// last commit log index acknowledged by each learner, as leader.
+	learnerProgress     map[proto.NodeID]uint64
+	learnerProgressLock sync.Mutex
+
+	/// Read leases
+	// lease duration of linearizable reads, 0 to confirm leadership on every read.
+	leaseDuration time.Duration
+	leaseLock     sync.Mutex
+	// leader lease confirmed by quorum followers, as leader.
+	leaderLease time.Time
+	// read leases granted to followers, as leader.
+	readLeases map[proto.NodeID]time.Time
+	// read lease granted by leader, as follower.
+	readLease time.Time
+	// leader confirmed by heartbeat and its lease, as follower.
+	grantedLeader proto.NodeID
+	grantedLease  time.Time
+
+	/// Staleness
+	stalenessLock sync.Mutex
+	// last commit index advertised by leader and the last time leader is heard from, as follower.
+	leaderCommit  uint64
+	leaderContact time.Time
+	// the local state includes all the leader commits made before caughtUp, as follower.
+	caughtUp time.Time
+	// time the Runtime is started.
+	startTime time.Time
+
+	/// Leader election
+	// base election timeout, 0 to disable leader election.
+	electionTimeout time.Duration
+	// private key to sign the peers of elected leader.
+	privateKey *asymmetric.PrivateKey
+	// callback on leader changes by election.
+	onLeaderChange func(peers *proto.Peers)
+	electionLock   sync.Mutex
+	// current term, ahead of the peers term during election.
+	term uint64
+	// candidate voted for in current term.
+	votedFor proto.NodeID
+	// durable storage of term and vote, provided by wal.
+	voteStore kt.VoteStore
+	// last time the leader is heard from as follower, or quorum followers are reached as leader.
+	lastContact time.Time
+	// randomized deadline to start election as follower.
+	electionDeadline time.Time
+	// last time each follower responded to heartbeat, as leader.
+	followerContacts map[proto.NodeID]time.Time
 
 	/// Sub-routines management.
 	started uint32
@@ -115,6 +213,8 @@ type commitReq struct {
 	log        *kt.Log
 	result     *commitFuture
 	tm         *timer.Timer
+	// barrier is run in commit cycle instead of a commit if set.
+	barrier func()
 }
 
 // commitResult defines the commit operation result.
@@ -172,43 +272,9 @@ func NewRuntime(cfg *kt.RuntimeConfig) (rt *Runtime, err error) {
 		return
 	}
 
-	// verify peers
-	if err = peers.Verify(); err != nil {
-		err = errors.Wrap(err, "verify peers during kayak init failed")
-		return
-	}
-
-	followers := make([]proto.NodeID, 0, len(peers.Servers))
-	exists := false
-	var role proto.ServerRole
-
-	for _, v := range peers.Servers {
-		if !v.IsEqual(&peers.Leader) {
-			followers = append(followers, v)
-		}
-
-		if v.IsEqual(&cfg.NodeID) {
-			exists = true
-			if v.IsEqual(&peers.Leader) {
-				role = proto.Leader
-			} else {
-				role = proto.Follower
-			}
-		}
-	}
-
-	if !exists {
-		err = errors.Wrapf(kt.ErrNotInPeer, "node %v not in peers %v", cfg.NodeID, peers)
-		return
-	}
-
-	// calculate fan-out count according to threshold and peers info
-	minPreparedFollowers := int(math.Max(math.Ceil(cfg.PrepareThreshold*float64(len(peers.Servers))), 1) - 1)
-	minCommitFollowers := int(math.Max(math.Ceil(cfg.CommitThreshold*float64(len(peers.Servers))), 1) - 1)
-
 	rt = &Runtime{
 		// indexes
-		pendingPrepares: make(map[uint64]bool, commitWindow*2),
+		pendingPrepares: make(map[uint64]time.Time, commitWindow*2),
 
 		// handler and logs
 		sh:         cfg.Handler,
@@ -216,12 +282,7 @@ func NewRuntime(cfg *kt.RuntimeConfig) (rt *Runtime, err error) {
 		instanceID: cfg.InstanceID,
 
 		// peers
-		peers:                cfg.Peers,
-		nodeID:               cfg.NodeID,
-		followers:            followers,
-		role:                 role,
-		minPreparedFollowers: minPreparedFollowers,
-		minCommitFollowers:   minCommitFollowers,
+		nodeID: cfg.NodeID,
 
 		// rpc related
 		TrackerNewCallerFunc: defaultNewCallerFunc,
@@ -229,6 +290,11 @@ func NewRuntime(cfg *kt.RuntimeConfig) (rt *Runtime, err error) {
 		serviceName:          cfg.ServiceName,
 		applyRPCMethod:       cfg.ServiceName + "." + cfg.ApplyMethodName,
 		fetchRPCMethod:       cfg.ServiceName + "." + cfg.FetchMethodName,
+		syncRPCMethod:        cfg.ServiceName + "." + cfg.SyncMethodName,
+		snapshotRPCMethod:    cfg.ServiceName + "." + cfg.SnapshotMethodName,
+		readIndexRPCMethod:   cfg.ServiceName + "." + cfg.ReadIndexMethodName,
+		heartbeatRPCMethod:   cfg.ServiceName + "." + cfg.HeartbeatMethodName,
+		voteRPCMethod:        cfg.ServiceName + "." + cfg.VoteMethodName,
 
 		// commits related
 		prepareThreshold: cfg.PrepareThreshold,
@@ -236,12 +302,69 @@ func NewRuntime(cfg *kt.RuntimeConfig) (rt *Runtime, err error) {
 		commitThreshold:  cfg.CommitThreshold,
 		commitTimeout:    cfg.CommitTimeout,
 		logWaitTimeout:   cfg.LogWaitTimeout,
+		syncThreshold:    cfg.SyncThreshold,
+		snapshotInterval: cfg.SnapshotInterval,
 		commitCh:         make(chan *commitReq, commitWindow),
+		commitNotify:     make(chan struct{}),
+
+		// replication
+		maxBatchSize:       cfg.MaxBatchSize,
+		maxInflightBatches: cfg.MaxInflightBatches,
+		maxReplicationLag:  cfg.MaxReplicationLag,
+		replicators:        make(map[proto.NodeID]*replicator),
+
+		// learners
+		learnerProgress: make(map[proto.NodeID]uint64),
+
+		// read leases
+		leaseDuration: cfg.LeaseDuration,
+		readLeases:    make(map[proto.NodeID]time.Time),
+
+		// leader election
+		electionTimeout:  cfg.ElectionTimeout,
+		privateKey:       cfg.PrivateKey,
+		onLeaderChange:   cfg.OnLeaderChange,
+		followerContacts: make(map[proto.NodeID]time.Time),
 
 		// stop coordinator
 		stopCh: make(chan struct{}),
 	}
 
+	if cfg.ApplyBatchMethodName != "" {
+		rt.applyBatchRPCMethod = cfg.ServiceName + "." + cfg.ApplyBatchMethodName
+	}
+	if rt.maxBatchSize <= 0 {
+		rt.maxBatchSize = defaultMaxBatchSize
+	}
+	if rt.maxInflightBatches <= 0 {
+		rt.maxInflightBatches = defaultMaxInflightBatches
+	}
+	if rt.electionTimeout > 0 && (cfg.VoteMethodName == "" || cfg.HeartbeatMethodName == "") {
+		err = errors.Wrap(kt.ErrInvalidConfig, "vote and heartbeat methods are required by leader election")
+		return
+	}
+	if rt.electionTimeout > 0 && cfg.PrivateKey == nil {
+		err = errors.Wrap(kt.ErrInvalidConfig, "private key is required by leader election")
+		return
+	}
+	if rt.electionTimeout > 0 {
+		var ok bool
+		if rt.voteStore, ok = cfg.Wal.(kt.VoteStore); !ok {
+			err = errors.Wrap(kt.ErrInvalidConfig, "wal does not persist vote state required by leader election")
+			return
+		}
+	}
+
+	// restore term and vote of last run, before the term of peers is persisted
+	if err = rt.restoreVote(); err != nil {
+		return
+	}
+
+	// calculate role and followers from peers info
+	if err = rt.applyPeers(peers); err != nil {
+		return
+	}
+
 	// read from pool to rebuild uncommitted log map
 	if err = rt.readLogs(); err != nil {
 		return
@@ -256,9 +379,24 @@ func (r *Runtime) Start() (err error) {
 		return
 	}
 
+	r.stalenessLock.Lock()
+	r.startTime = time.Now()
+	r.stalenessLock.Unlock()
+
 	// start commit cycle
 	r.goFunc(r.commitCycle)
 
+	// start election cycle
+	if r.electionTimeout > 0 {
+		// the assigned leader is trusted in the first election timeout
+		r.electionLock.Lock()
+		now := time.Now()
+		r.lastContact = now
+		r.resetElectionTimer(now)
+		r.electionLock.Unlock()
+		r.goFunc(r.electionCycle)
+	}
+
 	return
 }
 
@@ -273,6 +411,7 @@ func (r *Runtime) Shutdown() (err error) {
 	default:
 		close(r.stopCh)
 	}
+	r.stopReplicators()
 	r.wg.Wait()
 
 	return
@@ -356,7 +495,14 @@ func (r *Runtime) Fetch(ctx context.Context, index uint64) (l *kt.Log, err error
 	}
 
 	// wal get
-	return r.wal.Get(index)
+	if l, err = r.wal.Get(index); err != nil {
+		// compacted logs are answered by the checkpoint to notify follower to install snapshot
+		if cp := r.getCheckpoint(); cp != nil && index < cp.Index {
+			l, err = cp, nil
+		}
+	}
+
+	return
 }
 
 // FollowerApply defines entry for follower node.
@@ -364,11 +510,134 @@ func (r *Runtime) FollowerApply(l *kt.Log) (err error) {
 	return r.followerApply(l, true)
 }
 
+// FollowerApplyBatch defines entry for follower node to apply the batched logs in order, the apply
+// error of each log is returned respectively.
+func (r *Runtime) FollowerApplyBatch(logs []*kt.Log) (errs []error) {
+	errs = make([]error, len(logs))
+	for i, l := range logs {
+		errs[i] = r.followerApply(l, true)
+	}
+	return
+}
+
 // UpdatePeers defines entry for peers update logic.
 func (r *Runtime) UpdatePeers(peers *proto.Peers) (err error) {
 	r.peersLock.Lock()
 	defer r.peersLock.Unlock()
 
+	return r.applyPeers(peers)
+}
+
+// Peers returns the current peers of the Runtime.
+func (r *Runtime) Peers() *proto.Peers {
+	r.peersLock.RLock()
+	defer r.peersLock.RUnlock()
+
+	return r.peers
+}
+
+// LastCommit returns the index of the last committed log of the Runtime.
+func (r *Runtime) LastCommit() uint64 {
+	return atomic.LoadUint64(&r.lastCommit)
+}
+
+// applyPeers verifies peers and calculates the role and followers of current node from it.
+func (r *Runtime) applyPeers(peers *proto.Peers) (err error) {
+	if peers == nil {
+		err = errors.Wrap(kt.ErrInvalidConfig, "nil peers")
+		return
+	}
+
+	// verify peers
+	if err = peers.Verify(); err != nil {
+		err = errors.Wrap(err, "verify peers failed")
+		return
+	}
+
+	if r.electionTimeout > 0 && r.peers != nil && peers.Term < r.peers.Term {
+		// elected leadership is kept against the assigned one of earlier term
+		merged := peers.Clone()
+		merged.Term = r.peers.Term
+		merged.Leader = ""
+		if _, found := peers.Find(r.peers.Leader); found {
+			merged.Leader = r.peers.Leader
+		}
+		peers = &merged
+	}
+
+	return r.setPeers(peers)
+}
+
+// setPeers calculates the role and followers of current node from the verified or elected peers.
+func (r *Runtime) setPeers(peers *proto.Peers) (err error) {
+	followers := make([]proto.NodeID, 0, len(peers.Servers))
+	exists := false
+	var role proto.ServerRole
+	var learner bool
+
+	for _, v := range peers.Servers {
+		if !v.IsEqual(&peers.Leader) {
+			followers = append(followers, v)
+		}
+
+		if v.IsEqual(&r.nodeID) {
+			exists = true
+			if v.IsEqual(&peers.Leader) {
+				role = proto.Leader
+			} else {
+				role = proto.Follower
+			}
+		}
+	}
+
+	for _, v := range peers.Learners {
+		if _, found := peers.Find(v); found || v.IsEqual(&peers.Leader) {
+			err = errors.Wrapf(kt.ErrInvalidConfig, "learner %v is a server of peers", v)
+			return
+		}
+
+		if v.IsEqual(&r.nodeID) {
+			exists = true
+			role = proto.Follower
+			learner = true
+		}
+	}
+
+	if !exists {
+		err = errors.Wrapf(kt.ErrNotInPeer, "node %v not in peers %v", r.nodeID, peers)
+		return
+	}
+
+	// newer term of peers is persisted before it takes effect
+	r.electionLock.Lock()
+	if peers.Term > r.term {
+		if r.voteStore != nil {
+			if err = r.persistVote(peers.Term, ""); err != nil {
+				r.electionLock.Unlock()
+				return
+			}
+		}
+		r.term = peers.Term
+		r.votedFor = ""
+	}
+	r.electionLock.Unlock()
+
+	// calculate fan-out count according to threshold and peers info
+	r.minPreparedFollowers = int(math.Max(math.Ceil(r.prepareThreshold*float64(len(peers.Servers))), 1) - 1)
+	r.minCommitFollowers = int(math.Max(math.Ceil(r.commitThreshold*float64(len(peers.Servers))), 1) - 1)
+	r.peers = peers
+	r.followers = followers
+	r.learners = append([]proto.NodeID(nil), peers.Learners...)
+	r.learner = learner
+	r.role = role
+
+	// leases are bound to previous peers
+	r.resetReadLeases()
+
+	// stop replication to the removed followers and learners
+	r.retainReplicators(append(append([]proto.NodeID(nil), followers...), peers.Learners...))
+	r.retainLearnerProgress(peers.Learners)
+
 	return
 }
 
@@ -389,7 +658,8 @@ func (r *Runtime) checkIfPrepareFinished(ctx context.Context, index uint64) (fin
 	r.pendingPreparesLock.RLock()
 	defer r.pendingPreparesLock.RUnlock()
 
-	return !r.pendingPrepares[index]
+	_, pending := r.pendingPrepares[index]
+	return !pending
 }
 
 func (r *Runtime) markPendingPrepare(ctx context.Context, index uint64) {
@@ -398,7 +668,7 @@ func (r *Runtime) markPendingPrepare(ctx context.Context, index uint64) {
 	r.pendingPreparesLock.Lock()
 	defer r.pendingPreparesLock.Unlock()
 
-	r.pendingPrepares[index] = true
+	r.pendingPrepares[index] = time.Now()
 }
 
 func (r *Runtime) markPrepareFinished(ctx context.Context, index uint64) {
@@ -450,6 +720,14 @@ func (r *Runtime) followerApply(l *kt.Log, checkPrepare bool) (err error) {
 		return
 	}
 
+	if err = r.checkElectedLeader(l); err != nil {
+		return
+	}
+
+	if err = r.checkGrantedLeader(l); err != nil {
+		return
+	}
+
 	// verify log structure
 	switch l.Type {
 	case kt.LogPrepare:
@@ -463,6 +741,12 @@ func (r *Runtime) followerApply(l *kt.Log, checkPrepare bool) (err error) {
 	if err == nil {
 		r.updateNextIndex(ctx, l)
 		r.triggerLogAwaits(l)
+		// the leader commit is at least the commit log index
+		var leaderCommit uint64
+		if l.Type == kt.LogCommit {
+			leaderCommit = l.Index
+		}
+		r.observeLeaderCommit(leaderCommit, time.Now())
 	}
 
 	return
@@ -26,6 +26,7 @@ import (
 	"net"
 	"net/rpc"
 	"os"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -152,6 +153,8 @@ func (m *fakeMux) get(nodeID proto.NodeID) *fakeService {
 type fakeService struct {
 	rt *kayak.Runtime
 	s  *rpc.Server
+	// requester node of read index calls
+	requester proto.NodeID
 }
 
 func newFakeService(rt *kayak.Runtime) (fs *fakeService) {
@@ -170,6 +173,17 @@ func (s *fakeService) Apply(req *kt.ApplyRequest, resp *interface{}) (err error)
 	return s.rt.FollowerApply(req.Log)
 }
 
+func (s *fakeService) ApplyBatch(req *kt.ApplyBatchRequest, resp *kt.ApplyBatchResponse) (err error) {
+	errs := s.rt.FollowerApplyBatch(req.Logs)
+	resp.Errors = make([]string, len(errs))
+	for i, e := range errs {
+		if e != nil {
+			resp.Errors[i] = e.Error()
+		}
+	}
+	return
+}
+
 func (s *fakeService) Fetch(req *kt.FetchRequest, resp *kt.FetchResponse) (err error) {
 	var l *kt.Log
 	if l, err = s.rt.Fetch(req.GetContext(), req.Index); err != nil {
@@ -180,6 +194,45 @@ func (s *fakeService) Fetch(req *kt.FetchRequest, resp *kt.FetchResponse) (err e
 	return
 }
 
+func (s *fakeService) Sync(req *kt.SyncRequest, resp *kt.SyncResponse) (err error) {
+	var r *kt.SyncResponse
+	if r, err = s.rt.Sync(req.GetContext(), req.Digest); err != nil {
+		return
+	}
+
+	*resp = *r
+	return
+}
+
+func (s *fakeService) Snapshot(req *kt.SnapshotRequest, resp *kt.SnapshotResponse) (err error) {
+	var r *kt.SnapshotResponse
+	if r, err = s.rt.Snapshot(req.GetContext()); err != nil {
+		return
+	}
+
+	*resp = *r
+	return
+}
+
+func (s *fakeService) ReadIndex(req *kt.ReadIndexRequest, resp *kt.ReadIndexResponse) (err error) {
+	resp.Index, resp.Lease, err = s.rt.ReadIndex(req.GetContext(), s.requester)
+	return
+}
+
+func (s *fakeService) Heartbeat(req *kt.HeartbeatRequest, resp *interface{}) (err error) {
+	return s.rt.Heartbeat(req.Leader, req.Term, req.LastCommit)
+}
+
+func (s *fakeService) RequestVote(req *kt.VoteRequest, resp *kt.VoteResponse) (err error) {
+	var r *kt.VoteResponse
+	if r, err = s.rt.RequestVote(req); err != nil {
+		return
+	}
+
+	*resp = *r
+	return
+}
+
 func (s *fakeService) serveConn(c net.Conn) {
 	var r proto.NodeID
 	s.s.ServeCodec(crpc.NewNodeAwareServerCodec(context.Background(), utils.GetMsgPackServerCodec(c), r.ToRawNodeID()))
@@ -551,6 +604,161 @@ func TestRuntime(t *testing.T) {
 	})
 }
 
+func TestRuntime_UpdatePeers(t *testing.T) {
+	Convey("update peers", t, func() {
+		node1 := proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+		node2 := proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+		node3 := proto.NodeID("00000f3b43288fe99831eb533ab77ec455d13e11fc38ec35a42d4edd17aa320d")
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		newPeers := func(leader proto.NodeID, servers ...proto.NodeID) *proto.Peers {
+			peers := &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  leader,
+					Servers: servers,
+				},
+			}
+			So(peers.Sign(privKey), ShouldBeNil)
+			return peers
+		}
+
+		db, err := newSQLiteStorage("test_update_peers.db")
+		So(err, ShouldBeNil)
+		defer func() {
+			db.Close()
+			os.Remove("test_update_peers.db")
+		}()
+		wal := kl.NewMemWal()
+		defer wal.Close()
+		rt, err := kayak.NewRuntime(&kt.RuntimeConfig{
+			Handler:          db,
+			PrepareThreshold: 1.0,
+			CommitThreshold:  1.0,
+			PrepareTimeout:   time.Second,
+			CommitTimeout:    10 * time.Second,
+			LogWaitTimeout:   10 * time.Second,
+			Peers:            newPeers(node1, node1, node2),
+			Wal:              wal,
+			NodeID:           node2,
+			ServiceName:      "Test",
+			ApplyMethodName:  "Apply",
+		})
+		So(err, ShouldBeNil)
+		So(rt.Peers().Leader, ShouldEqual, node1)
+
+		// node1 is replaced by node3, and node2 takes over the leader
+		peers := newPeers(node2, node2, node3)
+		So(rt.UpdatePeers(peers), ShouldBeNil)
+		So(rt.Peers(), ShouldEqual, peers)
+
+		// current node is not in peers
+		err = rt.UpdatePeers(newPeers(node1, node1, node3))
+		So(errors.Cause(err), ShouldEqual, kt.ErrNotInPeer)
+		So(rt.Peers(), ShouldEqual, peers)
+	})
+}
+
+func TestRuntime_ApplyBatch(t *testing.T) {
+	Convey("pipelined and batched replication", t, func() {
+		node1 := proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+		node2 := proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Leader:  node1,
+				Servers: []proto.NodeID{node1, node2},
+			},
+		}
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		So(peers.Sign(privKey), ShouldBeNil)
+
+		db1, db2 := newKVStorage(), newKVStorage()
+		wal1, wal2 := kl.NewMemWal(), kl.NewMemWal()
+		defer wal1.Close()
+		defer wal2.Close()
+		newCfg := func(h kt.Handler, w kt.Wal, nodeID proto.NodeID) *kt.RuntimeConfig {
+			return &kt.RuntimeConfig{
+				Handler:              h,
+				PrepareThreshold:     1.0,
+				CommitThreshold:      1.0,
+				PrepareTimeout:       time.Second,
+				CommitTimeout:        10 * time.Second,
+				LogWaitTimeout:       10 * time.Second,
+				Peers:                peers,
+				Wal:                  w,
+				NodeID:               nodeID,
+				ServiceName:          "Test",
+				ApplyMethodName:      "Apply",
+				ApplyBatchMethodName: "ApplyBatch",
+				FetchMethodName:      "Fetch",
+				MaxBatchSize:         1024,
+				MaxInflightBatches:   2,
+			}
+		}
+
+		rt1, err := kayak.NewRuntime(newCfg(db1, wal1, node1))
+		So(err, ShouldBeNil)
+		rt2, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+		So(err, ShouldBeNil)
+
+		m := newFakeMux()
+		m.register(node1, newFakeService(rt1))
+		m.register(node2, newFakeService(rt2))
+		rt1.TrackerNewCallerFunc = func(target proto.NodeID) kayak.Caller {
+			return newFakeCaller(m, target)
+		}
+		rt2.WaiterNewCallerFunc = func(target proto.NodeID) kayak.Caller {
+			return newFakeCaller(m, target)
+		}
+
+		So(rt1.Start(), ShouldBeNil)
+		defer rt1.Shutdown()
+		So(rt2.Start(), ShouldBeNil)
+		defer rt2.Shutdown()
+
+		var (
+			wg   sync.WaitGroup
+			errs = make(chan error, 100)
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					_, _, err := rt1.Apply(context.Background(), &kvPair{
+						Key:   RandStringRunes(8),
+						Value: RandStringRunes(16),
+					})
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+
+		So(rt2.LastCommit(), ShouldEqual, rt1.LastCommit())
+		So(db2.snapshot(), ShouldResemble, db1.snapshot())
+		So(db1.snapshot(), ShouldHaveLength, 100)
+
+		// followers removed from peers are no longer replicated
+		single := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Leader:  node1,
+				Servers: []proto.NodeID{node1},
+			},
+		}
+		So(single.Sign(privKey), ShouldBeNil)
+		So(rt1.UpdatePeers(single), ShouldBeNil)
+		_, _, err = rt1.Apply(context.Background(), &kvPair{Key: "k", Value: "v"})
+		So(err, ShouldBeNil)
+		So(db2.snapshot(), ShouldNotContainKey, "k")
+	})
+}
+
 func BenchmarkRuntime(b *testing.B) {
 	Convey("runtime test", b, func(c C) {
 		log.SetLevel(log.FatalLevel)
@@ -0,0 +1,271 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Snapshot defines entry for snapshot requests of the peers lagging behind the compacted logs. The
+// snapshot is built in the commit cycle, so it represents exactly the state at the returned last
+// commit index.
+func (r *Runtime) Snapshot(ctx context.Context) (resp *kt.SnapshotResponse, err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+
+	snapshotter, ok := r.sh.(kt.Snapshotter)
+	if !ok {
+		err = kt.ErrSnapshotNotSupported
+		return
+	}
+
+	var snapErr error
+	if err = r.runInCommitCycle(ctx, func() {
+		resp = &kt.SnapshotResponse{
+			Instance:   r.instanceID,
+			LastCommit: atomic.LoadUint64(&r.lastCommit),
+		}
+		if resp.Prepares, snapErr = r.pendingPrepareLogs(resp.LastCommit); snapErr != nil {
+			return
+		}
+		resp.Snapshot, snapErr = snapshotter.Snapshot()
+	}); err == nil {
+		err = snapErr
+	}
+	if err != nil {
+		resp = nil
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"instance": r.instanceID,
+		"commit":   resp.LastCommit,
+		"prepares": len(resp.Prepares),
+		"size":     len(resp.Snapshot),
+	}).Debug("kayak serve snapshot")
+
+	return
+}
+
+// triggerSnapshot starts catching up with the leader by installing its snapshot, the state sync
+// is used instead if snapshot is not supported by the underlying handler.
+func (r *Runtime) triggerSnapshot() {
+	if _, ok := r.sh.(kt.Snapshotter); !ok {
+		r.triggerSync()
+		return
+	}
+
+	r.startCatchUp("snapshot install", r.doSnapshot)
+}
+
+func (r *Runtime) doSnapshot() (err error) {
+	var (
+		snapshotter = r.sh.(kt.Snapshotter)
+		req         = &kt.SnapshotRequest{Instance: r.instanceID}
+		resp        = &kt.SnapshotResponse{}
+		ctx         = context.Background()
+		aErr        error
+	)
+
+	caller := r.WaiterNewCallerFunc(r.Peers().Leader)
+	if pcaller, ok := caller.(*rpc.PersistentCaller); ok && pcaller != nil {
+		defer pcaller.Close()
+	}
+	if err = caller.Call(r.snapshotRPCMethod, req, resp); err != nil {
+		err = errors.Wrap(err, "send snapshot rpc failed")
+		return
+	}
+
+	if err = r.runInCommitCycle(ctx, func() {
+		aErr = r.applySnapshot(ctx, snapshotter, resp)
+	}); err == nil {
+		err = aErr
+	}
+	return
+}
+
+// applySnapshot replaces the local state with the snapshot and persists a checkpoint log at the
+// snapshot commit index followed by the pending prepares.
+func (r *Runtime) applySnapshot(
+	ctx context.Context, snapshotter kt.Snapshotter, resp *kt.SnapshotResponse) (err error,
+) {
+	if resp.LastCommit <= atomic.LoadUint64(&r.lastCommit) {
+		// caught up already
+		return
+	}
+
+	var checkpoint *kt.Log
+	if checkpoint, err = r.newCheckpoint(resp.LastCommit, resp.Prepares); err != nil {
+		return
+	}
+
+	if err = snapshotter.InstallSnapshot(resp.Snapshot); err != nil {
+		err = errors.Wrap(err, "restore snapshot failed")
+		return
+	}
+
+	r.installCheckpoint(ctx, checkpoint, resp.Prepares)
+	return
+}
+
+// compactLogs compacts the logs before the previous compaction point into a checkpoint, which is
+// called in the commit cycle. The logs since the previous compaction point are kept for the
+// followers lagging less than an interval to fetch, the others need to install the snapshot.
+func (r *Runtime) compactLogs(ctx context.Context) {
+	if r.snapshotInterval == 0 {
+		return
+	}
+	if _, ok := r.sh.(kt.Snapshotter); !ok {
+		// the lagging followers could never catch up without snapshot
+		return
+	}
+	compactor, ok := r.wal.(kt.Compactor)
+	if !ok {
+		return
+	}
+
+	var (
+		lastCommit = atomic.LoadUint64(&r.lastCommit)
+		base       uint64
+		prepares   []*kt.Log
+		point      *kt.Log
+		err        error
+	)
+	if r.compactPoint != nil {
+		base = r.compactPoint.Index
+	} else if cp := r.getCheckpoint(); cp != nil {
+		base = cp.Index
+	}
+	if lastCommit < base+r.snapshotInterval {
+		return
+	}
+
+	le := log.WithFields(log.Fields{
+		"instance": r.instanceID,
+		"commit":   lastCommit,
+	})
+
+	if prepares, err = r.pendingPrepareLogs(lastCommit); err != nil {
+		le.WithError(err).Warning("collect pending prepares for log compaction failed")
+		return
+	}
+	if point, err = r.newCheckpoint(lastCommit, prepares); err != nil {
+		le.WithError(err).Warning("build log compaction checkpoint failed")
+		return
+	}
+
+	if prev := r.compactPoint; prev != nil {
+		if err = r.compactTo(compactor, prev); err != nil {
+			le.WithError(err).Warning("compact logs failed")
+			return
+		}
+		le.WithField("checkpoint", prev.Index).Info("kayak logs compacted")
+	}
+
+	r.compactPoint = point
+}
+
+// Compact compacts all the applied logs into a checkpoint at the last commit immediately, without
+// keeping the logs for lagging followers as the periodic compaction does. The checkpoint index is
+// returned.
+func (r *Runtime) Compact(ctx context.Context) (index uint64, err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+	if _, ok := r.sh.(kt.Snapshotter); !ok {
+		err = kt.ErrSnapshotNotSupported
+		return
+	}
+	compactor, ok := r.wal.(kt.Compactor)
+	if !ok {
+		err = errors.Wrap(kt.ErrSnapshotNotSupported, "wal does not support compaction")
+		return
+	}
+
+	var cErr error
+	if err = r.runInCommitCycle(ctx, func() {
+		var (
+			lastCommit = atomic.LoadUint64(&r.lastCommit)
+			prepares   []*kt.Log
+			point      *kt.Log
+		)
+		if cp := r.getCheckpoint(); cp != nil && cp.Index >= lastCommit {
+			// nothing applied since last checkpoint, compact to it as the logs before an
+			// installed snapshot checkpoint are kept
+			point = cp
+		} else if lastCommit == 0 {
+			return
+		} else {
+			if prepares, cErr = r.pendingPrepareLogs(lastCommit); cErr != nil {
+				return
+			}
+			if point, cErr = r.newCheckpoint(lastCommit, prepares); cErr != nil {
+				return
+			}
+		}
+		if cErr = r.compactTo(compactor, point); cErr != nil {
+			return
+		}
+		// the pending compaction point is covered
+		r.compactPoint = nil
+		index = point.Index
+	}); err == nil {
+		err = cErr
+	}
+
+	return
+}
+
+// compactTo replaces the logs before the checkpoint with it, the pending prepares recorded in the
+// checkpoint are kept.
+func (r *Runtime) compactTo(compactor kt.Compactor, point *kt.Log) (err error) {
+	var keep []uint64
+	if keep, err = r.checkpointPrepares(point); err != nil {
+		err = errors.Wrap(err, "decode log compaction checkpoint failed")
+		return
+	}
+	if err = compactor.Compact(point, keep); err != nil {
+		err = errors.Wrap(err, "compact logs failed")
+		return
+	}
+	r.setCheckpoint(point)
+	return
+}
+
+func (r *Runtime) getCheckpoint() *kt.Log {
+	r.checkpointLock.RLock()
+	defer r.checkpointLock.RUnlock()
+	return r.checkpoint
+}
+
+func (r *Runtime) setCheckpoint(l *kt.Log) {
+	r.checkpointLock.Lock()
+	defer r.checkpointLock.Unlock()
+	if r.checkpoint == nil || r.checkpoint.Index < l.Index {
+		r.checkpoint = l
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak_test
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	kl "github.com/CovenantSQL/CovenantSQL/kayak/wal"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestRuntimeSnapshot(t *testing.T) {
+	Convey("Given a leader compacting logs and a follower missing the compacted logs", t, func() {
+		var (
+			node1 = proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+			node2 = proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+			peers = &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  node1,
+					Servers: []proto.NodeID{node1, node2},
+				},
+			}
+			db1, db2 = newKVStorage(), newKVStorage()
+			wal1     = kl.NewMemWal()
+			newCfg   = func(h kt.Handler, w kt.Wal, nodeID proto.NodeID) *kt.RuntimeConfig {
+				return &kt.RuntimeConfig{
+					Handler:            h,
+					PrepareTimeout:     time.Second,
+					CommitTimeout:      time.Second,
+					LogWaitTimeout:     100 * time.Millisecond,
+					Peers:              peers,
+					Wal:                w,
+					NodeID:             nodeID,
+					ServiceName:        "Test",
+					ApplyMethodName:    "Apply",
+					FetchMethodName:    "Fetch",
+					SnapshotMethodName: "Snapshot",
+					SnapshotInterval:   10,
+				}
+			}
+		)
+		defer wal1.Close()
+		wal2, err := kl.NewLevelDBWal("testSnapshot.db")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll("testSnapshot.db")
+		defer wal2.Close()
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		err = peers.Sign(privKey)
+		So(err, ShouldBeNil)
+
+		rt1, err := kayak.NewRuntime(newCfg(db1, wal1, node1))
+		So(err, ShouldBeNil)
+		rt2, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+		So(err, ShouldBeNil)
+
+		m := newFakeMux()
+		m.register(node1, newFakeService(rt1))
+		m.register(node2, newFakeService(rt2))
+		toFollower := &switchCaller{Caller: newFakeCaller(m, node2)}
+		toLeader := newFakeCaller(m, node1)
+		rt1.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt1.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt2.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+		rt2.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+
+		So(rt1.Start(), ShouldBeNil)
+		defer rt1.Shutdown()
+		So(rt2.Start(), ShouldBeNil)
+		defer rt2.Shutdown()
+
+		apply := func() {
+			_, _, err := rt1.Apply(context.Background(), &kvPair{
+				Key:   RandStringRunes(8),
+				Value: RandStringRunes(16),
+			})
+			So(err, ShouldBeNil)
+		}
+		for i := 0; i < 50; i++ {
+			apply()
+		}
+		So(rt2.LastCommit(), ShouldEqual, 0)
+
+		// compacted logs are answered by checkpoint
+		_, err = wal1.Get(1)
+		So(err, ShouldNotBeNil)
+		l, err := rt1.Fetch(context.Background(), 1)
+		So(err, ShouldBeNil)
+		So(l.Type, ShouldEqual, kt.LogCheckpoint)
+		So(l.Index, ShouldBeGreaterThan, 1)
+
+		Convey("The follower should catch up by installing snapshot", func() {
+			atomic.StoreUint32(&toFollower.enabled, 1)
+			waitCaughtUp := func() {
+				var deadline = time.Now().Add(10 * time.Second)
+				for rt2.LastCommit() != rt1.LastCommit() && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				So(rt2.LastCommit(), ShouldEqual, rt1.LastCommit())
+				So(db2.snapshot(), ShouldResemble, db1.snapshot())
+			}
+
+			apply()
+			waitCaughtUp()
+			installed := rt2.LastCommit()
+			_, err = wal2.Get(installed)
+			So(err, ShouldBeNil)
+
+			// the follower compacts its own logs
+			for i := 0; i < 5; i++ {
+				apply()
+			}
+			waitCaughtUp()
+			index, err := rt2.Compact(context.Background())
+			So(err, ShouldBeNil)
+			So(index, ShouldEqual, rt2.LastCommit())
+			_, err = wal2.Get(installed + 1)
+			So(err, ShouldNotBeNil)
+			l, err = wal2.Get(index)
+			So(err, ShouldBeNil)
+			So(l.Type, ShouldEqual, kt.LogCheckpoint)
+
+			// compaction without new commits is a no-op
+			index2, err := rt2.Compact(context.Background())
+			So(err, ShouldBeNil)
+			So(index2, ShouldEqual, index)
+
+			// the compacted logs should be restored on restart
+			So(rt2.Shutdown(), ShouldBeNil)
+			wal2.Close()
+			wal2, err = kl.NewLevelDBWal("testSnapshot.db")
+			So(err, ShouldBeNil)
+			defer wal2.Close()
+			rt3, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+			So(err, ShouldBeNil)
+			So(rt3.LastCommit(), ShouldEqual, rt1.LastCommit())
+		})
+
+		Convey("The snapshot should not be served by stopped runtime", func() {
+			So(rt2.Shutdown(), ShouldBeNil)
+			_, err = rt2.Snapshot(context.Background())
+			So(err, ShouldEqual, kt.ErrStopped)
+			_, err = rt2.Compact(context.Background())
+			So(err, ShouldEqual, kt.ErrStopped)
+		})
+	})
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Sync defines entry for state sync requests of lagging peers. The delta is built in the commit
+// cycle, so it represents exactly the state at the returned last commit index.
+func (r *Runtime) Sync(ctx context.Context, digest []byte) (resp *kt.SyncResponse, err error) {
+	if atomic.LoadUint32(&r.started) != 1 {
+		err = kt.ErrStopped
+		return
+	}
+
+	syncer, ok := r.sh.(kt.StateSyncer)
+	if !ok {
+		err = kt.ErrSyncNotSupported
+		return
+	}
+
+	var syncErr error
+	if err = r.runInCommitCycle(ctx, func() {
+		resp = &kt.SyncResponse{
+			Instance:   r.instanceID,
+			LastCommit: atomic.LoadUint64(&r.lastCommit),
+		}
+		if resp.Prepares, syncErr = r.pendingPrepareLogs(resp.LastCommit); syncErr != nil {
+			return
+		}
+		resp.Delta, syncErr = syncer.Delta(digest)
+	}); err == nil {
+		err = syncErr
+	}
+	if err != nil {
+		resp = nil
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"instance": r.instanceID,
+		"commit":   resp.LastCommit,
+		"prepares": len(resp.Prepares),
+	}).Debug("kayak serve state sync")
+
+	return
+}
+
+// runInCommitCycle runs f in the commit cycle, no commit is processed while f is running.
+func (r *Runtime) runInCommitCycle(ctx context.Context, f func()) (err error) {
+	var (
+		done  = make(chan struct{})
+		state uint32 // 1 for started, 2 for canceled
+		req   = &commitReq{
+			ctx: ctx,
+			barrier: func() {
+				defer close(done)
+				if atomic.CompareAndSwapUint32(&state, 0, 1) {
+					f()
+				}
+			},
+		}
+	)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.stopCh:
+		return kt.ErrStopped
+	case r.commitCh <- req:
+	}
+
+	select {
+	case <-done:
+	case <-r.stopCh:
+		if atomic.CompareAndSwapUint32(&state, 0, 2) {
+			return kt.ErrStopped
+		}
+		// a started barrier is never interrupted
+		<-done
+	}
+	return
+}
+
+// pendingPrepareLogs returns the prepare logs which are neither committed nor rolled back at
+// last commit. The leader resolves a prepare after its commit returns, so the commit chain is
+// walked back to exclude the committed ones.
+func (r *Runtime) pendingPrepareLogs(lastCommit uint64) (logs []*kt.Log, err error) {
+	var (
+		pending = make(map[uint64]bool)
+		min     uint64
+	)
+	r.pendingPreparesLock.RLock()
+	for index := range r.pendingPrepares {
+		if index < lastCommit {
+			pending[index] = true
+			if min == 0 || index < min {
+				min = index
+			}
+		}
+	}
+	r.pendingPreparesLock.RUnlock()
+
+	for index := lastCommit; index > min && len(pending) > 0; {
+		var l *kt.Log
+		if l, err = r.wal.Get(index); err != nil {
+			err = errors.Wrapf(err, "get commit log %d failed", index)
+			return
+		}
+		if l.Type == kt.LogCheckpoint {
+			// prepares before a checkpoint are resolved unless recorded in the checkpoint
+			var recorded []uint64
+			if recorded, err = r.checkpointPrepares(l); err != nil {
+				return
+			}
+			unresolved := make(map[uint64]bool, len(recorded))
+			for _, i := range recorded {
+				unresolved[i] = true
+			}
+			for i := range pending {
+				if i < l.Index && !unresolved[i] {
+					delete(pending, i)
+				}
+			}
+			break
+		}
+		if l.Type != kt.LogCommit {
+			break
+		}
+		var prepareIndex uint64
+		if prepareIndex, err = r.bytesToUint64(l.Data); err != nil {
+			return
+		}
+		delete(pending, prepareIndex)
+		if len(l.Data) < 16 {
+			break
+		}
+		index, _ = r.bytesToUint64(l.Data[8:])
+	}
+
+	indexes := make([]uint64, 0, len(pending))
+	for index := range pending {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	logs = make([]*kt.Log, 0, len(indexes))
+	for _, index := range indexes {
+		var l *kt.Log
+		if l, err = r.wal.Get(index); err != nil {
+			err = errors.Wrapf(err, "get prepare log %d failed", index)
+			return
+		}
+		logs = append(logs, l)
+	}
+	return
+}
+
+// triggerSync starts catching up with the leader by state sync if no sync is running.
+func (r *Runtime) triggerSync() {
+	if _, ok := r.sh.(kt.StateSyncer); !ok {
+		return
+	}
+
+	r.startCatchUp("state sync", r.doSync)
+}
+
+// startCatchUp runs the catch up method in background if neither state sync nor snapshot install
+// is running.
+func (r *Runtime) startCatchUp(method string, catchUp func() error) {
+	if !atomic.CompareAndSwapUint32(&r.syncing, 0, 1) {
+		return
+	}
+
+	r.goFunc(func() {
+		defer atomic.StoreUint32(&r.syncing, 0)
+
+		le := log.WithField("instance", r.instanceID)
+		le.Infof("replica is lagging, start %s", method)
+		if err := catchUp(); err != nil {
+			le.WithError(err).Warningf("%s failed", method)
+			return
+		}
+		le.WithField("commit", r.LastCommit()).Infof("%s finished", method)
+	})
+}
+
+func (r *Runtime) doSync() (err error) {
+	var (
+		syncer = r.sh.(kt.StateSyncer)
+		base   uint64
+		req    = &kt.SyncRequest{Instance: r.instanceID}
+		resp   = &kt.SyncResponse{}
+		ctx    = context.Background()
+		dErr   error
+	)
+
+	// digest in commit cycle to make sure it matches the last commit
+	if err = r.runInCommitCycle(ctx, func() {
+		base = atomic.LoadUint64(&r.lastCommit)
+		req.Digest, dErr = syncer.Digest()
+	}); err != nil {
+		return
+	} else if dErr != nil {
+		err = errors.Wrap(dErr, "build state digest failed")
+		return
+	}
+
+	caller := r.WaiterNewCallerFunc(r.Peers().Leader)
+	if pcaller, ok := caller.(*rpc.PersistentCaller); ok && pcaller != nil {
+		defer pcaller.Close()
+	}
+	if err = caller.Call(r.syncRPCMethod, req, resp); err != nil {
+		err = errors.Wrap(err, "send sync rpc failed")
+		return
+	}
+
+	if err = r.runInCommitCycle(ctx, func() {
+		dErr = r.applySync(ctx, syncer, base, resp)
+	}); err == nil {
+		err = dErr
+	}
+	return
+}
+
+// applySync applies the state delta and persists a checkpoint log at the synced commit index
+// followed by the pending prepares, the missing logs before the checkpoint are never fetched.
+func (r *Runtime) applySync(
+	ctx context.Context, syncer kt.StateSyncer, base uint64, resp *kt.SyncResponse) (err error,
+) {
+	if atomic.LoadUint64(&r.lastCommit) != base {
+		err = errors.Wrap(kt.ErrInvalidLog, "local state changed during state sync")
+		return
+	}
+	if resp.LastCommit <= base {
+		// caught up already
+		return
+	}
+
+	var checkpoint *kt.Log
+	if checkpoint, err = r.newCheckpoint(resp.LastCommit, resp.Prepares); err != nil {
+		return
+	}
+
+	if err = syncer.ApplyDelta(resp.Delta); err != nil {
+		err = errors.Wrap(err, "apply state delta failed")
+		return
+	}
+
+	r.installCheckpoint(ctx, checkpoint, resp.Prepares)
+	return
+}
+
+// newCheckpoint builds the checkpoint log at last commit with the pending prepares.
+func (r *Runtime) newCheckpoint(lastCommit uint64, prepares []*kt.Log) (l *kt.Log, err error) {
+	var data = make([]byte, 0, 8*len(prepares))
+	for _, p := range prepares {
+		if p == nil || p.Type != kt.LogPrepare || p.Index >= lastCommit {
+			err = errors.Wrap(kt.ErrInvalidLog, "invalid pending prepare log of checkpoint")
+			return
+		}
+		data = append(data, r.uint64ToBytes(p.Index)...)
+	}
+
+	l = &kt.Log{
+		LogHeader: kt.LogHeader{
+			Index:    lastCommit,
+			Type:     kt.LogCheckpoint,
+			Producer: r.nodeID,
+		},
+		Data: data,
+	}
+	return
+}
+
+// installCheckpoint persists the checkpoint log followed by the pending prepares after the local
+// state is replaced by a peer, the missing logs before the checkpoint are never fetched.
+func (r *Runtime) installCheckpoint(ctx context.Context, checkpoint *kt.Log, prepares []*kt.Log) {
+	// local state is already synced, failed write will be a fatal error like newLog
+	var (
+		now     = time.Now()
+		pending = make(map[uint64]time.Time, len(prepares))
+		err     error
+	)
+	for _, l := range prepares {
+		if _, gerr := r.wal.Get(l.Index); gerr != nil {
+			if err = r.writeWAL(ctx, l); err != nil {
+				log.WithError(err).Fatal("WRITE SYNCED PREPARE LOG FAILED")
+			}
+		}
+		pending[l.Index] = now
+	}
+	if err = r.writeWAL(ctx, checkpoint); err != nil {
+		log.WithError(err).Fatal("WRITE CHECKPOINT LOG FAILED")
+	}
+
+	r.pendingPreparesLock.Lock()
+	for index, t := range r.pendingPrepares {
+		// keep the newer prepares already received
+		if index > checkpoint.Index {
+			pending[index] = t
+		}
+	}
+	r.pendingPrepares = pending
+	r.pendingPreparesLock.Unlock()
+
+	r.setCheckpoint(checkpoint)
+	r.setLastCommit(checkpoint.Index)
+	r.updateNextIndex(ctx, checkpoint)
+
+	// release the awaits of the logs covered by checkpoint
+	r.waitLogMap.Range(func(k, v interface{}) bool {
+		if index := k.(uint64); index <= checkpoint.Index {
+			var l *kt.Log
+			if gl, gerr := r.wal.Get(index); gerr == nil {
+				l = gl
+			}
+			v.(*waitItem).set(l)
+		}
+		return true
+	})
+}
+
+// checkpointPrepares decodes the pending prepare indexes recorded in a checkpoint log.
+func (r *Runtime) checkpointPrepares(l *kt.Log) (indexes []uint64, err error) {
+	var index uint64
+	for i := 0; i+8 <= len(l.Data); i += 8 {
+		if index, err = r.bytesToUint64(l.Data[i:]); err != nil {
+			return
+		}
+		indexes = append(indexes, index)
+	}
+	return
+}
+
+// readCheckpoint restores the pending prepares recorded in a checkpoint log during init.
+func (r *Runtime) readCheckpoint(l *kt.Log) (err error) {
+	var (
+		pending = make(map[uint64]time.Time)
+		indexes []uint64
+	)
+	if indexes, err = r.checkpointPrepares(l); err != nil {
+		return
+	}
+	for _, index := range indexes {
+		if _, ok := r.pendingPrepares[index]; !ok {
+			err = errors.Wrapf(kt.ErrInvalidLog, "prepare %d of checkpoint does not exists", index)
+			return
+		}
+		pending[index] = r.pendingPrepares[index]
+	}
+	r.pendingPrepares = pending
+	r.lastCommit = l.Index
+	return
+}
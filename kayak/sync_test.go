@@ -0,0 +1,248 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kayak_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/kayak"
+	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
+	kl "github.com/CovenantSQL/CovenantSQL/kayak/wal"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+type kvPair struct {
+	Key   string
+	Value string
+}
+
+// kvStorage is a map based storage supporting state sync by full snapshot.
+type kvStorage struct {
+	sync.RWMutex
+	kv map[string]string
+}
+
+func newKVStorage() *kvStorage {
+	return &kvStorage{kv: make(map[string]string)}
+}
+
+func (s *kvStorage) EncodePayload(request interface{}) (data []byte, err error) {
+	var buf *bytes.Buffer
+	if buf, err = utils.EncodeMsgPack(request); err != nil {
+		return
+	}
+	data = buf.Bytes()
+	return
+}
+
+func (s *kvStorage) DecodePayload(data []byte) (request interface{}, err error) {
+	var req *kvPair
+	if err = utils.DecodeMsgPack(data, &req); err != nil {
+		return
+	}
+	request = req
+	return
+}
+
+func (s *kvStorage) Check(data interface{}) (err error) {
+	return
+}
+
+func (s *kvStorage) Commit(data interface{}, isLeader bool) (result interface{}, err error) {
+	req, ok := data.(*kvPair)
+	if !ok {
+		err = errors.New("invalid data")
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.kv[req.Key] = req.Value
+	return
+}
+
+func (s *kvStorage) Digest() (digest []byte, err error) {
+	s.RLock()
+	defer s.RUnlock()
+	return []byte{byte(len(s.kv))}, nil
+}
+
+func (s *kvStorage) Delta(digest []byte) (delta []byte, err error) {
+	var buf *bytes.Buffer
+	s.RLock()
+	defer s.RUnlock()
+	if buf, err = utils.EncodeMsgPack(s.kv); err != nil {
+		return
+	}
+	delta = buf.Bytes()
+	return
+}
+
+func (s *kvStorage) ApplyDelta(delta []byte) (err error) {
+	var kv map[string]string
+	if err = utils.DecodeMsgPack(delta, &kv); err != nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.kv = kv
+	return
+}
+
+func (s *kvStorage) Snapshot() (snapshot []byte, err error) {
+	return s.Delta(nil)
+}
+
+func (s *kvStorage) InstallSnapshot(snapshot []byte) (err error) {
+	return s.ApplyDelta(snapshot)
+}
+
+func (s *kvStorage) snapshot() map[string]string {
+	s.RLock()
+	defer s.RUnlock()
+	kv := make(map[string]string, len(s.kv))
+	for k, v := range s.kv {
+		kv[k] = v
+	}
+	return kv
+}
+
+// switchCaller drops all calls until it's enabled.
+type switchCaller struct {
+	kayak.Caller
+	enabled uint32
+}
+
+func (c *switchCaller) Call(method string, req interface{}, resp interface{}) (err error) {
+	if atomic.LoadUint32(&c.enabled) == 0 {
+		return errors.New("network unreachable")
+	}
+	return c.Caller.Call(method, req, resp)
+}
+
+func TestRuntimeSync(t *testing.T) {
+	Convey("Given a leader and a follower missing many logs", t, func() {
+		var (
+			node1 = proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+			node2 = proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+			peers = &proto.Peers{
+				PeersHeader: proto.PeersHeader{
+					Leader:  node1,
+					Servers: []proto.NodeID{node1, node2},
+				},
+			}
+			db1, db2 = newKVStorage(), newKVStorage()
+			wal1     = kl.NewMemWal()
+			newCfg   = func(h kt.Handler, w kt.Wal, nodeID proto.NodeID) *kt.RuntimeConfig {
+				return &kt.RuntimeConfig{
+					Handler:         h,
+					PrepareTimeout:  time.Second,
+					CommitTimeout:   time.Second,
+					LogWaitTimeout:  10 * time.Second,
+					Peers:           peers,
+					Wal:             w,
+					NodeID:          nodeID,
+					ServiceName:     "Test",
+					ApplyMethodName: "Apply",
+					FetchMethodName: "Fetch",
+					SyncMethodName:  "Sync",
+					SyncThreshold:   10,
+				}
+			}
+		)
+		defer wal1.Close()
+		wal2, err := kl.NewLevelDBWal("testSync.db")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll("testSync.db")
+		defer wal2.Close()
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		err = peers.Sign(privKey)
+		So(err, ShouldBeNil)
+
+		rt1, err := kayak.NewRuntime(newCfg(db1, wal1, node1))
+		So(err, ShouldBeNil)
+		rt2, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+		So(err, ShouldBeNil)
+
+		m := newFakeMux()
+		m.register(node1, newFakeService(rt1))
+		m.register(node2, newFakeService(rt2))
+		toFollower := &switchCaller{Caller: newFakeCaller(m, node2)}
+		toLeader := newFakeCaller(m, node1)
+		rt1.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt1.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toFollower }
+		rt2.TrackerNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+		rt2.WaiterNewCallerFunc = func(proto.NodeID) kayak.Caller { return toLeader }
+
+		So(rt1.Start(), ShouldBeNil)
+		defer rt1.Shutdown()
+		So(rt2.Start(), ShouldBeNil)
+		defer rt2.Shutdown()
+
+		apply := func(i int) {
+			_, _, err := rt1.Apply(context.Background(), &kvPair{
+				Key:   RandStringRunes(8),
+				Value: RandStringRunes(16),
+			})
+			So(err, ShouldBeNil)
+		}
+		for i := 0; i < 100; i++ {
+			apply(i)
+		}
+		So(rt2.LastCommit(), ShouldEqual, 0)
+
+		Convey("The follower should catch up by state sync", func() {
+			atomic.StoreUint32(&toFollower.enabled, 1)
+			for i := 0; i < 10; i++ {
+				apply(i)
+			}
+
+			var deadline = time.Now().Add(10 * time.Second)
+			for rt2.LastCommit() != rt1.LastCommit() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(rt2.LastCommit(), ShouldEqual, rt1.LastCommit())
+			So(db2.snapshot(), ShouldResemble, db1.snapshot())
+
+			// the missing logs before checkpoint are never fetched
+			_, err = wal2.Get(1)
+			So(err, ShouldNotBeNil)
+
+			// the checkpoint should be restored on restart
+			So(rt2.Shutdown(), ShouldBeNil)
+			wal2.Close()
+			wal2, err = kl.NewLevelDBWal("testSync.db")
+			So(err, ShouldBeNil)
+			defer wal2.Close()
+			rt3, err := kayak.NewRuntime(newCfg(db2, wal2, node2))
+			So(err, ShouldBeNil)
+			So(rt3.LastCommit(), ShouldEqual, rt1.LastCommit())
+		})
+	})
+}
@@ -40,11 +40,17 @@ type rpcTracker struct {
 	req interface{}
 	// minimum response count
 	minCount int
+	// count successful responses only for minCount, used by leadership confirmation
+	countSuccess bool
+	// callback on each response
+	onResult func(node proto.NodeID, err error)
 	// responses
 	errLock sync.RWMutex
 	errors  map[proto.NodeID]error
 	// scoreboard
 	complete int
+	success  int
+	nodeDone map[proto.NodeID]chan struct{}
 	sent     uint32
 	doneOnce sync.Once
 	doneCh   chan struct{}
@@ -53,8 +59,13 @@ type rpcTracker struct {
 }
 
 func newTracker(r *Runtime, req interface{}, minCount int) (t *rpcTracker) {
+	return newNodesTracker(r, r.followers, req, minCount)
+}
+
+// newNodesTracker creates the tracker of rpc calls to specified nodes.
+func newNodesTracker(r *Runtime, targets []proto.NodeID, req interface{}, minCount int) (t *rpcTracker) {
 	// copy nodes
-	nodes := append([]proto.NodeID(nil), r.followers...)
+	nodes := append([]proto.NodeID(nil), targets...)
 
 	if minCount > len(nodes) {
 		minCount = len(nodes)
@@ -70,9 +81,14 @@ func newTracker(r *Runtime, req interface{}, minCount int) (t *rpcTracker) {
 		req:      req,
 		minCount: minCount,
 		errors:   make(map[proto.NodeID]error, len(nodes)),
+		nodeDone: make(map[proto.NodeID]chan struct{}, len(nodes)),
 		doneCh:   make(chan struct{}),
 	}
 
+	for _, n := range nodes {
+		t.nodeDone[n] = make(chan struct{})
+	}
+
 	return
 }
 
@@ -97,13 +113,52 @@ func (t *rpcTracker) callSingle(idx int) {
 		defer pcaller.Close()
 	}
 	err := caller.Call(t.method, t.req, nil)
+	t.setResult(t.nodes[idx], err)
+}
+
+// replicate enqueues the apply request to the replicators of target nodes instead of calling them
+// directly, the results are set by the replicators.
+func (t *rpcTracker) replicate() {
+	if !atomic.CompareAndSwapUint32(&t.sent, 0, 1) {
+		return
+	}
+
+	l := t.req.(*kt.ApplyRequest).Log
+
+	for _, n := range t.nodes {
+		t.wg.Add(1)
+		if rp := t.r.getReplicator(n); rp != nil {
+			rp.enqueue(&replicateItem{log: l, tracker: t})
+		} else {
+			t.setResult(n, kt.ErrStopped)
+		}
+	}
+
+	if t.minCount == 0 {
+		t.done()
+	}
+}
+
+// setResult records the response of the node.
+func (t *rpcTracker) setResult(node proto.NodeID, err error) {
 	defer t.wg.Done()
+	if t.onResult != nil {
+		t.onResult(node, err)
+	}
 	t.errLock.Lock()
 	defer t.errLock.Unlock()
-	t.errors[t.nodes[idx]] = err
+	t.errors[node] = err
 	t.complete++
+	if err == nil {
+		t.success++
+	}
+	close(t.nodeDone[node])
 
-	if t.complete >= t.minCount {
+	if t.countSuccess {
+		if t.success >= t.minCount || t.complete == len(t.nodes) {
+			t.done()
+		}
+	} else if t.complete >= t.minCount {
 		t.done()
 	}
 }
@@ -168,6 +223,25 @@ func (t *rpcTracker) get(ctx context.Context) (errors map[proto.NodeID]error, me
 	return
 }
 
+// waitNode waits for the response of the node until ctx is done.
+func (t *rpcTracker) waitNode(ctx context.Context, node proto.NodeID) (responded bool, err error) {
+	ch, ok := t.nodeDone[node]
+	if !ok {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-ch:
+	}
+
+	t.errLock.RLock()
+	defer t.errLock.RUnlock()
+
+	return true, t.errors[node]
+}
+
 func (t *rpcTracker) close() {
 	if !atomic.CompareAndSwapUint32(&t.closed, 0, 1) {
 		return
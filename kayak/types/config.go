@@ -19,6 +19,7 @@ package types
 import (
 	"time"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 )
 
@@ -46,8 +47,40 @@ type RuntimeConfig struct {
 	ServiceName string
 	// apply service method.
 	ApplyMethodName string
+	// batched apply service method, logs are replicated by single apply requests if not set.
+	ApplyBatchMethodName string
+	// max total log data size of a batched apply request, a larger log is sent alone.
+	MaxBatchSize int
+	// max in-flight batched apply requests of each follower.
+	MaxInflightBatches int
+	// max pending log count of each follower, the oldest pending logs are dropped for the
+	// follower to fetch on demand, 0 for unlimited.
+	MaxReplicationLag int
 	// fetch service method.
 	FetchMethodName string
 	// fetch timeout.
 	LogWaitTimeout time.Duration
+	// state sync service method.
+	SyncMethodName string
+	// min missing log count to catch up by state sync instead of fetching logs, 0 to disable.
+	SyncThreshold uint64
+	// read index service method.
+	ReadIndexMethodName string
+	// leadership heartbeat service method.
+	HeartbeatMethodName string
+	// lease duration of leader and follower linearizable reads, 0 to confirm leadership on every read.
+	LeaseDuration time.Duration
+	// snapshot service method.
+	SnapshotMethodName string
+	// min log count between log compactions, 0 to disable log compaction.
+	SnapshotInterval uint64
+	// vote service method.
+	VoteMethodName string
+	// base election timeout of followers, the leader heartbeats followers every third of it and
+	// steps down if it can't reach quorum followers in it, 0 to disable leader election.
+	ElectionTimeout time.Duration
+	// private key to sign the peers of elected leader, required by leader election.
+	PrivateKey *asymmetric.PrivateKey
+	// callback on leader changes by election, peers of new leader and term is supplied.
+	OnLeaderChange func(peers *proto.Peers)
 }
@@ -35,4 +35,18 @@ var (
 	ErrInvalidConfig = errors.New("invalid runtime config")
 	// ErrStopped represents runtime not started.
 	ErrStopped = errors.New("stopped")
+	// ErrFollowerLagging represents the log is dropped from replication as the follower lags too much.
+	ErrFollowerLagging = errors.New("follower lagging")
+	// ErrSyncNotSupported represents the underlying handler does not support state sync.
+	ErrSyncNotSupported = errors.New("state sync not supported")
+	// ErrSnapshotNotSupported represents the underlying handler does not support snapshot.
+	ErrSnapshotNotSupported = errors.New("snapshot not supported")
+	// ErrLeadershipNotConfirmed represents leader failed to confirm its leadership with quorum followers.
+	ErrLeadershipNotConfirmed = errors.New("leadership not confirmed")
+	// ErrElectionDisabled represents leader election is not enabled by runtime config.
+	ErrElectionDisabled = errors.New("leader election disabled")
+	// ErrLeaseNotExpired represents the log is produced by another node during the lease of granted leader.
+	ErrLeaseNotExpired = errors.New("leader lease not expired")
+	// ErrStaleRead represents the follower state lags behind the leader beyond the read bound.
+	ErrStaleRead = errors.New("follower state too stale")
 )
@@ -23,3 +23,23 @@ type Handler interface {
 	Check(request interface{}) error
 	Commit(request interface{}, isLeader bool) (result interface{}, err error)
 }
+
+// StateSyncer defines the optional Handler interface to catch up with a peer by applying the state
+// delta instead of replaying the missing logs one by one.
+type StateSyncer interface {
+	// Digest returns the encoded digest of the local state.
+	Digest() (digest []byte, err error)
+	// Delta returns the encoded delta from the state described by digest to the local state.
+	Delta(digest []byte) (delta []byte, err error)
+	// ApplyDelta applies the encoded delta to the local state.
+	ApplyDelta(delta []byte) (err error)
+}
+
+// Snapshotter defines the optional Handler interface to compact the applied logs into the local
+// state and catch up with a peer by a full state snapshot once the logs are compacted.
+type Snapshotter interface {
+	// Snapshot returns the encoded snapshot of the local state.
+	Snapshot() (snapshot []byte, err error)
+	// InstallSnapshot replaces the local state with the encoded snapshot.
+	InstallSnapshot(snapshot []byte) (err error)
+}
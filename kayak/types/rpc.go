@@ -16,7 +16,11 @@
 
 package types
 
-import "github.com/CovenantSQL/CovenantSQL/proto"
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
 
 // ApplyRequest defines the apply request entity.
 type ApplyRequest struct {
@@ -25,6 +29,20 @@ type ApplyRequest struct {
 	Log      *Log
 }
 
+// ApplyBatchRequest defines the batched apply request entity, the logs are applied in order.
+type ApplyBatchRequest struct {
+	proto.Envelope
+	Instance string
+	Logs     []*Log
+}
+
+// ApplyBatchResponse defines the batched apply response entity.
+type ApplyBatchResponse struct {
+	proto.Envelope
+	Instance string
+	Errors   []string // apply error of each log, empty for success
+}
+
 // FetchRequest defines the fetch request entity.
 type FetchRequest struct {
 	proto.Envelope
@@ -38,3 +56,78 @@ type FetchResponse struct {
 	Instance string
 	Log      *Log
 }
+
+// SyncRequest defines the state sync request entity.
+type SyncRequest struct {
+	proto.Envelope
+	Instance string
+	Digest   []byte
+}
+
+// SyncResponse defines the state sync response entity.
+type SyncResponse struct {
+	proto.Envelope
+	Instance   string
+	LastCommit uint64 // last commit index the delta is built at
+	Prepares   []*Log // prepared logs which are not committed or rolled back at last commit
+	Delta      []byte
+}
+
+// SnapshotRequest defines the snapshot request entity.
+type SnapshotRequest struct {
+	proto.Envelope
+	Instance string
+}
+
+// SnapshotResponse defines the snapshot response entity.
+type SnapshotResponse struct {
+	proto.Envelope
+	Instance   string
+	LastCommit uint64 // last commit index the snapshot is built at
+	Prepares   []*Log // prepared logs which are not committed or rolled back at last commit
+	Snapshot   []byte
+}
+
+// ReadIndexRequest defines the read index request entity of follower.
+type ReadIndexRequest struct {
+	proto.Envelope
+	Instance string
+}
+
+// ReadIndexResponse defines the read index response entity.
+type ReadIndexResponse struct {
+	proto.Envelope
+	Instance string
+	Index    uint64        // commit index confirmed by leader
+	Lease    time.Duration // follower read lease granted by leader, 0 if not granted
+}
+
+// HeartbeatRequest defines the leadership confirmation request entity.
+type HeartbeatRequest struct {
+	proto.Envelope
+	Instance string
+	Leader   proto.NodeID
+	Term     uint64
+	// LastCommit is the last commit index of leader, which bounds the staleness of follower reads.
+	LastCommit uint64
+}
+
+// VoteRequest defines the vote request entity of candidate.
+type VoteRequest struct {
+	proto.Envelope
+	Instance   string
+	Candidate  proto.NodeID
+	Term       uint64 // term the candidate campaigns for
+	LastIndex  uint64 // last log index of candidate
+	LastCommit uint64 // last commit index of candidate
+	PreVote    bool   // pre-vote does not change the term and vote of voters
+}
+
+// VoteResponse defines the vote response entity.
+type VoteResponse struct {
+	proto.Envelope
+	Instance  string
+	Term      uint64 // current term of voter
+	LastIndex uint64 // last log index of voter
+	Granted   bool
+}
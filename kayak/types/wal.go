@@ -16,6 +16,10 @@
 
 package types
 
+import (
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
 // Wal defines the log storage interface.
 type Wal interface {
 	// sequential write
@@ -25,3 +29,26 @@ type Wal interface {
 	// random access
 	Get(index uint64) (*Log, error)
 }
+
+// Compactor defines the optional Wal interface to garbage-collect the compacted logs.
+type Compactor interface {
+	// Compact replaces the log at checkpoint index with the checkpoint log and removes the logs
+	// before it except the logs of keep indexes.
+	Compact(checkpoint *Log, keep []uint64) error
+}
+
+// VoteState defines the election state of a voter, which must be durable before the vote is
+// granted, so that a restarted voter never votes twice in one term.
+type VoteState struct {
+	Term     uint64
+	VotedFor proto.NodeID
+}
+
+// VoteStore defines the optional Wal interface to persist the election state, it's required by
+// leader election.
+type VoteStore interface {
+	// SaveVoteState saves the election state durably.
+	SaveVoteState(*VoteState) error
+	// LoadVoteState loads the last saved election state, empty state is returned if not exists.
+	LoadVoteState() (*VoteState, error)
+}
@@ -105,6 +105,15 @@ func (i *waitItem) run() {
 				"instance": i.r.instanceID,
 			}).Debug("could not fetch log")
 			continue
+		} else if resp.Log.Type == kt.LogCheckpoint {
+			// log is compacted by leader
+			log.WithFields(log.Fields{
+				"index":      i.index,
+				"instance":   i.r.instanceID,
+				"checkpoint": resp.Log.Index,
+			}).Debug("log is compacted, catch up by snapshot")
+			i.r.triggerSnapshot()
+			continue
 		}
 
 		if err = i.r.followerApply(resp.Log, false); err != nil {
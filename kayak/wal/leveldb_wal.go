@@ -26,8 +26,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -38,6 +40,8 @@ var (
 	logHeaderKeyPrefix = []byte{'L', 'H'}
 	// logDataKeyPrefix defines the leveldb data key prefix.
 	logDataKeyPrefix = []byte{'L', 'D'}
+	// voteStateKey defines the leveldb key of election state.
+	voteStateKey = []byte{'V', 'S'}
 )
 
 // LevelDBWal defines a toy wal using leveldb as storage.
@@ -47,6 +51,7 @@ type LevelDBWal struct {
 	closed   uint32
 	readLock sync.Mutex
 	read     uint32
+	keyring  *symmetric.Keyring
 }
 
 // NewLevelDBWal returns new leveldb wal instance.
@@ -60,6 +65,15 @@ func NewLevelDBWal(filename string) (p *LevelDBWal, err error) {
 	return
 }
 
+// NewEncryptedLevelDBWal returns new leveldb wal instance with log data sealed by keyring.
+func NewEncryptedLevelDBWal(filename string, keyring *symmetric.Keyring) (p *LevelDBWal, err error) {
+	if p, err = NewLevelDBWal(filename); err != nil {
+		return
+	}
+	p.keyring = keyring
+	return
+}
+
 // Write implements Wal.Write.
 func (p *LevelDBWal) Write(l *kt.Log) (err error) {
 	if atomic.LoadUint32(&p.closed) == 1 {
@@ -76,7 +90,7 @@ func (p *LevelDBWal) Write(l *kt.Log) (err error) {
 	}
 
 	// build header headerKey
-	headerKey := append(append([]byte(nil), logHeaderKeyPrefix...), p.uint64ToBytes(l.Index)...)
+	headerKey := p.headerKey(l.Index)
 
 	if _, err = p.db.Get(headerKey, nil); err != nil && err != leveldb.ErrNotFound {
 		err = errors.Wrap(err, "access leveldb failed")
@@ -86,34 +100,89 @@ func (p *LevelDBWal) Write(l *kt.Log) (err error) {
 		return
 	}
 
-	dataKey := append(append([]byte(nil), logDataKeyPrefix...), p.uint64ToBytes(l.Index)...)
+	var header, data []byte
+	if header, data, err = p.encode(l); err != nil {
+		return
+	}
 
 	// write data first
-	var enc *bytes.Buffer
-	if enc, err = utils.EncodeMsgPack(l.Data); err != nil {
-		err = errors.Wrap(err, "encode log data failed")
+	if err = p.db.Put(p.dataKey(l.Index), data, nil); err != nil {
+		err = errors.Wrap(err, "write log data failed")
 		return
 	}
 
-	if err = p.db.Put(dataKey, enc.Bytes(), nil); err != nil {
-		err = errors.Wrap(err, "write log data failed")
+	// save header
+	if err = p.db.Put(headerKey, header, nil); err != nil {
+		err = errors.Wrap(err, "encode log header failed")
 		return
 	}
 
-	// write header
-	l.DataLength = uint64(enc.Len())
+	return
+}
 
-	if enc, err = utils.EncodeMsgPack(l.LogHeader); err != nil {
-		err = errors.Wrap(err, "encode log header failed")
+// Compact implements Compactor.Compact.
+func (p *LevelDBWal) Compact(checkpoint *kt.Log, keep []uint64) (err error) {
+	if atomic.LoadUint32(&p.closed) == 1 {
+		err = ErrWalClosed
 		return
 	}
 
-	// save header
-	if err = p.db.Put(headerKey, enc.Bytes(), nil); err != nil {
-		err = errors.Wrap(err, "encode log header failed")
+	if checkpoint == nil {
+		err = ErrInvalidLog
 		return
 	}
 
+	var (
+		kept  = make(map[uint64]bool, len(keep))
+		batch = new(leveldb.Batch)
+	)
+	for _, i := range keep {
+		kept[i] = true
+	}
+
+	// remove logs before checkpoint
+	it := p.db.NewIterator(&util.Range{
+		Start: logHeaderKeyPrefix,
+		Limit: p.headerKey(checkpoint.Index),
+	}, nil)
+	for it.Next() {
+		index := binary.BigEndian.Uint64(it.Key()[len(logHeaderKeyPrefix):])
+		if kept[index] {
+			continue
+		}
+		batch.Delete(it.Key())
+		batch.Delete(p.dataKey(index))
+	}
+	it.Release()
+	if err = it.Error(); err != nil {
+		err = errors.Wrap(err, "iterate logs failed")
+		return
+	}
+
+	// replace log at checkpoint index
+	var header, data []byte
+	if header, data, err = p.encode(checkpoint); err != nil {
+		return
+	}
+	batch.Put(p.dataKey(checkpoint.Index), data)
+	batch.Put(p.headerKey(checkpoint.Index), header)
+
+	if err = p.db.Write(batch, nil); err != nil {
+		err = errors.Wrap(err, "write compaction batch failed")
+		return
+	}
+
+	// reclaim disk space of removed logs
+	for _, r := range []util.Range{
+		{Start: logHeaderKeyPrefix, Limit: p.headerKey(checkpoint.Index)},
+		{Start: logDataKeyPrefix, Limit: p.dataKey(checkpoint.Index)},
+	} {
+		if err = p.db.CompactRange(r); err != nil {
+			err = errors.Wrap(err, "compact leveldb failed")
+			return
+		}
+	}
+
 	return
 }
 
@@ -163,10 +232,8 @@ func (p *LevelDBWal) Get(i uint64) (l *kt.Log, err error) {
 		return
 	}
 
-	headerKey := append(append([]byte(nil), logHeaderKeyPrefix...), p.uint64ToBytes(i)...)
-
 	var headerData []byte
-	if headerData, err = p.db.Get(headerKey, nil); err == leveldb.ErrNotFound {
+	if headerData, err = p.db.Get(p.headerKey(i), nil); err == leveldb.ErrNotFound {
 		err = ErrNotExists
 		return
 	} else if err != nil {
@@ -177,6 +244,51 @@ func (p *LevelDBWal) Get(i uint64) (l *kt.Log, err error) {
 	return p.load(headerData)
 }
 
+// SaveVoteState implements VoteStore.SaveVoteState.
+func (p *LevelDBWal) SaveVoteState(st *kt.VoteState) (err error) {
+	if atomic.LoadUint32(&p.closed) == 1 {
+		err = ErrWalClosed
+		return
+	}
+
+	var buf *bytes.Buffer
+	if buf, err = utils.EncodeMsgPack(st); err != nil {
+		err = errors.Wrap(err, "encode vote state failed")
+		return
+	}
+
+	// the vote must survive crash before it's granted
+	if err = p.db.Put(voteStateKey, buf.Bytes(), &opt.WriteOptions{Sync: true}); err != nil {
+		err = errors.Wrap(err, "write vote state failed")
+	}
+
+	return
+}
+
+// LoadVoteState implements VoteStore.LoadVoteState.
+func (p *LevelDBWal) LoadVoteState() (st *kt.VoteState, err error) {
+	if atomic.LoadUint32(&p.closed) == 1 {
+		err = ErrWalClosed
+		return
+	}
+
+	var data []byte
+	st = &kt.VoteState{}
+	if data, err = p.db.Get(voteStateKey, nil); err == leveldb.ErrNotFound {
+		err = nil
+		return
+	} else if err != nil {
+		err = errors.Wrap(err, "get vote state failed")
+		return
+	}
+
+	if err = utils.DecodeMsgPack(data, st); err != nil {
+		err = errors.Wrap(err, "decode vote state failed")
+	}
+
+	return
+}
+
 // Close implements Wal.Close.
 func (p *LevelDBWal) Close() {
 	if !atomic.CompareAndSwapUint32(&p.closed, 0, 1) {
@@ -202,14 +314,19 @@ func (p *LevelDBWal) load(logHeader []byte) (l *kt.Log, err error) {
 		return
 	}
 
-	dataKey := append(append([]byte(nil), logDataKeyPrefix...), p.uint64ToBytes(l.Index)...)
-
 	var encData []byte
-	if encData, err = p.db.Get(dataKey, nil); err != nil {
+	if encData, err = p.db.Get(p.dataKey(l.Index), nil); err != nil {
 		err = errors.Wrap(err, "get log data failed")
 		return
 	}
 
+	if p.keyring != nil {
+		if encData, err = p.keyring.Open(encData); err != nil {
+			err = errors.Wrap(err, "open log data failed")
+			return
+		}
+	}
+
 	// load data
 	if err = utils.DecodeMsgPack(encData, &l.Data); err != nil {
 		err = errors.Wrap(err, "decode log data failed")
@@ -218,6 +335,41 @@ func (p *LevelDBWal) load(logHeader []byte) (l *kt.Log, err error) {
 	return
 }
 
+func (p *LevelDBWal) encode(l *kt.Log) (header []byte, data []byte, err error) {
+	var enc *bytes.Buffer
+	if enc, err = utils.EncodeMsgPack(l.Data); err != nil {
+		err = errors.Wrap(err, "encode log data failed")
+		return
+	}
+
+	l.DataLength = uint64(enc.Len())
+
+	data = enc.Bytes()
+	if p.keyring != nil {
+		if data, err = p.keyring.Seal(data); err != nil {
+			err = errors.Wrap(err, "seal log data failed")
+			return
+		}
+	}
+
+	if enc, err = utils.EncodeMsgPack(l.LogHeader); err != nil {
+		err = errors.Wrap(err, "encode log header failed")
+		return
+	}
+
+	header = enc.Bytes()
+
+	return
+}
+
+func (p *LevelDBWal) headerKey(i uint64) []byte {
+	return append(append([]byte(nil), logHeaderKeyPrefix...), p.uint64ToBytes(i)...)
+}
+
+func (p *LevelDBWal) dataKey(i uint64) []byte {
+	return append(append([]byte(nil), logDataKeyPrefix...), p.uint64ToBytes(i)...)
+}
+
 func (p *LevelDBWal) uint64ToBytes(o uint64) (res []byte) {
 	res = make([]byte, 8)
 	binary.BigEndian.PutUint64(res, o)
@@ -23,6 +23,7 @@ import (
 
 	. "github.com/smartystreets/goconvey/convey"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 )
@@ -143,5 +144,169 @@ func TestLevelDBWal_Write(t *testing.T) {
 	Convey("open failed test", t, func() {
 		_, err := NewLevelDBWal("")
 		So(err, ShouldNotBeNil)
+		_, err = NewEncryptedLevelDBWal("", nil)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("encrypted wal write/read", t, func() {
+		dbFile := "testEncryptedWrite.ldb"
+
+		keyring, err := symmetric.NewKeyring()
+		So(err, ShouldBeNil)
+		p, err := NewEncryptedLevelDBWal(dbFile, keyring)
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dbFile)
+
+		l1 := &kt.Log{
+			LogHeader: kt.LogHeader{
+				Index:    0,
+				Type:     kt.LogPrepare,
+				Producer: proto.NodeID("0000000000000000000000000000000000000000000000000000000000000000"),
+			},
+			Data: []byte("happy1"),
+		}
+		err = p.Write(l1)
+		So(err, ShouldBeNil)
+
+		// rotate and write with new key
+		_, err = keyring.Rotate()
+		So(err, ShouldBeNil)
+		l2 := &kt.Log{
+			LogHeader: kt.LogHeader{
+				Index:    1,
+				Type:     kt.LogPrepare,
+				Producer: proto.NodeID("0000000000000000000000000000000000000000000000000000000000000000"),
+			},
+			Data: []byte("happy2"),
+		}
+		err = p.Write(l2)
+		So(err, ShouldBeNil)
+		p.Close()
+
+		// plain wal could not read sealed data
+		p, err = NewLevelDBWal(dbFile)
+		So(err, ShouldBeNil)
+		_, err = p.Get(l1.Index)
+		So(err, ShouldNotBeNil)
+		p.Close()
+
+		// wal with another keyring could not read sealed data
+		other, err := symmetric.NewKeyring()
+		So(err, ShouldBeNil)
+		p, err = NewEncryptedLevelDBWal(dbFile, other)
+		So(err, ShouldBeNil)
+		_, err = p.Get(l1.Index)
+		So(err, ShouldNotBeNil)
+		p.Close()
+
+		p, err = NewEncryptedLevelDBWal(dbFile, keyring)
+		So(err, ShouldBeNil)
+		for _, ol := range []*kt.Log{l1, l2} {
+			var l *kt.Log
+			l, err = p.Read()
+			So(err, ShouldBeNil)
+			So(l, ShouldResemble, ol)
+		}
+		_, err = p.Read()
+		So(err, ShouldEqual, io.EOF)
+		p.Close()
+	})
+	Convey("wal compact", t, func() {
+		dbFile := "testCompact.ldb"
+
+		p, err := NewLevelDBWal(dbFile)
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dbFile)
+
+		for i := uint64(0); i < 10; i++ {
+			err = p.Write(&kt.Log{
+				LogHeader: kt.LogHeader{
+					Index:    i,
+					Type:     kt.LogPrepare,
+					Producer: proto.NodeID("0000000000000000000000000000000000000000000000000000000000000000"),
+				},
+				Data: []byte("happy"),
+			})
+			So(err, ShouldBeNil)
+		}
+
+		err = p.Compact(nil, nil)
+		So(err, ShouldNotBeNil)
+
+		checkpoint := &kt.Log{
+			LogHeader: kt.LogHeader{
+				Index:    5,
+				Type:     kt.LogCheckpoint,
+				Producer: proto.NodeID("0000000000000000000000000000000000000000000000000000000000000000"),
+			},
+			Data: []byte("checkpoint"),
+		}
+		err = p.Compact(checkpoint, []uint64{3})
+		So(err, ShouldBeNil)
+
+		var l *kt.Log
+		l, err = p.Get(5)
+		So(err, ShouldBeNil)
+		So(l, ShouldResemble, checkpoint)
+		for _, i := range []uint64{0, 1, 2, 4} {
+			_, err = p.Get(i)
+			So(err, ShouldEqual, ErrNotExists)
+		}
+		for _, i := range []uint64{3, 6, 9} {
+			l, err = p.Get(i)
+			So(err, ShouldBeNil)
+			So(l.Index, ShouldEqual, i)
+			So(l.Type, ShouldEqual, kt.LogPrepare)
+		}
+		p.Close()
+
+		err = p.Compact(checkpoint, nil)
+		So(err, ShouldEqual, ErrWalClosed)
+
+		// compacted logs are not read on reopen
+		p, err = NewLevelDBWal(dbFile)
+		So(err, ShouldBeNil)
+		var indexes []uint64
+		for {
+			if l, err = p.Read(); err != nil {
+				break
+			}
+			indexes = append(indexes, l.Index)
+		}
+		So(err, ShouldEqual, io.EOF)
+		So(indexes, ShouldResemble, []uint64{3, 5, 6, 7, 8, 9})
+		p.Close()
+	})
+}
+
+func TestLevelDBWal_VoteState(t *testing.T) {
+	Convey("wal vote state save/load", t, func() {
+		dbFile := "testVoteState.ldb"
+		defer os.RemoveAll(dbFile)
+
+		p, err := NewLevelDBWal(dbFile)
+		So(err, ShouldBeNil)
+
+		st, err := p.LoadVoteState()
+		So(err, ShouldBeNil)
+		So(st, ShouldResemble, &kt.VoteState{})
+
+		err = p.SaveVoteState(&kt.VoteState{Term: 2, VotedFor: proto.NodeID("0000000000000000000000000000000000000000000000000000000000000000")})
+		So(err, ShouldBeNil)
+		p.Close()
+
+		// vote state survives reopen and does not show up in logs
+		p, err = NewLevelDBWal(dbFile)
+		So(err, ShouldBeNil)
+		defer p.Close()
+		st, err = p.LoadVoteState()
+		So(err, ShouldBeNil)
+		So(st.Term, ShouldEqual, 2)
+		So(st.VotedFor, ShouldEqual, proto.NodeID("0000000000000000000000000000000000000000000000000000000000000000"))
+		_, err = p.Read()
+		So(err, ShouldEqual, io.EOF)
+
+		p.Close()
+		err = p.SaveVoteState(&kt.VoteState{Term: 3})
+		So(err, ShouldEqual, ErrWalClosed)
 	})
 }
@@ -31,6 +31,7 @@ type MemWal struct {
 	revIndex map[uint64]int
 	offset   uint64
 	closed   uint32
+	vote     kt.VoteState
 }
 
 // NewMemWal returns new memory wal instance.
@@ -105,6 +106,79 @@ func (p *MemWal) Get(index uint64) (l *kt.Log, err error) {
 	return
 }
 
+// Compact implements Compactor.Compact.
+func (p *MemWal) Compact(checkpoint *kt.Log, keep []uint64) (err error) {
+	if atomic.LoadUint32(&p.closed) == 1 {
+		err = ErrWalClosed
+		return
+	}
+
+	if checkpoint == nil {
+		err = ErrInvalidLog
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	kept := make(map[uint64]bool, len(keep)+1)
+	for _, i := range keep {
+		kept[i] = true
+	}
+
+	logs := make([]*kt.Log, 0, len(p.logs))
+	replaced := false
+	for _, l := range p.logs {
+		if l.Index == checkpoint.Index {
+			l, replaced = checkpoint, true
+		} else if l.Index < checkpoint.Index && !kept[l.Index] {
+			continue
+		}
+		logs = append(logs, l)
+	}
+	if !replaced {
+		logs = append(logs, checkpoint)
+	}
+
+	p.logs = logs
+	p.revIndex = make(map[uint64]int, len(logs))
+	for i, l := range logs {
+		p.revIndex[l.Index] = i
+	}
+	atomic.StoreUint64(&p.offset, uint64(len(logs)))
+
+	return
+}
+
+// SaveVoteState implements VoteStore.SaveVoteState.
+func (p *MemWal) SaveVoteState(st *kt.VoteState) (err error) {
+	if atomic.LoadUint32(&p.closed) == 1 {
+		err = ErrWalClosed
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.vote = *st
+
+	return
+}
+
+// LoadVoteState implements VoteStore.LoadVoteState.
+func (p *MemWal) LoadVoteState() (st *kt.VoteState, err error) {
+	if atomic.LoadUint32(&p.closed) == 1 {
+		err = ErrWalClosed
+		return
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+	st = &kt.VoteState{}
+	*st = p.vote
+
+	return
+}
+
 // Close implements Wal.Close.
 func (p *MemWal) Close() {
 	if !atomic.CompareAndSwapUint32(&p.closed, 0, 1) {
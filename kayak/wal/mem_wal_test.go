@@ -188,3 +188,77 @@ func TestMemWal_Write2(t *testing.T) {
 		So(p.offset, ShouldEqual, 5)
 	})
 }
+
+func TestMemWal_Compact(t *testing.T) {
+	Convey("test mem wal compact", t, func() {
+		p := NewMemWal()
+
+		for _, i := range []uint64{0, 2, 1, 3, 5, 4, 7} {
+			err := p.Write(&kt.Log{
+				LogHeader: kt.LogHeader{
+					Index: i,
+					Type:  kt.LogPrepare,
+				},
+			})
+			So(err, ShouldBeNil)
+		}
+
+		err := p.Compact(nil, nil)
+		So(err, ShouldEqual, ErrInvalidLog)
+
+		checkpoint := &kt.Log{
+			LogHeader: kt.LogHeader{
+				Index: 4,
+				Type:  kt.LogCheckpoint,
+			},
+		}
+		err = p.Compact(checkpoint, []uint64{1})
+		So(err, ShouldBeNil)
+		So(p.logs, ShouldHaveLength, 4)
+		So(p.revIndex, ShouldHaveLength, 4)
+		So(p.offset, ShouldEqual, 4)
+
+		var l *kt.Log
+		l, err = p.Get(4)
+		So(err, ShouldBeNil)
+		So(l, ShouldEqual, checkpoint)
+		for _, i := range []uint64{0, 2, 3} {
+			_, err = p.Get(i)
+			So(err, ShouldEqual, ErrNotExists)
+		}
+		for _, i := range []uint64{1, 5, 7} {
+			l, err = p.Get(i)
+			So(err, ShouldBeNil)
+			So(l.Index, ShouldEqual, i)
+		}
+
+		// checkpoint is written if log does not exist
+		checkpoint = &kt.Log{
+			LogHeader: kt.LogHeader{
+				Index: 6,
+				Type:  kt.LogCheckpoint,
+			},
+		}
+		err = p.Compact(checkpoint, nil)
+		So(err, ShouldBeNil)
+		So(p.logs, ShouldHaveLength, 2)
+		l, err = p.Get(6)
+		So(err, ShouldBeNil)
+		So(l, ShouldEqual, checkpoint)
+
+		err = p.Write(&kt.Log{
+			LogHeader: kt.LogHeader{
+				Index: 8,
+				Type:  kt.LogPrepare,
+			},
+		})
+		So(err, ShouldBeNil)
+		l, err = p.Get(8)
+		So(err, ShouldBeNil)
+		So(l.Index, ShouldEqual, 8)
+
+		p.Close()
+		err = p.Compact(checkpoint, nil)
+		So(err, ShouldEqual, ErrWalClosed)
+	})
+}
@@ -76,6 +76,45 @@ func (merkle *Merkle) GetRoot() *hash.Hash {
 	return merkle.tree[len(merkle.tree)-1]
 }
 
+// GetProof returns the sibling hashes from the leaf at index up to the root, a missing right
+// sibling is substituted by the node itself as it's merged with itself. Nil proof is returned if
+// the index is out of range.
+func (merkle *Merkle) GetProof(index uint64) (proof []hash.Hash) {
+	var (
+		width  = uint64(len(merkle.tree)+1) / 2
+		offset uint64
+	)
+	if index >= width || merkle.tree[index] == nil {
+		return
+	}
+	proof = make([]hash.Hash, 0)
+	for ; width > 1; width /= 2 {
+		sibling := merkle.tree[offset+index^1]
+		if sibling == nil {
+			sibling = merkle.tree[offset+index]
+		}
+		proof = append(proof, *sibling)
+		offset += width
+		index /= 2
+	}
+	return
+}
+
+// VerifyProof reports whether the leaf at index is proved to be a part of the merkle tree with
+// root by the sibling hashes in proof.
+func VerifyProof(leaf *hash.Hash, index uint64, proof []hash.Hash, root *hash.Hash) bool {
+	var cur = leaf
+	for i := range proof {
+		if index%2 == 0 {
+			cur = MergeTwoHash(cur, &proof[i])
+		} else {
+			cur = MergeTwoHash(&proof[i], cur)
+		}
+		index /= 2
+	}
+	return index == 0 && cur.IsEqual(root)
+}
+
 // MergeTwoHash computes the hash of the concatenate of two hash.
 func MergeTwoHash(l *hash.Hash, r *hash.Hash) *hash.Hash {
 	result := hash.THashH(append(append([]byte{}, (*l)[:]...), (*r)[:]...))
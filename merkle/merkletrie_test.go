@@ -44,6 +44,32 @@ func TestNewMerkle(t *testing.T) {
 	})
 }
 
+func TestMerkleProof(t *testing.T) {
+	Convey("Every leaf should be proved by its proof", t, func() {
+		for _, n := range []int{1, 2, 3, 4, 5, 7, 8, 13} {
+			items := make([]*hash.Hash, n)
+			for i := range items {
+				items[i] = &hash.Hash{}
+				rand.Read(items[i][:])
+			}
+			merkle := NewMerkle(items)
+			root := merkle.GetRoot()
+			for i := range items {
+				proof := merkle.GetProof(uint64(i))
+				So(proof, ShouldNotBeNil)
+				So(VerifyProof(items[i], uint64(i), proof, root), ShouldBeTrue)
+				// wrong position or leaf
+				if i%2 == 0 && i+1 < n {
+					So(VerifyProof(items[i], uint64(i+1), proof, root), ShouldBeFalse)
+				}
+				So(VerifyProof(items[i], uint64(i)+uint64(len(items)*2), proof, root), ShouldBeFalse)
+				So(VerifyProof(&hash.Hash{}, uint64(i), proof, root), ShouldBeFalse)
+			}
+			So(merkle.GetProof(uint64(n)), ShouldBeNil)
+		}
+	})
+}
+
 func mergeHash(h0 *hash.Hash, h1 *hash.Hash) *hash.Hash {
 	h := hash.THashH(append(h0[:], h1[:]...))
 	return &h
@@ -18,11 +18,13 @@ package metric
 
 import (
 	"expvar"
+	"net"
 	"net/http"
 	"runtime"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	mw "github.com/zserge/metric"
 
 	"github.com/CovenantSQL/CovenantSQL/utils"
@@ -91,3 +93,20 @@ func InitMetricWeb(metricWeb string) (err error) {
 	}()
 	return
 }
+
+// InitPrometheusExporter starts the Prometheus /metrics endpoint on addr, which exposes the
+// metrics registered to the default prometheus registry, including the RPC metrics.
+func InitPrometheusExporter(addr string) (err error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		err = errors.Wrapf(err, "listen prometheus exporter on %s failed", addr)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		_ = http.Serve(l, mux)
+	}()
+	log.WithField("addr", l.Addr()).Info("prometheus exporter started")
+	return
+}
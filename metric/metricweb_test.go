@@ -2,12 +2,14 @@ package metric
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 
+	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 )
 
@@ -29,3 +31,26 @@ func TestInitMetricWeb(t *testing.T) {
 		So(string(buf), ShouldContainSubstring, "go:alloc")
 	})
 }
+
+func TestInitPrometheusExporter(t *testing.T) {
+	Convey("init prometheus exporter", t, func() {
+		err := InitPrometheusExporter("127.0.0.1:0")
+		So(err, ShouldBeNil)
+		ports, err := utils.GetRandomPorts("127.0.0.1", 1025, 60000, 1)
+		So(err, ShouldBeNil)
+		addr := fmt.Sprintf("127.0.0.1:%d", ports[0])
+		err = InitPrometheusExporter(addr)
+		So(err, ShouldBeNil)
+		err = InitPrometheusExporter(addr)
+		So(err, ShouldNotBeNil)
+
+		rpc.ObserveDial("tcp", time.Now(), nil)
+		resp, err := http.Get("http://" + addr + "/metrics")
+		So(err, ShouldBeNil)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, "go_goroutines")
+		So(string(body), ShouldContainSubstring, `covenantsql_rpc_dial_duration_seconds_count{result="succ",transport="tcp"} 1`)
+	})
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/route"
+)
+
+var (
+	// ErrAnonymousQuotaExceeded indicates that the anonymous session from the remote IP is
+	// rejected by the quota.
+	ErrAnonymousQuotaExceeded = errors.New("anonymous session quota exceeded")
+
+	// defaultAnonymousQuotas defines the anonymous session limits of each node role, block
+	// producers are the entry points of new nodes so they accept more anonymous sessions.
+	defaultAnonymousQuotas = map[string]conf.AnonymousQuota{
+		conf.BlockProducerBuildTag: {
+			MaxConcurrent:      1024,
+			MaxConcurrentPerIP: 64,
+			RatePerIP:          20,
+			BurstPerIP:         100,
+			AllowedMethods:     []string{route.DHTPing.String()},
+		},
+		conf.MinerBuildTag: {
+			MaxConcurrent:      256,
+			MaxConcurrentPerIP: 16,
+			RatePerIP:          5,
+			BurstPerIP:         20,
+			AllowedMethods:     []string{route.DHTPing.String()},
+		},
+	}
+	fallbackAnonymousQuota = conf.AnonymousQuota{
+		MaxConcurrent:      128,
+		MaxConcurrentPerIP: 64,
+		RatePerIP:          20,
+		BurstPerIP:         100,
+		AllowedMethods:     []string{route.DHTPing.String()},
+	}
+
+	defaultAnonymousLimiter = &anonymousLimiter{
+		sessions: make(map[string]int),
+		buckets:  make(map[string]*tokenBucket),
+	}
+)
+
+// anonymousQuota returns the anonymous session quota of the node role overridden by config.
+func anonymousQuota() (quota conf.AnonymousQuota) {
+	var ok bool
+	if quota, ok = defaultAnonymousQuotas[conf.RoleTag[:1]]; !ok {
+		quota = fallbackAnonymousQuota
+	}
+	if conf.GConf == nil || conf.GConf.AnonymousQuota == nil {
+		return
+	}
+	override := conf.GConf.AnonymousQuota
+	if override.MaxConcurrent != 0 {
+		quota.MaxConcurrent = override.MaxConcurrent
+	}
+	if override.MaxConcurrentPerIP != 0 {
+		quota.MaxConcurrentPerIP = override.MaxConcurrentPerIP
+	}
+	if override.RatePerIP != 0 {
+		quota.RatePerIP = override.RatePerIP
+	}
+	if override.BurstPerIP != 0 {
+		quota.BurstPerIP = override.BurstPerIP
+	}
+	if override.AllowedMethods != nil {
+		quota.AllowedMethods = override.AllowedMethods
+	}
+	return
+}
+
+// IsAnonymousMethodAllowed reports whether the RPC method is callable by anonymous sessions.
+func IsAnonymousMethodAllowed(method string) bool {
+	for _, v := range anonymousQuota().AllowedMethods {
+		if v == method {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenBucket is the new session rate limiter of a remote IP.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// anonymousLimiter limits the concurrent and new anonymous sessions of each listening address
+// and each remote IP.
+type anonymousLimiter struct {
+	sync.Mutex
+	sessions map[string]int          // local address or local address + remote IP -> count
+	buckets  map[string]*tokenBucket // local address + remote IP -> bucket
+}
+
+// acquire takes an anonymous session slot, the returned release function must be called after
+// the session is closed.
+func (l *anonymousLimiter) acquire(
+	local, remote net.Addr, quota conf.AnonymousQuota) (release func(), err error,
+) {
+	var (
+		ip       = remoteIP(remote)
+		totalKey string
+		ipKey    = ip
+		now      = time.Now()
+	)
+	if local != nil {
+		totalKey = local.String()
+		ipKey = totalKey + "<-" + ip
+	}
+	l.Lock()
+	defer l.Unlock()
+	if quota.MaxConcurrent > 0 && l.sessions[totalKey] >= quota.MaxConcurrent {
+		err = errors.Wrap(ErrAnonymousQuotaExceeded, "too many anonymous sessions")
+		return
+	}
+	if quota.MaxConcurrentPerIP > 0 && l.sessions[ipKey] >= quota.MaxConcurrentPerIP {
+		err = errors.Wrapf(ErrAnonymousQuotaExceeded, "too many anonymous sessions from %s", ip)
+		return
+	}
+	if quota.RatePerIP > 0 {
+		burst := float64(quota.BurstPerIP)
+		if burst < 1 {
+			burst = 1
+		}
+		l.prune(now, quota.RatePerIP, burst)
+		b, ok := l.buckets[ipKey]
+		if !ok {
+			b = &tokenBucket{tokens: burst, last: now}
+			l.buckets[ipKey] = b
+		}
+		if b.tokens += now.Sub(b.last).Seconds() * quota.RatePerIP; b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+		if b.tokens < 1 {
+			err = errors.Wrapf(ErrAnonymousQuotaExceeded, "anonymous session rate exceeded from %s", ip)
+			return
+		}
+		b.tokens--
+	}
+	l.sessions[totalKey]++
+	l.sessions[ipKey]++
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			l.Lock()
+			defer l.Unlock()
+			for _, key := range []string{totalKey, ipKey} {
+				if l.sessions[key]--; l.sessions[key] <= 0 {
+					delete(l.sessions, key)
+				}
+			}
+		})
+	}
+	return
+}
+
+// prune removes the refilled buckets when there are too many of them, lock must be held.
+func (l *anonymousLimiter) prune(now time.Time, rate, burst float64) {
+	if len(l.buckets) < 4096 {
+		return
+	}
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.buckets, k)
+		}
+	}
+}
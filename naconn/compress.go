@@ -0,0 +1,260 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+// Payload compression algorithms negotiated in handshake, client offers a bit set of algorithms
+// and server chooses one of them.
+const (
+	CompressNone   byte = 0
+	CompressSnappy byte = 1 << 0
+	CompressZstd   byte = 1 << 1
+)
+
+const (
+	frameHeaderSize = 5 // flag + uint32 length
+	// maxFrameSize limits the raw and compressed size of a single frame.
+	maxFrameSize = 1 << 20
+
+	frameRaw        byte = 0
+	frameCompressed byte = 1
+)
+
+var (
+	// ErrBadFrame indicates a malformed compression frame.
+	ErrBadFrame = errors.New("bad compression frame")
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// CompressionByName returns the compression algorithm of name, unknown name means no
+// compression.
+func CompressionByName(name string) byte {
+	switch strings.ToLower(name) {
+	case "snappy":
+		return CompressSnappy
+	case "zstd":
+		return CompressZstd
+	default:
+		return CompressNone
+	}
+}
+
+// localCompression returns the configured compression algorithm.
+func localCompression() byte {
+	if conf.GConf != nil {
+		return CompressionByName(conf.GConf.Compression)
+	}
+	return CompressNone
+}
+
+// compressThreshold returns the minimum payload size to be compressed.
+func compressThreshold() int {
+	if conf.GConf != nil && conf.GConf.CompressThreshold > 0 {
+		return conf.GConf.CompressThreshold
+	}
+	return conf.DefaultCompressThreshold
+}
+
+// chooseCompression returns the algorithm used by server for the client offer, server prefers
+// its own configured algorithm and accepts the other offered ones unless compression is disabled
+// locally.
+func chooseCompression(offer byte) byte {
+	local := localCompression()
+	switch {
+	case local == CompressNone:
+		return CompressNone
+	case offer&local != 0:
+		return local
+	case offer&CompressZstd != 0:
+		return CompressZstd
+	case offer&CompressSnappy != 0:
+		return CompressSnappy
+	default:
+		return CompressNone
+	}
+}
+
+func initZstd() {
+	zstdOnce.Do(func() {
+		// EncodeAll and DecodeAll are safe for concurrent use
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxFrameSize))
+	})
+}
+
+// compressor frames the payload of the connection and compresses the frames larger than
+// threshold.
+type compressor struct {
+	algo      byte
+	threshold int
+
+	wLock  sync.Mutex
+	wBuf   []byte
+	wFrame []byte
+
+	rLock   sync.Mutex
+	rHeader [frameHeaderSize]byte
+	rBuf    []byte
+	pending []byte
+}
+
+func newCompressor(algo byte, threshold int) *compressor {
+	if algo == CompressZstd {
+		initZstd()
+	}
+	return &compressor{algo: algo, threshold: threshold}
+}
+
+func (c *compressor) compress(dst, src []byte) []byte {
+	switch c.algo {
+	case CompressSnappy:
+		return snappy.Encode(dst[:cap(dst)], src)
+	case CompressZstd:
+		return zstdEncoder.EncodeAll(src, dst[:0])
+	}
+	return nil
+}
+
+func (c *compressor) decompress(dst, src []byte) (out []byte, err error) {
+	switch c.algo {
+	case CompressSnappy:
+		var n int
+		if n, err = snappy.DecodedLen(src); err != nil {
+			return
+		}
+		if n > maxFrameSize {
+			err = ErrBadFrame
+			return
+		}
+		return snappy.Decode(dst[:cap(dst)], src)
+	case CompressZstd:
+		return zstdDecoder.DecodeAll(src, dst[:0])
+	}
+	return nil, ErrBadFrame
+}
+
+// write writes b to w as frames.
+func (c *compressor) write(w io.Writer, b []byte) (n int, err error) {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		flag, payload := frameRaw, chunk
+		if len(chunk) >= c.threshold {
+			if compressed := c.compress(c.wBuf, chunk); len(compressed) < len(chunk) {
+				flag, payload = frameCompressed, compressed
+				c.wBuf = compressed
+			}
+		}
+		// write the frame at once to avoid extra encryption and syscall
+		frame := append(c.wFrame[:0], flag, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+		frame = append(frame, payload...)
+		c.wFrame = frame
+		if _, err = w.Write(frame); err != nil {
+			return
+		}
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return
+}
+
+// read reads the decoded payload from the frames of r.
+func (c *compressor) read(r io.Reader, b []byte) (n int, err error) {
+	c.rLock.Lock()
+	defer c.rLock.Unlock()
+	for len(c.pending) == 0 {
+		if _, err = io.ReadFull(r, c.rHeader[:]); err != nil {
+			return
+		}
+		size := int(binary.BigEndian.Uint32(c.rHeader[1:]))
+		if size > maxFrameSize {
+			err = ErrBadFrame
+			return
+		}
+		payload := make([]byte, size)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return
+		}
+		switch c.rHeader[0] {
+		case frameRaw:
+			c.pending = payload
+		case frameCompressed:
+			if c.rBuf, err = c.decompress(c.rBuf, payload); err != nil {
+				err = errors.Wrap(err, "decompress frame failed")
+				return
+			}
+			c.pending = c.rBuf
+		default:
+			err = ErrBadFrame
+			return
+		}
+	}
+	n = copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return
+}
+
+// Compression returns the negotiated compression algorithm of the connection.
+func (c *NAConn) Compression() byte {
+	if c.compressor == nil {
+		return CompressNone
+	}
+	return c.compressor.algo
+}
+
+// setCompression enables payload framing and compression on the connection.
+func (c *NAConn) setCompression(algo byte) {
+	if algo != CompressNone {
+		c.compressor = newCompressor(algo, compressThreshold())
+	}
+}
+
+// Read reads the payload from the connection.
+func (c *NAConn) Read(b []byte) (n int, err error) {
+	if c.compressor == nil {
+		return c.CryptoConn.Read(b)
+	}
+	return c.compressor.read(c.CryptoConn, b)
+}
+
+// Write writes the payload to the connection, which may be compressed.
+func (c *NAConn) Write(b []byte) (n int, err error) {
+	if c.compressor == nil {
+		return c.CryptoConn.Write(b)
+	}
+	return c.compressor.write(c.CryptoConn, b)
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ConfirmNonceSize is the server nonce size of key confirmation message.
+	ConfirmNonceSize = 32
+	// ConfirmSize is the key confirmation message size with server nonce + server choice of the
+	// handshake offer + MAC.
+	ConfirmSize = ConfirmNonceSize + OfferSize + sha256.Size
+
+	confirmLabel = "CovenantSQL ETLS key confirmation"
+)
+
+// ErrKeyConfirmationFailed indicates that the server failed to prove the possession of the
+// shared key.
+var ErrKeyConfirmationFailed = errors.New("key confirmation failed")
+
+// confirmationMAC computes the MAC over the handshake transcript and server nonce with the
+// negotiation result.
+func confirmationMAC(key, transcript, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(confirmLabel))
+	_, _ = mac.Write(transcript)
+	_, _ = mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// sendConfirmation sends the encrypted key confirmation message with the server choice of the
+// handshake offer to client.
+func (c *NAConn) sendConfirmation(chosen []byte) (err error) {
+	buf := make([]byte, ConfirmSize)
+	if _, err = rand.Read(buf[:ConfirmNonceSize]); err != nil {
+		err = errors.Wrap(err, "generate confirmation nonce failed")
+		return
+	}
+	copy(buf[ConfirmNonceSize:], chosen[:OfferSize])
+	copy(buf[ConfirmNonceSize+OfferSize:], confirmationMAC(c.key, c.transcript, buf[:ConfirmNonceSize+OfferSize]))
+	if _, err = c.CryptoConn.Write(buf); err != nil {
+		err = errors.Wrap(err, "write key confirmation failed")
+		return
+	}
+	return
+}
+
+// verifyConfirmation reads the encrypted key confirmation message from server, verifies it and
+// returns the server choice of the handshake offer.
+func (c *NAConn) verifyConfirmation() (chosen []byte, err error) {
+	buf := make([]byte, ConfirmSize)
+	if _, err = io.ReadFull(c.CryptoConn, buf); err != nil {
+		err = errors.Wrap(err, "read key confirmation failed")
+		return
+	}
+	expected := confirmationMAC(c.key, c.transcript, buf[:ConfirmNonceSize+OfferSize])
+	if !hmac.Equal(expected, buf[ConfirmNonceSize+OfferSize:]) {
+		err = ErrKeyConfirmationFailed
+		return
+	}
+	chosen = buf[ConfirmNonceSize : ConfirmNonceSize+OfferSize]
+	return
+}
@@ -18,11 +18,13 @@ package naconn
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"net"
+	"time"
 
 	"github.com/pkg/errors"
 
-	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
@@ -48,6 +50,19 @@ type NAConn struct {
 	// The following fields may be rewritten during handshake.
 	isAnonymous bool
 	remote      proto.RawNodeID
+
+	// Shared key and client header for key confirmation.
+	key        []byte
+	transcript []byte
+
+	// Payload framing and compression, nil if compression is not negotiated.
+	compressor *compressor
+	// Cipher suite and session key rekeying negotiated in handshake.
+	suite etls.CipherSuite
+	rekey bool
+
+	// release frees the anonymous session quota of server side anonymous NAConn.
+	release func()
 }
 
 // NewServerConn takes a raw connection and returns a new server side NAConn.
@@ -57,14 +72,6 @@ func NewServerConn(conn net.Conn) *NAConn {
 	}
 }
 
-//func NewClientConn(conn net.Conn) *NAConn {
-//	return &NAConn{
-//		CryptoConn:  etls.NewConn(conn, nil),
-//		isClient:    true,
-//		isAnonymous: true,
-//	}
-//}
-
 // Remote returns the remote node ID of the NAConn.
 func (c *NAConn) Remote() proto.RawNodeID {
 	return c.remote
@@ -80,39 +87,70 @@ func (c *NAConn) Handshake() (err error) {
 
 func (c *NAConn) serverHandshake() (err error) {
 	headerBuf := make([]byte, HeaderSize)
-	rCount, err := c.CryptoConn.Conn.Read(headerBuf)
-	if err != nil {
+	// check magic first to fail fast on non-ETLS clients
+	if _, err = io.ReadFull(c.CryptoConn.Conn, headerBuf[:etls.MagicSize]); err != nil {
 		err = errors.Wrap(err, "read node header error")
 		return
 	}
 
-	if rCount != HeaderSize {
-		err = errors.New("invalid ETLS header size")
+	if !bytes.Equal(headerBuf[:etls.MagicSize], etls.MagicBytes[:]) {
+		err = errors.New("bad ETLS header")
 		return
 	}
 
-	if !bytes.Equal(headerBuf[:etls.MagicSize], etls.MagicBytes[:]) {
-		err = errors.New("bad ETLS header")
+	if _, err = io.ReadFull(c.CryptoConn.Conn, headerBuf[etls.MagicSize:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.Wrap(err, "invalid ETLS header size")
+			return
+		}
+		err = errors.Wrap(err, "read node header error")
 		return
 	}
 
 	// headerBuf len is hash.HashBSize, so there won't be any error
 	idHash, _ := hash.NewHash(headerBuf[etls.MagicSize : etls.MagicSize+hash.HashBSize])
 	rawNodeID := &proto.RawNodeID{Hash: *idHash}
-	// TODO(auxten): compute the nonce and check difficulty
-	_, _ = cpuminer.Uint256FromBytes(headerBuf[etls.MagicSize+hash.HashBSize:])
+	// headerBuf len is cpuminer.Uint256Size, so there won't be any error
+	nonce, _ := cpuminer.Uint256FromBytes(headerBuf[etls.MagicSize+hash.HashBSize:])
 
 	isAnonymous := rawNodeID.IsEqual(&kms.AnonymousRawNodeID.Hash)
+	if !isAnonymous {
+		if err = verifyNodeID(defaultResolver, rawNodeID, nonce); err != nil {
+			err = errors.Wrapf(err, "verify node id failed, remote: %s", rawNodeID.String())
+			return
+		}
+	}
 	symmetricKey, err := GetSharedSecretWith(defaultResolver, rawNodeID, isAnonymous)
 	if err != nil {
 		err = errors.Wrapf(err, "get shared secret, target: %s", rawNodeID.String())
 		return
 	}
-	cipher := etls.NewCipher(symmetricKey)
-	c.CryptoConn.Cipher = cipher // reset cipher
+	c.CryptoConn.Cipher = newCipher(symmetricKey, etls.AES256CFB, false) // reset cipher
 	c.remote = *rawNodeID
 	c.isAnonymous = isAnonymous
+	c.key = symmetricKey
+
+	// read the encrypted handshake offer following the header
+	offer := make([]byte, OfferSize)
+	if _, err = io.ReadFull(c.CryptoConn, offer); err != nil {
+		err = errors.Wrap(err, "read handshake offer failed")
+		return
+	}
+	c.transcript = append(headerBuf, offer...)
+	compression := chooseCompression(offer[offerCompression])
+	suite := chooseCipherSuite(offer[offerCipherSuite])
+	features := chooseFeatures(offer[offerFeatures])
 
+	// prove the possession of the shared key to client
+	chosen := make([]byte, OfferSize)
+	chosen[offerCompression] = compression
+	chosen[offerCipherSuite] = byte(suite)
+	chosen[offerFeatures] = features
+	if err = c.sendConfirmation(chosen); err != nil {
+		return
+	}
+	c.setCompression(compression)
+	c.setCipher(suite, features)
 	return
 }
 
@@ -149,15 +187,77 @@ func (c *NAConn) clientHandshake() (err error) {
 		err = errors.Errorf("write header size not match %d", wrote)
 		return
 	}
+
+	// offer the compression algorithm and cipher suites with encryption
+	offer := make([]byte, OfferSize)
+	offer[offerCompression] = localCompression()
+	offer[offerCipherSuite] = offerCipherSuites()
+	offer[offerFeatures] = localFeatures
+	if _, err = c.CryptoConn.Write(offer); err != nil {
+		err = errors.Wrap(err, "write handshake offer failed")
+		return
+	}
+
+	// verify that server holds the same shared key before use
+	c.transcript = append(writeBuf, offer...)
+	var chosen []byte
+	if chosen, err = c.verifyConfirmation(); err != nil {
+		if errors.Cause(err) == ErrKeyConfirmationFailed && !c.isAnonymous {
+			// the cached shared key may be derived from a stale public key of the remote node
+			InvalidateSharedSecret(&c.remote)
+		}
+		err = errors.Wrap(err, "verify server key confirmation failed")
+		return
+	}
+	compression := chosen[offerCompression]
+	if compression&offer[offerCompression] != compression {
+		err = errors.Errorf("server chose unexpected compression %d", compression)
+		return
+	}
+	var suite etls.CipherSuite
+	if suite, err = chosenCipherSuite(chosen[offerCipherSuite], offer[offerCipherSuite]); err != nil {
+		return
+	}
+	features := chosen[offerFeatures]
+	if features&^offer[offerFeatures] != 0 {
+		err = errors.Errorf("server chose unexpected features %#x", features)
+		return
+	}
+	c.setCompression(compression)
+	c.setCipher(suite, features)
 	return
 }
 
 // Accept takes the ownership of conn and accepts it as a NAConn.
+//
+// The handshake is bounded by the configured handshake timeout, and the concurrent handshakes
+// from the same remote IP are limited, so that slow clients can not hold the accepting
+// goroutines.
 func Accept(conn net.Conn) (*NAConn, error) {
+	release, err := defaultLimiter.acquire(conn.RemoteAddr(), maxHandshakesPerIP())
+	if err != nil {
+		handshakeFailures.WithLabelValues("server").Inc()
+		return nil, err
+	}
+	defer release()
+	if timeout := handshakeTimeout(); timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
 	naconn := NewServerConn(conn)
 	if err := naconn.Handshake(); err != nil {
+		handshakeFailures.WithLabelValues("server").Inc()
 		return nil, err
 	}
+	if naconn.isAnonymous {
+		if naconn.release, err = defaultAnonymousLimiter.acquire(
+			conn.LocalAddr(), conn.RemoteAddr(), anonymousQuota(),
+		); err != nil {
+			anonymousRejections.Inc()
+			return nil, err
+		}
+	}
+	// reset deadline, the timeout only covers the handshake process
+	_ = conn.SetDeadline(time.Time{})
 	return naconn, nil
 }
 
@@ -168,6 +268,46 @@ func Dial(remote proto.NodeID) (conn net.Conn, err error) {
 
 // DialEx connects to the node with remote node id.
 func DialEx(remote proto.NodeID, isAnonymous bool) (conn net.Conn, err error) {
+	return DialExContext(context.Background(), remote, isAnonymous)
+}
+
+// DialContext connects to the node with remote node id, the dial and handshake process will be
+// aborted if ctx is canceled or expired.
+func DialContext(ctx context.Context, remote proto.NodeID) (conn net.Conn, err error) {
+	return DialExContext(ctx, remote, false)
+}
+
+// DialExContext connects to the node with remote node id, the dial and handshake process will be
+// aborted if ctx is canceled or expired.
+func DialExContext(ctx context.Context, remote proto.NodeID, isAnonymous bool) (conn net.Conn, err error) {
+	nodeAddrs, err := ResolveAddrs(remote)
+	if err != nil {
+		return
+	}
+
+	iconn, err := dialAddrs(ctx, nodeAddrs)
+	if err != nil {
+		return
+	}
+
+	return NewClientConn(ctx, iconn, remote, isAnonymous)
+}
+
+// Resolve returns the address of the remote node with the registered resolver.
+func Resolve(remote proto.NodeID) (addr string, err error) {
+	var rawNodeID = remote.ToRawNodeID()
+	if addr, err = defaultResolver.Resolve(rawNodeID); err != nil {
+		err = errors.Wrapf(err, "resolve %s failed", rawNodeID.String())
+		return
+	}
+	return
+}
+
+// NewClientConn takes the ownership of conn and does the client side handshake with the remote
+// node over it, the handshake process will be aborted if ctx is canceled or expired.
+func NewClientConn(
+	ctx context.Context, conn net.Conn, remote proto.NodeID, isAnonymous bool) (*NAConn, error,
+) {
 	var rawNodeID = remote.ToRawNodeID()
 	/*
 		As a common practice of PKI, we should add some randomness to the ECDHed pre-master-key
@@ -186,33 +326,81 @@ func DialEx(remote proto.NodeID, isAnonymous bool) (conn net.Conn, err error) {
 	*/
 	symmetricKey, err := GetSharedSecretWith(defaultResolver, rawNodeID, isAnonymous)
 	if err != nil {
-		return
+		_ = conn.Close()
+		return nil, err
 	}
 
-	nodeAddr, err := defaultResolver.Resolve(rawNodeID)
-	if err != nil {
-		err = errors.Wrapf(err, "resolve %s failed", rawNodeID.String())
-		return
-	}
+	// the handshake is encrypted with the default cipher suite
+	cipher := newCipher(symmetricKey, etls.AES256CFB, false)
 
-	cipher := etls.NewCipher(symmetricKey)
-	iconn, err := net.DialTimeout("tcp", nodeAddr, conf.TCPDialTimeout)
-	if err != nil {
-		err = errors.Wrapf(err, "connect to node %s failed", nodeAddr)
-		return
+	// bound the handshake by the context deadline, and abort it on cancellation
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else if timeout := handshakeTimeout(); timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
 	}
+	stop, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
 
 	naconn := &NAConn{
-		CryptoConn:  etls.NewConn(iconn, cipher),
+		CryptoConn:  etls.NewConn(conn, cipher),
 		isAnonymous: isAnonymous,
 		isClient:    true,
 		remote:      *rawNodeID,
+		key:         symmetricKey,
 	}
 
-	if err = naconn.Handshake(); err != nil {
-		err = errors.Wrapf(err, "connect %s %s failed", rawNodeID.String(), nodeAddr)
-		return
+	err = naconn.Handshake()
+	close(stop)
+	<-exited
+	if err != nil {
+		handshakeFailures.WithLabelValues("client").Inc()
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			// conn deadline may be reached a little earlier than the context
+			err = context.DeadlineExceeded
+		}
+		err = errors.Wrapf(err, "connect %s %s failed", rawNodeID.String(), conn.RemoteAddr())
+		return nil, err
 	}
 
+	// reset deadline, the context only covers the handshake process
+	_ = conn.SetDeadline(time.Time{})
+
 	return naconn, nil
 }
+
+// Close closes the connection and frees its anonymous session quota.
+func (c *NAConn) Close() error {
+	if c.release != nil {
+		defer c.release()
+	}
+	return c.CryptoConn.Close()
+}
+
+// Derive returns a new NAConn over conn which shares the remote node and the shared key of the
+// handshaked NAConn c, without handshaking again. Both sides should derive the same way.
+func (c *NAConn) Derive(conn net.Conn) *NAConn {
+	derived := &NAConn{
+		CryptoConn:  etls.NewConn(conn, newCipher(c.key, c.suite, c.rekey)),
+		isClient:    c.isClient,
+		isAnonymous: c.isAnonymous,
+		remote:      c.remote,
+		key:         c.key,
+		suite:       c.suite,
+		rekey:       c.rekey,
+	}
+	if c.compressor != nil {
+		derived.compressor = newCompressor(c.compressor.algo, c.compressor.threshold)
+	}
+	return derived
+}
@@ -17,12 +17,16 @@
 package naconn
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -31,7 +35,11 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/utils"
@@ -63,6 +71,15 @@ func (r *simpleResolver) Resolve(id *proto.RawNodeID) (addr string, err error) {
 	return
 }
 
+func (r *simpleResolver) ResolveAddrs(id *proto.RawNodeID) (addrs []string, err error) {
+	var node *proto.Node
+	if node, err = r.ResolveEx(id); err != nil {
+		return
+	}
+	addrs = node.Addresses()
+	return
+}
+
 func (r *simpleResolver) ResolveEx(id *proto.RawNodeID) (*proto.Node, error) {
 	if node, ok := r.nodes.Load(*id); ok {
 		return node.(*proto.Node), nil
@@ -91,6 +108,624 @@ func TestNAConn(t *testing.T) {
 		So(ok, ShouldBeTrue)
 		So(nerr.Timeout(), ShouldBeTrue)
 	})
+	Convey("Test dial with context", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		resolver := &simpleResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		resolver.registerNode(&proto.Node{
+			Addr:      l.Addr().String(),
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		})
+		RegisterResolver(resolver)
+		// Canceled context should abort dialing
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = DialExContext(ctx, nodeinfo.ID, true)
+		So(errors.Cause(err), ShouldEqual, context.Canceled)
+		// Expired context should abort dialing
+		ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		_, err = DialContext(ctx, nodeinfo.ID)
+		So(errors.Cause(err), ShouldResemble, context.DeadlineExceeded)
+		// Handshake should be aborted by context if server never responds
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = DialContext(ctx, nodeinfo.ID)
+		So(errors.Cause(err), ShouldResemble, context.DeadlineExceeded)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		// Handshake should be aborted when context is canceled in the middle of it
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start = time.Now()
+		_, err = DialContext(ctx, nodeinfo.ID)
+		So(errors.Cause(err), ShouldEqual, context.Canceled)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		// Serve handshakes
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					if naconn, err := Accept(conn); err == nil {
+						_, _ = ioutil.ReadAll(naconn)
+					}
+					_ = conn.Close()
+				}()
+			}
+		}()
+		// Connection dialed with context should not be affected by the context afterwards
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		conn, err := DialContext(ctx, nodeinfo.ID)
+		So(err, ShouldBeNil)
+		defer func() { _ = conn.Close() }()
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		_, err = conn.Write([]byte("ping"))
+		So(err, ShouldBeNil)
+	})
+	Convey("Test address fallback", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					if naconn, err := Accept(conn); err == nil {
+						_, _ = ioutil.ReadAll(naconn)
+					}
+					_ = conn.Close()
+				}()
+			}
+		}()
+		// Get a refused address
+		closed, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		refused := closed.Addr().String()
+		_ = closed.Close()
+
+		resolver := &simpleResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		node := &proto.Node{
+			Addr:      refused,
+			Addrs:     []string{refused, "", l.Addr().String()},
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		}
+		So(node.Addresses(), ShouldResemble, []string{refused, l.Addr().String()})
+		resolver.registerNode(node)
+		RegisterResolver(resolver)
+
+		fallback := conf.GConf.DialFallback
+		defer func() { conf.GConf.DialFallback = fallback }()
+		for _, v := range []string{conf.DialFallbackParallel, conf.DialFallbackSequential} {
+			conf.GConf.DialFallback = v
+			conn, err := DialContext(context.Background(), nodeinfo.ID)
+			So(err, ShouldBeNil)
+			So(conn.RemoteAddr().String(), ShouldEqual, l.Addr().String())
+			_ = conn.Close()
+		}
+
+		// All addresses failed
+		node.Addrs = nil
+		for _, v := range []string{conf.DialFallbackParallel, conf.DialFallbackSequential} {
+			conf.GConf.DialFallback = v
+			_, err = DialContext(context.Background(), nodeinfo.ID)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, refused)
+		}
+		_, err = dialParallel(context.Background(), []string{refused, refused}, time.Millisecond)
+		So(err, ShouldNotBeNil)
+		So(strings.Count(err.Error(), "connect to node"), ShouldEqual, 2)
+	})
+	Convey("Test connection tuning", t, func(c C) {
+		origin := conf.GConf.Conn
+		defer func() { conf.GConf.Conn = origin }()
+		conf.GConf.Conn = nil
+		tuning := ConnTuning()
+		So(tuning.KeepAlive, ShouldEqual, conf.DefaultTCPKeepAlive)
+		So(tuning.DisableNoDelay, ShouldBeFalse)
+		So(tuning.HeartbeatInterval, ShouldEqual, conf.DefaultHeartbeatInterval)
+		So(tuning.HeartbeatTimeout, ShouldEqual, conf.DefaultHeartbeatTimeout)
+		conf.GConf.Conn = &conf.ConnTuning{
+			KeepAlive:         -1,
+			DisableNoDelay:    true,
+			ReadBufferSize:    1 << 20,
+			WriteBufferSize:   1 << 20,
+			HeartbeatInterval: time.Minute,
+		}
+		tuning = ConnTuning()
+		So(tuning.KeepAlive, ShouldEqual, -1)
+		So(tuning.HeartbeatInterval, ShouldEqual, time.Minute)
+		So(tuning.HeartbeatTimeout, ShouldEqual, time.Minute)
+
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		conn, err := dialAddr(context.Background(), l.Addr().String())
+		So(err, ShouldBeNil)
+		defer func() { _ = conn.Close() }()
+		sconn, err := l.Accept()
+		So(err, ShouldBeNil)
+		defer func() { _ = sconn.Close() }()
+		So(TuneConn(sconn), ShouldBeNil)
+		// non-TCP connection is skipped
+		pc, ps := net.Pipe()
+		defer func() { _, _ = pc.Close(), ps.Close() }()
+		So(TuneConn(pc), ShouldBeNil)
+	})
+	Convey("Test node id verification", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		resolver := &simpleResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		resolver.registerNode(&proto.Node{
+			Addr:      l.Addr().String(),
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		})
+		RegisterResolver(resolver)
+		handshake := func(id []byte, nonce *cpuminer.Uint256) error {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			So(err, ShouldBeNil)
+			defer func() { _ = conn.Close() }()
+			header := make([]byte, 0, HeaderSize)
+			header = append(header, etls.MagicBytes[:]...)
+			header = append(header, id...)
+			header = append(header, nonce.Bytes()...)
+			_, err = conn.Write(header)
+			So(err, ShouldBeNil)
+			if key, err := GetSharedSecretWith(resolver, nodeinfo.ID.ToRawNodeID(), false); err == nil {
+				// handshake offer
+				_, _ = etls.NewConn(conn, newCipher(key, etls.AES256CFB, false)).Write(make([]byte, OfferSize))
+			}
+			sconn, err := l.Accept()
+			So(err, ShouldBeNil)
+			defer func() { _ = sconn.Close() }()
+			_, err = Accept(sconn)
+			return err
+		}
+		// Node ID with low difficulty
+		lowID := bytes.Repeat([]byte{0x7f}, hash.HashSize)
+		err = handshake(lowID, &nodeinfo.Nonce)
+		So(errors.Cause(err), ShouldEqual, ErrNodeIDDifficultyTooLow)
+		// Node ID with mismatched nonce
+		err = handshake(nodeinfo.ID.ToRawNodeID().AsBytes(), &cpuminer.Uint256{A: 1})
+		So(errors.Cause(err), ShouldEqual, ErrNodeIDNonceNotMatch)
+		// Valid node ID and nonce
+		err = handshake(nodeinfo.ID.ToRawNodeID().AsBytes(), &nodeinfo.Nonce)
+		So(err, ShouldBeNil)
+	})
+	Convey("Test shared secret cache invalidation", t, func(c C) {
+		unittest := kms.Unittest
+		kms.Unittest = true // bypass node id nonce verification
+		defer func() { kms.Unittest = unittest }()
+		_, pub1, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		_, pub2, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		id := proto.NodeID(hash.HashH([]byte("rekeyed node")).String())
+		rawID := id.ToRawNodeID()
+		resolver := &simpleResolver{}
+		resolver.registerNode(&proto.Node{ID: id, PublicKey: pub1})
+
+		key1, err := GetSharedSecretWith(resolver, rawID, false)
+		So(err, ShouldBeNil)
+		// cached until the public key in kms is changed
+		resolver.registerNode(&proto.Node{ID: id, PublicKey: pub2})
+		key, err := GetSharedSecretWith(resolver, rawID, false)
+		So(err, ShouldBeNil)
+		So(key, ShouldResemble, key1)
+		err = kms.SetNode(&proto.Node{ID: id, PublicKey: pub1})
+		So(err, ShouldBeNil)
+		key, err = GetSharedSecretWith(resolver, rawID, false)
+		So(err, ShouldBeNil)
+		So(key, ShouldResemble, key1)
+		err = kms.SetNode(&proto.Node{ID: id, PublicKey: pub2})
+		So(err, ShouldBeNil)
+		key2, err := GetSharedSecretWith(resolver, rawID, false)
+		So(err, ShouldBeNil)
+		So(key2, ShouldNotResemble, key1)
+		// deleting node or explicit invalidation drops the cached key as well
+		resolver.registerNode(&proto.Node{ID: id, PublicKey: pub1})
+		err = kms.DelNode(id)
+		So(err, ShouldBeNil)
+		key, err = GetSharedSecretWith(resolver, rawID, false)
+		So(err, ShouldBeNil)
+		So(key, ShouldResemble, key1)
+		resolver.registerNode(&proto.Node{ID: id, PublicKey: pub2})
+		verifiedNodes.Add(id, cpuminer.Uint256{A: 1})
+		InvalidateSharedSecret(rawID)
+		So(verifiedNodes.Contains(id), ShouldBeFalse)
+		key, err = GetSharedSecretWith(resolver, rawID, false)
+		So(err, ShouldBeNil)
+		So(key, ShouldResemble, key2)
+		// node verification is dropped on public key change
+		verifiedNodes.Add(id, cpuminer.Uint256{A: 1})
+		err = kms.SetNode(&proto.Node{ID: id, PublicKey: pub2})
+		So(err, ShouldBeNil)
+		So(verifiedNodes.Contains(id), ShouldBeTrue)
+		err = kms.SetNode(&proto.Node{ID: id, PublicKey: pub1})
+		So(err, ShouldBeNil)
+		So(verifiedNodes.Contains(id), ShouldBeFalse)
+	})
+	Convey("Test server handshake hardening", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		header := make([]byte, 0, HeaderSize)
+		header = append(header, etls.MagicBytes[:]...)
+		header = append(header, kms.AnonymousRawNodeID.AsBytes()...)
+		header = append(header, (&cpuminer.Uint256{}).Bytes()...)
+		timeout := conf.GConf.HandshakeTimeout
+		conf.GConf.HandshakeTimeout = 200 * time.Millisecond
+		defer func() { conf.GConf.HandshakeTimeout = timeout }()
+
+		// Header sent in separated segments
+		conn, err := net.Dial("tcp", l.Addr().String())
+		So(err, ShouldBeNil)
+		defer func() { _ = conn.Close() }()
+		go func() {
+			_, _ = conn.Write(header[:10])
+			time.Sleep(50 * time.Millisecond)
+			_, _ = conn.Write(header[10:])
+			// handshake offer
+			_, _ = etls.NewConn(conn, newCipher([]byte(sharedSecret), etls.AES256CFB, false)).Write(make([]byte, OfferSize))
+		}()
+		sconn, err := l.Accept()
+		So(err, ShouldBeNil)
+		naconn, err := Accept(sconn)
+		So(err, ShouldBeNil)
+		So(naconn.isAnonymous, ShouldBeTrue)
+		_ = naconn.Close()
+
+		// Slow client should be timed out
+		conn, err = net.Dial("tcp", l.Addr().String())
+		So(err, ShouldBeNil)
+		defer func() { _ = conn.Close() }()
+		_, err = conn.Write(header[:10])
+		So(err, ShouldBeNil)
+		sconn, err = l.Accept()
+		So(err, ShouldBeNil)
+		start := time.Now()
+		_, err = Accept(sconn)
+		So(err, ShouldNotBeNil)
+		nerr, ok := errors.Cause(err).(net.Error)
+		So(ok, ShouldBeTrue)
+		So(nerr.Timeout(), ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		_ = sconn.Close()
+
+		// Concurrent handshakes from the same IP should be limited
+		limiter := &handshakeLimiter{pending: make(map[string]int)}
+		addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}
+		release1, err := limiter.acquire(addr, 2)
+		So(err, ShouldBeNil)
+		release2, err := limiter.acquire(&net.TCPAddr{IP: addr.IP, Port: 2}, 2)
+		So(err, ShouldBeNil)
+		_, err = limiter.acquire(&net.TCPAddr{IP: addr.IP, Port: 3}, 2)
+		So(errors.Cause(err), ShouldEqual, ErrTooManyHandshakes)
+		_, err = limiter.acquire(&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1}, 2)
+		So(err, ShouldBeNil)
+		release1()
+		release3, err := limiter.acquire(addr, 2)
+		So(err, ShouldBeNil)
+		release2()
+		release3()
+		So(limiter.pending, ShouldContainKey, "10.0.0.2")
+		So(limiter.pending, ShouldNotContainKey, "10.0.0.1")
+	})
+	Convey("Test anonymous session quota", t, func(c C) {
+		local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+		remote1 := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+		remote2 := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1}
+		limiter := &anonymousLimiter{
+			sessions: make(map[string]int),
+			buckets:  make(map[string]*tokenBucket),
+		}
+		// concurrent sessions
+		quota := conf.AnonymousQuota{MaxConcurrent: 3, MaxConcurrentPerIP: 2}
+		release1, err := limiter.acquire(local, remote1, quota)
+		So(err, ShouldBeNil)
+		release2, err := limiter.acquire(local, remote1, quota)
+		So(err, ShouldBeNil)
+		_, err = limiter.acquire(local, remote1, quota)
+		So(errors.Cause(err), ShouldEqual, ErrAnonymousQuotaExceeded)
+		release3, err := limiter.acquire(local, remote2, quota)
+		So(err, ShouldBeNil)
+		_, err = limiter.acquire(local, remote2, quota)
+		So(errors.Cause(err), ShouldEqual, ErrAnonymousQuotaExceeded)
+		release1()
+		release1() // should be idempotent
+		release4, err := limiter.acquire(local, remote2, quota)
+		So(err, ShouldBeNil)
+		for _, release := range []func(){release2, release3, release4} {
+			release()
+		}
+		So(limiter.sessions, ShouldBeEmpty)
+		// new session rate
+		quota = conf.AnonymousQuota{RatePerIP: 10, BurstPerIP: 2}
+		for i := 0; i < 2; i++ {
+			release, err := limiter.acquire(local, remote1, quota)
+			So(err, ShouldBeNil)
+			release()
+		}
+		_, err = limiter.acquire(local, remote1, quota)
+		So(errors.Cause(err), ShouldEqual, ErrAnonymousQuotaExceeded)
+		time.Sleep(150 * time.Millisecond)
+		release, err := limiter.acquire(local, remote1, quota)
+		So(err, ShouldBeNil)
+		release()
+		// quota config and method whitelist
+		So(IsAnonymousMethodAllowed(route.DHTPing.String()), ShouldBeTrue)
+		So(IsAnonymousMethodAllowed("DHT.FindNeighbor"), ShouldBeFalse)
+		override := conf.GConf.AnonymousQuota
+		conf.GConf.AnonymousQuota = &conf.AnonymousQuota{
+			MaxConcurrent:  1,
+			AllowedMethods: []string{"DHT.FindNeighbor"},
+		}
+		defer func() { conf.GConf.AnonymousQuota = override }()
+		So(anonymousQuota().MaxConcurrent, ShouldEqual, 1)
+		So(anonymousQuota().RatePerIP, ShouldEqual, fallbackAnonymousQuota.RatePerIP)
+		So(IsAnonymousMethodAllowed(route.DHTPing.String()), ShouldBeFalse)
+		So(IsAnonymousMethodAllowed("DHT.FindNeighbor"), ShouldBeTrue)
+	})
+	Convey("Test server key confirmation", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		resolver := &simpleResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		resolver.registerNode(&proto.Node{
+			Addr:      l.Addr().String(),
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		})
+		RegisterResolver(resolver)
+		// fakeServer reads client header and replies with the confirmation encrypted by key
+		fakeServer := func(key []byte, confirmation []byte) {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+			header := make([]byte, HeaderSize)
+			if _, err = io.ReadFull(conn, header); err != nil {
+				return
+			}
+			_, _ = etls.NewConn(conn, newCipher(key, etls.AES256CFB, false)).Write(confirmation)
+		}
+		// Server without the shared key
+		go fakeServer([]byte("bad key"), make([]byte, ConfirmSize))
+		_, err = DialEx(nodeinfo.ID, true)
+		So(err, ShouldNotBeNil)
+		// Server with the shared key but bad MAC
+		go fakeServer([]byte(sharedSecret), make([]byte, ConfirmSize))
+		_, err = DialEx(nodeinfo.ID, true)
+		So(errors.Cause(err), ShouldEqual, ErrKeyConfirmationFailed)
+	})
+	Convey("Test compression negotiation", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		resolver := &simpleResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		resolver.registerNode(&proto.Node{
+			Addr:      l.Addr().String(),
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		})
+		RegisterResolver(resolver)
+		compression := conf.GConf.Compression
+		defer func() { conf.GConf.Compression = compression }()
+
+		So(chooseCompression(CompressSnappy|CompressZstd), ShouldEqual, CompressionByName(compression))
+		// compressible payload larger than a single frame, and small payload below threshold
+		large := bytes.Repeat([]byte("CovenantSQL"), maxFrameSize/4)
+		small := []byte("small")
+		for _, name := range []string{"snappy", "zstd", "none"} {
+			conf.GConf.Compression = name
+			expected := CompressionByName(name)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				conn, err := l.Accept()
+				c.So(err, ShouldBeNil)
+				naconn, err := Accept(conn)
+				c.So(err, ShouldBeNil)
+				defer func() { _ = naconn.Close() }()
+				c.So(naconn.Compression(), ShouldEqual, expected)
+				_, err = io.Copy(naconn, io.LimitReader(naconn, int64(len(large)+len(small))))
+				c.So(err, ShouldBeNil)
+			}()
+			conn, err := Dial(nodeinfo.ID)
+			So(err, ShouldBeNil)
+			So(conn.(*NAConn).Compression(), ShouldEqual, expected)
+			for _, b := range [][]byte{large, small} {
+				n, err := conn.Write(b)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, len(b))
+			}
+			buffer := make([]byte, len(large)+len(small))
+			_, err = io.ReadFull(conn, buffer)
+			So(err, ShouldBeNil)
+			So(buffer, ShouldResemble, append(append([]byte{}, large...), small...))
+			_ = conn.Close()
+			<-done
+		}
+		// server without compression enabled
+		So(chooseCompression(CompressSnappy), ShouldEqual, CompressNone)
+		conf.GConf.Compression = "zstd"
+		So(chooseCompression(CompressSnappy), ShouldEqual, CompressSnappy)
+		So(chooseCompression(CompressNone), ShouldEqual, CompressNone)
+	})
+	Convey("Test cipher suite negotiation", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		resolver := &simpleResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		resolver.registerNode(&proto.Node{
+			Addr:      l.Addr().String(),
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		})
+		RegisterResolver(resolver)
+		suites := conf.GConf.CipherSuites
+		defer func() { conf.GConf.CipherSuites = suites }()
+
+		for _, v := range []struct {
+			suites   []string
+			expected etls.CipherSuite
+		}{
+			{[]string{"xchacha20"}, etls.XChaCha20},
+			{[]string{"aes-256-ctr", "xchacha20"}, etls.AES256CTR},
+			{[]string{"chacha20-poly1305", "aes-256-ctr"}, etls.ChaCha20Poly1305},
+			{[]string{"aes-256-gcm"}, etls.AES256GCM},
+			{[]string{"unknown"}, etls.AES256CFB},
+			{nil, etls.AES256CFB},
+		} {
+			conf.GConf.CipherSuites = v.suites
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				conn, err := l.Accept()
+				c.So(err, ShouldBeNil)
+				naconn, err := Accept(conn)
+				c.So(err, ShouldBeNil)
+				defer func() { _ = naconn.Close() }()
+				c.So(naconn.CipherSuite(), ShouldEqual, v.expected)
+				_, err = io.Copy(naconn, io.LimitReader(naconn, 5))
+				c.So(err, ShouldBeNil)
+			}()
+			conn, err := Dial(nodeinfo.ID)
+			So(err, ShouldBeNil)
+			So(conn.(*NAConn).CipherSuite(), ShouldEqual, v.expected)
+			_, err = conn.Write([]byte("hello"))
+			So(err, ShouldBeNil)
+			buffer := make([]byte, 5)
+			_, err = io.ReadFull(conn, buffer)
+			So(err, ShouldBeNil)
+			So(string(buffer), ShouldEqual, "hello")
+			_ = conn.Close()
+			<-done
+		}
+
+		// server prefers its own order and falls back to the default suite
+		conf.GConf.CipherSuites = []string{"xchacha20", "aes-256-ctr"}
+		So(chooseCipherSuite(suiteBit(etls.AES256CTR)), ShouldEqual, etls.AES256CTR)
+		So(chooseCipherSuite(suiteBit(etls.AES256CTR)|suiteBit(etls.XChaCha20)), ShouldEqual, etls.XChaCha20)
+		So(chooseCipherSuite(suiteBit(etls.AES256GCM)), ShouldEqual, etls.AES256CFB)
+		So(chooseCipherSuite(0), ShouldEqual, etls.AES256CFB)
+		// client rejects the suite not offered
+		_, err = chosenCipherSuite(byte(etls.XChaCha20), suiteBit(etls.AES256CTR))
+		So(err, ShouldNotBeNil)
+		suite, err := chosenCipherSuite(byte(etls.XChaCha20), suiteBit(etls.AES256CTR)|suiteBit(etls.XChaCha20))
+		So(err, ShouldBeNil)
+		So(suite, ShouldEqual, etls.XChaCha20)
+		suite, err = chosenCipherSuite(byte(etls.AES256CFB), 0)
+		So(err, ShouldBeNil)
+		So(suite, ShouldEqual, etls.AES256CFB)
+	})
+	Convey("Test rekey negotiation", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		resolver := &simpleResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		resolver.registerNode(&proto.Node{
+			Addr:      l.Addr().String(),
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		})
+		RegisterResolver(resolver)
+
+		// peers both supporting rekey enable it
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			conn, err := l.Accept()
+			c.So(err, ShouldBeNil)
+			naconn, err := Accept(conn)
+			c.So(err, ShouldBeNil)
+			defer func() { _ = naconn.Close() }()
+			c.So(naconn.rekey, ShouldBeTrue)
+			_, err = io.Copy(naconn, io.LimitReader(naconn, 5))
+			c.So(err, ShouldBeNil)
+		}()
+		conn, err := Dial(nodeinfo.ID)
+		So(err, ShouldBeNil)
+		So(conn.(*NAConn).rekey, ShouldBeTrue)
+		_, err = conn.Write([]byte("hello"))
+		So(err, ShouldBeNil)
+		buffer := make([]byte, 5)
+		_, err = io.ReadFull(conn, buffer)
+		So(err, ShouldBeNil)
+		So(string(buffer), ShouldEqual, "hello")
+		_ = conn.Close()
+		<-done
+
+		// peer not advertising rekey keeps the unframed stream
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			conn, err := l.Accept()
+			c.So(err, ShouldBeNil)
+			naconn, err := Accept(conn)
+			c.So(err, ShouldBeNil)
+			defer func() { _ = naconn.Close() }()
+			c.So(naconn.rekey, ShouldBeFalse)
+			_, err = io.Copy(naconn, io.LimitReader(naconn, 5))
+			c.So(err, ShouldBeNil)
+		}()
+		raw, err := net.Dial("tcp", l.Addr().String())
+		So(err, ShouldBeNil)
+		defer func() { _ = raw.Close() }()
+		header := make([]byte, 0, HeaderSize)
+		header = append(header, etls.MagicBytes[:]...)
+		header = append(header, kms.AnonymousRawNodeID.AsBytes()...)
+		header = append(header, (&cpuminer.Uint256{}).Bytes()...)
+		_, err = raw.Write(header)
+		So(err, ShouldBeNil)
+		legacy := etls.NewConn(raw, etls.NewCipher([]byte(sharedSecret)))
+		_, err = legacy.Write(make([]byte, OfferSize))
+		So(err, ShouldBeNil)
+		confirmation := make([]byte, ConfirmSize)
+		_, err = io.ReadFull(legacy, confirmation)
+		So(err, ShouldBeNil)
+		So(confirmation[ConfirmNonceSize:ConfirmNonceSize+OfferSize], ShouldResemble, make([]byte, OfferSize))
+		_, err = legacy.Write([]byte("hello"))
+		So(err, ShouldBeNil)
+		_, err = io.ReadFull(legacy, buffer)
+		So(err, ShouldBeNil)
+		So(string(buffer), ShouldEqual, "hello")
+		<-done
+	})
 	Convey("Test simple NAConn", t, func(c C) {
 		l, err := net.Listen("tcp", "localhost:0")
 		So(err, ShouldBeNil)
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+func isSequentialFallback() bool {
+	return conf.GConf != nil && strings.EqualFold(conf.GConf.DialFallback, conf.DialFallbackSequential)
+}
+
+// dialAddrs dials the addresses of a node with the configured fallback strategy and returns the
+// first established connection.
+func dialAddrs(ctx context.Context, addrs []string) (conn net.Conn, err error) {
+	if len(addrs) == 1 || isSequentialFallback() {
+		return dialSequential(ctx, addrs)
+	}
+	return dialParallel(ctx, addrs, conf.DialFallbackDelay)
+}
+
+func dialAddr(ctx context.Context, addr string) (conn net.Conn, err error) {
+	dialer := &net.Dialer{Timeout: conf.TCPDialTimeout, KeepAlive: ConnTuning().KeepAlive}
+	if conn, err = dialer.DialContext(ctx, "tcp", addr); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		err = errors.Wrapf(err, "connect to node %s failed", addr)
+		return
+	}
+	if err = TuneConn(conn); err != nil {
+		_ = conn.Close()
+		err = errors.Wrapf(err, "tune connection to node %s failed", addr)
+	}
+	return
+}
+
+func joinDialErrors(errs []string) error {
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// dialSequential dials the addresses one by one until a connection is established.
+func dialSequential(ctx context.Context, addrs []string) (conn net.Conn, err error) {
+	var errs []string
+	for _, addr := range addrs {
+		if conn, err = dialAddr(ctx, addr); err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		errs = append(errs, err.Error())
+	}
+	err = joinDialErrors(errs)
+	return
+}
+
+// dialParallel starts dialing the next address if the previous one fails or doesn't finish in
+// delay, and returns the first established connection. The other dialings are canceled and the
+// connections established later are closed.
+func dialParallel(ctx context.Context, addrs []string, delay time.Duration) (conn net.Conn, err error) {
+	var (
+		subctx, cancel = context.WithCancel(ctx)
+		results        = make(chan dialResult, len(addrs))
+		timer          = time.NewTimer(delay)
+		next, pending  int
+		errs           []string
+	)
+	defer cancel()
+	defer timer.Stop()
+	startNext := func() {
+		go func(addr string) {
+			c, e := dialAddr(subctx, addr)
+			results <- dialResult{conn: c, err: e}
+		}(addrs[next])
+		next++
+		pending++
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(delay)
+	}
+
+	startNext()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				conn = r.conn
+				go closeDialResults(results, pending)
+				return
+			}
+			if ctx.Err() != nil {
+				err = r.err
+				go closeDialResults(results, pending)
+				return
+			}
+			errs = append(errs, r.err.Error())
+			if next < len(addrs) {
+				startNext()
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				startNext()
+			}
+		}
+	}
+	err = joinDialErrors(errs)
+	return
+}
+
+// closeDialResults waits for the pending dialings and closes the established connections.
+func closeDialResults(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.conn != nil {
+			_ = r.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+// ErrTooManyHandshakes indicates that the concurrent handshakes from the remote IP exceed the
+// limit.
+var ErrTooManyHandshakes = errors.New("too many concurrent handshakes")
+
+// handshakeLimiter counts the in-progress server side handshakes of each remote IP.
+type handshakeLimiter struct {
+	sync.Mutex
+	pending map[string]int
+}
+
+var defaultLimiter = &handshakeLimiter{
+	pending: make(map[string]int),
+}
+
+// handshakeTimeout returns the deadline duration of server side handshake.
+func handshakeTimeout() time.Duration {
+	if conf.GConf != nil && conf.GConf.HandshakeTimeout > 0 {
+		return conf.GConf.HandshakeTimeout
+	}
+	return conf.DefaultHandshakeTimeout
+}
+
+// maxHandshakesPerIP returns the concurrent handshake limit of each remote IP, non-positive
+// value means unlimited.
+func maxHandshakesPerIP() int {
+	if conf.GConf != nil && conf.GConf.MaxHandshakesPerIP != 0 {
+		return conf.GConf.MaxHandshakesPerIP
+	}
+	return conf.DefaultMaxHandshakesPerIP
+}
+
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// acquire takes a handshake slot of the remote IP, the returned release function must be
+// called after the handshake is done.
+func (l *handshakeLimiter) acquire(addr net.Addr, max int) (release func(), err error) {
+	var ip = remoteIP(addr)
+	l.Lock()
+	defer l.Unlock()
+	if max > 0 && l.pending[ip] >= max {
+		err = errors.Wrapf(ErrTooManyHandshakes, "remote %s", ip)
+		return
+	}
+	l.pending[ip]++
+	release = func() {
+		l.Lock()
+		defer l.Unlock()
+		if l.pending[ip]--; l.pending[ip] <= 0 {
+			delete(l.pending, ip)
+		}
+	}
+	return
+}
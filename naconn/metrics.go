@@ -0,0 +1,39 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var handshakeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "covenantsql",
+	Subsystem: "naconn",
+	Name:      "handshake_failures_total",
+	Help:      "Number of failed ETLS handshakes, including the rejected ones on server side.",
+}, []string{"side"})
+
+var anonymousRejections = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "covenantsql",
+	Subsystem: "naconn",
+	Name:      "anonymous_rejections_total",
+	Help:      "Number of anonymous sessions rejected by the quota.",
+})
+
+func init() {
+	prometheus.MustRegister(handshakeFailures, anonymousRejections)
+}
@@ -16,7 +16,11 @@
 
 package naconn
 
-import "github.com/CovenantSQL/CovenantSQL/proto"
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
 
 // Resolver defines the node ID resolver interface for node-oriented connection.
 type Resolver interface {
@@ -32,3 +36,38 @@ var (
 func RegisterResolver(resolver Resolver) {
 	defaultResolver = resolver
 }
+
+// MultiAddrResolver defines the resolver which resolves all the advertised addresses of a node,
+// the preferred one goes first.
+type MultiAddrResolver interface {
+	ResolveAddrs(id *proto.RawNodeID) ([]string, error)
+}
+
+// ResolveAddrs returns the addresses of the remote node to dial in order. It falls back to the
+// single address resolved by Resolve if the registered resolver is not a MultiAddrResolver.
+func ResolveAddrs(remote proto.NodeID) (addrs []string, err error) {
+	var rawNodeID = remote.ToRawNodeID()
+	if r, ok := defaultResolver.(MultiAddrResolver); ok {
+		if addrs, err = r.ResolveAddrs(rawNodeID); err != nil {
+			err = errors.Wrapf(err, "resolve %s failed", rawNodeID.String())
+			return
+		}
+		if len(addrs) == 0 {
+			err = errors.Errorf("resolve %s failed: no address", rawNodeID.String())
+		}
+		return
+	}
+	var addr string
+	if addr, err = Resolve(remote); err != nil {
+		return
+	}
+	return []string{addr}, nil
+}
+
+// ResolveEx returns the node info of the remote node with the registered resolver.
+func ResolveEx(remote *proto.RawNodeID) (node *proto.Node, err error) {
+	if node, err = defaultResolver.ResolveEx(remote); err != nil {
+		err = errors.Wrapf(err, "resolve %s failed", remote.String())
+	}
+	return
+}
@@ -17,8 +17,9 @@
 package naconn
 
 import (
-	"sync"
+	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/pkg/errors"
 
 	"github.com/CovenantSQL/CovenantSQL/conf"
@@ -32,7 +33,41 @@ const (
 	sharedSecret = `!&\\!qEyey*\cbLc,aKl`
 )
 
-var symmetricKeyCache sync.Map
+// symmetricKeyEntry is the cached shared key with the remote public key it's generated from.
+type symmetricKeyEntry struct {
+	key       []byte
+	publicKey *asymmetric.PublicKey
+	expireAt  time.Time
+}
+
+// symmetricKeyCache is the LRU cache of shared keys: proto.NodeID -> *symmetricKeyEntry.
+var symmetricKeyCache, _ = lru.New(conf.SymmetricKeyCacheSize)
+
+func init() {
+	kms.AddNodeUpdateHook(onNodeUpdate)
+}
+
+// onNodeUpdate drops the cached shared key and node verification if the public key of the node is
+// changed or removed.
+func onNodeUpdate(id proto.NodeID, publicKey *asymmetric.PublicKey) {
+	if v, ok := symmetricKeyCache.Peek(id); ok {
+		if publicKey == nil || !publicKey.IsEqual(v.(*symmetricKeyEntry).publicKey) {
+			symmetricKeyCache.Remove(id)
+			verifiedNodes.Remove(id)
+		}
+	} else {
+		// the verified nonce may be bound to the previous public key
+		verifiedNodes.Remove(id)
+	}
+}
+
+// InvalidateSharedSecret removes the cached shared key and node verification of the node, the key
+// will be regenerated with the latest public key on next connection.
+func InvalidateSharedSecret(nodeID *proto.RawNodeID) {
+	id := proto.NodeID(nodeID.String())
+	symmetricKeyCache.Remove(id)
+	verifiedNodes.Remove(id)
+}
 
 // GetSharedSecretWith gets shared symmetric key with ECDH.
 func GetSharedSecretWith(resolver Resolver, nodeID *proto.RawNodeID, isAnonymous bool) (symmetricKey []byte, err error) {
@@ -41,46 +76,57 @@ func GetSharedSecretWith(resolver Resolver, nodeID *proto.RawNodeID, isAnonymous
 		return []byte(sharedSecret), nil
 	}
 
-	symmetricKeyI, ok := symmetricKeyCache.Load(nodeID.String())
-	if ok {
-		symmetricKey, _ = symmetricKeyI.([]byte)
-	} else {
-		var remotePublicKey *asymmetric.PublicKey
-		if route.IsBPNodeID(nodeID) {
-			remotePublicKey = kms.BP.PublicKey
-		} else if conf.RoleTag[0] == conf.BlockProducerBuildTag[0] {
-			remotePublicKey, err = kms.GetPublicKey(proto.NodeID(nodeID.String()))
-			if err != nil {
-				err = errors.Wrapf(err, "get public key locally failed, node: %s", nodeID)
-				return
-			}
-		} else {
-			// if non BP running and key not found, ask BlockProducer
-			var nodeInfo *proto.Node
-			nodeInfo, err = resolver.ResolveEx(nodeID)
-			if err != nil {
-				err = errors.Wrapf(err, "get public key failed, node: %s", nodeID)
-				return
-			}
-			remotePublicKey = nodeInfo.PublicKey
+	id := proto.NodeID(nodeID.String())
+	if v, ok := symmetricKeyCache.Get(id); ok {
+		if entry := v.(*symmetricKeyEntry); time.Now().Before(entry.expireAt) {
+			symmetricKey = entry.key
+			return
 		}
+		symmetricKeyCache.Remove(id)
+	}
+
+	var remotePublicKey *asymmetric.PublicKey
+	if remotePublicKey, err = getPublicKey(resolver, nodeID); err != nil {
+		return
+	}
+
+	// the local private key may be kept in a hardware token
+	if symmetricKey, err = kms.LocalECDH(remotePublicKey); err != nil {
+		err = errors.Wrap(err, "generate shared secret with local key failed")
+		return
+	}
+	symmetricKeyCache.Add(id, &symmetricKeyEntry{
+		key:       symmetricKey,
+		publicKey: remotePublicKey,
+		expireAt:  time.Now().Add(conf.SymmetricKeyCacheTTL),
+	})
+	//log.WithFields(log.Fields{
+	//	"node":       nodeID.String(),
+	//	"remotePub":  fmt.Sprintf("%#x", remotePublicKey.Serialize()),
+	//	"sessionKey": fmt.Sprintf("%#x", symmetricKey),
+	//}).Debug("generated shared secret")
+	return
+}
 
-		var localPrivateKey *asymmetric.PrivateKey
-		localPrivateKey, err = kms.GetLocalPrivateKey()
+// getPublicKey gets the public key of the remote node.
+func getPublicKey(resolver Resolver, nodeID *proto.RawNodeID) (key *asymmetric.PublicKey, err error) {
+	if route.IsBPNodeID(nodeID) {
+		key = kms.BP.PublicKey
+	} else if conf.RoleTag[0] == conf.BlockProducerBuildTag[0] {
+		key, err = kms.GetPublicKey(proto.NodeID(nodeID.String()))
 		if err != nil {
-			err = errors.Wrap(err, "get local private key failed")
+			err = errors.Wrapf(err, "get public key locally failed, node: %s", nodeID)
 			return
 		}
-
-		symmetricKey = asymmetric.GenECDHSharedSecret(localPrivateKey, remotePublicKey)
-		symmetricKeyCache.Store(nodeID.String(), symmetricKey)
-		//log.WithFields(log.Fields{
-		//	"node":       nodeID.String(),
-		//	"remotePub":  fmt.Sprintf("%#x", remotePublicKey.Serialize()),
-		//	"sessionKey": fmt.Sprintf("%#x", symmetricKey),
-		//}).Debug("generated shared secret")
+	} else {
+		// if non BP running and key not found, ask BlockProducer
+		var nodeInfo *proto.Node
+		nodeInfo, err = resolver.ResolveEx(nodeID)
+		if err != nil {
+			err = errors.Wrapf(err, "get public key failed, node: %s", nodeID)
+			return
+		}
+		key = nodeInfo.PublicKey
 	}
-	//log.Debugf("ECDH for %s Public Key: %x, Private Key: %x Session Key: %x",
-	//	nodeID.ToNodeID(), remotePublicKey.Serialize(), localPrivateKey.Serialize(), symmetricKey)
 	return
 }
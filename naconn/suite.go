@@ -0,0 +1,127 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/etls"
+)
+
+// The handshake offer of client has one byte for each negotiated field, and server replies its
+// choice of each field with the same layout in key confirmation.
+const (
+	offerCompression = iota
+	offerCipherSuite
+	offerFeatures
+	// OfferSize is the size of handshake offer and server choice.
+	OfferSize
+)
+
+// Optional ETLS features offered as a bit set in the features field of handshake offer, server
+// replies the ones both sides support. The features not chosen stay disabled, so the peers not
+// advertising them keep the unframed stream format.
+const (
+	// FeatureRekey enables ratcheting the session keys by data volume and interval, the stream
+	// cipher suites send the framed record format carrying the rekey signal.
+	FeatureRekey byte = 1 << 0
+
+	localFeatures = FeatureRekey
+)
+
+// chooseFeatures returns the features used by server for the client offer.
+func chooseFeatures(offer byte) byte {
+	return offer & localFeatures
+}
+
+// The ETLS cipher suites are offered as a bit set in the cipher suite field of handshake offer,
+// and server replies the chosen suite. The handshake itself is always encrypted with the default
+// etls.AES256CFB, both sides switch to the chosen cipher suite after key confirmation.
+
+// suiteBit returns the offer bit of the cipher suite, the default suite has no bit.
+func suiteBit(suite etls.CipherSuite) byte {
+	if suite == etls.AES256CFB || suite >= 8 {
+		return 0
+	}
+	return 1 << suite
+}
+
+// localCipherSuites returns the configured cipher suites in preference order.
+func localCipherSuites() (suites []etls.CipherSuite) {
+	if conf.GConf == nil {
+		return
+	}
+	for _, name := range conf.GConf.CipherSuites {
+		if suite, ok := etls.CipherSuiteByName(name); ok {
+			suites = append(suites, suite)
+		}
+	}
+	return
+}
+
+// offerCipherSuites returns the bit set of the configured cipher suites.
+func offerCipherSuites() (offer byte) {
+	for _, v := range localCipherSuites() {
+		offer |= suiteBit(v)
+	}
+	return
+}
+
+// chooseCipherSuite returns the cipher suite used by server for the client offer, which is the
+// first configured one in offer.
+func chooseCipherSuite(offer byte) etls.CipherSuite {
+	for _, v := range localCipherSuites() {
+		if v == etls.AES256CFB || offer&suiteBit(v) != 0 {
+			return v
+		}
+	}
+	return etls.AES256CFB
+}
+
+// chosenCipherSuite returns the cipher suite chosen by server, which must be offered by client.
+func chosenCipherSuite(chosen, offer byte) (suite etls.CipherSuite, err error) {
+	suite = etls.CipherSuite(chosen)
+	if suite != etls.AES256CFB && offer&suiteBit(suite) == 0 {
+		err = errors.Errorf("server chose unexpected cipher suite %d", chosen)
+	}
+	return
+}
+
+// newCipher returns the cipher of suite, the session keys are ratcheted if rekey is negotiated.
+func newCipher(key []byte, suite etls.CipherSuite, rekey bool) *etls.Cipher {
+	cipher := etls.NewCipherWithSuite(key, suite)
+	if rekey {
+		cipher.SetRekeyBytes(conf.ETLSRekeyBytes)
+		cipher.SetRekeyInterval(conf.ETLSRekeyInterval)
+	}
+	return cipher
+}
+
+// setCipher switches the connection to the cipher suite and features negotiated in handshake.
+func (c *NAConn) setCipher(suite etls.CipherSuite, features byte) {
+	rekey := features&FeatureRekey != 0
+	if suite != c.suite || rekey != c.rekey {
+		c.suite, c.rekey = suite, rekey
+		c.CryptoConn.Cipher = newCipher(c.key, suite, rekey)
+	}
+}
+
+// CipherSuite returns the negotiated cipher suite of the connection.
+func (c *NAConn) CipherSuite() etls.CipherSuite {
+	return c.suite
+}
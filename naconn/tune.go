@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+// ConnTuning returns the configured connection level options with defaults filled.
+func ConnTuning() (t conf.ConnTuning) {
+	if conf.GConf != nil && conf.GConf.Conn != nil {
+		t = *conf.GConf.Conn
+	}
+	if t.KeepAlive == 0 {
+		t.KeepAlive = conf.DefaultTCPKeepAlive
+	}
+	if t.HeartbeatInterval <= 0 {
+		t.HeartbeatInterval = conf.DefaultHeartbeatInterval
+	}
+	if t.HeartbeatTimeout <= 0 {
+		t.HeartbeatTimeout = conf.DefaultHeartbeatTimeout
+	}
+	if t.HeartbeatTimeout < t.HeartbeatInterval {
+		t.HeartbeatTimeout = t.HeartbeatInterval
+	}
+	return
+}
+
+// TuneConn applies the configured TCP options to conn, it does nothing if conn is not a TCP
+// connection.
+func TuneConn(conn net.Conn) (err error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	t := ConnTuning()
+	if err = tcpConn.SetNoDelay(!t.DisableNoDelay); err != nil {
+		return errors.Wrap(err, "set TCP no delay failed")
+	}
+	if err = tuneKeepAlive(tcpConn, t.KeepAlive); err != nil {
+		return
+	}
+	if t.ReadBufferSize > 0 {
+		if err = tcpConn.SetReadBuffer(t.ReadBufferSize); err != nil {
+			return errors.Wrap(err, "set TCP read buffer failed")
+		}
+	}
+	if t.WriteBufferSize > 0 {
+		if err = tcpConn.SetWriteBuffer(t.WriteBufferSize); err != nil {
+			return errors.Wrap(err, "set TCP write buffer failed")
+		}
+	}
+	return
+}
+
+func tuneKeepAlive(conn *net.TCPConn, period time.Duration) (err error) {
+	if period < 0 {
+		if err = conn.SetKeepAlive(false); err != nil {
+			err = errors.Wrap(err, "disable TCP keepalive failed")
+		}
+		return
+	}
+	if err = conn.SetKeepAlive(true); err != nil {
+		return errors.Wrap(err, "enable TCP keepalive failed")
+	}
+	if err = conn.SetKeepAlivePeriod(period); err != nil {
+		return errors.Wrap(err, "set TCP keepalive period failed")
+	}
+	return
+}
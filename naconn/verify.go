@@ -0,0 +1,72 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package naconn
+
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/pow/cpuminer"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+var (
+	// ErrNodeIDDifficultyTooLow indicates that the remote node ID does not meet the minimum
+	// difficulty requirement.
+	ErrNodeIDDifficultyTooLow = errors.New("node id difficulty too low")
+	// ErrNodeIDNonceNotMatch indicates that the remote node ID is not derived from its public key
+	// and nonce.
+	ErrNodeIDNonceNotMatch = errors.New("node id, nonce and public key not match")
+)
+
+// verifiedNodes is the LRU cache of verified node ID and nonce pairs: proto.NodeID -> cpuminer.Uint256.
+// It's cleared with the shared key cache, so the pairs are verified again with the new public key.
+var verifiedNodes, _ = lru.New(conf.VerifiedNodeCacheSize)
+
+// minNodeIDDifficulty returns the minimum difficulty of node IDs accepted by server.
+func minNodeIDDifficulty() int {
+	if conf.GConf != nil {
+		return conf.GConf.MinNodeIDDifficulty
+	}
+	return 0
+}
+
+// verifyNodeID checks that the node ID meets the minimum difficulty and it's exactly the hash of
+// the node public key and nonce, so that a node ID can not be claimed without the proof of work.
+func verifyNodeID(resolver Resolver, id *proto.RawNodeID, nonce *cpuminer.Uint256) (err error) {
+	nodeID := proto.NodeID(id.String())
+	if v, ok := verifiedNodes.Get(nodeID); ok && v.(cpuminer.Uint256) == *nonce {
+		return
+	}
+	if min := minNodeIDDifficulty(); id.Difficulty() < min {
+		err = errors.Wrapf(ErrNodeIDDifficultyTooLow,
+			"node %s difficulty %d, required %d", id, id.Difficulty(), min)
+		return
+	}
+	key, err := getPublicKey(resolver, id)
+	if err != nil {
+		return
+	}
+	if !kms.IsIDPubNonceValid(id, nonce, key) {
+		err = errors.Wrapf(ErrNodeIDNonceNotMatch, "node %s", id)
+		return
+	}
+	verifiedNodes.Add(nodeID, *nonce)
+	return
+}
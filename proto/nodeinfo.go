@@ -117,6 +117,24 @@ type Node struct {
 	DirectAddr string                `yaml:"DirectAddr,omitempty"`
 	PublicKey  *asymmetric.PublicKey `yaml:"PublicKey"`
 	Nonce      mine.Uint256          `yaml:"Nonce"`
+	// Addrs lists the other advertised addresses of the node (e.g. IPv6 or private network
+	// addresses), which are dialed as fallback of Addr.
+	Addrs []string `yaml:"Addrs,omitempty" hsp:"-"`
+}
+
+// Addresses returns Addr followed by the other advertised addresses of the node, empty and
+// duplicated addresses are skipped.
+func (n *Node) Addresses() (addrs []string) {
+	addrs = make([]string, 0, 1+len(n.Addrs))
+	seen := make(map[string]bool, 1+len(n.Addrs))
+	for _, v := range append([]string{n.Addr}, n.Addrs...) {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		addrs = append(addrs, v)
+	}
+	return
 }
 
 // NewNode just return a new node struct.
@@ -37,12 +37,14 @@ type EnvelopeAPI interface {
 	GetExpire() time.Duration
 	GetNodeID() *RawNodeID
 	GetContext() context.Context
+	GetTraceContext() map[string]string
 
 	SetVersion(string)
 	SetTTL(time.Duration)
 	SetExpire(time.Duration)
 	SetNodeID(*RawNodeID)
 	SetContext(context.Context)
+	SetTraceContext(map[string]string)
 }
 
 // Envelope is the protocol header.
@@ -51,7 +53,10 @@ type Envelope struct {
 	TTL     time.Duration `json:"t"`
 	Expire  time.Duration `json:"e"`
 	NodeID  *RawNodeID    `json:"id"`
-	_ctx    context.Context
+	// TraceContext carries the distributed tracing context of the caller, it's excluded from
+	// hash.
+	TraceContext map[string]string `json:"tc,omitempty" hsp:"-"`
+	_ctx         context.Context
 }
 
 // PingReq is Ping RPC request.
@@ -137,6 +142,11 @@ func (e *Envelope) GetContext() context.Context {
 	return e._ctx
 }
 
+// GetTraceContext implements EnvelopeAPI.GetTraceContext.
+func (e *Envelope) GetTraceContext() map[string]string {
+	return e.TraceContext
+}
+
 // SetVersion implements EnvelopeAPI.SetVersion.
 func (e *Envelope) SetVersion(ver string) {
 	e.Version = ver
@@ -162,6 +172,11 @@ func (e *Envelope) SetContext(ctx context.Context) {
 	e._ctx = ctx
 }
 
+// SetTraceContext implements EnvelopeAPI.SetTraceContext.
+func (e *Envelope) SetTraceContext(tc map[string]string) {
+	e.TraceContext = tc
+}
+
 // DatabaseID is database name, will be generated from UUID.
 type DatabaseID string
 
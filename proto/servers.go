@@ -17,6 +17,8 @@
 package proto
 
 import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
 )
@@ -29,6 +31,33 @@ type PeersHeader struct {
 	Term    uint64
 	Leader  NodeID
 	Servers []NodeID
+	// Learners are non-voting replicas catching up before being promoted to servers, they are
+	// hashed by signedPeersHeader only if present to keep signatures of previous peers valid.
+	Learners []NodeID `hsp:"-"`
+}
+
+// signedPeersHeader defines the signed content of peers, the learners are appended to the header
+// hash only if present.
+type signedPeersHeader struct {
+	*PeersHeader
+}
+
+// MarshalHash marshals the header and learners for hash.
+func (h signedPeersHeader) MarshalHash() (o []byte, err error) {
+	if o, err = h.PeersHeader.MarshalHash(); err != nil || len(h.Learners) == 0 {
+		return
+	}
+
+	o = hsp.AppendArrayHeader(o, uint32(len(h.Learners)))
+	for i := range h.Learners {
+		var b []byte
+		if b, err = h.Learners[i].MarshalHash(); err != nil {
+			return
+		}
+		o = hsp.AppendBytes(o, b)
+	}
+
+	return
 }
 
 // Peers defines the peers configuration.
@@ -43,18 +72,30 @@ func (p *Peers) Clone() (copy Peers) {
 	copy.Term = p.Term
 	copy.Leader = p.Leader
 	copy.Servers = append(copy.Servers, p.Servers...)
+	copy.Learners = append(copy.Learners, p.Learners...)
 	copy.DefaultHashSignVerifierImpl = p.DefaultHashSignVerifierImpl
 	return
 }
 
 // Sign generates signature.
 func (p *Peers) Sign(signer *asymmetric.PrivateKey) (err error) {
-	return p.DefaultHashSignVerifierImpl.Sign(&p.PeersHeader, signer)
+	return p.DefaultHashSignVerifierImpl.Sign(signedPeersHeader{&p.PeersHeader}, signer)
 }
 
 // Verify verify signature.
 func (p *Peers) Verify() (err error) {
-	return p.DefaultHashSignVerifierImpl.Verify(&p.PeersHeader)
+	return p.DefaultHashSignVerifierImpl.Verify(signedPeersHeader{&p.PeersHeader})
+}
+
+// IsLearner returns whether the node is a learner of the peers.
+func (p *Peers) IsLearner(key NodeID) bool {
+	for _, s := range p.Learners {
+		if key.IsEqual(&s) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Find finds the index of the server with the specified key in the server list.
@@ -17,11 +17,13 @@
 package proto
 
 import (
+	"encoding/hex"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 )
 
@@ -76,3 +78,53 @@ func TestPeers(t *testing.T) {
 		So(err, ShouldNotBeNil)
 	})
 }
+
+func TestPeersLearners(t *testing.T) {
+	Convey("peers signed before learners support should be verified", t, func() {
+		signee, err := hex.DecodeString("02c76216704d797c64c58bc11519fb68582e8e63de7e5b3b2dbbbe8733efe5fd24")
+		So(err, ShouldBeNil)
+		signature, err := hex.DecodeString("304402200e026856d872773da0f46d9509b18e2030b549cef1f2caa603fd4c0298a7d46c022070f2569d871bee194ec2cff1a2dc033257a3a2b79ee1cd3895973cbb279bb7c6")
+		So(err, ShouldBeNil)
+
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Version: 1,
+				Term:    2,
+				Leader:  NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade"),
+				Servers: []NodeID{
+					NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade"),
+					NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5"),
+				},
+			},
+		}
+		err = hash.Decode(&p.DataHash, "e92966bed398dd4ecb91542cfa058449d368e12123fbf46271e03a716246f7e7")
+		So(err, ShouldBeNil)
+		p.Signee, err = asymmetric.ParsePubKey(signee)
+		So(err, ShouldBeNil)
+		p.Signature, err = asymmetric.ParseSignature(signature)
+		So(err, ShouldBeNil)
+		So(p.Verify(), ShouldBeNil)
+
+		// learners are covered by signature once added
+		p.Learners = []NodeID{NodeID("00000f3b43288fe99831eb533ab77ec455d13e11fc38ec35a42d4edd17aa320d")}
+		So(p.Verify(), ShouldNotBeNil)
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		So(p.Sign(privKey), ShouldBeNil)
+		So(p.Verify(), ShouldBeNil)
+		So(p.IsLearner(NodeID("00000f3b43288fe99831eb533ab77ec455d13e11fc38ec35a42d4edd17aa320d")), ShouldBeTrue)
+
+		// learners survive encoding
+		buf, err := utils.EncodeMsgPack(p)
+		So(err, ShouldBeNil)
+		var peers *Peers
+		err = utils.DecodeMsgPack(buf.Bytes(), &peers)
+		So(err, ShouldBeNil)
+		So(peers.Learners, ShouldResemble, p.Learners)
+		So(peers.Verify(), ShouldBeNil)
+
+		peers.Learners = nil
+		So(peers.Verify(), ShouldNotBeNil)
+	})
+}
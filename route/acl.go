@@ -79,6 +79,24 @@ const (
 	DBSDeploy
 	// DBSObserverFetchBlock is used by observer to fetch block.
 	DBSObserverFetchBlock
+	// DBSRestore is the streaming method used by client to restore database state at a past block.
+	DBSRestore
+	// DBSQueryStream is the streaming method used by client to read large query results.
+	DBSQueryStream
+	// DBSSchemaChangeStatus is used by client and miners to query the schema change progress.
+	DBSSchemaChangeStatus
+	// DBSQueryStats is used by client and observer to fetch statement statistics and slow queries.
+	DBSQueryStats
+	// DBSQueryProof is used by client to fetch the proof binding a query response to a block.
+	DBSQueryProof
+	// DBSPrepare is used by client to prepare a statement on database miner.
+	DBSPrepare
+	// DBSCloseStmt is used by client to release a prepared statement on database miner.
+	DBSCloseStmt
+	// DBSPrepareTx is used by client to prepare a branch of cross database transaction.
+	DBSPrepareTx
+	// DBSFinishTx is used by client to commit or rollback a prepared cross database transaction.
+	DBSFinishTx
 	// DBCCall is used by Miner for data consistency
 	DBCCall
 	// SQLCAdviseNewBlock is used by sqlchain to advise new block between adjacent node
@@ -116,6 +134,27 @@ const (
 	MCCQueryTxState
 	// MCCQueryAccountSQLChainProfiles is used by client to query account databases.
 	MCCQueryAccountSQLChainProfiles
+	// MCCQueryBPPeers is used by nodes to query the current block producer peer list.
+	MCCQueryBPPeers
+	// MCCFetchSnapshot is used by block producer to fetch main chain state snapshot.
+	MCCFetchSnapshot
+	// MCCQueryMempool is used by client to query the pending transactions of block producer.
+	MCCQueryMempool
+	// MCCQueryDatabasesByOwner is used by client to query the databases owned by an account.
+	MCCQueryDatabasesByOwner
+	// MCCQueryDatabaseMiners is used by client to query the miners serving a database.
+	MCCQueryDatabaseMiners
+	// MCCQueryAccount is used by client to query the balances and nonce of an account.
+	MCCQueryAccount
+	// MCCQueryBillingSummary is used by client to query the billing summaries within a block
+	// height range.
+	MCCQueryBillingSummary
+	// MCCSubscribe is the streaming method used by client to subscribe the chain events of block
+	// producer.
+	MCCSubscribe
+	// AdminBandwidth is used by block producer or node operator to query the traffic of remote
+	// nodes served by the rpc server.
+	AdminBandwidth
 	// MaxRPCOffset defines max rpc constant.
 	MaxRPCOffset
 
@@ -129,6 +168,8 @@ const (
 	SQLChainRPCName = "SQLC"
 	// DBRPCName defines the sql chain db service rpc name
 	DBRPCName = "DBS"
+	// AdminRPCName defines the rpc server administration service name
+	AdminRPCName = "Admin"
 )
 
 // String returns the RemoteFunc string.
@@ -152,6 +193,24 @@ func (s RemoteFunc) String() string {
 		return "DBS.Deploy"
 	case DBSObserverFetchBlock:
 		return "DBS.ObserverFetchBlock"
+	case DBSRestore:
+		return "DBS.Restore"
+	case DBSQueryStream:
+		return "DBS.QueryStream"
+	case DBSSchemaChangeStatus:
+		return "DBS.SchemaChangeStatus"
+	case DBSQueryStats:
+		return "DBS.QueryStats"
+	case DBSQueryProof:
+		return "DBS.QueryProof"
+	case DBSPrepare:
+		return "DBS.Prepare"
+	case DBSCloseStmt:
+		return "DBS.CloseStmt"
+	case DBSPrepareTx:
+		return "DBS.PrepareTx"
+	case DBSFinishTx:
+		return "DBS.FinishTx"
 	case DBCCall:
 		return "DBC.Call"
 	case SQLCAdviseNewBlock:
@@ -188,6 +247,24 @@ func (s RemoteFunc) String() string {
 		return "MCC.QueryTxState"
 	case MCCQueryAccountSQLChainProfiles:
 		return "MCC.QueryAccountSQLChainProfiles"
+	case MCCQueryBPPeers:
+		return "MCC.QueryBPPeers"
+	case MCCFetchSnapshot:
+		return "MCC.FetchSnapshot"
+	case MCCQueryMempool:
+		return "MCC.QueryMempool"
+	case MCCQueryDatabasesByOwner:
+		return "MCC.QueryDatabasesByOwner"
+	case MCCQueryDatabaseMiners:
+		return "MCC.QueryDatabaseMiners"
+	case MCCQueryAccount:
+		return "MCC.QueryAccount"
+	case MCCQueryBillingSummary:
+		return "MCC.QueryBillingSummary"
+	case MCCSubscribe:
+		return "MCC.Subscribe"
+	case AdminBandwidth:
+		return "Admin.Bandwidth"
 	}
 	return "Unknown"
 }
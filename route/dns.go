@@ -55,6 +55,7 @@ type Resolver struct {
 	cache     NodeIDAddressMap
 	bpNodeIDs NodeIDAddressMap
 	bpNodes   IDNodeMap
+	sources   []NodeSource
 	sync.RWMutex
 }
 
@@ -66,6 +67,7 @@ func initResolver() {
 			bpNodeIDs: make(NodeIDAddressMap),
 		}
 		initBPNodeIDs()
+		resolver.sources = initNodeSources()
 	})
 }
 
@@ -75,6 +77,8 @@ func IsBPNodeID(id *proto.RawNodeID) bool {
 	if id == nil {
 		return false
 	}
+	resolver.RLock()
+	defer resolver.RUnlock()
 	_, ok := resolver.bpNodeIDs[*id]
 	return ok
 }
@@ -133,7 +137,7 @@ func initBPNodeIDs() (bpNodeIDs NodeIDAddressMap) {
 
 	if conf.GConf.DNSSeed.Domain != "" {
 		var bpIndex int
-		dc := IPv6SeedClient{}
+		dc := IPv6SeedClient{Lookup: NewDNSSeedLookup(&conf.GConf.DNSSeed)}
 		bpIndex = rand.Intn(conf.GConf.DNSSeed.BPCount)
 		bpDomain := fmt.Sprintf("bp%02d.%s", bpIndex, conf.GConf.DNSSeed.Domain)
 		log.Infof("Geting bp address from dns: %v", bpDomain)
@@ -173,8 +177,27 @@ func initBPNodeIDs() (bpNodeIDs NodeIDAddressMap) {
 	return resolver.bpNodeIDs
 }
 
+// UpdateBPs replaces the known BP list with nodes, it's used to follow the BP membership change.
+func UpdateBPs(nodes []proto.Node) {
+	initResolver()
+	resolver.Lock()
+	defer resolver.Unlock()
+	resolver.bpNodeIDs = make(NodeIDAddressMap, len(nodes))
+	resolver.bpNodes = make(IDNodeMap, len(nodes))
+	for _, n := range nodes {
+		rawID := n.ID.ToRawNodeID()
+		if rawID != nil {
+			resolver.bpNodes[*rawID] = n
+			resolver.bpNodeIDs[*rawID] = n.Addr
+			resolver.cache[*rawID] = n.Addr
+		}
+	}
+}
+
 // GetBPs returns the known BP node id list.
 func GetBPs() (bpAddrs []proto.NodeID) {
+	resolver.RLock()
+	defer resolver.RUnlock()
 	bpAddrs = make([]proto.NodeID, 0, len(resolver.bpNodeIDs))
 	for id := range resolver.bpNodeIDs {
 		bpAddrs = append(bpAddrs, proto.NodeID(id.String()))
@@ -202,6 +225,7 @@ func InitKMS(PubKeyStoreFile string) {
 				PublicKey:  n.PublicKey,
 				Nonce:      n.Nonce,
 				Role:       n.Role,
+				Addrs:      n.Addrs,
 			}
 			log.WithField("node", node).Debug("known node to set")
 			err := kms.SetNode(node)
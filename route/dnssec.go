@@ -0,0 +1,351 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+const (
+	dnsQueryTimeout = 5 * time.Second
+	resolvConfFile  = "/etc/resolv.conf"
+
+	// maxDNSSECChainDepth limits the zones walked from the signer zone up to a trust anchor.
+	maxDNSSECChainDepth = 16
+)
+
+// rootTrustAnchor is the DS record of the root zone KSK-2017, used if no trust anchor is
+// configured.
+const rootTrustAnchor = ". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+
+// ErrDNSSECNotValidated indicates the DNS answer could not be validated to a trust anchor while
+// EnforcedDNSSEC is set.
+var ErrDNSSECNotValidated = errors.New("DNS answer is not DNSSEC validated")
+
+// NewDNSSeedLookup returns the IPv6 lookup function of the DNS seed config. It returns nil for
+// the system resolver if neither DNSServers nor EnforcedDNSSEC is configured.
+//
+// With EnforcedDNSSEC, the signatures of answers are validated locally along the DS/DNSKEY chain
+// up to the configured DNSSECTrustAnchors or the root zone KSK, the AD bit of the resolver is
+// not trusted.
+func NewDNSSeedLookup(cfg *conf.DNSSeed) func(host string) ([]net.IP, error) {
+	if cfg == nil || (len(cfg.DNSServers) == 0 && !cfg.EnforcedDNSSEC) {
+		return nil
+	}
+	servers := make([]string, 0, len(cfg.DNSServers))
+	for _, v := range cfg.DNSServers {
+		servers = append(servers, withDNSPort(v))
+	}
+	var (
+		v       *dnssecValidator
+		initErr error
+	)
+	if cfg.EnforcedDNSSEC {
+		v, initErr = newDNSSECValidator(cfg.DNSSECTrustAnchors)
+	}
+	return func(host string) (ips []net.IP, err error) {
+		if initErr != nil {
+			err = initErr
+			return
+		}
+		queryServers := servers
+		if len(queryServers) == 0 {
+			var cc *dns.ClientConfig
+			if cc, err = dns.ClientConfigFromFile(resolvConfFile); err != nil {
+				err = errors.Wrap(err, "load system DNS config failed")
+				return
+			}
+			for _, v := range cc.Servers {
+				queryServers = append(queryServers, net.JoinHostPort(v, cc.Port))
+			}
+		}
+		return lookupAAAA(queryServers, host, v)
+	}
+}
+
+func withDNSPort(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
+
+// exchange queries the records of name with DNSSEC OK bit set, and retries over TCP if the
+// answer is truncated.
+func exchange(server string, name string, qtype uint16) (resp *dns.Msg, err error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(4096, true)
+
+	c := &dns.Client{Timeout: dnsQueryTimeout}
+	if resp, _, err = c.Exchange(msg, server); err == nil && resp.Truncated {
+		c.Net = "tcp"
+		resp, _, err = c.Exchange(msg, server)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "query %s from %s failed", name, server)
+		return
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		err = errors.Errorf("query %s from %s failed: %s",
+			name, server, dns.RcodeToString[resp.Rcode])
+	}
+	return
+}
+
+// lookupAAAA queries the AAAA records of host, the first server answering wins. The answer is
+// validated if the DNSSEC validator is not nil.
+func lookupAAAA(servers []string, host string, v *dnssecValidator) (ips []net.IP, err error) {
+	err = errors.New("no DNS server available")
+	for _, server := range servers {
+		var resp *dns.Msg
+		if resp, err = exchange(server, host, dns.TypeAAAA); err != nil {
+			continue
+		}
+		answer := resp.Answer
+		if v != nil {
+			if answer, err = v.verifyAnswer(server, resp.Answer); err != nil {
+				err = errors.Wrapf(err, "query %s from %s", host, server)
+				continue
+			}
+		}
+		// follow the CNAME chain from the queried name
+		name := dns.Fqdn(host)
+		for i := 0; i <= len(answer); i++ {
+			var next string
+			for _, rr := range answer {
+				if !strings.EqualFold(rr.Header().Name, name) {
+					continue
+				}
+				switch r := rr.(type) {
+				case *dns.AAAA:
+					ips = append(ips, r.AAAA)
+				case *dns.CNAME:
+					next = r.Target
+				}
+			}
+			if len(ips) > 0 || next == "" {
+				break
+			}
+			name = next
+		}
+		err = nil
+		return
+	}
+	return
+}
+
+// dnssecValidator validates the DNS answers along the DS/DNSKEY chain up to the trust anchors.
+type dnssecValidator struct {
+	anchors map[string][]dns.RR // map[zone][]DS or DNSKEY
+}
+
+func newDNSSECValidator(anchors []string) (v *dnssecValidator, err error) {
+	if len(anchors) == 0 {
+		anchors = []string{rootTrustAnchor}
+	}
+	v = &dnssecValidator{anchors: make(map[string][]dns.RR)}
+	for _, a := range anchors {
+		var rr dns.RR
+		if rr, err = dns.NewRR(a); err != nil {
+			err = errors.Wrapf(err, "parse DNSSEC trust anchor %q failed", a)
+			return
+		}
+		switch rr.(type) {
+		case *dns.DS, *dns.DNSKEY:
+		default:
+			err = errors.Errorf("DNSSEC trust anchor %q is neither DS nor DNSKEY", a)
+			return
+		}
+		zone := strings.ToLower(dns.Fqdn(rr.Header().Name))
+		v.anchors[zone] = append(v.anchors[zone], rr)
+	}
+	return
+}
+
+// verifyAnswer returns the records of the answer which are validated, it fails if any RRset of
+// the answer could not be validated.
+func (v *dnssecValidator) verifyAnswer(server string, answer []dns.RR) (rrs []dns.RR, err error) {
+	for _, rrset := range splitRRsets(answer) {
+		if err = v.verifyRRset(server, rrset, answer, 0); err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, rrset...)
+	}
+	return
+}
+
+// verifyRRset verifies the rrset with any of the RRSIGs covering it in records, signed by the
+// validated keys of the signer zone.
+func (v *dnssecValidator) verifyRRset(server string, rrset []dns.RR, records []dns.RR, depth int) (
+	err error) {
+	hdr := rrset[0].Header()
+	err = errors.Wrapf(ErrDNSSECNotValidated, "no signature of %s %s",
+		hdr.Name, dns.TypeToString[hdr.Rrtype])
+	now := time.Now()
+	for _, rr := range records {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok || sig.TypeCovered != hdr.Rrtype || !strings.EqualFold(sig.Hdr.Name, hdr.Name) ||
+			!dns.IsSubDomain(sig.SignerName, hdr.Name) || !sig.ValidityPeriod(now) {
+			continue
+		}
+		var keys []*dns.DNSKEY
+		if hdr.Rrtype == dns.TypeDNSKEY && strings.EqualFold(sig.SignerName, hdr.Name) {
+			// self-signed key set is verified by the caller with its trusted keys
+			for _, rr := range rrset {
+				keys = append(keys, rr.(*dns.DNSKEY))
+			}
+		} else if keys, err = v.zoneKeys(server, sig.SignerName, depth+1); err != nil {
+			continue
+		}
+		for _, k := range keys {
+			if sig.Verify(k, rrset) == nil {
+				return nil
+			}
+		}
+		err = errors.Wrapf(ErrDNSSECNotValidated, "bad signature of %s %s",
+			hdr.Name, dns.TypeToString[hdr.Rrtype])
+	}
+	return
+}
+
+// zoneKeys returns the DNSKEYs of zone which are self-signed by a key trusted by the DS records
+// of the zone, or by the trust anchors.
+func (v *dnssecValidator) zoneKeys(server string, zone string, depth int) (keys []*dns.DNSKEY,
+	err error) {
+	if depth > maxDNSSECChainDepth {
+		err = errors.Wrapf(ErrDNSSECNotValidated, "DNSSEC chain of %s is too long", zone)
+		return
+	}
+	zone = strings.ToLower(dns.Fqdn(zone))
+
+	// find the trusted DS or DNSKEY records of zone
+	trusted, ok := v.anchors[zone]
+	if !ok {
+		if zone == "." {
+			err = errors.Wrap(ErrDNSSECNotValidated, "no trust anchor found")
+			return
+		}
+		var resp *dns.Msg
+		if resp, err = exchange(server, zone, dns.TypeDS); err != nil {
+			return
+		}
+		dsSet := filterRRs(resp.Answer, zone, dns.TypeDS)
+		if len(dsSet) == 0 {
+			err = errors.Wrapf(ErrDNSSECNotValidated, "no DS record of %s", zone)
+			return
+		}
+		// the DS records are signed by the parent zone
+		if err = v.verifyRRset(server, dsSet, resp.Answer, depth); err != nil {
+			return
+		}
+		trusted = dsSet
+	}
+
+	resp, err := exchange(server, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return
+	}
+	keySet := filterRRs(resp.Answer, zone, dns.TypeDNSKEY)
+	var sep []dns.RR
+	for _, rr := range keySet {
+		if k := rr.(*dns.DNSKEY); isTrustedKey(k, trusted) {
+			sep = append(sep, k)
+		}
+	}
+	if len(sep) == 0 {
+		err = errors.Wrapf(ErrDNSSECNotValidated, "no trusted DNSKEY of %s", zone)
+		return
+	}
+	// the key set must be signed by one of the trusted keys
+	if err = v.verifyRRset(server, keySet, signaturesBy(resp.Answer, sep), depth); err != nil {
+		return
+	}
+	for _, rr := range keySet {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+	return
+}
+
+func isTrustedKey(k *dns.DNSKEY, trusted []dns.RR) bool {
+	for _, rr := range trusted {
+		switch t := rr.(type) {
+		case *dns.DNSKEY:
+			if t.Algorithm == k.Algorithm && t.Flags == k.Flags && t.PublicKey == k.PublicKey {
+				return true
+			}
+		case *dns.DS:
+			if ds := k.ToDS(t.DigestType); ds != nil && ds.KeyTag == t.KeyTag &&
+				ds.Algorithm == t.Algorithm && strings.EqualFold(ds.Digest, t.Digest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// signaturesBy returns the RRSIG records of rrs made by the keys.
+func signaturesBy(rrs []dns.RR, keys []dns.RR) (sigs []dns.RR) {
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		for _, k := range keys {
+			if k.(*dns.DNSKEY).KeyTag() == sig.KeyTag {
+				sigs = append(sigs, sig)
+				break
+			}
+		}
+	}
+	return
+}
+
+func filterRRs(rrs []dns.RR, name string, rrtype uint16) (res []dns.RR) {
+	for _, rr := range rrs {
+		if hdr := rr.Header(); hdr.Rrtype == rrtype && strings.EqualFold(hdr.Name, name) {
+			res = append(res, rr)
+		}
+	}
+	return
+}
+
+// splitRRsets groups the records except RRSIGs by owner name and type.
+func splitRRsets(rrs []dns.RR) (sets [][]dns.RR) {
+	index := make(map[string]int)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		key := strings.ToLower(hdr.Name) + "/" + dns.TypeToString[hdr.Rrtype]
+		if i, ok := index[key]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+		index[key] = len(sets)
+		sets = append(sets, []dns.RR{rr})
+	}
+	return
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"crypto"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+type testZoneKey struct {
+	*dns.DNSKEY
+	priv crypto.PrivateKey
+}
+
+func newTestZoneKey(zone string, flags uint16) *testZoneKey {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	So(err, ShouldBeNil)
+	return &testZoneKey{DNSKEY: k, priv: priv}
+}
+
+func (k *testZoneKey) sign(rrset ...dns.RR) []dns.RR {
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  k.Algorithm,
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:     k.KeyTag(),
+		SignerName: k.Hdr.Name,
+	}
+	So(sig.Sign(k.priv.(crypto.Signer), rrset), ShouldBeNil)
+	return append(append([]dns.RR{}, rrset...), sig)
+}
+
+type testDNSServer struct {
+	sync.Mutex
+	records map[string][]dns.RR
+}
+
+func (s *testDNSServer) set(name string, qtype uint16, rrs []dns.RR) {
+	s.Lock()
+	defer s.Unlock()
+	s.records[name+"/"+dns.TypeToString[qtype]] = rrs
+}
+
+func (s *testDNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.Lock()
+	defer s.Unlock()
+	m := new(dns.Msg)
+	m.SetReply(r)
+	// the AD bit should never be trusted
+	m.AuthenticatedData = true
+	m.Answer = s.records[r.Question[0].Name+"/"+dns.TypeToString[r.Question[0].Qtype]]
+	_ = w.WriteMsg(m)
+}
+
+func TestDNSSECLookup(t *testing.T) {
+	Convey("Given a DNSSEC signed zone", t, func() {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		server := &testDNSServer{records: make(map[string][]dns.RR)}
+		srv := &dns.Server{PacketConn: pc, Handler: server}
+		go func() { _ = srv.ActivateAndServe() }()
+		defer func() { _ = srv.Shutdown() }()
+
+		orgKSK := newTestZoneKey("org.", 257)
+		exKSK := newTestZoneKey("example.org.", 257)
+		exZSK := newTestZoneKey("example.org.", 256)
+		ds := exKSK.ToDS(dns.SHA256)
+		aaaa := &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: "seed.example.org.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+			AAAA: net.ParseIP("2001:db8::1"),
+		}
+		server.set("org.", dns.TypeDNSKEY, orgKSK.sign(orgKSK.DNSKEY))
+		server.set("example.org.", dns.TypeDS, orgKSK.sign(ds))
+		server.set("example.org.", dns.TypeDNSKEY, exKSK.sign(exKSK.DNSKEY, exZSK.DNSKEY))
+		server.set("seed.example.org.", dns.TypeAAAA, exZSK.sign(aaaa))
+
+		cfg := &conf.DNSSeed{
+			EnforcedDNSSEC:     true,
+			DNSServers:         []string{pc.LocalAddr().String()},
+			DNSSECTrustAnchors: []string{orgKSK.DNSKEY.String()},
+		}
+
+		Convey("The answer should be validated along the DS chain", func() {
+			ips, err := NewDNSSeedLookup(cfg)("seed.example.org")
+			So(err, ShouldBeNil)
+			So(ips, ShouldHaveLength, 1)
+			So(ips[0].Equal(aaaa.AAAA), ShouldBeTrue)
+
+			// DS trust anchor of the signer zone
+			cfg.DNSSECTrustAnchors = []string{ds.String()}
+			ips, err = NewDNSSeedLookup(cfg)("seed.example.org")
+			So(err, ShouldBeNil)
+			So(ips, ShouldHaveLength, 1)
+		})
+		Convey("The tampered answer should be rejected", func() {
+			forged := dns.Copy(aaaa).(*dns.AAAA)
+			forged.AAAA = net.ParseIP("2001:db8::2")
+			signed := exZSK.sign(aaaa)
+			server.set("seed.example.org.", dns.TypeAAAA, []dns.RR{forged, signed[1]})
+			_, err := NewDNSSeedLookup(cfg)("seed.example.org")
+			So(errors.Cause(err), ShouldEqual, ErrDNSSECNotValidated)
+
+			// without enforcement the answer is not validated
+			cfg.EnforcedDNSSEC = false
+			ips, err := NewDNSSeedLookup(cfg)("seed.example.org")
+			So(err, ShouldBeNil)
+			So(ips[0].Equal(forged.AAAA), ShouldBeTrue)
+		})
+		Convey("The unsigned answer with AD bit should be rejected", func() {
+			server.set("seed.example.org.", dns.TypeAAAA, []dns.RR{aaaa})
+			_, err := NewDNSSeedLookup(cfg)("seed.example.org")
+			So(errors.Cause(err), ShouldEqual, ErrDNSSECNotValidated)
+		})
+		Convey("The answer signed by key not in chain should be rejected", func() {
+			other := newTestZoneKey("example.org.", 256)
+			server.set("seed.example.org.", dns.TypeAAAA, other.sign(aaaa))
+			_, err := NewDNSSeedLookup(cfg)("seed.example.org")
+			So(errors.Cause(err), ShouldEqual, ErrDNSSECNotValidated)
+		})
+		Convey("The answer should be rejected with unrelated trust anchor", func() {
+			cfg.DNSSECTrustAnchors = []string{newTestZoneKey("org.", 257).DNSKEY.String()}
+			_, err := NewDNSSeedLookup(cfg)("seed.example.org")
+			So(errors.Cause(err), ShouldEqual, ErrDNSSECNotValidated)
+		})
+		Convey("The invalid trust anchor should fail the lookup", func() {
+			cfg.DNSSECTrustAnchors = []string{aaaa.String()}
+			_, err := NewDNSSeedLookup(cfg)("seed.example.org")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
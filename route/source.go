@@ -0,0 +1,166 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// ErrInvalidNodeInfo indicates the node info found doesn't match its node id.
+var ErrInvalidNodeInfo = errors.New("node info does not match node id")
+
+// NodeSource discovers the node info by node id in addition to the block producer DHT.
+type NodeSource interface {
+	// FindNode returns the node info of id, ErrUnknownNodeID if it's not found.
+	FindNode(id *proto.RawNodeID) (*proto.Node, error)
+}
+
+var (
+	nodeSourcesLock sync.RWMutex
+	nodeSources     []NodeSource
+)
+
+// RegisterNodeSource adds the node source, the sources are consulted in registration order.
+func RegisterNodeSource(source NodeSource) {
+	nodeSourcesLock.Lock()
+	defer nodeSourcesLock.Unlock()
+	nodeSources = append(nodeSources, source)
+}
+
+// FindNodeInSources finds the node info in the registered node sources. The node info is
+// verified against the node id, so that a source can't forge the public key of other node.
+func FindNodeInSources(id *proto.RawNodeID) (node *proto.Node, err error) {
+	initResolver()
+	nodeSourcesLock.RLock()
+	sources := make([]NodeSource, 0, len(resolver.sources)+len(nodeSources))
+	sources = append(sources, resolver.sources...)
+	sources = append(sources, nodeSources...)
+	nodeSourcesLock.RUnlock()
+
+	err = ErrUnknownNodeID
+	for _, v := range sources {
+		found, findErr := v.FindNode(id)
+		if findErr != nil {
+			if errors.Cause(findErr) != ErrUnknownNodeID {
+				err = findErr
+			}
+			continue
+		}
+		if !kms.IsIDPubNonceValid(id, &found.Nonce, found.PublicKey) {
+			err = errors.Wrapf(ErrInvalidNodeInfo, "node %s", id.String())
+			continue
+		}
+		return found, nil
+	}
+	return
+}
+
+// initNodeSources builds the node sources configured.
+func initNodeSources() (sources []NodeSource) {
+	if conf.GConf.NodeRegistryFile != "" {
+		registry, err := NewStaticRegistry(conf.GConf.NodeRegistryFile)
+		if err != nil {
+			log.WithField("file", conf.GConf.NodeRegistryFile).WithError(err).Error(
+				"load node registry failed")
+		} else {
+			sources = append(sources, registry)
+		}
+	}
+	if conf.GConf.DNSSeed.NodeDomain != "" {
+		sources = append(sources, &DNSSeedSource{
+			Domain: conf.GConf.DNSSeed.NodeDomain,
+			Client: IPv6SeedClient{Lookup: NewDNSSeedLookup(&conf.GConf.DNSSeed)},
+		})
+	}
+	return
+}
+
+// StaticRegistry is the node source of a static node list file, which is in the same format as
+// KnownNodes of config.
+type StaticRegistry struct {
+	nodes map[proto.RawNodeID]proto.Node
+}
+
+// NewStaticRegistry loads the node list file as a StaticRegistry.
+func NewStaticRegistry(path string) (r *StaticRegistry, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		err = errors.Wrapf(err, "read node registry %s failed", path)
+		return
+	}
+	var nodes []proto.Node
+	if err = yaml.Unmarshal(data, &nodes); err != nil {
+		err = errors.Wrapf(err, "unmarshal node registry %s failed", path)
+		return
+	}
+	r = &StaticRegistry{nodes: make(map[proto.RawNodeID]proto.Node, len(nodes))}
+	for _, v := range nodes {
+		if rawID := v.ID.ToRawNodeID(); rawID != nil {
+			r.nodes[*rawID] = v
+		}
+	}
+	return
+}
+
+// FindNode implements NodeSource.FindNode.
+func (r *StaticRegistry) FindNode(id *proto.RawNodeID) (node *proto.Node, err error) {
+	v, ok := r.nodes[*id]
+	if !ok {
+		err = ErrUnknownNodeID
+		return
+	}
+	node = &v
+	return
+}
+
+// DNSSeedSource is the node source of IPv6 DNS seed records. A node is published under the
+// domain "<the first 32 chars of node id>.<Domain>" in the same layout as the block
+// producers, see IPv6SeedClient.GenBPIPv6.
+type DNSSeedSource struct {
+	Domain string
+	Client IPv6SeedClient
+}
+
+// NodeDomain returns the seed domain of the node.
+func (s *DNSSeedSource) NodeDomain(id *proto.RawNodeID) string {
+	return string(id.ToNodeID())[:32] + "." + s.Domain
+}
+
+// FindNode implements NodeSource.FindNode.
+func (s *DNSSeedSource) FindNode(id *proto.RawNodeID) (node *proto.Node, err error) {
+	var nodes IDNodeMap
+	if nodes, err = s.Client.GetBPFromDNSSeed(s.NodeDomain(id)); err != nil {
+		err = errors.Wrapf(err, "lookup node %s in DNS seed failed", id.String())
+		return
+	}
+	v, ok := nodes[*id]
+	if !ok {
+		err = ErrUnknownNodeID
+		return
+	}
+	node = &v
+	return
+}
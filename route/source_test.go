@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package route
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestNodeSources(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	Convey("Given a static node registry file", t, func() {
+		dir, err := ioutil.TempDir("", "node_registry")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		nodes := conf.GConf.KnownNodes
+		So(len(nodes), ShouldBeGreaterThan, 1)
+		forged := nodes[1]
+		forged.Nonce.A++
+		data, err := yaml.Marshal([]proto.Node{nodes[0], forged})
+		So(err, ShouldBeNil)
+		registryFile := filepath.Join(dir, "registry.yaml")
+		So(ioutil.WriteFile(registryFile, data, 0600), ShouldBeNil)
+
+		registry, err := NewStaticRegistry(registryFile)
+		So(err, ShouldBeNil)
+		_, err = NewStaticRegistry(filepath.Join(dir, "not_exist.yaml"))
+		So(err, ShouldNotBeNil)
+
+		nodeSourcesLock.Lock()
+		nodeSources = nil
+		nodeSourcesLock.Unlock()
+		RegisterNodeSource(registry)
+		defer func() {
+			nodeSourcesLock.Lock()
+			nodeSources = nil
+			nodeSourcesLock.Unlock()
+		}()
+
+		Convey("The valid node should be found", func() {
+			node, err := FindNodeInSources(nodes[0].ID.ToRawNodeID())
+			So(err, ShouldBeNil)
+			So(node.ID, ShouldEqual, nodes[0].ID)
+			So(node.Addr, ShouldEqual, nodes[0].Addr)
+		})
+		Convey("The forged node should be rejected", func() {
+			_, err := FindNodeInSources(forged.ID.ToRawNodeID())
+			So(err, ShouldNotBeNil)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidNodeInfo)
+		})
+		Convey("The unknown node should not be found", func() {
+			_, err := FindNodeInSources(nodes[2].ID.ToRawNodeID())
+			So(err, ShouldEqual, ErrUnknownNodeID)
+		})
+	})
+	Convey("Given a DNS seed node source", t, func() {
+		id := conf.GConf.KnownNodes[0].ID
+		source := &DNSSeedSource{Domain: "nodes.example.org"}
+		So(source.NodeDomain(id.ToRawNodeID()), ShouldEqual,
+			string(id)[:32]+".nodes.example.org")
+		So(NewDNSSeedLookup(&conf.DNSSeed{}), ShouldBeNil)
+		So(NewDNSSeedLookup(&conf.DNSSeed{DNSServers: []string{"1.1.1.1"}}), ShouldNotBeNil)
+		So(withDNSPort("1.1.1.1"), ShouldEqual, "1.1.1.1:53")
+		So(withDNSPort("[::1]:5353"), ShouldEqual, "[::1]:5353")
+		So(withDNSPort("::1"), ShouldEqual, "[::1]:53")
+	})
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/naconn"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+)
+
+// Remote node roles of MethodACL.
+const (
+	ACLRoleBP      = "BP"
+	ACLRoleMiner   = "Miner"
+	ACLRoleClient  = "Client"
+	ACLRoleUnknown = "Unknown"
+)
+
+const aclRoleCacheSize = 4096
+
+// ErrMethodNotPermitted indicates the RPC method is not in the whitelist of the caller role.
+var ErrMethodNotPermitted = errors.New("method not permitted")
+
+// MethodACL is the server interceptor which rejects the calls outside the method whitelist of
+// the caller role. The anonymous calls are not checked, which are restricted by
+// NodeAwareServerCodec already.
+type MethodACL struct {
+	rules map[string][]string
+	// roles caches the resolved roles of remote nodes: proto.NodeID -> string.
+	roles *lru.Cache
+	// resolveNode returns the node info to find the role of remote node.
+	resolveNode func(id *proto.RawNodeID) (*proto.Node, error)
+}
+
+// NewMethodACL returns a new MethodACL with the whitelists of roles, the methods are given as
+// "Service.Method" or "Service.*".
+func NewMethodACL(rules map[string][]string) *MethodACL {
+	roles, _ := lru.New(aclRoleCacheSize)
+	return &MethodACL{
+		rules:       rules,
+		roles:       roles,
+		resolveNode: resolveNode,
+	}
+}
+
+// resolveNode finds the node info in local public key store first, and then asks the resolver.
+func resolveNode(id *proto.RawNodeID) (node *proto.Node, err error) {
+	if node, err = kms.GetNodeInfo(id.ToNodeID()); err == nil {
+		return
+	}
+	return naconn.ResolveEx(id)
+}
+
+// IsAllowed reports whether the method is in the whitelist of the role.
+func (a *MethodACL) IsAllowed(role, method string) bool {
+	for _, v := range a.rules[role] {
+		if v == method || v == "*" ||
+			(strings.HasSuffix(v, ".*") && strings.HasPrefix(method, v[:len(v)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Role returns the role of the remote node, ACLRoleUnknown is returned if the node info can't be
+// resolved.
+func (a *MethodACL) Role(id *proto.RawNodeID) string {
+	if route.IsBPNodeID(id) {
+		return ACLRoleBP
+	}
+	nodeID := id.ToNodeID()
+	if v, ok := a.roles.Get(nodeID); ok {
+		return v.(string)
+	}
+	node, err := a.resolveNode(id)
+	if err != nil {
+		// not cached, the node may be registered later
+		return ACLRoleUnknown
+	}
+	var role string
+	switch node.Role {
+	case proto.Leader, proto.Follower:
+		role = ACLRoleBP
+	case proto.Miner:
+		role = ACLRoleMiner
+	case proto.Client:
+		role = ACLRoleClient
+	default:
+		role = ACLRoleUnknown
+	}
+	a.roles.Add(nodeID, role)
+	return role
+}
+
+// OnRequest implements Interceptor.OnRequest.
+func (a *MethodACL) OnRequest(ctx context.Context, info *CallInfo) (err error) {
+	if info.Remote == nil || info.Remote.IsEqual(&kms.AnonymousRawNodeID.Hash) {
+		return
+	}
+	if role := a.Role(info.Remote); !a.IsAllowed(role, info.Method) {
+		err = errors.Wrapf(ErrMethodNotPermitted, "calling %s from %s node %s",
+			info.Method, role, info.Remote.ToNodeID())
+	}
+	return
+}
+
+// OnResponse implements Interceptor.OnResponse.
+func (a *MethodACL) OnResponse(ctx context.Context, info *CallInfo, err error) {}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+)
+
+func TestMethodACL(t *testing.T) {
+	Convey("Check methods of roles", t, func() {
+		acl := NewMethodACL(map[string][]string{
+			ACLRoleClient: {"DBS.Query", "DHT.*"},
+			ACLRoleBP:     {"*"},
+		})
+		So(acl.IsAllowed(ACLRoleClient, "DBS.Query"), ShouldBeTrue)
+		So(acl.IsAllowed(ACLRoleClient, "DBS.Deploy"), ShouldBeFalse)
+		So(acl.IsAllowed(ACLRoleClient, "DHT.Ping"), ShouldBeTrue)
+		So(acl.IsAllowed(ACLRoleClient, "DHTG.SetNode"), ShouldBeFalse)
+		So(acl.IsAllowed(ACLRoleBP, "DHTG.SetNode"), ShouldBeTrue)
+		So(acl.IsAllowed(ACLRoleMiner, "DBS.Query"), ShouldBeFalse)
+
+		var resolved int
+		acl.resolveNode = func(id *proto.RawNodeID) (*proto.Node, error) {
+			resolved++
+			if resolved == 1 {
+				return nil, errors.New("not found")
+			}
+			return &proto.Node{ID: id.ToNodeID(), Role: proto.Miner}, nil
+		}
+		id := &proto.RawNodeID{}
+		So(acl.Role(id), ShouldEqual, ACLRoleUnknown)
+		So(acl.Role(id), ShouldEqual, ACLRoleMiner)
+		So(acl.Role(id), ShouldEqual, ACLRoleMiner)
+		So(resolved, ShouldEqual, 2)
+		So(acl.Role(route.GetBPs()[0].ToRawNodeID()), ShouldEqual, ACLRoleBP)
+	})
+	Convey("Setup a single server with method ACL", t, func(c C) {
+		nodes, err := createLocalNodes(10, 1)
+		So(err, ShouldBeNil)
+		server, err := setupServer(nodes[0])
+		So(err, ShouldBeNil)
+		acl := NewMethodACL(map[string][]string{ACLRoleClient: {"Admin.*"}})
+		acl.resolveNode = func(id *proto.RawNodeID) (*proto.Node, error) {
+			return &proto.Node{ID: id.ToNodeID(), Role: proto.Client}, nil
+		}
+		server.Use(acl)
+		go server.Serve()
+		defer func() {
+			defaultResolver.deleteNode(*(nodes[0].ID.ToRawNodeID()))
+			server.Stop()
+		}()
+		pool := &ClientPool{}
+		defer func() { _ = pool.Close() }()
+		caller := NewCallerWithPool(pool)
+
+		var resp AddResp
+		err = caller.CallNode(nodes[0].ID, "Count.Add", &AddReq{Delta: 1}, &resp)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, ErrMethodNotPermitted.Error())
+		var bwResp BandwidthResp
+		err = caller.CallNode(nodes[0].ID, route.AdminBandwidth.String(), &BandwidthReq{}, &bwResp)
+		So(err, ShouldBeNil)
+
+		// anonymous calls are not checked by role
+		So(acl.OnRequest(context.Background(), &CallInfo{Method: "Count.Add", Remote: kms.AnonymousRawNodeID}), ShouldBeNil)
+	})
+}
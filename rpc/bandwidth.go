@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+)
+
+// BandwidthStats is the traffic of a remote node served by the rpc server, including the
+// handshake and encryption overhead.
+type BandwidthStats struct {
+	NodeID proto.NodeID
+	// BytesIn is the bytes received from the node.
+	BytesIn uint64
+	// BytesOut is the bytes sent to the node.
+	BytesOut uint64
+}
+
+// BandwidthReq defines a request of the Admin.Bandwidth RPC method.
+type BandwidthReq struct {
+	proto.Envelope
+	// NodeIDs filters the nodes to query, all nodes are returned if it's empty.
+	NodeIDs []proto.NodeID
+}
+
+// BandwidthResp defines a response of the Admin.Bandwidth RPC method.
+type BandwidthResp struct {
+	proto.Envelope
+	Stats []BandwidthStats
+}
+
+type bandwidthCounter struct {
+	in, out uint64
+}
+
+// bandwidthAccounting aggregates the traffic of server connections by remote node.
+type bandwidthAccounting struct {
+	sync.Mutex
+	nodes map[proto.NodeID]*bandwidthCounter
+}
+
+func (b *bandwidthAccounting) counter(id proto.NodeID) (c *bandwidthCounter) {
+	b.Lock()
+	defer b.Unlock()
+	if b.nodes == nil {
+		b.nodes = make(map[proto.NodeID]*bandwidthCounter)
+	}
+	if c = b.nodes[id]; c == nil {
+		c = &bandwidthCounter{}
+		b.nodes[id] = c
+	}
+	return
+}
+
+// attach accounts the traffic of conn to the node, including the bytes transferred during
+// handshake. It should be called before the conn is served.
+func (b *bandwidthAccounting) attach(conn *countingConn, id proto.NodeID) {
+	c := b.counter(id)
+	prev := conn.counter.Load().(*bandwidthCounter)
+	conn.counter.Store(c)
+	atomic.AddUint64(&c.in, atomic.LoadUint64(&prev.in))
+	atomic.AddUint64(&c.out, atomic.LoadUint64(&prev.out))
+}
+
+// stats returns the traffic of the nodes sorted by node id, or all nodes if ids is empty.
+func (b *bandwidthAccounting) stats(ids ...proto.NodeID) (stats []BandwidthStats) {
+	b.Lock()
+	defer b.Unlock()
+	add := func(id proto.NodeID, c *bandwidthCounter) {
+		stats = append(stats, BandwidthStats{
+			NodeID:   id,
+			BytesIn:  atomic.LoadUint64(&c.in),
+			BytesOut: atomic.LoadUint64(&c.out),
+		})
+	}
+	if len(ids) == 0 {
+		for id, c := range b.nodes {
+			add(id, c)
+		}
+	} else {
+		for _, id := range ids {
+			if c, ok := b.nodes[id]; ok {
+				add(id, c)
+			}
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].NodeID < stats[j].NodeID })
+	return
+}
+
+// countingConn counts the bytes transferred through the connection.
+type countingConn struct {
+	net.Conn
+	counter atomic.Value // *bandwidthCounter
+}
+
+func newCountingConn(conn net.Conn, counter *bandwidthCounter) *countingConn {
+	if counter == nil {
+		counter = &bandwidthCounter{}
+	}
+	c := &countingConn{Conn: conn}
+	c.counter.Store(counter)
+	return c
+}
+
+// Read implements net.Conn.Read.
+func (c *countingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddUint64(&c.counter.Load().(*bandwidthCounter).in, uint64(n))
+	return
+}
+
+// Write implements net.Conn.Write.
+func (c *countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddUint64(&c.counter.Load().(*bandwidthCounter).out, uint64(n))
+	return
+}
+
+// Bandwidth returns the traffic of the remote nodes served by the server, or all nodes if ids is
+// empty.
+func (s *Server) Bandwidth(ids ...proto.NodeID) []BandwidthStats {
+	return s.bandwidth.stats(ids...)
+}
+
+// AdminService is the administration service of rpc server, which is only permitted to block
+// producers and the local node.
+type AdminService struct {
+	server *Server
+}
+
+// Bandwidth queries the traffic of the remote nodes served by the server.
+func (a *AdminService) Bandwidth(req *BandwidthReq, resp *BandwidthResp) (err error) {
+	if err = checkAdminPermission(&req.Envelope, route.AdminBandwidth); err != nil {
+		return
+	}
+	resp.Stats = a.server.Bandwidth(req.NodeIDs...)
+	return
+}
+
+func checkAdminPermission(envelope *proto.Envelope, funcName route.RemoteFunc) (err error) {
+	if route.IsPermitted(envelope, funcName) {
+		return
+	}
+	if caller := envelope.GetNodeID(); caller != nil {
+		if local, err := kms.GetLocalNodeID(); err == nil && caller.ToNodeID() == local {
+			return nil
+		}
+	}
+	return errors.Errorf("calling %s from node %s is not permitted", funcName, envelope.GetNodeID())
+}
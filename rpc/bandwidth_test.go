@@ -0,0 +1,72 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+)
+
+func TestBandwidth(t *testing.T) {
+	Convey("Setup a single server and query bandwidth", t, func(c C) {
+		nodes, err := createLocalNodes(10, 2)
+		So(err, ShouldBeNil)
+		server, err := setupServer(nodes[0])
+		So(err, ShouldBeNil)
+		go server.Serve()
+		defer func() {
+			defaultResolver.deleteNode(*(nodes[0].ID.ToRawNodeID()))
+			server.Stop()
+		}()
+		local, err := kms.GetLocalNodeID()
+		So(err, ShouldBeNil)
+		So(server.Bandwidth(), ShouldBeEmpty)
+
+		pool := &ClientPool{}
+		defer func() { _ = pool.Close() }()
+		caller := NewCallerWithPool(pool)
+		var resp AddResp
+		err = caller.CallNode(nodes[0].ID, "Count.Add", &AddReq{Delta: 1}, &resp)
+		So(err, ShouldBeNil)
+
+		stats := server.Bandwidth(local)
+		So(len(stats), ShouldEqual, 1)
+		So(stats[0].NodeID, ShouldEqual, local)
+		So(stats[0].BytesIn, ShouldBeGreaterThan, 0)
+		So(stats[0].BytesOut, ShouldBeGreaterThan, 0)
+		So(server.Bandwidth(nodes[1].ID), ShouldBeEmpty)
+
+		// local node is permitted to query bandwidth
+		var bwResp BandwidthResp
+		err = caller.CallNode(nodes[0].ID, route.AdminBandwidth.String(), &BandwidthReq{}, &bwResp)
+		So(err, ShouldBeNil)
+		So(len(bwResp.Stats), ShouldEqual, 1)
+		So(bwResp.Stats[0].NodeID, ShouldEqual, local)
+		So(bwResp.Stats[0].BytesIn, ShouldBeGreaterThan, stats[0].BytesIn)
+
+		// other nodes are not permitted
+		err = checkAdminPermission(&proto.Envelope{NodeID: nodes[1].ID.ToRawNodeID()}, route.AdminBandwidth)
+		So(err, ShouldNotBeNil)
+		err = checkAdminPermission(&proto.Envelope{NodeID: local.ToRawNodeID()}, route.AdminBandwidth)
+		So(err, ShouldBeNil)
+	})
+}
@@ -39,6 +39,7 @@ func recordRPCCost(startTime time.Time, method string, err error) {
 		name, nameC string
 		val, valC   expvar.Var
 	)
+	observeCall(startTime, method, err)
 	costTime := time.Since(startTime)
 	if err == nil {
 		name = "t_succ:" + method
@@ -89,19 +90,33 @@ func (c *Caller) CallNodeWithContext(
 		recordRPCCost(startTime, method, err)
 	}()
 
-	client, err := DialToNodeWithPool(c.pool, node, method == route.DHTPing.String())
+	ctx, span := startClientSpan(ctx, node, method, args)
+	defer func() { endSpan(span, err) }()
+
+	intercepted, err := beginClientCall(ctx, node, method, args)
+	defer func() { intercepted.end(ctx, reply, err) }()
+	if err != nil {
+		return
+	}
+
+	client, err := DialToNodeWithContext(ctx, c.pool, node, method == route.DHTPing.String())
 	if err != nil {
 		err = errors.Wrapf(err, "dial to node %s failed", node)
 		return
 	}
 	defer func() { _ = client.Close() }()
 
-	// TODO(xq262144): golang net/rpc does not support cancel in progress calls
 	ch := client.Go(method, args, reply, make(chan *rpc.Call, 1))
 
 	select {
 	case <-ctx.Done():
 		err = ctx.Err()
+		// golang net/rpc does not support canceling in progress calls, mark the client as broken
+		// so that the underlying connection is closed instead of being put back to pool, which
+		// also aborts the pending read
+		if setter, ok := client.(LastErrSetter); ok {
+			setter.SetLastErr(err)
+		}
 	case call := <-ch.Done:
 		err = call.Error
 		// Set error state so that the associated will not reuse this client
@@ -18,8 +18,15 @@ package rpc
 
 import (
 	"context"
+	"fmt"
 	"net/rpc"
+	"strings"
+	"sync"
 
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/naconn"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 )
 
@@ -28,15 +35,44 @@ type NodeAwareServerCodec struct {
 	rpc.ServerCodec
 	NodeID *proto.RawNodeID
 	Ctx    context.Context
+
+	// interceptors of the server attached to Ctx
+	interceptors Interceptors
+	reading      rpc.Request
+	calls        sync.Map // seq -> *CallInfo
+	spans        sync.Map // seq -> trace.Span
 }
 
 // NewNodeAwareServerCodec returns new NodeAwareServerCodec with normal rpc.ServerCode and proto.RawNodeID.
 func NewNodeAwareServerCodec(ctx context.Context, codec rpc.ServerCodec, nodeID *proto.RawNodeID) *NodeAwareServerCodec {
-	return &NodeAwareServerCodec{
+	nc := &NodeAwareServerCodec{
 		ServerCodec: codec,
 		NodeID:      nodeID,
 		Ctx:         ctx,
 	}
+	if server := serverFromContext(ctx); server != nil {
+		nc.interceptors = server.interceptors
+	}
+	return nc
+}
+
+// ReadRequestHeader override default rpc.ServerCodec behaviour and rejects the methods which
+// are not allowed for anonymous node.
+//
+// The method name is rewritten to an ill-formed one without any dot, so that rpc.Server skips the
+// request body and replies an error containing the reason without closing the connection.
+func (nc *NodeAwareServerCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	if err = nc.ServerCodec.ReadRequestHeader(r); err != nil {
+		return
+	}
+	// net/rpc reads the header and body of a request sequentially
+	nc.reading = *r
+	if nc.NodeID != nil && nc.NodeID.IsEqual(&kms.AnonymousRawNodeID.Hash) &&
+		!naconn.IsAnonymousMethodAllowed(r.ServiceMethod) {
+		r.ServiceMethod = fmt.Sprintf(
+			"%s is not permitted for anonymous session", strings.Replace(r.ServiceMethod, ".", ":", -1))
+	}
+	return
 }
 
 // ReadRequestBody override default rpc.ServerCodec behaviour and inject remote node id into request.
@@ -51,12 +87,69 @@ func (nc *NodeAwareServerCodec) ReadRequestBody(body interface{}) (err error) {
 		return
 	}
 
+	// continue the trace of caller, the span is ended after the response is written
+	ctx, span := startServerSpan(nc.Ctx, nc.NodeID, nc.reading.ServiceMethod, body)
+	if span.IsRecording() {
+		nc.spans.Store(nc.reading.Seq, span)
+	}
+
 	if r, ok := body.(proto.EnvelopeAPI); ok {
 		// inject node id to rpc envelope
 		r.SetNodeID(nc.NodeID)
 		// inject context
-		r.SetContext(nc.Ctx)
+		r.SetContext(ctx)
+	}
+
+	if len(nc.interceptors) > 0 {
+		info := &CallInfo{
+			Method: nc.reading.ServiceMethod,
+			Remote: nc.NodeID,
+			Size:   payloadSize(body),
+		}
+		// the error is replied to the caller by rpc.Server
+		if err = nc.interceptors.OnRequest(nc.Ctx, info); err != nil {
+			nc.interceptors.OnResponse(nc.Ctx, info, err)
+			return
+		}
+		nc.calls.Store(nc.reading.Seq, info)
+	}
+
+	return
+}
+
+// WriteResponse override default rpc.ServerCodec behaviour, it calls the OnResponse hooks of
+// interceptors and ends the server span after the response is written.
+func (nc *NodeAwareServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	var (
+		info *CallInfo
+		span trace.Span
+	)
+	if v, ok := nc.calls.Load(r.Seq); ok {
+		nc.calls.Delete(r.Seq)
+		info = v.(*CallInfo)
+	}
+	if v, ok := nc.spans.Load(r.Seq); ok {
+		nc.spans.Delete(r.Seq)
+		span = v.(trace.Span)
+	}
+	if info == nil && span == nil {
+		return nc.ServerCodec.WriteResponse(r, body)
 	}
 
+	var callErr error
+	if r.Error != "" {
+		callErr = rpc.ServerError(r.Error)
+	} else if info != nil {
+		info.ResponseSize = payloadSize(body)
+	}
+	if err = nc.ServerCodec.WriteResponse(r, body); err != nil && callErr == nil {
+		callErr = err
+	}
+	if info != nil {
+		nc.interceptors.OnResponse(nc.Ctx, info, callErr)
+	}
+	if span != nil {
+		endSpan(span, callErr)
+	}
 	return
 }
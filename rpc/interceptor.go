@@ -0,0 +1,149 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// CallInfo describes an RPC call passing through the interceptors.
+type CallInfo struct {
+	// Method is the service method name of the call, e.g. "DHT.Ping".
+	Method string
+	// Remote is the peer node of the call: the caller on server side and the target on client
+	// side. It's nil if the peer is unknown.
+	Remote *proto.RawNodeID
+	// Size is the request payload size in bytes, which is estimated with the Msgsize method of
+	// the request if implemented, otherwise it's 0.
+	Size int
+	// ResponseSize is the response payload size estimated the same way as Size, it's available
+	// in OnResponse.
+	ResponseSize int
+}
+
+// Interceptor defines the middleware hooks of RPC calls, which are plugged into the server with
+// Server.Use and into the client with AddClientInterceptor.
+type Interceptor interface {
+	// OnRequest is called before the request is handled on server side or sent on client side,
+	// the call is rejected with the error if it's not nil.
+	OnRequest(ctx context.Context, info *CallInfo) error
+	// OnResponse is called after the call is done with its error, including the calls rejected
+	// by OnRequest.
+	OnResponse(ctx context.Context, info *CallInfo, err error)
+}
+
+// Interceptors chains the interceptors as a single one. OnRequest hooks are called in order and
+// stop at the first error, OnResponse hooks are called in reverse order.
+type Interceptors []Interceptor
+
+// OnRequest implements Interceptor.OnRequest.
+func (is Interceptors) OnRequest(ctx context.Context, info *CallInfo) (err error) {
+	for _, v := range is {
+		if err = v.OnRequest(ctx, info); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// OnResponse implements Interceptor.OnResponse.
+func (is Interceptors) OnResponse(ctx context.Context, info *CallInfo, err error) {
+	for i := len(is) - 1; i >= 0; i-- {
+		is[i].OnResponse(ctx, info, err)
+	}
+}
+
+var (
+	clientInterceptorsLock sync.RWMutex
+	clientInterceptors     Interceptors
+)
+
+// AddClientInterceptor adds interceptor to the calls made by Caller and PersistentCaller.
+func AddClientInterceptor(interceptor Interceptor) {
+	clientInterceptorsLock.Lock()
+	defer clientInterceptorsLock.Unlock()
+	// copy on write, the callers may hold the old slice
+	clientInterceptors = append(append(Interceptors(nil), clientInterceptors...), interceptor)
+}
+
+func getClientInterceptors() Interceptors {
+	clientInterceptorsLock.RLock()
+	defer clientInterceptorsLock.RUnlock()
+	return clientInterceptors
+}
+
+// Use adds interceptors to the server, it should be called before Serve.
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+func payloadSize(v interface{}) int {
+	switch p := v.(type) {
+	case interface{ Msgsize() int }:
+		return p.Msgsize()
+	case []byte:
+		return len(p)
+	case *[]byte:
+		return len(*p)
+	case string:
+		return len(p)
+	case *string:
+		return len(*p)
+	}
+	return 0
+}
+
+// interceptedCall is a client call passing through the client interceptors.
+type interceptedCall struct {
+	interceptors Interceptors
+	info         CallInfo
+}
+
+// beginClientCall calls the client interceptors before the call, the returned call is nil if
+// there is no client interceptor.
+func beginClientCall(
+	ctx context.Context, node proto.NodeID, method string, args interface{}) (call *interceptedCall, err error,
+) {
+	interceptors := getClientInterceptors()
+	if len(interceptors) == 0 {
+		return
+	}
+	call = &interceptedCall{
+		interceptors: interceptors,
+		info: CallInfo{
+			Method: method,
+			Remote: node.ToRawNodeID(),
+			Size:   payloadSize(args),
+		},
+	}
+	err = interceptors.OnRequest(ctx, &call.info)
+	return
+}
+
+// end calls the client interceptors after the call is done.
+func (c *interceptedCall) end(ctx context.Context, reply interface{}, err error) {
+	if c == nil {
+		return
+	}
+	if err == nil {
+		c.info.ResponseSize = payloadSize(reply)
+	}
+	c.interceptors.OnResponse(ctx, &c.info, err)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var errRejected = errors.New("rejected by interceptor")
+
+func (r *AddReq) Msgsize() int  { return 4 }
+func (r *AddResp) Msgsize() int { return 8 }
+
+type recordInterceptor struct {
+	sync.Mutex
+	reject    bool
+	requests  []CallInfo
+	responses []CallInfo
+	errs      []error
+}
+
+func (i *recordInterceptor) OnRequest(ctx context.Context, info *CallInfo) error {
+	i.Lock()
+	defer i.Unlock()
+	i.requests = append(i.requests, *info)
+	if i.reject {
+		return errRejected
+	}
+	return nil
+}
+
+func (i *recordInterceptor) OnResponse(ctx context.Context, info *CallInfo, err error) {
+	i.Lock()
+	defer i.Unlock()
+	i.responses = append(i.responses, *info)
+	i.errs = append(i.errs, err)
+}
+
+func TestInterceptors(t *testing.T) {
+	Convey("Setup a single server with interceptors", t, func(c C) {
+		nodes, err := createLocalNodes(10, 1)
+		So(err, ShouldBeNil)
+		server, err := setupServer(nodes[0])
+		So(err, ShouldBeNil)
+		var (
+			first, second = &recordInterceptor{}, &recordInterceptor{}
+			client        = &recordInterceptor{}
+		)
+		server.Use(first, second)
+		go server.Serve()
+		defer func() {
+			defaultResolver.deleteNode(*(nodes[0].ID.ToRawNodeID()))
+			server.Stop()
+		}()
+		AddClientInterceptor(client)
+		defer func() {
+			clientInterceptorsLock.Lock()
+			defer clientInterceptorsLock.Unlock()
+			clientInterceptors = nil
+		}()
+		target := nodes[0].ID
+		pool := &ClientPool{}
+		defer func() { _ = pool.Close() }()
+		caller := NewCallerWithPool(pool)
+
+		var resp AddResp
+		err = caller.CallNode(target, "Count.Add", &AddReq{Delta: 1}, &resp)
+		So(err, ShouldBeNil)
+		for _, v := range []*recordInterceptor{first, second, client} {
+			So(len(v.requests), ShouldEqual, 1)
+			So(v.requests[0].Method, ShouldEqual, "Count.Add")
+			So(v.requests[0].Size, ShouldEqual, 4)
+			So(v.responses[0].ResponseSize, ShouldEqual, 8)
+			So(v.errs[0], ShouldBeNil)
+		}
+		So(client.requests[0].Remote.IsEqual(&target.ToRawNodeID().Hash), ShouldBeTrue)
+		So(first.requests[0].Remote, ShouldNotBeNil)
+
+		// rejected by server side interceptor
+		first.reject = true
+		err = caller.CallNode(target, "Count.Add", &AddReq{Delta: 1}, &resp)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, errRejected.Error())
+		So(len(first.responses), ShouldEqual, 2)
+		So(first.errs[1], ShouldEqual, errRejected)
+		So(len(second.requests), ShouldEqual, 1)
+		So(len(client.errs), ShouldEqual, 2)
+		So(client.errs[1], ShouldNotBeNil)
+		first.reject = false
+
+		// the connection should still work after rejection
+		pcaller := NewPersistentCallerWithPool(pool, target)
+		defer pcaller.Close()
+		err = pcaller.Call("Count.Add", &AddReq{Delta: 1}, &resp)
+		So(err, ShouldBeNil)
+		So(len(second.requests), ShouldEqual, 2)
+
+		// rejected by client side interceptor
+		client.reject = true
+		err = pcaller.Call("Count.Add", &AddReq{Delta: 1}, &resp)
+		So(errors.Cause(err), ShouldEqual, errRejected)
+		So(len(first.requests), ShouldEqual, 3)
+	})
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const metricNamespace = "covenantsql"
+
+var (
+	dialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricNamespace,
+		Subsystem: "rpc",
+		Name:      "dial_duration_seconds",
+		Help:      "Latency of dialing new node connections, including the ETLS handshake.",
+		Buckets:   prometheus.ExponentialBuckets(.001, 2, 15),
+	}, []string{"transport", "result"})
+	callDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricNamespace,
+		Subsystem: "rpc",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of RPC calls by method and result.",
+		Buckets:   prometheus.ExponentialBuckets(.001, 2, 15),
+	}, []string{"method", "result"})
+	callErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "rpc",
+		Name:      "call_errors_total",
+		Help:      "Number of failed RPC calls by method.",
+	}, []string{"method"})
+	poolConnectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricNamespace, "rpc", "pool_connections"),
+		"Number of pooled physical connections by pool and node.",
+		[]string{"pool", "node"},
+		nil,
+	)
+
+	defaultPoolCollector = &poolCollector{}
+)
+
+// NodePoolSizer is implemented by the client pools which expose their physical connection count
+// of each node for metrics.
+type NodePoolSizer interface {
+	Sizes() map[proto.NodeID]int
+}
+
+// poolCollector implements the prometheus.Collector interface for the registered pools.
+type poolCollector struct {
+	pools sync.Map // string -> NodePoolSizer
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConnectionsDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.pools.Range(func(k, v interface{}) bool {
+		for node, size := range v.(NodePoolSizer).Sizes() {
+			ch <- prometheus.MustNewConstMetric(
+				poolConnectionsDesc, prometheus.GaugeValue, float64(size), k.(string), string(node))
+		}
+		return true
+	})
+}
+
+// RegisterPoolMetrics registers the pool to report its size per node with name as the pool
+// label value.
+func RegisterPoolMetrics(name string, pool NodePoolSizer) {
+	defaultPoolCollector.pools.Store(name, pool)
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "fail"
+	}
+	return "succ"
+}
+
+// ObserveDial records the latency and result of dialing a new connection with the transport.
+func ObserveDial(transport string, startTime time.Time, err error) {
+	dialDuration.WithLabelValues(transport, resultLabel(err)).Observe(time.Since(startTime).Seconds())
+}
+
+func observeCall(startTime time.Time, method string, err error) {
+	callDuration.WithLabelValues(method, resultLabel(err)).Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		callErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func init() {
+	prometheus.MustRegister(dialDuration, callDuration, callErrors, defaultPoolCollector)
+	RegisterPoolMetrics("tcp", defaultPool)
+	RegisterPoolMetrics("quic", defaultQUICPool)
+}
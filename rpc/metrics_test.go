@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func callCount(method, result string) uint64 {
+	var m dto.Metric
+	_ = callDuration.WithLabelValues(method, result).(prometheus.Histogram).Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRPCMetrics(t *testing.T) {
+	Convey("Setup a single server for metrics test", t, func(c C) {
+		nodes, stop, err := setupEnvironment(1, AcceptNAConn)
+		So(err, ShouldBeNil)
+		defer stop()
+
+		target := nodes[0].ID
+		pool := &ClientPool{}
+		defer func() { _ = pool.Close() }()
+		RegisterPoolMetrics("test", pool)
+		defer defaultPoolCollector.pools.Delete("test")
+
+		succ := callCount("Count.Add", "succ")
+		fail := testutil.ToFloat64(callErrors.WithLabelValues("Count.NotFound"))
+
+		caller := NewCallerWithPool(pool)
+		err = caller.CallNode(target, "Count.Add", &AddReq{Delta: 1}, &AddResp{})
+		So(err, ShouldBeNil)
+		err = caller.CallNode(target, "Count.NotFound", &AddReq{Delta: 1}, &AddResp{})
+		So(err, ShouldNotBeNil)
+
+		So(callCount("Count.Add", "succ"), ShouldEqual, succ+1)
+		So(testutil.ToFloat64(callErrors.WithLabelValues("Count.NotFound")), ShouldEqual, fail+1)
+		So(pool.Sizes(), ShouldResemble, map[proto.NodeID]int{target: 0})
+	})
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mux
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	mux "github.com/xtaci/smux"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// drainCheckInterval defines the interval of outstanding streams checks during draining.
+const drainCheckInterval = 10 * time.Millisecond
+
+// ErrDraining indicates that the session or the pool is draining and refuses new clients.
+var ErrDraining = errors.New("session pool is draining")
+
+// Drain stops opening new streams on the session and waits for the outstanding streams to be
+// closed until ctx is done, then closes the connections.
+func (s *Session) Drain(ctx context.Context) (err error) {
+	s.Lock()
+	s.draining = true
+	s.Unlock()
+
+	err = waitDrained(ctx, s.outstanding)
+	if cerr := s.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return
+}
+
+// outstanding returns the opened stream count of current and retired connections.
+func (s *Session) outstanding() (n int) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, sess := range append([]*mux.Session{s.sess}, s.retired...) {
+		if sess != nil && !sess.IsClosed() {
+			n += sess.NumStreams()
+		}
+	}
+	return
+}
+
+func (s *Session) isDraining() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.draining
+}
+
+// Drain stops handing out new clients and waits for the outstanding RPCs of all sessions to be
+// finished until ctx is done, then closes all connections. The pool refuses new clients after
+// draining.
+func (p *SessionPool) Drain(ctx context.Context) (err error) {
+	p.Lock()
+	p.draining = true
+	p.stopReaper()
+	sessions := make([]*Session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		errmsgs []string
+	)
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			if err := s.Drain(ctx); err != nil && err != ctx.Err() {
+				lock.Lock()
+				errmsgs = append(errmsgs, err.Error())
+				lock.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	p.Lock()
+	p.sessions = make(map[proto.NodeID]*Session)
+	p.Unlock()
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if len(errmsgs) > 0 {
+		err = errors.Wrap(errors.New(strings.Join(errmsgs, ", ")), "drain session pool")
+	}
+	return
+}
+
+func (p *SessionPool) isDraining() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.draining
+}
+
+// waitDrained waits until count returns 0 or ctx is done.
+func waitDrained(ctx context.Context, count func() int) error {
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for count() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
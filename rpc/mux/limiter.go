@@ -0,0 +1,219 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mux
+
+import (
+	"context"
+	"sync"
+
+	mux "github.com/xtaci/smux"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+)
+
+// streamWaiter is a pending request of stream opening.
+type streamWaiter struct {
+	node    proto.NodeID
+	ready   chan struct{}
+	granted bool
+}
+
+// streamLimiter limits the in-flight clients to each node and to all nodes of a pool. When the
+// limits are reached, the pending requests of different nodes are granted in round-robin order,
+// so that a hot node can not starve the others.
+type streamLimiter struct {
+	sync.Mutex
+	maxPerNode int
+	maxTotal   int
+	total      int
+	inflight   map[proto.NodeID]int
+	waiters    map[proto.NodeID][]*streamWaiter
+	queue      []proto.NodeID // nodes with pending requests in round-robin order
+}
+
+// setLimits updates the limits, non-positive value means unlimited.
+func (l *streamLimiter) setLimits(maxPerNode, maxTotal int) {
+	l.Lock()
+	defer l.Unlock()
+	l.maxPerNode = maxPerNode
+	l.maxTotal = maxTotal
+	l.dispatch()
+}
+
+// acquire waits until a client to the node is allowed or ctx is done, the returned release
+// function must be called after the client is closed.
+func (l *streamLimiter) acquire(ctx context.Context, id proto.NodeID) (release func(), err error) {
+	l.Lock()
+	if len(l.waiters[id]) == 0 && l.available(id) {
+		l.take(id)
+		l.Unlock()
+		return l.releaseFunc(id), nil
+	}
+	w := &streamWaiter{node: id, ready: make(chan struct{})}
+	if l.waiters == nil {
+		l.waiters = make(map[proto.NodeID][]*streamWaiter)
+	}
+	if len(l.waiters[id]) == 0 {
+		l.queue = append(l.queue, id)
+	}
+	l.waiters[id] = append(l.waiters[id], w)
+	l.Unlock()
+
+	select {
+	case <-w.ready:
+		return l.releaseFunc(id), nil
+	case <-ctx.Done():
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if w.granted {
+		// granted concurrently, give it back
+		l.release(id)
+	} else {
+		l.remove(w)
+	}
+	return nil, ctx.Err()
+}
+
+func (l *streamLimiter) releaseFunc(id proto.NodeID) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.Lock()
+			defer l.Unlock()
+			l.release(id)
+		})
+	}
+}
+
+// available reports whether a new client to the node is allowed, lock must be held.
+func (l *streamLimiter) available(id proto.NodeID) bool {
+	return (l.maxPerNode <= 0 || l.inflight[id] < l.maxPerNode) &&
+		(l.maxTotal <= 0 || l.total < l.maxTotal)
+}
+
+// take accounts a new client to the node, lock must be held.
+func (l *streamLimiter) take(id proto.NodeID) {
+	if l.inflight == nil {
+		l.inflight = make(map[proto.NodeID]int)
+	}
+	l.inflight[id]++
+	l.total++
+}
+
+// release accounts a closed client to the node and grants the pending requests, lock must be
+// held.
+func (l *streamLimiter) release(id proto.NodeID) {
+	if l.inflight[id]--; l.inflight[id] <= 0 {
+		delete(l.inflight, id)
+	}
+	l.total--
+	l.dispatch()
+}
+
+// remove removes the pending request, lock must be held.
+func (l *streamLimiter) remove(w *streamWaiter) {
+	ws := l.waiters[w.node]
+	for i, v := range ws {
+		if v == w {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) > 0 {
+		l.waiters[w.node] = ws
+		return
+	}
+	delete(l.waiters, w.node)
+	for i, v := range l.queue {
+		if v == w.node {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// dispatch grants the pending requests, at most one request of each node per round, and the
+// granted nodes are moved behind the others, lock must be held.
+func (l *streamLimiter) dispatch() {
+	for granted := true; granted; {
+		granted = false
+		var waiting, served []proto.NodeID
+		for _, id := range l.queue {
+			ws := l.waiters[id]
+			if !l.available(id) {
+				waiting = append(waiting, id)
+				continue
+			}
+			w := ws[0]
+			w.granted = true
+			l.take(id)
+			close(w.ready)
+			granted = true
+			if ws = ws[1:]; len(ws) == 0 {
+				delete(l.waiters, id)
+				continue
+			}
+			l.waiters[id] = ws
+			served = append(served, id)
+		}
+		l.queue = append(waiting, served...)
+	}
+}
+
+// inflights returns the in-flight client count to the node.
+func (l *streamLimiter) inflights(id proto.NodeID) int {
+	l.Lock()
+	defer l.Unlock()
+	return l.inflight[id]
+}
+
+// SetLimits updates the in-flight client limits of the pool, 0 for unlimited.
+func (p *SessionPool) SetLimits(maxStreamsPerNode, maxStreams int) {
+	p.Lock()
+	defer p.Unlock()
+	p.cfg.MaxStreamsPerNode = maxStreamsPerNode
+	p.cfg.MaxStreams = maxStreams
+	p.limiter.setLimits(maxStreamsPerNode, maxStreams)
+}
+
+// limitedClient releases the limiter slot after the client is closed.
+type limitedClient struct {
+	rpc.Client
+	release func()
+}
+
+// Close closes the client and releases its limiter slot.
+func (c *limitedClient) Close() error {
+	defer c.release()
+	return c.Client.Close()
+}
+
+// limitedStream releases the limiter slot after the stream is closed.
+type limitedStream struct {
+	*mux.Stream
+	once    sync.Once
+	release func()
+}
+
+// Close closes the stream and releases its limiter slot.
+func (s *limitedStream) Close() error {
+	defer s.once.Do(s.release)
+	return s.Stream.Close()
+}
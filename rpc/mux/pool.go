@@ -17,38 +17,55 @@
 package mux
 
 import (
+	"context"
+	"io"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	mux "github.com/xtaci/smux"
 
-	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/naconn"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
 )
 
-// Session is the Session type of SessionPool.
+// Session is the Session type of SessionPool, it holds a single persistent multiplexed connection
+// to the target node, each client is served by a new stream of the connection.
 type Session struct {
+	lastActive int64 // unix nano of last stream opening, accessed atomically
+
 	sync.RWMutex
-	target proto.NodeID
-	sess   []*mux.Session
-	offset int
+	target   proto.NodeID
+	sess     *mux.Session
+	created  time.Time      // establish time of current connection
+	retired  []*mux.Session // connections exceeded max lifetime, closed after streams drained
+	draining bool           // refuses new streams if set
+
+	beforeRedial func() // called before the slow path takes the lock to re-establish connection
 }
 
 // SessionPool is the struct type of session pool.
 type SessionPool struct {
 	sync.RWMutex
 	sessions map[proto.NodeID]*Session
+
+	cfg      SessionPoolConfig
+	stopCh   chan struct{}
+	limiter  streamLimiter
+	draining bool // refuses new clients if set
 }
 
 var (
-	defaultPool = &SessionPool{
-		sessions: make(map[proto.NodeID]*Session),
-	}
+	defaultPool = NewSessionPool(DefaultSessionPoolConfig)
 )
 
+func init() {
+	rpc.RegisterPoolMetrics("mux", defaultPool)
+}
+
 // GetSessionPoolInstance return default SessionPool instance with rpc.DefaultDialer.
 func GetSessionPoolInstance() *SessionPool {
 	return defaultPool
@@ -59,83 +76,140 @@ func (s *Session) Close() error {
 	s.Lock()
 	defer s.Unlock()
 	var errmsgs []string
-	for _, s := range s.sess {
-		if err := s.Close(); err != nil {
+	for _, sess := range append(s.retired, s.sess) {
+		if sess == nil {
+			continue
+		}
+		if err := sess.Close(); err != nil {
 			errmsgs = append(errmsgs, err.Error())
 		}
 	}
-	s.sess = s.sess[:0]
+	s.sess = nil
+	s.retired = nil
 	if len(errmsgs) > 0 {
 		return errors.Wrapf(errors.New(strings.Join(errmsgs, ", ")), "close session %s", s.target)
 	}
 	return nil
 }
 
-// Get returns new connection from session.
+// Get opens a new stream on the persistent connection, the connection is re-established if it's broken.
 func (s *Session) Get() (conn rpc.Client, err error) {
+	return s.GetContext(context.Background())
+}
+
+// GetContext is like Get but re-establishing the connection is aborted if ctx is canceled or
+// expired.
+func (s *Session) GetContext(ctx context.Context) (conn rpc.Client, err error) {
+	var stream *mux.Stream
+	if stream, err = s.openStream(ctx); err != nil {
+		return
+	}
+	return rpc.NewClient(stream), nil
+}
+
+func (s *Session) openStream(ctx context.Context) (stream *mux.Stream, err error) {
+	// fast path: open stream on the established connection
+	s.RLock()
+	sess, draining := s.sess, s.draining
+	s.RUnlock()
+
+	if draining {
+		err = errors.Wrapf(ErrDraining, "open stream to %s", s.target)
+		return
+	}
+
+	if sess != nil && !sess.IsClosed() {
+		if stream, err = sess.OpenStream(); err == nil {
+			s.touch()
+			return
+		}
+	}
+
+	if s.beforeRedial != nil {
+		s.beforeRedial()
+	}
+
 	s.Lock()
 	defer s.Unlock()
-	s.offset++
-	s.offset %= conf.MaxRPCMuxPoolPhysicalConnection
 
-	var (
-		sess     *mux.Session
-		stream   *mux.Stream
-		sessions []*mux.Session
-	)
+	if s.draining {
+		err = errors.Wrapf(ErrDraining, "open stream to %s", s.target)
+		return
+	}
 
-	for {
-		if len(s.sess) <= s.offset {
-			// open new session
-			sess, err = s.newSession()
-			if err != nil {
-				return
-			}
-			s.sess = append(s.sess, sess)
-			s.offset = len(s.sess) - 1
-		} else {
-			sess = s.sess[s.offset]
+	// the connection may be evicted by reaper or session close before lock is taken
+	if s.sess == nil || s.sess == sess || s.sess.IsClosed() {
+		// invalidate broken connection
+		if s.sess != nil {
+			_ = s.sess.Close()
+			s.sess = nil
 		}
-
-		// open connection
-		stream, err = sess.OpenStream()
-		if err != nil {
-			// invalidate session
-			sessions = nil
-			sessions = append(sessions, s.sess[0:s.offset]...)
-			sessions = append(sessions, s.sess[s.offset+1:]...)
-			s.sess = sessions
-			continue
+		if s.sess, err = s.newSession(ctx); err != nil {
+			return
 		}
+		s.created = time.Now()
+	}
 
-		return rpc.NewClient(stream), nil
+	if stream, err = s.sess.OpenStream(); err != nil {
+		err = errors.Wrapf(err, "open stream to %s failed", s.target)
+		return
 	}
+
+	s.touch()
+
+	return
 }
 
 // Len returns physical connection count.
 func (s *Session) Len() int {
 	s.RLock()
 	defer s.RUnlock()
-	return len(s.sess)
+	if s.sess == nil || s.sess.IsClosed() {
+		return 0
+	}
+	return 1
+}
+
+// Streams returns the opened stream count.
+func (s *Session) Streams() int {
+	s.RLock()
+	defer s.RUnlock()
+	if s.sess == nil {
+		return 0
+	}
+	return s.sess.NumStreams()
 }
 
-func newSession(id proto.NodeID, isAnonymous bool) (sess *mux.Session, err error) {
+// newMuxConfig returns the multiplexing config with the configured heartbeat options.
+func newMuxConfig() *mux.Config {
+	t := naconn.ConnTuning()
+	cfg := mux.DefaultConfig()
+	cfg.KeepAliveInterval = t.HeartbeatInterval
+	cfg.KeepAliveTimeout = t.HeartbeatTimeout
+	return cfg
+}
+
+func newSession(ctx context.Context, id proto.NodeID, isAnonymous bool) (sess *mux.Session, err error) {
 	var conn net.Conn
-	if conn, err = rpc.DialEx(id, isAnonymous); err != nil {
+	startTime := time.Now()
+	conn, err = rpc.DialExContext(ctx, id, isAnonymous)
+	rpc.ObserveDial("mux", startTime, err)
+	if err != nil {
 		err = errors.Wrap(err, "dialing new session connection failed")
 		return
 	}
-	return mux.Client(conn, mux.DefaultConfig())
+	return mux.Client(conn, newMuxConfig())
 }
 
-func (s *Session) newSession() (sess *mux.Session, err error) {
-	return newSession(s.target, false)
+func (s *Session) newSession(ctx context.Context) (sess *mux.Session, err error) {
+	return newSession(ctx, s.target, false)
 }
 
 func (p *SessionPool) getSession(id proto.NodeID) (sess *Session, loaded bool) {
 	// NO Blocking operation in this function
 	p.Lock()
 	defer p.Unlock()
+	p.startReaper()
 	sess, exist := p.sessions[id]
 	if exist {
 		//log.WithField("node", id).Debug("load session for target node")
@@ -152,9 +226,50 @@ func (p *SessionPool) getSession(id proto.NodeID) (sess *Session, loaded bool) {
 
 // Get returns existing session to the node, if not exist try best to create one.
 func (p *SessionPool) Get(id proto.NodeID) (conn rpc.Client, err error) {
+	return p.GetContext(context.Background(), id)
+}
+
+// GetContext is like Get but a new session dialing is aborted if ctx is canceled or expired.
+func (p *SessionPool) GetContext(ctx context.Context, id proto.NodeID) (conn rpc.Client, err error) {
+	if p.isDraining() {
+		err = errors.Wrapf(ErrDraining, "get client to %s", id)
+		return
+	}
+	var release func()
+	if release, err = p.limiter.acquire(ctx, id); err != nil {
+		err = errors.Wrapf(err, "wait for stream to %s", id)
+		return
+	}
 	var sess *Session
 	sess, _ = p.getSession(id)
-	return sess.Get()
+	if conn, err = sess.GetContext(ctx); err != nil {
+		release()
+		return
+	}
+	return &limitedClient{Client: conn, release: release}, nil
+}
+
+// OpenStream opens a dedicated stream to the node for streaming RPC calls.
+func (p *SessionPool) OpenStream(ctx context.Context, id proto.NodeID) (stream io.ReadWriteCloser, err error) {
+	if p.isDraining() {
+		err = errors.Wrapf(ErrDraining, "open stream to %s", id)
+		return
+	}
+	var release func()
+	if release, err = p.limiter.acquire(ctx, id); err != nil {
+		err = errors.Wrapf(err, "wait for stream to %s", id)
+		return
+	}
+	var (
+		sess *Session
+		raw  *mux.Stream
+	)
+	sess, _ = p.getSession(id)
+	if raw, err = sess.openStream(ctx); err != nil {
+		release()
+		return
+	}
+	return &limitedStream{Stream: raw, release: release}, nil
 }
 
 // oneOffMuxConn wraps a mux.Session to implement net.Conn.
@@ -178,7 +293,7 @@ func (c *oneOffMuxConn) Close() error {
 // 	Wrap conn as stream with NewOneOffMuxConn
 // 	Call rpc.NewClient(stream) to get client.
 func NewOneOffMuxConn(conn net.Conn) (net.Conn, error) {
-	sess, err := mux.Client(conn, mux.DefaultConfig())
+	sess, err := mux.Client(conn, newMuxConfig())
 	if err != nil {
 		return nil, errors.Wrapf(err, "create session to %s", conn.RemoteAddr())
 	}
@@ -195,24 +310,46 @@ func NewOneOffMuxConn(conn net.Conn) (net.Conn, error) {
 // GetEx returns an one-off connection if it's anonymous, otherwise returns existing session
 // with Get.
 func (p *SessionPool) GetEx(id proto.NodeID, isAnonymous bool) (conn rpc.Client, err error) {
+	return p.GetExContext(context.Background(), id, isAnonymous)
+}
+
+// GetExContext is like GetEx but a new session dialing is aborted if ctx is canceled or expired.
+func (p *SessionPool) GetExContext(
+	ctx context.Context, id proto.NodeID, isAnonymous bool) (conn rpc.Client, err error,
+) {
 	if isAnonymous {
 		var (
-			sess   *mux.Session
-			stream *mux.Stream
+			release func()
+			sess    *mux.Session
+			stream  *mux.Stream
 		)
-		if sess, err = newSession(id, true); err != nil {
+		if p.isDraining() {
+			err = errors.Wrapf(ErrDraining, "get client to %s", id)
+			return
+		}
+		if release, err = p.limiter.acquire(ctx, id); err != nil {
+			err = errors.Wrapf(err, "wait for stream to %s", id)
+			return
+		}
+		if sess, err = newSession(ctx, id, true); err != nil {
+			release()
 			return
 		}
 		if stream, err = sess.OpenStream(); err != nil {
+			release()
+			_ = sess.Close()
 			err = errors.Wrapf(err, "open new session to %s failed", id)
 			return
 		}
-		return rpc.NewClient(&oneOffMuxConn{
-			sess:   sess,
-			Stream: stream,
-		}), nil
+		return &limitedClient{
+			Client: rpc.NewClient(&oneOffMuxConn{
+				sess:   sess,
+				Stream: stream,
+			}),
+			release: release,
+		}, nil
 	}
-	return p.Get(id)
+	return p.GetContext(ctx, id)
 }
 
 // Remove the node sessions in the pool.
@@ -231,6 +368,7 @@ func (p *SessionPool) Remove(id proto.NodeID) {
 func (p *SessionPool) Close() error {
 	p.Lock()
 	defer p.Unlock()
+	p.stopReaper()
 	var errmsgs []string
 	for _, s := range p.sessions {
 		if err := s.Close(); err != nil {
@@ -244,7 +382,7 @@ func (p *SessionPool) Close() error {
 	return nil
 }
 
-// Len returns the session counts in the pool.
+// Len returns the physical connection counts in the pool.
 func (p *SessionPool) Len() (total int) {
 	p.RLock()
 	defer p.RUnlock()
@@ -254,3 +392,26 @@ func (p *SessionPool) Len() (total int) {
 	}
 	return
 }
+
+// Sizes returns the physical connection count of each node in the pool.
+func (p *SessionPool) Sizes() (sizes map[proto.NodeID]int) {
+	p.RLock()
+	defer p.RUnlock()
+
+	sizes = make(map[proto.NodeID]int, len(p.sessions))
+	for id, s := range p.sessions {
+		sizes[id] = s.Len()
+	}
+	return
+}
+
+// Streams returns the opened stream counts in the pool.
+func (p *SessionPool) Streams() (total int) {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, s := range p.sessions {
+		total += s.Streams()
+	}
+	return
+}
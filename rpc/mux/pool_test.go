@@ -17,14 +17,18 @@
 package mux
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
 	"net"
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
-	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/utils"
@@ -32,7 +36,7 @@ import (
 )
 
 const (
-	concurrency = conf.MaxRPCMuxPoolPhysicalConnection + 1
+	concurrency = 16
 	packetCount = 100
 )
 
@@ -49,6 +53,8 @@ var FJ = filepath.Join
 func withTCPDialer() func() {
 	dial := rpc.Dial
 	dialEx := rpc.DialEx
+	dialContext := rpc.DialContext
+	dialExContext := rpc.DialExContext
 
 	rpc.Dial = func(remote proto.NodeID) (net.Conn, error) {
 		return net.Dial("tcp", string(remote))
@@ -58,10 +64,20 @@ func withTCPDialer() func() {
 		return net.Dial("tcp", string(remote))
 	}
 
+	rpc.DialContext = func(ctx context.Context, remote proto.NodeID) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", string(remote))
+	}
+
+	rpc.DialExContext = func(ctx context.Context, remote proto.NodeID, isAnonymous bool) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", string(remote))
+	}
+
 	// recover func
 	return func() {
 		rpc.Dial = dial
 		rpc.DialEx = dialEx
+		rpc.DialContext = dialContext
+		rpc.DialExContext = dialExContext
 	}
 }
 
@@ -140,7 +156,9 @@ func TestNewSessionPool(t *testing.T) {
 		}
 
 		wg.Wait()
-		So(p.Len(), ShouldEqual, conf.MaxRPCMuxPoolPhysicalConnection)
+		// all streams share single physical connection
+		So(p.Len(), ShouldEqual, 1)
+		So(p.Streams(), ShouldEqual, 0)
 
 		// setup server
 		l2, err := net.Listen("tcp", ":0")
@@ -153,7 +171,7 @@ func TestNewSessionPool(t *testing.T) {
 
 		_, err = p.Get(proto.NodeID(l2.Addr().String()))
 		So(err, ShouldBeNil)
-		So(p.Len(), ShouldEqual, conf.MaxRPCMuxPoolPhysicalConnection+1)
+		So(p.Len(), ShouldEqual, 2)
 
 		wg2 := &sync.WaitGroup{}
 		wg2.Add(concurrency)
@@ -175,10 +193,10 @@ func TestNewSessionPool(t *testing.T) {
 		}
 
 		wg2.Wait()
-		So(p.Len(), ShouldEqual, conf.MaxRPCMuxPoolPhysicalConnection*2)
+		So(p.Len(), ShouldEqual, 2)
 
 		p.Remove(proto.NodeID(l.Addr().String()))
-		So(p.Len(), ShouldEqual, conf.MaxRPCMuxPoolPhysicalConnection)
+		So(p.Len(), ShouldEqual, 1)
 
 		_ = p.Close()
 		So(p.Len(), ShouldEqual, 0)
@@ -189,3 +207,453 @@ func TestNewSessionPool(t *testing.T) {
 		So(GetSessionPoolInstance() == GetSessionPoolInstance(), ShouldBeTrue)
 	})
 }
+
+func TestSessionPool_Reap(t *testing.T) {
+	Convey("session pool connection checks", t, func(c C) {
+		log.SetLevel(log.FatalLevel)
+		defer withTCPDialer()()
+
+		l, err := net.Listen("tcp", ":0")
+		So(err, ShouldBeNil)
+		server, err := NewServerWithService(ServiceMap{"Test": NewTestService()})
+		So(err, ShouldBeNil)
+		server.SetListener(l)
+		go server.WithAcceptConnFunc(rpc.AcceptRawConn).Serve()
+		defer server.Stop()
+
+		target := proto.NodeID(l.Addr().String())
+
+		Convey("idle connection should be evicted", func() {
+			cfg := SessionPoolConfig{
+				IdleTimeout:  100 * time.Millisecond,
+				ProbeTimeout: time.Second,
+			}
+			p := NewSessionPool(cfg)
+			defer func() { _ = p.Close() }()
+
+			client, err := p.Get(target)
+			So(err, ShouldBeNil)
+			So(client.Call("Test.IncCounter", &TestReq{Step: 1}, &TestRep{}), ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+
+			// alive and not idle yet
+			p.Reap(cfg)
+			So(p.Len(), ShouldEqual, 1)
+
+			time.Sleep(200 * time.Millisecond)
+			p.Reap(cfg)
+			So(p.Len(), ShouldEqual, 0)
+
+			// re-established on demand
+			client, err = p.Get(target)
+			So(err, ShouldBeNil)
+			So(client.Call("Test.IncCounter", &TestReq{Step: 1}, &TestRep{}), ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+			So(p.Len(), ShouldEqual, 1)
+		})
+
+		Convey("expired connection should be retired after streams drained", func() {
+			cfg := SessionPoolConfig{
+				MaxLifetime: 100 * time.Millisecond,
+			}
+			p := NewSessionPool(cfg)
+			defer func() { _ = p.Close() }()
+
+			client, err := p.Get(target)
+			So(err, ShouldBeNil)
+
+			time.Sleep(200 * time.Millisecond)
+			p.Reap(cfg)
+			So(p.Len(), ShouldEqual, 0)
+
+			// in-flight stream is still usable
+			So(client.Call("Test.IncCounter", &TestReq{Step: 1}, &TestRep{}), ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+
+			client, err = p.Get(target)
+			So(err, ShouldBeNil)
+			So(client.Call("Test.IncCounter", &TestReq{Step: 1}, &TestRep{}), ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+			So(p.Len(), ShouldEqual, 1)
+		})
+
+		Convey("unresponsive connection should be evicted on probe", func() {
+			// accepts connection but never replies
+			silent, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer func() { _ = silent.Close() }()
+			go func() {
+				for {
+					conn, err := silent.Accept()
+					if err != nil {
+						return
+					}
+					defer func() { _ = conn.Close() }()
+				}
+			}()
+
+			cfg := SessionPoolConfig{
+				ProbeTimeout: 100 * time.Millisecond,
+			}
+			p := NewSessionPool(cfg)
+			defer func() { _ = p.Close() }()
+
+			client, err := p.Get(proto.NodeID(silent.Addr().String()))
+			So(err, ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+			So(p.Len(), ShouldEqual, 1)
+
+			p.Reap(cfg)
+			So(p.Len(), ShouldEqual, 0)
+		})
+
+		Convey("connection evicted before re-establishing should be handled", func() {
+			cfg := SessionPoolConfig{}
+			p := NewSessionPool(cfg)
+			defer func() { _ = p.Close() }()
+
+			client, err := p.Get(target)
+			So(err, ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+
+			p.RLock()
+			sess := p.sessions[target]
+			p.RUnlock()
+			So(sess, ShouldNotBeNil)
+
+			// broken connection is seen by fast path and evicted by reaper before slow path
+			sess.RLock()
+			So(sess.sess.Close(), ShouldBeNil)
+			sess.RUnlock()
+			sess.beforeRedial = func() { p.Reap(cfg) }
+
+			client, err = p.Get(target)
+			So(err, ShouldBeNil)
+			So(client.Call("Test.IncCounter", &TestReq{Step: 1}, &TestRep{}), ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+			So(p.Len(), ShouldEqual, 1)
+		})
+
+		Convey("background checks should be started with sessions", func() {
+			p := NewSessionPool(SessionPoolConfig{
+				IdleTimeout:   50 * time.Millisecond,
+				CheckInterval: 50 * time.Millisecond,
+			})
+			defer func() { _ = p.Close() }()
+
+			client, err := p.Get(target)
+			So(err, ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+
+			time.Sleep(300 * time.Millisecond)
+			So(p.Len(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSessionPool_GetContext(t *testing.T) {
+	Convey("session pool with context", t, func(c C) {
+		log.SetLevel(log.FatalLevel)
+		defer withTCPDialer()()
+
+		// accepts connection but never replies
+		silent, err := net.Listen("tcp", ":0")
+		So(err, ShouldBeNil)
+		defer func() { _ = silent.Close() }()
+		go func() {
+			for {
+				conn, err := silent.Accept()
+				if err != nil {
+					return
+				}
+				defer func() { _ = conn.Close() }()
+			}
+		}()
+		target := proto.NodeID(silent.Addr().String())
+
+		p := NewSessionPool(DefaultSessionPoolConfig)
+		defer func() { _ = p.Close() }()
+
+		Convey("canceled context should abort dialing", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := rpc.DialToNodeWithContext(ctx, p, target, false)
+			So(err, ShouldEqual, context.Canceled)
+			_, err = p.GetExContext(ctx, target, true)
+			So(err, ShouldNotBeNil)
+			So(p.Len(), ShouldEqual, 0)
+		})
+
+		Convey("session should abort establishing connection on context", func() {
+			// dialing hangs until aborted
+			dialExContext := rpc.DialExContext
+			defer func() { rpc.DialExContext = dialExContext }()
+			rpc.DialExContext = func(ctx context.Context, remote proto.NodeID, isAnonymous bool) (net.Conn, error) {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(10 * time.Second):
+					return nil, errors.New("dialing is not aborted")
+				}
+			}
+
+			sess, _ := p.getSession(target)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := sess.GetContext(ctx)
+			So(errors.Cause(err), ShouldResemble, context.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(sess.Len(), ShouldEqual, 0)
+
+			ctx, cancel = context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			_, err = sess.GetContext(ctx)
+			So(errors.Cause(err), ShouldEqual, context.Canceled)
+			So(sess.Len(), ShouldEqual, 0)
+		})
+
+		Convey("expired context should abort pending call", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := rpc.NewCallerWithPool(p).CallNodeWithContext(
+				ctx, target, "Test.IncCounter", &TestReq{Step: 1}, &TestRep{})
+			So(err, ShouldResemble, context.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(p.Streams(), ShouldEqual, 0)
+		})
+
+		Convey("expired context should abort pending persistent call", func() {
+			caller := rpc.NewPersistentCallerWithPool(p, target)
+			defer caller.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := caller.CallWithContext(ctx, "Test.IncCounter", &TestReq{Step: 1}, &TestRep{})
+			So(errors.Cause(err), ShouldResemble, context.DeadlineExceeded)
+			So(p.Streams(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSessionPool_Limits(t *testing.T) {
+	Convey("session pool with stream limits", t, func(c C) {
+		log.SetLevel(log.FatalLevel)
+		defer withTCPDialer()()
+
+		var targets []proto.NodeID
+		for i := 0; i < 2; i++ {
+			l, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer func() { _ = l.Close() }()
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					defer func() { _ = conn.Close() }()
+				}
+			}()
+			targets = append(targets, proto.NodeID(l.Addr().String()))
+		}
+
+		cfg := DefaultSessionPoolConfig
+		cfg.MaxStreamsPerNode = 2
+		cfg.MaxStreams = 3
+		p := NewSessionPool(cfg)
+		defer func() { _ = p.Close() }()
+
+		getWithTimeout := func(id proto.NodeID) (rpc.Client, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			return p.GetContext(ctx, id)
+		}
+
+		a1, err := p.Get(targets[0])
+		So(err, ShouldBeNil)
+		a2, err := p.Get(targets[0])
+		So(err, ShouldBeNil)
+		_, err = getWithTimeout(targets[0])
+		So(errors.Cause(err), ShouldResemble, context.DeadlineExceeded)
+		b1, err := p.GetEx(targets[1], true)
+		So(err, ShouldBeNil)
+		_, err = getWithTimeout(targets[1])
+		So(errors.Cause(err), ShouldResemble, context.DeadlineExceeded)
+		So(p.limiter.inflights(targets[0]), ShouldEqual, 2)
+		So(p.limiter.inflights(targets[1]), ShouldEqual, 1)
+
+		// a released slot of the whole pool goes to the node under its own limit
+		ch := make(chan rpc.Client)
+		go func() {
+			cli, err := p.Get(targets[1])
+			c.So(err, ShouldBeNil)
+			ch <- cli
+		}()
+		So(a1.Close(), ShouldBeNil)
+		b2 := <-ch
+		So(p.limiter.inflights(targets[0]), ShouldEqual, 1)
+		So(p.limiter.inflights(targets[1]), ShouldEqual, 2)
+
+		for _, cli := range []rpc.Client{a2, b1, b2} {
+			So(cli.Close(), ShouldBeNil)
+		}
+		So(p.limiter.inflights(targets[0]), ShouldEqual, 0)
+		So(p.limiter.inflights(targets[1]), ShouldEqual, 0)
+	})
+
+	Convey("stream limiter grants nodes in round-robin order", t, func(c C) {
+		l := &streamLimiter{}
+		l.setLimits(0, 1)
+		release, err := l.acquire(context.Background(), "hot")
+		So(err, ShouldBeNil)
+
+		order := make(chan proto.NodeID, 4)
+		var wg sync.WaitGroup
+		waiting := func() (n int) {
+			l.Lock()
+			defer l.Unlock()
+			for _, ws := range l.waiters {
+				n += len(ws)
+			}
+			return
+		}
+		for i, id := range []proto.NodeID{"hot", "hot", "hot", "cold"} {
+			wg.Add(1)
+			go func(id proto.NodeID) {
+				defer wg.Done()
+				release, err := l.acquire(context.Background(), id)
+				c.So(err, ShouldBeNil)
+				order <- id
+				release()
+			}(id)
+			// make the waiting order deterministic
+			for waiting() <= i {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		release()
+		wg.Wait()
+		close(order)
+		var got []proto.NodeID
+		for id := range order {
+			got = append(got, id)
+		}
+		So(got[:2], ShouldResemble, []proto.NodeID{"hot", "cold"})
+	})
+}
+
+type SleepService struct{}
+
+func (s *SleepService) Sleep(ms int64, ret *int64) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*ret = ms
+	return nil
+}
+
+func TestSessionPool_Drain(t *testing.T) {
+	Convey("session pool draining", t, func(c C) {
+		log.SetLevel(log.FatalLevel)
+		defer withTCPDialer()()
+
+		l, err := net.Listen("tcp", ":0")
+		So(err, ShouldBeNil)
+		server, err := NewServerWithService(ServiceMap{"Sleep": &SleepService{}})
+		So(err, ShouldBeNil)
+		server.SetListener(l)
+		go server.WithAcceptConnFunc(rpc.AcceptRawConn).Serve()
+		defer server.Stop()
+		target := proto.NodeID(l.Addr().String())
+
+		p := NewSessionPool(DefaultSessionPoolConfig)
+		defer func() { _ = p.Close() }()
+
+		call := func(ms int64) chan error {
+			ch := make(chan error, 1)
+			go func() {
+				var ret int64
+				ch <- rpc.NewCallerWithPool(p).CallNode(target, "Sleep.Sleep", ms, &ret)
+			}()
+			for p.Streams() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			return ch
+		}
+
+		Convey("outstanding RPCs should be finished before closing", func() {
+			errCh := call(200)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			err := p.Drain(ctx)
+			So(err, ShouldBeNil)
+			So(time.Since(start), ShouldBeGreaterThan, 100*time.Millisecond)
+			So(<-errCh, ShouldBeNil)
+			So(p.Len(), ShouldEqual, 0)
+
+			_, err = p.Get(target)
+			So(errors.Cause(err), ShouldEqual, ErrDraining)
+			_, err = p.GetEx(target, true)
+			So(errors.Cause(err), ShouldEqual, ErrDraining)
+		})
+
+		Convey("outstanding RPCs should be aborted after deadline", func() {
+			errCh := call(5000)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := p.Drain(ctx)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(<-errCh, ShouldNotBeNil)
+			So(p.Len(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSessionPool_OpenStream(t *testing.T) {
+	Convey("streaming call over session pool", t, func() {
+		log.SetLevel(log.FatalLevel)
+		defer withTCPDialer()()
+
+		l, err := net.Listen("tcp", ":0")
+		So(err, ShouldBeNil)
+		server, err := NewServerWithService(ServiceMap{"Sleep": &SleepService{}})
+		So(err, ShouldBeNil)
+		server.RegisterStreamHandler("Stream.Echo", func(
+			ctx context.Context, call *rpc.StreamCall, w io.Writer) (err error,
+		) {
+			var data []byte
+			if err = call.ReadArgs(&data); err != nil {
+				return
+			}
+			for i := 0; i < 4; i++ {
+				if _, err = w.Write(data); err != nil {
+					return
+				}
+			}
+			return
+		})
+		server.SetListener(l)
+		go server.WithAcceptConnFunc(rpc.AcceptRawConn).Serve()
+		defer server.Stop()
+		target := proto.NodeID(l.Addr().String())
+
+		p := NewSessionPool(DefaultSessionPoolConfig)
+		defer func() { _ = p.Close() }()
+		caller := rpc.NewCallerWithPool(p)
+
+		r, err := caller.CallStream(context.Background(), target, "Stream.Echo", []byte("data"))
+		So(err, ShouldBeNil)
+		So(p.Streams(), ShouldEqual, 1)
+		data, err := ioutil.ReadAll(r)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "datadatadatadata")
+		So(r.Close(), ShouldBeNil)
+
+		var ret int64
+		err = caller.CallNode(target, "Sleep.Sleep", int64(1), &ret)
+		So(err, ShouldBeNil)
+		So(ret, ShouldEqual, 1)
+	})
+}
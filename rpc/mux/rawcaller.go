@@ -90,7 +90,8 @@ func (c *RawCaller) Call(method string, args interface{}, reply interface{}) (er
 			err == io.ErrClosedPipe ||
 			err == nrpc.ErrShutdown ||
 			strings.Contains(strings.ToLower(err.Error()), "shut down") ||
-			strings.Contains(strings.ToLower(err.Error()), "broken pipe") {
+			strings.Contains(strings.ToLower(err.Error()), "broken pipe") ||
+			strings.Contains(strings.ToLower(err.Error()), "connection reset") {
 			// if got EOF, retry once
 			reconnectErr := c.resetClient()
 			if reconnectErr != nil {
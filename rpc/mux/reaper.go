@@ -0,0 +1,220 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mux
+
+import (
+	nrpc "net/rpc"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	mux "github.com/xtaci/smux"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+const (
+	// probeMethod is a non-existent rpc method, a server error reply proves the connection is alive.
+	probeMethod = "Probe.Ping"
+)
+
+// SessionPoolConfig defines the connection management config of SessionPool.
+type SessionPoolConfig struct {
+	// IdleTimeout closes connection without any stream for the duration, 0 for never.
+	IdleTimeout time.Duration
+	// MaxLifetime re-establishes connection after the duration since established, 0 for never.
+	MaxLifetime time.Duration
+	// CheckInterval defines the interval of connection checks, 0 to disable the checks.
+	CheckInterval time.Duration
+	// ProbeTimeout defines the timeout of liveness probe in checks, 0 to disable the probes.
+	ProbeTimeout time.Duration
+	// MaxStreamsPerNode limits the in-flight clients to each node, 0 for unlimited.
+	MaxStreamsPerNode int
+	// MaxStreams limits the in-flight clients to all nodes, 0 for unlimited. Pending clients of
+	// different nodes are served in round-robin order when the limits are reached.
+	MaxStreams int
+}
+
+var (
+	// DefaultSessionPoolConfig defines the default connection management config of SessionPool.
+	DefaultSessionPoolConfig = SessionPoolConfig{
+		IdleTimeout:   10 * time.Minute,
+		CheckInterval: 30 * time.Second,
+		ProbeTimeout:  5 * time.Second,
+	}
+)
+
+// NewSessionPool returns a new SessionPool with connection management config.
+func NewSessionPool(cfg SessionPoolConfig) (p *SessionPool) {
+	p = &SessionPool{
+		sessions: make(map[proto.NodeID]*Session),
+		cfg:      cfg,
+	}
+	p.limiter.setLimits(cfg.MaxStreamsPerNode, cfg.MaxStreams)
+	return
+}
+
+// SetConfig updates the connection management config of the pool.
+func (p *SessionPool) SetConfig(cfg SessionPoolConfig) {
+	p.Lock()
+	defer p.Unlock()
+	p.stopReaper()
+	p.cfg = cfg
+	p.limiter.setLimits(cfg.MaxStreamsPerNode, cfg.MaxStreams)
+	if len(p.sessions) > 0 {
+		p.startReaper()
+	}
+}
+
+// startReaper starts connection checks in background, pool lock must be held by the caller.
+func (p *SessionPool) startReaper() {
+	if p.stopCh != nil || p.cfg.CheckInterval <= 0 {
+		return
+	}
+
+	p.stopCh = make(chan struct{})
+	go p.reapLoop(p.cfg, p.stopCh)
+}
+
+// stopReaper stops the background connection checks, pool lock must be held by the caller.
+func (p *SessionPool) stopReaper() {
+	if p.stopCh != nil {
+		close(p.stopCh)
+		p.stopCh = nil
+	}
+}
+
+func (p *SessionPool) reapLoop(cfg SessionPoolConfig, stopCh chan struct{}) {
+	ticker := time.NewTicker(cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			p.Reap(cfg)
+		}
+	}
+}
+
+// Reap checks all connections in the pool once, connections which are idle, expired or not responding
+// to liveness probe are evicted.
+func (p *SessionPool) Reap(cfg SessionPoolConfig) {
+	p.RLock()
+	sessions := make([]*Session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.RUnlock()
+
+	now := time.Now()
+	for _, s := range sessions {
+		s.reap(cfg, now)
+	}
+}
+
+func (s *Session) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+func (s *Session) reap(cfg SessionPoolConfig, now time.Time) {
+	s.Lock()
+
+	// close retired connections after streams drained
+	var retired []*mux.Session
+	for _, sess := range s.retired {
+		if sess.IsClosed() || sess.NumStreams() == 0 {
+			_ = sess.Close()
+			continue
+		}
+		retired = append(retired, sess)
+	}
+	s.retired = retired
+
+	sess := s.sess
+	switch {
+	case sess == nil:
+	case sess.IsClosed():
+		log.WithField("target", s.target).Debug("evict closed connection")
+		s.sess = nil
+	case sess.NumStreams() > 0:
+		s.touch()
+		if cfg.MaxLifetime > 0 && now.Sub(s.created) > cfg.MaxLifetime {
+			// retire connection, new streams are served by new connection
+			log.WithField("target", s.target).Debug("retire expired connection")
+			s.retired = append(s.retired, sess)
+			s.sess = nil
+		}
+	case cfg.MaxLifetime > 0 && now.Sub(s.created) > cfg.MaxLifetime:
+		log.WithField("target", s.target).Debug("evict expired connection")
+		_ = sess.Close()
+		s.sess = nil
+	case cfg.IdleTimeout > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastActive))) > cfg.IdleTimeout:
+		log.WithField("target", s.target).Debug("evict idle connection")
+		_ = sess.Close()
+		s.sess = nil
+	}
+
+	sess = s.sess
+	s.Unlock()
+
+	if sess == nil || cfg.ProbeTimeout <= 0 {
+		return
+	}
+
+	if err := probe(sess, cfg.ProbeTimeout); err != nil {
+		log.WithField("target", s.target).WithError(err).Warning("evict connection failed on liveness probe")
+
+		s.Lock()
+		if s.sess == sess {
+			s.sess = nil
+		}
+		s.Unlock()
+
+		_ = sess.Close()
+	}
+}
+
+// probe checks the liveness of connection by calling a non-existent method,
+// any reply from the remote rpc server proves the connection is alive.
+func probe(sess *mux.Session, timeout time.Duration) (err error) {
+	stream, err := sess.OpenStream()
+	if err != nil {
+		err = errors.Wrap(err, "open probe stream failed")
+		return
+	}
+
+	client := rpc.NewClient(stream)
+	defer func() { _ = client.Close() }()
+
+	call := client.Go(probeMethod, nil, nil, make(chan *nrpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		if _, ok := call.Error.(nrpc.ServerError); ok || call.Error == nil {
+			return nil
+		}
+		err = errors.Wrap(call.Error, "probe call failed")
+	case <-time.After(timeout):
+		err = errors.Errorf("probe timeout after %v", timeout)
+	}
+
+	return
+}
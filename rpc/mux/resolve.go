@@ -17,6 +17,7 @@
 package mux
 
 import (
+	"context"
 	"math/rand"
 	"sync"
 	"time"
@@ -27,6 +28,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/naconn"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
@@ -73,6 +75,24 @@ func (r *Resolver) Resolve(id *proto.RawNodeID) (string, error) {
 	return GetNodeAddr(id)
 }
 
+// ResolveAddrs implements naconn.MultiAddrResolver, it returns the address resolved by Resolve
+// followed by the other advertised addresses of the target node known to local KMS.
+func (r *Resolver) ResolveAddrs(id *proto.RawNodeID) (addrs []string, err error) {
+	var addr string
+	if addr, err = r.Resolve(id); err != nil {
+		return
+	}
+	addrs = []string{addr}
+	if r.direct {
+		return
+	}
+	if node, err := kms.GetNodeInfo(proto.NodeID(id.String())); err == nil {
+		node.Addr = addr
+		addrs = node.Addresses()
+	}
+	return
+}
+
 // ResolveEx implements the node ID resolver extended method using the BP network
 // with mux-RPC protocol.
 func (r *Resolver) ResolveEx(id *proto.RawNodeID) (*proto.Node, error) {
@@ -90,7 +110,7 @@ func GetNodeAddr(id *proto.RawNodeID) (addr string, err error) {
 		//log.WithField("target", id.String()).WithError(err).Debug("get node addr from cache failed")
 		if err == route.ErrUnknownNodeID {
 			var node *proto.Node
-			node, err = FindNodeInBP(id)
+			node, err = findNode(id)
 			if err != nil {
 				return
 			}
@@ -107,7 +127,7 @@ func GetNodeInfo(id *proto.RawNodeID) (nodeInfo *proto.Node, err error) {
 	if err != nil {
 		//log.WithField("target", id.String()).WithError(err).Info("get node info from KMS failed")
 		if errors.Cause(err) == kms.ErrKeyNotFound {
-			nodeInfo, err = FindNodeInBP(id)
+			nodeInfo, err = findNode(id)
 			if err != nil {
 				return
 			}
@@ -124,6 +144,24 @@ func GetNodeInfo(id *proto.RawNodeID) (nodeInfo *proto.Node, err error) {
 	return
 }
 
+// findNode finds node in block producer dht service, and falls back to the node sources
+// registered in route.
+func findNode(id *proto.RawNodeID) (node *proto.Node, err error) {
+	if node, err = FindNodeInBP(id); err == nil {
+		return
+	}
+	var srcErr error
+	if node, srcErr = route.FindNodeInSources(id); srcErr != nil {
+		if errors.Cause(srcErr) != route.ErrUnknownNodeID {
+			log.WithField("target", id.String()).WithError(srcErr).Warning(
+				"find node in node sources failed")
+		}
+		return
+	}
+	err = nil
+	return
+}
+
 // FindNodeInBP find node in block producer dht service.
 func FindNodeInBP(id *proto.RawNodeID) (node *proto.Node, err error) {
 	bps := route.GetBPs()
@@ -247,7 +285,42 @@ func RequestBP(method string, req interface{}, resp interface{}) (err error) {
 	if bp, err = GetCurrentBP(); err != nil {
 		return err
 	}
-	return NewCaller().CallNode(bp, method, req, resp)
+	if err = NewCaller().CallNode(bp, method, req, resp); rpc.IsRetryableError(err) {
+		// Reset current block producer to reconnect to another one on next request
+		resetCurrentBP(bp)
+	}
+	return
+}
+
+// RequestBPWithRetry sends idempotent request to main chain with context and retries on
+// transient errors according to policy, the retries fail over to the other block producers.
+func RequestBPWithRetry(
+	ctx context.Context, policy rpc.RetryPolicy, method string, req interface{}, resp interface{},
+) (err error) {
+	var bp proto.NodeID
+	if bp, err = GetCurrentBP(); err != nil {
+		return
+	}
+	var nodes = []proto.NodeID{bp}
+	for _, v := range route.GetBPs() {
+		if v != bp {
+			nodes = append(nodes, v)
+		}
+	}
+	if err = NewCaller().CallNodesWithRetry(ctx, policy, nodes, method, req, resp); rpc.IsRetryableError(err) {
+		// Reset current block producer to reconnect to another one on next request
+		resetCurrentBP(bp)
+	}
+	return
+}
+
+// resetCurrentBP resets current node chief block producer if it's still bp.
+func resetCurrentBP(bp proto.NodeID) {
+	currentBPLock.Lock()
+	defer currentBPLock.Unlock()
+	if currentBP == bp {
+		currentBP = ""
+	}
 }
 
 // RegisterNodeToBP registers the current node to bp network.
@@ -34,7 +34,7 @@ import (
 func ServeMux(
 	ctx context.Context, server *nrpc.Server, rawStream io.ReadWriteCloser, remote *proto.RawNodeID,
 ) {
-	sess, err := mux.Server(rawStream, mux.DefaultConfig())
+	sess, err := mux.Server(rawStream, newMuxConfig())
 	if err != nil {
 		err = errors.Wrap(err, "create mux server failed")
 		return
@@ -57,13 +57,30 @@ sessionLoop:
 				}
 				break sessionLoop
 			}
-			ctx, cancelFunc := context.WithCancel(context.Background())
+			streamCtx, cancelFunc := context.WithCancel(ctx)
 			go func() {
 				<-muxConn.GetDieCh()
 				cancelFunc()
 			}()
-			nodeAwareCodec := rpc.NewNodeAwareServerCodec(ctx, utils.GetMsgPackServerCodec(muxConn), remote)
-			go server.ServeCodec(nodeAwareCodec)
+			go serveStream(ctx, streamCtx, server, muxConn, remote)
 		}
 	}
 }
+
+// serveStream serves a single multiplexed stream, which carries either normal RPC requests or a
+// streaming RPC call.
+func serveStream(
+	ctx, streamCtx context.Context, server *nrpc.Server, stream io.ReadWriteCloser, remote *proto.RawNodeID,
+) {
+	stream, isStreamCall, err := rpc.AcceptStreamCall(stream)
+	if err != nil {
+		_ = stream.Close()
+		return
+	}
+	if isStreamCall {
+		rpc.ServeStreamCall(ctx, stream, remote)
+		return
+	}
+	nodeAwareCodec := rpc.NewNodeAwareServerCodec(streamCtx, utils.GetMsgPackServerCodec(stream), remote)
+	server.ServeCodec(nodeAwareCodec)
+}
@@ -17,6 +17,7 @@
 package mux
 
 import (
+	"context"
 	"net"
 	"sync/atomic"
 	"testing"
@@ -48,7 +49,7 @@ func (p *nilSessionPool) GetEx(id proto.NodeID, isAnonymous bool) (conn rpc.Clie
 		sess   *mux.Session
 		stream *mux.Stream
 	)
-	if sess, err = newSession(id, isAnonymous); err != nil {
+	if sess, err = newSession(context.Background(), id, isAnonymous); err != nil {
 		return
 	}
 	if stream, err = sess.OpenStream(); err != nil {
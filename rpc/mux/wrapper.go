@@ -64,7 +64,7 @@ type Caller struct {
 // NewCaller returns a new RPCCaller.
 func NewCaller() *Caller {
 	return &Caller{
-		Caller: rpc.NewCallerWithPool(defaultPool),
+		Caller: rpc.NewCallerWithPool(rpc.PoolForTransport(defaultPool)),
 	}
 }
 
@@ -78,7 +78,7 @@ type PersistentCaller struct {
 //  ETLS connection. It should not be used by any other RPC except DHT.Ping.
 func NewPersistentCaller(target proto.NodeID) *PersistentCaller {
 	return &PersistentCaller{
-		PersistentCaller: rpc.NewPersistentCallerWithPool(defaultPool, target),
+		PersistentCaller: rpc.NewPersistentCallerWithPool(rpc.PoolForTransport(defaultPool), target),
 	}
 }
 
@@ -17,6 +17,7 @@
 package rpc
 
 import (
+	"context"
 	"io"
 	"net/rpc"
 	"strings"
@@ -48,11 +49,11 @@ func NewPersistentCallerWithPool(pool NOClientPool, target proto.NodeID) *Persis
 	}
 }
 
-func (c *PersistentCaller) initClient(isAnonymous bool) (err error) {
+func (c *PersistentCaller) initClient(ctx context.Context, isAnonymous bool) (err error) {
 	c.Lock()
 	defer c.Unlock()
 	if c.client == nil {
-		c.client, err = DialToNodeWithPool(c.pool, c.TargetID, isAnonymous)
+		c.client, err = DialToNodeWithContext(ctx, c.pool, c.TargetID, isAnonymous)
 		if err != nil {
 			err = errors.Wrap(err, "dial to node failed")
 			return
@@ -64,28 +65,65 @@ func (c *PersistentCaller) initClient(isAnonymous bool) (err error) {
 
 // Call invokes the named function, waits for it to complete, and returns its error status.
 func (c *PersistentCaller) Call(method string, args interface{}, reply interface{}) (err error) {
+	return c.CallWithContext(context.Background(), method, args, reply)
+}
+
+// CallWithContext invokes the named function, waits for it to complete or ctx to be done, and
+// returns its error status.
+//
+// If ctx is done before the call completes, the client is reset to abort the pending call.
+func (c *PersistentCaller) CallWithContext(
+	ctx context.Context, method string, args interface{}, reply interface{}) (err error,
+) {
 	startTime := time.Now()
 	defer func() {
 		recordRPCCost(startTime, method, err)
 	}()
 
+	ctx, span := startClientSpan(ctx, c.TargetID, method, args)
+	defer func() { endSpan(span, err) }()
+
+	intercepted, err := beginClientCall(ctx, c.TargetID, method, args)
+	defer func() { intercepted.end(ctx, reply, err) }()
+	if err != nil {
+		return
+	}
+
 	isAnonymous := (method == route.DHTPing.String())
-	err = c.initClient(isAnonymous)
+	err = c.initClient(ctx, isAnonymous)
 	if err != nil {
 		err = errors.Wrap(err, "init PersistentCaller client failed")
 		return
 	}
-	err = c.client.Call(method, args, reply)
+
+	c.Lock()
+	client := c.client
+	c.Unlock()
+	if client == nil {
+		err = errors.Wrapf(rpc.ErrShutdown, "call %s failed", method)
+		return
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		c.resetClient(client)
+		err = errors.Wrapf(ctx.Err(), "call %s failed", method)
+		return
+	case <-call.Done:
+		err = call.Error
+	}
 	if err != nil {
 		if err == io.EOF ||
 			err == io.ErrUnexpectedEOF ||
 			err == io.ErrClosedPipe ||
 			err == rpc.ErrShutdown ||
 			strings.Contains(strings.ToLower(err.Error()), "shut down") ||
-			strings.Contains(strings.ToLower(err.Error()), "broken pipe") {
+			strings.Contains(strings.ToLower(err.Error()), "broken pipe") ||
+			strings.Contains(strings.ToLower(err.Error()), "connection reset") {
 			// if got EOF, retry once
 			_ = c.ResetClient()
-			reconnectErr := c.initClient(isAnonymous)
+			reconnectErr := c.initClient(ctx, isAnonymous)
 			if reconnectErr != nil {
 				err = errors.Wrap(reconnectErr, "reconnect failed")
 			}
@@ -107,6 +145,16 @@ func (c *PersistentCaller) ResetClient() (err error) {
 	return
 }
 
+// resetClient resets client only if it's still the current one.
+func (c *PersistentCaller) resetClient(client Client) {
+	c.Lock()
+	defer c.Unlock()
+	if c.client == client {
+		_ = c.client.Close()
+		c.client = nil
+	}
+}
+
 // Close closes the stream and RPC client.
 func (c *PersistentCaller) Close() {
 	c.Lock()
@@ -17,9 +17,12 @@
 package rpc
 
 import (
+	"context"
+	"io"
 	"net/rpc"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -112,13 +115,13 @@ func (l *freelist) getFree() (cli *rpc.Client, ok bool) {
 	return
 }
 
-func (l *freelist) get() (cli Client, err error) {
+func (l *freelist) get(ctx context.Context) (cli Client, err error) {
 	var (
 		raw *rpc.Client
 		ok  bool
 	)
 	if raw, ok = l.getFree(); !ok {
-		if raw, err = l.newClient(); err != nil {
+		if raw, err = l.newClient(ctx); err != nil {
 			return
 		}
 	}
@@ -135,8 +138,10 @@ func (l *freelist) len() int {
 	return len(l.freeCh)
 }
 
-func (l *freelist) newClient() (*rpc.Client, error) {
-	conn, err := Dial(l.target)
+func (l *freelist) newClient(ctx context.Context) (*rpc.Client, error) {
+	startTime := time.Now()
+	conn, err := DialContext(ctx, l.target)
+	ObserveDial("tcp", startTime, err)
 	if err != nil {
 		return nil, errors.Wrap(err, "dialing new connection failed")
 	}
@@ -164,21 +169,47 @@ func (p *ClientPool) loadFreeList(id proto.NodeID) (list *freelist, ok bool) {
 
 // Get returns existing freelist to the node, if not exist try best to create one.
 func (p *ClientPool) Get(id proto.NodeID) (cli Client, err error) {
+	return p.GetContext(context.Background(), id)
+}
+
+// GetContext is like Get but a new connection dialing is aborted if ctx is canceled or expired.
+func (p *ClientPool) GetContext(ctx context.Context, id proto.NodeID) (cli Client, err error) {
 	list, _ := p.loadFreeList(id)
-	return list.get()
+	return list.get(ctx)
 }
 
 // GetEx returns a client with an one-off connection if it's anonymous,
 // otherwise returns existing freelist with Get.
 func (p *ClientPool) GetEx(id proto.NodeID, isAnonymous bool) (cli Client, err error) {
+	return p.GetExContext(context.Background(), id, isAnonymous)
+}
+
+// GetExContext is like GetEx but a new connection dialing is aborted if ctx is canceled or
+// expired.
+func (p *ClientPool) GetExContext(
+	ctx context.Context, id proto.NodeID, isAnonymous bool) (cli Client, err error,
+) {
 	if isAnonymous {
-		conn, err := DialEx(id, true)
+		startTime := time.Now()
+		conn, err := DialExContext(ctx, id, true)
+		ObserveDial("tcp", startTime, err)
 		if err != nil {
 			return nil, err
 		}
 		return NewClient(conn), nil
 	}
-	return p.Get(id)
+	return p.GetContext(ctx, id)
+}
+
+// OpenStream dials a dedicated connection to the node for streaming RPC calls.
+func (p *ClientPool) OpenStream(ctx context.Context, id proto.NodeID) (io.ReadWriteCloser, error) {
+	startTime := time.Now()
+	conn, err := DialContext(ctx, id)
+	ObserveDial("tcp", startTime, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "dialing new connection failed")
+	}
+	return conn, nil
 }
 
 // Remove the node freelist in the pool.
@@ -215,6 +246,16 @@ func (p *ClientPool) Len() (total int) {
 	return
 }
 
+// Sizes returns the idle connection count of each node in the pool.
+func (p *ClientPool) Sizes() (sizes map[proto.NodeID]int) {
+	sizes = make(map[proto.NodeID]int)
+	p.nodeFreeLists.Range(func(k, v interface{}) bool {
+		sizes[k.(proto.NodeID)] = v.(*freelist).len()
+		return true
+	})
+	return
+}
+
 var (
 	defaultPool = &ClientPool{}
 )
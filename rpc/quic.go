@@ -0,0 +1,358 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/naconn"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// QUICNextProto is the ALPN protocol name of node RPC over QUIC.
+const QUICNextProto = "cql-rpc"
+
+// quicConfig is the QUIC transport config of node RPC.
+var quicConfig = &quic.Config{
+	MaxIdleTimeout:     time.Minute,
+	KeepAlivePeriod:    15 * time.Second,
+	MaxIncomingStreams: 1 << 16,
+}
+
+// IsQUICTransport reports whether QUIC is configured as the node RPC transport.
+func IsQUICTransport() bool {
+	return conf.GConf != nil && strings.EqualFold(conf.GConf.Transport, conf.TransportQUIC)
+}
+
+// PoolForTransport returns the QUIC pool if QUIC transport is configured, otherwise returns pool.
+func PoolForTransport(pool NOClientPool) NOClientPool {
+	if IsQUICTransport() {
+		return defaultQUICPool
+	}
+	return pool
+}
+
+// quicStreamConn wraps a QUIC stream as net.Conn.
+type quicStreamConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicStreamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// Close closes both directions of the stream.
+func (c *quicStreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+// oneOffQUICConn closes the underlying QUIC connection with the stream.
+type oneOffQUICConn struct {
+	net.Conn
+	conn quic.Connection
+}
+
+func (c *oneOffQUICConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.conn.CloseWithError(0, "")
+	return err
+}
+
+// newQUICServerTLSConfig returns a TLS config with a self-signed certificate. QUIC requires TLS,
+// but the peers are authenticated by the ETLS handshake carried over the first stream.
+func newQUICServerTLSConfig() (cfg *tls.Config, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: QUICNextProto},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+	cfg = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{QUICNextProto},
+	}
+	return
+}
+
+func newQUICClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true, // server is authenticated by ETLS key confirmation
+		NextProtos:         []string{QUICNextProto},
+	}
+}
+
+// ListenQUIC listens QUIC connections on the UDP address for node RPC.
+func ListenQUIC(addr string) (l *quic.Listener, err error) {
+	tlsConf, err := newQUICServerTLSConfig()
+	if err != nil {
+		err = errors.Wrap(err, "create QUIC tls config failed")
+		return
+	}
+	if l, err = quic.ListenAddr(addr, tlsConf, quicConfig); err != nil {
+		err = errors.Wrap(err, "create QUIC listener failed")
+		return
+	}
+	return
+}
+
+// SetQUICListener sets the QUIC listener served alongside the TCP listener by func Serve.
+func (s *Server) SetQUICListener(l *quic.Listener) {
+	s.QUICListener = l
+}
+
+// serveQUIC is the QUIC service loop.
+func (s *Server) serveQUIC() {
+	for {
+		conn, err := s.QUICListener.Accept(s.ctx)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.WithError(err).Error("accept QUIC connection failed")
+			}
+			return
+		}
+		go s.serveQUICConn(conn)
+	}
+}
+
+// serveQUICConn serves a QUIC connection: the first stream carries the ETLS handshake and is
+// served as a normal RPC stream, each of the following streams carries a single RPC client
+// encrypted with the shared key.
+func (s *Server) serveQUICConn(conn quic.Connection) {
+	le := log.WithField("remote_addr", conn.RemoteAddr())
+	defer func() { _ = conn.CloseWithError(0, "") }()
+	stream, err := conn.AcceptStream(s.ctx)
+	if err != nil {
+		le.WithError(err).Debug("accept QUIC control stream failed")
+		return
+	}
+	counted := newCountingConn(&quicStreamConn{Stream: stream, conn: conn}, nil)
+	control, err := naconn.Accept(counted)
+	if err != nil {
+		le.WithError(err).Error("failed to accept NAConn over QUIC")
+		_ = stream.Close()
+		return
+	}
+	remote := control.Remote()
+	s.bandwidth.attach(counted, remote.ToNodeID())
+	counter := s.bandwidth.counter(remote.ToNodeID())
+	go ServeDirect(s.ctx, s.rpcServer, control, &remote)
+	for {
+		stream, err := conn.AcceptStream(s.ctx)
+		if err != nil {
+			return
+		}
+		go ServeDirect(s.ctx, s.rpcServer, control.Derive(
+			newCountingConn(&quicStreamConn{Stream: stream, conn: conn}, counter)), &remote)
+	}
+}
+
+// quicSession is the QUIC connection to a node.
+type quicSession struct {
+	sync.Mutex
+	target  proto.NodeID
+	conn    quic.Connection
+	control *naconn.NAConn
+}
+
+func dialQUIC(
+	ctx context.Context, id proto.NodeID, isAnonymous bool) (conn quic.Connection, control *naconn.NAConn, err error,
+) {
+	startTime := time.Now()
+	defer func() { ObserveDial("quic", startTime, err) }()
+	addrs, err := naconn.ResolveAddrs(id)
+	if err != nil {
+		return
+	}
+	// try the advertised addresses in order
+	var addr string
+	for _, addr = range addrs {
+		if conn, err = quic.DialAddr(ctx, addr, newQUICClientTLSConfig(), quicConfig); err == nil {
+			break
+		}
+		err = errors.Wrapf(err, "dial QUIC to node %s failed", addr)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	if err != nil {
+		return
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		err = errors.Wrapf(err, "open QUIC control stream to %s failed", addr)
+		return
+	}
+	if control, err = naconn.NewClientConn(
+		ctx, &quicStreamConn{Stream: stream, conn: conn}, id, isAnonymous,
+	); err != nil {
+		_ = conn.CloseWithError(0, "")
+		return
+	}
+	return
+}
+
+func (s *quicSession) get(ctx context.Context) (cli Client, err error) {
+	var stream io.ReadWriteCloser
+	if stream, err = s.openStream(ctx); err != nil {
+		return
+	}
+	return NewClient(stream), nil
+}
+
+func (s *quicSession) openStream(ctx context.Context) (_ io.ReadWriteCloser, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.conn == nil || s.conn.Context().Err() != nil {
+		if s.conn, s.control, err = dialQUIC(ctx, s.target, false); err != nil {
+			s.conn, s.control = nil, nil
+			return
+		}
+	}
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		err = errors.Wrapf(err, "open QUIC stream to %s failed", s.target)
+		return
+	}
+	return s.control.Derive(&quicStreamConn{Stream: stream, conn: s.conn}), nil
+}
+
+func (s *quicSession) close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.CloseWithError(0, "")
+	s.conn, s.control = nil, nil
+	return err
+}
+
+// QUICPool is the node-oriented client pool over QUIC transport, it keeps a single QUIC
+// connection to each node and opens a new stream for each client.
+type QUICPool struct {
+	sessions sync.Map // proto.NodeID -> *quicSession
+}
+
+var defaultQUICPool = &QUICPool{}
+
+// GetQUICPoolInstance returns the default QUICPool instance.
+func GetQUICPoolInstance() *QUICPool {
+	return defaultQUICPool
+}
+
+// Get returns a client on a new stream to the node.
+func (p *QUICPool) Get(id proto.NodeID) (Client, error) {
+	return p.GetContext(context.Background(), id)
+}
+
+// GetEx returns a client with an one-off connection if it's anonymous, otherwise returns a
+// client on a new stream with Get.
+func (p *QUICPool) GetEx(id proto.NodeID, isAnonymous bool) (Client, error) {
+	return p.GetExContext(context.Background(), id, isAnonymous)
+}
+
+// GetContext is like Get but connecting is aborted if ctx is canceled or expired.
+func (p *QUICPool) GetContext(ctx context.Context, id proto.NodeID) (Client, error) {
+	v, _ := p.sessions.LoadOrStore(id, &quicSession{target: id})
+	return v.(*quicSession).get(ctx)
+}
+
+// GetExContext is like GetEx but connecting is aborted if ctx is canceled or expired.
+func (p *QUICPool) GetExContext(ctx context.Context, id proto.NodeID, isAnonymous bool) (Client, error) {
+	if isAnonymous {
+		conn, control, err := dialQUIC(ctx, id, true)
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(&oneOffQUICConn{Conn: control, conn: conn}), nil
+	}
+	return p.GetContext(ctx, id)
+}
+
+// OpenStream opens a dedicated stream to the node for streaming RPC calls.
+func (p *QUICPool) OpenStream(ctx context.Context, id proto.NodeID) (io.ReadWriteCloser, error) {
+	v, _ := p.sessions.LoadOrStore(id, &quicSession{target: id})
+	return v.(*quicSession).openStream(ctx)
+}
+
+// Remove closes the connection to the node and removes it from the pool.
+func (p *QUICPool) Remove(id proto.NodeID) {
+	if v, ok := p.sessions.Load(id); ok {
+		_ = v.(*quicSession).close()
+		p.sessions.Delete(id)
+	}
+}
+
+// Sizes returns the QUIC connection count of each node in the pool.
+func (p *QUICPool) Sizes() (sizes map[proto.NodeID]int) {
+	sizes = make(map[proto.NodeID]int)
+	p.sessions.Range(func(k, v interface{}) bool {
+		s := v.(*quicSession)
+		s.Lock()
+		if s.conn != nil && s.conn.Context().Err() == nil {
+			sizes[k.(proto.NodeID)] = 1
+		} else {
+			sizes[k.(proto.NodeID)] = 0
+		}
+		s.Unlock()
+		return true
+	})
+	return
+}
+
+// Close closes all connections in the pool.
+func (p *QUICPool) Close() error {
+	var errmsgs []string
+	p.sessions.Range(func(k, v interface{}) bool {
+		if err := v.(*quicSession).close(); err != nil {
+			errmsgs = append(errmsgs, err.Error())
+		}
+		p.sessions.Delete(k)
+		return true
+	})
+	if len(errmsgs) > 0 {
+		return errors.Wrap(errors.New(strings.Join(errmsgs, ", ")), "close QUIC pool")
+	}
+	return nil
+}
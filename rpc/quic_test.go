@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+func TestQUICTransport(t *testing.T) {
+	Convey("Setup a single server with QUIC transport", t, func(c C) {
+		transport := conf.GConf.Transport
+		conf.GConf.Transport = conf.TransportQUIC
+		defer func() { conf.GConf.Transport = transport }()
+
+		nodes, stop, err := setupEnvironment(1, AcceptNAConn)
+		So(err, ShouldBeNil)
+		defer stop()
+
+		target := nodes[0].ID
+		pool := &QUICPool{}
+		defer func() { _ = pool.Close() }()
+		So(PoolForTransport(&ClientPool{}), ShouldEqual, defaultQUICPool)
+
+		Convey("Test anonymous one-off connection", func() {
+			cli, err := pool.GetEx(target, true)
+			So(err, ShouldBeNil)
+			var resp AddResp
+			err = cli.Call("Count.Add", &AddReq{Delta: 1}, &resp)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not permitted")
+			So(cli.Close(), ShouldBeNil)
+
+			quota := conf.GConf.AnonymousQuota
+			conf.GConf.AnonymousQuota = &conf.AnonymousQuota{AllowedMethods: []string{"Count.Add"}}
+			defer func() { conf.GConf.AnonymousQuota = quota }()
+			cli, err = pool.GetEx(target, true)
+			So(err, ShouldBeNil)
+			err = cli.Call("Count.Add", &AddReq{Delta: 1}, &resp)
+			So(err, ShouldBeNil)
+			So(resp.Count, ShouldEqual, 1)
+			So(cli.Close(), ShouldBeNil)
+		})
+
+		Convey("Test stream-per-RPC calls", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cli, err := pool.Get(target)
+					c.So(err, ShouldBeNil)
+					defer func() { _ = cli.Close() }()
+					var resp AddResp
+					err = cli.Call("Count.Add", &AddReq{Delta: 1}, &resp)
+					c.So(err, ShouldBeNil)
+				}()
+			}
+			wg.Wait()
+
+			caller := NewPersistentCallerWithPool(pool, target)
+			defer caller.Close()
+			var resp AddResp
+			err = caller.Call("Count.Add", &AddReq{Delta: 1}, &resp)
+			So(err, ShouldBeNil)
+			So(resp.Count, ShouldEqual, 11)
+
+			// reconnect after the connection is removed
+			pool.Remove(target)
+			err = NewCallerWithPool(pool).CallNode(target, "Count.Add", &AddReq{Delta: 1}, &resp)
+			So(err, ShouldBeNil)
+			So(resp.Count, ShouldEqual, 12)
+		})
+	})
+}
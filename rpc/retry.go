@@ -0,0 +1,234 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/rpc"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// RetryPolicy defines the retry policy of idempotent RPC calls.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts including the first one, non-positive value
+	// means no retry.
+	MaxAttempts int
+	// InitialBackoff is the wait duration before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff limits the wait duration between retries.
+	MaxBackoff time.Duration
+	// Multiplier is the growth factor of backoff after each retry.
+	Multiplier float64
+	// Jitter randomizes the backoff by the fraction in [0, 1], e.g. 0.2 means ±20%.
+	Jitter float64
+	// HedgeDelay enables hedged requests if positive: a new attempt is sent to the next replica
+	// if the outstanding ones do not complete within the delay, the first success wins.
+	HedgeDelay time.Duration
+}
+
+// DefaultRetryPolicy is the default retry policy of idempotent RPC calls.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Attempts returns the max number of attempts, which is at least 1.
+func (p *RetryPolicy) Attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Backoff returns the wait duration before the n-th retry (starting from 1).
+func (p *RetryPolicy) Backoff(n int) (d time.Duration) {
+	if n < 1 || p.InitialBackoff <= 0 {
+		return
+	}
+	var mul = p.Multiplier
+	if mul < 1 {
+		mul = 1
+	}
+	var f = float64(p.InitialBackoff) * math.Pow(mul, float64(n-1))
+	if p.MaxBackoff > 0 && f > float64(p.MaxBackoff) {
+		f = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		var jitter = math.Min(p.Jitter, 1)
+		f *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(f)
+}
+
+// IsRetryableError reports whether a failed call can be retried: context errors and errors
+// returned by the remote service are final, others are regarded as transient network errors.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errors.Cause(err).(type) {
+	case rpc.ServerError:
+		return false
+	}
+	switch errors.Cause(err) {
+	case context.Canceled, context.DeadlineExceeded:
+		return false
+	}
+	return true
+}
+
+// CallNodeWithRetry calls node method with context and retries on transient errors according
+// to policy. The method must be idempotent.
+func (c *Caller) CallNodeWithRetry(
+	ctx context.Context, policy RetryPolicy, node proto.NodeID,
+	method string, args, reply interface{}) (err error,
+) {
+	return c.CallNodesWithRetry(ctx, policy, []proto.NodeID{node}, method, args, reply)
+}
+
+// CallNodesWithRetry calls method on the replica nodes with context, attempts are sent to the
+// nodes in a round-robin manner. If policy.HedgeDelay is set, hedged requests are sent without
+// waiting for the outstanding ones to fail. The method must be idempotent.
+func (c *Caller) CallNodesWithRetry(
+	ctx context.Context, policy RetryPolicy, nodes []proto.NodeID,
+	method string, args, reply interface{}) (err error,
+) {
+	if len(nodes) == 0 {
+		return errors.New("no target node to call")
+	}
+	if policy.HedgeDelay > 0 {
+		return c.callHedged(ctx, &policy, nodes, method, args, reply)
+	}
+	return policy.Run(ctx, func(attempt int) error {
+		return c.CallNodeWithContext(ctx, nodes[attempt%len(nodes)], method, args, reply)
+	})
+}
+
+// Run runs f until it succeeds or fails with a non-retryable error, the attempts (starting from
+// 0) are limited and delayed according to policy. f must be idempotent.
+func (p *RetryPolicy) Run(ctx context.Context, f func(attempt int) error) (err error) {
+	for i := 0; i < p.Attempts(); i++ {
+		if i > 0 {
+			timer := time.NewTimer(p.Backoff(i))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				err = errors.Wrapf(ctx.Err(), "retry aborted, last error: %v", err)
+				return
+			case <-timer.C:
+			}
+		}
+		if err = f(i); !IsRetryableError(err) {
+			return
+		}
+	}
+	err = errors.Wrapf(err, "failed after %d attempts", p.Attempts())
+	return
+}
+
+type hedgedResult struct {
+	reply interface{}
+	err   error
+}
+
+// newReply returns a fresh reply object of the same type so that concurrent attempts do not
+// decode into the same one.
+func newReply(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	var t = reflect.TypeOf(reply)
+	if t.Kind() != reflect.Ptr {
+		return reply
+	}
+	return reflect.New(t.Elem()).Interface()
+}
+
+func setReply(dst, src interface{}) {
+	if dst == nil || src == nil || reflect.TypeOf(dst).Kind() != reflect.Ptr {
+		return
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
+
+func (c *Caller) callHedged(
+	ctx context.Context, policy *RetryPolicy, nodes []proto.NodeID,
+	method string, args, reply interface{}) (err error,
+) {
+	var (
+		cctx, cancel = context.WithCancel(ctx)
+		attempts     = policy.Attempts()
+		resultCh     = make(chan *hedgedResult, attempts)
+		sent, done   int
+		hedge        = time.NewTimer(policy.HedgeDelay)
+	)
+	defer cancel()
+	defer hedge.Stop()
+
+	send := func() {
+		var (
+			node = nodes[sent%len(nodes)]
+			r    = newReply(reply)
+		)
+		sent++
+		go func() {
+			resultCh <- &hedgedResult{
+				reply: r,
+				err:   c.CallNodeWithContext(cctx, node, method, args, r),
+			}
+		}()
+	}
+
+	send()
+	for done < sent {
+		select {
+		case <-ctx.Done():
+			err = errors.Wrapf(ctx.Err(), "hedged call %s aborted, last error: %v", method, err)
+			return
+		case <-hedge.C:
+			if sent < attempts {
+				send()
+				hedge.Reset(policy.HedgeDelay)
+			}
+		case res := <-resultCh:
+			done++
+			if err = res.err; err == nil {
+				setReply(reply, res.reply)
+				return
+			}
+			if !IsRetryableError(err) {
+				return
+			}
+			if sent < attempts {
+				// failed fast, send next attempt without waiting for the hedge delay
+				send()
+			}
+		}
+	}
+	err = errors.Wrapf(err, "call %s failed after %d attempts", method, sent)
+	return
+}
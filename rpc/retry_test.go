@@ -0,0 +1,207 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// fakeClient answers calls with the handler of fakePool after the configured delay.
+type fakeClient struct {
+	pool *fakePool
+	node proto.NodeID
+}
+
+func (c *fakeClient) Call(method string, args interface{}, reply interface{}) error {
+	call := <-c.Go(method, args, reply, make(chan *rpc.Call, 1)).Done
+	return call.Error
+}
+
+func (c *fakeClient) Go(method string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	call := &rpc.Call{ServiceMethod: method, Args: args, Reply: reply, Done: done}
+	go func() {
+		n := atomic.AddInt32(&c.pool.calls, 1)
+		delay, err := c.pool.handler(c.node, int(n), reply)
+		time.Sleep(delay)
+		call.Error = err
+		call.Done <- call
+	}()
+	return call
+}
+
+func (c *fakeClient) Close() error { return nil }
+
+type fakePool struct {
+	calls   int32
+	handler func(node proto.NodeID, n int, reply interface{}) (time.Duration, error)
+}
+
+func (p *fakePool) Get(id proto.NodeID) (Client, error) {
+	return &fakeClient{pool: p, node: id}, nil
+}
+
+func (p *fakePool) GetEx(id proto.NodeID, isAnonymous bool) (Client, error) {
+	return p.Get(id)
+}
+
+func (p *fakePool) Close() error { return nil }
+
+func TestRetryPolicy(t *testing.T) {
+	Convey("retry policy backoff", t, func() {
+		p := RetryPolicy{
+			InitialBackoff: 100 * time.Millisecond,
+			MaxBackoff:     300 * time.Millisecond,
+			Multiplier:     2,
+		}
+		So(p.Backoff(0), ShouldEqual, 0)
+		So(p.Backoff(1), ShouldEqual, 100*time.Millisecond)
+		So(p.Backoff(2), ShouldEqual, 200*time.Millisecond)
+		So(p.Backoff(3), ShouldEqual, 300*time.Millisecond)
+		p.Jitter = 0.5
+		for i := 0; i < 100; i++ {
+			d := p.Backoff(1)
+			So(d, ShouldBeBetweenOrEqual, 50*time.Millisecond, 150*time.Millisecond)
+		}
+		So((&RetryPolicy{}).Attempts(), ShouldEqual, 1)
+	})
+	Convey("retry policy run", t, func() {
+		p := RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 10 * time.Millisecond,
+		}
+		var attempts []int
+		err := p.Run(context.Background(), func(attempt int) error {
+			attempts = append(attempts, attempt)
+			if attempt < 1 {
+				return io.EOF
+			}
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(attempts, ShouldResemble, []int{0, 1})
+
+		attempts = attempts[:0]
+		err = p.Run(context.Background(), func(attempt int) error {
+			attempts = append(attempts, attempt)
+			return rpc.ServerError("bad request")
+		})
+		So(err, ShouldResemble, rpc.ServerError("bad request"))
+		So(attempts, ShouldResemble, []int{0})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		err = p.Run(ctx, func(attempt int) error {
+			cancel()
+			return io.EOF
+		})
+		So(errors.Cause(err), ShouldEqual, context.Canceled)
+	})
+	Convey("retryable errors", t, func() {
+		So(IsRetryableError(nil), ShouldBeFalse)
+		So(IsRetryableError(io.EOF), ShouldBeTrue)
+		So(IsRetryableError(errors.Wrap(rpc.ErrShutdown, "call failed")), ShouldBeTrue)
+		So(IsRetryableError(rpc.ServerError("bad request")), ShouldBeFalse)
+		So(IsRetryableError(context.Canceled), ShouldBeFalse)
+		So(IsRetryableError(errors.Wrap(context.DeadlineExceeded, "call failed")), ShouldBeFalse)
+	})
+}
+
+func TestCaller_CallNodesWithRetry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		Multiplier:     2,
+	}
+	Convey("retry on transient errors", t, func() {
+		pool := &fakePool{handler: func(node proto.NodeID, n int, reply interface{}) (time.Duration, error) {
+			if n < 3 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			reply.(*AddResp).Count = int32(n)
+			return 0, nil
+		}}
+		resp := &AddResp{}
+		err := NewCallerWithPool(pool).CallNodeWithRetry(
+			context.Background(), policy, "node", "Count.Add", &AddReq{}, resp)
+		So(err, ShouldBeNil)
+		So(resp.Count, ShouldEqual, 3)
+		So(pool.calls, ShouldEqual, 3)
+	})
+	Convey("give up after max attempts", t, func() {
+		pool := &fakePool{handler: func(node proto.NodeID, n int, reply interface{}) (time.Duration, error) {
+			return 0, io.EOF
+		}}
+		err := NewCallerWithPool(pool).CallNodeWithRetry(
+			context.Background(), policy, "node", "Count.Add", &AddReq{}, &AddResp{})
+		So(errors.Cause(err), ShouldEqual, io.EOF)
+		So(pool.calls, ShouldEqual, 3)
+	})
+	Convey("no retry on server errors", t, func() {
+		pool := &fakePool{handler: func(node proto.NodeID, n int, reply interface{}) (time.Duration, error) {
+			return 0, rpc.ServerError("bad request")
+		}}
+		err := NewCallerWithPool(pool).CallNodeWithRetry(
+			context.Background(), policy, "node", "Count.Add", &AddReq{}, &AddResp{})
+		So(err, ShouldResemble, rpc.ServerError("bad request"))
+		So(pool.calls, ShouldEqual, 1)
+	})
+	Convey("hedged request to replicas", t, func() {
+		pool := &fakePool{handler: func(node proto.NodeID, n int, reply interface{}) (time.Duration, error) {
+			reply.(*AddResp).Count = int32(n)
+			if node == "slow" {
+				return time.Second, nil
+			}
+			return 0, nil
+		}}
+		hedged := policy
+		hedged.HedgeDelay = 50 * time.Millisecond
+		resp := &AddResp{}
+		start := time.Now()
+		err := NewCallerWithPool(pool).CallNodesWithRetry(
+			context.Background(), hedged, []proto.NodeID{"slow", "fast"}, "Count.Add", &AddReq{}, resp)
+		So(err, ShouldBeNil)
+		So(resp.Count, ShouldEqual, 2)
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+	})
+	Convey("hedged request fails over immediately", t, func() {
+		pool := &fakePool{handler: func(node proto.NodeID, n int, reply interface{}) (time.Duration, error) {
+			if node == "broken" {
+				return 0, io.EOF
+			}
+			reply.(*AddResp).Count = 1
+			return 0, nil
+		}}
+		hedged := policy
+		hedged.HedgeDelay = time.Second
+		resp := &AddResp{}
+		start := time.Now()
+		err := NewCallerWithPool(pool).CallNodesWithRetry(
+			context.Background(), hedged, []proto.NodeID{"broken", "ok"}, "Count.Add", &AddReq{}, resp)
+		So(err, ShouldBeNil)
+		So(resp.Count, ShouldEqual, 1)
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+	})
+}
@@ -29,6 +29,15 @@ import (
 func ServeDirect(
 	ctx context.Context, server *rpc.Server, stream io.ReadWriteCloser, remote *proto.RawNodeID,
 ) {
+	stream, isStreamCall, err := AcceptStreamCall(stream)
+	if err != nil {
+		_ = stream.Close()
+		return
+	}
+	if isStreamCall {
+		ServeStreamCall(ctx, stream, remote)
+		return
+	}
 	subctx, cancelFunc := context.WithCancel(ctx)
 	defer cancelFunc()
 	nodeAwareCodec := NewNodeAwareServerCodec(subctx, utils.GetMsgPackServerCodec(stream), remote)
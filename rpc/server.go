@@ -23,10 +23,13 @@ import (
 	"net/rpc"
 
 	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
 
+	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/naconn"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
@@ -43,6 +46,13 @@ type ServeStream func(
 	ctx context.Context, server *rpc.Server, stream io.ReadWriteCloser, remote *proto.RawNodeID,
 )
 
+type serverKey struct{}
+
+func serverFromContext(ctx context.Context) *Server {
+	s, _ := ctx.Value(serverKey{}).(*Server)
+	return s
+}
+
 // Server is the RPC server struct.
 type Server struct {
 	ctx         context.Context
@@ -50,19 +60,32 @@ type Server struct {
 	rpcServer   *rpc.Server
 	acceptConn  AcceptConn
 	serveStream ServeStream
-	Listener    net.Listener
+	// streamHandlers holds the streaming RPC methods.
+	streamHandlers streamHandlers
+	// interceptors are the middlewares of the RPC calls served by the server.
+	interceptors Interceptors
+	// bandwidth accounts the traffic of the served connections by remote node.
+	bandwidth bandwidthAccounting
+	Listener  net.Listener
+	// QUICListener is the optional QUIC listener served alongside Listener.
+	QUICListener *quic.Listener
 }
 
 // NewServerWithServeFunc return a new Server.
 func NewServerWithServeFunc(f ServeStream) *Server {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &Server{
-		ctx:         ctx,
-		cancel:      cancel,
+	s := &Server{
 		rpcServer:   rpc.NewServer(),
 		acceptConn:  AcceptNAConn,
 		serveStream: f,
 	}
+	// the server is attached to ctx, so that ServeStream can access its stream handlers and
+	// interceptors
+	s.ctx, s.cancel = context.WithCancel(context.WithValue(context.Background(), serverKey{}, s))
+	_ = s.RegisterService(route.AdminRPCName, &AdminService{server: s})
+	if conf.GConf != nil && conf.GConf.MethodACL != nil {
+		s.Use(NewMethodACL(conf.GConf.MethodACL))
+	}
+	return s
 }
 
 // InitRPCServer load the private key, init the crypto transfer layer and register RPC
@@ -89,6 +112,15 @@ func (s *Server) InitRPCServer(
 
 	s.SetListener(l)
 
+	if IsQUICTransport() {
+		var ql *quic.Listener
+		if ql, err = ListenQUIC(l.Addr().String()); err != nil {
+			_ = l.Close()
+			return
+		}
+		s.SetQUICListener(ql)
+	}
+
 	return
 }
 
@@ -99,6 +131,9 @@ func (s *Server) SetListener(l net.Listener) {
 
 // Serve start the Server main loop,.
 func (s *Server) Serve() {
+	if s.QUICListener != nil {
+		go s.serveQUIC()
+	}
 serverLoop:
 	for {
 		select {
@@ -118,7 +153,11 @@ serverLoop:
 
 func (s *Server) serveConn(conn net.Conn) {
 	le := log.WithField("remote_addr", conn.RemoteAddr())
-	stream, err := s.acceptConn(s.ctx, conn)
+	if err := naconn.TuneConn(conn); err != nil {
+		le.WithError(err).Warning("failed to tune conn")
+	}
+	counted := newCountingConn(conn, nil)
+	stream, err := s.acceptConn(s.ctx, counted)
 	if err != nil {
 		le.WithError(err).Error("failed to accept conn")
 		return
@@ -130,6 +169,7 @@ func (s *Server) serveConn(conn net.Conn) {
 		id := remoter.Remote()
 		remote = &id
 		le = le.WithField("remote_node", id)
+		s.bandwidth.attach(counted, id.ToNodeID())
 	}
 	le.Debug("accept server conn")
 	// Serve data stream
@@ -146,5 +186,8 @@ func (s *Server) Stop() {
 	if s.Listener != nil {
 		_ = s.Listener.Close()
 	}
+	if s.QUICListener != nil {
+		_ = s.QUICListener.Close()
+	}
 	s.cancel()
 }
@@ -0,0 +1,305 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/naconn"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// streamCallMagic is the leading byte of a streaming RPC data stream. It's never used in
+// msgpack encoding, so a stream carrying normal RPC requests never starts with it.
+const streamCallMagic byte = 0xc1
+
+var (
+	// ErrStreamMethodNotFound indicates that the requested streaming method is not registered.
+	ErrStreamMethodNotFound = errors.New("stream method not found")
+	// ErrStreamNotSupported indicates that the client pool can not open dedicated data streams.
+	ErrStreamNotSupported = errors.New("stream is not supported by client pool")
+)
+
+// StreamHandler defines the function type which serves a streaming RPC call. The response is
+// written to w incrementally and chunked into frames of at most conf.StreamChunkSize bytes, the
+// returned error is sent to the caller after all the written data.
+type StreamHandler func(ctx context.Context, call *StreamCall, w io.Writer) error
+
+// StreamCall is the request of a streaming RPC call.
+type StreamCall struct {
+	Method string
+	Remote *proto.RawNodeID
+	dec    interface{ Decode(interface{}) error }
+}
+
+// ReadArgs decodes the request arguments of the call into args.
+func (c *StreamCall) ReadArgs(args interface{}) (err error) {
+	if err = c.dec.Decode(args); err != nil {
+		err = errors.Wrapf(err, "decode arguments of %s failed", c.Method)
+	}
+	return
+}
+
+type streamCallHeader struct {
+	Method string
+}
+
+type streamFrame struct {
+	Data  []byte
+	Error string
+	EOF   bool
+}
+
+type streamHandlers struct {
+	sync.Map // method -> StreamHandler
+}
+
+func (h *streamHandlers) get(method string) (handler StreamHandler, ok bool) {
+	var v interface{}
+	if v, ok = h.Load(method); ok {
+		handler = v.(StreamHandler)
+	}
+	return
+}
+
+// RegisterStreamHandler registers handler as the streaming RPC method, which is called with
+// Caller.CallStream.
+func (s *Server) RegisterStreamHandler(method string, handler StreamHandler) {
+	s.streamHandlers.Store(method, handler)
+}
+
+// AcceptStreamCall reads the leading byte of stream and reports whether it's a streaming RPC
+// data stream. The returned stream should be used in place of the original one.
+func AcceptStreamCall(stream io.ReadWriteCloser) (_ io.ReadWriteCloser, isStreamCall bool, err error) {
+	var lead [1]byte
+	if _, err = io.ReadFull(stream, lead[:]); err != nil {
+		return stream, false, err
+	}
+	if lead[0] == streamCallMagic {
+		return stream, true, nil
+	}
+	return &prefixedStream{
+		Reader:          io.MultiReader(bytes.NewReader(lead[:]), stream),
+		ReadWriteCloser: stream,
+	}, false, nil
+}
+
+// prefixedStream puts the bytes consumed by AcceptStreamCall back to the stream.
+type prefixedStream struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (s *prefixedStream) Read(p []byte) (int, error) {
+	return s.Reader.Read(p)
+}
+
+// ServeStreamCall serves a streaming RPC call on stream accepted by AcceptStreamCall with the
+// handlers registered to the Server of ctx.
+func ServeStreamCall(ctx context.Context, stream io.ReadWriteCloser, remote *proto.RawNodeID) {
+	defer func() { _ = stream.Close() }()
+	var (
+		dec    = utils.GetMsgPackDecoder(stream)
+		w      = &streamWriter{enc: utils.GetMsgPackEncoder(stream)}
+		header streamCallHeader
+		err    error
+	)
+	if err = dec.Decode(&header); err != nil {
+		log.WithError(err).Debug("read stream call header failed")
+		return
+	}
+	le := log.WithField("method", header.Method)
+	defer func() {
+		if err = w.finish(err); err != nil {
+			le.WithError(err).Debug("finish stream call failed")
+		}
+	}()
+	if remote != nil && remote.IsEqual(&kms.AnonymousRawNodeID.Hash) &&
+		!naconn.IsAnonymousMethodAllowed(header.Method) {
+		err = errors.Errorf("%s is not permitted for anonymous session", header.Method)
+		return
+	}
+	server := serverFromContext(ctx)
+	if server == nil {
+		err = errors.Wrap(ErrStreamMethodNotFound, header.Method)
+		return
+	}
+	if len(server.interceptors) > 0 {
+		info := &CallInfo{Method: header.Method, Remote: remote}
+		if err = server.interceptors.OnRequest(ctx, info); err != nil {
+			server.interceptors.OnResponse(ctx, info, err)
+			return
+		}
+		defer func() { server.interceptors.OnResponse(ctx, info, err) }()
+	}
+	handler, ok := server.streamHandlers.get(header.Method)
+	if !ok {
+		err = errors.Wrap(ErrStreamMethodNotFound, header.Method)
+		return
+	}
+	err = handler(ctx, &StreamCall{Method: header.Method, Remote: remote, dec: dec}, w)
+}
+
+// streamWriter splits the written data into frames.
+type streamWriter struct {
+	sync.Mutex
+	enc interface{ Encode(interface{}) error }
+	err error
+}
+
+func (w *streamWriter) Write(p []byte) (n int, err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	for len(p) > 0 {
+		size := len(p)
+		if size > conf.StreamChunkSize {
+			size = conf.StreamChunkSize
+		}
+		if err = w.enc.Encode(&streamFrame{Data: p[:size]}); err != nil {
+			w.err = errors.Wrap(err, "write stream frame failed")
+			return n, w.err
+		}
+		n += size
+		p = p[size:]
+	}
+	return
+}
+
+func (w *streamWriter) finish(cause error) (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	frame := &streamFrame{EOF: true}
+	if cause != nil {
+		frame.Error = cause.Error()
+	}
+	w.err = io.ErrClosedPipe
+	return w.enc.Encode(frame)
+}
+
+// StreamReader reads the response data of a streaming RPC call.
+type StreamReader struct {
+	stream io.ReadWriteCloser
+	dec    interface{ Decode(interface{}) error }
+	buf    []byte
+	err    error
+	once   sync.Once
+	done   chan struct{}
+}
+
+// Read implements io.Reader. It returns io.EOF after all the response data is read, or the
+// error returned by the remote handler.
+func (r *StreamReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var frame streamFrame
+		if err = r.dec.Decode(&frame); err != nil {
+			r.err = errors.Wrap(err, "read stream frame failed")
+			continue
+		}
+		if frame.EOF {
+			if frame.Error != "" {
+				r.err = errors.New(frame.Error)
+			} else {
+				r.err = io.EOF
+			}
+		}
+		r.buf = frame.Data
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return
+}
+
+// Close closes the underlying data stream.
+func (r *StreamReader) Close() (err error) {
+	r.once.Do(func() {
+		close(r.done)
+		err = r.stream.Close()
+	})
+	return
+}
+
+// NOStreamOpener defines the node-oriented client pool interface which opens dedicated data
+// streams for streaming RPC calls.
+type NOStreamOpener interface {
+	OpenStream(ctx context.Context, remote proto.NodeID) (io.ReadWriteCloser, error)
+}
+
+// CallStream calls the streaming method of node and returns the response reader, the caller
+// must close the reader after use. If ctx is canceled or expired, the data stream is closed and
+// the pending read is aborted.
+func (c *Caller) CallStream(
+	ctx context.Context, node proto.NodeID, method string, args interface{}) (r *StreamReader, err error,
+) {
+	startTime := time.Now()
+	defer func() {
+		recordRPCCost(startTime, method, err)
+	}()
+
+	opener, ok := c.pool.(NOStreamOpener)
+	if !ok {
+		err = ErrStreamNotSupported
+		return
+	}
+	stream, err := opener.OpenStream(ctx, node)
+	if err != nil {
+		err = errors.Wrapf(err, "open stream to node %s failed", node)
+		return
+	}
+	enc := utils.GetMsgPackEncoder(stream)
+	if _, err = stream.Write([]byte{streamCallMagic}); err == nil {
+		if err = enc.Encode(&streamCallHeader{Method: method}); err == nil {
+			err = enc.Encode(args)
+		}
+	}
+	if err != nil {
+		_ = stream.Close()
+		err = errors.Wrapf(err, "send stream call to node %s failed", node)
+		return
+	}
+	r = &StreamReader{
+		stream: stream,
+		dec:    utils.GetMsgPackDecoder(stream),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = r.Close()
+		case <-r.done:
+		}
+	}()
+	return
+}
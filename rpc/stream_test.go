@@ -0,0 +1,116 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+// serveRepeat writes the requested count of repeated bytes in chunks larger than a single frame.
+func serveRepeat(ctx context.Context, call *StreamCall, w io.Writer) (err error) {
+	var size int
+	if err = call.ReadArgs(&size); err != nil {
+		return
+	}
+	chunk := bytes.Repeat([]byte{'x'}, 3*conf.StreamChunkSize+1)
+	for size > 0 {
+		n := len(chunk)
+		if n > size {
+			n = size
+		}
+		if _, err = w.Write(chunk[:n]); err != nil {
+			return
+		}
+		size -= n
+	}
+	return
+}
+
+func serveFailure(ctx context.Context, call *StreamCall, w io.Writer) (err error) {
+	if _, err = w.Write([]byte("partial")); err != nil {
+		return
+	}
+	return errors.New("handler failure")
+}
+
+func TestStreamCall(t *testing.T) {
+	Convey("Setup a single server with streaming methods", t, func(c C) {
+		transport := conf.GConf.Transport
+		conf.GConf.Transport = conf.TransportQUIC
+		defer func() { conf.GConf.Transport = transport }()
+
+		nodes, err := createLocalNodes(10, 1)
+		So(err, ShouldBeNil)
+		server, err := setupServer(nodes[0])
+		So(err, ShouldBeNil)
+		server.RegisterStreamHandler("Stream.Repeat", serveRepeat)
+		server.RegisterStreamHandler("Stream.Failure", serveFailure)
+		go server.Serve()
+		defer func() {
+			defaultResolver.deleteNode(*(nodes[0].ID.ToRawNodeID()))
+			server.Stop()
+		}()
+		target := nodes[0].ID
+
+		for _, pool := range []NOClientPool{&ClientPool{}, &QUICPool{}} {
+			caller := NewCallerWithPool(pool)
+
+			r, err := caller.CallStream(context.Background(), target, "Stream.Repeat", 1<<20)
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1<<20)
+			So(bytes.Count(data, []byte{'x'}), ShouldEqual, 1<<20)
+			_ = r.Close()
+
+			// normal RPC calls should still work on the same pool
+			var resp AddResp
+			err = caller.CallNode(target, "Count.Add", &AddReq{Delta: 1}, &resp)
+			So(err, ShouldBeNil)
+
+			r, err = caller.CallStream(context.Background(), target, "Stream.Failure", nil)
+			So(err, ShouldBeNil)
+			data, err = ioutil.ReadAll(r)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "handler failure")
+			So(string(data), ShouldEqual, "partial")
+			_ = r.Close()
+
+			r, err = caller.CallStream(context.Background(), target, "Stream.NotFound", nil)
+			So(err, ShouldBeNil)
+			_, err = ioutil.ReadAll(r)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrStreamMethodNotFound.Error())
+			_ = r.Close()
+
+			_ = pool.Close()
+		}
+
+		_, err = NewCallerWithPool(&nilPool{}).CallStream(
+			context.Background(), target, "Stream.Repeat", 1)
+		So(err, ShouldEqual, ErrStreamNotSupported)
+	})
+}
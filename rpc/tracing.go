@@ -0,0 +1,154 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const tracerName = "github.com/CovenantSQL/CovenantSQL/rpc"
+
+// tracePropagator encodes span contexts to rpc envelopes in W3C Trace Context format.
+var tracePropagator = propagation.TraceContext{}
+
+// SetupTracing installs the global tracer provider which exports spans as configured, the
+// returned shutdown function flushes the pending spans and should be called before exiting.
+func SetupTracing(cfg *conf.TracingConfig, nodeID proto.NodeID) (
+	shutdown func(context.Context) error, err error,
+) {
+	shutdown = func(context.Context) error { return nil }
+	if cfg == nil || cfg.Exporter == "" || cfg.Exporter == conf.TracingExporterNone {
+		return
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		file     *os.File
+	)
+	switch cfg.Exporter {
+	case conf.TracingExporterStdout:
+		var opts []stdouttrace.Option
+		if cfg.File != "" {
+			if file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+				err = errors.Wrapf(err, "open trace file %s failed", cfg.File)
+				return
+			}
+			opts = append(opts, stdouttrace.WithWriter(file))
+		}
+		if exporter, err = stdouttrace.New(opts...); err != nil {
+			err = errors.Wrap(err, "create stdout trace exporter failed")
+			if file != nil {
+				_ = file.Close()
+			}
+			return
+		}
+	default:
+		err = errors.Errorf("unknown tracing exporter: %s", cfg.Exporter)
+		return
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRate > 0 && cfg.SampleRate < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRate)
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = conf.RoleTag
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("node.id", string(nodeID)),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(tracePropagator)
+
+	shutdown = func(ctx context.Context) (err error) {
+		err = provider.Shutdown(ctx)
+		if file != nil {
+			_ = file.Close()
+		}
+		return
+	}
+	return
+}
+
+// startClientSpan starts a client span of the call and injects its context into the envelope of
+// args, so that the server side span is linked to the caller.
+func startClientSpan(
+	ctx context.Context, node proto.NodeID, method string, args interface{}) (context.Context, trace.Span,
+) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.method", method),
+			attribute.String("rpc.peer", string(node)),
+		),
+	)
+	if e, ok := args.(proto.EnvelopeAPI); ok && span.SpanContext().IsValid() {
+		carrier := propagation.MapCarrier{}
+		tracePropagator.Inject(ctx, carrier)
+		e.SetTraceContext(carrier)
+	}
+	return ctx, span
+}
+
+// startServerSpan starts a server span of the request, which is a child of the caller span if
+// the envelope of body carries a trace context.
+func startServerSpan(
+	ctx context.Context, remote *proto.RawNodeID, method string, body interface{}) (context.Context, trace.Span,
+) {
+	if e, ok := body.(proto.EnvelopeAPI); ok {
+		if tc := e.GetTraceContext(); len(tc) > 0 {
+			ctx = tracePropagator.Extract(ctx, propagation.MapCarrier(tc))
+		}
+	}
+	attrs := []attribute.KeyValue{attribute.String("rpc.method", method)}
+	if remote != nil {
+		attrs = append(attrs, attribute.String("rpc.peer", string(remote.ToNodeID())))
+	}
+	return otel.Tracer(tracerName).Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan records the error of the call if any and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
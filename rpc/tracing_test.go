@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+)
+
+func TestTracing(t *testing.T) {
+	Convey("Setup a single server with span recorder", t, func(c C) {
+		recorder := tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		origin := otel.GetTracerProvider()
+		otel.SetTracerProvider(provider)
+		defer otel.SetTracerProvider(origin)
+
+		nodes, err := createLocalNodes(10, 1)
+		So(err, ShouldBeNil)
+		server, err := setupServer(nodes[0])
+		So(err, ShouldBeNil)
+		go server.Serve()
+		defer func() {
+			defaultResolver.deleteNode(*(nodes[0].ID.ToRawNodeID()))
+			server.Stop()
+		}()
+		pool := &ClientPool{}
+		defer func() { _ = pool.Close() }()
+		caller := NewCallerWithPool(pool)
+
+		ctx, root := provider.Tracer("test").Start(context.Background(), "query")
+		var (
+			req  = &AddReq{Delta: 1}
+			resp AddResp
+		)
+		err = caller.CallNodeWithContext(ctx, nodes[0].ID, "Count.Add", req, &resp)
+		So(err, ShouldBeNil)
+		root.End()
+		So(req.GetTraceContext(), ShouldContainKey, "traceparent")
+
+		// server span is ended after the response is written
+		var spans []sdktrace.ReadOnlySpan
+		for i := 0; i < 100; i++ {
+			if spans = recorder.Ended(); len(spans) >= 3 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(len(spans), ShouldEqual, 3)
+		var clientSpan, serverSpan sdktrace.ReadOnlySpan
+		for _, v := range spans {
+			switch v.SpanKind() {
+			case trace.SpanKindClient:
+				clientSpan = v
+			case trace.SpanKindServer:
+				serverSpan = v
+			}
+		}
+		So(clientSpan, ShouldNotBeNil)
+		So(serverSpan, ShouldNotBeNil)
+		So(clientSpan.Name(), ShouldEqual, "Count.Add")
+		So(clientSpan.Parent().SpanID(), ShouldEqual, root.SpanContext().SpanID())
+		So(serverSpan.SpanContext().TraceID(), ShouldEqual, root.SpanContext().TraceID())
+		So(serverSpan.Parent().SpanID(), ShouldEqual, clientSpan.SpanContext().SpanID())
+		So(serverSpan.Parent().IsRemote(), ShouldBeTrue)
+	})
+	Convey("Setup tracing with stdout exporter", t, func(c C) {
+		origin := otel.GetTracerProvider()
+		defer otel.SetTracerProvider(origin)
+
+		dir, err := ioutil.TempDir("", "tracing")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+		file := filepath.Join(dir, "trace.json")
+
+		_, err = SetupTracing(&conf.TracingConfig{Exporter: "unknown"}, "")
+		So(err, ShouldNotBeNil)
+		shutdown, err := SetupTracing(nil, "")
+		So(err, ShouldBeNil)
+		So(shutdown(context.Background()), ShouldBeNil)
+
+		shutdown, err = SetupTracing(&conf.TracingConfig{
+			Exporter:    conf.TracingExporterStdout,
+			File:        file,
+			ServiceName: "test",
+		}, "node")
+		So(err, ShouldBeNil)
+		_, span := otel.Tracer("test").Start(context.Background(), "query")
+		span.End()
+		So(shutdown(context.Background()), ShouldBeNil)
+		data, err := ioutil.ReadFile(file)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"Name":"query"`)
+	})
+}
@@ -17,6 +17,8 @@
 package rpc
 
 import (
+	"context"
+
 	"github.com/CovenantSQL/CovenantSQL/naconn"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 )
@@ -26,9 +28,11 @@ import (
 //
 // TODO(leventeliu): allow to config other node-oriented connection dialer/accepter.
 var (
-	Dial   = naconn.Dial
-	DialEx = naconn.DialEx
-	Accept = naconn.Accept
+	Dial          = naconn.Dial
+	DialEx        = naconn.DialEx
+	DialContext   = naconn.DialContext
+	DialExContext = naconn.DialExContext
+	Accept        = naconn.Accept
 )
 
 // NOClientPool defines the node-oriented client pool interface.
@@ -38,6 +42,14 @@ type NOClientPool interface {
 	Close() error
 }
 
+// NOClientPoolWithContext defines the node-oriented client pool interface which supports
+// canceling the connecting process with context.
+type NOClientPoolWithContext interface {
+	NOClientPool
+	GetContext(ctx context.Context, remote proto.NodeID) (Client, error)
+	GetExContext(ctx context.Context, remote proto.NodeID, isAnonymous bool) (Client, error)
+}
+
 // DialToNodeWithPool ties use connection in pool, if fails then connects to the node with nodeID.
 func DialToNodeWithPool(pool NOClientPool, nodeID proto.NodeID, isAnonymous bool) (Client, error) {
 	if isAnonymous {
@@ -46,3 +58,20 @@ func DialToNodeWithPool(pool NOClientPool, nodeID proto.NodeID, isAnonymous bool
 	//log.WithField("poolSize", pool.Len()).Debug("session pool size")
 	return pool.Get(nodeID)
 }
+
+// DialToNodeWithContext is like DialToNodeWithPool but the dialing process is aborted if ctx is
+// canceled or expired.
+func DialToNodeWithContext(
+	ctx context.Context, pool NOClientPool, nodeID proto.NodeID, isAnonymous bool) (Client, error,
+) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cp, ok := pool.(NOClientPoolWithContext); ok {
+		if isAnonymous {
+			return cp.GetExContext(ctx, nodeID, true)
+		}
+		return cp.GetContext(ctx, nodeID)
+	}
+	return DialToNodeWithPool(pool, nodeID, isAnonymous)
+}
@@ -53,12 +53,12 @@ type PCaller interface {
 
 // NewCaller returns a new RPCCaller.
 func NewCaller() *Caller {
-	return NewCallerWithPool(defaultPool)
+	return NewCallerWithPool(PoolForTransport(defaultPool))
 }
 
 // NewPersistentCaller returns a persistent RPCCaller.
 //  IMPORTANT: If a PersistentCaller is firstly used by a DHT.Ping, which is an anonymous
 //  ETLS connection. It should not be used by any other RPC except DHT.Ping.
 func NewPersistentCaller(target proto.NodeID) *PersistentCaller {
-	return NewPersistentCallerWithPool(defaultPool, target)
+	return NewPersistentCallerWithPool(PoolForTransport(defaultPool), target)
 }
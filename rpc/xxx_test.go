@@ -230,6 +230,8 @@ func setup() {
 	if conf.GConf, err = conf.LoadConfig(confFile); err != nil {
 		panic(err)
 	}
+	// all the test clients dial from loopback concurrently
+	conf.GConf.MaxHandshakesPerIP = -1
 	if err = kms.InitLocalKeyPair(privateKey, []byte{}); err != nil {
 		panic(err)
 	}
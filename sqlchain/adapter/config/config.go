@@ -32,6 +32,13 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
 
+const (
+	// DefaultMySQLUser defines the default user of mysql protocol listener.
+	DefaultMySQLUser = "root"
+	// DefaultPostgresUser defines the default user of postgresql protocol listener.
+	DefaultPostgresUser = "postgres"
+)
+
 var (
 	// global config object.
 	currentConfig *Config
@@ -63,6 +70,16 @@ type Config struct {
 	StorageDriver   string          `yaml:"StorageDriver"` // sqlite3 or covenantsql
 	StorageRoot     string          `yaml:"StorageRoot"`
 	StorageInstance storage.Storage `yaml:"-"`
+
+	// mysql protocol related
+	MySQLListenAddr string `yaml:"MySQLListenAddr"` // mysql protocol listener is disabled if empty
+	MySQLUser       string `yaml:"MySQLUser"`
+	MySQLPassword   string `yaml:"MySQLPassword"`
+
+	// postgresql protocol related
+	PostgresListenAddr string `yaml:"PostgresListenAddr"` // postgresql protocol listener is disabled if empty
+	PostgresUser       string `yaml:"PostgresUser"`
+	PostgresPassword   string `yaml:"PostgresPassword"`
 }
 
 type confWrapper struct {
@@ -85,6 +102,13 @@ func LoadConfig(configPath string) (config *Config, err error) {
 
 	config = &configWrapper.Adapter
 
+	if len(config.MySQLUser) == 0 {
+		config.MySQLUser = DefaultMySQLUser
+	}
+	if len(config.PostgresUser) == 0 {
+		config.PostgresUser = DefaultPostgresUser
+	}
+
 	if len(config.StorageDriver) == 0 {
 		config.StorageDriver = "covenantsql"
 	}
@@ -23,4 +23,10 @@ var (
 	ErrInvalidStorageConfig = errors.New("invalid storage config")
 	// ErrInvalidCertificateFile defines invalid certificate file error.
 	ErrInvalidCertificateFile = errors.New("invalid certificate file")
+	// ErrConfigNotLoaded defines error on using adapter config before loading.
+	ErrConfigNotLoaded = errors.New("adapter config not loaded")
+	// ErrMissingMySQLListenAddr defines error on starting mysql adapter without listen address.
+	ErrMissingMySQLListenAddr = errors.New("missing mysql listen address")
+	// ErrMissingPostgresListenAddr defines error on starting postgresql adapter without listen address.
+	ErrMissingPostgresListenAddr = errors.New("missing postgresql listen address")
 )
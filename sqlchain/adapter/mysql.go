@@ -0,0 +1,112 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adapter
+
+import (
+	"net"
+	"sync"
+
+	mys "github.com/siddontang/go-mysql/server"
+
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/config"
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/mysql"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// MySQLAdapter serves the adapter storage using MySQL protocol, so the existing MySQL clients
+// and ORMs can connect without code changes.
+type MySQLAdapter struct {
+	listenAddr string
+	listener   net.Listener
+	user       string
+	password   string
+	conns      sync.Map // net.Conn -> struct{}
+}
+
+// NewMySQLAdapter creates MySQL protocol adapter on listenAddr, the adapter config should be
+// loaded by NewHTTPAdapter first. MySQLListenAddr of config is used if listenAddr is empty.
+func NewMySQLAdapter(listenAddr string) (adapter *MySQLAdapter, err error) {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		err = config.ErrConfigNotLoaded
+		return
+	}
+	if listenAddr == "" {
+		listenAddr = cfg.MySQLListenAddr
+	}
+	if listenAddr == "" {
+		err = config.ErrMissingMySQLListenAddr
+		return
+	}
+
+	adapter = &MySQLAdapter{
+		listenAddr: listenAddr,
+		user:       cfg.MySQLUser,
+		password:   cfg.MySQLPassword,
+	}
+	return
+}
+
+// Serve defines adapter serve logic.
+func (adapter *MySQLAdapter) Serve() (err error) {
+	if adapter.listener, err = net.Listen("tcp", adapter.listenAddr); err != nil {
+		return
+	}
+
+	go func() {
+		for {
+			conn, err := adapter.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go adapter.handleConn(conn)
+		}
+	}()
+
+	return
+}
+
+func (adapter *MySQLAdapter) handleConn(conn net.Conn) {
+	adapter.conns.Store(conn, struct{}{})
+	defer adapter.conns.Delete(conn)
+	defer conn.Close()
+
+	h := mysql.NewHandler(config.GetConfig().StorageInstance, adapter.user)
+	c, err := mys.NewConn(conn, adapter.user, adapter.password, h)
+	if err != nil {
+		log.WithField("remote", conn.RemoteAddr()).WithError(err).Debug("mysql handshake failed")
+		return
+	}
+
+	for !c.Closed() {
+		if err = c.HandleCommand(); err != nil {
+			return
+		}
+	}
+}
+
+// Shutdown shutdown the service.
+func (adapter *MySQLAdapter) Shutdown() {
+	if adapter.listener != nil {
+		adapter.listener.Close()
+	}
+	adapter.conns.Range(func(k, _ interface{}) bool {
+		k.(net.Conn).Close()
+		return true
+	})
+}
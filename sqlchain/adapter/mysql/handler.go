@@ -0,0 +1,443 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	my "github.com/siddontang/go-mysql/mysql"
+
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/storage"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+var (
+	dbIDRegex                     = regexp.MustCompile("^[a-zA-Z0-9_\\.]+$")
+	specialSelectQuery            = regexp.MustCompile("^(?i)SELECT\\s+(DATABASE|USER)\\(\\)\\s*;?\\s*$")
+	emptyResultQuery              = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*SET.*$")
+	beginQuery                    = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*(?:BEGIN(?:\\s+WORK)?|START\\s+TRANSACTION)\\s*;?\\s*$")
+	commitQuery                   = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*COMMIT(?:\\s+WORK)?\\s*;?\\s*$")
+	rollbackQuery                 = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*ROLLBACK(?:\\s+WORK)?\\s*;?\\s*$")
+	savepointQuery                = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*(?:SAVEPOINT|RELEASE\\s+SAVEPOINT|ROLLBACK\\s+(?:WORK\\s+)?TO)\\b")
+	emptyResultWithResultSetQuery = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*(?:(?:SELECT\\s+)?@@(?:\\w+\\.)?|SHOW\\s+WARNINGS).*$")
+	showVariablesQuery            = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*SHOW\\s+VARIABLES.*$")
+	showDatabasesQuery            = regexp.MustCompile("^(?i)\\s*(?:/\\*.*?\\*/)?\\s*SHOW\\s+DATABASES.*$")
+	useDatabaseQuery              = regexp.MustCompile("^(?i)\\s*USE\\s+`?(\\w+)`?\\s*;?\\s*$")
+	readQuery                     = regexp.MustCompile("^(?i)\\s*(?:SELECT|SHOW|DESC)")
+	mysqlServerVariables          = map[string]interface{}{
+		"max_allowed_packet":       255 * 255 * 255,
+		"auto_increment_increment": 1,
+		"transaction_isolation":    "SERIALIZABLE",
+		"tx_isolation":             "SERIALIZABLE",
+		"transaction_read_only":    0,
+		"tx_read_only":             0,
+		"autocommit":               1,
+		"character_set_server":     "utf8",
+		"collation_server":         "utf8_general_ci",
+	}
+)
+
+// Handler is the MySQL connection handler which translates the MySQL commands to the queries of
+// adapter storage, each connection should use its own handler.
+type Handler struct {
+	storage storage.Storage
+	user    string
+	curDB   string
+	inTx    bool
+	txStmts []storage.Statement // writes buffered in transaction, sent as a batch on commit
+}
+
+// NewHandler returns a new connection handler of the authenticated user.
+func NewHandler(s storage.Storage, user string) *Handler {
+	return &Handler{storage: s, user: user}
+}
+
+func newResult(resultSet *my.Resultset) *my.Result {
+	return &my.Result{
+		Status:       0,
+		InsertId:     0,
+		AffectedRows: 0,
+		Resultset:    resultSet,
+	}
+}
+
+func wrapError(err error) error {
+	if _, ok := err.(*my.MyError); ok {
+		return err
+	}
+	return my.NewError(my.ER_UNKNOWN_ERROR, err.Error())
+}
+
+// normalizeRows converts the storage values to the types supported by MySQL result set, the
+// columns with mixed value types are sent as strings.
+func normalizeRows(rows [][]interface{}) {
+	for _, row := range rows {
+		for i, v := range row {
+			switch value := v.(type) {
+			case []byte:
+				row[i] = string(value)
+			case bool:
+				if value {
+					row[i] = int8(1)
+				} else {
+					row[i] = int8(0)
+				}
+			case time.Time:
+				row[i] = value.Format("2006-01-02 15:04:05.999999999")
+			}
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	for col := range rows[0] {
+		var colType reflect.Type
+		mixed := false
+		for _, row := range rows {
+			if row[col] == nil {
+				continue
+			}
+			if t := reflect.TypeOf(row[col]); colType == nil {
+				colType = t
+			} else if t != colType {
+				mixed = true
+				break
+			}
+		}
+		if !mixed {
+			continue
+		}
+		for _, row := range rows {
+			if row[col] != nil {
+				row[col] = fmt.Sprint(row[col])
+			}
+		}
+	}
+}
+
+func (h *Handler) buildResultSet(columns []string, rows [][]interface{}) (r *my.Result, err error) {
+	normalizeRows(rows)
+
+	var resultSet *my.Resultset
+	if resultSet, err = my.BuildSimpleTextResultset(columns, rows); err != nil {
+		err = wrapError(err)
+		return
+	}
+
+	r = newResult(resultSet)
+	return
+}
+
+func (h *Handler) ensureDatabase() (dbID string, err error) {
+	if h.curDB == "" {
+		err = my.NewError(my.ER_NO_DB_ERROR, "select database before any query")
+		return
+	}
+	dbID = h.curDB
+	return
+}
+
+func detectColumnType(typeStr string) (typeByte uint8) {
+	typeStr = strings.ToUpper(typeStr)
+
+	if strings.Contains(typeStr, "INT") {
+		return my.MYSQL_TYPE_LONGLONG
+	} else if strings.Contains(typeStr, "CHAR") || strings.Contains(typeStr, "CLOB") ||
+		strings.Contains(typeStr, "TEXT") {
+		return my.MYSQL_TYPE_VAR_STRING
+	} else if strings.Contains(typeStr, "BLOB") || typeStr == "" {
+		return my.MYSQL_TYPE_LONG_BLOB
+	} else if strings.Contains(typeStr, "REAL") || strings.Contains(typeStr, "FLOA") ||
+		strings.Contains(typeStr, "DOUB") {
+		return my.MYSQL_TYPE_DOUBLE
+	} else if strings.Contains(typeStr, "BOOLEAN") {
+		return my.MYSQL_TYPE_BIT
+	} else if strings.Contains(typeStr, "TIMESTAMP") || strings.Contains(typeStr, "DATETIME") {
+		return my.MYSQL_TYPE_TIMESTAMP
+	} else if strings.Contains(typeStr, "TIME") {
+		return my.MYSQL_TYPE_TIME
+	} else if strings.Contains(typeStr, "DATE") {
+		return my.MYSQL_TYPE_DATE
+	}
+	return my.MYSQL_TYPE_LONG_BLOB
+}
+
+// handleTransaction buffers the writes between BEGIN and COMMIT and commits them in a single
+// batch, the buffered writes are discarded on ROLLBACK.
+func (h *Handler) handleTransaction(query string) (r *my.Result, processed bool, err error) {
+	processed = true
+
+	switch {
+	case beginQuery.MatchString(query):
+		h.inTx = true
+		h.txStmts = nil
+	case commitQuery.MatchString(query):
+		stmts := h.txStmts
+		h.inTx = false
+		h.txStmts = nil
+		if len(stmts) > 0 {
+			var dbID string
+			if dbID, err = h.ensureDatabase(); err != nil {
+				return
+			}
+			if err = h.storage.ExecBatch(dbID, stmts); err != nil {
+				err = wrapError(err)
+				return
+			}
+		}
+	case rollbackQuery.MatchString(query):
+		h.inTx = false
+		h.txStmts = nil
+	case savepointQuery.MatchString(query):
+		err = my.NewError(my.ER_NOT_SUPPORTED_YET, "savepoint is not supported yet")
+		return
+	default:
+		processed = false
+		return
+	}
+
+	r = newResult(nil)
+	return
+}
+
+// handleSpecialQuery answers the session and metadata queries sent by MySQL clients and ORMs on
+// connecting, which are not supported by the storage.
+func (h *Handler) handleSpecialQuery(query string) (r *my.Result, processed bool, err error) {
+	processed = true
+
+	if emptyResultQuery.MatchString(query) {
+		r = newResult(nil)
+	} else if emptyResultWithResultSetQuery.MatchString(query) {
+		var (
+			columns   []string
+			row       []interface{}
+			resultSet *my.Resultset
+		)
+		for k, v := range mysqlServerVariables {
+			if strings.Contains(query, k) {
+				columns = append(columns, k)
+				row = append(row, v)
+			}
+		}
+		if len(columns) == 0 {
+			columns = append(columns, "_")
+		}
+		if row != nil {
+			resultSet, _ = my.BuildSimpleTextResultset(columns, [][]interface{}{row})
+		} else {
+			resultSet, _ = my.BuildSimpleTextResultset(columns, [][]interface{}{})
+		}
+		if resultSet.RowDatas == nil {
+			// force non-empty result set
+			resultSet.RowDatas = make([]my.RowData, 0)
+		}
+		r = newResult(resultSet)
+	} else if showVariablesQuery.MatchString(query) {
+		var rows [][]interface{}
+		for k, v := range mysqlServerVariables {
+			rows = append(rows, []interface{}{k, v})
+		}
+		resultSet, _ := my.BuildSimpleTextResultset([]string{"Variable_name", "Value"}, rows)
+		r = newResult(resultSet)
+	} else if showDatabasesQuery.MatchString(query) {
+		var rows [][]interface{}
+		if h.curDB != "" {
+			rows = append(rows, []interface{}{h.curDB})
+		}
+		resultSet, _ := my.BuildSimpleTextResultset([]string{"Database"}, rows)
+		r = newResult(resultSet)
+	} else if matches := useDatabaseQuery.FindStringSubmatch(query); len(matches) > 1 {
+		if err = h.UseDB(matches[1]); err == nil {
+			r = newResult(nil)
+		}
+	} else if matches := specialSelectQuery.FindStringSubmatch(query); len(matches) > 1 {
+		var resultSet *my.Resultset
+		switch strings.ToUpper(matches[1]) {
+		case "DATABASE":
+			resultSet, _ = my.BuildSimpleTextResultset([]string{"DATABASE()"}, [][]interface{}{{h.curDB}})
+		case "USER":
+			resultSet, _ = my.BuildSimpleTextResultset([]string{"USER()"}, [][]interface{}{{h.user}})
+		}
+		r = newResult(resultSet)
+	} else {
+		processed = false
+	}
+
+	return
+}
+
+// UseDB handles COM_INIT_DB command, the database name is the database id.
+func (h *Handler) UseDB(dbName string) (err error) {
+	if !dbIDRegex.MatchString(dbName) {
+		return my.NewError(my.ER_BAD_DB_ERROR, fmt.Sprintf("invalid database: %v", dbName))
+	}
+	h.curDB = dbName
+	return
+}
+
+// HandleQuery handles COM_QUERY command, read queries are sent as storage queries with result
+// sets, others are sent as storage executions. Writes in transaction are buffered until COMMIT and
+// reads in transaction do not see the buffered writes.
+func (h *Handler) HandleQuery(query string) (r *my.Result, err error) {
+	var processed bool
+
+	log.WithField("query", query).Debug("received mysql query")
+
+	if r, processed, err = h.handleSpecialQuery(query); processed {
+		return
+	}
+	if r, processed, err = h.handleTransaction(query); processed {
+		return
+	}
+
+	var dbID string
+	if dbID, err = h.ensureDatabase(); err != nil {
+		return
+	}
+
+	if readQuery.MatchString(query) {
+		var (
+			columns []string
+			rows    [][]interface{}
+		)
+		if columns, _, rows, err = h.storage.Query(dbID, query); err != nil {
+			err = wrapError(err)
+			return
+		}
+		return h.buildResultSet(columns, rows)
+	}
+
+	if h.inTx {
+		// affected rows and insert id are unknown until commit
+		h.txStmts = append(h.txStmts, storage.Statement{Query: query})
+		r = newResult(nil)
+		return
+	}
+
+	var affectedRows, lastInsertID int64
+	if affectedRows, lastInsertID, err = h.storage.Exec(dbID, query); err != nil {
+		err = wrapError(err)
+		return
+	}
+
+	r = &my.Result{
+		Status:       0,
+		InsertId:     uint64(lastInsertID),
+		AffectedRows: uint64(affectedRows),
+		Resultset:    nil,
+	}
+	return
+}
+
+// HandleFieldList handles COM_FIELD_LIST command.
+func (h *Handler) HandleFieldList(table string, fieldWildcard string) (fields []*my.Field, err error) {
+	var dbID string
+	if dbID, err = h.ensureDatabase(); err != nil {
+		return
+	}
+
+	// DESC is supported by covenantsql storage, sqlite3 storage uses PRAGMA instead
+	var rows [][]interface{}
+	if _, _, rows, err = h.storage.Query(dbID, fmt.Sprintf("DESC `%s`", table)); err != nil {
+		if _, _, rows, err = h.storage.Query(dbID, fmt.Sprintf("PRAGMA table_info(`%s`)", table)); err != nil {
+			err = wrapError(err)
+			return
+		}
+	}
+
+	// transform the sql wildcard to glob pattern
+	var fieldGlob string
+	if fieldWildcard != "" {
+		fieldGlob = strings.NewReplacer("_", "?", "%", "*").Replace(fieldWildcard)
+	}
+
+	// rows of DESC are: cid, name, type, notnull, dflt_value, pk
+	for _, row := range rows {
+		if len(row) < 6 {
+			continue
+		}
+		columnName := fmt.Sprint(row[1])
+		if fieldGlob != "" {
+			if matched, _ := filepath.Match(fieldGlob, columnName); !matched {
+				continue
+			}
+		}
+
+		colFlag := uint16(0)
+		if isTrue(row[3]) {
+			colFlag |= my.NOT_NULL_FLAG
+		}
+		if isTrue(row[5]) {
+			colFlag |= my.NOT_NULL_FLAG
+			colFlag |= my.PRI_KEY_FLAG
+		}
+
+		fields = append(fields, &my.Field{
+			Name:         []byte(columnName),
+			OrgName:      []byte(columnName),
+			Table:        []byte(table),
+			OrgTable:     []byte(table),
+			Schema:       []byte(dbID),
+			Flag:         colFlag,
+			Charset:      uint16(my.DEFAULT_COLLATION_ID),
+			ColumnLength: 0, // no column length specified
+			Type:         detectColumnType(fmt.Sprint(row[2])),
+		})
+	}
+
+	return
+}
+
+func isTrue(v interface{}) bool {
+	switch value := v.(type) {
+	case bool:
+		return value
+	case int64:
+		return value != 0
+	case string:
+		return value != "" && value != "0"
+	}
+	return false
+}
+
+// HandleStmtPrepare handles COM_STMT_PREPARE command, server side prepared statements are not
+// supported, clients should prepare statements on client side instead.
+func (h *Handler) HandleStmtPrepare(query string) (params int, columns int, context interface{}, err error) {
+	err = my.NewError(my.ER_NOT_SUPPORTED_YET, "stmt prepare is not supported yet")
+	return
+}
+
+// HandleStmtExecute handles COM_STMT_EXECUTE command, which is not supported.
+func (h *Handler) HandleStmtExecute(context interface{}, query string, args []interface{}) (result *my.Result, err error) {
+	err = my.NewError(my.ER_NOT_SUPPORTED_YET, "stmt execute is not supported yet")
+	return
+}
+
+// HandleStmtClose handles COM_STMT_CLOSE command, this handler has no response.
+func (h *Handler) HandleStmtClose(context interface{}) (err error) {
+	return
+}
+
+// HandleOtherCommand handles the commands not handled by the library.
+func (h *Handler) HandleOtherCommand(cmd byte, data []byte) (err error) {
+	return my.NewError(my.ER_UNKNOWN_ERROR, fmt.Sprintf("command %d is not supported now", cmd))
+}
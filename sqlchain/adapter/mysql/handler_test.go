@@ -0,0 +1,168 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"testing"
+
+	my "github.com/siddontang/go-mysql/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/storage"
+)
+
+type fakeStorage struct {
+	queries []string
+	execs   []string
+	batches [][]storage.Statement
+}
+
+func (s *fakeStorage) Create(nodeCnt int) (dbID string, err error) { return }
+
+func (s *fakeStorage) Drop(dbID string) (err error) { return }
+
+func (s *fakeStorage) Query(dbID string, query string, args ...interface{}) (
+	columns []string, types []string, rows [][]interface{}, err error,
+) {
+	s.queries = append(s.queries, query)
+	return []string{"cid", "name", "type", "notnull", "dflt_value", "pk"}, nil, [][]interface{}{
+		{int64(0), "id", "INTEGER", int64(1), nil, int64(1)},
+		{int64(1), "name", "TEXT", int64(0), nil, int64(0)},
+	}, nil
+}
+
+func (s *fakeStorage) Exec(dbID string, query string, args ...interface{}) (
+	affectedRows int64, lastInsertID int64, err error,
+) {
+	s.execs = append(s.execs, query)
+	return 1, 2, nil
+}
+
+func (s *fakeStorage) ExecBatch(dbID string, stmts []storage.Statement) (err error) {
+	s.batches = append(s.batches, stmts)
+	return
+}
+
+func TestHandler_HandleQuery(t *testing.T) {
+	Convey("handler should answer queries", t, func() {
+		s := &fakeStorage{}
+		h := NewHandler(s, "root")
+
+		_, err := h.HandleQuery("SELECT * FROM t")
+		So(err, ShouldNotBeNil)
+		So(err.(*my.MyError).Code, ShouldEqual, my.ER_NO_DB_ERROR)
+
+		So(h.UseDB("bad-db"), ShouldNotBeNil)
+		r, err := h.HandleQuery("USE db")
+		So(err, ShouldBeNil)
+		So(r, ShouldNotBeNil)
+
+		Convey("special queries should not reach storage", func() {
+			r, err = h.HandleQuery("SELECT DATABASE()")
+			So(err, ShouldBeNil)
+			So(r.Resultset.RowDatas, ShouldHaveLength, 1)
+			r, err = h.HandleQuery("SELECT @@max_allowed_packet")
+			So(err, ShouldBeNil)
+			So(r.Resultset.Fields, ShouldHaveLength, 1)
+			_, err = h.HandleQuery("SET NAMES utf8")
+			So(err, ShouldBeNil)
+			So(s.queries, ShouldBeEmpty)
+			So(s.execs, ShouldBeEmpty)
+		})
+		Convey("reads and writes should be sent to storage", func() {
+			r, err = h.HandleQuery("SELECT * FROM t")
+			So(err, ShouldBeNil)
+			So(r.Resultset.RowDatas, ShouldHaveLength, 2)
+			r, err = h.HandleQuery("INSERT INTO t VALUES (1)")
+			So(err, ShouldBeNil)
+			So(r.AffectedRows, ShouldEqual, 1)
+			So(r.InsertId, ShouldEqual, 2)
+			So(s.execs, ShouldResemble, []string{"INSERT INTO t VALUES (1)"})
+		})
+		Convey("rollback should discard the buffered writes", func() {
+			_, err = h.HandleQuery("BEGIN")
+			So(err, ShouldBeNil)
+			_, err = h.HandleQuery("INSERT INTO t VALUES (1)")
+			So(err, ShouldBeNil)
+			_, err = h.HandleQuery("ROLLBACK")
+			So(err, ShouldBeNil)
+			So(s.execs, ShouldBeEmpty)
+			So(s.batches, ShouldBeEmpty)
+
+			// statement is committed on execution after transaction ends
+			_, err = h.HandleQuery("INSERT INTO t VALUES (2)")
+			So(err, ShouldBeNil)
+			So(s.execs, ShouldHaveLength, 1)
+		})
+		Convey("commit should send the buffered writes in a batch", func() {
+			_, err = h.HandleQuery("START TRANSACTION")
+			So(err, ShouldBeNil)
+			_, err = h.HandleQuery("INSERT INTO t VALUES (1)")
+			So(err, ShouldBeNil)
+			_, err = h.HandleQuery("DELETE FROM t")
+			So(err, ShouldBeNil)
+			So(s.execs, ShouldBeEmpty)
+			_, err = h.HandleQuery("COMMIT")
+			So(err, ShouldBeNil)
+			So(s.batches, ShouldHaveLength, 1)
+			So(s.batches[0], ShouldResemble, []storage.Statement{
+				{Query: "INSERT INTO t VALUES (1)"},
+				{Query: "DELETE FROM t"},
+			})
+		})
+		Convey("rollback to savepoint should be rejected", func() {
+			_, err = h.HandleQuery("BEGIN")
+			So(err, ShouldBeNil)
+			_, err = h.HandleQuery("ROLLBACK TO SAVEPOINT sp")
+			So(err, ShouldNotBeNil)
+			So(err.(*my.MyError).Code, ShouldEqual, my.ER_NOT_SUPPORTED_YET)
+			So(h.inTx, ShouldBeTrue)
+		})
+	})
+}
+
+func TestHandler_HandleFieldList(t *testing.T) {
+	Convey("field list should be built from table description", t, func() {
+		h := NewHandler(&fakeStorage{}, "root")
+		_, err := h.HandleFieldList("t", "")
+		So(err, ShouldNotBeNil)
+
+		So(h.UseDB("db"), ShouldBeNil)
+		fields, err := h.HandleFieldList("t", "")
+		So(err, ShouldBeNil)
+		So(fields, ShouldHaveLength, 2)
+		So(fields[0].Flag&my.PRI_KEY_FLAG, ShouldNotEqual, 0)
+		So(fields[0].Type, ShouldEqual, my.MYSQL_TYPE_LONGLONG)
+		So(fields[1].Type, ShouldEqual, my.MYSQL_TYPE_VAR_STRING)
+
+		fields, err = h.HandleFieldList("t", "n%")
+		So(err, ShouldBeNil)
+		So(fields, ShouldHaveLength, 1)
+		So(string(fields[0].Name), ShouldEqual, "name")
+	})
+}
+
+func TestDetectColumnType(t *testing.T) {
+	Convey("column types should be detected by sqlite affinity", t, func() {
+		So(detectColumnType("bigint"), ShouldEqual, my.MYSQL_TYPE_LONGLONG)
+		So(detectColumnType("VARCHAR(10)"), ShouldEqual, my.MYSQL_TYPE_VAR_STRING)
+		So(detectColumnType("DOUBLE"), ShouldEqual, my.MYSQL_TYPE_DOUBLE)
+		So(detectColumnType("DATETIME"), ShouldEqual, my.MYSQL_TYPE_TIMESTAMP)
+		So(detectColumnType("DATE"), ShouldEqual, my.MYSQL_TYPE_DATE)
+		So(detectColumnType(""), ShouldEqual, my.MYSQL_TYPE_LONG_BLOB)
+	})
+}
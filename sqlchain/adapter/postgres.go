@@ -0,0 +1,111 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adapter
+
+import (
+	"net"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/config"
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/postgres"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// PostgresAdapter serves the adapter storage using PostgreSQL v3 protocol, so psql and the
+// PostgreSQL drivers can connect without code changes.
+type PostgresAdapter struct {
+	listenAddr string
+	listener   net.Listener
+	user       string
+	password   string
+	conns      sync.Map // net.Conn -> struct{}
+}
+
+// NewPostgresAdapter creates PostgreSQL protocol adapter on listenAddr, the adapter config
+// should be loaded by NewHTTPAdapter first. PostgresListenAddr of config is used if listenAddr
+// is empty.
+func NewPostgresAdapter(listenAddr string) (adapter *PostgresAdapter, err error) {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		err = config.ErrConfigNotLoaded
+		return
+	}
+	if listenAddr == "" {
+		listenAddr = cfg.PostgresListenAddr
+	}
+	if listenAddr == "" {
+		err = config.ErrMissingPostgresListenAddr
+		return
+	}
+
+	adapter = &PostgresAdapter{
+		listenAddr: listenAddr,
+		user:       cfg.PostgresUser,
+		password:   cfg.PostgresPassword,
+	}
+	return
+}
+
+// Serve defines adapter serve logic.
+func (adapter *PostgresAdapter) Serve() (err error) {
+	if adapter.listener, err = net.Listen("tcp", adapter.listenAddr); err != nil {
+		return
+	}
+
+	go func() {
+		for {
+			conn, err := adapter.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go adapter.handleConn(conn)
+		}
+	}()
+
+	return
+}
+
+func (adapter *PostgresAdapter) handleConn(conn net.Conn) {
+	adapter.conns.Store(conn, struct{}{})
+	defer adapter.conns.Delete(conn)
+	defer conn.Close()
+	defer func() {
+		if p := recover(); p != nil {
+			log.WithFields(log.Fields{
+				"remote": conn.RemoteAddr(),
+				"panic":  p,
+			}).Error("postgresql connection panicked")
+		}
+	}()
+
+	c := postgres.NewConn(conn, config.GetConfig().StorageInstance, adapter.user, adapter.password)
+	if err := c.Serve(); err != nil {
+		log.WithField("remote", conn.RemoteAddr()).WithError(err).Debug("postgresql connection closed")
+	}
+}
+
+// Shutdown shutdown the service.
+func (adapter *PostgresAdapter) Shutdown() {
+	if adapter.listener != nil {
+		adapter.listener.Close()
+	}
+	adapter.conns.Range(func(k, _ interface{}) bool {
+		k.(net.Conn).Close()
+		return true
+	})
+}
@@ -0,0 +1,779 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package postgres
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/storage"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// ServerVersion is the PostgreSQL server version reported to clients.
+const ServerVersion = "9.6.0"
+
+var (
+	dbIDRegex       = regexp.MustCompile("^[a-zA-Z0-9_\\.]+$")
+	readQuery       = regexp.MustCompile("^(?i)\\s*(?:SELECT|SHOW|DESC|WITH|VALUES|EXPLAIN)\\b")
+	selectQuery     = regexp.MustCompile("^(?i)\\s*SELECT\\b")
+	beginQuery      = regexp.MustCompile("^(?i)\\s*(?:BEGIN|START\\s+TRANSACTION)\\b")
+	commitQuery     = regexp.MustCompile("^(?i)\\s*(?:COMMIT|END)\\b")
+	rollbackQuery   = regexp.MustCompile("^(?i)\\s*(?:ROLLBACK|ABORT)(?:\\s+(?:WORK|TRANSACTION))?\\s*$")
+	savepointQuery  = regexp.MustCompile("^(?i)\\s*(?:SAVEPOINT|RELEASE|ROLLBACK\\s+(?:(?:WORK|TRANSACTION)\\s+)?TO)\\b")
+	noopQuery       = regexp.MustCompile("^(?i)\\s*(SET|RESET|DISCARD|DEALLOCATE)\\b")
+	showParamQuery  = regexp.MustCompile("^(?i)\\s*SHOW\\s+(\\w+)\\s*$")
+	specialFunction = regexp.MustCompile(
+		"^(?i)\\s*SELECT\\s+(version|current_database|current_schema|current_user|session_user|user)\\s*(?:\\(\\s*\\))?\\s*$")
+
+	// serverParams are sent as ParameterStatus on startup and answered to SHOW queries.
+	serverParams = [][2]string{
+		{"server_version", ServerVersion},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"IntervalStyle", "postgres"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+		{"transaction_isolation", "serializable"},
+	}
+)
+
+// Error is the error sent to client as ErrorResponse.
+type Error struct {
+	Code    string // SQLSTATE code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code string, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type column struct {
+	name string
+	oid  uint32
+}
+
+type result struct {
+	columns []column
+	rows    [][]interface{}
+	tag     string
+}
+
+type statement struct {
+	raw       string   // original query
+	query     string   // query with SQLite placeholders
+	order     []int    // parameter index of each placeholder
+	params    []uint32 // parameter type oids
+	columns   []column // result columns of statement description
+	described bool
+}
+
+type portal struct {
+	stmt    *statement
+	args    []interface{}
+	formats []int16
+	result  *result // result executed on portal description
+}
+
+// Conn serves a PostgreSQL frontend connection, the database of the startup message is used as
+// the database id of storage.
+type Conn struct {
+	conn     net.Conn
+	rd       *bufio.Reader
+	w        *writer
+	storage  storage.Storage
+	user     string
+	password string
+	dbID     string
+	inTx     bool
+	txStmts  []storage.Statement // writes buffered in transaction, sent as a batch on commit
+	stmts    map[string]*statement
+	portals  map[string]*portal
+	skipSync bool // discard extended query messages until Sync on error
+}
+
+// NewConn returns a new PostgreSQL connection handler authenticating user with password.
+func NewConn(conn net.Conn, s storage.Storage, user string, password string) *Conn {
+	return &Conn{
+		conn:     conn,
+		rd:       bufio.NewReader(conn),
+		w:        newWriter(conn),
+		storage:  s,
+		user:     user,
+		password: password,
+		stmts:    make(map[string]*statement),
+		portals:  make(map[string]*portal),
+	}
+}
+
+// Serve runs the startup and authentication flow, then serves the queries until the client
+// terminates or the connection is closed.
+func (c *Conn) Serve() (err error) {
+	if err = c.startup(); err != nil {
+		return
+	}
+
+	for {
+		var (
+			typ     byte
+			payload []byte
+		)
+		if typ, payload, err = readMessage(c.rd, maxMessageSize); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if typ == 'X' {
+			return
+		}
+		if err = c.handleMessage(typ, payload); err != nil {
+			return
+		}
+	}
+}
+
+func (c *Conn) startup() (err error) {
+	var (
+		code    uint32
+		payload []byte
+	)
+	for {
+		if code, payload, err = readStartup(c.rd); err != nil {
+			return
+		}
+		if code != sslRequestCode {
+			break
+		}
+		// SSL is not supported, client continues without SSL
+		if _, err = c.conn.Write([]byte{'N'}); err != nil {
+			return
+		}
+	}
+	if code == cancelCode {
+		return errors.New("cancel request is not supported")
+	}
+	if code != protocolVersion {
+		err = newError("0A000", "unsupported frontend protocol %d.%d", code>>16, code&0xffff)
+		return c.fatal(err)
+	}
+
+	params := make(map[string]string)
+	r := &reader{buf: payload}
+	for len(r.buf) > 1 && r.err == nil {
+		key := r.string()
+		params[key] = r.string()
+	}
+
+	if params["user"] != c.user {
+		return c.fatal(newError("28000", "role %q does not exist", params["user"]))
+	}
+	if err = c.authenticate(); err != nil {
+		return
+	}
+
+	c.dbID = params["database"]
+	if !dbIDRegex.MatchString(c.dbID) {
+		return c.fatal(newError("3D000", "invalid database: %v", c.dbID))
+	}
+
+	c.w.start('R')
+	c.w.int32(0) // AuthenticationOk
+	_ = c.w.end()
+	for _, p := range serverParams {
+		c.w.start('S')
+		c.w.string(p[0])
+		c.w.string(p[1])
+		_ = c.w.end()
+	}
+	var key [8]byte
+	_, _ = rand.Read(key[:])
+	c.w.start('K')
+	c.w.bytes(key[:])
+	_ = c.w.end()
+	return c.readyForQuery()
+}
+
+// authenticate requests MD5 password authentication.
+func (c *Conn) authenticate() (err error) {
+	var salt [4]byte
+	if _, err = rand.Read(salt[:]); err != nil {
+		return
+	}
+	c.w.start('R')
+	c.w.int32(5) // AuthenticationMD5Password
+	c.w.bytes(salt[:])
+	if err = c.w.end(); err != nil {
+		return
+	}
+	if err = c.w.flush(); err != nil {
+		return
+	}
+
+	var (
+		typ     byte
+		payload []byte
+	)
+	if typ, payload, err = readMessage(c.rd, maxStartupSize); err != nil {
+		return
+	}
+	r := &reader{buf: payload}
+	if password := r.string(); typ != 'p' || password != md5Password(c.user, c.password, salt[:]) {
+		return c.fatal(newError("28P01", "password authentication failed for user %q", c.user))
+	}
+	return
+}
+
+func md5Password(user string, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// fatal sends the error to client and returns it to close the connection.
+func (c *Conn) fatal(err error) error {
+	c.writeError(err, "FATAL")
+	_ = c.w.flush()
+	return err
+}
+
+func (c *Conn) writeError(err error, severity string) {
+	pgErr, ok := err.(*Error)
+	if !ok {
+		pgErr = &Error{Code: "XX000", Message: err.Error()}
+	}
+	c.w.start('E')
+	c.w.byte('S')
+	c.w.string(severity)
+	c.w.byte('V')
+	c.w.string(severity)
+	c.w.byte('C')
+	c.w.string(pgErr.Code)
+	c.w.byte('M')
+	c.w.string(pgErr.Message)
+	c.w.byte(0)
+	_ = c.w.end()
+}
+
+func (c *Conn) readyForQuery() error {
+	c.w.start('Z')
+	if c.inTx {
+		c.w.byte('T')
+	} else {
+		c.w.byte('I')
+	}
+	_ = c.w.end()
+	return c.w.flush()
+}
+
+func (c *Conn) handleMessage(typ byte, payload []byte) (err error) {
+	if typ == 'Q' {
+		return c.handleSimpleQuery(payload)
+	}
+	if typ == 'S' {
+		c.skipSync = false
+		return c.readyForQuery()
+	}
+	if typ == 'H' {
+		return c.w.flush()
+	}
+	if c.skipSync {
+		return
+	}
+
+	r := &reader{buf: payload}
+	switch typ {
+	case 'P':
+		err = c.handleParse(r)
+	case 'B':
+		err = c.handleBind(r)
+	case 'D':
+		err = c.handleDescribe(r)
+	case 'E':
+		err = c.handleExecute(r)
+	case 'C':
+		err = c.handleClose(r)
+	default:
+		err = newError("0A000", "unsupported message type %q", typ)
+	}
+	if err == nil && r.err != nil {
+		err = newError("08P01", "%v", r.err)
+	}
+	if err != nil {
+		log.WithError(err).Debug("postgres extended query failed")
+		c.writeError(err, "ERROR")
+		c.skipSync = true
+	}
+	return nil
+}
+
+func (c *Conn) handleSimpleQuery(payload []byte) (err error) {
+	r := &reader{buf: payload}
+	query := r.string()
+	if r.err != nil {
+		return c.fatal(newError("08P01", "%v", r.err))
+	}
+
+	log.WithField("query", query).Debug("received postgres query")
+
+	for _, q := range splitStatements(query) {
+		if strings.TrimSpace(q) == "" {
+			c.w.start('I') // EmptyQueryResponse
+			_ = c.w.end()
+			continue
+		}
+
+		stmt := newStatement(q, nil)
+		var res *result
+		if res, err = c.execute(stmt, nil); err != nil {
+			c.writeError(err, "ERROR")
+			break
+		}
+		if res.columns != nil {
+			c.writeRowDescription(res.columns, nil)
+		}
+		if err = c.writeResult(res, nil); err != nil {
+			c.writeError(err, "ERROR")
+			break
+		}
+	}
+	return c.readyForQuery()
+}
+
+func newStatement(raw string, params []uint32) (stmt *statement) {
+	stmt = &statement{raw: raw}
+	var count int
+	stmt.query, stmt.order, count = rewriteQuery(strings.TrimRight(strings.TrimSpace(raw), ";"))
+	stmt.params = make([]uint32, count)
+	copy(stmt.params, params)
+	return
+}
+
+func (c *Conn) handleParse(r *reader) (err error) {
+	name := r.string()
+	query := r.string()
+	params := make([]uint32, r.count(4))
+	for i := range params {
+		params[i] = uint32(r.int32())
+	}
+	if r.err != nil {
+		return
+	}
+
+	c.stmts[name] = newStatement(query, params)
+	c.w.start('1') // ParseComplete
+	return c.w.end()
+}
+
+func (c *Conn) handleBind(r *reader) (err error) {
+	portalName := r.string()
+	stmtName := r.string()
+	paramFormats := make([]int16, r.count(2))
+	for i := range paramFormats {
+		paramFormats[i] = r.int16()
+	}
+	values := make([][]byte, r.count(4))
+	for i := range values {
+		if size := r.int32(); size >= 0 {
+			values[i] = r.bytes(int(size))
+		}
+	}
+	resultFormats := make([]int16, r.count(2))
+	for i := range resultFormats {
+		resultFormats[i] = r.int16()
+	}
+	if r.err != nil {
+		return
+	}
+
+	stmt, ok := c.stmts[stmtName]
+	if !ok {
+		return newError("26000", "prepared statement %q does not exist", stmtName)
+	}
+	if len(values) != len(stmt.params) {
+		return newError("08P01", "bind message supplies %d parameters, but prepared statement requires %d",
+			len(values), len(stmt.params))
+	}
+
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		if args[i], err = decodeParam(stmt.params[i], formatCode(paramFormats, i) == 1, v); err != nil {
+			return newError("22P02", "invalid parameter $%d: %v", i+1, err)
+		}
+	}
+
+	c.portals[portalName] = &portal{stmt: stmt, args: args, formats: resultFormats}
+	c.w.start('2') // BindComplete
+	return c.w.end()
+}
+
+func formatCode(formats []int16, i int) int16 {
+	switch {
+	case len(formats) == 0:
+		return 0
+	case len(formats) == 1:
+		return formats[0]
+	case i < len(formats):
+		return formats[i]
+	}
+	return 0
+}
+
+func (c *Conn) handleDescribe(r *reader) (err error) {
+	typ := r.byte()
+	name := r.string()
+	if r.err != nil {
+		return
+	}
+
+	switch typ {
+	case 'S':
+		stmt, ok := c.stmts[name]
+		if !ok {
+			return newError("26000", "prepared statement %q does not exist", name)
+		}
+		c.w.start('t') // ParameterDescription
+		c.w.int16(int16(len(stmt.params)))
+		for _, oid := range stmt.params {
+			if oid == 0 {
+				oid = oidText
+			}
+			c.w.int32(int32(oid))
+		}
+		_ = c.w.end()
+		if err = c.describeStatement(stmt); err != nil {
+			return
+		}
+		if stmt.columns == nil {
+			return c.writeNoData()
+		}
+		c.writeRowDescription(stmt.columns, nil)
+		return
+	case 'P':
+		p, ok := c.portals[name]
+		if !ok {
+			return newError("34000", "portal %q does not exist", name)
+		}
+		if !isRead(p.stmt) {
+			return c.writeNoData()
+		}
+		if p.result == nil {
+			if p.result, err = c.execute(p.stmt, p.args); err != nil {
+				return
+			}
+		}
+		c.writeRowDescription(p.result.columns, p.formats)
+		return
+	}
+	return newError("08P01", "invalid describe type %q", typ)
+}
+
+func (c *Conn) writeNoData() error {
+	c.w.start('n')
+	return c.w.end()
+}
+
+func isRead(stmt *statement) bool {
+	return readQuery.MatchString(stmt.query)
+}
+
+// describeStatement gets the result columns of the read statement without the parameters.
+func (c *Conn) describeStatement(stmt *statement) (err error) {
+	if stmt.described || !isRead(stmt) {
+		return
+	}
+	if res, ok := c.specialRead(stmt.query); ok {
+		stmt.columns = res.columns
+		stmt.described = true
+		return
+	}
+
+	args := make([]interface{}, len(stmt.order))
+	var (
+		columns []string
+		types   []string
+	)
+	if selectQuery.MatchString(stmt.query) {
+		columns, types, _, err = c.storage.Query(c.dbID, "SELECT * FROM ("+stmt.query+") LIMIT 0", args...)
+	}
+	if columns == nil {
+		if columns, types, _, err = c.storage.Query(c.dbID, stmt.query, args...); err != nil {
+			return
+		}
+	}
+
+	stmt.columns = make([]column, len(columns))
+	for i, name := range columns {
+		var declType string
+		if i < len(types) {
+			declType = types[i]
+		}
+		stmt.columns[i] = column{name: name, oid: typeOID(declType, nil)}
+	}
+	stmt.described = true
+	return
+}
+
+func (c *Conn) handleExecute(r *reader) (err error) {
+	name := r.string()
+	_ = r.int32() // max rows, all rows are returned
+	if r.err != nil {
+		return
+	}
+
+	p, ok := c.portals[name]
+	if !ok {
+		return newError("34000", "portal %q does not exist", name)
+	}
+	res := p.result
+	p.result = nil
+	if res == nil {
+		if res, err = c.execute(p.stmt, p.args); err != nil {
+			return
+		}
+		if p.stmt.described && len(p.stmt.columns) == len(res.columns) {
+			// keep the column types sent in statement description
+			res.columns = p.stmt.columns
+		}
+	}
+	return c.writeResult(res, p.formats)
+}
+
+func (c *Conn) handleClose(r *reader) (err error) {
+	typ := r.byte()
+	name := r.string()
+	if r.err != nil {
+		return
+	}
+	if typ == 'S' {
+		delete(c.stmts, name)
+	} else {
+		delete(c.portals, name)
+	}
+	c.w.start('3') // CloseComplete
+	return c.w.end()
+}
+
+// specialRead answers the session information queries which are not supported by storage.
+func (c *Conn) specialRead(query string) (res *result, ok bool) {
+	var name, value string
+	if m := specialFunction.FindStringSubmatch(query); m != nil {
+		name = strings.ToLower(m[1])
+		switch name {
+		case "version":
+			value = "PostgreSQL " + ServerVersion + " on CovenantSQL"
+		case "current_database":
+			value = c.dbID
+		case "current_schema":
+			value = "public"
+		default:
+			value = c.user
+		}
+	} else if m := showParamQuery.FindStringSubmatch(query); m != nil {
+		for _, p := range serverParams {
+			if strings.EqualFold(p[0], m[1]) {
+				name, value = p[0], p[1]
+			}
+		}
+		if name == "" {
+			return
+		}
+	} else {
+		return
+	}
+
+	res = &result{
+		columns: []column{{name: name, oid: oidText}},
+		rows:    [][]interface{}{{value}},
+		tag:     "SELECT 1",
+	}
+	return res, true
+}
+
+// execute runs the statement on storage. The writes in transaction are buffered and committed
+// in a single batch on COMMIT, reads in transaction do not see the buffered writes.
+func (c *Conn) execute(stmt *statement, params []interface{}) (res *result, err error) {
+	if res, ok := c.specialRead(stmt.query); ok {
+		return res, nil
+	}
+	switch {
+	case beginQuery.MatchString(stmt.query):
+		c.inTx = true
+		c.txStmts = nil
+		return &result{tag: "BEGIN"}, nil
+	case commitQuery.MatchString(stmt.query):
+		stmts := c.txStmts
+		c.inTx = false
+		c.txStmts = nil
+		if len(stmts) > 0 {
+			if err = c.storage.ExecBatch(c.dbID, stmts); err != nil {
+				return
+			}
+		}
+		return &result{tag: "COMMIT"}, nil
+	case rollbackQuery.MatchString(stmt.query):
+		c.inTx = false
+		c.txStmts = nil
+		return &result{tag: "ROLLBACK"}, nil
+	case savepointQuery.MatchString(stmt.query):
+		return nil, newError("0A000", "savepoint is not supported")
+	}
+	if m := noopQuery.FindStringSubmatch(stmt.query); m != nil {
+		return &result{tag: strings.ToUpper(m[1])}, nil
+	}
+
+	args := make([]interface{}, len(stmt.order))
+	for i, idx := range stmt.order {
+		if idx >= len(params) {
+			return nil, newError("08P01", "missing parameter $%d", idx+1)
+		}
+		args[i] = params[idx]
+	}
+
+	if isRead(stmt) {
+		var (
+			columns []string
+			types   []string
+			rows    [][]interface{}
+		)
+		if columns, types, rows, err = c.storage.Query(c.dbID, stmt.query, args...); err != nil {
+			return
+		}
+		res = &result{
+			columns: make([]column, len(columns)),
+			rows:    rows,
+			tag:     fmt.Sprintf("SELECT %d", len(rows)),
+		}
+		for i, name := range columns {
+			var (
+				declType string
+				value    interface{}
+			)
+			if i < len(types) {
+				declType = types[i]
+			}
+			for _, row := range rows {
+				if row[i] != nil {
+					value = row[i]
+					break
+				}
+			}
+			res.columns[i] = column{name: name, oid: typeOID(declType, value)}
+		}
+		return
+	}
+
+	if c.inTx {
+		// affected rows are unknown until commit
+		c.txStmts = append(c.txStmts, storage.Statement{Query: stmt.query, Args: args})
+		res = &result{tag: commandTag(stmt.query, 0)}
+		return
+	}
+
+	var affected int64
+	if affected, _, err = c.storage.Exec(c.dbID, stmt.query, args...); err != nil {
+		return
+	}
+	res = &result{tag: commandTag(stmt.query, affected)}
+	return
+}
+
+func commandTag(query string, affected int64) string {
+	fields := strings.Fields(strings.ToUpper(query))
+	if len(fields) == 0 {
+		return ""
+	}
+	switch fields[0] {
+	case "INSERT":
+		return fmt.Sprintf("INSERT 0 %d", affected)
+	case "UPDATE", "DELETE", "REPLACE":
+		return fmt.Sprintf("%s %d", fields[0], affected)
+	case "CREATE", "DROP", "ALTER":
+		if len(fields) > 2 && (fields[1] == "UNIQUE" || fields[1] == "TEMP" || fields[1] == "TEMPORARY") {
+			return fields[0] + " " + fields[2]
+		}
+		if len(fields) > 1 {
+			return fields[0] + " " + fields[1]
+		}
+	}
+	return fields[0]
+}
+
+func (c *Conn) writeRowDescription(columns []column, formats []int16) {
+	c.w.start('T')
+	c.w.int16(int16(len(columns)))
+	for i, col := range columns {
+		c.w.string(col.name)
+		c.w.int32(0) // table oid
+		c.w.int16(0) // column attribute number
+		c.w.int32(int32(col.oid))
+		c.w.int16(typeSize(col.oid))
+		c.w.int32(-1) // type modifier
+		c.w.int16(formatCode(formats, i))
+	}
+	_ = c.w.end()
+}
+
+func (c *Conn) writeResult(res *result, formats []int16) (err error) {
+	for _, row := range res.rows {
+		c.w.start('D')
+		c.w.int16(int16(len(row)))
+		for i, v := range row {
+			var (
+				b   []byte
+				oid uint32 = oidText
+			)
+			if i < len(res.columns) {
+				oid = res.columns[i].oid
+			}
+			if formatCode(formats, i) == 1 {
+				if b, err = encodeBinary(oid, v); err != nil {
+					return newError("22P03", "encode column %d failed: %v", i+1, err)
+				}
+			} else {
+				b = encodeText(oid, v)
+			}
+			if b == nil {
+				c.w.int32(-1)
+				continue
+			}
+			c.w.int32(int32(len(b)))
+			c.w.bytes(b)
+		}
+		if err = c.w.end(); err != nil {
+			return
+		}
+	}
+	c.w.start('C')
+	c.w.string(res.tag)
+	return c.w.end()
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package postgres
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/storage"
+)
+
+const (
+	testUser     = "postgres"
+	testPassword = "secret"
+	testDB       = "db"
+)
+
+type fakeStorage struct {
+	sync.Mutex
+	execs   []string
+	batches [][]storage.Statement
+}
+
+func (s *fakeStorage) Create(nodeCnt int) (dbID string, err error) { return }
+
+func (s *fakeStorage) Drop(dbID string) (err error) { return }
+
+func (s *fakeStorage) Query(dbID string, query string, args ...interface{}) (
+	columns []string, types []string, rows [][]interface{}, err error,
+) {
+	return []string{"v"}, []string{"INTEGER"}, [][]interface{}{{int64(1)}}, nil
+}
+
+func (s *fakeStorage) Exec(dbID string, query string, args ...interface{}) (
+	affectedRows int64, lastInsertID int64, err error,
+) {
+	s.Lock()
+	defer s.Unlock()
+	s.execs = append(s.execs, query)
+	return 1, 0, nil
+}
+
+func (s *fakeStorage) ExecBatch(dbID string, stmts []storage.Statement) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.batches = append(s.batches, stmts)
+	return
+}
+
+// testClient is the frontend side of a connection served by Conn.
+type testClient struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	wch  chan []byte
+	done chan error
+}
+
+func newTestClient(s storage.Storage) *testClient {
+	client, server := net.Pipe()
+	tc := &testClient{
+		conn: client,
+		rd:   bufio.NewReader(client),
+		wch:  make(chan []byte, 16),
+		done: make(chan error, 1),
+	}
+	go func() {
+		tc.done <- NewConn(server, s, testUser, testPassword).Serve()
+		_ = server.Close()
+	}()
+	// writes are sent in order without blocking the reads of responses
+	go func() {
+		for b := range tc.wch {
+			if _, err := client.Write(b); err != nil {
+				return
+			}
+		}
+	}()
+	return tc
+}
+
+func (tc *testClient) close() {
+	close(tc.wch)
+	_ = tc.conn.Close()
+}
+
+func (tc *testClient) writeRaw(b []byte) {
+	tc.wch <- b
+}
+
+func (tc *testClient) send(typ byte, payload []byte) {
+	msg := make([]byte, 5, 5+len(payload))
+	msg[0] = typ
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(payload)+4))
+	tc.writeRaw(append(msg, payload...))
+}
+
+func (tc *testClient) recv() (typ byte, payload []byte, err error) {
+	return readMessage(tc.rd, maxMessageSize)
+}
+
+// recvUntil reads messages until a message of typ is received, the message types are returned.
+func (tc *testClient) recvUntil(typ byte) (types []byte, err error) {
+	for {
+		var t byte
+		if t, _, err = tc.recv(); err != nil {
+			return
+		}
+		types = append(types, t)
+		if t == typ {
+			return
+		}
+	}
+}
+
+func startupPacket(params ...string) []byte {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint32(payload[4:], protocolVersion)
+	for _, p := range params {
+		payload = append(append(payload, p...), 0)
+	}
+	payload = append(payload, 0)
+	binary.BigEndian.PutUint32(payload, uint32(len(payload)))
+	return payload
+}
+
+func (tc *testClient) login() (err error) {
+	tc.writeRaw(startupPacket("user", testUser, "database", testDB))
+	var payload []byte
+	if _, payload, err = tc.recv(); err != nil {
+		return
+	}
+	tc.send('p', append([]byte(md5Password(testUser, testPassword, payload[4:8])), 0))
+	_, err = tc.recvUntil('Z')
+	return
+}
+
+func (tc *testClient) query(q string) (types []byte, err error) {
+	tc.send('Q', append([]byte(q), 0))
+	return tc.recvUntil('Z')
+}
+
+func int16Bytes(v int16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func TestConn_Startup(t *testing.T) {
+	Convey("startup packet exceeding the startup limit should be rejected", t, func() {
+		tc := newTestClient(&fakeStorage{})
+		defer tc.close()
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header, maxStartupSize+1)
+		binary.BigEndian.PutUint32(header[4:], protocolVersion)
+		tc.writeRaw(header)
+		So(<-tc.done, ShouldEqual, ErrMessageTooLarge)
+	})
+	Convey("startup packet shorter than its header should be rejected", t, func() {
+		tc := newTestClient(&fakeStorage{})
+		defer tc.close()
+		tc.writeRaw([]byte{0, 0, 0, 4, 0, 3, 0, 0})
+		So(<-tc.done, ShouldEqual, ErrMessageTooLarge)
+	})
+	Convey("password message exceeding the startup limit should be rejected", t, func() {
+		tc := newTestClient(&fakeStorage{})
+		defer tc.close()
+		tc.writeRaw(startupPacket("user", testUser, "database", testDB))
+		_, _, err := tc.recv()
+		So(err, ShouldBeNil)
+		header := []byte{'p', 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(header[1:], maxStartupSize+1)
+		tc.writeRaw(header)
+		So(<-tc.done, ShouldEqual, ErrMessageTooLarge)
+	})
+	Convey("unknown user should be rejected", t, func() {
+		tc := newTestClient(&fakeStorage{})
+		defer tc.close()
+		tc.writeRaw(startupPacket("user", "nobody", "database", testDB))
+		typ, _, err := tc.recv()
+		So(err, ShouldBeNil)
+		So(typ, ShouldEqual, 'E')
+		So(<-tc.done, ShouldNotBeNil)
+	})
+}
+
+func TestConn_MalformedExtendedQuery(t *testing.T) {
+	Convey("malformed extended query messages should be reported without panic", t, func() {
+		tc := newTestClient(&fakeStorage{})
+		defer tc.close()
+		So(tc.login(), ShouldBeNil)
+
+		expectError := func(typ byte, payload []byte) {
+			tc.send(typ, payload)
+			tc.send('S', nil)
+			types, err := tc.recvUntil('Z')
+			So(err, ShouldBeNil)
+			So(types, ShouldResemble, []byte{'E', 'Z'})
+		}
+
+		Convey("parse with negative parameter count", func() {
+			payload := append([]byte("s\x00SELECT 1\x00"), int16Bytes(-1)...)
+			expectError('P', payload)
+		})
+		Convey("parse with parameter count exceeding payload", func() {
+			payload := append([]byte("s\x00SELECT $1\x00"), int16Bytes(1000)...)
+			expectError('P', payload)
+		})
+		Convey("bind with negative format count", func() {
+			payload := append([]byte("\x00\x00"), int16Bytes(-2)...)
+			expectError('B', payload)
+		})
+		Convey("bind with negative value count", func() {
+			payload := append([]byte("\x00\x00"), int16Bytes(0)...)
+			payload = append(payload, int16Bytes(-32768)...)
+			expectError('B', payload)
+		})
+		Convey("bind with value count exceeding payload", func() {
+			payload := append([]byte("\x00\x00"), int16Bytes(0)...)
+			payload = append(payload, int16Bytes(32767)...)
+			expectError('B', payload)
+		})
+		Convey("bind with truncated value", func() {
+			payload := append([]byte("\x00\x00"), int16Bytes(0)...)
+			payload = append(payload, int16Bytes(1)...)
+			payload = append(payload, 0, 0, 0, 100, 'x')
+			expectError('B', payload)
+		})
+		Convey("bind with negative result format count", func() {
+			payload := append([]byte("\x00\x00"), int16Bytes(0)...)
+			payload = append(payload, int16Bytes(0)...)
+			payload = append(payload, int16Bytes(-1)...)
+			expectError('B', payload)
+		})
+		Convey("parse without terminated strings", func() {
+			expectError('P', []byte("s"))
+		})
+
+		// connection is still usable after the errors
+		types, err := tc.query("SELECT 1")
+		So(err, ShouldBeNil)
+		So(types, ShouldResemble, []byte{'T', 'D', 'C', 'Z'})
+	})
+}
+
+func TestConn_Transaction(t *testing.T) {
+	Convey("writes in transaction should be buffered until commit", t, func() {
+		s := &fakeStorage{}
+		tc := newTestClient(s)
+		defer tc.close()
+		So(tc.login(), ShouldBeNil)
+
+		_, err := tc.query("INSERT INTO t VALUES (1)")
+		So(err, ShouldBeNil)
+		So(s.execs, ShouldHaveLength, 1)
+
+		Convey("rollback should discard the buffered writes", func() {
+			_, err = tc.query("BEGIN; INSERT INTO t VALUES (2); ROLLBACK")
+			So(err, ShouldBeNil)
+			So(s.execs, ShouldHaveLength, 1)
+			So(s.batches, ShouldBeEmpty)
+		})
+		Convey("rollback to savepoint should be rejected", func() {
+			var types []byte
+			types, err = tc.query("BEGIN; INSERT INTO t VALUES (2); ROLLBACK TO SAVEPOINT sp")
+			So(err, ShouldBeNil)
+			So(types, ShouldResemble, []byte{'C', 'C', 'E', 'Z'})
+			_, err = tc.query("COMMIT")
+			So(err, ShouldBeNil)
+			So(s.batches, ShouldHaveLength, 1)
+		})
+		Convey("commit should send the buffered writes in a batch", func() {
+			_, err = tc.query("BEGIN")
+			So(err, ShouldBeNil)
+			_, err = tc.query("INSERT INTO t VALUES (2)")
+			So(err, ShouldBeNil)
+			_, err = tc.query("UPDATE t SET v = 3")
+			So(err, ShouldBeNil)
+			So(s.execs, ShouldHaveLength, 1)
+			_, err = tc.query("COMMIT")
+			So(err, ShouldBeNil)
+			So(s.execs, ShouldHaveLength, 1)
+			So(s.batches, ShouldHaveLength, 1)
+			So(s.batches[0], ShouldHaveLength, 2)
+			So(s.batches[0][1].Query, ShouldEqual, "UPDATE t SET v = 3")
+		})
+	})
+}
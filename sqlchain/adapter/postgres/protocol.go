@@ -0,0 +1,194 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package postgres
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	protocolVersion = 196608   // 3.0
+	sslRequestCode  = 80877103 // SSLRequest
+	cancelCode      = 80877102 // CancelRequest
+
+	// maxStartupSize limits the size of messages received before authentication.
+	maxStartupSize = 10000
+	// maxMessageSize limits the size of frontend messages of authenticated clients.
+	maxMessageSize = 64 << 20
+)
+
+// ErrMessageTooLarge defines error on receiving a frontend message exceeding the size limit.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// readStartup reads the untyped startup packet, returns the request code and payload.
+func readStartup(r io.Reader) (code uint32, payload []byte, err error) {
+	var header [8]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 8 || size > maxStartupSize {
+		err = ErrMessageTooLarge
+		return
+	}
+	code = binary.BigEndian.Uint32(header[4:])
+	payload = make([]byte, size-8)
+	_, err = io.ReadFull(r, payload)
+	return
+}
+
+// readMessage reads a typed frontend message no larger than limit.
+func readMessage(r io.Reader, limit uint32) (typ byte, payload []byte, err error) {
+	var header [5]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	typ = header[0]
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size > limit {
+		err = ErrMessageTooLarge
+		return
+	}
+	payload = make([]byte, size-4)
+	_, err = io.ReadFull(r, payload)
+	return
+}
+
+// reader decodes the fields of a frontend message payload.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = errors.New("malformed message")
+	}
+	r.buf = nil
+}
+
+func (r *reader) string() (s string) {
+	for i, c := range r.buf {
+		if c == 0 {
+			s = string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return
+		}
+	}
+	r.fail()
+	return
+}
+
+func (r *reader) byte() (b byte) {
+	if len(r.buf) < 1 {
+		r.fail()
+		return
+	}
+	b = r.buf[0]
+	r.buf = r.buf[1:]
+	return
+}
+
+func (r *reader) int16() (v int16) {
+	if len(r.buf) < 2 {
+		r.fail()
+		return
+	}
+	v = int16(binary.BigEndian.Uint16(r.buf))
+	r.buf = r.buf[2:]
+	return
+}
+
+// count reads the int16 element count of an array whose elements take at least elemSize bytes,
+// the count is rejected if it's negative or exceeds the remaining payload.
+func (r *reader) count(elemSize int) (n int) {
+	if n = int(r.int16()); n < 0 || n > len(r.buf)/elemSize {
+		r.fail()
+		n = 0
+	}
+	return
+}
+
+func (r *reader) int32() (v int32) {
+	if len(r.buf) < 4 {
+		r.fail()
+		return
+	}
+	v = int32(binary.BigEndian.Uint32(r.buf))
+	r.buf = r.buf[4:]
+	return
+}
+
+func (r *reader) bytes(n int) (b []byte) {
+	if n < 0 || len(r.buf) < n {
+		r.fail()
+		return
+	}
+	b = r.buf[:n]
+	r.buf = r.buf[n:]
+	return
+}
+
+// writer encodes backend messages into a buffered writer.
+type writer struct {
+	w   *bufio.Writer
+	msg []byte
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w)}
+}
+
+func (w *writer) start(typ byte) {
+	w.msg = append(w.msg[:0], typ, 0, 0, 0, 0)
+}
+
+func (w *writer) string(s string) {
+	w.msg = append(w.msg, s...)
+	w.msg = append(w.msg, 0)
+}
+
+func (w *writer) byte(b byte) {
+	w.msg = append(w.msg, b)
+}
+
+func (w *writer) int16(v int16) {
+	w.msg = append(w.msg, byte(v>>8), byte(v))
+}
+
+func (w *writer) int32(v int32) {
+	w.msg = append(w.msg, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *writer) bytes(b []byte) {
+	w.msg = append(w.msg, b...)
+}
+
+// end finishes the current message and writes it to the buffer.
+func (w *writer) end() (err error) {
+	binary.BigEndian.PutUint32(w.msg[1:5], uint32(len(w.msg)-1))
+	_, err = w.w.Write(w.msg)
+	return
+}
+
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
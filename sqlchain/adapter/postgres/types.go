@@ -0,0 +1,392 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package postgres
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PostgreSQL type oids of the values mapped from SQLite.
+const (
+	oidBool        = 16
+	oidBytea       = 17
+	oidInt8        = 20
+	oidInt2        = 21
+	oidInt4        = 23
+	oidText        = 25
+	oidFloat4      = 700
+	oidFloat8      = 701
+	oidVarchar     = 1043
+	oidTimestamp   = 1114
+	oidTimestamptz = 1184
+)
+
+const timestampFormat = "2006-01-02 15:04:05.999999"
+
+var (
+	// pgEpoch is the epoch of PostgreSQL binary timestamps.
+	pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// serialType matches the PostgreSQL auto increment types which are not known by SQLite.
+	serialType  = regexp.MustCompile(`(?i)\b(?:SMALL|BIG)?SERIAL\b`)
+	createQuery = regexp.MustCompile(`^(?i)\s*CREATE\s`)
+)
+
+// typeOID maps the SQLite declared type of a column to PostgreSQL type oid following the SQLite
+// type affinity rules, value is used if the column has no declared type.
+func typeOID(declType string, value interface{}) uint32 {
+	t := strings.ToUpper(declType)
+	switch {
+	case t == "":
+	case strings.Contains(t, "BOOL"):
+		return oidBool
+	case strings.Contains(t, "INT"):
+		return oidInt8
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return oidText
+	case strings.Contains(t, "BLOB"), strings.Contains(t, "BYTEA"):
+		return oidBytea
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return oidFloat8
+	case strings.Contains(t, "TIMESTAMP"), strings.Contains(t, "DATETIME"):
+		return oidTimestamp
+	default:
+		return oidText
+	}
+
+	switch value.(type) {
+	case int64:
+		return oidInt8
+	case float64:
+		return oidFloat8
+	case bool:
+		return oidBool
+	case []byte:
+		return oidBytea
+	case time.Time:
+		return oidTimestamp
+	}
+	return oidText
+}
+
+// typeSize returns the fixed size of the type in RowDescription, -1 for variable size.
+func typeSize(oid uint32) int16 {
+	switch oid {
+	case oidBool:
+		return 1
+	case oidInt8, oidFloat8, oidTimestamp:
+		return 8
+	}
+	return -1
+}
+
+func toInt64(v interface{}) (i int64, err error) {
+	switch value := v.(type) {
+	case int64:
+		return value, nil
+	case float64:
+		return int64(value), nil
+	case bool:
+		if value {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseInt(value, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(value), 10, 64)
+	}
+	err = errors.Errorf("can not convert %T to integer", v)
+	return
+}
+
+func toFloat64(v interface{}) (f float64, err error) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	case string:
+		return strconv.ParseFloat(value, 64)
+	case []byte:
+		return strconv.ParseFloat(string(value), 64)
+	}
+	err = errors.Errorf("can not convert %T to float", v)
+	return
+}
+
+func toTime(v interface{}) (t time.Time, err error) {
+	switch value := v.(type) {
+	case time.Time:
+		return value, nil
+	case string:
+		for _, layout := range []string{timestampFormat, time.RFC3339Nano, "2006-01-02"} {
+			if t, err = time.Parse(layout, value); err == nil {
+				return
+			}
+		}
+		return
+	case int64:
+		return time.Unix(value, 0).UTC(), nil
+	}
+	err = errors.Errorf("can not convert %T to timestamp", v)
+	return
+}
+
+// encodeText encodes the value in text format of the type, nil for NULL.
+func encodeText(oid uint32, v interface{}) []byte {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case int64:
+		if oid == oidBool {
+			return []byte(strconv.FormatBool(value != 0)[:1])
+		}
+		return strconv.AppendInt(nil, value, 10)
+	case float64:
+		return strconv.AppendFloat(nil, value, 'g', -1, 64)
+	case bool:
+		if value {
+			return []byte("t")
+		}
+		return []byte("f")
+	case []byte:
+		if oid == oidBytea {
+			return []byte(`\x` + hex.EncodeToString(value))
+		}
+		return value
+	case string:
+		return []byte(value)
+	case time.Time:
+		return []byte(value.Format(timestampFormat))
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+// encodeBinary encodes the value in binary format of the type, nil for NULL.
+func encodeBinary(oid uint32, v interface{}) (b []byte, err error) {
+	if v == nil {
+		return
+	}
+	switch oid {
+	case oidInt8:
+		var i int64
+		if i, err = toInt64(v); err != nil {
+			return
+		}
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(i))
+	case oidFloat8:
+		var f float64
+		if f, err = toFloat64(v); err != nil {
+			return
+		}
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	case oidBool:
+		var i int64
+		if i, err = toInt64(v); err != nil {
+			return
+		}
+		b = []byte{0}
+		if i != 0 {
+			b[0] = 1
+		}
+	case oidTimestamp:
+		var t time.Time
+		if t, err = toTime(v); err != nil {
+			return
+		}
+		b = make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(t.Sub(pgEpoch)/time.Microsecond))
+	default:
+		switch value := v.(type) {
+		case []byte:
+			b = value
+		case string:
+			b = []byte(value)
+		default:
+			b = encodeText(oid, v)
+		}
+	}
+	return
+}
+
+// decodeParam decodes the bind parameter of the type in text or binary format to the value
+// passed to SQLite.
+func decodeParam(oid uint32, binaryFormat bool, data []byte) (v interface{}, err error) {
+	if data == nil {
+		return
+	}
+	if !binaryFormat {
+		s := string(data)
+		switch oid {
+		case oidInt2, oidInt4, oidInt8:
+			return strconv.ParseInt(s, 10, 64)
+		case oidFloat4, oidFloat8:
+			return strconv.ParseFloat(s, 64)
+		case oidBool:
+			return strconv.ParseBool(s)
+		case oidBytea:
+			if strings.HasPrefix(s, `\x`) {
+				return hex.DecodeString(s[2:])
+			}
+			return data, nil
+		}
+		return s, nil
+	}
+
+	switch oid {
+	case oidInt2:
+		if len(data) == 2 {
+			return int64(int16(binary.BigEndian.Uint16(data))), nil
+		}
+	case oidInt4:
+		if len(data) == 4 {
+			return int64(int32(binary.BigEndian.Uint32(data))), nil
+		}
+	case oidInt8:
+		if len(data) == 8 {
+			return int64(binary.BigEndian.Uint64(data)), nil
+		}
+	case oidFloat4:
+		if len(data) == 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+		}
+	case oidFloat8:
+		if len(data) == 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+		}
+	case oidBool:
+		if len(data) == 1 {
+			return data[0] != 0, nil
+		}
+	case oidTimestamp, oidTimestamptz:
+		if len(data) == 8 {
+			us := int64(binary.BigEndian.Uint64(data))
+			return pgEpoch.Add(time.Duration(us) * time.Microsecond).Format(timestampFormat), nil
+		}
+	case oidBytea:
+		return data, nil
+	default:
+		return string(data), nil
+	}
+	err = errors.Errorf("invalid binary parameter of type %d", oid)
+	return
+}
+
+// scanQuery calls f with the position of each character outside string literals, quoted
+// identifiers and comments of the query.
+func scanQuery(query string, f func(i int)) {
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			for i++; i < len(query); i++ {
+				if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+		default:
+			f(i)
+		}
+	}
+}
+
+// splitStatements splits the simple query into statements separated by semicolons.
+func splitStatements(query string) (stmts []string) {
+	start := 0
+	scanQuery(query, func(i int) {
+		if query[i] == ';' {
+			stmts = append(stmts, query[start:i])
+			start = i + 1
+		}
+	})
+	stmts = append(stmts, query[start:])
+
+	// remove empty statements except the only one
+	var result []string
+	for _, s := range stmts {
+		if strings.TrimSpace(s) != "" {
+			result = append(result, s)
+		}
+	}
+	if len(result) == 0 && len(stmts) > 0 {
+		result = stmts[:1]
+	}
+	return result
+}
+
+// rewriteQuery converts the PostgreSQL $n placeholders to SQLite positional placeholders and the
+// serial types to integer, returns the parameter index of each placeholder and the param count.
+func rewriteQuery(query string) (rewritten string, order []int, params int) {
+	var (
+		sb   strings.Builder
+		last int
+	)
+	scanQuery(query, func(i int) {
+		if query[i] != '$' || i < last {
+			return
+		}
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		if j == i+1 {
+			return
+		}
+		n, err := strconv.Atoi(query[i+1 : j])
+		if err != nil || n < 1 {
+			return
+		}
+		sb.WriteString(query[last:i])
+		sb.WriteByte('?')
+		last = j
+		order = append(order, n-1)
+		if n > params {
+			params = n
+		}
+	})
+	sb.WriteString(query[last:])
+	rewritten = sb.String()
+	if createQuery.MatchString(rewritten) {
+		rewritten = serialType.ReplaceAllString(rewritten, "INTEGER")
+	}
+	return
+}
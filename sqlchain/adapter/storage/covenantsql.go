@@ -115,6 +115,18 @@ func (s *CovenantSQLStorage) Exec(dbID string, query string, args ...interface{}
 	return
 }
 
+// ExecBatch implements the Storage abstraction interface, the statements are sent to the
+// database in a single request on commit.
+func (s *CovenantSQLStorage) ExecBatch(dbID string, stmts []Statement) (err error) {
+	var conn *sql.DB
+	if conn, err = s.getConn(dbID); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	return execBatch(conn, stmts)
+}
+
 func (s *CovenantSQLStorage) getConn(dbID string) (db *sql.DB, err error) {
 	cfg := client.NewConfig()
 	cfg.DatabaseID = dbID
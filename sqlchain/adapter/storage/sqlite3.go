@@ -132,6 +132,17 @@ func (s *SQLite3Storage) Exec(dbID string, query string, args ...interface{}) (a
 	return
 }
 
+// ExecBatch implements the Storage abstraction interface.
+func (s *SQLite3Storage) ExecBatch(dbID string, stmts []Statement) (err error) {
+	var conn *sql.DB
+	if conn, err = s.getConn(dbID, false); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	return execBatch(conn, stmts)
+}
+
 func (s *SQLite3Storage) getConn(dbID string, readonly bool) (db *sql.DB, err error) {
 	dbFile := filepath.Join(s.rootDir, dbID+".db3")
 	dbDSN := fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=NORMAL", dbFile)
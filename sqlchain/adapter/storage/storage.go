@@ -31,6 +31,29 @@ type Storage interface {
 	Query(dbID string, query string, args ...interface{}) (columns []string, types []string, rows [][]interface{}, err error)
 	// Exec for update.
 	Exec(dbID string, query string, args ...interface{}) (affectedRows int64, lastInsertID int64, err error)
+	// ExecBatch for updates committed in a single transaction.
+	ExecBatch(dbID string, stmts []Statement) (err error)
+}
+
+// Statement defines a write statement of batch.
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// execBatch executes the statements in a transaction, the transaction is rolled back on any error.
+func execBatch(conn *sql.DB, stmts []Statement) (err error) {
+	var tx *sql.Tx
+	if tx, err = conn.Begin(); err != nil {
+		return
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.Exec(stmt.Query, stmt.Args...); err != nil {
+			_ = tx.Rollback()
+			return
+		}
+	}
+	return tx.Commit()
 }
 
 // golang does trick convert, use rowScanner to return the original result type in sqlite3 driver.
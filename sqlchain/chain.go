@@ -36,7 +36,9 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
@@ -61,6 +63,7 @@ var (
 	metaBlockIndex    = [4]byte{'B', 'L', 'C', 'K'}
 	metaResponseIndex = [4]byte{'R', 'E', 'S', 'P'}
 	metaAckIndex      = [4]byte{'Q', 'A', 'C', 'K'}
+	metaProofIndex    = [4]byte{'P', 'R', 'O', 'F'}
 
 	leveldbConf = opt.Options{
 		Compression: opt.SnappyCompression,
@@ -114,6 +117,9 @@ type Chain struct {
 	gasPrice     uint64
 	updatePeriod uint64
 
+	// keyring seals values persisted in chain files if not nil
+	keyring *symmetric.Keyring
+
 	// Cached fileds, may need to renew some of this fields later.
 	//
 	// pk is the private key of the local miner.
@@ -124,6 +130,7 @@ type Chain struct {
 	metaBlockIndex    []byte
 	metaResponseIndex []byte
 	metaAckIndex      []byte
+	metaProofIndex    []byte
 
 	// Atomic counters for stats
 	cachedBlockCount int32
@@ -207,12 +214,14 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 		gasPrice:     c.GasPrice,
 		updatePeriod: c.UpdatePeriod,
 		databaseID:   c.DatabaseID,
+		keyring:      c.Keyring,
 
 		pk:                pk,
 		addr:              &addr,
 		metaBlockIndex:    utils.ConcatAll(metaKeyPrefix[:], metaBlockIndex[:]),
 		metaResponseIndex: utils.ConcatAll(metaKeyPrefix[:], metaResponseIndex[:]),
 		metaAckIndex:      utils.ConcatAll(metaKeyPrefix[:], metaAckIndex[:]),
+		metaProofIndex:    utils.ConcatAll(metaKeyPrefix[:], metaProofIndex[:]),
 
 		expVars: new(expvar.Map).Init(),
 	}
@@ -227,6 +236,11 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 
 	le = le.WithField("peer", chain.rt.getPeerInfoString())
 
+	if err = chain.checkStaleRecords(c.PurgeStaleRecords); err != nil {
+		_ = chain.st.Close(false)
+		return
+	}
+
 	// Read blocks and rebuild memory index
 	var (
 		id           uint64
@@ -241,6 +255,11 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 			block = &types.Block{}
 		)
 
+		if v, err = chain.openValue(v); err != nil {
+			err = errors.Wrapf(err, "open block at height %d with key %s",
+				keyWithSymbolToHeight(k), string(k))
+			return
+		}
 		if err = utils.DecodeMsgPack(v, block); err != nil {
 			err = errors.Wrapf(err, "decoding failed at height %d with key %s",
 				keyWithSymbolToHeight(k), string(k))
@@ -311,6 +330,10 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 		v := respIter.Value()
 		h := keyWithSymbolToHeight(k)
 		var resp = &types.SignedResponseHeader{}
+		if v, err = chain.openValue(v); err != nil {
+			err = errors.Wrapf(err, "open resp, height %d, index %s", h, string(k))
+			return
+		}
 		if err = utils.DecodeMsgPack(v, resp); err != nil {
 			err = errors.Wrapf(err, "load resp, height %d, index %s", h, string(k))
 			return
@@ -333,6 +356,10 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 		v := ackIter.Value()
 		h := keyWithSymbolToHeight(k)
 		var ack = &types.SignedAckHeader{}
+		if v, err = chain.openValue(v); err != nil {
+			err = errors.Wrapf(err, "open ack, height %d, index %s", h, string(k))
+			return
+		}
 		if err = utils.DecodeMsgPack(v, ack); err != nil {
 			err = errors.Wrapf(err, "load ack, height %d, index %s", h, string(k))
 			return
@@ -377,17 +404,33 @@ func (c *Chain) pushBlock(b *types.Block) (err error) {
 
 		blockKey = utils.ConcatAll(c.metaBlockIndex, node.indexKey())
 		encBlock *bytes.Buffer
+		v        []byte
 	)
 	if encBlock, err = utils.EncodeMsgPack(b); err != nil {
 		return
 	}
+	if v, err = c.sealValue(encBlock.Bytes()); err != nil {
+		err = errors.Wrapf(err, "seal %s", string(node.indexKey()))
+		return
+	}
 
 	// Put block
-	err = blkDB.Put(blockKey, encBlock.Bytes(), nil)
+	err = blkDB.Put(blockKey, v, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "put %s", string(node.indexKey()))
 		return
 	}
+	// Index responses to the block for query proofs
+	if len(b.QueryTxs) > 0 {
+		batch := new(leveldb.Batch)
+		for _, v := range b.QueryTxs {
+			batch.Put(utils.ConcatAll(c.metaProofIndex, v.Response.ResponseHash[:]), node.indexKey())
+		}
+		if err = txDB.Write(batch, nil); err != nil {
+			err = errors.Wrapf(err, "put proof index of %s", string(node.indexKey()))
+			return
+		}
+	}
 	atomic.AddInt32(&c.cachedBlockCount, 1)
 	c.rt.setHead(head)
 	c.bi.addBlock(node)
@@ -443,11 +486,18 @@ func (c *Chain) pushAckedQuery(ack *types.SignedAckHeader) (err error) {
 	log.WithField("db", c.databaseID).Debugf("push ack %s", ack.Hash().String())
 	h := c.rt.getHeightFromTime(ack.GetResponseTimestamp())
 	k := heightToKey(h)
-	var enc *bytes.Buffer
+	var (
+		enc *bytes.Buffer
+		v   []byte
+	)
 
 	if enc, err = utils.EncodeMsgPack(ack); err != nil {
 		return
 	}
+	if v, err = c.sealValue(enc.Bytes()); err != nil {
+		err = errors.Wrapf(err, "seal ack %d %s", h, ack.Hash().String())
+		return
+	}
 
 	tdbKey := utils.ConcatAll(c.metaAckIndex, k, ack.Hash().AsBytes())
 
@@ -456,7 +506,7 @@ func (c *Chain) pushAckedQuery(ack *types.SignedAckHeader) (err error) {
 		return
 	}
 
-	if err = txDB.Put(tdbKey, enc.Bytes(), nil); err != nil {
+	if err = txDB.Put(tdbKey, v, nil); err != nil {
 		err = errors.Wrapf(err, "put ack %d %s", h, ack.Hash().String())
 		return
 	}
@@ -779,12 +829,11 @@ func (c *Chain) processBlocks(ctx context.Context) {
 		le := c.logEntryWithHeadState()
 		select {
 		case h := <-c.heights:
-			// Trigger billing
-			index, total := c.rt.getIndexTotal()
+			// Trigger billing, every miner reports the billing of the epoch and the block
+			// producers settle it by majority
 			period := int32(c.updatePeriod)
 			isBillingPeriod := (h%period == 0)
-			isMyTurnBilling := (h/period%total == index)
-			if isBillingPeriod && isMyTurnBilling {
+			if isBillingPeriod {
 				ub, err := c.billing(h, c.rt.getHead().node)
 				if err != nil {
 					le.WithError(err).Error("billing failed")
@@ -918,6 +967,11 @@ func (c *Chain) fetchBlockByIndexKey(indexKey []byte) (b *types.Block, err error
 		return
 	}
 
+	if v, err = c.openValue(v); err != nil {
+		err = errors.Wrapf(err, "open block %s", string(k))
+		return
+	}
+
 	b = &types.Block{}
 	err = utils.DecodeMsgPack(v, b)
 	if err != nil {
@@ -928,6 +982,98 @@ func (c *Chain) fetchBlockByIndexKey(indexKey []byte) (b *types.Block, err error
 	return
 }
 
+// QueryProof returns the proof which binds the query response with responseHash to the header of
+// the block packing it.
+func (c *Chain) QueryProof(responseHash *hash.Hash) (proof *types.QueryProof, err error) {
+	var indexKey []byte
+	if indexKey, err = txDB.Get(utils.ConcatAll(c.metaProofIndex, responseHash[:]), nil); err == leveldb.ErrNotFound {
+		err = errors.Wrapf(ErrQueryNotFound, "response %s", responseHash)
+		return
+	} else if err != nil {
+		err = errors.Wrapf(err, "get proof index of response %s", responseHash)
+		return
+	}
+
+	var b *types.Block
+	if b, err = c.fetchBlockByIndexKey(indexKey); err != nil {
+		return
+	}
+	if proof, err = b.QueryProof(responseHash); err != nil {
+		return
+	}
+	proof.Height = c.rt.getHeightFromTime(b.Timestamp())
+	return
+}
+
+// checkStaleRecords checks that the chain records could be opened by the chain keyring. The records
+// left by a destroyed database of the same id are sealed by the lost keyring, which are removed only
+// if purge is set as the database is being recreated, otherwise ErrInvalidChainKey is returned.
+func (c *Chain) checkStaleRecords(purge bool) (err error) {
+	if c.keyring == nil {
+		return
+	}
+
+	it := blkDB.NewIterator(util.BytesPrefix(c.metaBlockIndex), nil)
+	if !it.Next() {
+		it.Release()
+		return it.Error()
+	}
+	_, err = c.openValue(it.Value())
+	it.Release()
+	if err == nil {
+		return
+	}
+	if !purge {
+		err = errors.Wrapf(ErrInvalidChainKey, "open chain records failed: %v", err)
+		return
+	}
+	c.logEntry().WithError(err).Warning("purge stale chain records sealed by unknown key")
+
+	for _, v := range []struct {
+		db     *leveldb.DB
+		prefix []byte
+	}{
+		{blkDB, c.metaBlockIndex},
+		{txDB, c.metaResponseIndex},
+		{txDB, c.metaAckIndex},
+		{txDB, c.metaProofIndex},
+	} {
+		var (
+			batch = new(leveldb.Batch)
+			iter  = v.db.NewIterator(util.BytesPrefix(v.prefix), nil)
+		)
+		for iter.Next() {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+		iter.Release()
+		if err = iter.Error(); err != nil {
+			err = errors.Wrap(err, "iterate stale chain records")
+			return
+		}
+		if err = v.db.Write(batch, nil); err != nil {
+			err = errors.Wrap(err, "purge stale chain records")
+			return
+		}
+	}
+	return
+}
+
+// sealValue seals value to persist in chain files by the chain keyring if configured.
+func (c *Chain) sealValue(v []byte) ([]byte, error) {
+	if c.keyring == nil {
+		return v, nil
+	}
+	return c.keyring.Seal(v)
+}
+
+// openValue opens value loaded from chain files by the chain keyring if configured.
+func (c *Chain) openValue(v []byte) ([]byte, error) {
+	if c.keyring == nil {
+		return v, nil
+	}
+	return c.keyring.Open(v)
+}
+
 // CheckAndPushNewBlock implements ChainRPCServer.CheckAndPushNewBlock.
 func (c *Chain) CheckAndPushNewBlock(block *types.Block) (err error) {
 	height := c.rt.getHeightFromTime(block.Timestamp())
@@ -1033,6 +1179,51 @@ func (c *Chain) Query(
 	return c.st.QueryWithContext(req.GetContext(), req, isLeader)
 }
 
+// StateDigest returns the digest of local chain state.
+func (c *Chain) StateDigest() (*x.StateDigest, error) {
+	return c.st.Digest()
+}
+
+// StateDelta returns the changes of local chain state against the remote state digest.
+func (c *Chain) StateDelta(remote *x.StateDigest) (*x.StateDelta, error) {
+	return c.st.Delta(remote)
+}
+
+// ApplyStateDelta applies the state delta to local chain state.
+func (c *Chain) ApplyStateDelta(delta *x.StateDelta) error {
+	return c.st.ApplyDelta(delta)
+}
+
+// Prepare prepares the statement of pattern in local chain state.
+func (c *Chain) Prepare(pattern string) (*x.Statement, error) {
+	return c.st.Prepare(pattern)
+}
+
+// CloseStatement releases the statement of pattern in local chain state.
+func (c *Chain) CloseStatement(pattern string) {
+	c.st.CloseStatement(pattern)
+}
+
+// PreparedStatement returns the prepared statement of pattern in local chain state if exists.
+func (c *Chain) PreparedStatement(pattern string) (*x.Statement, bool) {
+	return c.st.PreparedStatement(pattern)
+}
+
+// SetStorageLimits updates the resource limits of local chain state storage.
+func (c *Chain) SetStorageLimits(l xs.Limits) {
+	c.st.SetStorageLimits(l)
+}
+
+// ContainsDDL reports whether any of the queries contains a schema change statement.
+func (c *Chain) ContainsDDL(queries []types.Query) (bool, error) {
+	return c.st.ContainsDDL(queries)
+}
+
+// DryRun checks the write queries of req are executable on local chain state without any change.
+func (c *Chain) DryRun(req *types.Request) (err error) {
+	return c.st.DryRun(req)
+}
+
 // AddResponse addes a response to the ackIndex, awaiting for acknowledgement.
 func (c *Chain) AddResponse(resp *types.SignedResponseHeader) (err error) {
 	return c.ai.addResponse(c.rt.getHeightFromTime(resp.GetRequestTimestamp()), resp)
@@ -1224,6 +1415,12 @@ func (c *Chain) updateMetrics() {
 	c.expVars.Get(mwMinerChainBlockTimestamp).(*expvar.String).Set(b.Timestamp().String())
 }
 
+// Head returns the height and hash of the current head block of the chain.
+func (c *Chain) Head() (height int32, head hash.Hash) {
+	st := c.rt.getHead()
+	return st.Height, st.Head
+}
+
 func (c *Chain) getCurrentHeight() int32 {
 	return c.rt.getHead().Height
 }
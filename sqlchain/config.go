@@ -19,6 +19,7 @@ package sqlchain
 import (
 	"time"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/types"
 )
@@ -47,4 +48,10 @@ type Config struct {
 	UpdatePeriod      uint64
 	LastBillingHeight int32
 	IsolationLevel    int
+
+	// Keyring seals the blocks and acks persisted in chain files, keep nil to store in plain.
+	Keyring *symmetric.Keyring
+	// PurgeStaleRecords removes the chain records which could not be opened by the keyring instead
+	// of failing, set only if the database is being recreated.
+	PurgeStaleRecords bool
 }
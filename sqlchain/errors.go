@@ -45,4 +45,8 @@ var (
 	// ErrInitiating indicates that a sqlchain is in initiate state and is not available for sync
 	// requests.
 	ErrInitiating = errors.New("sqlchain is in initiate")
+	// ErrRecoveryPointNotFound indicates that no block matches the requested recovery point.
+	ErrRecoveryPointNotFound = errors.New("recovery point not found")
+	// ErrInvalidChainKey indicates that the chain records could not be opened by the chain keyring.
+	ErrInvalidChainKey = errors.New("chain records could not be opened by chain keyring")
 )
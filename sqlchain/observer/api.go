@@ -445,6 +445,24 @@ func (a *explorerAPI) GetHighestBlockV3(rw http.ResponseWriter, r *http.Request)
 	sendResponse(200, true, "", a.formatBlockV3(count, height, block, op), rw)
 }
 
+func (a *explorerAPI) GetQueryStats(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	dbID, err := a.getDBID(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	stats, err := a.service.getQueryStats(dbID)
+	if err != nil {
+		sendResponse(500, false, err, nil, rw)
+		return
+	}
+
+	sendResponse(200, true, "", a.formatQueryStats(stats), rw)
+}
+
 func (a *explorerAPI) formatBlock(height int32, b *types.Block) (res map[string]interface{}) {
 	queries := make([]string, 0, len(b.Acks))
 
@@ -642,6 +660,38 @@ func (a *explorerAPI) formatAck(ack *types.SignedAckHeader) map[string]interface
 	}
 }
 
+func (a *explorerAPI) formatQueryStats(stats *types.QueryStatsResp) map[string]interface{} {
+	statements := make([]map[string]interface{}, 0, len(stats.Statements))
+	for _, st := range stats.Statements {
+		statements = append(statements, map[string]interface{}{
+			"query":         st.Query,
+			"calls":         st.Calls,
+			"total_latency": a.formatDuration(st.TotalLatency),
+			"p95_latency":   a.formatDuration(st.P95Latency),
+			"rows":          st.Rows,
+		})
+	}
+	slowQueries := make([]map[string]interface{}, 0, len(stats.SlowQueries))
+	for _, q := range stats.SlowQueries {
+		slowQueries = append(slowQueries, map[string]interface{}{
+			"timestamp": a.formatTime(q.Time),
+			"elapsed":   a.formatDuration(q.Elapsed),
+			"node":      q.NodeID,
+			"type":      q.QueryType.String(),
+			"sample":    q.Sample,
+		})
+	}
+	return map[string]interface{}{
+		"slow_query_time": a.formatDuration(stats.SlowQueryTime),
+		"statements":      statements,
+		"slow_queries":    slowQueries,
+	}
+}
+
+func (a *explorerAPI) formatDuration(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 func (a *explorerAPI) formatTime(t time.Time) float64 {
 	return float64(t.UnixNano()) / 1e6
 }
@@ -710,6 +760,16 @@ func startAPI(service *Service, listenAddr string, version string) (server *http
 	v3Router.HandleFunc("/height/{db}/{height:[0-9]+}", api.GetBlockByHeightV3).Methods("GET")
 	v3Router.HandleFunc("/head/{db}", api.GetHighestBlockV3).Methods("GET")
 	v3Router.HandleFunc("/subscriptions", api.GetAllSubscriptions).Methods("GET")
+	v3Router.HandleFunc("/stats/{db}", api.GetQueryStats).Methods("GET")
+	v4Router := apiRouter.PathPrefix("/v4").Subrouter()
+	v4Routes := api.v4Routes()
+	for _, r := range v4Routes {
+		v4Router.HandleFunc(r.path, r.handler).Methods("GET")
+	}
+	apiRouter.HandleFunc(openAPIPath, func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(openAPISpec(v4Routes, version))
+	}).Methods("GET")
 
 	server = &http.Server{
 		Addr:         listenAddr,
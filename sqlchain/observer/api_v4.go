@@ -0,0 +1,436 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package observer
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+const (
+	// maxPageSize defines the max page size of the v4 listing apis.
+	maxPageSize = 100
+)
+
+// apiParam defines a query string parameter of an api route.
+type apiParam struct {
+	name        string
+	kind        string
+	description string
+}
+
+// apiRoute defines an api route which is registered to router and described in the OpenAPI spec.
+type apiRoute struct {
+	path    string
+	summary string
+	params  []apiParam
+	handler http.HandlerFunc
+}
+
+var (
+	pathParamDescriptions = map[string]string{
+		"db":     "database id",
+		"hash":   "hash in hex string",
+		"height": "block height, calculated by block timestamp and sqlchain period",
+		"count":  "block count, the sequence number of the block in sqlchain",
+	}
+	paginationParams = []apiParam{
+		{name: "page", kind: "integer", description: "page number starts from 1"},
+		{name: "size", kind: "integer", description: "page size, at most 100"},
+	}
+)
+
+func (a *explorerAPI) v4Routes() []*apiRoute {
+	return []*apiRoute{
+		{
+			path:    "/databases",
+			summary: "list subscribed databases and their synced block count",
+			handler: a.ListDatabasesV4,
+		},
+		{
+			path:    "/databases/{db}/blocks",
+			summary: "list blocks of the database in descending count order",
+			params:  paginationParams,
+			handler: a.ListBlocksV4,
+		},
+		{
+			path:    "/databases/{db}/blocks/head",
+			summary: "get the highest block of the database",
+			params:  a.blockQueryParams(),
+			handler: a.GetHighestBlockV3,
+		},
+		{
+			path:    "/databases/{db}/blocks/height/{height:[0-9]+}",
+			summary: "get block by height",
+			params:  a.blockQueryParams(),
+			handler: a.GetBlockByHeightV3,
+		},
+		{
+			path:    "/databases/{db}/blocks/count/{count:[0-9]+}",
+			summary: "get block by count",
+			params:  a.blockQueryParams(),
+			handler: a.GetBlockByCountV3,
+		},
+		{
+			path:    "/databases/{db}/blocks/hash/{hash}",
+			summary: "get block by hash",
+			params:  a.blockQueryParams(),
+			handler: a.GetBlockV3,
+		},
+		{
+			path:    "/databases/{db}/queries",
+			summary: "list query history of the database in descending timestamp order",
+			params: append([]apiParam{
+				{name: "type", kind: "string", description: "query type, read or write"},
+				{name: "node", kind: "string", description: "node id of the query issuer"},
+				{name: "since", kind: "number", description: "inclusive start timestamp in milliseconds"},
+				{name: "until", kind: "number", description: "exclusive end timestamp in milliseconds"},
+				{name: "failed", kind: "boolean", description: "only failed or only successful queries"},
+			}, paginationParams...),
+			handler: a.ListQueriesV4,
+		},
+		{
+			path:    "/databases/{db}/transactions/{hash}",
+			summary: "get transaction by request hash, including response, block and ack status",
+			handler: a.GetTransactionV4,
+		},
+		{
+			path:    "/databases/{db}/transactions/{hash}/ack",
+			summary: "get ack status of transaction by request hash",
+			handler: a.GetTransactionAckV4,
+		},
+		{
+			path:    "/databases/{db}/acks/{hash}",
+			summary: "get ack by hash",
+			handler: a.GetAck,
+		},
+		{
+			path:    "/databases/{db}/responses/{hash}",
+			summary: "get response by hash",
+			handler: a.GetResponse,
+		},
+		{
+			path:    "/databases/{db}/miners",
+			summary: "list miner membership history of the database",
+			handler: a.ListMembershipV4,
+		},
+		{
+			path:    "/databases/{db}/stats",
+			summary: "get statement statistics and slow queries of the database",
+			handler: a.GetQueryStats,
+		},
+		{
+			path: "/ws",
+			summary: "websocket stream of new blocks, queries and acks, " +
+				`send {"action": "subscribe|unsubscribe", "db": "database id"} to change subscriptions`,
+			params: []apiParam{
+				{name: "db", kind: "string", description: "database id to subscribe, could be repeated"},
+				{name: "events", kind: "string", description: "comma separated event types: block, query, ack"},
+			},
+			handler: a.StreamEvents,
+		},
+	}
+}
+
+func (a *explorerAPI) blockQueryParams() []apiParam {
+	return append([]apiParam{
+		{name: "type", kind: "string", description: "query type of the listed block queries, read or write"},
+	}, paginationParams...)
+}
+
+func sendError(err error, rw http.ResponseWriter) {
+	if errors.Cause(err) == ErrNotFound {
+		sendResponse(http.StatusNotFound, false, err, nil, rw)
+		return
+	}
+	sendResponse(http.StatusInternalServerError, false, err, nil, rw)
+}
+
+func newLimitedPaginationFromReq(r *http.Request) (op *paginationOps) {
+	op = newPaginationFromReq(r)
+	if op.size > maxPageSize {
+		op.size = maxPageSize
+	}
+	return
+}
+
+func (a *explorerAPI) formatPagination(op *paginationOps, total int) map[string]interface{} {
+	return map[string]interface{}{
+		"page":  op.page,
+		"size":  op.size,
+		"total": total,
+	}
+}
+
+func (a *explorerAPI) ListDatabasesV4(rw http.ResponseWriter, r *http.Request) {
+	subscriptions, err := a.service.getAllSubscriptions()
+	if err != nil {
+		sendError(err, rw)
+		return
+	}
+
+	databases := make([]map[string]interface{}, 0, len(subscriptions))
+	for dbID, count := range subscriptions {
+		databases = append(databases, map[string]interface{}{
+			"db":    dbID,
+			"count": count,
+		})
+	}
+	sort.Slice(databases, func(i, j int) bool {
+		return databases[i]["db"].(proto.DatabaseID) < databases[j]["db"].(proto.DatabaseID)
+	})
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"databases": databases,
+	}, rw)
+}
+
+func (a *explorerAPI) ListBlocksV4(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	dbID, err := a.getDBID(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	op := newLimitedPaginationFromReq(r)
+	records, total, err := a.service.listBlocks(dbID, (op.page-1)*op.size, op.size)
+	if err != nil {
+		sendError(err, rw)
+		return
+	}
+
+	blocks := make([]interface{}, 0, len(records))
+	for _, v := range records {
+		blocks = append(blocks, a.formatBlockV2(v.Count, v.Height, v.Block)["block"])
+	}
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"blocks":     blocks,
+		"pagination": a.formatPagination(op, total),
+	}, rw)
+}
+
+func (a *explorerAPI) ListQueriesV4(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	dbID, err := a.getDBID(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	filter, err := a.getQueryFilter(r)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	op := newLimitedPaginationFromReq(r)
+	records, total, err := a.service.listQueries(dbID, filter, (op.page-1)*op.size, op.size)
+	if err != nil {
+		sendError(err, rw)
+		return
+	}
+
+	queries := make([]map[string]interface{}, 0, len(records))
+	for _, v := range records {
+		queries = append(queries, a.formatQueryRecord(v))
+	}
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"queries":    queries,
+		"pagination": a.formatPagination(op, total),
+	}, rw)
+}
+
+func (a *explorerAPI) GetTransactionV4(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	dbID, err := a.getDBID(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	h, err := a.getHash(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	record, b, err := a.service.getTransaction(dbID, h)
+	if err != nil {
+		sendError(err, rw)
+		return
+	}
+
+	var tx map[string]interface{}
+	if record.Failed {
+		tx = a.formatRequest(b.FailedReqs[record.Offset])
+	} else {
+		tx = a.formatRequest(b.QueryTxs[record.Offset].Request)
+		tx["response"] = a.formatResponseHeader(b.QueryTxs[record.Offset].Response)["response"]
+	}
+	tx["failed"] = record.Failed
+	tx["block"] = map[string]interface{}{
+		"height": record.Height,
+		"count":  record.Count,
+		"hash":   b.BlockHash().String(),
+	}
+	tx["ack"] = nil
+	if record.Ack != "" {
+		if ack, err := a.service.getQueryAck(dbID, h); err == nil {
+			tx["ack"] = a.formatAck(ack)["ack"]
+		}
+	}
+
+	sendResponse(200, true, "", tx, rw)
+}
+
+func (a *explorerAPI) GetTransactionAckV4(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	dbID, err := a.getDBID(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	h, err := a.getHash(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	ack, err := a.service.getQueryAck(dbID, h)
+	if errors.Cause(err) == ErrNotFound {
+		// not acked yet
+		sendResponse(200, true, "", map[string]interface{}{
+			"acked": false,
+			"ack":   nil,
+		}, rw)
+		return
+	} else if err != nil {
+		sendError(err, rw)
+		return
+	}
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"acked": true,
+		"ack":   a.formatAck(ack)["ack"],
+	}, rw)
+}
+
+func (a *explorerAPI) ListMembershipV4(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	dbID, err := a.getDBID(vars)
+	if err != nil {
+		sendResponse(400, false, err, nil, rw)
+		return
+	}
+
+	records, err := a.service.listMembership(dbID)
+	if err != nil {
+		sendError(err, rw)
+		return
+	}
+
+	history := make([]map[string]interface{}, 0, len(records))
+	for _, v := range records {
+		miners := make([]map[string]interface{}, 0, len(v.Miners))
+		for i, m := range v.Miners {
+			miners = append(miners, map[string]interface{}{
+				"node":    m.NodeID,
+				"address": m.Address,
+				"name":    m.Name,
+				"leader":  i == 0,
+			})
+		}
+		history = append(history, map[string]interface{}{
+			"timestamp": a.formatTime(v.Timestamp),
+			"miners":    miners,
+		})
+	}
+
+	sendResponse(200, true, "", map[string]interface{}{
+		"history": history,
+	}, rw)
+}
+
+func (a *explorerAPI) formatQueryRecord(r *queryRecord) map[string]interface{} {
+	return map[string]interface{}{
+		"hash":      r.Hash,
+		"type":      r.QueryType.String(),
+		"node":      r.NodeID,
+		"timestamp": a.formatTime(r.Timestamp),
+		"height":    r.Height,
+		"count":     r.Count,
+		"failed":    r.Failed,
+		"response":  r.Response,
+		"ack":       r.Ack,
+		"acked":     r.Ack != "",
+	}
+}
+
+func (a *explorerAPI) parseTime(s string) (t time.Time, err error) {
+	var ms float64
+	if ms, err = strconv.ParseFloat(s, 64); err != nil {
+		return
+	}
+	t = time.Unix(0, int64(ms*float64(time.Millisecond)))
+	return
+}
+
+func (a *explorerAPI) getQueryFilter(r *http.Request) (filter *queryFilter, err error) {
+	var values = r.URL.Query()
+
+	filter = &queryFilter{
+		queryType: newPaginationFromReq(r).queryType,
+		node:      proto.NodeID(values.Get("node")),
+	}
+	if v := values.Get("since"); v != "" {
+		if filter.since, err = a.parseTime(v); err != nil {
+			err = errors.Wrap(err, "invalid since timestamp")
+			return
+		}
+	}
+	if v := values.Get("until"); v != "" {
+		if filter.until, err = a.parseTime(v); err != nil {
+			err = errors.Wrap(err, "invalid until timestamp")
+			return
+		}
+	}
+	if v := values.Get("failed"); v != "" {
+		var failed bool
+		if failed, err = strconv.ParseBool(v); err != nil {
+			err = errors.Wrap(err, "invalid failed filter")
+			return
+		}
+		filter.failed = &failed
+	}
+	return
+}
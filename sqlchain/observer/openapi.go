@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package observer
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+const (
+	openAPIVersion = "3.0.0"
+	openAPIPath    = "/v4/openapi.json"
+)
+
+var (
+	// pathVariableRe matches gorilla mux path variables with optional patterns.
+	pathVariableRe = regexp.MustCompile(`{([^{}:]+)(:[^{}]+)?}`)
+)
+
+// GenerateOpenAPISpec generates the OpenAPI spec of the observer v4 REST API.
+func GenerateOpenAPISpec(version string) ([]byte, error) {
+	return json.MarshalIndent(openAPISpec((&explorerAPI{}).v4Routes(), version), "", "  ")
+}
+
+func openAPISpec(routes []*apiRoute, version string) map[string]interface{} {
+	paths := make(map[string]interface{}, len(routes))
+
+	for _, r := range routes {
+		var (
+			path   = pathVariableRe.ReplaceAllString(r.path, "{$1}")
+			params = make([]map[string]interface{}, 0, len(r.params)+2)
+		)
+
+		for _, m := range pathVariableRe.FindAllStringSubmatch(r.path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":        m[1],
+				"in":          "path",
+				"required":    true,
+				"description": pathParamDescriptions[m[1]],
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range r.params {
+			params = append(params, map[string]interface{}{
+				"name":        p.name,
+				"in":          "query",
+				"required":    false,
+				"description": p.description,
+				"schema":      map[string]interface{}{"type": p.kind},
+			})
+		}
+
+		paths["/v4"+path] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":     r.summary,
+				"operationId": openAPIOperationID(path),
+				"parameters":  params,
+				"responses": map[string]interface{}{
+					"200": openAPIResponse("success"),
+					"400": openAPIResponse("invalid parameters"),
+					"404": openAPIResponse("resource not found"),
+					"500": openAPIResponse("internal error"),
+				},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "CovenantSQL Observer API",
+			"description": "REST API to browse the observed SQLChain blocks, queries and acks.",
+			"version":     version,
+		},
+		"servers": []map[string]interface{}{
+			{"url": apiProxyPrefix},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Response": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"status":  map[string]interface{}{"type": "string"},
+						"success": map[string]interface{}{"type": "boolean"},
+						"data":    map[string]interface{}{"type": "object"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func openAPIResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Response"},
+			},
+		},
+	}
+}
+
+// openAPIOperationID builds operation id from path, e.g. /databases/{db}/blocks/head to
+// getDatabasesByDbBlocksHead.
+func openAPIOperationID(path string) string {
+	var b strings.Builder
+	b.WriteString("get")
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		if strings.HasPrefix(seg, "{") {
+			seg = "by_" + strings.Trim(seg, "{}")
+		}
+		for _, part := range strings.Split(seg, "_") {
+			if part != "" {
+				b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+			}
+		}
+	}
+	return b.String()
+}
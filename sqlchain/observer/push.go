@@ -0,0 +1,335 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package observer
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+const (
+	// event types pushed to websocket clients
+	eventBlock        = "block"
+	eventQuery        = "query"
+	eventAck          = "ack"
+	eventSubscribed   = "subscribed"
+	eventUnsubscribed = "unsubscribed"
+	eventError        = "error"
+
+	// actions sent by websocket clients
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+)
+
+var (
+	// eventBufferSize defines the pending block events of a subscriber, the subscriber is
+	// disconnected if it's full.
+	eventBufferSize = 64
+	wsWriteTimeout  = 10 * time.Second
+	wsPingInterval  = 30 * time.Second
+	wsUpgrader      = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+)
+
+// blockEvent defines a new block observed of the database.
+type blockEvent struct {
+	dbID   proto.DatabaseID
+	count  int32
+	height int32
+	block  *types.Block
+}
+
+// eventSubscriber defines a subscriber of block events of some databases.
+type eventSubscriber struct {
+	sync.RWMutex
+	dbs map[proto.DatabaseID]bool
+	ch  chan *blockEvent
+}
+
+func (s *eventSubscriber) add(dbID proto.DatabaseID) {
+	s.Lock()
+	defer s.Unlock()
+	s.dbs[dbID] = true
+}
+
+func (s *eventSubscriber) remove(dbID proto.DatabaseID) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.dbs, dbID)
+}
+
+func (s *eventSubscriber) has(dbID proto.DatabaseID) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.dbs[dbID]
+}
+
+// eventHub dispatches the observed block events to the subscribers.
+type eventHub struct {
+	sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	closed      bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+func (h *eventHub) subscribe(dbs ...proto.DatabaseID) (sub *eventSubscriber) {
+	sub = &eventSubscriber{
+		dbs: make(map[proto.DatabaseID]bool),
+		ch:  make(chan *blockEvent, eventBufferSize),
+	}
+	for _, dbID := range dbs {
+		sub.dbs[dbID] = true
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		close(sub.ch)
+		return
+	}
+	h.subscribers[sub] = struct{}{}
+	return
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+func (h *eventHub) publish(ev *blockEvent) {
+	h.Lock()
+	defer h.Unlock()
+	for sub := range h.subscribers {
+		if !sub.has(ev.dbID) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// slow subscriber, disconnect it instead of dropping events silently
+			log.WithField("db", ev.dbID).Warning("event subscriber is too slow, disconnect")
+			delete(h.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+func (h *eventHub) close() {
+	h.Lock()
+	defer h.Unlock()
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// wsMessage defines the message pushed to websocket clients.
+type wsMessage struct {
+	Type string           `json:"type"`
+	DB   proto.DatabaseID `json:"db,omitempty"`
+	Data interface{}      `json:"data,omitempty"`
+}
+
+// wsAction defines the message sent by websocket clients to change subscriptions.
+type wsAction struct {
+	Action string           `json:"action"`
+	DB     proto.DatabaseID `json:"db"`
+}
+
+func (a *explorerAPI) parseEventTypes(r *http.Request) (eventTypes map[string]bool) {
+	eventTypes = map[string]bool{eventBlock: true, eventQuery: true, eventAck: true}
+	if v := r.URL.Query().Get("events"); v != "" {
+		eventTypes = map[string]bool{}
+		for _, t := range strings.Split(v, ",") {
+			eventTypes[strings.TrimSpace(t)] = true
+		}
+	}
+	return
+}
+
+func (a *explorerAPI) formatBlockEvent(ev *blockEvent, eventTypes map[string]bool) (msgs []*wsMessage) {
+	b := ev.block
+	if eventTypes[eventBlock] {
+		msgs = append(msgs, &wsMessage{
+			Type: eventBlock,
+			DB:   ev.dbID,
+			Data: a.formatBlockV2(ev.count, ev.height, b)["block"],
+		})
+	}
+	if eventTypes[eventQuery] {
+		blockInfo := map[string]interface{}{
+			"height": ev.height,
+			"count":  ev.count,
+			"hash":   b.BlockHash().String(),
+		}
+		for _, tx := range b.QueryTxs {
+			q := a.formatRequest(tx.Request)
+			q["response"] = a.formatResponseHeader(tx.Response)["response"]
+			q["failed"] = false
+			q["block"] = blockInfo
+			msgs = append(msgs, &wsMessage{Type: eventQuery, DB: ev.dbID, Data: q})
+		}
+		for _, req := range b.FailedReqs {
+			q := a.formatRequest(req)
+			q["failed"] = true
+			q["block"] = blockInfo
+			msgs = append(msgs, &wsMessage{Type: eventQuery, DB: ev.dbID, Data: q})
+		}
+	}
+	if eventTypes[eventAck] {
+		for _, ack := range b.Acks {
+			msgs = append(msgs, &wsMessage{Type: eventAck, DB: ev.dbID, Data: a.formatAck(ack)["ack"]})
+		}
+	}
+	return
+}
+
+// StreamEvents pushes new blocks, queries and acks of the databases specified by db query
+// parameters to websocket clients, the client could change the subscriptions by sending
+// {"action": "subscribe|unsubscribe", "db": "database id"} messages.
+func (a *explorerAPI) StreamEvents(rw http.ResponseWriter, r *http.Request) {
+	var (
+		dbs        []proto.DatabaseID
+		eventTypes = a.parseEventTypes(r)
+	)
+	for _, v := range r.URL.Query()["db"] {
+		if v != "" {
+			dbs = append(dbs, proto.DatabaseID(v))
+		}
+	}
+
+	conn, err := wsUpgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// response is written by upgrader
+		log.WithError(err).Debug("upgrade websocket connection failed")
+		return
+	}
+
+	var (
+		sub     = a.service.events.subscribe(dbs...)
+		replies = make(chan *wsMessage, 1)
+		done    = make(chan struct{})
+		stop    = make(chan struct{})
+	)
+
+	for _, dbID := range dbs {
+		if err := a.service.ensureSubscribed(dbID); err != nil {
+			log.WithField("db", dbID).WithError(err).Debug("subscribe database failed")
+		}
+	}
+
+	// read subscription actions
+	go func() {
+		defer close(done)
+		for {
+			var action wsAction
+			if err := conn.ReadJSON(&action); err != nil {
+				return
+			}
+			var reply *wsMessage
+			switch {
+			case action.DB == "":
+				reply = &wsMessage{Type: eventError, Data: "invalid database id"}
+			case action.Action == actionSubscribe:
+				if err := a.service.ensureSubscribed(action.DB); err != nil {
+					reply = &wsMessage{Type: eventError, DB: action.DB, Data: err.Error()}
+					break
+				}
+				sub.add(action.DB)
+				reply = &wsMessage{Type: eventSubscribed, DB: action.DB}
+			case action.Action == actionUnsubscribe:
+				sub.remove(action.DB)
+				reply = &wsMessage{Type: eventUnsubscribed, DB: action.DB}
+			default:
+				reply = &wsMessage{Type: eventError, Data: "unknown action " + action.Action}
+			}
+			select {
+			case replies <- reply:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	a.writeEvents(conn, sub, eventTypes, replies, done)
+	a.service.events.unsubscribe(sub)
+	close(stop)
+	_ = conn.Close()
+	<-done
+}
+
+func (a *explorerAPI) writeEvents(
+	conn *websocket.Conn, sub *eventSubscriber, eventTypes map[string]bool,
+	replies <-chan *wsMessage, done <-chan struct{},
+) {
+	var ping = time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	write := func(msg *wsMessage) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(msg)
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case ev, ok := <-sub.ch:
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "subscription closed"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			}
+			if !sub.has(ev.dbID) {
+				// unsubscribed after the event is dispatched
+				continue
+			}
+			for _, msg := range a.formatBlockEvent(ev, eventTypes) {
+				if err := write(msg); err != nil {
+					return
+				}
+			}
+		case msg := <-replies:
+			if err := write(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(
+				websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
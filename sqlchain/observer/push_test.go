@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package observer
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestEventHub(t *testing.T) {
+	Convey("Given an event hub", t, func() {
+		var (
+			hub  = newEventHub()
+			sub1 = hub.subscribe("db1")
+			sub2 = hub.subscribe("db1", "db2")
+		)
+		Convey("The events should be dispatched to subscribers of the database", func() {
+			hub.publish(&blockEvent{dbID: "db2"})
+			So(sub1.ch, ShouldHaveLength, 0)
+			So(sub2.ch, ShouldHaveLength, 1)
+			sub1.add("db2")
+			hub.publish(&blockEvent{dbID: "db2"})
+			So(sub1.ch, ShouldHaveLength, 1)
+			So(sub2.ch, ShouldHaveLength, 2)
+			sub2.remove("db2")
+			hub.publish(&blockEvent{dbID: "db2"})
+			So(sub1.ch, ShouldHaveLength, 2)
+			So(sub2.ch, ShouldHaveLength, 2)
+		})
+		Convey("The slow subscriber should be disconnected", func() {
+			for i := 0; i <= eventBufferSize; i++ {
+				hub.publish(&blockEvent{dbID: "db2"})
+			}
+			So(sub1.ch, ShouldHaveLength, 0)
+			for range sub2.ch {
+			}
+			hub.unsubscribe(sub2)
+			So(hub.subscribers, ShouldHaveLength, 1)
+		})
+		Convey("The subscribers should be closed with hub", func() {
+			hub.close()
+			_, ok := <-sub1.ch
+			So(ok, ShouldBeFalse)
+			_, ok = <-hub.subscribe("db1").ch
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestStreamEvents(t *testing.T) {
+	Convey("Given an observer service with websocket api", t, func() {
+		var (
+			dbID       = proto.DatabaseID("db")
+			client     = proto.NodeID(hash.THashH([]byte("client")).String())
+			miner      = proto.NodeID(hash.THashH([]byte("miner")).String())
+			priv, _, _ = asymmetric.GenSecp256k1KeyPair()
+			now        = time.Now().UTC()
+			s, cleanup = setupTestService(dbID, now)
+			api        = &explorerAPI{service: s}
+			router     = mux.NewRouter()
+			server     *httptest.Server
+			conn       *websocket.Conn
+			err        error
+		)
+		Reset(cleanup)
+
+		// prevent the service from pulling blocks of the database
+		s.subscription.Store(dbID, newSubscribeWorker(dbID, 0, s))
+		router.HandleFunc("/ws", api.StreamEvents)
+		server = httptest.NewServer(router)
+		Reset(server.Close)
+
+		conn, _, err = websocket.DefaultDialer.Dial(
+			"ws"+strings.TrimPrefix(server.URL, "http")+"/ws?db=db&events=block,query", nil)
+		So(err, ShouldBeNil)
+		Reset(func() { _ = conn.Close() })
+
+		var (
+			read = func() (msg *wsMessage) {
+				msg = &wsMessage{}
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				So(conn.ReadJSON(msg), ShouldBeNil)
+				return
+			}
+			addBlock = func(count int32) *types.Block {
+				req, err := buildTestRequest(priv, dbID, client, types.WriteQuery, now)
+				So(err, ShouldBeNil)
+				tx, err := buildTestQueryTx(req, miner)
+				So(err, ShouldBeNil)
+				ack, err := buildTestAck(priv, tx.Response, client)
+				So(err, ShouldBeNil)
+				b := &types.Block{
+					SignedHeader: types.SignedHeader{Header: types.Header{
+						Producer: miner, Timestamp: now.Add(time.Duration(count) * time.Minute),
+					}},
+					QueryTxs: []*types.QueryAsTx{tx},
+					Acks:     []*types.SignedAckHeader{ack},
+				}
+				So(b.PackAndSignBlock(priv), ShouldBeNil)
+				So(s.addBlock(dbID, count, b), ShouldBeNil)
+				return b
+			}
+		)
+
+		Convey("The new blocks and queries should be pushed", func() {
+			// wait for the subscription of the connection
+			for {
+				s.events.Lock()
+				n := len(s.events.subscribers)
+				s.events.Unlock()
+				if n > 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			b := addBlock(1)
+			msg := read()
+			So(msg.Type, ShouldEqual, eventBlock)
+			So(msg.DB, ShouldEqual, dbID)
+			So(msg.Data.(map[string]interface{})["hash"], ShouldEqual, b.BlockHash().String())
+			msg = read()
+			So(msg.Type, ShouldEqual, eventQuery)
+			So(msg.Data.(map[string]interface{})["failed"], ShouldBeFalse)
+
+			Convey("The subscriptions should be changed by client actions", func() {
+				So(conn.WriteJSON(&wsAction{Action: actionUnsubscribe, DB: dbID}), ShouldBeNil)
+				msg = read()
+				So(msg.Type, ShouldEqual, eventUnsubscribed)
+				addBlock(2)
+				So(conn.WriteJSON(&wsAction{Action: "unknown", DB: dbID}), ShouldBeNil)
+				msg = read()
+				So(msg.Type, ShouldEqual, eventError)
+				So(conn.WriteJSON(&wsAction{Action: actionSubscribe, DB: dbID}), ShouldBeNil)
+				msg = read()
+				So(msg.Type, ShouldEqual, eventSubscribed)
+				b = addBlock(3)
+				msg = read()
+				So(msg.Type, ShouldEqual, eventBlock)
+				So(msg.Data.(map[string]interface{})["hash"], ShouldEqual, b.BlockHash().String())
+			})
+			Convey("The connection should be closed on service stopping", func() {
+				s.events.close()
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, _, err = conn.ReadMessage()
+				So(websocket.IsCloseError(err, websocket.CloseGoingAway), ShouldBeTrue)
+			})
+		})
+	})
+}
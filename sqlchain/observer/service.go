@@ -18,9 +18,12 @@ package observer
 
 import (
 	"database/sql"
+	"encoding/json"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -86,6 +89,34 @@ var (
 			"db"		TEXT,
 			"count"	INTEGER
 		)`,
+		`CREATE TABLE IF NOT EXISTS "query" (
+			"db"		TEXT,
+			"hash"		TEXT,
+			"height"	INTEGER,
+			"count"		INTEGER,
+			"offset"	INTEGER,
+			"type"		INTEGER,
+			"node"		TEXT,
+			"timestamp"	INTEGER,
+			"failed"	INTEGER,
+			"response"	TEXT,
+			UNIQUE("db", "hash")
+		)`,
+		`CREATE INDEX IF NOT EXISTS "idx_query_db_timestamp" ON "query" ("db", "timestamp")`,
+		`CREATE TABLE IF NOT EXISTS "query_ack" (
+			"db"		TEXT,
+			"request"	TEXT,
+			"ack"		TEXT,
+			"height"	INTEGER,
+			"offset"	INTEGER,
+			UNIQUE("db", "request")
+		)`,
+		`CREATE TABLE IF NOT EXISTS "membership" (
+			"db"		TEXT,
+			"timestamp"	INTEGER,
+			"miners"	TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS "idx_membership_db_timestamp" ON "membership" ("db", "timestamp")`,
 	}
 	getAllSubscriptionsSQL = `SELECT "db", "count" FROM "subscription"`
 	saveSubscriptionSQL    = `INSERT OR REPLACE INTO "subscription" ("db", "count") VALUES(?, ?)`
@@ -100,8 +131,61 @@ var (
 	getBlockByHeightSQL    = `SELECT "count", "block" FROM "block" WHERE "db" = ? AND "height" = ? LIMIT 1`
 	getBlockByCountSQL     = `SELECT "height", "block" FROM "block" WHERE "db" = ? AND "count" = ? LIMIT 1`
 	getBlockByHashSQL      = `SELECT "height", "count", "block" FROM "block" WHERE "db" = ? AND "hash" = ? LIMIT 1`
+	listBlocksSQL          = `SELECT "height", "count", "block" FROM "block" WHERE "db" = ? ORDER BY "count" DESC LIMIT ? OFFSET ?`
+	countBlocksSQL         = `SELECT COUNT(1) FROM "block" WHERE "db" = ?`
+	saveQuerySQL           = `INSERT OR REPLACE INTO "query" ("db", "hash", "height", "count", "offset", "type", "node", "timestamp", "failed", "response") VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	saveQueryAckSQL        = `INSERT OR REPLACE INTO "query_ack" ("db", "request", "ack", "height", "offset") VALUES(?, ?, ?, ?, ?)`
+	getQueryAckSQL         = `SELECT "height", "offset" FROM "query_ack" WHERE "db" = ? AND "request" = ? LIMIT 1`
+	selectQuerySQL         = `SELECT q."hash", q."height", q."count", q."offset", q."type", q."node", q."timestamp", q."failed", q."response", IFNULL(a."ack", '') FROM "query" AS q LEFT JOIN "query_ack" AS a ON a."db" = q."db" AND a."request" = q."hash"`
+	countQuerySQL          = `SELECT COUNT(1) FROM "query" AS q`
+	saveMembershipSQL      = `INSERT INTO "membership" ("db", "timestamp", "miners") VALUES(?, ?, ?)`
+	getLatestMembershipSQL = `SELECT "miners" FROM "membership" WHERE "db" = ? ORDER BY "timestamp" DESC LIMIT 1`
+	listMembershipSQL      = `SELECT "timestamp", "miners" FROM "membership" WHERE "db" = ? ORDER BY "timestamp"`
 )
 
+// queryFilter defines the filters of the query history listing.
+type queryFilter struct {
+	queryType types.QueryType // types.NumberOfQueryType for any query type
+	node      proto.NodeID
+	since     time.Time
+	until     time.Time
+	failed    *bool
+}
+
+// queryRecord defines an indexed query of the observed blocks.
+type queryRecord struct {
+	Hash      string
+	Height    int32
+	Count     int32
+	Offset    int32
+	QueryType types.QueryType
+	NodeID    proto.NodeID
+	Timestamp time.Time
+	Failed    bool
+	Response  string
+	Ack       string
+}
+
+// blockRecord defines an observed block with its position in the chain.
+type blockRecord struct {
+	Height int32
+	Count  int32
+	Block  *types.Block
+}
+
+// membershipMiner defines a miner of the database in a membership record.
+type membershipMiner struct {
+	NodeID  proto.NodeID `json:"node"`
+	Address string       `json:"address"`
+	Name    string       `json:"name"`
+}
+
+// membershipRecord defines the miners of the database since the record timestamp.
+type membershipRecord struct {
+	Timestamp time.Time
+	Miners    []*membershipMiner
+}
+
 // Service defines the observer service structure.
 type Service struct {
 	subscription    sync.Map // map[proto.DatabaseID]*subscribeWorker
@@ -109,6 +193,7 @@ type Service struct {
 
 	db      *xs.SQLite3
 	caller  *rpc.Caller
+	events  *eventHub
 	stopped int32
 }
 
@@ -139,6 +224,7 @@ func NewService() (service *Service, err error) {
 	service = &Service{
 		db:     db,
 		caller: rpc.NewCallerWithPool(mux.GetSessionPoolInstance()),
+		events: newEventHub(),
 	}
 
 	// load previous subscriptions
@@ -199,6 +285,14 @@ func (s *Service) subscribe(dbID proto.DatabaseID, resetSubscribePosition string
 	return
 }
 
+// ensureSubscribed subscribes the database from the newest block if it's not subscribed yet.
+func (s *Service) ensureSubscribed(dbID proto.DatabaseID) (err error) {
+	if _, ok := s.subscription.Load(dbID); ok {
+		return
+	}
+	return s.subscribe(dbID, "newest")
+}
+
 func unpackWorker(actual interface{}, _ ...interface{}) (worker *subscribeWorker) {
 	if actual == nil {
 		return
@@ -258,6 +352,32 @@ func (s *Service) addAck(dbID proto.DatabaseID, height int32, offset int32, ack
 	_, err = s.db.Writer().Exec(saveAckSQL, string(dbID), ack.Hash().String(), height, offset)
 	if err != nil {
 		err = errors.Wrapf(err, "save ack failed: %s, %s, %d", dbID, ack.Hash().String(), height)
+		return
+	}
+	// index ack by request for the ack status of queries
+	_, err = s.db.Writer().Exec(saveQueryAckSQL,
+		string(dbID), ack.GetRequestHash().String(), ack.Hash().String(), height, offset)
+	if err != nil {
+		err = errors.Wrapf(err, "save query ack failed: %s, %s, %d", dbID, ack.Hash().String(), height)
+	}
+	return
+}
+
+func (s *Service) addQuery(
+	dbID proto.DatabaseID, height, count, offset int32, req *types.Request, resp *types.SignedResponseHeader,
+) (err error) {
+	var (
+		reqHash  = req.Header.Hash()
+		respHash string
+		failed   = resp == nil
+	)
+	if !failed {
+		respHash = resp.Hash().String()
+	}
+	_, err = s.db.Writer().Exec(saveQuerySQL, string(dbID), reqHash.String(), height, count, offset,
+		int(req.Header.QueryType), string(req.Header.NodeID), req.Header.Timestamp.UnixNano(), failed, respHash)
+	if err != nil {
+		err = errors.Wrapf(err, "save query failed: %s, %s, %d", dbID, reqHash.String(), height)
 	}
 	return
 }
@@ -332,8 +452,26 @@ func (s *Service) addBlock(dbID proto.DatabaseID, count int32, b *types.Block) (
 		if err = s.addQueryTracker(dbID, h, int32(i), q); err != nil {
 			return
 		}
+		if err = s.addQuery(dbID, h, count, int32(i), q.Request, q.Response); err != nil {
+			return
+		}
 	}
 
+	// save failed requests
+	for i, req := range b.FailedReqs {
+		if err = s.addQuery(dbID, h, count, int32(i), req, nil); err != nil {
+			return
+		}
+	}
+
+	// push to event subscribers
+	s.events.publish(&blockEvent{
+		dbID:   dbID,
+		count:  count,
+		height: h,
+		block:  b,
+	})
+
 	return
 }
 
@@ -351,12 +489,24 @@ func (s *Service) stop() (err error) {
 		return true
 	})
 
+	// disconnect event subscribers
+	s.events.close()
+
 	// close the subscription database
 	_ = s.db.Close()
 
 	return
 }
 
+func (s *Service) getQueryStats(dbID proto.DatabaseID) (resp *types.QueryStatsResp, err error) {
+	var req = &types.QueryStatsReq{
+		DatabaseID: dbID,
+	}
+	resp = &types.QueryStatsResp{}
+	err = s.minerRequest(dbID, route.DBSQueryStats.String(), req, resp)
+	return
+}
+
 func (s *Service) minerRequest(dbID proto.DatabaseID, method string, request interface{}, response interface{}) (err error) {
 	instance, err := s.getUpstream(dbID)
 	if err != nil {
@@ -374,6 +524,12 @@ func (s *Service) getUpstream(dbID proto.DatabaseID) (instance *types.ServiceIns
 		return
 	}
 
+	return s.refreshUpstream(dbID)
+}
+
+// refreshUpstream reloads the sqlchain profile of the database from block producer and records
+// the miner membership changes.
+func (s *Service) refreshUpstream(dbID proto.DatabaseID) (instance *types.ServiceInstance, err error) {
 	curBP, err := mux.GetCurrentBP()
 	if err != nil {
 		return
@@ -427,6 +583,76 @@ func (s *Service) getUpstream(dbID proto.DatabaseID) (instance *types.ServiceIns
 	}
 	s.upstreamServers.Store(dbID, instance)
 
+	if _, err := s.updateMembership(dbID, profile.Miners, time.Now()); err != nil {
+		log.WithField("db", dbID).WithError(err).Warning("update miner membership failed")
+	}
+
+	return
+}
+
+func (s *Service) updateMembership(
+	dbID proto.DatabaseID, miners []*types.MinerInfo, ts time.Time) (changed bool, err error,
+) {
+	var (
+		members = make([]*membershipMiner, len(miners))
+		encoded []byte
+		latest  string
+	)
+	for i, v := range miners {
+		members[i] = &membershipMiner{
+			NodeID:  v.NodeID,
+			Address: v.Address.String(),
+			Name:    v.Name,
+		}
+	}
+	if encoded, err = json.Marshal(members); err != nil {
+		return
+	}
+	err = s.db.Writer().QueryRow(getLatestMembershipSQL, string(dbID)).Scan(&latest)
+	if err != nil && errors.Cause(err) != sql.ErrNoRows {
+		err = errors.Wrapf(err, "query membership failed: %s", dbID)
+		return
+	}
+	if latest == string(encoded) {
+		return false, nil
+	}
+	if _, err = s.db.Writer().Exec(saveMembershipSQL, string(dbID), ts.UnixNano(), string(encoded)); err != nil {
+		err = errors.Wrapf(err, "save membership failed: %s", dbID)
+		return
+	}
+	return true, nil
+}
+
+func (s *Service) listMembership(dbID proto.DatabaseID) (records []*membershipRecord, err error) {
+	rows, err := s.db.Writer().Query(listMembershipSQL, string(dbID))
+	if err != nil {
+		err = errors.Wrapf(err, "query membership failed: %s", dbID)
+		return
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var (
+			ts      int64
+			encoded string
+			r       = &membershipRecord{}
+		)
+		if err = rows.Scan(&ts, &encoded); err != nil {
+			err = errors.Wrap(err, "scan membership failed")
+			return
+		}
+		if err = json.Unmarshal([]byte(encoded), &r.Miners); err != nil {
+			err = errors.Wrapf(err, "decode membership failed: %s", dbID)
+			return
+		}
+		r.Timestamp = time.Unix(0, ts)
+		records = append(records, r)
+	}
+
+	err = rows.Err()
 	return
 }
 
@@ -669,3 +895,205 @@ func (s *Service) getAllSubscriptions() (subscriptions map[proto.DatabaseID]int3
 
 	return
 }
+
+func (s *Service) listBlocks(
+	dbID proto.DatabaseID, offset, limit int) (blocks []*blockRecord, total int, err error,
+) {
+	if err = s.db.Writer().QueryRow(countBlocksSQL, string(dbID)).Scan(&total); err != nil {
+		err = errors.Wrapf(err, "count blocks failed: %s", dbID)
+		return
+	}
+
+	rows, err := s.db.Writer().Query(listBlocksSQL, string(dbID), limit, offset)
+	if err != nil {
+		err = errors.Wrapf(err, "query blocks failed: %s", dbID)
+		return
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var (
+			blockData []byte
+			r         = &blockRecord{}
+		)
+		if err = rows.Scan(&r.Height, &r.Count, &blockData); err != nil {
+			err = errors.Wrap(err, "scan blocks failed")
+			return
+		}
+		if err = utils.DecodeMsgPack(blockData, &r.Block); err != nil {
+			err = errors.Wrapf(err, "decode block failed: %s", dbID)
+			return
+		}
+		blocks = append(blocks, r)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (f *queryFilter) where(dbID proto.DatabaseID) (clause string, args []interface{}) {
+	var conds = []string{`q."db" = ?`}
+	args = []interface{}{string(dbID)}
+
+	if f.queryType == types.ReadQuery || f.queryType == types.WriteQuery {
+		conds = append(conds, `q."type" = ?`)
+		args = append(args, int(f.queryType))
+	}
+	if f.node != "" {
+		conds = append(conds, `q."node" = ?`)
+		args = append(args, string(f.node))
+	}
+	if !f.since.IsZero() {
+		conds = append(conds, `q."timestamp" >= ?`)
+		args = append(args, f.since.UnixNano())
+	}
+	if !f.until.IsZero() {
+		conds = append(conds, `q."timestamp" < ?`)
+		args = append(args, f.until.UnixNano())
+	}
+	if f.failed != nil {
+		conds = append(conds, `q."failed" = ?`)
+		args = append(args, *f.failed)
+	}
+
+	clause = " WHERE " + strings.Join(conds, " AND ")
+	return
+}
+
+func scanQueryRecord(row interface {
+	Scan(dest ...interface{}) error
+}) (r *queryRecord, err error) {
+	var (
+		queryType int
+		node      string
+		ts        int64
+	)
+	r = &queryRecord{}
+	if err = row.Scan(&r.Hash, &r.Height, &r.Count, &r.Offset, &queryType, &node, &ts,
+		&r.Failed, &r.Response, &r.Ack); err != nil {
+		return
+	}
+	r.QueryType = types.QueryType(queryType)
+	r.NodeID = proto.NodeID(node)
+	r.Timestamp = time.Unix(0, ts)
+	return
+}
+
+func (s *Service) listQueries(
+	dbID proto.DatabaseID, filter *queryFilter, offset, limit int) (records []*queryRecord, total int, err error,
+) {
+	clause, args := filter.where(dbID)
+
+	if err = s.db.Writer().QueryRow(countQuerySQL+clause, args...).Scan(&total); err != nil {
+		err = errors.Wrapf(err, "count queries failed: %s", dbID)
+		return
+	}
+
+	rows, err := s.db.Writer().Query(selectQuerySQL+clause+` ORDER BY q."timestamp" DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		err = errors.Wrapf(err, "query queries failed: %s", dbID)
+		return
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var r *queryRecord
+		if r, err = scanQueryRecord(rows); err != nil {
+			err = errors.Wrap(err, "scan queries failed")
+			return
+		}
+		records = append(records, r)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (s *Service) getTransaction(
+	dbID proto.DatabaseID, h *hash.Hash) (record *queryRecord, b *types.Block, err error,
+) {
+	record, err = scanQueryRecord(s.db.Writer().QueryRow(
+		selectQuerySQL+` WHERE q."db" = ? AND q."hash" = ? LIMIT 1`, string(dbID), h.String()))
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			err = ErrNotFound
+			return
+		}
+
+		err = errors.Wrapf(err, "query transaction failed: %s, %s", dbID, h.String())
+		return
+	}
+
+	// get data from block
+	if _, b, err = s.getBlockByHeight(dbID, record.Height); err != nil {
+		return
+	}
+
+	var req *types.Request
+	if record.Failed {
+		if record.Offset >= 0 && int32(len(b.FailedReqs)) > record.Offset {
+			req = b.FailedReqs[int(record.Offset)]
+		}
+	} else {
+		if record.Offset >= 0 && int32(len(b.QueryTxs)) > record.Offset {
+			req = b.QueryTxs[int(record.Offset)].Request
+		}
+	}
+
+	// verify hash
+	if req == nil {
+		err = ErrInconsistentData
+		return
+	}
+	if reqHash := req.Header.Hash(); !reqHash.IsEqual(h) {
+		err = ErrInconsistentData
+	}
+
+	return
+}
+
+func (s *Service) getQueryAck(dbID proto.DatabaseID, h *hash.Hash) (ack *types.SignedAckHeader, err error) {
+	var (
+		blockHeight int32
+		dataOffset  int32
+	)
+
+	err = s.db.Writer().QueryRow(getQueryAckSQL, string(dbID), h.String()).Scan(&blockHeight, &dataOffset)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			err = ErrNotFound
+			return
+		}
+
+		err = errors.Wrapf(err, "query ack of request failed: %s, %s", dbID, h.String())
+		return
+	}
+
+	// get data from block
+	var b *types.Block
+	if _, b, err = s.getBlockByHeight(dbID, blockHeight); err != nil {
+		return
+	}
+
+	if dataOffset < 0 || int32(len(b.Acks)) <= dataOffset {
+		err = ErrInconsistentData
+		return
+	}
+
+	ack = b.Acks[int(dataOffset)]
+
+	// verify hash
+	reqHash := ack.GetRequestHash()
+	if !reqHash.IsEqual(h) {
+		err = ErrInconsistentData
+	}
+
+	return
+}
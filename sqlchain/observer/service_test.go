@@ -0,0 +1,340 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package observer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func buildTestRequest(
+	priv *asymmetric.PrivateKey, dbID proto.DatabaseID, node proto.NodeID, qt types.QueryType, ts time.Time,
+) (req *types.Request, err error) {
+	req = &types.Request{
+		Envelope: proto.Envelope{
+			NodeID: node.ToRawNodeID(),
+		},
+		Header: types.SignedRequestHeader{
+			RequestHeader: types.RequestHeader{
+				QueryType:  qt,
+				NodeID:     node,
+				DatabaseID: dbID,
+				Timestamp:  ts,
+			},
+		},
+		Payload: types.RequestPayload{
+			Queries: []types.Query{{Pattern: "SELECT 1"}},
+		},
+	}
+	err = req.Sign(priv)
+	return
+}
+
+func buildTestQueryTx(req *types.Request, node proto.NodeID) (qt *types.QueryAsTx, err error) {
+	resp := &types.Response{
+		Header: types.SignedResponseHeader{
+			ResponseHeader: types.ResponseHeader{
+				Request:     req.Header.RequestHeader,
+				RequestHash: req.Header.Hash(),
+				NodeID:      node,
+				Timestamp:   req.Header.Timestamp.Add(time.Millisecond),
+			},
+		},
+	}
+	if err = resp.BuildHash(); err != nil {
+		return
+	}
+	qt = &types.QueryAsTx{
+		Request:  req,
+		Response: &resp.Header,
+	}
+	return
+}
+
+func buildTestAck(
+	priv *asymmetric.PrivateKey, resp *types.SignedResponseHeader, node proto.NodeID,
+) (ack *types.SignedAckHeader, err error) {
+	a := &types.Ack{
+		Header: types.SignedAckHeader{
+			AckHeader: types.AckHeader{
+				Response:     resp.ResponseHeader,
+				ResponseHash: resp.Hash(),
+				NodeID:       node,
+				Timestamp:    resp.Timestamp.Add(time.Millisecond),
+			},
+		},
+	}
+	if err = a.Sign(priv); err != nil {
+		return
+	}
+	ack = &a.Header
+	return
+}
+
+// setupTestService creates an observer service in a temporary working directory, the upstream
+// of the database is set with a genesis block created an hour before now.
+func setupTestService(dbID proto.DatabaseID, now time.Time) (s *Service, cleanup func()) {
+	var (
+		tmp, err = ioutil.TempDir("", "observer")
+		origConf = conf.GConf
+		genesis  *types.Block
+	)
+	So(err, ShouldBeNil)
+	conf.GConf = &conf.Config{
+		WorkingRoot:    tmp,
+		SQLChainPeriod: time.Minute,
+	}
+	cleanup = func() {
+		if s != nil {
+			_ = s.stop()
+		}
+		conf.GConf = origConf
+		_ = os.RemoveAll(tmp)
+	}
+
+	s, err = NewService()
+	So(err, ShouldBeNil)
+	genesis, err = types.CreateRandomBlock(hash.Hash{}, true)
+	So(err, ShouldBeNil)
+	genesis.SignedHeader.Timestamp = now.Add(-time.Hour)
+	s.upstreamServers.Store(dbID, &types.ServiceInstance{
+		DatabaseID:   dbID,
+		GenesisBlock: genesis,
+	})
+	return
+}
+
+func TestServiceIndex(t *testing.T) {
+	Convey("Given an observer service with a known upstream", t, func() {
+		var (
+			dbID       = proto.DatabaseID("db")
+			client     = proto.NodeID(hash.THashH([]byte("client")).String())
+			miner      = proto.NodeID(hash.THashH([]byte("miner")).String())
+			priv, _, _ = asymmetric.GenSecp256k1KeyPair()
+			now        = time.Now().UTC()
+			s, cleanup = setupTestService(dbID, now)
+			err        error
+		)
+		Reset(cleanup)
+
+		Convey("The blocks with queries and acks should be indexed", func() {
+			var (
+				reqs   = make([]*types.Request, 4)
+				txs    = make([]*types.QueryAsTx, 2)
+				ack    *types.SignedAckHeader
+				blocks = make([]*types.Block, 2)
+			)
+			for i := range reqs {
+				qt := types.WriteQuery
+				if i%2 == 1 {
+					qt = types.ReadQuery
+				}
+				reqs[i], err = buildTestRequest(priv, dbID, client, qt, now.Add(time.Duration(i)*time.Second))
+				So(err, ShouldBeNil)
+			}
+			for i := range txs {
+				txs[i], err = buildTestQueryTx(reqs[i], miner)
+				So(err, ShouldBeNil)
+			}
+			ack, err = buildTestAck(priv, txs[0].Response, client)
+			So(err, ShouldBeNil)
+
+			// block #0 contains queries, block #1 contains failed request and ack
+			blocks[0] = &types.Block{
+				SignedHeader: types.SignedHeader{Header: types.Header{
+					Producer: miner, Timestamp: now.Add(-2 * time.Minute),
+				}},
+				QueryTxs: txs,
+			}
+			blocks[1] = &types.Block{
+				SignedHeader: types.SignedHeader{Header: types.Header{
+					Producer: miner, Timestamp: now.Add(-time.Minute), ParentHash: *blocks[0].BlockHash(),
+				}},
+				FailedReqs: reqs[2:3],
+				Acks:       []*types.SignedAckHeader{ack},
+			}
+			for i, b := range blocks {
+				err = b.PackAndSignBlock(priv)
+				So(err, ShouldBeNil)
+				err = s.addBlock(dbID, int32(i), b)
+				So(err, ShouldBeNil)
+			}
+
+			records, total, err := s.listBlocks(dbID, 0, 10)
+			So(err, ShouldBeNil)
+			So(total, ShouldEqual, 2)
+			So(records, ShouldHaveLength, 2)
+			So(records[0].Count, ShouldEqual, 1)
+			So(records[0].Block.BlockHash(), ShouldResemble, blocks[1].BlockHash())
+			records, total, err = s.listBlocks(dbID, 1, 10)
+			So(err, ShouldBeNil)
+			So(total, ShouldEqual, 2)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Count, ShouldEqual, 0)
+
+			queries, total, err := s.listQueries(dbID, &queryFilter{queryType: types.NumberOfQueryType}, 0, 10)
+			So(err, ShouldBeNil)
+			So(total, ShouldEqual, 3)
+			So(queries, ShouldHaveLength, 3)
+			So(queries[0].Hash, ShouldEqual, reqs[2].Header.Hash().String())
+			So(queries[0].Failed, ShouldBeTrue)
+			So(queries[2].Hash, ShouldEqual, reqs[0].Header.Hash().String())
+			So(queries[2].Ack, ShouldEqual, ack.Hash().String())
+			So(queries[2].Response, ShouldEqual, txs[0].Response.Hash().String())
+
+			failed := false
+			queries, total, err = s.listQueries(dbID, &queryFilter{
+				queryType: types.WriteQuery,
+				node:      client,
+				failed:    &failed,
+			}, 0, 10)
+			So(err, ShouldBeNil)
+			So(total, ShouldEqual, 1)
+			So(queries[0].Hash, ShouldEqual, reqs[0].Header.Hash().String())
+			queries, total, err = s.listQueries(dbID, &queryFilter{
+				queryType: types.NumberOfQueryType,
+				since:     now.Add(time.Second),
+				until:     now.Add(2 * time.Second),
+			}, 0, 10)
+			So(err, ShouldBeNil)
+			So(total, ShouldEqual, 1)
+			So(queries[0].Hash, ShouldEqual, reqs[1].Header.Hash().String())
+			queries, total, err = s.listQueries(dbID, &queryFilter{
+				queryType: types.NumberOfQueryType,
+				node:      miner,
+			}, 0, 10)
+			So(err, ShouldBeNil)
+			So(total, ShouldEqual, 0)
+			So(queries, ShouldBeEmpty)
+
+			h := reqs[2].Header.Hash()
+			record, b, err := s.getTransaction(dbID, &h)
+			So(err, ShouldBeNil)
+			So(record.Failed, ShouldBeTrue)
+			So(b.BlockHash(), ShouldResemble, blocks[1].BlockHash())
+			h = reqs[3].Header.Hash()
+			_, _, err = s.getTransaction(dbID, &h)
+			So(err, ShouldEqual, ErrNotFound)
+
+			h = reqs[0].Header.Hash()
+			acked, err := s.getQueryAck(dbID, &h)
+			So(err, ShouldBeNil)
+			So(acked.Hash(), ShouldResemble, ack.Hash())
+			h = reqs[1].Header.Hash()
+			_, err = s.getQueryAck(dbID, &h)
+			So(err, ShouldEqual, ErrNotFound)
+
+			Convey("The v4 api should serve the indexed data", func() {
+				var (
+					api    = &explorerAPI{service: s}
+					router = mux.NewRouter()
+					get    = func(path string) (code int, data map[string]interface{}) {
+						var (
+							rw  = httptest.NewRecorder()
+							res struct {
+								Data map[string]interface{} `json:"data"`
+							}
+						)
+						router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, nil))
+						So(json.Unmarshal(rw.Body.Bytes(), &res), ShouldBeNil)
+						return rw.Code, res.Data
+					}
+				)
+				for _, r := range api.v4Routes() {
+					router.HandleFunc(r.path, r.handler).Methods("GET")
+				}
+
+				code, data := get("/databases/db/blocks?size=1")
+				So(code, ShouldEqual, http.StatusOK)
+				So(data["blocks"], ShouldHaveLength, 1)
+				So(data["pagination"].(map[string]interface{})["total"], ShouldEqual, 2)
+				code, data = get("/databases/db/queries?type=write&failed=true")
+				So(code, ShouldEqual, http.StatusOK)
+				So(data["queries"], ShouldHaveLength, 1)
+				code, _ = get("/databases/db/queries?failed=maybe")
+				So(code, ShouldEqual, http.StatusBadRequest)
+				code, data = get("/databases/db/transactions/" + reqs[0].Header.Hash().String())
+				So(code, ShouldEqual, http.StatusOK)
+				So(data["failed"], ShouldBeFalse)
+				So(data["ack"], ShouldNotBeNil)
+				So(data["response"], ShouldNotBeNil)
+				code, _ = get("/databases/db/transactions/" + reqs[3].Header.Hash().String())
+				So(code, ShouldEqual, http.StatusNotFound)
+				code, data = get("/databases/db/transactions/" + reqs[1].Header.Hash().String() + "/ack")
+				So(code, ShouldEqual, http.StatusOK)
+				So(data["acked"], ShouldBeFalse)
+			})
+		})
+
+		Convey("The miner membership changes should be recorded", func() {
+			var miners = []*types.MinerInfo{
+				{NodeID: miner, Name: "m0"},
+				{NodeID: client, Name: "m1"},
+			}
+			changed, err := s.updateMembership(dbID, miners, now)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeTrue)
+			changed, err = s.updateMembership(dbID, miners, now.Add(time.Second))
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeFalse)
+			changed, err = s.updateMembership(dbID, miners[1:], now.Add(2*time.Second))
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeTrue)
+
+			records, err := s.listMembership(dbID)
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 2)
+			So(records[0].Miners, ShouldHaveLength, 2)
+			So(records[0].Timestamp.UnixNano(), ShouldEqual, now.UnixNano())
+			So(records[1].Miners, ShouldHaveLength, 1)
+			So(records[1].Miners[0].NodeID, ShouldEqual, client)
+		})
+	})
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	Convey("The OpenAPI spec should describe all v4 routes", t, func() {
+		data, err := GenerateOpenAPISpec("test")
+		So(err, ShouldBeNil)
+		var spec struct {
+			OpenAPI string                                       `json:"openapi"`
+			Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+		}
+		err = json.Unmarshal(data, &spec)
+		So(err, ShouldBeNil)
+		So(spec.OpenAPI, ShouldEqual, openAPIVersion)
+		So(spec.Paths, ShouldHaveLength, len((&explorerAPI{}).v4Routes()))
+		op, ok := spec.Paths["/v4/databases/{db}/blocks/height/{height}"]["get"]
+		So(ok, ShouldBeTrue)
+		So(op["operationId"], ShouldEqual, "getDatabasesByDbBlocksHeightByHeight")
+		So(op["parameters"], ShouldHaveLength, 5)
+	})
+}
@@ -29,6 +29,11 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/worker"
 )
 
+var (
+	// membershipRefreshInterval defines the interval to reload miners of the subscribed database.
+	membershipRefreshInterval = time.Minute
+)
+
 type subscribeWorker struct {
 	l      sync.Mutex
 	s      *Service
@@ -50,12 +55,21 @@ func (w *subscribeWorker) run() {
 	defer w.wg.Done()
 
 	// calc next tick
-	var nextTick time.Duration
+	var (
+		nextTick     time.Duration
+		refreshTimer = time.NewTicker(membershipRefreshInterval)
+	)
+
+	defer refreshTimer.Stop()
 
 	for {
 		select {
 		case <-w.stopCh:
 			return
+		case <-refreshTimer.C:
+			if _, err := w.s.refreshUpstream(w.dbID); err != nil {
+				log.WithField("db", w.dbID).WithError(err).Debug("refresh upstream failed")
+			}
 		case <-time.After(nextTick):
 			if err := w.pull(w.getHead()); err != nil {
 				// calc next tick
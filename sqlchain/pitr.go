@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+// RecoveryPointAt returns the recovery point of the last block no higher than height on the
+// current best chain, or the current chain head if height is negative.
+func (c *Chain) RecoveryPointAt(height int32) (point *types.RecoveryPoint, err error) {
+	var head = c.rt.getHead().node
+	if head == nil {
+		err = ErrRecoveryPointNotFound
+		return
+	}
+	if height < 0 {
+		height = head.height
+	}
+	var n = head
+	for ; n != nil && n.height > height; n = n.parent {
+	}
+	if n == nil {
+		err = errors.Wrapf(ErrRecoveryPointNotFound, "no block at or below height %d", height)
+		return
+	}
+	var b *types.Block
+	if b, err = c.fetchBlockByIndexKey(n.indexKey()); err != nil {
+		return
+	}
+	point = newRecoveryPoint(n, b)
+	return
+}
+
+// RecoveryPointBefore returns the recovery point of the last block produced no later than ts on
+// the current best chain.
+func (c *Chain) RecoveryPointBefore(ts time.Time) (point *types.RecoveryPoint, err error) {
+	var head = c.rt.getHead().node
+	// Block height is derived from the block timestamp, so skip the higher blocks directly
+	var n = head
+	for h := c.rt.getHeightFromTime(ts); n != nil && n.height > h; n = n.parent {
+	}
+	for ; n != nil; n = n.parent {
+		var b *types.Block
+		if b, err = c.fetchBlockByIndexKey(n.indexKey()); err != nil {
+			return
+		}
+		if !b.Timestamp().After(ts) {
+			point = newRecoveryPoint(n, b)
+			return
+		}
+	}
+	err = errors.Wrapf(ErrRecoveryPointNotFound,
+		"no block produced before %s", ts.Format(time.RFC3339Nano))
+	return
+}
+
+func newRecoveryPoint(n *blockNode, b *types.Block) *types.RecoveryPoint {
+	return &types.RecoveryPoint{
+		Height:    n.height,
+		Count:     n.count,
+		BlockHash: n.hash,
+		Timestamp: b.Timestamp(),
+	}
+}
+
+// Materialize replays the blocks from genesis to the recovery point into the data file specified
+// by dsn, which restores the database state as of the recovery point. The data file should be a
+// new one, and the chain itself is not affected.
+func (c *Chain) Materialize(ctx context.Context, point *types.RecoveryPoint, dsn string) (err error) {
+	var n = c.bi.lookupNode(&point.BlockHash)
+	if n == nil {
+		err = errors.Wrapf(ErrRecoveryPointNotFound, "unknown block %s", point.BlockHash.String())
+		return
+	}
+	// Collect block nodes back to genesis
+	var nodes = make([]*blockNode, n.count+1)
+	for i := len(nodes) - 1; i >= 0 && n != nil; i, n = i-1, n.parent {
+		nodes[i] = n
+	}
+
+	strg, err := xs.NewSqlite(dsn)
+	if err != nil {
+		err = errors.Wrapf(err, "open data file %s", dsn)
+		return
+	}
+	var st = x.NewState(sql.LevelReadUncommitted, c.rt.getServer(), strg)
+	defer func() {
+		if ierr := st.Close(err == nil); ierr != nil && err == nil {
+			err = errors.Wrap(ierr, "close materialized state")
+		}
+	}()
+
+	le := c.logEntry().WithFields(log.Fields{
+		"height": point.Height,
+		"block":  point.BlockHash.String(),
+	})
+	for _, v := range nodes {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var b *types.Block
+		if b, err = c.fetchBlockByIndexKey(v.indexKey()); err != nil {
+			return
+		}
+		if err = st.ReplayBlockWithContext(ctx, b); err != nil {
+			err = errors.Wrapf(err, "replay block %s at height %d", v.hash.String(), v.height)
+			return
+		}
+	}
+	le.WithField("blocks", len(nodes)).Info("materialized database state")
+	return
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 )
 
@@ -263,6 +264,17 @@ type MinerInfo struct {
 	Deposit        uint64
 	Status         Status
 	EncryptionKey  string
+	// MissedBillings counts the successive billing epochs closed without a report of the miner,
+	// excluded from MarshalHash to keep the miner info hash layout of previous versions
+	MissedBillings uint32 `hsp:"-"`
+}
+
+// BillingReport defines a pending billing report submitted by a miner of the billing epoch.
+type BillingReport struct {
+	Miner  proto.AccountAddress
+	Range  Range
+	Digest hash.Hash
+	Users  []*UserCost
 }
 
 // SQLChainProfile defines a SQLChainProfile related to an account.
@@ -284,6 +296,10 @@ type SQLChainProfile struct {
 	EncodedGenesis []byte
 
 	Meta ResourceMeta // dumped from db creation tx
+
+	// pending billing reports of the current epoch, which starts from LastUpdatedHeight, excluded
+	// from MarshalHash to keep the profile hash layout of previous versions
+	BillingReports []*BillingReport `hsp:"-"`
 }
 
 // ProviderProfile defines a provider list.
@@ -297,6 +313,8 @@ type ProviderProfile struct {
 	GasPrice      uint64
 	TokenType     TokenType // default Particle
 	NodeID        proto.NodeID
+	// Region is excluded from MarshalHash to keep the profile hash layout of previous versions.
+	Region string `hsp:"-"` // the region which the provider is located in
 }
 
 // Account store its balance, and other mate data.
@@ -36,6 +36,55 @@ func (z *Account) Msgsize() (s int) {
 	return
 }
 
+// MarshalHash marshals for hash
+func (z *BillingReport) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 4
+	o = append(o, 0x84)
+	if oTemp, err := z.Digest.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Miner.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Range.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Users)))
+	for za0001 := range z.Users {
+		if z.Users[za0001] == nil {
+			o = hsp.AppendNil(o)
+		} else {
+			if oTemp, err := z.Users[za0001].MarshalHash(); err != nil {
+				return nil, err
+			} else {
+				o = hsp.AppendBytes(o, oTemp)
+			}
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *BillingReport) Msgsize() (s int) {
+	s = 1 + 7 + z.Digest.Msgsize() + 6 + z.Miner.Msgsize() + 6 + z.Range.Msgsize() + 6 + hsp.ArrayHeaderSize
+	for za0001 := range z.Users {
+		if z.Users[za0001] == nil {
+			s += hsp.NilSize
+		} else {
+			s += z.Users[za0001].Msgsize()
+		}
+	}
+	return
+}
+
 // MarshalHash marshals for hash
 func (z *MinerInfo) MarshalHash() (o []byte, err error) {
 	var b []byte
@@ -46,6 +46,43 @@ func BenchmarkAppendMsgAccount(b *testing.B) {
 	}
 }
 
+func TestMarshalHashBillingReport(t *testing.T) {
+	v := BillingReport{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHashBillingReport(b *testing.B) {
+	v := BillingReport{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHash()
+	}
+}
+
+func BenchmarkAppendMsgBillingReport(b *testing.B) {
+	v := BillingReport{}
+	bts := make([]byte, 0, v.Msgsize())
+	bts, _ = v.MarshalHash()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHash()
+	}
+}
+
 func TestMarshalHashMinerInfo(t *testing.T) {
 	v := MinerInfo{}
 	binary.Read(rand.Reader, binary.BigEndian, &v)
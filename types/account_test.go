@@ -19,8 +19,12 @@ package types
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
 )
 
 func TestUserPermissionFromRole(t *testing.T) {
@@ -132,3 +136,91 @@ func TestUserPermission(t *testing.T) {
 		So(state, ShouldBeFalse)
 	})
 }
+
+func TestProfileHashLayout(t *testing.T) {
+	var (
+		addr   = pinnedAccountAddress()
+		nodeID = pinnedNodeID
+	)
+	checkHash := func(v interface{ MarshalHash() ([]byte, error) }, expected string) {
+		enc, err := v.MarshalHash()
+		So(err, ShouldBeNil)
+		So(hash.THashH(enc).String(), ShouldEqual, expected)
+	}
+
+	Convey("provider profile hash should not be changed by region", t, func() {
+		pp := &ProviderProfile{
+			Provider:      addr,
+			Space:         100,
+			Memory:        200,
+			LoadAvgPerCPU: 0.5,
+			TargetUser:    []proto.AccountAddress{addr},
+			Deposit:       10,
+			GasPrice:      1,
+			TokenType:     Particle,
+			NodeID:        nodeID,
+		}
+		checkHash(pp, "a3de4a2bb50ed5ab030e1b565b04bc7086775d2690f1c2e29e7e9fdbbfbb7a27")
+		pp.Region = "us-east"
+		checkHash(pp, "a3de4a2bb50ed5ab030e1b565b04bc7086775d2690f1c2e29e7e9fdbbfbb7a27")
+	})
+	Convey("sqlchain profile hash should not be changed by billing reports", t, func() {
+		sp := &SQLChainProfile{
+			ID:                proto.DatabaseID("db"),
+			Address:           addr,
+			Period:            10,
+			GasPrice:          1,
+			LastUpdatedHeight: 5,
+			TokenType:         Particle,
+			Owner:             addr,
+		}
+		enc, err := sp.MarshalHash()
+		So(err, ShouldBeNil)
+		sp.BillingReports = []*BillingReport{
+			{Miner: addr, Range: Range{From: 5, To: 15}},
+		}
+		checkHash(sp, hash.THashH(enc).String())
+	})
+	Convey("miner info hash should not be changed by missed billings", t, func() {
+		mi := &MinerInfo{
+			Address:        addr,
+			NodeID:         nodeID,
+			Name:           "miner",
+			PendingIncome:  1,
+			ReceivedIncome: 2,
+			Deposit:        3,
+			Status:         Normal,
+			EncryptionKey:  "key",
+		}
+		checkHash(mi, "320e4026581cca348e12c7404ce617a2be71bf4403472e732b26daad9424b335")
+		mi.MissedBillings = 1
+		checkHash(mi, "320e4026581cca348e12c7404ce617a2be71bf4403472e732b26daad9424b335")
+	})
+	Convey("sqlchain profile hash should be kept", t, func() {
+		sp := &SQLChainProfile{
+			ID:                proto.DatabaseID("db"),
+			Address:           addr,
+			Period:            10,
+			GasPrice:          1,
+			LastUpdatedHeight: 5,
+			TokenType:         Particle,
+			Owner:             addr,
+			Miners: []*MinerInfo{
+				{
+					Address:        addr,
+					NodeID:         nodeID,
+					Name:           "miner",
+					PendingIncome:  1,
+					ReceivedIncome: 2,
+					Deposit:        3,
+					Status:         Normal,
+					EncryptionKey:  "key",
+				},
+			},
+			Meta: ResourceMeta{Node: 1},
+		}
+		checkHash(sp, "7879ab0df5e98acefb04eea285492564daa2b8cd65b666a3b23453800da1fc63")
+		sp.Meta.Quota.QueryTimeout = time.Second
+		checkHash(sp, "7879ab0df5e98acefb04eea285492564daa2b8cd65b666a3b23453800da1fc63")
+	})
+}
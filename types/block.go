@@ -172,6 +172,10 @@ func (b *Block) Signee() *ca.PublicKey {
 }
 
 func (b *Block) computeMerkleRoot() hash.Hash {
+	return *b.merkleTree().GetRoot()
+}
+
+func (b *Block) merkleTree() *merkle.Merkle {
 	var hs = make([]*hash.Hash, 0, len(b.FailedReqs)+len(b.QueryTxs)+len(b.Acks))
 	for i := range b.FailedReqs {
 		h := b.FailedReqs[i].Header.Hash()
@@ -185,7 +189,7 @@ func (b *Block) computeMerkleRoot() hash.Hash {
 		h := b.Acks[i].Hash()
 		hs = append(hs, &h)
 	}
-	return *merkle.NewMerkle(hs).GetRoot()
+	return merkle.NewMerkle(hs)
 }
 
 // Blocks is Block (reference) array.
@@ -17,6 +17,8 @@
 package types
 
 import (
+	"time"
+
 	"github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
@@ -187,3 +189,191 @@ type QueryAccountSQLChainProfilesResp struct {
 	Addr     proto.AccountAddress
 	Profiles []*SQLChainProfile
 }
+
+// QueryBPPeersReq defines a request of QueryBPPeers RPC method.
+type QueryBPPeersReq struct {
+	proto.Envelope
+}
+
+// QueryBPPeersResp defines a response of QueryBPPeers RPC method.
+type QueryBPPeersResp struct {
+	proto.Envelope
+	Profile *BPPeersProfile
+}
+
+// MempoolTx defines a pending transaction in the mempool of block producer.
+type MempoolTx struct {
+	Hash     hash.Hash
+	Type     pi.TransactionType
+	Address  proto.AccountAddress
+	Nonce    pi.AccountNonce
+	Priority uint64
+	Received time.Time
+}
+
+// QueryMempoolReq defines a request of QueryMempool RPC method.
+type QueryMempoolReq struct {
+	proto.Envelope
+	// Addr specifies the account of the pending transactions, or all accounts if it's nil.
+	Addr *proto.AccountAddress
+	// Limit specifies the max number of the returned transactions, or no limit if it's 0.
+	Limit uint32
+}
+
+// QueryMempoolResp defines a response of QueryMempool RPC method.
+type QueryMempoolResp struct {
+	proto.Envelope
+	// Total is the total number of the pending transactions matching the request.
+	Total uint32
+	// Txs lists the pending transactions in packing order.
+	Txs []*MempoolTx
+}
+
+// QueryDatabasesByOwnerReq defines a request of QueryDatabasesByOwner RPC method.
+type QueryDatabasesByOwnerReq struct {
+	proto.Envelope
+	Owner proto.AccountAddress
+}
+
+// QueryDatabasesByOwnerResp defines a response of QueryDatabasesByOwner RPC method.
+type QueryDatabasesByOwnerResp struct {
+	proto.Envelope
+	Owner    proto.AccountAddress
+	Profiles []*SQLChainProfile
+}
+
+// QueryDatabaseMinersReq defines a request of QueryDatabaseMiners RPC method.
+type QueryDatabaseMinersReq struct {
+	proto.Envelope
+	DBID proto.DatabaseID
+}
+
+// QueryDatabaseMinersResp defines a response of QueryDatabaseMiners RPC method.
+type QueryDatabaseMinersResp struct {
+	proto.Envelope
+	DBID proto.DatabaseID
+	// Period is the billing term of the database in blocks.
+	Period uint64
+	// LastUpdatedHeight is the main chain height of the last billing update.
+	LastUpdatedHeight uint32
+	// Miners lists the miners serving the database with their deposits, the first one is
+	// the leader.
+	Miners []*MinerInfo
+}
+
+// QueryAccountReq defines a request of QueryAccount RPC method.
+type QueryAccountReq struct {
+	proto.Envelope
+	Addr proto.AccountAddress
+}
+
+// QueryAccountResp defines a response of QueryAccount RPC method.
+type QueryAccountResp struct {
+	proto.Envelope
+	Addr proto.AccountAddress
+	OK   bool
+	// Balances lists the confirmed balances of each token type.
+	Balances [SupportTokenNumber]uint64
+	// Nonce is the next nonce of the account in the confirmed state.
+	Nonce pi.AccountNonce
+	// PendingNonce is the next nonce of the account counting the pending transactions.
+	PendingNonce pi.AccountNonce
+}
+
+// BillingSummary defines the aggregated billing of a database within a block height range.
+type BillingSummary struct {
+	// Receiver is the account address of the database.
+	Receiver proto.AccountAddress
+	// Range is the union of the billing ranges covered by the summary.
+	Range Range
+	// Updates is the number of the billing updates aggregated.
+	Updates uint32
+	// TotalCost is the total cost of all users.
+	TotalCost uint64
+	// Users lists the aggregated cost of each user and the income of its miners.
+	Users []*UserCost
+}
+
+// QueryBillingSummaryReq defines a request of QueryBillingSummary RPC method.
+type QueryBillingSummaryReq struct {
+	proto.Envelope
+	// DBID specifies the database of the summaries, or all databases if it's empty.
+	DBID proto.DatabaseID
+	// From and To specify the main chain block height range [From, To] of the billing updates.
+	From, To uint32
+}
+
+// QueryBillingSummaryResp defines a response of QueryBillingSummary RPC method.
+type QueryBillingSummaryResp struct {
+	proto.Envelope
+	Summaries []*BillingSummary
+}
+
+// FetchSnapshotReq defines a request of FetchSnapshot RPC method.
+type FetchSnapshotReq struct {
+	proto.Envelope
+	// Count specifies the block count of the snapshot, or 0 for the latest one.
+	Count uint32
+	// DigestOnly indicates that only the state hash of the snapshot is requested.
+	DigestOnly bool
+}
+
+// FetchSnapshotResp defines a response of FetchSnapshot RPC method.
+type FetchSnapshotResp struct {
+	proto.Envelope
+	Count     uint32
+	StateHash hash.Hash
+	Snapshot  *BPSnapshot
+}
+
+// ChainEventType defines the type of chain events pushed to subscribers.
+type ChainEventType uint8
+
+const (
+	// ChainEventNewBlock is pushed when a new block is appended to the main chain.
+	ChainEventNewBlock ChainEventType = iota
+	// ChainEventNewTx is pushed when a new transaction is accepted by the transaction pool.
+	ChainEventNewTx
+	// ChainEventAccountChange is pushed when the state of an account is changed by an
+	// irreversible block.
+	ChainEventAccountChange
+)
+
+func (t ChainEventType) String() string {
+	switch t {
+	case ChainEventNewBlock:
+		return "NewBlock"
+	case ChainEventNewTx:
+		return "NewTx"
+	case ChainEventAccountChange:
+		return "AccountChange"
+	default:
+		return "Unknown"
+	}
+}
+
+// SubscribeReq defines a request of the Subscribe streaming RPC method.
+type SubscribeReq struct {
+	proto.Envelope
+	Events []ChainEventType
+	// Accounts filters the transaction and account change events by the involved accounts,
+	// all the events are pushed if it's empty.
+	Accounts []proto.AccountAddress
+}
+
+// ChainEvent defines a chain event pushed by the Subscribe streaming RPC method.
+type ChainEvent struct {
+	Type ChainEventType
+	// Count, Height and BlockHash reference the new block or the block changing the account.
+	Count     uint32
+	Height    uint32
+	BlockHash hash.Hash
+	// Block is the new block of a ChainEventNewBlock event.
+	Block *BPBlock
+	// Tx is the new transaction of a ChainEventNewTx event.
+	Tx pi.Transaction
+	// Account and Txs are the changed account and the transactions changing it of a
+	// ChainEventAccountChange event.
+	Account proto.AccountAddress
+	Txs     []hash.Hash
+}
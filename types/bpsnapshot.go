@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+// BPSnapshot defines the main chain metadata state snapshot at an irreversible block.
+type BPSnapshot struct {
+	Count     uint32
+	Height    uint32
+	Block     *BPBlock
+	Accounts  []*Account
+	Databases []*SQLChainProfile
+	Providers []*ProviderProfile
+	BPPeers   *BPPeersProfile
+	// ChainConfigs lists the chain config updates in ascending order of effective height.
+	ChainConfigs []*ChainConfigProfile
+	// Equivocations lists the recorded block producer equivocations.
+	Equivocations []*EquivocationRecord
+	StateHash     hash.Hash
+}
+
+// Sort sorts the state objects of the snapshot in a deterministic order.
+func (s *BPSnapshot) Sort() {
+	sort.Slice(s.Accounts, func(i, j int) bool {
+		return bytes.Compare(s.Accounts[i].Address[:], s.Accounts[j].Address[:]) < 0
+	})
+	sort.Slice(s.Databases, func(i, j int) bool {
+		return s.Databases[i].ID < s.Databases[j].ID
+	})
+	sort.Slice(s.Providers, func(i, j int) bool {
+		return bytes.Compare(s.Providers[i].Provider[:], s.Providers[j].Provider[:]) < 0
+	})
+	sort.Slice(s.Equivocations, func(i, j int) bool {
+		return bytes.Compare(s.Equivocations[i].Evidence[:], s.Equivocations[j].Evidence[:]) < 0
+	})
+}
+
+// ComputeStateHash computes the hash of the snapshot state, the state objects should be sorted.
+func (s *BPSnapshot) ComputeStateHash() (h hash.Hash, err error) {
+	var (
+		cpy = *s
+		enc *bytes.Buffer
+	)
+	cpy.StateHash = hash.Hash{}
+	if enc, err = utils.EncodeMsgPack(&cpy); err != nil {
+		return
+	}
+	h = hash.THashH(enc.Bytes())
+	return
+}
+
+// SetStateHash sorts the state objects and sets the state hash of the snapshot.
+func (s *BPSnapshot) SetStateHash() (err error) {
+	s.Sort()
+	s.StateHash, err = s.ComputeStateHash()
+	return
+}
+
+// VerifyStateHash verifies the block and the state hash of the snapshot.
+func (s *BPSnapshot) VerifyStateHash() (err error) {
+	var h hash.Hash
+	if s.Block == nil {
+		return ErrNilBlock
+	}
+	if err = s.Block.Verify(); err != nil {
+		return
+	}
+	if h, err = s.ComputeStateHash(); err != nil {
+		return
+	}
+	if !h.IsEqual(&s.StateHash) {
+		return ErrHashVerification
+	}
+	return
+}
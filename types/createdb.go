@@ -41,6 +41,25 @@ func (h *CreateDatabaseHeader) GetAccountNonce() pi.AccountNonce {
 	return h.Nonce
 }
 
+// signedCreateDatabaseHeader defines the signed content of database creation transaction, the
+// extended resource meta fields are appended to the header hash only if set.
+type signedCreateDatabaseHeader struct {
+	*CreateDatabaseHeader
+}
+
+// MarshalHash marshals the header and extended resource meta fields for hash.
+func (h signedCreateDatabaseHeader) MarshalHash() (o []byte, err error) {
+	if o, err = h.CreateDatabaseHeader.MarshalHash(); err != nil {
+		return
+	}
+	var e hashExtension
+	if err = h.ResourceMeta.hashExtension("ResourceMeta.", &e); err != nil {
+		return
+	}
+	o = e.appendTo(o)
+	return
+}
+
 // CreateDatabase defines the database creation transaction.
 type CreateDatabase struct {
 	CreateDatabaseHeader
@@ -58,12 +77,12 @@ func NewCreateDatabase(header *CreateDatabaseHeader) *CreateDatabase {
 
 // Sign implements interfaces/Transaction.Sign.
 func (cd *CreateDatabase) Sign(signer *asymmetric.PrivateKey) (err error) {
-	return cd.DefaultHashSignVerifierImpl.Sign(&cd.CreateDatabaseHeader, signer)
+	return cd.DefaultHashSignVerifierImpl.Sign(signedCreateDatabaseHeader{&cd.CreateDatabaseHeader}, signer)
 }
 
 // Verify implements interfaces/Transaction.Verify.
 func (cd *CreateDatabase) Verify() error {
-	return cd.DefaultHashSignVerifierImpl.Verify(&cd.CreateDatabaseHeader)
+	return cd.DefaultHashSignVerifierImpl.Verify(signedCreateDatabaseHeader{&cd.CreateDatabaseHeader})
 }
 
 // GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
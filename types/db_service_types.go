@@ -29,6 +29,25 @@ type CreateDatabaseRequestHeader struct {
 	ResourceMeta ResourceMeta
 }
 
+// signedCreateDatabaseRequestHeader defines the signed content of create database request header,
+// the extended resource meta fields are appended to the header hash only if set.
+type signedCreateDatabaseRequestHeader struct {
+	*CreateDatabaseRequestHeader
+}
+
+// MarshalHash marshals the header and extended resource meta fields for hash.
+func (h signedCreateDatabaseRequestHeader) MarshalHash() (o []byte, err error) {
+	if o, err = h.CreateDatabaseRequestHeader.MarshalHash(); err != nil {
+		return
+	}
+	var e hashExtension
+	if err = h.ResourceMeta.hashExtension("ResourceMeta.", &e); err != nil {
+		return
+	}
+	o = e.appendTo(o)
+	return
+}
+
 // SignedCreateDatabaseRequestHeader defines signed client create database request header.
 type SignedCreateDatabaseRequestHeader struct {
 	CreateDatabaseRequestHeader
@@ -37,12 +56,12 @@ type SignedCreateDatabaseRequestHeader struct {
 
 // Verify checks hash and signature in create database request header.
 func (sh *SignedCreateDatabaseRequestHeader) Verify() (err error) {
-	return sh.DefaultHashSignVerifierImpl.Verify(&sh.CreateDatabaseRequestHeader)
+	return sh.DefaultHashSignVerifierImpl.Verify(signedCreateDatabaseRequestHeader{&sh.CreateDatabaseRequestHeader})
 }
 
 // Sign the request.
 func (sh *SignedCreateDatabaseRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
-	return sh.DefaultHashSignVerifierImpl.Sign(&sh.CreateDatabaseRequestHeader, signer)
+	return sh.DefaultHashSignVerifierImpl.Sign(signedCreateDatabaseRequestHeader{&sh.CreateDatabaseRequestHeader}, signer)
 }
 
 // CreateDatabaseRequest defines client create database rpc request entity.
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"bytes"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// EquivocationRecord defines an on-chain record of a proved block producer equivocation.
+type EquivocationRecord struct {
+	// Evidence is the identity of the evidence, see EquivocationEvidenceHeader.EvidenceHash.
+	Evidence hash.Hash
+	Producer proto.AccountAddress
+	Reporter proto.AccountAddress
+	// Height is the main chain height which the evidence is packed at.
+	Height uint32
+	// Penalty is the amount of Particle slashed from the producer.
+	Penalty uint64
+}
+
+// EquivocationEvidenceHeader defines the equivocation evidence transaction header.
+type EquivocationEvidenceHeader struct {
+	// First and Second are two different block headers signed by the same producer on the same
+	// parent block, which means that they are produced at the same height.
+	First, Second BPSignedHeader
+	Nonce         pi.AccountNonce
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (h *EquivocationEvidenceHeader) GetAccountNonce() pi.AccountNonce {
+	return h.Nonce
+}
+
+// Producer returns the block producer accused by the evidence.
+func (h *EquivocationEvidenceHeader) Producer() proto.AccountAddress {
+	return h.First.Producer
+}
+
+// EvidenceHash returns the identity of the evidence, which is independent of the order of the
+// two block headers.
+func (h *EquivocationEvidenceHeader) EvidenceHash() hash.Hash {
+	var first, second = h.First.DataHash, h.Second.DataHash
+	if bytes.Compare(first[:], second[:]) > 0 {
+		first, second = second, first
+	}
+	return hash.THashH(append(first[:], second[:]...))
+}
+
+// VerifyProof verifies that the two block headers are validly signed by the same producer on the
+// same parent block, and they are different blocks.
+func (h *EquivocationEvidenceHeader) VerifyProof() (err error) {
+	if h.First.DataHash.IsEqual(&h.Second.DataHash) {
+		return ErrInvalidEvidence
+	}
+	if h.First.Producer != h.Second.Producer || !h.First.ParentHash.IsEqual(&h.Second.ParentHash) {
+		return ErrInvalidEvidence
+	}
+	for _, v := range []*BPSignedHeader{&h.First, &h.Second} {
+		if v.Signee == nil {
+			return ErrInvalidEvidence
+		}
+		if addr, err := crypto.PubKeyHash(v.Signee); err != nil || addr != v.Producer {
+			return ErrInvalidEvidence
+		}
+		if err = v.verify(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// EquivocationEvidence defines the transaction submitting the proof that a block producer has
+// produced two different blocks at the same height.
+type EquivocationEvidence struct {
+	EquivocationEvidenceHeader
+	pi.TransactionTypeMixin
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// NewEquivocationEvidence returns new instance.
+func NewEquivocationEvidence(header *EquivocationEvidenceHeader) *EquivocationEvidence {
+	return &EquivocationEvidence{
+		EquivocationEvidenceHeader: *header,
+		TransactionTypeMixin:       *pi.NewTransactionTypeMixin(pi.TransactionTypeEquivocationEvidence),
+	}
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (e *EquivocationEvidence) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return e.DefaultHashSignVerifierImpl.Sign(&e.EquivocationEvidenceHeader, signer)
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (e *EquivocationEvidence) Verify() (err error) {
+	if err = e.VerifyProof(); err != nil {
+		return
+	}
+	return e.DefaultHashSignVerifierImpl.Verify(&e.EquivocationEvidenceHeader)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (e *EquivocationEvidence) GetAccountAddress() proto.AccountAddress {
+	addr, _ := crypto.PubKeyHash(e.Signee)
+	return addr
+}
+
+func init() {
+	pi.RegisterTransaction(pi.TransactionTypeEquivocationEvidence, (*EquivocationEvidence)(nil))
+}
@@ -34,4 +34,11 @@ var (
 	ErrHashVerification = errors.New("hash verification failed")
 	// ErrInvalidGenesis indicates a failed genesis block verification.
 	ErrInvalidGenesis = errors.New("invalid genesis block")
+	// ErrNilBlock indicates that the block is missing.
+	ErrNilBlock = errors.New("nil block")
+	// ErrInvalidEvidence indicates that the block headers in an equivocation evidence don't
+	// prove a double production.
+	ErrInvalidEvidence = errors.New("invalid equivocation evidence")
+	// ErrResponseNotInBlock indicates that the query response is not packed in the block.
+	ErrResponseNotInBlock = errors.New("response not in block")
 )
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// hashExtension collects the fields added to a signed type after its hash layout was released.
+//
+// These fields are excluded from the generated MarshalHash by `hsp:"-"`, and only the ones set are
+// appended to the hash with their names, so the hashes and signatures of objects created before
+// the fields were added stay valid.
+type hashExtension struct {
+	names  []string
+	values [][]byte
+}
+
+func (e *hashExtension) add(name string, value []byte) {
+	e.names = append(e.names, name)
+	e.values = append(e.values, value)
+}
+
+func (e *hashExtension) appendTo(o []byte) []byte {
+	if len(e.names) == 0 {
+		return o
+	}
+	o = hsp.AppendMapHeader(o, uint32(len(e.names)))
+	for i := range e.names {
+		o = hsp.AppendString(o, e.names[i])
+		o = hsp.AppendBytes(o, e.values[i])
+	}
+	return o
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+// The following hashes and signatures were produced before the extended fields were added.
+const (
+	pinnedSignee = "03d00a94fbb0adf2451af3e1acd5867b83ec0b62eb999a1857d2a4782cd2c93c37"
+
+	pinnedProvideServiceHash      = "c5958647301b1be00775de9d3dc0f0c6a3aa91251582b39a0c1456427288b48c"
+	pinnedProvideServiceSignature = "3045022100ded2a9a87ae31f62ff308e3106f8809f0fe899776c2a66f94d138adf572589280220292b398f5f29eabe20d517473328a4d22329de66d7fe21151e6bd2f7460107e6"
+
+	pinnedCreateDatabaseHash      = "626dd89c46e7827baa9fd6ec4c42485dc2723fa869ea499f822a45daeea463d4"
+	pinnedCreateDatabaseSignature = "3045022100a0ab0920f70c5f07f5b68979268eb557e68417bb1a7f9fef26bd089a60bd167902205ba43219f6dc90eb0ef351d9c186d9315b5a319a619fd938c3213b53bf82084d"
+
+	pinnedCreateDatabaseRequestHash      = "5ca14ad2cefca86caefb15fc39fe64fd1af1c6e641d15ddfcedb37047d94ad93"
+	pinnedCreateDatabaseRequestSignature = "3045022100de97306c9aaac592e9d4027a804b589a0546ea4aa339918b78d057670bf401c202205483d6d78c154e3e0053b767fdb3428f48f85d8d5fcac021e95089bd7c731c8d"
+
+	pinnedInitServiceResponseHash      = "339adaefd8d0a620d63d2a93cb59e91d9b920908638841edd09ba15d1fc69167"
+	pinnedInitServiceResponseSignature = "30440220688c52a363287f6c13eb95365977568cfd5706f0c1b166c2139a2c85cb3538ee0220178132a5fd26383b3d4e8dc84620021da23de4461899242205f5836224c669a4"
+
+	pinnedRequestHash      = "21a265174d7be1d07b446a66f6a6234828b0f1830ebbf1b14f5636202aa90506"
+	pinnedRequestSignature = "3045022100ed126adc71606144f603ab082448734edd025ac5363521b459948f9f0c3754cc02203ae201efbb55041e4593b999df7ef45bd91aa5a73c2d8e330284e33f7f83dc41"
+)
+
+var (
+	pinnedNodeID = proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+)
+
+type signVerifier interface {
+	Sign(*asymmetric.PrivateKey) error
+	Verify() error
+}
+
+func pinnedAccountAddress() (addr proto.AccountAddress) {
+	addr[0] = 1
+	return
+}
+
+func pinnedResourceMeta() ResourceMeta {
+	return ResourceMeta{
+		TargetMiners:     []proto.AccountAddress{pinnedAccountAddress()},
+		Node:             2,
+		Space:            100,
+		Memory:           200,
+		LoadAvgPerCPU:    0.5,
+		EncryptionKey:    "key",
+		ConsistencyLevel: 1,
+		IsolationLevel:   1,
+	}
+}
+
+func setPinnedSignature(v *verifier.DefaultHashSignVerifierImpl, dataHash, signature string) {
+	err := hash.Decode(&v.DataHash, dataHash)
+	So(err, ShouldBeNil)
+	signee, err := hex.DecodeString(pinnedSignee)
+	So(err, ShouldBeNil)
+	v.Signee, err = asymmetric.ParsePubKey(signee)
+	So(err, ShouldBeNil)
+	sig, err := hex.DecodeString(signature)
+	So(err, ShouldBeNil)
+	v.Signature, err = asymmetric.ParseSignature(sig)
+	So(err, ShouldBeNil)
+}
+
+// checkHashExtension checks that the pinned object is valid and the extended fields are covered by
+// signature once set.
+func checkHashExtension(obj signVerifier, setExtension func()) {
+	So(obj.Verify(), ShouldBeNil)
+
+	setExtension()
+	So(obj.Verify(), ShouldNotBeNil)
+
+	privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+	So(err, ShouldBeNil)
+	So(obj.Sign(privKey), ShouldBeNil)
+	So(obj.Verify(), ShouldBeNil)
+}
+
+func TestHashExtension(t *testing.T) {
+	Convey("empty hash extension should not change the encoding", t, func() {
+		var e hashExtension
+		So(e.appendTo([]byte{0x1}), ShouldResemble, []byte{0x1})
+		e.add("Region", []byte{0x2})
+		So(len(e.appendTo([]byte{0x1})), ShouldBeGreaterThan, 1)
+	})
+	Convey("provide service signed before region support should be verified", t, func() {
+		ps := NewProvideService(&ProvideServiceHeader{
+			Space:         100,
+			Memory:        200,
+			LoadAvgPerCPU: 0.5,
+			TargetUser:    []proto.AccountAddress{pinnedAccountAddress()},
+			GasPrice:      1,
+			TokenType:     Particle,
+			NodeID:        pinnedNodeID,
+			Nonce:         1,
+		})
+		setPinnedSignature(&ps.DefaultHashSignVerifierImpl,
+			pinnedProvideServiceHash, pinnedProvideServiceSignature)
+		checkHashExtension(ps, func() { ps.Region = "us-east" })
+
+		// region survives encoding
+		buf, err := utils.EncodeMsgPack(ps)
+		So(err, ShouldBeNil)
+		var decoded *ProvideService
+		err = utils.DecodeMsgPack(buf.Bytes(), &decoded)
+		So(err, ShouldBeNil)
+		So(decoded.Region, ShouldEqual, "us-east")
+		So(decoded.Verify(), ShouldBeNil)
+	})
+	Convey("extended resource meta fields should be covered by signature if set", t, func() {
+		cd := NewCreateDatabase(&CreateDatabaseHeader{
+			Owner: pinnedAccountAddress(),
+			Nonce: 1,
+		})
+		enc, err := cd.CreateDatabaseHeader.MarshalHash()
+		So(err, ShouldBeNil)
+		signed, err := signedCreateDatabaseHeader{&cd.CreateDatabaseHeader}.MarshalHash()
+		So(err, ShouldBeNil)
+		So(signed, ShouldResemble, enc)
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		So(cd.Sign(privKey), ShouldBeNil)
+		for _, set := range []func(m *ResourceMeta){
+			func(m *ResourceMeta) { m.TargetNodes = []proto.NodeID{pinnedNodeID} },
+			func(m *ResourceMeta) { m.Regions = []string{"us-east"} },
+			func(m *ResourceMeta) { m.MaxGasPrice = 10 },
+			func(m *ResourceMeta) { m.Quota.MaxResultSize = 1 << 20 },
+		} {
+			var tx = *cd
+			tx.ResourceMeta = ResourceMeta{}
+			set(&tx.ResourceMeta)
+			So(tx.Verify(), ShouldNotBeNil)
+			So(tx.Sign(privKey), ShouldBeNil)
+			So(tx.Verify(), ShouldBeNil)
+		}
+	})
+	Convey("request staleness bounds should be covered by signature if set", t, func() {
+		req := &Request{
+			Header: SignedRequestHeader{
+				RequestHeader: RequestHeader{
+					QueryType:  ReadQuery,
+					NodeID:     pinnedNodeID,
+					DatabaseID: proto.DatabaseID("db"),
+				},
+			},
+		}
+		enc, err := req.Header.RequestHeader.MarshalHash()
+		So(err, ShouldBeNil)
+		signed, err := signedRequestHeader{&req.Header.RequestHeader}.MarshalHash()
+		So(err, ShouldBeNil)
+		So(signed, ShouldResemble, enc)
+
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		So(req.Sign(privKey), ShouldBeNil)
+		checkHashExtension(req, func() { req.Header.MaxStaleBlocks = 1 })
+		checkHashExtension(req, func() { req.Header.MaxStaleMillis = 1000 })
+	})
+	Convey("request signed before staleness bounds and linearizable support should be verified", t, func() {
+		req := &Request{
+			Header: SignedRequestHeader{
+				RequestHeader: RequestHeader{
+					QueryType:    ReadQuery,
+					NodeID:       pinnedNodeID,
+					DatabaseID:   proto.DatabaseID("db"),
+					ConnectionID: 1,
+					SeqNo:        2,
+					Timestamp:    time.Unix(1560000000, 0).UTC(),
+					BatchCount:   1,
+				},
+			},
+			Payload: RequestPayload{
+				Queries: []Query{{Pattern: "SELECT 1"}},
+			},
+		}
+		err := hash.Decode(&req.Header.QueriesHash,
+			"e08ad84977aa098c2d617defb16be43a400a9e76da6c3975f30147669d5e472f")
+		So(err, ShouldBeNil)
+		setPinnedSignature(&req.Header.DefaultHashSignVerifierImpl,
+			pinnedRequestHash, pinnedRequestSignature)
+		checkHashExtension(req, func() { req.Header.Linearizable = true })
+
+		// the signed flag survives encoding
+		buf, err := utils.EncodeMsgPack(req)
+		So(err, ShouldBeNil)
+		var decoded *Request
+		err = utils.DecodeMsgPack(buf.Bytes(), &decoded)
+		So(err, ShouldBeNil)
+		So(decoded.Header.Linearizable, ShouldBeTrue)
+		So(decoded.Verify(), ShouldBeNil)
+	})
+	Convey("create database signed before resource meta extension should be verified", t, func() {
+		cd := NewCreateDatabase(&CreateDatabaseHeader{
+			Owner:          pinnedAccountAddress(),
+			ResourceMeta:   pinnedResourceMeta(),
+			GasPrice:       1,
+			AdvancePayment: 1000,
+			TokenType:      Particle,
+			Nonce:          2,
+		})
+		setPinnedSignature(&cd.DefaultHashSignVerifierImpl,
+			pinnedCreateDatabaseHash, pinnedCreateDatabaseSignature)
+		checkHashExtension(cd, func() {
+			cd.ResourceMeta.TargetNodes = []proto.NodeID{pinnedNodeID}
+			cd.ResourceMeta.Regions = []string{"us-east"}
+			cd.ResourceMeta.MaxGasPrice = 10
+			cd.ResourceMeta.Quota = ResourceQuota{QueryTimeout: time.Second}
+		})
+
+		// each extended field is covered by signature
+		cd.ResourceMeta.Regions = nil
+		So(cd.Verify(), ShouldNotBeNil)
+	})
+	Convey("create database request signed before resource meta extension should be verified", t, func() {
+		req := &CreateDatabaseRequest{
+			Header: SignedCreateDatabaseRequestHeader{
+				CreateDatabaseRequestHeader: CreateDatabaseRequestHeader{
+					ResourceMeta: pinnedResourceMeta(),
+				},
+			},
+		}
+		setPinnedSignature(&req.Header.DefaultHashSignVerifierImpl,
+			pinnedCreateDatabaseRequestHash, pinnedCreateDatabaseRequestSignature)
+		checkHashExtension(req, func() {
+			req.Header.ResourceMeta.Quota = ResourceQuota{MaxMemory: 1 << 20}
+		})
+	})
+	Convey("init service response signed before resource meta extension should be verified", t, func() {
+		resp := &InitServiceResponse{
+			Header: SignedInitServiceResponseHeader{
+				InitServiceResponseHeader: InitServiceResponseHeader{
+					Instances: []ServiceInstance{
+						{
+							DatabaseID:   proto.DatabaseID("db"),
+							ResourceMeta: pinnedResourceMeta(),
+						},
+					},
+				},
+			},
+		}
+		setPinnedSignature(&resp.Header.DefaultHashSignVerifierImpl,
+			pinnedInitServiceResponseHash, pinnedInitServiceResponseSignature)
+		checkHashExtension(resp, func() {
+			resp.Header.Instances[0].ResourceMeta.Regions = []string{"us-east"}
+		})
+	})
+}
@@ -17,12 +17,17 @@
 package types
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
 //go:generate hsp
+//hsp:shim time.Duration as:int64 using:int64/int64 mode:cast
 
 // InitService defines worker service init request.
 type InitService struct {
@@ -40,6 +45,57 @@ type ResourceMeta struct {
 	UseEventualConsistency bool                   // use eventual consistency replication if enabled
 	ConsistencyLevel       float64                // customized strong consistency level
 	IsolationLevel         int                    // customized isolation level
+
+	// The following fields are hashed by hashExtension only if set to keep hashes of previous
+	// transactions valid.
+	TargetNodes []proto.NodeID `hsp:"-"` // designated miner nodes
+	Regions     []string       `hsp:"-"` // acceptable miner regions, any region if empty
+	MaxGasPrice uint64         `hsp:"-"` // max gas price of each miner, no limit other than GasPrice if 0
+	Quota       ResourceQuota  `hsp:"-"` // resource usage limits enforced by miners
+}
+
+// hashExtension returns the fields of resource meta excluded from MarshalHash which are set.
+func (m *ResourceMeta) hashExtension(prefix string, e *hashExtension) (err error) {
+	if len(m.TargetNodes) > 0 {
+		var o []byte
+		o = hsp.AppendArrayHeader(o, uint32(len(m.TargetNodes)))
+		for i := range m.TargetNodes {
+			var b []byte
+			if b, err = m.TargetNodes[i].MarshalHash(); err != nil {
+				return
+			}
+			o = hsp.AppendBytes(o, b)
+		}
+		e.add(prefix+"TargetNodes", o)
+	}
+	if len(m.Regions) > 0 {
+		var o []byte
+		o = hsp.AppendArrayHeader(o, uint32(len(m.Regions)))
+		for i := range m.Regions {
+			o = hsp.AppendString(o, m.Regions[i])
+		}
+		e.add(prefix+"Regions", o)
+	}
+	if m.MaxGasPrice > 0 {
+		e.add(prefix+"MaxGasPrice", hsp.AppendUint64(nil, m.MaxGasPrice))
+	}
+	if m.Quota != (ResourceQuota{}) {
+		var o []byte
+		if o, err = m.Quota.MarshalHash(); err != nil {
+			return
+		}
+		e.add(prefix+"Quota", o)
+	}
+	return
+}
+
+// ResourceQuota defines the resource usage limits of a database instance, zero means unlimited.
+type ResourceQuota struct {
+	QueryTimeout    time.Duration // max execution time of a read query
+	MaxMemory       uint64        // max page cache memory of a storage connection in bytes
+	MaxTempStorage  uint64        // max temporary storage of a storage connection in bytes
+	MaxResultSize   uint64        // max result size of a read query in bytes
+	MaxDatabaseSize uint64        // max size of the database storage in bytes
 }
 
 // ServiceInstance defines single instance to be initialized.
@@ -61,6 +117,29 @@ type SignedInitServiceResponseHeader struct {
 	verifier.DefaultHashSignVerifierImpl
 }
 
+// signedInitServiceResponseHeader defines the signed content of init service response header, the
+// extended resource meta fields of instances are appended to the header hash only if set.
+type signedInitServiceResponseHeader struct {
+	*InitServiceResponseHeader
+}
+
+// MarshalHash marshals the header and extended resource meta fields for hash.
+func (h signedInitServiceResponseHeader) MarshalHash() (o []byte, err error) {
+	if o, err = h.InitServiceResponseHeader.MarshalHash(); err != nil {
+		return
+	}
+	var e hashExtension
+	for i := range h.Instances {
+		if err = h.Instances[i].ResourceMeta.hashExtension(
+			fmt.Sprintf("Instances.%d.ResourceMeta.", i), &e,
+		); err != nil {
+			return
+		}
+	}
+	o = e.appendTo(o)
+	return
+}
+
 // InitServiceResponse defines worker service init response.
 type InitServiceResponse struct {
 	Header SignedInitServiceResponseHeader
@@ -68,12 +147,12 @@ type InitServiceResponse struct {
 
 // Verify checks hash and signature in init service response header.
 func (sh *SignedInitServiceResponseHeader) Verify() (err error) {
-	return sh.DefaultHashSignVerifierImpl.Verify(&sh.InitServiceResponseHeader)
+	return sh.DefaultHashSignVerifierImpl.Verify(signedInitServiceResponseHeader{&sh.InitServiceResponseHeader})
 }
 
 // Sign the request.
 func (sh *SignedInitServiceResponseHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
-	return sh.DefaultHashSignVerifierImpl.Sign(&sh.InitServiceResponseHeader, signer)
+	return sh.DefaultHashSignVerifierImpl.Sign(signedInitServiceResponseHeader{&sh.InitServiceResponseHeader}, signer)
 }
 
 // Verify checks hash and signature in init service response header.
@@ -121,6 +121,26 @@ func (z *ResourceMeta) Msgsize() (s int) {
 	return
 }
 
+// MarshalHash marshals for hash
+func (z *ResourceQuota) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 5
+	o = append(o, 0x85)
+	o = hsp.AppendUint64(o, z.MaxDatabaseSize)
+	o = hsp.AppendUint64(o, z.MaxMemory)
+	o = hsp.AppendUint64(o, z.MaxResultSize)
+	o = hsp.AppendUint64(o, z.MaxTempStorage)
+	o = hsp.AppendInt64(o, int64(z.QueryTimeout))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ResourceQuota) Msgsize() (s int) {
+	s = 1 + 16 + hsp.Uint64Size + 10 + hsp.Uint64Size + 14 + hsp.Uint64Size + 15 + hsp.Uint64Size + 13 + hsp.Int64Size
+	return
+}
+
 // MarshalHash marshals for hash
 func (z *ServiceInstance) MarshalHash() (o []byte, err error) {
 	var b []byte
@@ -157,6 +157,43 @@ func BenchmarkAppendMsgResourceMeta(b *testing.B) {
 	}
 }
 
+func TestMarshalHashResourceQuota(t *testing.T) {
+	v := ResourceQuota{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHashResourceQuota(b *testing.B) {
+	v := ResourceQuota{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHash()
+	}
+}
+
+func BenchmarkAppendMsgResourceQuota(b *testing.B) {
+	v := ResourceQuota{}
+	bts := make([]byte, 0, v.Msgsize())
+	bts, _ = v.MarshalHash()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHash()
+	}
+}
+
 func TestMarshalHashServiceInstance(t *testing.T) {
 	v := ServiceInstance{}
 	binary.Read(rand.Reader, binary.BigEndian, &v)
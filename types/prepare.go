@@ -0,0 +1,47 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// PrepareReq defines a request of the Prepare RPC method of database miner.
+type PrepareReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+	Pattern    string
+}
+
+// PrepareResp defines a response of the Prepare RPC method of database miner.
+type PrepareResp struct {
+	proto.Envelope
+	StatementID uint64
+	Normalized  string // normalized query text used as the statistics key
+}
+
+// CloseStmtReq defines a request of the CloseStmt RPC method of database miner.
+type CloseStmtReq struct {
+	proto.Envelope
+	DatabaseID  proto.DatabaseID
+	StatementID uint64
+}
+
+// CloseStmtResp defines a response of the CloseStmt RPC method of database miner.
+type CloseStmtResp struct {
+	proto.Envelope
+}
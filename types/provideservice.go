@@ -22,6 +22,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
 //go:generate hsp
@@ -38,6 +39,28 @@ type ProvideServiceHeader struct {
 	TokenType     TokenType
 	NodeID        proto.NodeID
 	Nonce         interfaces.AccountNonce
+	// Region is hashed by signedProvideServiceHeader only if set to keep hashes of previous
+	// transactions valid.
+	Region string `hsp:"-"` // the region which the miner is located in
+}
+
+// signedProvideServiceHeader defines the signed content of provide service transaction, the region
+// is appended to the header hash only if set.
+type signedProvideServiceHeader struct {
+	*ProvideServiceHeader
+}
+
+// MarshalHash marshals the header and region for hash.
+func (h signedProvideServiceHeader) MarshalHash() (o []byte, err error) {
+	if o, err = h.ProvideServiceHeader.MarshalHash(); err != nil {
+		return
+	}
+	var e hashExtension
+	if h.Region != "" {
+		e.add("Region", hsp.AppendString(nil, h.Region))
+	}
+	o = e.appendTo(o)
+	return
 }
 
 // GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
@@ -62,12 +85,12 @@ func NewProvideService(h *ProvideServiceHeader) *ProvideService {
 
 // Sign implements interfaces/Transaction.Sign.
 func (ps *ProvideService) Sign(signer *asymmetric.PrivateKey) (err error) {
-	return ps.DefaultHashSignVerifierImpl.Sign(&ps.ProvideServiceHeader, signer)
+	return ps.DefaultHashSignVerifierImpl.Sign(signedProvideServiceHeader{&ps.ProvideServiceHeader}, signer)
 }
 
 // Verify implements interfaces/Transaction.Verify.
 func (ps *ProvideService) Verify() error {
-	return ps.DefaultHashSignVerifierImpl.Verify(&ps.ProvideServiceHeader)
+	return ps.DefaultHashSignVerifierImpl.Verify(signedProvideServiceHeader{&ps.ProvideServiceHeader})
 }
 
 // GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/merkle"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// QueryProofReq defines a request of the QueryProof RPC method of database miner.
+type QueryProofReq struct {
+	proto.Envelope
+	DatabaseID   proto.DatabaseID
+	ResponseHash hash.Hash
+}
+
+// QueryProof binds a query response to a signed block header of the database sqlchain by the
+// merkle path from the response header hash to the merkle root of the block.
+type QueryProof struct {
+	Header SignedHeader
+	Height int32       // block height in the sqlchain
+	Index  uint64      // leaf index of the response header hash in the block merkle tree
+	Path   []hash.Hash // sibling hashes from the leaf up to the merkle root
+}
+
+// QueryProofResp defines a response of the QueryProof RPC method of database miner.
+type QueryProofResp struct {
+	proto.Envelope
+	Proof QueryProof
+}
+
+// QueryProof builds the proof of the query response with responseHash in the block.
+func (b *Block) QueryProof(responseHash *hash.Hash) (proof *QueryProof, err error) {
+	var index = -1
+	for i, v := range b.QueryTxs {
+		if v.Response.ResponseHash.IsEqual(responseHash) {
+			index = len(b.FailedReqs) + i
+			break
+		}
+	}
+	if index < 0 {
+		err = errors.Wrapf(ErrResponseNotInBlock, "response %s", responseHash)
+		return
+	}
+	proof = &QueryProof{
+		Header: b.SignedHeader,
+		Index:  uint64(index),
+		Path:   b.merkleTree().GetProof(uint64(index)),
+	}
+	return
+}
+
+// Verify verifies that the response header is bound to the block header by the merkle path and
+// the block header is signed by its producer. The caller should verify the payload of the full
+// response with Response.VerifyHash and decide whether the block producer is trusted.
+func (p *QueryProof) Verify(header *SignedResponseHeader) (err error) {
+	if err = header.VerifyHash(); err != nil {
+		return
+	}
+	if !merkle.VerifyProof(&header.ResponseHash, p.Index, p.Path, &p.Header.MerkleRoot) {
+		return ErrMerkleRootVerification
+	}
+	return p.Header.Verify()
+}
+
+// VerifyResponse verifies the payload and header of the response along with the proof.
+func (p *QueryProof) VerifyResponse(resp *Response) (err error) {
+	if err = resp.VerifyHash(); err != nil {
+		return
+	}
+	return p.Verify(&resp.Header)
+}
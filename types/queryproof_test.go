@@ -0,0 +1,87 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+)
+
+func TestQueryProof(t *testing.T) {
+	Convey("Given a block with query responses", t, func() {
+		priv, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+
+		var (
+			block = &Block{
+				SignedHeader: SignedHeader{
+					Header: Header{
+						Version:     0x01000000,
+						GenesisHash: genesisHash,
+						ParentHash:  generateRandomHash(),
+					},
+				},
+				FailedReqs: []*Request{buildRequest(WriteQuery, []Query{buildQuery("INSERT INTO t VALUES (1)")})},
+			}
+			resps []*Response
+		)
+		for i := 0; i < 5; i++ {
+			req := buildRequest(ReadQuery, []Query{buildQuery("SELECT * FROM t WHERE k = ?", i)})
+			resp := buildResponse(&req.Header, []string{"k"}, []string{"INT"}, []ResponseRow{
+				{Values: []interface{}{int64(i)}},
+			})
+			resps = append(resps, resp)
+			block.QueryTxs = append(block.QueryTxs, &QueryAsTx{Request: req, Response: &resp.Header})
+		}
+		err = block.PackAndSignBlock(priv)
+		So(err, ShouldBeNil)
+
+		Convey("The proof of every response should be verified", func() {
+			for _, resp := range resps {
+				proof, err := block.QueryProof(&resp.Header.ResponseHash)
+				So(err, ShouldBeNil)
+				So(proof.VerifyResponse(resp), ShouldBeNil)
+			}
+		})
+		Convey("The proof should not be built for unknown response", func() {
+			_, err := block.QueryProof(&hash.Hash{})
+			So(errors.Cause(err), ShouldEqual, ErrResponseNotInBlock)
+		})
+		Convey("Tampered response or proof should not be verified", func() {
+			proof, err := block.QueryProof(&resps[1].Header.ResponseHash)
+			So(err, ShouldBeNil)
+
+			// tampered payload
+			resps[1].Payload.Rows[0].Values[0] = int64(100)
+			So(proof.VerifyResponse(resps[1]), ShouldNotBeNil)
+			resps[1].Payload.Rows[0].Values[0] = int64(1)
+			So(proof.VerifyResponse(resps[1]), ShouldBeNil)
+
+			// proof of another response
+			So(proof.VerifyResponse(resps[2]), ShouldEqual, ErrMerkleRootVerification)
+
+			// tampered block header
+			proof.Header.Timestamp = proof.Header.Timestamp.Add(1)
+			So(proof.VerifyResponse(resps[1]), ShouldNotBeNil)
+		})
+	})
+}
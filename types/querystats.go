@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// QueryStatsReq defines a request of the QueryStats RPC method of database miner.
+type QueryStatsReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+}
+
+// StatementStats defines the execution statistics of a normalized statement.
+type StatementStats struct {
+	Query        string        // normalized statement with literals replaced by placeholders
+	Calls        uint64        // execution count
+	TotalLatency time.Duration // accumulated execution latency
+	P95Latency   time.Duration // 95th percentile latency of the recent executions
+	Rows         uint64        // accumulated rows returned by reads or affected by writes
+}
+
+// SlowQuery defines a slow query log entry.
+type SlowQuery struct {
+	Time      time.Time     // start time of the query on miner
+	Elapsed   time.Duration // execution time of the query
+	NodeID    proto.NodeID  // request node
+	QueryType QueryType
+	Sample    string // sampled query statements
+}
+
+// QueryStatsResp defines a response of the QueryStats RPC method of database miner.
+type QueryStatsResp struct {
+	proto.Envelope
+	SlowQueryTime time.Duration    // slow query log threshold of the miner
+	Statements    []StatementStats // sorted by total latency in descending order
+	SlowQueries   []SlowQuery      // recent slow queries in chronological order
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
 //go:generate hsp
@@ -67,6 +68,15 @@ type RequestHeader struct {
 	Timestamp    time.Time        `json:"t"`  // time in UTC zone
 	BatchCount   uint64           `json:"bc"` // query count in this request
 	QueriesHash  hash.Hash        `json:"qh"` // hash of query payload
+	// MaxStaleBlocks and MaxStaleMillis bound the staleness of a follower read by the log
+	// index lag behind the leader commit and by the time since the follower caught up with
+	// the leader, zero means the request is not bounded by that measure. They are hashed by
+	// signedRequestHeader only if set to keep signatures of previous requests valid.
+	MaxStaleBlocks int32 `json:"msb" hsp:"-"`
+	MaxStaleMillis int64 `json:"msm" hsp:"-"`
+	// Linearizable requires the read to reflect all the writes acknowledged before it, it's hashed
+	// by signedRequestHeader only if set as well.
+	Linearizable bool `json:"lin" hsp:"-"`
 }
 
 // GetQueryKey returns a unique query key of this request.
@@ -96,6 +106,31 @@ type SignedRequestHeader struct {
 	verifier.DefaultHashSignVerifierImpl
 }
 
+// signedRequestHeader defines the signed content of request header, the staleness bounds and the
+// linearizable flag are appended to the header hash only if set.
+type signedRequestHeader struct {
+	*RequestHeader
+}
+
+// MarshalHash marshals the header, staleness bounds and linearizable flag for hash.
+func (h signedRequestHeader) MarshalHash() (o []byte, err error) {
+	if o, err = h.RequestHeader.MarshalHash(); err != nil {
+		return
+	}
+	var e hashExtension
+	if h.MaxStaleBlocks != 0 {
+		e.add("MaxStaleBlocks", hsp.AppendInt32(nil, h.MaxStaleBlocks))
+	}
+	if h.MaxStaleMillis != 0 {
+		e.add("MaxStaleMillis", hsp.AppendInt64(nil, h.MaxStaleMillis))
+	}
+	if h.Linearizable {
+		e.add("Linearizable", hsp.AppendBool(nil, h.Linearizable))
+	}
+	o = e.appendTo(o)
+	return
+}
+
 // Request defines a complete query request.
 type Request struct {
 	proto.Envelope
@@ -118,12 +153,12 @@ func (t QueryType) String() string {
 
 // Verify checks hash and signature in request header.
 func (sh *SignedRequestHeader) Verify() (err error) {
-	return sh.DefaultHashSignVerifierImpl.Verify(&sh.RequestHeader)
+	return sh.DefaultHashSignVerifierImpl.Verify(signedRequestHeader{&sh.RequestHeader})
 }
 
 // Sign the request.
 func (sh *SignedRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
-	return sh.DefaultHashSignVerifierImpl.Sign(&sh.RequestHeader, signer)
+	return sh.DefaultHashSignVerifierImpl.Sign(signedRequestHeader{&sh.RequestHeader}, signer)
 }
 
 // Verify checks hash and signature in whole request.
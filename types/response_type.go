@@ -92,6 +92,14 @@ type Response struct {
 	Payload ResponsePayload      `json:"p"`
 }
 
+// ResponseStreamHeader defines the leading message of a streamed query response, which is
+// followed by the msgpack encoded ResponseRow of each row.
+type ResponseStreamHeader struct {
+	Header    SignedResponseHeader
+	Columns   []string
+	DeclTypes []string
+}
+
 // BuildHash computes the hash of the response.
 func (r *Response) BuildHash() (err error) {
 	// set rows count
@@ -0,0 +1,44 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// RestoreReq defines a request of the Restore streaming RPC method of database miner.
+type RestoreReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+	// Height is the sqlchain block height to restore the database state at, a negative height
+	// restores the state of the current chain head. It's ignored if Timestamp is set.
+	Height int32
+	// Timestamp restores the database state with the last block produced no later than it.
+	Timestamp time.Time
+}
+
+// RecoveryPoint describes the sqlchain block which a restored database state is materialized at.
+type RecoveryPoint struct {
+	Height    int32
+	Count     int32
+	BlockHash hash.Hash
+	Timestamp time.Time
+	Size      int64 // size of the materialized data file in bytes
+}
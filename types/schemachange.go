@@ -0,0 +1,48 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// SchemaChangeStatusReq defines a request of the SchemaChangeStatus RPC method of database miner.
+type SchemaChangeStatusReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+}
+
+// SchemaChangeStatus describes the progress of the last coordinated schema change of a database,
+// subsequent writes are not accepted by the leader until the schema change is applied by all
+// replicas or the wait times out.
+type SchemaChangeStatus struct {
+	Pending    bool           // replicas are still applying the schema change
+	Queries    []string       // schema change statements
+	LogIndex   uint64         // kayak commit log index of the schema change
+	StartTime  time.Time      // commit time of the schema change on leader
+	FinishTime time.Time      // zero if the schema change is pending
+	Applied    []proto.NodeID // replicas which have applied the schema change
+}
+
+// SchemaChangeStatusResp defines a response of the SchemaChangeStatus RPC method of database miner.
+type SchemaChangeStatusResp struct {
+	proto.Envelope
+	LastCommit uint64 // last committed kayak log index of the responding miner
+	Status     SchemaChangeStatus
+}
@@ -17,9 +17,13 @@
 package types
 
 import (
+	"bytes"
+	"sort"
+
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 )
@@ -53,6 +57,40 @@ type UpdateBillingHeader struct {
 	Version  int32 `hsp:"v,version"`
 }
 
+// BillingDigest returns the digest of the billing range and user costs, which doesn't depend on
+// the order of the users and miners, so the reports of different miners can be compared.
+func (h *UpdateBillingHeader) BillingDigest() (d hash.Hash, err error) {
+	var (
+		cpy = &UpdateBillingHeader{
+			Receiver: h.Receiver,
+			Users:    make([]*UserCost, len(h.Users)),
+			Range:    h.Range,
+			Version:  h.Version,
+		}
+		enc []byte
+	)
+	for i, v := range h.Users {
+		var user = &UserCost{
+			User:   v.User,
+			Cost:   v.Cost,
+			Miners: make([]*MinerIncome, len(v.Miners)),
+		}
+		copy(user.Miners, v.Miners)
+		sort.Slice(user.Miners, func(i, j int) bool {
+			return bytes.Compare(user.Miners[i].Miner[:], user.Miners[j].Miner[:]) < 0
+		})
+		cpy.Users[i] = user
+	}
+	sort.Slice(cpy.Users, func(i, j int) bool {
+		return bytes.Compare(cpy.Users[i].User[:], cpy.Users[j].User[:]) < 0
+	})
+	if enc, err = cpy.MarshalHash(); err != nil {
+		return
+	}
+	d = hash.THashH(enc)
+	return
+}
+
 // UpdateBilling defines the UpdateBilling transaction.
 type UpdateBilling struct {
 	UpdateBillingHeader
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// BPPeersProfile defines the block producer peer list and the node info of the peers.
+type BPPeersProfile struct {
+	Peers proto.Peers
+	Nodes []proto.Node
+}
+
+// Node returns the node info of id in the profile, or nil if it's not found.
+func (p *BPPeersProfile) Node(id proto.NodeID) *proto.Node {
+	for i := range p.Nodes {
+		if p.Nodes[i].ID.IsEqual(&id) {
+			return &p.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// BPNodes returns a copy of the node info with the block producer roles set by the peer list.
+func (p *BPPeersProfile) BPNodes() (nodes []proto.Node) {
+	nodes = make([]proto.Node, len(p.Nodes))
+	for i, v := range p.Nodes {
+		nodes[i] = v
+		if v.ID == p.Peers.Leader {
+			nodes[i].Role = proto.Leader
+		} else {
+			nodes[i].Role = proto.Follower
+		}
+	}
+	return
+}
+
+// UpdateBPPeersHeader defines the block producer peers update transaction header.
+type UpdateBPPeersHeader struct {
+	BPPeersProfile
+	Nonce pi.AccountNonce
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (h *UpdateBPPeersHeader) GetAccountNonce() pi.AccountNonce {
+	return h.Nonce
+}
+
+// UpdateBPPeers defines the block producer peers update transaction, which should be signed by
+// the current leader, while the new peer list is signed by the new leader.
+type UpdateBPPeers struct {
+	UpdateBPPeersHeader
+	pi.TransactionTypeMixin
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// NewUpdateBPPeers returns new instance.
+func NewUpdateBPPeers(header *UpdateBPPeersHeader) *UpdateBPPeers {
+	return &UpdateBPPeers{
+		UpdateBPPeersHeader:  *header,
+		TransactionTypeMixin: *pi.NewTransactionTypeMixin(pi.TransactionTypeUpdateBPPeers),
+	}
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (u *UpdateBPPeers) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return u.DefaultHashSignVerifierImpl.Sign(&u.UpdateBPPeersHeader, signer)
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (u *UpdateBPPeers) Verify() (err error) {
+	if err = u.Peers.Verify(); err != nil {
+		return
+	}
+	return u.DefaultHashSignVerifierImpl.Verify(&u.UpdateBPPeersHeader)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (u *UpdateBPPeers) GetAccountAddress() proto.AccountAddress {
+	addr, _ := crypto.PubKeyHash(u.Signee)
+	return addr
+}
+
+func init() {
+	pi.RegisterTransaction(pi.TransactionTypeUpdateBPPeers, (*UpdateBPPeers)(nil))
+}
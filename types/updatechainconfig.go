@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+//hsp:shim time.Duration as:int64 using:int64/int64 mode:cast
+
+// ChainConfig defines the runtime adjustable block producing parameters of the main chain.
+type ChainConfig struct {
+	Period time.Duration
+	Tick   time.Duration
+}
+
+// ChainConfigProfile defines the chain config which takes effect from the given height.
+type ChainConfigProfile struct {
+	ChainConfig
+	Height uint32
+}
+
+// Signoff signs the chain config profile with the block producer private key.
+func (p *ChainConfigProfile) Signoff(signer *asymmetric.PrivateKey) (
+	signoff *verifier.DefaultHashSignVerifierImpl, err error,
+) {
+	signoff = &verifier.DefaultHashSignVerifierImpl{}
+	if err = signoff.Sign(p, signer); err != nil {
+		signoff = nil
+	}
+	return
+}
+
+// UpdateChainConfigHeader defines the chain config update transaction header.
+type UpdateChainConfigHeader struct {
+	ChainConfigProfile
+	Nonce pi.AccountNonce
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (h *UpdateChainConfigHeader) GetAccountNonce() pi.AccountNonce {
+	return h.Nonce
+}
+
+// UpdateChainConfig defines the chain config update transaction, the new chain config profile
+// should be signed off by the majority of the current block producers.
+type UpdateChainConfig struct {
+	UpdateChainConfigHeader
+	Signoffs []*verifier.DefaultHashSignVerifierImpl
+	pi.TransactionTypeMixin
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// NewUpdateChainConfig returns new instance.
+func NewUpdateChainConfig(header *UpdateChainConfigHeader) *UpdateChainConfig {
+	return &UpdateChainConfig{
+		UpdateChainConfigHeader: *header,
+		TransactionTypeMixin:    *pi.NewTransactionTypeMixin(pi.TransactionTypeUpdateChainConfig),
+	}
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (u *UpdateChainConfig) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return u.DefaultHashSignVerifierImpl.Sign(&u.UpdateChainConfigHeader, signer)
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (u *UpdateChainConfig) Verify() (err error) {
+	for _, v := range u.Signoffs {
+		if v == nil {
+			return ErrSignVerification
+		}
+		if err = v.Verify(&u.ChainConfigProfile); err != nil {
+			return
+		}
+	}
+	return u.DefaultHashSignVerifierImpl.Verify(&u.UpdateChainConfigHeader)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (u *UpdateChainConfig) GetAccountAddress() proto.AccountAddress {
+	addr, _ := crypto.PubKeyHash(u.Signee)
+	return addr
+}
+
+func init() {
+	pi.RegisterTransaction(pi.TransactionTypeUpdateChainConfig, (*UpdateChainConfig)(nil))
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+)
+
+func TestTxUpdateChainConfig(t *testing.T) {
+	Convey("test update chain config", t, func() {
+		priv1, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		priv2, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		addr, err := crypto.PubKeyHash(priv1.PubKey())
+		So(err, ShouldBeNil)
+
+		t := NewUpdateChainConfig(&UpdateChainConfigHeader{
+			ChainConfigProfile: ChainConfigProfile{
+				ChainConfig: ChainConfig{
+					Period: 10 * time.Second,
+					Tick:   time.Second,
+				},
+				Height: 100,
+			},
+			Nonce: 1,
+		})
+		So(t.GetAccountNonce(), ShouldEqual, 1)
+		for _, v := range []*asymmetric.PrivateKey{priv1, priv2} {
+			signoff, err := t.Signoff(v)
+			So(err, ShouldBeNil)
+			t.Signoffs = append(t.Signoffs, signoff)
+		}
+		So(t.Sign(priv1), ShouldBeNil)
+		So(t.Verify(), ShouldBeNil)
+		So(t.GetAccountAddress(), ShouldEqual, addr)
+
+		// Signoffs should be invalidated by any change to the chain config profile
+		t.Tick = 2 * time.Second
+		So(t.Sign(priv1), ShouldBeNil)
+		So(t.Verify(), ShouldNotBeNil)
+	})
+}
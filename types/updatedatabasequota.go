@@ -0,0 +1,74 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// UpdateDatabaseQuotaHeader defines the updating sqlchain resource quota transaction header.
+type UpdateDatabaseQuotaHeader struct {
+	TargetSQLChain proto.AccountAddress
+	Quota          ResourceQuota
+	Nonce          interfaces.AccountNonce
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (u *UpdateDatabaseQuotaHeader) GetAccountNonce() interfaces.AccountNonce {
+	return u.Nonce
+}
+
+// UpdateDatabaseQuota defines the updating sqlchain resource quota transaction.
+type UpdateDatabaseQuota struct {
+	UpdateDatabaseQuotaHeader
+	interfaces.TransactionTypeMixin
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// NewUpdateDatabaseQuota returns new instance.
+func NewUpdateDatabaseQuota(header *UpdateDatabaseQuotaHeader) *UpdateDatabaseQuota {
+	return &UpdateDatabaseQuota{
+		UpdateDatabaseQuotaHeader: *header,
+		TransactionTypeMixin:      *interfaces.NewTransactionTypeMixin(interfaces.TransactionTypeUpdateDatabaseQuota),
+	}
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (uq *UpdateDatabaseQuota) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return uq.DefaultHashSignVerifierImpl.Sign(&uq.UpdateDatabaseQuotaHeader, signer)
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (uq *UpdateDatabaseQuota) Verify() error {
+	return uq.DefaultHashSignVerifierImpl.Verify(&uq.UpdateDatabaseQuotaHeader)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (uq *UpdateDatabaseQuota) GetAccountAddress() proto.AccountAddress {
+	addr, _ := crypto.PubKeyHash(uq.Signee)
+	return addr
+}
+
+func init() {
+	interfaces.RegisterTransaction(interfaces.TransactionTypeUpdateDatabaseQuota, (*UpdateDatabaseQuota)(nil))
+}
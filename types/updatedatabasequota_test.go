@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+)
+
+func TestUpdateDatabaseQuota(t *testing.T) {
+	Convey("test UpdateDatabaseQuota", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		addr, err := crypto.PubKeyHash(privKey.PubKey())
+		So(err, ShouldBeNil)
+
+		uq := NewUpdateDatabaseQuota(&UpdateDatabaseQuotaHeader{
+			TargetSQLChain: addr,
+			Quota: ResourceQuota{
+				QueryTimeout:  time.Second,
+				MaxResultSize: 1 << 20,
+			},
+			Nonce: 2,
+		})
+		So(uq.GetTransactionType(), ShouldEqual, pi.TransactionTypeUpdateDatabaseQuota)
+		So(uq.Sign(privKey), ShouldBeNil)
+		So(uq.Verify(), ShouldBeNil)
+		So(uq.GetAccountAddress(), ShouldEqual, addr)
+		So(uq.GetAccountNonce(), ShouldEqual, 2)
+
+		// tamper the quota after signing
+		uq.Quota.MaxResultSize = 0
+		So(uq.Verify(), ShouldNotBeNil)
+	})
+}
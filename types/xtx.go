@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// PrepareTxReq defines a request of the PrepareTx RPC method of database miner, which prepares
+// a branch of cross database transaction on the database.
+//
+// The Prepare, Commit and Rollback requests are signed by the coordinator in advance: the
+// Prepare request records the prepared transaction in the chain, the Commit request contains
+// the queries of the branch with the commit record, and the Rollback request records the abort
+// of the transaction, which is also applied by the participant on timeout.
+type PrepareTxReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+	XID        string
+	Timeout    time.Duration
+	Prepare    Request
+	Commit     Request
+	Rollback   Request
+}
+
+// PrepareTxResp defines a response of the PrepareTx RPC method of database miner.
+type PrepareTxResp struct {
+	proto.Envelope
+	Response Response // response of the Prepare request
+}
+
+// FinishTxReq defines a request of the FinishTx RPC method of database miner, which commits or
+// rolls back a prepared branch of cross database transaction.
+type FinishTxReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+	XID        string
+	Commit     bool
+}
+
+// FinishTxResp defines a response of the FinishTx RPC method of database miner.
+type FinishTxResp struct {
+	proto.Envelope
+	Response Response // response of the Commit or Rollback request
+}
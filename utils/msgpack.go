@@ -66,3 +66,13 @@ func GetMsgPackServerCodec(c io.ReadWriteCloser) rpc.ServerCodec {
 func GetMsgPackClientCodec(c io.ReadWriteCloser) rpc.ClientCodec {
 	return codec.MsgpackSpecRpc.ClientCodec(c, msgPackHandle)
 }
+
+// GetMsgPackEncoder returns msgpack encoder which writes objects to w.
+func GetMsgPackEncoder(w io.Writer) *codec.Encoder {
+	return codec.NewEncoder(w, msgPackHandle)
+}
+
+// GetMsgPackDecoder returns msgpack decoder which reads objects from r.
+func GetMsgPackDecoder(r io.Reader) *codec.Decoder {
+	return codec.NewDecoder(r, msgPackHandle)
+}
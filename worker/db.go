@@ -20,6 +20,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,6 +31,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	"github.com/CovenantSQL/CovenantSQL/kayak"
 	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
 	kl "github.com/CovenantSQL/CovenantSQL/kayak/wal"
@@ -39,6 +41,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
 )
 
 const (
@@ -69,8 +72,38 @@ const (
 	// LogWaitTimeout defines the missing log wait timeout config.
 	LogWaitTimeout = 10 * time.Second
 
+	// ReplicationBatchSize defines the max total log data size of a batched kayak apply request.
+	ReplicationBatchSize = 1 << 20
+
+	// ReplicationInflightBatches defines the max in-flight batched kayak apply requests of each follower.
+	ReplicationInflightBatches = 4
+
+	// MaxReplicationLag defines the max pending kayak log count of each follower.
+	MaxReplicationLag = 10000
+
+	// StateSyncThreshold defines the min missing log count to catch up by state sync.
+	StateSyncThreshold = 1000
+
+	// LogCompactionInterval defines the log count between kayak log compactions.
+	LogCompactionInterval = 10000
+
+	// ReadLeaseDuration defines the lease duration of linearizable reads.
+	ReadLeaseDuration = 2 * time.Second
+
+	// LinearizableReadTimeout defines the max time to wait for the read index of a linearizable read.
+	LinearizableReadTimeout = 10 * time.Second
+
+	// SchemaChangeTimeout defines the max time the leader waits for replicas to apply a schema change.
+	SchemaChangeTimeout = 10 * time.Minute
+
+	// SchemaChangePollInterval defines the interval of schema change progress polling.
+	SchemaChangePollInterval = 100 * time.Millisecond
+
 	// SlowQuerySampleSize defines the maximum slow query log size (default: 1KB).
 	SlowQuerySampleSize = 1 << 10
+
+	// MaxPreparedStatements defines the maximum prepared statements count of a single client node.
+	MaxPreparedStatements = 1 << 10
 )
 
 // Database defines a single database instance in worker runtime.
@@ -87,6 +120,19 @@ type Database struct {
 	mux            *DBKayakMuxService
 	privateKey     *asymmetric.PrivateKey
 	accountAddr    proto.AccountAddress
+	quota          atomic.Value // types.ResourceQuota
+	timeLimits     atomic.Value // TimeLimits
+	stats          *queryStats
+	keyring        *symmetric.Keyring
+	stmts          *stmtRegistry
+	batcher        *writeBatcher
+	xtxs           preparedTxs
+
+	// schemaLock is held exclusively by schema changes to keep subsequent writes waiting
+	// until the schema change is applied by all replicas
+	schemaLock       sync.RWMutex
+	schemaStatusLock sync.Mutex
+	schemaStatus     types.SchemaChangeStatus
 }
 
 // NewDatabase create a single database instance using config.
@@ -102,6 +148,11 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		return
 	}
 
+	if cfg.ElectionTimeout > 0 && cfg.ElectionTimeout <= ReadLeaseDuration {
+		err = errors.Wrap(ErrInvalidDBConfig, "election timeout must exceed the read lease duration")
+		return
+	}
+
 	// get private key
 	var privateKey *asymmetric.PrivateKey
 	if privateKey, err = kms.GetLocalPrivateKey(); err != nil {
@@ -121,7 +172,14 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		connSeqEvictCh: make(chan uint64, 1),
 		privateKey:     privateKey,
 		accountAddr:    accountAddr,
+		stats:          newQueryStats(),
+		stmts:          newStmtRegistry(),
 	}
+	db.quota.Store(cfg.Quota)
+	db.timeLimits.Store(TimeLimits{
+		MaxWriteTimeGap: cfg.MaxWriteTimeGap,
+		SlowQueryTime:   cfg.SlowQueryTime,
+	})
 
 	defer func() {
 		// on error recycle all resources
@@ -138,6 +196,11 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		}
 	}()
 
+	// init database keyring
+	if db.keyring, err = loadKeyring(cfg.DataDir, privateKey); err != nil {
+		return
+	}
+
 	// init storage
 	storageFile := filepath.Join(cfg.DataDir, StorageFileName)
 	storageDSN, err := storage.NewDSN(storageFile)
@@ -145,8 +208,28 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		return
 	}
 
-	if cfg.EncryptionKey != "" {
-		storageDSN.AddParam("_crypto_key", cfg.EncryptionKey)
+	if db.keyring != nil {
+		var key string
+		if key, err = prepareStorageKey(
+			cfg.DataDir, cfg.EncryptionKey, db.keyring, privateKey.PubKey(), cfg.KeyRotationPeriod,
+		); err != nil {
+			return
+		}
+		storageDSN.AddParam("_crypto_key", key)
+	} else {
+		log.WithField("db", cfg.DatabaseID).Warning("database storage is not encrypted at rest")
+		if cfg.EncryptionKey != "" {
+			storageDSN.AddParam("_crypto_key", cfg.EncryptionKey)
+		}
+	}
+	if cfg.Quota.MaxMemory > 0 {
+		storageDSN.AddParam(xs.ParamMaxMemory, strconv.FormatUint(cfg.Quota.MaxMemory, 10))
+	}
+	if cfg.Quota.MaxTempStorage > 0 {
+		storageDSN.AddParam(xs.ParamMaxTempStorage, strconv.FormatUint(cfg.Quota.MaxTempStorage, 10))
+	}
+	if cfg.Quota.MaxDatabaseSize > 0 {
+		storageDSN.AddParam(xs.ParamMaxDatabaseSize, strconv.FormatUint(cfg.Quota.MaxDatabaseSize, 10))
 	}
 
 	// init chain
@@ -172,6 +255,8 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		LastBillingHeight: cfg.LastBillingHeight,
 		UpdatePeriod:      cfg.UpdateBlockCount,
 		IsolationLevel:    cfg.IsolationLevel,
+		Keyring:           db.keyring,
+		PurgeStaleRecords: cfg.Recreated,
 	}
 	if db.chain, err = sqlchain.NewChain(chainCfg); err != nil {
 		return
@@ -179,10 +264,11 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 	if err = db.chain.Start(); err != nil {
 		return
 	}
+	db.stats.normalize = db.normalizeQuery
 
 	// init kayak config
 	kayakWalPath := filepath.Join(cfg.DataDir, KayakWalFileName)
-	if db.kayakWal, err = kl.NewLevelDBWal(kayakWalPath); err != nil {
+	if db.kayakWal, err = kl.NewEncryptedLevelDBWal(kayakWalPath, db.keyring); err != nil {
 		err = errors.Wrap(err, "init kayak log pool failed")
 		return
 	}
@@ -201,6 +287,25 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		ServiceName:      DBKayakRPCName,
 		ApplyMethodName:  DBKayakApplyMethodName,
 		FetchMethodName:  DBKayakFetchMethodName,
+		SyncMethodName:   DBKayakSyncMethodName,
+		SyncThreshold:    StateSyncThreshold,
+
+		ApplyBatchMethodName: DBKayakApplyBatchMethodName,
+		MaxBatchSize:         ReplicationBatchSize,
+		MaxInflightBatches:   ReplicationInflightBatches,
+		MaxReplicationLag:    MaxReplicationLag,
+
+		ReadIndexMethodName: DBKayakReadIndexMethodName,
+		HeartbeatMethodName: DBKayakHeartbeatMethodName,
+		LeaseDuration:       ReadLeaseDuration,
+
+		SnapshotMethodName: DBKayakSnapshotMethodName,
+		SnapshotInterval:   LogCompactionInterval,
+
+		VoteMethodName:  DBKayakVoteMethodName,
+		ElectionTimeout: cfg.ElectionTimeout,
+		PrivateKey:      db.privateKey,
+		OnLeaderChange:  db.onLeaderChange,
 	}
 
 	// create kayak runtime
@@ -217,6 +322,12 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 	// init sequence eviction processor
 	go db.evictSequences()
 
+	// init write batching
+	if cfg.WriteBatchWindow > 0 {
+		db.batcher = newWriteBatcher(db, cfg.WriteBatchWindow, cfg.MaxWriteBatchSize)
+		db.batcher.start()
+	}
+
 	return
 }
 
@@ -229,6 +340,46 @@ func (db *Database) UpdatePeers(peers *proto.Peers) (err error) {
 	return db.chain.UpdatePeers(peers)
 }
 
+// onLeaderChange applies the peers of the leader elected by kayak to the chain.
+func (db *Database) onLeaderChange(peers *proto.Peers) {
+	log.WithFields(log.Fields{
+		"db":     db.dbID,
+		"leader": peers.Leader,
+		"term":   peers.Term,
+	}).Info("database leader changed")
+
+	if err := db.chain.UpdatePeers(peers); err != nil {
+		log.WithError(err).WithField("db", db.dbID).Warning("update chain peers failed")
+	}
+}
+
+// Quota returns the resource quota of the database.
+func (db *Database) Quota() types.ResourceQuota {
+	return db.quota.Load().(types.ResourceQuota)
+}
+
+// SetQuota updates the resource quota of the database, the memory, temporary storage and database
+// size limits are applied to the storage connections on their next use.
+func (db *Database) SetQuota(quota types.ResourceQuota) {
+	db.quota.Store(quota)
+	db.chain.SetStorageLimits(xs.Limits{
+		MaxMemory:       quota.MaxMemory,
+		MaxTempStorage:  quota.MaxTempStorage,
+		MaxDatabaseSize: quota.MaxDatabaseSize,
+	})
+}
+
+// TimeLimits returns the request time limits of the database.
+func (db *Database) TimeLimits() TimeLimits {
+	return db.timeLimits.Load().(TimeLimits)
+}
+
+// SetTimeLimits updates the request time limits of the database, which take effect on the
+// subsequent requests.
+func (db *Database) SetTimeLimits(limits TimeLimits) {
+	db.timeLimits.Store(limits)
+}
+
 // Query defines database query interface.
 func (db *Database) Query(request *types.Request) (response *types.Response, err error) {
 	// Just need to verify signature in db.saveAck
@@ -243,7 +394,7 @@ func (db *Database) Query(request *types.Request) (response *types.Response, err
 	)
 
 	// log the query if the underlying storage layer take too long to response
-	slowQueryTimer := time.AfterFunc(db.cfg.SlowQueryTime, func() {
+	slowQueryTimer := time.AfterFunc(db.TimeLimits().SlowQueryTime, func() {
 		// mark as slow query
 		atomic.StoreUint32(&isSlowQuery, 1)
 		db.logSlow(request, false, tmStart)
@@ -258,6 +409,26 @@ func (db *Database) Query(request *types.Request) (response *types.Response, err
 
 	switch request.Header.QueryType {
 	case types.ReadQuery:
+		if request.Header.Linearizable {
+			err = db.waitLinearizableRead(request.GetContext())
+		} else {
+			err = db.checkStaleness(&request.Header.RequestHeader)
+		}
+		if err != nil {
+			return
+		}
+		// write queries are not limited as they must be executed identically on all replicas
+		quota := db.Quota()
+		ctx := request.GetContext()
+		if quota.MaxResultSize > 0 {
+			ctx = x.WithMaxResultSize(ctx, quota.MaxResultSize)
+		}
+		if quota.QueryTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, quota.QueryTimeout)
+			defer cancel()
+		}
+		request.SetContext(ctx)
 		if tracker, response, err = db.chain.Query(request, false); err != nil {
 			err = errors.Wrap(err, "failed to query read query")
 			return
@@ -295,6 +466,49 @@ func (db *Database) Query(request *types.Request) (response *types.Response, err
 	}
 	tracker.UpdateResp(response)
 
+	rows := response.Header.RowCount
+	if request.Header.QueryType == types.WriteQuery && response.Header.AffectedRows > 0 {
+		rows = uint64(response.Header.AffectedRows)
+	}
+	db.stats.record(request.Payload.Queries, time.Since(tmStart), rows)
+
+	return
+}
+
+// waitLinearizableRead waits for the local state to reflect all the writes acknowledged before
+// the linearizable read request.
+func (db *Database) waitLinearizableRead(ctx context.Context) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, LinearizableReadTimeout)
+	defer cancel()
+
+	if err = db.kayakRuntime.LinearizableRead(ctx); err != nil {
+		err = errors.Wrap(err, "wait for linearizable read failed")
+	}
+
+	return
+}
+
+// checkStaleness rejects the follower read if the local state lags behind the leader beyond the
+// request bound.
+func (db *Database) checkStaleness(header *types.RequestHeader) (err error) {
+	var (
+		maxLag uint64
+		maxAge time.Duration
+	)
+	if header.MaxStaleBlocks > 0 {
+		maxLag = uint64(header.MaxStaleBlocks)
+	}
+	if header.MaxStaleMillis > 0 {
+		maxAge = time.Duration(header.MaxStaleMillis) * time.Millisecond
+	}
+	if maxLag == 0 && maxAge == 0 {
+		return
+	}
+
+	if err = db.kayakRuntime.CheckStaleness(maxLag, maxAge); err != nil {
+		err = errors.Wrapf(ErrStaleRead, "%v", err)
+	}
+
 	return
 }
 
@@ -320,6 +534,17 @@ func (db *Database) logSlow(request *types.Request, isFinished bool, tmStart tim
 		querySample += "..."
 	}
 
+	elapsed := time.Since(tmStart)
+	if isFinished {
+		db.stats.recordSlow(types.SlowQuery{
+			Time:      tmStart.UTC(),
+			Elapsed:   elapsed,
+			NodeID:    request.Header.NodeID,
+			QueryType: request.Header.QueryType,
+			Sample:    querySample,
+		})
+	}
+
 	log.WithFields(log.Fields{
 		"finished": isFinished,
 		"db":       request.Header.DatabaseID,
@@ -329,7 +554,7 @@ func (db *Database) logSlow(request *types.Request, isFinished bool, tmStart tim
 		"type":     request.Header.QueryType.String(),
 		"sample":   querySample,
 		"start":    tmStart.String(),
-		"elapsed":  time.Now().Sub(tmStart).String(),
+		"elapsed":  elapsed.String(),
 	}).Error("slow query detected")
 }
 
@@ -345,6 +570,16 @@ func (db *Database) Ack(ack *types.Ack) (err error) {
 
 // Shutdown stop database handles and stop service the database.
 func (db *Database) Shutdown() (err error) {
+	if db.kayakRuntime != nil {
+		// rollback prepared cross database transaction
+		db.abortTx()
+	}
+
+	if db.batcher != nil {
+		// stop write batching before kayak
+		db.batcher.stop()
+	}
+
 	if db.kayakRuntime != nil {
 		// shutdown, stop kayak
 		if err = db.kayakRuntime.Shutdown(); err != nil {
@@ -412,9 +647,40 @@ func (db *Database) writeQuery(request *types.Request) (tracker *x.QueryTracker,
 		}
 	}
 
+	var isSchemaChange bool
+	if isSchemaChange, err = db.chain.ContainsDDL(request.Payload.Queries); err != nil {
+		err = errors.Wrap(err, "invalid query")
+		return
+	}
+	if isSchemaChange {
+		db.schemaLock.Lock()
+		defer db.schemaLock.Unlock()
+	} else {
+		db.schemaLock.RLock()
+		defer db.schemaLock.RUnlock()
+
+		// schema changes are never batched to keep the schema change tracking
+		if db.batcher != nil {
+			return db.batcher.submit(request)
+		}
+	}
+
 	// call kayak runtime Process
+	var logIndex uint64
+	if tracker, response, logIndex, err = db.applyWrite(request); err != nil {
+		return
+	}
+	if isSchemaChange {
+		db.waitSchemaChange(request, logIndex)
+	}
+	return
+}
+
+func (db *Database) applyWrite(request *types.Request) (
+	tracker *x.QueryTracker, response *types.Response, logIndex uint64, err error,
+) {
 	var result interface{}
-	if result, _, err = db.kayakRuntime.Apply(request.GetContext(), request); err != nil {
+	if result, logIndex, err = db.kayakRuntime.Apply(request.GetContext(), request); err != nil {
 		err = errors.Wrap(err, "apply failed")
 		return
 	}
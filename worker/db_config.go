@@ -21,8 +21,15 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/sqlchain"
+	"github.com/CovenantSQL/CovenantSQL/types"
 )
 
+// TimeLimits defines the request time limits of a database which can be updated at runtime.
+type TimeLimits struct {
+	MaxWriteTimeGap time.Duration // max time gap between request timestamp and server
+	SlowQueryTime   time.Duration // slow query log threshold
+}
+
 // DBConfig defines the database config.
 type DBConfig struct {
 	DatabaseID             proto.DatabaseID
@@ -39,4 +46,20 @@ type DBConfig struct {
 	ConsistencyLevel       float64
 	IsolationLevel         int
 	SlowQueryTime          time.Duration
+	Quota                  types.ResourceQuota
+	// KeyRotationPeriod rotates the database key on loading if the key is older than the
+	// period, zero to disable rotation.
+	KeyRotationPeriod time.Duration
+	// WriteBatchWindow groups the concurrent write requests arrived within the window into a
+	// single replication log entry, zero to disable write batching.
+	WriteBatchWindow time.Duration
+	// MaxWriteBatchSize limits the request count of a write batch, DefaultMaxWriteBatchSize
+	// if not set.
+	MaxWriteBatchSize int
+	// ElectionTimeout is the base kayak leader election timeout, it must exceed the read lease
+	// duration. Zero disables leader election and keeps the leader of database peers fixed.
+	ElectionTimeout time.Duration
+	// Recreated indicates that the data dir is cleaned up for creating the database, the chain
+	// records left by a destroyed database of the same id are purged.
+	Recreated bool
 }
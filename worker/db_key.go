@@ -0,0 +1,173 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+const (
+	// DatabaseKeyFileName defines the file name of the database keyring wrapped by the node key.
+	DatabaseKeyFileName = "storage.key"
+)
+
+// loadKeyring loads the database keyring from the data dir, a new keyring is created for newly
+// created database. Nil keyring is returned for legacy database which is stored in plain.
+func loadKeyring(dataDir string, privateKey *asymmetric.PrivateKey) (keyring *symmetric.Keyring, err error) {
+	var (
+		keyFile = filepath.Join(dataDir, DatabaseKeyFileName)
+		data    []byte
+	)
+	if data, err = ioutil.ReadFile(keyFile); err == nil {
+		if keyring, err = symmetric.UnwrapKeyring(data, privateKey); err != nil {
+			err = errors.Wrapf(err, "unwrap database keyring %s failed", keyFile)
+		}
+		return
+	} else if !os.IsNotExist(err) {
+		err = errors.Wrapf(err, "read database keyring %s failed", keyFile)
+		return
+	}
+
+	// keep legacy database in plain
+	for _, name := range []string{StorageFileName, KayakWalFileName} {
+		if _, err = os.Stat(filepath.Join(dataDir, name)); err == nil {
+			return
+		} else if !os.IsNotExist(err) {
+			return
+		}
+	}
+
+	if keyring, err = symmetric.NewKeyring(); err != nil {
+		err = errors.Wrap(err, "create database keyring failed")
+		return
+	}
+	if err = saveKeyring(dataDir, keyring, privateKey.PubKey()); err != nil {
+		keyring = nil
+	}
+	return
+}
+
+// saveKeyring wraps the keyring by the node public key and replaces the key file atomically.
+func saveKeyring(dataDir string, keyring *symmetric.Keyring, publicKey *asymmetric.PublicKey) (err error) {
+	var (
+		keyFile = filepath.Join(dataDir, DatabaseKeyFileName)
+		tmpFile = keyFile + ".tmp"
+		data    []byte
+	)
+	if data, err = keyring.Wrap(publicKey); err != nil {
+		err = errors.Wrap(err, "wrap database keyring failed")
+		return
+	}
+	if err = ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		err = errors.Wrapf(err, "write database keyring %s failed", tmpFile)
+		return
+	}
+	if err = os.Rename(tmpFile, keyFile); err != nil {
+		err = errors.Wrapf(err, "replace database keyring %s failed", keyFile)
+	}
+	return
+}
+
+// storageKey derives the sqlite encryption key from the user provided encryption key and the
+// data key.
+func storageKey(encryptionKey string, key *symmetric.DataKey) string {
+	return hex.EncodeToString(hash.THashB(append([]byte(encryptionKey), key.Key...)))
+}
+
+// prepareStorageKey returns the sqlite encryption key of the storage file sealed by the latest
+// data key of the keyring. The storage file sealed by a retired data key is re-keyed, which also
+// completes an interrupted key rotation. If rotation period is set and the latest data key is
+// outdated, a new data key is rotated in and saved before re-keying the storage file.
+func prepareStorageKey(dataDir string, encryptionKey string, keyring *symmetric.Keyring,
+	publicKey *asymmetric.PublicKey, rotationPeriod time.Duration) (key string, err error) {
+	if rotationPeriod > 0 && time.Since(keyring.Current().Created) >= rotationPeriod {
+		if _, err = keyring.Rotate(); err != nil {
+			err = errors.Wrap(err, "rotate database key failed")
+			return
+		}
+		// save keyring first, the storage file is re-keyed on next loading if interrupted
+		if err = saveKeyring(dataDir, keyring, publicKey); err != nil {
+			return
+		}
+	}
+
+	storageFile := filepath.Join(dataDir, StorageFileName)
+	key = storageKey(encryptionKey, keyring.Current())
+	if _, err = os.Stat(storageFile); os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	for _, k := range keyring.Keys() {
+		oldKey := storageKey(encryptionKey, k)
+		if err = checkStorageKey(storageFile, oldKey); err != nil {
+			continue
+		}
+		if oldKey != key {
+			err = rekeyStorage(storageFile, oldKey, key)
+		}
+		return
+	}
+
+	err = errors.Wrapf(ErrInvalidStorageKey, "open storage %s failed", storageFile)
+	return
+}
+
+func openStorageWithKey(storageFile string, key string) (st *xs.SQLite3, err error) {
+	var dsn *storage.DSN
+	if dsn, err = storage.NewDSN(storageFile); err != nil {
+		return
+	}
+	dsn.AddParam("_crypto_key", key)
+	return xs.NewSqlite(dsn.Format())
+}
+
+func checkStorageKey(storageFile string, key string) (err error) {
+	var st *xs.SQLite3
+	if st, err = openStorageWithKey(storageFile, key); err != nil {
+		return
+	}
+	defer st.Close()
+	var count int
+	return st.Writer().QueryRow("SELECT count(*) FROM sqlite_master").Scan(&count)
+}
+
+func rekeyStorage(storageFile string, oldKey string, newKey string) (err error) {
+	var st *xs.SQLite3
+	if st, err = openStorageWithKey(storageFile, oldKey); err != nil {
+		return
+	}
+	defer st.Close()
+	if _, err = st.Writer().Exec("PRAGMA rekey = '" + newKey + "'"); err != nil {
+		err = errors.Wrapf(err, "rekey storage %s failed", storageFile)
+	}
+	return
+}
@@ -32,11 +32,14 @@ import (
 
 // EncodePayload implements kayak.types.Handler.EncodePayload.
 func (db *Database) EncodePayload(request interface{}) (data []byte, err error) {
-	if req, ok := request.(*types.Request); ok {
+	switch req := request.(type) {
+	case *types.Request:
 		data = req.GetMarshalCache()
 		if data != nil {
 			return
 		}
+	case *writeBatch:
+		return db.encodeBatch(req)
 	}
 
 	var buf *bytes.Buffer
@@ -52,6 +55,10 @@ func (db *Database) EncodePayload(request interface{}) (data []byte, err error)
 
 // DecodePayload implements kayak.types.Handler.DecodePayload.
 func (db *Database) DecodePayload(data []byte) (request interface{}, err error) {
+	if len(data) > 0 && data[0] == writeBatchMarker {
+		return db.decodeBatch(data[1:])
+	}
+
 	var req *types.Request
 
 	if err = utils.DecodeMsgPack(data, &req); err != nil {
@@ -65,27 +72,75 @@ func (db *Database) DecodePayload(data []byte) (request interface{}, err error)
 	return
 }
 
-// Check implements kayak.types.Handler.Check.
-func (db *Database) Check(rawReq interface{}) (err error) {
-	var req *types.Request
-	var ok bool
-	if req, ok = rawReq.(*types.Request); !ok || req == nil {
-		err = errors.Wrap(ErrInvalidRequest, "invalid request payload")
+func (db *Database) encodeBatch(wb *writeBatch) (data []byte, err error) {
+	var payload = &writeBatchPayload{
+		Requests: make([][]byte, len(wb.Requests)),
+	}
+	for i, req := range wb.Requests {
+		if payload.Requests[i], err = db.EncodePayload(req); err != nil {
+			return
+		}
+	}
+
+	var buf *bytes.Buffer
+	if buf, err = utils.EncodeMsgPack(payload); err != nil {
+		err = errors.Wrap(err, "encode write batch failed")
 		return
 	}
 
-	// verify signature, check time/sequence only
-	if err = req.Verify(); err != nil {
+	data = append([]byte{writeBatchMarker}, buf.Bytes()...)
+	return
+}
+
+func (db *Database) decodeBatch(data []byte) (wb *writeBatch, err error) {
+	var payload *writeBatchPayload
+	if err = utils.DecodeMsgPack(data, &payload); err != nil {
+		err = errors.Wrap(err, "decode write batch failed")
 		return
 	}
 
-	// verify timestamp
-	nowTime := getLocalTime()
-	minTime := nowTime.Add(-db.cfg.MaxWriteTimeGap)
-	maxTime := nowTime.Add(db.cfg.MaxWriteTimeGap)
+	wb = &writeBatch{
+		Requests: make([]*types.Request, len(payload.Requests)),
+	}
+	for i, v := range payload.Requests {
+		var req interface{}
+		if req, err = db.DecodePayload(v); err != nil {
+			return
+		}
+		var ok bool
+		if wb.Requests[i], ok = req.(*types.Request); !ok {
+			err = errors.Wrap(ErrInvalidRequest, "nested write batch")
+			return
+		}
+	}
+	return
+}
 
-	if req.Header.Timestamp.Before(minTime) || req.Header.Timestamp.After(maxTime) {
-		err = errors.Wrap(ErrInvalidRequest, "invalid request time")
+// Check implements kayak.types.Handler.Check.
+func (db *Database) Check(rawReq interface{}) (err error) {
+	switch req := rawReq.(type) {
+	case *types.Request:
+		if req != nil {
+			return db.checkRequest(req)
+		}
+	case *writeBatch:
+		if req != nil {
+			for i, r := range req.Requests {
+				if err = db.checkRequest(r); err != nil {
+					err = errors.Wrapf(err, "check batched request #%d failed", i)
+					return
+				}
+			}
+			return
+		}
+	}
+	err = errors.Wrap(ErrInvalidRequest, "invalid request payload")
+	return
+}
+
+func (db *Database) checkRequest(req *types.Request) (err error) {
+	// verify signature, check time/sequence only
+	if err = db.verifyRequest(req); err != nil {
 		return
 	}
 
@@ -100,6 +155,37 @@ func (db *Database) Check(rawReq interface{}) (err error) {
 	return
 }
 
+// precheckRequest checks the request to be batched without recording its sequence, seqs
+// contains the sequences of the requests already in the batch.
+func (db *Database) precheckRequest(req *types.Request, seqs map[uint64]uint64) (err error) {
+	if err = db.verifyRequest(req); err != nil {
+		return
+	}
+	if lastSeq, ok := seqs[req.Header.ConnectionID]; ok && req.Header.SeqNo <= lastSeq {
+		return ErrInvalidRequestSeq
+	}
+	return db.verifySequence(req.Header.ConnectionID, req.Header.SeqNo)
+}
+
+func (db *Database) verifyRequest(req *types.Request) (err error) {
+	if err = req.Verify(); err != nil {
+		return
+	}
+
+	// verify timestamp
+	nowTime := getLocalTime()
+	timeGap := db.TimeLimits().MaxWriteTimeGap
+	minTime := nowTime.Add(-timeGap)
+	maxTime := nowTime.Add(timeGap)
+
+	if req.Header.Timestamp.Before(minTime) || req.Header.Timestamp.After(maxTime) {
+		err = errors.Wrap(ErrInvalidRequest, "invalid request time")
+		return
+	}
+
+	return
+}
+
 // TrackerAndResponse defines a query tracker used by xenomint and an unsigned response.
 type TrackerAndResponse struct {
 	Tracker  *x.QueryTracker
@@ -115,6 +201,11 @@ func (db *Database) Commit(rawReq interface{}, isLeader bool) (result interface{
 		tracker  *x.QueryTracker
 		ok       bool
 	)
+	if wb, isBatch := rawReq.(*writeBatch); isBatch && wb != nil {
+		// errors of batched requests are returned to each request separately
+		result = db.commitBatch(wb, isLeader)
+		return
+	}
 	if req, ok = rawReq.(*types.Request); !ok || req == nil {
 		err = errors.Wrap(ErrInvalidRequest, "invalid request payload")
 		return
@@ -134,6 +225,80 @@ func (db *Database) Commit(rawReq interface{}, isLeader bool) (result interface{
 	return
 }
 
+// Digest implements kayak.types.StateSyncer.Digest.
+func (db *Database) Digest() (digest []byte, err error) {
+	var (
+		d   *x.StateDigest
+		buf *bytes.Buffer
+	)
+	if d, err = db.chain.StateDigest(); err != nil {
+		return
+	}
+	if buf, err = utils.EncodeMsgPack(d); err != nil {
+		err = errors.Wrap(err, "encode state digest failed")
+		return
+	}
+	digest = buf.Bytes()
+	return
+}
+
+// Delta implements kayak.types.StateSyncer.Delta.
+func (db *Database) Delta(digest []byte) (delta []byte, err error) {
+	var (
+		d   *x.StateDigest
+		dt  *x.StateDelta
+		buf *bytes.Buffer
+	)
+	if err = utils.DecodeMsgPack(digest, &d); err != nil {
+		err = errors.Wrap(err, "decode state digest failed")
+		return
+	}
+	if dt, err = db.chain.StateDelta(d); err != nil {
+		return
+	}
+	if buf, err = utils.EncodeMsgPack(dt); err != nil {
+		err = errors.Wrap(err, "encode state delta failed")
+		return
+	}
+	delta = buf.Bytes()
+	return
+}
+
+// ApplyDelta implements kayak.types.StateSyncer.ApplyDelta.
+func (db *Database) ApplyDelta(delta []byte) (err error) {
+	var dt *x.StateDelta
+	if err = utils.DecodeMsgPack(delta, &dt); err != nil {
+		err = errors.Wrap(err, "decode state delta failed")
+		return
+	}
+	return db.chain.ApplyStateDelta(dt)
+}
+
+// Snapshot implements kayak.types.Snapshotter.Snapshot.
+func (db *Database) Snapshot() (snapshot []byte, err error) {
+	var (
+		dt  *x.StateDelta
+		buf *bytes.Buffer
+	)
+	// the delta against an empty state carries the full state
+	if dt, err = db.chain.StateDelta(&x.StateDigest{}); err != nil {
+		return
+	}
+	// replace the local schema even if the snapshot is empty
+	dt.SchemaChanged = true
+	if buf, err = utils.EncodeMsgPack(dt); err != nil {
+		err = errors.Wrap(err, "encode state snapshot failed")
+		return
+	}
+	snapshot = buf.Bytes()
+	return
+}
+
+// InstallSnapshot implements kayak.types.Snapshotter.InstallSnapshot.
+func (db *Database) InstallSnapshot(snapshot []byte) (err error) {
+	return db.ApplyDelta(snapshot)
+}
+
 func (db *Database) recordSequence(connID uint64, seqNo uint64) {
 	db.connSeqs.Store(connID, seqNo)
 }
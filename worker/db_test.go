@@ -20,22 +20,28 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
 	"github.com/CovenantSQL/CovenantSQL/sqlchain"
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
 )
 
 var rootHash = hash.Hash{}
@@ -76,6 +82,8 @@ func TestSingleDatabase(t *testing.T) {
 			ChainMux:         chainMuxService,
 			MaxWriteTimeGap:  time.Second * 5,
 			UpdateBlockCount: 2,
+			// chain records of the fixed database id may be left by previous tests
+			Recreated: true,
 		}
 
 		// create genesis block
@@ -379,6 +387,630 @@ func TestSingleDatabase(t *testing.T) {
 	})
 }
 
+func TestDatabaseRestore(t *testing.T) {
+	Convey("test restore", t, func() {
+		var err error
+		var server *rpc.Server
+		var cleanup func()
+		cleanup, server, err = initNode()
+		So(err, ShouldBeNil)
+
+		var rootDir string
+		rootDir, err = ioutil.TempDir("", "db_test_")
+		So(err, ShouldBeNil)
+
+		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
+		So(err, ShouldBeNil)
+		chainMuxService, err := sqlchain.NewMuxService("sqlchain", server)
+		So(err, ShouldBeNil)
+
+		var peers *proto.Peers
+		peers, err = getPeers(1)
+		So(err, ShouldBeNil)
+
+		// use a fresh database id, the chain blocks are kept across test runs
+		cfg := &DBConfig{
+			DatabaseID:       proto.FromAccountAndNonce(proto.AccountAddress{}, uint32(time.Now().Unix())),
+			DataDir:          rootDir,
+			KayakMux:         kayakMuxService,
+			ChainMux:         chainMuxService,
+			MaxWriteTimeGap:  time.Second * 5,
+			UpdateBlockCount: 2,
+		}
+
+		var block *types.Block
+		block, err = types.CreateRandomBlock(rootHash, true)
+		So(err, ShouldBeNil)
+
+		var db *Database
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		defer func() {
+			db.Shutdown()
+			os.RemoveAll(rootDir)
+			cleanup()
+		}()
+
+		waitHead := func(count int32) (point *types.RecoveryPoint) {
+			for i := 0; i < 100; i++ {
+				if point, err = db.chain.RecoveryPointAt(-1); err == nil && point.Count >= count {
+					return
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+			return
+		}
+		countRows := func(dataFile string) (count int) {
+			strg, err := xs.NewSqlite(dataFile)
+			So(err, ShouldBeNil)
+			defer strg.Close()
+			err = strg.Reader().QueryRow("select count(1) from test").Scan(&count)
+			So(err, ShouldBeNil)
+			return
+		}
+
+		var writeQuery *types.Request
+		writeQuery, err = buildQuery(types.WriteQuery, 1, 1, []string{
+			"create table test (test int)",
+			"insert into test values(1)",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(err, ShouldBeNil)
+		first := waitHead(1)
+		So(first, ShouldNotBeNil)
+		So(first.Count, ShouldBeGreaterThanOrEqualTo, 1)
+
+		writeQuery, err = buildQuery(types.WriteQuery, 1, 2, []string{
+			"insert into test values(2)",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(err, ShouldBeNil)
+		last := waitHead(first.Count + 1)
+		So(last, ShouldNotBeNil)
+		So(last.Count, ShouldBeGreaterThan, first.Count)
+
+		ctx := context.Background()
+		point, err := db.Restore(ctx, first.Height, time.Time{}, filepath.Join(rootDir, "first.db3"))
+		So(err, ShouldBeNil)
+		So(point.BlockHash, ShouldResemble, first.BlockHash)
+		So(point.Size, ShouldBeGreaterThan, 0)
+		So(countRows(filepath.Join(rootDir, "first.db3")), ShouldEqual, 1)
+
+		point, err = db.Restore(ctx, 0, last.Timestamp, filepath.Join(rootDir, "last.db3"))
+		So(err, ShouldBeNil)
+		So(point.BlockHash, ShouldResemble, last.BlockHash)
+		So(countRows(filepath.Join(rootDir, "last.db3")), ShouldEqual, 2)
+
+		// existing data file
+		_, err = db.Restore(ctx, -1, time.Time{}, filepath.Join(rootDir, "last.db3"))
+		So(err, ShouldNotBeNil)
+		// before genesis
+		_, err = db.Restore(ctx, 0, time.Unix(0, 0), filepath.Join(rootDir, "none.db3"))
+		So(errors.Cause(err), ShouldEqual, sqlchain.ErrRecoveryPointNotFound)
+	})
+}
+
+func TestDatabaseQuota(t *testing.T) {
+	Convey("test resource quota", t, func() {
+		var err error
+		var server *rpc.Server
+		var cleanup func()
+		cleanup, server, err = initNode()
+		So(err, ShouldBeNil)
+
+		var rootDir string
+		rootDir, err = ioutil.TempDir("", "db_test_")
+		So(err, ShouldBeNil)
+
+		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
+		So(err, ShouldBeNil)
+		chainMuxService, err := sqlchain.NewMuxService("sqlchain", server)
+		So(err, ShouldBeNil)
+
+		var peers *proto.Peers
+		peers, err = getPeers(1)
+		So(err, ShouldBeNil)
+
+		cfg := &DBConfig{
+			DatabaseID:       proto.FromAccountAndNonce(proto.AccountAddress{}, uint32(time.Now().UnixNano())),
+			DataDir:          rootDir,
+			KayakMux:         kayakMuxService,
+			ChainMux:         chainMuxService,
+			MaxWriteTimeGap:  time.Second * 5,
+			UpdateBlockCount: 2,
+			Quota: types.ResourceQuota{
+				MaxMemory:       1 << 20,
+				MaxTempStorage:  1 << 20,
+				MaxResultSize:   16,
+				MaxDatabaseSize: 1 << 20,
+			},
+		}
+
+		var block *types.Block
+		block, err = types.CreateRandomBlock(rootHash, true)
+		So(err, ShouldBeNil)
+
+		var db *Database
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		defer func() {
+			db.Shutdown()
+			os.RemoveAll(rootDir)
+			cleanup()
+		}()
+		So(db.Quota(), ShouldResemble, cfg.Quota)
+
+		var writeQuery, readQuery *types.Request
+		writeQuery, err = buildQuery(types.WriteQuery, 1, 1, []string{
+			"create table test (test int)",
+			"insert into test values(1)",
+			"insert into test values(2)",
+			"insert into test values(3)",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(err, ShouldBeNil)
+
+		// writes beyond the database size limit should fail
+		writeQuery, err = buildQuery(types.WriteQuery, 1, 6, []string{
+			"insert into test values(zeroblob(2097152))",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "full")
+
+		status := db.Status()
+		So(status.DatabaseID, ShouldEqual, cfg.DatabaseID)
+		So(status.Role, ShouldEqual, proto.Leader)
+		So(status.LastCommit, ShouldBeGreaterThan, 0)
+		So(status.StorageSize, ShouldBeGreaterThan, 0)
+
+		// 3 integers exceed the 16 bytes result size limit
+		readQuery, err = buildQuery(types.ReadQuery, 1, 2, []string{
+			"select * from test",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(readQuery)
+		So(errors.Cause(err), ShouldEqual, x.ErrResultSizeExceeded)
+
+		db.SetQuota(types.ResourceQuota{MaxResultSize: 1024})
+		readQuery, err = buildQuery(types.ReadQuery, 1, 3, []string{
+			"select * from test",
+		})
+		So(err, ShouldBeNil)
+		res, err := db.Query(readQuery)
+		So(err, ShouldBeNil)
+		So(res.Header.RowCount, ShouldEqual, 3)
+
+		// memory limit is applied to the live storage connections
+		db.SetQuota(types.ResourceQuota{MaxMemory: 2 << 20, MaxResultSize: 1024})
+		readQuery, err = buildQuery(types.ReadQuery, 1, 5, []string{
+			"select cache_size from pragma_cache_size",
+		})
+		So(err, ShouldBeNil)
+		res, err = db.Query(readQuery)
+		So(err, ShouldBeNil)
+		So(res.Payload.Rows, ShouldHaveLength, 1)
+		So(res.Payload.Rows[0].Values[0], ShouldEqual, -2048)
+
+		// statement timeout
+		db.SetQuota(types.ResourceQuota{QueryTimeout: time.Millisecond})
+		readQuery, err = buildQuery(types.ReadQuery, 1, 4, []string{
+			"with recursive r(i) as (select 1 union all select i+1 from r) select count(1) from r",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(readQuery)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDatabaseWriteBatch(t *testing.T) {
+	Convey("test write batching", t, func() {
+		var err error
+		var server *rpc.Server
+		var cleanup func()
+		cleanup, server, err = initNode()
+		So(err, ShouldBeNil)
+
+		var rootDir string
+		rootDir, err = ioutil.TempDir("", "db_test_")
+		So(err, ShouldBeNil)
+
+		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
+		So(err, ShouldBeNil)
+		chainMuxService, err := sqlchain.NewMuxService("sqlchain", server)
+		So(err, ShouldBeNil)
+
+		var peers *proto.Peers
+		peers, err = getPeers(1)
+		So(err, ShouldBeNil)
+
+		cfg := &DBConfig{
+			DatabaseID:        proto.FromAccountAndNonce(proto.AccountAddress{}, uint32(time.Now().UnixNano())),
+			DataDir:           rootDir,
+			KayakMux:          kayakMuxService,
+			ChainMux:          chainMuxService,
+			MaxWriteTimeGap:   time.Second * 5,
+			UpdateBlockCount:  2,
+			WriteBatchWindow:  100 * time.Millisecond,
+			MaxWriteBatchSize: 8,
+		}
+
+		var block *types.Block
+		block, err = types.CreateRandomBlock(rootHash, true)
+		So(err, ShouldBeNil)
+
+		var db *Database
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		defer func() {
+			db.Shutdown()
+			os.RemoveAll(rootDir)
+			cleanup()
+		}()
+
+		var writeQuery *types.Request
+		writeQuery, err = buildQuery(types.WriteQuery, 1, 1, []string{
+			"create table test (test int)",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(err, ShouldBeNil)
+
+		// concurrent writes are grouped into fewer log entries
+		const writeCount = 16
+		var (
+			lastCommit = db.kayakRuntime.LastCommit()
+			errs       = make(chan error, writeCount)
+		)
+		for i := 0; i < writeCount; i++ {
+			go func(i int) {
+				req, err := buildQuery(types.WriteQuery, uint64(i+2), 1, []string{
+					"insert into test values(1)",
+				})
+				if err == nil {
+					_, err = db.Query(req)
+				}
+				errs <- err
+			}(i)
+		}
+		for i := 0; i < writeCount; i++ {
+			So(<-errs, ShouldBeNil)
+		}
+		So(db.kayakRuntime.LastCommit()-lastCommit, ShouldBeLessThan, writeCount)
+
+		// replayed sequence is rejected without failing the batch
+		writeQuery, err = buildQuery(types.WriteQuery, 2, 1, []string{
+			"insert into test values(1)",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidRequestSeq)
+
+		readQuery, err := buildQuery(types.ReadQuery, 1, 2, []string{
+			"select * from test",
+		})
+		So(err, ShouldBeNil)
+		res, err := db.Query(readQuery)
+		So(err, ShouldBeNil)
+		So(res.Header.RowCount, ShouldEqual, writeCount)
+	})
+}
+
+func TestDatabaseCrossTx(t *testing.T) {
+	Convey("test cross database transaction branch", t, func() {
+		var err error
+		var server *rpc.Server
+		var cleanup func()
+		cleanup, server, err = initNode()
+		So(err, ShouldBeNil)
+
+		var rootDir string
+		rootDir, err = ioutil.TempDir("", "db_test_")
+		So(err, ShouldBeNil)
+
+		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
+		So(err, ShouldBeNil)
+		chainMuxService, err := sqlchain.NewMuxService("sqlchain", server)
+		So(err, ShouldBeNil)
+
+		var peers *proto.Peers
+		peers, err = getPeers(1)
+		So(err, ShouldBeNil)
+
+		cfg := &DBConfig{
+			DatabaseID:       proto.FromAccountAndNonce(proto.AccountAddress{}, uint32(time.Now().UnixNano())),
+			DataDir:          rootDir,
+			KayakMux:         kayakMuxService,
+			ChainMux:         chainMuxService,
+			MaxWriteTimeGap:  time.Second * 5,
+			UpdateBlockCount: 2,
+		}
+
+		var block *types.Block
+		block, err = types.CreateRandomBlock(rootHash, true)
+		So(err, ShouldBeNil)
+
+		var db *Database
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		defer func() {
+			db.Shutdown()
+			os.RemoveAll(rootDir)
+			cleanup()
+		}()
+
+		nodeID, err := kms.GetLocalNodeID()
+		So(err, ShouldBeNil)
+
+		writeQuery, err := buildQuery(types.WriteQuery, 1, 1, []string{
+			"create table test (test int)",
+			"create table xtx (xid text, state text)",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(err, ShouldBeNil)
+
+		var seqNo uint64 = 1
+		buildPrepareTx := func(xid string, timeout time.Duration, queries ...string) (req *types.PrepareTxReq) {
+			prepare, err := buildQueryWithDatabaseID(types.WriteQuery, 2, atomic.AddUint64(&seqNo, 1),
+				cfg.DatabaseID, []string{"insert into xtx values('" + xid + "', 'prepared')"})
+			So(err, ShouldBeNil)
+			commit, err := buildQueryWithDatabaseID(types.WriteQuery, 2, atomic.AddUint64(&seqNo, 1),
+				cfg.DatabaseID, append(queries, "update xtx set state = 'committed' where xid = '"+xid+"'"))
+			So(err, ShouldBeNil)
+			rollback, err := buildQueryWithDatabaseID(types.WriteQuery, 2, atomic.AddUint64(&seqNo, 1),
+				cfg.DatabaseID, []string{"update xtx set state = 'aborted' where xid = '" + xid + "'"})
+			So(err, ShouldBeNil)
+			return &types.PrepareTxReq{
+				DatabaseID: cfg.DatabaseID,
+				XID:        xid,
+				Timeout:    timeout,
+				Prepare:    *prepare,
+				Commit:     *commit,
+				Rollback:   *rollback,
+			}
+		}
+		countRows := func(query string) int64 {
+			readQuery, err := buildQuery(types.ReadQuery, 1, atomic.AddUint64(&seqNo, 1), []string{query})
+			So(err, ShouldBeNil)
+			res, err := db.Query(readQuery)
+			So(err, ShouldBeNil)
+			return int64(res.Header.RowCount)
+		}
+
+		// other writes are blocked until the prepared branch is committed
+		_, err = db.PrepareTx(nodeID, buildPrepareTx("tx1", 3*time.Second, "insert into test values(1)"))
+		So(err, ShouldBeNil)
+		So(countRows("select * from test"), ShouldEqual, 0)
+		writeDone := make(chan error, 1)
+		go func() {
+			writeQuery, err := buildQuery(types.WriteQuery, 3, 1, []string{"insert into test values(2)"})
+			if err == nil {
+				_, err = db.Query(writeQuery)
+			}
+			writeDone <- err
+		}()
+		select {
+		case <-writeDone:
+			t.Fatal("write is not blocked by prepared transaction")
+		case <-time.After(200 * time.Millisecond):
+		}
+		_, err = db.FinishTx(nodeID, "tx2", true)
+		So(errors.Cause(err), ShouldEqual, ErrTxNotFound)
+		_, err = db.FinishTx(nodeID, "tx1", true)
+		So(err, ShouldBeNil)
+		So(<-writeDone, ShouldBeNil)
+		So(countRows("select * from test"), ShouldEqual, 2)
+		So(countRows("select * from xtx where state = 'committed'"), ShouldEqual, 1)
+
+		// prepared branch is rolled back on timeout
+		_, err = db.PrepareTx(nodeID, buildPrepareTx("tx2", 100*time.Millisecond, "insert into test values(3)"))
+		So(err, ShouldBeNil)
+		time.Sleep(500 * time.Millisecond)
+		_, err = db.FinishTx(nodeID, "tx2", true)
+		So(errors.Cause(err), ShouldEqual, ErrTxNotFound)
+		So(countRows("select * from test"), ShouldEqual, 2)
+		So(countRows("select * from xtx where state = 'aborted'"), ShouldEqual, 1)
+
+		// prepare fails if the branch is not executable
+		_, err = db.PrepareTx(nodeID, buildPrepareTx("tx3", time.Second, "insert into not_exists values(1)"))
+		So(err, ShouldNotBeNil)
+		So(countRows("select * from xtx where state = 'aborted'"), ShouldEqual, 2)
+		_, err = db.PrepareTx(nodeID, buildPrepareTx("tx4", time.Second, "create table test2 (test int)"))
+		So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+		_, err = db.PrepareTx(nodeID, buildPrepareTx("tx5", time.Minute, "insert into test values(4)"))
+		So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+	})
+}
+
+func TestDatabaseKeyring(t *testing.T) {
+	Convey("test encrypted at rest database", t, func() {
+		var err error
+		var server *rpc.Server
+		var cleanup func()
+		cleanup, server, err = initNode()
+		So(err, ShouldBeNil)
+		defer cleanup()
+
+		var rootDir string
+		rootDir, err = ioutil.TempDir("", "db_test_")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(rootDir)
+
+		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
+		So(err, ShouldBeNil)
+		chainMuxService, err := sqlchain.NewMuxService("sqlchain", server)
+		So(err, ShouldBeNil)
+
+		var peers *proto.Peers
+		peers, err = getPeers(1)
+		So(err, ShouldBeNil)
+
+		cfg := &DBConfig{
+			DatabaseID:       proto.FromAccountAndNonce(proto.AccountAddress{}, uint32(time.Now().UnixNano())),
+			DataDir:          rootDir,
+			KayakMux:         kayakMuxService,
+			ChainMux:         chainMuxService,
+			MaxWriteTimeGap:  time.Second * 5,
+			UpdateBlockCount: 2,
+			EncryptionKey:    "user key",
+		}
+
+		var block *types.Block
+		block, err = types.CreateRandomBlock(rootHash, true)
+		So(err, ShouldBeNil)
+
+		var db *Database
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		So(db.keyring, ShouldNotBeNil)
+		_, err = os.Stat(filepath.Join(rootDir, DatabaseKeyFileName))
+		So(err, ShouldBeNil)
+
+		writeQuery, err := buildQuery(types.WriteQuery, 1, 1, []string{
+			"create table test (test int)",
+			"insert into test values(1)",
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Query(writeQuery)
+		So(err, ShouldBeNil)
+		oldKey := db.keyring.Current()
+		err = db.Shutdown()
+		So(err, ShouldBeNil)
+
+		storageFile := filepath.Join(rootDir, StorageFileName)
+		So(checkStorageKey(storageFile, cfg.EncryptionKey), ShouldNotBeNil)
+		So(checkStorageKey(storageFile, storageKey(cfg.EncryptionKey, oldKey)), ShouldBeNil)
+
+		// reload with key rotation
+		cfg.KeyRotationPeriod = time.Nanosecond
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		So(len(db.keyring.Keys()), ShouldEqual, 2)
+		So(db.keyring.Current().Version, ShouldEqual, oldKey.Version+1)
+		So(checkStorageKey(storageFile, storageKey(cfg.EncryptionKey, oldKey)), ShouldNotBeNil)
+
+		readQuery, err := buildQuery(types.ReadQuery, 1, 2, []string{
+			"select * from test",
+		})
+		So(err, ShouldBeNil)
+		res, err := db.Query(readQuery)
+		So(err, ShouldBeNil)
+		So(res.Header.RowCount, ShouldEqual, 1)
+		err = db.Shutdown()
+		So(err, ShouldBeNil)
+
+		// interrupted rotation is completed on loading
+		privateKey, err := kms.GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		keyring, err := loadKeyring(rootDir, privateKey)
+		So(err, ShouldBeNil)
+		_, err = keyring.Rotate()
+		So(err, ShouldBeNil)
+		err = saveKeyring(rootDir, keyring, privateKey.PubKey())
+		So(err, ShouldBeNil)
+		key, err := prepareStorageKey(rootDir, cfg.EncryptionKey, keyring, privateKey.PubKey(), 0)
+		So(err, ShouldBeNil)
+		So(key, ShouldEqual, storageKey(cfg.EncryptionKey, keyring.Current()))
+		So(checkStorageKey(storageFile, key), ShouldBeNil)
+
+		// unknown keyring
+		other, err := symmetric.NewKeyring()
+		So(err, ShouldBeNil)
+		_, err = prepareStorageKey(rootDir, cfg.EncryptionKey, other, privateKey.PubKey(), 0)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidStorageKey)
+
+		// stale chain records of destroyed database are kept unless the database is recreated
+		removeFiles := func() {
+			for _, name := range []string{DatabaseKeyFileName, StorageFileName, KayakWalFileName} {
+				os.RemoveAll(filepath.Join(rootDir, name))
+			}
+		}
+		removeFiles()
+		cfg.KeyRotationPeriod = 0
+		_, err = NewDatabase(cfg, peers, block)
+		So(errors.Cause(err), ShouldEqual, sqlchain.ErrInvalidChainKey)
+
+		removeFiles()
+		cfg.Recreated = true
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		So(len(db.keyring.Keys()), ShouldEqual, 1)
+		err = db.Shutdown()
+		So(err, ShouldBeNil)
+	})
+
+	Convey("test legacy plain database without storage", t, func() {
+		var err error
+		var server *rpc.Server
+		var cleanup func()
+		cleanup, server, err = initNode()
+		So(err, ShouldBeNil)
+		defer cleanup()
+
+		var rootDir string
+		rootDir, err = ioutil.TempDir("", "db_test_")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(rootDir)
+
+		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
+		So(err, ShouldBeNil)
+		chainMuxService, err := sqlchain.NewMuxService("sqlchain", server)
+		So(err, ShouldBeNil)
+
+		var peers *proto.Peers
+		peers, err = getPeers(1)
+		So(err, ShouldBeNil)
+
+		cfg := &DBConfig{
+			DatabaseID:       proto.FromAccountAndNonce(proto.AccountAddress{}, uint32(time.Now().UnixNano())),
+			DataDir:          rootDir,
+			KayakMux:         kayakMuxService,
+			ChainMux:         chainMuxService,
+			MaxWriteTimeGap:  time.Second * 5,
+			UpdateBlockCount: 2,
+		}
+
+		var block *types.Block
+		block, err = types.CreateRandomBlock(rootHash, true)
+		So(err, ShouldBeNil)
+
+		// existing storage file is kept in plain as legacy database
+		storageFile := filepath.Join(rootDir, StorageFileName)
+		err = ioutil.WriteFile(storageFile, nil, 0600)
+		So(err, ShouldBeNil)
+		var db *Database
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		So(db.keyring, ShouldBeNil)
+		err = db.Shutdown()
+		So(err, ShouldBeNil)
+
+		// the plain chain records are not purged by the keyring created for missing storage
+		for _, name := range []string{StorageFileName, KayakWalFileName} {
+			os.RemoveAll(filepath.Join(rootDir, name))
+		}
+		_, err = NewDatabase(cfg, peers, block)
+		So(errors.Cause(err), ShouldEqual, sqlchain.ErrInvalidChainKey)
+		_, err = os.Stat(filepath.Join(rootDir, DatabaseKeyFileName))
+		So(err, ShouldBeNil)
+
+		// the legacy database is loaded again once the storage is restored
+		os.RemoveAll(filepath.Join(rootDir, DatabaseKeyFileName))
+		err = ioutil.WriteFile(storageFile, nil, 0600)
+		So(err, ShouldBeNil)
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		So(db.keyring, ShouldBeNil)
+		err = db.Shutdown()
+		So(err, ShouldBeNil)
+	})
+}
+
 func TestInitFailed(t *testing.T) {
 	Convey("test database", t, func() {
 		var err error
@@ -470,6 +1102,8 @@ func TestDatabaseRecycle(t *testing.T) {
 			ChainMux:         chainMuxService,
 			MaxWriteTimeGap:  time.Duration(5 * time.Second),
 			UpdateBlockCount: 2,
+			// chain records of the fixed database id may be left by previous tests
+			Recreated: true,
 		}
 
 		// create genesis block
@@ -563,6 +1197,16 @@ func TestDatabase_EncodePayload(t *testing.T) {
 		encoded2, err := db.EncodePayload(req)
 		So(err, ShouldBeNil)
 		So(encoded2, ShouldResemble, encoded)
+
+		// write batch
+		encoded, err = db.EncodePayload(&writeBatch{Requests: []*types.Request{req, req}})
+		So(err, ShouldBeNil)
+		So(encoded[0], ShouldEqual, writeBatchMarker)
+		wb, err := db.DecodePayload(encoded)
+		So(err, ShouldBeNil)
+		So(wb.(*writeBatch).Requests, ShouldHaveLength, 2)
+		So(reflect.DeepEqual(req.Header, wb.(*writeBatch).Requests[1].Header), ShouldBeTrue)
+		So(reflect.DeepEqual(req.Payload, wb.(*writeBatch).Requests[1].Payload), ShouldBeTrue)
 	})
 }
 
@@ -65,6 +65,7 @@ func init() {
 // DBMS defines a database management instance.
 type DBMS struct {
 	cfg        *DBMSConfig
+	cfgLock    sync.RWMutex // protects the reloadable fields of cfg
 	dbMap      sync.Map
 	kayakMux   *DBKayakMuxService
 	chainMux   *sqlchain.MuxService
@@ -79,6 +80,9 @@ func NewDBMS(cfg *DBMSConfig) (dbms *DBMS, err error) {
 	dbms = &DBMS{
 		cfg: cfg,
 	}
+	if cfg.SlowQueryTime <= 0 {
+		cfg.SlowQueryTime = DefaultSlowQueryTime
+	}
 
 	// init kayak rpc mux
 	if dbms.kayakMux, err = NewDBKayakMuxService(DBKayakRPCName, cfg.Server); err != nil {
@@ -197,6 +201,10 @@ func (dbms *DBMS) Init() (err error) {
 		err = errors.Wrap(err, "init chain bus failed")
 		return
 	}
+	if err = dbms.busService.Subscribe("/UpdateDatabaseQuota/", dbms.updateDatabaseQuota); err != nil {
+		err = errors.Wrap(err, "init chain bus failed")
+		return
+	}
 	dbms.busService.Start()
 
 	return
@@ -218,21 +226,90 @@ func (dbms *DBMS) updateBilling(itx interfaces.Transaction, count uint32) {
 		id       = tx.Receiver.DatabaseID()
 		profile  *types.SQLChainProfile
 		database *Database
+		exists   bool
 	)
 	le := log.WithFields(log.Fields{
 		"id": id,
 	})
-	if database, ok = dbms.getMeta(id); !ok {
-		le.Warn("cannot find database")
+	database, exists = dbms.getMeta(id)
+	profile, ok = dbms.busService.RequestSQLProfile(id)
+	if !ok {
+		if exists {
+			// the database profile is only available to its miners, so this miner is replaced
+			le.Info("local miner is replaced, drop database")
+			if err := dbms.Drop(id); err != nil {
+				le.WithError(err).Error("drop database failed")
+			}
+		}
 		return
 	}
-	if profile, ok = dbms.busService.RequestSQLProfile(id); !ok {
-		le.Warn("cannot find profile")
+	if !exists {
+		// this miner is allocated as a replacement of a failed miner, the database state is
+		// synchronized from the other miners by replaying their sqlchain blocks
+		le.Info("local miner is allocated as replacement, create database")
+		dbms.createDatabaseFromProfile(profile)
 		return
 	}
+	if err := dbms.updatePeers(database, profile); err != nil {
+		le.WithError(err).Error("update database peers failed")
+	}
 	database.chain.SetLastBillingHeight(int32(profile.LastUpdatedHeight))
 }
 
+func (dbms *DBMS) updateDatabaseQuota(itx interfaces.Transaction, count uint32) {
+	tx, ok := itx.(*types.UpdateDatabaseQuota)
+	if !ok {
+		log.WithFields(log.Fields{
+			"type": itx.GetTransactionType(),
+		}).WithError(ErrInvalidTransactionType).Warn("invalid tx type in update database quota")
+		return
+	}
+
+	var id = tx.TargetSQLChain.DatabaseID()
+	database, exists := dbms.getMeta(id)
+	if !exists {
+		return
+	}
+	// use the quota in profile as the transaction may be rejected by block producers
+	profile, ok := dbms.busService.RequestSQLProfile(id)
+	if !ok {
+		log.WithField("id", id).Warning("database profile not found")
+		return
+	}
+	database.SetQuota(profile.Meta.Quota)
+}
+
+// updatePeers applies the miner list of profile to the database if it's changed.
+func (dbms *DBMS) updatePeers(db *Database, profile *types.SQLChainProfile) (err error) {
+	var current = db.kayakRuntime.Peers()
+	if current != nil && len(current.Servers) == len(profile.Miners) {
+		// leader is elected by kayak, only the miner list changes are applied
+		var changed bool
+		for i, v := range profile.Miners {
+			changed = changed || current.Servers[i] != v.NodeID
+		}
+		if !changed {
+			return
+		}
+	}
+	var instance *types.ServiceInstance
+	if instance, err = dbms.buildSQLChainServiceInstance(profile); err != nil {
+		return
+	}
+	if current != nil && len(current.Learners) > 0 {
+		// keep the learners which are not promoted to miners yet
+		for _, l := range current.Learners {
+			if _, found := instance.Peers.Find(l); !found {
+				instance.Peers.Learners = append(instance.Peers.Learners, l)
+			}
+		}
+		if err = instance.Peers.Sign(dbms.privKey); err != nil {
+			return
+		}
+	}
+	return db.UpdatePeers(instance.Peers)
+}
+
 func (dbms *DBMS) createDatabase(tx interfaces.Transaction, count uint32) {
 	cd, ok := tx.(*types.CreateDatabase)
 	if !ok {
@@ -241,10 +318,7 @@ func (dbms *DBMS) createDatabase(tx interfaces.Transaction, count uint32) {
 		return
 	}
 
-	var (
-		dbID          = proto.FromAccountAndNonce(cd.Owner, uint32(cd.Nonce))
-		isTargetMiner = false
-	)
+	var dbID = proto.FromAccountAndNonce(cd.Owner, uint32(cd.Nonce))
 	log.WithFields(log.Fields{
 		"databaseid": dbID,
 		"owner":      cd.Owner.String(),
@@ -258,13 +332,15 @@ func (dbms *DBMS) createDatabase(tx interfaces.Transaction, count uint32) {
 		return
 	}
 
-	nodeIDs := make([]proto.NodeID, len(p.Miners))
+	dbms.createDatabaseFromProfile(p)
+}
 
-	for i, mi := range p.Miners {
+func (dbms *DBMS) createDatabaseFromProfile(p *types.SQLChainProfile) {
+	var isTargetMiner = false
+	for _, mi := range p.Miners {
 		if mi.Address == dbms.address {
 			isTargetMiner = true
 		}
-		nodeIDs[i] = mi.NodeID
 	}
 	if !isTargetMiner {
 		return
@@ -440,6 +516,7 @@ func (dbms *DBMS) Create(instance *types.ServiceInstance, cleanup bool) (err err
 	}()
 
 	// new db
+	dbms.cfgLock.RLock()
 	dbCfg := &DBConfig{
 		DatabaseID:             instance.DatabaseID,
 		RootDir:                dbms.cfg.RootDir,
@@ -453,8 +530,15 @@ func (dbms *DBMS) Create(instance *types.ServiceInstance, cleanup bool) (err err
 		UseEventualConsistency: instance.ResourceMeta.UseEventualConsistency,
 		ConsistencyLevel:       instance.ResourceMeta.ConsistencyLevel,
 		IsolationLevel:         instance.ResourceMeta.IsolationLevel,
-		SlowQueryTime:          DefaultSlowQueryTime,
+		SlowQueryTime:          dbms.cfg.SlowQueryTime,
+		Quota:                  instance.ResourceMeta.Quota,
+		KeyRotationPeriod:      dbms.cfg.KeyRotationPeriod,
+		WriteBatchWindow:       dbms.cfg.WriteBatchWindow,
+		MaxWriteBatchSize:      dbms.cfg.MaxWriteBatchSize,
+		ElectionTimeout:        dbms.cfg.ElectionTimeout,
+		Recreated:              cleanup,
 	}
+	dbms.cfgLock.RUnlock()
 
 	// set last billing height
 	if profile, ok := dbms.busService.RequestSQLProfile(dbCfg.DatabaseID); ok {
@@ -474,6 +558,32 @@ func (dbms *DBMS) Create(instance *types.ServiceInstance, cleanup bool) (err err
 	return
 }
 
+// Reload applies the request time gap, slow query time, key rotation, write batching and leader
+// election settings of cfg. The request time limits take effect on the served databases immediately,
+// the others take effect on the databases created or loaded afterwards.
+func (dbms *DBMS) Reload(cfg *DBMSConfig) {
+	dbms.cfgLock.Lock()
+	dbms.cfg.MaxReqTimeGap = cfg.MaxReqTimeGap
+	dbms.cfg.SlowQueryTime = cfg.SlowQueryTime
+	if dbms.cfg.SlowQueryTime <= 0 {
+		dbms.cfg.SlowQueryTime = DefaultSlowQueryTime
+	}
+	dbms.cfg.KeyRotationPeriod = cfg.KeyRotationPeriod
+	dbms.cfg.WriteBatchWindow = cfg.WriteBatchWindow
+	dbms.cfg.MaxWriteBatchSize = cfg.MaxWriteBatchSize
+	dbms.cfg.ElectionTimeout = cfg.ElectionTimeout
+	limits := TimeLimits{
+		MaxWriteTimeGap: dbms.cfg.MaxReqTimeGap,
+		SlowQueryTime:   dbms.cfg.SlowQueryTime,
+	}
+	dbms.cfgLock.Unlock()
+
+	dbms.dbMap.Range(func(_, rawDB interface{}) bool {
+		rawDB.(*Database).SetTimeLimits(limits)
+		return true
+	})
+}
+
 // Drop remove database from the miner dbms.
 func (dbms *DBMS) Drop(dbID proto.DatabaseID) (err error) {
 	var db *Database
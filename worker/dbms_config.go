@@ -30,9 +30,14 @@ var (
 
 // DBMSConfig defines the local multi-database management system config.
 type DBMSConfig struct {
-	RootDir          string
-	Server           *mux.Server
-	DirectServer     *rpc.Server // optional server to provide DBMS service
-	MaxReqTimeGap    time.Duration
-	OnCreateDatabase func()
+	RootDir           string
+	Server            *mux.Server
+	DirectServer      *rpc.Server // optional server to provide DBMS service
+	MaxReqTimeGap     time.Duration
+	SlowQueryTime     time.Duration // slow query log threshold, DefaultSlowQueryTime if not set
+	KeyRotationPeriod time.Duration // database key rotation period, zero to disable rotation
+	WriteBatchWindow  time.Duration // write batching window, zero to disable write batching
+	MaxWriteBatchSize int           // max request count of a write batch
+	ElectionTimeout   time.Duration // leader election timeout, zero to disable leader election
+	OnCreateDatabase  func()
 }
@@ -18,6 +18,7 @@ package worker
 
 import (
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -30,8 +31,20 @@ import (
 const (
 	// DBKayakApplyMethodName defines the database kayak apply rpc method name.
 	DBKayakApplyMethodName = "Apply"
+	// DBKayakApplyBatchMethodName defines the database kayak batched apply rpc method name.
+	DBKayakApplyBatchMethodName = "ApplyBatch"
 	// DBKayakFetchMethodName defines the database kayak fetch rpc method name.
 	DBKayakFetchMethodName = "Fetch"
+	// DBKayakSyncMethodName defines the database kayak state sync rpc method name.
+	DBKayakSyncMethodName = "Sync"
+	// DBKayakSnapshotMethodName defines the database kayak snapshot rpc method name.
+	DBKayakSnapshotMethodName = "Snapshot"
+	// DBKayakReadIndexMethodName defines the database kayak read index rpc method name.
+	DBKayakReadIndexMethodName = "ReadIndex"
+	// DBKayakHeartbeatMethodName defines the database kayak leadership heartbeat rpc method name.
+	DBKayakHeartbeatMethodName = "Heartbeat"
+	// DBKayakVoteMethodName defines the database kayak leader election vote rpc method name.
+	DBKayakVoteMethodName = "RequestVote"
 )
 
 // DBKayakMuxService defines a mux service for sqlchain kayak.
@@ -71,6 +84,25 @@ func (s *DBKayakMuxService) Apply(req *kt.ApplyRequest, _ *interface{}) (err err
 	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
 }
 
+// ApplyBatch handles kayak batched apply call.
+func (s *DBKayakMuxService) ApplyBatch(req *kt.ApplyBatchRequest, resp *kt.ApplyBatchResponse) (err error) {
+	id := proto.DatabaseID(req.Instance)
+
+	if v, ok := s.serviceMap.Load(id); ok {
+		errs := v.(*kayak.Runtime).FollowerApplyBatch(req.Logs)
+		resp.Instance = req.Instance
+		resp.Errors = make([]string, len(errs))
+		for i, e := range errs {
+			if e != nil {
+				resp.Errors[i] = e.Error()
+			}
+		}
+		return
+	}
+
+	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
+}
+
 // Fetch handles kayak fetch call.
 func (s *DBKayakMuxService) Fetch(req *kt.FetchRequest, resp *kt.FetchResponse) (err error) {
 	id := proto.DatabaseID(req.Instance)
@@ -86,3 +118,89 @@ func (s *DBKayakMuxService) Fetch(req *kt.FetchRequest, resp *kt.FetchResponse)
 
 	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
 }
+
+// Sync handles kayak state sync call.
+func (s *DBKayakMuxService) Sync(req *kt.SyncRequest, resp *kt.SyncResponse) (err error) {
+	id := proto.DatabaseID(req.Instance)
+
+	if v, ok := s.serviceMap.Load(id); ok {
+		var r *kt.SyncResponse
+		if r, err = v.(*kayak.Runtime).Sync(req.GetContext(), req.Digest); err == nil {
+			resp.Instance = req.Instance
+			resp.LastCommit = r.LastCommit
+			resp.Prepares = r.Prepares
+			resp.Delta = r.Delta
+		}
+		return
+	}
+
+	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
+}
+
+// Snapshot handles kayak snapshot call.
+func (s *DBKayakMuxService) Snapshot(req *kt.SnapshotRequest, resp *kt.SnapshotResponse) (err error) {
+	id := proto.DatabaseID(req.Instance)
+
+	if v, ok := s.serviceMap.Load(id); ok {
+		var r *kt.SnapshotResponse
+		if r, err = v.(*kayak.Runtime).Snapshot(req.GetContext()); err == nil {
+			resp.Instance = req.Instance
+			resp.LastCommit = r.LastCommit
+			resp.Prepares = r.Prepares
+			resp.Snapshot = r.Snapshot
+		}
+		return
+	}
+
+	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
+}
+
+// ReadIndex handles kayak read index call.
+func (s *DBKayakMuxService) ReadIndex(req *kt.ReadIndexRequest, resp *kt.ReadIndexResponse) (err error) {
+	id := proto.DatabaseID(req.Instance)
+
+	if v, ok := s.serviceMap.Load(id); ok {
+		var (
+			index uint64
+			lease time.Duration
+		)
+		if index, lease, err = v.(*kayak.Runtime).ReadIndex(
+			req.GetContext(), req.GetNodeID().ToNodeID()); err == nil {
+			resp.Instance = req.Instance
+			resp.Index = index
+			resp.Lease = lease
+		}
+		return
+	}
+
+	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
+}
+
+// Heartbeat handles kayak leadership heartbeat call.
+func (s *DBKayakMuxService) Heartbeat(req *kt.HeartbeatRequest, _ *interface{}) (err error) {
+	id := proto.DatabaseID(req.Instance)
+
+	if v, ok := s.serviceMap.Load(id); ok {
+		return v.(*kayak.Runtime).Heartbeat(req.Leader, req.Term, req.LastCommit)
+	}
+
+	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
+}
+
+// RequestVote handles kayak leader election vote call.
+func (s *DBKayakMuxService) RequestVote(req *kt.VoteRequest, resp *kt.VoteResponse) (err error) {
+	id := proto.DatabaseID(req.Instance)
+
+	if v, ok := s.serviceMap.Load(id); ok {
+		var r *kt.VoteResponse
+		if r, err = v.(*kayak.Runtime).RequestVote(req); err == nil {
+			resp.Instance = req.Instance
+			resp.Term = r.Term
+			resp.LastIndex = r.LastIndex
+			resp.Granted = r.Granted
+		}
+		return
+	}
+
+	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
+}
@@ -17,14 +17,20 @@
 package worker
 
 import (
+	"bufio"
+	"context"
+	"io"
+
 	"github.com/pkg/errors"
 	metrics "github.com/rcrowley/go-metrics"
 
+	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
 	"github.com/CovenantSQL/CovenantSQL/rpc/mux"
 	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
 )
 
 var (
@@ -60,8 +66,12 @@ func NewDBMSRPCService(
 		dbms: dbms,
 	}
 	server.RegisterService(serviceName, service)
+	server.RegisterStreamHandler(route.DBSRestore.String(), dbms.serveRestore)
+	server.RegisterStreamHandler(route.DBSQueryStream.String(), dbms.serveQuery)
 	if direct != nil {
 		direct.RegisterService(serviceName, service)
+		direct.RegisterStreamHandler(route.DBSRestore.String(), dbms.serveRestore)
+		direct.RegisterStreamHandler(route.DBSQueryStream.String(), dbms.serveQuery)
 	}
 
 	dbQuerySuccCounter = metrics.NewMeter()
@@ -99,6 +109,51 @@ func (rpc *DBMSRPCService) Query(req *types.Request, res *types.Response) (err e
 	return
 }
 
+// serveQuery serves the QueryStream streaming RPC method. The response header is sent first and
+// followed by the rows one by one, so that client could consume large result sets incrementally.
+func (dbms *DBMS) serveQuery(ctx context.Context, call *rpc.StreamCall, w io.Writer) (err error) {
+	defer func() {
+		if err != nil {
+			dbQueryFailCounter.Mark(1)
+		} else {
+			dbQuerySuccCounter.Mark(1)
+		}
+	}()
+
+	var req = &types.Request{}
+	if err = call.ReadArgs(req); err != nil {
+		return
+	}
+	// verify query is sent from the request node
+	if call.Remote == nil || call.Remote.ToNodeID() != req.Header.NodeID {
+		err = errors.Wrap(ErrInvalidRequest, "request node id mismatch in query")
+		return
+	}
+
+	var r *types.Response
+	if r, err = dbms.Query(req); err != nil {
+		return
+	}
+
+	var (
+		bw  = bufio.NewWriterSize(w, conf.StreamChunkSize)
+		enc = utils.GetMsgPackEncoder(bw)
+	)
+	if err = enc.Encode(&types.ResponseStreamHeader{
+		Header:    r.Header,
+		Columns:   r.Payload.Columns,
+		DeclTypes: r.Payload.DeclTypes,
+	}); err != nil {
+		return
+	}
+	for i := range r.Payload.Rows {
+		if err = enc.Encode(&r.Payload.Rows[i]); err != nil {
+			return
+		}
+	}
+	return bw.Flush()
+}
+
 // Ack rpc, called by client to confirm read request.
 func (rpc *DBMSRPCService) Ack(ack *types.Ack, _ *types.AckResponse) (err error) {
 	// Just need to verify signature in db.saveAck
@@ -117,6 +172,78 @@ func (rpc *DBMSRPCService) Ack(ack *types.Ack, _ *types.AckResponse) (err error)
 	return
 }
 
+// SchemaChangeStatus rpc, called by client and peer miners to query schema change progress.
+func (rpc *DBMSRPCService) SchemaChangeStatus(
+	req *types.SchemaChangeStatusReq, resp *types.SchemaChangeStatusResp) (err error,
+) {
+	var r *types.SchemaChangeStatusResp
+	if r, err = rpc.dbms.schemaChangeStatus(req); err != nil {
+		return
+	}
+	*resp = *r
+	return
+}
+
+// QueryStats rpc, called by client and observer to fetch statement statistics and slow queries.
+func (rpc *DBMSRPCService) QueryStats(req *types.QueryStatsReq, resp *types.QueryStatsResp) (err error) {
+	var r *types.QueryStatsResp
+	if r, err = rpc.dbms.queryStats(req); err != nil {
+		return
+	}
+	*resp = *r
+	return
+}
+
+// QueryProof rpc, called by client to fetch the proof which binds a query response to a block.
+func (rpc *DBMSRPCService) QueryProof(req *types.QueryProofReq, resp *types.QueryProofResp) (err error) {
+	var r *types.QueryProofResp
+	if r, err = rpc.dbms.queryProof(req); err != nil {
+		return
+	}
+	*resp = *r
+	return
+}
+
+// Prepare rpc, called by client to prepare a statement for later queries.
+func (rpc *DBMSRPCService) Prepare(req *types.PrepareReq, resp *types.PrepareResp) (err error) {
+	var r *types.PrepareResp
+	if r, err = rpc.dbms.prepare(req); err != nil {
+		return
+	}
+	*resp = *r
+	return
+}
+
+// CloseStmt rpc, called by client to release a prepared statement.
+func (rpc *DBMSRPCService) CloseStmt(req *types.CloseStmtReq, resp *types.CloseStmtResp) (err error) {
+	var r *types.CloseStmtResp
+	if r, err = rpc.dbms.closeStmt(req); err != nil {
+		return
+	}
+	*resp = *r
+	return
+}
+
+// PrepareTx rpc, called by client to prepare a branch of cross database transaction.
+func (rpc *DBMSRPCService) PrepareTx(req *types.PrepareTxReq, resp *types.PrepareTxResp) (err error) {
+	var r *types.PrepareTxResp
+	if r, err = rpc.dbms.prepareTx(req); err != nil {
+		return
+	}
+	*resp = *r
+	return
+}
+
+// FinishTx rpc, called by client to commit or rollback a prepared cross database transaction.
+func (rpc *DBMSRPCService) FinishTx(req *types.FinishTxReq, resp *types.FinishTxResp) (err error) {
+	var r *types.FinishTxResp
+	if r, err = rpc.dbms.finishTx(req); err != nil {
+		return
+	}
+	*resp = *r
+	return
+}
+
 // Deploy rpc, called by BP to create/drop database and update peers.
 func (rpc *DBMSRPCService) Deploy(req *types.UpdateService, _ *types.UpdateServiceResponse) (err error) {
 	// verify request node is block producer
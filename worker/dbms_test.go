@@ -198,6 +198,87 @@ func TestDBMS(t *testing.T) {
 				_, _, err = dbms.observerFetchBlock(dbID, nodeID, 1)
 				So(err, ShouldBeNil)
 
+				// the table creation is a coordinated schema change
+				var statusRes types.SchemaChangeStatusResp
+				err = testRequest(route.DBSSchemaChangeStatus,
+					&types.SchemaChangeStatusReq{DatabaseID: dbID}, &statusRes)
+				So(err, ShouldBeNil)
+				So(statusRes.Status.Pending, ShouldBeFalse)
+				So(statusRes.Status.Queries, ShouldResemble, []string{
+					"create table test (test int)",
+					"insert into test values(1)",
+				})
+				So(statusRes.Status.Applied, ShouldResemble, []proto.NodeID{nodeID})
+				So(statusRes.LastCommit, ShouldBeGreaterThanOrEqualTo, statusRes.Status.LogIndex)
+				err = testRequest(route.DBSSchemaChangeStatus,
+					&types.SchemaChangeStatusReq{DatabaseID: dbID2}, &statusRes)
+				So(err, ShouldNotBeNil)
+
+				// statement statistics
+				var statsRes types.QueryStatsResp
+				err = testRequest(route.DBSQueryStats,
+					&types.QueryStatsReq{DatabaseID: dbID}, &statsRes)
+				So(err, ShouldBeNil)
+				So(statsRes.SlowQueryTime, ShouldEqual, DefaultSlowQueryTime)
+				So(len(statsRes.Statements), ShouldBeGreaterThanOrEqualTo, 3)
+				for _, st := range statsRes.Statements {
+					So(st.Calls, ShouldBeGreaterThan, 0)
+				}
+				err = testRequest(route.DBSQueryStats,
+					&types.QueryStatsReq{DatabaseID: dbID2}, &statsRes)
+				So(err, ShouldNotBeNil)
+
+				// query proof of the read response, available after the block is produced
+				var proofRes types.QueryProofResp
+				for i := 0; i < 50; i++ {
+					if err = testRequest(route.DBSQueryProof, &types.QueryProofReq{
+						DatabaseID:   dbID,
+						ResponseHash: queryRes.Header.ResponseHash,
+					}, &proofRes); err == nil {
+						break
+					}
+					time.Sleep(200 * time.Millisecond)
+				}
+				So(err, ShouldBeNil)
+				So(proofRes.Proof.VerifyResponse(queryRes), ShouldBeNil)
+				err = testRequest(route.DBSQueryProof, &types.QueryProofReq{
+					DatabaseID:   dbID,
+					ResponseHash: hash.Hash{},
+				}, &proofRes)
+				So(err, ShouldNotBeNil)
+				err = testRequest(route.DBSQueryProof, &types.QueryProofReq{
+					DatabaseID:   dbID2,
+					ResponseHash: queryRes.Header.ResponseHash,
+				}, &proofRes)
+				So(err, ShouldNotBeNil)
+
+				// prepare and close statement
+				var prepareRes types.PrepareResp
+				err = testRequest(route.DBSPrepare, &types.PrepareReq{
+					DatabaseID: dbID,
+					Pattern:    "select * from test where test = ?",
+				}, &prepareRes)
+				So(err, ShouldBeNil)
+				So(prepareRes.StatementID, ShouldNotEqual, uint64(0))
+				So(prepareRes.Normalized, ShouldEqual, "select * from test where test = :v1")
+				var invalidPrepareRes types.PrepareResp
+				err = testRequest(route.DBSPrepare, &types.PrepareReq{
+					DatabaseID: dbID,
+					Pattern:    "begin",
+				}, &invalidPrepareRes)
+				So(err, ShouldNotBeNil)
+				var closeRes types.CloseStmtResp
+				err = testRequest(route.DBSCloseStmt, &types.CloseStmtReq{
+					DatabaseID:  dbID,
+					StatementID: prepareRes.StatementID,
+				}, &closeRes)
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSCloseStmt, &types.CloseStmtReq{
+					DatabaseID:  dbID,
+					StatementID: prepareRes.StatementID,
+				}, &closeRes)
+				So(err, ShouldNotBeNil)
+
 				// revoke write permission
 				err = dbms.UpdatePermission(dbAddr.DatabaseID(), userAddr,
 					&types.PermStat{Permission: types.UserPermissionFromRole(types.Read), Status: types.Normal})
@@ -547,6 +628,19 @@ func TestDBMS(t *testing.T) {
 				So(err, ShouldBeNil)
 			})
 
+			Convey("reload config", func() {
+				dbms.Reload(&DBMSConfig{
+					MaxReqTimeGap: time.Second * 10,
+				})
+
+				db, exists := dbms.getMeta(dbID)
+				So(exists, ShouldBeTrue)
+				So(db.TimeLimits(), ShouldResemble, TimeLimits{
+					MaxWriteTimeGap: time.Second * 10,
+					SlowQueryTime:   DefaultSlowQueryTime,
+				})
+			})
+
 			Convey("drop database before shutdown", func() {
 				// drop database
 				req = new(types.UpdateService)
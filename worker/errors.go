@@ -39,4 +39,16 @@ var (
 	ErrInvalidPermission = errors.New("invalid permission")
 	// ErrInvalidTransactionType indicates that the transaction type is invalid.
 	ErrInvalidTransactionType = errors.New("invalid transaction type")
+	// ErrStaleRead indicates that the follower state is staler than the read request allows.
+	ErrStaleRead = errors.New("follower state is too stale")
+	// ErrInvalidStorageKey indicates that none of the database keys could open the storage.
+	ErrInvalidStorageKey = errors.New("no valid storage key in database keyring")
+	// ErrStatementNotFound indicates that the prepared statement is not found or already closed.
+	ErrStatementNotFound = errors.New("prepared statement not found")
+	// ErrTooManyStatements indicates that the client has prepared too many statements.
+	ErrTooManyStatements = errors.New("too many prepared statements")
+	// ErrWriterStopped indicates that the write batching of the database is stopped.
+	ErrWriterStopped = errors.New("database writer stopped")
+	// ErrTxNotFound indicates that the prepared cross database transaction is not found.
+	ErrTxNotFound = errors.New("prepared transaction not found")
 )
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// QueryProof returns the proof which binds the query response with responseHash to the signed
+// header of the block packing it. The proof is available after the block is produced.
+func (db *Database) QueryProof(responseHash *hash.Hash) (proof *types.QueryProof, err error) {
+	return db.chain.QueryProof(responseHash)
+}
+
+func (dbms *DBMS) queryProof(req *types.QueryProofReq) (resp *types.QueryProofResp, err error) {
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+
+	var addr proto.AccountAddress
+	if addr, err = nodeAccountAddress(req.GetNodeID().ToNodeID()); err != nil {
+		return
+	}
+	if err = dbms.checkPermission(addr, req.DatabaseID, types.ReadQuery, nil); err != nil {
+		return
+	}
+
+	var proof *types.QueryProof
+	if proof, err = db.QueryProof(&req.ResponseHash); err != nil {
+		return
+	}
+	resp = &types.QueryProofResp{
+		Proof: *proof,
+	}
+	return
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+)
+
+const (
+	// MaxStatementStats defines the max number of distinct statements tracked per database,
+	// the least called statement is evicted for new statements.
+	MaxStatementStats = 1000
+
+	// StatementLatencySamples defines the number of recent latencies kept per statement to
+	// compute the 95th percentile latency.
+	StatementLatencySamples = 128
+
+	// MaxSlowQueryLog defines the max number of recent slow queries kept per database.
+	MaxSlowQueryLog = 100
+)
+
+type statementStats struct {
+	calls   uint64
+	total   time.Duration
+	rows    uint64
+	samples []time.Duration
+	next    int
+}
+
+func (s *statementStats) p95() time.Duration {
+	if len(s.samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), s.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)*95-1)/100]
+}
+
+// queryStats collects the statement statistics and slow query log of a database.
+type queryStats struct {
+	sync.Mutex
+	// normalize returns the normalized query of pattern for statistics
+	normalize  func(pattern string) string
+	statements map[string]*statementStats
+	slow       []types.SlowQuery
+	slowNext   int
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{
+		normalize:  x.NormalizeQuery,
+		statements: make(map[string]*statementStats),
+	}
+}
+
+// record accounts a finished request, the latency and rows of a batch request are evenly
+// attributed to the statements in the batch.
+func (s *queryStats) record(queries []types.Query, elapsed time.Duration, rows uint64) {
+	if len(queries) == 0 {
+		return
+	}
+	var (
+		n          = uint64(len(queries))
+		latency    = elapsed / time.Duration(n)
+		normalized = make([]string, len(queries))
+	)
+	// normalize outside of the lock
+	for i, q := range queries {
+		normalized[i] = s.normalize(q.Pattern)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for i, key := range normalized {
+		st, ok := s.statements[key]
+		if !ok {
+			if len(s.statements) >= MaxStatementStats {
+				s.evict()
+			}
+			st = &statementStats{}
+			s.statements[key] = st
+		}
+		st.calls++
+		st.total += latency
+		st.rows += rows / n
+		if uint64(i) < rows%n {
+			st.rows++
+		}
+		if len(st.samples) < StatementLatencySamples {
+			st.samples = append(st.samples, latency)
+		} else {
+			st.samples[st.next] = latency
+			st.next = (st.next + 1) % StatementLatencySamples
+		}
+	}
+}
+
+func (s *queryStats) evict() {
+	var (
+		victim string
+		calls  uint64
+		found  bool
+	)
+	for k, v := range s.statements {
+		if !found || v.calls < calls {
+			victim, calls, found = k, v.calls, true
+		}
+	}
+	delete(s.statements, victim)
+}
+
+func (s *queryStats) recordSlow(q types.SlowQuery) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.slow) < MaxSlowQueryLog {
+		s.slow = append(s.slow, q)
+	} else {
+		s.slow[s.slowNext] = q
+		s.slowNext = (s.slowNext + 1) % MaxSlowQueryLog
+	}
+}
+
+func (s *queryStats) snapshot() (statements []types.StatementStats, slow []types.SlowQuery) {
+	s.Lock()
+	defer s.Unlock()
+	statements = make([]types.StatementStats, 0, len(s.statements))
+	for k, v := range s.statements {
+		statements = append(statements, types.StatementStats{
+			Query:        k,
+			Calls:        v.calls,
+			TotalLatency: v.total,
+			P95Latency:   v.p95(),
+			Rows:         v.rows,
+		})
+	}
+	sort.Slice(statements, func(i, j int) bool {
+		return statements[i].TotalLatency > statements[j].TotalLatency
+	})
+	slow = make([]types.SlowQuery, 0, len(s.slow))
+	slow = append(slow, s.slow[s.slowNext:]...)
+	slow = append(slow, s.slow[:s.slowNext]...)
+	return
+}
+
+// QueryStats returns the statement statistics and recent slow queries of the database.
+func (db *Database) QueryStats() (statements []types.StatementStats, slow []types.SlowQuery) {
+	return db.stats.snapshot()
+}
+
+func (dbms *DBMS) queryStats(req *types.QueryStatsReq) (resp *types.QueryStatsResp, err error) {
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+
+	var addr proto.AccountAddress
+	if addr, err = nodeAccountAddress(req.GetNodeID().ToNodeID()); err != nil {
+		return
+	}
+	if err = dbms.checkPermission(addr, req.DatabaseID, types.ReadQuery, nil); err != nil {
+		return
+	}
+
+	resp = &types.QueryStatsResp{
+		SlowQueryTime: db.TimeLimits().SlowQueryTime,
+	}
+	resp.Statements, resp.SlowQueries = db.QueryStats()
+	return
+}
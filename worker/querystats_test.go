@@ -0,0 +1,77 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestQueryStats(t *testing.T) {
+	Convey("test query stats", t, func() {
+		s := newQueryStats()
+
+		Convey("statements differing in literals should be aggregated", func() {
+			for i := 1; i <= 100; i++ {
+				s.record([]types.Query{
+					{Pattern: fmt.Sprintf("SELECT * FROM t WHERE k = %d", i)},
+				}, time.Duration(i)*time.Millisecond, 1)
+			}
+			s.record([]types.Query{
+				{Pattern: "INSERT INTO t VALUES (1)"},
+				{Pattern: "INSERT INTO t VALUES (2)"},
+			}, 10*time.Millisecond, 3)
+
+			statements, slow := s.snapshot()
+			So(slow, ShouldBeEmpty)
+			So(statements, ShouldHaveLength, 2)
+			So(statements[0].Calls, ShouldEqual, 100)
+			So(statements[0].TotalLatency, ShouldEqual, 5050*time.Millisecond)
+			So(statements[0].P95Latency, ShouldEqual, 95*time.Millisecond)
+			So(statements[0].Rows, ShouldEqual, 100)
+			So(statements[1].Calls, ShouldEqual, 2)
+			So(statements[1].TotalLatency, ShouldEqual, 10*time.Millisecond)
+			So(statements[1].Rows, ShouldEqual, 3)
+		})
+		Convey("least called statement should be evicted", func() {
+			s.record([]types.Query{{Pattern: "SELECT 1 FROM hot"}}, time.Millisecond, 0)
+			for i := 0; i < MaxStatementStats; i++ {
+				s.record([]types.Query{
+					{Pattern: fmt.Sprintf("SELECT 1 FROM t%d", i)},
+				}, time.Millisecond, 0)
+				s.record([]types.Query{{Pattern: "SELECT 1 FROM hot"}}, time.Millisecond, 0)
+			}
+			statements, _ := s.snapshot()
+			So(statements, ShouldHaveLength, MaxStatementStats)
+			So(statements[0].Calls, ShouldEqual, MaxStatementStats+1)
+		})
+		Convey("slow query log should keep the recent entries in order", func() {
+			for i := 0; i < MaxSlowQueryLog+10; i++ {
+				s.recordSlow(types.SlowQuery{Elapsed: time.Duration(i)})
+			}
+			_, slow := s.snapshot()
+			So(slow, ShouldHaveLength, MaxSlowQueryLog)
+			So(slow[0].Elapsed, ShouldEqual, 10)
+			So(slow[MaxSlowQueryLog-1].Elapsed, ShouldEqual, MaxSlowQueryLog+9)
+		})
+	})
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Restore materializes the database state at the last block no higher than height, or at the
+// last block produced no later than ts if ts is not zero, into the new data file.
+func (db *Database) Restore(
+	ctx context.Context, height int32, ts time.Time, dataFile string) (point *types.RecoveryPoint, err error,
+) {
+	if _, err = os.Stat(dataFile); err == nil {
+		err = errors.Errorf("data file %s already exists", dataFile)
+		return
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	if ts.IsZero() {
+		point, err = db.chain.RecoveryPointAt(height)
+	} else {
+		point, err = db.chain.RecoveryPointBefore(ts)
+	}
+	if err != nil {
+		return
+	}
+
+	var dsn *storage.DSN
+	if dsn, err = storage.NewDSN(dataFile); err != nil {
+		return
+	}
+	if err = db.chain.Materialize(ctx, point, dsn.Format()); err != nil {
+		return
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(dataFile); err != nil {
+		return
+	}
+	point.Size = info.Size()
+	return
+}
+
+// serveRestore serves the Restore streaming RPC method. The recovery point is sent first and
+// followed by the materialized data file in chunks, the data file is removed after sending.
+func (dbms *DBMS) serveRestore(ctx context.Context, call *rpc.StreamCall, w io.Writer) (err error) {
+	var req = &types.RestoreReq{}
+	if err = call.ReadArgs(req); err != nil {
+		return
+	}
+	if call.Remote == nil {
+		err = errors.Wrap(ErrInvalidRequest, "unknown remote node in restore")
+		return
+	}
+
+	var (
+		nodeID = call.Remote.ToNodeID()
+		le     = log.WithFields(log.Fields{
+			"databaseID": req.DatabaseID,
+			"nodeID":     nodeID,
+			"height":     req.Height,
+			"timestamp":  req.Timestamp,
+		})
+	)
+	defer func() {
+		le.WithError(err).Debug("restore database")
+	}()
+
+	// check permission
+	var addr proto.AccountAddress
+	if addr, err = nodeAccountAddress(nodeID); err != nil {
+		return
+	}
+	if err = dbms.checkPermission(addr, req.DatabaseID, types.ReadQuery, nil); err != nil {
+		return
+	}
+
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+
+	// materialize to a temporary data file
+	dir, err := ioutil.TempDir(db.cfg.DataDir, "restore-")
+	if err != nil {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	var (
+		dataFile = filepath.Join(dir, StorageFileName)
+		point    *types.RecoveryPoint
+	)
+	if point, err = db.Restore(ctx, req.Height, req.Timestamp, dataFile); err != nil {
+		return
+	}
+	le = le.WithField("block", point.BlockHash.String())
+
+	// send recovery point and data file
+	f, err := os.Open(dataFile)
+	if err != nil {
+		return
+	}
+	defer func() { _ = f.Close() }()
+	var (
+		enc = utils.GetMsgPackEncoder(w)
+		buf = make([]byte, conf.StreamChunkSize)
+		n   int
+	)
+	if err = enc.Encode(point); err != nil {
+		return
+	}
+	for {
+		if n, err = f.Read(buf); n > 0 {
+			if ierr := enc.Encode(buf[:n]); ierr != nil {
+				err = ierr
+				return
+			}
+		}
+		if err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+	}
+}
+
+func nodeAccountAddress(nodeID proto.NodeID) (addr proto.AccountAddress, err error) {
+	pubKey, err := kms.GetPublicKey(nodeID)
+	if err != nil {
+		return
+	}
+	return crypto.PubKeyHash(pubKey)
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// SchemaChangeStatus returns the progress of the last coordinated schema change of the database.
+func (db *Database) SchemaChangeStatus() (status types.SchemaChangeStatus) {
+	db.schemaStatusLock.Lock()
+	defer db.schemaStatusLock.Unlock()
+
+	status = db.schemaStatus
+	status.Queries = append([]string(nil), db.schemaStatus.Queries...)
+	status.Applied = append([]proto.NodeID(nil), db.schemaStatus.Applied...)
+	return
+}
+
+func (db *Database) updateSchemaStatus(f func(status *types.SchemaChangeStatus)) {
+	db.schemaStatusLock.Lock()
+	defer db.schemaStatusLock.Unlock()
+	f(&db.schemaStatus)
+}
+
+// waitSchemaChange blocks until all the followers have committed the schema change log at
+// logIndex, or SchemaChangeTimeout is reached. The caller should hold the schema lock so that
+// no subsequent writes are accepted meanwhile.
+func (db *Database) waitSchemaChange(request *types.Request, logIndex uint64) {
+	var (
+		peers   = db.kayakRuntime.Peers()
+		pending = make(map[proto.NodeID]bool)
+		queries = make([]string, len(request.Payload.Queries))
+		le      = log.WithFields(log.Fields{
+			"db":    db.dbID,
+			"index": logIndex,
+		})
+	)
+	for i, q := range request.Payload.Queries {
+		queries[i] = q.Pattern
+	}
+	if peers != nil {
+		for _, s := range peers.Servers {
+			if s != db.nodeID {
+				pending[s] = true
+			}
+		}
+	}
+	db.updateSchemaStatus(func(status *types.SchemaChangeStatus) {
+		*status = types.SchemaChangeStatus{
+			Pending:   len(pending) > 0,
+			Queries:   queries,
+			LogIndex:  logIndex,
+			StartTime: time.Now().UTC(),
+			Applied:   []proto.NodeID{db.nodeID},
+		}
+		if !status.Pending {
+			status.FinishTime = status.StartTime
+		}
+	})
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), SchemaChangeTimeout)
+	defer cancel()
+	defer db.updateSchemaStatus(func(status *types.SchemaChangeStatus) {
+		status.Pending = false
+		status.FinishTime = time.Now().UTC()
+	})
+
+	caller := rpc.NewCaller()
+	ticker := time.NewTicker(SchemaChangePollInterval)
+	defer ticker.Stop()
+	for {
+		for node := range pending {
+			var (
+				req  = &types.SchemaChangeStatusReq{DatabaseID: db.dbID}
+				resp = &types.SchemaChangeStatusResp{}
+			)
+			if err := caller.CallNodeWithContext(
+				ctx, node, route.DBSSchemaChangeStatus.String(), req, resp,
+			); err != nil {
+				le.WithField("node", node).WithError(err).Debug("failed to fetch schema change status")
+				continue
+			}
+			if resp.LastCommit >= logIndex {
+				delete(pending, node)
+				db.updateSchemaStatus(func(status *types.SchemaChangeStatus) {
+					status.Applied = append(status.Applied, node)
+				})
+			}
+		}
+		if len(pending) == 0 {
+			le.Debug("schema change applied by all replicas")
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			le.WithField("pending", len(pending)).Warning("wait schema change timeout, accepting writes")
+			return
+		}
+	}
+}
+
+func (dbms *DBMS) schemaChangeStatus(req *types.SchemaChangeStatusReq) (
+	resp *types.SchemaChangeStatusResp, err error,
+) {
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+
+	// peer miners are always permitted to check the barrier
+	var (
+		nodeID = req.GetNodeID().ToNodeID()
+		isPeer = false
+	)
+	if peers := db.kayakRuntime.Peers(); peers != nil {
+		for _, s := range peers.Servers {
+			isPeer = isPeer || s == nodeID
+		}
+	}
+	if !isPeer {
+		var addr proto.AccountAddress
+		if addr, err = nodeAccountAddress(nodeID); err != nil {
+			return
+		}
+		if err = dbms.checkPermission(addr, req.DatabaseID, types.ReadQuery, nil); err != nil {
+			return
+		}
+	}
+
+	resp = &types.SchemaChangeStatusResp{
+		LastCommit: db.kayakRuntime.LastCommit(),
+		Status:     db.SchemaChangeStatus(),
+	}
+	return
+}
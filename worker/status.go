@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// DatabaseStatus describes the serving status of a database on the miner.
+type DatabaseStatus struct {
+	DatabaseID     proto.DatabaseID
+	Role           proto.ServerRole // Leader or Follower of the database
+	Leader         proto.NodeID
+	Learner        bool                    // the miner is a non-voting learner replica
+	LearnerLags    map[proto.NodeID]uint64 // committed logs not acknowledged by each learner, reported by leader
+	Height         int32                   // height of the sqlchain head block
+	Head           hash.Hash               // hash of the sqlchain head block
+	LastCommit     uint64                  // last committed kayak log index
+	CommitLag      uint64                  // kayak log index lag behind the last commit of leader
+	ReplicationLag time.Duration           // time since the state is known to catch up with leader
+	StorageSize    int64                   // total size in bytes of the database data directory
+}
+
+// Status returns the current serving status of the database.
+func (db *Database) Status() (status *DatabaseStatus) {
+	status = &DatabaseStatus{
+		DatabaseID:  db.dbID,
+		Role:        proto.Leader,
+		LastCommit:  db.kayakRuntime.LastCommit(),
+		StorageSize: dirSize(db.cfg.DataDir),
+	}
+	status.CommitLag, status.ReplicationLag = db.kayakRuntime.Staleness()
+	if peers := db.kayakRuntime.Peers(); peers != nil {
+		status.Leader = peers.Leader
+		if peers.Leader != db.nodeID {
+			status.Role = proto.Follower
+			status.Learner = peers.IsLearner(db.nodeID)
+		} else if len(peers.Learners) > 0 {
+			status.LearnerLags = make(map[proto.NodeID]uint64, len(peers.Learners))
+			for _, l := range peers.Learners {
+				if lag, err := db.kayakRuntime.LearnerLag(l); err == nil {
+					status.LearnerLags[l] = lag
+				}
+			}
+		}
+	}
+	status.Height, status.Head = db.chain.Head()
+	return
+}
+
+// Status returns the serving status of all databases on the miner ordered by database id.
+func (dbms *DBMS) Status() (statuses []*DatabaseStatus) {
+	dbms.dbMap.Range(func(_, rawDB interface{}) bool {
+		statuses = append(statuses, rawDB.(*Database).Status())
+		return true
+	})
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].DatabaseID < statuses[j].DatabaseID
+	})
+	return
+}
+
+func dirSize(dir string) (size int64) {
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
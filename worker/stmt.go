@@ -0,0 +1,154 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+)
+
+// stmtRegistry tracks the statements prepared by each client node. Statements are identified
+// by node instead of connection, as the client rotates connection id on every query.
+type stmtRegistry struct {
+	sync.Mutex
+	nextID     uint64
+	statements map[proto.NodeID]map[uint64]string
+}
+
+func newStmtRegistry() *stmtRegistry {
+	return &stmtRegistry{
+		statements: make(map[proto.NodeID]map[uint64]string),
+	}
+}
+
+func (r *stmtRegistry) add(nodeID proto.NodeID, pattern string) (id uint64, err error) {
+	r.Lock()
+	defer r.Unlock()
+	stmts, ok := r.statements[nodeID]
+	if !ok {
+		stmts = make(map[uint64]string)
+		r.statements[nodeID] = stmts
+	}
+	if len(stmts) >= MaxPreparedStatements {
+		err = errors.Wrapf(ErrTooManyStatements, "node %s", nodeID)
+		return
+	}
+	r.nextID++
+	id = r.nextID
+	stmts[id] = pattern
+	return
+}
+
+func (r *stmtRegistry) remove(nodeID proto.NodeID, id uint64) (pattern string, err error) {
+	r.Lock()
+	defer r.Unlock()
+	stmts, ok := r.statements[nodeID]
+	if !ok {
+		err = errors.Wrapf(ErrStatementNotFound, "statement %d", id)
+		return
+	}
+	if pattern, ok = stmts[id]; !ok {
+		err = errors.Wrapf(ErrStatementNotFound, "statement %d", id)
+		return
+	}
+	delete(stmts, id)
+	if len(stmts) == 0 {
+		delete(r.statements, nodeID)
+	}
+	return
+}
+
+// Prepare prepares the statement of pattern for the client node and returns its id. Later
+// queries carrying the same pattern skip the query parsing while the statement is open.
+func (db *Database) Prepare(nodeID proto.NodeID, pattern string) (id uint64, stmt *x.Statement, err error) {
+	if stmt, err = db.chain.Prepare(pattern); err != nil {
+		return
+	}
+	if id, err = db.stmts.add(nodeID, pattern); err != nil {
+		db.chain.CloseStatement(pattern)
+		return
+	}
+	return
+}
+
+// CloseStatement releases the statement with id prepared by the client node.
+func (db *Database) CloseStatement(nodeID proto.NodeID, id uint64) (err error) {
+	var pattern string
+	if pattern, err = db.stmts.remove(nodeID, id); err != nil {
+		return
+	}
+	db.chain.CloseStatement(pattern)
+	return
+}
+
+// normalizeQuery returns the normalized query of pattern, reusing the prepared statement if any.
+func (db *Database) normalizeQuery(pattern string) string {
+	if stmt, ok := db.chain.PreparedStatement(pattern); ok {
+		return stmt.Normalized
+	}
+	return x.NormalizeQuery(pattern)
+}
+
+func (dbms *DBMS) prepare(req *types.PrepareReq) (resp *types.PrepareResp, err error) {
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+
+	var (
+		nodeID = req.GetNodeID().ToNodeID()
+		addr   proto.AccountAddress
+	)
+	if addr, err = nodeAccountAddress(nodeID); err != nil {
+		return
+	}
+	if err = dbms.checkPermission(addr, req.DatabaseID, types.ReadQuery, nil); err != nil {
+		return
+	}
+
+	var (
+		id   uint64
+		stmt *x.Statement
+	)
+	if id, stmt, err = db.Prepare(nodeID, req.Pattern); err != nil {
+		return
+	}
+	resp = &types.PrepareResp{
+		StatementID: id,
+		Normalized:  stmt.Normalized,
+	}
+	return
+}
+
+func (dbms *DBMS) closeStmt(req *types.CloseStmtReq) (resp *types.CloseStmtResp, err error) {
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+	if err = db.CloseStatement(req.GetNodeID().ToNodeID(), req.StatementID); err != nil {
+		return
+	}
+	resp = &types.CloseStmtResp{}
+	return
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+)
+
+const (
+	// DefaultMaxWriteBatchSize defines the default max request count of a write batch.
+	DefaultMaxWriteBatchSize = 128
+
+	// writeBatchMarker prefixes the encoded write batch payload, 0xc1 is never used by msgpack
+	// so the batch payload is distinguished from the single request payload.
+	writeBatchMarker byte = 0xc1
+)
+
+// writeBatch defines a group of write requests replicated and committed as a single log entry.
+type writeBatch struct {
+	Requests []*types.Request
+}
+
+// writeBatchPayload defines the encoding of write batch, the requests are kept in their own
+// encoding to reuse the marshal cache.
+type writeBatchPayload struct {
+	Requests [][]byte
+}
+
+type writeResult struct {
+	tracker  *x.QueryTracker
+	response *types.Response
+	err      error
+}
+
+type pendingWrite struct {
+	req    *types.Request
+	result chan *writeResult
+}
+
+// writeBatcher groups the concurrent write requests of the leader arrived within the batch
+// window, and applies them to kayak runtime as a single log entry.
+type writeBatcher struct {
+	db        *Database
+	window    time.Duration
+	maxSize   int
+	pendingCh chan *pendingWrite
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+func newWriteBatcher(db *Database, window time.Duration, maxSize int) *writeBatcher {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxWriteBatchSize
+	}
+	return &writeBatcher{
+		db:        db,
+		window:    window,
+		maxSize:   maxSize,
+		pendingCh: make(chan *pendingWrite),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+func (b *writeBatcher) start() {
+	b.wg.Add(1)
+	go b.run()
+}
+
+func (b *writeBatcher) stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopCh)
+	})
+	b.wg.Wait()
+}
+
+// submit enqueues the write request into the next batch and waits for its result.
+func (b *writeBatcher) submit(req *types.Request) (tracker *x.QueryTracker, response *types.Response, err error) {
+	p := &pendingWrite{
+		req:    req,
+		result: make(chan *writeResult, 1),
+	}
+	select {
+	case b.pendingCh <- p:
+	case <-b.stopCh:
+		err = ErrWriterStopped
+		return
+	case <-req.GetContext().Done():
+		err = req.GetContext().Err()
+		return
+	}
+	r := <-p.result
+	return r.tracker, r.response, r.err
+}
+
+func (b *writeBatcher) run() {
+	defer b.wg.Done()
+	for {
+		var batch []*pendingWrite
+		select {
+		case p := <-b.pendingCh:
+			batch = append(batch, p)
+		case <-b.stopCh:
+			return
+		}
+
+		timer := time.NewTimer(b.window)
+	collectLoop:
+		for len(batch) < b.maxSize {
+			select {
+			case p := <-b.pendingCh:
+				batch = append(batch, p)
+			case <-timer.C:
+				break collectLoop
+			case <-b.stopCh:
+				break collectLoop
+			}
+		}
+		timer.Stop()
+
+		b.apply(batch)
+	}
+}
+
+func (b *writeBatcher) apply(batch []*pendingWrite) {
+	var (
+		valid = make([]*pendingWrite, 0, len(batch))
+		seqs  = make(map[uint64]uint64)
+	)
+	// drop the invalid requests before replication, otherwise they fail the whole batch
+	for _, p := range batch {
+		var err = p.req.GetContext().Err()
+		if err == nil {
+			err = b.db.precheckRequest(p.req, seqs)
+		}
+		if err != nil {
+			p.result <- &writeResult{err: err}
+			continue
+		}
+		seqs[p.req.Header.ConnectionID] = p.req.Header.SeqNo
+		valid = append(valid, p)
+	}
+
+	switch len(valid) {
+	case 0:
+		return
+	case 1:
+		var r = &writeResult{}
+		r.tracker, r.response, _, r.err = b.db.applyWrite(valid[0].req)
+		valid[0].result <- r
+		return
+	}
+
+	var wb = &writeBatch{
+		Requests: make([]*types.Request, len(valid)),
+	}
+	for i, p := range valid {
+		wb.Requests[i] = p.req
+	}
+	result, logIndex, err := b.db.kayakRuntime.Apply(context.Background(), wb)
+	if err == nil {
+		var (
+			results []*writeResult
+			ok      bool
+		)
+		if results, ok = result.([]*writeResult); !ok || len(results) != len(valid) {
+			err = errors.Wrap(ErrInvalidRequest, "invalid batch response type")
+		} else {
+			for i, p := range valid {
+				p.result <- results[i]
+			}
+			log.WithFields(log.Fields{
+				"db":    b.db.dbID,
+				"index": logIndex,
+				"count": len(valid),
+			}).Debug("applied write batch")
+			return
+		}
+	}
+	err = errors.Wrap(err, "apply failed")
+	for _, p := range valid {
+		p.result <- &writeResult{err: err}
+	}
+}
+
+func (db *Database) commitBatch(wb *writeBatch, isLeader bool) (results []*writeResult) {
+	results = make([]*writeResult, len(wb.Requests))
+	for i, req := range wb.Requests {
+		// reset context, commit should never be canceled
+		req.SetContext(context.Background())
+		var r = &writeResult{}
+		r.tracker, r.response, r.err = db.chain.Query(req, isLeader)
+		results[i] = r
+	}
+	return
+}
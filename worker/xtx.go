@@ -0,0 +1,218 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// preparedTx defines a prepared branch of cross database transaction. The database holds the
+// schema lock exclusively while the branch is prepared, so the prepared queries are kept
+// executable until the branch is committed or rolled back.
+type preparedTx struct {
+	xid      string
+	nodeID   proto.NodeID
+	commit   *types.Request
+	rollback *types.Request
+	timer    *time.Timer
+}
+
+type preparedTxs struct {
+	sync.Mutex
+	current *preparedTx
+}
+
+// PrepareTx prepares a branch of cross database transaction, which is rolled back automatically
+// if not finished within the timeout of the request.
+func (db *Database) PrepareTx(nodeID proto.NodeID, req *types.PrepareTxReq) (response *types.Response, err error) {
+	if req.XID == "" || req.Timeout <= 0 || req.Timeout >= db.TimeLimits().MaxWriteTimeGap {
+		err = errors.Wrap(ErrInvalidRequest, "invalid transaction id or timeout")
+		return
+	}
+	for _, r := range []*types.Request{&req.Prepare, &req.Commit, &req.Rollback} {
+		if r.Header.QueryType != types.WriteQuery || r.Header.DatabaseID != db.dbID {
+			err = errors.Wrap(ErrInvalidRequest, "invalid transaction record")
+			return
+		}
+	}
+	var containsDDL bool
+	if containsDDL, err = db.chain.ContainsDDL(req.Commit.Payload.Queries); err != nil {
+		err = errors.Wrap(err, "invalid query")
+		return
+	} else if containsDDL {
+		err = errors.Wrap(ErrInvalidRequest, "schema change in cross database transaction")
+		return
+	}
+
+	// block the other writes until the branch is finished
+	db.schemaLock.Lock()
+	if _, response, _, err = db.applyWrite(&req.Prepare); err != nil {
+		db.schemaLock.Unlock()
+		return
+	}
+	if err = db.chain.DryRun(&req.Commit); err != nil {
+		if _, _, _, rerr := db.applyWrite(&req.Rollback); rerr != nil {
+			log.WithField("xid", req.XID).WithError(rerr).Error("record transaction rollback failed")
+		}
+		db.schemaLock.Unlock()
+		err = errors.Wrap(err, "prepare transaction failed")
+		return
+	}
+
+	tx := &preparedTx{
+		xid:      req.XID,
+		nodeID:   nodeID,
+		commit:   &req.Commit,
+		rollback: &req.Rollback,
+	}
+	db.xtxs.Lock()
+	db.xtxs.current = tx
+	tx.timer = time.AfterFunc(req.Timeout, func() {
+		if _, err := db.finishTx(tx, false); err != nil {
+			log.WithField("xid", tx.xid).WithError(err).Error("rollback timeout transaction failed")
+		}
+	})
+	db.xtxs.Unlock()
+	return
+}
+
+// FinishTx commits or rolls back the prepared branch of cross database transaction.
+func (db *Database) FinishTx(nodeID proto.NodeID, xid string, commit bool) (response *types.Response, err error) {
+	db.xtxs.Lock()
+	tx := db.xtxs.current
+	db.xtxs.Unlock()
+	if tx == nil || tx.xid != xid || tx.nodeID != nodeID {
+		err = errors.Wrapf(ErrTxNotFound, "xid %s", xid)
+		return
+	}
+	return db.finishTx(tx, commit)
+}
+
+func (db *Database) finishTx(tx *preparedTx, commit bool) (response *types.Response, err error) {
+	db.xtxs.Lock()
+	if db.xtxs.current != tx {
+		db.xtxs.Unlock()
+		err = errors.Wrapf(ErrTxNotFound, "xid %s", tx.xid)
+		return
+	}
+	db.xtxs.current = nil
+	tx.timer.Stop()
+	db.xtxs.Unlock()
+	defer db.schemaLock.Unlock()
+
+	le := log.WithFields(log.Fields{"db": db.dbID, "xid": tx.xid})
+	if commit {
+		if _, response, _, err = db.applyWrite(tx.commit); err == nil {
+			le.Debug("committed cross database transaction")
+			return
+		}
+		le.WithError(err).Error("commit prepared transaction failed")
+	}
+	var rerr error
+	if _, response, _, rerr = db.applyWrite(tx.rollback); rerr != nil {
+		le.WithError(rerr).Error("record transaction rollback failed")
+		if err == nil {
+			err = rerr
+		}
+		return
+	}
+	le.Debug("rolled back cross database transaction")
+	return
+}
+
+// abortTx rolls back the prepared branch of cross database transaction if exists.
+func (db *Database) abortTx() {
+	db.xtxs.Lock()
+	tx := db.xtxs.current
+	db.xtxs.Unlock()
+	if tx != nil {
+		_, _ = db.finishTx(tx, false)
+	}
+}
+
+func (dbms *DBMS) prepareTx(req *types.PrepareTxReq) (resp *types.PrepareTxResp, err error) {
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+
+	// only the owner of database is allowed to run cross database transaction
+	var (
+		nodeID = req.GetNodeID().ToNodeID()
+		addr   proto.AccountAddress
+	)
+	if addr, err = nodeAccountAddress(nodeID); err != nil {
+		return
+	}
+	if err = dbms.checkPermission(addr, req.DatabaseID, types.WriteQuery, req.Commit.Payload.Queries); err != nil {
+		return
+	}
+	if permStat, ok := dbms.busService.RequestPermStat(req.DatabaseID, addr); !ok ||
+		!permStat.Permission.HasSuperPermission() {
+		err = errors.Wrap(ErrPermissionDeny, "cross database transaction requires super permission")
+		return
+	}
+	for _, r := range []*types.Request{&req.Prepare, &req.Commit, &req.Rollback} {
+		var signer proto.AccountAddress
+		if r.Header.Signee == nil {
+			err = errors.Wrap(ErrInvalidRequest, "unsigned transaction record")
+			return
+		}
+		if signer, err = crypto.PubKeyHash(r.Header.Signee); err != nil {
+			return
+		}
+		if signer != addr {
+			err = errors.Wrap(ErrPermissionDeny, "transaction record signed by another account")
+			return
+		}
+	}
+
+	var response *types.Response
+	if response, err = db.PrepareTx(nodeID, req); err != nil {
+		return
+	}
+	resp = &types.PrepareTxResp{
+		Response: *response,
+	}
+	return
+}
+
+func (dbms *DBMS) finishTx(req *types.FinishTxReq) (resp *types.FinishTxResp, err error) {
+	db, exists := dbms.getMeta(req.DatabaseID)
+	if !exists {
+		err = ErrNotExists
+		return
+	}
+
+	var response *types.Response
+	if response, err = db.FinishTx(req.GetNodeID().ToNodeID(), req.XID, req.Commit); err != nil {
+		return
+	}
+	resp = &types.FinishTxResp{
+		Response: *response,
+	}
+	return
+}
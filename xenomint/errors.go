@@ -33,4 +33,14 @@ var (
 	ErrStatefulQueryParts = errors.New("query contains stateful query parts")
 	// ErrInvalidTableName indicates query contains invalid table name in ddl statement.
 	ErrInvalidTableName = errors.New("invalid table name in ddl")
+	// ErrResultSizeExceeded indicates the query result exceeds the max result size.
+	ErrResultSizeExceeded = errors.New("query result size exceeded")
+	// ErrUnsupportedTokenizer indicates the fulltext table uses a non-deterministic tokenizer.
+	ErrUnsupportedTokenizer = errors.New("unsupported fulltext tokenizer")
+	// ErrStatementNotPreparable indicates the transaction control statement can not be prepared.
+	ErrStatementNotPreparable = errors.New("statement not preparable")
+	// ErrUnregisteredFunction indicates the query calls a function not in the function registry.
+	ErrUnregisteredFunction = errors.New("function not registered")
+	// ErrInvalidTrigger indicates the trigger definition is malformed or not deterministic.
+	ErrInvalidTrigger = errors.New("invalid trigger")
 )
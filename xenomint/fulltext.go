@@ -0,0 +1,180 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"strings"
+
+	"github.com/CovenantSQL/sqlparser"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultFTS5Tokenizer defines the tokenizer pinned to the fts5 tables created without an
+	// explicit tokenizer, so that the table keeps tokenizing identically regardless of the
+	// default of the sqlite version of the replicas.
+	DefaultFTS5Tokenizer = "unicode61 remove_diacritics 1"
+)
+
+var (
+	// fulltextTokenizers defines the deterministic builtin tokenizers of the fulltext modules,
+	// locale dependent tokenizers like icu are not allowed as replicas may tokenize differently.
+	fulltextTokenizers = map[string]map[string]bool{
+		"fts3": {"simple": true, "porter": true, "unicode61": true},
+		"fts4": {"simple": true, "porter": true, "unicode61": true},
+		"fts5": {"ascii": true, "porter": true, "unicode61": true},
+	}
+)
+
+// sanitizeVirtualTable validates the tokenizer configuration of the fulltext virtual table
+// statement and pins the default fts5 tokenizer.
+func sanitizeVirtualTable(stmt *sqlparser.DDL, query string) (sanitized string, err error) {
+	var (
+		module     = strings.ToLower(stmt.NewName.Name.String())
+		tokenizers = fulltextTokenizers[module]
+		open, end  int
+		hasTokens  bool
+	)
+	if tokenizers == nil {
+		// not a fulltext module
+		return query, nil
+	}
+	if open, end = findArgs(query); open < 0 || end < 0 {
+		// no module arguments, default tokenizer of fts3/fts4, fts5 requires columns anyway
+		return query, nil
+	}
+
+	args := splitArgs(query[open+1 : end])
+	for _, arg := range args {
+		lower := strings.ToLower(arg)
+		if !strings.HasPrefix(lower, "tokenize") {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(arg[len("tokenize"):]), "="))
+		if value == "" {
+			// a column named tokenize
+			continue
+		}
+		hasTokens = true
+		words := strings.Fields(unquote(value))
+		for i := range words {
+			words[i] = strings.ToLower(unquote(words[i]))
+		}
+		if len(words) == 0 || !tokenizers[words[0]] {
+			err = errors.Wrapf(ErrUnsupportedTokenizer, "%s tokenizer %s", module, value)
+			return
+		}
+		if module == "fts5" && words[0] == "porter" && len(words) > 1 && !tokenizers[words[1]] {
+			// porter wraps another tokenizer
+			err = errors.Wrapf(ErrUnsupportedTokenizer, "%s tokenizer %s", module, value)
+			return
+		}
+	}
+
+	if module != "fts5" || hasTokens {
+		return query, nil
+	}
+	pinned := "tokenize = '" + DefaultFTS5Tokenizer + "'"
+	if len(args) > 0 {
+		pinned = ", " + pinned
+	}
+	sanitized = query[:end] + pinned + query[end:]
+	return
+}
+
+// findArgs returns the positions of the parentheses enclosing the module arguments.
+func findArgs(query string) (open int, end int) {
+	var (
+		quote byte
+		depth int
+	)
+	open, end = -1, -1
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			if depth == 0 && open < 0 {
+				open = i
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 && open >= 0 {
+				end = i
+				return
+			}
+		}
+	}
+	return
+}
+
+// splitArgs splits the module arguments by the top level commas.
+func splitArgs(s string) (args []string) {
+	var (
+		quote byte
+		depth int
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(args) > 0 {
+		args = append(args, last)
+	}
+	return
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 {
+		switch s[0] {
+		case '\'', '"', '`':
+			if s[len(s)-1] == s[0] {
+				return s[1 : len(s)-1]
+			}
+		case '[':
+			if s[len(s)-1] == ']' {
+				return s[1 : len(s)-1]
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,114 @@
+// +build sqlite_fts5
+
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestFulltextSearch(t *testing.T) {
+	Convey("Given a state with fts5 enabled storage", t, func() {
+		var (
+			filePath = path.Join(testingDataDir, t.Name())
+			resp     *types.Response
+		)
+		storage, err := xs.NewSqlite(fmt.Sprint("file:", filePath))
+		So(err, ShouldBeNil)
+		state := NewState(sql.LevelReadUncommitted, nodeID, storage)
+		Reset(func() {
+			err = state.Close(true)
+			So(err, ShouldBeNil)
+			for _, suffix := range []string{"", "-shm", "-wal"} {
+				err = os.Remove(fmt.Sprint(filePath, suffix))
+				So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			}
+		})
+
+		_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+			buildQuery(`CREATE VIRTUAL TABLE docs USING fts5(title, body)`),
+			buildQuery(`INSERT INTO docs (title, body) VALUES (?, ?), (?, ?)`,
+				"first", "the quick brown fox", "second", "jumps over the lazy dog"),
+		}), true)
+		So(err, ShouldBeNil)
+
+		Convey("The tokenizer should be pinned in schema", func() {
+			_, resp, err = state.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT sql FROM sqlite_master WHERE name = 'docs'`),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+			So(resp.Payload.Rows[0].Values[0], ShouldContainSubstring, DefaultFTS5Tokenizer)
+		})
+		Convey("The match query should return matched documents", func() {
+			_, resp, err = state.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT title, highlight(docs, 1, '[', ']') FROM docs
+WHERE docs MATCH ? ORDER BY rank`, "fox"),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "first")
+			So(resp.Payload.Rows[0].Values[1], ShouldEqual, "the quick brown [fox]")
+		})
+		Convey("The fulltext index should be synced by state delta", func() {
+			var (
+				replicaPath = fmt.Sprint(filePath, "-replica")
+				digest      *StateDigest
+				delta       *StateDelta
+			)
+			replicaStorage, err := xs.NewSqlite(fmt.Sprint("file:", replicaPath))
+			So(err, ShouldBeNil)
+			replica := NewState(sql.LevelReadUncommitted, nodeID, replicaStorage)
+			defer func() {
+				_ = replica.Close(true)
+				for _, suffix := range []string{"", "-shm", "-wal"} {
+					_ = os.Remove(fmt.Sprint(replicaPath, suffix))
+				}
+			}()
+
+			digest, err = replica.Digest()
+			So(err, ShouldBeNil)
+			delta, err = state.Delta(digest)
+			So(err, ShouldBeNil)
+			err = replica.ApplyDelta(delta)
+			So(err, ShouldBeNil)
+
+			_, resp, err = replica.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT title FROM docs WHERE docs MATCH ?`, "lazy"),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "second")
+		})
+		Convey("The non-deterministic tokenizer should be rejected", func() {
+			_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE VIRTUAL TABLE docs2 USING fts5(body, tokenize = 'icu')`),
+			}), true)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/sqlparser"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+const (
+	// maxRandomBlobSize defines the max size of the rewritten randomblob function.
+	maxRandomBlobSize = 1 << 16
+
+	// timeValueFormat defines the format of the rewritten current time values.
+	timeValueFormat = "2006-01-02 15:04:05.000"
+)
+
+var (
+	functionRegistryLock sync.RWMutex
+	// functionRegistryStrict rejects calls to the functions not in registry if enabled.
+	functionRegistryStrict bool
+	// functionRegistry is the registry of reviewed deterministic functions usable in queries.
+	functionRegistry = map[string]bool{
+		// core functions
+		"abs": true, "char": true, "coalesce": true, "concat": true, "concat_ws": true,
+		"format": true, "glob": true, "hex": true, "ifnull": true, "iif": true, "instr": true,
+		"length": true, "like": true, "lower": true, "ltrim": true, "max": true, "min": true,
+		"nullif": true, "octet_length": true, "printf": true, "quote": true, "replace": true,
+		"round": true, "rtrim": true, "sign": true, "soundex": true, "substr": true,
+		"substring": true, "trim": true, "unhex": true, "unicode": true, "upper": true,
+		"zeroblob": true, "changes": true, "last_insert_rowid": true, "total_changes": true,
+		// math functions
+		"acos": true, "acosh": true, "asin": true, "asinh": true, "atan": true, "atan2": true,
+		"atanh": true, "ceil": true, "ceiling": true, "cos": true, "cosh": true, "degrees": true,
+		"exp": true, "floor": true, "ln": true, "log": true, "log10": true, "log2": true,
+		"mod": true, "pi": true, "pow": true, "power": true, "radians": true, "sin": true,
+		"sinh": true, "sqrt": true, "tan": true, "tanh": true, "trunc": true,
+		// aggregate functions
+		"avg": true, "count": true, "group_concat": true, "string_agg": true, "sum": true,
+		"total": true,
+		// window functions
+		"row_number": true, "rank": true, "dense_rank": true, "percent_rank": true,
+		"cume_dist": true, "ntile": true, "lag": true, "lead": true, "first_value": true,
+		"last_value": true, "nth_value": true,
+		// date and time functions, current time is rewritten to request time
+		"date": true, "time": true, "datetime": true, "julianday": true, "strftime": true,
+		// json functions
+		"json": true, "json_array": true, "json_array_length": true, "json_extract": true,
+		"json_insert": true, "json_object": true, "json_patch": true, "json_remove": true,
+		"json_replace": true, "json_set": true, "json_type": true, "json_valid": true,
+		"json_quote": true, "json_group_array": true, "json_group_object": true,
+		"json_each": true, "json_tree": true,
+		// fulltext auxiliary functions
+		"bm25": true, "highlight": true, "snippet": true, "offsets": true,
+		"matchinfo": true,
+		// random functions, rewritten to values derived from request hash
+		"random": true, "randomblob": true,
+	}
+
+	// timeFunctions defines the date and time functions to rewrite the current time argument.
+	timeFunctions = map[string]bool{
+		"date": true, "time": true, "datetime": true, "julianday": true, "strftime": true,
+	}
+)
+
+// RegisterFunction adds the reviewed deterministic functions to the registry of functions usable
+// in queries under strict function check, the functions must be deterministic across replicas and
+// registered to the sqlite driver of all the miners.
+func RegisterFunction(names ...string) {
+	functionRegistryLock.Lock()
+	defer functionRegistryLock.Unlock()
+	for _, name := range names {
+		functionRegistry[strings.ToLower(name)] = true
+	}
+}
+
+// UnregisterFunction removes the functions from the registry of functions usable in queries.
+func UnregisterFunction(names ...string) {
+	functionRegistryLock.Lock()
+	defer functionRegistryLock.Unlock()
+	for _, name := range names {
+		delete(functionRegistry, strings.ToLower(name))
+	}
+}
+
+// SetStrictFunctionCheck sets whether the calls to the functions not in registry are rejected,
+// all functions are allowed by default.
+func SetStrictFunctionCheck(strict bool) {
+	functionRegistryLock.Lock()
+	defer functionRegistryLock.Unlock()
+	functionRegistryStrict = strict
+}
+
+func isAllowedFunction(name string) bool {
+	functionRegistryLock.RLock()
+	defer functionRegistryLock.RUnlock()
+	return !functionRegistryStrict || functionRegistry[name]
+}
+
+// queryEnv provides the chain determined values of a request to rewrite the non-deterministic
+// function calls, so all the replicas executing the request get the same results.
+type queryEnv struct {
+	now       time.Time
+	rand      *rand.Rand
+	rewritten bool // function calls of the query are rewritten with the values
+}
+
+func newQueryEnv(req *types.Request) *queryEnv {
+	var h = req.Header.Hash()
+	return &queryEnv{
+		now:  req.Header.Timestamp.UTC(),
+		rand: rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(h[:8])))),
+	}
+}
+
+// newDetachedQueryEnv returns a query environment to sanitize queries without a request, the
+// rewritten results are only used to check the queries.
+func newDetachedQueryEnv() *queryEnv {
+	return &queryEnv{
+		rand: rand.New(rand.NewSource(0)),
+	}
+}
+
+// rewriteFunc rewrites the non-deterministic function call in place with the environment values.
+func (e *queryEnv) rewriteFunc(n *sqlparser.FuncExpr) (err error) {
+	switch name := n.Name.Lowered(); {
+	case name == "random":
+		if len(n.Exprs) != 0 {
+			return errors.Wrap(ErrStatefulQueryParts, "random function with arguments")
+		}
+		e.replaceFunc(n, sqlparser.NewIntVal([]byte(strconv.FormatInt(int64(e.rand.Uint64()), 10))))
+	case name == "randomblob":
+		var size int
+		if len(n.Exprs) == 1 {
+			if ae, ok := n.Exprs[0].(*sqlparser.AliasedExpr); ok {
+				if v, ok := ae.Expr.(*sqlparser.SQLVal); ok && v.Type == sqlparser.IntVal {
+					size, _ = strconv.Atoi(string(v.Val))
+				}
+			}
+		}
+		if size <= 0 || size > maxRandomBlobSize {
+			return errors.Wrap(ErrStatefulQueryParts, "randomblob function requires a constant size")
+		}
+		blob := make([]byte, size)
+		_, _ = e.rand.Read(blob)
+		e.replaceFunc(n, sqlparser.NewHexVal([]byte(hex.EncodeToString(blob))))
+	case timeFunctions[name]:
+		for _, expr := range n.Exprs {
+			if ae, ok := expr.(*sqlparser.AliasedExpr); ok {
+				if v, ok := ae.Expr.(*sqlparser.SQLVal); ok && v.Type == sqlparser.StrVal {
+					switch {
+					case strings.EqualFold(string(v.Val), "now"):
+						v.Val = []byte(e.now.Format(timeValueFormat))
+						e.rewritten = true
+					case strings.EqualFold(string(v.Val), "localtime"):
+						// node local timezone differs between miners
+						return errors.Wrap(ErrStatefulQueryParts, "localtime modifier is not deterministic")
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+func (e *queryEnv) replaceFunc(n *sqlparser.FuncExpr, value sqlparser.Expr) {
+	n.Qualifier = sqlparser.TableIdent{}
+	n.Name = sqlparser.NewColIdent("coalesce")
+	n.Distinct = false
+	// coalesce requires at least two arguments
+	n.Exprs = sqlparser.SelectExprs{
+		&sqlparser.AliasedExpr{Expr: value},
+		&sqlparser.AliasedExpr{Expr: &sqlparser.NullVal{}},
+	}
+	e.rewritten = true
+}
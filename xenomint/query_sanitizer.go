@@ -23,6 +23,7 @@ import (
 	"strings"
 
 	"github.com/CovenantSQL/sqlparser"
+	querypb "github.com/CovenantSQL/sqlparser/dependency/querypb"
 	"github.com/pkg/errors"
 
 	"github.com/CovenantSQL/CovenantSQL/types"
@@ -74,9 +75,137 @@ var (
 	}
 )
 
+// ContainsDDL reports whether any of the queries contains a schema change statement.
+func ContainsDDL(queries []types.Query) (containsDDL bool, err error) {
+	for _, q := range queries {
+		if containsDDL, _, _, err = convertQueryAndBuildArgsWithEnv(
+			q.Pattern, q.Args, newDetachedQueryEnv()); err != nil || containsDDL {
+			return
+		}
+	}
+	return
+}
+
+// NormalizeQuery returns the query pattern with literals replaced by placeholders, so that
+// statements differing only in literal values are aggregated together. The whitespace
+// collapsed pattern is returned if the query can not be parsed.
+func NormalizeQuery(pattern string) (normalized string) {
+	var (
+		tokenizer  = sqlparser.NewStringTokenizer(pattern)
+		statements []sqlparser.Statement
+		parts      []string
+		err        error
+	)
+	if _, statements, err = sqlparser.ParseMultiple(tokenizer); err != nil || len(statements) == 0 {
+		return strings.Join(strings.Fields(pattern), " ")
+	}
+	parts = make([]string, len(statements))
+	for i, stmt := range statements {
+		sqlparser.Normalize(stmt, map[string]*querypb.BindVariable{}, "v")
+		parts[i] = sqlparser.String(stmt)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// sanitizeNodes checks the nodes for stateful query parts and unregistered function calls, the
+// non-deterministic function calls are rewritten with env if it's not nil. Trigger bodies are
+// checked with inTrigger set, which allows the raise function.
+func sanitizeNodes(env *queryEnv, inTrigger bool, nodes ...sqlparser.SQLNode) error {
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch n := node.(type) {
+		case *sqlparser.SQLVal:
+			if n.Type == sqlparser.ValArg && bytes.EqualFold([]byte("CURRENT_TIMESTAMP"), n.Val) {
+				// current_timestamp literal in default expression
+				err = errors.Wrap(ErrStatefulQueryParts, "DEFAULT CURRENT_TIMESTAMP not supported")
+				return
+			}
+		case *sqlparser.TimeExpr:
+			tb := sqlparser.NewTrackedBuffer(nil)
+			err = errors.Wrapf(ErrStatefulQueryParts, "time expression %s not supported",
+				tb.WriteNode(n).String())
+			return
+		case *sqlparser.FuncExpr:
+			if strings.HasPrefix(n.Name.Lowered(), "sqlite") {
+				tb := sqlparser.NewTrackedBuffer(nil)
+				err = errors.Wrapf(ErrStatefulQueryParts, "function call %s not supported",
+					tb.WriteNode(n).String())
+				return
+			}
+			if env != nil {
+				if err = env.rewriteFunc(n); err != nil {
+					return
+				}
+			}
+			if sanitizeArgs, ok := sanitizeFunctionMap[n.Name.Lowered()]; ok {
+				// need to sanitize this function
+				tb := sqlparser.NewTrackedBuffer(nil)
+				sanitizeErr := errors.Wrapf(ErrStatefulQueryParts, "stateful function call %s not supported",
+					tb.WriteNode(n).String())
+
+				if sanitizeArgs == nil {
+					err = sanitizeErr
+					return
+				}
+
+				err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, walkErr error) {
+					if v, ok := node.(*sqlparser.SQLVal); ok {
+						if v.Type == sqlparser.StrVal {
+							argStr := strings.ToLower(string(v.Val))
+
+							if sanitizeArgs[argStr] {
+								walkErr = sanitizeErr
+							}
+							return
+						}
+					}
+					return true, nil
+				}, n.Exprs)
+				if err != nil {
+					return
+				}
+			}
+			if !isAllowedFunction(n.Name.Lowered()) && !(inTrigger && n.Name.Lowered() == "raise") {
+				err = errors.Wrapf(ErrUnregisteredFunction, "%s", n.Name.String())
+				return
+			}
+		}
+		return true, nil
+	}, nodes...)
+}
+
+func isTxControlQuery(pattern string) bool {
+	lower := strings.ToLower(pattern)
+	return strings.Contains(lower, "begin") || strings.Contains(lower, "rollback") ||
+		strings.Contains(lower, "commit")
+}
+
+func buildArgs(args []types.NamedArg) (ifs []interface{}) {
+	ifs = make([]interface{}, len(args))
+	for i, v := range args {
+		ifs[i] = sql.NamedArg{
+			Name:  v.Name,
+			Value: v.Value,
+		}
+	}
+	return
+}
+
 func convertQueryAndBuildArgs(pattern string, args []types.NamedArg) (containsDDL bool, p string, ifs []interface{}, err error) {
-	if lower := strings.ToLower(pattern); strings.Contains(lower, "begin") ||
-		strings.Contains(lower, "rollback") || strings.Contains(lower, "commit") {
+	return convertQueryAndBuildArgsWithEnv(pattern, args, nil)
+}
+
+// convertQueryAndBuildArgsWithEnv sanitizes the query and rewrites the non-deterministic function
+// calls with the values of env, the non-deterministic function calls are rejected if env is nil.
+func convertQueryAndBuildArgsWithEnv(
+	pattern string, args []types.NamedArg, env *queryEnv) (containsDDL bool, p string, ifs []interface{}, err error,
+) {
+	if isTriggerQuery(pattern) {
+		if p, err = sanitizeTrigger(pattern); err != nil {
+			return
+		}
+		return true, p, buildArgs(args), nil
+	}
+	if isTxControlQuery(pattern) {
 		return false, pattern, nil, nil
 	}
 	var (
@@ -150,73 +279,31 @@ WHERE type = "index" AND tbl_name = "%s"
 				err = errors.Wrapf(ErrInvalidTableName, "%s", stmt.NewName.Name.String())
 				return
 			}
-		}
-
-		// scan query and test if there is any stateful query logic like time expression or random function
-		err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
-			switch n := node.(type) {
-			case *sqlparser.SQLVal:
-				if n.Type == sqlparser.ValArg && bytes.EqualFold([]byte("CURRENT_TIMESTAMP"), n.Val) {
-					// current_timestamp literal in default expression
-					err = errors.Wrap(ErrStatefulQueryParts, "DEFAULT CURRENT_TIMESTAMP not supported")
-					return
-				}
-			case *sqlparser.TimeExpr:
-				tb := sqlparser.NewTrackedBuffer(nil)
-				err = errors.Wrapf(ErrStatefulQueryParts, "time expression %s not supported",
-					tb.WriteNode(n).String())
-				return
-			case *sqlparser.FuncExpr:
-				if strings.HasPrefix(n.Name.Lowered(), "sqlite") {
-					tb := sqlparser.NewTrackedBuffer(nil)
-					err = errors.Wrapf(ErrStatefulQueryParts, "function call %s not supported",
-						tb.WriteNode(n).String())
-					return
-				}
-				if sanitizeArgs, ok := sanitizeFunctionMap[n.Name.Lowered()]; ok {
-					// need to sanitize this function
-					tb := sqlparser.NewTrackedBuffer(nil)
-					sanitizeErr := errors.Wrapf(ErrStatefulQueryParts, "stateful function call %s not supported",
-						tb.WriteNode(n).String())
-
-					if sanitizeArgs == nil {
-						err = sanitizeErr
-						return
-					}
-
-					err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, walkErr error) {
-						if v, ok := node.(*sqlparser.SQLVal); ok {
-							if v.Type == sqlparser.StrVal {
-								argStr := strings.ToLower(string(v.Val))
-
-								if sanitizeArgs[argStr] {
-									walkErr = sanitizeErr
-								}
-								return
-							}
-						}
-						return true, nil
-					})
-
+			// for fulltext tables
+			if stmt.Action == sqlparser.CreateVirtualTableStr {
+				if queryParts[i], err = sanitizeVirtualTable(stmt, queryParts[i]); err != nil {
 					return
 				}
 			}
-			return true, nil
-		}, walkNodes...)
-		if err != nil {
+		}
+
+		// scan query and test if there is any stateful query logic like time expression or random
+		// function, the function calls are not rewritten in schema definitions
+		var stmtEnv *queryEnv
+		if _, isDDL := statements[i].(*sqlparser.DDL); !isDDL && env != nil {
+			stmtEnv = &queryEnv{now: env.now, rand: env.rand}
+		}
+		if err = sanitizeNodes(stmtEnv, false, walkNodes...); err != nil {
 			err = errors.Wrap(err, "parse sql failed")
 			return
 		}
+		if stmtEnv != nil && stmtEnv.rewritten {
+			queryParts[i] = sqlparser.String(statements[i])
+			env.rewritten = true
+		}
 	}
 
 	p = strings.Join(queryParts, "; ")
-
-	ifs = make([]interface{}, len(args))
-	for i, v := range args {
-		ifs[i] = sql.NamedArg{
-			Name:  v.Name,
-			Value: v.Value,
-		}
-	}
+	ifs = buildArgs(args)
 	return
 }
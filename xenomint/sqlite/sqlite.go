@@ -17,10 +17,16 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
+	"github.com/pkg/errors"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	"github.com/CovenantSQL/CovenantSQL/storage"
@@ -28,8 +34,194 @@ import (
 )
 
 const (
-	serializableDriver = "sqlite3-custom"
-	dirtyReadDriver    = "sqlite3-dirty-reader"
+	// ParamMaxMemory is the DSN parameter which limits the page cache memory of each connection
+	// in bytes.
+	ParamMaxMemory = "_max_memory"
+	// ParamMaxTempStorage is the DSN parameter which limits the temporary storage of each
+	// connection in bytes.
+	ParamMaxTempStorage = "_max_temp_storage"
+	// ParamMaxDatabaseSize is the DSN parameter which limits the size of the main database in
+	// bytes.
+	ParamMaxDatabaseSize = "_max_database_size"
+
+	tempPageSize = 4096
+	// defaultCacheSize and maxPageCount are the compile-time defaults of sqlite.
+	defaultCacheSize = -2000
+	maxPageCount     = 1073741823
+)
+
+// Limits defines the resource limits applied to each storage connection, zero means unlimited.
+type Limits struct {
+	// MaxMemory limits the page cache memory in bytes.
+	MaxMemory uint64
+	// MaxTempStorage limits the temporary storage in bytes.
+	MaxTempStorage uint64
+	// MaxDatabaseSize limits the size of the main database in bytes.
+	MaxDatabaseSize uint64
+}
+
+func parseLimits(name string) (l Limits, err error) {
+	var dsn *storage.DSN
+	if dsn, err = storage.NewDSN(name); err != nil {
+		return
+	}
+	if v, ok := dsn.GetParam(ParamMaxMemory); ok {
+		if l.MaxMemory, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return
+		}
+	}
+	if v, ok := dsn.GetParam(ParamMaxTempStorage); ok {
+		if l.MaxTempStorage, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return
+		}
+	}
+	if v, ok := dsn.GetParam(ParamMaxDatabaseSize); ok {
+		if l.MaxDatabaseSize, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// pragmas returns the statements to apply the limits with the page size of the main database,
+// the unlimited ones are restored to the sqlite defaults if reset is set.
+func (l Limits) pragmas(pageSize uint64, reset bool) (pragmas []string) {
+	if l.MaxMemory > 0 {
+		// negative cache size is the cache memory in KiB
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = -%d", (l.MaxMemory+1023)>>10))
+	} else if reset {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = %d", defaultCacheSize))
+	}
+	if l.MaxTempStorage > 0 {
+		pragmas = append(pragmas,
+			fmt.Sprintf("PRAGMA temp.page_size = %d", tempPageSize),
+			fmt.Sprintf("PRAGMA temp.max_page_count = %d",
+				(l.MaxTempStorage+tempPageSize-1)/tempPageSize),
+		)
+	} else if reset {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA temp.max_page_count = %d", maxPageCount))
+	}
+	if l.MaxDatabaseSize > 0 {
+		// max page count can not be less than the current page count, so a database already
+		// exceeding the limit is not allowed to grow any more
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA main.max_page_count = %d",
+			(l.MaxDatabaseSize+pageSize-1)/pageSize))
+	} else if reset {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA main.max_page_count = %d", maxPageCount))
+	}
+	return
+}
+
+// sharedLimits holds the limits shared by all connections of a storage, version is increased on
+// each update so that the pooled connections can catch up on reuse.
+type sharedLimits struct {
+	sync.RWMutex
+	limits  Limits
+	version uint64
+}
+
+func (s *sharedLimits) load() (Limits, uint64) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.limits, s.version
+}
+
+func (s *sharedLimits) store(l Limits) {
+	s.Lock()
+	defer s.Unlock()
+	s.limits = l
+	s.version++
+}
+
+// limitedConnector opens connections with the storage limits applied.
+type limitedConnector struct {
+	driver *sqlite3.SQLiteDriver
+	name   string
+	limits *sharedLimits
+}
+
+// Connect implements driver.Connector.Connect.
+func (c *limitedConnector) Connect(context.Context) (conn driver.Conn, err error) {
+	var raw driver.Conn
+	if raw, err = c.driver.Open(c.name); err != nil {
+		return
+	}
+	var lc = &limitedConn{SQLiteConn: raw.(*sqlite3.SQLiteConn), limits: c.limits}
+	if err = lc.apply(false); err != nil {
+		_ = lc.Close()
+		return
+	}
+	conn = lc
+	return
+}
+
+// Driver implements driver.Connector.Driver.
+func (c *limitedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// limitedConn is a sqlite connection which keeps up with the updated storage limits.
+type limitedConn struct {
+	*sqlite3.SQLiteConn
+	limits  *sharedLimits
+	version uint64
+}
+
+func (c *limitedConn) apply(reset bool) (err error) {
+	var (
+		limits, version = c.limits.load()
+		pageSize        uint64
+	)
+	if limits.MaxDatabaseSize > 0 {
+		if pageSize, err = c.pageSize(); err != nil {
+			return
+		}
+	}
+	for _, v := range limits.pragmas(pageSize, reset) {
+		if _, err = c.Exec(v, nil); err != nil {
+			return
+		}
+	}
+	c.version = version
+	return
+}
+
+func (c *limitedConn) pageSize() (size uint64, err error) {
+	var (
+		rows driver.Rows
+		dest = make([]driver.Value, 1)
+	)
+	if rows, err = c.Query("PRAGMA main.page_size", nil); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	if err = rows.Next(dest); err != nil {
+		return
+	}
+	if v, ok := dest[0].(int64); ok && v > 0 {
+		size = uint64(v)
+		return
+	}
+	err = errors.Errorf("unexpected page size: %v", dest[0])
+	return
+}
+
+// ResetSession implements driver.SessionResetter.ResetSession, which is called before the
+// connection is reused.
+func (c *limitedConn) ResetSession(context.Context) (err error) {
+	if _, version := c.limits.load(); version == c.version {
+		return
+	}
+	if err = c.apply(true); err != nil {
+		log.WithError(err).Warning("failed to apply storage limits, discard connection")
+		return driver.ErrBadConn
+	}
+	return
+}
+
+var (
+	serializableDriver *sqlite3.SQLiteDriver
+	dirtyReadDriver    *sqlite3.SQLiteDriver
 )
 
 func init() {
@@ -63,7 +255,7 @@ func init() {
 		return
 	}
 
-	sql.Register(dirtyReadDriver, &sqlite3.SQLiteDriver{
+	dirtyReadDriver = &sqlite3.SQLiteDriver{
 		ConnectHook: func(c *sqlite3.SQLiteConn) (err error) {
 			if _, err = c.Exec("PRAGMA read_uncommitted=1", nil); err != nil {
 				return
@@ -73,20 +265,21 @@ func init() {
 			}
 			return
 		},
-	})
-	sql.Register(serializableDriver, &sqlite3.SQLiteDriver{
+	}
+	serializableDriver = &sqlite3.SQLiteDriver{
 		ConnectHook: func(c *sqlite3.SQLiteConn) (err error) {
 			if err = regCustomFunc(c); err != nil {
 				return
 			}
 			return
 		},
-	})
+	}
 }
 
 // SQLite3 is the sqlite3 implementation of the xenomint/interfaces.Storage interface.
 type SQLite3 struct {
 	filename    string
+	limits      *sharedLimits
 	dirtyReader *sql.DB
 	reader      *sql.DB
 	writer      *sql.DB
@@ -95,7 +288,7 @@ type SQLite3 struct {
 // NewSqlite returns a new SQLite3 instance attached to filename.
 func NewSqlite(filename string) (s *SQLite3, err error) {
 	var (
-		instance  = &SQLite3{filename: filename}
+		instance  = &SQLite3{filename: filename, limits: &sharedLimits{}}
 		shmRODSN  string
 		privRODSN string
 		shmRWDSN  string
@@ -105,6 +298,9 @@ func NewSqlite(filename string) (s *SQLite3, err error) {
 	if dsn, err = storage.NewDSN(filename); err != nil {
 		return
 	}
+	if instance.limits.limits, err = parseLimits(filename); err != nil {
+		return
+	}
 
 	dsnRO := dsn.Clone()
 	dsnRO.AddParam("_journal_mode", "WAL")
@@ -122,19 +318,23 @@ func NewSqlite(filename string) (s *SQLite3, err error) {
 	dsnSHMRW.AddParam("cache", "shared")
 	shmRWDSN = dsnSHMRW.Format()
 
-	if instance.dirtyReader, err = sql.Open(dirtyReadDriver, shmRODSN); err != nil {
-		return
-	}
-	if instance.reader, err = sql.Open(serializableDriver, privRODSN); err != nil {
-		return
-	}
-	if instance.writer, err = sql.Open(serializableDriver, shmRWDSN); err != nil {
-		return
-	}
+	instance.dirtyReader = sql.OpenDB(instance.connector(dirtyReadDriver, shmRODSN))
+	instance.reader = sql.OpenDB(instance.connector(serializableDriver, privRODSN))
+	instance.writer = sql.OpenDB(instance.connector(serializableDriver, shmRWDSN))
 	s = instance
 	return
 }
 
+func (s *SQLite3) connector(d *sqlite3.SQLiteDriver, name string) driver.Connector {
+	return &limitedConnector{driver: d, name: name, limits: s.limits}
+}
+
+// SetLimits updates the resource limits of the storage, which are applied to the pooled
+// connections on their next use.
+func (s *SQLite3) SetLimits(l Limits) {
+	s.limits.store(l)
+}
+
 // DirtyReader implements DirtyReader method of the xenomint/interfaces.Storage interface.
 func (s *SQLite3) DirtyReader() *sql.DB {
 	return s.dirtyReader
@@ -17,6 +17,7 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math/rand"
@@ -258,6 +259,140 @@ func TestStorage(t *testing.T) {
 	})
 }
 
+func TestStorageLimits(t *testing.T) {
+	Convey("Given a sqlite storage with resource limits", t, func() {
+		var (
+			fl  = path.Join(testingDataDir, t.Name())
+			st  xi.Storage
+			err error
+		)
+		st, err = NewSqlite(fmt.Sprintf("file:%s?%s=%d&%s=%d",
+			fl, ParamMaxMemory, 1<<20, ParamMaxTempStorage, 4*tempPageSize))
+		So(err, ShouldBeNil)
+		defer func() {
+			So(st.Close(), ShouldBeNil)
+			_ = os.Remove(fl)
+			_ = os.Remove(fmt.Sprint(fl, "-shm"))
+			_ = os.Remove(fmt.Sprint(fl, "-wal"))
+		}()
+
+		var cacheSize int
+		err = st.Writer().QueryRow(`PRAGMA cache_size`).Scan(&cacheSize)
+		So(err, ShouldBeNil)
+		So(cacheSize, ShouldEqual, -1024)
+
+		// temporary storage is limited to 4 pages
+		conn, err := st.Writer().Conn(context.Background())
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		_, err = conn.ExecContext(context.Background(), `CREATE TEMP TABLE "t1" ("v" BLOB)`)
+		So(err, ShouldBeNil)
+		for i := 0; i < 16 && err == nil; i++ {
+			_, err = conn.ExecContext(context.Background(),
+				`INSERT INTO "t1" VALUES (?)`, strings.Repeat("v", tempPageSize/2))
+		}
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "full")
+
+		// invalid limit parameter
+		_, err = parseLimits(fmt.Sprintf("file:%s?%s=invalid", fl, ParamMaxMemory))
+		So(err, ShouldNotBeNil)
+		_, err = NewSqlite(fmt.Sprintf("file:%s?%s=invalid", fl, ParamMaxTempStorage))
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Given a sqlite storage with updated resource limits", t, func() {
+		var (
+			fl  = path.Join(testingDataDir, t.Name())
+			st  *SQLite3
+			err error
+		)
+		st, err = NewSqlite(fmt.Sprintf("file:%s", fl))
+		So(err, ShouldBeNil)
+		defer func() {
+			So(st.Close(), ShouldBeNil)
+			_ = os.Remove(fl)
+			_ = os.Remove(fmt.Sprint(fl, "-shm"))
+			_ = os.Remove(fmt.Sprint(fl, "-wal"))
+		}()
+		st.Writer().SetMaxOpenConns(1)
+
+		var cacheSize int
+		err = st.Writer().QueryRow(`PRAGMA cache_size`).Scan(&cacheSize)
+		So(err, ShouldBeNil)
+		So(cacheSize, ShouldEqual, defaultCacheSize)
+		_, err = st.Writer().Exec(`CREATE TEMP TABLE "t1" ("v" BLOB)`)
+		So(err, ShouldBeNil)
+
+		// the pooled connection should catch up with the limits on reuse
+		st.SetLimits(Limits{MaxMemory: 1 << 20, MaxTempStorage: 4 * tempPageSize})
+		err = st.Writer().QueryRow(`PRAGMA cache_size`).Scan(&cacheSize)
+		So(err, ShouldBeNil)
+		So(cacheSize, ShouldEqual, -1024)
+		for i := 0; i < 16 && err == nil; i++ {
+			_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?)`, strings.Repeat("v", tempPageSize/2))
+		}
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "full")
+
+		// the limits should be restored to defaults once removed
+		st.SetLimits(Limits{})
+		err = st.Writer().QueryRow(`PRAGMA cache_size`).Scan(&cacheSize)
+		So(err, ShouldBeNil)
+		So(cacheSize, ShouldEqual, defaultCacheSize)
+		_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?)`, strings.Repeat("v", 4*tempPageSize))
+		So(err, ShouldBeNil)
+	})
+	Convey("Given a sqlite storage with database size limit", t, func() {
+		var (
+			fl  = path.Join(testingDataDir, t.Name())
+			st  *SQLite3
+			err error
+		)
+		st, err = NewSqlite(fmt.Sprintf("file:%s?%s=%d", fl, ParamMaxDatabaseSize, 16*tempPageSize))
+		So(err, ShouldBeNil)
+		defer func() {
+			So(st.Close(), ShouldBeNil)
+			_ = os.Remove(fl)
+			_ = os.Remove(fmt.Sprint(fl, "-shm"))
+			_ = os.Remove(fmt.Sprint(fl, "-wal"))
+		}()
+
+		var pageCount int
+		err = st.Writer().QueryRow(`PRAGMA max_page_count`).Scan(&pageCount)
+		So(err, ShouldBeNil)
+		So(pageCount, ShouldEqual, 16)
+
+		// writes beyond the database size limit should fail
+		_, err = st.Writer().Exec(`CREATE TABLE "t1" ("v" BLOB)`)
+		So(err, ShouldBeNil)
+		var insert = func() (err error) {
+			_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?)`, strings.Repeat("v", tempPageSize))
+			return
+		}
+		for i := 0; i < 32 && err == nil; i++ {
+			err = insert()
+		}
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "full")
+		var count int
+		err = st.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+		So(err, ShouldBeNil)
+		So(count, ShouldBeLessThan, 16)
+
+		// the database should grow again once the limit is raised
+		st.SetLimits(Limits{MaxDatabaseSize: 64 * tempPageSize})
+		So(insert(), ShouldBeNil)
+		st.SetLimits(Limits{})
+		err = st.Writer().QueryRow(`PRAGMA max_page_count`).Scan(&pageCount)
+		So(err, ShouldBeNil)
+		So(pageCount, ShouldEqual, maxPageCount)
+
+		// invalid limit parameter
+		_, err = NewSqlite(fmt.Sprintf("file:%s?%s=invalid", fl, ParamMaxDatabaseSize))
+		So(err, ShouldNotBeNil)
+	})
+}
+
 const (
 	benchmarkQueriesPerTx      = 100
 	benchmarkVNum              = 3
@@ -29,8 +29,28 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
 )
 
+type maxResultSizeKey struct{}
+
+// WithMaxResultSize returns a copy of ctx in which the result size of each read query is
+// limited to size bytes.
+func WithMaxResultSize(ctx context.Context, size uint64) context.Context {
+	return context.WithValue(ctx, maxResultSizeKey{}, size)
+}
+
+func valueSize(v interface{}) uint64 {
+	switch x := v.(type) {
+	case []byte:
+		return uint64(len(x))
+	case string:
+		return uint64(len(x))
+	default:
+		return 8
+	}
+}
+
 type sqlQuerier interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
@@ -61,6 +81,7 @@ type State struct {
 	closed bool
 	nodeID proto.NodeID
 
+	stmts           *stmtCache
 	handler         sqlHandler
 	maxTx           uint64
 	lastCommitPoint uint64
@@ -75,6 +96,7 @@ func NewState(level sql.IsolationLevel, nodeID proto.NodeID, strg xi.Storage) (s
 		nodeID: nodeID,
 		strg:   strg,
 		pool:   newPool(),
+		stmts:  newStmtCache(),
 		maxTx:  100,
 	}
 	s.openHandler()
@@ -116,6 +138,14 @@ func (s *State) getLastCommitPoint() uint64 {
 	return atomic.LoadUint64(&s.lastCommitPoint)
 }
 
+// SetStorageLimits updates the resource limits of the underlying storage, it takes no effect if
+// the storage does not support limits.
+func (s *State) SetStorageLimits(l xs.Limits) {
+	if strg, ok := s.strg.(interface{ SetLimits(xs.Limits) }); ok {
+		strg.SetLimits(l)
+	}
+}
+
 // Close commits any ongoing transaction if needed and closes the underlying storage.
 func (s *State) Close(commit bool) (err error) {
 	s.Lock()
@@ -145,8 +175,8 @@ func buildTypeNamesFromSQLColumnTypes(types []*sql.ColumnType) (names []string)
 	return
 }
 
-func readSingle(
-	ctx context.Context, qer sqlQuerier, q *types.Query,
+func (s *State) readSingle(
+	ctx context.Context, qer sqlQuerier, env *queryEnv, q *types.Query,
 ) (
 	names []string, types []string, data [][]interface{}, err error,
 ) {
@@ -155,9 +185,11 @@ func readSingle(
 		cols    []*sql.ColumnType
 		pattern string
 		args    []interface{}
+		size    uint64
 	)
 
-	if _, pattern, args, err = convertQueryAndBuildArgs(q.Pattern, q.Args); err != nil {
+	maxSize, _ := ctx.Value(maxResultSizeKey{}).(uint64)
+	if _, pattern, args, err = s.convertQueryAndBuildArgs(q.Pattern, q.Args, env); err != nil {
 		return
 	}
 	if rows, err = qer.QueryContext(ctx, pattern, args...); err != nil {
@@ -187,6 +219,15 @@ func readSingle(
 		if err = rows.Scan(dest...); err != nil {
 			return
 		}
+		if maxSize > 0 {
+			for _, v := range row {
+				size += valueSize(v)
+			}
+			if size > maxSize {
+				err = errors.Wrapf(ErrResultSizeExceeded, "result size exceeds %d bytes", maxSize)
+				return
+			}
+		}
 		data = append(data, row)
 	}
 	return
@@ -213,8 +254,9 @@ func (s *State) readWithContext(
 		data           [][]interface{}
 	)
 	// TODO(leventeliu): no need to run every read query here.
+	var env = newQueryEnv(req)
 	for i, v := range req.Payload.Queries {
-		if cnames, ctypes, data, ierr = readSingle(ctx, s.reader(), &v); ierr != nil {
+		if cnames, ctypes, data, ierr = s.readSingle(ctx, s.reader(), env, &v); ierr != nil {
 			err = errors.Wrapf(ierr, "query at #%d failed", i)
 			// Add to failed pool list
 			s.pool.setFailed(req)
@@ -283,8 +325,9 @@ func (s *State) readTx(
 		}
 	}()
 
+	var env = newQueryEnv(req)
 	for i, v := range req.Payload.Queries {
-		if cnames, ctypes, data, ierr = readSingle(ctx, querier, &v); ierr != nil {
+		if cnames, ctypes, data, ierr = s.readSingle(ctx, querier, env, &v); ierr != nil {
 			err = errors.Wrapf(ierr, "query at #%d failed", i)
 			// Add to failed pool list
 			s.Lock()
@@ -319,7 +362,7 @@ func (s *State) readTx(
 }
 
 func (s *State) writeSingle(
-	ctx context.Context, q *types.Query) (res sql.Result, err error,
+	ctx context.Context, env *queryEnv, q *types.Query) (res sql.Result, err error,
 ) {
 	var (
 		containsDDL bool
@@ -341,7 +384,7 @@ func (s *State) writeSingle(
 	//	}
 	//	log.WithFields(fields).Debug("writeSingle duration stat (us)")
 	//}()
-	if containsDDL, pattern, args, err = convertQueryAndBuildArgs(q.Pattern, q.Args); err != nil {
+	if containsDDL, pattern, args, err = s.convertQueryAndBuildArgs(q.Pattern, q.Args, env); err != nil {
 		return
 	}
 	//parsed = time.Since(start)
@@ -420,9 +463,10 @@ func (s *State) write(
 				_, _ = s.handler.Exec(`ROLLBACK`)
 			}()
 		}
+		var env = newQueryEnv(req)
 		for i, v := range req.Payload.Queries {
 			var res sql.Result
-			if res, ierr = s.writeSingle(ctx, &v); ierr != nil {
+			if res, ierr = s.writeSingle(ctx, env, &v); ierr != nil {
 				err = errors.Wrapf(ierr, "execute at #%d failed", i)
 				// TODO(leventeliu): request may actually be partial succeed without
 				// rolling back.
@@ -477,6 +521,51 @@ func (s *State) write(
 	return
 }
 
+// DryRun executes the write queries of req and rolls back the changes, to check the queries are
+// executable on the current state.
+func (s *State) DryRun(req *types.Request) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var handler sqlExecuter
+	if s.level == sql.LevelReadUncommitted {
+		if _, err = s.handler.Exec(`SAVEPOINT "dry_run"`); err != nil {
+			err = errors.Wrap(err, "failed to create dry run savepoint")
+			return
+		}
+		defer func() {
+			_, _ = s.handler.Exec(`ROLLBACK TO "dry_run"`)
+			_, _ = s.handler.Exec(`RELEASE SAVEPOINT "dry_run"`)
+		}()
+		handler = s.handler
+	} else {
+		var tx *sql.Tx
+		if tx, err = s.strg.Writer().Begin(); err != nil {
+			err = errors.Wrap(err, "failed to begin dry run transaction")
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+		handler = tx
+	}
+
+	var env = newQueryEnv(req)
+	for i, v := range req.Payload.Queries {
+		var (
+			pattern string
+			args    []interface{}
+		)
+		if _, pattern, args, err = s.convertQueryAndBuildArgs(v.Pattern, v.Args, env); err != nil {
+			err = errors.Wrapf(err, "convert query at #%d failed", i)
+			return
+		}
+		if _, err = handler.Exec(pattern, args...); err != nil {
+			err = errors.Wrapf(err, "execute at #%d failed", i)
+			return
+		}
+	}
+	return
+}
+
 func (s *State) replay(ctx context.Context, req *types.Request, resp *types.Response) (err error) {
 	var (
 		ierr    error
@@ -493,8 +582,9 @@ func (s *State) replay(ctx context.Context, req *types.Request, resp *types.Resp
 		)
 		return
 	}
+	var env = newQueryEnv(req)
 	for i, v := range req.Payload.Queries {
-		if _, ierr = s.writeSingle(ctx, &v); ierr != nil {
+		if _, ierr = s.writeSingle(ctx, env, &v); ierr != nil {
 			err = errors.Wrapf(ierr, "execute at #%d failed", i)
 			return
 		}
@@ -539,12 +629,13 @@ func (s *State) ReplayBlockWithContext(ctx context.Context, block *types.Block)
 			continue
 		}
 		// Replay query
+		var env = newQueryEnv(q.Request)
 		for j, v := range q.Request.Payload.Queries {
 			if q.Request.Header.QueryType != types.WriteQuery {
 				err = errors.Wrapf(ErrInvalidRequest, "replay block at %d:%d", i, j)
 				return
 			}
-			if _, ierr = s.writeSingle(ctx, &v); ierr != nil {
+			if _, ierr = s.writeSingle(ctx, env, &v); ierr != nil {
 				err = errors.Wrapf(ierr, "execute at %d:%d failed", i, j)
 				return
 			}
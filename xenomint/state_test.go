@@ -145,6 +145,23 @@ func TestState(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(resp.Header.RowCount, ShouldEqual, 0)
 			})
+			Convey("The state should not change after dry run", func() {
+				err = st1.DryRun(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[1]...),
+				}))
+				So(err, ShouldBeNil)
+				err = st1.DryRun(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
+				}))
+				So(err, ShouldNotBeNil)
+				_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT v FROM t1`),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Header.RowCount, ShouldEqual, 0)
+			})
 			Convey("The state should report invalid request with unknown query type", func() {
 				req = buildRequest(types.QueryType(0xff), []types.Query{
 					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
@@ -208,6 +225,25 @@ func TestState(t *testing.T) {
 				So(resp, ShouldBeNil)
 				st1.Stat(id1)
 			})
+			Convey("The state should limit result size of read queries", func() {
+				_, resp, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?), (?, ?), (?, ?), (?, ?)`,
+						concat(values)...),
+				}), true)
+				So(err, ShouldBeNil)
+				_, resp, err = st1.QueryWithContext(
+					WithMaxResultSize(context.Background(), 25), buildRequest(types.ReadQuery, []types.Query{
+						buildQuery(`SELECT * FROM t1`),
+					}), true)
+				So(errors.Cause(err), ShouldEqual, ErrResultSizeExceeded)
+				So(resp, ShouldBeNil)
+				_, resp, err = st1.QueryWithContext(
+					WithMaxResultSize(context.Background(), 40), buildRequest(types.ReadQuery, []types.Query{
+						buildQuery(`SELECT * FROM t1`),
+					}), true)
+				So(err, ShouldBeNil)
+				So(resp.Header.RowCount, ShouldEqual, 4)
+			})
 			Convey("The state should work properly with reading/writing queries", func() {
 				_, resp, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
 					buildQuery(`INSERT INTO "t1" ("k", "v") VALUES (?, ?)`, values[0]...),
@@ -367,6 +403,48 @@ INSERT INTO t1 (k, v) VALUES (?, ?)`, concat(values[2:4])...),
 					So(resp1.Payload, ShouldResemble, resp2.Payload)
 				}
 			})
+			Convey("The triggers and rewritten functions should be reproducible in another instance", func() {
+				var (
+					qt   *QueryTracker
+					reqs = []*types.Request{
+						buildRequest(types.WriteQuery, []types.Query{
+							buildQuery(`CREATE TABLE log (k INT, v TEXT, r INT, t TEXT)`),
+						}),
+						buildRequest(types.WriteQuery, []types.Query{
+							buildQuery(`CREATE TRIGGER t1_log AFTER INSERT ON t1 FOR EACH ROW
+BEGIN
+	INSERT INTO log (k, v) VALUES (new.k, upper(new.v));
+END`),
+						}),
+						buildRequest(types.WriteQuery, []types.Query{
+							buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
+							buildQuery(`UPDATE log SET r = random(), t = datetime('now') WHERE k = ?`, values[0][0]),
+						}),
+					}
+				)
+				for i := range reqs {
+					qt, resp, err = st1.Query(reqs[i], true)
+					So(err, ShouldBeNil)
+					So(qt, ShouldNotBeNil)
+					So(resp, ShouldNotBeNil)
+					qt.UpdateResp(resp)
+					err = st2.Replay(reqs[i], resp)
+					So(err, ShouldBeNil)
+				}
+				var resp1, resp2 *types.Response
+				req = buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT k, v, r, t FROM log`),
+				})
+				_, resp1, err = st1.Query(req, true)
+				So(err, ShouldBeNil)
+				_, resp2, err = st2.Query(req, true)
+				So(err, ShouldBeNil)
+				So(len(resp1.Payload.Rows), ShouldEqual, 1)
+				So(resp1.Payload.Rows[0].Values[1], ShouldEqual, "V1")
+				So(resp1.Payload.Rows[0].Values[3], ShouldEqual,
+					reqs[2].Header.Timestamp.UTC().Format("2006-01-02 15:04:05"))
+				So(resp1.Payload, ShouldResemble, resp2.Payload)
+			})
 			Convey("When queries are committed to blocks on state instance #1", func() {
 				var (
 					qt   *QueryTracker
@@ -619,6 +697,60 @@ func TestConvertQueryAndBuildArgs(t *testing.T) {
 			"CREATE 1", []types.NamedArg{})
 		So(err, ShouldNotBeNil)
 
+		// schema change detection of a whole request
+		containsDDL, err = ContainsDDL([]types.Query{
+			buildQuery(`INSERT INTO t1 (k, v) VALUES (1, 1)`),
+			buildQuery(`ALTER TABLE t1 ADD COLUMN v2 TEXT`),
+		})
+		So(err, ShouldBeNil)
+		So(containsDDL, ShouldBeTrue)
+		containsDDL, err = ContainsDDL([]types.Query{
+			buildQuery(`INSERT INTO t1 (k, v) VALUES (1, 1)`),
+		})
+		So(err, ShouldBeNil)
+		So(containsDDL, ShouldBeFalse)
+
+		// statements differing only in literals are normalized to the same pattern
+		So(NormalizeQuery(`SELECT * FROM t1 WHERE k = 1`), ShouldEqual,
+			NormalizeQuery("select *  from t1\n where k = 2"))
+		So(NormalizeQuery(`SELECT * FROM t1 WHERE k = 1`), ShouldNotEqual,
+			NormalizeQuery(`SELECT * FROM t2 WHERE k = 1`))
+		So(NormalizeQuery("not  a\nvalid query"), ShouldEqual, "not a valid query")
+
+		// fulltext tables with deterministic tokenizers
+		ddlQuery = `CREATE VIRTUAL TABLE docs USING fts5(title, body, tokenize = 'porter ascii')`
+		containsDDL, sanitizedQuery, _, err = convertQueryAndBuildArgs(ddlQuery, nil)
+		So(err, ShouldBeNil)
+		So(containsDDL, ShouldBeTrue)
+		So(sanitizedQuery, ShouldEqual, ddlQuery)
+		ddlQuery = `CREATE VIRTUAL TABLE docs USING fts4(title, "tokenize, body", tokenize=unicode61)`
+		_, sanitizedQuery, _, err = convertQueryAndBuildArgs(ddlQuery, nil)
+		So(err, ShouldBeNil)
+		So(sanitizedQuery, ShouldEqual, ddlQuery)
+		_, sanitizedQuery, _, err = convertQueryAndBuildArgs(
+			`CREATE VIRTUAL TABLE docs USING fts5(title, body)`, nil)
+		So(err, ShouldBeNil)
+		So(sanitizedQuery, ShouldEqual,
+			`CREATE VIRTUAL TABLE docs USING fts5(title, body, tokenize = 'unicode61 remove_diacritics 1')`)
+		_, sanitizedQuery, _, err = convertQueryAndBuildArgs(
+			`CREATE VIRTUAL TABLE geo USING rtree(id, minX, maxX)`, nil)
+		So(err, ShouldBeNil)
+		So(sanitizedQuery, ShouldEqual, `CREATE VIRTUAL TABLE geo USING rtree(id, minX, maxX)`)
+		for _, q := range []string{
+			`CREATE VIRTUAL TABLE docs USING fts5(body, tokenize = 'icu zh_CN')`,
+			`CREATE VIRTUAL TABLE docs USING fts5(body, tokenize = 'porter icu')`,
+			`CREATE VIRTUAL TABLE docs USING fts4(body, tokenize=icu en_US)`,
+		} {
+			_, _, _, err = convertQueryAndBuildArgs(q, nil)
+			So(errors.Cause(err), ShouldEqual, ErrUnsupportedTokenizer)
+		}
+
+		// fulltext queries
+		_, sanitizedQuery, _, err = convertQueryAndBuildArgs(
+			`SELECT highlight(docs, 1, '[', ']') FROM docs WHERE docs MATCH ? ORDER BY rank`, nil)
+		So(err, ShouldBeNil)
+		So(sanitizedQuery, ShouldContainSubstring, "MATCH")
+
 		// contains stateful query parts, create table with default current_timestamp
 		ddlQuery = "CREATE TABLE test (test datetime default current_timestamp)"
 		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
@@ -653,6 +785,83 @@ func TestConvertQueryAndBuildArgs(t *testing.T) {
 			"SELECT 1; SELECT func(); SELECT * FROM a", []types.NamedArg{})
 		So(err, ShouldBeNil)
 
+		// functions not in registry under strict function check
+		SetStrictFunctionCheck(true)
+		_, _, _, err = convertQueryAndBuildArgs("SELECT func()", nil)
+		So(errors.Cause(err), ShouldEqual, ErrUnregisteredFunction)
+		_, _, _, err = convertQueryAndBuildArgs(
+			`CREATE TRIGGER t AFTER INSERT ON t1 BEGIN INSERT INTO log VALUES (func2()); END`, nil)
+		So(errors.Cause(err), ShouldEqual, ErrUnregisteredFunction)
+		_, _, _, err = convertQueryAndBuildArgs(
+			"SELECT iif(a, substring(b, 1), unhex('00')), octet_length(b) FROM a", nil)
+		So(err, ShouldBeNil)
+		RegisterFunction("FUNC")
+		_, _, _, err = convertQueryAndBuildArgs("SELECT func()", nil)
+		So(err, ShouldBeNil)
+		UnregisterFunction("FUNC")
+		SetStrictFunctionCheck(false)
+
+		// non-deterministic functions rewritten with request values
+		var (
+			req = buildRequest(types.WriteQuery, nil)
+			env = newQueryEnv(req)
+		)
+		_, sanitizedQuery, _, err = convertQueryAndBuildArgsWithEnv(
+			"SELECT random(), randomblob(4), datetime('now'), strftime('%s', 'NOW', '+1 day')", nil, env)
+		So(err, ShouldBeNil)
+		So(env.rewritten, ShouldBeTrue)
+		So(sanitizedQuery, ShouldNotContainSubstring, "random")
+		So(sanitizedQuery, ShouldNotContainSubstring, "'now'")
+		So(sanitizedQuery, ShouldContainSubstring, req.Header.Timestamp.UTC().Format(timeValueFormat))
+		var rewrittenQuery string
+		_, rewrittenQuery, _, err = convertQueryAndBuildArgsWithEnv(
+			"SELECT random(), randomblob(4), datetime('now'), strftime('%s', 'NOW', '+1 day')",
+			nil, newQueryEnv(req))
+		So(err, ShouldBeNil)
+		So(rewrittenQuery, ShouldEqual, sanitizedQuery)
+		for _, q := range []string{
+			"SELECT randomblob(length(x)) FROM a",
+			"SELECT datetime('now', 'localtime')",
+		} {
+			_, _, _, err = convertQueryAndBuildArgsWithEnv(q, nil, newQueryEnv(req))
+			So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
+		}
+		env = newQueryEnv(req)
+		_, sanitizedQuery, _, err = convertQueryAndBuildArgsWithEnv("SELECT datetime('2019-01-01')", nil, env)
+		So(err, ShouldBeNil)
+		So(env.rewritten, ShouldBeFalse)
+		So(sanitizedQuery, ShouldEqual, "SELECT datetime('2019-01-01')")
+
+		// deterministic triggers
+		ddlQuery = `CREATE TRIGGER IF NOT EXISTS log_insert AFTER INSERT ON t1 FOR EACH ROW
+WHEN new.k > 0
+BEGIN
+	INSERT INTO log (k, v) VALUES (new.k, upper(new.v));
+	UPDATE stat SET cnt = cnt + 1;
+END`
+		containsDDL, sanitizedQuery, _, err = convertQueryAndBuildArgs(ddlQuery, nil)
+		So(err, ShouldBeNil)
+		So(containsDDL, ShouldBeTrue)
+		So(sanitizedQuery, ShouldEqual, ddlQuery)
+		_, _, _, err = convertQueryAndBuildArgs(
+			`CREATE TRIGGER check_v BEFORE UPDATE OF v ON t1 BEGIN SELECT raise(ABORT, 'readonly'); END;`, nil)
+		So(err, ShouldBeNil)
+		containsDDL, _, _, err = convertQueryAndBuildArgs(`DROP TRIGGER IF EXISTS log_insert`, nil)
+		So(err, ShouldBeNil)
+		So(containsDDL, ShouldBeTrue)
+		for q, e := range map[string]error{
+			`CREATE TRIGGER t AFTER INSERT ON t1 BEGIN INSERT INTO log VALUES (random()); END`:        ErrStatefulQueryParts,
+			`CREATE TEMP TRIGGER t AFTER INSERT ON t1 BEGIN DELETE FROM log; END`:                     ErrInvalidTrigger,
+			`CREATE TRIGGER t AFTER INSERT ON t1 BEGIN CREATE TABLE x (k int); END`:                   ErrInvalidTrigger,
+			`CREATE TRIGGER t AFTER TRUNCATE ON t1 BEGIN DELETE FROM log; END`:                        ErrInvalidTrigger,
+			`CREATE TRIGGER t AFTER INSERT ON sqlite_master BEGIN DELETE FROM log; END`:               ErrInvalidTableName,
+			`CREATE TRIGGER t AFTER INSERT ON t1 WHEN datetime('now') > 0 BEGIN DELETE FROM log; END`: ErrStatefulQueryParts,
+			`CREATE TRIGGER t AFTER INSERT ON t1`:                                                     ErrInvalidTrigger,
+		} {
+			_, _, _, err = convertQueryAndBuildArgs(q, nil)
+			So(errors.Cause(err), ShouldEqual, e)
+		}
+
 		// counterpart to prove successful parsing of normal query
 		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
 			"SELECT count(*) FROM enrondata1 WHERE content MATCH 'linux'", []types.NamedArg{})
@@ -744,6 +953,17 @@ func TestSerializableState(t *testing.T) {
 			_, resp, err = state.Query(req, true)
 			So(err, ShouldBeNil)
 			So(resp, ShouldNotBeNil)
+			Convey("The state should not change after dry run", func() {
+				err = state.DryRun(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, 1, "v1"),
+				}))
+				So(err, ShouldBeNil)
+				_, resp, err = state.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT v FROM t1`),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Header.RowCount, ShouldEqual, 0)
+			})
 			Convey("The state should keep consistent with committed transaction", func(c C) {
 				var (
 					count         = 1000
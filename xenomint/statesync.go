@@ -0,0 +1,786 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+)
+
+const (
+	// StateSyncBucketBits defines the rowid bits of a state sync bucket, rows are compared and
+	// transferred by buckets of 1 << StateSyncBucketBits rowids.
+	StateSyncBucketBits = 10
+
+	sqliteSequenceTable = "sqlite_sequence"
+)
+
+const (
+	valueNull byte = iota
+	valueInteger
+	valueReal
+	valueText
+	valueBlob
+)
+
+var withoutRowidPattern = regexp.MustCompile(`(?i)\)\s*WITHOUT\s+ROWID\s*$`)
+
+// SchemaObject defines a schema object in sqlite_master.
+type SchemaObject struct {
+	Type    string
+	Name    string
+	TblName string
+	SQL     string
+}
+
+// BucketDigest defines the digest of the rows in a bucket.
+type BucketDigest struct {
+	Bucket int64
+	Hash   hash.Hash
+}
+
+// TableDigest defines the digest of a table.
+type TableDigest struct {
+	Name    string
+	Buckets []BucketDigest
+}
+
+// StateDigest defines the digest of a state, which is used to build the state delta.
+type StateDigest struct {
+	Schema []SchemaObject
+	Tables []TableDigest
+}
+
+// BucketDelta defines the encoded rows of a bucket to replace.
+type BucketDelta struct {
+	Bucket int64
+	Rows   []byte
+}
+
+// TableDelta defines the buckets of a table to replace, the whole table is replaced if Full is
+// set.
+type TableDelta struct {
+	Name    string
+	Full    bool
+	Buckets []BucketDelta
+}
+
+// StateDelta defines the changes to bring a state with the digest up to date.
+type StateDelta struct {
+	Seq           uint64
+	SchemaChanged bool
+	Schema        []SchemaObject
+	Tables        []TableDelta
+}
+
+type tableInfo struct {
+	name    string
+	sql     string
+	columns []string
+	orderBy []string
+	rowid   bool
+}
+
+// Digest returns the digest of the current state.
+func (s *State) Digest() (digest *StateDigest, err error) {
+	s.Lock()
+	defer s.Unlock()
+	return buildDigest(s.handler)
+}
+
+// Delta returns the changes of the current state against the remote state digest.
+func (s *State) Delta(remote *StateDigest) (delta *StateDelta, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var (
+		local   *StateDigest
+		tables  map[string]*tableInfo
+		changed = make(map[string]bool)
+		virtual []string
+	)
+	if local, err = buildDigest(s.handler); err != nil {
+		return
+	}
+	if tables, err = loadTables(s.handler, local.Schema); err != nil {
+		return
+	}
+
+	delta = &StateDelta{Seq: s.getSeq()}
+
+	// compare schema
+	remoteSQL := make(map[string]string, len(remote.Schema))
+	for _, o := range remote.Schema {
+		remoteSQL[o.Name] = o.SQL
+	}
+	for _, o := range local.Schema {
+		if sql, ok := remoteSQL[o.Name]; !ok || sql != o.SQL {
+			changed[o.Name] = true
+			if isVirtualTable(o) {
+				virtual = append(virtual, o.Name)
+			}
+		}
+	}
+	if len(changed) > 0 || len(local.Schema) != len(remote.Schema) {
+		delta.SchemaChanged = true
+		// keep the creation order of schema objects
+		if delta.Schema, err = loadSchema(s.handler); err != nil {
+			return
+		}
+	}
+
+	remoteTables := make(map[string]*TableDigest, len(remote.Tables))
+	for i := range remote.Tables {
+		remoteTables[remote.Tables[i].Name] = &remote.Tables[i]
+	}
+	for _, t := range local.Tables {
+		var (
+			ti      = tables[t.Name]
+			rt, ok  = remoteTables[t.Name]
+			td      = TableDelta{Name: t.Name}
+			buckets = make(map[int64]bool)
+		)
+		// replace the whole table on schema change, including the shadow tables of the
+		// changed virtual tables
+		td.Full = !ok || changed[t.Name]
+		for _, v := range virtual {
+			if strings.HasPrefix(t.Name, v+"_") {
+				td.Full = true
+			}
+		}
+
+		if td.Full {
+			for _, b := range t.Buckets {
+				buckets[b.Bucket] = true
+			}
+		} else {
+			remoteHashes := make(map[int64]hash.Hash, len(rt.Buckets))
+			for _, b := range rt.Buckets {
+				remoteHashes[b.Bucket] = b.Hash
+			}
+			for _, b := range t.Buckets {
+				if h, ok := remoteHashes[b.Bucket]; !ok || !h.IsEqual(&b.Hash) {
+					buckets[b.Bucket] = true
+				}
+				delete(remoteHashes, b.Bucket)
+			}
+			for b := range remoteHashes {
+				// not exists locally, deletes remote rows only
+				td.Buckets = append(td.Buckets, BucketDelta{Bucket: b})
+			}
+			if !ti.rowid && len(buckets)+len(td.Buckets) > 0 {
+				// rows without rowid are always replaced as a whole
+				td.Full, td.Buckets = true, nil
+			}
+		}
+
+		if len(buckets) > 0 {
+			var rows map[int64][]byte
+			if rows, err = readBuckets(s.handler, ti, buckets); err != nil {
+				return
+			}
+			for b := range buckets {
+				td.Buckets = append(td.Buckets, BucketDelta{Bucket: b, Rows: rows[b]})
+			}
+		}
+		if !td.Full && len(td.Buckets) == 0 {
+			continue
+		}
+		sort.Slice(td.Buckets, func(i, j int) bool { return td.Buckets[i].Bucket < td.Buckets[j].Bucket })
+		delta.Tables = append(delta.Tables, td)
+	}
+
+	return
+}
+
+// ApplyDelta applies the state delta built against the digest of the current state. The delta
+// is applied in a single transaction and the pooled queries are discarded.
+func (s *State) ApplyDelta(delta *StateDelta) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var tx *sql.Tx
+
+	// commit the ongoing transaction before applying delta
+	s.commitHandler()
+	defer s.openHandler()
+
+	if tx, err = s.strg.Writer().Begin(); err != nil {
+		err = errors.Wrap(err, "begin transaction failed")
+		return
+	}
+	if err = applyDelta(tx, delta); err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		err = errors.Wrap(err, "commit transaction failed")
+		return
+	}
+
+	s.SetSeq(delta.Seq)
+	atomic.StoreUint64(&s.lastCommitPoint, delta.Seq)
+	s.pool = newPool()
+	return
+}
+
+func applyDelta(h sqlHandler, delta *StateDelta) (err error) {
+	var (
+		local  []SchemaObject
+		target []SchemaObject
+		tables map[string]*tableInfo
+	)
+
+	if _, err = h.Exec(`PRAGMA defer_foreign_keys = ON`); err != nil {
+		err = errors.Wrap(err, "defer foreign keys failed")
+		return
+	}
+	if local, err = loadSchema(h); err != nil {
+		return
+	}
+	target = local
+	if delta.SchemaChanged {
+		target = delta.Schema
+	}
+
+	// drop triggers to apply rows as is
+	for _, o := range local {
+		if o.Type == "trigger" {
+			if err = dropObject(h, o); err != nil {
+				return
+			}
+		}
+	}
+
+	if delta.SchemaChanged {
+		if err = applySchema(h, local, target); err != nil {
+			return
+		}
+	}
+
+	if tables, err = loadTables(h, target); err != nil {
+		return
+	}
+	for _, td := range delta.Tables {
+		ti, ok := tables[td.Name]
+		if !ok {
+			err = errors.Errorf("table %s of state delta does not exist", td.Name)
+			return
+		}
+		if td.Full {
+			if _, err = h.Exec(fmt.Sprintf(`DELETE FROM %s`, quoteIdent(ti.name))); err != nil {
+				err = errors.Wrapf(err, "clear table %s failed", ti.name)
+				return
+			}
+		}
+		for _, b := range td.Buckets {
+			if err = applyBucket(h, ti, td.Full, &b); err != nil {
+				return
+			}
+		}
+	}
+
+	for _, o := range target {
+		if o.Type == "trigger" {
+			if _, err = h.Exec(o.SQL); err != nil {
+				err = errors.Wrapf(err, "create trigger %s failed", o.Name)
+				return
+			}
+		}
+	}
+	return
+}
+
+func applySchema(h sqlHandler, local, target []SchemaObject) (err error) {
+	var (
+		targetSQL = make(map[string]string, len(target))
+		dropped   []SchemaObject
+		existing  map[string]bool
+	)
+	for _, o := range target {
+		targetSQL[o.Name] = o.SQL
+	}
+	for _, o := range local {
+		if o.Type == "trigger" {
+			continue
+		}
+		if sql, ok := targetSQL[o.Name]; !ok || sql != o.SQL {
+			dropped = append(dropped, o)
+		}
+	}
+	// drop views and virtual tables first, shadow tables are dropped with the virtual tables
+	sort.SliceStable(dropped, func(i, j int) bool {
+		return dropOrder(dropped[i]) < dropOrder(dropped[j])
+	})
+	for _, o := range dropped {
+		if err = dropObject(h, o); err != nil {
+			return
+		}
+	}
+
+	// create virtual tables first, which create the shadow tables implicitly
+	for pass := 0; pass < 4; pass++ {
+		if existing, err = loadSchemaNames(h); err != nil {
+			return
+		}
+		for _, o := range target {
+			if createOrder(o) != pass || existing[o.Name] {
+				continue
+			}
+			if _, err = h.Exec(o.SQL); err != nil {
+				err = errors.Wrapf(err, "create %s %s failed", o.Type, o.Name)
+				return
+			}
+		}
+	}
+	return
+}
+
+func dropOrder(o SchemaObject) int {
+	switch {
+	case o.Type == "view":
+		return 0
+	case isVirtualTable(o):
+		return 1
+	case o.Type == "index":
+		return 2
+	default:
+		return 3
+	}
+}
+
+func createOrder(o SchemaObject) int {
+	switch {
+	case isVirtualTable(o):
+		return 0
+	case o.Type == "table":
+		return 1
+	case o.Type == "index":
+		return 2
+	case o.Type == "view":
+		return 3
+	default:
+		// triggers are created after rows are applied
+		return -1
+	}
+}
+
+func dropObject(h sqlHandler, o SchemaObject) (err error) {
+	if _, err = h.Exec(fmt.Sprintf(
+		`DROP %s IF EXISTS %s`, strings.ToUpper(o.Type), quoteIdent(o.Name)),
+	); err != nil {
+		err = errors.Wrapf(err, "drop %s %s failed", o.Type, o.Name)
+	}
+	return
+}
+
+func applyBucket(h sqlHandler, ti *tableInfo, full bool, b *BucketDelta) (err error) {
+	if !full {
+		lo := b.Bucket << StateSyncBucketBits
+		if _, err = h.Exec(fmt.Sprintf(`DELETE FROM %s WHERE rowid BETWEEN ? AND ?`,
+			quoteIdent(ti.name)), lo, lo+(1<<StateSyncBucketBits)-1); err != nil {
+			err = errors.Wrapf(err, "clear bucket %d of table %s failed", b.Bucket, ti.name)
+			return
+		}
+	}
+
+	var (
+		columns      = make([]string, 0, len(ti.columns)+1)
+		placeholders = make([]string, 0, len(ti.columns)+1)
+		r            = bytes.NewReader(b.Rows)
+	)
+	if ti.rowid {
+		columns = append(columns, "rowid")
+		placeholders = append(placeholders, "?")
+	}
+	for _, c := range ti.columns {
+		columns = append(columns, quoteIdent(c))
+		placeholders = append(placeholders, "?")
+	}
+	insert := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`,
+		quoteIdent(ti.name), strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+	for r.Len() > 0 {
+		var args = make([]interface{}, len(columns))
+		for i := range args {
+			if args[i], err = decodeValue(r); err != nil {
+				err = errors.Wrapf(err, "decode rows of table %s failed", ti.name)
+				return
+			}
+		}
+		if _, err = h.Exec(insert, args...); err != nil {
+			err = errors.Wrapf(err, "insert into table %s failed", ti.name)
+			return
+		}
+	}
+	return
+}
+
+func buildDigest(h sqlHandler) (digest *StateDigest, err error) {
+	var tables map[string]*tableInfo
+
+	digest = &StateDigest{}
+	if digest.Schema, err = loadSchema(h); err != nil {
+		return
+	}
+	if tables, err = loadTables(h, digest.Schema); err != nil {
+		return
+	}
+	sort.Slice(digest.Schema, func(i, j int) bool { return digest.Schema[i].Name < digest.Schema[j].Name })
+
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var (
+			td  = TableDigest{Name: name}
+			buf bytes.Buffer
+			cur int64
+		)
+		flush := func() {
+			if buf.Len() > 0 {
+				td.Buckets = append(td.Buckets, BucketDigest{Bucket: cur, Hash: hash.THashH(buf.Bytes())})
+				buf.Reset()
+			}
+		}
+		if err = scanTable(h, tables[name], "", nil, func(bucket int64, row []byte) {
+			if bucket != cur {
+				flush()
+				cur = bucket
+			}
+			buf.Write(row)
+		}); err != nil {
+			return
+		}
+		flush()
+		digest.Tables = append(digest.Tables, td)
+	}
+	return
+}
+
+func readBuckets(
+	h sqlHandler, ti *tableInfo, buckets map[int64]bool) (rows map[int64][]byte, err error,
+) {
+	rows = make(map[int64][]byte, len(buckets))
+	collect := func(bucket int64, row []byte) {
+		rows[bucket] = append(rows[bucket], row...)
+	}
+	if !ti.rowid {
+		err = scanTable(h, ti, "", nil, collect)
+		return
+	}
+	for b := range buckets {
+		lo := b << StateSyncBucketBits
+		if err = scanTable(h, ti, "WHERE rowid BETWEEN ? AND ?",
+			[]interface{}{lo, lo + (1 << StateSyncBucketBits) - 1}, collect); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// scanTable scans the table rows in order and calls fn with the bucket and encoded row.
+func scanTable(
+	h sqlHandler, ti *tableInfo, where string, args []interface{}, fn func(int64, []byte)) (err error,
+) {
+	var (
+		fields  = make([]string, 0, 2*len(ti.columns)+1)
+		orderBy = ti.orderBy
+		rows    *sql.Rows
+	)
+	if ti.rowid {
+		fields = append(fields, "rowid")
+		orderBy = []string{"rowid"}
+	}
+	for _, c := range ti.columns {
+		// unary plus prevents the driver from converting values by declared column types
+		fields = append(fields, fmt.Sprintf("typeof(%[1]s), +%[1]s", quoteIdent(c)))
+	}
+	if rows, err = h.Query(fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY %s`,
+		strings.Join(fields, ", "), quoteIdent(ti.name), where, strings.Join(orderBy, ", ")),
+		args...); err != nil {
+		err = errors.Wrapf(err, "scan table %s failed", ti.name)
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	var (
+		n      = len(ti.columns) * 2
+		values = make([]interface{}, n)
+		dest   = make([]interface{}, 0, n+1)
+		rowid  int64
+		buf    bytes.Buffer
+	)
+	if ti.rowid {
+		dest = append(dest, &rowid)
+	}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			err = errors.Wrapf(err, "scan table %s failed", ti.name)
+			return
+		}
+		buf.Reset()
+		if ti.rowid {
+			encodeValue(&buf, valueInteger, rowid)
+		}
+		for i := 0; i < n; i += 2 {
+			encodeValue(&buf, valueTag(values[i]), values[i+1])
+		}
+		fn(rowid>>StateSyncBucketBits, buf.Bytes())
+	}
+	if err = rows.Err(); err != nil {
+		err = errors.Wrapf(err, "scan table %s failed", ti.name)
+	}
+	return
+}
+
+func loadSchema(h sqlHandler) (schema []SchemaObject, err error) {
+	var rows *sql.Rows
+	if rows, err = h.Query(`SELECT type, name, tbl_name, sql FROM sqlite_master
+WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite%' ORDER BY rowid`); err != nil {
+		err = errors.Wrap(err, "load schema failed")
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var o SchemaObject
+		if err = rows.Scan(&o.Type, &o.Name, &o.TblName, &o.SQL); err != nil {
+			err = errors.Wrap(err, "load schema failed")
+			return
+		}
+		schema = append(schema, o)
+	}
+	err = rows.Err()
+	return
+}
+
+func loadSchemaNames(h sqlHandler) (names map[string]bool, err error) {
+	var schema []SchemaObject
+	if schema, err = loadSchema(h); err != nil {
+		return
+	}
+	names = make(map[string]bool, len(schema))
+	for _, o := range schema {
+		names[o.Name] = true
+	}
+	return
+}
+
+// loadTables returns the tables storing rows in schema, including the shadow tables of virtual
+// tables and the sqlite_sequence table.
+func loadTables(h sqlHandler, schema []SchemaObject) (tables map[string]*tableInfo, err error) {
+	tables = make(map[string]*tableInfo)
+	for _, o := range schema {
+		if o.Type != "table" || isVirtualTable(o) {
+			continue
+		}
+		ti := &tableInfo{
+			name:  o.Name,
+			sql:   o.SQL,
+			rowid: !withoutRowidPattern.MatchString(o.SQL),
+		}
+		if err = loadColumns(h, ti); err != nil {
+			return
+		}
+		tables[o.Name] = ti
+	}
+
+	var (
+		rows   *sql.Rows
+		exists bool
+	)
+	if rows, err = h.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		sqliteSequenceTable); err != nil {
+		err = errors.Wrap(err, "load schema failed")
+		return
+	}
+	exists = rows.Next()
+	_ = rows.Close()
+	if exists {
+		ti := &tableInfo{name: sqliteSequenceTable}
+		if err = loadColumns(h, ti); err != nil {
+			return
+		}
+		tables[ti.name] = ti
+	}
+	return
+}
+
+func loadColumns(h sqlHandler, ti *tableInfo) (err error) {
+	var (
+		rows *sql.Rows
+		pks  = make(map[int]string)
+	)
+	if rows, err = h.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, quoteIdent(ti.name))); err != nil {
+		err = errors.Wrapf(err, "load columns of table %s failed", ti.name)
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			typ     string
+			notNull bool
+			dflt    interface{}
+			pk      int
+		)
+		if err = rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			err = errors.Wrapf(err, "load columns of table %s failed", ti.name)
+			return
+		}
+		ti.columns = append(ti.columns, name)
+		if pk > 0 {
+			pks[pk] = quoteIdent(name)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	// rows without rowid are ordered by primary key, or all columns if no primary key
+	for i := 1; i <= len(pks); i++ {
+		ti.orderBy = append(ti.orderBy, pks[i])
+	}
+	if len(ti.orderBy) == 0 {
+		for _, c := range ti.columns {
+			ti.orderBy = append(ti.orderBy, quoteIdent(c))
+		}
+	}
+	return
+}
+
+func isVirtualTable(o SchemaObject) bool {
+	return o.Type == "table" && strings.HasPrefix(strings.ToUpper(o.SQL), "CREATE VIRTUAL TABLE")
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// valueTag returns the value tag of the storage class name from typeof function.
+func valueTag(typ interface{}) byte {
+	var name string
+	switch x := typ.(type) {
+	case []byte:
+		name = string(x)
+	case string:
+		name = x
+	}
+	switch name {
+	case "integer":
+		return valueInteger
+	case "real":
+		return valueReal
+	case "text":
+		return valueText
+	case "blob":
+		return valueBlob
+	default:
+		return valueNull
+	}
+}
+
+// encodeValue writes the value with its storage class tag, which is used for both hashing and
+// transferring rows.
+func encodeValue(buf *bytes.Buffer, tag byte, v interface{}) {
+	var b [binary.MaxVarintLen64]byte
+	switch tag {
+	case valueInteger:
+		i, _ := v.(int64)
+		buf.WriteByte(tag)
+		binary.BigEndian.PutUint64(b[:8], uint64(i))
+		buf.Write(b[:8])
+	case valueReal:
+		f, _ := v.(float64)
+		buf.WriteByte(tag)
+		binary.BigEndian.PutUint64(b[:8], math.Float64bits(f))
+		buf.Write(b[:8])
+	case valueText, valueBlob:
+		var data []byte
+		switch x := v.(type) {
+		case []byte:
+			data = x
+		case string:
+			data = []byte(x)
+		}
+		buf.WriteByte(tag)
+		buf.Write(b[:binary.PutUvarint(b[:], uint64(len(data)))])
+		buf.Write(data)
+	default:
+		buf.WriteByte(valueNull)
+	}
+}
+
+func decodeValue(r *bytes.Reader) (v interface{}, err error) {
+	var (
+		tag byte
+		b   [8]byte
+		l   uint64
+	)
+	if tag, err = r.ReadByte(); err != nil {
+		return
+	}
+	switch tag {
+	case valueNull:
+	case valueInteger, valueReal:
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		if tag == valueInteger {
+			v = int64(binary.BigEndian.Uint64(b[:]))
+		} else {
+			v = math.Float64frombits(binary.BigEndian.Uint64(b[:]))
+		}
+	case valueText, valueBlob:
+		if l, err = binary.ReadUvarint(r); err != nil {
+			return
+		}
+		if l > uint64(r.Len()) {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		data := make([]byte, l)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return
+		}
+		if tag == valueText {
+			v = string(data)
+		} else {
+			v = data
+		}
+	default:
+		err = errors.Errorf("unknown value tag %d", tag)
+	}
+	return
+}
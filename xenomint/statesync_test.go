@@ -0,0 +1,154 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestStateSync(t *testing.T) {
+	Convey("Given two states with divergent data and schema", t, func() {
+		var (
+			fl1    = path.Join(testingDataDir, fmt.Sprint(t.Name(), "x1"))
+			fl2    = path.Join(testingDataDir, fmt.Sprint(t.Name(), "x2"))
+			states = make([]*State, 2)
+			err    error
+		)
+		for i, fl := range []string{fl1, fl2} {
+			strg, err := xs.NewSqlite(fmt.Sprint("file:", fl))
+			So(err, ShouldBeNil)
+			states[i] = NewState(sql.LevelReadUncommitted, nodeID, strg)
+		}
+		Reset(func() {
+			for i, fl := range []string{fl1, fl2} {
+				So(states[i].Close(true), ShouldBeNil)
+				for _, suffix := range []string{"", "-shm", "-wal"} {
+					err = os.Remove(fl + suffix)
+					So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+				}
+			}
+		})
+		write := func(st *State, qs ...types.Query) {
+			_, _, err := st.Query(buildRequest(types.WriteQuery, qs), true)
+			So(err, ShouldBeNil)
+		}
+		read := func(st *State, q string) [][]interface{} {
+			_, resp, err := st.Query(buildRequest(types.ReadQuery, []types.Query{buildQuery(q)}), true)
+			So(err, ShouldBeNil)
+			var rows = make([][]interface{}, len(resp.Payload.Rows))
+			for i, r := range resp.Payload.Rows {
+				rows[i] = r.Values
+			}
+			return rows
+		}
+
+		var leader, follower = states[0], states[1]
+		for _, st := range states {
+			var qs = []types.Query{buildQuery(
+				`CREATE TABLE t1 (k INTEGER PRIMARY KEY, v TEXT, d DATETIME, b BLOB, r REAL)`)}
+			for i := 0; i < 3000; i++ {
+				qs = append(qs, buildQuery(`INSERT INTO t1 VALUES (?, ?, ?, ?, ?)`,
+					i, fmt.Sprint("v", i), "2018-01-01 00:00:00", []byte{byte(i)}, float64(i)/3))
+			}
+			write(st, qs...)
+		}
+		write(leader,
+			buildQuery(`UPDATE t1 SET v = 'updated', b = NULL WHERE k = 10`),
+			buildQuery(`DELETE FROM t1 WHERE k = 2500`),
+			buildQuery(`INSERT INTO t1 (k, v) VALUES (100000, 'far')`),
+			buildQuery(`CREATE INDEX t1_v ON t1 (v)`),
+			buildQuery(`CREATE TABLE t2 (id INTEGER PRIMARY KEY AUTOINCREMENT, v INT)`),
+			buildQuery(`CREATE TABLE t3 (k TEXT, v INT, PRIMARY KEY (k)) WITHOUT ROWID`),
+			buildQuery(`CREATE TRIGGER t2_insert AFTER INSERT ON t2 BEGIN
+INSERT INTO t3 VALUES (new.id, new.v); END`),
+			buildQuery(`INSERT INTO t2 (v) VALUES (1), (2), (3)`),
+		)
+		write(follower,
+			buildQuery(`UPDATE t1 SET r = 0 WHERE k = 20`),
+			buildQuery(`INSERT INTO t1 (k, v) VALUES (50000, 'stale')`),
+			buildQuery(`CREATE TABLE t4 (v INT)`),
+			buildQuery(`INSERT INTO t4 VALUES (1)`),
+		)
+
+		Convey("The delta should contain the divergent buckets only", func() {
+			var (
+				digest *StateDigest
+				delta  *StateDelta
+			)
+			digest, err = follower.Digest()
+			So(err, ShouldBeNil)
+			delta, err = leader.Delta(digest)
+			So(err, ShouldBeNil)
+			So(delta.Seq, ShouldEqual, leader.getSeq())
+			So(delta.SchemaChanged, ShouldBeTrue)
+
+			var buckets = make(map[string][]int64)
+			for _, td := range delta.Tables {
+				So(td.Full, ShouldEqual, td.Name != "t1")
+				for _, b := range td.Buckets {
+					buckets[td.Name] = append(buckets[td.Name], b.Bucket)
+				}
+			}
+			So(buckets["t1"], ShouldResemble, []int64{0, 2, 48, 97})
+
+			Convey("The follower should be identical to the leader after applying delta", func() {
+				err = follower.ApplyDelta(delta)
+				So(err, ShouldBeNil)
+				So(follower.getSeq(), ShouldEqual, leader.getSeq())
+
+				var expected, actual *StateDigest
+				expected, err = leader.Digest()
+				So(err, ShouldBeNil)
+				actual, err = follower.Digest()
+				So(err, ShouldBeNil)
+				So(actual, ShouldResemble, expected)
+
+				for _, q := range []string{
+					`SELECT * FROM t1 WHERE k IN (10, 20, 2500, 50000, 100000) ORDER BY k`,
+					`SELECT * FROM t3`,
+					`SELECT * FROM sqlite_sequence`,
+				} {
+					So(read(follower, q), ShouldResemble, read(leader, q))
+				}
+
+				// trigger and autoincrement should work after sync
+				for _, st := range states {
+					write(st, buildQuery(`INSERT INTO t2 (v) VALUES (4)`))
+				}
+				So(read(follower, `SELECT * FROM t3`), ShouldResemble, read(leader, `SELECT * FROM t3`))
+
+				Convey("The delta should be empty if states are identical", func() {
+					digest, err = follower.Digest()
+					So(err, ShouldBeNil)
+					delta, err = leader.Delta(digest)
+					So(err, ShouldBeNil)
+					So(delta.SchemaChanged, ShouldBeFalse)
+					So(delta.Tables, ShouldBeEmpty)
+				})
+			})
+		})
+	})
+}
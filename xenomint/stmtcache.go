@@ -0,0 +1,147 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// Statement defines a prepared statement of state. The sanitized and translated query of the
+// statement is cached, so the queries with the same SQL text skip parsing until the statement
+// is closed by all its preparers.
+type Statement struct {
+	Pattern     string // SQL text of the statement
+	Normalized  string // normalized SQL with literals replaced by placeholders
+	ContainsDDL bool
+
+	query     string // sanitized and translated query
+	rewritten bool   // query is rewritten with request values and can not be reused
+	refs      int
+}
+
+type stmtCache struct {
+	sync.RWMutex
+	statements map[string]*Statement
+}
+
+func newStmtCache() *stmtCache {
+	return &stmtCache{
+		statements: make(map[string]*Statement),
+	}
+}
+
+func (c *stmtCache) get(pattern string) (st *Statement, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	st, ok = c.statements[pattern]
+	return
+}
+
+func (c *stmtCache) prepare(pattern string) (st *Statement, err error) {
+	c.Lock()
+	if st = c.statements[pattern]; st != nil {
+		st.refs++
+		c.Unlock()
+		return
+	}
+	c.Unlock()
+
+	if isTxControlQuery(pattern) {
+		err = errors.Wrapf(ErrStatementNotPreparable, "%s", pattern)
+		return
+	}
+	var prepared = &Statement{
+		Pattern:    pattern,
+		Normalized: NormalizeQuery(pattern),
+	}
+	var env = newDetachedQueryEnv()
+	if prepared.ContainsDDL, prepared.query, _, err = convertQueryAndBuildArgsWithEnv(
+		pattern, nil, env); err != nil {
+		return
+	}
+	prepared.rewritten = env.rewritten
+
+	c.Lock()
+	defer c.Unlock()
+	if st = c.statements[pattern]; st == nil {
+		st = prepared
+		c.statements[pattern] = st
+	}
+	st.refs++
+	return
+}
+
+func (c *stmtCache) close(pattern string) {
+	c.Lock()
+	defer c.Unlock()
+	if st := c.statements[pattern]; st != nil {
+		if st.refs--; st.refs <= 0 {
+			delete(c.statements, pattern)
+		}
+	}
+}
+
+func (c *stmtCache) len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.statements)
+}
+
+// Prepare prepares the statement of pattern, the sanitized and translated query is reused by
+// the following queries with the same pattern until the statement is closed.
+func (s *State) Prepare(pattern string) (st *Statement, err error) {
+	return s.stmts.prepare(pattern)
+}
+
+// CloseStatement releases the statement of pattern prepared by Prepare.
+func (s *State) CloseStatement(pattern string) {
+	s.stmts.close(pattern)
+}
+
+// PreparedStatement returns the prepared statement of pattern if exists.
+func (s *State) PreparedStatement(pattern string) (st *Statement, ok bool) {
+	return s.stmts.get(pattern)
+}
+
+// ContainsDDL reports whether any of the queries contains a schema change statement, the
+// prepared statements are not parsed again.
+func (s *State) ContainsDDL(queries []types.Query) (containsDDL bool, err error) {
+	for _, q := range queries {
+		if st, ok := s.stmts.get(q.Pattern); ok {
+			containsDDL = st.ContainsDDL
+		} else {
+			containsDDL, _, _, err = convertQueryAndBuildArgsWithEnv(q.Pattern, q.Args, newDetachedQueryEnv())
+		}
+		if err != nil || containsDDL {
+			return
+		}
+	}
+	return
+}
+
+func (s *State) convertQueryAndBuildArgs(
+	pattern string, args []types.NamedArg, env *queryEnv) (containsDDL bool, p string, ifs []interface{}, err error,
+) {
+	if st, ok := s.stmts.get(pattern); ok && !st.rewritten {
+		return st.ContainsDDL, st.query, buildArgs(args), nil
+	}
+	return convertQueryAndBuildArgsWithEnv(pattern, args, env)
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestStatementCache(t *testing.T) {
+	Convey("Given a state with prepared statements", t, func() {
+		var (
+			fl  = path.Join(testingDataDir, t.Name())
+			st  *State
+			err error
+		)
+		strg, err := xs.NewSqlite(fmt.Sprint("file:", fl))
+		So(err, ShouldBeNil)
+		st = NewState(sql.LevelReadUncommitted, nodeID, strg)
+		Reset(func() {
+			So(st.Close(true), ShouldBeNil)
+			for _, suffix := range []string{"", "-shm", "-wal"} {
+				err = os.Remove(fl + suffix)
+				So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			}
+		})
+
+		const (
+			createPattern = `CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`
+			insertPattern = `INSERT INTO t1 VALUES (?, ?)`
+			selectPattern = `SELECT v FROM t1 WHERE k = 2`
+		)
+		create, err := st.Prepare(createPattern)
+		So(err, ShouldBeNil)
+		So(create.ContainsDDL, ShouldBeTrue)
+		insert, err := st.Prepare(insertPattern)
+		So(err, ShouldBeNil)
+		So(insert.ContainsDDL, ShouldBeFalse)
+		selectStmt, err := st.Prepare(selectPattern)
+		So(err, ShouldBeNil)
+		So(selectStmt.Normalized, ShouldEqual, NormalizeQuery(selectPattern))
+
+		containsDDL, err := st.ContainsDDL([]types.Query{buildQuery(insertPattern, 1, "v1")})
+		So(err, ShouldBeNil)
+		So(containsDDL, ShouldBeFalse)
+		containsDDL, err = st.ContainsDDL([]types.Query{
+			buildQuery(insertPattern, 1, "v1"), buildQuery(createPattern),
+		})
+		So(err, ShouldBeNil)
+		So(containsDDL, ShouldBeTrue)
+
+		Convey("The prepared statements should be executed", func() {
+			_, _, err = st.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(createPattern),
+				buildQuery(insertPattern, 1, "v1"),
+				buildQuery(insertPattern, 2, "v2"),
+			}), true)
+			So(err, ShouldBeNil)
+			_, resp, err := st.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(selectPattern),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "v2")
+		})
+		Convey("The statements should be kept until closed by all preparers", func() {
+			again, err := st.Prepare(insertPattern)
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, insert)
+			So(st.stmts.len(), ShouldEqual, 3)
+
+			st.CloseStatement(insertPattern)
+			_, ok := st.PreparedStatement(insertPattern)
+			So(ok, ShouldBeTrue)
+			st.CloseStatement(insertPattern)
+			_, ok = st.PreparedStatement(insertPattern)
+			So(ok, ShouldBeFalse)
+			st.CloseStatement(insertPattern)
+			So(st.stmts.len(), ShouldEqual, 2)
+		})
+		Convey("The invalid statements should not be prepared", func() {
+			_, err = st.Prepare(`BEGIN`)
+			So(errors.Cause(err), ShouldEqual, ErrStatementNotPreparable)
+			_, err = st.Prepare(`SELECT sqlite_version()`)
+			So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
+			So(st.stmts.len(), ShouldEqual, 3)
+		})
+		Convey("The rewritten statements should not reuse the cached query", func() {
+			stmt, err := st.Prepare(`SELECT random()`)
+			So(err, ShouldBeNil)
+			So(stmt.rewritten, ShouldBeTrue)
+		})
+	})
+}
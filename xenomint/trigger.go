@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/CovenantSQL/sqlparser"
+	"github.com/pkg/errors"
+)
+
+var (
+	triggerQueryRe  = regexp.MustCompile(`(?is)^\s*(create\s+((temp|temporary)\s+)?|drop\s+)trigger\s`)
+	createTriggerRe = regexp.MustCompile(`(?is)^\s*create\s+((temp|temporary)\s+)?trigger\s+(if\s+not\s+exists\s+)?(\S+)\s+` +
+		`(.+?)\s+on\s+(\S+)\s+(for\s+each\s+row\s+)?(when\s+(.+?)\s+)?begin\s+(.+?)\s*end\s*;?\s*$`)
+	dropTriggerRe    = regexp.MustCompile(`(?is)^\s*drop\s+trigger\s+(if\s+exists\s+)?([^\s;]+)\s*;?\s*$`)
+	triggerEventRe   = regexp.MustCompile(`(?is)^((before|after|instead\s+of)\s+)?(delete|insert|update(\s+of\s+.+)?)$`)
+	identifierQuotes = "\"`[]'"
+)
+
+// isTriggerQuery reports whether the query creates or drops a trigger, which is not supported by
+// the sql parser and is sanitized separately.
+func isTriggerQuery(pattern string) bool {
+	return triggerQueryRe.MatchString(pattern)
+}
+
+// sanitizeTrigger validates the trigger statement. The trigger body may only contain the data
+// manipulation statements with registered functions, the non-deterministic functions are not
+// rewritten in trigger bodies as they are evaluated on every firing of the trigger.
+func sanitizeTrigger(pattern string) (sanitized string, err error) {
+	if m := dropTriggerRe.FindStringSubmatch(pattern); m != nil {
+		if isSystemName(m[2]) {
+			err = errors.Wrapf(ErrInvalidTrigger, "trigger name %s", m[2])
+			return
+		}
+		return pattern, nil
+	}
+
+	m := createTriggerRe.FindStringSubmatch(pattern)
+	if m == nil {
+		err = errors.Wrap(ErrInvalidTrigger, "malformed trigger definition")
+		return
+	}
+	var (
+		temp    = m[1] != ""
+		name    = m[4]
+		event   = m[5]
+		table   = m[6]
+		when    = m[9]
+		body    = m[10]
+		queries []string
+		stmts   []sqlparser.Statement
+	)
+	if temp {
+		err = errors.Wrap(ErrInvalidTrigger, "temporary trigger not supported")
+		return
+	}
+	if isSystemName(name) {
+		err = errors.Wrapf(ErrInvalidTrigger, "trigger name %s", name)
+		return
+	}
+	if isSystemName(table) {
+		err = errors.Wrapf(ErrInvalidTableName, "%s", table)
+		return
+	}
+	if !triggerEventRe.MatchString(event) {
+		err = errors.Wrapf(ErrInvalidTrigger, "trigger event %s", event)
+		return
+	}
+	if when != "" {
+		var stmt sqlparser.Statement
+		if stmt, err = sqlparser.Parse("SELECT " + when); err != nil {
+			err = errors.Wrapf(ErrInvalidTrigger, "trigger condition %s: %v", when, err)
+			return
+		}
+		if err = sanitizeNodes(nil, true, stmt); err != nil {
+			return
+		}
+	}
+	if queries, stmts, err = sqlparser.ParseMultiple(sqlparser.NewStringTokenizer(body)); err != nil {
+		err = errors.Wrapf(ErrInvalidTrigger, "trigger body: %v", err)
+		return
+	}
+	if len(stmts) == 0 {
+		err = errors.Wrap(ErrInvalidTrigger, "empty trigger body")
+		return
+	}
+	for i, stmt := range stmts {
+		switch stmt.(type) {
+		case *sqlparser.Insert, *sqlparser.Update, *sqlparser.Delete, *sqlparser.Select:
+		default:
+			err = errors.Wrapf(ErrInvalidTrigger, "statement %s in trigger body", queries[i])
+			return
+		}
+		if err = sanitizeNodes(nil, true, stmt); err != nil {
+			return
+		}
+	}
+	return pattern, nil
+}
+
+func isSystemName(name string) bool {
+	name = strings.Trim(name, identifierQuotes)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = strings.Trim(name[i+1:], identifierQuotes)
+	}
+	return strings.HasPrefix(strings.ToLower(name), "sqlite")
+}